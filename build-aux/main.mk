@@ -89,6 +89,10 @@ pkg/install/helm/telepresence-chart.tgz: $(tools/helm) charts/telepresence FORCE
 
 PKG_VERSION = $(shell go list ./pkg/version)
 
+# The base64 encoded ed25519 public key that "telepresence upgrade self" verifies the signed manifests of releases
+# with. Binaries that are built without it refuse to upgrade themselves.
+TELEPRESENCE_RELEASE_SIGNING_KEY ?=
+
 # We might be building for arm64 on a mac that doesn't have an M1 chip
 # (which is definitely the case with circle), so GOARCH may be set for that,
 # but we need to ensure it's using the host's architecture so the go command runs successfully.
@@ -116,7 +120,7 @@ else
 build-version: pkg/install/helm/telepresence-chart.tgz ## (Build) Generate a telepresence-chart.tgz and build all the source code
 	mkdir -p $(BINDIR)
 endif
-	CGO_ENABLED=$(CGO_ENABLED) $(sdkroot) go build -trimpath -ldflags=-X=$(PKG_VERSION).Version=$(TELEPRESENCE_VERSION) -o $(BINDIR) ./cmd/telepresence/... || \
+	CGO_ENABLED=$(CGO_ENABLED) $(sdkroot) go build -trimpath -ldflags="-X=$(PKG_VERSION).Version=$(TELEPRESENCE_VERSION) -X=$(PKG_VERSION).ReleaseSigningKey=$(TELEPRESENCE_RELEASE_SIGNING_KEY)" -o $(BINDIR) ./cmd/telepresence/... || \
 		(git restore pkg/install/helm/telepresence-chart.tgz; exit 1) # in case the build fails
 
 # Build: artifacts that don't get checked in to Git
@@ -124,15 +128,24 @@ endif
 build: build-version ## (Build)  Generate a telepresence-chart.tgz, build all the source code, then git restore telepresence-chart.tgz
 	git restore pkg/install/helm/telepresence-chart.tgz
 
-.PHONY: tel2-base tel2
-tel2-base tel2:
+.PHONY: tel2-base tel2 tel2-agent
+tel2-base tel2 tel2-agent:
 	mkdir -p $(BUILDDIR)
 	printf $(TELEPRESENCE_VERSION) > $(BUILDDIR)/version.txt ## Pass version in a file instead of a --build-arg to maximize cache usage
-	docker build --target $@ --tag $@ --tag $(TELEPRESENCE_REGISTRY)/$@:$(patsubst v%,%,$(TELEPRESENCE_VERSION)) -f base-image/Dockerfile .
+	DOCKER_BUILDKIT=1 docker build --target $@ --tag $@ --tag $(TELEPRESENCE_REGISTRY)/$@:$(patsubst v%,%,$(TELEPRESENCE_VERSION)) -f base-image/Dockerfile .
+
+# The platforms of the published tel2 image. The image is pushed as a manifest list so that
+# the container runtime picks the variant that matches the node's architecture.
+TELEPRESENCE_PLATFORMS ?= linux/amd64,linux/arm64
 
 .PHONY: push-image
-push-image: tel2 ## (Build) Push the manager/agent container image to $(TELEPRESENCE_REGISTRY)
-	docker push $(TELEPRESENCE_REGISTRY)/tel2:$(patsubst v%,%,$(TELEPRESENCE_VERSION))
+push-image: ## (Build) Build and push the multi-arch manager/agent container images to $(TELEPRESENCE_REGISTRY)
+	mkdir -p $(BUILDDIR)
+	printf $(TELEPRESENCE_VERSION) > $(BUILDDIR)/version.txt
+	for target in tel2 tel2-agent; do \
+		docker buildx build --platform $(TELEPRESENCE_PLATFORMS) --target $$target --push \
+			--tag $(TELEPRESENCE_REGISTRY)/$$target:$(patsubst v%,%,$(TELEPRESENCE_VERSION)) -f base-image/Dockerfile . || exit 1; \
+	done
 
 tel2-image: tel2
 	docker save $(TELEPRESENCE_REGISTRY)/tel2:$(patsubst v%,%,$(TELEPRESENCE_VERSION)) > $(BUILDDIR)/tel2-image.tar
@@ -78,7 +78,82 @@ RBAC rules required to create an intercept in a namespace; excludes any rules th
 - apiGroups: [""]
   resources: ["pods"]
   verbs: ["list"]
+- apiGroups: [""]
+  resources: ["events"]
+  verbs: ["list"]
 - apiGroups: ["apps"]
   resources: ["deployments", "replicasets", "statefulsets"]
   verbs: ["get", "watch", "list"]
+- apiGroups: ["batch"]
+  resources: ["jobs", "cronjobs"]
+  verbs: ["get"]
+{{- end }}
+
+{{/*
+RBAC rules required by the traffic-manager to find, inject agents into, and roll out the custom workload kinds.
+Argo Rollouts and Knative Services are always included. Configured kinds without a resource get no rules.
+*/}}
+{{- define "telepresence.workloadKindRules" -}}
+- apiGroups: ["argoproj.io"]
+  resources: ["rollouts"]
+  verbs: ["get", "list", "patch", "update"]
+- apiGroups: ["serving.knative.dev"]
+  resources: ["services"]
+  verbs: ["get", "list", "patch", "update"]
+{{- range .Values.workloadKinds }}
+{{- if .resource }}
+- apiGroups: [{{ index (splitList "/" .apiVersion) 0 | quote }}]
+  resources: [{{ .resource | quote }}]
+  verbs: ["get", "list", "patch", "update"]
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{/*
+The SecurityContext of the traffic-manager and its hooks. OpenShift assigns the user from the namespace's UID range,
+so the configured runAsUser is omitted there.
+*/}}
+{{- define "telepresence.securityContext" -}}
+{{- if .Values.openshift.enabled }}
+{{- toYaml (omit .Values.securityContext "runAsUser") }}
+{{- else }}
+{{- toYaml .Values.securityContext }}
+{{- end }}
+{{- end }}
+
+{{/*
+The image of the traffic-manager. A digest takes precedence over the tag.
+*/}}
+{{- define "telepresence.image" -}}
+{{- if .Values.image.digest }}
+{{- printf "%s/%s@%s" .Values.image.registry .Values.image.name .Values.image.digest }}
+{{- else }}
+{{- printf "%s/%s:%s" .Values.image.registry .Values.image.name (.Values.image.tag | default .Chart.AppVersion) }}
+{{- end }}
+{{- end }}
+
+{{/*
+The name of the injected traffic-agent image, without the registry. A digest takes precedence over the tag.
+*/}}
+{{- define "telepresence.agentImage" -}}
+{{- with .Values.agentInjector.agentImage }}
+{{- if .digest }}
+{{- printf "%s@%s" .name .digest }}
+{{- else }}
+{{- printf "%s:%s" .name (.tag | default $.Chart.AppVersion) }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{/*
+The image used by the hooks.
+*/}}
+{{- define "telepresence.hookImage" -}}
+{{- with .Values.hooks.curl }}
+{{- if .registry }}
+{{- printf "%s/%s:%s" .registry .image .tag }}
+{{- else }}
+{{- printf "%s:%s" .image .tag }}
+{{- end }}
+{{- end }}
 {{- end }}
@@ -39,7 +39,7 @@ func main() {
 	var cmd *cobra.Command
 	if isDaemon() {
 		// Avoid the initialization of all subcommands except for [connector|daemon]-foreground and
-		// avoids checks for legacy commands.
+		// daemon-service, and avoids checks for legacy commands.
 		cmd = &cobra.Command{
 			Use:  "telepresence",
 			Args: cli.OnlySubcommands,
@@ -52,6 +52,10 @@ func main() {
 		}
 		cmd.AddCommand(userd.Command(commands.GetCommands, []userd.DaemonService{}, []trafficmgr.SessionService{}))
 		cmd.AddCommand(rootd.Command())
+		cmd.AddCommand(rootd.CleanupCommand())
+		if sc := rootd.ServiceCommand(); sc != nil {
+			cmd.AddCommand(sc)
+		}
 		if err := cmd.ExecuteContext(ctx); err != nil {
 			fmt.Fprintf(cmd.ErrOrStderr(), "%s: error: %v\n", cmd.CommandPath(), err)
 			os.Exit(1)
@@ -91,8 +95,11 @@ func main() {
 
 func isDaemon() bool {
 	const fg = "-foreground"
+	const svc = "daemon-service"
+	const cleanup = "daemon-cleanup"
 	a := os.Args
-	return len(a) > 1 && strings.HasSuffix(a[1], fg) || len(a) > 2 && strings.HasSuffix(a[2], fg) && a[1] == "help"
+	return len(a) > 1 && (strings.HasSuffix(a[1], fg) || a[1] == svc || a[1] == cleanup) ||
+		len(a) > 2 && (strings.HasSuffix(a[2], fg) || a[2] == svc || a[2] == cleanup) && a[1] == "help"
 }
 
 func summarizeLogs(ctx context.Context, cmd *cobra.Command) {
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -26,8 +27,9 @@ import (
 
 // AppEnvironment returns the environment visible to this agent together with environment variables
 // explicitly declared for the app container and minus the environment variables provided by this
-// config.
-func AppEnvironment(ctx context.Context, ag *agentconfig.Container) (map[string]string, error) {
+// config. The keys of the variables that are obtained from secrets are also returned, mapped to the
+// secret keys that they're obtained from.
+func AppEnvironment(ctx context.Context, ag *agentconfig.Container) (map[string]string, map[string]*rpc.SecretKeyRef, error) {
 	osEnv := dos.Environ(ctx)
 	prefix := agentconfig.EnvPrefixApp + ag.EnvPrefix
 	fullEnv := make(map[string]string, len(osEnv))
@@ -45,20 +47,84 @@ func AppEnvironment(ctx context.Context, ag *agentconfig.Container) (map[string]
 			}
 		}
 	}
+	appEnv := make(map[string]struct{})
 	for _, env := range osEnv {
 		if strings.HasPrefix(env, prefix) {
 			pair := strings.SplitN(env, "=", 2)
 			if len(pair) == 2 {
 				k := pair[0][len(prefix):]
 				fullEnv[k] = pair[1]
+				appEnv[k] = struct{}{}
 			}
 		}
 	}
+
+	// The envFrom secrets come first and the explicitly declared variables last, so that the latter
+	// take precedence. The keys imported from a secret never override explicitly declared variables.
+	var secrets map[string]*rpc.SecretKeyRef
+	for _, se := range ag.SecretEnv {
+		sePrefix := ag.SecretEnvPrefix(se)
+		for _, env := range osEnv {
+			if !strings.HasPrefix(env, sePrefix) {
+				continue
+			}
+			pair := strings.SplitN(env, "=", 2)
+			if len(pair) != 2 {
+				continue
+			}
+			k := pair[0][len(sePrefix):]
+			key := se.Key
+			if key == "" {
+				if _, ok := appEnv[k]; ok {
+					continue
+				}
+				key = strings.TrimPrefix(k, se.Name)
+			}
+			fullEnv[k] = pair[1]
+			if secrets == nil {
+				secrets = make(map[string]*rpc.SecretKeyRef)
+			}
+			secrets[k] = &rpc.SecretKeyRef{Name: se.Secret, Key: key}
+		}
+	}
 	fullEnv[agentconfig.EnvInterceptContainer] = ag.Name
 	if len(ag.Mounts) > 0 {
 		fullEnv[agentconfig.EnvInterceptMounts] = strings.Join(ag.Mounts, ":")
 	}
-	return fullEnv, nil
+	return fullEnv, secrets, nil
+}
+
+// opaque returns true if one of the given intercepts, which share an agent port, declares its traffic as a byte
+// stream that must be passed on as is, because TLS can then not be terminated for the port.
+func opaque(ics []*agentconfig.Intercept) bool {
+	for _, ic := range ics {
+		if ic.IsOpaque() {
+			return true
+		}
+	}
+	return false
+}
+
+// listenAddress returns the address that a forwarder listens to for the given agent port. The host is given by the
+// listen address of the agent config, which is empty for all interfaces, agentconfig.ListenLocalhost,
+// agentconfig.ListenPodIP, or an IP address.
+func listenAddress(host, podIP string, port uint16) (*net.TCPAddr, error) {
+	addr := &net.TCPAddr{Port: int(port)}
+	switch host {
+	case "":
+	case agentconfig.ListenLocalhost:
+		addr.IP = net.IPv4(127, 0, 0, 1)
+	case agentconfig.ListenPodIP:
+		if addr.IP = iputil.Parse(podIP); addr.IP == nil {
+			return nil, fmt.Errorf("unable to listen to the pod IP, because it is %q", podIP)
+		}
+	default:
+		if addr.IP = iputil.Parse(host); addr.IP == nil {
+			return nil, fmt.Errorf("invalid listen address %q, must be empty, %q, %q, or an IP address",
+				host, agentconfig.ListenLocalhost, agentconfig.ListenPodIP)
+		}
+	}
+	return addr, nil
 }
 
 // SftpServer creates a listener on the next available port, writes that port on the
@@ -139,10 +205,25 @@ func Main(ctx context.Context, args ...string) error {
 	}
 	info.Mechanisms = mechanisms
 
+	// The agent is cancelled when it terminates with the app
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	g := dgroup.NewGroup(ctx, dgroup.GroupConfig{
 		EnableSignalHandling: true,
 	})
 
+	if config.AgentConfig().TerminateWithApp {
+		// The pod runs to completion, which it can't do while the agent is running.
+		g.Go("app-watcher", func(ctx context.Context) error {
+			if WaitForAppExit(ctx, os.Getpid(), time.Second, appExitGracePeriod) {
+				dlog.Info(ctx, "The app containers have exited, so the traffic-agent terminates too")
+				cancel()
+			}
+			return nil
+		})
+	}
+
 	sftpPortCh := make(chan uint16)
 	if config.HasMounts(ctx) {
 		g.Go("sftp-server", func(ctx context.Context) error {
@@ -169,7 +250,11 @@ func Main(ctx context.Context, args ...string) error {
 
 		// Manage the forwarders
 		for _, cn := range ac.Containers {
-			env, err := AppEnvironment(ctx, cn)
+			env, secrets, err := AppEnvironment(ctx, cn)
+			if err != nil {
+				return err
+			}
+			terminatingTLS, originatingTLS, err := tlsConfigs(ac, cn)
 			if err != nil {
 				return err
 			}
@@ -182,16 +267,25 @@ func Main(ctx context.Context, args ...string) error {
 
 			for _, ics := range icStates {
 				ic := ics[0] // They all have the same agent port and container port, so the first one will do
-				lisAddr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf(":%d", ic.AgentPort))
+				lisAddr, err := listenAddress(ac.ListenAddress, config.PodIP(), ic.AgentPort)
 				if err != nil {
 					return err
 				}
-				fwd := forwarder.NewForwarder(lisAddr, "", ic.ContainerPort)
+				targetPort := ic.ContainerPort
+				if cn.Replace {
+					// The container is gone, so there's nothing to forward to unless the port is intercepted
+					targetPort = 0
+				}
+				fwd := forwarder.NewForwarder(lisAddr, "", targetPort)
+				fwd.SetReusePort(ac.ReusePort)
+				if terminatingTLS != nil && !opaque(ics) {
+					fwd.SetTLS(withNextProtos(terminatingTLS, ics), originatingTLS)
+				}
 				g.Go(fmt.Sprintf("forward-%s:%d", cn.Name, ic.ContainerPort), func(ctx context.Context) error {
 					return fwd.Serve(tunnel.WithPool(ctx, tunnel.NewPool()))
 				})
 				cnMountPoint := filepath.Join(agentconfig.ExportsMountPoint, filepath.Base(cn.MountPoint))
-				state.AddInterceptState(NewInterceptState(state, fwd, ics, cnMountPoint, env))
+				state.AddInterceptState(NewInterceptState(state, fwd, ics, cnMountPoint, env, secrets))
 			}
 		}
 
@@ -202,7 +296,7 @@ func Main(ctx context.Context, args ...string) error {
 		}
 
 		for {
-			if err := TalkToManager(ctx, gRPCAddress, info, state); err != nil {
+			if err := TalkToManager(ctx, gRPCAddress, info, state, managerDialOptions(ac)...); err != nil {
 				dlog.Info(ctx, err)
 			}
 
@@ -13,6 +13,7 @@ import (
 	"gopkg.in/yaml.v3"
 
 	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/agent"
 	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
 	"github.com/telepresenceio/telepresence/v2/pkg/dos"
@@ -22,6 +23,7 @@ import (
 const serviceName = "test-echo"
 const namespace = "teltest"
 const podIP = "192.168.50.34"
+const podName = "hello-7c7c565cb7-jnql2"
 
 var testConfig = agentconfig.Sidecar{
 	Create:       false,
@@ -39,6 +41,10 @@ var testConfig = agentconfig.Sidecar{
 		EnvPrefix:  "A_",
 		MountPoint: "/tel_app_mounts/test-echo",
 		Mounts:     []string{"/home/bob"},
+		SecretEnv: []*agentconfig.SecretEnv{
+			{Secret: "db", Name: "DB_"},
+			{Secret: "token", Key: "value", Name: "TOKEN"},
+		},
 		Intercepts: []*agentconfig.Intercept{
 			{
 				ContainerPortName: "http",
@@ -67,6 +73,7 @@ func testContext(t *testing.T, env dos.MapEnv) context.Context {
 	require.NoError(t, afero.WriteFile(fs, filepath.Join(agentconfig.ConfigMountPoint, agentconfig.ConfigFile), y, 0600))
 
 	env[agentconfig.EnvPrefixAgent+"POD_IP"] = podIP
+	env[agentconfig.EnvPrefixAgent+"NAME"] = podName
 
 	ctx := dlog.NewTestContext(t, false)
 	ctx = dos.WithFS(ctx, aferofs.Wrap(fs))
@@ -79,6 +86,21 @@ func Test_LoadConfig(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, &testConfig, config.AgentConfig())
 	require.Equal(t, podIP, config.PodIP())
+	require.Equal(t, podName, config.PodName())
+}
+
+func Test_LoadConfig_ephemeral(t *testing.T) {
+	ec := testConfig
+	ec.AgentName = "ephemeral-echo"
+	y, err := yaml.Marshal(&ec)
+	require.NoError(t, err)
+	ctx := testContext(t, dos.MapEnv{agentconfig.EnvAgentConfig: string(y)})
+	config, err := agent.LoadConfig(ctx)
+	require.NoError(t, err)
+	ac := config.AgentConfig()
+	require.Equal(t, "ephemeral-echo", ac.AgentName, "config not loaded from the environment")
+	require.Empty(t, ac.Containers[0].Mounts, "an ephemeral agent has no exported mounts")
+	require.Equal(t, podIP, config.PodIP())
 }
 
 func Test_AppEnvironment(t *testing.T) {
@@ -89,8 +111,11 @@ func Test_AppEnvironment(t *testing.T) {
 		"HOME": "/home/tel",                    // skip
 		"PATH": "/bin:/usr/bin:/usr/local/bin", // skip
 		"ZULU": "zulu",                         // include,
-		agentconfig.EnvPrefixApp + "A_" + "ALPHA": "alpha", // include
-		agentconfig.EnvPrefixApp + "B_" + "BRAVO": "bravo", // skip
+		agentconfig.EnvPrefixApp + "A_" + "ALPHA":        "alpha", // include
+		agentconfig.EnvPrefixApp + "B_" + "BRAVO":        "bravo", // skip
+		agentconfig.EnvPrefixSecret + "A_0_" + "DB_USER": "bob",   // include, from secret
+		agentconfig.EnvPrefixSecret + "A_0_" + "ALPHA":   "other", // skip, explicitly declared
+		agentconfig.EnvPrefixSecret + "A_1_" + "TOKEN":   "t0ken", // include, from secret
 	})
 
 	ksDir := "/var/run/secrets/kubernetes.io/serviceaccount"
@@ -105,14 +130,20 @@ func Test_AppEnvironment(t *testing.T) {
 	require.NoError(t, err)
 
 	cn := config.AgentConfig().Containers[0]
-	env, err := agent.AppEnvironment(ctx, cn)
+	env, secrets, err := agent.AppEnvironment(ctx, cn)
 	require.NoError(t, err)
 	require.Equal(t, map[string]string{
 		"ALPHA":                           "alpha",
+		"DB_USER":                         "bob",
+		"TOKEN":                           "t0ken",
 		"ZULU":                            "zulu",
 		agentconfig.EnvInterceptContainer: "test-echo",
 		agentconfig.EnvInterceptMounts:    "/home/bob:/var/run/secrets/kubernetes.io",
 	}, env)
+	require.Equal(t, map[string]*rpc.SecretKeyRef{
+		"DB_USER": {Name: "db", Key: "USER"},
+		"TOKEN":   {Name: "token", Key: "value"},
+	}, secrets)
 
 	// Check symlink to container's remote mount point
 	cnMountPoint := filepath.Join(agentconfig.ExportsMountPoint, filepath.Base(cn.MountPoint))
@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/dos"
+)
+
+// appExitGracePeriod is the time that the app processes must be gone before the agent terminates. It allows
+// for a restart of an app container in a pod with restart policy OnFailure.
+const appExitGracePeriod = 15 * time.Second
+
+type procStat struct {
+	pid   int
+	ppid  int
+	state byte
+}
+
+// parseProcStat parses the pid, state, and parent pid from the contents of a /proc/<pid>/stat file. The
+// command name is enclosed in parentheses and may contain spaces, so the fields following it are found
+// using the last closing parenthesis.
+func parseProcStat(data []byte) (procStat, error) {
+	s := string(data)
+	ob := strings.IndexByte(s, '(')
+	cb := strings.LastIndexByte(s, ')')
+	if ob < 0 || cb < ob {
+		return procStat{}, fmt.Errorf("malformed stat %q", s)
+	}
+	var ps procStat
+	var err error
+	if ps.pid, err = strconv.Atoi(strings.TrimSpace(s[:ob])); err != nil {
+		return procStat{}, fmt.Errorf("malformed stat %q: %w", s, err)
+	}
+	fields := strings.Fields(s[cb+1:])
+	if len(fields) < 2 || len(fields[0]) != 1 {
+		return procStat{}, fmt.Errorf("malformed stat %q", s)
+	}
+	ps.state = fields[0][0]
+	if ps.ppid, err = strconv.Atoi(fields[1]); err != nil {
+		return procStat{}, fmt.Errorf("malformed stat %q: %w", s, err)
+	}
+	return ps, nil
+}
+
+// readProcs returns the processes that are visible in /proc.
+func readProcs(ctx context.Context) ([]procStat, error) {
+	des, err := dos.ReadDir(ctx, "/proc")
+	if err != nil {
+		return nil, err
+	}
+	var procs []procStat
+	for _, de := range des {
+		if _, err := strconv.Atoi(de.Name()); err != nil || !de.IsDir() {
+			continue
+		}
+		data, err := dos.ReadFile(ctx, "/proc/"+de.Name()+"/stat")
+		if err != nil {
+			// The process exited after the directory was read
+			continue
+		}
+		ps, err := parseProcStat(data)
+		if err != nil {
+			return nil, err
+		}
+		procs = append(procs, ps)
+	}
+	return procs, nil
+}
+
+// appRunning returns true if any of the given processes belongs to an app container. The pause process (pid 1),
+// zombies, and the agent process with the given pid and its descendants don't count.
+func appRunning(procs []procStat, self int) bool {
+	parents := make(map[int]int, len(procs))
+	for _, ps := range procs {
+		parents[ps.pid] = ps.ppid
+	}
+	isAgent := func(pid int) bool {
+		// The number of steps is limited, so that a pid that is reused during the scan can't cause an endless loop
+		for i := 0; pid > 0 && i <= len(procs); i++ {
+			if pid == self {
+				return true
+			}
+			pid = parents[pid]
+		}
+		return false
+	}
+	for _, ps := range procs {
+		if ps.pid == 1 || ps.state == 'Z' || ps.state == 'X' || isAgent(ps.pid) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// WaitForAppExit polls the processes of the pod's shared process namespace and returns true when the app
+// processes, having been seen at least once, have been gone for the given grace period. The traffic-agent
+// process with the pid self and its descendants aren't app processes. False is returned when the context
+// is cancelled, or when the processes can't be read.
+func WaitForAppExit(ctx context.Context, self int, pollInterval, grace time.Duration) bool {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	seen := false
+	var goneSince time.Time
+	for {
+		procs, err := readProcs(ctx)
+		if err != nil {
+			dlog.Errorf(ctx, "unable to watch the processes of the app containers: %v", err)
+			return false
+		}
+		switch {
+		case appRunning(procs, self):
+			if !seen {
+				dlog.Debug(ctx, "The app processes are running")
+				seen = true
+			}
+			goneSince = time.Time{}
+		case !seen:
+		case goneSince.IsZero():
+			dlog.Infof(ctx, "The app processes are gone, terminating in %s unless they return", grace)
+			goneSince = time.Now()
+		case time.Since(goneSince) >= grace:
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package agent_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/agent"
+	"github.com/telepresenceio/telepresence/v2/pkg/dos"
+	"github.com/telepresenceio/telepresence/v2/pkg/dos/aferofs"
+)
+
+const agentPID = 7
+
+func addProc(t *testing.T, fs afero.Fs, pid, ppid int, comm string, state byte) {
+	t.Helper()
+	dir := fmt.Sprintf("/proc/%d", pid)
+	require.NoError(t, fs.MkdirAll(dir, 0o755))
+	stat := fmt.Sprintf("%d (%s) %c %d 1 1 0 -1 4194560", pid, comm, state, ppid)
+	require.NoError(t, afero.WriteFile(fs, dir+"/stat", []byte(stat), 0o444))
+}
+
+// podProcs creates the processes of a pod with a shared process namespace, where the agent has started a child
+// process.
+func podProcs(t *testing.T) (context.Context, afero.Fs) {
+	fs := afero.NewMemMapFs()
+	addProc(t, fs, 1, 0, "pause", 'S')
+	addProc(t, fs, agentPID, 0, "traffic", 'S')
+	addProc(t, fs, 9, agentPID, "sftp server", 'S')
+	require.NoError(t, fs.MkdirAll("/proc/sys", 0o755))
+	return dos.WithFS(dlog.NewTestContext(t, false), aferofs.Wrap(fs)), fs
+}
+
+func waitForAppExit(ctx context.Context) <-chan bool {
+	done := make(chan bool, 1)
+	go func() {
+		done <- agent.WaitForAppExit(ctx, agentPID, 10*time.Millisecond, 100*time.Millisecond)
+	}()
+	return done
+}
+
+func TestWaitForAppExit(t *testing.T) {
+	ctx, fs := podProcs(t)
+	addProc(t, fs, 12, 0, "job (worker)", 'R')
+	addProc(t, fs, 13, 12, "job-child", 'S')
+
+	done := waitForAppExit(ctx)
+	select {
+	case <-done:
+		require.Fail(t, "returned while the app is running")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// The child exits first and the worker becomes a zombie
+	require.NoError(t, fs.RemoveAll("/proc/13"))
+	addProc(t, fs, 12, 0, "job (worker)", 'Z')
+	select {
+	case exited := <-done:
+		assert.True(t, exited)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "didn't return when the app exited")
+	}
+}
+
+func TestWaitForAppExit_restart(t *testing.T) {
+	ctx, fs := podProcs(t)
+	addProc(t, fs, 12, 0, "job", 'R')
+	done := waitForAppExit(ctx)
+
+	// The app process is restarted within the grace period
+	time.Sleep(30 * time.Millisecond)
+	require.NoError(t, fs.RemoveAll("/proc/12"))
+	time.Sleep(20 * time.Millisecond)
+	addProc(t, fs, 14, 0, "job", 'R')
+	select {
+	case <-done:
+		require.Fail(t, "returned while the app is restarting")
+	case <-time.After(200 * time.Millisecond):
+	}
+	require.NoError(t, fs.RemoveAll("/proc/14"))
+	select {
+	case exited := <-done:
+		assert.True(t, exited)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "didn't return when the app exited")
+	}
+}
+
+func TestWaitForAppExit_noApp(t *testing.T) {
+	// The agent doesn't terminate unless it has seen the app, e.g. when the process namespace isn't shared
+	ctx, _ := podProcs(t)
+	ctx, cancel := context.WithCancel(ctx)
+	done := waitForAppExit(ctx)
+	select {
+	case <-done:
+		require.Fail(t, "returned although no app was seen")
+	case <-time.After(200 * time.Millisecond):
+	}
+	cancel()
+	select {
+	case exited := <-done:
+		assert.False(t, exited)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "didn't return when cancelled")
+	}
+}
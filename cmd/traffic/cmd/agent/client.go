@@ -19,6 +19,8 @@ import (
 	"github.com/datawire/dlib/dgroup"
 	"github.com/datawire/dlib/dlog"
 	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+	"github.com/telepresenceio/telepresence/v2/pkg/dnet"
 	"github.com/telepresenceio/telepresence/v2/pkg/dos"
 	"github.com/telepresenceio/telepresence/v2/pkg/install"
 	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
@@ -61,17 +63,27 @@ func (is interceptsStringer) String() string {
 	return sb.String()
 }
 
-func TalkToManager(ctx context.Context, address string, info *rpc.AgentInfo, state State) error {
+// managerDialOptions returns the dial options that apply the keep-alive settings of the given agent config.
+func managerDialOptions(ac *agentconfig.Sidecar) []grpc.DialOption {
+	opts := []grpc.DialOption{
+		grpc.WithContextDialer(dnet.NewTCPDialer(ac.ManagerTCPUserTimeout)),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithBlock(),
+	}
+	return append(opts, dnet.KeepAliveDialOptions(ac.ManagerKeepAliveInterval, ac.ManagerKeepAliveTimeout)...)
+}
+
+func TalkToManager(ctx context.Context, address string, info *rpc.AgentInfo, state State, opts ...grpc.DialOption) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	conn, err := grpc.DialContext(ctx, address, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	conn, err := grpc.DialContext(ctx, address, opts...)
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
 
-	manager := rpc.NewManagerClient(conn)
+	manager := tunnel.NewPrewarmingClient(ctx, rpc.NewManagerClient(conn), state.AgentConfig().TunnelPoolSize)
 
 	ver, err := manager.Version(ctx, &empty.Empty{})
 	if err != nil {
@@ -135,7 +147,7 @@ func TalkToManager(ctx context.Context, address string, info *rpc.AgentInfo, sta
 		return err
 	}
 	wg.Go("dialWait", func(ctx context.Context) error {
-		return tunnel.DialWaitLoop(ctx, manager, dialerStream, session.SessionId)
+		return tunnel.DialWaitLoop(ctx, manager, dialerStream, session.SessionId, tunnel.NoCompression)
 	})
 
 	// Deal with log-level changes
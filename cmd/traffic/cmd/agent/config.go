@@ -18,11 +18,13 @@ type Config interface {
 	AgentConfig() *agentconfig.Sidecar
 	HasMounts(ctx context.Context) bool
 	PodIP() string
+	PodName() string
 }
 
 type config struct {
 	agentconfig.Sidecar
-	podIP string
+	podIP   string
+	podName string
 }
 
 // Keys that aren't useful when running on the local machine
@@ -33,18 +35,25 @@ var skipKeys = map[string]bool{
 }
 
 func LoadConfig(ctx context.Context) (Config, error) {
-	cf, err := dos.Open(ctx, filepath.Join(agentconfig.ConfigMountPoint, agentconfig.ConfigFile))
-	if err != nil {
-		return nil, fmt.Errorf("unable to open agent ConfigMap: %w", err)
-	}
-	defer cf.Close()
-
 	c := config{}
-	if err = yaml.NewDecoder(cf).Decode(&c.Sidecar); err != nil {
-		return nil, fmt.Errorf("unable to decode agent ConfigMap: %w", err)
+	y := dos.Getenv(ctx, agentconfig.EnvAgentConfig)
+	ephemeral := y != ""
+	if ephemeral {
+		// The agent runs as an ephemeral container, which has no config volume
+		if err := yaml.Unmarshal([]byte(y), &c.Sidecar); err != nil {
+			return nil, fmt.Errorf("unable to decode agent config in %s: %w", agentconfig.EnvAgentConfig, err)
+		}
+	} else if err := loadConfigFile(ctx, &c.Sidecar); err != nil {
+		return nil, err
 	}
 	c.podIP = dos.Getenv(ctx, "_TEL_AGENT_POD_IP")
+	c.podName = dos.Getenv(ctx, "_TEL_AGENT_NAME")
 	for _, cn := range c.Containers {
+		if ephemeral {
+			// An ephemeral container has no exports volume, so the app's mounts can't be shared
+			cn.Mounts = nil
+			continue
+		}
 		if err := addAppMounts(ctx, cn); err != nil {
 			return nil, err
 		}
@@ -59,6 +68,19 @@ func LoadConfig(ctx context.Context) (Config, error) {
 	return &c, nil
 }
 
+func loadConfigFile(ctx context.Context, sc *agentconfig.Sidecar) error {
+	cf, err := dos.Open(ctx, filepath.Join(agentconfig.ConfigMountPoint, agentconfig.ConfigFile))
+	if err != nil {
+		return fmt.Errorf("unable to open agent ConfigMap: %w", err)
+	}
+	defer cf.Close()
+
+	if err = yaml.NewDecoder(cf).Decode(sc); err != nil {
+		return fmt.Errorf("unable to decode agent ConfigMap: %w", err)
+	}
+	return nil
+}
+
 func (c *config) AgentConfig() *agentconfig.Sidecar {
 	return &c.Sidecar
 }
@@ -76,6 +98,10 @@ func (c *config) PodIP() string {
 	return c.podIP
 }
 
+func (c *config) PodName() string {
+	return c.podName
+}
+
 // addAppMounts adds each of the mounts present under the containers MountPoint as a
 // symlink under the agentconfig.ExportsMountPoint/<container mount>/
 func addAppMounts(ctx context.Context, ag *agentconfig.Container) error {
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"net/http"
 
+	"google.golang.org/protobuf/proto"
+
 	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
@@ -18,17 +20,19 @@ type fwdState struct {
 	forwarder  *forwarder.Forwarder
 	mountPoint string
 	env        map[string]string
+	secrets    map[string]*manager.SecretKeyRef
 }
 
 // NewInterceptState creates a InterceptState that performs intercepts by using a forwarder.Forwarder. A forwarder will indiscriminately
 // intercept all traffic to the port that it forwards.
-func NewInterceptState(s State, forwarder *forwarder.Forwarder, intercepts []*agentconfig.Intercept, mountPoint string, env map[string]string) InterceptState {
+func NewInterceptState(s State, forwarder *forwarder.Forwarder, intercepts []*agentconfig.Intercept, mountPoint string, env map[string]string, secrets map[string]*manager.SecretKeyRef) InterceptState {
 	return &fwdState{
 		simpleState: s.(*simpleState),
 		mountPoint:  mountPoint,
 		intercepts:  intercepts,
 		forwarder:   forwarder,
 		env:         env,
+		secrets:     secrets,
 	}
 }
 
@@ -54,6 +58,23 @@ func (fs *fwdState) InterceptInfo(ctx context.Context, callerID, path string, co
 	return &restapi.InterceptInfo{Intercepted: false}, nil
 }
 
+// forwardedIntercept returns the intercept that the forwarder should use. A replace intercept that matches another
+// port of the replaced container is forwarded to the same port on the client, because the spec only declares the
+// target port of the port that it matches.
+func (fs *fwdState) forwardedIntercept(ii *manager.InterceptInfo) *manager.InterceptInfo {
+	if ii == nil || !ii.Spec.Replace {
+		return ii
+	}
+	for _, ic := range fs.intercepts {
+		if agentconfig.SpecMatchesIntercept(ii.Spec, ic) {
+			return ii
+		}
+	}
+	ii = proto.Clone(ii).(*manager.InterceptInfo)
+	ii.Spec.TargetPort = int32(fs.intercepts[0].ContainerPort)
+	return ii
+}
+
 func (fs *fwdState) HandleIntercepts(ctx context.Context, cepts []*manager.InterceptInfo) []*manager.ReviewInterceptRequest {
 	var myChoice, activeIntercept *manager.InterceptInfo
 
@@ -84,7 +105,7 @@ func (fs *fwdState) HandleIntercepts(ctx context.Context, cepts []*manager.Inter
 
 	// Update forwarding.
 	fs.forwarder.SetManager(fs.SessionInfo(), fs.ManagerClient(), fs.ManagerVersion())
-	fs.forwarder.SetIntercepting(activeIntercept)
+	fs.forwarder.SetIntercepting(fs.forwardedIntercept(activeIntercept))
 
 	// Review waiting intercepts
 	reviews := []*manager.ReviewInterceptRequest{}
@@ -97,13 +118,15 @@ func (fs *fwdState) HandleIntercepts(ctx context.Context, cepts []*manager.Inter
 				// snapshot. Let's go ahead and tell the manager to mark it ACTIVE.
 				dlog.Infof(ctx, "Setting intercept %q as ACTIVE (again?)", cept.Id)
 				reviews = append(reviews, &manager.ReviewInterceptRequest{
-					Id:                cept.Id,
-					Disposition:       manager.InterceptDispositionType_ACTIVE,
-					PodIp:             fs.PodIP(),
-					SftpPort:          int32(fs.SftpPort()),
-					MountPoint:        fs.mountPoint,
-					MechanismArgsDesc: "all TCP connections",
-					Environment:       fs.env,
+					Id:                 cept.Id,
+					Disposition:        manager.InterceptDispositionType_ACTIVE,
+					PodIp:              fs.PodIP(),
+					PodName:            fs.PodName(),
+					SftpPort:           int32(fs.SftpPort()),
+					MountPoint:         fs.mountPoint,
+					MechanismArgsDesc:  "all TCP connections",
+					Environment:        fs.env,
+					EnvironmentSecrets: fs.secrets,
 				})
 			case fs.chosenIntercept == nil:
 				// We don't have an intercept in play, so choose this one. All
@@ -115,13 +138,15 @@ func (fs *fwdState) HandleIntercepts(ctx context.Context, cepts []*manager.Inter
 				fs.chosenIntercept = cept
 				myChoice = cept
 				reviews = append(reviews, &manager.ReviewInterceptRequest{
-					Id:                cept.Id,
-					Disposition:       manager.InterceptDispositionType_ACTIVE,
-					PodIp:             fs.PodIP(),
-					SftpPort:          int32(fs.SftpPort()),
-					MountPoint:        fs.mountPoint,
-					MechanismArgsDesc: "all TCP connections",
-					Environment:       fs.env,
+					Id:                 cept.Id,
+					Disposition:        manager.InterceptDispositionType_ACTIVE,
+					PodIp:              fs.PodIP(),
+					PodName:            fs.PodName(),
+					SftpPort:           int32(fs.SftpPort()),
+					MountPoint:         fs.mountPoint,
+					MechanismArgsDesc:  "all TCP connections",
+					Environment:        fs.env,
+					EnvironmentSecrets: fs.secrets,
 				})
 			default:
 				// We already have an intercept in play, so reject this one.
@@ -92,7 +92,7 @@ func (s *state) HandleIntercepts(ctx context.Context, iis []*manager.InterceptIn
 		ms := make([]*manager.InterceptInfo, 0, len(iis))
 		for _, ii := range iis {
 			for _, ic := range ist.InterceptConfigs() {
-				if agentconfig.SpecMatchesIntercept(ii.Spec, ic) {
+				if agentconfig.SpecMatchesIntercept(ii.Spec, ic) || s.replacedBy(ii.Spec, ic) {
 					dlog.Debugf(ctx, "intercept id %s svc=%q, svcPort=%q matches config svc=%q, svcPort=%d",
 						ii.Id, ii.Spec.ServiceName, ii.Spec.ServicePortIdentifier, ic.ServiceName, ic.ServicePort)
 					ms = append(ms, ii)
@@ -105,6 +105,32 @@ func (s *state) HandleIntercepts(ctx context.Context, iis []*manager.InterceptIn
 	return rs
 }
 
+// replacedBy returns true if the given spec replaces the container that the given intercept config belongs to, in
+// which case the intercept receives the traffic of all ports of that container.
+func (s *state) replacedBy(spec *manager.InterceptSpec, ic *agentconfig.Intercept) bool {
+	if !spec.Replace {
+		return false
+	}
+	for _, cc := range s.AgentConfig().Containers {
+		if !cc.Replace {
+			continue
+		}
+		owned, matched := false, false
+		for _, cic := range cc.Intercepts {
+			if cic == ic {
+				owned = true
+			}
+			if agentconfig.SpecMatchesIntercept(spec, cic) {
+				matched = true
+			}
+		}
+		if owned {
+			return matched
+		}
+	}
+	return false
+}
+
 func (s *simpleState) HandleIntercepts(ctx context.Context, iis []*manager.InterceptInfo) []*manager.ReviewInterceptRequest {
 	if s.chosenIntercept != nil {
 		chosenID := s.chosenIntercept.Id
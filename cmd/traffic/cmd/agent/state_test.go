@@ -42,7 +42,7 @@ func makeFS(t *testing.T, ctx context.Context) (*forwarder.Forwarder, agent.Stat
 	s := agent.NewSimpleState(c)
 	cn := c.AgentConfig().Containers[0]
 	cnMountPoint := filepath.Join(agentconfig.ExportsMountPoint, filepath.Base(cn.MountPoint))
-	s.AddInterceptState(agent.NewInterceptState(s, f, cn.Intercepts, cnMountPoint, map[string]string{}))
+	s.AddInterceptState(agent.NewInterceptState(s, f, cn.Intercepts, cnMountPoint, map[string]string{}, nil))
 
 	return f, s
 }
@@ -0,0 +1,127 @@
+package agent
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+)
+
+// tlsFileNames are the names of the certificate, key, and CA files of the supported secret types,
+// kubernetes.io/tls and istio.io/key-and-cert.
+var tlsFileNames = [][3]string{
+	{"tls.crt", "tls.key", "ca.crt"},
+	{"cert-chain.pem", "key.pem", "root-cert.pem"},
+}
+
+// tlsConfigs returns the configs that the forwarders of the given container use when terminating TLS, and when
+// originating TLS to the app. Both are nil unless TLS termination is configured.
+func tlsConfigs(ac *agentconfig.Sidecar, cn *agentconfig.Container) (terminating, originating *tls.Config, err error) {
+	dir := ac.TerminatingTLSDir(cn)
+	if dir == "" {
+		return nil, nil, nil
+	}
+	cert, _, err := loadTLSFiles(dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to load the certificate used when terminating TLS: %w", err)
+	}
+	if cert == nil {
+		return nil, nil, fmt.Errorf("found no certificate to use when terminating TLS in %s", dir)
+	}
+	terminating = &tls.Config{
+		Certificates: []tls.Certificate{*cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if originating, err = originatingTLSConfig(ac.OriginatingTLSDir(cn)); err != nil {
+		return nil, nil, fmt.Errorf("unable to load the certificates used when originating TLS: %w", err)
+	}
+	return terminating, originating, nil
+}
+
+// withNextProtos returns a copy of the given terminating config that offers the application protocols that the
+// given intercepts, which share an agent port, declare. The config is returned as is when none is declared.
+func withNextProtos(terminating *tls.Config, ics []*agentconfig.Intercept) *tls.Config {
+	for _, ic := range ics {
+		if nps := ic.NextProtos(); nps != nil {
+			cfg := terminating.Clone()
+			cfg.NextProtos = nps
+			return cfg
+		}
+	}
+	return terminating
+}
+
+// loadTLSFiles loads the certificate and the CA found in the given directory. Both are nil when not found.
+func loadTLSFiles(dir string) (*tls.Certificate, *x509.CertPool, error) {
+	for _, fns := range tlsFileNames {
+		certFile := filepath.Join(dir, fns[0])
+		if _, err := os.Stat(certFile); err != nil {
+			continue
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, filepath.Join(dir, fns[1]))
+		if err != nil {
+			return nil, nil, err
+		}
+		caPEM, err := os.ReadFile(filepath.Join(dir, fns[2]))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return &cert, nil, nil
+			}
+			return nil, nil, err
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(caPEM) {
+			return nil, nil, fmt.Errorf("no certificates found in %s", filepath.Join(dir, fns[2]))
+		}
+		return &cert, roots, nil
+	}
+	return nil, nil, nil
+}
+
+// originatingTLSConfig returns the config used when originating TLS to the app. The app is reached using the pod's
+// loopback address, so its certificate is never issued for the host that is dialed. The certificate chain is
+// verified when the given directory contains a CA, but its host name is not.
+func originatingTLSConfig(dir string) (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: true}
+	if dir == "" {
+		return cfg, nil
+	}
+	cert, roots, err := loadTLSFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	if cert != nil {
+		cfg.Certificates = []tls.Certificate{*cert}
+	}
+	if roots != nil {
+		cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyChain(rawCerts, roots)
+		}
+	}
+	return cfg, nil
+}
+
+// verifyChain verifies the given certificate chain against the given roots without verifying the host name.
+func verifyChain(rawCerts [][]byte, roots *x509.CertPool) error {
+	if len(rawCerts) == 0 {
+		return errors.New("the app presented no certificate")
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		c, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs[i] = c
+	}
+	opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+	for _, c := range certs[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	_, err := certs[0].Verify(opts)
+	return err
+}
@@ -44,13 +44,19 @@ func loadConfig(ctx context.Context) (*config, error) {
 }
 
 func (c *config) configureIptables(ctx context.Context, iptables *iptables.IPTables, loopback string) error {
+	// A service mesh will typically use an UID different from the one used by this process
+	return ConfigureIptables(ctx, &c.Sidecar, iptables, loopback, os.Getuid())
+}
+
+// ConfigureIptables configures the rules that redirect the traffic of the intercepted ports of the given config to
+// the traffic-agent, which runs with the given UID. The rules are added to the network namespace of the calling
+// thread.
+func ConfigureIptables(ctx context.Context, c *agentconfig.Sidecar, iptables *iptables.IPTables, loopback string, uid int) error {
 	// These iptables rules implement routing such that a packet directed to the appPort will hit the agentPort instead.
 	// If there's no mesh this is simply request -> agent -> app (or intercept)
 	// However, if there's a service mesh we want to make sure we don't bypass the mesh, so the traffic
 	// will flow request -> mesh -> agent -> app
-
-	// A service mesh will typically use an UID different from the one used by this process
-	agentUID := strconv.Itoa(os.Getuid())
+	agentUID := strconv.Itoa(uid)
 
 	outputInsertCount := 0
 	for _, proto := range []string{"tcp", "udp"} {
@@ -105,7 +111,7 @@ func (c *config) configureIptables(ctx context.Context, iptables *iptables.IPTab
 		// Any traffic heading out of the loopback and into the app port (other than traffic from the agent) needs to
 		// be redirected to the agent. This will ensure that if there's a service mesh, when the mesh's proxy goes to
 		// request the application, it will get a response via the traffic agent.
-		err = iptables.Insert(nat, "OUTPUT", 1,
+		err = insertUnique(iptables, nat, "OUTPUT", 1,
 			"-o", loopback,
 			"-m", "owner", "!", "--uid-owner", agentUID,
 			"-j", chain)
@@ -118,7 +124,7 @@ func (c *config) configureIptables(ctx context.Context, iptables *iptables.IPTab
 		// it needs to be redirected. This is so that if the traffic agent requests its own IP, it doesn't just
 		// serve the app but actually goes through the agent, and thus through any intercepts.
 		// This is needed to support requesting an intercepted pod by IP (or to intercept a headless service).
-		err = iptables.Insert(nat, "OUTPUT", 1,
+		err = insertUnique(iptables, nat, "OUTPUT", 1,
 			"-o", loopback,
 			"-p", proto,
 			"!", "-d", "127.0.0.1/32",
@@ -133,7 +139,7 @@ func (c *config) configureIptables(ctx context.Context, iptables *iptables.IPTab
 	// Finally, any other traffic heading out of the traffic agent should pass by unperturbed -- it should obviously not be
 	// redirected back into the agent, but it also should not pass through a mesh proxy.
 	// This will include not just agent->manager traffic but also the agent requesting 127.0.0.1:appPort to serve the application
-	err := iptables.Insert(nat, "OUTPUT", 1+outputInsertCount,
+	err := insertUnique(iptables, nat, "OUTPUT", 1+outputInsertCount,
 		"-m", "owner", "--uid-owner", agentUID,
 		"-j", "RETURN")
 	if err != nil {
@@ -142,7 +148,37 @@ func (c *config) configureIptables(ctx context.Context, iptables *iptables.IPTab
 	return nil
 }
 
-func findLoopback(ctx context.Context) (string, error) {
+// insertUnique inserts the given rule at the given position of the chain, unless the chain has the rule already. The
+// rules can then be configured again in a network namespace that has them.
+func insertUnique(iptables *iptables.IPTables, table, chain string, pos int, rulespec ...string) error {
+	exists, err := iptables.Exists(table, chain, rulespec...)
+	if err != nil || exists {
+		return err
+	}
+	return iptables.Insert(table, chain, pos, rulespec...)
+}
+
+// ClearIptables clears the chains that the rules of ConfigureIptables direct the intercepted traffic to, so that the
+// traffic is no longer redirected to the traffic-agent. The rules that direct the traffic to the chains remain, but
+// the traffic passes the empty chains.
+func ClearIptables(iptables *iptables.IPTables) error {
+	for _, proto := range []string{"tcp", "udp"} {
+		chain := inboundChain + "_" + strings.ToUpper(proto)
+		exists, err := iptables.ChainExists(nat, chain)
+		if err != nil {
+			return fmt.Errorf("failed to check chain %s: %w", chain, err)
+		}
+		if exists {
+			if err = iptables.ClearChain(nat, chain); err != nil {
+				return fmt.Errorf("failed to clear chain %s: %w", chain, err)
+			}
+		}
+	}
+	return nil
+}
+
+// FindLoopback returns the name of the loopback interface of the network namespace of the calling thread.
+func FindLoopback(ctx context.Context) (string, error) {
 	ifaces, err := net.Interfaces()
 	if err != nil {
 		return "", fmt.Errorf("failed to get network interfaces: %w", err)
@@ -173,7 +209,7 @@ func Main(ctx context.Context, args ...string) error {
 		return err
 	}
 
-	lo, err := findLoopback(ctx)
+	lo, err := FindLoopback(ctx)
 	if err != nil {
 		dlog.Error(ctx, err)
 		return err
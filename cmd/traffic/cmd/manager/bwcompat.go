@@ -1,15 +1,95 @@
 package manager
 
 import (
+	"context"
 	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
 
+	"github.com/blang/semver"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
+	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+	"github.com/telepresenceio/telepresence/v2/pkg/version"
 )
 
+var (
+	// minClientVersion is the oldest client version that the traffic-manager accepts.
+	minClientVersion = semver.MustParse("2.6.0")
+
+	// firstWarnedClientVersion is the first client version that shows the warnings that the traffic-manager pushes
+	// in the header of its response to ArriveAsClient. Older clients ignore the header.
+	firstWarnedClientVersion = semver.MustParse("2.7.0")
+)
+
+// releaseOf returns the given version without its pre-release and build, so that pre-releases of a version
+// are considered to be of that version.
+func releaseOf(v semver.Version) semver.Version {
+	return semver.Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch}
+}
+
+// deprecatedClientVersion returns the version of the given client if it is of an older minor release than the
+// given version of the traffic-manager. Such clients are accepted, but the next minor release of the traffic-manager
+// won't accept them.
+func deprecatedClientVersion(ci *manager.ClientInfo, managerVersion semver.Version) (semver.Version, bool) {
+	sv, err := semver.Parse(strings.TrimPrefix(ci.GetVersion(), "v"))
+	if err != nil {
+		return semver.Version{}, false
+	}
+	oldMinor := sv.Major < managerVersion.Major || sv.Major == managerVersion.Major && sv.Minor < managerVersion.Minor
+	return sv, oldMinor
+}
+
+// warnDeprecatedClient warns the given client if it's deprecated by this traffic-manager. The warning is pushed
+// to clients that show it, and logged by the traffic-manager for all clients.
+func warnDeprecatedClient(ctx context.Context, ci *manager.ClientInfo) {
+	mv, err := semver.ParseTolerant(version.Version)
+	if err != nil {
+		return
+	}
+	sv, ok := deprecatedClientVersion(ci, mv)
+	if !ok {
+		return
+	}
+	msg := fmt.Sprintf("client version %s is deprecated and will not be supported by future versions "+
+		"of the traffic-manager, please upgrade the client to version %d.%d.0 or later", ci.Version, mv.Major, mv.Minor)
+	dlog.Warnf(ctx, "%s: %s", ci.Name, msg)
+	if !releaseOf(sv).LT(firstWarnedClientVersion) {
+		pushWarnings(ctx, msg)
+	}
+}
+
+// pushWarnings sends the given warnings to the client in the header of the response to the current call.
+func pushWarnings(ctx context.Context, warnings ...string) {
+	if err := grpc.SetHeader(ctx, client.ManagerWarningsHeader(warnings...)); err != nil {
+		dlog.Debugf(ctx, "unable to push warnings to client: %v", err)
+	}
+}
+
+// upgradeInterceptSpec translates an intercept spec created by an older client into the shape that the
+// traffic-manager and its agents expect. Older clients don't declare timeouts for the dials that the
+// agents make to the workstation, and such dials would then be cancelled immediately.
+func upgradeInterceptSpec(ctx context.Context, spec *manager.InterceptSpec) {
+	if spec.DialTimeout > 0 && spec.RoundtripLatency > 0 {
+		return
+	}
+	tos := client.GetDefaultConfig().Timeouts
+	if spec.DialTimeout <= 0 {
+		spec.DialTimeout = int64(tos.Get(client.TimeoutEndpointDial))
+	}
+	if spec.RoundtripLatency <= 0 {
+		spec.RoundtripLatency = int64(tos.Get(client.TimeoutRoundtripLatency)) * 2 // Account for extra hop
+	}
+	dlog.Debugf(ctx, "intercept %s: using default dial timeout %s and roundtrip latency %s",
+		spec.Name, time.Duration(spec.DialTimeout), time.Duration(spec.RoundtripLatency))
+}
+
 // muxExchangeVersion reads the version of the origin and sends our version back using the
 // deprecated mux tunnel
 // Deprecated
@@ -24,8 +24,8 @@ func validateClient(client *rpc.ClientInfo) string {
 	if err != nil {
 		return err.Error()
 	}
-	if sv.Major < 2 || sv.Major == 2 && sv.Minor < 6 {
-		return "client version must be at least 2.6.0"
+	if releaseOf(sv).LT(minClientVersion) {
+		return fmt.Sprintf("client version must be at least %s", minClientVersion)
 	}
 	return ""
 }
@@ -76,6 +76,8 @@ func validateIntercept(spec *rpc.InterceptSpec) string {
 		return "namespace must not be empty"
 	case spec.Mechanism == "":
 		return "mechanism must not be empty"
+	case spec.Replace && spec.Mechanism != "tcp":
+		return "a replace intercept must use the tcp mechanism"
 	}
 
 	return ""
@@ -192,6 +192,8 @@ func NewInfo(ctx context.Context) Info {
 		dlog.Errorf(ctx, "invalid POD_CIDR_STRATEGY %q", podCIDRStrategy)
 	}
 
+	go oi.watchServiceSubnets(ctx, managedNamespaces)
+
 	oi.ManagerPodIp = iputil.Parse(env.PodIP)
 	if oi.ManagerPodIp == nil {
 		dlog.Warnf(ctx, "Unable to get manager pod ip; env var says %s", env.PodIP)
@@ -262,6 +264,39 @@ func (oi *info) watchPodSubnets(ctx context.Context, namespaces []string) bool {
 	return true
 }
 
+func (oi *info) watchServiceSubnets(ctx context.Context, namespaces []string) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if len(namespaces) == 0 {
+		// Create one lister and one informer that have cluster wide scope
+		namespaces = []string{""}
+	}
+	svcListers := make([]ServiceLister, len(namespaces))
+	svcInformers := make([]cache.SharedIndexInformer, len(namespaces))
+	for i, ns := range namespaces {
+		var opts []informers.SharedInformerOption
+		if ns != "" {
+			opts = []informers.SharedInformerOption{informers.WithNamespace(ns)}
+		}
+		informerFactory := informers.NewSharedInformerFactoryWithOptions(k8sapi.GetK8sInterface(ctx), 0, opts...)
+		svcController := informerFactory.Core().V1().Services()
+		svcListers[i] = svcController.Lister()
+		svcInformers[i] = svcController.Informer()
+		informerFactory.Start(ctx.Done())
+		informerFactory.WaitForCacheSync(ctx.Done())
+	}
+
+	retriever := newServiceWatcher(ctx, svcListers, svcInformers)
+	if !retriever.viable(ctx) {
+		dlog.Infof(ctx, "Unable to derive subnets from cluster IPs of services")
+	}
+	retriever.changeNotifier(ctx, func(subnets subnet.Set) {
+		oi.ServiceIpSubnets = toRPCSubnets(subnets)
+		oi.ciSubs.notify(ctx, oi.clusterInfo())
+	})
+}
+
 func (oi *info) setSubnetsFromEnv(ctx context.Context) bool {
 	pcEnv := managerutil.GetEnv(ctx).PodCIDRs
 	cidrStrs := strings.Split(pcEnv, " ")
@@ -307,6 +342,10 @@ func (oi *info) clusterInfo() *rpc.ClusterInfo {
 		ManagerPodIp:  oi.ManagerPodIp,
 	}
 	copy(ci.PodSubnets, oi.PodSubnets)
+	if len(oi.ServiceIpSubnets) > 0 {
+		ci.ServiceIpSubnets = make([]*rpc.IPNet, len(oi.ServiceIpSubnets))
+		copy(ci.ServiceIpSubnets, oi.ServiceIpSubnets)
+	}
 	return ci
 }
 
@@ -0,0 +1,133 @@
+package cluster
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/subnet"
+)
+
+// Check for changes every 5 second
+const ipReviewPeriod = 5 * time.Second
+
+// The time we wait from when the first change arrived until we actually do something. This
+// so that more changes can arrive (hopefully all of them) before everything is recalculated.
+const ipCollectTime = 3 * time.Second
+
+// ipWatcher keeps track of a set of IPs and computes the subnets that cover them when the set changes.
+type ipWatcher struct {
+	name    string
+	ipsMap  map[iputil.IPKey]struct{}
+	subnets subnet.Set
+	changed time.Time
+	lock    sync.Mutex // Protects all access to ipsMap
+}
+
+func newIPWatcher(name string) ipWatcher {
+	return ipWatcher{
+		name:    name,
+		ipsMap:  make(map[iputil.IPKey]struct{}),
+		subnets: make(subnet.Set),
+	}
+}
+
+func (w *ipWatcher) changeNotifier(ctx context.Context, updateSubnets func(set subnet.Set)) {
+	ticker := time.NewTicker(ipReviewPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		w.lock.Lock()
+		if w.changed.IsZero() || time.Since(w.changed) < ipCollectTime {
+			w.lock.Unlock()
+			continue
+		}
+		w.changed = time.Time{}
+		ips := make(iputil.IPs, len(w.ipsMap))
+		i := 0
+		for ip := range w.ipsMap {
+			ips[i] = ip.IP()
+			i++
+		}
+		w.lock.Unlock()
+		subnets := subnet.NewSet(subnet.CoveringCIDRs(ips))
+		if !subnets.Equals(w.subnets) {
+			w.subnets = subnets
+			updateSubnets(subnets)
+		}
+		dlog.Debugf(ctx, "%s calling updateSubnets with %v", w.name, subnets)
+	}
+}
+
+func (w *ipWatcher) add(ips []iputil.IPKey) {
+	w.lock.Lock()
+	if w.addLocked(ips) {
+		// If this was the first change since the last subnet calculation, then store
+		// its timestamp. Subsequent changes will not change that timestamp until it's
+		// reset by the subnet compute worker.
+		if w.changed.IsZero() {
+			w.changed = time.Now()
+		}
+	}
+	w.lock.Unlock()
+}
+
+func (w *ipWatcher) drop(ips []iputil.IPKey) {
+	w.lock.Lock()
+	if w.dropLocked(ips) {
+		// If this was the first change since the last subnet calculation, then store
+		// its timestamp. Subsequent changes will not change that timestamp until it's
+		// reset by the subnet compute worker.
+		if w.changed.IsZero() {
+			w.changed = time.Now()
+		}
+	}
+	w.lock.Unlock()
+}
+
+func (w *ipWatcher) update(dropped, added []iputil.IPKey) {
+	w.lock.Lock()
+	if w.dropLocked(dropped) || w.addLocked(added) {
+		// If this was the first change since the last subnet calculation, then store
+		// its timestamp. Subsequent changes will not change that timestamp until it's
+		// reset by the subnet compute worker.
+		if w.changed.IsZero() {
+			w.changed = time.Now()
+		}
+	}
+	w.lock.Unlock()
+}
+
+func (w *ipWatcher) addLocked(ips []iputil.IPKey) bool {
+	if w.ipsMap == nil {
+		w.ipsMap = make(map[iputil.IPKey]struct{}, 100)
+	}
+
+	changed := false
+	exists := struct{}{}
+	for _, ip := range ips {
+		if _, ok := w.ipsMap[ip]; !ok {
+			w.ipsMap[ip] = exists
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (w *ipWatcher) dropLocked(ips []iputil.IPKey) bool {
+	changed := false
+	for _, ip := range ips {
+		if _, ok := w.ipsMap[ip]; ok {
+			delete(w.ipsMap, ip)
+			changed = true
+		}
+	}
+	return changed
+}
@@ -2,7 +2,6 @@ package cluster
 
 import (
 	"context"
-	"sync"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
@@ -11,7 +10,6 @@ import (
 
 	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
-	"github.com/telepresenceio/telepresence/v2/pkg/subnet"
 )
 
 // PodLister helps list Pods.
@@ -23,20 +21,16 @@ type PodLister interface {
 }
 
 type podWatcher struct {
+	ipWatcher
 	listers   []PodLister
 	informers []cache.SharedIndexInformer
-	ipsMap    map[iputil.IPKey]struct{}
-	subnets   subnet.Set
-	changed   time.Time
-	lock      sync.Mutex // Protects all access to ipsMap
 }
 
 func newPodWatcher(ctx context.Context, listers []PodLister, informers []cache.SharedIndexInformer) *podWatcher {
 	w := &podWatcher{
+		ipWatcher: newIPWatcher("podWatcher"),
 		listers:   listers,
 		informers: informers,
-		ipsMap:    make(map[iputil.IPKey]struct{}),
-		subnets:   make(subnet.Set),
 	}
 	for _, informer := range informers {
 		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
@@ -54,45 +48,6 @@ func newPodWatcher(ctx context.Context, listers []PodLister, informers []cache.S
 	return w
 }
 
-func (w *podWatcher) changeNotifier(ctx context.Context, updateSubnets func(set subnet.Set)) {
-	// Check for changes every 5 second
-	const podReviewPeriod = 5 * time.Second
-
-	// The time we wait from when the first change arrived until we actually do something. This
-	// so that more changes can arrive (hopefully all of them) before everything is recalculated.
-	const podCollectTime = 3 * time.Second
-
-	ticker := time.NewTicker(podReviewPeriod)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-		}
-		w.lock.Lock()
-		if w.changed.IsZero() || time.Since(w.changed) < podCollectTime {
-			w.lock.Unlock()
-			continue
-		}
-		w.changed = time.Time{}
-		ips := make(iputil.IPs, len(w.ipsMap))
-		i := 0
-		for ip := range w.ipsMap {
-			ips[i] = ip.IP()
-			i++
-		}
-		w.lock.Unlock()
-		subnets := subnet.NewSet(subnet.CoveringCIDRs(ips))
-		if !subnets.Equals(w.subnets) {
-			w.subnets = subnets
-			updateSubnets(subnets)
-		}
-		dlog.Debugf(ctx, "podWatcher calling updateSubnets with %v", subnets)
-	}
-}
-
 func (w *podWatcher) viable(ctx context.Context) bool {
 	if len(w.ipsMap) > 0 {
 		return true
@@ -147,72 +102,6 @@ func (w *podWatcher) onPodUpdated(ctx context.Context, oldPod, newPod *corev1.Po
 	}
 }
 
-func (w *podWatcher) add(ips []iputil.IPKey) {
-	w.lock.Lock()
-	if w.addLocked(ips) {
-		// If this was the first change since the last subnet calculation, then store
-		// its timestamp. Subsequent changes will not change that timestamp until it's
-		// reset by the subnet compute worker.
-		if w.changed.IsZero() {
-			w.changed = time.Now()
-		}
-	}
-	w.lock.Unlock()
-}
-
-func (w *podWatcher) drop(ips []iputil.IPKey) {
-	w.lock.Lock()
-	if w.dropLocked(ips) {
-		// If this was the first change since the last subnet calculation, then store
-		// its timestamp. Subsequent changes will not change that timestamp until it's
-		// reset by the subnet compute worker.
-		if w.changed.IsZero() {
-			w.changed = time.Now()
-		}
-	}
-	w.lock.Unlock()
-}
-
-func (w *podWatcher) update(dropped, added []iputil.IPKey) {
-	w.lock.Lock()
-	if w.dropLocked(dropped) || w.addLocked(added) {
-		// If this was the first change since the last subnet calculation, then store
-		// its timestamp. Subsequent changes will not change that timestamp until it's
-		// reset by the subnet compute worker.
-		if w.changed.IsZero() {
-			w.changed = time.Now()
-		}
-	}
-	w.lock.Unlock()
-}
-
-func (w *podWatcher) addLocked(ips []iputil.IPKey) bool {
-	if w.ipsMap == nil {
-		w.ipsMap = make(map[iputil.IPKey]struct{}, 100)
-	}
-
-	changed := false
-	exists := struct{}{}
-	for _, ip := range ips {
-		if _, ok := w.ipsMap[ip]; !ok {
-			w.ipsMap[ip] = exists
-			changed = true
-		}
-	}
-	return changed
-}
-
-func (w *podWatcher) dropLocked(ips []iputil.IPKey) bool {
-	changed := false
-	for _, ip := range ips {
-		if _, ok := w.ipsMap[ip]; ok {
-			delete(w.ipsMap, ip)
-			changed = true
-		}
-	}
-	return changed
-}
-
 // getIPsDelta returns the difference between the old and new IPs.
 //
 // NOTE! The array of the old slice is modified and used for the dropped return
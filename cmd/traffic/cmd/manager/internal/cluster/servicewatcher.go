@@ -0,0 +1,119 @@
+package cluster
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+)
+
+// ServiceLister helps list Services.
+// All objects returned here must be treated as read-only.
+type ServiceLister interface {
+	// List lists all Services in the indexer.
+	// Objects returned here must be treated as read-only.
+	List(selector labels.Selector) (ret []*corev1.Service, err error)
+}
+
+// serviceWatcher tracks the cluster IPs of services, so that clients can route the subnets that cover them
+// instead of the whole service subnet.
+type serviceWatcher struct {
+	ipWatcher
+	listers []ServiceLister
+}
+
+func newServiceWatcher(ctx context.Context, listers []ServiceLister, informers []cache.SharedIndexInformer) *serviceWatcher {
+	w := &serviceWatcher{
+		ipWatcher: newIPWatcher("serviceWatcher"),
+		listers:   listers,
+	}
+	for _, informer := range informers {
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj any) {
+				if ipKeys := serviceIPKeys(ctx, obj); len(ipKeys) > 0 {
+					w.add(ipKeys)
+				}
+			},
+			DeleteFunc: func(obj any) {
+				if ipKeys := serviceIPKeys(ctx, obj); len(ipKeys) > 0 {
+					w.drop(ipKeys)
+				}
+			},
+			UpdateFunc: func(oldObj, newObj any) {
+				added, dropped := getIPsDelta(serviceIPKeys(ctx, oldObj), serviceIPKeys(ctx, newObj))
+				switch {
+				case len(added) > 0 && len(dropped) > 0:
+					w.update(dropped, added)
+				case len(added) > 0:
+					w.add(added)
+				case len(dropped) > 0:
+					w.drop(dropped)
+				}
+			},
+		})
+	}
+	return w
+}
+
+func (w *serviceWatcher) viable(ctx context.Context) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if len(w.ipsMap) > 0 {
+		return true
+	}
+
+	// Create the initial snapshot
+	changed := false
+	for _, lister := range w.listers {
+		svcs, err := lister.List(labels.Everything())
+		if err != nil {
+			dlog.Errorf(ctx, "unable to list services: %v", err)
+			return false
+		}
+		for _, svc := range svcs {
+			if w.addLocked(serviceIPKeys(ctx, svc)) {
+				changed = true
+			}
+		}
+	}
+	w.changed = time.Now()
+	return changed
+}
+
+// serviceIPKeys returns the cluster IPs of the given service. Headless and ExternalName services have none.
+func serviceIPKeys(ctx context.Context, obj any) []iputil.IPKey {
+	svc, ok := obj.(*corev1.Service)
+	if !ok {
+		if dfsu, isDfsu := obj.(cache.DeletedFinalStateUnknown); isDfsu {
+			svc, ok = dfsu.Obj.(*corev1.Service)
+		}
+		if !ok {
+			return nil
+		}
+	}
+	if svc.Spec.Type == corev1.ServiceTypeExternalName {
+		return nil
+	}
+	clusterIPs := svc.Spec.ClusterIPs
+	if len(clusterIPs) == 0 && svc.Spec.ClusterIP != "" {
+		clusterIPs = []string{svc.Spec.ClusterIP}
+	}
+	ips := make([]iputil.IPKey, 0, len(clusterIPs))
+	for _, cs := range clusterIPs {
+		if cs == "" || cs == corev1.ClusterIPNone {
+			continue
+		}
+		ip := iputil.Parse(cs)
+		if ip == nil {
+			dlog.Errorf(ctx, "unable to parse cluster IP %q of service %s.%s", cs, svc.Name, svc.Namespace)
+			continue
+		}
+		ips = append(ips, iputil.IPKey(ip))
+	}
+	return ips
+}
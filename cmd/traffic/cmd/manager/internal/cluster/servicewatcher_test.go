@@ -0,0 +1,37 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+)
+
+func Test_serviceIPKeys(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	svc := func(tp corev1.ServiceType, clusterIP string, clusterIPs ...string) *corev1.Service {
+		return &corev1.Service{Spec: corev1.ServiceSpec{Type: tp, ClusterIP: clusterIP, ClusterIPs: clusterIPs}}
+	}
+	v6IP := iputil.IPKey(iputil.Parse("fd00::1"))
+	tests := []struct {
+		name string
+		obj  any
+		want []iputil.IPKey
+	}{
+		{"not a service", &corev1.Pod{}, nil},
+		{"cluster IP", svc(corev1.ServiceTypeClusterIP, "192.168.0.1"), []iputil.IPKey{oneIP}},
+		{"dual stack", svc(corev1.ServiceTypeClusterIP, "192.168.0.1", "192.168.0.1", "fd00::1"), []iputil.IPKey{oneIP, v6IP}},
+		{"headless", svc(corev1.ServiceTypeClusterIP, corev1.ClusterIPNone, corev1.ClusterIPNone), []iputil.IPKey{}},
+		{"external name", svc(corev1.ServiceTypeExternalName, ""), nil},
+		{"deleted", cache.DeletedFinalStateUnknown{Obj: svc(corev1.ServiceTypeNodePort, "192.168.0.3")}, []iputil.IPKey{threeIP}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, serviceIPKeys(ctx, tt.obj))
+		})
+	}
+}
@@ -0,0 +1,397 @@
+// Package expose makes the local ports of clients reachable from the cluster. The traffic-manager listens on a port
+// for each exposed port, and a Service without a selector, which has the DNS name of the session, routes the
+// connections to those listeners. A connection is then tunneled to the client, which dials its local port.
+package expose
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/datawire/dlib/dcontext"
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+// SessionLabel is the label with the ID of the session on the Services and Endpoints that expose its ports.
+const SessionLabel = agentconfig.DomainPrefix + "session-id"
+
+const (
+	// The timeouts of the tunnels to the clients. They're the defaults of the clients.
+	roundtripLatency = 2 * time.Second
+	dialTimeout      = 3 * time.Second
+)
+
+// Sessions are the client sessions of the traffic-manager.
+type Sessions interface {
+	// SessionDone returns a channel that is closed when the session with the given ID ends.
+	SessionDone(id string) (<-chan struct{}, error)
+
+	// ConnectClient extends the given stream to the client of the session with the given ID.
+	ConnectClient(ctx context.Context, sessionID string, stream tunnel.Stream) (tunnel.Endpoint, error)
+}
+
+// Exposer exposes the ports of the clients using Services in one namespace.
+type Exposer struct {
+	sync.Mutex
+	ctx       context.Context
+	namespace string
+	podIP     string
+	sessions  Sessions
+
+	// exposed are the sessions that expose ports, keyed by session ID.
+	exposed map[string]*exposed
+}
+
+// exposed are the ports that a session exposes.
+type exposed struct {
+	sessionID string
+	user      string
+	name      string
+	cancel    context.CancelFunc
+}
+
+// NewExposer returns an Exposer that creates its Services in the given namespace, with endpoints at the given IP
+// of the traffic-manager. A nil Exposer, which exposes nothing, is returned when the namespace is empty.
+func NewExposer(ctx context.Context, namespace, podIP string, sessions Sessions) (*Exposer, error) {
+	if namespace == "" {
+		return nil, nil
+	}
+	if net.ParseIP(podIP) == nil {
+		return nil, fmt.Errorf("unable to expose the ports of clients: the pod IP %q of the traffic-manager is invalid", podIP)
+	}
+	return &Exposer{
+		ctx:       ctx,
+		namespace: namespace,
+		podIP:     podIP,
+		sessions:  sessions,
+		exposed:   make(map[string]*exposed),
+	}, nil
+}
+
+// Run removes the Services of sessions that no longer exist, i.e. those of a previous traffic-manager, and removes
+// the Services of all sessions when the given context is cancelled.
+func (e *Exposer) Run(ctx context.Context) error {
+	if e == nil {
+		return nil
+	}
+	e.removeStale(ctx)
+	<-ctx.Done()
+	ctx, cancel := context.WithTimeout(dcontext.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	e.Lock()
+	names := make([]string, 0, len(e.exposed))
+	for _, x := range e.exposed {
+		names = append(names, x.name)
+	}
+	e.Unlock()
+	for _, name := range names {
+		e.deleteService(ctx, name)
+	}
+	return nil
+}
+
+// Expose exposes the given ports of the client of the given session, and returns the DNS name that the pods in the
+// cluster use to connect to them. The name is derived from the given user, and it's valid until the session ends.
+// An empty name is returned when there are no ports, or when the Exposer is nil.
+func (e *Exposer) Expose(ctx context.Context, sessionID, user string, ports []*rpc.ExposedPort) (string, error) {
+	if e == nil || len(ports) == 0 {
+		return "", nil
+	}
+	if err := validatePorts(ports); err != nil {
+		return "", err
+	}
+	done, err := e.sessions.SessionDone(sessionID)
+	if err != nil {
+		return "", err
+	}
+	lctx, cancel := context.WithCancel(e.ctx)
+	x, err := e.reserve(sessionID, user, cancel)
+	if err != nil {
+		cancel()
+		return "", err
+	}
+
+	listenPorts := make([]int32, len(ports))
+	for i, p := range ports {
+		l, err := net.Listen("tcp", ":0")
+		if err != nil {
+			e.release(ctx, x)
+			return "", fmt.Errorf("unable to listen for connections to port %d of %s: %w", p.Port, x.name, err)
+		}
+		listenPorts[i] = int32(l.Addr().(*net.TCPAddr).Port)
+		go e.serve(lctx, x, p, l)
+	}
+	if err = e.applyService(ctx, x, ports, listenPorts); err != nil {
+		e.release(ctx, x)
+		return "", err
+	}
+	go func() {
+		select {
+		case <-done:
+			e.release(e.ctx, x)
+		case <-e.ctx.Done():
+		}
+	}()
+	dlog.Infof(ctx, "Exposed %s of session %s as %s.%s", portList(ports), sessionID, x.name, e.namespace)
+	return x.name + "." + e.namespace, nil
+}
+
+// reserve reserves a name for the given session. The name is the DNS label of the user. A name that another session
+// uses is never given to the session, because the pods that connect to the name expect the other session. The name
+// of a session that already exposes ports is kept, and its ports are replaced. The given cancel function stops the
+// listeners of the ports.
+func (e *Exposer) reserve(sessionID, user string, cancel context.CancelFunc) (*exposed, error) {
+	name := DNSLabel(user)
+	if name == "" {
+		return nil, fmt.Errorf("unable to derive a DNS name from user %q", user)
+	}
+	e.Lock()
+	defer e.Unlock()
+	if x, ok := e.exposed[sessionID]; ok {
+		x.cancel()
+		x = &exposed{sessionID: sessionID, user: x.user, name: x.name, cancel: cancel}
+		e.exposed[sessionID] = x
+		return x, nil
+	}
+	if o := e.nameOwner(name); o != nil {
+		if o.user == user {
+			return nil, fmt.Errorf("the name %s.%s is taken by another session of %s. Disconnect that session to expose the ports of this one",
+				name, e.namespace, user)
+		}
+		return nil, fmt.Errorf("the name %s.%s is taken by a session of user %s", name, e.namespace, o.user)
+	}
+	x := &exposed{sessionID: sessionID, user: user, name: name, cancel: cancel}
+	e.exposed[sessionID] = x
+	return x, nil
+}
+
+// nameOwner returns the ports that are exposed using the given name, or nil if no session uses it.
+func (e *Exposer) nameOwner(name string) *exposed {
+	for _, x := range e.exposed {
+		if x.name == name {
+			return x
+		}
+	}
+	return nil
+}
+
+// release stops exposing the ports of the given session, unless the session has exposed them again since.
+func (e *Exposer) release(ctx context.Context, x *exposed) {
+	e.Lock()
+	current := e.exposed[x.sessionID] == x
+	if current {
+		delete(e.exposed, x.sessionID)
+	}
+	e.Unlock()
+	x.cancel()
+	if current {
+		e.deleteService(ctx, x.name)
+	}
+}
+
+// serve accepts the connections of the given listener and tunnels them to the given port of the client.
+func (e *Exposer) serve(ctx context.Context, x *exposed, p *rpc.ExposedPort, l net.Listener) {
+	go func() {
+		<-ctx.Done()
+		_ = l.Close()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				dlog.Errorf(ctx, "failed to accept connections to port %d of %s: %v", p.Port, x.name, err)
+			}
+			return
+		}
+		go e.forward(ctx, x.sessionID, p, conn)
+	}
+}
+
+// forward tunnels the given connection to the local port of the client of the given session.
+func (e *Exposer) forward(ctx context.Context, sessionID string, p *rpc.ExposedPort, conn net.Conn) {
+	srcIP, srcPort, err := iputil.SplitToIPPort(conn.RemoteAddr())
+	if err != nil {
+		dlog.Errorf(ctx, "failed to parse the source address %s: %v", conn.RemoteAddr(), err)
+		_ = conn.Close()
+		return
+	}
+	id := tunnel.NewConnID(ipproto.TCP, srcIP, net.IPv4(127, 0, 0, 1), srcPort, uint16(p.LocalPort))
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The client end of the pipe is attached to the connection, and the server end is extended to the client.
+	cs, ss := tunnel.NewPipe(ctx.Done())
+	go func() {
+		s, err := tunnel.NewServerStream(ctx, ss)
+		if err != nil {
+			dlog.Errorf(ctx, "!! CONN %s, %v", id, err)
+			return
+		}
+		ep, err := e.sessions.ConnectClient(ctx, sessionID, s)
+		if err != nil {
+			dlog.Errorf(ctx, "!! CONN %s, unable to extend the tunnel to the client: %v", id, err)
+			cancel()
+			return
+		}
+		<-ep.Done()
+	}()
+	s, err := tunnel.NewClientStream(ctx, cs, id, sessionID, roundtripLatency, dialTimeout, tunnel.NoCompression)
+	if err != nil {
+		dlog.Errorf(ctx, "!! CONN %s, %v", id, err)
+		_ = conn.Close()
+		return
+	}
+	ep := tunnel.NewConnEndpoint(s, conn)
+	ep.Start(ctx)
+	<-ep.Done()
+}
+
+// applyService creates or updates the Service and the Endpoints that route the given ports to the given ports of
+// the listeners.
+func (e *Exposer) applyService(ctx context.Context, x *exposed, ports []*rpc.ExposedPort, listenPorts []int32) error {
+	om := meta.ObjectMeta{
+		Name:      x.name,
+		Namespace: e.namespace,
+		Labels: map[string]string{
+			"app.kubernetes.io/created-by": "traffic-manager",
+			SessionLabel:                   x.sessionID,
+		},
+	}
+	svcPorts := make([]core.ServicePort, len(ports))
+	epPorts := make([]core.EndpointPort, len(ports))
+	for i, p := range ports {
+		name := "port-" + strconv.Itoa(int(p.Port))
+		svcPorts[i] = core.ServicePort{Name: name, Protocol: core.ProtocolTCP, Port: p.Port, TargetPort: intstr.FromInt(int(listenPorts[i]))}
+		epPorts[i] = core.EndpointPort{Name: name, Protocol: core.ProtocolTCP, Port: listenPorts[i]}
+	}
+	ep := &core.Endpoints{
+		ObjectMeta: om,
+		Subsets: []core.EndpointSubset{{
+			Addresses: []core.EndpointAddress{{IP: e.podIP}},
+			Ports:     epPorts,
+		}},
+	}
+	svc := &core.Service{ObjectMeta: om, Spec: core.ServiceSpec{Ports: svcPorts}}
+
+	ki := k8sapi.GetK8sInterface(ctx).CoreV1()
+	svcAPI := ki.Services(e.namespace)
+	if old, err := svcAPI.Get(ctx, x.name, meta.GetOptions{}); err == nil {
+		old.Labels = svc.Labels
+		old.Spec.Ports = svc.Spec.Ports
+		_, err = svcAPI.Update(ctx, old, meta.UpdateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to update Service %s.%s: %w", x.name, e.namespace, err)
+		}
+	} else if !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get Service %s.%s: %w", x.name, e.namespace, err)
+	} else if _, err = svcAPI.Create(ctx, svc, meta.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create Service %s.%s: %w", x.name, e.namespace, err)
+	}
+
+	epAPI := ki.Endpoints(e.namespace)
+	if old, err := epAPI.Get(ctx, x.name, meta.GetOptions{}); err == nil {
+		old.Labels = ep.Labels
+		old.Subsets = ep.Subsets
+		_, err = epAPI.Update(ctx, old, meta.UpdateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to update Endpoints %s.%s: %w", x.name, e.namespace, err)
+		}
+	} else if !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get Endpoints %s.%s: %w", x.name, e.namespace, err)
+	} else if _, err = epAPI.Create(ctx, ep, meta.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create Endpoints %s.%s: %w", x.name, e.namespace, err)
+	}
+	return nil
+}
+
+// deleteService deletes the Service and the Endpoints with the given name.
+func (e *Exposer) deleteService(ctx context.Context, name string) {
+	ki := k8sapi.GetK8sInterface(ctx).CoreV1()
+	if err := ki.Services(e.namespace).Delete(ctx, name, meta.DeleteOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+		dlog.Errorf(ctx, "failed to delete Service %s.%s: %v", name, e.namespace, err)
+	}
+	if err := ki.Endpoints(e.namespace).Delete(ctx, name, meta.DeleteOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+		dlog.Errorf(ctx, "failed to delete Endpoints %s.%s: %v", name, e.namespace, err)
+	}
+}
+
+// removeStale deletes the Services of sessions that the Exposer doesn't know.
+func (e *Exposer) removeStale(ctx context.Context) {
+	svcs, err := k8sapi.GetK8sInterface(ctx).CoreV1().Services(e.namespace).List(ctx, meta.ListOptions{LabelSelector: SessionLabel})
+	if err != nil {
+		dlog.Errorf(ctx, "failed to list the Services in namespace %s: %v", e.namespace, err)
+		return
+	}
+	for _, svc := range svcs.Items {
+		e.Lock()
+		x, ok := e.exposed[svc.Labels[SessionLabel]]
+		e.Unlock()
+		if !ok || x.name != svc.Name {
+			dlog.Debugf(ctx, "Deleting stale Service %s.%s", svc.Name, e.namespace)
+			e.deleteService(ctx, svc.Name)
+		}
+	}
+}
+
+func validatePorts(ports []*rpc.ExposedPort) error {
+	seen := make(map[int32]struct{}, len(ports))
+	for _, p := range ports {
+		if p.Port < 1 || p.Port > 0xffff || p.LocalPort < 1 || p.LocalPort > 0xffff {
+			return fmt.Errorf("invalid exposed port %d:%d", p.LocalPort, p.Port)
+		}
+		if _, ok := seen[p.Port]; ok {
+			return fmt.Errorf("port %d is exposed more than once", p.Port)
+		}
+		seen[p.Port] = struct{}{}
+	}
+	return nil
+}
+
+func portList(ports []*rpc.ExposedPort) string {
+	ps := make([]string, len(ports))
+	for i, p := range ports {
+		ps[i] = strconv.Itoa(int(p.Port))
+	}
+	return "port " + strings.Join(ps, ", ")
+}
+
+var nonLabelChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// DNSLabel returns the given user as an RFC 1035 label, i.e. lower case letters, digits, and dashes, starting with a
+// letter. The domain of a user that is an email address, and the prefix of a Kubernetes user, are dropped, so
+// "Jane.Doe@example.com" becomes "jane-doe", and "system:serviceaccount:dev:ci" becomes "ci". An empty string is
+// returned when no letters remain.
+func DNSLabel(user string) string {
+	if i := strings.IndexByte(user, '@'); i >= 0 {
+		user = user[:i]
+	}
+	if i := strings.LastIndexByte(user, ':'); i >= 0 {
+		user = user[i+1:]
+	}
+	label := nonLabelChars.ReplaceAllString(strings.ToLower(user), "-")
+	label = strings.TrimLeft(label, "-0123456789")
+	return strings.TrimRight(truncate(label, 63), "-")
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
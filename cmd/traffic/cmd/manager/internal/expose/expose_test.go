@@ -0,0 +1,169 @@
+package expose
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+// fakeSessions are client sessions whose clients dial the connections themselves, like a client does when the
+// traffic-manager sends it a DialRequest.
+type fakeSessions struct {
+	sync.Mutex
+	done map[string]chan struct{}
+}
+
+func (s *fakeSessions) SessionDone(id string) (<-chan struct{}, error) {
+	s.Lock()
+	defer s.Unlock()
+	done, ok := s.done[id]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "session %q not found", id)
+	}
+	return done, nil
+}
+
+func (s *fakeSessions) ConnectClient(ctx context.Context, _ string, stream tunnel.Stream) (tunnel.Endpoint, error) {
+	d := tunnel.NewDialer(stream)
+	d.Start(ctx)
+	return d, nil
+}
+
+func echoServer(t *testing.T) int32 {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return int32(l.Addr().(*net.TCPAddr).Port)
+}
+
+func TestExposer_Expose(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	cs := fake.NewSimpleClientset()
+	ctx = k8sapi.WithK8sInterface(ctx, cs)
+	sessions := &fakeSessions{done: map[string]chan struct{}{"s1": make(chan struct{}), "s2": make(chan struct{})}}
+	e, err := NewExposer(ctx, "telepresence", "10.1.2.3", sessions)
+	require.NoError(t, err)
+
+	localPort := echoServer(t)
+	name, err := e.Expose(ctx, "s1", "Jane.Doe@example.com", []*rpc.ExposedPort{{Port: 80, LocalPort: localPort}})
+	require.NoError(t, err)
+	assert.Equal(t, "jane-doe.telepresence", name)
+
+	svc, err := cs.CoreV1().Services("telepresence").Get(ctx, "jane-doe", meta.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "s1", svc.Labels[SessionLabel])
+	assert.Nil(t, svc.Spec.Selector)
+	require.Len(t, svc.Spec.Ports, 1)
+	assert.Equal(t, int32(80), svc.Spec.Ports[0].Port)
+	listenPort := svc.Spec.Ports[0].TargetPort.IntVal
+
+	ep, err := cs.CoreV1().Endpoints("telepresence").Get(ctx, "jane-doe", meta.GetOptions{})
+	require.NoError(t, err)
+	require.Len(t, ep.Subsets, 1)
+	assert.Equal(t, "10.1.2.3", ep.Subsets[0].Addresses[0].IP)
+	assert.Equal(t, listenPort, ep.Subsets[0].Ports[0].Port)
+	assert.Equal(t, svc.Spec.Ports[0].Name, ep.Subsets[0].Ports[0].Name)
+
+	// A connection to the listener reaches the local port of the client
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", svc.Spec.Ports[0].TargetPort.String()))
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+
+	// A name that another session uses is never taken over, and the error tells who uses it
+	_, err = e.Expose(ctx, "s2", "Jane.Doe@example.com", []*rpc.ExposedPort{{Port: 8080, LocalPort: localPort}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "another session of Jane.Doe@example.com")
+	_, err = e.Expose(ctx, "s2", "jane.doe@other.example.com", []*rpc.ExposedPort{{Port: 8080, LocalPort: localPort}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "jane-doe.telepresence is taken by a session of user Jane.Doe@example.com")
+	name, err = e.Expose(ctx, "s2", "john@example.com", []*rpc.ExposedPort{{Port: 8080, LocalPort: localPort}})
+	require.NoError(t, err)
+	assert.Equal(t, "john.telepresence", name)
+
+	_, err = e.Expose(ctx, "s3", "john", []*rpc.ExposedPort{{Port: 8080, LocalPort: localPort}})
+	assert.Error(t, err, "a session that doesn't exist exposed ports")
+	_, err = e.Expose(ctx, "s1", "jane", []*rpc.ExposedPort{{Port: 80, LocalPort: 0}})
+	assert.Error(t, err)
+
+	// The Service is deleted when the session ends
+	close(sessions.done["s1"])
+	assert.Eventually(t, func() bool {
+		_, err := cs.CoreV1().Services("telepresence").Get(ctx, "jane-doe", meta.GetOptions{})
+		return err != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	_, err = cs.CoreV1().Endpoints("telepresence").Get(ctx, "jane-doe", meta.GetOptions{})
+	assert.Error(t, err)
+	_, err = cs.CoreV1().Services("telepresence").Get(ctx, "john", meta.GetOptions{})
+	assert.NoError(t, err)
+
+	// The name is free for another session once the session that used it has ended
+	e.Lock()
+	_, taken := e.exposed["s1"]
+	e.Unlock()
+	require.False(t, taken)
+	sessions.Lock()
+	sessions.done["s4"] = make(chan struct{})
+	sessions.Unlock()
+	name, err = e.Expose(ctx, "s4", "Jane.Doe@example.com", []*rpc.ExposedPort{{Port: 80, LocalPort: localPort}})
+	require.NoError(t, err)
+	assert.Equal(t, "jane-doe.telepresence", name)
+}
+
+func TestExposer_nil(t *testing.T) {
+	e, err := NewExposer(dlog.NewTestContext(t, false), "", "", &fakeSessions{})
+	require.NoError(t, err)
+	assert.Nil(t, e)
+	name, err := e.Expose(context.Background(), "s1", "jane", []*rpc.ExposedPort{{Port: 80, LocalPort: 8080}})
+	assert.NoError(t, err)
+	assert.Empty(t, name)
+}
+
+func TestDNSLabel(t *testing.T) {
+	tests := map[string]string{
+		"jane":                             "jane",
+		"jane@laptop":                      "jane",
+		"Jane.Doe@example.com":             "jane-doe",
+		"system:serviceaccount:dev:ci-bot": "ci-bot",
+		"42nd_user":                        "nd-user",
+		"__":                               "",
+		"a-very-long-user-name-that-exceeds-the-length-of-a-dns-label-by-far": "a-very-long-user-name-that-exceeds-the-length-of-a-dns-label-by",
+	}
+	for user, want := range tests {
+		assert.Equal(t, want, DNSLabel(user), user)
+	}
+}
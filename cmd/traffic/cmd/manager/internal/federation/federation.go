@@ -0,0 +1,244 @@
+// Package federation lets a traffic-manager forward the intercepts and workload listings of its clients to the
+// traffic-managers of peered clusters, and extend the tunnels of the traffic that those intercepts receive to the
+// clients. A client thus intercepts workloads in several clusters using one session. Only the intercepted traffic
+// is routed between the clusters; the outbound traffic and the DNS lookups of the clients reach the connected
+// cluster only.
+package federation
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/watchable"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+// Sessions are the client sessions of the local traffic-manager.
+type Sessions interface {
+	// SessionDone returns a channel that is closed when the session with the given ID ends.
+	SessionDone(id string) (<-chan struct{}, error)
+
+	// ConnectClient extends the given stream to the client of the session with the given ID.
+	ConnectClient(ctx context.Context, sessionID string, stream tunnel.Stream) (tunnel.Endpoint, error)
+}
+
+// Federation is the set of peered traffic-managers, and the intercepts that the local clients have in their clusters.
+type Federation struct {
+	ctx      context.Context
+	sessions Sessions
+	peers    map[string]*peer
+
+	// intercepts are the intercepts in the peered clusters, as seen by the local clients. They have the same IDs
+	// and client sessions as local intercepts, and their specs name the cluster.
+	intercepts watchable.Map[*rpc.InterceptInfo]
+}
+
+// NewFederation returns a Federation of the given peers. A nil Federation, which has no peers, is returned when
+// no peers are given. The connections to the peers use mutual TLS with the given config, see LoadTLSConfig, and
+// the given options.
+func NewFederation(
+	ctx context.Context,
+	peers managerutil.FederationPeers,
+	sessions Sessions,
+	tlsConfig *tls.Config,
+	opts ...grpc.DialOption,
+) (*Federation, error) {
+	if len(peers) == 0 {
+		return nil, nil
+	}
+	if tlsConfig == nil {
+		return nil, errors.New("the traffic-managers of a federation must authenticate each other, but no federation TLS config is given")
+	}
+	f := &Federation{ctx: ctx, sessions: sessions, peers: make(map[string]*peer, len(peers))}
+	for _, p := range peers {
+		tc := tlsConfig.Clone()
+		tc.ServerName = p.ServerName
+		conn, err := grpc.DialContext(ctx, p.Address, append([]grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(tc))}, opts...)...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to connect to the traffic-manager of peered cluster %s at %s: %w", p.Name, p.Address, err)
+		}
+		go func() {
+			<-ctx.Done()
+			_ = conn.Close()
+		}()
+		f.peers[p.Name] = &peer{
+			fed:      f,
+			name:     p.Name,
+			client:   rpc.NewManagerClient(conn),
+			sessions: make(map[string]*peerSession),
+		}
+		dlog.Infof(ctx, "Peered with the traffic-manager of cluster %s at %s", p.Name, p.Address)
+	}
+	return f, nil
+}
+
+// getPeer returns the peer with the given name, or a user error when there's no such peer.
+func (f *Federation) getPeer(name string) (*peer, error) {
+	if f == nil {
+		return nil, errcat.User.Newf("unable to use cluster %q: the traffic-manager has no peered clusters", name)
+	}
+	p, ok := f.peers[name]
+	if !ok {
+		names := make([]string, 0, len(f.peers))
+		for n := range f.peers {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, errcat.User.Newf("unable to use cluster %q: the peered clusters are %s", name, strings.Join(names, ", "))
+	}
+	return p, nil
+}
+
+// PrepareIntercept forwards the given request to the peer that its spec names.
+func (f *Federation) PrepareIntercept(ctx context.Context, client *rpc.ClientInfo, cr *rpc.CreateInterceptRequest) (*rpc.PreparedIntercept, error) {
+	ps, err := f.session(ctx, cr.InterceptSpec.Cluster, cr.GetSession().GetSessionId(), client)
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		return &rpc.PreparedIntercept{Error: err.Error(), ErrorCategory: int32(errcat.GetCategory(err))}, nil
+	}
+	return ps.peer.client.PrepareIntercept(ctx, ps.forward(cr))
+}
+
+// CreateIntercept forwards the given request to the peer that its spec names, and returns the intercept as seen
+// by the local client.
+func (f *Federation) CreateIntercept(ctx context.Context, client *rpc.ClientInfo, cr *rpc.CreateInterceptRequest) (*rpc.InterceptInfo, error) {
+	ps, err := f.session(ctx, cr.InterceptSpec.Cluster, cr.GetSession().GetSessionId(), client)
+	if err != nil {
+		return nil, asStatus(err)
+	}
+	ii, err := ps.peer.client.CreateIntercept(ctx, ps.forward(cr))
+	if err != nil {
+		return nil, err
+	}
+	ii = ps.mirror(ii)
+	f.intercepts.Store(ii.Id, ii)
+	return ii, nil
+}
+
+// RemoveIntercept removes the intercept with the given ID from the cluster that it was created in. It returns false
+// when the ID isn't the ID of an intercept in a peered cluster.
+func (f *Federation) RemoveIntercept(ctx context.Context, id string) (bool, error) {
+	ii, ok := f.GetIntercept(id)
+	if !ok {
+		return false, nil
+	}
+	ps, err := f.existingSession(ii)
+	if err != nil {
+		return true, err
+	}
+	f.intercepts.Delete(id)
+	_, err = ps.peer.client.RemoveIntercept(ctx, &rpc.RemoveInterceptRequest2{Session: ps.remote, Name: ii.Spec.Name})
+	return true, err
+}
+
+// GetIntercept returns the intercept in a peered cluster that has the given ID.
+func (f *Federation) GetIntercept(id string) (*rpc.InterceptInfo, bool) {
+	if f == nil {
+		return nil, false
+	}
+	return f.intercepts.Load(id)
+}
+
+// GetInterceptDiagnostics returns the diagnostics of the intercept in a peered cluster that has the given ID. It
+// returns false when there's no such intercept.
+func (f *Federation) GetInterceptDiagnostics(ctx context.Context, id string) (*rpc.InterceptDiagnostics, bool, error) {
+	ii, ok := f.GetIntercept(id)
+	if !ok {
+		return nil, false, nil
+	}
+	ps, err := f.existingSession(ii)
+	if err != nil {
+		return nil, true, err
+	}
+	d, err := ps.peer.client.GetInterceptDiagnostics(ctx, &rpc.GetInterceptRequest{Session: ps.remote, Name: ii.Spec.Name})
+	return d, true, err
+}
+
+// WatchIntercepts returns a channel of snapshots of the intercepts that the client of the given session has in the
+// peered clusters. A nil channel is returned when there are no peers.
+func (f *Federation) WatchIntercepts(ctx context.Context, sessionID string) <-chan watchable.Snapshot[*rpc.InterceptInfo] {
+	if f == nil {
+		return nil
+	}
+	return f.intercepts.SubscribeSubset(ctx, func(_ string, ii *rpc.InterceptInfo) bool {
+		return ii.ClientSession.GetSessionId() == sessionID
+	})
+}
+
+// ListWorkloads forwards the given request to the peer that it names.
+func (f *Federation) ListWorkloads(ctx context.Context, client *rpc.ClientInfo, req *rpc.ListWorkloadsRequest) (*rpc.WorkloadInfoList, error) {
+	ps, err := f.session(ctx, req.Cluster, req.GetSession().GetSessionId(), client)
+	if err != nil {
+		return nil, asStatus(err)
+	}
+	return ps.peer.client.ListWorkloads(ctx, &rpc.ListWorkloadsRequest{Session: ps.remote, Namespace: req.Namespace})
+}
+
+// session returns the session that the named peer has for the client of the given local session, and creates it
+// when it doesn't exist.
+func (f *Federation) session(ctx context.Context, cluster, sessionID string, client *rpc.ClientInfo) (*peerSession, error) {
+	p, err := f.getPeer(cluster)
+	if err != nil {
+		return nil, err
+	}
+	return p.session(ctx, sessionID, client)
+}
+
+// existingSession returns the session of the given intercept in a peered cluster.
+func (f *Federation) existingSession(ii *rpc.InterceptInfo) (*peerSession, error) {
+	p, err := f.getPeer(ii.Spec.Cluster)
+	if err != nil {
+		return nil, err
+	}
+	p.Lock()
+	ps, ok := p.sessions[ii.ClientSession.GetSessionId()]
+	p.Unlock()
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "the session of intercept %q in cluster %s has ended", ii.Spec.Name, p.name)
+	}
+	return ps, nil
+}
+
+// mirrored returns the intercepts that the given peer session has in the federation.
+func (f *Federation) mirrored(ps *peerSession) map[string]*rpc.InterceptInfo {
+	return f.intercepts.LoadAllMatching(func(_ string, ii *rpc.InterceptInfo) bool {
+		return ii.ClientSession.GetSessionId() == ps.localID && ii.Spec.Cluster == ps.peer.name
+	})
+}
+
+func asStatus(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if errcat.GetCategory(err) == errcat.User {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return status.Error(codes.Unavailable, err.Error())
+}
+
+// peer is the traffic-manager of a peered cluster.
+type peer struct {
+	sync.Mutex
+	fed    *Federation
+	name   string
+	client rpc.ManagerClient
+
+	// sessions are the sessions that the peer has for the local clients, keyed by their local session IDs.
+	sessions map[string]*peerSession
+}
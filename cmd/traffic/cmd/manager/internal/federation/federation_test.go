@@ -0,0 +1,351 @@
+package federation
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+// fakePeer is the traffic-manager of a peered cluster. It knows one session.
+type fakePeer struct {
+	rpc.UnimplementedManagerServer
+	sync.Mutex
+	verified []bool
+	arrived  []*rpc.ClientInfo
+	departed []string
+	created  []*rpc.InterceptSpec
+	apiKeys  []string
+	removed  []string
+	snapshot chan *rpc.InterceptInfoSnapshot
+}
+
+const remoteSessionID = "remote-1"
+
+func (p *fakePeer) ArriveAsClient(ctx context.Context, ci *rpc.ClientInfo) (*rpc.SessionInfo, error) {
+	p.Lock()
+	p.verified = append(p.verified, VerifiedPeer(ctx))
+	p.arrived = append(p.arrived, ci)
+	p.Unlock()
+	return &rpc.SessionInfo{SessionId: remoteSessionID}, nil
+}
+
+func (p *fakePeer) Remain(context.Context, *rpc.RemainRequest) (*empty.Empty, error) {
+	return &empty.Empty{}, nil
+}
+
+func (p *fakePeer) Depart(_ context.Context, si *rpc.SessionInfo) (*empty.Empty, error) {
+	p.Lock()
+	p.departed = append(p.departed, si.SessionId)
+	p.Unlock()
+	return &empty.Empty{}, nil
+}
+
+func (p *fakePeer) CreateIntercept(_ context.Context, cr *rpc.CreateInterceptRequest) (*rpc.InterceptInfo, error) {
+	if cr.Session.SessionId != remoteSessionID {
+		return nil, status.Error(codes.NotFound, "no such session")
+	}
+	p.Lock()
+	p.created = append(p.created, cr.InterceptSpec)
+	p.apiKeys = append(p.apiKeys, cr.ApiKey)
+	p.Unlock()
+	return &rpc.InterceptInfo{
+		Id:            remoteSessionID + ":" + cr.InterceptSpec.Name,
+		Spec:          cr.InterceptSpec,
+		ClientSession: cr.Session,
+		Disposition:   rpc.InterceptDispositionType_WAITING,
+		SftpPort:      2222,
+	}, nil
+}
+
+func (p *fakePeer) RemoveIntercept(_ context.Context, rr *rpc.RemoveInterceptRequest2) (*empty.Empty, error) {
+	p.Lock()
+	p.removed = append(p.removed, rr.Name)
+	p.Unlock()
+	return &empty.Empty{}, nil
+}
+
+func (p *fakePeer) WatchIntercepts(_ *rpc.SessionInfo, stream rpc.Manager_WatchInterceptsServer) error {
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case s := <-p.snapshot:
+			if err := stream.Send(s); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (p *fakePeer) WatchDial(_ *rpc.SessionInfo, stream rpc.Manager_WatchDialServer) error {
+	<-stream.Context().Done()
+	return nil
+}
+
+func (p *fakePeer) ListWorkloads(_ context.Context, req *rpc.ListWorkloadsRequest) (*rpc.WorkloadInfoList, error) {
+	return &rpc.WorkloadInfoList{Workloads: []*rpc.WorkloadInfo{{Name: "echo", Namespace: req.Namespace, Kind: "Deployment"}}}, nil
+}
+
+// fakeSessions has one client session, which ends when done is closed.
+type fakeSessions struct {
+	done chan struct{}
+}
+
+func (s *fakeSessions) SessionDone(id string) (<-chan struct{}, error) {
+	if id != "local-1" {
+		return nil, status.Errorf(codes.NotFound, "session %q not found", id)
+	}
+	return s.done, nil
+}
+
+func (s *fakeSessions) ConnectClient(context.Context, string, tunnel.Stream) (tunnel.Endpoint, error) {
+	return nil, status.Error(codes.Unimplemented, "not used")
+}
+
+func newTestFederation(t *testing.T) (context.Context, *Federation, *fakePeer, *fakeSessions) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	t.Cleanup(cancel)
+
+	dir := writeTLSDir(t, t.TempDir(), "east", "west")
+	tc, err := LoadTLSConfig(filepath.Join(dir, "east"))
+	require.NoError(t, err)
+	pc, err := LoadTLSConfig(filepath.Join(dir, "west"))
+	require.NoError(t, err)
+
+	lis := bufconn.Listen(64 * 1024)
+	srv := grpc.NewServer(grpc.Creds(credentials.NewTLS(pc)))
+	p := &fakePeer{snapshot: make(chan *rpc.InterceptInfoSnapshot, 1)}
+	rpc.RegisterManagerServer(srv, p)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	ss := &fakeSessions{done: make(chan struct{})}
+	f, err := NewFederation(ctx, managerutil.FederationPeers{{Name: "west", Address: "bufnet:8083", ServerName: "west"}}, ss, tc,
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }))
+	require.NoError(t, err)
+	return ctx, f, p, ss
+}
+
+// writeTLSDir writes a CA, and a TLS directory with a certificate that the CA issued, for each of the given names.
+func writeTLSDir(t *testing.T, dir string, names ...string) string {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "federation-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	for i, name := range names {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(int64(i + 2)),
+			Subject:      pkix.Name{CommonName: name},
+			DNSNames:     []string{name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		}, caTmpl, &key.PublicKey, caKey)
+		require.NoError(t, err)
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		require.NoError(t, err)
+		nd := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(nd, 0o700))
+		require.NoError(t, os.WriteFile(filepath.Join(nd, certFile), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+		require.NoError(t, os.WriteFile(filepath.Join(nd, keyFile), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+		require.NoError(t, os.WriteFile(filepath.Join(nd, caFile), caPEM, 0o600))
+	}
+	return dir
+}
+
+func TestNewFederation_requiresTLS(t *testing.T) {
+	_, err := NewFederation(dlog.NewTestContext(t, false), managerutil.FederationPeers{{Name: "west", Address: "west:8083"}}, &fakeSessions{}, nil)
+	assert.Error(t, err)
+}
+
+func TestFederation_untrustedPeer(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	tc, err := LoadTLSConfig(filepath.Join(writeTLSDir(t, t.TempDir(), "east"), "east"))
+	require.NoError(t, err)
+	pc, err := LoadTLSConfig(filepath.Join(writeTLSDir(t, t.TempDir(), "west"), "west"))
+	require.NoError(t, err)
+
+	lis := bufconn.Listen(64 * 1024)
+	srv := grpc.NewServer(grpc.Creds(credentials.NewTLS(pc)))
+	p := &fakePeer{}
+	rpc.RegisterManagerServer(srv, p)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	// The peer's certificate is issued by another CA, so the peers don't trust each other
+	ss := &fakeSessions{done: make(chan struct{})}
+	f, err := NewFederation(ctx, managerutil.FederationPeers{{Name: "west", Address: "bufnet:8083", ServerName: "west"}}, ss, tc,
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }))
+	require.NoError(t, err)
+	_, err = f.ListWorkloads(ctx, &rpc.ClientInfo{Name: "alice@laptop"}, &rpc.ListWorkloadsRequest{
+		Session: &rpc.SessionInfo{SessionId: "local-1"},
+		Cluster: "west",
+	})
+	assert.Error(t, err)
+	p.Lock()
+	assert.Empty(t, p.arrived)
+	p.Unlock()
+}
+
+func TestLoadTLSConfig(t *testing.T) {
+	dir := writeTLSDir(t, t.TempDir(), "east")
+	tc, err := LoadTLSConfig(filepath.Join(dir, "east"))
+	require.NoError(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, tc.ClientAuth)
+	assert.Len(t, tc.Certificates, 1)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "east", caFile), []byte("garbage"), 0o600))
+	_, err = LoadTLSConfig(filepath.Join(dir, "east"))
+	assert.Error(t, err)
+	_, err = LoadTLSConfig(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+func TestNewFederation_noPeers(t *testing.T) {
+	f, err := NewFederation(dlog.NewTestContext(t, false), nil, &fakeSessions{}, nil)
+	require.NoError(t, err)
+	assert.Nil(t, f)
+
+	// A nil Federation has no intercepts, and rejects requests for peered clusters
+	_, ok := f.GetIntercept("local-1:echo")
+	assert.False(t, ok)
+	_, err = f.getPeer("west")
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+}
+
+func TestFederation_intercept(t *testing.T) {
+	ctx, f, p, ss := newTestFederation(t)
+	client := &rpc.ClientInfo{
+		Name:          "alice@laptop",
+		IdentityToken: "secret",
+		ResumeToken:   "resume",
+		ApiKey:        "key",
+		Identity:      &rpc.ClientIdentity{User: "alice"},
+	}
+	session := &rpc.SessionInfo{SessionId: "local-1"}
+
+	pi, err := f.PrepareIntercept(ctx, client, &rpc.CreateInterceptRequest{
+		Session:       session,
+		InterceptSpec: &rpc.InterceptSpec{Name: "echo", Agent: "echo", Namespace: "default", Cluster: "east"},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, pi.Error, "the peered clusters are west")
+	assert.Equal(t, int32(errcat.User), pi.ErrorCategory)
+
+	ii, err := f.CreateIntercept(ctx, client, &rpc.CreateInterceptRequest{
+		Session:       session,
+		InterceptSpec: &rpc.InterceptSpec{Name: "echo", Agent: "echo", Namespace: "default", Cluster: "west"},
+		ApiKey:        "key",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "local-1:echo", ii.Id)
+	assert.Equal(t, "local-1", ii.ClientSession.SessionId)
+	assert.Equal(t, "west", ii.Spec.Cluster)
+	assert.Zero(t, ii.SftpPort, "the sftp server of the agent in a peered cluster isn't reachable")
+
+	p.Lock()
+	require.Len(t, p.arrived, 1)
+	assert.True(t, p.verified[0], "the peer didn't authenticate the traffic-manager")
+	assert.Empty(t, p.arrived[0].IdentityToken)
+	assert.Empty(t, p.arrived[0].ResumeToken)
+	assert.Empty(t, p.arrived[0].ApiKey)
+	assert.Equal(t, "alice", p.arrived[0].Identity.GetUser(), "the verified identity wasn't passed on")
+	require.Len(t, p.created, 1)
+	assert.Empty(t, p.created[0].Cluster, "the peer got a spec for a peered cluster")
+	assert.Equal(t, []string{""}, p.apiKeys, "the API key of the client was passed on")
+	p.Unlock()
+
+	// The intercept is watched, and follows the disposition that the peer reports
+	watchCtx, cancelWatch := context.WithCancel(ctx)
+	defer cancelWatch()
+	snapshots := f.WatchIntercepts(watchCtx, "local-1")
+	s := <-snapshots
+	require.Contains(t, s.State, "local-1:echo")
+
+	active := &rpc.InterceptInfo{
+		Id:            remoteSessionID + ":echo",
+		Spec:          &rpc.InterceptSpec{Name: "echo", Agent: "echo", Namespace: "default"},
+		ClientSession: &rpc.SessionInfo{SessionId: remoteSessionID},
+		Disposition:   rpc.InterceptDispositionType_ACTIVE,
+	}
+	p.snapshot <- &rpc.InterceptInfoSnapshot{Intercepts: []*rpc.InterceptInfo{active}}
+	assert.Eventually(t, func() bool {
+		ii, ok := f.GetIntercept("local-1:echo")
+		return ok && ii.Disposition == rpc.InterceptDispositionType_ACTIVE
+	}, 5*time.Second, 10*time.Millisecond)
+
+	wls, err := f.ListWorkloads(ctx, client, &rpc.ListWorkloadsRequest{Session: session, Namespace: "default", Cluster: "west"})
+	require.NoError(t, err)
+	require.Len(t, wls.Workloads, 1)
+	p.Lock()
+	assert.Len(t, p.arrived, 1, "the session of the client wasn't reused")
+	p.Unlock()
+
+	ok, err := f.RemoveIntercept(ctx, "local-1:echo")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	_, ok = f.GetIntercept("local-1:echo")
+	assert.False(t, ok)
+	p.Lock()
+	assert.Equal(t, []string{"echo"}, p.removed)
+	p.Unlock()
+
+	ok, err = f.RemoveIntercept(ctx, "local-1:other")
+	require.NoError(t, err)
+	assert.False(t, ok, "a local intercept was removed from the peer")
+
+	// The session in the peered cluster ends with the local session
+	p.snapshot <- &rpc.InterceptInfoSnapshot{Intercepts: []*rpc.InterceptInfo{active}}
+	assert.Eventually(t, func() bool {
+		_, ok := f.GetIntercept("local-1:echo")
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+	close(ss.done)
+	assert.Eventually(t, func() bool {
+		p.Lock()
+		defer p.Unlock()
+		return len(p.departed) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	_, ok = f.GetIntercept("local-1:echo")
+	assert.False(t, ok)
+}
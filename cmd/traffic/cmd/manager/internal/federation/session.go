@@ -0,0 +1,215 @@
+package federation
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/datawire/dlib/dcontext"
+	"github.com/datawire/dlib/dlog"
+	"github.com/datawire/dlib/dtime"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+// remainInterval is the interval at which a session in a peered cluster is kept alive. It's the interval that
+// the clients use.
+const remainInterval = 5 * time.Second
+
+// peerSession is the session that a peer has for a local client. It lives as long as the session of the client.
+type peerSession struct {
+	peer    *peer
+	localID string
+	remote  *rpc.SessionInfo
+}
+
+// session returns the session that the peer has for the client of the given local session, and creates it when it
+// doesn't exist. A created session ends when the local session ends.
+func (p *peer) session(ctx context.Context, sessionID string, client *rpc.ClientInfo) (*peerSession, error) {
+	p.Lock()
+	defer p.Unlock()
+	if ps, ok := p.sessions[sessionID]; ok {
+		return ps, nil
+	}
+	done, err := p.fed.sessions.SessionDone(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The secrets of the client are never passed on. The peer instead trusts the identity that this traffic-manager
+	// has verified, because this traffic-manager authenticates itself with a certificate issued by the federation CA.
+	// The peer's session can't be resumed by the client, because the client never talks to the peer.
+	ci := proto.Clone(client).(*rpc.ClientInfo)
+	ci.IdentityToken = ""
+	ci.ResumeToken = ""
+	ci.ApiKey = ""
+	remote, err := p.client.ArriveAsClient(ctx, ci)
+	if err != nil {
+		return nil, status.Errorf(status.Code(err), "unable to create a session in cluster %s: %v", p.name, status.Convert(err).Message())
+	}
+	ps := &peerSession{peer: p, localID: sessionID, remote: remote}
+	p.sessions[sessionID] = ps
+	dlog.Debugf(ctx, "Session %s in cluster %s created for session %s", remote.SessionId, p.name, sessionID)
+
+	sctx, cancel := context.WithCancel(p.fed.ctx)
+	go func() {
+		ps.remain(sctx, done)
+		cancel()
+		ps.end(sctx)
+	}()
+	go ps.watchIntercepts(sctx)
+	go ps.watchDials(sctx)
+	return ps, nil
+}
+
+// forward returns a copy of the given request for the peer. The API key of the client isn't passed on.
+func (ps *peerSession) forward(cr *rpc.CreateInterceptRequest) *rpc.CreateInterceptRequest {
+	spec := proto.Clone(cr.InterceptSpec).(*rpc.InterceptSpec)
+	spec.Cluster = ""
+	return &rpc.CreateInterceptRequest{Session: ps.remote, InterceptSpec: spec}
+}
+
+// mirror returns a copy of the given intercept that the peer reported, as seen by the local client. The sftp server
+// of the traffic-agent isn't reachable for the client, so the copy has no sftp port.
+func (ps *peerSession) mirror(ii *rpc.InterceptInfo) *rpc.InterceptInfo {
+	ii = proto.Clone(ii).(*rpc.InterceptInfo)
+	ii.Id = ps.localID + ":" + ii.Spec.Name
+	ii.Spec.Cluster = ps.peer.name
+	if ii.ClientSession == nil {
+		ii.ClientSession = &rpc.SessionInfo{}
+	}
+	ii.ClientSession.SessionId = ps.localID
+	ii.SftpPort = 0
+	return ii
+}
+
+// remain keeps the session alive until the given done channel is closed, or the peer no longer knows the session.
+func (ps *peerSession) remain(ctx context.Context, done <-chan struct{}) {
+	p := ps.peer
+	ticker := time.NewTicker(remainInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := p.client.Remain(ctx, &rpc.RemainRequest{Session: ps.remote}); err != nil {
+				if status.Code(err) == codes.NotFound {
+					dlog.Errorf(ctx, "session %s in cluster %s has expired", ps.remote.SessionId, p.name)
+					return
+				}
+				dlog.Errorf(ctx, "failed to keep session %s in cluster %s alive: %v", ps.remote.SessionId, p.name, err)
+			}
+		}
+	}
+}
+
+// end removes the session and its intercepts from the federation, and departs from the peer.
+func (ps *peerSession) end(ctx context.Context) {
+	p := ps.peer
+	p.Lock()
+	delete(p.sessions, ps.localID)
+	p.Unlock()
+	for id := range p.fed.mirrored(ps) {
+		p.fed.intercepts.Delete(id)
+	}
+	ctx, cancel := context.WithTimeout(dcontext.WithoutCancel(ctx), 3*time.Second)
+	defer cancel()
+	if _, err := p.client.Depart(ctx, ps.remote); err != nil {
+		dlog.Errorf(ctx, "failed to depart from cluster %s: %v", p.name, err)
+	}
+}
+
+// update replaces the intercepts of the session in the federation with the given intercepts that the peer reported.
+// It does nothing when the session has ended.
+func (ps *peerSession) update(iis []*rpc.InterceptInfo) {
+	p := ps.peer
+	p.Lock()
+	defer p.Unlock()
+	if p.sessions[ps.localID] != ps {
+		return
+	}
+	old := p.fed.mirrored(ps)
+	for _, ii := range iis {
+		ii = ps.mirror(ii)
+		if oi, ok := old[ii.Id]; !ok || !proto.Equal(oi, ii) {
+			p.fed.intercepts.Store(ii.Id, ii)
+		}
+		delete(old, ii.Id)
+	}
+	for id := range old {
+		p.fed.intercepts.Delete(id)
+	}
+}
+
+// watchIntercepts keeps the intercepts of the session in the federation up-to-date with the intercepts that the peer
+// reports.
+func (ps *peerSession) watchIntercepts(ctx context.Context) {
+	p := ps.peer
+	for ctx.Err() == nil {
+		stream, err := p.client.WatchIntercepts(ctx, ps.remote)
+		if err == nil {
+			var snapshot *rpc.InterceptInfoSnapshot
+			for {
+				if snapshot, err = stream.Recv(); err != nil {
+					break
+				}
+				ps.update(snapshot.Intercepts)
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		dlog.Errorf(ctx, "failed to watch the intercepts of session %s in cluster %s: %v", ps.remote.SessionId, p.name, err)
+		dtime.SleepWithContext(ctx, remainInterval)
+	}
+}
+
+// watchDials extends the tunnels that the peer dials for the session to the local client.
+func (ps *peerSession) watchDials(ctx context.Context) {
+	p := ps.peer
+	for ctx.Err() == nil {
+		stream, err := p.client.WatchDial(ctx, ps.remote)
+		if err == nil {
+			var dr *rpc.DialRequest
+			for {
+				if dr, err = stream.Recv(); err != nil {
+					break
+				}
+				go ps.connect(ctx, dr)
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		dlog.Errorf(ctx, "failed to watch the dial requests of session %s in cluster %s: %v", ps.remote.SessionId, p.name, err)
+		dtime.SleepWithContext(ctx, remainInterval)
+	}
+}
+
+// connect responds to the given dial request with a tunnel to the peer, and extends that tunnel to the local client.
+func (ps *peerSession) connect(ctx context.Context, dr *rpc.DialRequest) {
+	id := tunnel.ConnID(dr.ConnId)
+	mt, err := ps.peer.client.Tunnel(ctx)
+	if err != nil {
+		dlog.Errorf(ctx, "!! CONN %s, call to Tunnel of cluster %s failed: %v", id, ps.peer.name, err)
+		return
+	}
+	s, err := tunnel.NewClientStream(ctx, mt, id, ps.remote.SessionId, time.Duration(dr.RoundtripLatency), time.Duration(dr.DialTimeout), tunnel.NoCompression)
+	if err != nil {
+		dlog.Error(ctx, err)
+		return
+	}
+	ep, err := ps.peer.fed.sessions.ConnectClient(ctx, ps.localID, s)
+	if err != nil {
+		dlog.Errorf(ctx, "!! CONN %s, unable to extend the tunnel from cluster %s to the client: %v", id, ps.peer.name, err)
+		_ = s.CloseSend(ctx)
+		return
+	}
+	<-ep.Done()
+}
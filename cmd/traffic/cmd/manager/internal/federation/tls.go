@@ -0,0 +1,57 @@
+package federation
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"google.golang.org/grpc/credentials"
+	grpcPeer "google.golang.org/grpc/peer"
+)
+
+// The files of the TLS directory of a federation, as found in a Kubernetes TLS secret with a CA.
+const (
+	certFile = "tls.crt"
+	keyFile  = "tls.key"
+	caFile   = "ca.crt"
+)
+
+// LoadTLSConfig returns the TLS config that the traffic-managers of a federation use to authenticate each other. It's
+// loaded from the given directory, which has the certificate and key of this traffic-manager, and the certificate of
+// the CA that issued the certificates of all traffic-managers in the federation. The config is used both when
+// dialing the peers, and when serving them, and requires that the other side presents a certificate issued by the CA.
+func LoadTLSConfig(dir string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, certFile), filepath.Join(dir, keyFile))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the federation certificate: %w", err)
+	}
+	caPEM, err := os.ReadFile(filepath.Join(dir, caFile))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the federation CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in the federation CA file %s", filepath.Join(dir, caFile))
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// VerifiedPeer returns true when the gRPC call of the given context was made by a peered traffic-manager, i.e.
+// over a TLS connection on which the caller presented a certificate that was verified using the federation CA.
+func VerifiedPeer(ctx context.Context) bool {
+	p, ok := grpcPeer.FromContext(ctx)
+	if !ok {
+		return false
+	}
+	ti, ok := p.AuthInfo.(credentials.TLSInfo)
+	return ok && len(ti.State.VerifiedChains) > 0
+}
@@ -0,0 +1,48 @@
+package identity
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	authzv1 "k8s.io/api/authorization/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+// MayGetWorkload lets the API server decide, using a SubjectAccessReview, whether the given identity is allowed
+// to get the workload of the given intercept. An identity that isn't allowed results in a nil error and a false
+// result.
+func MayGetWorkload(ctx context.Context, id *rpc.ClientIdentity, spec *rpc.InterceptSpec) (bool, error) {
+	ra := &authzv1.ResourceAttributes{
+		Namespace: spec.Namespace,
+		Verb:      "get",
+		Name:      spec.Agent,
+	}
+	switch spec.WorkloadKind {
+	case "Deployment", "ReplicaSet", "StatefulSet":
+		ra.Group = "apps"
+		ra.Resource = strings.ToLower(spec.WorkloadKind) + "s"
+	case "Job", "CronJob":
+		ra.Group = "batch"
+		ra.Resource = strings.ToLower(spec.WorkloadKind) + "s"
+	default:
+		// Kinds that the traffic-manager doesn't know about are authorized using the pods that they own.
+		ra.Verb = "list"
+		ra.Resource = "pods"
+		ra.Name = ""
+	}
+	sar, err := k8sapi.GetK8sInterface(ctx).AuthorizationV1().SubjectAccessReviews().Create(ctx, &authzv1.SubjectAccessReview{
+		Spec: authzv1.SubjectAccessReviewSpec{
+			User:               id.User,
+			Groups:             id.Groups,
+			ResourceAttributes: ra,
+		},
+	}, meta.CreateOptions{})
+	if err != nil {
+		return false, fmt.Errorf("unable to review the access of %s: %w", id.User, err)
+	}
+	return sar.Status.Allowed, nil
+}
@@ -0,0 +1,44 @@
+package identity
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authzv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+func TestMayGetWorkload(t *testing.T) {
+	var reviewed *authzv1.ResourceAttributes
+	cs := fake.NewSimpleClientset()
+	cs.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		sar := action.(k8stesting.CreateAction).GetObject().(*authzv1.SubjectAccessReview)
+		reviewed = sar.Spec.ResourceAttributes
+		sar.Status.Allowed = sar.Spec.User == "alice@example.com" || len(sar.Spec.Groups) > 0 && sar.Spec.Groups[0] == "devs"
+		return true, sar, nil
+	})
+	ctx := k8sapi.WithK8sInterface(dlog.NewTestContext(t, false), cs)
+
+	spec := &rpc.InterceptSpec{Agent: "echo", Namespace: "dev", WorkloadKind: "StatefulSet"}
+	ok, err := MayGetWorkload(ctx, &rpc.ClientIdentity{User: "alice@example.com"}, spec)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, &authzv1.ResourceAttributes{Namespace: "dev", Verb: "get", Group: "apps", Resource: "statefulsets", Name: "echo"}, reviewed)
+
+	ok, err = MayGetWorkload(ctx, &rpc.ClientIdentity{User: "bob@example.com", Groups: []string{"devs"}}, spec)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	spec.WorkloadKind = "Rollout"
+	ok, err = MayGetWorkload(ctx, &rpc.ClientIdentity{User: "mallory@example.com"}, spec)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, &authzv1.ResourceAttributes{Namespace: "dev", Verb: "list", Resource: "pods"}, reviewed)
+}
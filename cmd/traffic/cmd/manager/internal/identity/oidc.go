@@ -0,0 +1,181 @@
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+const (
+	fetchTimeout = 10 * time.Second
+
+	// minRefetchInterval limits how often the keys of the issuer are fetched when a token is signed with an
+	// unknown key, so that clients can't make the traffic-manager hammer the issuer.
+	minRefetchInterval = time.Minute
+
+	// clockSkew is the leeway used when validating the time-based claims of a token.
+	clockSkew = time.Minute
+)
+
+// OIDCConfig configures the verification of OIDC ID tokens.
+type OIDCConfig struct {
+	// IssuerURL is the URL of the issuer. Its keys are found using OIDC discovery.
+	IssuerURL string
+
+	// ClientID is the audience that the tokens must be issued to.
+	ClientID string
+
+	// UsernameClaim and GroupsClaim are the claims that contain the user and the groups of the identity.
+	UsernameClaim string
+	GroupsClaim   string
+}
+
+type oidcVerifier struct {
+	OIDCConfig
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    *jose.JSONWebKeySet
+	fetched time.Time
+}
+
+func newOIDCVerifier(oc *OIDCConfig) (*oidcVerifier, error) {
+	if oc == nil || oc.IssuerURL == "" || oc.ClientID == "" {
+		return nil, errors.New("the issuer URL and the client ID must be set to verify OIDC ID tokens")
+	}
+	ov := &oidcVerifier{OIDCConfig: *oc, client: &http.Client{Timeout: fetchTimeout}}
+	if ov.UsernameClaim == "" {
+		ov.UsernameClaim = "sub"
+	}
+	return ov, nil
+}
+
+func (ov *oidcVerifier) verify(ctx context.Context, token string) (*rpc.ClientIdentity, error) {
+	jt, err := jwt.ParseSigned(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	kid := ""
+	if len(jt.Headers) > 0 {
+		kid = jt.Headers[0].KeyID
+	}
+	keys, err := ov.signingKeys(ctx, kid)
+	if err != nil {
+		return nil, err
+	}
+	var claims jwt.Claims
+	var extra map[string]any
+	for _, key := range keys {
+		if err = jt.Claims(key, &claims, &extra); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	err = claims.ValidateWithLeeway(jwt.Expected{
+		Issuer:   ov.IssuerURL,
+		Audience: jwt.Audience{ov.ClientID},
+		Time:     time.Now(),
+	}, clockSkew)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	user, _ := extra[ov.UsernameClaim].(string)
+	if user == "" {
+		return nil, fmt.Errorf("%w: the token has no %q claim", ErrInvalidToken, ov.UsernameClaim)
+	}
+	return &rpc.ClientIdentity{User: user, Groups: stringsClaim(extra[ov.GroupsClaim]), Method: MethodOIDC}, nil
+}
+
+// stringsClaim returns the strings of a claim that is either a string or an array of strings.
+func stringsClaim(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		ss := make([]string, 0, len(v))
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				ss = append(ss, s)
+			}
+		}
+		return ss
+	}
+	return nil
+}
+
+// signingKeys returns the keys of the issuer that may have signed a token with the given key ID. All keys are
+// returned when the key ID is empty. The keys are fetched again when none of them has the key ID, because the
+// issuer may have rotated them.
+func (ov *oidcVerifier) signingKeys(ctx context.Context, kid string) ([]jose.JSONWebKey, error) {
+	ov.mu.Lock()
+	defer ov.mu.Unlock()
+	if ov.keys == nil || kid != "" && len(ov.keys.Key(kid)) == 0 && time.Since(ov.fetched) > minRefetchInterval {
+		keys, err := ov.fetchKeys(ctx)
+		if err != nil {
+			if ov.keys == nil {
+				return nil, err
+			}
+		} else {
+			ov.keys = keys
+			ov.fetched = time.Now()
+		}
+	}
+	if kid == "" {
+		return ov.keys.Keys, nil
+	}
+	keys := ov.keys.Key(kid)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: the token is signed with an unknown key %q", ErrInvalidToken, kid)
+	}
+	return keys, nil
+}
+
+// fetchKeys uses OIDC discovery to fetch the keys of the issuer.
+func (ov *oidcVerifier) fetchKeys(ctx context.Context) (*jose.JSONWebKeySet, error) {
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := ov.getJSON(ctx, strings.TrimSuffix(ov.IssuerURL, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	if discovery.Issuer != ov.IssuerURL {
+		return nil, fmt.Errorf("the OIDC discovery of %s returned the issuer %q", ov.IssuerURL, discovery.Issuer)
+	}
+	var keys jose.JSONWebKeySet
+	if err := ov.getJSON(ctx, discovery.JWKSURI, &keys); err != nil {
+		return nil, err
+	}
+	return &keys, nil
+}
+
+func (ov *oidcVerifier) getJSON(ctx context.Context, url string, v any) error {
+	rq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := ov.client.Do(rq)
+	if err != nil {
+		return fmt.Errorf("unable to get %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to get %s: %s", url, resp.Status)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("unable to decode %s: %w", url, err)
+	}
+	return nil
+}
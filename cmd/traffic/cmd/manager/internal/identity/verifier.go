@@ -0,0 +1,99 @@
+// Package identity verifies the identities that clients present to the traffic-manager, so that its policies,
+// limits, and audit trail can use real identities rather than the user@hostname names that the clients report.
+package identity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	authv1 "k8s.io/api/authentication/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+// The methods that a Verifier uses to verify tokens.
+const (
+	// MethodKubernetes verifies a Kubernetes bearer token using a TokenReview.
+	MethodKubernetes = "kubernetes"
+
+	// MethodOIDC verifies an OIDC ID token using the keys of its issuer.
+	MethodOIDC = "oidc"
+)
+
+// ErrInvalidToken is returned when a token can't be verified, e.g. because it has expired or was issued to another
+// audience.
+var ErrInvalidToken = errors.New("invalid identity token")
+
+// Verifier verifies the tokens that clients present, and returns the identities that they establish.
+type Verifier struct {
+	method   string
+	audience string
+	oidc     *oidcVerifier
+}
+
+// NewVerifier returns a Verifier that uses the given method. The audience is only used by MethodKubernetes, and the
+// OIDC config is only used by MethodOIDC. A nil Verifier is returned when the method is empty, meaning that
+// identities aren't verified.
+func NewVerifier(method, audience string, oc *OIDCConfig) (*Verifier, error) {
+	switch method {
+	case "":
+		return nil, nil
+	case MethodKubernetes:
+		if audience == "" {
+			return nil, errors.New("the kubernetes client identity method requires an audience")
+		}
+		return &Verifier{method: method, audience: audience}, nil
+	case MethodOIDC:
+		ov, err := newOIDCVerifier(oc)
+		if err != nil {
+			return nil, err
+		}
+		return &Verifier{method: method, oidc: ov}, nil
+	default:
+		return nil, fmt.Errorf("invalid client identity method %q, must be %q or %q", method, MethodKubernetes, MethodOIDC)
+	}
+}
+
+// Verify verifies the given token and returns the identity that it establishes. The returned error wraps
+// ErrInvalidToken when the token itself is the problem.
+func (v *Verifier) Verify(ctx context.Context, token string) (*rpc.ClientIdentity, error) {
+	if v.method == MethodOIDC {
+		return v.oidc.verify(ctx, token)
+	}
+	return reviewToken(ctx, token, v.audience)
+}
+
+// reviewToken lets the API server verify the given token using a TokenReview. The token must be issued to the given
+// audience, so that a token that a client uses to access the API server, or another traffic-manager, isn't accepted.
+// Without that, anyone that obtains a token presented to the traffic-manager could use it to access the cluster as
+// its owner.
+func reviewToken(ctx context.Context, token, audience string) (*rpc.ClientIdentity, error) {
+	tr, err := k8sapi.GetK8sInterface(ctx).AuthenticationV1().TokenReviews().Create(ctx, &authv1.TokenReview{
+		Spec: authv1.TokenReviewSpec{Token: token, Audiences: []string{audience}},
+	}, meta.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to review the identity token: %w", err)
+	}
+	if !tr.Status.Authenticated {
+		if msg := tr.Status.Error; msg != "" {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidToken, msg)
+		}
+		return nil, ErrInvalidToken
+	}
+	// An authenticator that isn't audience aware may accept the token without returning the audience.
+	issuedTo := false
+	for _, aud := range tr.Status.Audiences {
+		if aud == audience {
+			issuedTo = true
+			break
+		}
+	}
+	if !issuedTo {
+		return nil, fmt.Errorf("%w: the token isn't issued to the audience %q", ErrInvalidToken, audience)
+	}
+	user := tr.Status.User
+	return &rpc.ClientIdentity{User: user.Username, Groups: user.Groups, Method: MethodKubernetes}, nil
+}
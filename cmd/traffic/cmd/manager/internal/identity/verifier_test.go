@@ -0,0 +1,128 @@
+package identity
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+	authv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+func TestVerifier_kubernetes(t *testing.T) {
+	cs := fake.NewSimpleClientset()
+	cs.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		tr := action.(k8stesting.CreateAction).GetObject().(*authv1.TokenReview)
+		assert.Equal(t, []string{"traffic-manager.ambassador"}, tr.Spec.Audiences)
+		user := authv1.UserInfo{Username: "alice@example.com", Groups: []string{"devs"}}
+		switch tr.Spec.Token {
+		case "good":
+			tr.Status.Authenticated = true
+			tr.Status.User = user
+			tr.Status.Audiences = tr.Spec.Audiences
+		case "audience-unaware":
+			// Authenticated by an authenticator that doesn't check the audiences of the tokens
+			tr.Status.Authenticated = true
+			tr.Status.User = user
+		case "api-server":
+			// A token that is issued to the API server doesn't intersect with the requested audiences
+			tr.Status.Error = "token audiences [\"https://kubernetes.default.svc\"] is invalid for the target audiences [\"traffic-manager.ambassador\"]"
+		default:
+			tr.Status.Error = "token expired"
+		}
+		return true, tr, nil
+	})
+	ctx := k8sapi.WithK8sInterface(dlog.NewTestContext(t, false), cs)
+
+	_, err := NewVerifier(MethodKubernetes, "", nil)
+	assert.Error(t, err)
+
+	v, err := NewVerifier(MethodKubernetes, "traffic-manager.ambassador", nil)
+	require.NoError(t, err)
+	id, err := v.Verify(ctx, "good")
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", id.User)
+	assert.Equal(t, []string{"devs"}, id.Groups)
+	assert.Equal(t, MethodKubernetes, id.Method)
+
+	_, err = v.Verify(ctx, "bad")
+	assert.True(t, errors.Is(err, ErrInvalidToken))
+	assert.Contains(t, err.Error(), "token expired")
+
+	_, err = v.Verify(ctx, "api-server")
+	assert.True(t, errors.Is(err, ErrInvalidToken))
+
+	_, err = v.Verify(ctx, "audience-unaware")
+	assert.True(t, errors.Is(err, ErrInvalidToken))
+	assert.Contains(t, err.Error(), `isn't issued to the audience "traffic-manager.ambassador"`)
+}
+
+func TestVerifier_oidc(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	jwk := jose.JSONWebKey{Key: &key.PublicKey, KeyID: "k1", Algorithm: string(jose.RS256), Use: "sig"}
+
+	var issuer string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"issuer": issuer, "jwks_uri": issuer + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{jwk}})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	issuer = srv.URL
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key},
+		(&jose.SignerOptions{}).WithHeader("kid", "k1"))
+	require.NoError(t, err)
+	sign := func(aud string, expiry time.Time) string {
+		tok, err := jwt.Signed(signer).Claims(jwt.Claims{
+			Issuer:   issuer,
+			Subject:  "1234",
+			Audience: jwt.Audience{aud},
+			Expiry:   jwt.NewNumericDate(expiry),
+		}).Claims(map[string]any{"email": "bob@example.com", "groups": []string{"ops", "devs"}}).CompactSerialize()
+		require.NoError(t, err)
+		return tok
+	}
+
+	v, err := NewVerifier(MethodOIDC, "", &OIDCConfig{IssuerURL: issuer, ClientID: "telepresence", UsernameClaim: "email", GroupsClaim: "groups"})
+	require.NoError(t, err)
+	ctx := dlog.NewTestContext(t, false)
+
+	id, err := v.Verify(ctx, sign("telepresence", time.Now().Add(time.Hour)))
+	require.NoError(t, err)
+	assert.Equal(t, "bob@example.com", id.User)
+	assert.Equal(t, []string{"ops", "devs"}, id.Groups)
+	assert.Equal(t, MethodOIDC, id.Method)
+
+	_, err = v.Verify(ctx, sign("other", time.Now().Add(time.Hour)))
+	assert.True(t, errors.Is(err, ErrInvalidToken))
+
+	_, err = v.Verify(ctx, sign("telepresence", time.Now().Add(-time.Hour)))
+	assert.True(t, errors.Is(err, ErrInvalidToken))
+
+	_, err = v.Verify(ctx, "not a token")
+	assert.True(t, errors.Is(err, ErrInvalidToken))
+
+	_, err = NewVerifier(MethodOIDC, "", &OIDCConfig{IssuerURL: issuer})
+	assert.Error(t, err)
+	_, err = NewVerifier("ldap", "", nil)
+	assert.Error(t, err)
+}
@@ -15,6 +15,7 @@ import (
 	admission "k8s.io/api/admission/v1"
 	core "k8s.io/api/core/v1"
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/utils/strings/slices"
 
@@ -152,8 +153,11 @@ func (a *agentInjector) inject(ctx context.Context, req *admission.AdmissionRequ
 	patches = addInitContainer(ctx, pod, config, patches)
 	patches = addAgentContainer(ctx, pod, config, patches)
 	patches = addAgentVolumes(pod, config, patches)
+	patches = addPullSecrets(pod, config, patches)
+	patches = addShareProcessNamespace(pod, config, patches)
+	patches = neutralizeResources(ctx, pod, config, patches)
 	patches = hidePorts(pod, config, patches)
-	patches = addPodAnnotations(ctx, pod, patches)
+	patches = addPodAnnotations(ctx, pod, config, patches)
 
 	if env.APIPort != 0 {
 		tpEnv := make(map[string]string)
@@ -161,6 +165,9 @@ func (a *agentInjector) inject(ctx context.Context, req *admission.AdmissionRequ
 		patches = addTPEnv(pod, config, tpEnv, patches)
 	}
 
+	// Must be last, because the removal changes the indexes of the containers that the other patches use
+	patches = removeReplacedContainers(ctx, pod, config, patches)
+
 	// Create patch operations to add the traffic-agent sidecar
 	if len(patches) > 0 {
 		dlog.Infof(ctx, "Injecting %d patches into pod %s.%s", len(patches), pod.Name, pod.Namespace)
@@ -194,7 +201,7 @@ func (a *agentInjector) upgradeLegacy(ctx context.Context) {
 func needInitContainer(config *agentconfig.Sidecar) bool {
 	for _, cc := range config.Containers {
 		for _, ic := range cc.Intercepts {
-			if ic.Headless || ic.TargetPortNumeric {
+			if ic.NeedsInitContainer() {
 				return true
 			}
 		}
@@ -216,7 +223,8 @@ func addInitContainer(ctx context.Context, pod *core.Pod, config *agentconfig.Si
 	}
 
 	pis := pod.Spec.InitContainers
-	ic := agentconfig.InitContainer(config.AgentImage)
+	env := managerutil.GetEnv(ctx)
+	ic := agentconfig.InitContainer(config.AgentImage, env != nil && env.AgentInitRunAsRoot && runsAsNonRoot(pod))
 	if len(pis) == 0 {
 		return append(patches, patchOperation{
 			Op:    "replace",
@@ -225,13 +233,34 @@ func addInitContainer(ctx context.Context, pod *core.Pod, config *agentconfig.Si
 		})
 	}
 
+	// The init container must run after the init container of a service mesh, so that the mesh's PREROUTING rules
+	// take precedence over ours. The mesh will then terminate mTLS and the agent receives plaintext.
+	meshInit := -1
+	if sm := findServiceMesh(pod); sm != nil {
+		meshInit = sm.initContainerIndex(pod)
+	}
+
 	for i := range pis {
 		oc := &pis[i]
 		if ic.Name == oc.Name {
+			if i < meshInit {
+				dlog.Debugf(ctx, "Moving %s after the service mesh init container in pod %s.%s", ic.Name, pod.Name, pod.Namespace)
+				return append(patches,
+					patchOperation{
+						Op:   "remove",
+						Path: fmt.Sprintf("/spec/initContainers/%d", i),
+					},
+					patchOperation{
+						Op:    "add",
+						Path:  fmt.Sprintf("/spec/initContainers/%d", meshInit),
+						Value: ic,
+					})
+			}
 			if ic.Image == oc.Image &&
 				slices.Equal(ic.Args, oc.Args) &&
 				compareVolumeMounts(ic.VolumeMounts, oc.VolumeMounts) &&
-				compareCapabilities(ic.SecurityContext, oc.SecurityContext) {
+				compareCapabilities(ic.SecurityContext, oc.SecurityContext) &&
+				compareRunAsUser(ic.SecurityContext, oc.SecurityContext) {
 				return patches
 			}
 			return append(patches, patchOperation{
@@ -255,7 +284,7 @@ func addAgentVolumes(pod *core.Pod, ag *agentconfig.Sidecar, patches patchOps) p
 			return patches
 		}
 	}
-	for _, av := range agentconfig.AgentVolumes(ag.AgentName) {
+	for _, av := range append(agentconfig.AgentVolumes(ag.AgentName), agentconfig.SidecarVolumes(ag)...) {
 		patches = append(patches,
 			patchOperation{
 				Op:    "add",
@@ -266,6 +295,130 @@ func addAgentVolumes(pod *core.Pod, ag *agentconfig.Sidecar, patches patchOps) p
 	return patches
 }
 
+// addPullSecrets adds the secrets used when pulling the traffic-agent image to the pod's imagePullSecrets.
+func addPullSecrets(pod *core.Pod, config *agentconfig.Sidecar, patches patchOps) patchOps {
+	if len(config.PullSecrets) == 0 {
+		return patches
+	}
+	var refs []core.LocalObjectReference
+	for _, ps := range config.PullSecrets {
+		found := false
+		for _, ref := range pod.Spec.ImagePullSecrets {
+			if ref.Name == ps {
+				found = true
+				break
+			}
+		}
+		if !found {
+			refs = append(refs, core.LocalObjectReference{Name: ps})
+		}
+	}
+	if len(refs) == 0 {
+		return patches
+	}
+	if len(pod.Spec.ImagePullSecrets) == 0 {
+		return append(patches, patchOperation{
+			Op:    "add",
+			Path:  "/spec/imagePullSecrets",
+			Value: refs,
+		})
+	}
+	for _, ref := range refs {
+		patches = append(patches, patchOperation{
+			Op:    "add",
+			Path:  "/spec/imagePullSecrets/-",
+			Value: ref,
+		})
+	}
+	return patches
+}
+
+// addShareProcessNamespace makes the containers of the pod share a process namespace when the traffic-agent
+// must terminate with the app, so that the traffic-agent can see when the app processes have exited. The pod
+// must opt in to it using the agentconfig.ShareProcessNamespaceAnnotation.
+func addShareProcessNamespace(pod *core.Pod, config *agentconfig.Sidecar, patches patchOps) patchOps {
+	if !config.TerminateWithApp {
+		return patches
+	}
+	if sp := pod.Spec.ShareProcessNamespace; sp != nil && *sp {
+		return patches
+	}
+	if !agentconfig.SharesProcessNamespace(pod.Annotations, &pod.Spec) {
+		return patches
+	}
+	return append(patches, patchOperation{
+		Op:    "add",
+		Path:  "/spec/shareProcessNamespace",
+		Value: true,
+	})
+}
+
+// neutralizeResources takes the resources of the traffic-agent from the app containers when the config has the
+// NeutralResourcePolicy, so that the total resources of the pod don't change. The request of each resource is
+// taken from the app container that requests the most of it, and so is the limit, provided that the container
+// still has a limit that is no less than its request. A resource that no app container has enough of is left as is.
+func neutralizeResources(ctx context.Context, pod *core.Pod, config *agentconfig.Sidecar, patches patchOps) patchOps {
+	if config.ResourcePolicy != agentconfig.NeutralResourcePolicy || config.Resources == nil {
+		return patches
+	}
+	cns := pod.Spec.Containers
+	for i := range cns {
+		if cns[i].Name == agentconfig.ContainerName {
+			// The resources were taken when the traffic-agent was injected
+			return patches
+		}
+	}
+	replaced := func(name string) bool {
+		for _, cc := range config.Containers {
+			if cc.Replace && cc.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+	escape := strings.NewReplacer("~", "~0", "/", "~1")
+	for name, q := range config.Resources.Requests {
+		ci := -1
+		var most resource.Quantity
+		for i := range cns {
+			if r, ok := cns[i].Resources.Requests[name]; ok && r.Cmp(most) > 0 && !replaced(cns[i].Name) {
+				ci, most = i, r
+			}
+		}
+		if ci < 0 {
+			continue
+		}
+		cn := &cns[ci]
+		rq := most.DeepCopy()
+		rq.Sub(q)
+		if rq.Sign() <= 0 {
+			dlog.Infof(ctx, "Container %s of pod %s.%s doesn't request enough %s to share with the %s",
+				cn.Name, pod.Name, pod.Namespace, name, agentconfig.ContainerName)
+			continue
+		}
+		path := "/spec/containers/" + strconv.Itoa(ci) + "/resources/"
+		patches = append(patches, patchOperation{
+			Op:    "replace",
+			Path:  path + "requests/" + escape.Replace(string(name)),
+			Value: rq.String(),
+		})
+		if l, ok := cn.Resources.Limits[name]; ok {
+			if al, ok := config.Resources.Limits[name]; ok {
+				lm := l.DeepCopy()
+				lm.Sub(al)
+				if lm.Cmp(rq) >= 0 {
+					patches = append(patches, patchOperation{
+						Op:    "replace",
+						Path:  path + "limits/" + escape.Replace(string(name)),
+						Value: lm.String(),
+					})
+				}
+			}
+		}
+	}
+	return patches
+}
+
 // compareProbes compares two Probes but will only consider their Handler.Exec.Command in the comparison
 func compareProbes(a, b *core.Probe) bool {
 	if a == nil || b == nil {
@@ -303,6 +456,27 @@ func compareCapabilities(a *core.SecurityContext, b *core.SecurityContext) bool
 	return compareCaps(ac.Add, bc.Add) && compareCaps(ac.Drop, bc.Drop)
 }
 
+func compareRunAsUser(a *core.SecurityContext, b *core.SecurityContext) bool {
+	var au, bu *int64
+	if a != nil {
+		au = a.RunAsUser
+	}
+	if b != nil {
+		bu = b.RunAsUser
+	}
+	return au == bu || au != nil && bu != nil && *au == *bu
+}
+
+// runsAsNonRoot returns true if the security context of the given pod makes its containers run as a user other than
+// root, which would prevent the init container from configuring iptables.
+func runsAsNonRoot(pod *core.Pod) bool {
+	psc := pod.Spec.SecurityContext
+	if psc == nil {
+		return false
+	}
+	return psc.RunAsNonRoot != nil && *psc.RunAsNonRoot || psc.RunAsUser != nil && *psc.RunAsUser != 0
+}
+
 // compareVolumeMounts compares two VolumeMount slices but will not include volume mounts using "kube-api-access-" prefix
 func compareVolumeMounts(a, b []core.VolumeMount) bool {
 	stripKubeAPI := func(vs []core.VolumeMount) []core.VolumeMount {
@@ -363,7 +537,9 @@ func addAgentContainer(
 // addTPEnv adds telepresence specific environment variables to all interceptable app containers
 func addTPEnv(pod *core.Pod, config *agentconfig.Sidecar, env map[string]string, patches patchOps) patchOps {
 	agentconfig.EachContainer(pod, config, func(app *core.Container, cc *agentconfig.Container) {
-		patches = addContainerTPEnv(pod, app, env, patches)
+		if !cc.Replace {
+			patches = addContainerTPEnv(pod, app, env, patches)
+		}
 	})
 	return patches
 }
@@ -420,6 +596,10 @@ func addContainerTPEnv(pod *core.Pod, cn *core.Container, env map[string]string,
 // the same replacement on all references to that port from the probes of the container
 func hidePorts(pod *core.Pod, config *agentconfig.Sidecar, patches patchOps) patchOps {
 	agentconfig.EachContainer(pod, config, func(app *core.Container, cc *agentconfig.Container) {
+		if cc.Replace {
+			// The container is removed
+			return
+		}
 		for _, ic := range agentconfig.PortUniqueIntercepts(cc) {
 			if ic.Headless || ic.TargetPortNumeric {
 				// Rely on iptables mapping instead of port renames
@@ -476,7 +656,27 @@ func hideContainerPorts(pod *core.Pod, app *core.Container, portName string, pat
 	return patches
 }
 
-func addPodAnnotations(_ context.Context, pod *core.Pod, patches patchOps) patchOps {
+// removeReplacedContainers removes the app containers that the traffic-agent replaces. The agent has already
+// copied their environment and volume mounts.
+func removeReplacedContainers(ctx context.Context, pod *core.Pod, config *agentconfig.Sidecar, patches patchOps) patchOps {
+	cns := pod.Spec.Containers
+	// Remove from the end, so that the removal of one container doesn't change the index of another
+	for i := len(cns) - 1; i >= 0; i-- {
+		for _, cc := range config.Containers {
+			if cc.Replace && cc.Name == cns[i].Name {
+				dlog.Infof(ctx, "Replacing container %s in pod %s.%s with %s", cc.Name, pod.Name, pod.Namespace, agentconfig.ContainerName)
+				patches = append(patches, patchOperation{
+					Op:   "remove",
+					Path: "/spec/containers/" + strconv.Itoa(i),
+				})
+				break
+			}
+		}
+	}
+	return patches
+}
+
+func addPodAnnotations(ctx context.Context, pod *core.Pod, config *agentconfig.Sidecar, patches patchOps) patchOps {
 	op := "replace"
 	changed := false
 	am := pod.Annotations
@@ -496,6 +696,16 @@ func addPodAnnotations(_ context.Context, pod *core.Pod, patches patchOps) patch
 		am[agentconfig.InjectAnnotation] = "enabled"
 	}
 
+	// The traffic-agent talks directly to the traffic-manager, so a service mesh must not redirect that traffic.
+	if sm := findServiceMesh(pod); sm != nil && config.ManagerPort != 0 {
+		ports := am[sm.excludeOutboundPortsAnnotation]
+		if np := addPortToList(ports, config.ManagerPort); np != ports {
+			dlog.Debugf(ctx, "Excluding port %d from %s outbound redirection in pod %s.%s", config.ManagerPort, sm.name, pod.Name, pod.Namespace)
+			changed = true
+			am[sm.excludeOutboundPortsAnnotation] = np
+		}
+	}
+
 	if changed {
 		patches = append(patches, patchOperation{
 			Op:    op,
@@ -514,6 +724,16 @@ func (a *agentInjector) findConfigMapValue(ctx context.Context, pod *core.Pod, w
 	if wl != nil {
 		refs = wl.GetOwnerReferences()
 	} else {
+		if kind, name := k8sapi.LabeledWorkloadRef(ctx, pod.GetLabels()); name != "" {
+			ag := agentconfig.Sidecar{}
+			ok, err := a.agentConfigs.GetInto(name, pod.GetNamespace(), &ag)
+			if err != nil {
+				return nil, err
+			}
+			if ok && ag.WorkloadKind == kind {
+				return &ag, nil
+			}
+		}
 		refs = pod.GetOwnerReferences()
 	}
 	for i := range refs {
@@ -14,6 +14,7 @@ import (
 	admission "k8s.io/api/admission/v1"
 	apps "k8s.io/api/apps/v1"
 	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
@@ -34,6 +35,9 @@ const serviceAccountMountPath = "/var/run/secrets/kubernetes.io/serviceaccount"
 func int32P(i int32) *int32 {
 	return &i
 }
+func int64P(i int64) *int64 {
+	return &i
+}
 func boolP(b bool) *bool {
 	return &b
 }
@@ -225,6 +229,25 @@ func TestTrafficAgentConfigGenerator(t *testing.T) {
 		},
 	}
 
+	podNoService := core.Pod{
+		ObjectMeta: podObjectMeta("no-service", "app"),
+		Spec: core.PodSpec{
+			Containers: []core.Container{
+				{
+					Name: "some-container",
+					Ports: []core.ContainerPort{
+						{
+							Name: "http", ContainerPort: 8080,
+						},
+						{
+							ContainerPort: 8081, Protocol: "UDP",
+						},
+					},
+				},
+			},
+		},
+	}
+
 	deployment := func(pod *core.Pod) *apps.Deployment {
 		name := wlName(pod.Name)
 		return &apps.Deployment{
@@ -337,11 +360,13 @@ func TestTrafficAgentConfigGenerator(t *testing.T) {
 		&podNamedAndNumericPort,
 		&podMultiPort,
 		&podMultiSplitPort,
+		&podNoService,
 		deployment(&podNamedPort),
 		deployment(&podNumericPort),
 		deployment(&podNamedAndNumericPort),
 		deployment(&podMultiPort),
 		deployment(&podMultiSplitPort),
+		deployment(&podNoService),
 	)
 	tests := []struct {
 		name           string
@@ -595,6 +620,42 @@ func TestTrafficAgentConfigGenerator(t *testing.T) {
 			},
 			"",
 		},
+		{
+			"Container ports without service",
+			&podNoService,
+			&agentconfig.Sidecar{
+				AgentName:    "no-service",
+				AgentImage:   "docker.io/datawire/tel2:2.6.0",
+				Namespace:    "some-ns",
+				WorkloadName: "no-service",
+				WorkloadKind: "Deployment",
+				ManagerHost:  "traffic-manager.default",
+				ManagerPort:  8081,
+				Containers: []*agentconfig.Container{
+					{
+						Name: "some-container",
+						Intercepts: []*agentconfig.Intercept{
+							{
+								ContainerPortName: "http",
+								TargetPortNumeric: true,
+								Protocol:          "TCP",
+								AgentPort:         9900,
+								ContainerPort:     8080,
+							},
+							{
+								TargetPortNumeric: true,
+								Protocol:          "UDP",
+								AgentPort:         9901,
+								ContainerPort:     8081,
+							},
+						},
+						EnvPrefix:  "A_",
+						MountPoint: "/tel_app_mounts/some-container",
+					},
+				},
+			},
+			"",
+		},
 	}
 	for _, test := range tests {
 		test := test // pin it
@@ -612,6 +673,100 @@ func TestTrafficAgentConfigGenerator(t *testing.T) {
 			assert.Equal(t, expectedConfig, actualConfig, "configs differ")
 		})
 	}
+
+	t.Run("Restricted security profile", func(t *testing.T) {
+		ctx := k8sapi.WithK8sInterface(ctx, clientset)
+		cfg := env.GeneratorConfig("docker.io/datawire/tel2:2.6.0")
+		cfg.SecurityProfile = agentconfig.RestrictedSecurityProfile
+		ac, err := generateForPod(t, ctx, &podNumericPort, cfg)
+		require.NoError(t, err)
+		assert.Equal(t, agentconfig.RestrictedSecurityProfile, ac.SecurityProfile)
+		require.Len(t, ac.Containers, 1)
+		require.Len(t, ac.Containers[0].Intercepts, 1)
+		ic := ac.Containers[0].Intercepts[0]
+		assert.True(t, ic.TargetPortNumeric)
+		assert.True(t, ic.ServiceRedirect)
+		assert.False(t, ic.NeedsInitContainer())
+	})
+
+	t.Run("Agent resources, security context, and pull secrets", func(t *testing.T) {
+		ctx := k8sapi.WithK8sInterface(ctx, clientset)
+		cfg := env.GeneratorConfig("docker.io/datawire/tel2:2.6.0")
+		cfg.Resources = &agentconfig.ResourceRequirements{
+			Requests: core.ResourceList{core.ResourceCPU: resource.MustParse("50m")},
+		}
+		cfg.PullSecrets = []string{"mirror"}
+
+		pod := podNamedPort.DeepCopy()
+		pod.Annotations = map[string]string{
+			agentmap.AgentSecurityContextAnnotation: `{"runAsUser":1000}`,
+			agentmap.AgentPullSecretsAnnotation:     "mirror, other",
+		}
+		ac, err := generateForPod(t, ctx, pod, cfg)
+		require.NoError(t, err)
+		assert.Equal(t, cfg.Resources, ac.Resources)
+		require.NotNil(t, ac.SecurityContext)
+		assert.Equal(t, int64P(1000), ac.SecurityContext.RunAsUser)
+		assert.Equal(t, []string{"mirror", "other"}, ac.PullSecrets)
+
+		pod.Annotations[agentmap.AgentResourcesAnnotation] = `{"limits":{"memory":"64Mi"}}`
+		ac, err = generateForPod(t, ctx, pod, cfg)
+		require.NoError(t, err)
+		assert.Empty(t, ac.Resources.Requests)
+		assert.Equal(t, "64Mi", ac.Resources.Limits.Memory().String())
+
+		pod.Annotations[agentmap.AgentResourcesAnnotation] = `{"limits":`
+		_, err = generateForPod(t, ctx, pod, cfg)
+		requireContains(t, err, "invalid "+agentmap.AgentResourcesAnnotation+" annotation")
+	})
+
+	t.Run("Agent ports and listener", func(t *testing.T) {
+		ctx := k8sapi.WithK8sInterface(ctx, clientset)
+		cfg := env.GeneratorConfig("docker.io/datawire/tel2:2.6.0")
+		cfg.ListenAddress = agentconfig.ListenPodIP
+		cfg.ReusePort = true
+
+		// The port that the app exposes is skipped
+		pod := podMultiPort.DeepCopy()
+		cn := &pod.Spec.Containers[0]
+		cn.Ports = append(cn.Ports, core.ContainerPort{Name: "metrics", ContainerPort: 9901})
+		ac, err := generateForPod(t, ctx, pod, cfg)
+		require.NoError(t, err)
+		assert.Equal(t, agentconfig.ListenPodIP, ac.ListenAddress)
+		assert.True(t, ac.ReusePort)
+		require.Len(t, ac.Containers, 1)
+		var agentPorts []uint16
+		for _, ic := range ac.Containers[0].Intercepts {
+			agentPorts = append(agentPorts, ic.AgentPort)
+		}
+		assert.Equal(t, []uint16{9900, 9902}, agentPorts)
+
+		cfg.AgentMaxPort = 9901
+		_, err = generateForPod(t, ctx, pod, cfg)
+		requireContains(t, err, "the ports 9900-9901 of the traffic-agent sidecar are exhausted")
+	})
+
+	t.Run("Host network", func(t *testing.T) {
+		ctx := k8sapi.WithK8sInterface(ctx, clientset)
+		cfg := env.GeneratorConfig("docker.io/datawire/tel2:2.6.0")
+
+		// The numeric target port is redirected using the service, because the init container can't be used
+		pod := podNumericPort.DeepCopy()
+		pod.Spec.HostNetwork = true
+		ac, err := generateForPod(t, ctx, pod, cfg)
+		require.NoError(t, err)
+		require.Len(t, ac.Containers, 1)
+		require.Len(t, ac.Containers[0].Intercepts, 1)
+		ic := ac.Containers[0].Intercepts[0]
+		assert.True(t, ic.ServiceRedirect)
+		assert.False(t, ic.NeedsInitContainer())
+
+		// A pod without a service can only be intercepted using the init container
+		pod = podNoService.DeepCopy()
+		pod.Spec.HostNetwork = true
+		_, err = generateForPod(t, ctx, pod, cfg)
+		requireContains(t, err, "uses the host network, and all of its ports need an init container")
+	})
 }
 
 func TestTrafficAgentInjector(t *testing.T) {
@@ -1560,3 +1715,145 @@ func generateForPod(t *testing.T, ctx context.Context, pod *core.Pod, gc *agentm
 	}
 	return agentmap.Generate(ctx, wl, gc)
 }
+
+func TestServiceMesh(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	config := &agentconfig.Sidecar{
+		AgentImage:  "docker.io/datawire/tel2:2.6.0",
+		ManagerPort: 8081,
+		Containers: []*agentconfig.Container{{
+			Name:       "some-container",
+			Intercepts: []*agentconfig.Intercept{{ContainerPort: 8888, TargetPortNumeric: true}},
+		}},
+	}
+
+	t.Run("no mesh", func(t *testing.T) {
+		pod := &core.Pod{Spec: core.PodSpec{Containers: []core.Container{{Name: "some-container"}}}}
+		assert.Nil(t, findServiceMesh(pod))
+		patches := addPodAnnotations(ctx, pod, config, nil)
+		require.Len(t, patches, 1)
+		assert.Equal(t, map[string]string{agentconfig.InjectAnnotation: "enabled"}, patches[0].Value)
+	})
+
+	t.Run("istio outbound exclusion", func(t *testing.T) {
+		pod := &core.Pod{
+			ObjectMeta: meta.ObjectMeta{Annotations: map[string]string{
+				agentconfig.InjectAnnotation:                    "enabled",
+				"traffic.sidecar.istio.io/excludeOutboundPorts": "5432",
+			}},
+			Spec: core.PodSpec{Containers: []core.Container{{Name: "some-container"}, {Name: "istio-proxy"}}},
+		}
+		sm := findServiceMesh(pod)
+		require.NotNil(t, sm)
+		assert.Equal(t, "istio", sm.name)
+		patches := addPodAnnotations(ctx, pod, config, nil)
+		require.Len(t, patches, 1)
+		am := patches[0].Value.(map[string]string)
+		assert.Equal(t, "5432,8081", am["traffic.sidecar.istio.io/excludeOutboundPorts"])
+
+		pod.Annotations = am
+		assert.Empty(t, addPodAnnotations(ctx, pod, config, nil))
+	})
+
+	t.Run("linkerd init ordering", func(t *testing.T) {
+		pod := &core.Pod{
+			ObjectMeta: meta.ObjectMeta{Annotations: map[string]string{"linkerd.io/inject": "enabled"}},
+			Spec: core.PodSpec{InitContainers: []core.Container{
+				*agentconfig.InitContainer(config.AgentImage, false),
+				{Name: "linkerd-init"},
+			}},
+		}
+		patches := addInitContainer(ctx, pod, config, nil)
+		require.Len(t, patches, 2)
+		assert.Equal(t, "remove", patches[0].Op)
+		assert.Equal(t, "/spec/initContainers/0", patches[0].Path)
+		assert.Equal(t, "add", patches[1].Op)
+		assert.Equal(t, "/spec/initContainers/1", patches[1].Path)
+
+		pod.Spec.InitContainers[0], pod.Spec.InitContainers[1] = pod.Spec.InitContainers[1], pod.Spec.InitContainers[0]
+		assert.Empty(t, addInitContainer(ctx, pod, config, nil))
+	})
+}
+
+func TestAddInitContainer_runAsRoot(t *testing.T) {
+	config := &agentconfig.Sidecar{
+		AgentImage: "docker.io/datawire/tel2:2.7.0",
+		Containers: []*agentconfig.Container{{
+			Name:       "some-container",
+			Intercepts: []*agentconfig.Intercept{{ContainerPort: 8080, AgentPort: 9900, TargetPortNumeric: true}},
+		}},
+	}
+	nonRoot := true
+	nonRootPod := &core.Pod{Spec: core.PodSpec{SecurityContext: &core.PodSecurityContext{RunAsNonRoot: &nonRoot}}}
+	runAsUser := func(patches patchOps) *int64 {
+		require.Len(t, patches, 1)
+		return patches[0].Value.([]core.Container)[0].SecurityContext.RunAsUser
+	}
+
+	// Root is opt-in
+	ctx := managerutil.WithEnv(dlog.NewTestContext(t, false), &managerutil.Env{})
+	assert.Nil(t, runAsUser(addInitContainer(ctx, nonRootPod, config, nil)))
+
+	// and only used when the pod would otherwise run the init container as another user
+	ctx = managerutil.WithEnv(dlog.NewTestContext(t, false), &managerutil.Env{AgentInitRunAsRoot: true})
+	assert.Nil(t, runAsUser(addInitContainer(ctx, &core.Pod{}, config, nil)))
+	uid := runAsUser(addInitContainer(ctx, nonRootPod, config, nil))
+	require.NotNil(t, uid)
+	assert.Equal(t, int64(0), *uid)
+}
+
+func TestAddShareProcessNamespace(t *testing.T) {
+	pod := &core.Pod{}
+	assert.Empty(t, addShareProcessNamespace(pod, &agentconfig.Sidecar{}, nil))
+
+	// Sharing the process namespace is opt-in
+	config := &agentconfig.Sidecar{TerminateWithApp: true}
+	assert.Empty(t, addShareProcessNamespace(pod, config, nil))
+
+	pod.Annotations = map[string]string{agentconfig.ShareProcessNamespaceAnnotation: "true"}
+	patches := addShareProcessNamespace(pod, config, nil)
+	require.Len(t, patches, 1)
+	assert.Equal(t, "/spec/shareProcessNamespace", patches[0].Path)
+	assert.Equal(t, true, patches[0].Value)
+
+	pod.Spec.ShareProcessNamespace = boolP(true)
+	assert.Empty(t, addShareProcessNamespace(pod, config, nil))
+}
+
+func TestNeutralizeResources(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	rl := func(cpu, mem string) core.ResourceList {
+		return core.ResourceList{core.ResourceCPU: resource.MustParse(cpu), core.ResourceMemory: resource.MustParse(mem)}
+	}
+	pod := &core.Pod{Spec: core.PodSpec{Containers: []core.Container{
+		{Name: "small", Resources: core.ResourceRequirements{Requests: rl("100m", "64Mi")}},
+		{Name: "big", Resources: core.ResourceRequirements{Requests: rl("500m", "16Mi"), Limits: rl("1", "1Gi")}},
+	}}}
+	config := &agentconfig.Sidecar{Resources: &agentconfig.ResourceRequirements{Requests: rl("10m", "32Mi"), Limits: rl("100m", "32Mi")}}
+	assert.Empty(t, neutralizeResources(ctx, pod, config, nil))
+
+	config.ResourcePolicy = agentconfig.NeutralResourcePolicy
+	patches := neutralizeResources(ctx, pod, config, nil)
+	values := make(map[string]any, len(patches))
+	for _, p := range patches {
+		assert.Equal(t, "replace", p.Op)
+		values[p.Path] = p.Value
+	}
+	assert.Equal(t, map[string]any{
+		"/spec/containers/1/resources/requests/cpu":    "490m",
+		"/spec/containers/1/resources/limits/cpu":      "900m",
+		"/spec/containers/0/resources/requests/memory": "32Mi",
+	}, values)
+
+	// The resources of a replaced container aren't shared
+	config.Containers = []*agentconfig.Container{{Name: "big", Replace: true}}
+	patches = neutralizeResources(ctx, pod, config, nil)
+	require.Len(t, patches, 2)
+	for _, p := range patches {
+		assert.True(t, strings.HasPrefix(p.Path, "/spec/containers/0/resources/requests/"), p.Path)
+	}
+
+	// Nothing is taken from a pod that already has an agent
+	pod.Spec.Containers = append(pod.Spec.Containers, core.Container{Name: agentconfig.ContainerName})
+	assert.Empty(t, neutralizeResources(ctx, pod, config, nil))
+}
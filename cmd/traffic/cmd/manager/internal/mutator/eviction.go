@@ -0,0 +1,151 @@
+package mutator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/datawire/dlib/dtime"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+// evictionRetryInterval is the time between attempts to evict a pod that a PodDisruptionBudget protects, and
+// between the checks that the replacement of an evicted pod is ready.
+var evictionRetryInterval = 2 * time.Second
+
+// podRolloutTimeout is the maximum time that a rollout that evicts the pods of a workload may take.
+const podRolloutTimeout = 10 * time.Minute
+
+// podEvictionTimeout is the maximum time that the evictions of a pod are retried while a PodDisruptionBudget refuses
+// them.
+var podEvictionTimeout = 2 * time.Minute
+
+// evictPod evicts the given pod using the eviction API, which respects the PodDisruptionBudgets of the pod. An
+// eviction that a budget refuses is retried until the budget permits it, the podEvictionTimeout expires, or the
+// context is done. The pod is deleted instead when the traffic-manager isn't permitted to evict it.
+func evictPod(ctx context.Context, pod *core.Pod) error {
+	api := k8sapi.GetK8sInterface(ctx).CoreV1().Pods(pod.Namespace)
+	ev := &policy.Eviction{ObjectMeta: meta.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace}}
+	retryCtx, cancel := context.WithTimeout(ctx, podEvictionTimeout)
+	defer cancel()
+	for {
+		err := api.EvictV1(ctx, ev)
+		switch {
+		case err == nil || errors.IsNotFound(err):
+			return nil
+		case errors.IsTooManyRequests(err):
+			dlog.Debugf(ctx, "Eviction of pod %s.%s is refused by a PodDisruptionBudget, will retry", pod.Name, pod.Namespace)
+			dtime.SleepWithContext(retryCtx, evictionRetryInterval)
+			if retryCtx.Err() != nil {
+				return fmt.Errorf("unable to evict pod %s.%s: %w", pod.Name, pod.Namespace, err)
+			}
+		case errors.IsForbidden(err):
+			// The RBAC of the traffic-manager predates the use of evictions.
+			dlog.Warnf(ctx, "The traffic-manager isn't permitted to create pods/eviction, so pod %s.%s is deleted without "+
+				"respecting its PodDisruptionBudgets. Upgrade the RBAC of the traffic-manager to avoid this", pod.Name, pod.Namespace)
+			if err = api.Delete(ctx, pod.Name, meta.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+				return fmt.Errorf("unable to delete pod %s.%s: %w", pod.Name, pod.Namespace, err)
+			}
+			return nil
+		default:
+			return fmt.Errorf("unable to evict pod %s.%s: %w", pod.Name, pod.Namespace, err)
+		}
+	}
+}
+
+// restartReplicaSetPods makes the given ReplicaSet recreate its pods from its current pod template by evicting them,
+// one at a time. The next pod isn't evicted until the ReplicaSet has all its replicas ready again, so the ReplicaSet
+// never has more than one pod missing, and the PodDisruptionBudgets of the pods are respected.
+func restartReplicaSetPods(ctx context.Context, rs *apps.ReplicaSet) {
+	ctx, cancel := context.WithTimeout(ctx, podRolloutTimeout)
+	defer cancel()
+
+	pods, err := workloadPods(ctx, rs.Namespace, rs.Spec.Selector)
+	if err != nil {
+		dlog.Errorf(ctx, "unable to list the pods of ReplicaSet %s.%s: %v", rs.Name, rs.Namespace, err)
+		return
+	}
+	replicas := 1
+	if rp := rs.Spec.Replicas; rp != nil {
+		replicas = int(*rp)
+	}
+	restarted := 0
+	for i := range pods {
+		pod := &pods[i]
+		if !isRunning(pod) {
+			continue
+		}
+		if restarted > 0 {
+			if err = waitForReadyPods(ctx, rs.Namespace, rs.Spec.Selector, replicas); err != nil {
+				dlog.Errorf(ctx, "rollout of ReplicaSet %s.%s is incomplete: %v", rs.Name, rs.Namespace, err)
+				return
+			}
+		}
+		if err = evictPod(ctx, pod); err != nil {
+			dlog.Errorf(ctx, "rollout of ReplicaSet %s.%s is incomplete: %v", rs.Name, rs.Namespace, err)
+			return
+		}
+		restarted++
+	}
+	if restarted == 0 {
+		dlog.Debugf(ctx, "ReplicaSet %s.%s has no running pods so rollout was a no-op", rs.Name, rs.Namespace)
+		return
+	}
+	dlog.Infof(ctx, "Successfully restarted %d pods of ReplicaSet %s.%s", restarted, rs.Name, rs.Namespace)
+}
+
+// waitForReadyPods waits until the given selector selects at least the given number of ready pods that aren't
+// terminating.
+func waitForReadyPods(ctx context.Context, namespace string, selector *meta.LabelSelector, count int) error {
+	for {
+		pods, err := workloadPods(ctx, namespace, selector)
+		if err != nil {
+			return err
+		}
+		ready := 0
+		for i := range pods {
+			if pod := &pods[i]; isRunning(pod) && isReady(pod) {
+				ready++
+			}
+		}
+		if ready >= count {
+			return nil
+		}
+		dtime.SleepWithContext(ctx, evictionRetryInterval)
+		if ctx.Err() != nil {
+			return fmt.Errorf("%d of %d pods are ready: %w", ready, count, ctx.Err())
+		}
+	}
+}
+
+func workloadPods(ctx context.Context, namespace string, selector *meta.LabelSelector) ([]core.Pod, error) {
+	sel, err := meta.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := k8sapi.GetK8sInterface(ctx).CoreV1().Pods(namespace).List(ctx, meta.ListOptions{LabelSelector: sel.String()})
+	if err != nil {
+		return nil, err
+	}
+	return pods.Items, nil
+}
+
+func isRunning(pod *core.Pod) bool {
+	return pod.DeletionTimestamp == nil && pod.Status.Phase != core.PodSucceeded && pod.Status.Phase != core.PodFailed
+}
+
+func isReady(pod *core.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == core.PodReady {
+			return c.Status == core.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package mutator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+var podsGVR = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+func readyPod(name string) *core.Pod {
+	return &core.Pod{
+		ObjectMeta: meta.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"app": "echo"}},
+		Status: core.PodStatus{
+			Phase:      core.PodRunning,
+			Conditions: []core.PodCondition{{Type: core.PodReady, Status: core.ConditionTrue}},
+		},
+	}
+}
+
+// evictionClientset returns a clientset whose evictions are refused with the given error the given number of times,
+// and that otherwise replaces an evicted pod with a new ready pod, like a ReplicaSet would. The names of the evicted
+// pods are sent to the returned channel.
+func evictionClientset(refusals int, refusal error, pods ...runtime.Object) (*fake.Clientset, chan string) {
+	cs := fake.NewSimpleClientset(pods...)
+	evicted := make(chan string, 10)
+	cs.PrependReactor("create", "pods", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		ca := a.(k8stesting.CreateAction)
+		if ca.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		if refusals > 0 {
+			refusals--
+			return true, nil, refusal
+		}
+		name := ca.GetObject().(*policy.Eviction).Name
+		if err := cs.Tracker().Delete(podsGVR, "default", name); err != nil {
+			return true, nil, err
+		}
+		evicted <- name
+		return true, nil, cs.Tracker().Add(readyPod(name + "-new"))
+	})
+	return cs, evicted
+}
+
+func podNames(ctx context.Context, t *testing.T) []string {
+	pods, err := k8sapi.GetK8sInterface(ctx).CoreV1().Pods("default").List(ctx, meta.ListOptions{})
+	require.NoError(t, err)
+	names := make([]string, len(pods.Items))
+	for i := range pods.Items {
+		names[i] = pods.Items[i].Name
+	}
+	return names
+}
+
+func TestRestartReplicaSetPods(t *testing.T) {
+	defer func(d time.Duration) { evictionRetryInterval = d }(evictionRetryInterval)
+	evictionRetryInterval = 10 * time.Millisecond
+
+	// The first eviction is refused by a PodDisruptionBudget
+	cs, evicted := evictionClientset(1, errors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0),
+		readyPod("echo-1"), readyPod("echo-2"))
+	ctx := k8sapi.WithK8sInterface(dlog.NewTestContext(t, false), cs)
+	rs := &apps.ReplicaSet{
+		ObjectMeta: meta.ObjectMeta{Name: "echo", Namespace: "default"},
+		Spec:       apps.ReplicaSetSpec{Selector: &meta.LabelSelector{MatchLabels: map[string]string{"app": "echo"}}},
+	}
+	restartReplicaSetPods(ctx, rs)
+	close(evicted)
+	var names []string
+	for name := range evicted {
+		names = append(names, name)
+	}
+	assert.Equal(t, []string{"echo-1", "echo-2"}, names)
+	assert.ElementsMatch(t, []string{"echo-1-new", "echo-2-new"}, podNames(ctx, t))
+}
+
+func TestEvictPod_forbidden(t *testing.T) {
+	// A traffic-manager that isn't permitted to evict pods deletes them
+	pod := readyPod("echo-1")
+	cs, evicted := evictionClientset(1, errors.NewForbidden(podsGVR.GroupResource(), "echo-1", nil), pod)
+	ctx := k8sapi.WithK8sInterface(dlog.NewTestContext(t, false), cs)
+	require.NoError(t, evictPod(ctx, pod))
+	assert.Empty(t, evicted)
+	assert.Empty(t, podNames(ctx, t))
+}
+
+func TestEvictPod_timeout(t *testing.T) {
+	defer func(d, td time.Duration) { evictionRetryInterval, podEvictionTimeout = d, td }(evictionRetryInterval, podEvictionTimeout)
+	evictionRetryInterval = 10 * time.Millisecond
+	podEvictionTimeout = 50 * time.Millisecond
+
+	// A PodDisruptionBudget that never permits the eviction doesn't block the rollout forever
+	pod := readyPod("echo-1")
+	cs, evicted := evictionClientset(1000, errors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0), pod)
+	ctx := k8sapi.WithK8sInterface(dlog.NewTestContext(t, false), cs)
+	err := evictPod(ctx, pod)
+	require.Error(t, err)
+	assert.True(t, errors.IsTooManyRequests(err))
+	assert.Empty(t, evicted)
+	assert.Equal(t, []string{"echo-1"}, podNames(ctx, t))
+}
+
+func TestConfigWatcher_startRollout(t *testing.T) {
+	defer func(d time.Duration) { evictionRetryInterval = d }(evictionRetryInterval)
+	evictionRetryInterval = 10 * time.Millisecond
+
+	// The evictions are refused, so the rollout stays in progress until it's cancelled
+	cs, _ := evictionClientset(1000, errors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0),
+		readyPod("echo-1"))
+	ctx := k8sapi.WithK8sInterface(dlog.NewTestContext(t, false), cs)
+	wl := k8sapi.ReplicaSet(&apps.ReplicaSet{
+		TypeMeta:   meta.TypeMeta{Kind: "ReplicaSet"},
+		ObjectMeta: meta.ObjectMeta{Name: "echo", Namespace: "default"},
+		Spec:       apps.ReplicaSetSpec{Selector: &meta.LabelSelector{MatchLabels: map[string]string{"app": "echo"}}},
+	})
+	c := NewWatcher("")
+	pending := func() *pendingRollout {
+		c.rolloutsLock.Lock()
+		defer c.rolloutsLock.Unlock()
+		return c.rollouts[rolloutKey(wl)]
+	}
+
+	// startRollout doesn't wait for the rollout
+	c.startRollout(ctx, wl)
+	first := pending()
+	require.NotNil(t, first)
+
+	// A new rollout of the same workload replaces the one in progress
+	c.startRollout(ctx, wl)
+	second := pending()
+	require.NotNil(t, second)
+	assert.NotSame(t, first, second)
+
+	c.cancelRollout(rolloutKey(wl))
+	assert.Nil(t, pending())
+	c.rolloutsWg.Wait()
+}
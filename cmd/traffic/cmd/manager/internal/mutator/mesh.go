@@ -0,0 +1,99 @@
+package mutator
+
+import (
+	"strconv"
+	"strings"
+
+	core "k8s.io/api/core/v1"
+)
+
+// serviceMesh describes a service mesh that injects a proxy sidecar into the pods that it manages.
+type serviceMesh struct {
+	// name of the mesh, used in log messages
+	name string
+
+	// proxyContainer is the name of the sidecar proxy container
+	proxyContainer string
+
+	// initContainer is the name of the init container that sets up the mesh's iptables rules
+	initContainer string
+
+	// injectedAnnotation is an annotation that the mesh adds to the pods that it has injected
+	injectedAnnotation string
+
+	// injectAnnotation is an annotation or label that requests injection of the mesh, and the value that enables it
+	injectAnnotation string
+	injectValue      string
+
+	// excludeOutboundPortsAnnotation is a pod annotation containing a comma separated list of ports that the mesh
+	// will not redirect outbound traffic for
+	excludeOutboundPortsAnnotation string
+}
+
+var serviceMeshes = []*serviceMesh{
+	{
+		name:                           "istio",
+		proxyContainer:                 "istio-proxy",
+		initContainer:                  "istio-init",
+		injectedAnnotation:             "sidecar.istio.io/status",
+		injectAnnotation:               "sidecar.istio.io/inject",
+		injectValue:                    "true",
+		excludeOutboundPortsAnnotation: "traffic.sidecar.istio.io/excludeOutboundPorts",
+	},
+	{
+		name:                           "linkerd",
+		proxyContainer:                 "linkerd-proxy",
+		initContainer:                  "linkerd-init",
+		injectedAnnotation:             "linkerd.io/proxy-version",
+		injectAnnotation:               "linkerd.io/inject",
+		injectValue:                    "enabled",
+		excludeOutboundPortsAnnotation: "config.linkerd.io/skip-outbound-ports",
+	},
+}
+
+// findServiceMesh returns the service mesh that has been, or is about to be, injected into the given pod, or nil
+// when no known mesh is present.
+func findServiceMesh(pod *core.Pod) *serviceMesh {
+	for _, sm := range serviceMeshes {
+		if _, ok := pod.Annotations[sm.injectedAnnotation]; ok {
+			return sm
+		}
+		if pod.Annotations[sm.injectAnnotation] == sm.injectValue || pod.Labels[sm.injectAnnotation] == sm.injectValue {
+			return sm
+		}
+		for i := range pod.Spec.Containers {
+			if pod.Spec.Containers[i].Name == sm.proxyContainer {
+				return sm
+			}
+		}
+		if sm.initContainerIndex(pod) >= 0 {
+			return sm
+		}
+	}
+	return nil
+}
+
+// initContainerIndex returns the index of the mesh's init container in the given pod, or -1 if it's not found.
+func (sm *serviceMesh) initContainerIndex(pod *core.Pod) int {
+	for i := range pod.Spec.InitContainers {
+		if pod.Spec.InitContainers[i].Name == sm.initContainer {
+			return i
+		}
+	}
+	return -1
+}
+
+// addPortToList adds the given port to a comma separated list of ports unless
+// it's already present. The list is returned unchanged when that's the case.
+func addPortToList(list string, port int32) string {
+	ps := strconv.Itoa(int(port))
+	if list == "" {
+		return ps
+	}
+	for _, p := range strings.Split(list, ",") {
+		if strings.TrimSpace(p) == ps {
+			return list
+		}
+	}
+	return list + "," + ps
+}
@@ -2,7 +2,6 @@ package v25uninstall
 
 import (
 	"context"
-	"sync"
 	"time"
 
 	apps "k8s.io/api/apps/v1"
@@ -12,6 +11,7 @@ import (
 
 	"github.com/datawire/dlib/dlog"
 	"github.com/datawire/dlib/dtime"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
 	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/install"
@@ -56,21 +56,14 @@ func RemoveAgents(ctx context.Context, namespace string) []k8sapi.Workload {
 		}
 	}
 
-	wg := sync.WaitGroup{}
-	wg.Add(len(withModifications))
-	for _, wl := range withModifications {
-		go func(wl k8sapi.Workload) {
-			defer wg.Done()
-			err := undoModifications(ctx, wl)
-			if err == nil {
-				err = waitForApply(ctx, wl)
-			}
-			if err != nil {
-				dlog.Error(ctx, err)
-			}
-		}(wl)
-	}
-	wg.Wait()
+	managerutil.ForEachWorkload(ctx, "Removed the agent from", len(withModifications), func(ctx context.Context, i int) error {
+		wl := withModifications[i]
+		err := undoModifications(ctx, wl)
+		if err == nil {
+			err = waitForApply(ctx, wl)
+		}
+		return err
+	})
 	return withAgent
 }
 
@@ -4,18 +4,24 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"hash/fnv"
 	"strings"
 	"sync"
 	"time"
 
 	"gopkg.in/yaml.v3"
+	batch "k8s.io/api/batch/v1"
 	core "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/strings/slices"
 
+	"github.com/datawire/dlib/dcontext"
 	"github.com/datawire/dlib/dlog"
+	"github.com/datawire/dlib/dtime"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/mutator/v25uninstall"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
 	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
@@ -65,45 +71,86 @@ func (e *entry) workload(ctx context.Context) (*agentconfig.Sidecar, k8sapi.Work
 
 func triggerRollout(ctx context.Context, wl k8sapi.Workload) {
 	if rs, ok := k8sapi.ReplicaSetImpl(wl); ok {
-		// Rollout of a replicatset will not recreate the pods. In order for that to happen, the
-		// set must be scaled down and then up again.
-		dlog.Debugf(ctx, "Performing ReplicaSet rollout of %s.%s using scaling", wl.GetName(), wl.GetNamespace())
-		replicas := 1
-		if rp := rs.Spec.Replicas; rp != nil {
-			replicas = int(*rp)
-		}
-		if replicas > 0 {
-			patch := `{"spec": {"replicas": 0}}`
-			if err := wl.Patch(ctx, types.StrategicMergePatchType, []byte(patch)); err != nil {
-				dlog.Errorf(ctx, "unable to scale ReplicaSet %s.%s to zero: %v", wl.GetName(), wl.GetNamespace(), err)
-				return
-			}
-			patch = fmt.Sprintf(`{"spec": {"replicas": %d}}`, replicas)
-			if err := wl.Patch(ctx, types.StrategicMergePatchType, []byte(patch)); err != nil {
-				dlog.Errorf(ctx, "unable to scale ReplicaSet %s.%s to %d: %v", wl.GetName(), wl.GetNamespace(), replicas, err)
+		// Rollout of a replicaset will not recreate the pods. In order for that to happen, the
+		// pods are evicted so that the set recreates them.
+		dlog.Debugf(ctx, "Performing ReplicaSet rollout of %s.%s using evictions", wl.GetName(), wl.GetNamespace())
+		restartReplicaSetPods(ctx, rs)
+		return
+	}
+	if k8sapi.IsBatchKind(wl.GetKind()) && !agentconfig.RestartsJobPods(wl.GetPodTemplate().Annotations) {
+		// Evicting a running pod of a Job interrupts its work, and may count against its backoffLimit, so the
+		// change is left to the pods that the Job creates next unless the workload opts in.
+		dlog.Infof(ctx, "The running pods of %s %s.%s are not restarted, so the change applies to its next pods. Annotate "+
+			"the pod template with %s: \"true\" to restart them", wl.GetKind(), wl.GetName(), wl.GetNamespace(), agentconfig.RestartJobPodsAnnotation)
+		return
+	}
+	if job, ok := k8sapi.JobImpl(wl); ok {
+		// The pod template of a Job is immutable, but the pods that the Job creates are handled by the
+		// agent-injector, so deleting the active pods makes the Job recreate them with the change.
+		restartJobPods(ctx, job)
+		return
+	}
+	if cj, ok := k8sapi.CronJobImpl(wl); ok {
+		// The jobs that the CronJob creates next will get the change, so only its active jobs are restarted.
+		for _, ref := range cj.Status.Active {
+			j, err := k8sapi.GetJob(ctx, ref.Name, wl.GetNamespace())
+			if err != nil {
+				if !errors.IsNotFound(err) {
+					dlog.Errorf(ctx, "unable to get Job %s.%s of CronJob %s: %v", ref.Name, wl.GetNamespace(), wl.GetName(), err)
+				}
+				continue
 			}
-		} else {
-			dlog.Debugf(ctx, "ReplicaSet %s.%s has zero replicas so rollout was a no-op", wl.GetName(), wl.GetNamespace())
+			job, _ := k8sapi.JobImpl(j)
+			restartJobPods(ctx, job)
 		}
 		return
 	}
 	restartAnnotation := fmt.Sprintf(
-		`{"spec": {"template": {"metadata": {"annotations": {"%srestartedAt": "%s"}}}}}`,
+		`{"annotations": {"%srestartedAt": "%s"}}`,
 		install.DomainPrefix,
 		time.Now().Format(time.RFC3339),
 	)
-	if err := wl.Patch(ctx, types.StrategicMergePatchType, []byte(restartAnnotation)); err != nil {
+	if err := k8sapi.PatchPodTemplateMetadata(ctx, wl, restartAnnotation); err != nil {
 		dlog.Errorf(ctx, "unable to patch %s %s.%s: %v", wl.GetKind(), wl.GetName(), wl.GetNamespace(), err)
 		return
 	}
 	dlog.Infof(ctx, "Successfully rolled out %s.%s", wl.GetName(), wl.GetNamespace())
 }
 
+// restartJobPods evicts the pods of the given Job that are still running, so that the Job controller
+// replaces them.
+func restartJobPods(ctx context.Context, job *batch.Job) {
+	pods, err := workloadPods(ctx, job.Namespace, job.Spec.Selector)
+	if err != nil {
+		dlog.Errorf(ctx, "unable to list the pods of Job %s.%s: %v", job.Name, job.Namespace, err)
+		return
+	}
+	restarted := 0
+	for i := range pods {
+		pod := &pods[i]
+		if !isRunning(pod) {
+			continue
+		}
+		if err = evictPod(ctx, pod); err != nil {
+			dlog.Errorf(ctx, "unable to restart pod of Job %s: %v", job.Name, err)
+			continue
+		}
+		restarted++
+	}
+	if restarted == 0 {
+		dlog.Debugf(ctx, "Job %s.%s has no running pods so rollout was a no-op", job.Name, job.Namespace)
+		return
+	}
+	dlog.Infof(ctx, "Successfully restarted %d pods of Job %s.%s", restarted, job.Name, job.Namespace)
+}
+
 func NewWatcher(name string, namespaces ...string) *configWatcher {
 	return &configWatcher{
 		name:       name,
 		namespaces: namespaces,
 		data:       make(map[string]map[string]string),
+		redirects:  make(map[string]*pendingRedirect),
+		rollouts:   make(map[string]*pendingRollout),
 	}
 }
 
@@ -115,6 +162,23 @@ type configWatcher struct {
 	data       map[string]map[string]string
 	modCh      chan entry
 	delCh      chan entry
+
+	// redirects are the pending redirects of service target ports, keyed by agent name and namespace.
+	redirectsLock sync.Mutex
+	redirects     map[string]*pendingRedirect
+
+	// rollouts are the rollouts that are in progress, keyed by workload kind, name, and namespace.
+	rolloutsLock sync.Mutex
+	rollouts     map[string]*pendingRollout
+	rolloutsWg   sync.WaitGroup
+}
+
+type pendingRedirect struct {
+	cancel context.CancelFunc
+}
+
+type pendingRollout struct {
+	cancel context.CancelFunc
 }
 
 type entry struct {
@@ -129,54 +193,148 @@ func (c *configWatcher) Run(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+
+	// The entries are handled concurrently by a bounded number of workers. All entries of a workload are handled by
+	// the same worker, so that they are handled in the order that they arrive.
+	workers := make([]chan func(), managerutil.RolloutConcurrency(ctx))
+
+	// The rollouts that the workers start are cancelled with the context, and awaited once the workers are done.
+	defer c.rolloutsWg.Wait()
+	wg := sync.WaitGroup{}
+	wg.Add(len(workers))
+	for i := range workers {
+		wch := make(chan func(), 16)
+		workers[i] = wch
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case f := <-wch:
+					f()
+				}
+			}
+		}()
+	}
+	defer wg.Wait()
+	dispatch := func(e entry, f func()) {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(e.namespace + "/" + e.name))
+		select {
+		case <-ctx.Done():
+		case workers[h.Sum32()%uint32(len(workers))] <- f:
+		}
+	}
+
+	var agentImageLock sync.Mutex
 	var agentImage string
+	getAgentImage := func() string {
+		agentImageLock.Lock()
+		defer agentImageLock.Unlock()
+		if agentImage == "" {
+			agentImage = managerutil.GetAgentImage(ctx)
+		}
+		return agentImage
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
 		case e := <-delCh:
-			dlog.Debugf(ctx, "del %s.%s", e.name, e.namespace)
-			ac, wl, err := e.workload(ctx)
-			if err != nil {
-				if !errors.IsNotFound(err) {
-					dlog.Error(ctx, err)
-				}
-				continue
-			}
-			if ac.Create || ac.Manual {
-				// Deleted before it was generated or manually added, just ignore
-				continue
-			}
-			triggerRollout(ctx, wl)
+			dispatch(e, func() { c.handleDelete(ctx, e) })
 		case e := <-addCh:
-			dlog.Debugf(ctx, "add %s.%s", e.name, e.namespace)
-			ac, wl, err := e.workload(ctx)
-			if err != nil {
-				if !errors.IsNotFound(err) {
-					dlog.Error(ctx, err)
-				}
-				continue
-			}
-			if ac.Manual {
-				// Manually added, just ignore
-				continue
-			}
-			if ac.Create {
-				if agentImage == "" {
-					agentImage = managerutil.GetAgentImage(ctx)
-				}
-				if ac, err = agentmap.Generate(ctx, wl, managerutil.GetEnv(ctx).GeneratorConfig(agentImage)); err != nil {
-					dlog.Error(ctx, err)
-				} else if err = c.Store(ctx, ac, false); err != nil {
-					dlog.Error(ctx, err)
-				}
-				continue // Calling Store() will generate a new event, so we skip rollout here
-			}
-			triggerRollout(ctx, wl)
+			dispatch(e, func() { c.handleAdd(ctx, e, getAgentImage) })
+		}
+	}
+}
+
+func (c *configWatcher) handleDelete(ctx context.Context, e entry) {
+	dlog.Debugf(ctx, "del %s.%s", e.name, e.namespace)
+	ac, wl, err := e.workload(ctx)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			dlog.Error(ctx, err)
+		}
+		return
+	}
+	if ac.Create || ac.Manual {
+		// Deleted before it was generated or manually added, just ignore
+		return
+	}
+	c.cancelRedirect(ac.AgentName, ac.Namespace)
+	restoreServices(ctx, ac)
+	managerutil.WorkloadEvent(ctx, wl, managerutil.ReasonAgentRemoved, "Rolling out pods without the traffic-agent")
+	c.startRollout(ctx, wl)
+}
+
+func (c *configWatcher) handleAdd(ctx context.Context, e entry, agentImage func() string) {
+	dlog.Debugf(ctx, "add %s.%s", e.name, e.namespace)
+	ac, wl, err := e.workload(ctx)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			dlog.Error(ctx, err)
 		}
+		return
+	}
+	if ac.Manual {
+		// Manually added, just ignore
+		return
+	}
+	if ac.Create {
+		if ac, err = agentmap.Generate(ctx, wl, managerutil.GetEnv(ctx).GeneratorConfig(agentImage())); err != nil {
+			dlog.Error(ctx, err)
+		} else if err = c.Store(ctx, ac, false); err != nil {
+			dlog.Error(ctx, err)
+		}
+		return // Calling Store() will generate a new event, so we skip rollout here
+	}
+	managerutil.WorkloadEvent(ctx, wl, managerutil.ReasonAgentInjected, "Rolling out pods with an injected traffic-agent")
+	c.startRollout(ctx, wl)
+}
+
+// startRollout rolls out the given workload in a goroutine of its own, because a rollout that evicts pods one at a
+// time may take minutes, and the worker that handles the entries of the workload must not be blocked meanwhile. A
+// rollout of the same workload that is still in progress is cancelled, because the new rollout replaces all its pods.
+func (c *configWatcher) startRollout(ctx context.Context, wl k8sapi.Workload) {
+	key := rolloutKey(wl)
+	c.cancelRollout(key)
+	rc, cancel := context.WithCancel(ctx)
+	pr := &pendingRollout{cancel: cancel}
+	c.rolloutsLock.Lock()
+	c.rollouts[key] = pr
+	c.rolloutsLock.Unlock()
+	c.rolloutsWg.Add(1)
+	go func() {
+		defer c.rolloutsWg.Done()
+		defer func() {
+			c.rolloutsLock.Lock()
+			if c.rollouts[key] == pr {
+				delete(c.rollouts, key)
+			}
+			c.rolloutsLock.Unlock()
+			cancel()
+		}()
+		triggerRollout(rc, wl)
+	}()
+}
+
+// cancelRollout cancels the rollout with the given key that is in progress, if any.
+func (c *configWatcher) cancelRollout(key string) {
+	c.rolloutsLock.Lock()
+	pr, ok := c.rollouts[key]
+	delete(c.rollouts, key)
+	c.rolloutsLock.Unlock()
+	if ok {
+		pr.cancel()
 	}
 }
 
+func rolloutKey(wl k8sapi.Workload) string {
+	return wl.GetKind() + "/" + wl.GetName() + "." + wl.GetNamespace()
+}
+
 func (c *configWatcher) GetInto(key, ns string, into any) (bool, error) {
 	c.RLock()
 	var v string
@@ -217,6 +375,8 @@ func (c *configWatcher) Delete(ctx context.Context, name, namespace string) erro
 	if ac.Manual {
 		return nil
 	}
+	c.cancelRedirect(ac.AgentName, ac.Namespace)
+	restoreServices(ctx, &ac)
 	delete(cm.Data, name)
 	dlog.Debugf(ctx, "Deleting %s from ConfigMap %s.%s", name, agentconfig.ConfigMap, namespace)
 	_, err = api.Update(ctx, cm, meta.UpdateOptions{})
@@ -244,66 +404,258 @@ func (c *configWatcher) Store(ctx context.Context, ac *agentconfig.Sidecar, upda
 		return nil
 	}
 
-	create := false
+	// Workloads in the same namespace may be stored concurrently, so the ConfigMap is re-read and the update is
+	// retried when it was modified, or created, by someone else.
 	api := k8sapi.GetK8sInterface(ctx).CoreV1().ConfigMaps(ns)
-	cm, err := api.Get(ctx, agentconfig.ConfigMap, meta.GetOptions{})
-	if err != nil {
-		if !errors.IsNotFound(err) {
-			return fmt.Errorf("unable to get ConfigMap %s: %w", agentconfig.ConfigMap, err)
-		}
-		create = true
-	} else {
-		// Ensure that we're not about to overwrite a manually added config entry
-		if currentYml, ok := cm.Data[ac.AgentName]; ok {
-			var currAc agentconfig.Sidecar
-			if err = decode(currentYml, &currAc); err == nil && currAc.Manual {
-				dlog.Warnf(ctx, "avoided an attempt to overwrite manually added config entry for %s.%s", ac.AgentName, ns)
+	stored := false
+	err := retry.OnError(retry.DefaultBackoff, func(err error) bool {
+		return errors.IsConflict(err) || errors.IsAlreadyExists(err)
+	}, func() error {
+		create := false
+		cm, err := api.Get(ctx, agentconfig.ConfigMap, meta.GetOptions{})
+		if err != nil {
+			if !errors.IsNotFound(err) {
+				return fmt.Errorf("unable to get ConfigMap %s: %w", agentconfig.ConfigMap, err)
+			}
+			create = true
+		} else {
+			// Ensure that we're not about to overwrite a manually added config entry
+			if currentYml, ok := cm.Data[ac.AgentName]; ok {
+				var currAc agentconfig.Sidecar
+				if err = decode(currentYml, &currAc); err == nil && currAc.Manual {
+					dlog.Warnf(ctx, "avoided an attempt to overwrite manually added config entry for %s.%s", ac.AgentName, ns)
+					return nil
+				}
+			}
+			if cm.Data == nil {
+				cm.Data = make(map[string]string)
+			}
+			if cm.Data[ac.AgentName] == yml {
+				// Race condition. Snapshot isn't updated yet, or we wouldn't have gotten here.
 				return nil
 			}
 		}
-		if cm.Data == nil {
-			cm.Data = make(map[string]string)
+
+		if updateSnapshot {
+			c.Lock()
+			if nm, ok := c.data[ns]; ok {
+				nm[ac.AgentName] = yml
+			}
+			c.Unlock()
+		}
+
+		if create {
+			cm = &core.ConfigMap{
+				TypeMeta: meta.TypeMeta{
+					Kind:       "ConfigMap",
+					APIVersion: "v1",
+				},
+				ObjectMeta: meta.ObjectMeta{
+					Name:      agentconfig.ConfigMap,
+					Namespace: ns,
+				},
+				Data: map[string]string{
+					ac.AgentName: yml,
+				},
+			}
+			dlog.Debugf(ctx, "Creating new ConfigMap %s.%s with %s", agentconfig.ConfigMap, ns, ac.AgentName)
+			_, err = api.Create(ctx, cm, meta.CreateOptions{})
+		} else {
+			if _, ok := cm.Data[ac.AgentName]; ok {
+				dlog.Debugf(ctx, "Updating %s in ConfigMap %s.%s", ac.AgentName, agentconfig.ConfigMap, ns)
+			} else {
+				dlog.Debugf(ctx, "Adding %s to ConfigMap %s.%s", ac.AgentName, agentconfig.ConfigMap, ns)
+			}
+			cm.Data[ac.AgentName] = yml
+			_, err = api.Update(ctx, cm, meta.UpdateOptions{})
 		}
-		if cm.Data[ac.AgentName] == yml {
-			// Race condition. Snapshot isn't updated yet, or we wouldn't have gotten here.
+		stored = err == nil
+		return err
+	})
+	if stored {
+		// The services are redirected once the pods that the new config produces are ready. Redirecting them now
+		// would make the services lose their endpoints until the rollout completes.
+		c.redirectWhenReady(ctx, ac)
+	}
+	return err
+}
+
+// redirectedServices returns the names of the services whose target ports are redirected by the given config.
+func redirectedServices(ac *agentconfig.Sidecar) []string {
+	var names []string
+	for _, cc := range ac.Containers {
+		for _, ic := range cc.Intercepts {
+			if ic.ServiceRedirect && !slices.Contains(names, ic.ServiceName) {
+				names = append(names, ic.ServiceName)
+			}
+		}
+	}
+	return names
+}
+
+// redirectServices replaces the numeric target ports of the services that the given config redirects with the
+// names of the traffic-agent's ports.
+func redirectServices(ctx context.Context, ac *agentconfig.Sidecar) error {
+	api := k8sapi.GetK8sInterface(ctx).CoreV1().Services(ac.Namespace)
+	for _, name := range redirectedServices(ac) {
+		svc, err := api.Get(ctx, name, meta.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("unable to get service %s.%s: %w", name, ac.Namespace, err)
+		}
+		if agentmap.RedirectServicePorts(svc, ac) {
+			dlog.Debugf(ctx, "Redirecting target ports of service %s.%s to %s", name, ac.Namespace, agentconfig.ContainerName)
+			if _, err = api.Update(ctx, svc, meta.UpdateOptions{}); err != nil {
+				return fmt.Errorf("unable to redirect target ports of service %s.%s: %w", name, ac.Namespace, err)
+			}
+		}
+	}
+	return nil
+}
+
+// redirectWhenReady redirects the target ports of the services that the given config redirects once all running
+// pods of the config's workload are ready and expose the named ports of the traffic-agent. The target ports are
+// restored if that doesn't happen within the podRolloutTimeout. A pending redirect for the same workload is
+// cancelled.
+func (c *configWatcher) redirectWhenReady(ctx context.Context, ac *agentconfig.Sidecar) {
+	c.cancelRedirect(ac.AgentName, ac.Namespace)
+	if len(redirectedServices(ac)) == 0 {
+		return
+	}
+
+	// The given context may be the one of an admission request, so the redirect gets a context of its own.
+	ctx = dcontext.WithoutCancel(ctx)
+	wc, cancel := context.WithTimeout(ctx, podRolloutTimeout)
+	pr := &pendingRedirect{cancel: cancel}
+	key := ac.AgentName + "." + ac.Namespace
+	c.redirectsLock.Lock()
+	c.redirects[key] = pr
+	c.redirectsLock.Unlock()
+	go func() {
+		defer func() {
+			c.redirectsLock.Lock()
+			if c.redirects[key] == pr {
+				delete(c.redirects, key)
+			}
+			c.redirectsLock.Unlock()
+			cancel()
+		}()
+		err := waitForRedirectedPorts(wc, ac)
+		if err == nil {
+			err = redirectServices(wc, ac)
+		}
+		if err == nil {
+			return
+		}
+		if wc.Err() == context.Canceled {
+			// Superseded by another config, or the config was deleted.
+			return
+		}
+		dlog.Errorf(ctx, "unable to redirect the target ports of the services of %s %s.%s: %v",
+			ac.WorkloadKind, ac.WorkloadName, ac.Namespace, err)
+		restoreServices(ctx, ac)
+	}()
+}
+
+// cancelRedirect cancels the pending redirect of the service target ports of the given agent, if any.
+func (c *configWatcher) cancelRedirect(name, namespace string) {
+	key := name + "." + namespace
+	c.redirectsLock.Lock()
+	pr, ok := c.redirects[key]
+	delete(c.redirects, key)
+	c.redirectsLock.Unlock()
+	if ok {
+		pr.cancel()
+	}
+}
+
+// waitForRedirectedPorts waits until the running pods of the workload of the given config are ready and expose the
+// named ports that the config's redirected service target ports will use.
+func waitForRedirectedPorts(ctx context.Context, ac *agentconfig.Sidecar) error {
+	wl, err := k8sapi.GetWorkload(ctx, ac.WorkloadName, ac.Namespace, ac.WorkloadKind)
+	if err != nil {
+		return err
+	}
+	sel, err := wl.Selector()
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, cc := range ac.Containers {
+		for _, ic := range cc.Intercepts {
+			if ic.ServiceRedirect {
+				names = append(names, agentconfig.RedirectedPortName(ic.ContainerPort))
+			}
+		}
+	}
+	api := k8sapi.GetK8sInterface(ctx).CoreV1().Pods(ac.Namespace)
+	for {
+		pods, err := api.List(ctx, meta.ListOptions{LabelSelector: sel.String()})
+		if err != nil {
+			return err
+		}
+		ready, running := 0, 0
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			if !isRunning(pod) {
+				continue
+			}
+			running++
+			if isReady(pod) && exposesPorts(pod, names) {
+				ready++
+			}
+		}
+		if ready > 0 && ready == running {
 			return nil
 		}
+		dtime.SleepWithContext(ctx, evictionRetryInterval)
+		if ctx.Err() != nil {
+			return fmt.Errorf("%d of %d pods are ready with the ports %v: %w", ready, running, names, ctx.Err())
+		}
 	}
+}
 
-	if updateSnapshot {
-		c.Lock()
-		if nm, ok := c.data[ns]; ok {
-			nm[ac.AgentName] = yml
+// exposesPorts returns true if the traffic-agent container of the given pod has ports with all the given names.
+func exposesPorts(pod *core.Pod, names []string) bool {
+	for i := range pod.Spec.Containers {
+		cn := &pod.Spec.Containers[i]
+		if cn.Name != agentconfig.ContainerName {
+			continue
 		}
-		c.Unlock()
+		for _, name := range names {
+			found := false
+			for _, p := range cn.Ports {
+				if p.Name == name {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
 	}
+	return false
+}
 
-	if create {
-		cm = &core.ConfigMap{
-			TypeMeta: meta.TypeMeta{
-				Kind:       "ConfigMap",
-				APIVersion: "v1",
-			},
-			ObjectMeta: meta.ObjectMeta{
-				Name:      agentconfig.ConfigMap,
-				Namespace: ns,
-			},
-			Data: map[string]string{
-				ac.AgentName: yml,
-			},
+// restoreServices restores the target ports of the services that the given config redirects.
+func restoreServices(ctx context.Context, ac *agentconfig.Sidecar) {
+	api := k8sapi.GetK8sInterface(ctx).CoreV1().Services(ac.Namespace)
+	for _, name := range redirectedServices(ac) {
+		svc, err := api.Get(ctx, name, meta.GetOptions{})
+		if err != nil {
+			if !errors.IsNotFound(err) {
+				dlog.Errorf(ctx, "unable to get service %s.%s: %v", name, ac.Namespace, err)
+			}
+			continue
 		}
-		dlog.Debugf(ctx, "Creating new ConfigMap %s.%s with %s", agentconfig.ConfigMap, ns, ac.AgentName)
-		_, err = api.Create(ctx, cm, meta.CreateOptions{})
-	} else {
-		if _, ok := cm.Data[ac.AgentName]; ok {
-			dlog.Debugf(ctx, "Updating %s in ConfigMap %s.%s", ac.AgentName, agentconfig.ConfigMap, ns)
-		} else {
-			dlog.Debugf(ctx, "Adding %s to ConfigMap %s.%s", ac.AgentName, agentconfig.ConfigMap, ns)
+		if agentmap.RestoreServicePorts(svc) {
+			dlog.Debugf(ctx, "Restoring target ports of service %s.%s", name, ac.Namespace)
+			if _, err = api.Update(ctx, svc, meta.UpdateOptions{}); err != nil {
+				dlog.Errorf(ctx, "unable to restore target ports of service %s.%s: %v", name, ac.Namespace, err)
+			}
 		}
-		cm.Data[ac.AgentName] = yml
-		_, err = api.Update(ctx, cm, meta.UpdateOptions{})
 	}
-	return err
 }
 
 func whereWeWatch(ns string) string {
@@ -463,6 +815,15 @@ func (c *configWatcher) affectedConfigs(ctx context.Context, svc *core.Service,
 		if stss, err := k8sapi.StatefulSets(ctx, ns, selector); err == nil {
 			wls = append(wls, stss...)
 		}
+		if jobs, err := k8sapi.Jobs(ctx, ns, selector); err == nil {
+			wls = append(wls, jobs...)
+		}
+		if cjs, err := k8sapi.CronJobs(ctx, ns, selector); err == nil {
+			wls = append(wls, cjs...)
+		}
+		if cws, err := k8sapi.CustomWorkloads(ctx, ns, selector); err == nil {
+			wls = append(wls, cws...)
+		}
 	}
 	return c.configsAffectedByWorkloads(ctx, nsData, wls)
 }
@@ -564,24 +925,36 @@ func (c *configWatcher) DeleteMapsAndRolloutAll(ctx context.Context) {
 	c.RLock()
 	defer c.RUnlock()
 
-	now := meta.NewDeleteOptions(0)
-	api := k8sapi.GetK8sInterface(ctx).CoreV1()
+	var es []*entry
 	for ns, wlm := range c.data {
 		for k, v := range wlm {
-			e := &entry{name: k, namespace: ns, value: v}
-			ac, wl, err := e.workload(ctx)
-			if err != nil {
-				if !errors.IsNotFound(err) {
-					dlog.Errorf(ctx, "unable to get workload for %s.%s %s: %v", k, ns, v, err)
-				}
-				continue
-			}
-			if ac.Create || ac.Manual {
-				// Deleted before it was generated or manually added, just ignore
-				continue
+			es = append(es, &entry{name: k, namespace: ns, value: v})
+		}
+	}
+	managerutil.ForEachWorkload(ctx, "Rolled out", len(es), func(ctx context.Context, i int) error {
+		e := es[i]
+		ac, wl, err := e.workload(ctx)
+		if err != nil {
+			if !errors.IsNotFound(err) {
+				return fmt.Errorf("unable to get workload for %s.%s %s: %w", e.name, e.namespace, e.value, err)
 			}
-			triggerRollout(ctx, wl)
+			return nil
 		}
+		if ac.Create || ac.Manual {
+			// Deleted before it was generated or manually added, just ignore
+			return nil
+		}
+		c.cancelRedirect(ac.AgentName, ac.Namespace)
+		c.cancelRollout(rolloutKey(wl))
+		restoreServices(ctx, ac)
+		managerutil.WorkloadEvent(ctx, wl, managerutil.ReasonAgentRemoved, "Rolling out pods without the traffic-agent")
+		triggerRollout(ctx, wl)
+		return nil
+	})
+
+	now := meta.NewDeleteOptions(0)
+	api := k8sapi.GetK8sInterface(ctx).CoreV1()
+	for ns := range c.data {
 		if err := api.ConfigMaps(ns).Delete(ctx, agentconfig.ConfigMap, *now); err != nil {
 			dlog.Errorf(ctx, "unable to delete ConfigMap %s-%s: %v", agentconfig.ConfigMap, ns, err)
 		}
@@ -601,13 +974,11 @@ func (c *configWatcher) UninstallV25(ctx context.Context) {
 		}
 	}
 	gc := managerutil.GetEnv(ctx).GeneratorConfig(managerutil.GetAgentImage(ctx))
-	for _, wl := range affectedWorkloads {
-		ac, err := agentmap.Generate(ctx, wl, gc)
+	managerutil.ForEachWorkload(ctx, "Configured", len(affectedWorkloads), func(ctx context.Context, i int) error {
+		ac, err := agentmap.Generate(ctx, affectedWorkloads[i], gc)
 		if err == nil {
 			err = c.Store(ctx, ac, false)
 		}
-		if err != nil {
-			dlog.Warn(ctx, err)
-		}
-	}
+		return err
+	})
 }
@@ -0,0 +1,116 @@
+package mutator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apps "k8s.io/api/apps/v1"
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+func redirectedPod(name string, ready bool, portNames ...string) *core.Pod {
+	pod := readyPod(name)
+	if !ready {
+		pod.Status.Conditions = nil
+	}
+	cn := core.Container{Name: agentconfig.ContainerName}
+	for _, pn := range portNames {
+		cn.Ports = append(cn.Ports, core.ContainerPort{Name: pn})
+	}
+	pod.Spec.Containers = []core.Container{{Name: "echo"}, cn}
+	return pod
+}
+
+func TestWaitForRedirectedPorts(t *testing.T) {
+	defer func(d time.Duration) { evictionRetryInterval = d }(evictionRetryInterval)
+	evictionRetryInterval = 10 * time.Millisecond
+
+	ac := &agentconfig.Sidecar{
+		WorkloadName: "echo",
+		WorkloadKind: "Deployment",
+		Namespace:    "default",
+		Containers: []*agentconfig.Container{{
+			Name:       "echo",
+			Intercepts: []*agentconfig.Intercept{{ServiceName: "echo", ContainerPort: 8080, ServiceRedirect: true}},
+		}},
+	}
+	portName := agentconfig.RedirectedPortName(8080)
+	deploy := &apps.Deployment{
+		ObjectMeta: meta.ObjectMeta{Name: "echo", Namespace: "default"},
+		Spec:       apps.DeploymentSpec{Selector: &meta.LabelSelector{MatchLabels: map[string]string{"app": "echo"}}},
+	}
+
+	tests := []struct {
+		name  string
+		pods  []*core.Pod
+		ready bool
+	}{
+		{"all ready with port", []*core.Pod{redirectedPod("echo-1", true, portName), redirectedPod("echo-2", true, portName)}, true},
+		{"old pod remains", []*core.Pod{redirectedPod("echo-1", true, portName), readyPod("echo-2")}, false},
+		{"new pod not ready", []*core.Pod{redirectedPod("echo-1", false, portName)}, false},
+		{"agent without port", []*core.Pod{redirectedPod("echo-1", true)}, false},
+		{"no pods", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := fake.NewSimpleClientset(deploy)
+			for _, pod := range tt.pods {
+				require.NoError(t, cs.Tracker().Add(pod))
+			}
+			ctx, cancel := context.WithTimeout(k8sapi.WithK8sInterface(dlog.NewTestContext(t, false), cs), 100*time.Millisecond)
+			defer cancel()
+			err := waitForRedirectedPorts(ctx, ac)
+			if tt.ready {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, context.DeadlineExceeded)
+			}
+		})
+	}
+}
+
+func TestTriggerRollout_job(t *testing.T) {
+	job := func(annotations map[string]string) *batch.Job {
+		return &batch.Job{
+			ObjectMeta: meta.ObjectMeta{Name: "report", Namespace: "default"},
+			Spec: batch.JobSpec{
+				Selector: &meta.LabelSelector{MatchLabels: map[string]string{"job-name": "report"}},
+				Template: core.PodTemplateSpec{ObjectMeta: meta.ObjectMeta{Annotations: annotations}},
+			},
+		}
+	}
+	pod := &core.Pod{
+		ObjectMeta: meta.ObjectMeta{Name: "report-x1", Namespace: "default", Labels: map[string]string{"job-name": "report"}},
+		Status:     core.PodStatus{Phase: core.PodRunning},
+	}
+	evictions := func(cs *fake.Clientset) int {
+		n := 0
+		for _, a := range cs.Actions() {
+			if a.GetVerb() == "create" && a.GetSubresource() == "eviction" {
+				n++
+			}
+		}
+		return n
+	}
+
+	// The running pods of a Job are left alone unless it opts in
+	j := job(nil)
+	cs := fake.NewSimpleClientset(j, pod.DeepCopy())
+	triggerRollout(k8sapi.WithK8sInterface(dlog.NewTestContext(t, false), cs), k8sapi.Job(j))
+	assert.Equal(t, 0, evictions(cs))
+
+	j = job(map[string]string{agentconfig.RestartJobPodsAnnotation: "true"})
+	cs = fake.NewSimpleClientset(j, pod.DeepCopy())
+	triggerRollout(k8sapi.WithK8sInterface(dlog.NewTestContext(t, false), cs), k8sapi.Job(j))
+	assert.Equal(t, 1, evictions(cs))
+}
@@ -0,0 +1,279 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	autoscaling "k8s.io/api/autoscaling/v1"
+	errors2 "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/datawire/dlib/dlog"
+	managerrpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+const (
+	// pauseAutoscalersAnnotation is a pod template annotation, "true" or "false", that determines if the
+	// autoscalers of a workload are paused while it's intercepted. It takes precedence over the setting of the
+	// traffic-manager.
+	pauseAutoscalersAnnotation = agentconfig.DomainPrefix + "pause-autoscalers"
+
+	// pausedAutoscalerAnnotation is the annotation of an autoscaler that is paused by intercepts. Its value is the
+	// JSON of the part of the autoscaler spec that was changed when it was paused.
+	pausedAutoscalerAnnotation = agentconfig.DomainPrefix + "paused-autoscaler"
+)
+
+var vpaGVR = schema.GroupVersionResource{Group: "autoscaling.k8s.io", Version: "v1", Resource: "verticalpodautoscalers"}
+
+// shouldPauseAutoscalers returns true if the autoscalers of the given workload are paused while it's intercepted.
+func shouldPauseAutoscalers(ctx context.Context, wl k8sapi.Workload) bool {
+	if a, ok := wl.GetPodTemplate().Annotations[pauseAutoscalersAnnotation]; ok {
+		b, err := strconv.ParseBool(a)
+		if err == nil {
+			return b
+		}
+		dlog.Errorf(ctx, "invalid %s annotation %q of %s %s.%s", pauseAutoscalersAnnotation, a, wl.GetKind(), wl.GetName(), wl.GetNamespace())
+	}
+	return managerutil.GetEnv(ctx).PauseAutoscalers
+}
+
+// pauseAutoscalers pauses the HorizontalPodAutoscalers and VerticalPodAutoscalers that target the given workload, so
+// that the rollout of the traffic-agent doesn't cause scale events. A HorizontalPodAutoscaler is paused by fixing its
+// minimum and maximum number of replicas to the current number, and a VerticalPodAutoscaler by turning its updates
+// off. Autoscalers that are already paused by other intercepts are left as they are.
+func pauseAutoscalers(ctx context.Context, wl k8sapi.Workload) error {
+	ns := wl.GetNamespace()
+	api := k8sapi.GetK8sInterface(ctx).AutoscalingV1().HorizontalPodAutoscalers(ns)
+	hpas, err := api.List(ctx, meta.ListOptions{})
+	if err != nil {
+		if !errors2.IsForbidden(err) {
+			return fmt.Errorf("unable to list the HorizontalPodAutoscalers in namespace %s: %w", ns, err)
+		}
+		dlog.Debugf(ctx, "not permitted to list the HorizontalPodAutoscalers in namespace %s", ns)
+	} else {
+		for i := range hpas.Items {
+			hpa := &hpas.Items[i]
+			tr := &hpa.Spec.ScaleTargetRef
+			if !targets(wl, tr.Kind, tr.Name) || isPaused(hpa.Annotations) {
+				continue
+			}
+			orig, err := json.Marshal(map[string]any{"minReplicas": hpa.Spec.MinReplicas, "maxReplicas": hpa.Spec.MaxReplicas})
+			if err != nil {
+				return err
+			}
+			n := hpa.Status.CurrentReplicas
+			if n < 1 {
+				n = 1
+			}
+			if n > hpa.Spec.MaxReplicas {
+				n = hpa.Spec.MaxReplicas
+			}
+			dlog.Infof(ctx, "Pausing HorizontalPodAutoscaler %s.%s at %d replicas", hpa.Name, ns, n)
+			patch := fmt.Sprintf(`{"metadata": {"annotations": {%q: %q}}, "spec": {"minReplicas": %d, "maxReplicas": %d}}`,
+				pausedAutoscalerAnnotation, orig, n, n)
+			if _, err = api.Patch(ctx, hpa.Name, types.MergePatchType, []byte(patch), meta.PatchOptions{}); err != nil {
+				return fmt.Errorf("unable to pause HorizontalPodAutoscaler %s.%s: %w", hpa.Name, ns, err)
+			}
+		}
+	}
+
+	return eachVPA(ctx, wl, func(vpa *unstructured.Unstructured) error {
+		if isPaused(vpa.GetAnnotations()) {
+			return nil
+		}
+		var mode any
+		if m, ok, _ := unstructured.NestedString(vpa.Object, "spec", "updatePolicy", "updateMode"); ok {
+			mode = m
+		}
+		orig, err := json.Marshal(map[string]any{"updatePolicy": map[string]any{"updateMode": mode}})
+		if err != nil {
+			return err
+		}
+		dlog.Infof(ctx, "Pausing VerticalPodAutoscaler %s.%s", vpa.GetName(), ns)
+		patch := fmt.Sprintf(`{"metadata": {"annotations": {%q: %q}}, "spec": {"updatePolicy": {"updateMode": "Off"}}}`,
+			pausedAutoscalerAnnotation, orig)
+		if _, err = k8sapi.GetDynamicInterface(ctx).Resource(vpaGVR).Namespace(ns).Patch(
+			ctx, vpa.GetName(), types.MergePatchType, []byte(patch), meta.PatchOptions{}); err != nil {
+			return fmt.Errorf("unable to pause VerticalPodAutoscaler %s.%s: %w", vpa.GetName(), ns, err)
+		}
+		return nil
+	})
+}
+
+// ResumeAutoscalers is an InterceptFinalizer that resumes the autoscalers that were paused for the workload of the
+// given intercept, unless other intercepts of the workload remain.
+func (s *State) ResumeAutoscalers(ctx context.Context, ii *managerrpc.InterceptInfo) error {
+	return s.finalizeWorkload(ctx, ii, nil, resumeAutoscalers)
+}
+
+// resumeAutoscalers restores the settings of the paused autoscalers that target the given workload.
+func resumeAutoscalers(ctx context.Context, wl k8sapi.Workload) error {
+	ns := wl.GetNamespace()
+	api := k8sapi.GetK8sInterface(ctx).AutoscalingV1().HorizontalPodAutoscalers(ns)
+	hpas, err := api.List(ctx, meta.ListOptions{})
+	if err != nil {
+		if !errors2.IsForbidden(err) {
+			return fmt.Errorf("unable to list the HorizontalPodAutoscalers in namespace %s: %w", ns, err)
+		}
+	} else {
+		for i := range hpas.Items {
+			hpa := &hpas.Items[i]
+			tr := &hpa.Spec.ScaleTargetRef
+			if !targets(wl, tr.Kind, tr.Name) {
+				continue
+			}
+			if err = resumeHPA(ctx, hpa); err != nil {
+				return err
+			}
+		}
+	}
+	return eachVPA(ctx, wl, func(vpa *unstructured.Unstructured) error {
+		return resumeVPA(ctx, vpa)
+	})
+}
+
+// ResumeOrphanedAutoscalers resumes the paused autoscalers whose targets have no intercepts. The intercepts don't
+// survive a restart of the traffic-manager, so this is called on startup to resume the autoscalers that it paused
+// before it was restarted. An autoscaler of a workload that is intercepted again is paused again.
+func (s *State) ResumeOrphanedAutoscalers(ctx context.Context) {
+	namespaces := managerutil.GetEnv(ctx).GetManagedNamespaces()
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+	for _, ns := range namespaces {
+		hpas, err := k8sapi.GetK8sInterface(ctx).AutoscalingV1().HorizontalPodAutoscalers(ns).List(ctx, meta.ListOptions{})
+		if err != nil {
+			if !errors2.IsForbidden(err) {
+				dlog.Errorf(ctx, "unable to list the HorizontalPodAutoscalers in namespace %q: %v", ns, err)
+			}
+		} else {
+			for i := range hpas.Items {
+				hpa := &hpas.Items[i]
+				if isPaused(hpa.Annotations) && !s.isIntercepted("", hpa.Spec.ScaleTargetRef.Name, hpa.Namespace) {
+					if err = resumeHPA(ctx, hpa); err != nil {
+						dlog.Error(ctx, err)
+					}
+				}
+			}
+		}
+
+		di := k8sapi.GetDynamicInterface(ctx)
+		if di == nil {
+			continue
+		}
+		vpas, err := di.Resource(vpaGVR).Namespace(ns).List(ctx, meta.ListOptions{})
+		if err != nil {
+			if !(errors2.IsNotFound(err) || errors2.IsForbidden(err)) {
+				dlog.Errorf(ctx, "unable to list the VerticalPodAutoscalers in namespace %q: %v", ns, err)
+			}
+			continue
+		}
+		for i := range vpas.Items {
+			vpa := &vpas.Items[i]
+			name, _, _ := unstructured.NestedString(vpa.Object, "spec", "targetRef", "name")
+			if isPaused(vpa.GetAnnotations()) && !s.isIntercepted("", name, vpa.GetNamespace()) {
+				if err = resumeVPA(ctx, vpa); err != nil {
+					dlog.Error(ctx, err)
+				}
+			}
+		}
+	}
+}
+
+// resumeHPA restores the settings of the given HorizontalPodAutoscaler if it's paused.
+func resumeHPA(ctx context.Context, hpa *autoscaling.HorizontalPodAutoscaler) error {
+	patch, ok := resumePatch(ctx, hpa.Annotations)
+	if !ok {
+		return nil
+	}
+	dlog.Infof(ctx, "Resuming HorizontalPodAutoscaler %s.%s", hpa.Name, hpa.Namespace)
+	api := k8sapi.GetK8sInterface(ctx).AutoscalingV1().HorizontalPodAutoscalers(hpa.Namespace)
+	if _, err := api.Patch(ctx, hpa.Name, types.MergePatchType, patch, meta.PatchOptions{}); err != nil {
+		return fmt.Errorf("unable to resume HorizontalPodAutoscaler %s.%s: %w", hpa.Name, hpa.Namespace, err)
+	}
+	return nil
+}
+
+// resumeVPA restores the settings of the given VerticalPodAutoscaler if it's paused.
+func resumeVPA(ctx context.Context, vpa *unstructured.Unstructured) error {
+	patch, ok := resumePatch(ctx, vpa.GetAnnotations())
+	if !ok {
+		return nil
+	}
+	ns := vpa.GetNamespace()
+	dlog.Infof(ctx, "Resuming VerticalPodAutoscaler %s.%s", vpa.GetName(), ns)
+	if _, err := k8sapi.GetDynamicInterface(ctx).Resource(vpaGVR).Namespace(ns).Patch(
+		ctx, vpa.GetName(), types.MergePatchType, patch, meta.PatchOptions{}); err != nil {
+		return fmt.Errorf("unable to resume VerticalPodAutoscaler %s.%s: %w", vpa.GetName(), ns, err)
+	}
+	return nil
+}
+
+// eachVPA calls f for each VerticalPodAutoscaler that targets the given workload. Clusters that don't have the
+// VerticalPodAutoscaler resource, or that don't permit it to be listed, have no VerticalPodAutoscalers.
+func eachVPA(ctx context.Context, wl k8sapi.Workload, f func(*unstructured.Unstructured) error) error {
+	di := k8sapi.GetDynamicInterface(ctx)
+	if di == nil {
+		return nil
+	}
+	ns := wl.GetNamespace()
+	vpas, err := di.Resource(vpaGVR).Namespace(ns).List(ctx, meta.ListOptions{})
+	if err != nil {
+		if errors2.IsNotFound(err) || errors2.IsForbidden(err) {
+			return nil
+		}
+		return fmt.Errorf("unable to list the VerticalPodAutoscalers in namespace %s: %w", ns, err)
+	}
+	for i := range vpas.Items {
+		vpa := &vpas.Items[i]
+		kind, _, _ := unstructured.NestedString(vpa.Object, "spec", "targetRef", "kind")
+		name, _, _ := unstructured.NestedString(vpa.Object, "spec", "targetRef", "name")
+		if targets(wl, kind, name) {
+			if err = f(vpa); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func targets(wl k8sapi.Workload, kind, name string) bool {
+	return kind == wl.GetKind() && name == wl.GetName()
+}
+
+func isPaused(annotations map[string]string) bool {
+	_, ok := annotations[pausedAutoscalerAnnotation]
+	return ok
+}
+
+// resumePatch returns the merge patch that restores the spec stored in the pausedAutoscalerAnnotation and removes
+// the annotation, or false if there is no such annotation.
+func resumePatch(ctx context.Context, annotations map[string]string) ([]byte, bool) {
+	orig, ok := annotations[pausedAutoscalerAnnotation]
+	if !ok {
+		return nil, false
+	}
+	rp := map[string]any{
+		"metadata": map[string]any{"annotations": map[string]any{pausedAutoscalerAnnotation: nil}},
+	}
+	var spec map[string]any
+	if err := json.Unmarshal([]byte(orig), &spec); err != nil || spec == nil {
+		// The annotation is removed, so that the autoscaler can be paused again.
+		dlog.Errorf(ctx, "invalid %s annotation %q", pausedAutoscalerAnnotation, orig)
+	} else {
+		rp["spec"] = spec
+	}
+	patch, err := json.Marshal(rp)
+	if err != nil {
+		return nil, false
+	}
+	return patch, true
+}
@@ -0,0 +1,145 @@
+package state
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apps "k8s.io/api/apps/v1"
+	autoscaling "k8s.io/api/autoscaling/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicFake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/datawire/dlib/dlog"
+	managerrpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+func int32P(i int32) *int32 {
+	return &i
+}
+
+func autoscalerContext(t *testing.T) context.Context {
+	dep := &apps.Deployment{
+		TypeMeta:   meta.TypeMeta{Kind: "Deployment", APIVersion: "apps/v1"},
+		ObjectMeta: meta.ObjectMeta{Name: "echo", Namespace: "default"},
+	}
+	hpa := func(name, target string) *autoscaling.HorizontalPodAutoscaler {
+		return &autoscaling.HorizontalPodAutoscaler{
+			ObjectMeta: meta.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: autoscaling.HorizontalPodAutoscalerSpec{
+				ScaleTargetRef: autoscaling.CrossVersionObjectReference{Kind: "Deployment", Name: target, APIVersion: "apps/v1"},
+				MinReplicas:    int32P(2),
+				MaxReplicas:    10,
+			},
+			Status: autoscaling.HorizontalPodAutoscalerStatus{CurrentReplicas: 3},
+		}
+	}
+	vpa := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "autoscaling.k8s.io/v1",
+		"kind":       "VerticalPodAutoscaler",
+		"metadata":   map[string]any{"name": "echo", "namespace": "default"},
+		"spec": map[string]any{
+			"targetRef": map[string]any{"apiVersion": "apps/v1", "kind": "Deployment", "name": "echo"},
+		},
+	}}
+	di := dynamicFake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{vpaGVR: "VerticalPodAutoscalerList"}, vpa)
+	ctx := k8sapi.WithK8sInterface(dlog.NewTestContext(t, false), fake.NewSimpleClientset(dep, hpa("echo", "echo"), hpa("other", "other")))
+	return k8sapi.WithDynamicInterface(ctx, di)
+}
+
+func getHPA(ctx context.Context, t *testing.T, name string) *autoscaling.HorizontalPodAutoscaler {
+	hpa, err := k8sapi.GetK8sInterface(ctx).AutoscalingV1().HorizontalPodAutoscalers("default").Get(ctx, name, meta.GetOptions{})
+	require.NoError(t, err)
+	return hpa
+}
+
+func getVPAMode(ctx context.Context, t *testing.T) (string, bool) {
+	vpa, err := k8sapi.GetDynamicInterface(ctx).Resource(vpaGVR).Namespace("default").Get(ctx, "echo", meta.GetOptions{})
+	require.NoError(t, err)
+	mode, ok, err := unstructured.NestedString(vpa.Object, "spec", "updatePolicy", "updateMode")
+	require.NoError(t, err)
+	return mode, ok
+}
+
+func TestPauseAutoscalers(t *testing.T) {
+	ctx := autoscalerContext(t)
+	s := NewState(ctx)
+	wl, err := k8sapi.GetWorkload(ctx, "echo", "default", "Deployment")
+	require.NoError(t, err)
+
+	require.NoError(t, pauseAutoscalers(ctx, wl))
+	hpa := getHPA(ctx, t, "echo")
+	assert.Equal(t, int32(3), *hpa.Spec.MinReplicas)
+	assert.Equal(t, int32(3), hpa.Spec.MaxReplicas)
+	assert.Equal(t, int32(2), *getHPA(ctx, t, "other").Spec.MinReplicas, "an autoscaler of another workload is paused")
+	mode, _ := getVPAMode(ctx, t)
+	assert.Equal(t, "Off", mode)
+
+	// Pausing again doesn't overwrite the stored settings
+	require.NoError(t, pauseAutoscalers(ctx, wl))
+
+	ii := func(id string) *managerrpc.InterceptInfo {
+		return &managerrpc.InterceptInfo{Id: id, Spec: &managerrpc.InterceptSpec{
+			Name: id, Agent: "echo", Namespace: "default", WorkloadKind: "Deployment",
+		}}
+	}
+	s.intercepts.Store("b", ii("b"))
+	require.NoError(t, s.ResumeAutoscalers(ctx, ii("a")))
+	assert.Equal(t, int32(3), getHPA(ctx, t, "echo").Spec.MaxReplicas, "resumed while another intercept remains")
+
+	s.intercepts.Delete("b")
+	require.NoError(t, s.ResumeAutoscalers(ctx, ii("b")))
+	hpa = getHPA(ctx, t, "echo")
+	assert.Equal(t, int32(2), *hpa.Spec.MinReplicas)
+	assert.Equal(t, int32(10), hpa.Spec.MaxReplicas)
+	assert.NotContains(t, hpa.Annotations, pausedAutoscalerAnnotation)
+	_, ok := getVPAMode(ctx, t)
+	assert.False(t, ok)
+}
+
+func TestShouldPauseAutoscalers(t *testing.T) {
+	ctx := autoscalerContext(t)
+	wl, err := k8sapi.GetWorkload(ctx, "echo", "default", "Deployment")
+	require.NoError(t, err)
+	ctx = managerutil.WithEnv(ctx, &managerutil.Env{PauseAutoscalers: true})
+	assert.True(t, shouldPauseAutoscalers(ctx, wl))
+
+	wl.GetPodTemplate().Annotations = map[string]string{pauseAutoscalersAnnotation: "false"}
+	assert.False(t, shouldPauseAutoscalers(ctx, wl))
+}
+
+func TestResumeOrphanedAutoscalers(t *testing.T) {
+	ctx := managerutil.WithEnv(autoscalerContext(t), &managerutil.Env{})
+	s := NewState(ctx)
+	wl, err := k8sapi.GetWorkload(ctx, "echo", "default", "Deployment")
+	require.NoError(t, err)
+	require.NoError(t, pauseAutoscalers(ctx, wl))
+
+	// The autoscalers of an intercepted workload remain paused
+	s.intercepts.Store("a", &managerrpc.InterceptInfo{Id: "a", Spec: &managerrpc.InterceptSpec{
+		Name: "a", Agent: "echo", Namespace: "default", WorkloadKind: "Deployment",
+	}})
+	s.ResumeOrphanedAutoscalers(ctx)
+	assert.Equal(t, int32(3), getHPA(ctx, t, "echo").Spec.MaxReplicas)
+	mode, _ := getVPAMode(ctx, t)
+	assert.Equal(t, "Off", mode)
+
+	// and are resumed when no intercept remains, e.g. after a restart of the traffic-manager
+	s.intercepts.Delete("a")
+	s.ResumeOrphanedAutoscalers(ctx)
+	hpa := getHPA(ctx, t, "echo")
+	assert.Equal(t, int32(2), *hpa.Spec.MinReplicas)
+	assert.Equal(t, int32(10), hpa.Spec.MaxReplicas)
+	assert.NotContains(t, hpa.Annotations, pausedAutoscalerAnnotation)
+	_, ok := getVPAMode(ctx, t)
+	assert.False(t, ok)
+	assert.Equal(t, int32(2), *getHPA(ctx, t, "other").Spec.MinReplicas, "an autoscaler that isn't paused is changed")
+}
@@ -0,0 +1,197 @@
+package state
+
+import (
+	"context"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+	core "k8s.io/api/core/v1"
+	errors2 "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/datawire/dlib/dlog"
+	managerrpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+	"github.com/telepresenceio/telepresence/v2/pkg/agentmap"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+// attachEphemeralAgents adds the traffic-agent to the running pods of the given workload as an ephemeral container,
+// so that the pods can be intercepted without being restarted. The agent config is generated but not stored in the
+// agents ConfigMap, because storing it would roll out the workload. It's stored in the EphemeralAgentConfigMap
+// together with the UIDs of the pods instead, and the traffic-node-agent on the node of each pod then redirects the
+// intercepted ports to the agent once it runs.
+//
+// Pods that already have an ephemeral agent keep it. The config of a workload that already has an entry in the
+// ConfigMap is returned as is, because its pods get the agent injected anyway.
+func (s *State) attachEphemeralAgents(ctx context.Context, wl k8sapi.Workload, extended bool) (*agentconfig.Sidecar, error) {
+	if !managerutil.GetEnv(ctx).EphemeralAgents {
+		return nil, errcat.User.New("ephemeral intercepts are not enabled in the traffic-manager. " +
+			"They are enabled by the ephemeralAgents.enabled Helm chart value")
+	}
+	if ac, err := s.injectedAgentConfig(ctx, wl, extended); ac != nil || err != nil {
+		return ac, err
+	}
+	ns := wl.GetNamespace()
+	ac, err := s.volatileAgentConfig(ctx, wl, extended)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := runningPods(ctx, wl)
+	if err != nil {
+		return nil, err
+	}
+	if len(pods) == 0 {
+		return nil, errcat.User.Newf("%s %s.%s has no running pods to add an ephemeral traffic-agent to", wl.GetKind(), wl.GetName(), ns)
+	}
+	config, err := agentconfig.EncodeEphemeralConfig(ac)
+	if err != nil {
+		return nil, err
+	}
+	ea := agentconfig.EphemeralAgents{Config: config}
+	for _, pod := range pods {
+		if err = attachEphemeralAgent(ctx, pod.Name, ns, ac); err != nil {
+			return nil, err
+		}
+		ea.Pods = append(ea.Pods, string(pod.UID))
+	}
+	data, err := yaml.Marshal(&ea)
+	if err != nil {
+		return nil, err
+	}
+	if err = updateManagerConfigMap(ctx, agentconfig.EphemeralAgentConfigMap, agentconfig.NodeAgentKey(wl.GetName(), ns), string(data)); err != nil {
+		return nil, err
+	}
+	return ac, nil
+}
+
+// injectedAgentConfig returns the config of the given workload if the workload has an entry in the agents ConfigMap,
+// because its pods get the traffic-agent injected anyway. It returns nil when the workload has no such entry.
+func (s *State) injectedAgentConfig(ctx context.Context, wl k8sapi.Workload, extended bool) (*agentconfig.Sidecar, error) {
+	ns := wl.GetNamespace()
+	cm, err := k8sapi.GetK8sInterface(ctx).CoreV1().ConfigMaps(ns).Get(ctx, agentconfig.ConfigMap, meta.GetOptions{})
+	if err != nil {
+		if errors2.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get ConfigMap %s.%s: %w", agentconfig.ConfigMap, ns, err)
+	}
+	if _, ok := cm.Data[wl.GetName()]; !ok {
+		return nil, nil
+	}
+	dlog.Debugf(ctx, "%s %s.%s has an injected traffic-agent, so its running pods get no additional agents", wl.GetKind(), wl.GetName(), ns)
+	return s.getOrCreateAgentConfig(ctx, wl, extended)
+}
+
+// volatileAgentConfig generates the agent config of the given workload for traffic-agents that are added to its
+// running pods. The config isn't stored in the agents ConfigMap, because storing it would roll out the workload.
+func (s *State) volatileAgentConfig(ctx context.Context, wl k8sapi.Workload, extended bool) (*agentconfig.Sidecar, error) {
+	manuallyManaged, enabled, err := checkInterceptAnnotations(wl)
+	if err != nil {
+		return nil, err
+	}
+	if manuallyManaged || !enabled {
+		return nil, errcat.User.Newf("%s %s.%s is not interceptable", wl.GetKind(), wl.GetName(), wl.GetNamespace())
+	}
+	agentImage, err := s.qualifiedAgentImage(ctx, extended)
+	if err != nil {
+		return nil, err
+	}
+	ac, err := agentmap.Generate(ctx, wl, managerutil.GetEnv(ctx).GeneratorConfig(agentImage))
+	if err != nil {
+		return nil, err
+	}
+	adaptVolatileAgentConfig(ac)
+	return ac, nil
+}
+
+// adaptVolatileAgentConfig adapts the given generated config to a traffic-agent that is added to a running pod. The
+// traffic of all intercepted ports is redirected by the traffic-node-agent, so no service is redirected, and TLS isn't
+// terminated, because the pod has no volumes with the certificates. The agent doesn't share the PID namespace of the
+// app containers, so it can't terminate with them.
+func adaptVolatileAgentConfig(ac *agentconfig.Sidecar) {
+	ac.TerminatingTLS = ""
+	ac.OriginatingTLS = ""
+	ac.TerminateWithApp = false
+	for _, cn := range ac.Containers {
+		for _, ic := range cn.Intercepts {
+			ic.ServiceRedirect = false
+		}
+	}
+}
+
+// runningPods returns the pods of the given workload that are running and not terminating.
+func runningPods(ctx context.Context, wl k8sapi.Workload) ([]*core.Pod, error) {
+	sel, err := wl.Selector()
+	if err != nil {
+		return nil, err
+	}
+	pods, err := k8sapi.GetK8sInterface(ctx).CoreV1().Pods(wl.GetNamespace()).List(ctx, meta.ListOptions{LabelSelector: sel.String()})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the pods of %s %s.%s: %w", wl.GetKind(), wl.GetName(), wl.GetNamespace(), err)
+	}
+	var running []*core.Pod
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.DeletionTimestamp == nil && pod.Status.Phase == core.PodRunning {
+			running = append(running, pod)
+		}
+	}
+	return running, nil
+}
+
+// attachEphemeralAgent adds the traffic-agent of the given config to the named pod as an ephemeral container, unless
+// the pod has one already. An ephemeral container can't be changed, so an agent that the pod has must have the given
+// config.
+func attachEphemeralAgent(ctx context.Context, name, namespace string, ac *agentconfig.Sidecar) error {
+	api := k8sapi.GetK8sInterface(ctx).CoreV1().Pods(namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pod, err := api.Get(ctx, name, meta.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("unable to get pod %s.%s: %w", name, namespace, err)
+		}
+		if config, ok := agentconfig.EphemeralAgentConfig(pod); ok {
+			if want, err := agentconfig.EncodeEphemeralConfig(ac); err != nil || config == want {
+				return err
+			}
+			return errcat.User.Newf("pod %s.%s has an ephemeral traffic-agent with another config. "+
+				"An ephemeral container can't be changed, so the pod must be restarted", name, namespace)
+		}
+		for i := range pod.Spec.Containers {
+			if pod.Spec.Containers[i].Name == agentconfig.ContainerName {
+				return nil
+			}
+		}
+		ec, err := agentconfig.EphemeralAgentContainer(pod, ac)
+		if err != nil || ec == nil {
+			return err
+		}
+		pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, *ec)
+		if _, err = api.UpdateEphemeralContainers(ctx, name, pod, meta.UpdateOptions{}); err != nil {
+			if errors2.IsNotFound(err) {
+				return errcat.User.Newf("unable to add an ephemeral traffic-agent to pod %s.%s. "+
+					"Ephemeral containers require Kubernetes 1.23 or later: %w", name, namespace, err)
+			}
+			return fmt.Errorf("unable to add an ephemeral traffic-agent to pod %s.%s: %w", name, namespace, err)
+		}
+		dlog.Infof(ctx, "Added an ephemeral traffic-agent to pod %s.%s", name, namespace)
+		return nil
+	})
+}
+
+// ReleaseEphemeralAgents is an InterceptFinalizer that removes the entry of the workload of the given intercept from
+// the EphemeralAgentConfigMap, unless other intercepts of the workload remain. The traffic-node-agents then stop
+// redirecting the intercepted ports of the pods to their ephemeral traffic-agents.
+func (s *State) ReleaseEphemeralAgents(ctx context.Context, ii *managerrpc.InterceptInfo) error {
+	spec := ii.Spec
+	return s.finalizeWorkload(ctx, ii, func(ctx context.Context) error {
+		if err := updateManagerConfigMap(ctx, agentconfig.EphemeralAgentConfigMap, agentconfig.NodeAgentKey(spec.Agent, spec.Namespace), ""); err != nil {
+			return err
+		}
+		dlog.Infof(ctx, "Released the ephemeral traffic-agents of %s.%s", spec.Agent, spec.Namespace)
+		return nil
+	}, nil)
+}
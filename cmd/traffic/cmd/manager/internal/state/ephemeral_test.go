@@ -0,0 +1,100 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/datawire/dlib/dlog"
+	managerrpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+func TestAttachEphemeralAgent(t *testing.T) {
+	pod := &core.Pod{
+		ObjectMeta: meta.ObjectMeta{Name: "echo-1", Namespace: "default"},
+		Spec:       core.PodSpec{Containers: []core.Container{{Name: "echo"}}},
+		Status:     core.PodStatus{Phase: core.PodRunning},
+	}
+	ctx := k8sapi.WithK8sInterface(dlog.NewTestContext(t, false), fake.NewSimpleClientset(pod))
+	ac := &agentconfig.Sidecar{
+		AgentName:      "echo",
+		AgentImage:     "ghcr.io/telepresenceio/tel2:2.7.0",
+		Namespace:      "default",
+		TerminatingTLS: "echo-tls",
+		Containers: []*agentconfig.Container{{
+			Name: "echo",
+			Intercepts: []*agentconfig.Intercept{{
+				ContainerPort: 8080, AgentPort: 9900, Protocol: "TCP", TargetPortNumeric: true, ServiceRedirect: true,
+			}},
+		}},
+	}
+	adaptVolatileAgentConfig(ac)
+	assert.Empty(t, ac.TerminatingTLS)
+	assert.False(t, ac.Containers[0].Intercepts[0].ServiceRedirect)
+
+	// A second attach leaves the agent that the pod has as it is
+	require.NoError(t, attachEphemeralAgent(ctx, "echo-1", "default", ac))
+	require.NoError(t, attachEphemeralAgent(ctx, "echo-1", "default", ac))
+	pod, err := k8sapi.GetK8sInterface(ctx).CoreV1().Pods("default").Get(ctx, "echo-1", meta.GetOptions{})
+	require.NoError(t, err)
+	require.Len(t, pod.Spec.EphemeralContainers, 1)
+	ec := pod.Spec.EphemeralContainers[0]
+	assert.Equal(t, agentconfig.ContainerName, ec.Name)
+	assert.Empty(t, ec.Ports)
+	var hasConfig bool
+	for _, e := range ec.Env {
+		if e.Name == agentconfig.EnvAgentConfig {
+			hasConfig = true
+		}
+	}
+	assert.True(t, hasConfig, "the agent config is not in the environment")
+
+	// An agent with another config can't be changed
+	other := *ac
+	other.AgentName = "other"
+	err = attachEphemeralAgent(ctx, "echo-1", "default", &other)
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+}
+
+func TestAttachEphemeralAgents_disabled(t *testing.T) {
+	dep := &apps.Deployment{ObjectMeta: meta.ObjectMeta{Name: "echo", Namespace: "default"}}
+	ctx := k8sapi.WithK8sInterface(dlog.NewTestContext(t, false), fake.NewSimpleClientset(dep))
+	ctx = managerutil.WithEnv(ctx, &managerutil.Env{})
+	wl, err := k8sapi.GetWorkload(ctx, "echo", "default", "Deployment")
+	require.NoError(t, err)
+	_, err = NewState(ctx).attachEphemeralAgents(ctx, wl, false)
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+}
+
+func TestReleaseEphemeralAgents(t *testing.T) {
+	ctx := k8sapi.WithK8sInterface(dlog.NewTestContext(t, false), fake.NewSimpleClientset())
+	ctx = managerutil.WithEnv(ctx, &managerutil.Env{ManagerNamespace: "ambassador"})
+	api := k8sapi.GetK8sInterface(ctx).CoreV1().ConfigMaps("ambassador")
+	key := agentconfig.NodeAgentKey("echo", "default")
+	require.NoError(t, updateManagerConfigMap(ctx, agentconfig.EphemeralAgentConfigMap, key, "pods:\n- uid-1\nconfig: |\n  agentName: echo\n"))
+	require.NoError(t, updateManagerConfigMap(ctx, agentconfig.EphemeralAgentConfigMap, "other.default", "pods:\n- uid-2\n"))
+
+	ii := &managerrpc.InterceptInfo{
+		Id:   "00000000-0000-0000-0000-000000000000:echo",
+		Spec: &managerrpc.InterceptSpec{Agent: "echo", Namespace: "default", WorkloadKind: "Deployment", Ephemeral: true},
+	}
+	require.NoError(t, NewState(ctx).ReleaseEphemeralAgents(ctx, ii))
+	cm, err := api.Get(ctx, agentconfig.EphemeralAgentConfigMap, meta.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, cm.Data, key)
+	assert.Contains(t, cm.Data, "other.default")
+
+	// A workload that has been released has nothing to release
+	assert.NoError(t, NewState(ctx).ReleaseEphemeralAgents(ctx, ii))
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -18,6 +19,7 @@ import (
 	"k8s.io/apimachinery/pkg/watch"
 	typed "k8s.io/client-go/kubernetes/typed/core/v1"
 
+	"github.com/datawire/dlib/dcontext"
 	"github.com/datawire/dlib/dlog"
 	managerrpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
@@ -47,13 +49,30 @@ func (s *State) PrepareIntercept(ctx context.Context, cr *managerrpc.CreateInter
 	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
+	var paused, pinned k8sapi.Workload
 	interceptError := func(err error) (*managerrpc.PreparedIntercept, error) {
+		if paused != nil && !s.isIntercepted("", paused.GetName(), paused.GetNamespace()) {
+			// No intercept will resume the autoscalers that were paused for this one
+			if err := resumeAutoscalers(dcontext.WithoutCancel(ctx), paused); err != nil {
+				dlog.Error(ctx, err)
+			}
+		}
+		if pinned != nil && !s.isIntercepted("", pinned.GetName(), pinned.GetNamespace()) {
+			// No intercept will restore the minimum scale that was pinned for this one
+			if err := restoreMinScale(dcontext.WithoutCancel(ctx), pinned); err != nil {
+				dlog.Error(ctx, err)
+			}
+		}
 		if _, ok := status.FromError(err); ok {
 			return nil, err
 		}
 		return &managerrpc.PreparedIntercept{Error: err.Error(), ErrorCategory: int32(errcat.GetCategory(err))}, nil
 	}
 
+	if client := s.GetClient(cr.GetSession().GetSessionId()); client.GetConnectOnly() {
+		return nil, errConnectOnly(client.Name)
+	}
+
 	spec := cr.InterceptSpec
 	wl, err := k8sapi.GetWorkload(ctx, spec.Agent, spec.Namespace, spec.WorkloadKind)
 	if err != nil {
@@ -63,23 +82,74 @@ func (s *State) PrepareIntercept(ctx context.Context, cr *managerrpc.CreateInter
 		return interceptError(err)
 	}
 
-	ac, err := s.getOrCreateAgentConfig(ctx, wl, spec.Mechanism != "tcp")
+	if shouldPauseAutoscalers(ctx, wl) {
+		if err = pauseAutoscalers(ctx, wl); err != nil {
+			return interceptError(err)
+		}
+		paused = wl
+	}
+
+	var ac *agentconfig.Sidecar
+	switch {
+	case spec.Ephemeral:
+		if spec.Replace {
+			return interceptError(errcat.User.New("an ephemeral traffic-agent can't replace a container"))
+		}
+		ac, err = s.attachEphemeralAgents(ctx, wl, spec.Mechanism != "tcp")
+	case managerutil.GetEnv(ctx).AgentInjectionMode == agentconfig.NodeInjectionMode:
+		if spec.Replace {
+			return interceptError(errcat.User.New("a traffic-agent that the traffic-node-agent runs can't replace a container"))
+		}
+		ac, err = s.assignNodeAgents(ctx, wl, spec.Mechanism != "tcp")
+	default:
+		ac, err = s.getOrCreateAgentConfig(ctx, wl, spec.Mechanism != "tcp")
+	}
 	if err != nil {
 		return interceptError(err)
 	}
-	_, ic, err := findIntercept(ac, spec)
+	cn, ic, err := findIntercept(ac, spec)
 	if err != nil {
 		return interceptError(err)
 	}
-	if err = s.waitForAgent(ctx, ac.AgentName, ac.Namespace); err != nil {
+	if spec.Mechanism == "http" && ic.IsOpaque() {
+		return interceptError(errcat.User.Newf(
+			"the application protocol of port %d of service %s.%s is %s, so its HTTP headers can't be matched. "+
+				"Use an intercept without HTTP filters instead", ic.ServicePort, ic.ServiceName, ac.Namespace, ic.AppProtocol))
+	}
+	if spec.Replace && !cn.Replace {
+		if ac, err = s.replaceContainer(ctx, wl, ac, cn.Name); err != nil {
+			return interceptError(err)
+		}
+		if _, ic, err = findIntercept(ac, spec); err != nil {
+			return interceptError(err)
+		}
+	}
+	if msa := minScaleToPin(ctx, wl, spec.PinScale); msa != "" {
+		// The undo is in place before the workload is pinned, so that a pin that fails half-way is undone too.
+		pinned = wl
+		if err = pinMinScale(ctx, wl, msa); err != nil {
+			return interceptError(err)
+		}
+	}
+	if k8sapi.IsBatchKind(wl.GetKind()) || spec.NoRolloutWait {
+		// The pods of a Job or CronJob come and go, and there might not be any pods when the intercept is
+		// created. The intercept is held until an agent arrives, and it's kept when the agent goes away.
+		// An intercept that is created without waiting for the rollout is held in the same way.
+		dlog.Debugf(ctx, "Not waiting for an agent of %s %s.%s", wl.GetKind(), wl.GetName(), wl.GetNamespace())
+	} else if err = s.waitForAgent(ctx, ac.AgentName, ac.Namespace); err != nil {
 		return interceptError(err)
 	}
+	portName, port := ic.ServicePortName, ic.ServicePort
+	if ic.ServiceName == "" {
+		// The intercept has no service, so the container port identifies it.
+		portName, port = ic.ContainerPortName, ic.ContainerPort
+	}
 	return &managerrpc.PreparedIntercept{
 		Namespace:       spec.Namespace,
 		ServiceUid:      string(ic.ServiceUID),
 		ServiceName:     ic.ServiceName,
-		ServicePortName: ic.ServicePortName,
-		ServicePort:     int32(ic.ServicePort),
+		ServicePortName: portName,
+		ServicePort:     int32(port),
 		AgentImage:      ac.AgentImage,
 		WorkloadKind:    ac.WorkloadKind,
 	}, nil
@@ -202,7 +272,9 @@ func (s *State) loadAgentConfig(
 		}
 		if ac.Create {
 			// This may happen if someone else is doing the initial intercept at the exact (well, more or less) same time
-			if ac, err = waitForConfigMapUpdate(ctx, cmAPI, wl.GetName(), wl.GetNamespace()); err != nil {
+			if ac, err = waitForConfigMapUpdate(ctx, cmAPI, wl.GetName(), wl.GetNamespace(), func(conf *agentconfig.Sidecar) bool {
+				return !conf.Create
+			}); err != nil {
 				return nil, err
 			}
 		}
@@ -281,8 +353,13 @@ func checkInterceptAnnotations(wl k8sapi.Workload) (bool, bool, error) {
 }
 
 // Wait for the cluster's mutating webhook injector to do its magic. It will update the
-// configMap once it's done.
-func waitForConfigMapUpdate(ctx context.Context, cmAPI typed.ConfigMapInterface, agentName, namespace string) (*agentconfig.Sidecar, error) {
+// configMap once it's done. The entry is returned when it satisfies the given done function.
+func waitForConfigMapUpdate(
+	ctx context.Context,
+	cmAPI typed.ConfigMapInterface,
+	agentName, namespace string,
+	done func(*agentconfig.Sidecar) bool,
+) (*agentconfig.Sidecar, error) {
 	wi, err := cmAPI.Watch(ctx, meta.SingleObject(meta.ObjectMeta{
 		Name:      agentconfig.ConfigMap,
 		Namespace: namespace,
@@ -315,7 +392,7 @@ func waitForConfigMapUpdate(ctx context.Context, cmAPI typed.ConfigMapInterface,
 					if err != nil {
 						return nil, err
 					}
-					if !conf.Create {
+					if done(conf) {
 						return conf, nil
 					}
 				}
@@ -358,74 +435,191 @@ func unmarshalConfigMapEntry(y string, name, namespace string) (*agentconfig.Sid
 	return &conf, nil
 }
 
-// findIntercept finds the intercept configuration that matches the given InterceptSpec's service/service port
+// findIntercept finds the intercept configuration that matches the given InterceptSpec's service/service port.
+// The ServicePortIdentifier is first matched against the name and number of the service ports. If no service port
+// matches, it's matched against the name and number of the container ports that the service ports target.
 func findIntercept(ac *agentconfig.Sidecar, spec *managerrpc.InterceptSpec) (foundCN *agentconfig.Container, foundIC *agentconfig.Intercept, err error) {
 	spi := spec.ServicePortIdentifier
+	var candidates []containerIntercept
 	for _, cn := range ac.Containers {
 		for _, ic := range cn.Intercepts {
-			if !(spec.ServiceName == "" || spec.ServiceName == ic.ServiceName) {
-				continue
-			}
-			if !(spi == "" || agentconfig.IsInterceptFor(spi, ic)) {
-				continue
+			if spec.ServiceName == "" || spec.ServiceName == ic.ServiceName {
+				candidates = append(candidates, containerIntercept{cn, ic})
 			}
-			if foundIC == nil {
-				foundCN = cn
-				foundIC = ic
-				continue
-			}
-			var msg string
-			switch {
-			case spec.ServiceName == "" && spi == "":
-				msg = fmt.Sprintf("%s %s.%s has multiple interceptable service ports.\n"+
-					"Please specify the service and/or service port you want to intercept "+
-					"by passing the --service=<svc> and/or --port=<local:svcPortName> flag.",
-					ac.WorkloadKind, ac.WorkloadName, ac.Namespace)
-			case spec.ServiceName == "":
-				msg = fmt.Sprintf("%s %s.%s has multiple interceptable services with port %s.\n"+
-					"Please specify the service you want to intercept by passing the --service=<svc> flag.",
-					ac.WorkloadKind, ac.WorkloadName, ac.Namespace, spi)
-			case spi == "":
-				msg = fmt.Sprintf("%s %s.%s has multiple interceptable ports in service %s.\n"+
-					"Please specify the port you want to intercept by passing the --port=<local:svcPortName> flag.",
-					ac.WorkloadKind, ac.WorkloadName, ac.Namespace, spec.ServiceName)
-			default:
-				msg = fmt.Sprintf("%s %s.%s intercept config is broken. Service %s, port %s is declared more than once\n",
-					ac.WorkloadKind, ac.WorkloadName, ac.Namespace, spec.ServiceName, spi)
+		}
+	}
+
+	matches := candidates
+	if spi != "" {
+		matches = filterIntercepts(candidates, func(ic *agentconfig.Intercept) bool { return agentconfig.IsInterceptFor(spi, ic) })
+		if len(matches) == 0 {
+			matches = filterIntercepts(candidates, func(ic *agentconfig.Intercept) bool { return agentconfig.IsInterceptForContainerPort(spi, ic) })
+		}
+	}
+
+	if spec.Replace && spi == "" && len(matches) > 1 && sameContainer(matches) {
+		// All ports of the container are intercepted, so any one of them will do.
+		matches = matches[:1]
+	}
+
+	switch len(matches) {
+	case 1:
+		return matches[0].cn, matches[0].ic, nil
+	case 0:
+		ss := ""
+		if spec.ServiceName != "" {
+			if spi != "" {
+				ss = fmt.Sprintf(" matching service %s, port %s", spec.ServiceName, spi)
+			} else {
+				ss = fmt.Sprintf(" matching service %s", spec.ServiceName)
 			}
-			return nil, nil, errcat.User.New(msg)
+		} else if spi != "" {
+			ss = fmt.Sprintf(" matching port %s", spi)
 		}
+		msg := fmt.Sprintf("%s %s.%s has no interceptable port%s", ac.WorkloadKind, ac.WorkloadName, ac.Namespace, ss)
+		if len(candidates) > 0 {
+			msg += ". Available ports are:\n" + describeIntercepts(candidates)
+		}
+		return nil, nil, errcat.User.New(msg)
 	}
-	if foundIC != nil {
-		return foundCN, foundIC, nil
+
+	var msg string
+	switch {
+	case spec.ServiceName == "" && spi == "":
+		msg = fmt.Sprintf("%s %s.%s has multiple interceptable service ports.\n"+
+			"Please specify the service and/or service port you want to intercept "+
+			"by passing the --service=<svc> and/or --port=<local:svcPortName> flag.",
+			ac.WorkloadKind, ac.WorkloadName, ac.Namespace)
+	case spec.ServiceName == "":
+		msg = fmt.Sprintf("%s %s.%s has multiple interceptable services with port %s.\n"+
+			"Please specify the service you want to intercept by passing the --service=<svc> flag.",
+			ac.WorkloadKind, ac.WorkloadName, ac.Namespace, spi)
+	case spi == "":
+		msg = fmt.Sprintf("%s %s.%s has multiple interceptable ports in service %s.\n"+
+			"Please specify the port you want to intercept by passing the --port=<local:svcPortName> flag.",
+			ac.WorkloadKind, ac.WorkloadName, ac.Namespace, spec.ServiceName)
+	default:
+		msg = fmt.Sprintf("%s %s.%s has multiple interceptable ports in service %s matching port %s.\n"+
+			"Please specify the port you want to intercept by passing the --port=<local:svcPortName> flag.",
+			ac.WorkloadKind, ac.WorkloadName, ac.Namespace, spec.ServiceName, spi)
 	}
+	return nil, nil, errcat.User.New(msg + " Matching ports are:\n" + describeIntercepts(matches))
+}
 
-	ss := ""
-	if spec.ServiceName != "" {
-		if spi != "" {
-			ss = fmt.Sprintf(" matching service %s, port %s", spec.ServiceName, spi)
-		} else {
-			ss = fmt.Sprintf(" matching service %s", spec.ServiceName)
+// containerIntercept is an intercept configuration and the container that it belongs to.
+type containerIntercept struct {
+	cn *agentconfig.Container
+	ic *agentconfig.Intercept
+}
+
+func sameContainer(cis []containerIntercept) bool {
+	for _, ci := range cis[1:] {
+		if ci.cn != cis[0].cn {
+			return false
+		}
+	}
+	return true
+}
+
+func filterIntercepts(cis []containerIntercept, f func(*agentconfig.Intercept) bool) []containerIntercept {
+	var fcs []containerIntercept
+	for _, ci := range cis {
+		if f(ci.ic) {
+			fcs = append(fcs, ci)
 		}
 	}
-	return nil, nil, errcat.User.Newf("%s %s.%s has no interceptable port%s", ac.WorkloadKind, ac.WorkloadName, ac.Namespace, ss)
+	return fcs
+}
+
+// describeIntercepts returns one line per intercept that describes the service port, and the container
+// port that the service port targets.
+func describeIntercepts(cis []containerIntercept) string {
+	portDesc := func(name string, port uint16) string {
+		if name == "" {
+			return strconv.Itoa(int(port))
+		}
+		return fmt.Sprintf("%s (%d)", name, port)
+	}
+	sb := strings.Builder{}
+	for i, ci := range cis {
+		if i > 0 {
+			sb.WriteByte('\n')
+		}
+		ic := ci.ic
+		if ic.ServiceName == "" {
+			fmt.Fprintf(&sb, "    container %s, port %s", ci.cn.Name, portDesc(ic.ContainerPortName, ic.ContainerPort))
+			continue
+		}
+		fmt.Fprintf(&sb, "    service %s, port %s -> container %s, port %s",
+			ic.ServiceName, portDesc(ic.ServicePortName, ic.ServicePort), ci.cn.Name, portDesc(ic.ContainerPortName, ic.ContainerPort))
+	}
+	return sb.String()
 }
 
 type InterceptFinalizer func(ctx context.Context, interceptInfo *managerrpc.InterceptInfo) error
 
+// finalizeWorkload is called by the InterceptFinalizers that undo what the intercepts of a workload did to it. It
+// does nothing when other intercepts of the workload of the given intercept remain. Otherwise, it calls release,
+// unless it's nil, and then restore with the workload, unless restore is nil or the workload is gone. The functions
+// are called with a context that isn't cancelled, because the finalizer runs when the intercept is removed, which is
+// often due to a cancellation.
+func (s *State) finalizeWorkload(
+	ctx context.Context,
+	ii *managerrpc.InterceptInfo,
+	release func(context.Context) error,
+	restore func(context.Context, k8sapi.Workload) error,
+) error {
+	ctx = dcontext.WithoutCancel(ctx)
+	spec := ii.Spec
+	if s.isIntercepted(ii.Id, spec.Agent, spec.Namespace) {
+		return nil
+	}
+	if release != nil {
+		if err := release(ctx); err != nil {
+			return err
+		}
+	}
+	if restore == nil {
+		return nil
+	}
+	wl, err := k8sapi.GetWorkload(ctx, spec.Agent, spec.Namespace, spec.WorkloadKind)
+	if err != nil {
+		if errors2.IsNotFound(err) {
+			// There's nothing to restore in a workload that is gone.
+			err = nil
+		}
+		return err
+	}
+	return restore(ctx, wl)
+}
+
+// isIntercepted returns true if the given workload has intercepts other than the one with the given ID.
+func (s *State) isIntercepted(id, name, namespace string) bool {
+	others := s.intercepts.LoadAllMatching(func(oid string, o *managerrpc.InterceptInfo) bool {
+		return oid != id && o.Spec.Agent == name && o.Spec.Namespace == namespace
+	})
+	return len(others) > 0
+}
+
+// maxDispositionHistory is the maximum number of disposition changes that are retained for an intercept.
+const maxDispositionHistory = 50
+
 type interceptState struct {
 	sync.Mutex
 	lastInfoCh  chan *managerrpc.InterceptInfo
 	finalizers  []InterceptFinalizer
 	interceptID string
 	clientCtx   context.Context
+	history     []*managerrpc.InterceptDispositionChange
+	created     time.Time
 }
 
-func newInterceptState(clientCtx context.Context, tmCtx context.Context, interceptID string) *interceptState {
+func newInterceptState(clientCtx context.Context, tmCtx context.Context, interceptID string, created time.Time) *interceptState {
 	is := &interceptState{
 		lastInfoCh:  make(chan *managerrpc.InterceptInfo),
 		interceptID: interceptID,
 		clientCtx:   clientCtx,
+		created:     created,
 	}
 	return is
 }
@@ -447,3 +641,31 @@ func (is *interceptState) terminate(interceptInfo *managerrpc.InterceptInfo) {
 		}
 	}
 }
+
+// handoff makes the intercept state belong to the intercept with the given ID, of a client session with the given
+// context. The finalizers then run in the context of the new client session.
+func (is *interceptState) handoff(clientCtx context.Context, interceptID string) {
+	is.Lock()
+	defer is.Unlock()
+	is.clientCtx = clientCtx
+	is.interceptID = interceptID
+}
+
+// recordDisposition appends the given change to the disposition history. The oldest change is dropped when
+// the history is full.
+func (is *interceptState) recordDisposition(dc *managerrpc.InterceptDispositionChange) {
+	is.Lock()
+	defer is.Unlock()
+	if len(is.history) >= maxDispositionHistory {
+		is.history = append(is.history[:0], is.history[1:]...)
+	}
+	is.history = append(is.history, dc)
+}
+
+func (is *interceptState) dispositionHistory() []*managerrpc.InterceptDispositionChange {
+	is.Lock()
+	defer is.Unlock()
+	history := make([]*managerrpc.InterceptDispositionChange, len(is.history))
+	copy(history, is.history)
+	return history
+}
@@ -0,0 +1,60 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	managerrpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+)
+
+func TestFindIntercept(t *testing.T) {
+	ac := &agentconfig.Sidecar{
+		WorkloadKind: "Deployment",
+		WorkloadName: "echo",
+		Namespace:    "default",
+		Containers: []*agentconfig.Container{
+			{
+				Name: "echo",
+				Intercepts: []*agentconfig.Intercept{
+					{ServiceName: "echo", ServicePortName: "http", ServicePort: 80, ContainerPortName: "web", ContainerPort: 8080},
+					{ServiceName: "echo", ServicePortName: "grpc", ServicePort: 81, ContainerPort: 9090, TargetPortNumeric: true},
+					{ServiceName: "echo-alt", ServicePort: 80, ContainerPortName: "web", ContainerPort: 8080},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		svc     string
+		spi     string
+		replace bool
+		want    *agentconfig.Intercept
+		wantErr string
+	}{
+		{name: "service port name", svc: "echo", spi: "http", want: ac.Containers[0].Intercepts[0]},
+		{name: "service port number", svc: "echo", spi: "81", want: ac.Containers[0].Intercepts[1]},
+		{name: "named container port", svc: "echo", spi: "web", want: ac.Containers[0].Intercepts[0]},
+		{name: "container port number", svc: "echo", spi: "9090", want: ac.Containers[0].Intercepts[1]},
+		{name: "service port before container port", spi: "81", want: ac.Containers[0].Intercepts[1]},
+		{name: "ambiguous port", spi: "80", wantErr: "service echo-alt, port 80 -> container echo, port web (8080)"},
+		{name: "ambiguous service", svc: "echo", wantErr: "service echo, port grpc (81) -> container echo, port 9090"},
+		{name: "replace any port", svc: "echo", replace: true, want: ac.Containers[0].Intercepts[0]},
+		{name: "no such port", svc: "echo", spi: "8081", wantErr: "Available ports are:\n    service echo, port http (80)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ic, err := findIntercept(ac, &managerrpc.InterceptSpec{ServiceName: tt.svc, ServicePortIdentifier: tt.spi, Replace: tt.replace})
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Same(t, tt.want, ic)
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+	core "k8s.io/api/core/v1"
+	errors2 "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/datawire/dlib/dlog"
+	managerrpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+// assignNodeAgents asks the traffic-node-agents to run the traffic-agent of the given workload for each of its pods,
+// by adding an entry with the agent config and the selector of the workload to the NodeAgentConfigMap. The pod
+// specs aren't changed, so the pods aren't restarted and no sidecar is injected. The traffic-node-agents also run
+// agents for the pods that are created after the assignment. The running pods are annotated with the hash of the
+// config, so that clients can tell when their agents run with it.
+//
+// The config of a workload that already has an entry in the agents ConfigMap is returned as is, because its pods get
+// the agent injected anyway.
+func (s *State) assignNodeAgents(ctx context.Context, wl k8sapi.Workload, extended bool) (*agentconfig.Sidecar, error) {
+	if ac, err := s.injectedAgentConfig(ctx, wl, extended); ac != nil || err != nil {
+		return ac, err
+	}
+	if extended {
+		return nil, errcat.User.New("the traffic-agents that the traffic-node-agent runs can only intercept all TCP connections")
+	}
+	ns := wl.GetNamespace()
+	ac, err := s.volatileAgentConfig(ctx, wl, extended)
+	if err != nil {
+		return nil, err
+	}
+	bf := bytes.Buffer{}
+	if err = yaml.NewEncoder(&bf).Encode(ac); err != nil {
+		return nil, err
+	}
+	config := bf.String()
+	sel, err := wl.Selector()
+	if err != nil {
+		return nil, err
+	}
+
+	pods, err := runningPods(ctx, wl)
+	if err != nil {
+		return nil, err
+	}
+	if len(pods) == 0 {
+		return nil, errcat.User.Newf("%s %s.%s has no running pods for the traffic-node-agent to run a traffic-agent for",
+			wl.GetKind(), wl.GetName(), ns)
+	}
+	na, err := yaml.Marshal(&agentconfig.NodeAgent{Selector: sel.String(), Config: config})
+	if err != nil {
+		return nil, err
+	}
+	if err = updateManagerConfigMap(ctx, agentconfig.NodeAgentConfigMap, agentconfig.NodeAgentKey(wl.GetName(), ns), string(na)); err != nil {
+		return nil, err
+	}
+	hash := agentconfig.NodeAgentConfigHash(config)
+	for _, pod := range pods {
+		if pod.Annotations[agentconfig.NodeAgentConfigAnnotation] == hash {
+			continue
+		}
+		if err = annotatePod(ctx, pod.Name, ns, agentconfig.NodeAgentConfigAnnotation, &hash); err != nil {
+			return nil, err
+		}
+		dlog.Infof(ctx, "Assigned a traffic-agent to pod %s.%s", pod.Name, ns)
+	}
+	return ac, nil
+}
+
+// ReleaseNodeAgents is an InterceptFinalizer that removes the entry of the workload of the given intercept from the
+// NodeAgentConfigMap, and the NodeAgentConfigAnnotation from its pods, unless other intercepts of the workload
+// remain, so that the traffic-node-agents stop the traffic-agents of the pods.
+func (s *State) ReleaseNodeAgents(ctx context.Context, ii *managerrpc.InterceptInfo) error {
+	spec := ii.Spec
+	return s.finalizeWorkload(ctx, ii, func(ctx context.Context) error {
+		return updateManagerConfigMap(ctx, agentconfig.NodeAgentConfigMap, agentconfig.NodeAgentKey(spec.Agent, spec.Namespace), "")
+	}, releasePodsOfNodeAgents)
+}
+
+// releasePodsOfNodeAgents removes the NodeAgentConfigAnnotation from the pods of the given workload.
+func releasePodsOfNodeAgents(ctx context.Context, wl k8sapi.Workload) error {
+	sel, err := wl.Selector()
+	if err != nil {
+		return err
+	}
+	pods, err := k8sapi.GetK8sInterface(ctx).CoreV1().Pods(wl.GetNamespace()).List(ctx, meta.ListOptions{LabelSelector: sel.String()})
+	if err != nil {
+		return fmt.Errorf("unable to list the pods of %s %s.%s: %w", wl.GetKind(), wl.GetName(), wl.GetNamespace(), err)
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !agentconfig.HasNodeAgent(pod) {
+			continue
+		}
+		if err = annotatePod(ctx, pod.Name, wl.GetNamespace(), agentconfig.NodeAgentConfigAnnotation, nil); err != nil {
+			if errors2.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		dlog.Infof(ctx, "Released the traffic-agent of pod %s.%s", pod.Name, wl.GetNamespace())
+	}
+	return nil
+}
+
+// updateManagerConfigMap sets the entry with the given key of the named ConfigMap in the namespace of the
+// traffic-manager to the given value, or removes it when the value is empty. The ConfigMap is created when it
+// doesn't exist.
+func updateManagerConfigMap(ctx context.Context, name, key, value string) error {
+	ns := managerutil.GetEnv(ctx).ManagerNamespace
+	api := k8sapi.GetK8sInterface(ctx).CoreV1().ConfigMaps(ns)
+	err := retry.OnError(retry.DefaultBackoff, func(err error) bool {
+		return errors2.IsConflict(err) || errors2.IsAlreadyExists(err)
+	}, func() error {
+		cm, err := api.Get(ctx, name, meta.GetOptions{})
+		if err != nil {
+			if !errors2.IsNotFound(err) || value == "" {
+				return err
+			}
+			cm = &core.ConfigMap{
+				ObjectMeta: meta.ObjectMeta{Name: name, Namespace: ns},
+				Data:       map[string]string{key: value},
+			}
+			_, err = api.Create(ctx, cm, meta.CreateOptions{})
+			return err
+		}
+		if cm.Data[key] == value {
+			return nil
+		}
+		if value == "" {
+			delete(cm.Data, key)
+		} else {
+			if cm.Data == nil {
+				cm.Data = make(map[string]string)
+			}
+			cm.Data[key] = value
+		}
+		_, err = api.Update(ctx, cm, meta.UpdateOptions{})
+		return err
+	})
+	if err != nil && !(value == "" && errors2.IsNotFound(err)) {
+		return fmt.Errorf("unable to update ConfigMap %s: %w", name, err)
+	}
+	return nil
+}
+
+// annotatePod sets the given annotation of the named pod to the given value, or removes it when the value is nil.
+func annotatePod(ctx context.Context, name, namespace, key string, value *string) error {
+	patch, err := json.Marshal(map[string]any{"metadata": map[string]any{"annotations": map[string]*string{key: value}}})
+	if err != nil {
+		return err
+	}
+	_, err = k8sapi.GetK8sInterface(ctx).CoreV1().Pods(namespace).Patch(ctx, name, types.MergePatchType, patch, meta.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to annotate pod %s.%s: %w", name, namespace, err)
+	}
+	return nil
+}
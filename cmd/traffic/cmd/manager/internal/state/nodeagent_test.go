@@ -0,0 +1,96 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	errors2 "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/datawire/dlib/dlog"
+	managerrpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+func TestReleaseNodeAgents(t *testing.T) {
+	labels := map[string]string{"app": "echo"}
+	dep := &apps.Deployment{
+		ObjectMeta: meta.ObjectMeta{Name: "echo", Namespace: "default"},
+		Spec:       apps.DeploymentSpec{Selector: &meta.LabelSelector{MatchLabels: labels}},
+	}
+	pod := func(name string, annotations map[string]string) *core.Pod {
+		return &core.Pod{ObjectMeta: meta.ObjectMeta{Name: name, Namespace: "default", Labels: labels, Annotations: annotations}}
+	}
+	ctx := k8sapi.WithK8sInterface(dlog.NewTestContext(t, false), fake.NewSimpleClientset(
+		dep,
+		pod("echo-1", map[string]string{agentconfig.NodeAgentConfigAnnotation: agentconfig.NodeAgentConfigHash("agentName: echo\n"), "other": "kept"}),
+		pod("echo-2", nil),
+		&core.ConfigMap{
+			ObjectMeta: meta.ObjectMeta{Name: agentconfig.NodeAgentConfigMap, Namespace: "ambassador"},
+			Data: map[string]string{
+				agentconfig.NodeAgentKey("echo", "default"):  "selector: app=echo\n",
+				agentconfig.NodeAgentKey("other", "default"): "selector: app=other\n",
+			},
+		},
+	))
+	ctx = managerutil.WithEnv(ctx, &managerutil.Env{ManagerNamespace: "ambassador"})
+	ii := &managerrpc.InterceptInfo{
+		Id:   "00000000-0000-0000-0000-000000000000:echo",
+		Spec: &managerrpc.InterceptSpec{Agent: "echo", Namespace: "default", WorkloadKind: "Deployment"},
+	}
+	require.NoError(t, NewState(ctx).ReleaseNodeAgents(ctx, ii))
+
+	p, err := k8sapi.GetK8sInterface(ctx).CoreV1().Pods("default").Get(ctx, "echo-1", meta.GetOptions{})
+	require.NoError(t, err)
+	assert.False(t, agentconfig.HasNodeAgent(p))
+	assert.Equal(t, "kept", p.Annotations["other"])
+
+	cm, err := k8sapi.GetK8sInterface(ctx).CoreV1().ConfigMaps("ambassador").Get(ctx, agentconfig.NodeAgentConfigMap, meta.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, cm.Data, agentconfig.NodeAgentKey("echo", "default"))
+	assert.Contains(t, cm.Data, agentconfig.NodeAgentKey("other", "default"))
+
+	// A workload that is gone has nothing to release
+	ii.Spec.Agent = "gone"
+	assert.NoError(t, NewState(ctx).ReleaseNodeAgents(ctx, ii))
+}
+
+func TestAssignNodeAgents_extended(t *testing.T) {
+	dep := &apps.Deployment{ObjectMeta: meta.ObjectMeta{Name: "echo", Namespace: "default"}}
+	ctx := k8sapi.WithK8sInterface(dlog.NewTestContext(t, false), fake.NewSimpleClientset(dep))
+	ctx = managerutil.WithEnv(ctx, &managerutil.Env{AgentInjectionMode: agentconfig.NodeInjectionMode})
+	wl, err := k8sapi.GetWorkload(ctx, "echo", "default", "Deployment")
+	require.NoError(t, err)
+	_, err = NewState(ctx).assignNodeAgents(ctx, wl, true)
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+}
+
+func TestUpdateManagerConfigMap(t *testing.T) {
+	ctx := k8sapi.WithK8sInterface(dlog.NewTestContext(t, false), fake.NewSimpleClientset())
+	ctx = managerutil.WithEnv(ctx, &managerutil.Env{ManagerNamespace: "ambassador"})
+	api := k8sapi.GetK8sInterface(ctx).CoreV1().ConfigMaps("ambassador")
+
+	// Removing an entry from a ConfigMap that doesn't exist is a no-op
+	require.NoError(t, updateManagerConfigMap(ctx, agentconfig.NodeAgentConfigMap, "echo.default", ""))
+	_, err := api.Get(ctx, agentconfig.NodeAgentConfigMap, meta.GetOptions{})
+	assert.True(t, errors2.IsNotFound(err))
+
+	require.NoError(t, updateManagerConfigMap(ctx, agentconfig.NodeAgentConfigMap, "echo.default", "selector: app=echo\n"))
+	require.NoError(t, updateManagerConfigMap(ctx, agentconfig.NodeAgentConfigMap, "other.default", "selector: app=other\n"))
+	cm, err := api.Get(ctx, agentconfig.NodeAgentConfigMap, meta.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"echo.default": "selector: app=echo\n", "other.default": "selector: app=other\n"}, cm.Data)
+
+	require.NoError(t, updateManagerConfigMap(ctx, agentconfig.NodeAgentConfigMap, "echo.default", ""))
+	cm, err = api.Get(ctx, agentconfig.NodeAgentConfigMap, meta.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"other.default": "selector: app=other\n"}, cm.Data)
+}
@@ -0,0 +1,68 @@
+package state
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/datawire/dlib/dlog"
+	managerrpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+	"github.com/telepresenceio/telepresence/v2/pkg/agentmap"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+// replaceContainer annotates the pod template of the given workload so that the agent-injector removes the named
+// container from the pods and lets the traffic-agent listen to all of its ports. The annotation triggers a rollout.
+// The agent config that is returned is the one that the agent-injector generates for the new pods.
+func (s *State) replaceContainer(ctx context.Context, wl k8sapi.Workload, ac *agentconfig.Sidecar, name string) (*agentconfig.Sidecar, error) {
+	if ac.Manual {
+		return nil, errcat.User.Newf("the traffic-agent of %s %s.%s is manually injected, so it can't replace a container",
+			wl.GetKind(), wl.GetName(), wl.GetNamespace())
+	}
+	if _, ok := k8sapi.ReplicaSetImpl(wl); ok {
+		return nil, errcat.User.Newf("replace is not supported for ReplicaSet %s.%s, because updating its pod template doesn't recreate its pods",
+			wl.GetName(), wl.GetNamespace())
+	}
+	if k8sapi.IsBatchKind(wl.GetKind()) {
+		return nil, errcat.User.Newf("replace is not supported for %s %s.%s, because the pod template of a Job is immutable",
+			wl.GetKind(), wl.GetName(), wl.GetNamespace())
+	}
+	dlog.Infof(ctx, "Replacing container %s of %s %s.%s", name, wl.GetKind(), wl.GetName(), wl.GetNamespace())
+	if err := patchReplaceAnnotation(ctx, wl, fmt.Sprintf("%q", name)); err != nil {
+		return nil, err
+	}
+	cmAPI := k8sapi.GetK8sInterface(ctx).CoreV1().ConfigMaps(wl.GetNamespace())
+	return waitForConfigMapUpdate(ctx, cmAPI, wl.GetName(), wl.GetNamespace(), func(conf *agentconfig.Sidecar) bool {
+		for _, cn := range conf.Containers {
+			if cn.Name == name {
+				return cn.Replace
+			}
+		}
+		return false
+	})
+}
+
+// RestoreReplacedContainer is an InterceptFinalizer that removes the annotation that makes the agent-injector replace
+// a container of the intercepted workload, so that the original container is restored, unless other intercepts of
+// the workload remain. It's added to all intercepts, because the last one to be removed might not be the one that
+// replaced the container.
+func (s *State) RestoreReplacedContainer(ctx context.Context, ii *managerrpc.InterceptInfo) error {
+	return s.finalizeWorkload(ctx, ii, nil, restoreReplacedContainer)
+}
+
+func restoreReplacedContainer(ctx context.Context, wl k8sapi.Workload) error {
+	if _, ok := wl.GetPodTemplate().Annotations[agentmap.ReplaceContainerAnnotation]; !ok {
+		return nil
+	}
+	dlog.Infof(ctx, "Restoring replaced container of %s %s.%s", wl.GetKind(), wl.GetName(), wl.GetNamespace())
+	return patchReplaceAnnotation(ctx, wl, "null")
+}
+
+func patchReplaceAnnotation(ctx context.Context, wl k8sapi.Workload, value string) error {
+	patch := fmt.Sprintf(`{"annotations": {%q: %s}}`, agentmap.ReplaceContainerAnnotation, value)
+	if err := k8sapi.PatchPodTemplateMetadata(ctx, wl, patch); err != nil {
+		return fmt.Errorf("unable to patch %s %s.%s: %w", wl.GetKind(), wl.GetName(), wl.GetNamespace(), err)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/agentmap"
+)
+
+func TestRestoreReplacedContainer(t *testing.T) {
+	ctx := knativeContext(t, map[string]any{agentmap.ReplaceContainerAnnotation: "hello"})
+	s := NewState(ctx)
+
+	// Not restored while another intercept of the workload remains, even if that intercept didn't replace it
+	s.intercepts.Store("b", pinSpec("b"))
+	require.NoError(t, s.RestoreReplacedContainer(ctx, pinSpec("a")))
+	assert.Contains(t, getKnativeService(ctx, t).GetPodTemplate().Annotations, agentmap.ReplaceContainerAnnotation)
+
+	s.intercepts.Delete("b")
+	require.NoError(t, s.RestoreReplacedContainer(ctx, pinSpec("b")))
+	assert.NotContains(t, getKnativeService(ctx, t).GetPodTemplate().Annotations, agentmap.ReplaceContainerAnnotation)
+
+	// A workload that is gone has nothing to restore
+	ii := pinSpec("c")
+	ii.Spec.Agent = "gone"
+	assert.NoError(t, s.RestoreReplacedContainer(ctx, ii))
+}
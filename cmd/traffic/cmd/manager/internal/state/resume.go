@@ -0,0 +1,74 @@
+package state
+
+import (
+	"context"
+	"crypto/subtle"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+// suspendedSession is a client session that has expired, but that can be resumed by a client that presents the
+// resume token that it provided when the session was established.
+type suspendedSession struct {
+	client     *rpc.ClientInfo
+	intercepts []*rpc.InterceptInfo
+	lastMarked time.Time
+}
+
+// unlockedSuspendSession (1) assumes that s.mu is already locked, and (2) retains what's needed to resume the
+// given client session after it has been removed. Sessions without a resume token are not retained.
+func (s *State) unlockedSuspendSession(sessionID string, sess SessionState) {
+	client, ok := s.clients.Load(sessionID)
+	if !ok || client.ResumeToken == "" {
+		return
+	}
+	ss := &suspendedSession{client: client, lastMarked: sess.LastMarked()}
+	for _, intercept := range s.intercepts.LoadAll() {
+		if intercept.ClientSession.SessionId == sessionID {
+			ss.intercepts = append(ss.intercepts, intercept)
+		}
+	}
+	s.suspended[sessionID] = ss
+}
+
+// ExpireSuspendedSessions forgets suspended sessions that haven't had a MarkSession heartbeat since the given
+// moment. Such sessions can no longer be resumed.
+func (s *State) ExpireSuspendedSessions(ctx context.Context, moment time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, ss := range s.suspended {
+		if ss.lastMarked.Before(moment) {
+			dlog.Debugf(ctx, "Suspended Client Session %s removed. It has expired", id)
+			delete(s.suspended, id)
+		}
+	}
+}
+
+// ResumeSession resumes the client session with the given ID, provided that the given token matches the resume
+// token of the session. A session that is still alive is just marked as being present. The intercepts that a
+// suspended session had when it expired are returned so that the caller can recreate them.
+func (s *State) ResumeSession(ctx context.Context, sessionID, token string, now time.Time) ([]*rpc.InterceptInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokenMatches := func(client *rpc.ClientInfo) bool {
+		return token != "" && subtle.ConstantTimeCompare([]byte(client.ResumeToken), []byte(token)) == 1
+	}
+	if sess, ok := s.sessions[sessionID]; ok {
+		if client, ok := s.clients.Load(sessionID); ok && tokenMatches(client) {
+			sess.SetLastMarked(now)
+			return nil, nil
+		}
+	} else if ss, ok := s.suspended[sessionID]; ok && tokenMatches(ss.client) {
+		delete(s.suspended, sessionID)
+		s.unlockedAddClient(sessionID, ss.client, now)
+		dlog.Debugf(ctx, "Client Session %s resumed", sessionID)
+		return ss.intercepts, nil
+	}
+	return nil, status.Errorf(codes.NotFound, "Session %q not found", sessionID)
+}
@@ -0,0 +1,129 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/datawire/dlib/dlog"
+	managerrpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+// pinnedMinScaleAnnotation is the annotation of a workload whose minimum scale is pinned by intercepts. Its value is
+// the minimum scale that the pod template declared before it was pinned, or empty if it declared none.
+const pinnedMinScaleAnnotation = agentconfig.DomainPrefix + "pinned-min-scale"
+
+// pinMinScale makes the workload keep at least one pod by setting the given minimum scale annotation of its pod
+// template, unless the workload is already pinned by another intercept or declares a minimum scale of its own. The
+// pinnedMinScaleAnnotation is set first, so that restoreMinScale undoes a pin that fails half-way.
+func pinMinScale(ctx context.Context, wl k8sapi.Workload, minScaleAnnotation string) error {
+	if _, ok := wl.GetAnnotations()[pinnedMinScaleAnnotation]; ok {
+		return nil
+	}
+	orig := wl.GetPodTemplate().Annotations[minScaleAnnotation]
+	if n, err := strconv.Atoi(orig); err == nil && n > 0 {
+		dlog.Debugf(ctx, "%s %s.%s has a minimum scale of %d, so it isn't pinned", wl.GetKind(), wl.GetName(), wl.GetNamespace(), n)
+		return nil
+	}
+	dlog.Infof(ctx, "Pinning the minimum scale of %s %s.%s to 1", wl.GetKind(), wl.GetName(), wl.GetNamespace())
+	patch := fmt.Sprintf(`{"metadata": {"annotations": {%q: %q}}}`, pinnedMinScaleAnnotation, orig)
+	if err := wl.Patch(ctx, types.MergePatchType, []byte(patch)); err != nil {
+		return fmt.Errorf("unable to patch %s %s.%s: %w", wl.GetKind(), wl.GetName(), wl.GetNamespace(), err)
+	}
+	if err := k8sapi.PatchPodTemplateMetadata(ctx, wl, fmt.Sprintf(`{"annotations": {%q: "1"}}`, minScaleAnnotation)); err != nil {
+		return fmt.Errorf("unable to patch %s %s.%s: %w", wl.GetKind(), wl.GetName(), wl.GetNamespace(), err)
+	}
+	return nil
+}
+
+// RestoreMinScale is an InterceptFinalizer that restores the minimum scale of a workload that was pinned by
+// intercepts, unless other intercepts of the workload remain.
+func (s *State) RestoreMinScale(ctx context.Context, ii *managerrpc.InterceptInfo) error {
+	return s.finalizeWorkload(ctx, ii, nil, restoreMinScale)
+}
+
+// RestoreOrphanedMinScales restores the minimum scale of the workloads that are pinned although they have no
+// intercepts. The intercepts don't survive a restart of the traffic-manager, so this is called on startup to restore
+// the workloads that it pinned before it was restarted.
+func (s *State) RestoreOrphanedMinScales(ctx context.Context) {
+	namespaces := managerutil.GetEnv(ctx).GetManagedNamespaces()
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+	for _, ns := range namespaces {
+		wls, err := k8sapi.CustomWorkloads(ctx, ns, nil)
+		if err != nil {
+			dlog.Errorf(ctx, "unable to list the custom workloads in namespace %q: %v", ns, err)
+			continue
+		}
+		for _, wl := range wls {
+			if _, ok := wl.GetAnnotations()[pinnedMinScaleAnnotation]; ok && !s.isIntercepted("", wl.GetName(), wl.GetNamespace()) {
+				if err = restoreMinScale(ctx, wl); err != nil {
+					dlog.Error(ctx, err)
+				}
+			}
+		}
+	}
+}
+
+// restoreMinScale restores the minimum scale that the pod template of the given workload declared before it was
+// pinned, if it's pinned.
+func restoreMinScale(ctx context.Context, wl k8sapi.Workload) error {
+	orig, ok := wl.GetAnnotations()[pinnedMinScaleAnnotation]
+	if !ok {
+		return nil
+	}
+	value := "null"
+	if orig != "" {
+		value = strconv.Quote(orig)
+	}
+	dlog.Infof(ctx, "Restoring the minimum scale of %s %s.%s", wl.GetKind(), wl.GetName(), wl.GetNamespace())
+	if err := k8sapi.PatchPodTemplateMetadata(ctx, wl, fmt.Sprintf(`{"annotations": {%q: %s}}`, k8sapi.MinScaleAnnotation(wl), value)); err != nil {
+		return fmt.Errorf("unable to patch %s %s.%s: %w", wl.GetKind(), wl.GetName(), wl.GetNamespace(), err)
+	}
+	patch := fmt.Sprintf(`{"metadata": {"annotations": {%q: null}}}`, pinnedMinScaleAnnotation)
+	if err := wl.Patch(ctx, types.MergePatchType, []byte(patch)); err != nil {
+		return fmt.Errorf("unable to patch %s %s.%s: %w", wl.GetKind(), wl.GetName(), wl.GetNamespace(), err)
+	}
+	return nil
+}
+
+// minScaleToPin returns the minimum scale annotation of a workload that scales to zero if it must be pinned for an
+// intercept, or an empty string. It's pinned when the intercept asks for it, and when the workload has scaled to
+// zero, because the traffic-agent can't arrive in a workload that has no pods. Sending a request to the address of
+// the workload would also scale it up, but the request would reach the app.
+func minScaleToPin(ctx context.Context, wl k8sapi.Workload, pinScale bool) string {
+	msa := k8sapi.MinScaleAnnotation(wl)
+	if msa == "" || pinScale || !hasRunningPods(ctx, wl) {
+		return msa
+	}
+	return ""
+}
+
+// hasRunningPods returns true if the workload has pods that haven't terminated. True is also returned when the
+// pods can't be listed, because a workload that can't be checked shouldn't be changed.
+func hasRunningPods(ctx context.Context, wl k8sapi.Workload) bool {
+	sel, err := wl.Selector()
+	if err == nil {
+		var pods *core.PodList
+		api := k8sapi.GetK8sInterface(ctx).CoreV1().Pods(wl.GetNamespace())
+		if pods, err = api.List(ctx, meta.ListOptions{LabelSelector: sel.String()}); err == nil {
+			for i := range pods.Items {
+				pod := &pods.Items[i]
+				if pod.DeletionTimestamp == nil && pod.Status.Phase != core.PodSucceeded && pod.Status.Phase != core.PodFailed {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	dlog.Errorf(ctx, "unable to list the pods of %s %s.%s: %v", wl.GetKind(), wl.GetName(), wl.GetNamespace(), err)
+	return true
+}
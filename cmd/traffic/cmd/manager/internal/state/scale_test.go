@@ -0,0 +1,145 @@
+package state
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicFake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/datawire/dlib/dlog"
+	managerrpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+const minScale = "autoscaling.knative.dev/min-scale"
+
+var (
+	knativeGVR = schema.GroupVersionResource{Group: "serving.knative.dev", Version: "v1", Resource: "services"}
+	rolloutGVR = schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "rollouts"}
+)
+
+func knativeContext(t *testing.T, tplAnnotations map[string]any, pods ...runtime.Object) context.Context {
+	ksvc := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "serving.knative.dev/v1",
+		"kind":       "Service",
+		"metadata":   map[string]any{"name": "hello", "namespace": "default"},
+		"spec": map[string]any{
+			"template": map[string]any{
+				"metadata": map[string]any{"annotations": tplAnnotations},
+				"spec":     map[string]any{"containers": []any{map[string]any{"name": "hello", "image": "hello:1.0"}}},
+			},
+		},
+	}}
+	di := dynamicFake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{knativeGVR: "ServiceList", rolloutGVR: "RolloutList"}, ksvc)
+	ctx := k8sapi.WithK8sInterface(dlog.NewTestContext(t, false), fake.NewSimpleClientset(pods...))
+	ctx = k8sapi.WithDynamicInterface(ctx, di)
+	return k8sapi.WithWorkloadKinds(ctx, k8sapi.MergeWorkloadKinds(nil))
+}
+
+func getKnativeService(ctx context.Context, t *testing.T) k8sapi.Workload {
+	wl, err := k8sapi.GetWorkload(ctx, "hello", "default", "Service")
+	require.NoError(t, err)
+	return wl
+}
+
+func pinSpec(id string) *managerrpc.InterceptInfo {
+	return &managerrpc.InterceptInfo{Id: id, Spec: &managerrpc.InterceptSpec{
+		Name: id, Agent: "hello", Namespace: "default", WorkloadKind: "Service", PinScale: true,
+	}}
+}
+
+func TestPinMinScale(t *testing.T) {
+	ctx := knativeContext(t, nil)
+	s := NewState(ctx)
+	wl := getKnativeService(ctx, t)
+	require.Equal(t, minScale, k8sapi.MinScaleAnnotation(wl))
+
+	// A second intercept doesn't overwrite the stored minimum scale
+	require.NoError(t, pinMinScale(ctx, wl, minScale))
+	require.NoError(t, pinMinScale(ctx, wl, minScale))
+	wl = getKnativeService(ctx, t)
+	assert.Equal(t, "1", wl.GetPodTemplate().Annotations[minScale])
+	assert.Equal(t, "", wl.GetAnnotations()[pinnedMinScaleAnnotation])
+
+	// Not restored while another intercept pins it
+	s.intercepts.Store("b", pinSpec("b"))
+	require.NoError(t, s.RestoreMinScale(ctx, pinSpec("a")))
+	assert.Equal(t, "1", getKnativeService(ctx, t).GetPodTemplate().Annotations[minScale])
+
+	s.intercepts.Delete("b")
+	require.NoError(t, s.RestoreMinScale(ctx, pinSpec("b")))
+	wl = getKnativeService(ctx, t)
+	assert.NotContains(t, wl.GetPodTemplate().Annotations, minScale)
+	assert.NotContains(t, wl.GetAnnotations(), pinnedMinScaleAnnotation)
+}
+
+func TestPinMinScale_declared(t *testing.T) {
+	ctx := knativeContext(t, map[string]any{minScale: "0"})
+	s := NewState(ctx)
+	require.NoError(t, pinMinScale(ctx, getKnativeService(ctx, t), minScale))
+	require.NoError(t, s.RestoreMinScale(ctx, pinSpec("a")))
+	assert.Equal(t, "0", getKnativeService(ctx, t).GetPodTemplate().Annotations[minScale])
+
+	// A workload that declares a minimum scale of its own isn't pinned
+	ctx = knativeContext(t, map[string]any{minScale: "2"})
+	require.NoError(t, pinMinScale(ctx, getKnativeService(ctx, t), minScale))
+	assert.NotContains(t, getKnativeService(ctx, t).GetAnnotations(), pinnedMinScaleAnnotation)
+}
+
+func TestMinScaleToPin(t *testing.T) {
+	ctx := knativeContext(t, nil)
+	wl := getKnativeService(ctx, t)
+	assert.Equal(t, minScale, minScaleToPin(ctx, wl, false), "a workload that has scaled to zero isn't pinned")
+	assert.Equal(t, minScale, minScaleToPin(ctx, wl, true))
+
+	pod := &core.Pod{
+		ObjectMeta: meta.ObjectMeta{Name: "hello-1", Namespace: "default", Labels: map[string]string{"serving.knative.dev/service": "hello"}},
+		Status:     core.PodStatus{Phase: core.PodRunning},
+	}
+	ctx = knativeContext(t, nil, pod)
+	wl = getKnativeService(ctx, t)
+	assert.Empty(t, minScaleToPin(ctx, wl, false))
+	assert.Equal(t, minScale, minScaleToPin(ctx, wl, true))
+
+	// A workload that doesn't scale to zero is never pinned
+	assert.Empty(t, minScaleToPin(ctx, k8sapi.Deployment(&apps.Deployment{}), true))
+}
+
+func TestRestoreOrphanedMinScales(t *testing.T) {
+	ctx := managerutil.WithEnv(knativeContext(t, map[string]any{minScale: "0"}), &managerutil.Env{})
+	s := NewState(ctx)
+	require.NoError(t, pinMinScale(ctx, getKnativeService(ctx, t), minScale))
+
+	s.intercepts.Store("a", pinSpec("a"))
+	s.RestoreOrphanedMinScales(ctx)
+	assert.Equal(t, "1", getKnativeService(ctx, t).GetPodTemplate().Annotations[minScale], "restored while intercepted")
+
+	s.intercepts.Delete("a")
+	s.RestoreOrphanedMinScales(ctx)
+	wl := getKnativeService(ctx, t)
+	assert.Equal(t, "0", wl.GetPodTemplate().Annotations[minScale])
+	assert.NotContains(t, wl.GetAnnotations(), pinnedMinScaleAnnotation)
+}
+
+func TestRestoreMinScale_partialPin(t *testing.T) {
+	// The pin failed after the workload was annotated, but before its pod template was changed
+	ctx := knativeContext(t, map[string]any{minScale: "0"})
+	wl := getKnativeService(ctx, t)
+	require.NoError(t, wl.Patch(ctx, types.MergePatchType, []byte(`{"metadata": {"annotations": {"`+pinnedMinScaleAnnotation+`": "0"}}}`)))
+	require.NoError(t, restoreMinScale(ctx, getKnativeService(ctx, t)))
+	wl = getKnativeService(ctx, t)
+	assert.Equal(t, "0", wl.GetPodTemplate().Annotations[minScale])
+	assert.NotContains(t, wl.GetAnnotations(), pinnedMinScaleAnnotation)
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,6 +14,7 @@ import (
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/datawire/dlib/dlog"
 	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
@@ -184,12 +187,16 @@ type State struct {
 	//  7. `cfgMapLocks` access must be concurrency protected
 	//  8. `cachedAgentImage` access must be concurrency protected
 	//  9. `interceptState` must be concurrency protected and updated/deleted in sync with intercepts
+	// 10. `suspended` must be updated in sync with `sessions`
+	// 11. `handoffOffers` must be pruned in sync with `intercepts` and `sessions`
 	intercepts       watchable.Map[*rpc.InterceptInfo]
 	agents           watchable.Map[*rpc.AgentInfo]        // info for agent sessions
 	clients          watchable.Map[*rpc.ClientInfo]       // info for client sessions
 	sessions         map[string]SessionState              // info for all sessions
+	suspended        map[string]*suspendedSession         // expired client sessions that can be resumed
 	agentsByName     map[string]map[string]*rpc.AgentInfo // indexed copy of `agents`
 	interceptStates  map[string]*interceptState
+	handoffOffers    map[string]string // intercept ID -> session ID of the client it's offered to
 	timedLogLevel    log.TimedLevel
 	llSubs           *loglevelSubscribers
 	cfgMapLocks      map[string]*sync.Mutex
@@ -201,9 +208,11 @@ func NewState(ctx context.Context) *State {
 	return &State{
 		ctx:             ctx,
 		sessions:        make(map[string]SessionState),
+		suspended:       make(map[string]*suspendedSession),
 		agentsByName:    make(map[string]map[string]*rpc.AgentInfo),
 		cfgMapLocks:     make(map[string]*sync.Mutex),
 		interceptStates: make(map[string]*interceptState),
+		handoffOffers:   make(map[string]string),
 		timedLogLevel:   log.NewTimedLevel(loglevel, log.SetLevel),
 		llSubs:          newLoglevelSubscribers(),
 	}
@@ -260,7 +269,9 @@ func (s *State) unlockedCheckAgentsForIntercept(intercept *rpc.InterceptInfo) (e
 		return
 	}
 
-	if !managerutil.AgentsAreCompatible(agentList) {
+	// Agents of different versions are inconsistent, unless they are the result of an upgrade that is in progress,
+	// in which case the intercept is shared by the old and the new agents until the old ones are gone.
+	if !(managerutil.AgentsAreCompatible(agentList) || managerutil.AgentsAreUpgrading(agentList)) {
 		errCode = rpc.InterceptDispositionType_NO_AGENT
 		errMsg = fmt.Sprintf("Agents for %q are not consistent", intercept.Spec.Agent)
 		return
@@ -325,11 +336,16 @@ func (s *State) gcSessionIntercepts(sessionID string) {
 			intercept.Disposition = errCode
 			intercept.Message = errMsg
 			s.intercepts.Store(interceptID, intercept)
-		} else if isAgent && agent.PodIp == intercept.PodIp {
-			// The agent whose podIP was stored by the intercept is dead, but it's not the last agent
+			s.unlockedRecordDisposition(intercept)
+		} else if intercept.Disposition == rpc.InterceptDispositionType_NO_AGENT ||
+			isAgent && agent.PodIp == intercept.PodIp {
+			// The agent whose podIP was stored by the intercept is dead, but it's not the last agent, or
+			// the agents became consistent when this agent went away.
 			// Send it back to waiting so that one of the other agents can pick it up and set their own podIP
 			intercept.Disposition = rpc.InterceptDispositionType_WAITING
+			intercept.Message = ""
 			s.intercepts.Store(interceptID, intercept)
+			s.unlockedRecordDisposition(intercept)
 		}
 	}
 }
@@ -339,16 +355,19 @@ func (s *State) unlockedRemoveSession(sessionID string) {
 		// kill the session
 		defer sess.Cancel()
 
-		s.gcSessionIntercepts(sessionID)
-
 		agent, isAgent := s.agents.Load(sessionID)
 		if isAgent {
-			// remove it from the agentsByName index (if nescessary)
-
+			// remove it from the agentsByName index (if nescessary) before the intercepts are checked, so
+			// that the check doesn't count the agent that is going away.
 			delete(s.agentsByName[agent.Name], sessionID)
 			if len(s.agentsByName[agent.Name]) == 0 {
 				delete(s.agentsByName, agent.Name)
 			}
+		}
+
+		s.gcSessionIntercepts(sessionID)
+
+		if isAgent {
 			// remove the session
 			s.agents.Delete(sessionID)
 		} else {
@@ -356,6 +375,11 @@ func (s *State) unlockedRemoveSession(sessionID string) {
 		}
 
 		delete(s.sessions, sessionID)
+		for id, recipient := range s.handoffOffers {
+			if recipient == sessionID {
+				delete(s.handoffOffers, id)
+			}
+		}
 	}
 }
 
@@ -369,6 +393,7 @@ func (s *State) ExpireSessions(ctx context.Context, clientMoment, agentMoment ti
 		if _, ok := sess.(*clientSessionState); ok {
 			if sess.LastMarked().Before(clientMoment) {
 				dlog.Debugf(ctx, "Client Session %s removed. It has expired", id)
+				s.unlockedSuspendSession(id, sess)
 				s.unlockedRemoveSession(id)
 			}
 		} else {
@@ -408,7 +433,10 @@ func (s *State) AddClient(client *rpc.ClientInfo, now time.Time) string {
 func (s *State) addClient(sessionID string, client *rpc.ClientInfo, now time.Time) string {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.unlockedAddClient(sessionID, client, now)
+}
 
+func (s *State) unlockedAddClient(sessionID string, client *rpc.ClientInfo, now time.Time) string {
 	if oldClient, hasConflict := s.clients.LoadOrStore(sessionID, client); hasConflict {
 		panic(fmt.Errorf("duplicate id %q, existing %+v, new %+v", sessionID, oldClient, client))
 	}
@@ -471,10 +499,12 @@ func (s *State) AddAgent(agent *rpc.AgentInfo, now time.Time) string {
 			intercept.Disposition = errCode
 			intercept.Message = errMsg
 			s.intercepts.Store(interceptID, intercept)
+			s.unlockedRecordDisposition(intercept)
 		} else if intercept.Disposition == rpc.InterceptDispositionType_NO_AGENT {
 			intercept.Disposition = rpc.InterceptDispositionType_WAITING
 			intercept.Message = ""
 			s.intercepts.Store(interceptID, intercept)
+			s.unlockedRecordDisposition(intercept)
 		}
 	}
 	return sessionID
@@ -516,7 +546,12 @@ func (s *State) WatchAgents(
 
 // Intercepts //////////////////////////////////////////////////////////////////////////////////////
 
-func (s *State) AddIntercept(sessionID, clusterID, apiKey string, client *rpc.ClientInfo, spec *rpc.InterceptSpec) (*rpc.InterceptInfo, error) {
+func (s *State) AddIntercept(
+	sessionID, clusterID, apiKey string,
+	client *rpc.ClientInfo,
+	spec *rpc.InterceptSpec,
+	now time.Time,
+) (*rpc.InterceptInfo, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -524,6 +559,9 @@ func (s *State) AddIntercept(sessionID, clusterID, apiKey string, client *rpc.Cl
 	if sess == nil || !ok {
 		return nil, status.Errorf(codes.NotFound, "session %q not found", sessionID)
 	}
+	if client.ConnectOnly {
+		return nil, errConnectOnly(client.Name)
+	}
 
 	interceptID := fmt.Sprintf("%s:%s", sessionID, spec.Name)
 	installId := client.GetInstallId()
@@ -537,7 +575,8 @@ func (s *State) AddIntercept(sessionID, clusterID, apiKey string, client *rpc.Cl
 			ClusterId: clusterID,
 			InstallId: &installId,
 		},
-		ApiKey: apiKey,
+		ApiKey:         apiKey,
+		ClientIdentity: client.Identity,
 	}
 
 	// Wrap each potential-state-change in a
@@ -545,6 +584,10 @@ func (s *State) AddIntercept(sessionID, clusterID, apiKey string, client *rpc.Cl
 	//     if cept.Disposition == rpc.InterceptDispositionType_WAITING { … }
 	//
 	// so that we don't need to worry about different state-changes stomping on eachother.
+	if err := s.unlockedCheckInterceptLimits(cept, ""); err != nil {
+		return nil, err
+	}
+
 	if cept.Disposition == rpc.InterceptDispositionType_WAITING {
 		if errCode, errMsg := s.unlockedCheckAgentsForIntercept(cept); errCode != 0 {
 			cept.Disposition = errCode
@@ -556,12 +599,63 @@ func (s *State) AddIntercept(sessionID, clusterID, apiKey string, client *rpc.Cl
 		return nil, status.Errorf(codes.AlreadyExists, "Intercept named %q already exists", spec.Name)
 	}
 
-	state := newInterceptState(sess.ctx, s.ctx, cept.Id)
+	state := newInterceptState(sess.ctx, s.ctx, cept.Id, now)
 	s.interceptStates[interceptID] = state
+	s.unlockedRecordDisposition(cept)
 
 	return cept, nil
 }
 
+// errConnectOnly returns the PermissionDenied error for an intercept of a connect-only client.
+func errConnectOnly(clientName string) error {
+	return status.Errorf(codes.PermissionDenied,
+		"%s is connect-only: the session provides outbound connectivity and DNS, but it can't intercept", clientName)
+}
+
+// unlockedCheckInterceptLimits assumes that s.mu is already locked, and returns a ResourceExhausted error if
+// adding the given intercept would exceed the maximum number of intercepts per user or namespace. The intercept
+// with the replacedID, if any, isn't counted.
+func (s *State) unlockedCheckInterceptLimits(cept *rpc.InterceptInfo, replacedID string) error {
+	env := managerutil.GetEnv(s.ctx)
+	if env == nil || env.MaxInterceptsPerUser <= 0 && env.MaxInterceptsPerNamespace <= 0 {
+		return nil
+	}
+	spec := cept.Spec
+	owner := interceptOwner(cept)
+	perUser, perNamespace := 0, 0
+	for id, ii := range s.intercepts.LoadAll() {
+		if id == replacedID {
+			continue
+		}
+		if interceptOwner(ii) == owner {
+			perUser++
+		}
+		if ii.Spec.Namespace == spec.Namespace {
+			perNamespace++
+		}
+	}
+	if max := env.MaxInterceptsPerUser; max > 0 && perUser >= max {
+		return status.Errorf(codes.ResourceExhausted,
+			"%s already has %d intercepts, and the traffic-manager permits at most %d intercepts per user. Use telepresence leave to remove one",
+			owner, perUser, max)
+	}
+	if max := env.MaxInterceptsPerNamespace; max > 0 && perNamespace >= max {
+		return status.Errorf(codes.ResourceExhausted,
+			"namespace %q already has %d intercepts, and the traffic-manager permits at most %d intercepts per namespace",
+			spec.Namespace, perNamespace, max)
+	}
+	return nil
+}
+
+// interceptOwner returns the user that owns the given intercept. It's the verified user of the client when it has
+// one, so that a user is limited across all workstations, and otherwise the reported user@hostname.
+func interceptOwner(ii *rpc.InterceptInfo) string {
+	if id := ii.ClientIdentity; id != nil && id.User != "" {
+		return id.User
+	}
+	return ii.Spec.Client
+}
+
 func (s *State) AddInterceptFinalizer(interceptID string, finalizer InterceptFinalizer) error {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -637,6 +731,7 @@ func (s *State) RemoveIntercept(interceptID string) bool {
 
 func (s *State) unlockedRemoveIntercept(interceptID string) bool {
 	intercept, didDelete := s.intercepts.LoadAndDelete(interceptID)
+	delete(s.handoffOffers, interceptID)
 	if state, ok := s.interceptStates[interceptID]; ok && didDelete {
 		delete(s.interceptStates, interceptID)
 		state.terminate(intercept)
@@ -645,10 +740,251 @@ func (s *State) unlockedRemoveIntercept(interceptID string) bool {
 	return didDelete
 }
 
+// OfferIntercept offers the intercept with the given ID to the session of the client with the given recipient
+// name, and returns the full name of that client. The recipient is either the full name of a client, i.e.
+// "user@host", or just the user, provided that the user has only one session. The intercept isn't moved until
+// the recipient accepts the offer using HandoffIntercept. A new offer replaces any previous offer of the same
+// intercept.
+func (s *State) OfferIntercept(interceptID, recipient string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cept, ok := s.intercepts.Load(interceptID)
+	if !ok {
+		return "", status.Errorf(codes.NotFound, "intercept %s not found", interceptID)
+	}
+	sessionID, sess, err := s.unlockedFindClientSession(recipient)
+	if err != nil {
+		return "", err
+	}
+	if err = s.unlockedCheckHandoff(cept, sessionID, sess); err != nil {
+		return "", err
+	}
+	s.handoffOffers[interceptID] = sessionID
+	return sess.name, nil
+}
+
+// GetOfferedIntercept returns the intercept with the given name that has been offered to the client session with
+// the given ID by the client with the given owner name, or by any client when the owner is empty.
+func (s *State) GetOfferedIntercept(sessionID, name, owner string) (*rpc.InterceptInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.unlockedFindOffer(sessionID, name, owner)
+}
+
+// HandoffIntercept lets the client session with the given ID accept the intercept with the given name that
+// another client has offered it using OfferIntercept, and returns the moved intercept. The owner is the name of
+// the client that offered the intercept, and may be empty when only one client has offered an intercept with
+// that name. The intercept keeps its spec, finalizers, and history, but gets a new ID and must be reviewed by
+// the agent again. The API key of the previous owner isn't handed off.
+func (s *State) HandoffIntercept(sessionID, name, owner string) (*rpc.InterceptInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cept, err := s.unlockedFindOffer(sessionID, name, owner)
+	if err != nil {
+		return nil, err
+	}
+	sess, ok := s.sessions[sessionID].(*clientSessionState)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "client session %q not found", sessionID)
+	}
+	if err = s.unlockedCheckHandoff(cept, sessionID, sess); err != nil {
+		return nil, err
+	}
+
+	interceptID := cept.Id
+	newID := sessionID + ":" + cept.Spec.Name
+	recv := proto.Clone(cept).(*rpc.InterceptInfo)
+	recv.Id = newID
+	recv.Spec.Client = sess.name
+	recv.ApiKey = ""
+	ci, _ := s.clients.Load(sessionID)
+	installID := ci.GetInstallId()
+	recv.ClientSession = &rpc.SessionInfo{SessionId: sessionID, ClusterId: cept.ClientSession.ClusterId, InstallId: &installID}
+	recv.ClientIdentity = ci.GetIdentity()
+	recv.Disposition = rpc.InterceptDispositionType_WAITING
+	recv.Message = "Waiting for Agent approval"
+	if err = s.unlockedCheckInterceptLimits(recv, interceptID); err != nil {
+		return nil, err
+	}
+
+	// The old intercept is removed before the new one is added, so that the agent never sees them both and
+	// rejects the new one as conflicting. The lock prevents other clients from creating an intercept in between.
+	delete(s.handoffOffers, interceptID)
+	is := s.interceptStates[interceptID]
+	delete(s.interceptStates, interceptID)
+	s.intercepts.Delete(interceptID)
+	s.intercepts.Store(newID, recv)
+	if is != nil {
+		is.handoff(sess.ctx, newID)
+		s.interceptStates[newID] = is
+	}
+	s.unlockedRecordDisposition(recv)
+	return recv, nil
+}
+
+// unlockedFindOffer assumes that s.mu is already locked, and returns the intercept with the given name that has
+// been offered to the client session with the given ID by the client with the given owner name, or by any client
+// when the owner is empty.
+func (s *State) unlockedFindOffer(sessionID, name, owner string) (*rpc.InterceptInfo, error) {
+	var cept *rpc.InterceptInfo
+	var owners []string
+	for id, recipient := range s.handoffOffers {
+		if recipient != sessionID {
+			continue
+		}
+		c, ok := s.intercepts.Load(id)
+		if !ok || c.Spec.Name != name {
+			continue
+		}
+		if user, _, _ := strings.Cut(c.Spec.Client, "@"); owner == "" || owner == c.Spec.Client || owner == user {
+			cept = c
+			owners = append(owners, c.Spec.Client)
+		}
+	}
+	switch len(owners) {
+	case 0:
+		return nil, status.Errorf(codes.NotFound, "no intercept named %q has been offered to this client", name)
+	case 1:
+		return cept, nil
+	default:
+		sort.Strings(owners)
+		return nil, status.Errorf(codes.FailedPrecondition, "intercept %q has been offered by several clients, use one of %s",
+			name, strings.Join(owners, ", "))
+	}
+}
+
+// unlockedCheckHandoff assumes that s.mu is already locked, and checks that the given intercept can be handed
+// off to the given client session.
+func (s *State) unlockedCheckHandoff(cept *rpc.InterceptInfo, sessionID string, sess *clientSessionState) error {
+	if sessionID == cept.ClientSession.SessionId {
+		return status.Errorf(codes.InvalidArgument, "intercept %s is already owned by %s", cept.Spec.Name, sess.name)
+	}
+	if _, ok := s.intercepts.Load(sessionID + ":" + cept.Spec.Name); ok {
+		return status.Errorf(codes.AlreadyExists, "%s already has an intercept named %q", sess.name, cept.Spec.Name)
+	}
+	if ci, _ := s.clients.Load(sessionID); ci.GetConnectOnly() {
+		return errConnectOnly(sess.name)
+	}
+	return nil
+}
+
+// unlockedFindClientSession assumes that s.mu is already locked, and returns the client session of the client
+// with the given name, which is either "user@host", or "user" when that user has exactly one session.
+func (s *State) unlockedFindClientSession(name string) (string, *clientSessionState, error) {
+	var matches []string
+	var found *clientSessionState
+	var foundID string
+	for id, ss := range s.sessions {
+		cs, ok := ss.(*clientSessionState)
+		if !ok {
+			continue
+		}
+		if cs.name == name {
+			return id, cs, nil
+		}
+		if user, _, _ := strings.Cut(cs.name, "@"); user == name {
+			matches = append(matches, cs.name)
+			foundID, found = id, cs
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", nil, status.Errorf(codes.NotFound, "no client named %q is connected", name)
+	case 1:
+		return foundID, found, nil
+	default:
+		sort.Strings(matches)
+		return "", nil, status.Errorf(codes.FailedPrecondition, "%s has several sessions, use one of %s", name, strings.Join(matches, ", "))
+	}
+}
+
+// interceptExpiryWarning is how long before an intercept exceeds the maximum intercept duration that its message
+// warns about it. A quarter of the maximum duration is used when that's shorter.
+const interceptExpiryWarning = 15 * time.Minute
+
+// ExpireIntercepts removes the intercepts that, at the given moment, have exceeded the given maximum duration. The
+// message of an active intercept that is about to exceed it tells its owner when it will be removed.
+func (s *State) ExpireIntercepts(ctx context.Context, now time.Time, maxDuration time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	warning := interceptExpiryWarning
+	if q := maxDuration / 4; q < warning {
+		warning = q
+	}
+	for id, is := range s.interceptStates {
+		expires := is.created.Add(maxDuration)
+		if !now.Before(expires) {
+			dlog.Infof(ctx, "Intercept %s removed. It has exceeded the maximum intercept duration", id)
+			s.unlockedRemoveIntercept(id)
+			continue
+		}
+		if expires.Sub(now) > warning {
+			continue
+		}
+		msg := fmt.Sprintf("the intercept will be removed at %s, when it exceeds the maximum intercept duration of %s",
+			expires.UTC().Format(time.RFC3339), maxDuration)
+		if cept, ok := s.intercepts.Load(id); ok && cept.Disposition == rpc.InterceptDispositionType_ACTIVE && cept.Message != msg {
+			dlog.Infof(ctx, "Intercept %s expires at %s", id, expires)
+			s.UpdateIntercept(id, func(cept *rpc.InterceptInfo) {
+				cept.Message = msg
+			})
+		}
+	}
+}
+
 func (s *State) GetIntercept(interceptID string) (*rpc.InterceptInfo, bool) {
 	return s.intercepts.Load(interceptID)
 }
 
+// GetInterceptDiagnostics returns the intercept with the given ID together with its disposition history.
+func (s *State) GetInterceptDiagnostics(interceptID string) (*rpc.InterceptDiagnostics, bool) {
+	intercept, ok := s.intercepts.Load(interceptID)
+	if !ok {
+		return nil, false
+	}
+	s.mu.RLock()
+	state, ok := s.interceptStates[interceptID]
+	s.mu.RUnlock()
+	d := &rpc.InterceptDiagnostics{Intercept: intercept}
+	if ok {
+		d.History = state.dispositionHistory()
+	}
+	return d, true
+}
+
+// RecordInterceptReview adds the review of an agent to the disposition history of the intercept with the given
+// ID. A review that wasn't applied is recorded too, because its message often explains why another agent
+// disagrees with the current disposition.
+func (s *State) RecordInterceptReview(interceptID, agentPodIP string, disposition rpc.InterceptDispositionType, message string, applied bool) {
+	s.mu.RLock()
+	state, ok := s.interceptStates[interceptID]
+	s.mu.RUnlock()
+	if ok {
+		state.recordDisposition(&rpc.InterceptDispositionChange{
+			Time:        timestamppb.Now(),
+			Disposition: disposition,
+			Message:     message,
+			AgentPodIp:  agentPodIP,
+			Ignored:     !applied,
+		})
+	}
+}
+
+// unlockedRecordDisposition assumes that s.mu is already locked, and adds the current disposition of the given
+// intercept to its history.
+func (s *State) unlockedRecordDisposition(intercept *rpc.InterceptInfo) {
+	if state, ok := s.interceptStates[intercept.Id]; ok {
+		state.recordDisposition(&rpc.InterceptDispositionChange{
+			Time:        timestamppb.Now(),
+			Disposition: intercept.Disposition,
+			Message:     intercept.Message,
+		})
+	}
+}
+
 func (s *State) WatchIntercepts(
 	ctx context.Context,
 	filter func(sessionID string, intercept *rpc.InterceptInfo) bool,
@@ -738,6 +1074,19 @@ func (s *State) WatchDial(sessionID string) <-chan *rpc.DialRequest {
 	return ss.Dials()
 }
 
+// ConnectClient extends the given stream to the client of the given session. A DialRequest is sent to the client,
+// and the stream is connected to the tunnel that the client creates in response. It's used for the streams of the
+// intercepts that peered traffic-managers forward to the client.
+func (s *State) ConnectClient(ctx context.Context, sessionID string, stream tunnel.Stream) (tunnel.Endpoint, error) {
+	s.mu.RLock()
+	ss, ok := s.sessions[sessionID].(*clientSessionState)
+	s.mu.RUnlock()
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "Client session %q not found", sessionID)
+	}
+	return ss.EstablishBidiPipe(ctx, stream)
+}
+
 // AgentsLookup will send the given request to all agents currently intercepted by the client identified with
 // the clientSessionID, it will then wait for results to arrive, collect those results, and return them as a
 // unique and sorted slice together with a count of how many agents that replied.
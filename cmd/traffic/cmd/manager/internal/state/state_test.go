@@ -5,8 +5,14 @@ import (
 	"testing"
 	"time"
 
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
 	manager "github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/state"
 	testdata "github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/test"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
 )
 
 type FakeClock struct {
@@ -70,6 +76,313 @@ func TestStateInternal(topT *testing.T) {
 		a.Len(agents, 0)
 	})
 
+	topT.Run("agent-upgrade", func(t *testing.T) {
+		a := assertNew(t)
+
+		clock := &FakeClock{}
+		state := manager.NewState(ctx)
+
+		d1 := state.AddAgent(testAgents["demo1"], clock.Now())
+		c1 := state.AddClient(testClients["alice"], clock.Now())
+		cept, err := state.AddIntercept(c1, "cluster-id", "", testClients["alice"], &rpc.InterceptSpec{
+			Name:      "demo",
+			Client:    "alice",
+			Agent:     "demo",
+			Namespace: "default",
+			Mechanism: "tcp",
+		}, clock.Now())
+		a.NoError(err)
+		a.Equal(rpc.InterceptDispositionType_WAITING, cept.Disposition)
+
+		disposition := func() rpc.InterceptDispositionType {
+			ii, ok := state.GetIntercept(cept.Id)
+			a.True(ok)
+			return ii.Disposition
+		}
+
+		// An agent of a newer version shares the intercept while the old one is still around
+		upgraded := proto.Clone(testAgents["demo2"]).(*rpc.AgentInfo)
+		upgraded.Version = "2"
+		for _, m := range upgraded.Mechanisms {
+			m.Version = "2"
+		}
+		d2 := state.AddAgent(upgraded, clock.Now())
+		a.Equal(rpc.InterceptDispositionType_WAITING, disposition())
+
+		state.RemoveSession(ctx, d1)
+		a.Equal(rpc.InterceptDispositionType_WAITING, disposition())
+
+		// The intercept waits for the agent of a recreated pod
+		state.RemoveSession(ctx, d2)
+		a.Equal(rpc.InterceptDispositionType_NO_AGENT, disposition())
+		state.AddAgent(upgraded, clock.Now())
+		a.Equal(rpc.InterceptDispositionType_WAITING, disposition())
+	})
+
+	topT.Run("disposition-history", func(t *testing.T) {
+		a := assertNew(t)
+
+		clock := &FakeClock{}
+		state := manager.NewState(ctx)
+
+		d1 := state.AddAgent(testAgents["demo1"], clock.Now())
+		c1 := state.AddClient(testClients["alice"], clock.Now())
+		cept, err := state.AddIntercept(c1, "cluster-id", "", testClients["alice"], &rpc.InterceptSpec{
+			Name:      "demo",
+			Client:    "alice",
+			Agent:     "demo",
+			Namespace: "default",
+			Mechanism: "tcp",
+		}, clock.Now())
+		a.NoError(err)
+
+		state.RecordInterceptReview(cept.Id, "10.0.0.1", rpc.InterceptDispositionType_ACTIVE, "", true)
+		state.RecordInterceptReview(cept.Id, "10.0.0.2", rpc.InterceptDispositionType_AGENT_ERROR, "Conflicts", false)
+		state.RemoveSession(ctx, d1)
+
+		d, ok := state.GetInterceptDiagnostics(cept.Id)
+		a.True(ok)
+		a.Equal(cept.Id, d.Intercept.Id)
+		h := d.History
+		a.Len(h, 4)
+		a.Equal(rpc.InterceptDispositionType_WAITING, h[0].Disposition)
+		a.Equal("", h[0].AgentPodIp)
+		a.Equal(rpc.InterceptDispositionType_ACTIVE, h[1].Disposition)
+		a.Equal("10.0.0.1", h[1].AgentPodIp)
+		a.False(h[1].Ignored)
+		a.Equal(rpc.InterceptDispositionType_AGENT_ERROR, h[2].Disposition)
+		a.Equal("Conflicts", h[2].Message)
+		a.True(h[2].Ignored)
+		a.Equal(rpc.InterceptDispositionType_NO_AGENT, h[3].Disposition)
+
+		_, ok = state.GetInterceptDiagnostics("no-such-intercept")
+		a.False(ok)
+	})
+
+	topT.Run("intercept-limits", func(t *testing.T) {
+		a := assertNew(t)
+
+		clock := &FakeClock{}
+		ctx := managerutil.WithEnv(ctx, &managerutil.Env{MaxInterceptsPerUser: 2, MaxInterceptsPerNamespace: 3})
+		state := manager.NewState(ctx)
+
+		c1 := state.AddClient(testClients["alice"], clock.Now())
+		c2 := state.AddClient(testClients["bob"], clock.Now())
+		intercept := func(sessionID, client, name, namespace string) error {
+			_, err := state.AddIntercept(sessionID, "cluster-id", "", testClients[client], &rpc.InterceptSpec{
+				Name:      name,
+				Client:    client,
+				Agent:     name,
+				Namespace: namespace,
+				Mechanism: "tcp",
+			}, clock.Now())
+			return err
+		}
+		a.NoError(intercept(c1, "alice", "hello", "default"))
+		a.NoError(intercept(c1, "alice", "demo", "default"))
+		err := intercept(c1, "alice", "echo", "other")
+		a.Equal(codes.ResourceExhausted, status.Code(err))
+		a.Contains(err.Error(), "at most 2 intercepts per user")
+
+		a.NoError(intercept(c2, "bob", "echo", "default"))
+		err = intercept(c2, "bob", "web", "default")
+		a.Equal(codes.ResourceExhausted, status.Code(err))
+		a.Contains(err.Error(), "at most 3 intercepts per namespace")
+		a.NoError(intercept(c2, "bob", "web", "other"))
+
+		// Active intercepts are warned before they exceed the maximum duration, and removed when they do
+		state.UpdateIntercept(c1+":hello", func(cept *rpc.InterceptInfo) {
+			cept.Disposition = rpc.InterceptDispositionType_ACTIVE
+			cept.Message = ""
+		})
+		clock.When = 30 * 60
+		state.ExpireIntercepts(ctx, clock.Now(), time.Hour)
+		cept, ok := state.GetIntercept(c1 + ":hello")
+		a.True(ok)
+		a.Empty(cept.Message)
+
+		clock.When = 50 * 60
+		state.ExpireIntercepts(ctx, clock.Now(), time.Hour)
+		cept, ok = state.GetIntercept(c1 + ":hello")
+		a.True(ok)
+		a.Equal("the intercept will be removed at 2000-01-01T01:00:00Z, when it exceeds the maximum intercept duration of 1h0m0s", cept.Message)
+		cept, ok = state.GetIntercept(c1 + ":demo")
+		a.True(ok)
+		a.NotContains(cept.Message, "will be removed", "the message of an inactive intercept was replaced")
+
+		clock.When = 60 * 60
+		state.ExpireIntercepts(ctx, clock.Now(), time.Hour)
+		_, ok = state.GetIntercept(c1 + ":hello")
+		a.False(ok)
+		a.NoError(intercept(c1, "alice", "echo", "other"))
+	})
+
+	topT.Run("intercept-limits-identity", func(t *testing.T) {
+		a := assertNew(t)
+
+		clock := &FakeClock{}
+		ctx := managerutil.WithEnv(ctx, &managerutil.Env{MaxInterceptsPerUser: 1})
+		state := manager.NewState(ctx)
+
+		// The same verified user on two workstations is limited across both
+		laptop := proto.Clone(testClients["alice"]).(*rpc.ClientInfo)
+		laptop.Identity = &rpc.ClientIdentity{User: "alice@bigcorp.com", Method: "oidc"}
+		desktop := proto.Clone(laptop).(*rpc.ClientInfo)
+		desktop.Name = "alice@charmander.bigcorp.com"
+		c1 := state.AddClient(laptop, clock.Now())
+		c2 := state.AddClient(desktop, clock.Now())
+		intercept := func(sessionID string, client *rpc.ClientInfo, name string) (*rpc.InterceptInfo, error) {
+			return state.AddIntercept(sessionID, "cluster-id", "", client, &rpc.InterceptSpec{
+				Name:      name,
+				Client:    client.Name,
+				Agent:     name,
+				Namespace: "default",
+				Mechanism: "tcp",
+			}, clock.Now())
+		}
+		cept, err := intercept(c1, laptop, "hello")
+		a.NoError(err)
+		a.Equal("alice@bigcorp.com", cept.ClientIdentity.GetUser())
+		_, err = intercept(c2, desktop, "echo")
+		a.Equal(codes.ResourceExhausted, status.Code(err))
+		a.Contains(err.Error(), "alice@bigcorp.com already has 1 intercepts")
+	})
+
+	topT.Run("intercept-handoff", func(t *testing.T) {
+		a := assertNew(t)
+
+		clock := &FakeClock{}
+		state := manager.NewState(ctx)
+
+		c1 := state.AddClient(testClients["alice"], clock.Now())
+		c2 := state.AddClient(testClients["bob"], clock.Now())
+		cept, err := state.AddIntercept(c1, "cluster-id", "my-api-key", testClients["alice"], &rpc.InterceptSpec{
+			Name:       "demo",
+			Client:     testClients["alice"].Name,
+			Agent:      "demo",
+			Namespace:  "default",
+			Mechanism:  "tcp",
+			TargetHost: "127.0.0.1",
+			TargetPort: 8080,
+		}, clock.Now())
+		a.NoError(err)
+		finalized := ""
+		a.NoError(state.AddInterceptFinalizer(cept.Id, func(_ context.Context, ii *rpc.InterceptInfo) error {
+			finalized = ii.Id
+			return nil
+		}))
+		state.RecordInterceptReview(cept.Id, "10.0.0.1", rpc.InterceptDispositionType_ACTIVE, "", true)
+
+		_, err = state.OfferIntercept(cept.Id, "nobody")
+		a.Equal(codes.NotFound, status.Code(err))
+		_, err = state.OfferIntercept(cept.Id, "alice")
+		a.Equal(codes.InvalidArgument, status.Code(err))
+
+		// Nothing moves until the recipient accepts an offer
+		_, err = state.HandoffIntercept(c2, "demo", "")
+		a.Equal(codes.NotFound, status.Code(err))
+		name, err := state.OfferIntercept(cept.Id, "bob")
+		a.NoError(err)
+		a.Equal(testClients["bob"].Name, name)
+		_, ok := state.GetIntercept(cept.Id)
+		a.True(ok)
+		_, err = state.HandoffIntercept(c1, "demo", "")
+		a.Equal(codes.NotFound, status.Code(err))
+		_, err = state.HandoffIntercept(c2, "demo", "carol")
+		a.Equal(codes.NotFound, status.Code(err))
+		offered, err := state.GetOfferedIntercept(c2, "demo", "alice")
+		a.NoError(err)
+		a.Equal(cept.Id, offered.Id)
+
+		moved, err := state.HandoffIntercept(c2, "demo", "")
+		a.NoError(err)
+		a.Equal(c2+":demo", moved.Id)
+		a.Equal(c2, moved.ClientSession.SessionId)
+		a.Equal(testClients["bob"].Name, moved.Spec.Client)
+		a.Equal(int32(8080), moved.Spec.TargetPort)
+		a.Equal(rpc.InterceptDispositionType_WAITING, moved.Disposition)
+		a.Empty(moved.ApiKey)
+		_, ok = state.GetIntercept(cept.Id)
+		a.False(ok)
+
+		// The offer is consumed
+		_, err = state.HandoffIntercept(c2, "demo", "")
+		a.Equal(codes.NotFound, status.Code(err))
+
+		// The history and the finalizers move with the intercept
+		d, ok := state.GetInterceptDiagnostics(moved.Id)
+		a.True(ok)
+		a.Len(d.History, 3)
+		a.Equal("", finalized)
+		a.True(state.RemoveIntercept(moved.Id))
+		a.Equal(moved.Id, finalized)
+
+		// A user with several sessions must be named with the host
+		alice2 := proto.Clone(testClients["alice"]).(*rpc.ClientInfo)
+		alice2.Name = "alice@charmander.bigcorp.com"
+		c3 := state.AddClient(alice2, clock.Now())
+		cept, err = state.AddIntercept(c2, "cluster-id", "", testClients["bob"], &rpc.InterceptSpec{
+			Name:      "demo",
+			Client:    testClients["bob"].Name,
+			Agent:     "demo",
+			Namespace: "default",
+			Mechanism: "tcp",
+		}, clock.Now())
+		a.NoError(err)
+		_, err = state.OfferIntercept(cept.Id, "alice")
+		a.Equal(codes.FailedPrecondition, status.Code(err))
+		_, err = state.OfferIntercept(cept.Id, alice2.Name)
+		a.NoError(err)
+		moved, err = state.HandoffIntercept(c3, "demo", "bob")
+		a.NoError(err)
+		a.Equal(c3+":demo", moved.Id)
+
+		// An offer is withdrawn when the intercept is removed
+		cept, err = state.AddIntercept(c2, "cluster-id", "", testClients["bob"], &rpc.InterceptSpec{
+			Name:      "other",
+			Client:    testClients["bob"].Name,
+			Agent:     "other",
+			Namespace: "default",
+			Mechanism: "tcp",
+		}, clock.Now())
+		a.NoError(err)
+		_, err = state.OfferIntercept(cept.Id, alice2.Name)
+		a.NoError(err)
+		a.True(state.RemoveIntercept(cept.Id))
+		_, err = state.GetOfferedIntercept(c3, "other", "")
+		a.Equal(codes.NotFound, status.Code(err))
+	})
+
+	topT.Run("intercept-connect-only", func(t *testing.T) {
+		a := assertNew(t)
+
+		clock := &FakeClock{}
+		state := manager.NewState(ctx)
+
+		bob := proto.Clone(testClients["bob"]).(*rpc.ClientInfo)
+		bob.ConnectOnly = true
+		c1 := state.AddClient(testClients["alice"], clock.Now())
+		c2 := state.AddClient(bob, clock.Now())
+		spec := &rpc.InterceptSpec{
+			Name:      "demo",
+			Client:    bob.Name,
+			Agent:     "demo",
+			Namespace: "default",
+			Mechanism: "tcp",
+		}
+		_, err := state.AddIntercept(c2, "cluster-id", "", bob, spec, clock.Now())
+		a.Equal(codes.PermissionDenied, status.Code(err))
+
+		// A connect-only client can't receive an intercept either
+		spec.Client = testClients["alice"].Name
+		cept, err := state.AddIntercept(c1, "cluster-id", "", testClients["alice"], spec, clock.Now())
+		a.NoError(err)
+		_, err = state.OfferIntercept(cept.Id, "bob")
+		a.Equal(codes.PermissionDenied, status.Code(err))
+		_, ok := state.GetIntercept(cept.Id)
+		a.True(ok)
+	})
+
 	topT.Run("presence-redundant", func(t *testing.T) {
 		a := assertNew(t)
 
@@ -124,4 +437,48 @@ func TestStateInternal(topT *testing.T) {
 		a.False(state.Mark(c2, clock.Now()))
 		a.False(state.Mark(c3, clock.Now()))
 	})
+
+	topT.Run("presence-resume", func(t *testing.T) {
+		a := assertNew(t)
+
+		clock := &FakeClock{}
+		epoch := clock.Now()
+		state := manager.NewState(ctx)
+
+		alice := proto.Clone(testClients["alice"]).(*rpc.ClientInfo)
+		alice.ResumeToken = "alice-token"
+		c1 := state.AddClient(alice, clock.Now())
+		c2 := state.AddClient(testClients["bob"], clock.Now())
+
+		// A live session is just marked
+		_, err := state.ResumeSession(ctx, c1, "alice-token", clock.Now())
+		a.NoError(err)
+		_, err = state.ResumeSession(ctx, c1, "wrong-token", clock.Now())
+		a.Error(err)
+
+		clock.When = 10
+		moment := epoch.Add(5 * time.Second)
+		state.ExpireSessions(ctx, moment, moment)
+		a.False(state.HasClient(c1))
+		a.False(state.HasClient(c2))
+
+		// Only sessions with a matching resume token can be resumed
+		_, err = state.ResumeSession(ctx, c1, "wrong-token", clock.Now())
+		a.Error(err)
+		_, err = state.ResumeSession(ctx, c2, "", clock.Now())
+		a.Error(err)
+		_, err = state.ResumeSession(ctx, c1, "alice-token", clock.Now())
+		a.NoError(err)
+		a.True(state.HasClient(c1))
+		a.Equal(alice, state.GetClient(c1))
+
+		// A suspended session that has expired can't be resumed
+		clock.When = 20
+		moment = epoch.Add(15 * time.Second)
+		state.ExpireSessions(ctx, moment, moment)
+		a.False(state.HasClient(c1))
+		state.ExpireSuspendedSessions(ctx, moment)
+		_, err = state.ResumeSession(ctx, c1, "alice-token", clock.Now())
+		a.Error(err)
+	})
 }
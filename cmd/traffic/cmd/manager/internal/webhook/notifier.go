@@ -0,0 +1,195 @@
+// Package webhook posts notifications about the lifecycle of client sessions and intercepts to an outbound
+// webhook, so that platform teams can wire up chat notifications or policy automation without scraping logs.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// The types of the events that a Notifier sends.
+const (
+	SessionStarted   = "io.telepresence.session.started"
+	SessionEnded     = "io.telepresence.session.ended"
+	InterceptStarted = "io.telepresence.intercept.started"
+	InterceptEnded   = "io.telepresence.intercept.ended"
+)
+
+// The formats of the requests that a Notifier sends.
+const (
+	// FormatCloudEvents posts each event as a CloudEvent 1.0 in structured content mode.
+	FormatCloudEvents = "cloudevents"
+
+	// FormatSlack posts the message of each event in the format of a Slack incoming webhook.
+	FormatSlack = "slack"
+)
+
+const (
+	queueSize   = 256
+	postTimeout = 10 * time.Second
+)
+
+// Event is the payload of a CloudEvent.
+type Event struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`
+	Subject         string    `json:"subject,omitempty"`
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype"`
+	Data            any       `json:"data"`
+
+	message string
+}
+
+// Session is the data of a session event. The User is only set when the traffic-manager verifies the identities
+// of its clients.
+type Session struct {
+	ID        string `json:"id"`
+	Client    string `json:"client"`
+	User      string `json:"user,omitempty"`
+	InstallID string `json:"installId,omitempty"`
+}
+
+// Intercept is the data of an intercept event. The User is only set when the traffic-manager verifies the
+// identities of its clients.
+type Intercept struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Namespace    string `json:"namespace"`
+	Workload     string `json:"workload"`
+	WorkloadKind string `json:"workloadKind"`
+	Mechanism    string `json:"mechanism"`
+	Client       string `json:"client"`
+	User         string `json:"user,omitempty"`
+	SessionID    string `json:"sessionId"`
+}
+
+// Notifier sends events to a webhook. The events are queued and sent in the order that they were created by the
+// Run method. A nil Notifier discards all events.
+type Notifier struct {
+	url    string
+	format string
+	source string
+	client *http.Client
+	queue  chan *Event
+}
+
+// NewNotifier returns a Notifier that posts events in the given format to the given URL. The source identifies
+// the traffic-manager in the events. A nil Notifier is returned when the URL is empty.
+func NewNotifier(webhookURL, format, source string) (*Notifier, error) {
+	if webhookURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook URL: %w", withoutURL(err))
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.New("invalid webhook URL: the scheme must be http or https")
+	}
+	switch format {
+	case "":
+		format = FormatCloudEvents
+	case FormatCloudEvents, FormatSlack:
+	default:
+		return nil, fmt.Errorf("invalid webhook format %q, must be %s or %s", format, FormatCloudEvents, FormatSlack)
+	}
+	return &Notifier{
+		url:    webhookURL,
+		format: format,
+		source: source,
+		client: &http.Client{Timeout: postTimeout},
+		queue:  make(chan *Event, queueSize),
+	}, nil
+}
+
+// Notify queues an event of the given type. The subject identifies the session or intercept, the message is a
+// human-readable description of the event, and the data is its machine-readable description. The event is
+// dropped with a warning when the queue is full, so that a slow webhook never blocks the traffic-manager.
+func (n *Notifier) Notify(ctx context.Context, eventType, subject, message string, data any) {
+	if n == nil {
+		return
+	}
+	ev := &Event{
+		SpecVersion:     "1.0",
+		ID:              uuid.New().String(),
+		Source:          n.source,
+		Type:            eventType,
+		Subject:         subject,
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		Data:            data,
+		message:         message,
+	}
+	select {
+	case n.queue <- ev:
+	default:
+		dlog.Warnf(ctx, "webhook: queue is full, dropping %s event for %s", eventType, subject)
+	}
+}
+
+// Run posts the queued events until the given context is cancelled.
+func (n *Notifier) Run(ctx context.Context) error {
+	if n == nil {
+		return nil
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev := <-n.queue:
+			if err := n.post(ctx, ev); err != nil {
+				dlog.Errorf(ctx, "webhook: unable to post %s event for %s: %v", ev.Type, ev.Subject, err)
+			}
+		}
+	}
+}
+
+func (n *Notifier) post(ctx context.Context, ev *Event) error {
+	var body any = ev
+	contentType := "application/cloudevents+json"
+	if n.format == FormatSlack {
+		body = map[string]string{"text": ev.message}
+		contentType = "application/json"
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	rq, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	rq.Header.Set("Content-Type", contentType)
+	rs, err := n.client.Do(rq)
+	if err != nil {
+		return withoutURL(err)
+	}
+	rs.Body.Close()
+	if rs.StatusCode < 200 || rs.StatusCode > 299 {
+		return fmt.Errorf("the webhook responded with %s", rs.Status)
+	}
+	return nil
+}
+
+// withoutURL strips the URL from the given error. The URL of a webhook often contains a secret, so it must not be
+// logged.
+func withoutURL(err error) error {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		return ue.Err
+	}
+	return err
+}
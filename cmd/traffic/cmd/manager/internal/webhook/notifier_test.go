@@ -0,0 +1,86 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+type request struct {
+	contentType string
+	body        map[string]any
+}
+
+func webhookServer(t *testing.T) (string, <-chan request) {
+	rqs := make(chan request, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		var body map[string]any
+		assert.NoError(t, json.Unmarshal(data, &body))
+		rqs <- request{contentType: r.Header.Get("Content-Type"), body: body}
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, rqs
+}
+
+func receive(t *testing.T, rqs <-chan request) request {
+	select {
+	case rq := <-rqs:
+		return rq
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "no request was received")
+		return request{}
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	url, rqs := webhookServer(t)
+
+	n, err := NewNotifier(url, "", "/telepresence/clusters/1234/traffic-manager")
+	require.NoError(t, err)
+	go func() { _ = n.Run(ctx) }()
+	n.Notify(ctx, SessionStarted, "abc", "jane@laptop connected", &Session{ID: "abc", Client: "jane@laptop"})
+	rq := receive(t, rqs)
+	assert.Equal(t, "application/cloudevents+json", rq.contentType)
+	assert.Equal(t, "1.0", rq.body["specversion"])
+	assert.Equal(t, SessionStarted, rq.body["type"])
+	assert.Equal(t, "abc", rq.body["subject"])
+	assert.Equal(t, "/telepresence/clusters/1234/traffic-manager", rq.body["source"])
+	assert.NotEmpty(t, rq.body["id"])
+	assert.Equal(t, map[string]any{"id": "abc", "client": "jane@laptop"}, rq.body["data"])
+
+	n, err = NewNotifier(url, FormatSlack, "")
+	require.NoError(t, err)
+	go func() { _ = n.Run(ctx) }()
+	n.Notify(ctx, SessionEnded, "abc", "jane@laptop disconnected", &Session{ID: "abc", Client: "jane@laptop"})
+	rq = receive(t, rqs)
+	assert.Equal(t, "application/json", rq.contentType)
+	assert.Equal(t, map[string]any{"text": "jane@laptop disconnected"}, rq.body)
+}
+
+func TestNewNotifier(t *testing.T) {
+	n, err := NewNotifier("", FormatSlack, "")
+	require.NoError(t, err)
+	assert.Nil(t, n)
+	n.Notify(context.Background(), SessionStarted, "abc", "", nil) // no-op
+
+	_, err = NewNotifier("ftp://example.com/hook", "", "")
+	assert.Error(t, err)
+	_, err = NewNotifier("https://example.com/hook", "xml", "")
+	assert.Error(t, err)
+	_, err = NewNotifier("https://example.com/%zz/secret", "", "")
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "secret")
+}
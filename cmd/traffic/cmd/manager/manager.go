@@ -2,6 +2,7 @@ package manager
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -10,6 +11,7 @@ import (
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/health/grpc_health_v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 
@@ -19,6 +21,9 @@ import (
 	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/mutator"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/benchmark"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/dnet"
 	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
 	"github.com/telepresenceio/telepresence/v2/pkg/version"
 )
@@ -41,11 +46,23 @@ func Main(ctx context.Context, _ ...string) error {
 		return fmt.Errorf("unable to create the Kubernetes Interface from InClusterConfig: %w", err)
 	}
 	ctx = k8sapi.WithK8sInterface(ctx, ki)
+	di, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("unable to create the Kubernetes dynamic Interface from InClusterConfig: %w", err)
+	}
+	ctx = k8sapi.WithDynamicInterface(ctx, di)
+	ctx = k8sapi.WithWorkloadKinds(ctx, k8sapi.MergeWorkloadKinds(managerutil.GetEnv(ctx).WorkloadKinds))
+	ctx = managerutil.WithEventBroadcaster(ctx)
 	mgr, ctx, err := NewManager(ctx)
 	if err != nil {
 		return fmt.Errorf("unable to initialize traffic manager: %w", err)
 	}
 
+	// The intercepts didn't survive the restart, so the workloads that they changed are restored before any
+	// new intercepts can be created.
+	mgr.state.ResumeOrphanedAutoscalers(ctx)
+	mgr.state.RestoreOrphanedMinScales(ctx)
+
 	g := dgroup.NewGroup(ctx, dgroup.GroupConfig{
 		EnableSignalHandling: true,
 	})
@@ -57,6 +74,10 @@ func Main(ctx context.Context, _ ...string) error {
 
 	g.Go("session-gc", mgr.runSessionGCLoop)
 
+	g.Go("webhook", mgr.notifier.Run)
+
+	g.Go("client-dns", mgr.exposer.Run)
+
 	// Wait for exit
 	return g.Wait()
 }
@@ -71,10 +92,21 @@ func (m *Manager) serveHTTP(ctx context.Context) error {
 	}
 
 	grpcHandler := grpc.NewServer(opts...)
-	httpHandler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintf(w, "Hello World from: %s\n", r.URL.Path)
+	h2Config := (&client.Grpc{
+		InitialWindowSize:     env.InitialWindowSize,
+		InitialConnWindowSize: env.InitialConnWindowSize,
+	}).HTTP2Server()
+
+	// Clients that can't reach the gRPC API directly may tunnel it through a websocket.
+	httpHandler := http.NewServeMux()
+	httpHandler.Handle(dnet.WebsocketPath, dnet.WebsocketHandler(ctx, h2Config, grpcHandler, func(rq *http.Request) error {
+		return m.authorizeWebsocket(ctx, rq)
 	}))
+	httpHandler.Handle("/", benchmark.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Hello World from: %s\n", r.URL.Path)
+	})))
 	sc := &dhttp.ServerConfig{
+		HTTP2Config: h2Config,
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
 				grpcHandler.ServeHTTP(w, r)
@@ -87,7 +119,45 @@ func (m *Manager) serveHTTP(ctx context.Context) error {
 	rpc.RegisterManagerServer(grpcHandler, m)
 	grpc_health_v1.RegisterHealthServer(grpcHandler, &HealthChecker{})
 
-	return sc.ListenAndServe(ctx, host+":"+port)
+	if m.federationTLS == nil {
+		return sc.ListenAndServe(ctx, host+":"+port)
+	}
+
+	// The peered traffic-managers use a separate port, on which they must authenticate using mutual TLS. The
+	// verified TLS connection is what makes the manager trust the client identities that a peer passes on.
+	g := dgroup.NewGroup(ctx, dgroup.GroupConfig{})
+	g.Go("api", func(ctx context.Context) error {
+		return sc.ListenAndServe(ctx, host+":"+port)
+	})
+	g.Go("federation", func(ctx context.Context) error {
+		fc := &dhttp.ServerConfig{
+			HTTP2Config: h2Config,
+			TLSConfig:   m.federationTLS,
+			Handler:     grpcHandler,
+		}
+		return fc.ListenAndServeTLS(ctx, host+":"+env.FederationPort, "", "")
+	})
+	return g.Wait()
+}
+
+// authorizeWebsocket authorizes a websocket tunnel when its upgrade request carries a bearer token that verifies as
+// the identity of a client. The websocket is typically reached through an ingress, so it's only served when the
+// identities are verified.
+func (m *Manager) authorizeWebsocket(ctx context.Context, rq *http.Request) error {
+	if m.identities == nil {
+		return errors.New("websocket tunnels require that the identities of clients are verified")
+	}
+	token := dnet.BearerToken(rq)
+	if token == "" {
+		return errors.New("websocket tunnels require an identity token")
+	}
+	id, err := m.identities.Verify(ctx, token)
+	if err != nil {
+		dlog.Infof(ctx, "Rejected websocket tunnel from %s: %v", rq.RemoteAddr, err)
+		return err
+	}
+	dlog.Debugf(ctx, "Websocket tunnel from %s verified as %s", rq.RemoteAddr, id.User)
+	return nil
 }
 
 func (m *Manager) runSessionGCLoop(ctx context.Context) error {
@@ -0,0 +1,65 @@
+package managerutil
+
+import (
+	"context"
+	"sync"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// RolloutConcurrency returns the maximum number of workloads that the traffic-manager modifies or rolls out
+// concurrently.
+func RolloutConcurrency(ctx context.Context) int {
+	if env := GetEnv(ctx); env != nil && env.AgentRolloutConcurrency > 0 {
+		return env.AgentRolloutConcurrency
+	}
+	return 1
+}
+
+// ForEachWorkload calls fn with the indexes 0 to n-1 of n workloads, using at most RolloutConcurrency goroutines
+// at a time, and returns when all calls have returned. The errors returned by fn are logged, and so is the aggregated
+// progress of the operation, using the given past tense verb, e.g. "Rolled out".
+func ForEachWorkload(ctx context.Context, verb string, n int, fn func(context.Context, int) error) {
+	if n == 0 {
+		return
+	}
+	step := n / 10
+	if step == 0 {
+		step = 1
+	}
+	var mu sync.Mutex
+	completed, failed := 0, 0
+	sem := make(chan struct{}, RolloutConcurrency(ctx))
+	wg := sync.WaitGroup{}
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		select {
+		case <-ctx.Done():
+			continue
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			err := fn(ctx, i)
+			mu.Lock()
+			defer mu.Unlock()
+			completed++
+			if err != nil {
+				failed++
+				dlog.Error(ctx, err)
+			}
+			if completed < n && completed%step == 0 {
+				dlog.Infof(ctx, "%s %d of %d workloads", verb, completed, n)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if failed > 0 {
+		dlog.Infof(ctx, "%s %d of %d workloads, %d failed", verb, completed-failed, n, failed)
+	} else {
+		dlog.Infof(ctx, "%s %d of %d workloads", verb, completed, n)
+	}
+}
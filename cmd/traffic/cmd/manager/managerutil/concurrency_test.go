@@ -0,0 +1,46 @@
+package managerutil_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+)
+
+func TestForEachWorkload(t *testing.T) {
+	ctx := managerutil.WithEnv(dlog.NewTestContext(t, false), &managerutil.Env{AgentRolloutConcurrency: 3})
+	assert.Equal(t, 3, managerutil.RolloutConcurrency(ctx))
+
+	const n = 20
+	var running, maxRunning int32
+	called := make([]int32, n)
+	managerutil.ForEachWorkload(ctx, "Tested", n, func(ctx context.Context, i int) error {
+		r := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if r <= m || atomic.CompareAndSwapInt32(&maxRunning, m, r) {
+				break
+			}
+		}
+		atomic.AddInt32(&called[i], 1)
+		time.Sleep(5 * time.Millisecond)
+		if i%5 == 0 {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	for i, c := range called {
+		assert.Equal(t, int32(1), c, "workload %d", i)
+	}
+	assert.LessOrEqual(t, maxRunning, int32(3))
+	assert.Greater(t, maxRunning, int32(1))
+
+	assert.Equal(t, 1, managerutil.RolloutConcurrency(managerutil.WithEnv(ctx, &managerutil.Env{})))
+}
@@ -28,6 +28,48 @@ func AgentsAreCompatible(agents []*rpc.AgentInfo) bool {
 	return true
 }
 
+// AgentsAreUpgrading returns whether the specified agents have the same name,
+// product, and mechanism names, but differ in versions. That's the case when the
+// traffic-agents of a workload are upgraded and its Pods are replaced one by one,
+// and the agents can then still share the intercepts of the workload.
+func AgentsAreUpgrading(agents []*rpc.AgentInfo) bool {
+	if len(agents) < 2 {
+		return false
+	}
+
+	golden := agents[0]
+	for _, agent := range agents[1:] {
+		names := golden.Name == agent.Name
+		products := golden.Product == agent.Product
+		mechanisms := mechanismNamesAreTheSame(golden.Mechanisms, agent.Mechanisms)
+
+		if !(names && products && mechanisms) {
+			return false
+		}
+	}
+
+	return !AgentsAreCompatible(agents)
+}
+
+// mechanismNamesAreTheSame returns whether both lists of mechanisms contain
+// mechanisms with the same names, regardless of their product and version.
+func mechanismNamesAreTheSame(a, b []*rpc.AgentInfo_Mechanism) bool {
+	if len(a) != len(b) || len(a) == 0 {
+		return false
+	}
+
+	names := make(map[string]struct{}, len(a))
+	for _, mechanism := range a {
+		names[mechanism.Name] = struct{}{}
+	}
+	for _, mechanism := range b {
+		if _, ok := names[mechanism.Name]; !ok {
+			return false
+		}
+	}
+	return len(names) == len(a)
+}
+
 // mechanismsAreTheSame returns whether both lists of mechanisms contain the
 // same mechanisms (name, product, and version). As a sanity check, this helper
 // verifies that the mechanism names in each list are distinct.
@@ -62,3 +104,13 @@ func mechanismsAreTheSame(a, b []*rpc.AgentInfo_Mechanism) bool {
 
 	return true
 }
+
+// ClientName returns the name of a client for use in events, logs, and notifications. It's the verified user
+// followed by the reported user@hostname name when the client's identity is verified, and otherwise just the
+// reported name.
+func ClientName(name string, identity *rpc.ClientIdentity) string {
+	if identity == nil || identity.User == "" {
+		return name
+	}
+	return identity.User + " (" + name + ")"
+}
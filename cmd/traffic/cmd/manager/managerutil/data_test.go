@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
 
 	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
 	testdata "github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/test"
@@ -46,3 +47,20 @@ func TestAgentsAreCompatible(t *testing.T) {
 	a.False(AgentsAreCompatible([]*rpc.AgentInfo{}))
 	a.False(AgentsAreCompatible([]*rpc.AgentInfo{helloAgent, helloProAgent}))
 }
+
+func TestAgentsAreUpgrading(t *testing.T) {
+	a := assert.New(t)
+
+	testAgents := testdata.GetTestAgents(t)
+	demoAgent1 := testAgents["demo1"]
+	demoAgent2 := proto.Clone(testAgents["demo2"]).(*rpc.AgentInfo)
+	demoAgent2.Version = "2"
+	for _, m := range demoAgent2.Mechanisms {
+		m.Version = "2"
+	}
+
+	a.True(AgentsAreUpgrading([]*rpc.AgentInfo{demoAgent1, demoAgent2}))
+	a.False(AgentsAreUpgrading([]*rpc.AgentInfo{demoAgent1, testAgents["demo2"]}))
+	a.False(AgentsAreUpgrading([]*rpc.AgentInfo{demoAgent1}))
+	a.False(AgentsAreUpgrading([]*rpc.AgentInfo{testAgents["hello"], testAgents["helloPro"]}))
+}
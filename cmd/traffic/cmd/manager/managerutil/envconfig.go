@@ -3,10 +3,13 @@ package managerutil
 import (
 	"context"
 	"strings"
+	"time"
 
 	"github.com/sethvargo/go-envconfig"
 	"k8s.io/apimachinery/pkg/api/resource"
 
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+
 	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
 	"github.com/telepresenceio/telepresence/v2/pkg/agentmap"
 	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
@@ -21,15 +24,112 @@ type Env struct {
 	SystemAHost string `env:"SYSTEMA_HOST,default=app.getambassador.io"`
 	SystemAPort string `env:"SYSTEMA_PORT,default=443"`
 
-	ManagerNamespace    string                     `env:"MANAGER_NAMESPACE,default="`
-	ManagedNamespaces   string                     `env:"MANAGED_NAMESPACES,default="`
-	AgentRegistry       string                     `env:"TELEPRESENCE_REGISTRY,default=docker.io/datawire"`
-	AgentImage          string                     `env:"TELEPRESENCE_AGENT_IMAGE,default="`
-	AgentPort           int32                      `env:"TELEPRESENCE_AGENT_PORT,default=9900"`
-	APIPort             int32                      `env:"TELEPRESENCE_API_PORT,default="`
-	MaxReceiveSize      resource.Quantity          `env:"TELEPRESENCE_MAX_RECEIVE_SIZE,default=4Mi"`
-	AppProtocolStrategy k8sapi.AppProtocolStrategy `env:"TELEPRESENCE_APP_PROTO_STRATEGY,default="`
-	AgentInjectPolicy   agentconfig.InjectPolicy   `env:"AGENT_INJECT_POLICY,default="`
+	ManagerNamespace      string                           `env:"MANAGER_NAMESPACE,default="`
+	ManagedNamespaces     string                           `env:"MANAGED_NAMESPACES,default="`
+	AgentRegistry         string                           `env:"TELEPRESENCE_REGISTRY,default=docker.io/datawire"`
+	AgentImage            string                           `env:"TELEPRESENCE_AGENT_IMAGE,default="`
+	AgentArchImages       map[string]string                `env:"TELEPRESENCE_AGENT_ARCH_IMAGES,default="`
+	AgentPort             int32                            `env:"TELEPRESENCE_AGENT_PORT,default=9900"`
+	APIPort               int32                            `env:"TELEPRESENCE_API_PORT,default="`
+	MaxReceiveSize        resource.Quantity                `env:"TELEPRESENCE_MAX_RECEIVE_SIZE,default=4Mi"`
+	InitialWindowSize     resource.Quantity                `env:"TELEPRESENCE_INITIAL_WINDOW_SIZE,default=0"`
+	InitialConnWindowSize resource.Quantity                `env:"TELEPRESENCE_INITIAL_CONN_WINDOW_SIZE,default=0"`
+	AppProtocolStrategy   k8sapi.AppProtocolStrategy       `env:"TELEPRESENCE_APP_PROTO_STRATEGY,default="`
+	AgentInjectPolicy     agentconfig.InjectPolicy         `env:"AGENT_INJECT_POLICY,default="`
+	AgentSecurityProfile  agentconfig.SecurityProfile      `env:"TELEPRESENCE_AGENT_SECURITY_PROFILE,default="`
+	AgentSecurityContext  agentconfig.SecurityContext      `env:"TELEPRESENCE_AGENT_SECURITY_CONTEXT,default="`
+	AgentResources        agentconfig.ResourceRequirements `env:"TELEPRESENCE_AGENT_RESOURCES,default="`
+	AgentResourcePolicy   agentconfig.ResourcePolicy       `env:"TELEPRESENCE_AGENT_RESOURCE_POLICY,default="`
+	AgentPullSecrets      []string                         `env:"TELEPRESENCE_AGENT_IMAGE_PULL_SECRETS,default="`
+
+	// How the forwarders of the traffic-agents listen. The agent ports are allocated from AgentPort up to
+	// AgentMaxPort, skipping the ports that the containers of the pod expose. Zero means no upper bound.
+	AgentMaxPort       int32  `env:"TELEPRESENCE_AGENT_MAX_PORT,default=0"`
+	AgentListenAddress string `env:"TELEPRESENCE_AGENT_LISTEN_ADDRESS,default="`
+	AgentReusePort     bool   `env:"TELEPRESENCE_AGENT_REUSE_PORT,default=false"`
+
+	// AgentInitRunAsRoot makes the tel-agent-init container run as root in pods whose security context
+	// requires a non-root user, so that it can configure iptables.
+	AgentInitRunAsRoot bool `env:"TELEPRESENCE_AGENT_INIT_RUN_AS_ROOT,default=false"`
+
+	// AgentTunnelPoolSize is the number of tunnel streams to the traffic-manager that each traffic-agent opens in
+	// advance.
+	AgentTunnelPoolSize int `env:"TELEPRESENCE_AGENT_TUNNEL_POOL_SIZE,default=0"`
+
+	// AgentRolloutConcurrency is the maximum number of workloads that are modified or rolled out concurrently when
+	// agents are added to, or removed from, many workloads at once.
+	AgentRolloutConcurrency int `env:"TELEPRESENCE_AGENT_ROLLOUT_CONCURRENCY,default=8"`
+
+	// WorkloadKinds are custom resources that manage pods using a pod template, and that may be intercepted in
+	// addition to the built-in workload kinds, Argo Rollouts, and Knative Services. A JSON array.
+	WorkloadKinds k8sapi.WorkloadKinds `env:"TELEPRESENCE_WORKLOAD_KINDS,default="`
+
+	// RedactSecrets prevents the values that intercepted containers obtain from secrets from being passed on
+	// to the clients. A client will instead read them using its own credentials.
+	RedactSecrets bool `env:"TELEPRESENCE_REDACT_SECRETS,default=false"`
+
+	// PauseAutoscalers pauses the HorizontalPodAutoscalers and VerticalPodAutoscalers of intercepted workloads,
+	// so that the rollout of the traffic-agent doesn't cause scale events. Overridden by the
+	// telepresence.getambassador.io/pause-autoscalers annotation of a workload.
+	PauseAutoscalers bool `env:"TELEPRESENCE_PAUSE_AUTOSCALERS,default=false"`
+
+	// EphemeralAgents permits intercepts that add the traffic-agent to running pods as an ephemeral container,
+	// so that the pods aren't restarted. It requires the traffic-node-agent DaemonSet. Experimental.
+	EphemeralAgents bool `env:"TELEPRESENCE_EPHEMERAL_AGENTS,default=false"`
+
+	// AgentInjectionMode determines if the traffic-agents are injected into the pods as sidecars, or run by the
+	// traffic-node-agent on the node of each intercepted pod.
+	AgentInjectionMode agentconfig.InjectionMode `env:"TELEPRESENCE_AGENT_INJECTION_MODE,default="`
+
+	// Limits on the intercepts of the clients. Zero means no limit. An intercept that exceeds the maximum duration
+	// is removed.
+	MaxInterceptsPerUser      int           `env:"TELEPRESENCE_MAX_INTERCEPTS_PER_USER,default=0"`
+	MaxInterceptsPerNamespace int           `env:"TELEPRESENCE_MAX_INTERCEPTS_PER_NAMESPACE,default=0"`
+	MaxInterceptDuration      time.Duration `env:"TELEPRESENCE_MAX_INTERCEPT_DURATION,default=0s"`
+
+	// InterceptUsers and InterceptGroups are the users and groups that may intercept. When either is set, all other
+	// clients are connect-only, i.e. they get outbound connectivity and DNS but can't intercept. They only match the
+	// verified identities of clients, so all clients are connect-only when the identities aren't verified.
+	InterceptUsers  []string `env:"TELEPRESENCE_INTERCEPT_USERS,default="`
+	InterceptGroups []string `env:"TELEPRESENCE_INTERCEPT_GROUPS,default="`
+
+	// ClientIdentity is the method used to verify the identities of the clients, "kubernetes" or "oidc". The
+	// identities aren't verified when it's empty. The audience that the tokens of the "kubernetes" method must be
+	// issued to defaults to traffic-manager.<manager namespace>. The OIDC settings are only used by the "oidc" method.
+	ClientIdentity         string `env:"TELEPRESENCE_CLIENT_IDENTITY,default="`
+	ClientIdentityAudience string `env:"TELEPRESENCE_CLIENT_IDENTITY_AUDIENCE,default="`
+	OIDCIssuerURL          string `env:"TELEPRESENCE_OIDC_ISSUER_URL,default="`
+	OIDCClientID           string `env:"TELEPRESENCE_OIDC_CLIENT_ID,default="`
+	OIDCUsernameClaim      string `env:"TELEPRESENCE_OIDC_USERNAME_CLAIM,default=sub"`
+	OIDCGroupsClaim        string `env:"TELEPRESENCE_OIDC_GROUPS_CLAIM,default=groups"`
+
+	// The outbound webhook that is notified when client sessions and intercepts start and end, and the format of
+	// its requests; "cloudevents" or "slack".
+	EventWebhookURL    string `env:"TELEPRESENCE_EVENT_WEBHOOK_URL,default="`
+	EventWebhookFormat string `env:"TELEPRESENCE_EVENT_WEBHOOK_FORMAT,default=cloudevents"`
+
+	// FederationPeers are the traffic-managers of other clusters that the clients of this traffic-manager may list
+	// the workloads of, and intercept in. A JSON array.
+	FederationPeers FederationPeers `env:"TELEPRESENCE_FEDERATION_PEERS,default="`
+
+	// FederationTLSDir is the directory with the certificate, key, and CA that the traffic-managers of a federation
+	// use to authenticate each other. The federation API, which the peers connect to, is served on FederationPort
+	// when it's set.
+	FederationTLSDir string `env:"TELEPRESENCE_FEDERATION_TLS_DIR,default="`
+	FederationPort   string `env:"TELEPRESENCE_FEDERATION_PORT,default=8083"`
+
+	// ClientDNSNamespace is the namespace of the Services that give the clients that expose local ports a DNS name,
+	// so that "<user>.<namespace>" reaches the ports from the cluster. The ports of clients aren't exposed when it's
+	// empty.
+	ClientDNSNamespace string `env:"TELEPRESENCE_CLIENT_DNS_NAMESPACE,default="`
+
+	// Keep-alive settings used by the traffic-agents when they connect to the traffic-manager.
+	KeepAliveInterval time.Duration `env:"TELEPRESENCE_GRPC_KEEPALIVE_INTERVAL,default=0s"`
+	KeepAliveTimeout  time.Duration `env:"TELEPRESENCE_GRPC_KEEPALIVE_TIMEOUT,default=0s"`
+	TCPUserTimeout    time.Duration `env:"TELEPRESENCE_TCP_USER_TIMEOUT,default=0s"`
+
+	// ClientConfig is the YAML (or JSON) client configuration that is served to the clients.
+	ClientConfig string `env:"TELEPRESENCE_CLIENT_CONFIG,default="`
 
 	PodCIDRStrategy string `env:"POD_CIDR_STRATEGY,default=auto"`
 	PodCIDRs        string `env:"POD_CIDRS,default="`
@@ -41,10 +141,23 @@ type envKey struct{}
 func (e *Env) GeneratorConfig(qualifiedAgentImage string) *agentmap.GeneratorConfig {
 	return &agentmap.GeneratorConfig{
 		AgentPort:           uint16(e.AgentPort),
+		AgentMaxPort:        uint16(e.AgentMaxPort),
+		ListenAddress:       e.AgentListenAddress,
+		ReusePort:           e.AgentReusePort,
+		TunnelPoolSize:      e.AgentTunnelPoolSize,
 		APIPort:             uint16(e.APIPort),
 		QualifiedAgentImage: qualifiedAgentImage,
+		ArchAgentImages:     e.AgentArchImages,
 		ManagerNamespace:    e.ManagerNamespace,
 		LogLevel:            e.LogLevel,
+		KeepAliveInterval:   e.KeepAliveInterval,
+		KeepAliveTimeout:    e.KeepAliveTimeout,
+		TCPUserTimeout:      e.TCPUserTimeout,
+		SecurityProfile:     e.AgentSecurityProfile,
+		SecurityContext:     &e.AgentSecurityContext,
+		Resources:           &e.AgentResources,
+		ResourcePolicy:      e.AgentResourcePolicy,
+		PullSecrets:         e.AgentPullSecrets,
 	}
 }
 
@@ -63,6 +176,31 @@ func (e *Env) GetManagedNamespaces() []string {
 	return nil
 }
 
+// MayIntercept returns true if the given client may intercept. The name that a client reports can't be trusted, so
+// a client without a verified identity may only intercept when no users or groups are required.
+func (e *Env) MayIntercept(client *rpc.ClientInfo) bool {
+	if len(e.InterceptUsers) == 0 && len(e.InterceptGroups) == 0 {
+		return true
+	}
+	id := client.GetIdentity()
+	if id == nil {
+		return false
+	}
+	for _, u := range e.InterceptUsers {
+		if u == id.User {
+			return true
+		}
+	}
+	for _, g := range e.InterceptGroups {
+		for _, ig := range id.Groups {
+			if g == ig {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func LoadEnv(ctx context.Context) (context.Context, error) {
 	var env Env
 	if err := envconfig.Process(ctx, &env); err != nil {
@@ -6,11 +6,14 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"k8s.io/apimachinery/pkg/api/resource"
 
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
 	"github.com/telepresenceio/telepresence/v2/pkg/version"
 )
 
@@ -30,17 +33,28 @@ func TestEnvconfig(t *testing.T) {
 	}()
 
 	defaults := managerutil.Env{
-		User:            "",
-		ServerHost:      "",
-		ServerPort:      "8081",
-		SystemAHost:     "app.getambassador.io",
-		SystemAPort:     "443",
-		AgentRegistry:   "docker.io/datawire",
-		AgentImage:      "",
-		AgentPort:       9900,
-		MaxReceiveSize:  resource.MustParse("4Mi"),
-		PodCIDRStrategy: "auto",
-		LogLevel:        "info",
+		User:                  "",
+		ServerHost:            "",
+		ServerPort:            "8081",
+		SystemAHost:           "app.getambassador.io",
+		SystemAPort:           "443",
+		AgentRegistry:         "docker.io/datawire",
+		AgentImage:            "",
+		AgentPort:             9900,
+		MaxReceiveSize:        resource.MustParse("4Mi"),
+		InitialWindowSize:     resource.MustParse("0"),
+		InitialConnWindowSize: resource.MustParse("0"),
+		AgentSecurityProfile:  agentconfig.DefaultSecurityProfile,
+		AgentResourcePolicy:   agentconfig.AddResourcePolicy,
+		AgentInjectionMode:    agentconfig.SidecarInjectionMode,
+		PodCIDRStrategy:       "auto",
+		LogLevel:              "info",
+		EventWebhookFormat:    "cloudevents",
+		OIDCUsernameClaim:     "sub",
+		OIDCGroupsClaim:       "groups",
+		FederationPort:        "8083",
+
+		AgentRolloutConcurrency: 8,
 	}
 
 	testcases := map[string]struct {
@@ -59,6 +73,57 @@ func TestEnvconfig(t *testing.T) {
 				e.SystemAHost = "app.getambassador.io"
 			},
 		},
+		"window-sizes": {
+			Input: map[string]string{
+				"TELEPRESENCE_INITIAL_WINDOW_SIZE":      "1Mi",
+				"TELEPRESENCE_INITIAL_CONN_WINDOW_SIZE": "16Mi",
+			},
+			Output: func(e *managerutil.Env) {
+				e.InitialWindowSize = resource.MustParse("1Mi")
+				e.InitialConnWindowSize = resource.MustParse("16Mi")
+			},
+		},
+		"keep-alive": {
+			Input: map[string]string{
+				"TELEPRESENCE_GRPC_KEEPALIVE_INTERVAL": "30s",
+				"TELEPRESENCE_GRPC_KEEPALIVE_TIMEOUT":  "10s",
+				"TELEPRESENCE_TCP_USER_TIMEOUT":        "20s",
+			},
+			Output: func(e *managerutil.Env) {
+				e.KeepAliveInterval = 30 * time.Second
+				e.KeepAliveTimeout = 10 * time.Second
+				e.TCPUserTimeout = 20 * time.Second
+			},
+		},
+		"arch-images": {
+			Input: map[string]string{
+				"TELEPRESENCE_AGENT_ARCH_IMAGES": "amd64:example.com/tel2:2.7.0-amd64,arm64:example.com/tel2:2.7.0-arm64",
+			},
+			Output: func(e *managerutil.Env) {
+				e.AgentArchImages = map[string]string{
+					"amd64": "example.com/tel2:2.7.0-amd64",
+					"arm64": "example.com/tel2:2.7.0-arm64",
+				}
+			},
+		},
+		"injection-mode": {
+			Input: map[string]string{
+				"TELEPRESENCE_AGENT_INJECTION_MODE": "node",
+			},
+			Output: func(e *managerutil.Env) {
+				e.AgentInjectionMode = agentconfig.NodeInjectionMode
+			},
+		},
+		"federation-peers": {
+			Input: map[string]string{
+				"TELEPRESENCE_FEDERATION_PEERS":   `[{"name":"west","address":"10.20.0.4:8083","serverName":"traffic-manager.west"}]`,
+				"TELEPRESENCE_FEDERATION_TLS_DIR": "/var/run/secrets/federation_tls",
+			},
+			Output: func(e *managerutil.Env) {
+				e.FederationPeers = managerutil.FederationPeers{{Name: "west", Address: "10.20.0.4:8083", ServerName: "traffic-manager.west"}}
+				e.FederationTLSDir = "/var/run/secrets/federation_tls"
+			},
+		},
 	}
 
 	for tcName, tc := range testcases {
@@ -86,3 +151,30 @@ func TestEnvconfig(t *testing.T) {
 		})
 	}
 }
+
+func TestEnv_MayIntercept(t *testing.T) {
+	env := managerutil.Env{InterceptUsers: []string{"alice", "bob@laptop", "carol@bigcorp.com"}, InterceptGroups: []string{"devs"}}
+
+	// The reported names can't be trusted
+	assert.False(t, env.MayIntercept(&rpc.ClientInfo{Name: "alice@laptop"}))
+	assert.False(t, env.MayIntercept(&rpc.ClientInfo{Name: "bob@laptop"}))
+	assert.False(t, env.MayIntercept(&rpc.ClientInfo{Name: "carol@bigcorp.com"}))
+
+	// Verified identities are matched using the verified user and groups only
+	assert.False(t, env.MayIntercept(&rpc.ClientInfo{Name: "alice@laptop", Identity: &rpc.ClientIdentity{User: "mallory@bigcorp.com"}}))
+	assert.True(t, env.MayIntercept(&rpc.ClientInfo{Name: "alice@laptop", Identity: &rpc.ClientIdentity{User: "carol@bigcorp.com"}}))
+	assert.True(t, env.MayIntercept(&rpc.ClientInfo{Name: "dave@laptop", Identity: &rpc.ClientIdentity{User: "dave", Groups: []string{"ops", "devs"}}}))
+
+	assert.True(t, (&managerutil.Env{}).MayIntercept(&rpc.ClientInfo{Name: "anyone@anywhere"}))
+}
+
+func TestFederationPeers_EnvDecode(t *testing.T) {
+	var ps managerutil.FederationPeers
+	assert.NoError(t, ps.EnvDecode(""))
+	assert.Nil(t, ps)
+	assert.Error(t, ps.EnvDecode(`[{"address":"10.20.0.4:8081"}]`), "missing name")
+	assert.Error(t, ps.EnvDecode(`[{"name":"west","address":"10.20.0.4"}]`), "missing port")
+	assert.Error(t, ps.EnvDecode(`[{"name":"west","address":"a:8081"},{"name":"west","address":"b:8081"}]`), "duplicate name")
+	assert.NoError(t, ps.EnvDecode(`[{"name":"east","address":"a:8081"},{"name":"west","address":"b:8081"}]`))
+	assert.Equal(t, []string{"east", "west"}, ps.Names())
+}
@@ -0,0 +1,79 @@
+package managerutil
+
+import (
+	"context"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcore "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/datawire/dlib/dcontext"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+// Reasons of the events that the traffic-manager records on the workloads that it manages.
+const (
+	ReasonAgentInjected    = "AgentInjected"
+	ReasonAgentRemoved     = "AgentRemoved"
+	ReasonInterceptStarted = "InterceptStarted"
+	ReasonInterceptEnded   = "InterceptEnded"
+	ReasonInterceptHandoff = "InterceptHandoff"
+)
+
+type eventRecorderKey struct{}
+
+// WithEventRecorder returns a context that records workload events using the given recorder.
+func WithEventRecorder(ctx context.Context, recorder record.EventRecorder) context.Context {
+	return context.WithValue(ctx, eventRecorderKey{}, recorder)
+}
+
+// WithEventBroadcaster returns a context that records workload events using the Kubernetes interface of the given
+// context. The broadcaster is shut down when the given context is cancelled.
+func WithEventBroadcaster(ctx context.Context) context.Context {
+	eb := record.NewBroadcaster()
+	eb.StartRecordingToSink(&typedcore.EventSinkImpl{Interface: k8sapi.GetK8sInterface(ctx).CoreV1().Events("")})
+	go func() {
+		<-ctx.Done()
+		eb.Shutdown()
+	}()
+	return WithEventRecorder(ctx, eb.NewRecorder(scheme.Scheme, core.EventSource{Component: "traffic-manager"}))
+}
+
+// WorkloadEvent records a Normal event on the given workload so that it becomes visible in `kubectl describe` and
+// in event pipelines. It is a no-op when the context has no event recorder.
+func WorkloadEvent(ctx context.Context, wl k8sapi.Workload, reason, messageFmt string, args ...any) {
+	recorder, ok := ctx.Value(eventRecorderKey{}).(record.EventRecorder)
+	if !ok {
+		return
+	}
+	apiVersion, _ := k8sapi.GetGroupVersionKind(wl).ToAPIVersionAndKind()
+	recorder.Eventf(&core.ObjectReference{
+		APIVersion:      apiVersion,
+		Kind:            wl.GetKind(),
+		Namespace:       wl.GetNamespace(),
+		Name:            wl.GetName(),
+		UID:             wl.GetUID(),
+		ResourceVersion: wl.GetResourceVersion(),
+	}, core.EventTypeNormal, reason, messageFmt, args...)
+}
+
+// InterceptEvent records a Normal event on the workload targeted by the given intercept. The workload is looked up
+// asynchronously so that the caller isn't delayed, and a failure to find it is logged but otherwise ignored.
+func InterceptEvent(ctx context.Context, spec *manager.InterceptSpec, reason, messageFmt string, args ...any) {
+	if _, ok := ctx.Value(eventRecorderKey{}).(record.EventRecorder); !ok {
+		return
+	}
+	// The intercept might be removed due to a cancellation.
+	ctx = dcontext.WithoutCancel(ctx)
+	go func() {
+		wl, err := k8sapi.GetWorkload(ctx, spec.Agent, spec.Namespace, spec.WorkloadKind)
+		if err != nil {
+			dlog.Errorf(ctx, "unable to record %s event for intercept %s: %v", reason, spec.Name, err)
+			return
+		}
+		WorkloadEvent(ctx, wl, reason, messageFmt, args...)
+	}()
+}
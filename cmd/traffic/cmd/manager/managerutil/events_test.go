@@ -0,0 +1,69 @@
+package managerutil_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apps "k8s.io/api/apps/v1"
+	batch "k8s.io/api/batch/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+func TestInterceptEvent(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	ctx = k8sapi.WithK8sInterface(ctx, fake.NewSimpleClientset(&apps.Deployment{
+		ObjectMeta: meta.ObjectMeta{Name: "echo", Namespace: "default", UID: "1234"},
+	}))
+	spec := &manager.InterceptSpec{
+		Name:         "echo",
+		Client:       "jane@example",
+		Agent:        "echo",
+		Namespace:    "default",
+		WorkloadKind: "Deployment",
+	}
+
+	// No recorder in the context is a no-op
+	managerutil.InterceptEvent(ctx, spec, managerutil.ReasonInterceptStarted, "Intercept %s started by %s", spec.Name, spec.Client)
+
+	recorder := record.NewFakeRecorder(10)
+	recorder.IncludeObject = true
+	ctx = managerutil.WithEventRecorder(ctx, recorder)
+	managerutil.InterceptEvent(ctx, spec, managerutil.ReasonInterceptStarted, "Intercept %s started by %s", spec.Name, spec.Client)
+	select {
+	case ev := <-recorder.Events:
+		assert.Equal(t, "Normal InterceptStarted Intercept echo started by jane@example"+
+			" involvedObject{kind=Deployment,apiVersion=apps/v1}", ev)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "no event was recorded")
+	}
+
+	// A missing workload doesn't result in an event
+	spec.Agent = "missing"
+	managerutil.InterceptEvent(ctx, spec, managerutil.ReasonInterceptEnded, "Intercept %s ended", spec.Name)
+	select {
+	case ev := <-recorder.Events:
+		require.Failf(t, "unexpected event", "%s", ev)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWorkloadEvent(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	recorder.IncludeObject = true
+	ctx := managerutil.WithEventRecorder(dlog.NewTestContext(t, false), recorder)
+
+	// The apiVersion of the involved object is the one of the workload's kind
+	managerutil.WorkloadEvent(ctx, k8sapi.Job(&batch.Job{ObjectMeta: meta.ObjectMeta{Name: "migrate", Namespace: "default"}}),
+		managerutil.ReasonAgentInjected, "Rolling out pods with an injected traffic-agent")
+	assert.Equal(t, "Normal AgentInjected Rolling out pods with an injected traffic-agent"+
+		" involvedObject{kind=Job,apiVersion=batch/v1}", <-recorder.Events)
+}
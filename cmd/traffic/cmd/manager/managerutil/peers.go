@@ -0,0 +1,60 @@
+package managerutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+)
+
+// FederationPeer is the traffic-manager of another cluster that this traffic-manager forwards the intercepts and
+// workload listings of that cluster to.
+type FederationPeer struct {
+	// Name is the name of the peered cluster, i.e. the name that clients use to select it, e.g. "west"
+	Name string `json:"name"`
+
+	// Address is the host and port of the federation API of the peered traffic-manager, e.g. "10.20.0.4:8083"
+	Address string `json:"address"`
+
+	// ServerName is the name that the certificate of the peered traffic-manager is verified against. The host of
+	// the address is used when it's empty.
+	ServerName string `json:"serverName,omitempty"`
+}
+
+// FederationPeers are the peers of a traffic-manager. They're decoded from their JSON representation in the
+// environment.
+type FederationPeers []FederationPeer
+
+func (ps *FederationPeers) EnvDecode(val string) error {
+	*ps = nil
+	if val == "" {
+		return nil
+	}
+	var fps FederationPeers
+	if err := json.Unmarshal([]byte(val), &fps); err != nil {
+		return fmt.Errorf("invalid FederationPeers: %w", err)
+	}
+	names := make(map[string]struct{}, len(fps))
+	for _, p := range fps {
+		if p.Name == "" {
+			return fmt.Errorf("invalid FederationPeers: missing name")
+		}
+		if _, ok := names[p.Name]; ok {
+			return fmt.Errorf("invalid FederationPeers: duplicate name %q", p.Name)
+		}
+		names[p.Name] = struct{}{}
+		if _, _, err := net.SplitHostPort(p.Address); err != nil {
+			return fmt.Errorf("invalid FederationPeers: address of peer %q: %w", p.Name, err)
+		}
+	}
+	*ps = fps
+	return nil
+}
+
+// Names returns the names of the peers.
+func (ps FederationPeers) Names() []string {
+	names := make([]string, len(ps))
+	for i, p := range ps {
+		names[i] = p.Name
+	}
+	return names
+}
@@ -0,0 +1,26 @@
+package manager
+
+import (
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+// redactedValue replaces the values of the environment that are obtained from secrets
+const redactedValue = "<redacted>"
+
+// redactSecrets returns a copy of the given environment where the values that are obtained from the given secrets
+// are replaced with a placeholder, along with the keys of the values that were replaced.
+func redactSecrets(env map[string]string, secrets map[string]*rpc.SecretKeyRef) (map[string]string, map[string]*rpc.SecretKeyRef) {
+	if len(secrets) == 0 {
+		return env, nil
+	}
+	redactedEnv := make(map[string]string, len(env))
+	redacted := make(map[string]*rpc.SecretKeyRef, len(secrets))
+	for k, v := range env {
+		if ref, ok := secrets[k]; ok {
+			v = redactedValue
+			redacted[k] = ref
+		}
+		redactedEnv[k] = v
+	}
+	return redactedEnv, redacted
+}
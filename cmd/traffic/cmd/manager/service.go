@@ -2,12 +2,14 @@ package manager
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"path"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -21,10 +23,16 @@ import (
 	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/rpc/v2/systema"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/cluster"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/expose"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/federation"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/identity"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/state"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/watchable"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/webhook"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/license"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
 	"github.com/telepresenceio/telepresence/v2/pkg/a8rcloud"
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
 	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
 	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
 	"github.com/telepresenceio/telepresence/v2/pkg/version"
@@ -42,6 +50,13 @@ type Manager struct {
 	state       *state.State
 	clusterInfo cluster.Info
 	cloudConfig *rpc.AmbassadorCloudConfig
+	notifier    *webhook.Notifier
+	exposer     *expose.Exposer
+	identities  *identity.Verifier
+	federation  *federation.Federation
+
+	// federationTLS authenticates the traffic-managers of a federation. Nil unless a federation TLS dir is given.
+	federationTLS *tls.Config
 
 	rpc.UnsafeManagerServer
 }
@@ -95,6 +110,40 @@ func NewManager(ctx context.Context) (*Manager, context.Context, error) {
 	// These are context dependent so build them once the pool is up
 	ret.clusterInfo = cluster.NewInfo(ctx)
 	ret.state = state.NewState(ctx)
+	if env := managerutil.GetEnv(ctx); env != nil {
+		source := fmt.Sprintf("/telepresence/clusters/%s/traffic-manager", ret.clusterInfo.GetClusterID())
+		if ret.notifier, err = webhook.NewNotifier(env.EventWebhookURL, env.EventWebhookFormat, source); err != nil {
+			return nil, nil, err
+		}
+		audience := env.ClientIdentityAudience
+		if audience == "" {
+			audience = "traffic-manager." + env.ManagerNamespace
+		}
+		ret.identities, err = identity.NewVerifier(env.ClientIdentity, audience, &identity.OIDCConfig{
+			IssuerURL:     env.OIDCIssuerURL,
+			ClientID:      env.OIDCClientID,
+			UsernameClaim: env.OIDCUsernameClaim,
+			GroupsClaim:   env.OIDCGroupsClaim,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if ret.identities == nil && (len(env.InterceptUsers) > 0 || len(env.InterceptGroups) > 0) {
+			dlog.Warn(ctx, "The users and groups that may intercept only match verified client identities, "+
+				"and identities aren't verified, so all clients are connect-only")
+		}
+		if env.FederationTLSDir != "" {
+			if ret.federationTLS, err = federation.LoadTLSConfig(env.FederationTLSDir); err != nil {
+				return nil, nil, err
+			}
+		}
+		if ret.federation, err = federation.NewFederation(ctx, env.FederationPeers, ret.state, ret.federationTLS); err != nil {
+			return nil, nil, err
+		}
+		if ret.exposer, err = expose.NewExposer(ctx, env.ClientDNSNamespace, env.PodIP, ret.state); err != nil {
+			return nil, nil, err
+		}
+	}
 	return ret, ctx, nil
 }
 
@@ -148,6 +197,11 @@ func (m *Manager) GetTelepresenceAPI(ctx context.Context, e *empty.Empty) (*rpc.
 	return &rpc.TelepresenceAPIInfo{Port: env.APIPort}, nil
 }
 
+// GetClientConfig returns the client configuration declared by the client value of the Helm chart.
+func (m *Manager) GetClientConfig(ctx context.Context, _ *empty.Empty) (*rpc.CLIConfig, error) {
+	return &rpc.CLIConfig{ConfigYaml: []byte(managerutil.GetEnv(ctx).ClientConfig)}, nil
+}
+
 // ArriveAsClient establishes a session between a client and the Manager.
 func (m *Manager) ArriveAsClient(ctx context.Context, client *rpc.ClientInfo) (*rpc.SessionInfo, error) {
 	dlog.Debug(ctx, "ArriveAsClient called")
@@ -156,16 +210,86 @@ func (m *Manager) ArriveAsClient(ctx context.Context, client *rpc.ClientInfo) (*
 		return nil, status.Errorf(codes.InvalidArgument, val)
 	}
 
+	if federation.VerifiedPeer(ctx) {
+		// A peered traffic-manager creates the session on behalf of one of its clients, and has verified its identity.
+		client.IdentityToken = ""
+		if m.identities != nil && client.Identity == nil {
+			return nil, status.Errorf(codes.Unauthenticated, "the peered traffic-manager didn't verify the identity of %s", client.Name)
+		}
+	} else if err := m.verifyIdentity(ctx, client); err != nil {
+		return nil, err
+	}
+
+	warnDeprecatedClient(ctx, client)
+	if env := managerutil.GetEnv(ctx); env != nil && !client.ConnectOnly && !env.MayIntercept(client) {
+		dlog.Infof(ctx, "Client %s isn't permitted to intercept, and is connect-only", managerutil.ClientName(client.Name, client.Identity))
+		client.ConnectOnly = true
+	}
 	sessionID := m.state.AddClient(client, m.clock.Now())
+	m.notifySession(ctx, sessionID, client)
 
 	installId := client.GetInstallId()
 	return &rpc.SessionInfo{
-		SessionId: sessionID,
-		ClusterId: m.clusterInfo.GetClusterID(),
-		InstallId: &installId,
+		SessionId:   sessionID,
+		ClusterId:   m.clusterInfo.GetClusterID(),
+		InstallId:   &installId,
+		ConnectOnly: client.ConnectOnly,
+		DnsName:     m.exposeClient(ctx, sessionID, client),
 	}, nil
 }
 
+// exposeClient exposes the ports that the given client asks for, and returns the DNS name that the pods in the
+// cluster use to connect to them. The name is derived from the verified user of the client, because a name derived
+// from the name that a client reports would let anyone take the name of another user. A failure is pushed to the
+// client as a warning, because the session is usable without the exposed ports.
+func (m *Manager) exposeClient(ctx context.Context, sessionID string, client *rpc.ClientInfo) string {
+	if len(client.ExposedPorts) == 0 {
+		return ""
+	}
+	if m.exposer == nil {
+		pushWarnings(ctx, "the traffic-manager doesn't expose the ports of clients. It's enabled by the clientDNS.enabled Helm chart value")
+		return ""
+	}
+	user := client.Identity.GetUser()
+	if user == "" {
+		pushWarnings(ctx, "the traffic-manager only exposes the ports of clients with verified identities. "+
+			"They're verified when the clientIdentity.method Helm chart value is set")
+		return ""
+	}
+	dnsName, err := m.exposer.Expose(ctx, sessionID, user, client.ExposedPorts)
+	if err != nil {
+		dlog.Errorf(ctx, "unable to expose the ports of %s: %v", client.Name, err)
+		pushWarnings(ctx, fmt.Sprintf("unable to expose the ports: %v", err))
+	}
+	return dnsName
+}
+
+// verifyIdentity verifies the identity token of the given client, and replaces its identity with the verified one.
+// The token is removed so that it's never stored or passed on. A client that doesn't present a token when the
+// identities are verified gets an Unauthenticated error, and will then retry with a token.
+func (m *Manager) verifyIdentity(ctx context.Context, client *rpc.ClientInfo) error {
+	token := client.IdentityToken
+	client.IdentityToken = ""
+	client.Identity = nil
+	if m.identities == nil {
+		return nil
+	}
+	if token == "" {
+		return status.Error(codes.Unauthenticated, "the traffic-manager requires that clients present an identity token")
+	}
+	id, err := m.identities.Verify(ctx, token)
+	if err != nil {
+		dlog.Infof(ctx, "Unable to verify the identity of %s: %v", client.Name, err)
+		if errors.Is(err, identity.ErrInvalidToken) {
+			return status.Errorf(codes.Unauthenticated, "unable to verify the identity of %s: %v", client.Name, err)
+		}
+		return status.Errorf(codes.Internal, "unable to verify the identity of %s: %v", client.Name, err)
+	}
+	dlog.Debugf(ctx, "Client %s verified as %s", client.Name, id.User)
+	client.Identity = id
+	return nil
+}
+
 // ArriveAsAgent establishes a session between an agent and the Manager.
 func (m *Manager) ArriveAsAgent(ctx context.Context, agent *rpc.AgentInfo) (*rpc.SessionInfo, error) {
 	dlog.Debug(ctx, "ArriveAsAgent called")
@@ -204,6 +328,34 @@ func (m *Manager) Depart(ctx context.Context, session *rpc.SessionInfo) (*empty.
 	return &empty.Empty{}, nil
 }
 
+// ResumeSession resumes a client session that has expired and recreates the intercepts that it had.
+func (m *Manager) ResumeSession(ctx context.Context, req *rpc.ResumeSessionRequest) (*rpc.SessionInfo, error) {
+	ctx = managerutil.WithSessionInfo(ctx, req.GetSession())
+	dlog.Debug(ctx, "ResumeSession called")
+
+	sessionID := req.GetSession().GetSessionId()
+	intercepts, err := m.state.ResumeSession(ctx, sessionID, req.GetResumeToken(), m.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	client := m.state.GetClient(sessionID)
+	m.notifySession(ctx, sessionID, client)
+	dnsName := m.exposeClient(ctx, sessionID, client)
+	for _, ii := range intercepts {
+		if _, err := m.createIntercept(ctx, sessionID, ii.ApiKey, client, ii.Spec); err != nil {
+			dlog.Errorf(ctx, "unable to recreate intercept %s: %v", ii.Spec.Name, err)
+		}
+	}
+
+	installId := client.GetInstallId()
+	return &rpc.SessionInfo{
+		SessionId: sessionID,
+		ClusterId: m.clusterInfo.GetClusterID(),
+		InstallId: &installId,
+		DnsName:   dnsName,
+	}, nil
+}
+
 // WatchAgents notifies a client of the set of known Agents.
 func (m *Manager) WatchAgents(session *rpc.SessionInfo, stream rpc.Manager_WatchAgentsServer) error {
 	ctx := managerutil.WithSessionInfo(stream.Context(), session)
@@ -350,6 +502,7 @@ func (m *Manager) WatchIntercepts(session *rpc.SessionInfo, stream rpc.Manager_W
 	dlog.Debug(ctx, "WatchIntercepts called")
 
 	var sessionDone <-chan struct{}
+	var peerCh <-chan watchable.Snapshot[*rpc.InterceptInfo]
 	var filter func(id string, info *rpc.InterceptInfo) bool
 	if sessionID == "" {
 		// No sessonID; watch everything
@@ -385,14 +538,17 @@ func (m *Manager) WatchIntercepts(session *rpc.SessionInfo, stream rpc.Manager_W
 				}
 			}
 		} else {
-			// sessionID refers to a client session
+			// sessionID refers to a client session, which might have intercepts in peered clusters too
 			filter = func(id string, info *rpc.InterceptInfo) bool {
 				return info.ClientSession.SessionId == sessionID
 			}
+			peerCh = m.federation.WatchIntercepts(ctx, sessionID)
 		}
 	}
 
 	snapshotCh := m.state.WatchIntercepts(ctx, filter)
+	var local, peered map[string]*rpc.InterceptInfo
+	hasLocal := false
 	for {
 		select {
 		case snapshot, ok := <-snapshotCh:
@@ -400,20 +556,17 @@ func (m *Manager) WatchIntercepts(session *rpc.SessionInfo, stream rpc.Manager_W
 				dlog.Debugf(ctx, "WatchIntercepts request cancelled")
 				return nil
 			}
-			dlog.Debugf(ctx, "WatchIntercepts sending update")
-			intercepts := make([]*rpc.InterceptInfo, 0, len(snapshot.State))
-			for _, intercept := range snapshot.State {
-				intercepts = append(intercepts, intercept)
-			}
-			resp := &rpc.InterceptInfoSnapshot{
-				Intercepts: intercepts,
+			local = snapshot.State
+			hasLocal = true
+		case snapshot, ok := <-peerCh:
+			if !ok {
+				dlog.Debugf(ctx, "WatchIntercepts request cancelled")
+				return nil
 			}
-			sort.Slice(intercepts, func(i, j int) bool {
-				return intercepts[i].Id < intercepts[j].Id
-			})
-			if err := stream.Send(resp); err != nil {
-				dlog.Debugf(ctx, "WatchIntercepts encountered a write error: %v", err)
-				return err
+			peered = snapshot.State
+			if !hasLocal {
+				// Wait for the initial snapshot of the local intercepts
+				continue
 			}
 		case <-ctx.Done():
 			dlog.Debugf(ctx, "WatchIntercepts context cancelled")
@@ -422,12 +575,38 @@ func (m *Manager) WatchIntercepts(session *rpc.SessionInfo, stream rpc.Manager_W
 			dlog.Debugf(ctx, "WatchIntercepts session cancelled")
 			return nil
 		}
+		dlog.Debugf(ctx, "WatchIntercepts sending update")
+		intercepts := make([]*rpc.InterceptInfo, 0, len(local)+len(peered))
+		for _, intercept := range local {
+			intercepts = append(intercepts, intercept)
+		}
+		for _, intercept := range peered {
+			intercepts = append(intercepts, intercept)
+		}
+		resp := &rpc.InterceptInfoSnapshot{
+			Intercepts: intercepts,
+		}
+		sort.Slice(intercepts, func(i, j int) bool {
+			return intercepts[i].Id < intercepts[j].Id
+		})
+		if err := stream.Send(resp); err != nil {
+			dlog.Debugf(ctx, "WatchIntercepts encountered a write error: %v", err)
+			return err
+		}
 	}
 }
 
 func (m *Manager) PrepareIntercept(ctx context.Context, request *rpc.CreateInterceptRequest) (*rpc.PreparedIntercept, error) {
 	ctx = managerutil.WithSessionInfo(ctx, request.Session)
 	dlog.Debugf(ctx, "PrepareIntercept called")
+	if request.InterceptSpec.GetCluster() != "" {
+		sessionID := request.GetSession().GetSessionId()
+		client := m.state.GetClient(sessionID)
+		if client == nil {
+			return nil, status.Errorf(codes.NotFound, "Client session %q not found", sessionID)
+		}
+		return m.federation.PrepareIntercept(ctx, client, request)
+	}
 	return m.state.PrepareIntercept(ctx, request)
 }
 
@@ -448,13 +627,41 @@ func (m *Manager) CreateIntercept(ctx context.Context, ciReq *rpc.CreateIntercep
 	if val := validateIntercept(spec); val != "" {
 		return nil, status.Errorf(codes.InvalidArgument, val)
 	}
+	upgradeInterceptSpec(ctx, spec)
+	if spec.Cluster != "" {
+		// The intercept is created, and its events are recorded, by the traffic-manager of the peered cluster
+		return m.federation.CreateIntercept(ctx, client, ciReq)
+	}
+	return m.createIntercept(ctx, sessionID, apiKey, client, spec)
+}
 
-	interceptInfo, err := m.state.AddIntercept(sessionID, m.clusterInfo.GetClusterID(), apiKey, client, spec)
+func (m *Manager) createIntercept(
+	ctx context.Context,
+	sessionID, apiKey string,
+	client *rpc.ClientInfo,
+	spec *rpc.InterceptSpec,
+) (*rpc.InterceptInfo, error) {
+	interceptInfo, err := m.state.AddIntercept(sessionID, m.clusterInfo.GetClusterID(), apiKey, client, spec, m.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	managerutil.InterceptEvent(ctx, spec, managerutil.ReasonInterceptStarted, "Intercept %s started by %s",
+		spec.Name, managerutil.ClientName(spec.Client, interceptInfo.ClientIdentity))
+	m.notifyIntercept(ctx, webhook.InterceptStarted, interceptInfo, "%s started intercept %s of %s %s.%s")
+	err = m.state.AddInterceptFinalizer(interceptInfo.Id, func(ctx context.Context, interceptInfo *rpc.InterceptInfo) error {
+		managerutil.InterceptEvent(ctx, interceptInfo.Spec, managerutil.ReasonInterceptEnded, "Intercept %s by %s ended",
+			interceptInfo.Spec.Name, managerutil.ClientName(interceptInfo.Spec.Client, interceptInfo.ClientIdentity))
+		m.notifyIntercept(ctx, webhook.InterceptEnded, interceptInfo, "Intercept %[2]s of %[3]s %[4]s.%[5]s by %[1]s ended")
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	// The intercept gets another ID if it's handed off, but it remains registered with its original ID.
+	systemaID := interceptInfo.Id
 	err = m.state.AddInterceptFinalizer(interceptInfo.Id, func(ctx context.Context, interceptInfo *rpc.InterceptInfo) error {
-		if interceptInfo.ApiKey == "" {
+		// The API key isn't handed off, so the key that created the intercept decides if it was registered.
+		if apiKey == "" {
 			return nil
 		}
 		sysa := a8rcloud.GetSystemAPool[managerutil.SystemaCRUDClient](ctx, a8rcloud.TrafficManagerConnName)
@@ -462,9 +669,9 @@ func (m *Manager) CreateIntercept(ctx context.Context, ciReq *rpc.CreateIntercep
 			dlog.Errorln(ctx, "systema: acquire connection:", err)
 			return err
 		} else {
-			dlog.Debugf(ctx, "systema: remove intercept: %q", interceptInfo.Id)
+			dlog.Debugf(ctx, "systema: remove intercept: %q", systemaID)
 			_, err := sa.RemoveIntercept(ctx, &systema.InterceptRemoval{
-				InterceptId: interceptInfo.Id,
+				InterceptId: systemaID,
 			})
 
 			if err != nil {
@@ -481,9 +688,70 @@ func (m *Manager) CreateIntercept(ctx context.Context, ciReq *rpc.CreateIntercep
 	if err != nil {
 		return nil, err
 	}
+	if err = m.state.AddInterceptFinalizer(interceptInfo.Id, m.state.RestoreReplacedContainer); err != nil {
+		return nil, err
+	}
+	// The minimum scale of a workload that has scaled to zero is pinned even when the intercept doesn't ask for it
+	if err = m.state.AddInterceptFinalizer(interceptInfo.Id, m.state.RestoreMinScale); err != nil {
+		return nil, err
+	}
+	if err = m.state.AddInterceptFinalizer(interceptInfo.Id, m.state.ResumeAutoscalers); err != nil {
+		return nil, err
+	}
+	if spec.Ephemeral {
+		if err = m.state.AddInterceptFinalizer(interceptInfo.Id, m.state.ReleaseEphemeralAgents); err != nil {
+			return nil, err
+		}
+	} else if managerutil.GetEnv(ctx).AgentInjectionMode == agentconfig.NodeInjectionMode {
+		if err = m.state.AddInterceptFinalizer(interceptInfo.Id, m.state.ReleaseNodeAgents); err != nil {
+			return nil, err
+		}
+	}
 	return interceptInfo, nil
 }
 
+// notifySession notifies the webhook that the given client session has started, and again when it ends.
+func (m *Manager) notifySession(ctx context.Context, sessionID string, client *rpc.ClientInfo) {
+	if m.notifier == nil || client == nil {
+		return
+	}
+	data := &webhook.Session{ID: sessionID, Client: client.Name, User: client.Identity.GetUser(), InstallID: client.InstallId}
+	name := managerutil.ClientName(client.Name, client.Identity)
+	m.notifier.Notify(ctx, webhook.SessionStarted, sessionID, fmt.Sprintf("%s connected", name), data)
+	done, err := m.state.SessionDone(sessionID)
+	if err != nil {
+		return
+	}
+	go func() {
+		select {
+		case <-done:
+			m.notifier.Notify(m.ctx, webhook.SessionEnded, sessionID, fmt.Sprintf("%s disconnected", name), data)
+		case <-m.ctx.Done():
+		}
+	}()
+}
+
+// notifyIntercept notifies the webhook about the given intercept. The message format is given the client, the
+// intercept name, and the kind, name, and namespace of the intercepted workload.
+func (m *Manager) notifyIntercept(ctx context.Context, eventType string, ii *rpc.InterceptInfo, messageFormat string) {
+	if m.notifier == nil {
+		return
+	}
+	spec := ii.Spec
+	msg := fmt.Sprintf(messageFormat, managerutil.ClientName(spec.Client, ii.ClientIdentity), spec.Name, strings.ToLower(spec.WorkloadKind), spec.Agent, spec.Namespace)
+	m.notifier.Notify(ctx, eventType, ii.Id, msg, &webhook.Intercept{
+		ID:           ii.Id,
+		Name:         spec.Name,
+		Namespace:    spec.Namespace,
+		Workload:     spec.Agent,
+		WorkloadKind: spec.WorkloadKind,
+		Mechanism:    spec.Mechanism,
+		Client:       spec.Client,
+		User:         ii.ClientIdentity.GetUser(),
+		SessionID:    ii.ClientSession.GetSessionId(),
+	})
+}
+
 func (m *Manager) makeinterceptID(ctx context.Context, sessionID string, name string) (string, error) {
 	// When something without a session ID (e.g. System A) calls this function,
 	// it is sending the intercept ID as the name, so we use that.
@@ -679,6 +947,13 @@ func (m *Manager) RemoveIntercept(ctx context.Context, riReq *rpc.RemoveIntercep
 		return nil, status.Errorf(codes.NotFound, "Client session %q not found", sessionID)
 	}
 
+	if ok, err := m.federation.RemoveIntercept(ctx, sessionID+":"+name); ok {
+		if err != nil {
+			return nil, err
+		}
+		return &empty.Empty{}, nil
+	}
+
 	if !m.state.RemoveIntercept(sessionID + ":" + name) {
 		return nil, status.Errorf(codes.NotFound, "Intercept named %q not found", name)
 	}
@@ -686,6 +961,68 @@ func (m *Manager) RemoveIntercept(ctx context.Context, riReq *rpc.RemoveIntercep
 	return &empty.Empty{}, nil
 }
 
+// HandoffIntercept lets a client offer an intercept of its session to the session of another client, and lets
+// that client accept the offer. The recipient must have a verified identity that is allowed to get the
+// intercepted workload.
+func (m *Manager) HandoffIntercept(ctx context.Context, hiReq *rpc.HandoffInterceptRequest) (*rpc.InterceptInfo, error) {
+	ctx = managerutil.WithSessionInfo(ctx, hiReq.GetSession())
+	sessionID := hiReq.GetSession().GetSessionId()
+	name := hiReq.Name
+
+	dlog.Debugf(ctx, "HandoffIntercept called: %s, recipient %q, accept %t", name, hiReq.Recipient, hiReq.Accept)
+
+	client := m.state.GetClient(sessionID)
+	if client == nil {
+		return nil, status.Errorf(codes.NotFound, "Client session %q not found", sessionID)
+	}
+	if hiReq.Accept {
+		return m.acceptIntercept(ctx, sessionID, client, name, hiReq.Recipient)
+	}
+	if hiReq.Recipient == "" {
+		return nil, status.Error(codes.InvalidArgument, "the recipient of the intercept must be given")
+	}
+	if _, ok := m.federation.GetIntercept(sessionID + ":" + name); ok {
+		return nil, status.Errorf(codes.InvalidArgument, "intercept %q is in a peered cluster and can't be handed off", name)
+	}
+	recipient, err := m.state.OfferIntercept(sessionID+":"+name, hiReq.Recipient)
+	if err != nil {
+		return nil, err
+	}
+	ii, ok := m.state.GetIntercept(sessionID + ":" + name)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "Intercept named %q not found", name)
+	}
+	dlog.Infof(ctx, "Intercept %s offered by %s to %s", name, managerutil.ClientName(client.Name, client.Identity), recipient)
+	return ii, nil
+}
+
+// acceptIntercept moves the intercept with the given name that has been offered to the given client, to the
+// session of that client.
+func (m *Manager) acceptIntercept(ctx context.Context, sessionID string, client *rpc.ClientInfo, name, owner string) (*rpc.InterceptInfo, error) {
+	if client.Identity == nil {
+		return nil, status.Error(codes.PermissionDenied, "only a client with a verified identity can accept an intercept")
+	}
+	offered, err := m.state.GetOfferedIntercept(sessionID, name, owner)
+	if err != nil {
+		return nil, err
+	}
+	allowed, err := identity.MayGetWorkload(ctx, client.Identity, offered.Spec)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !allowed {
+		return nil, status.Errorf(codes.PermissionDenied, "%s isn't allowed to get %s %s.%s",
+			client.Identity.User, offered.Spec.WorkloadKind, offered.Spec.Agent, offered.Spec.Namespace)
+	}
+	ii, err := m.state.HandoffIntercept(sessionID, name, offered.Spec.Client)
+	if err != nil {
+		return nil, err
+	}
+	managerutil.InterceptEvent(ctx, ii.Spec, managerutil.ReasonInterceptHandoff, "Intercept %s handed off from %s to %s",
+		name, managerutil.ClientName(offered.Spec.Client, offered.ClientIdentity), managerutil.ClientName(ii.Spec.Client, ii.ClientIdentity))
+	return ii, nil
+}
+
 // GetIntercept gets an intercept info from intercept name
 func (m *Manager) GetIntercept(ctx context.Context, request *rpc.GetInterceptRequest) (*rpc.InterceptInfo, error) {
 	interceptID, err := m.makeinterceptID(ctx, request.GetSession().GetSessionId(), request.GetName())
@@ -694,11 +1031,28 @@ func (m *Manager) GetIntercept(ctx context.Context, request *rpc.GetInterceptReq
 	}
 	if intercept, ok := m.state.GetIntercept(interceptID); ok {
 		return intercept, nil
+	} else if intercept, ok = m.federation.GetIntercept(interceptID); ok {
+		return intercept, nil
 	} else {
 		return nil, status.Errorf(codes.NotFound, "Intercept named %q not found", request.Name)
 	}
 }
 
+// GetInterceptDiagnostics returns the disposition history of an intercept.
+func (m *Manager) GetInterceptDiagnostics(ctx context.Context, request *rpc.GetInterceptRequest) (*rpc.InterceptDiagnostics, error) {
+	interceptID, err := m.makeinterceptID(ctx, request.GetSession().GetSessionId(), request.GetName())
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := m.state.GetInterceptDiagnostics(interceptID); ok {
+		return d, nil
+	}
+	if d, ok, err := m.federation.GetInterceptDiagnostics(ctx, interceptID); ok {
+		return d, err
+	}
+	return nil, status.Errorf(codes.NotFound, "Intercept named %q not found", request.Name)
+}
+
 // ReviewIntercept lets an agent approve or reject an intercept.
 func (m *Manager) ReviewIntercept(ctx context.Context, rIReq *rpc.ReviewInterceptRequest) (*empty.Empty, error) {
 	ctx = managerutil.WithSessionInfo(ctx, rIReq.GetSession())
@@ -712,7 +1066,16 @@ func (m *Manager) ReviewIntercept(ctx context.Context, rIReq *rpc.ReviewIntercep
 		return nil, status.Errorf(codes.NotFound, "Agent session %q not found", sessionID)
 	}
 
+	env := rIReq.Environment
+	var redacted map[string]*rpc.SecretKeyRef
+	if managerutil.GetEnv(ctx).RedactSecrets {
+		env, redacted = redactSecrets(env, rIReq.EnvironmentSecrets)
+	}
+
+	applied := false
 	intercept := m.state.UpdateIntercept(ceptID, func(intercept *rpc.InterceptInfo) {
+		applied = false
+
 		// Sanity check: The reviewing agent must be an agent for the intercept.
 		if intercept.Spec.Namespace != agent.Namespace || intercept.Spec.Agent != agent.Name {
 			return
@@ -721,31 +1084,38 @@ func (m *Manager) ReviewIntercept(ctx context.Context, rIReq *rpc.ReviewIntercep
 		// Only update intercepts in the waiting state.  Agents race to review an intercept, but we
 		// expect they will always compatible answers.
 		if intercept.Disposition == rpc.InterceptDispositionType_WAITING {
+			applied = true
 			intercept.Disposition = rIReq.Disposition
 			intercept.Message = rIReq.Message
 			intercept.PodIp = rIReq.PodIp
+			intercept.PodName = rIReq.PodName
 			intercept.SftpPort = rIReq.SftpPort
 			intercept.MountPoint = rIReq.MountPoint
 			intercept.MechanismArgsDesc = rIReq.MechanismArgsDesc
 			intercept.Headers = rIReq.Headers
 			intercept.Metadata = rIReq.Metadata
-			intercept.Environment = rIReq.Environment
+			intercept.Environment = env
+			intercept.RedactedEnvironment = redacted
 		}
 	})
 
 	if intercept == nil {
 		return nil, status.Errorf(codes.NotFound, "Intercept with ID %q not found for this session", ceptID)
 	}
+	m.state.RecordInterceptReview(ceptID, agent.PodIp, rIReq.Disposition, rIReq.Message, applied)
 
 	return &empty.Empty{}, nil
 }
 
 func (m *Manager) Tunnel(server rpc.Manager_TunnelServer) error {
 	ctx := server.Context()
-	stream, err := tunnel.NewServerStream(ctx, server)
+	stream, mux, err := tunnel.AcceptServerStream(ctx, server)
 	if err != nil {
 		return status.Errorf(codes.FailedPrecondition, "failed to connect stream: %v", err)
 	}
+	if mux != nil {
+		return mux.Serve(ctx, m.state.Tunnel)
+	}
 	return m.state.Tunnel(ctx, stream)
 }
 
@@ -753,6 +1123,9 @@ func (m *Manager) WatchDial(session *rpc.SessionInfo, stream rpc.Manager_WatchDi
 	ctx := managerutil.WithSessionInfo(stream.Context(), session)
 	dlog.Debugf(ctx, "WatchDial called")
 	lrCh := m.state.WatchDial(session.SessionId)
+	if lrCh == nil {
+		return status.Errorf(codes.NotFound, "Session %q not found", session.SessionId)
+	}
 	for {
 		select {
 		case <-m.ctx.Done():
@@ -863,8 +1236,15 @@ func (m *Manager) WatchClusterInfo(session *rpc.SessionInfo, stream rpc.Manager_
 const clientSessionTTL = 24 * time.Hour
 const agentSessionTTL = 15 * time.Second
 
-// expire removes stale sessions.
+// clientResumeTTL is how long a client session can be resumed after its last heartbeat.
+const clientResumeTTL = 7 * 24 * time.Hour
+
+// expire removes stale sessions, and intercepts that exceed the maximum intercept duration.
 func (m *Manager) expire(ctx context.Context) {
 	now := m.clock.Now()
 	m.state.ExpireSessions(ctx, now.Add(-clientSessionTTL), now.Add(-agentSessionTTL))
+	m.state.ExpireSuspendedSessions(ctx, now.Add(-clientResumeTTL))
+	if env := managerutil.GetEnv(ctx); env != nil && env.MaxInterceptDuration > 0 {
+		m.state.ExpireIntercepts(ctx, now, env.MaxInterceptDuration)
+	}
 }
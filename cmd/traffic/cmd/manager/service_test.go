@@ -9,6 +9,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/test/bufconn"
 	"google.golang.org/protobuf/proto"
 	empty "google.golang.org/protobuf/types/known/emptypb"
@@ -25,6 +26,7 @@ import (
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager"
 	testdata "github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/test"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+	telclient "github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
 	"github.com/telepresenceio/telepresence/v2/pkg/version"
 )
@@ -55,6 +57,39 @@ func TestConnect(t *testing.T) {
 
 	// Alice arrives and departs
 
+	// Clients that are too old are rejected
+	tooOld := proto.Clone(testClients["alice"]).(*rpc.ClientInfo)
+	tooOld.Version = "2.5.8"
+	_, err = client.ArriveAsClient(ctx, tooOld)
+	a.Error(err)
+
+	// A traffic-manager of a later minor release warns the clients that show warnings that they are deprecated
+	version.Version = "v2.8.1"
+	var header metadata.MD
+	deprecated := proto.Clone(testClients["alice"]).(*rpc.ClientInfo)
+	deprecated.Version = "2.7.3"
+	deprecatedSess, err := client.ArriveAsClient(ctx, deprecated, grpc.Header(&header))
+	a.NoError(err)
+	warnings := telclient.ManagerWarnings(header)
+	a.Len(warnings, 1)
+	a.Contains(warnings[0], "client version 2.7.3 is deprecated")
+	a.Contains(warnings[0], "version 2.8.0 or later")
+	_, err = client.Depart(ctx, deprecatedSess)
+	a.NoError(err)
+
+	// Clients that don't show warnings, and current clients, aren't sent any
+	for _, v := range []string{"2.6.0", "2.8.0-rc.1", "2.9.0"} {
+		header = nil
+		ci := proto.Clone(testClients["alice"]).(*rpc.ClientInfo)
+		ci.Version = v
+		sess, err := client.ArriveAsClient(ctx, ci, grpc.Header(&header))
+		a.NoError(err)
+		a.Empty(telclient.ManagerWarnings(header), v)
+		_, err = client.Depart(ctx, sess)
+		a.NoError(err)
+	}
+	version.Version = "testing"
+
 	aliceSess1, err := client.ArriveAsClient(ctx, testClients["alice"])
 	a.NoError(err)
 	t.Logf("aliceSess1: %v", aliceSess1)
@@ -197,6 +232,12 @@ func TestConnect(t *testing.T) {
 		InterceptSpec: spec,
 	})
 	a.NoError(err)
+
+	// Alice's client doesn't declare the dial timeouts, so the defaults are used
+	a.NotZero(first.Spec.DialTimeout)
+	a.NotZero(first.Spec.RoundtripLatency)
+	spec.DialTimeout = first.Spec.DialTimeout
+	spec.RoundtripLatency = first.Spec.RoundtripLatency
 	a.True(proto.Equal(spec, first.Spec))
 	t.Logf("=> intercept info: %s", dumps(first))
 
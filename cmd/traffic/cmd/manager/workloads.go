@@ -0,0 +1,73 @@
+package manager
+
+import (
+	"context"
+	"sort"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+// ListWorkloads lists the workloads of a namespace in the local cluster, or in a peered cluster.
+func (m *Manager) ListWorkloads(ctx context.Context, req *rpc.ListWorkloadsRequest) (*rpc.WorkloadInfoList, error) {
+	ctx = managerutil.WithSessionInfo(ctx, req.GetSession())
+	sessionID := req.GetSession().GetSessionId()
+	dlog.Debugf(ctx, "ListWorkloads called: %s", req.Namespace)
+
+	client := m.state.GetClient(sessionID)
+	if client == nil {
+		return nil, status.Errorf(codes.NotFound, "Client session %q not found", sessionID)
+	}
+	if req.Namespace == "" {
+		return nil, status.Error(codes.InvalidArgument, "namespace must not be empty")
+	}
+	if req.Cluster != "" {
+		return m.federation.ListWorkloads(ctx, client, req)
+	}
+	return m.listWorkloads(ctx, req.Namespace)
+}
+
+// listWorkloads lists the Deployments, ReplicaSets, StatefulSets, and custom workloads of the given namespace in the
+// local cluster, together with their traffic-agents. ReplicaSets that are controlled by a Deployment are omitted in
+// favor of their Deployment.
+func (m *Manager) listWorkloads(ctx context.Context, namespace string) (*rpc.WorkloadInfoList, error) {
+	var wls []k8sapi.Workload
+	for _, list := range []func(context.Context, string, labels.Set) ([]k8sapi.Workload, error){
+		k8sapi.Deployments, k8sapi.ReplicaSets, k8sapi.StatefulSets, k8sapi.CustomWorkloads,
+	} {
+		ws, err := list(ctx, namespace, nil)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "unable to list the workloads in namespace %s: %v", namespace, err)
+		}
+		wls = append(wls, ws...)
+	}
+
+	infos := make([]*rpc.WorkloadInfo, 0, len(wls))
+nextWl:
+	for _, wl := range wls {
+		for _, or := range wl.GetOwnerReferences() {
+			if or.Controller != nil && *or.Controller && or.Kind == "Deployment" {
+				continue nextWl
+			}
+		}
+		info := &rpc.WorkloadInfo{Name: wl.GetName(), Namespace: namespace, Kind: wl.GetKind()}
+		for _, ai := range m.state.GetAgentsByName(wl.GetName(), namespace) {
+			info.AgentInfo = ai
+			break
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Name == infos[j].Name {
+			return infos[i].Kind < infos[j].Kind
+		}
+		return infos[i].Name < infos[j].Name
+	})
+	return &rpc.WorkloadInfoList{Workloads: infos}, nil
+}
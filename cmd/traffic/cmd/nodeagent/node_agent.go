@@ -0,0 +1,494 @@
+//go:build linux
+// +build linux
+
+package nodeagent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-iptables/iptables"
+	"golang.org/x/sys/unix"
+	"gopkg.in/yaml.v3"
+	core "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/datawire/dlib/dgroup"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/agentinit"
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+	"github.com/telepresenceio/telepresence/v2/pkg/version"
+)
+
+// procRoot is where the proc filesystem of the node is found. The traffic-node-agent uses the host's PID
+// namespace, so it's the node's /proc.
+var procRoot = "/proc"
+
+// Main is the main function of the traffic-node-agent. It runs privileged on each node, and redirects the
+// intercepted ports of the pods on its node to the traffic-agents that have been added to them as ephemeral
+// containers. An ephemeral container can't be accompanied by an init container, so the iptables rules that the
+// tel-agent-init container would configure are configured in the network namespace of the pod by this agent.
+//
+// When the traffic-manager uses the node injection mode, the traffic-node-agent also runs the traffic-agents of
+// the pods on its node that the traffic-manager assigns agents to, so that no sidecars are injected.
+func Main(ctx context.Context, _ ...string) error {
+	dlog.Infof(ctx, "Traffic Node Agent %s [pid:%d]", version.Version, os.Getpid())
+
+	node := os.Getenv("NODE_NAME")
+	if node == "" {
+		return errors.New("the NODE_NAME environment variable is not set")
+	}
+	managerNamespace := os.Getenv("MANAGER_NAMESPACE")
+	if managerNamespace == "" {
+		return errors.New("the MANAGER_NAMESPACE environment variable is not set")
+	}
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return fmt.Errorf("unable to get the Kubernetes InClusterConfig: %w", err)
+	}
+	ki, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("unable to create the Kubernetes Interface from InClusterConfig: %w", err)
+	}
+	ctx = k8sapi.WithK8sInterface(ctx, ki)
+
+	g := dgroup.NewGroup(ctx, dgroup.GroupConfig{
+		EnableSignalHandling: true,
+	})
+	agents := newPodAgents()
+	ephemerals := newEphemeralAgents()
+
+	// The assignments are loaded before the pods are watched, so that the agents of the pods that already have one
+	// aren't released when the traffic-node-agent restarts.
+	for name, assign := range map[string]func(context.Context, map[string]string){
+		agentconfig.NodeAgentConfigMap:      agents.assign,
+		agentconfig.EphemeralAgentConfigMap: ephemerals.assign,
+	} {
+		name, assign := name, assign
+		cm, err := ki.CoreV1().ConfigMaps(managerNamespace).Get(ctx, name, meta.GetOptions{})
+		switch {
+		case err == nil:
+			assign(ctx, cm.Data)
+		case !k8sErrors.IsNotFound(err):
+			return fmt.Errorf("unable to get ConfigMap %s.%s: %w", name, managerNamespace, err)
+		}
+		g.Go("watcher-"+name, func(ctx context.Context) error {
+			watchAssignments(ctx, managerNamespace, name, assign)
+			return nil
+		})
+	}
+	namespaces := strings.Fields(os.Getenv("MANAGED_NAMESPACES"))
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+	for _, ns := range namespaces {
+		ns := ns
+		g.Go("pod-watcher-"+ns, func(ctx context.Context) error {
+			watchPods(ctx, node, ns, agents, ephemerals)
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// watchPods redirects the ports of each pod on the given node that gets an ephemeral traffic-agent, and runs the
+// traffic-agents of the pods that are assigned one.
+func watchPods(ctx context.Context, node, ns string, agents *podAgents, ephemerals *ephemeralAgents) {
+	// The Watch will perform a http GET call to the kubernetes API server, and that connection will not remain open forever
+	// so when it closes, the watch must start over. This goes on until the context is cancelled.
+	api := k8sapi.GetK8sInterface(ctx).CoreV1().Pods(ns)
+	opts := meta.ListOptions{FieldSelector: fields.OneTermEqualSelector("spec.nodeName", node).String()}
+	for ctx.Err() == nil {
+		w, err := api.Watch(ctx, opts)
+		if err != nil {
+			dlog.Errorf(ctx, "unable to create pod watcher: %v", err)
+			return
+		}
+		for ev := range w.ResultChan() {
+			pod, ok := ev.Object.(*core.Pod)
+			if !ok {
+				continue
+			}
+			switch ev.Type {
+			case watch.Added, watch.Modified:
+				if err = ephemerals.update(ctx, pod); err != nil {
+					dlog.Errorf(ctx, "unable to redirect the ports of pod %s.%s: %v", pod.Name, pod.Namespace, err)
+				}
+				if err = agents.update(ctx, pod); err != nil {
+					dlog.Errorf(ctx, "unable to run the traffic-agent of pod %s.%s: %v", pod.Name, pod.Namespace, err)
+				}
+			case watch.Deleted:
+				agents.forget(pod.UID)
+				ephemerals.forget(pod.UID)
+			}
+		}
+		w.Stop()
+	}
+}
+
+// watchAssignments calls the given assign function with the entries of the named ConfigMap in the given namespace of
+// the traffic-manager whenever they change. Only the traffic-manager can write that ConfigMap, so unlike the
+// annotations of a pod, or its ephemeral containers, which anyone who can patch the pod can set, its configs are
+// trusted.
+func watchAssignments(ctx context.Context, ns, name string, assign func(context.Context, map[string]string)) {
+	api := k8sapi.GetK8sInterface(ctx).CoreV1().ConfigMaps(ns)
+	opts := meta.ListOptions{FieldSelector: fields.OneTermEqualSelector("metadata.name", name).String()}
+	for ctx.Err() == nil {
+		w, err := api.Watch(ctx, opts)
+		if err != nil {
+			dlog.Errorf(ctx, "unable to create ConfigMap watcher: %v", err)
+			return
+		}
+		for ev := range w.ResultChan() {
+			cm, ok := ev.Object.(*core.ConfigMap)
+			if !ok {
+				continue
+			}
+			switch ev.Type {
+			case watch.Added, watch.Modified:
+				assign(ctx, cm.Data)
+			case watch.Deleted:
+				assign(ctx, nil)
+			}
+		}
+		w.Stop()
+	}
+}
+
+// ephemeralAgents are the ephemeral traffic-agents that the traffic-manager has added to the pods of the node.
+type ephemeralAgents struct {
+	sync.Mutex
+
+	// configs are the configs of the entries of the EphemeralAgentConfigMap, keyed by the UIDs of their pods.
+	configs map[types.UID]string
+
+	// redirected are the container IDs of the ephemeral traffic-agents that the intercepted ports of the pods are
+	// redirected to, keyed by the UIDs of the pods.
+	redirected map[types.UID]string
+
+	// pods are the running pods of the node that have an ephemeral traffic-agent, so that their ports are redirected,
+	// or no longer redirected, when the EphemeralAgentConfigMap changes.
+	pods map[types.UID]*core.Pod
+
+	// updateLock serializes the updates of the redirects.
+	updateLock sync.Mutex
+}
+
+func newEphemeralAgents() *ephemeralAgents {
+	return &ephemeralAgents{
+		configs:    make(map[types.UID]string),
+		redirected: make(map[types.UID]string),
+		pods:       make(map[types.UID]*core.Pod),
+	}
+}
+
+// assign replaces the configs with the given entries of the EphemeralAgentConfigMap, and updates the redirects of the
+// running pods accordingly.
+func (eas *ephemeralAgents) assign(ctx context.Context, data map[string]string) {
+	configs := make(map[types.UID]string)
+	for key, value := range data {
+		var ea agentconfig.EphemeralAgents
+		if err := yaml.Unmarshal([]byte(value), &ea); err != nil {
+			dlog.Errorf(ctx, "unable to decode entry %s of ConfigMap %s: %v", key, agentconfig.EphemeralAgentConfigMap, err)
+			continue
+		}
+		for _, uid := range ea.Pods {
+			configs[types.UID(uid)] = ea.Config
+		}
+	}
+	eas.Lock()
+	eas.configs = configs
+	pods := make([]*core.Pod, 0, len(eas.pods))
+	for _, pod := range eas.pods {
+		pods = append(pods, pod)
+	}
+	eas.Unlock()
+	for _, pod := range pods {
+		if err := eas.update(ctx, pod); err != nil {
+			dlog.Errorf(ctx, "unable to redirect the ports of pod %s.%s: %v", pod.Name, pod.Namespace, err)
+		}
+	}
+}
+
+// update configures the iptables rules that redirect the intercepted ports of the given pod to its ephemeral
+// traffic-agent once the agent is running, and records that in the EphemeralRedirectAnnotation of the pod. The
+// redirect is only configured when the EphemeralAgentConfigMap lists the pod, and it uses the config of that entry.
+// The redirect is undone when the agent terminates, or when the traffic-manager removes the entry.
+func (eas *ephemeralAgents) update(ctx context.Context, pod *core.Pod) error {
+	eas.updateLock.Lock()
+	defer eas.updateLock.Unlock()
+	if pod.DeletionTimestamp != nil || pod.Status.Phase != core.PodRunning || !agentconfig.HasEphemeralAgent(pod) {
+		// The network namespace goes away with the pod.
+		eas.forget(pod.UID)
+		return nil
+	}
+	eas.Lock()
+	eas.pods[pod.UID] = pod
+	config := eas.configs[pod.UID]
+	redirected, isRedirected := eas.redirected[pod.UID]
+	eas.Unlock()
+	if !isRedirected {
+		// The redirect might have been configured before the traffic-node-agent restarted. Removing the redirect
+		// only clears the chains of telepresence, so the annotation is good enough to tell that.
+		_, isRedirected = pod.Annotations[agentconfig.EphemeralRedirectAnnotation]
+	}
+	if needsUnredirect(pod, config, isRedirected) {
+		return eas.unredirect(ctx, pod)
+	}
+	if !needsRedirect(pod, config, redirected) {
+		return nil
+	}
+	ac, err := verifyEphemeralAgent(pod, config)
+	if err != nil {
+		return err
+	}
+	cs := agentconfig.EphemeralAgentStatus(pod)
+	pid, uid, err := findContainerProcess(containerID(cs.ContainerID))
+	if err != nil {
+		return err
+	}
+	err = inNetNS(pid, func() error {
+		lo, err := agentinit.FindLoopback(ctx)
+		if err != nil {
+			return err
+		}
+		it, err := iptables.New()
+		if err != nil {
+			return fmt.Errorf("unable to create iptables instance: %w", err)
+		}
+		// The ports might have been redirected before the traffic-node-agent restarted.
+		if err = agentinit.ClearIptables(it); err != nil {
+			return err
+		}
+		return agentinit.ConfigureIptables(ctx, ac, it, lo, uid)
+	})
+	if err != nil {
+		return err
+	}
+	eas.Lock()
+	eas.redirected[pod.UID] = cs.ContainerID
+	eas.Unlock()
+	if pod.Annotations[agentconfig.EphemeralRedirectAnnotation] != cs.ContainerID {
+		if err = annotatePod(ctx, pod, agentconfig.EphemeralRedirectAnnotation, &cs.ContainerID); err != nil {
+			return err
+		}
+	}
+	dlog.Infof(ctx, "Redirected the intercepted ports of pod %s.%s to its ephemeral traffic-agent", pod.Name, pod.Namespace)
+	return nil
+}
+
+// forget removes the pod with the given UID from the running pods.
+func (eas *ephemeralAgents) forget(uid types.UID) {
+	eas.Lock()
+	delete(eas.pods, uid)
+	delete(eas.redirected, uid)
+	eas.Unlock()
+}
+
+// needsRedirect returns true if the given pod has a running ephemeral traffic-agent, the EphemeralAgentConfigMap
+// gives it the given non-empty config, and its intercepted ports aren't redirected to the agent with the container ID
+// that they're redirected to.
+func needsRedirect(pod *core.Pod, config, redirected string) bool {
+	cs := agentconfig.EphemeralAgentStatus(pod)
+	if cs == nil || cs.State.Running == nil || cs.ContainerID == "" || config == "" {
+		return false
+	}
+	return redirected != cs.ContainerID
+}
+
+// needsUnredirect returns true if the intercepted ports of the given running pod are redirected to an ephemeral
+// traffic-agent that has terminated, or that the EphemeralAgentConfigMap no longer gives a config.
+func needsUnredirect(pod *core.Pod, config string, redirected bool) bool {
+	if !redirected {
+		return false
+	}
+	if pod.DeletionTimestamp != nil || pod.Status.Phase != core.PodRunning {
+		// The network namespace goes away with the pod.
+		return false
+	}
+	if config == "" {
+		return true
+	}
+	cs := agentconfig.EphemeralAgentStatus(pod)
+	return cs == nil || cs.State.Terminated != nil
+}
+
+// unredirect clears the iptables chains that the intercepted ports of the given pod are redirected to, so that
+// the traffic reaches the app containers again, and removes the EphemeralRedirectAnnotation of the pod. The agent
+// process might be gone, so the network namespace is entered through another process of the pod.
+func (eas *ephemeralAgents) unredirect(ctx context.Context, pod *core.Pod) error {
+	pid, _, err := findPodProcess(pod.UID)
+	if err != nil {
+		return err
+	}
+	err = inNetNS(pid, func() error {
+		it, err := iptables.New()
+		if err != nil {
+			return fmt.Errorf("unable to create iptables instance: %w", err)
+		}
+		return agentinit.ClearIptables(it)
+	})
+	if err != nil {
+		return err
+	}
+	eas.Lock()
+	delete(eas.redirected, pod.UID)
+	eas.Unlock()
+	if _, ok := pod.Annotations[agentconfig.EphemeralRedirectAnnotation]; ok {
+		if err = annotatePod(ctx, pod, agentconfig.EphemeralRedirectAnnotation, nil); err != nil {
+			return err
+		}
+	}
+	dlog.Infof(ctx, "Removed the redirect of the intercepted ports of pod %s.%s to its ephemeral traffic-agent", pod.Name, pod.Namespace)
+	return nil
+}
+
+// annotatePod sets the given annotation of the given pod to the given value, or removes it when the value is nil.
+func annotatePod(ctx context.Context, pod *core.Pod, key string, value *string) error {
+	patch, err := json.Marshal(map[string]any{"metadata": map[string]any{"annotations": map[string]*string{key: value}}})
+	if err != nil {
+		return err
+	}
+	_, err = k8sapi.GetK8sInterface(ctx).CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.MergePatchType, patch, meta.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to annotate pod %s.%s: %w", pod.Name, pod.Namespace, err)
+	}
+	return nil
+}
+
+// verifyEphemeralAgent decodes the given config of the ephemeral traffic-agent of the given pod, which is the config
+// of its entry in the EphemeralAgentConfigMap. The ephemeral container must have been created from that config,
+// because anyone who can add ephemeral containers to the pod could otherwise have the ports redirected to a
+// container of their own.
+func verifyEphemeralAgent(pod *core.Pod, config string) (*agentconfig.Sidecar, error) {
+	ac := agentconfig.Sidecar{}
+	if err := yaml.Unmarshal([]byte(config), &ac); err != nil {
+		return nil, fmt.Errorf("unable to decode the config of the ephemeral traffic-agent: %w", err)
+	}
+	if ac.Namespace != pod.Namespace {
+		return nil, fmt.Errorf("the config of the ephemeral traffic-agent of pod %s.%s is for namespace %s", pod.Name, pod.Namespace, ac.Namespace)
+	}
+	if ec, ok := agentconfig.EphemeralAgentConfig(pod); !ok || ec != config {
+		return nil, fmt.Errorf("the ephemeral traffic-agent of pod %s.%s wasn't created with the config that the traffic-manager gave it", pod.Name, pod.Namespace)
+	}
+	for i := range pod.Spec.EphemeralContainers {
+		if ec := &pod.Spec.EphemeralContainers[i]; ec.Name == agentconfig.ContainerName && ec.Image != ac.AgentImage {
+			return nil, fmt.Errorf("the ephemeral traffic-agent of pod %s.%s doesn't use the image %s", pod.Name, pod.Namespace, ac.AgentImage)
+		}
+	}
+	return &ac, nil
+}
+
+// containerID returns the ID of a container, without the "<runtime>://" prefix of its status.
+func containerID(id string) string {
+	if i := strings.Index(id, "://"); i >= 0 {
+		id = id[i+3:]
+	}
+	return id
+}
+
+// findContainerProcess returns the PID of the first process of the container with the given ID, and the
+// effective UID of that process. The process is found by its cgroup, the path of which contains the ID of
+// the container regardless of the cgroup driver.
+func findContainerProcess(id string) (int, int, error) {
+	return findProcess("container "+id, id)
+}
+
+// findPodProcess returns the PID of the first process of the pod with the given UID, which is typically the
+// process of the pod's sandbox, and the effective UID of that process. The cgroup path of the process contains
+// the UID of the pod, with the dashes replaced by underscores when the systemd cgroup driver is used.
+func findPodProcess(uid types.UID) (int, int, error) {
+	return findProcess("pod "+string(uid), string(uid), strings.ReplaceAll(string(uid), "-", "_"))
+}
+
+// findProcess returns the PID of the first process with a cgroup path that contains one of the given IDs, and the
+// effective UID of that process.
+func findProcess(desc string, ids ...string) (int, int, error) {
+	des, err := os.ReadDir(procRoot)
+	if err != nil {
+		return 0, 0, err
+	}
+	found := 0
+	for _, de := range des {
+		pid, err := strconv.Atoi(de.Name())
+		if err != nil || found != 0 && pid >= found {
+			continue
+		}
+		cg, err := os.ReadFile(filepath.Join(procRoot, de.Name(), "cgroup"))
+		if err != nil {
+			continue
+		}
+		for _, id := range ids {
+			if strings.Contains(string(cg), id) {
+				found = pid
+				break
+			}
+		}
+	}
+	if found == 0 {
+		return 0, 0, fmt.Errorf("no process of %s was found", desc)
+	}
+	uid, err := effectiveUID(found)
+	if err != nil {
+		return 0, 0, err
+	}
+	return found, uid, nil
+}
+
+// effectiveUID returns the effective UID of the process with the given PID.
+func effectiveUID(pid int) (int, error) {
+	f, err := os.Open(filepath.Join(procRoot, strconv.Itoa(pid), "status"))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// Uid: real, effective, saved set, and filesystem UIDs
+		if fs := strings.Fields(sc.Text()); len(fs) >= 3 && fs[0] == "Uid:" {
+			return strconv.Atoi(fs[2])
+		}
+	}
+	if err = sc.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("the status of process %d has no Uid", pid)
+}
+
+// inNetNS calls the given function on a thread that has entered the network namespace of the process with the
+// given PID. The processes that the function starts, such as iptables, inherit that namespace.
+func inNetNS(pid int, f func() error) error {
+	ns, err := os.Open(filepath.Join(procRoot, strconv.Itoa(pid), "ns", "net"))
+	if err != nil {
+		return err
+	}
+	defer ns.Close()
+	errCh := make(chan error, 1)
+	go func() {
+		// The thread is never unlocked, so it's discarded instead of being reused in the node's namespace when
+		// the goroutine ends.
+		runtime.LockOSThread()
+		if err := unix.Setns(int(ns.Fd()), unix.CLONE_NEWNET); err != nil {
+			errCh <- fmt.Errorf("unable to enter the network namespace of process %d: %w", pid, err)
+			return
+		}
+		errCh <- f()
+	}()
+	return <-errCh
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package nodeagent
+
+import (
+	"context"
+	"fmt"
+)
+
+// This file needs to exist because node_agent.go enters network namespaces, which only exist on linux.
+
+// Main is the main function of the traffic-node-agent
+func Main(ctx context.Context, args ...string) error {
+	return fmt.Errorf("the traffic-node-agent requires linux")
+}
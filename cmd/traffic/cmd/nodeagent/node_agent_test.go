@@ -0,0 +1,250 @@
+//go:build linux
+// +build linux
+
+package nodeagent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+)
+
+func TestFindContainerProcess(t *testing.T) {
+	defer func(r string) { procRoot = r }(procRoot)
+	procRoot = t.TempDir()
+	process := func(pid, cgroup, uid string) {
+		dir := filepath.Join(procRoot, pid)
+		require.NoError(t, os.Mkdir(dir, 0700))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "cgroup"), []byte(cgroup), 0600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "status"), []byte("Name:\ttraffic\nUid:\t"+uid+"\t"+uid+"\t"+uid+"\t"+uid+"\n"), 0600))
+	}
+	const agentID = "3f4e1c2b9a"
+	process("1", "0::/init.scope\n", "0")
+	process("200", "0::/kubepods.slice/kubepods-pod1.slice/cri-containerd-"+agentID+".scope\n", "65532")
+	process("1000", "0::/kubepods.slice/kubepods-pod1.slice/cri-containerd-"+agentID+".scope\n", "65532")
+	process("30", "0::/kubepods.slice/kubepods-pod1.slice/cri-containerd-0a1b2c3d4e.scope\n", "1000")
+	require.NoError(t, os.Mkdir(filepath.Join(procRoot, "sys"), 0700))
+
+	pid, uid, err := findContainerProcess(containerID("containerd://" + agentID))
+	require.NoError(t, err)
+	assert.Equal(t, 200, pid, "not the first process of the container")
+	assert.Equal(t, 65532, uid)
+
+	_, _, err = findContainerProcess("5e6f7a8b9c")
+	assert.Error(t, err)
+}
+
+func TestVerifyEphemeralAgent(t *testing.T) {
+	pod := &core.Pod{
+		ObjectMeta: meta.ObjectMeta{Name: "echo-1", Namespace: "default"},
+		Spec: core.PodSpec{
+			Containers: []core.Container{{Name: "echo"}},
+		},
+	}
+	ac := &agentconfig.Sidecar{
+		AgentName:  "echo",
+		AgentImage: "ghcr.io/telepresenceio/tel2:2.7.0",
+		Namespace:  "default",
+		Containers: []*agentconfig.Container{{
+			Name:       "echo",
+			Mounts:     []string{},
+			Intercepts: []*agentconfig.Intercept{{ContainerPort: 8080, AgentPort: 9900, Protocol: "TCP"}},
+		}},
+	}
+	config, err := agentconfig.EncodeEphemeralConfig(ac)
+	require.NoError(t, err)
+	_, err = verifyEphemeralAgent(pod, config)
+	assert.Error(t, err, "the pod has no ephemeral traffic-agent")
+
+	ec, err := agentconfig.EphemeralAgentContainer(pod, ac)
+	require.NoError(t, err)
+	pod.Spec.EphemeralContainers = []core.EphemeralContainer{*ec}
+	got, err := verifyEphemeralAgent(pod, config)
+	require.NoError(t, err)
+	assert.Equal(t, ac, got)
+
+	// The config of the traffic-manager must be the one that the agent was created with
+	other := *ac
+	other.AgentName = "other"
+	otherConfig, err := agentconfig.EncodeEphemeralConfig(&other)
+	require.NoError(t, err)
+	_, err = verifyEphemeralAgent(pod, otherConfig)
+	assert.Error(t, err)
+
+	// An ephemeral container that runs another image isn't a traffic-agent, even with the right config
+	pod.Spec.EphemeralContainers[0].Image = "example.com/sniffer"
+	_, err = verifyEphemeralAgent(pod, config)
+	assert.Error(t, err)
+	pod.Spec.EphemeralContainers[0].Image = ac.AgentImage
+
+	// A config for another namespace is rejected
+	pod.Namespace = "other"
+	_, err = verifyEphemeralAgent(pod, config)
+	assert.Error(t, err)
+}
+
+func TestEphemeralAgents_assign(t *testing.T) {
+	eas := newEphemeralAgents()
+	eas.assign(dlog.NewTestContext(t, false), map[string]string{
+		agentconfig.NodeAgentKey("echo", "default"): "pods:\n- uid-1\n- uid-2\nconfig: |\n  agentName: echo\n",
+		agentconfig.NodeAgentKey("bad", "default"):  "pods: [",
+	})
+	assert.Equal(t, map[types.UID]string{"uid-1": "agentName: echo\n", "uid-2": "agentName: echo\n"}, eas.configs)
+
+	eas.assign(dlog.NewTestContext(t, false), nil)
+	assert.Empty(t, eas.configs)
+}
+
+func TestFindPodProcess(t *testing.T) {
+	defer func(r string) { procRoot = r }(procRoot)
+	procRoot = t.TempDir()
+	process := func(pid, cgroup string) {
+		dir := filepath.Join(procRoot, pid)
+		require.NoError(t, os.Mkdir(dir, 0700))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "cgroup"), []byte(cgroup), 0600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "status"), []byte("Name:\tpause\nUid:\t0\t0\t0\t0\n"), 0600))
+	}
+	const podUID = "d3b07384-d113-4ec6-a6a5-b2f1c6e0f0a1"
+	process("40", "0::/kubepods.slice/kubepods-pod"+strings.ReplaceAll(podUID, "-", "_")+".slice/cri-containerd-3f4e1c2b9a.scope\n")
+	process("50", "12:memory:/kubepods/besteffort/pod"+podUID+"/0a1b2c3d4e\n")
+
+	pid, _, err := findPodProcess(podUID)
+	require.NoError(t, err)
+	assert.Equal(t, 40, pid)
+
+	_, _, err = findPodProcess("6f1ed002-ab5d-42f0-8c6c-5b8d7f0e3a2b")
+	assert.Error(t, err)
+}
+
+func TestAgentEnv(t *testing.T) {
+	pod := &core.Pod{
+		ObjectMeta: meta.ObjectMeta{Name: "echo-1", Namespace: "default"},
+		Spec: core.PodSpec{
+			Containers: []core.Container{{
+				Name: "echo",
+				Env: []core.EnvVar{
+					{Name: "GREETING", Value: "hello"},
+					{Name: "PASSWORD", ValueFrom: &core.EnvVarSource{SecretKeyRef: &core.SecretKeySelector{Key: "password"}}},
+				},
+			}},
+		},
+		Status: core.PodStatus{PodIP: "10.1.2.3"},
+	}
+	ac := &agentconfig.Sidecar{
+		AgentName: "echo",
+		Containers: []*agentconfig.Container{{
+			Name:       "echo",
+			EnvPrefix:  "A_",
+			Intercepts: []*agentconfig.Intercept{{ContainerPort: 8080, AgentPort: 9900, Protocol: "TCP"}},
+		}},
+	}
+	env, err := agentEnv(pod, ac, "agentName: echo\n")
+	require.NoError(t, err)
+	assert.Contains(t, env, agentconfig.EnvPrefixAgent+"POD_IP=10.1.2.3")
+	assert.Contains(t, env, agentconfig.EnvPrefixAgent+"NAME=echo-1")
+	assert.Contains(t, env, agentconfig.EnvAgentConfig+"=agentName: echo\n")
+	var hasGreeting bool
+	for _, e := range env {
+		assert.NotContains(t, e, "PASSWORD")
+		if strings.HasSuffix(e, "GREETING=hello") {
+			hasGreeting = true
+		}
+	}
+	assert.True(t, hasGreeting, "the literal value of the app container's environment is missing")
+	for _, e := range os.Environ() {
+		assert.NotContains(t, env, e, "the environment of the traffic-node-agent is inherited")
+	}
+
+	_, err = agentEnv(pod, &agentconfig.Sidecar{AgentName: "echo"}, "")
+	assert.Error(t, err)
+}
+
+func TestPodAgents_assignedConfig(t *testing.T) {
+	const config = "agentName: echo\nnamespace: default\n"
+	pas := newPodAgents()
+	pas.assign(dlog.NewTestContext(t, false), map[string]string{
+		agentconfig.NodeAgentKey("echo", "default"): "selector: app=echo\nconfig: |\n  agentName: echo\n  namespace: default\n",
+		agentconfig.NodeAgentKey("bad", "default"):  "selector: \"\"\nconfig: |\n  agentName: bad\n  namespace: default\n",
+	})
+	pod := func(ns string, lbs map[string]string, annotations map[string]string) *core.Pod {
+		return &core.Pod{ObjectMeta: meta.ObjectMeta{Name: "echo-1", Namespace: ns, Labels: lbs, Annotations: annotations}}
+	}
+
+	ac, ok := pas.assignedConfig(pod("default", map[string]string{"app": "echo"}, nil))
+	assert.True(t, ok)
+	assert.Equal(t, config, ac)
+
+	// A pod in another namespace isn't assigned the agent
+	_, ok = pas.assignedConfig(pod("other", map[string]string{"app": "echo"}, nil))
+	assert.False(t, ok)
+
+	// An entry with an empty selector would select all pods, so it's ignored
+	_, ok = pas.assignedConfig(pod("default", map[string]string{"app": "bad"}, nil))
+	assert.False(t, ok)
+
+	// The annotation of a pod isn't trusted
+	_, ok = pas.assignedConfig(pod("default", map[string]string{"app": "other"}, map[string]string{
+		agentconfig.NodeAgentConfigAnnotation: config,
+	}))
+	assert.False(t, ok)
+}
+
+func TestNeedsRedirect(t *testing.T) {
+	const agentID = "containerd://3f4e1c2b9a"
+	const config = "agentName: echo\n"
+	pod := func(state core.ContainerState) *core.Pod {
+		return &core.Pod{
+			ObjectMeta: meta.ObjectMeta{Name: "echo-1", Namespace: "default"},
+			Status: core.PodStatus{
+				Phase: core.PodRunning,
+				EphemeralContainerStatuses: []core.ContainerStatus{{
+					Name:        agentconfig.ContainerName,
+					ContainerID: agentID,
+					State:       state,
+				}},
+			},
+		}
+	}
+	running := core.ContainerState{Running: &core.ContainerStateRunning{}}
+	terminated := core.ContainerState{Terminated: &core.ContainerStateTerminated{}}
+
+	tests := []struct {
+		name       string
+		pod        *core.Pod
+		config     string
+		redirected string
+		redirect   bool
+		unredirect bool
+	}{
+		{"no agent", &core.Pod{Status: core.PodStatus{Phase: core.PodRunning}}, config, "", false, false},
+		{"waiting", pod(core.ContainerState{Waiting: &core.ContainerStateWaiting{}}), config, "", false, false},
+		{"running", pod(running), config, "", true, false},
+		{"running without an entry", pod(running), "", "", false, false},
+		{"redirected", pod(running), config, agentID, false, false},
+		{"redirected to another agent", pod(running), config, "containerd://0a1b2c3d4e", true, false},
+		{"released", pod(running), "", agentID, false, true},
+		{"terminated", pod(terminated), config, agentID, false, true},
+		{"terminated and not redirected", pod(terminated), config, "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.redirect, needsRedirect(tt.pod, tt.config, tt.redirected))
+			assert.Equal(t, tt.unredirect, needsUnredirect(tt.pod, tt.config, tt.redirected != ""))
+		})
+	}
+
+	// The network namespace of a pod that is done goes away with it
+	p := pod(terminated)
+	p.Status.Phase = core.PodSucceeded
+	assert.False(t, needsUnredirect(p, config, true))
+}
@@ -0,0 +1,318 @@
+//go:build linux
+// +build linux
+
+package nodeagent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/coreos/go-iptables/iptables"
+	"gopkg.in/yaml.v3"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/datawire/dlib/dexec"
+	"github.com/datawire/dlib/dlog"
+	"github.com/datawire/dlib/dtime"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/agentinit"
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+)
+
+// agentUID is the UID of the traffic-agents that the traffic-node-agent runs. It must differ from the UIDs of the
+// app containers, because the iptables rules that redirect the intercepted ports exempt the traffic of the agent.
+const agentUID = 7777
+
+// restartDelay is the time that the traffic-node-agent waits before it restarts a traffic-agent that exited.
+const restartDelay = 5 * time.Second
+
+// podAgent is a traffic-agent that the traffic-node-agent runs for a pod.
+type podAgent struct {
+	hash   string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// assignment is an entry of the NodeAgentConfigMap.
+type assignment struct {
+	namespace string
+	selector  labels.Selector
+	config    string
+}
+
+// podAgents are the traffic-agents that the traffic-node-agent runs, keyed by the UIDs of their pods.
+type podAgents struct {
+	sync.Mutex
+	agents map[types.UID]*podAgent
+
+	// assignments are the entries of the NodeAgentConfigMap, keyed by workload name and namespace.
+	assignments map[string]*assignment
+
+	// pods are the running pods of the node, so that the agents of new pods of an assigned workload are started,
+	// and the agents of the pods of a released workload are stopped, when the NodeAgentConfigMap changes.
+	pods map[types.UID]*core.Pod
+
+	// updateLock serializes the updates of the agents.
+	updateLock sync.Mutex
+}
+
+func newPodAgents() *podAgents {
+	return &podAgents{
+		agents:      make(map[types.UID]*podAgent),
+		assignments: make(map[string]*assignment),
+		pods:        make(map[types.UID]*core.Pod),
+	}
+}
+
+// assign replaces the assignments with the given entries of the NodeAgentConfigMap, and updates the agents of the
+// running pods accordingly.
+func (pas *podAgents) assign(ctx context.Context, data map[string]string) {
+	as := make(map[string]*assignment, len(data))
+	for key, value := range data {
+		var na agentconfig.NodeAgent
+		if err := yaml.Unmarshal([]byte(value), &na); err != nil {
+			dlog.Errorf(ctx, "unable to decode entry %s of ConfigMap %s: %v", key, agentconfig.NodeAgentConfigMap, err)
+			continue
+		}
+		ac := agentconfig.Sidecar{}
+		if err := yaml.Unmarshal([]byte(na.Config), &ac); err != nil {
+			dlog.Errorf(ctx, "unable to decode the traffic-agent config of entry %s of ConfigMap %s: %v", key, agentconfig.NodeAgentConfigMap, err)
+			continue
+		}
+		sel, err := labels.Parse(na.Selector)
+		if err != nil || sel.Empty() {
+			dlog.Errorf(ctx, "invalid selector %q in entry %s of ConfigMap %s: %v", na.Selector, key, agentconfig.NodeAgentConfigMap, err)
+			continue
+		}
+		as[key] = &assignment{namespace: ac.Namespace, selector: sel, config: na.Config}
+	}
+	pas.Lock()
+	pas.assignments = as
+	pods := make([]*core.Pod, 0, len(pas.pods))
+	for _, pod := range pas.pods {
+		pods = append(pods, pod)
+	}
+	pas.Unlock()
+	for _, pod := range pods {
+		if err := pas.update(ctx, pod); err != nil {
+			dlog.Errorf(ctx, "unable to run the traffic-agent of pod %s.%s: %v", pod.Name, pod.Namespace, err)
+		}
+	}
+}
+
+// assignedConfig returns the config of the traffic-agent that the NodeAgentConfigMap assigns to the given pod.
+func (pas *podAgents) assignedConfig(pod *core.Pod) (string, bool) {
+	pas.Lock()
+	defer pas.Unlock()
+	pls := labels.Set(pod.Labels)
+	for _, a := range pas.assignments {
+		if a.namespace == pod.Namespace && a.selector.Matches(pls) {
+			return a.config, true
+		}
+	}
+	return "", false
+}
+
+// update starts, restarts, or stops the traffic-agent of the given pod, so that it runs with the config that the
+// NodeAgentConfigMap assigns to the pod. The agent runs in the network namespace of the pod, but in the file system
+// of the traffic-node-agent, so its config is passed in the environment.
+func (pas *podAgents) update(ctx context.Context, pod *core.Pod) error {
+	pas.updateLock.Lock()
+	defer pas.updateLock.Unlock()
+	if pod.DeletionTimestamp != nil || pod.Status.Phase != core.PodRunning {
+		pas.forget(pod.UID)
+		return nil
+	}
+	pas.Lock()
+	pas.pods[pod.UID] = pod
+	pas.Unlock()
+	config, ok := pas.assignedConfig(pod)
+	if !ok {
+		return pas.release(ctx, pod)
+	}
+	hash := agentconfig.NodeAgentConfigHash(config)
+	pas.Lock()
+	pa, ok := pas.agents[pod.UID]
+	pas.Unlock()
+	if ok && pa.hash == hash {
+		return nil
+	}
+
+	ac := agentconfig.Sidecar{}
+	if err := yaml.Unmarshal([]byte(config), &ac); err != nil {
+		return fmt.Errorf("unable to decode the traffic-agent config: %w", err)
+	}
+	env, err := agentEnv(pod, &ac, config)
+	if err != nil {
+		return err
+	}
+	pas.stop(pod.UID)
+	pid, _, err := findPodProcess(pod.UID)
+	if err != nil {
+		return err
+	}
+	err = inNetNS(pid, func() error {
+		lo, err := agentinit.FindLoopback(ctx)
+		if err != nil {
+			return err
+		}
+		it, err := iptables.New()
+		if err != nil {
+			return fmt.Errorf("unable to create iptables instance: %w", err)
+		}
+		// A changed config might not intercept the ports that the previous one did.
+		if err = agentinit.ClearIptables(it); err != nil {
+			return err
+		}
+		return agentinit.ConfigureIptables(ctx, &ac, it, lo, agentUID)
+	})
+	if err != nil {
+		return err
+	}
+
+	actx, cancel := context.WithCancel(ctx)
+	pa = &podAgent{hash: hash, cancel: cancel, done: make(chan struct{})}
+	pas.Lock()
+	pas.agents[pod.UID] = pa
+	pas.Unlock()
+	go pa.run(actx, pod, env)
+
+	// Pods that were created after the workload was assigned agents aren't annotated by the traffic-manager.
+	if pod.Annotations[agentconfig.NodeAgentConfigAnnotation] != hash {
+		if err = annotatePod(ctx, pod, agentconfig.NodeAgentConfigAnnotation, &hash); err != nil {
+			return err
+		}
+	}
+	if err = annotatePod(ctx, pod, agentconfig.NodeAgentRedirectAnnotation, &hash); err != nil {
+		return err
+	}
+	dlog.Infof(ctx, "Started the traffic-agent of pod %s.%s", pod.Name, pod.Namespace)
+	return nil
+}
+
+// forget stops the traffic-agent of the pod with the given UID and removes the pod from the running pods.
+func (pas *podAgents) forget(uid types.UID) {
+	pas.Lock()
+	delete(pas.pods, uid)
+	pas.Unlock()
+	pas.stop(uid)
+}
+
+// stop stops the traffic-agent of the pod with the given UID, if it has one.
+func (pas *podAgents) stop(uid types.UID) {
+	pas.Lock()
+	pa, ok := pas.agents[uid]
+	delete(pas.agents, uid)
+	pas.Unlock()
+	if ok {
+		pa.cancel()
+		<-pa.done
+	}
+}
+
+// release stops the traffic-agent of the given running pod when the pod no longer is assigned one, removes the
+// redirects of its intercepted ports, and then removes the NodeAgentRedirectAnnotation of the pod. A
+// NodeAgentConfigAnnotation that isn't backed by an entry in the NodeAgentConfigMap is ignored.
+func (pas *podAgents) release(ctx context.Context, pod *core.Pod) error {
+	pas.stop(pod.UID)
+	if _, ok := pod.Annotations[agentconfig.NodeAgentRedirectAnnotation]; !ok {
+		return nil
+	}
+	pid, _, err := findPodProcess(pod.UID)
+	if err != nil {
+		return err
+	}
+	err = inNetNS(pid, func() error {
+		it, err := iptables.New()
+		if err != nil {
+			return fmt.Errorf("unable to create iptables instance: %w", err)
+		}
+		return agentinit.ClearIptables(it)
+	})
+	if err != nil {
+		return err
+	}
+	if err = annotatePod(ctx, pod, agentconfig.NodeAgentRedirectAnnotation, nil); err != nil {
+		return err
+	}
+	dlog.Infof(ctx, "Stopped the traffic-agent of pod %s.%s", pod.Name, pod.Namespace)
+	return nil
+}
+
+// run runs the traffic-agent of the given pod with the given environment, and restarts it when it exits, until the
+// given context is cancelled.
+func (pa *podAgent) run(ctx context.Context, pod *core.Pod, env []string) {
+	defer close(pa.done)
+	for {
+		err := runAgent(ctx, pod.UID, env)
+		if ctx.Err() != nil {
+			return
+		}
+		dlog.Errorf(ctx, "the traffic-agent of pod %s.%s exited: %v", pod.Name, pod.Namespace, err)
+		dtime.SleepWithContext(ctx, restartDelay)
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// runAgent runs the traffic binary of the traffic-node-agent as a traffic-agent in the network namespace of the
+// pod with the given UID, and waits for it to exit.
+func runAgent(ctx context.Context, uid types.UID, env []string) error {
+	pid, _, err := findPodProcess(uid)
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := dexec.CommandContext(ctx, exe, "agent")
+	cmd.Env = env
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.DisableLogging = true
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: agentUID, Gid: agentUID}}
+	if err = inNetNS(pid, cmd.Start); err != nil {
+		return err
+	}
+	return cmd.Wait()
+}
+
+// agentEnv returns the environment of the traffic-agent of the given pod. It consists of the environment of the
+// traffic-agent container that the config describes and the config itself. The environment of the
+// traffic-node-agent isn't inherited. The values that the environment of the app containers obtains from secrets,
+// config maps, and fields other than the IP, name, and namespace of the pod aren't available, because the
+// traffic-node-agent doesn't resolve them.
+func agentEnv(pod *core.Pod, ac *agentconfig.Sidecar, config string) ([]string, error) {
+	cn := agentconfig.AgentContainer(pod, ac)
+	if cn == nil {
+		return nil, fmt.Errorf("the traffic-agent config of pod %s.%s has no intercepts", pod.Name, pod.Namespace)
+	}
+	env := make([]string, 0, len(cn.Env)+1)
+	for _, e := range cn.Env {
+		v := e.Value
+		if vf := e.ValueFrom; vf != nil {
+			if vf.FieldRef == nil {
+				continue
+			}
+			switch vf.FieldRef.FieldPath {
+			case "status.podIP":
+				v = pod.Status.PodIP
+			case "metadata.name":
+				v = pod.Name
+			case "metadata.namespace":
+				v = pod.Namespace
+			default:
+				continue
+			}
+		}
+		env = append(env, e.Name+"="+v)
+	}
+	return append(env, agentconfig.EnvAgentConfig+"="+config), nil
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/agent"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/agentinit"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/nodeagent"
 	"github.com/telepresenceio/telepresence/v2/pkg/log"
 )
 
@@ -33,6 +34,8 @@ func main() {
 			doMain(manager.Main, level, os.Args[2:]...)
 		case "agent-init":
 			doMain(agentinit.Main, level, os.Args[2:]...)
+		case "node-agent":
+			doMain(nodeagent.Main, level, os.Args[2:]...)
 		default:
 			fmt.Println("traffic: unknown command:", name)
 			os.Exit(127)
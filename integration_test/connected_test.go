@@ -25,6 +25,7 @@ func (s *connectedSuite) Test_ReportsVersionFromDaemon() {
 	s.Contains(stdout, fmt.Sprintf("Client: %s", s.TelepresenceVersion()))
 	s.Contains(stdout, fmt.Sprintf("Root Daemon: %s", s.TelepresenceVersion()))
 	s.Contains(stdout, fmt.Sprintf("User Daemon: %s", s.TelepresenceVersion()))
+	s.Contains(stdout, fmt.Sprintf("Traffic Manager: %s", s.TelepresenceVersion()))
 }
 
 func (s *connectedSuite) Test_Status() {
@@ -0,0 +1,37 @@
+package agentconfig
+
+import (
+	"strconv"
+
+	core "k8s.io/api/core/v1"
+)
+
+const (
+	// ShareProcessNamespaceAnnotation opts the pods of a Job or a CronJob in to a shared process namespace. The
+	// traffic-agent needs to see the processes of the app containers in order to terminate when they have exited, so
+	// that the pod can complete. A pod that already shares its process namespace doesn't need the annotation.
+	ShareProcessNamespaceAnnotation = DomainPrefix + "inject-share-process-namespace"
+
+	// RestartJobPodsAnnotation opts a Job or a CronJob in to having its running pods evicted when the traffic-agent
+	// is injected or removed. Without it, only the pods that the Job creates next are affected.
+	RestartJobPodsAnnotation = DomainPrefix + "restart-job-pods"
+)
+
+// SharesProcessNamespace returns true if a pod with the given annotations and spec shares its process namespace,
+// either because its spec says so, or because the ShareProcessNamespaceAnnotation opts in to it.
+func SharesProcessNamespace(annotations map[string]string, spec *core.PodSpec) bool {
+	if sp := spec.ShareProcessNamespace; sp != nil && *sp {
+		return true
+	}
+	return annotationIsTrue(annotations, ShareProcessNamespaceAnnotation)
+}
+
+// RestartsJobPods returns true if the given pod template annotations opt in to the eviction of running Job pods.
+func RestartsJobPods(annotations map[string]string) bool {
+	return annotationIsTrue(annotations, RestartJobPodsAnnotation)
+}
+
+func annotationIsTrue(annotations map[string]string, annotation string) bool {
+	b, err := strconv.ParseBool(annotations[annotation])
+	return err == nil && b
+}
@@ -0,0 +1,26 @@
+package agentconfig_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	core "k8s.io/api/core/v1"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+)
+
+func TestSharesProcessNamespace(t *testing.T) {
+	yes := true
+	no := false
+	assert.False(t, agentconfig.SharesProcessNamespace(nil, &core.PodSpec{}))
+	assert.False(t, agentconfig.SharesProcessNamespace(nil, &core.PodSpec{ShareProcessNamespace: &no}))
+	assert.True(t, agentconfig.SharesProcessNamespace(nil, &core.PodSpec{ShareProcessNamespace: &yes}))
+	assert.True(t, agentconfig.SharesProcessNamespace(map[string]string{agentconfig.ShareProcessNamespaceAnnotation: "true"}, &core.PodSpec{}))
+	assert.False(t, agentconfig.SharesProcessNamespace(map[string]string{agentconfig.ShareProcessNamespaceAnnotation: "yes"}, &core.PodSpec{}))
+}
+
+func TestRestartsJobPods(t *testing.T) {
+	assert.False(t, agentconfig.RestartsJobPods(nil))
+	assert.False(t, agentconfig.RestartsJobPods(map[string]string{agentconfig.RestartJobPodsAnnotation: "false"}))
+	assert.True(t, agentconfig.RestartsJobPods(map[string]string{agentconfig.RestartJobPodsAnnotation: "true"}))
+}
@@ -14,8 +14,12 @@ func AgentContainer(
 	ports := make([]core.ContainerPort, 0, 5)
 	for _, cc := range config.Containers {
 		for _, ic := range PortUniqueIntercepts(cc) {
+			name := ic.ContainerPortName
+			if ic.ServiceRedirect {
+				name = RedirectedPortName(ic.ContainerPort)
+			}
 			ports = append(ports, core.ContainerPort{
-				Name:          ic.ContainerPortName,
+				Name:          name,
 				ContainerPort: int32(ic.AgentPort),
 				Protocol:      core.Protocol(ic.Protocol),
 			})
@@ -70,18 +74,36 @@ func AgentContainer(
 			MountPath: ExportsMountPoint,
 		},
 	)
+	mounts = appendTLSVolumeMounts(config, mounts)
+	if config.SecurityProfile == RestrictedSecurityProfile {
+		// The root filesystem is read-only, so the agent needs a writable /tmp
+		mounts = append(mounts, core.VolumeMount{
+			Name:      TmpVolumeName,
+			MountPath: TmpMountPoint,
+		})
+	}
 
 	if len(efs) == 0 {
 		efs = nil
 	}
+	sc := config.SecurityProfile.SecurityContext()
+	if !config.SecurityContext.IsEmpty() {
+		sc = (*core.SecurityContext)(config.SecurityContext)
+	}
+	var rr core.ResourceRequirements
+	if !config.Resources.IsEmpty() {
+		rr = core.ResourceRequirements(*config.Resources)
+	}
 	return &core.Container{
-		Name:         ContainerName,
-		Image:        config.AgentImage,
-		Args:         []string{"agent"},
-		Ports:        ports,
-		Env:          evs,
-		EnvFrom:      efs,
-		VolumeMounts: mounts,
+		Name:            ContainerName,
+		Image:           config.AgentImage,
+		Args:            []string{"agent"},
+		Ports:           ports,
+		Env:             evs,
+		EnvFrom:         efs,
+		VolumeMounts:    mounts,
+		Resources:       rr,
+		SecurityContext: sc,
 		ReadinessProbe: &core.Probe{
 			ProbeHandler: core.ProbeHandler{
 				Exec: &core.ExecAction{
@@ -92,7 +114,21 @@ func AgentContainer(
 	}
 }
 
-func InitContainer(qualifiedAgentImage string) *core.Container {
+// InitContainer returns the init container that configures the iptables rules that redirect the intercepted ports to
+// the traffic-agent. It runs as root when runAsRoot is true, even when the security context of the pod says
+// otherwise. Otherwise, it runs as the user of the pod, which must be root for iptables to be configured.
+func InitContainer(qualifiedAgentImage string, runAsRoot bool) *core.Container {
+	sc := &core.SecurityContext{
+		Capabilities: &core.Capabilities{
+			Add: []core.Capability{"NET_ADMIN"},
+		},
+	}
+	if runAsRoot {
+		rootUID := int64(0)
+		runAsNonRoot := false
+		sc.RunAsUser = &rootUID
+		sc.RunAsNonRoot = &runAsNonRoot
+	}
 	return &core.Container{
 		Name:  InitContainerName,
 		Image: qualifiedAgentImage,
@@ -101,11 +137,7 @@ func InitContainer(qualifiedAgentImage string) *core.Container {
 			Name:      ConfigVolumeName,
 			MountPath: ConfigMountPoint,
 		}},
-		SecurityContext: &core.SecurityContext{
-			Capabilities: &core.Capabilities{
-				Add: []core.Capability{"NET_ADMIN"},
-			},
-		},
+		SecurityContext: sc,
 	}
 }
 
@@ -194,18 +226,96 @@ func appendAppContainerVolumeMounts(app *core.Container, cc *Container, mounts [
 	return mounts
 }
 
+// appendAppContainerEnv appends the environment of the app container, with each name prefixed, so that the
+// traffic-agent can hand it to intercepting clients. The Downward API references are retained and thereby resolved
+// by the kubelet, but a resourceFieldRef must be given the app container's name explicitly, or it will resolve the
+// resources of the traffic-agent. References to earlier variables in the values are prefixed too, so that the
+// kubelet can expand them. Variables obtained from secrets get a prefix of their own, so that the traffic-agent can
+// tell them apart.
 func appendAppContainerEnv(app *core.Container, cc *Container, es []core.EnvVar) []core.EnvVar {
+	prefix := EnvPrefixApp + cc.EnvPrefix
+	defined := make(map[string]string, len(app.Env))
 	for _, e := range app.Env {
-		e.Name = EnvPrefixApp + cc.EnvPrefix + e.Name
+		if e.Value != "" {
+			e.Value = prefixVarRefs(e.Value, defined)
+		}
+		name := prefix + e.Name
+		if vf := e.ValueFrom; vf != nil {
+			switch {
+			case vf.ResourceFieldRef != nil && vf.ResourceFieldRef.ContainerName == "":
+				rf := *vf.ResourceFieldRef
+				rf.ContainerName = app.Name
+				e.ValueFrom = &core.EnvVarSource{ResourceFieldRef: &rf}
+			case vf.SecretKeyRef != nil:
+				if sp := cc.SecretEnvPrefix(&SecretEnv{Secret: vf.SecretKeyRef.Name, Key: vf.SecretKeyRef.Key, Name: e.Name}); sp != "" {
+					name = sp + e.Name
+				}
+			}
+		}
+		defined[e.Name] = name
+		e.Name = name
 		es = append(es, e)
 	}
 	return es
 }
 
+// prefixVarRefs replaces all $(NAME) references in the given value where NAME is one of the defined names with
+// references to the names that they're defined as. Other references, and escaped references written as $$(NAME),
+// are left as is.
+func prefixVarRefs(value string, defined map[string]string) string {
+	if !strings.Contains(value, "$(") {
+		return value
+	}
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c != '$' || i+1 == len(value) {
+			sb.WriteByte(c)
+			continue
+		}
+		switch value[i+1] {
+		case '$':
+			sb.WriteString("$$")
+			i++
+			continue
+		case '(':
+			if end := strings.IndexByte(value[i+2:], ')'); end >= 0 {
+				if name, ok := defined[value[i+2:i+2+end]]; ok {
+					sb.WriteString("$(" + name + ")")
+					i += 2 + end
+					continue
+				}
+			}
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
 func appendAppContainerEnvFrom(app *core.Container, cc *Container, es []core.EnvFromSource) []core.EnvFromSource {
 	for _, e := range app.EnvFrom {
-		e.Prefix = EnvPrefixApp + cc.EnvPrefix + e.Prefix
+		prefix := EnvPrefixApp + cc.EnvPrefix
+		if e.SecretRef != nil {
+			if sp := cc.SecretEnvPrefix(&SecretEnv{Secret: e.SecretRef.Name, Name: e.Prefix}); sp != "" {
+				prefix = sp
+			}
+		}
+		e.Prefix = prefix + e.Prefix
 		es = append(es, e)
 	}
 	return es
 }
+
+// SidecarVolumes returns the volumes that the given config needs in addition to the AgentVolumes.
+func SidecarVolumes(config *Sidecar) []core.Volume {
+	vols := appendTLSVolumes(config, nil)
+	if config.SecurityProfile == RestrictedSecurityProfile {
+		vols = append(vols, core.Volume{
+			Name: TmpVolumeName,
+			VolumeSource: core.VolumeSource{
+				EmptyDir: &core.EmptyDirVolumeSource{},
+			},
+		})
+	}
+	return vols
+}
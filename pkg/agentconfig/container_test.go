@@ -0,0 +1,116 @@
+package agentconfig_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+)
+
+func TestAgentContainer_appEnv(t *testing.T) {
+	pod := &core.Pod{
+		ObjectMeta: meta.ObjectMeta{Name: "echo-easy-xyz", Namespace: "default"},
+		Spec: core.PodSpec{
+			Containers: []core.Container{{
+				Name: "echo",
+				Env: []core.EnvVar{
+					{
+						Name:      "POD_NAME",
+						ValueFrom: &core.EnvVarSource{FieldRef: &core.ObjectFieldSelector{FieldPath: "metadata.name"}},
+					},
+					{
+						Name:      "CPU_LIMIT",
+						ValueFrom: &core.EnvVarSource{ResourceFieldRef: &core.ResourceFieldSelector{Resource: "limits.cpu"}},
+					},
+					{Name: "HOST", Value: "$(POD_NAME).echo:$(PORT)"},
+					{Name: "PORT", Value: "8080"},
+					{Name: "ESCAPED", Value: "$$(POD_NAME)"},
+					{
+						Name: "PASSWORD",
+						ValueFrom: &core.EnvVarSource{SecretKeyRef: &core.SecretKeySelector{
+							LocalObjectReference: core.LocalObjectReference{Name: "db"},
+							Key:                  "password",
+						}},
+					},
+					{Name: "DSN", Value: "postgres://bob:$(PASSWORD)@db"},
+				},
+				EnvFrom: []core.EnvFromSource{
+					{SecretRef: &core.SecretEnvSource{LocalObjectReference: core.LocalObjectReference{Name: "api"}}, Prefix: "API_"},
+					{ConfigMapRef: &core.ConfigMapEnvSource{LocalObjectReference: core.LocalObjectReference{Name: "cfg"}}},
+				},
+			}},
+		},
+	}
+	ac := &agentconfig.Sidecar{
+		AgentName: "echo-easy",
+		Containers: []*agentconfig.Container{{
+			Name:       "echo",
+			EnvPrefix:  "A_",
+			MountPoint: "/tel_app_mounts/echo",
+			Intercepts: []*agentconfig.Intercept{{ContainerPort: 8080, AgentPort: 9900, Protocol: "TCP"}},
+		}},
+	}
+	ac.Containers[0].SecretEnv = agentconfig.AppSecretEnv(&pod.Spec.Containers[0])
+	assert.Equal(t, []*agentconfig.SecretEnv{
+		{Secret: "api", Name: "API_"},
+		{Secret: "db", Key: "password", Name: "PASSWORD"},
+	}, ac.Containers[0].SecretEnv)
+
+	ct := agentconfig.AgentContainer(pod, ac)
+	require.NotNil(t, ct)
+
+	env := make(map[string]core.EnvVar, len(ct.Env))
+	for _, e := range ct.Env {
+		env[e.Name] = e
+	}
+	assert.Equal(t, "metadata.name", env["_TEL_APP_A_POD_NAME"].ValueFrom.FieldRef.FieldPath)
+	assert.Equal(t, "echo", env["_TEL_APP_A_CPU_LIMIT"].ValueFrom.ResourceFieldRef.ContainerName)
+	assert.Empty(t, pod.Spec.Containers[0].Env[1].ValueFrom.ResourceFieldRef.ContainerName, "app container must not be modified")
+
+	// PORT is declared after HOST, so the kubelet doesn't expand it and neither must the reference be prefixed
+	assert.Equal(t, "$(_TEL_APP_A_POD_NAME).echo:$(PORT)", env["_TEL_APP_A_HOST"].Value)
+	assert.Equal(t, "$$(POD_NAME)", env["_TEL_APP_A_ESCAPED"].Value)
+
+	// Values from secrets are distinguishable by their prefix
+	assert.Equal(t, "db", env["_TEL_SEC_A_1_PASSWORD"].ValueFrom.SecretKeyRef.Name)
+	assert.Equal(t, "postgres://bob:$(_TEL_SEC_A_1_PASSWORD)@db", env["_TEL_APP_A_DSN"].Value)
+	require.Len(t, ct.EnvFrom, 2)
+	assert.Equal(t, "_TEL_SEC_A_0_API_", ct.EnvFrom[0].Prefix)
+	assert.Equal(t, "_TEL_APP_A_", ct.EnvFrom[1].Prefix)
+}
+
+func TestEphemeralAgentContainer(t *testing.T) {
+	pod := &core.Pod{
+		ObjectMeta: meta.ObjectMeta{Name: "echo-easy-xyz", Namespace: "default"},
+		Spec:       core.PodSpec{Containers: []core.Container{{Name: "echo"}}},
+	}
+	ac := &agentconfig.Sidecar{
+		AgentName:       "echo-easy",
+		AgentImage:      "ghcr.io/telepresenceio/tel2:2.7.0",
+		SecurityProfile: agentconfig.RestrictedSecurityProfile,
+		Containers: []*agentconfig.Container{{
+			Name:       "echo",
+			Intercepts: []*agentconfig.Intercept{{ContainerPort: 8080, AgentPort: 9900, Protocol: "TCP"}},
+		}},
+	}
+	ec, err := agentconfig.EphemeralAgentContainer(pod, ac)
+	require.NoError(t, err)
+	require.NotNil(t, ec)
+	assert.Equal(t, agentconfig.ContainerName, ec.Name)
+	assert.Empty(t, ec.Ports)
+	assert.Empty(t, ec.VolumeMounts, "an ephemeral container can't mount new volumes")
+	assert.Nil(t, ec.SecurityContext.ReadOnlyRootFilesystem, "the agent needs a writable /tmp")
+	assert.True(t, *ec.SecurityContext.RunAsNonRoot)
+	last := ec.Env[len(ec.Env)-1]
+	assert.Equal(t, agentconfig.EnvAgentConfig, last.Name)
+	assert.Contains(t, last.Value, "agentName: echo-easy")
+
+	ac.Containers[0].Intercepts = nil
+	ec, err = agentconfig.EphemeralAgentContainer(pod, ac)
+	require.NoError(t, err)
+	assert.Nil(t, ec)
+}
@@ -0,0 +1,119 @@
+package agentconfig
+
+import (
+	"bytes"
+
+	"gopkg.in/yaml.v3"
+	core "k8s.io/api/core/v1"
+)
+
+const (
+	// EnvAgentConfig is the environment variable that holds the YAML config of a traffic-agent that runs as an
+	// ephemeral container, or that the traffic-node-agent runs. Such an agent can't mount the config volume,
+	// because the volumes of a running pod can't be changed.
+	EnvAgentConfig = EnvPrefixAgent + "CONFIG"
+
+	// EphemeralAgentConfigMap is the ConfigMap in the namespace of the traffic-manager with the EphemeralAgents
+	// entries of the workloads that are intercepted using ephemeral traffic-agents. Only the traffic-manager can
+	// write it, so the traffic-node-agents trust its configs. Anyone who can add ephemeral containers to a pod can
+	// add one that looks like a traffic-agent, so the traffic-node-agents never redirect the ports of a pod that has
+	// no entry.
+	EphemeralAgentConfigMap = "telepresence-ephemeral-agents"
+
+	// EphemeralRedirectAnnotation is the pod annotation that the traffic-node-agent sets once it has redirected the
+	// intercepted ports of the pod to the ephemeral traffic-agent. Its value is the ID of the agent's container. It
+	// tells the clients that the intercept is ready.
+	EphemeralRedirectAnnotation = DomainPrefix + "ephemeral-agent-redirect"
+)
+
+// EphemeralAgents is an entry of the EphemeralAgentConfigMap. The traffic-node-agents redirect the intercepted ports
+// of the Pods to their ephemeral traffic-agents using the Config. An ephemeral container can't be removed from a pod,
+// so the entry is removed when the last intercept of the workload ends, and the redirects are then removed.
+type EphemeralAgents struct {
+	// Pods are the UIDs of the pods that the traffic-manager has added an ephemeral traffic-agent to.
+	Pods []string `json:"pods" yaml:"pods"`
+
+	// Config is the YAML config of the traffic-agents, as found in their EnvAgentConfig.
+	Config string `json:"config" yaml:"config"`
+}
+
+// EphemeralAgentContainer returns the traffic-agent of the given config as an ephemeral container that can be added
+// to the given running pod. An ephemeral container can't declare ports, probes, or resources, and it can't have
+// mounts of volumes that the pod doesn't have already, so the config is passed in the EnvAgentConfig variable. It
+// returns nil when the config has no intercepts.
+func EphemeralAgentContainer(pod *core.Pod, config *Sidecar) (*core.EphemeralContainer, error) {
+	ac := AgentContainer(pod, config)
+	if ac == nil {
+		return nil, nil
+	}
+	cfg, err := EncodeEphemeralConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	sc := ac.SecurityContext
+	if sc != nil && sc.ReadOnlyRootFilesystem != nil && *sc.ReadOnlyRootFilesystem {
+		// There's no volume for a writable /tmp
+		sc = sc.DeepCopy()
+		sc.ReadOnlyRootFilesystem = nil
+	}
+	return &core.EphemeralContainer{
+		EphemeralContainerCommon: core.EphemeralContainerCommon{
+			Name:            ac.Name,
+			Image:           ac.Image,
+			Args:            ac.Args,
+			Env:             append(ac.Env, core.EnvVar{Name: EnvAgentConfig, Value: cfg}),
+			EnvFrom:         ac.EnvFrom,
+			SecurityContext: sc,
+		},
+	}, nil
+}
+
+// EncodeEphemeralConfig returns the YAML config of an ephemeral traffic-agent, as found in its EnvAgentConfig and in
+// the EphemeralAgents entry of its workload.
+func EncodeEphemeralConfig(config *Sidecar) (string, error) {
+	bf := bytes.Buffer{}
+	if err := yaml.NewEncoder(&bf).Encode(config); err != nil {
+		return "", err
+	}
+	return bf.String(), nil
+}
+
+// EphemeralAgentConfig returns the EnvAgentConfig of the ephemeral traffic-agent of the given pod, and whether the
+// pod has such an agent. Anyone who can add ephemeral containers to the pod can choose the config, so it's only
+// compared to the config of the EphemeralAgents entry of the workload.
+func EphemeralAgentConfig(pod *core.Pod) (string, bool) {
+	for i := range pod.Spec.EphemeralContainers {
+		ec := &pod.Spec.EphemeralContainers[i]
+		if ec.Name != ContainerName {
+			continue
+		}
+		for _, e := range ec.Env {
+			if e.Name == EnvAgentConfig {
+				return e.Value, true
+			}
+		}
+		return "", true
+	}
+	return "", false
+}
+
+// EphemeralAgentStatus returns the status of the ephemeral traffic-agent of the given pod, or nil if the pod has no
+// such agent.
+func EphemeralAgentStatus(pod *core.Pod) *core.ContainerStatus {
+	for i := range pod.Status.EphemeralContainerStatuses {
+		if cs := &pod.Status.EphemeralContainerStatuses[i]; cs.Name == ContainerName {
+			return cs
+		}
+	}
+	return nil
+}
+
+// HasEphemeralAgent returns true if a traffic-agent has been added to the given pod as an ephemeral container.
+func HasEphemeralAgent(pod *core.Pod) bool {
+	for i := range pod.Spec.EphemeralContainers {
+		if pod.Spec.EphemeralContainers[i].Name == ContainerName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package agentconfig
+
+import (
+	"fmt"
+)
+
+// InjectionMode determines how the traffic-agents are added to the pods of the intercepted workloads.
+type InjectionMode string
+
+const (
+	// SidecarInjectionMode injects the traffic-agent into the pods of a workload as a sidecar container, which
+	// means that the workload is rolled out.
+	//
+	// This is the default setting.
+	SidecarInjectionMode = InjectionMode("sidecar")
+
+	// NodeInjectionMode leaves the pod specs untouched. The traffic-node-agent on the node of each intercepted pod
+	// runs the traffic-agent of the pod in the network namespace of the pod instead.
+	NodeInjectionMode = InjectionMode("node")
+)
+
+func NewInjectionMode(s string) (InjectionMode, error) {
+	switch im := InjectionMode(s); im {
+	case "":
+		return SidecarInjectionMode, nil
+	case SidecarInjectionMode, NodeInjectionMode:
+		return im, nil
+	default:
+		return "", fmt.Errorf("invalid InjectionMode: %q", s)
+	}
+}
+
+func (im *InjectionMode) EnvDecode(val string) (err error) {
+	*im, err = NewInjectionMode(val)
+	return err
+}
@@ -0,0 +1,89 @@
+package agentconfig
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+	core "k8s.io/api/core/v1"
+)
+
+// ResourceRequirements are the resource requirements of the traffic-agent container. The Kubernetes type only
+// declares json tags, so it's decoded from its JSON representation, both in the environment and in YAML.
+type ResourceRequirements core.ResourceRequirements
+
+// SecurityContext is the security context of the traffic-agent container. The Kubernetes type only declares json
+// tags, so it's decoded from its JSON representation, both in the environment and in YAML.
+type SecurityContext core.SecurityContext
+
+// IsEmpty returns true if no requests or limits are declared.
+func (r *ResourceRequirements) IsEmpty() bool {
+	return r == nil || len(r.Requests) == 0 && len(r.Limits) == 0
+}
+
+func (r *ResourceRequirements) EnvDecode(val string) error {
+	*r = ResourceRequirements{}
+	if val == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(val), (*core.ResourceRequirements)(r)); err != nil {
+		return fmt.Errorf("invalid ResourceRequirements: %w", err)
+	}
+	return nil
+}
+
+func (r *ResourceRequirements) MarshalYAML() (any, error) {
+	return marshalYAMLAsJSON((*core.ResourceRequirements)(r))
+}
+
+func (r *ResourceRequirements) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalYAMLAsJSON(node, (*core.ResourceRequirements)(r))
+}
+
+// IsEmpty returns true if no security settings are declared.
+func (s *SecurityContext) IsEmpty() bool {
+	return s == nil || *s == SecurityContext{}
+}
+
+func (s *SecurityContext) EnvDecode(val string) error {
+	*s = SecurityContext{}
+	if val == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(val), (*core.SecurityContext)(s)); err != nil {
+		return fmt.Errorf("invalid SecurityContext: %w", err)
+	}
+	return nil
+}
+
+func (s *SecurityContext) MarshalYAML() (any, error) {
+	return marshalYAMLAsJSON((*core.SecurityContext)(s))
+}
+
+func (s *SecurityContext) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalYAMLAsJSON(node, (*core.SecurityContext)(s))
+}
+
+func marshalYAMLAsJSON(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]any
+	if err = json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func unmarshalYAMLAsJSON(node *yaml.Node, v any) error {
+	var m map[string]any
+	if err := node.Decode(&m); err != nil {
+		return err
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
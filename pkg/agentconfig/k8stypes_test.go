@@ -0,0 +1,47 @@
+package agentconfig_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+)
+
+func TestSidecar_k8sTypesYAML(t *testing.T) {
+	uid := int64(1000)
+	ac := agentconfig.Sidecar{
+		AgentName: "echo",
+		Resources: &agentconfig.ResourceRequirements{
+			Requests: core.ResourceList{core.ResourceCPU: resource.MustParse("50m")},
+			Limits:   core.ResourceList{core.ResourceMemory: resource.MustParse("64Mi")},
+		},
+		SecurityContext: &agentconfig.SecurityContext{RunAsUser: &uid},
+		PullSecrets:     []string{"mirror"},
+	}
+	data, err := yaml.Marshal(&ac)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "cpu: 50m")
+	assert.Contains(t, string(data), "runAsUser: 1000")
+
+	var rac agentconfig.Sidecar
+	require.NoError(t, yaml.Unmarshal(data, &rac))
+	assert.True(t, ac.Resources.Requests.Cpu().Equal(*rac.Resources.Requests.Cpu()))
+	assert.True(t, ac.Resources.Limits.Memory().Equal(*rac.Resources.Limits.Memory()))
+	assert.Equal(t, ac.SecurityContext, rac.SecurityContext)
+	assert.Equal(t, ac.PullSecrets, rac.PullSecrets)
+}
+
+func TestResourceRequirements_EnvDecode(t *testing.T) {
+	var rr agentconfig.ResourceRequirements
+	require.NoError(t, rr.EnvDecode(""))
+	assert.True(t, rr.IsEmpty())
+	require.NoError(t, rr.EnvDecode(`{"requests":{"cpu":"50m","memory":"32Mi"}}`))
+	assert.False(t, rr.IsEmpty())
+	assert.Equal(t, "50m", rr.Requests.Cpu().String())
+	assert.Error(t, rr.EnvDecode(`{"requests":`))
+}
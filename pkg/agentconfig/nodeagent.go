@@ -0,0 +1,61 @@
+package agentconfig
+
+import (
+	"hash/fnv"
+	"strconv"
+
+	core "k8s.io/api/core/v1"
+)
+
+const (
+	// NodeAgentConfigMap is the ConfigMap in the namespace of the traffic-manager with the NodeAgent entries of the
+	// workloads that the traffic-node-agents run traffic-agents for when the traffic-manager uses the
+	// NodeInjectionMode. Only the traffic-manager can write it, so the traffic-node-agents trust its configs.
+	NodeAgentConfigMap = "telepresence-node-agents"
+
+	// NodeAgentConfigAnnotation is the pod annotation with the NodeAgentConfigHash of the config of the
+	// traffic-agent that the traffic-node-agent is asked to run for the pod. The config itself is found in the
+	// NodeAgentConfigMap, so the annotation only tells what config the traffic-agent is expected to run with.
+	NodeAgentConfigAnnotation = DomainPrefix + "node-agent-config"
+
+	// NodeAgentRedirectAnnotation is the pod annotation that the traffic-node-agent sets once it runs the
+	// traffic-agent of the pod and has redirected the intercepted ports to it. Its value is the NodeAgentConfigHash
+	// of the config that the agent runs with.
+	NodeAgentRedirectAnnotation = DomainPrefix + "node-agent-redirect"
+)
+
+// NodeAgent is an entry of the NodeAgentConfigMap. The traffic-node-agents run a traffic-agent with the Config for
+// each pod in the entry's namespace that the Selector selects, including the pods that are created after the
+// entry.
+type NodeAgent struct {
+	// Selector is the label selector of the pods of the workload.
+	Selector string `json:"selector" yaml:"selector"`
+
+	// Config is the YAML config of the traffic-agent.
+	Config string `json:"config" yaml:"config"`
+}
+
+// NodeAgentKey returns the key of the NodeAgentConfigMap entry of the given workload.
+func NodeAgentKey(name, namespace string) string {
+	return name + "." + namespace
+}
+
+// NodeAgentConfigHash returns a short hash of the given traffic-agent config of a NodeAgent.
+func NodeAgentConfigHash(config string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(config))
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// HasNodeAgent returns true if the traffic-node-agent has been asked to run a traffic-agent for the given pod.
+func HasNodeAgent(pod *core.Pod) bool {
+	_, ok := pod.Annotations[NodeAgentConfigAnnotation]
+	return ok
+}
+
+// NodeAgentRedirected returns true if the traffic-agent that the traffic-node-agent runs for the given pod runs
+// with the config that the pod is annotated with.
+func NodeAgentRedirected(pod *core.Pod) bool {
+	hash, ok := pod.Annotations[NodeAgentConfigAnnotation]
+	return ok && pod.Annotations[NodeAgentRedirectAnnotation] == hash
+}
@@ -0,0 +1,37 @@
+package agentconfig
+
+import (
+	"fmt"
+)
+
+// ResourcePolicy determines how the resources of the injected traffic-agent relate to the resources of the pod.
+type ResourcePolicy string
+
+const (
+	// AddResourcePolicy adds the resources of the traffic-agent to the resources of the pod.
+	//
+	// This is the default setting.
+	AddResourcePolicy = ResourcePolicy("add")
+
+	// NeutralResourcePolicy takes the resources of the traffic-agent from the app containers, so that the total
+	// resources of the pod don't change when the traffic-agent is injected. The utilization that a
+	// HorizontalPodAutoscaler computes from the requests of the pod, and the resource quota of the namespace,
+	// are then unaffected by the injection.
+	NeutralResourcePolicy = ResourcePolicy("neutral")
+)
+
+func NewResourcePolicy(s string) (ResourcePolicy, error) {
+	switch rp := ResourcePolicy(s); rp {
+	case "":
+		return AddResourcePolicy, nil
+	case AddResourcePolicy, NeutralResourcePolicy:
+		return rp, nil
+	default:
+		return "", fmt.Errorf("invalid ResourcePolicy: %q", s)
+	}
+}
+
+func (rp *ResourcePolicy) EnvDecode(val string) (err error) {
+	*rp, err = NewResourcePolicy(val)
+	return err
+}
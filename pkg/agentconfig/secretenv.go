@@ -0,0 +1,47 @@
+package agentconfig
+
+import (
+	"strconv"
+
+	core "k8s.io/api/core/v1"
+)
+
+// SecretEnv describes an environment variable, or, when Key is empty, an envFrom source, that an app container
+// obtains from a secret.
+type SecretEnv struct {
+	// Name of the secret
+	Secret string `json:"secret,omitempty" yaml:"secret,omitempty"`
+
+	// The key in the secret, or empty when all keys of the secret are imported
+	Key string `json:"key,omitempty" yaml:"key,omitempty"`
+
+	// Name of the environment variable, or the prefix of the imported keys
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+}
+
+// AppSecretEnv returns the environment that the given app container obtains from secrets, envFrom sources first.
+func AppSecretEnv(app *core.Container) []*SecretEnv {
+	var ses []*SecretEnv
+	for _, e := range app.EnvFrom {
+		if e.SecretRef != nil {
+			ses = append(ses, &SecretEnv{Secret: e.SecretRef.Name, Name: e.Prefix})
+		}
+	}
+	for _, e := range app.Env {
+		if e.ValueFrom != nil && e.ValueFrom.SecretKeyRef != nil {
+			ses = append(ses, &SecretEnv{Secret: e.ValueFrom.SecretKeyRef.Name, Key: e.ValueFrom.SecretKeyRef.Key, Name: e.Name})
+		}
+	}
+	return ses
+}
+
+// SecretEnvPrefix returns the prefix of the names of the traffic-agent's environment variables that get their
+// values from the given SecretEnv of the given container.
+func (cc *Container) SecretEnvPrefix(se *SecretEnv) string {
+	for i, cs := range cc.SecretEnv {
+		if *cs == *se {
+			return EnvPrefixSecret + cc.EnvPrefix + strconv.Itoa(i) + "_"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,67 @@
+package agentconfig
+
+import (
+	"fmt"
+
+	core "k8s.io/api/core/v1"
+)
+
+// SecurityProfile determines the security context of the injected traffic-agent.
+type SecurityProfile string
+
+const (
+	// DefaultSecurityProfile leaves the security context of the traffic-agent unset so that it's
+	// inherited from the pod.
+	//
+	// This is the default setting.
+	DefaultSecurityProfile = SecurityProfile("default")
+
+	// RestrictedSecurityProfile gives the traffic-agent a security context that satisfies the "restricted"
+	// Pod Security Standard, and that is accepted by GKE Autopilot. No init container is injected, so numeric
+	// service target ports are redirected to the traffic-agent, and intercepts of headless services are not available.
+	RestrictedSecurityProfile = SecurityProfile("restricted")
+
+	// SecurityProfileAnnotation selects the SecurityProfile of a workload. It takes precedence over the
+	// profile configured for the traffic-manager.
+	SecurityProfileAnnotation = DomainPrefix + "inject-security-profile"
+
+	TmpVolumeName = "traffic-agent-tmp"
+	TmpMountPoint = "/tmp"
+)
+
+func NewSecurityProfile(s string) (SecurityProfile, error) {
+	switch sp := SecurityProfile(s); sp {
+	case "":
+		return DefaultSecurityProfile, nil
+	case DefaultSecurityProfile, RestrictedSecurityProfile:
+		return sp, nil
+	default:
+		return "", fmt.Errorf("invalid SecurityProfile: %q", s)
+	}
+}
+
+func (sp *SecurityProfile) EnvDecode(val string) (err error) {
+	*sp, err = NewSecurityProfile(val)
+	return err
+}
+
+// SecurityContext returns the security context of the traffic-agent container, or nil if it's inherited from the pod.
+func (sp SecurityProfile) SecurityContext() *core.SecurityContext {
+	if sp != RestrictedSecurityProfile {
+		return nil
+	}
+	noEscalation := false
+	nonRoot := true
+	readOnlyRoot := true
+	return &core.SecurityContext{
+		AllowPrivilegeEscalation: &noEscalation,
+		Capabilities: &core.Capabilities{
+			Drop: []core.Capability{"ALL"},
+		},
+		ReadOnlyRootFilesystem: &readOnlyRoot,
+		RunAsNonRoot:           &nonRoot,
+		SeccompProfile: &core.SeccompProfile{
+			Type: core.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+}
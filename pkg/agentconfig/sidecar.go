@@ -1,6 +1,10 @@
 package agentconfig
 
-import "k8s.io/apimachinery/pkg/types"
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
 
 const (
 	// ConfigMap is the name of the ConfigMap that contains the agent configs
@@ -19,6 +23,7 @@ const (
 	EnvPrefix            = "_TEL_"
 	EnvPrefixAgent       = EnvPrefix + "AGENT_"
 	EnvPrefixApp         = EnvPrefix + "APP_"
+	EnvPrefixSecret      = EnvPrefix + "SEC_"
 
 	// EnvInterceptContainer intercepted container propagated to client during intercept
 	EnvInterceptContainer = "TELEPRESENCE_CONTAINER"
@@ -26,6 +31,10 @@ const (
 	// EnvInterceptMounts mount points propagated to client during intercept
 	EnvInterceptMounts = "TELEPRESENCE_MOUNTS"
 
+	// ListenLocalhost and ListenPodIP are the symbolic values of a Sidecar's ListenAddress
+	ListenLocalhost = "localhost"
+	ListenPodIP     = "pod-ip"
+
 	DomainPrefix     = "telepresence.getambassador.io/"
 	InjectAnnotation = DomainPrefix + "inject-" + ContainerName
 )
@@ -56,6 +65,10 @@ type Intercept struct {
 	// True if the service is headless
 	Headless bool `json:"headless,omitempty" yaml:"headless,omitempty"`
 
+	// ServiceRedirect is true when the numeric target port of the service port has been replaced with the name
+	// of the agent's port, so that no init container is needed to redirect the traffic to the agent
+	ServiceRedirect bool `json:"serviceRedirect,omitempty" yaml:"serviceRedirect,omitempty"`
+
 	// The number of the intercepted container port
 	ContainerPort uint16 `json:"containerPort,omitempty" yaml:"containerPort,omitempty"`
 
@@ -82,6 +95,12 @@ type Container struct {
 
 	// Mounts are the actual mount points that are mounted by this container
 	Mounts []string
+
+	// SecretEnv describes the environment that the container obtains from secrets
+	SecretEnv []*SecretEnv `json:"secretEnv,omitempty" yaml:"secretEnv,omitempty"`
+
+	// Replace is true when the container is removed from the pod and the traffic-agent listens to all of its ports
+	Replace bool `json:"replace,omitempty" yaml:"replace,omitempty"`
 }
 
 // The Sidecar configures the traffic-agent sidecar
@@ -119,6 +138,51 @@ type Sidecar struct {
 	// The port used by the agents restFUL API server
 	APIPort uint16 `json:"apiPort,omitempty" yaml:"apiPort,omitempty"`
 
+	// The time of inactivity after which the agent pings the traffic manager. Zero means no pings
+	ManagerKeepAliveInterval time.Duration `json:"managerKeepAliveInterval,omitempty" yaml:"managerKeepAliveInterval,omitempty"`
+
+	// The time that the agent waits for a ping to be acknowledged by the traffic manager
+	ManagerKeepAliveTimeout time.Duration `json:"managerKeepAliveTimeout,omitempty" yaml:"managerKeepAliveTimeout,omitempty"`
+
+	// The maximum time that data sent to the traffic manager may remain unacknowledged
+	ManagerTCPUserTimeout time.Duration `json:"managerTCPUserTimeout,omitempty" yaml:"managerTCPUserTimeout,omitempty"`
+
+	// The address that the agent's forwarders listen to. Empty means all interfaces, ListenLocalhost means the
+	// loopback interface only, and ListenPodIP means the IP of the pod only.
+	ListenAddress string `json:"listenAddress,omitempty" yaml:"listenAddress,omitempty"`
+
+	// If ReusePort is true, then the agent's forwarders listen using SO_REUSEPORT
+	ReusePort bool `json:"reusePort,omitempty" yaml:"reusePort,omitempty"`
+
+	// The number of tunnel streams to the traffic manager that the agent opens in advance
+	TunnelPoolSize int `json:"tunnelPoolSize,omitempty" yaml:"tunnelPoolSize,omitempty"`
+
+	// If TerminateWithApp is true, then the agent terminates when the processes of the app containers have exited,
+	// so that a pod that runs to completion, like the pod of a Job, can complete. The pod must share its process
+	// namespace.
+	TerminateWithApp bool `json:"terminateWithApp,omitempty" yaml:"terminateWithApp,omitempty"`
+
+	// The SecurityProfile that determines the security context of the traffic-agent
+	SecurityProfile SecurityProfile `json:"securityProfile,omitempty" yaml:"securityProfile,omitempty"`
+
+	// The security context of the traffic-agent. Takes precedence over the SecurityProfile when set
+	SecurityContext *SecurityContext `json:"securityContext,omitempty" yaml:"securityContext,omitempty"`
+
+	// The resource requirements of the traffic-agent
+	Resources *ResourceRequirements `json:"resources,omitempty" yaml:"resources,omitempty"`
+
+	// The ResourcePolicy of the traffic-agent. Empty means AddResourcePolicy
+	ResourcePolicy ResourcePolicy `json:"resourcePolicy,omitempty" yaml:"resourcePolicy,omitempty"`
+
+	// Names of the secrets used when pulling the traffic-agent image
+	PullSecrets []string `json:"pullSecrets,omitempty" yaml:"pullSecrets,omitempty"`
+
+	// The secret, or the path in the app container, that holds the certificate used when terminating TLS
+	TerminatingTLS string `json:"terminatingTLS,omitempty" yaml:"terminatingTLS,omitempty"`
+
+	// The secret, or the path in the app container, that holds the certificates used when originating TLS
+	OriginatingTLS string `json:"originatingTLS,omitempty" yaml:"originatingTLS,omitempty"`
+
 	// The intercepts managed by the agent
 	Containers []*Container `json:"containers,omitempty" yaml:"containers,omitempty"`
 }
@@ -0,0 +1,89 @@
+package agentconfig
+
+import (
+	"path"
+	"strings"
+
+	core "k8s.io/api/core/v1"
+)
+
+const (
+	// TerminatingTLSAnnotation names a secret, or an absolute path to a directory in the app container, that holds
+	// the certificate and key that the traffic-agent uses when terminating TLS for the app's ports.
+	TerminatingTLSAnnotation = "getambassador.io/inject-terminating-tls-secret"
+
+	// OriginatingTLSAnnotation names a secret, or an absolute path to a directory in the app container, that holds
+	// the client certificate and CA that the traffic-agent uses when originating TLS to the app.
+	OriginatingTLSAnnotation = "getambassador.io/inject-originating-tls-secret"
+
+	TerminatingTLSVolumeName = "traffic-terminating-tls"
+	TerminatingTLSMountPoint = "/tel_terminating_tls"
+	OriginatingTLSVolumeName = "traffic-originating-tls"
+	OriginatingTLSMountPoint = "/tel_originating_tls"
+)
+
+// isTLSSecret returns true if the given TLS source is the name of a secret rather than a path in the app container.
+func isTLSSecret(source string) bool {
+	return source != "" && !strings.HasPrefix(source, "/")
+}
+
+// appendTLSVolumes appends the volumes for the TLS secrets that the given config uses.
+func appendTLSVolumes(config *Sidecar, vols []core.Volume) []core.Volume {
+	secretVolume := func(name, secret string) core.Volume {
+		return core.Volume{
+			Name: name,
+			VolumeSource: core.VolumeSource{
+				Secret: &core.SecretVolumeSource{SecretName: secret},
+			},
+		}
+	}
+	if isTLSSecret(config.TerminatingTLS) {
+		vols = append(vols, secretVolume(TerminatingTLSVolumeName, config.TerminatingTLS))
+	}
+	if isTLSSecret(config.OriginatingTLS) {
+		vols = append(vols, secretVolume(OriginatingTLSVolumeName, config.OriginatingTLS))
+	}
+	return vols
+}
+
+func appendTLSVolumeMounts(config *Sidecar, mounts []core.VolumeMount) []core.VolumeMount {
+	if isTLSSecret(config.TerminatingTLS) {
+		mounts = append(mounts, core.VolumeMount{
+			Name:      TerminatingTLSVolumeName,
+			MountPath: TerminatingTLSMountPoint,
+			ReadOnly:  true,
+		})
+	}
+	if isTLSSecret(config.OriginatingTLS) {
+		mounts = append(mounts, core.VolumeMount{
+			Name:      OriginatingTLSVolumeName,
+			MountPath: OriginatingTLSMountPoint,
+			ReadOnly:  true,
+		})
+	}
+	return mounts
+}
+
+// TerminatingTLSDir returns the directory in the traffic-agent that holds the certificate and key used when
+// terminating TLS for the given container, or an empty string when TLS isn't terminated.
+func (s *Sidecar) TerminatingTLSDir(cc *Container) string {
+	return tlsDir(s.TerminatingTLS, TerminatingTLSMountPoint, cc)
+}
+
+// OriginatingTLSDir returns the directory in the traffic-agent that holds the certificates used when originating TLS
+// to the given container, or an empty string when no such certificates are configured.
+func (s *Sidecar) OriginatingTLSDir(cc *Container) string {
+	return tlsDir(s.OriginatingTLS, OriginatingTLSMountPoint, cc)
+}
+
+func tlsDir(source, secretMountPoint string, cc *Container) string {
+	switch {
+	case source == "":
+		return ""
+	case isTLSSecret(source):
+		return secretMountPoint
+	default:
+		// The app container's volumes are mounted below the container's mount point in the traffic-agent
+		return path.Join(cc.MountPoint, source)
+	}
+}
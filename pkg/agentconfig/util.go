@@ -11,7 +11,12 @@ import (
 //   - its ServiceName is equal to the config's ServiceName
 //   - its ServicePortIdentifier is equal to the config's ServicePortName, or can
 //     be parsed to an integer equal to the config's ServicePort
+//
+// The ServicePortIdentifier of an intercept that has no service identifies the container port.
 func SpecMatchesIntercept(spec *manager.InterceptSpec, ic *Intercept) bool {
+	if ic.ServiceName == "" {
+		return spec.ServiceName == "" && IsInterceptForContainerPort(spec.ServicePortIdentifier, ic)
+	}
 	return ic.ServiceName == spec.ServiceName && IsInterceptFor(spec.ServicePortIdentifier, ic)
 }
 
@@ -25,6 +30,74 @@ func IsInterceptFor(spi string, ic *Intercept) bool {
 	return err == nil && uint16(pn) == ic.ServicePort
 }
 
+// IsInterceptForContainerPort returns true when the given ServicePortIdentifier is equal to the
+// config's ContainerPortName, or can be parsed to an integer equal to the config's ContainerPort. This
+// is used when a service port isn't found, because the port that a user knows of often is the named
+// container port that the service port targets.
+func IsInterceptForContainerPort(spi string, ic *Intercept) bool {
+	if ic.ContainerPortName != "" && spi == ic.ContainerPortName {
+		return true
+	}
+	pn, err := strconv.Atoi(spi)
+	return err == nil && uint16(pn) == ic.ContainerPort
+}
+
+// The application protocols that the AppProtocolsAnnotation of a service can declare for its ports.
+const (
+	AppProtoTCP            = "tcp"
+	AppProtoHTTP           = "http"
+	AppProtoHTTP2          = "http2"
+	AppProtoGRPC           = "grpc"
+	AppProtoTLSPassthrough = "tls-passthrough"
+)
+
+// AppProtocolsAnnotation is a service annotation that declares the application protocols of its ports as a comma
+// separated list of <port name or number>=<protocol>, e.g. "http=http2,8443=tls-passthrough".
+const AppProtocolsAnnotation = DomainPrefix + "app-protocols"
+
+// IsAppProto returns true if the given string is one of the application protocols that the
+// AppProtocolsAnnotation can declare.
+func IsAppProto(s string) bool {
+	switch s {
+	case AppProtoTCP, AppProtoHTTP, AppProtoHTTP2, AppProtoGRPC, AppProtoTLSPassthrough:
+		return true
+	}
+	return false
+}
+
+// IsOpaque returns true if the traffic of the given intercept is declared as a byte stream that the agent must
+// pass on as is, so that it can neither terminate TLS for it nor match its HTTP headers.
+func (ic *Intercept) IsOpaque() bool {
+	return ic.AppProtocol == AppProtoTCP || ic.AppProtocol == AppProtoTLSPassthrough
+}
+
+// NextProtos returns the application protocols that the agent offers in the TLS handshake when it terminates TLS
+// for the given intercept, or nil when the protocol isn't declared. HTTP/2 and gRPC clients require that "h2" is
+// negotiated, and an HTTP/1 app must not be reached using "h2", because the agent re-originates TLS to the app
+// using the protocol that was negotiated with the client.
+func (ic *Intercept) NextProtos() []string {
+	switch ic.AppProtocol {
+	case AppProtoHTTP:
+		return []string{"http/1.1"}
+	case AppProtoHTTP2, AppProtoGRPC:
+		return []string{"h2"}
+	}
+	return nil
+}
+
+// NeedsInitContainer returns true if the traffic for the given intercept is redirected to the agent by
+// iptables rules that the init container sets up. That's never the case when the agent listens to the
+// container port itself, which it does when it replaces the container.
+func (ic *Intercept) NeedsInitContainer() bool {
+	return ic.AgentPort != ic.ContainerPort && (ic.Headless || ic.TargetPortNumeric && !ic.ServiceRedirect)
+}
+
+// RedirectedPortName returns the name of the agent's port when the numeric target port of a service is
+// redirected to the agent.
+func RedirectedPortName(containerPort uint16) string {
+	return "tel-rd-" + strconv.Itoa(int(containerPort))
+}
+
 // PortUniqueIntercepts returns a slice of intercepts for the container where each intercept
 // is unique with respect to the AgentPort.
 // This method should always be used when iterating the intercepts, except for when an
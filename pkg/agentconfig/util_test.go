@@ -0,0 +1,29 @@
+package agentconfig_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+)
+
+func TestIntercept_NextProtos(t *testing.T) {
+	tests := []struct {
+		appProto string
+		want     []string
+	}{
+		{"", nil},
+		{agentconfig.AppProtoTCP, nil},
+		{"https", nil},
+		{agentconfig.AppProtoHTTP, []string{"http/1.1"}},
+		{agentconfig.AppProtoHTTP2, []string{"h2"}},
+		{agentconfig.AppProtoGRPC, []string{"h2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.appProto, func(t *testing.T) {
+			ic := &agentconfig.Intercept{AppProtocol: tt.appProto}
+			assert.Equal(t, tt.want, ic.NextProtos())
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package agentmap
+
+import (
+	"fmt"
+	"strings"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+)
+
+const (
+	// AgentResourcesAnnotation declares the resource requirements of the traffic-agent container, in JSON format.
+	AgentResourcesAnnotation = agentconfig.DomainPrefix + "inject-agent-resources"
+
+	// AgentSecurityContextAnnotation declares the security context of the traffic-agent container, in JSON format.
+	AgentSecurityContextAnnotation = agentconfig.DomainPrefix + "inject-agent-security-context"
+
+	// AgentPullSecretsAnnotation is a comma separated list of the secrets used when pulling the traffic-agent image.
+	AgentPullSecretsAnnotation = agentconfig.DomainPrefix + "inject-agent-image-pull-secrets"
+
+	// AgentResourcePolicyAnnotation declares the agentconfig.ResourcePolicy of the traffic-agent container, "add"
+	// or "neutral".
+	AgentResourcePolicyAnnotation = agentconfig.DomainPrefix + "inject-agent-resource-policy"
+)
+
+// applyAgentSettings sets the resource requirements, security context, and image pull secrets of the given
+// config. The annotations of the pod take precedence over the settings of the traffic-manager.
+func applyAgentSettings(pod *core.PodTemplateSpec, cfg *GeneratorConfig, ag *agentconfig.Sidecar) error {
+	ag.Resources = cfg.Resources
+	if a, ok := pod.Annotations[AgentResourcesAnnotation]; ok {
+		var rr agentconfig.ResourceRequirements
+		if err := rr.EnvDecode(a); err != nil {
+			return fmt.Errorf("invalid %s annotation in pod %s.%s: %w", AgentResourcesAnnotation, pod.Name, pod.Namespace, err)
+		}
+		ag.Resources = &rr
+	}
+	policy := cfg.ResourcePolicy
+	if a, ok := pod.Annotations[AgentResourcePolicyAnnotation]; ok {
+		var err error
+		if policy, err = agentconfig.NewResourcePolicy(a); err != nil {
+			return fmt.Errorf("invalid %s annotation in pod %s.%s: %w", AgentResourcePolicyAnnotation, pod.Name, pod.Namespace, err)
+		}
+	}
+	if policy == agentconfig.NeutralResourcePolicy {
+		ag.ResourcePolicy = policy
+		ag.Resources = neutralAgentResources(pod, ag.Resources)
+	}
+	if ag.Resources.IsEmpty() {
+		ag.Resources = nil
+	}
+
+	ag.SecurityContext = cfg.SecurityContext
+	if a, ok := pod.Annotations[AgentSecurityContextAnnotation]; ok {
+		var sc agentconfig.SecurityContext
+		if err := sc.EnvDecode(a); err != nil {
+			return fmt.Errorf("invalid %s annotation in pod %s.%s: %w", AgentSecurityContextAnnotation, pod.Name, pod.Namespace, err)
+		}
+		ag.SecurityContext = &sc
+	}
+	if ag.SecurityContext.IsEmpty() {
+		ag.SecurityContext = nil
+	}
+
+	ag.PullSecrets = cfg.PullSecrets
+	if a, ok := pod.Annotations[AgentPullSecretsAnnotation]; ok {
+		ag.PullSecrets = nil
+		for _, s := range strings.Split(a, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				ag.PullSecrets = append(ag.PullSecrets, s)
+			}
+		}
+	}
+	return nil
+}
+
+// neutralAgentRequests are the requests of a traffic-agent with the NeutralResourcePolicy for the resources that
+// the app containers request but the agent resources don't declare. A HorizontalPodAutoscaler can't compute the
+// utilization of a resource unless all containers of the pod request it.
+var neutralAgentRequests = core.ResourceList{
+	core.ResourceCPU:    resource.MustParse("10m"),
+	core.ResourceMemory: resource.MustParse("32Mi"),
+}
+
+// neutralAgentResources returns the given resources of the traffic-agent, amended with the neutralAgentRequests
+// for the resources that the containers of the pod request.
+func neutralAgentResources(pod *core.PodTemplateSpec, rr *agentconfig.ResourceRequirements) *agentconfig.ResourceRequirements {
+	nr := &agentconfig.ResourceRequirements{}
+	if rr != nil {
+		nr.Limits = rr.Limits.DeepCopy()
+		nr.Requests = rr.Requests.DeepCopy()
+	}
+	for name, q := range neutralAgentRequests {
+		if _, ok := nr.Requests[name]; ok {
+			continue
+		}
+		if l, ok := nr.Limits[name]; ok {
+			// Kubernetes would default the request to the limit
+			q = l
+		} else if !podRequests(pod, name) {
+			continue
+		}
+		if nr.Requests == nil {
+			nr.Requests = make(core.ResourceList)
+		}
+		nr.Requests[name] = q
+	}
+	return nr
+}
+
+func podRequests(pod *core.PodTemplateSpec, name core.ResourceName) bool {
+	for i := range pod.Spec.Containers {
+		if cn := &pod.Spec.Containers[i]; cn.Name != agentconfig.ContainerName {
+			if _, ok := cn.Resources.Requests[name]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package agentmap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+)
+
+func TestApplyAgentSettings_resourcePolicy(t *testing.T) {
+	pod := &core.PodTemplateSpec{Spec: core.PodSpec{Containers: []core.Container{{
+		Name: "app",
+		Resources: core.ResourceRequirements{
+			Requests: core.ResourceList{core.ResourceCPU: resource.MustParse("200m")},
+		},
+	}}}}
+	cfg := &GeneratorConfig{Resources: &agentconfig.ResourceRequirements{
+		Limits: core.ResourceList{core.ResourceMemory: resource.MustParse("64Mi")},
+	}}
+
+	ag := &agentconfig.Sidecar{}
+	require.NoError(t, applyAgentSettings(pod, cfg, ag))
+	assert.Empty(t, ag.ResourcePolicy)
+	assert.Nil(t, ag.Resources.Requests)
+
+	// The agent requests the resources that the app requests, and the memory that its limit defaults to
+	cfg.ResourcePolicy = agentconfig.NeutralResourcePolicy
+	require.NoError(t, applyAgentSettings(pod, cfg, ag))
+	assert.Equal(t, agentconfig.NeutralResourcePolicy, ag.ResourcePolicy)
+	assert.Equal(t, core.ResourceList{
+		core.ResourceCPU:    resource.MustParse("10m"),
+		core.ResourceMemory: resource.MustParse("64Mi"),
+	}, ag.Resources.Requests)
+	assert.Nil(t, cfg.Resources.Requests, "the configured resources are not modified")
+
+	pod.Annotations = map[string]string{AgentResourcePolicyAnnotation: "add"}
+	ag = &agentconfig.Sidecar{}
+	require.NoError(t, applyAgentSettings(pod, cfg, ag))
+	assert.Empty(t, ag.ResourcePolicy)
+
+	pod.Annotations[AgentResourcePolicyAnnotation] = "half"
+	assert.Error(t, applyAgentSettings(pod, cfg, ag))
+}
@@ -0,0 +1,45 @@
+package agentmap
+
+import (
+	core "k8s.io/api/core/v1"
+)
+
+// agentImage returns the image to inject into the given pod. The QualifiedAgentImage is a multi-arch image, so the
+// container runtime will pick the right variant for the node. An image from the ArchAgentImages is used instead
+// when the pod is constrained to nodes of one architecture, e.g. because the registry it's retrieved from only
+// provides single-arch images.
+func (cfg *GeneratorConfig) agentImage(pod *core.PodTemplateSpec) string {
+	if arch := podArchitecture(pod); arch != "" {
+		if img, ok := cfg.ArchAgentImages[arch]; ok {
+			return img
+		}
+	}
+	return cfg.QualifiedAgentImage
+}
+
+// podArchitecture returns the node architecture that the given pod is constrained to by its node selector or its
+// required node affinity, or an empty string when it's not constrained to exactly one architecture.
+func podArchitecture(pod *core.PodTemplateSpec) string {
+	if arch, ok := pod.Spec.NodeSelector[core.LabelArchStable]; ok {
+		return arch
+	}
+	af := pod.Spec.Affinity
+	if af == nil || af.NodeAffinity == nil || af.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return ""
+	}
+	// The terms are ORed, so all of them must constrain the pod to the same architecture
+	arch := ""
+	for _, term := range af.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		termArch := ""
+		for _, expr := range term.MatchExpressions {
+			if expr.Key == core.LabelArchStable && expr.Operator == core.NodeSelectorOpIn && len(expr.Values) == 1 {
+				termArch = expr.Values[0]
+			}
+		}
+		if termArch == "" || arch != "" && arch != termArch {
+			return ""
+		}
+		arch = termArch
+	}
+	return arch
+}
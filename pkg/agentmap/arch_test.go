@@ -0,0 +1,65 @@
+package agentmap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	core "k8s.io/api/core/v1"
+)
+
+func TestGeneratorConfig_agentImage(t *testing.T) {
+	cfg := &GeneratorConfig{
+		QualifiedAgentImage: "docker.io/datawire/tel2:2.7.0",
+		ArchAgentImages:     map[string]string{"arm64": "example.com/tel2:2.7.0-arm64"},
+	}
+	archAffinity := func(archs ...string) *core.Affinity {
+		terms := make([]core.NodeSelectorTerm, len(archs))
+		for i, arch := range archs {
+			terms[i] = core.NodeSelectorTerm{MatchExpressions: []core.NodeSelectorRequirement{{
+				Key:      core.LabelArchStable,
+				Operator: core.NodeSelectorOpIn,
+				Values:   []string{arch},
+			}}}
+		}
+		return &core.Affinity{NodeAffinity: &core.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &core.NodeSelector{NodeSelectorTerms: terms},
+		}}
+	}
+	tests := []struct {
+		name string
+		spec core.PodSpec
+		want string
+	}{
+		{
+			"unconstrained",
+			core.PodSpec{},
+			"docker.io/datawire/tel2:2.7.0",
+		},
+		{
+			"node selector",
+			core.PodSpec{NodeSelector: map[string]string{core.LabelArchStable: "arm64"}},
+			"example.com/tel2:2.7.0-arm64",
+		},
+		{
+			"node selector without arch image",
+			core.PodSpec{NodeSelector: map[string]string{core.LabelArchStable: "amd64"}},
+			"docker.io/datawire/tel2:2.7.0",
+		},
+		{
+			"node affinity",
+			core.PodSpec{Affinity: archAffinity("arm64", "arm64")},
+			"example.com/tel2:2.7.0-arm64",
+		},
+		{
+			"node affinity with several archs",
+			core.PodSpec{Affinity: archAffinity("arm64", "amd64")},
+			"docker.io/datawire/tel2:2.7.0",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, cfg.agentImage(&core.PodTemplateSpec{Spec: tt.spec}))
+		})
+	}
+}
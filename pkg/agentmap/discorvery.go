@@ -14,6 +14,16 @@ import (
 )
 
 func FindOwnerWorkload(ctx context.Context, obj k8sapi.Object) (k8sapi.Workload, error) {
+	// The controller of workloads like Knative Services doesn't own the pods directly, but labels them
+	if kind, name := k8sapi.LabeledWorkloadRef(ctx, obj.GetLabels()); name != "" && !(kind == obj.GetKind() && name == obj.GetName()) {
+		wl, err := k8sapi.GetWorkload(ctx, name, obj.GetNamespace(), kind)
+		if err == nil {
+			return wl, nil
+		}
+		if !k8sErrors.IsNotFound(err) {
+			return nil, err
+		}
+	}
 	refs := obj.GetOwnerReferences()
 	for i := range refs {
 		if or := &refs[i]; or.Controller != nil && *or.Controller {
@@ -37,6 +47,8 @@ func FindOwnerWorkload(ctx context.Context, obj k8sapi.Object) (k8sapi.Workload,
 	return nil, fmt.Errorf("unable to find workload owner for %s.%s", obj.GetName(), obj.GetNamespace())
 }
 
+// findServicesForPod returns the services that select the given pod, or the service with the given name if it
+// isn't empty. An empty result is returned when no service selects the pod.
 func findServicesForPod(ctx context.Context, pod *core.PodTemplateSpec, svcName string) ([]k8sapi.Object, error) {
 	switch {
 	case svcName != "":
@@ -51,17 +63,9 @@ func findServicesForPod(ctx context.Context, pod *core.PodTemplateSpec, svcName
 		}
 		return []k8sapi.Object{svc}, nil
 	case len(pod.Labels) > 0:
-		lbs := labels.Set(pod.Labels)
-		svcs, err := findServicesSelecting(ctx, pod.Namespace, lbs)
-		if err != nil {
-			return nil, err
-		}
-		if len(svcs) > 0 {
-			return svcs, nil
-		}
-		return nil, fmt.Errorf("unable to find services that selects pod %s.%s using labels %s", pod.Name, pod.Namespace, lbs)
+		return findServicesSelecting(ctx, pod.Namespace, labels.Set(pod.Labels))
 	default:
-		return nil, fmt.Errorf("unable to resolve a service using pod %s.%s because it has no labels", pod.Name, pod.Namespace)
+		return nil, nil
 	}
 }
 
@@ -3,10 +3,14 @@ package agentmap
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	core "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 
+	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
 	"github.com/telepresenceio/telepresence/v2/pkg/install"
 	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
@@ -18,14 +22,31 @@ const (
 	ManagerAppName        = "traffic-manager"
 	ManagerPortHTTP       = 8081
 	AgentInjectorName     = "agent-injector"
+
+	// ReplaceContainerAnnotation names the container that the traffic-agent replaces. The traffic-manager adds it
+	// to the pod template of a workload while a replace intercept is active.
+	ReplaceContainerAnnotation = agentconfig.DomainPrefix + "replace-container"
 )
 
 type GeneratorConfig struct {
 	AgentPort           uint16
+	AgentMaxPort        uint16
+	ListenAddress       string
+	ReusePort           bool
+	TunnelPoolSize      int
 	APIPort             uint16
 	QualifiedAgentImage string
+	ArchAgentImages     map[string]string
 	ManagerNamespace    string
 	LogLevel            string
+	KeepAliveInterval   time.Duration
+	KeepAliveTimeout    time.Duration
+	TCPUserTimeout      time.Duration
+	SecurityProfile     agentconfig.SecurityProfile
+	SecurityContext     *agentconfig.SecurityContext
+	Resources           *agentconfig.ResourceRequirements
+	ResourcePolicy      agentconfig.ResourcePolicy
+	PullSecrets         []string
 }
 
 func GenerateForPod(ctx context.Context, pod *core.Pod, env *GeneratorConfig) (*agentconfig.Sidecar, error) {
@@ -62,14 +83,24 @@ func Generate(ctx context.Context, wl k8sapi.Workload, cfg *GeneratorConfig) (*a
 
 	var ccs []*agentconfig.Container
 	pns := make(map[int32]uint16)
-	portNumber := func(cnPort int32) uint16 {
+	next := cfg.AgentPort
+	portNumber := func(cnPort int32) (uint16, error) {
 		if p, ok := pns[cnPort]; ok {
 			// Port already mapped. Reuse that mapping
-			return p
+			return p, nil
+		}
+		// Skip the ports that the containers of the pod are exposing
+		for containerExposesPort(cns, next) {
+			next++
+		}
+		if next == 0 || cfg.AgentMaxPort != 0 && next > cfg.AgentMaxPort {
+			return 0, fmt.Errorf("the ports %d-%d of the %s sidecar are exhausted by pod %s.%s",
+				cfg.AgentPort, cfg.AgentMaxPort, agentconfig.ContainerName, pod.Name, pod.Namespace)
 		}
-		p := cfg.AgentPort + uint16(len(pns))
+		p := next
+		next++
 		pns[cnPort] = p
-		return p
+		return p, nil
 	}
 
 	for _, svc := range svcs {
@@ -78,12 +109,60 @@ func Generate(ctx context.Context, wl k8sapi.Workload, cfg *GeneratorConfig) (*a
 			return nil, err
 		}
 	}
+	if len(svcs) == 0 {
+		// No service selects the pod, so its container ports are intercepted directly. This is for
+		// workloads that receive their traffic from a service mesh rather than from a service.
+		if ccs, err = appendServicelessContainerConfigs(pod, portNumber, ccs); err != nil {
+			return nil, err
+		}
+	}
+	if ccs, err = replaceContainer(pod, ccs); err != nil {
+		return nil, err
+	}
 	if len(ccs) == 0 {
+		if len(svcs) == 0 {
+			return nil, fmt.Errorf("found no service that selects pod %s.%s and no container port to intercept", pod.Name, pod.Namespace)
+		}
 		return nil, fmt.Errorf("found no service with a port that matches a container in pod %s.%s", pod.Name, pod.Namespace)
 	}
 
+	profile := cfg.SecurityProfile
+	if pa, ok := pod.Annotations[agentconfig.SecurityProfileAnnotation]; ok {
+		if profile, err = agentconfig.NewSecurityProfile(pa); err != nil {
+			return nil, fmt.Errorf("invalid %s annotation in pod %s.%s: %w", agentconfig.SecurityProfileAnnotation, pod.Name, pod.Namespace, err)
+		}
+	}
+	if pod.Spec.HostNetwork {
+		// The init container would change the iptables of the node, so the ports must be redirected using the
+		// service instead.
+		dlog.Infof(ctx, "Pod %s.%s uses the host network, so its ports are redirected to the %s without an init container",
+			pod.Name, pod.Namespace, agentconfig.ContainerName)
+		if ccs = redirectWithoutInitContainer(ctx, pod, ccs); len(ccs) == 0 {
+			return nil, fmt.Errorf("pod %s.%s uses the host network, and all of its ports need an init container, which "+
+				"would change the iptables rules of the node. Only the ports of services that aren't headless can be "+
+				"intercepted in such a pod", pod.Name, pod.Namespace)
+		}
+	} else if !initContainerPermitted(ctx, pod.Namespace, profile) {
+		if ccs = redirectWithoutInitContainer(ctx, pod, ccs); len(ccs) == 0 {
+			return nil, fmt.Errorf("all ports of pod %s.%s need an init container, which isn't permitted in namespace %s",
+				pod.Name, pod.Namespace, pod.Namespace)
+		}
+	}
+
+	warnAboutHostPorts(ctx, pod, ccs)
+
+	terminateWithApp := false
+	if k8sapi.IsBatchKind(wl.GetKind()) {
+		if terminateWithApp = agentconfig.SharesProcessNamespace(pod.Annotations, &pod.Spec); !terminateWithApp {
+			dlog.Warnf(ctx, "The pods of %s %s.%s don't share their process namespace, so the %s can't see when the app "+
+				"has exited, and the pods won't complete while it runs. Annotate the pod template with %s: \"true\" "+
+				"to let the pods complete", wl.GetKind(), wl.GetName(), wl.GetNamespace(), agentconfig.ContainerName,
+				agentconfig.ShareProcessNamespaceAnnotation)
+		}
+	}
+
 	ag := &agentconfig.Sidecar{
-		AgentImage:   cfg.QualifiedAgentImage,
+		AgentImage:   cfg.agentImage(pod),
 		AgentName:    wl.GetName(),
 		LogLevel:     cfg.LogLevel,
 		Namespace:    wl.GetNamespace(),
@@ -93,18 +172,86 @@ func Generate(ctx context.Context, wl k8sapi.Workload, cfg *GeneratorConfig) (*a
 		ManagerPort:  ManagerPortHTTP,
 		APIPort:      cfg.APIPort,
 		Containers:   ccs,
+
+		ListenAddress: cfg.ListenAddress,
+		ReusePort:     cfg.ReusePort,
+
+		TunnelPoolSize: cfg.TunnelPoolSize,
+
+		TerminateWithApp: terminateWithApp,
+
+		SecurityProfile: profile,
+		TerminatingTLS:  pod.Annotations[agentconfig.TerminatingTLSAnnotation],
+		OriginatingTLS:  pod.Annotations[agentconfig.OriginatingTLSAnnotation],
+
+		ManagerKeepAliveInterval: cfg.KeepAliveInterval,
+		ManagerKeepAliveTimeout:  cfg.KeepAliveTimeout,
+		ManagerTCPUserTimeout:    cfg.TCPUserTimeout,
+	}
+	if err = applyAgentSettings(pod, cfg, ag); err != nil {
+		return nil, err
 	}
 	return ag, nil
 }
 
-func appendAgentContainerConfigs(svc *core.Service, pod *core.PodTemplateSpec, portNumber func(int32) uint16, ccs []*agentconfig.Container) ([]*agentconfig.Container, error) {
+// appendServicelessContainerConfigs appends configs for the container ports of the given pod. The intercepts have no
+// service, and the traffic that arrives at the container port is redirected to the agent port using iptables.
+func appendServicelessContainerConfigs(pod *core.PodTemplateSpec, portNumber func(int32) (uint16, error), ccs []*agentconfig.Container) ([]*agentconfig.Container, error) {
+	portNameOrNumber := pod.Annotations[ServicePortAnnotation]
+	cns := pod.Spec.Containers
+	for ci := range cns {
+		cn := &cns[ci]
+		if cn.Name == agentconfig.ContainerName {
+			continue
+		}
+		var ics []*agentconfig.Intercept
+		for _, port := range cn.Ports {
+			if portNameOrNumber != "" && portNameOrNumber != port.Name && portNameOrNumber != strconv.Itoa(int(port.ContainerPort)) {
+				continue
+			}
+			proto := port.Protocol
+			if proto == "" {
+				proto = core.ProtocolTCP
+			} else if proto == core.ProtocolSCTP {
+				// The traffic-agent can't forward SCTP
+				continue
+			}
+			agentPort, err := portNumber(port.ContainerPort)
+			if err != nil {
+				return nil, err
+			}
+			ics = append(ics, &agentconfig.Intercept{
+				TargetPortNumeric: true,
+				Protocol:          string(proto),
+				AgentPort:         agentPort,
+				ContainerPortName: port.Name,
+				ContainerPort:     uint16(port.ContainerPort),
+			})
+		}
+		if len(ics) > 0 {
+			ccs = append(ccs, newContainerConfig(cn, len(ccs), ics))
+		}
+	}
+	return ccs, nil
+}
+
+func appendAgentContainerConfigs(svc *core.Service, pod *core.PodTemplateSpec, portNumber func(int32) (uint16, error), ccs []*agentconfig.Container) ([]*agentconfig.Container, error) {
 	portNameOrNumber := pod.Annotations[ServicePortAnnotation]
 	ports, err := install.FilterServicePorts(svc, portNameOrNumber)
 	if err != nil {
 		return nil, err
 	}
+	appProtos, err := declaredAppProtocols(svc)
+	if err != nil {
+		return nil, err
+	}
 nextSvcPort:
 	for _, port := range ports {
+		if port.Protocol == core.ProtocolSCTP {
+			// The traffic-agent can't forward SCTP
+			continue
+		}
+		port = originalServicePort(svc, port)
 		cn, i := findContainerMatchingPort(&port, pod.Spec.Containers)
 		if cn == nil || cn.Name == agentconfig.ContainerName {
 			continue
@@ -123,6 +270,15 @@ nextSvcPort:
 		if port.AppProtocol != nil {
 			appProto = *port.AppProtocol
 		}
+		if ap, ok := appProtos[port.Name]; ok && port.Name != "" {
+			appProto = ap
+		} else if ap, ok = appProtos[strconv.Itoa(int(port.Port))]; ok {
+			appProto = ap
+		}
+		agentPort, err := portNumber(appPort.ContainerPort)
+		if err != nil {
+			return nil, err
+		}
 
 		ic := &agentconfig.Intercept{
 			ServiceName:       svc.Name,
@@ -132,7 +288,7 @@ nextSvcPort:
 			TargetPortNumeric: port.TargetPort.Type == intstr.Int,
 			Protocol:          string(port.Protocol),
 			AppProtocol:       appProto,
-			AgentPort:         portNumber(appPort.ContainerPort),
+			AgentPort:         agentPort,
 			ContainerPortName: appPort.Name,
 			ContainerPort:     uint16(appPort.ContainerPort),
 		}
@@ -144,20 +300,68 @@ nextSvcPort:
 				continue nextSvcPort
 			}
 		}
-		var mounts []string
-		if l := len(cn.VolumeMounts); l > 0 {
-			mounts = make([]string, l)
-			for i, vm := range cn.VolumeMounts {
-				mounts[i] = vm.MountPath
+		ccs = append(ccs, newContainerConfig(cn, len(ccs), []*agentconfig.Intercept{ic}))
+	}
+	return ccs, nil
+}
+
+// declaredAppProtocols returns the application protocols that the AppProtocolsAnnotation of the given service
+// declares, keyed by port name or number.
+func declaredAppProtocols(svc *core.Service) (map[string]string, error) {
+	a, ok := svc.Annotations[agentconfig.AppProtocolsAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	aps := make(map[string]string)
+	for _, pp := range strings.Split(a, ",") {
+		pp = strings.TrimSpace(pp)
+		if pp == "" {
+			continue
+		}
+		port, proto, ok := strings.Cut(pp, "=")
+		port, proto = strings.TrimSpace(port), strings.ToLower(strings.TrimSpace(proto))
+		if !ok || port == "" || !agentconfig.IsAppProto(proto) {
+			return nil, fmt.Errorf("invalid %s annotation %q in service %s.%s: %q must be <port name or number>=<protocol>, "+
+				"where the protocol is one of %s, %s, %s, %s, or %s", agentconfig.AppProtocolsAnnotation, a, svc.Name, svc.Namespace, pp,
+				agentconfig.AppProtoTCP, agentconfig.AppProtoHTTP, agentconfig.AppProtoHTTP2, agentconfig.AppProtoGRPC,
+				agentconfig.AppProtoTLSPassthrough)
+		}
+		aps[port] = proto
+	}
+	return aps, nil
+}
+
+// containerExposesPort returns true if one of the given containers, other than the traffic-agent, exposes the
+// given port.
+func containerExposesPort(cns []core.Container, port uint16) bool {
+	for i := range cns {
+		cn := &cns[i]
+		if cn.Name == agentconfig.ContainerName {
+			continue
+		}
+		for _, p := range cn.Ports {
+			if p.ContainerPort == int32(port) {
+				return true
 			}
 		}
-		ccs = append(ccs, &agentconfig.Container{
-			Name:       cn.Name,
-			EnvPrefix:  CapsBase26(uint64(len(ccs))) + "_",
-			MountPoint: agentconfig.MountPrefixApp + "/" + cn.Name,
-			Mounts:     mounts,
-			Intercepts: []*agentconfig.Intercept{ic},
-		})
 	}
-	return ccs, nil
+	return false
+}
+
+func newContainerConfig(cn *core.Container, index int, ics []*agentconfig.Intercept) *agentconfig.Container {
+	var mounts []string
+	if l := len(cn.VolumeMounts); l > 0 {
+		mounts = make([]string, l)
+		for i, vm := range cn.VolumeMounts {
+			mounts[i] = vm.MountPath
+		}
+	}
+	return &agentconfig.Container{
+		Name:       cn.Name,
+		EnvPrefix:  CapsBase26(uint64(index)) + "_",
+		MountPoint: agentconfig.MountPrefixApp + "/" + cn.Name,
+		Mounts:     mounts,
+		Intercepts: ics,
+		SecretEnv:  agentconfig.AppSecretEnv(cn),
+	}
 }
@@ -0,0 +1,41 @@
+package agentmap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+)
+
+func TestDeclaredAppProtocols(t *testing.T) {
+	svc := func(a string) *core.Service {
+		return &core.Service{ObjectMeta: meta.ObjectMeta{
+			Name:        "echo",
+			Namespace:   "default",
+			Annotations: map[string]string{agentconfig.AppProtocolsAnnotation: a},
+		}}
+	}
+
+	aps, err := declaredAppProtocols(&core.Service{})
+	require.NoError(t, err)
+	assert.Nil(t, aps)
+
+	aps, err = declaredAppProtocols(svc("http=HTTP2, 8443=tls-passthrough,,db=tcp,api=grpc,web=http"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"http": agentconfig.AppProtoHTTP2,
+		"api":  agentconfig.AppProtoGRPC,
+		"web":  agentconfig.AppProtoHTTP,
+		"8443": agentconfig.AppProtoTLSPassthrough,
+		"db":   agentconfig.AppProtoTCP,
+	}, aps)
+
+	for _, a := range []string{"http", "=grpc", "http=sctp"} {
+		_, err = declaredAppProtocols(svc(a))
+		assert.ErrorContains(t, err, "invalid "+agentconfig.AppProtocolsAnnotation+" annotation", a)
+	}
+}
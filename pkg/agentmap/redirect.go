@@ -0,0 +1,188 @@
+package agentmap
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+const (
+	// RedirectedTargetPortsAnnotation is a service annotation that maps the numbers of the service ports
+	// whose target ports have been redirected to the traffic-agent to their original target ports.
+	RedirectedTargetPortsAnnotation = agentconfig.DomainPrefix + "redirected-target-ports"
+
+	podSecurityEnforceLabel = "pod-security.kubernetes.io/enforce"
+)
+
+// initContainerPermitted returns false when the init container, and its NET_ADMIN capability, cannot be used in
+// the given namespace. That's the case with the restricted security profile and in namespaces where the baseline
+// or restricted Pod Security Standard is enforced.
+func initContainerPermitted(ctx context.Context, namespace string, profile agentconfig.SecurityProfile) bool {
+	if profile == agentconfig.RestrictedSecurityProfile {
+		return false
+	}
+	ns, err := k8sapi.GetK8sInterface(ctx).CoreV1().Namespaces().Get(ctx, namespace, meta.GetOptions{})
+	if err != nil {
+		dlog.Debugf(ctx, "unable to get namespace %s to determine its pod security level: %v", namespace, err)
+		return true
+	}
+	switch ns.Labels[podSecurityEnforceLabel] {
+	case "baseline", "restricted":
+		return false
+	default:
+		return true
+	}
+}
+
+// redirectWithoutInitContainer makes the intercepts of numeric service target ports use service redirection
+// instead of the init container, and drops the intercepts that need the init container anyway, along with the
+// containers that have no intercepts left.
+func redirectWithoutInitContainer(ctx context.Context, pod *core.PodTemplateSpec, ccs []*agentconfig.Container) []*agentconfig.Container {
+	rccs := ccs[:0]
+	for _, cc := range ccs {
+		ics := cc.Intercepts[:0]
+		for _, ic := range cc.Intercepts {
+			if ic.TargetPortNumeric && !ic.Headless && ic.ServiceName != "" && !cc.Replace {
+				ic.ServiceRedirect = true
+			}
+			if ic.NeedsInitContainer() {
+				dlog.Debugf(ctx, "Skipping port %d of container %s in pod %s.%s because it needs an init container",
+					ic.ContainerPort, cc.Name, pod.Name, pod.Namespace)
+				continue
+			}
+			ics = append(ics, ic)
+		}
+		if len(ics) > 0 {
+			cc.Intercepts = ics
+			rccs = append(rccs, cc)
+		}
+	}
+	return rccs
+}
+
+// warnAboutHostPorts warns about the intercepted ports that are exposed on the host and reach the traffic-agent
+// through a service rather than through the iptables rules of the init container, because the traffic that
+// arrives at the host port then goes straight to the app.
+func warnAboutHostPorts(ctx context.Context, pod *core.PodTemplateSpec, ccs []*agentconfig.Container) {
+	for _, cc := range ccs {
+		if cc.Replace {
+			continue
+		}
+		for _, ic := range cc.Intercepts {
+			if ic.ServiceName == "" || ic.NeedsInitContainer() {
+				continue
+			}
+			if hp := hostPort(pod, cc.Name, ic.ContainerPort); hp != 0 {
+				dlog.Warnf(ctx, "Port %d of container %s in pod %s.%s is exposed as host port %d. Intercepts of the port "+
+					"only receive the traffic of service %s, because the traffic to the host port bypasses it",
+					ic.ContainerPort, cc.Name, pod.Name, pod.Namespace, hp, ic.ServiceName)
+			}
+		}
+	}
+}
+
+// hostPort returns the host port that the given port of the given container is exposed as, or zero if it isn't
+// exposed on the host. All ports of a pod that uses the host network are host ports.
+func hostPort(pod *core.PodTemplateSpec, cnName string, port uint16) int32 {
+	if pod.Spec.HostNetwork {
+		return int32(port)
+	}
+	for i := range pod.Spec.Containers {
+		cn := &pod.Spec.Containers[i]
+		if cn.Name != cnName {
+			continue
+		}
+		for _, p := range cn.Ports {
+			if p.ContainerPort == int32(port) {
+				return p.HostPort
+			}
+		}
+	}
+	return 0
+}
+
+func redirectedTargetPorts(svc *core.Service) map[string]int32 {
+	var rps map[string]int32
+	if a, ok := svc.Annotations[RedirectedTargetPortsAnnotation]; ok {
+		if err := json.Unmarshal([]byte(a), &rps); err != nil {
+			return nil
+		}
+	}
+	return rps
+}
+
+// originalServicePort returns the given service port with the target port that it had before it was redirected to
+// the traffic-agent.
+func originalServicePort(svc *core.Service, port core.ServicePort) core.ServicePort {
+	if tp, ok := redirectedTargetPorts(svc)[strconv.Itoa(int(port.Port))]; ok &&
+		port.TargetPort.Type == intstr.String && port.TargetPort.StrVal == agentconfig.RedirectedPortName(uint16(tp)) {
+		port.TargetPort = intstr.FromInt(int(tp))
+	}
+	return port
+}
+
+// RedirectServicePorts replaces the numeric target ports of the given service that the given config redirects with
+// the names of the traffic-agent's ports, and records the original target ports in an annotation. It returns true
+// if the service was modified.
+func RedirectServicePorts(svc *core.Service, ac *agentconfig.Sidecar) bool {
+	rps := redirectedTargetPorts(svc)
+	modified := false
+	for _, cc := range ac.Containers {
+		for _, ic := range cc.Intercepts {
+			if !ic.ServiceRedirect || ic.ServiceUID != svc.UID {
+				continue
+			}
+			ports := svc.Spec.Ports
+			for i := range ports {
+				port := &ports[i]
+				if uint16(port.Port) != ic.ServicePort || port.TargetPort.Type != intstr.Int || uint16(port.TargetPort.IntVal) != ic.ContainerPort {
+					continue
+				}
+				if rps == nil {
+					rps = make(map[string]int32)
+				}
+				rps[strconv.Itoa(int(port.Port))] = port.TargetPort.IntVal
+				port.TargetPort = intstr.FromString(agentconfig.RedirectedPortName(ic.ContainerPort))
+				modified = true
+			}
+		}
+	}
+	if modified {
+		setRedirectedTargetPorts(svc, rps)
+	}
+	return modified
+}
+
+// RestoreServicePorts restores the target ports of the given service that were redirected to the traffic-agent
+// and removes the annotation that records them. It returns true if the service was modified.
+func RestoreServicePorts(svc *core.Service) bool {
+	if _, ok := svc.Annotations[RedirectedTargetPortsAnnotation]; !ok {
+		return false
+	}
+	ports := svc.Spec.Ports
+	for i := range ports {
+		ports[i] = originalServicePort(svc, ports[i])
+	}
+	setRedirectedTargetPorts(svc, nil)
+	return true
+}
+
+func setRedirectedTargetPorts(svc *core.Service, rps map[string]int32) {
+	if len(rps) == 0 {
+		delete(svc.Annotations, RedirectedTargetPortsAnnotation)
+		return
+	}
+	data, _ := json.Marshal(rps)
+	if svc.Annotations == nil {
+		svc.Annotations = make(map[string]string)
+	}
+	svc.Annotations[RedirectedTargetPortsAnnotation] = string(data)
+}
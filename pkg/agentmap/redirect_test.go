@@ -0,0 +1,54 @@
+package agentmap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+)
+
+func TestRedirectServicePorts(t *testing.T) {
+	svc := &core.Service{
+		ObjectMeta: meta.ObjectMeta{Name: "echo", Namespace: "default", UID: "svc-uid"},
+		Spec: core.ServiceSpec{
+			Ports: []core.ServicePort{
+				{Name: "http", Port: 80, TargetPort: intstr.FromInt(8080)},
+				{Name: "grpc", Port: 81, TargetPort: intstr.FromString("grpc")},
+				{Name: "metrics", Port: 82, TargetPort: intstr.FromInt(9090)},
+			},
+		},
+	}
+	ac := &agentconfig.Sidecar{
+		Containers: []*agentconfig.Container{{
+			Name: "echo",
+			Intercepts: []*agentconfig.Intercept{{
+				ServiceName:       "echo",
+				ServiceUID:        "svc-uid",
+				ServicePort:       80,
+				ContainerPort:     8080,
+				TargetPortNumeric: true,
+				ServiceRedirect:   true,
+			}},
+		}},
+	}
+
+	assert.True(t, RedirectServicePorts(svc, ac))
+	assert.Equal(t, intstr.FromString(agentconfig.RedirectedPortName(8080)), svc.Spec.Ports[0].TargetPort)
+	assert.Equal(t, intstr.FromString("grpc"), svc.Spec.Ports[1].TargetPort)
+	assert.Equal(t, intstr.FromInt(9090), svc.Spec.Ports[2].TargetPort)
+	assert.Equal(t, `{"80":8080}`, svc.Annotations[RedirectedTargetPortsAnnotation])
+	assert.Equal(t, intstr.FromInt(8080), originalServicePort(svc, svc.Spec.Ports[0]).TargetPort)
+
+	// Redirecting again is a no-op
+	assert.False(t, RedirectServicePorts(svc, ac))
+
+	assert.True(t, RestoreServicePorts(svc))
+	assert.Equal(t, intstr.FromInt(8080), svc.Spec.Ports[0].TargetPort)
+	assert.Equal(t, intstr.FromString("grpc"), svc.Spec.Ports[1].TargetPort)
+	assert.NotContains(t, svc.Annotations, RedirectedTargetPortsAnnotation)
+	assert.False(t, RestoreServicePorts(svc))
+}
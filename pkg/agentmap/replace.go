@@ -0,0 +1,70 @@
+package agentmap
+
+import (
+	"fmt"
+
+	core "k8s.io/api/core/v1"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+)
+
+// replaceContainer marks the config of the container that the ReplaceContainerAnnotation of the given pod names as
+// replaced. The container is then removed from the pod, and the traffic-agent listens to all the container's ports,
+// including the ones that no service targets, so no init container or port renaming is needed.
+func replaceContainer(pod *core.PodTemplateSpec, ccs []*agentconfig.Container) ([]*agentconfig.Container, error) {
+	name, ok := pod.Annotations[ReplaceContainerAnnotation]
+	if !ok {
+		return ccs, nil
+	}
+	var cn *core.Container
+	if name != agentconfig.ContainerName {
+		for i := range pod.Spec.Containers {
+			if pod.Spec.Containers[i].Name == name {
+				cn = &pod.Spec.Containers[i]
+				break
+			}
+		}
+	}
+	if cn == nil {
+		return nil, fmt.Errorf("pod %s.%s has no container %q to replace", pod.Name, pod.Namespace, name)
+	}
+
+	var cc *agentconfig.Container
+	for _, c := range ccs {
+		if c.Name == name {
+			cc = c
+			break
+		}
+	}
+	if cc == nil {
+		cc = newContainerConfig(cn, len(ccs), nil)
+		ccs = append(ccs, cc)
+	}
+	cc.Replace = true
+
+nextPort:
+	for _, port := range cn.Ports {
+		proto := port.Protocol
+		if proto == "" {
+			proto = core.ProtocolTCP
+		}
+		for _, ic := range cc.Intercepts {
+			if ic.ContainerPort == uint16(port.ContainerPort) && ic.Protocol == string(proto) {
+				continue nextPort
+			}
+		}
+		cc.Intercepts = append(cc.Intercepts, &agentconfig.Intercept{
+			TargetPortNumeric: true,
+			Protocol:          string(proto),
+			ContainerPortName: port.Name,
+			ContainerPort:     uint16(port.ContainerPort),
+		})
+	}
+	if len(cc.Intercepts) == 0 {
+		return nil, fmt.Errorf("container %s in pod %s.%s has no ports to replace", name, pod.Name, pod.Namespace)
+	}
+	for _, ic := range cc.Intercepts {
+		ic.AgentPort = ic.ContainerPort
+	}
+	return ccs, nil
+}
@@ -0,0 +1,64 @@
+package agentmap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+)
+
+func TestReplaceContainer(t *testing.T) {
+	pod := &core.PodTemplateSpec{
+		ObjectMeta: meta.ObjectMeta{Name: "echo", Namespace: "default"},
+		Spec: core.PodSpec{Containers: []core.Container{{
+			Name: "echo",
+			Ports: []core.ContainerPort{
+				{Name: "http", ContainerPort: 8080},
+				{Name: "metrics", ContainerPort: 9090},
+				{Name: "dns", ContainerPort: 5353, Protocol: core.ProtocolUDP},
+			},
+		}}},
+	}
+	ccs := []*agentconfig.Container{{
+		Name: "echo",
+		Intercepts: []*agentconfig.Intercept{{
+			ServiceName:       "echo",
+			ServicePort:       80,
+			Protocol:          string(core.ProtocolTCP),
+			ContainerPortName: "http",
+			ContainerPort:     8080,
+			AgentPort:         9900,
+		}},
+	}}
+
+	// Nothing is replaced without the annotation
+	rccs, err := replaceContainer(pod, ccs)
+	require.NoError(t, err)
+	assert.False(t, rccs[0].Replace)
+	assert.Equal(t, uint16(9900), rccs[0].Intercepts[0].AgentPort)
+
+	pod.Annotations = map[string]string{ReplaceContainerAnnotation: "echo"}
+	rccs, err = replaceContainer(pod, ccs)
+	require.NoError(t, err)
+	require.Len(t, rccs, 1)
+	cc := rccs[0]
+	assert.True(t, cc.Replace)
+	require.Len(t, cc.Intercepts, 3)
+	for i, port := range []uint16{8080, 9090, 5353} {
+		ic := cc.Intercepts[i]
+		assert.Equal(t, port, ic.ContainerPort)
+		assert.Equal(t, port, ic.AgentPort)
+		assert.False(t, ic.NeedsInitContainer())
+	}
+	assert.Equal(t, "echo", cc.Intercepts[0].ServiceName)
+	assert.Equal(t, "", cc.Intercepts[1].ServiceName)
+	assert.Equal(t, string(core.ProtocolUDP), cc.Intercepts[2].Protocol)
+
+	pod.Annotations[ReplaceContainerAnnotation] = "other"
+	_, err = replaceContainer(pod, ccs)
+	assert.Error(t, err)
+}
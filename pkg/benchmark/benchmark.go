@@ -0,0 +1,217 @@
+// Package benchmark contains the HTTP endpoints that the traffic-manager serves for latency and throughput
+// measurements, and the client that performs those measurements using a given dialer.
+package benchmark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	PingPath     = "/benchmark/ping"
+	DownloadPath = "/benchmark/download"
+	UploadPath   = "/benchmark/upload"
+
+	// MaxSize is the maximum number of bytes that the server will send or receive in one request.
+	MaxSize = 1 << 30
+)
+
+// zeroes is the chunk that is repeatedly written by the download handler.
+var zeroes = make([]byte, 32*1024)
+
+// Handler returns a http.Handler that serves the benchmark endpoints. Requests for other paths are dispatched to
+// the given fallback handler.
+func Handler(fallback http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(PingPath, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc(DownloadPath, func(w http.ResponseWriter, r *http.Request) {
+		size, err := strconv.ParseInt(r.URL.Query().Get("size"), 10, 64)
+		if err != nil || size < 0 || size > MaxSize {
+			http.Error(w, "invalid size", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		for size > 0 {
+			chunk := zeroes
+			if size < int64(len(chunk)) {
+				chunk = chunk[:size]
+			}
+			n, err := w.Write(chunk)
+			if err != nil {
+				return
+			}
+			size -= int64(n)
+		}
+	})
+	mux.HandleFunc(UploadPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		n, err := io.Copy(io.Discard, io.LimitReader(r.Body, MaxSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, n)
+	})
+	mux.Handle("/", fallback)
+	return mux
+}
+
+// Result is the outcome of a measurement.
+type Result struct {
+	MinRTT      time.Duration
+	AvgRTT      time.Duration
+	MaxRTT      time.Duration
+	UploadBPS   float64
+	DownloadBPS float64
+}
+
+// ErrUnsupported is returned by Measure when the server doesn't serve the benchmark endpoints.
+var ErrUnsupported = errors.New("the traffic-manager does not support benchmarks")
+
+// Measure uses the given dialer to connect to the benchmark server at addr and then measures the round-trip time
+// using roundTrips sequential requests, followed by the throughput when uploading and downloading size bytes.
+func Measure(ctx context.Context, dial func(context.Context, string, string) (net.Conn, error), addr string, size int64, roundTrips int) (*Result, error) {
+	if size <= 0 || size > MaxSize {
+		return nil, fmt.Errorf("size must be between 1 and %d", MaxSize)
+	}
+	if roundTrips <= 0 {
+		return nil, errors.New("number of round-trips must be greater than zero")
+	}
+	tr := &http.Transport{
+		DialContext:  dial,
+		MaxIdleConns: 1,
+	}
+	defer tr.CloseIdleConnections()
+	hc := &http.Client{Transport: tr}
+	baseURL := "http://" + addr
+
+	// The first request establishes the connection and is therefore not included in the RTT.
+	if err := ping(ctx, hc, baseURL); err != nil {
+		return nil, err
+	}
+
+	r := &Result{}
+	var total time.Duration
+	for i := 0; i < roundTrips; i++ {
+		start := time.Now()
+		if err := ping(ctx, hc, baseURL); err != nil {
+			return nil, err
+		}
+		rtt := time.Since(start)
+		total += rtt
+		if i == 0 || rtt < r.MinRTT {
+			r.MinRTT = rtt
+		}
+		if rtt > r.MaxRTT {
+			r.MaxRTT = rtt
+		}
+	}
+	r.AvgRTT = total / time.Duration(roundTrips)
+
+	var err error
+	if r.UploadBPS, err = upload(ctx, hc, baseURL, size); err != nil {
+		return nil, err
+	}
+	if r.DownloadBPS, err = download(ctx, hc, baseURL, size); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func ping(ctx context.Context, hc *http.Client, baseURL string) error {
+	rq, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+PingPath, nil)
+	if err != nil {
+		return err
+	}
+	rs, err := hc.Do(rq)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, rs.Body)
+	rs.Body.Close()
+	if rs.StatusCode != http.StatusNoContent {
+		// Older traffic-managers respond with 200 OK on all paths.
+		return ErrUnsupported
+	}
+	return nil
+}
+
+func upload(ctx context.Context, hc *http.Client, baseURL string, size int64) (float64, error) {
+	body := io.LimitReader(zeroReader{}, size)
+	rq, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+UploadPath, body)
+	if err != nil {
+		return 0, err
+	}
+	rq.ContentLength = size
+	start := time.Now()
+	rs, err := hc.Do(rq)
+	if err != nil {
+		return 0, err
+	}
+	defer rs.Body.Close()
+	data, err := io.ReadAll(rs.Body)
+	if err != nil {
+		return 0, err
+	}
+	elapsed := time.Since(start)
+	if rs.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("upload failed: %s", rs.Status)
+	}
+	if n, err := strconv.ParseInt(string(data), 10, 64); err != nil || n != size {
+		return 0, fmt.Errorf("upload failed: server received %q bytes, expected %d", data, size)
+	}
+	return bytesPerSecond(size, elapsed), nil
+}
+
+func download(ctx context.Context, hc *http.Client, baseURL string, size int64) (float64, error) {
+	rq, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s%s?size=%d", baseURL, DownloadPath, size), nil)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	rs, err := hc.Do(rq)
+	if err != nil {
+		return 0, err
+	}
+	defer rs.Body.Close()
+	if rs.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("download failed: %s", rs.Status)
+	}
+	n, err := io.Copy(io.Discard, rs.Body)
+	if err != nil {
+		return 0, err
+	}
+	elapsed := time.Since(start)
+	if n != size {
+		return 0, fmt.Errorf("download failed: received %d bytes, expected %d", n, size)
+	}
+	return bytesPerSecond(size, elapsed), nil
+}
+
+func bytesPerSecond(size int64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		elapsed = time.Nanosecond
+	}
+	return float64(size) / elapsed.Seconds()
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
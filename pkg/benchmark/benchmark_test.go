@@ -0,0 +1,46 @@
+package benchmark
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMeasure(t *testing.T) {
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Hello World from: %s\n", r.URL.Path)
+	})
+	dialer := &net.Dialer{}
+
+	t.Run("supported", func(t *testing.T) {
+		srv := httptest.NewServer(Handler(fallback))
+		defer srv.Close()
+		r, err := Measure(context.Background(), dialer.DialContext, strings.TrimPrefix(srv.URL, "http://"), 1<<20, 5)
+		require.NoError(t, err)
+		assert.True(t, r.MinRTT > 0)
+		assert.True(t, r.MinRTT <= r.AvgRTT)
+		assert.True(t, r.AvgRTT <= r.MaxRTT)
+		assert.True(t, r.UploadBPS > 0)
+		assert.True(t, r.DownloadBPS > 0)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		srv := httptest.NewServer(fallback)
+		defer srv.Close()
+		_, err := Measure(context.Background(), dialer.DialContext, strings.TrimPrefix(srv.URL, "http://"), 1<<20, 5)
+		assert.ErrorIs(t, err, ErrUnsupported)
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		Handler(fallback).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/foo", nil))
+		assert.Equal(t, "Hello World from: /foo\n", rr.Body.String())
+	})
+}
@@ -0,0 +1,308 @@
+// Package api is a Go API for programs that control Telepresence without running the telepresence binary.
+//
+// The exported identifiers of this package are supported and follow the versioning of Telepresence: within a
+// major version they are never removed or changed in an incompatible way, although identifiers and struct
+// fields may be added. Use field names in composite literals for this reason. The package never exposes the
+// gRPC API of the daemons, or the types of other Telepresence packages, because those may change in any
+// release.
+//
+// A Client controls a running user daemon, which is started by the telepresence binary, e.g. using "telepresence
+// connect". The user daemon must use the same daemon API version as this package.
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dcontext"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+)
+
+// Client is a client of the Telepresence user daemon. It's safe for concurrent use.
+type Client struct {
+	conn *grpc.ClientConn
+	cc   connector.ConnectorClient
+
+	// ctx carries the Telepresence config and environment for the lifetime of the client
+	ctx context.Context
+}
+
+// NewClient returns a Client that is connected to the user daemon. The given context is only used while
+// connecting. It returns ErrNoUserDaemon when the user daemon isn't running.
+func NewClient(ctx context.Context) (*Client, error) {
+	ctx, err := withClientConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := cliutil.DialConnector(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c := newClient(dcontext.WithoutCancel(ctx), connector.NewConnectorClient(conn))
+	c.conn = conn
+	if err = c.checkVersion(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func newClient(ctx context.Context, cc connector.ConnectorClient) *Client {
+	return &Client{cc: cc, ctx: ctx}
+}
+
+// withClientConfig adds the Telepresence environment and config to the given context, unless it already has them.
+func withClientConfig(ctx context.Context) (context.Context, error) {
+	if client.GetEnv(ctx) == nil {
+		env, err := client.LoadEnv(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the Telepresence environment: %w", err)
+		}
+		ctx = client.WithEnv(ctx, env)
+	}
+	if client.GetConfig(ctx) == nil {
+		cfg, err := client.LoadConfig(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the Telepresence config: %w", err)
+		}
+		ctx = client.WithConfig(ctx, cfg)
+	}
+	return ctx, nil
+}
+
+func (c *Client) checkVersion(ctx context.Context) error {
+	vi, err := c.cc.Version(ctx, &empty.Empty{})
+	if err != nil {
+		return fmt.Errorf("unable to retrieve the version of the user daemon: %w", err)
+	}
+	if vi.ApiVersion != client.APIVersion {
+		return fmt.Errorf("the user daemon %s uses API version %d, but this client requires API version %d",
+			vi.Version, vi.ApiVersion, client.APIVersion)
+	}
+	return nil
+}
+
+// Close closes the connection to the user daemon. It doesn't end the daemon's connection to the cluster.
+func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// Connect connects the user daemon to a cluster, unless it's already connected, and returns the status of the
+// connection.
+func (c *Client) Connect(ctx context.Context, cr ConnectRequest) (*Status, error) {
+	rq := &connector.ConnectRequest{MappedNamespaces: cr.MappedNamespaces, ConnectOnly: cr.ConnectOnly}
+	for _, p := range cr.ExposedPorts {
+		port := p.Port
+		if port == 0 {
+			port = p.LocalPort
+		}
+		rq.ExposedPorts = append(rq.ExposedPorts, &manager.ExposedPort{Port: int32(port), LocalPort: int32(p.LocalPort)})
+	}
+	if len(cr.KubeFlags) > 0 {
+		rq.KubeFlags = make(map[string]string, len(cr.KubeFlags))
+		for k, v := range cr.KubeFlags {
+			rq.KubeFlags[k] = v
+		}
+	}
+	cliutil.AddKubeconfigEnv(rq)
+	ci, err := c.cc.Connect(ctx, rq)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	switch ci.Error {
+	case connector.ConnectInfo_UNSPECIFIED, connector.ConnectInfo_ALREADY_CONNECTED:
+		return statusFromRPC(ci), nil
+	case connector.ConnectInfo_MUST_RESTART:
+		return nil, fmt.Errorf("the user daemon is connected to another cluster, disconnect before connecting to %s",
+			ci.ClusterContext)
+	case connector.ConnectInfo_DISCONNECTED:
+		return nil, ErrNotConnected
+	default:
+		return nil, fmt.Errorf("unable to connect: %s", ci.ErrorText)
+	}
+}
+
+// Disconnect ends the user daemon's connection to the cluster. The user daemon keeps running.
+func (c *Client) Disconnect(ctx context.Context) error {
+	_, err := c.cc.Disconnect(ctx, &empty.Empty{})
+	return rpcError(err)
+}
+
+// Status returns the status of the user daemon's connection.
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	ci, err := c.cc.Status(ctx, &empty.Empty{})
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	if ci.Error == connector.ConnectInfo_DISCONNECTED {
+		return &Status{}, nil
+	}
+	return statusFromRPC(ci), nil
+}
+
+// List returns the interceptable workloads of the given namespace, or of the connection's namespace when the
+// given namespace is empty.
+func (c *Client) List(ctx context.Context, namespace string) ([]Workload, error) {
+	r, err := c.cc.List(ctx, &connector.ListRequest{Namespace: namespace, Filter: connector.ListRequest_INTERCEPTABLE})
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	wls := make([]Workload, len(r.Workloads))
+	for i, wi := range r.Workloads {
+		wl := Workload{
+			Name:                   wi.Name,
+			Namespace:              wi.Namespace,
+			Kind:                   wi.WorkloadResourceType,
+			NotInterceptableReason: wi.NotInterceptableReason,
+			AgentInstalled:         wi.AgentInfo != nil,
+		}
+		for _, ii := range wi.InterceptInfos {
+			wl.Intercepts = append(wl.Intercepts, interceptFromRPC(ii))
+		}
+		wls[i] = wl
+	}
+	return wls, nil
+}
+
+// Intercept creates an intercept and returns it. The intercept might not be active yet when this method returns,
+// e.g. when the pods of the workload are restarted to get a traffic-agent.
+func (c *Client) Intercept(ctx context.Context, ir InterceptRequest) (*Intercept, error) {
+	if ir.Name == "" {
+		return nil, errors.New("an intercept must have a name")
+	}
+	spec := &manager.InterceptSpec{
+		Name:                  ir.Name,
+		Namespace:             ir.Namespace,
+		Agent:                 ir.Workload,
+		ServiceName:           ir.ServiceName,
+		ServicePortIdentifier: ir.ServicePort,
+		Mechanism:             "tcp",
+		TargetHost:            ir.LocalHost,
+		TargetPort:            int32(ir.LocalPort),
+		Replace:               ir.Replace,
+		PinScale:              ir.PinScale,
+		NoRolloutWait:         ir.NoRolloutWait,
+		Ephemeral:             ir.Ephemeral,
+	}
+	if spec.Agent == "" {
+		spec.Agent = ir.Name
+	}
+	if spec.TargetHost == "" {
+		spec.TargetHost = "127.0.0.1"
+	}
+	if spec.TargetPort == 0 {
+		spec.TargetPort = int32(client.GetConfig(c.ctx).Intercept.DefaultPort)
+	}
+	for _, p := range ir.ExtraPorts {
+		spec.ExtraPorts = append(spec.ExtraPorts, int32(p))
+	}
+	r, err := c.cc.CreateIntercept(ctx, &connector.CreateInterceptRequest{Spec: spec, MountPoint: ir.MountPoint})
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	if err = interceptError(r); err != nil {
+		return nil, err
+	}
+	ic := interceptFromRPC(r.InterceptInfo)
+	return &ic, nil
+}
+
+// Leave removes the intercept with the given name. It returns ErrInterceptNotFound when no such intercept exists.
+func (c *Client) Leave(ctx context.Context, name string) error {
+	r, err := c.cc.RemoveIntercept(ctx, &manager.RemoveInterceptRequest2{Name: name})
+	if err != nil {
+		return rpcError(err)
+	}
+	return interceptError(r)
+}
+
+func rpcError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if st, ok := status.FromError(err); ok {
+		switch st.Code() {
+		case codes.Unavailable:
+			return fmt.Errorf("%w: %s", ErrNotConnected, st.Message())
+		case codes.Unknown:
+			return fmt.Errorf("user daemon: %s", st.Message())
+		}
+	}
+	return err
+}
+
+func interceptError(r *connector.InterceptResult) error {
+	switch r.Error {
+	case connector.InterceptError_UNSPECIFIED:
+		return nil
+	case connector.InterceptError_NO_CONNECTION, connector.InterceptError_NO_TRAFFIC_MANAGER:
+		return ErrNotConnected
+	case connector.InterceptError_NOT_FOUND:
+		return fmt.Errorf("%w: %s", ErrInterceptNotFound, r.ErrorText)
+	case connector.InterceptError_ALREADY_EXISTS:
+		return fmt.Errorf("intercept %q already exists", r.ErrorText)
+	case connector.InterceptError_LOCAL_TARGET_IN_USE:
+		spec := r.InterceptInfo.Spec
+		return fmt.Errorf("%s is already in use by intercept %s",
+			net.JoinHostPort(spec.TargetHost, strconv.Itoa(int(spec.TargetPort))), spec.Name)
+	case connector.InterceptError_NO_ACCEPTABLE_WORKLOAD:
+		return fmt.Errorf("no interceptable workload matching %s found", r.ErrorText)
+	}
+	msg := r.ErrorText
+	if msg == "" {
+		msg = r.Error.String()
+	}
+	if ii := r.InterceptInfo; ii != nil && ii.Message != "" && ii.Message != msg {
+		msg += ": " + ii.Message
+	}
+	return fmt.Errorf("unable to intercept: %s", msg)
+}
+
+func statusFromRPC(ci *connector.ConnectInfo) *Status {
+	s := &Status{
+		Connected:       true,
+		ClusterContext:  ci.ClusterContext,
+		ClusterServer:   ci.ClusterServer,
+		ClusterID:       ci.ClusterId,
+		SessionID:       ci.GetSessionInfo().GetSessionId(),
+		ConnectOnly:     ci.GetSessionInfo().GetConnectOnly(),
+		DNSName:         ci.GetSessionInfo().GetDnsName(),
+		ManagerWarnings: ci.ManagerWarnings,
+	}
+	for _, ii := range ci.GetIntercepts().GetIntercepts() {
+		s.Intercepts = append(s.Intercepts, interceptFromRPC(ii))
+	}
+	return s
+}
+
+func interceptFromRPC(ii *manager.InterceptInfo) Intercept {
+	spec := ii.Spec
+	return Intercept{
+		ID:          ii.Id,
+		Name:        spec.Name,
+		Namespace:   spec.Namespace,
+		Workload:    spec.Agent,
+		Disposition: ii.Disposition.String(),
+		Message:     ii.Message,
+		LocalHost:   spec.TargetHost,
+		LocalPort:   uint16(spec.TargetPort),
+		ServicePort: spec.ServicePortIdentifier,
+		PodIP:       ii.PodIp,
+		MountPoint:  ii.ClientMountPoint,
+		Environment: ii.Environment,
+	}
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/common"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+type testConnector struct {
+	connector.ConnectorClient
+	apiVersion int32
+	connected  bool
+	intercepts map[string]*manager.InterceptInfo
+}
+
+func (tc *testConnector) Version(context.Context, *empty.Empty, ...grpc.CallOption) (*common.VersionInfo, error) {
+	return &common.VersionInfo{ApiVersion: tc.apiVersion, Version: "v2.7.0"}, nil
+}
+
+func (tc *testConnector) Connect(_ context.Context, rq *connector.ConnectRequest, _ ...grpc.CallOption) (*connector.ConnectInfo, error) {
+	if rq.KubeFlags["context"] != "default" {
+		return &connector.ConnectInfo{Error: connector.ConnectInfo_CLUSTER_FAILED, ErrorText: "no such context"}, nil
+	}
+	tc.connected = true
+	return tc.status(), nil
+}
+
+func (tc *testConnector) Status(context.Context, *empty.Empty, ...grpc.CallOption) (*connector.ConnectInfo, error) {
+	if !tc.connected {
+		return &connector.ConnectInfo{Error: connector.ConnectInfo_DISCONNECTED}, nil
+	}
+	return tc.status(), nil
+}
+
+func (tc *testConnector) status() *connector.ConnectInfo {
+	ci := &connector.ConnectInfo{
+		ClusterContext: "default",
+		ClusterId:      "1234",
+		SessionInfo:    &manager.SessionInfo{SessionId: "abcd"},
+		Intercepts:     &manager.InterceptInfoSnapshot{},
+	}
+	for _, ii := range tc.intercepts {
+		ci.Intercepts.Intercepts = append(ci.Intercepts.Intercepts, ii)
+	}
+	return ci
+}
+
+func (tc *testConnector) List(_ context.Context, rq *connector.ListRequest, _ ...grpc.CallOption) (*connector.WorkloadInfoSnapshot, error) {
+	if !tc.connected {
+		return nil, status.Error(codes.Unavailable, "no active session")
+	}
+	return &connector.WorkloadInfoSnapshot{Workloads: []*connector.WorkloadInfo{{
+		Name:                 "echo",
+		Namespace:            "default",
+		WorkloadResourceType: "Deployment",
+		AgentInfo:            &manager.AgentInfo{Name: "echo"},
+	}}}, nil
+}
+
+func (tc *testConnector) CreateIntercept(_ context.Context, rq *connector.CreateInterceptRequest, _ ...grpc.CallOption) (*connector.InterceptResult, error) {
+	if !tc.connected {
+		return nil, status.Error(codes.Unavailable, "no active session")
+	}
+	if _, ok := tc.intercepts[rq.Spec.Name]; ok {
+		return &connector.InterceptResult{Error: connector.InterceptError_ALREADY_EXISTS, ErrorText: rq.Spec.Name}, nil
+	}
+	ii := &manager.InterceptInfo{
+		Spec:        rq.Spec,
+		Id:          "abcd:" + rq.Spec.Name,
+		Disposition: manager.InterceptDispositionType_ACTIVE,
+		PodIp:       "10.1.2.3",
+		Environment: map[string]string{"A": "B"},
+	}
+	tc.intercepts[rq.Spec.Name] = ii
+	return &connector.InterceptResult{InterceptInfo: ii}, nil
+}
+
+func (tc *testConnector) RemoveIntercept(_ context.Context, rq *manager.RemoveInterceptRequest2, _ ...grpc.CallOption) (*connector.InterceptResult, error) {
+	if _, ok := tc.intercepts[rq.Name]; !ok {
+		return &connector.InterceptResult{Error: connector.InterceptError_NOT_FOUND, ErrorText: rq.Name}, nil
+	}
+	delete(tc.intercepts, rq.Name)
+	return &connector.InterceptResult{}, nil
+}
+
+func testContext(t *testing.T) context.Context {
+	cfg := client.GetDefaultConfig()
+	return client.WithConfig(dlog.NewTestContext(t, false), &cfg)
+}
+
+func TestClient(t *testing.T) {
+	ctx := testContext(t)
+	tc := &testConnector{apiVersion: client.APIVersion, intercepts: make(map[string]*manager.InterceptInfo)}
+	c := newClient(ctx, tc)
+	require.NoError(t, c.checkVersion(ctx))
+
+	st, err := c.Status(ctx)
+	require.NoError(t, err)
+	assert.False(t, st.Connected)
+
+	_, err = c.List(ctx, "")
+	assert.True(t, errors.Is(err, ErrNotConnected))
+
+	_, err = c.Connect(ctx, ConnectRequest{KubeFlags: map[string]string{"context": "other"}})
+	assert.EqualError(t, err, "unable to connect: no such context")
+
+	st, err = c.Connect(ctx, ConnectRequest{KubeFlags: map[string]string{"context": "default"}})
+	require.NoError(t, err)
+	assert.Equal(t, &Status{Connected: true, ClusterContext: "default", ClusterID: "1234", SessionID: "abcd"}, st)
+
+	wls, err := c.List(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []Workload{{Name: "echo", Namespace: "default", Kind: "Deployment", AgentInstalled: true}}, wls)
+
+	ic, err := c.Intercept(ctx, InterceptRequest{Name: "echo", ExtraPorts: []uint16{5432}})
+	require.NoError(t, err)
+	assert.True(t, ic.Active())
+	assert.Equal(t, "echo", ic.Workload)
+	assert.Equal(t, "127.0.0.1", ic.LocalHost)
+	assert.Equal(t, uint16(client.GetConfig(ctx).Intercept.DefaultPort), ic.LocalPort)
+	assert.Equal(t, map[string]string{"A": "B"}, ic.Environment)
+	assert.Equal(t, []int32{5432}, tc.intercepts["echo"].Spec.ExtraPorts)
+	assert.Equal(t, "tcp", tc.intercepts["echo"].Spec.Mechanism)
+
+	_, err = c.Intercept(ctx, InterceptRequest{Name: "echo"})
+	assert.EqualError(t, err, `intercept "echo" already exists`)
+
+	st, err = c.Status(ctx)
+	require.NoError(t, err)
+	require.Len(t, st.Intercepts, 1)
+	assert.Equal(t, "abcd:echo", st.Intercepts[0].ID)
+
+	require.NoError(t, c.Leave(ctx, "echo"))
+	assert.True(t, errors.Is(c.Leave(ctx, "echo"), ErrInterceptNotFound))
+}
+
+func TestClient_checkVersion(t *testing.T) {
+	ctx := testContext(t)
+	c := newClient(ctx, &testConnector{apiVersion: client.APIVersion + 1})
+	assert.Error(t, c.checkVersion(ctx))
+}
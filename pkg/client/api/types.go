@@ -0,0 +1,163 @@
+package api
+
+import (
+	"errors"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+)
+
+var (
+	// ErrNoUserDaemon is returned by NewClient when the user daemon isn't running.
+	ErrNoUserDaemon = cliutil.ErrNoUserDaemon
+
+	// ErrNotConnected is returned when a method requires a connection to a cluster and the user daemon isn't
+	// connected.
+	ErrNotConnected = errors.New("telepresence is not connected to a cluster")
+
+	// ErrInterceptNotFound is returned by Leave when no intercept with the given name exists.
+	ErrInterceptNotFound = errors.New("intercept not found")
+)
+
+// ConnectRequest describes how to connect to a cluster.
+type ConnectRequest struct {
+	// KubeFlags are the kubectl flags that select the cluster, e.g. "context" or "kubeconfig", without leading
+	// dashes. The KUBECONFIG environment variable of the calling process is always passed on.
+	KubeFlags map[string]string
+
+	// MappedNamespaces limits the namespaces that are mapped into the local network. All namespaces that the
+	// user has access to are mapped when it's empty.
+	MappedNamespaces []string
+
+	// ConnectOnly only provides outbound connectivity and DNS. The session can't intercept.
+	ConnectOnly bool
+
+	// ExposedPorts are local ports that the pods in the cluster can connect to using the DNS name of the session.
+	// The traffic-manager must be configured to expose them.
+	ExposedPorts []ExposedPort
+}
+
+// ExposedPort is a local port that is reachable from the cluster.
+type ExposedPort struct {
+	// Port is the port that the pods in the cluster connect to. Defaults to LocalPort.
+	Port uint16
+
+	// LocalPort is the port on the local host.
+	LocalPort uint16
+}
+
+// Status describes the connection of the user daemon.
+type Status struct {
+	// Connected is true when the user daemon is connected to a cluster. The other fields are only set when it is.
+	Connected bool
+
+	ClusterContext string
+	ClusterServer  string
+	ClusterID      string
+	SessionID      string
+
+	// ConnectOnly is true when the session can't intercept, because it was requested, or because the
+	// traffic-manager doesn't permit the user to intercept.
+	ConnectOnly bool
+
+	// DNSName is the name that the pods in the cluster use to connect to the exposed ports. Empty when no ports
+	// are exposed.
+	DNSName string
+
+	// ManagerWarnings are warnings that the traffic-manager has for the user.
+	ManagerWarnings []string
+
+	// Intercepts are the intercepts of the session.
+	Intercepts []Intercept
+}
+
+// Workload is a workload, i.e. a Deployment, ReplicaSet, or StatefulSet, in the cluster.
+type Workload struct {
+	Name      string
+	Namespace string
+	Kind      string
+
+	// NotInterceptableReason explains why the workload can't be intercepted. It's empty when it can.
+	NotInterceptableReason string
+
+	// AgentInstalled is true when a traffic-agent has been injected into the workload's pods.
+	AgentInstalled bool
+
+	// Intercepts are the intercepts of the workload that belong to this session.
+	Intercepts []Intercept
+}
+
+// InterceptRequest describes an intercept to create. Intercepts created with this API receive all traffic of the
+// intercepted port.
+type InterceptRequest struct {
+	// Name is the name of the intercept.
+	Name string
+
+	// Workload is the name of the workload to intercept. Defaults to Name.
+	Workload string
+
+	// Namespace is the namespace of the workload. Defaults to the namespace of the connection.
+	Namespace string
+
+	// ServiceName is the name of the service to intercept. Only needed when several services select the workload.
+	ServiceName string
+
+	// ServicePort is the name or number of the service port to intercept. Only needed when the service has
+	// several ports.
+	ServicePort string
+
+	// LocalPort is the port that intercepted traffic is sent to. Defaults to the intercept.defaultPort of the
+	// Telepresence config.
+	LocalPort uint16
+
+	// LocalHost is the host that intercepted traffic is sent to. Defaults to 127.0.0.1.
+	LocalHost string
+
+	// MountPoint is the local directory where the intercepted container's volumes are mounted. The volumes
+	// aren't mounted when it's empty.
+	MountPoint string
+
+	// ExtraPorts are additional ports of the intercepted pod to make available on LocalHost.
+	ExtraPorts []uint16
+
+	// Replace replaces the intercepted container instead of adding a traffic-agent beside it.
+	Replace bool
+
+	// PinScale keeps at least one pod of a workload that scales to zero running until the intercept ends.
+	PinScale bool
+
+	// NoRolloutWait returns the intercept as soon as it's created, without waiting for the rollout of the workload
+	// to bring a pod with a traffic-agent. The intercept is WAITING until then.
+	NoRolloutWait bool
+
+	// Ephemeral adds the traffic-agent to the running pods as an ephemeral container, so that they aren't
+	// restarted. Experimental.
+	Ephemeral bool
+}
+
+// Intercept is an intercept of a workload.
+type Intercept struct {
+	ID        string
+	Name      string
+	Namespace string
+	Workload  string
+
+	// Disposition is the state of the intercept, e.g. "ACTIVE" or "WAITING".
+	Disposition string
+
+	// Message explains the Disposition when the intercept isn't active.
+	Message string
+
+	LocalHost   string
+	LocalPort   uint16
+	ServicePort string
+	PodIP       string
+	MountPoint  string
+
+	// Environment is the environment of the intercepted container.
+	Environment map[string]string
+}
+
+// Active returns true when the intercept receives traffic.
+func (ic *Intercept) Active() bool {
+	return ic.Disposition == "ACTIVE"
+}
@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"context"
+	"os"
+)
+
+const interceptRecordsFile = "intercepts.json"
+
+// InterceptRecord describes how the CLI created an intercept. Besides what the cluster knows about the intercept,
+// it contains what only the CLI knows, like the command that handles the intercepted traffic and the files that
+// the environment of the intercepted container is written to. It's recorded when the intercept is created, so
+// that the intercept can be exported and re-created elsewhere.
+type InterceptRecord struct {
+	Name          string   `json:"name" yaml:"name"`
+	Workload      string   `json:"workload,omitempty" yaml:"workload,omitempty"`
+	Namespace     string   `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	LocalOnly     bool     `json:"local_only,omitempty" yaml:"localOnly,omitempty"`
+	Replace       bool     `json:"replace,omitempty" yaml:"replace,omitempty"`
+	Port          string   `json:"port,omitempty" yaml:"port,omitempty"`
+	Service       string   `json:"service,omitempty" yaml:"service,omitempty"`
+	Mechanism     string   `json:"mechanism,omitempty" yaml:"mechanism,omitempty"`
+	MechanismArgs []string `json:"mechanism_args,omitempty" yaml:"mechanismArgs,omitempty"`
+	Mount         string   `json:"mount,omitempty" yaml:"mount,omitempty"`
+	MountReadOnly bool     `json:"mount_read_only,omitempty" yaml:"mountReadOnly,omitempty"`
+	MountInclude  []string `json:"mount_include,omitempty" yaml:"mountInclude,omitempty"`
+	MountExclude  []string `json:"mount_exclude,omitempty" yaml:"mountExclude,omitempty"`
+	ToPod         []string `json:"to_pod,omitempty" yaml:"toPod,omitempty"`
+	PreviewURL    bool     `json:"preview_url,omitempty" yaml:"previewURL,omitempty"`
+	PinScale      bool     `json:"pin_scale,omitempty" yaml:"pinScale,omitempty"`
+	Ephemeral     bool     `json:"ephemeral,omitempty" yaml:"ephemeral,omitempty"`
+	Cluster       string   `json:"cluster,omitempty" yaml:"cluster,omitempty"`
+
+	// Handler is the command that handles the intercepted traffic. Nil when the intercept was created without one.
+	Handler *InterceptHandler `json:"handler,omitempty" yaml:"handler,omitempty"`
+
+	// Env is where the environment of the intercepted container is written. Nil when it isn't written anywhere.
+	Env *InterceptEnv `json:"env,omitempty" yaml:"env,omitempty"`
+}
+
+// InterceptHandler is the command that handles the traffic of an intercept. The Command is the arguments of
+// "docker run" when DockerRun is true.
+type InterceptHandler struct {
+	Command     []string `json:"command,omitempty" yaml:"command,omitempty"`
+	DockerRun   bool     `json:"docker_run,omitempty" yaml:"dockerRun,omitempty"`
+	DockerMount string   `json:"docker_mount,omitempty" yaml:"dockerMount,omitempty"`
+}
+
+// InterceptEnv is where the environment of an intercepted container is written.
+type InterceptEnv struct {
+	File   string `json:"file,omitempty" yaml:"file,omitempty"`
+	Syntax string `json:"syntax,omitempty" yaml:"syntax,omitempty"`
+	JSON   string `json:"json,omitempty" yaml:"json,omitempty"`
+}
+
+// SaveInterceptRecordToUserCache adds the provided InterceptRecord to the records in the user cache, replacing
+// any record with the same name.
+func SaveInterceptRecordToUserCache(ctx context.Context, ir *InterceptRecord) error {
+	irs, err := LoadInterceptRecordsFromUserCache(ctx)
+	if err != nil {
+		return err
+	}
+	irs[ir.Name] = ir
+	return SaveToUserCache(ctx, irs, interceptRecordsFile)
+}
+
+// LoadInterceptRecordsFromUserCache gets the InterceptRecords from cache, keyed by intercept name. An empty map
+// is returned if the file does not exist. An error is returned if something goes wrong while loading or
+// unmarshalling.
+func LoadInterceptRecordsFromUserCache(ctx context.Context) (map[string]*InterceptRecord, error) {
+	var irs map[string]*InterceptRecord
+	err := LoadFromUserCache(ctx, &irs, interceptRecordsFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		return make(map[string]*InterceptRecord), nil
+	}
+	if irs == nil {
+		irs = make(map[string]*InterceptRecord)
+	}
+	return irs, nil
+}
+
+// DeleteInterceptRecordFromUserCache removes the InterceptRecord with the given name from the user cache. An
+// attempt to remove a non-existing record is a no-op and the function returns nil.
+func DeleteInterceptRecordFromUserCache(ctx context.Context, name string) error {
+	irs, err := LoadInterceptRecordsFromUserCache(ctx)
+	if err != nil {
+		return err
+	}
+	if _, ok := irs[name]; !ok {
+		return nil
+	}
+	delete(irs, name)
+	if len(irs) == 0 {
+		return DeleteFromUserCache(ctx, interceptRecordsFile)
+	}
+	return SaveToUserCache(ctx, irs, interceptRecordsFile)
+}
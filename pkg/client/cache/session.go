@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+const sessionStateFile = "session.json"
+
+// SessionState is the state of the session of the user daemon. It's saved when the session is established,
+// updated when intercepts are created or removed, and deleted when the session ends. A SessionState that is
+// found when the user daemon starts means that the previous user daemon quit unexpectedly.
+type SessionState struct {
+	// SessionID is the id of the session in the root daemon.
+	SessionID string `json:"session_id"`
+
+	// Request is the request that established the session.
+	Request *connector.ConnectRequest `json:"request"`
+
+	// Intercepts are the requests of the intercepts that were active in the session.
+	Intercepts []*connector.CreateInterceptRequest `json:"intercepts,omitempty"`
+}
+
+// SaveSessionStateToUserCache saves the provided SessionState to user cache and returns an error if
+// something goes wrong while marshalling or persisting.
+func SaveSessionStateToUserCache(ctx context.Context, ss *SessionState) error {
+	return SaveToUserCache(ctx, ss, sessionStateFile)
+}
+
+// LoadSessionStateFromUserCache gets the SessionState from cache. An error is returned if something
+// goes wrong while loading or unmarshalling.
+func LoadSessionStateFromUserCache(ctx context.Context) (*SessionState, error) {
+	var ss SessionState
+	if err := LoadFromUserCache(ctx, &ss, sessionStateFile); err != nil {
+		return nil, err
+	}
+	return &ss, nil
+}
+
+// DeleteSessionStateFromUserCache removes the SessionState cache if exists or returns an error. An
+// attempt to remove a non-existing cache is a no-op and the function returns nil.
+func DeleteSessionStateFromUserCache(ctx context.Context) error {
+	return DeleteFromUserCache(ctx, sessionStateFile)
+}
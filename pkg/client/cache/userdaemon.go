@@ -0,0 +1,36 @@
+package cache
+
+import (
+	"context"
+)
+
+const userDaemonTCPFile = "user-daemon-tcp.json"
+
+// UserDaemonTCP describes a TCP address that the user daemon listens to, and the token that a client
+// must present when it connects to that address.
+type UserDaemonTCP struct {
+	Address string `json:"address"`
+	Token   string `json:"token"`
+}
+
+// SaveUserDaemonTCPToUserCache saves the provided UserDaemonTCP to user cache and returns an error if
+// something goes wrong while marshalling or persisting.
+func SaveUserDaemonTCPToUserCache(ctx context.Context, udt *UserDaemonTCP) error {
+	return SaveToUserCache(ctx, udt, userDaemonTCPFile)
+}
+
+// LoadUserDaemonTCPFromUserCache gets the UserDaemonTCP from cache. An error is returned if something
+// goes wrong while loading or unmarshalling.
+func LoadUserDaemonTCPFromUserCache(ctx context.Context) (*UserDaemonTCP, error) {
+	var udt UserDaemonTCP
+	if err := LoadFromUserCache(ctx, &udt, userDaemonTCPFile); err != nil {
+		return nil, err
+	}
+	return &udt, nil
+}
+
+// DeleteUserDaemonTCPFromUserCache removes the UserDaemonTCP cache if exists or returns an error. An
+// attempt to remove a non-existing cache is a no-op and the function returns nil.
+func DeleteUserDaemonTCPFromUserCache(ctx context.Context) error {
+	return DeleteFromUserCache(ctx, userDaemonTCPFile)
+}
@@ -0,0 +1,35 @@
+package cache
+
+import (
+	"context"
+	"os"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+func workloadsFile(clusterID string) string {
+	return "workloads-" + clusterID + ".json"
+}
+
+// SaveWorkloadsToUserCache saves the provided workloads, keyed by namespace, to the user cache of the
+// given cluster and returns an error if something goes wrong while marshalling or persisting.
+func SaveWorkloadsToUserCache(ctx context.Context, clusterID string, workloads map[string][]*connector.WorkloadInfo) error {
+	if len(workloads) == 0 {
+		return DeleteFromUserCache(ctx, workloadsFile(clusterID))
+	}
+	return SaveToUserCache(ctx, workloads, workloadsFile(clusterID))
+}
+
+// LoadWorkloadsFromUserCache gets the workloads of the given cluster from cache. An empty map is returned if
+// the file does not exist. An error is returned if something goes wrong while loading or unmarshalling.
+func LoadWorkloadsFromUserCache(ctx context.Context, clusterID string) (map[string][]*connector.WorkloadInfo, error) {
+	var workloads map[string][]*connector.WorkloadInfo
+	err := LoadFromUserCache(ctx, &workloads, workloadsFile(clusterID))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		return make(map[string][]*connector.WorkloadInfo), nil
+	}
+	return workloads, nil
+}
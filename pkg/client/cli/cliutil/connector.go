@@ -2,6 +2,7 @@ package cliutil
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -21,7 +22,9 @@ import (
 	"github.com/datawire/dlib/dgroup"
 	"github.com/telepresenceio/telepresence/rpc/v2/connector"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/output"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 	"github.com/telepresenceio/telepresence/v2/pkg/proc"
 )
 
@@ -67,9 +70,56 @@ func withConnectorConn(ctx context.Context, conn *grpc.ClientConn) context.Conte
 	return context.WithValue(ctx, connectorConnPtrKey{}, &up)
 }
 
+// dialConnectorTCP dials a user daemon that listens to the given TCP address. The token is taken from
+// the environment, or from the user cache when the environment doesn't provide it. No token is needed
+// when a client certificate is used.
+func dialConnectorTCP(ctx context.Context, env *client.Env) (*grpc.ClientConn, error) {
+	var tlsConfig *tls.Config
+	if env.UserDaemonCA != "" || env.UserDaemonCert != "" {
+		var err error
+		if tlsConfig, err = client.ClientTLSConfig(env.UserDaemonCA, env.UserDaemonCert, env.UserDaemonKey); err != nil {
+			return nil, errcat.User.Newf("unable to load the TLS configuration for the user daemon: %w", err)
+		}
+	}
+	token := env.UserDaemonToken
+	if token == "" && env.UserDaemonCert == "" {
+		udt, err := cache.LoadUserDaemonTCPFromUserCache(ctx)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, errcat.User.Newf("TELEPRESENCE_USER_DAEMON_TOKEN must be set when connecting to the user daemon at %s", env.UserDaemonAddress)
+			}
+			return nil, err
+		}
+		token = udt.Token
+	}
+	conn, err := client.DialTCP(ctx, env.UserDaemonAddress, token, tlsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unable to connect to %s: %v", ErrNoUserDaemon, env.UserDaemonAddress, err)
+	}
+	return conn, nil
+}
+
+// dialConnectorWSL dials the user daemon of the Windows host of a WSL2 distribution, using a relay that is started
+// with the given Windows executable. The relay starts the daemons on the Windows host when they aren't running, unless
+// maybeStart is false.
+func dialConnectorWSL(ctx context.Context, windowsBinary string, maybeStart bool) (*grpc.ClientConn, error) {
+	conn, err := client.DialRelay(ctx, windowsBinary, []string{"daemon-relay", fmt.Sprintf("--start=%t", maybeStart)})
+	if err != nil {
+		return nil, fmt.Errorf("%w: unable to relay to the user daemon using %s: %v", ErrNoUserDaemon, windowsBinary, err)
+	}
+	return conn, nil
+}
+
 func launchConnectorDaemon(ctx context.Context, connectorDaemon string, maybeStart bool) (conn *grpc.ClientConn, err error) {
+	if env := client.GetEnv(ctx); env != nil && env.UserDaemonAddress != "" {
+		// The user daemon is managed elsewhere, so it's never started here.
+		return dialConnectorTCP(ctx, env)
+	}
+	if windowsBinary := client.WSLWindowsBinary(ctx); windowsBinary != "" {
+		return dialConnectorWSL(ctx, windowsBinary, maybeStart)
+	}
 	for {
-		conn, err = client.DialSocket(ctx, client.ConnectorSocketName)
+		conn, err = client.DialSocket(ctx, client.ConnectorSocketName(ctx))
 		if err == nil {
 			return conn, nil
 		}
@@ -77,14 +127,23 @@ func launchConnectorDaemon(ctx context.Context, connectorDaemon string, maybeSta
 			err = ErrNoUserDaemon
 			if maybeStart {
 				stdout, _ := output.Structured(ctx)
-				fmt.Fprintln(stdout, "Launching Telepresence User Daemon")
+				if _, err = cache.LoadSessionStateFromUserCache(ctx); err == nil {
+					fmt.Fprintln(stdout, "Launching Telepresence User Daemon to restore the session of a user daemon that quit unexpectedly")
+				} else {
+					fmt.Fprintln(stdout, "Launching Telepresence User Daemon")
+				}
 				if _, err = ensureAppUserConfigDir(ctx); err != nil {
 					return nil, err
 				}
-				if err = proc.StartInBackground(connectorDaemon, "connector-foreground"); err != nil {
+				args := []string{connectorDaemon, "connector-foreground"}
+				if client.IsOffline(ctx) && connectorDaemon == client.GetExe() {
+					// Other user daemon binaries only obey the offline setting of the config
+					args = append(args, "--offline")
+				}
+				if err = proc.StartInBackground(args...); err != nil {
 					return nil, fmt.Errorf("failed to launch the connector service: %w", err)
 				}
-				if err = client.WaitUntilSocketAppears("connector", client.ConnectorSocketName, 10*time.Second); err != nil {
+				if err = client.WaitUntilSocketAppears("connector", client.ConnectorSocketName(ctx), 10*time.Second); err != nil {
 					return nil, fmt.Errorf("connector service did not start: %w", err)
 				}
 				maybeStart = false
@@ -95,6 +154,12 @@ func launchConnectorDaemon(ctx context.Context, connectorDaemon string, maybeSta
 	}
 }
 
+// DialConnector establishes a connection to a running connector. It returns ErrNoUserDaemon if the connector isn't
+// running. It's the caller's responsibility to close the returned connection.
+func DialConnector(ctx context.Context) (*grpc.ClientConn, error) {
+	return launchConnectorDaemon(ctx, "", false)
+}
+
 func withConnector(ctx context.Context, maybeStart bool, withNotify bool, fn func(context.Context, connector.ConnectorClient) error) error {
 	if conn := getConnectorConn(ctx); conn != nil {
 		connectorClient := connector.NewConnectorClient(conn)
@@ -191,7 +256,7 @@ func UserDaemonDisconnect(ctx context.Context, quitUserDaemon bool) error {
 			// Disconnect is not implemented so daemon predates 2.4.9. Force a quit
 		}
 		if _, err = connectorClient.Quit(ctx, &empty.Empty{}); err == nil || grpcStatus.Code(err) == grpcCodes.Unavailable {
-			err = client.WaitUntilSocketVanishes("user daemon", client.ConnectorSocketName, 5*time.Second)
+			err = client.WaitUntilSocketVanishes("user daemon", client.ConnectorSocketName(ctx), 5*time.Second)
 		}
 		return err
 	})
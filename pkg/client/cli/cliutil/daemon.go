@@ -13,6 +13,7 @@ import (
 	"google.golang.org/grpc/status"
 	empty "google.golang.org/protobuf/types/known/emptypb"
 
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
 	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/output"
@@ -27,38 +28,37 @@ func launchDaemon(ctx context.Context) error {
 	stdout, _ := output.Structured(ctx)
 	fmt.Fprintln(stdout, "Launching Telepresence Root Daemon")
 
-	// Ensure that the logfile is present before the daemon starts so that it isn't created with
-	// root permissions.
-	logDir, err := filelocation.AppUserLogDir(ctx)
+	logDir, err := ensureDaemonLogFile(ctx)
 	if err != nil {
 		return err
 	}
-	logFile := filepath.Join(logDir, "daemon.log")
-	if _, err := os.Stat(logFile); err != nil {
-		if !os.IsNotExist(err) {
-			return err
-		}
-		if err = os.MkdirAll(logDir, 0700); err != nil {
-			return err
-		}
-		fh, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY, 0600)
-		if err != nil {
-			return err
-		}
-		_ = fh.Close()
-	}
-
 	configDir, err := ensureAppUserConfigDir(ctx)
 	if err != nil {
 		return err
 	}
-	return proc.StartInBackgroundAsRoot(ctx, client.GetExe(), "daemon-foreground", logDir, configDir)
+
+	// A daemon service that has been installed with "telepresence service install" is started without
+	// elevation.
+	if started, err := startDaemonService(ctx); started || err != nil {
+		return err
+	}
+
+	cfg := client.GetConfig(ctx).Daemons
+	elevation := proc.Elevation{Command: cfg.ElevationCommand, Prompt: cfg.ElevationPrompt}
+	args := []string{client.GetExe(), "daemon-foreground", logDir, configDir}
+	if client.IsOffline(ctx) {
+		args = append(args, "--offline")
+	}
+	return proc.StartInBackgroundAsRoot(ctx, elevation, args...)
 }
 
 // WithNetwork (1) ensures that the daemon is running, (2) establishes a connection to it, and (3)
 // runs the given function with that connection.
 //
 // Nested calls to WithNetwork will reuse the outer connection.
+//
+// The function is called with a nil daemon.DaemonClient when the CLI uses a remote user daemon, or the daemons
+// of the Windows host of a WSL2 distribution, because the root daemon then runs on the same host as that user daemon.
 func WithNetwork(ctx context.Context, fn func(context.Context, daemon.DaemonClient) error) error {
 	return withNetwork(ctx, true, fn)
 }
@@ -70,6 +70,12 @@ func WithStartedNetwork(ctx context.Context, fn func(context.Context, daemon.Dae
 }
 
 func withNetwork(ctx context.Context, maybeStart bool, fn func(context.Context, daemon.DaemonClient) error) error {
+	if usesRemoteDaemons(ctx) {
+		if !maybeStart {
+			return ErrNoNetwork
+		}
+		return fn(ctx, nil)
+	}
 	type daemonConnCtxKey struct{}
 	if untyped := ctx.Value(daemonConnCtxKey{}); untyped != nil {
 		conn := untyped.(*grpc.ClientConn)
@@ -81,7 +87,7 @@ func withNetwork(ctx context.Context, maybeStart bool, fn func(context.Context,
 	started := false
 	for {
 		var err error
-		conn, err = client.DialSocket(ctx, client.DaemonSocketName)
+		conn, err = client.DialSocket(ctx, client.DaemonSocketName(ctx))
 		if err == nil {
 			break
 		}
@@ -92,7 +98,7 @@ func withNetwork(ctx context.Context, maybeStart bool, fn func(context.Context,
 					return fmt.Errorf("failed to launch the daemon service: %w", err)
 				}
 
-				if err = client.WaitUntilSocketAppears("daemon", client.DaemonSocketName, 10*time.Second); err != nil {
+				if err = client.WaitUntilSocketAppears("daemon", client.DaemonSocketName(ctx), 10*time.Second); err != nil {
 					return fmt.Errorf("daemon service did not start: %w", err)
 				}
 
@@ -117,8 +123,35 @@ func withNetwork(ctx context.Context, maybeStart bool, fn func(context.Context,
 	return fn(ctx, daemonClient)
 }
 
+// usesRemoteDaemons returns true when the CLI uses daemons that it neither starts nor reaches using their sockets.
+func usesRemoteDaemons(ctx context.Context) bool {
+	if env := client.GetEnv(ctx); env != nil && env.UserDaemonAddress != "" {
+		return true
+	}
+	return client.WSLWindowsBinary(ctx) != ""
+}
+
 type quitting struct{}
 
+// WithUserDaemonSession returns a context that makes calls to the root daemon concern the session of the running
+// user daemon. The root daemon can host the sessions of several user daemons, so a call that doesn't identify its
+// session could concern the session of another user daemon. The context is returned as is when the user daemon
+// isn't running or has no session.
+func WithUserDaemonSession(ctx context.Context) context.Context {
+	var id string
+	_ = WithStartedConnector(ctx, false, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
+		ci, err := connectorClient.Status(ctx, &empty.Empty{})
+		if err == nil {
+			id = ci.GetSessionInfo().GetSessionId()
+		}
+		return err
+	})
+	if id == "" {
+		return ctx
+	}
+	return client.WithRootDaemonSession(ctx, id)
+}
+
 // Disconnect shuts down a session in the root daemon. When it shuts down, it will tell the connector to shut down.
 func Disconnect(ctx context.Context, quitUserDaemon, quitRootDaemon bool) (err error) {
 	stdout, stderr := output.Structured(ctx)
@@ -134,11 +167,16 @@ func Disconnect(ctx context.Context, quitUserDaemon, quitRootDaemon bool) (err e
 			}
 		}
 		if err == nil && quitRootDaemon {
-			err = client.WaitUntilSocketVanishes("root daemon", client.DaemonSocketName, 5*time.Second)
+			err = client.WaitUntilSocketVanishes("root daemon", client.DaemonSocketName(ctx), 5*time.Second)
 		}
 	}()
 	fmt.Fprint(stdout, "Telepresence Network ")
-	err = WithStartedNetwork(ctx, func(ctx context.Context, daemonClient daemon.DaemonClient) (err error) {
+	rctx := ctx
+	if !quitRootDaemon {
+		// Only the session of this user daemon is disconnected.
+		rctx = WithUserDaemonSession(ctx)
+	}
+	err = WithStartedNetwork(rctx, func(ctx context.Context, daemonClient daemon.DaemonClient) (err error) {
 		defer func() {
 			if err == nil {
 				fmt.Fprintln(stdout, "done")
@@ -175,6 +213,71 @@ func Disconnect(ctx context.Context, quitUserDaemon, quitRootDaemon bool) (err e
 	return err
 }
 
+// ForceCleanup restores the network snapshots that a root daemon, which didn't get a chance to restore them, left
+// behind. The snapshots are restored by a process with elevated privileges, and ForceCleanup waits until it's done.
+// The root daemon must not be running when this function is called.
+func ForceCleanup(ctx context.Context) error {
+	stdout, _ := output.Structured(ctx)
+	fmt.Fprint(stdout, "Telepresence Network ")
+	snapshots, err := client.NetworkSnapshots(ctx)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) == 0 {
+		fmt.Fprintln(stdout, "has nothing to clean up")
+		return nil
+	}
+	fmt.Fprint(stdout, "cleaning up...")
+	logDir, err := ensureDaemonLogFile(ctx)
+	if err != nil {
+		return err
+	}
+	configDir, err := ensureAppUserConfigDir(ctx)
+	if err != nil {
+		return err
+	}
+	cfg := client.GetConfig(ctx).Daemons
+	elevation := proc.Elevation{Command: cfg.ElevationCommand, Prompt: cfg.ElevationPrompt}
+	if err = proc.StartInBackgroundAsRoot(ctx, elevation, client.GetExe(), "daemon-cleanup", logDir, configDir); err != nil {
+		return err
+	}
+	giveUp := time.Now().Add(30 * time.Second)
+	for giveUp.After(time.Now()) {
+		if snapshots, err = client.NetworkSnapshots(ctx); err != nil || len(snapshots) == 0 {
+			if err == nil {
+				fmt.Fprintln(stdout, "done")
+			}
+			return err
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	return errors.New("timeout while waiting for the network configuration to be restored")
+}
+
+// ensureDaemonLogFile ensures that the logfile is present before the daemon starts so that it isn't created with
+// root permissions, and returns the directory of the logfile.
+func ensureDaemonLogFile(ctx context.Context) (string, error) {
+	logDir, err := filelocation.AppUserLogDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	logFile := filepath.Join(logDir, "daemon.log")
+	if _, err := os.Stat(logFile); err != nil {
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		if err = os.MkdirAll(logDir, 0700); err != nil {
+			return "", err
+		}
+		fh, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return "", err
+		}
+		_ = fh.Close()
+	}
+	return logDir, nil
+}
+
 func ensureAppUserConfigDir(ctx context.Context) (string, error) {
 	configDir, err := filelocation.AppUserConfigDir(ctx)
 	if err != nil {
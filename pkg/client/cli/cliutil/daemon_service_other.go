@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package cliutil
+
+import (
+	"context"
+)
+
+// startDaemonService returns false. The daemon only runs as a service on Windows.
+func startDaemonService(context.Context) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,207 @@
+package cliutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+)
+
+// serviceSecurityFormat is a security descriptor, formatted with the SID of the user, that grants full access to
+// the daemon service to SYSTEM and the Administrators, and permits the user to query, start, and stop it.
+// For more info about the syntax, see:
+// https://docs.microsoft.com/en-us/windows/win32/secauthz/security-descriptor-string-format
+const serviceSecurityFormat = "D:(A;;CCLCSWRPWPDTLOCRRC;;;SY)(A;;CCDCLCSWRPWPDTLOCRSDRCWDWO;;;BA)(A;;CCLCSWRPWPDTLOCRRC;;;%s)"
+
+// startDaemonService starts the daemon service of the current user. It returns false if no such service is
+// installed, or if the user isn't permitted to start it, in which case the daemon must be started with elevation.
+// The service isn't started unless its binary can only be modified by SYSTEM and the Administrators.
+func startDaemonService(ctx context.Context) (bool, error) {
+	name, err := client.DaemonServiceName(ctx)
+	if err != nil {
+		return false, err
+	}
+	namePtr, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return false, err
+	}
+	h, err := windows.OpenSCManager(nil, nil, windows.SC_MANAGER_CONNECT)
+	if err != nil {
+		dlog.Debugf(ctx, "unable to connect to the service control manager: %v", err)
+		return false, nil
+	}
+	defer func() {
+		_ = windows.CloseServiceHandle(h)
+	}()
+	sh, err := windows.OpenService(h, namePtr, windows.SERVICE_START|windows.SERVICE_QUERY_STATUS|windows.SERVICE_QUERY_CONFIG)
+	if err != nil {
+		if !errors.Is(err, windows.ERROR_SERVICE_DOES_NOT_EXIST) {
+			dlog.Debugf(ctx, "unable to open service %s: %v", name, err)
+		}
+		return false, nil
+	}
+	s := &mgr.Service{Name: name, Handle: sh}
+	defer s.Close()
+
+	cfg, err := s.Config()
+	if err != nil {
+		return true, fmt.Errorf("unable to get the configuration of service %s: %w", name, err)
+	}
+	if err = checkServiceBinary(serviceBinaryPath(cfg.BinaryPathName)); err != nil {
+		return true, errcat.User.Newf("refusing to start service %s, please reinstall it: %w", name, err)
+	}
+
+	var args []string
+	if client.IsOffline(ctx) {
+		args = append(args, "--offline")
+	}
+	if err = s.Start(args...); err != nil && !errors.Is(err, windows.ERROR_SERVICE_ALREADY_RUNNING) {
+		return true, fmt.Errorf("unable to start service %s: %w", name, err)
+	}
+	return true, nil
+}
+
+// InstallDaemonService installs a Windows service that runs the root daemon of the current user. The service
+// is started by the CLI when the daemon is needed, so that the user only sees a UAC prompt once, when the
+// service is installed. The command must run in an elevated prompt of the user who will use the service.
+//
+// The service runs a copy of the current binary that is installed in a directory that only SYSTEM and the
+// Administrators can modify, so the service must be reinstalled to make it use an upgraded binary.
+func InstallDaemonService(ctx context.Context) error {
+	if !proc.IsAdmin() {
+		return errcat.User.New("the daemon service can only be installed from a prompt that runs as Administrator")
+	}
+	logDir, err := ensureDaemonLogFile(ctx)
+	if err != nil {
+		return err
+	}
+	configDir, err := ensureAppUserConfigDir(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The daemon finds the pipes of its user by looking up the owner of the config directory, so the service is
+	// installed for that user.
+	if ctx, err = client.WithSocketUserOfDir(ctx, configDir); err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("unable to connect to the service control manager: %w", err)
+	}
+	defer func() {
+		_ = m.Disconnect()
+	}()
+
+	sid, err := client.SocketUserSID(ctx)
+	if err != nil {
+		return err
+	}
+	name, err := client.DaemonServiceName(ctx)
+	if err != nil {
+		return err
+	}
+	if s, err := m.OpenService(name); err == nil {
+		s.Close()
+		return errcat.User.Newf("service %s is already installed", name)
+	}
+	binDir, err := serviceBinaryDir(sid)
+	if err != nil {
+		return err
+	}
+	exe, err := installServiceBinary(client.GetExe(), binDir)
+	if err != nil {
+		_ = os.RemoveAll(binDir)
+		return fmt.Errorf("unable to install the binary of service %s: %w", name, err)
+	}
+	s, err := m.CreateService(name, exe, mgr.Config{
+		DisplayName: "Telepresence Root Daemon",
+		Description: "Manages the network of the Telepresence sessions of the user with SID " + sid,
+		StartType:   mgr.StartManual,
+	}, "daemon-service", logDir, configDir)
+	if err != nil {
+		_ = os.RemoveAll(binDir)
+		return fmt.Errorf("unable to create service %s: %w", name, err)
+	}
+	defer s.Close()
+
+	if err = setServiceSecurity(s, sid); err != nil {
+		_ = s.Delete()
+		_ = os.RemoveAll(binDir)
+		return fmt.Errorf("unable to grant the user access to service %s: %w", name, err)
+	}
+	return nil
+}
+
+func setServiceSecurity(s *mgr.Service, sid string) error {
+	sd, err := windows.SecurityDescriptorFromString(fmt.Sprintf(serviceSecurityFormat, sid))
+	if err != nil {
+		return err
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil {
+		return err
+	}
+	return windows.SetSecurityInfo(s.Handle, windows.SE_SERVICE, windows.DACL_SECURITY_INFORMATION, nil, nil, dacl, nil)
+}
+
+// UninstallDaemonService stops and removes the daemon service of the current user, and the binary that it runs.
+func UninstallDaemonService(ctx context.Context) error {
+	if !proc.IsAdmin() {
+		return errcat.User.New("the daemon service can only be uninstalled from a prompt that runs as Administrator")
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("unable to connect to the service control manager: %w", err)
+	}
+	defer func() {
+		_ = m.Disconnect()
+	}()
+
+	sid, err := client.SocketUserSID(ctx)
+	if err != nil {
+		return err
+	}
+	name, err := client.DaemonServiceName(ctx)
+	if err != nil {
+		return err
+	}
+	s, err := m.OpenService(name)
+	if err != nil {
+		if errors.Is(err, windows.ERROR_SERVICE_DOES_NOT_EXIST) {
+			return errcat.User.Newf("service %s is not installed", name)
+		}
+		return fmt.Errorf("unable to open service %s: %w", name, err)
+	}
+	defer s.Close()
+
+	if st, err := s.Control(svc.Stop); err == nil {
+		for giveUp := time.Now().Add(10 * time.Second); st.State != svc.Stopped && time.Now().Before(giveUp); {
+			time.Sleep(250 * time.Millisecond)
+			if st, err = s.Query(); err != nil {
+				break
+			}
+		}
+	}
+	if err = s.Delete(); err != nil {
+		return fmt.Errorf("unable to delete service %s: %w", name, err)
+	}
+	binDir, err := serviceBinaryDir(sid)
+	if err != nil {
+		return err
+	}
+	if err = os.RemoveAll(binDir); err != nil {
+		return fmt.Errorf("unable to remove the binary of service %s: %w", name, err)
+	}
+	return nil
+}
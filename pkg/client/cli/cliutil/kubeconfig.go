@@ -0,0 +1,42 @@
+package cliutil
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+// AddKubeconfigEnv adds the kubeconfig settings of the calling process to the given request.
+//
+// Certain options' default are bound to the connector daemon process; this is notably true of the kubeconfig file(s) to use,
+// and since those files can be specified, both as a --kubeconfig flag and in the KUBECONFIG setting, and since the flag won't
+// accept multiple path entries, we need to pass the environment setting to the connector daemon so that it can set it every
+// time it receives a new config.
+//
+// The daemon doesn't share the working directory of the CLI, so relative paths are made absolute.
+func AddKubeconfigEnv(cr *connector.ConnectRequest) {
+	if kc, ok := cr.KubeFlags["kubeconfig"]; ok {
+		cr.KubeFlags["kubeconfig"] = absPath(kc)
+	}
+	if cfg, ok := os.LookupEnv("KUBECONFIG"); ok {
+		if cr.KubeFlags == nil {
+			cr.KubeFlags = make(map[string]string)
+		}
+		kcs := filepath.SplitList(cfg)
+		for i, kc := range kcs {
+			kcs[i] = absPath(kc)
+		}
+		cr.KubeFlags["KUBECONFIG"] = strings.Join(kcs, string(os.PathListSeparator))
+	}
+}
+
+func absPath(path string) string {
+	if path != "" {
+		if ap, err := filepath.Abs(path); err == nil {
+			return ap
+		}
+	}
+	return path
+}
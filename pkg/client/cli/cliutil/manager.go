@@ -14,3 +14,13 @@ func WithManager(ctx context.Context, fn func(context.Context, manager.ManagerCl
 		return fn(ctx, managerClient)
 	})
 }
+
+// WithStartedManager is like WithManager, but returns ErrNoUserDaemon if the connector is not
+// already running, rather than starting it.
+func WithStartedManager(ctx context.Context, fn func(context.Context, manager.ManagerClient) error) error {
+	return WithStartedConnector(ctx, false, func(ctx context.Context, _ connector.ConnectorClient) error {
+		conn := getConnectorConn(ctx)
+		managerClient := manager.NewManagerClient(conn)
+		return fn(ctx, managerClient)
+	})
+}
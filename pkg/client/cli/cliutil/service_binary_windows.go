@@ -0,0 +1,255 @@
+package cliutil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// The binary of the daemon service runs as LocalSystem, so it's installed in a directory of its own that only SYSTEM
+// and the Administrators can modify. Running the binary that the CLI was started from would let anyone who can
+// write to that binary, e.g. the user when it's installed in the home directory, run code as LocalSystem.
+const (
+	// serviceDirSecurity is the security descriptor of the directory of the service binary. The DACL is protected,
+	// so that nothing is inherited from the parent directory, and the users may only read and execute.
+	serviceDirSecurity = "O:BAD:P(A;OICI;FA;;;SY)(A;OICI;FA;;;BA)(A;OICI;0x1200a9;;;BU)"
+
+	// serviceFileSecurity is the security descriptor of the files in the directory of the service binary.
+	serviceFileSecurity = "O:BAD:P(A;;FA;;;SY)(A;;FA;;;BA)(A;;0x1200a9;;;BU)"
+
+	// trustedInstallerSID is the SID of the TrustedInstaller service, which owns the files of Windows.
+	trustedInstallerSID = "S-1-5-80-956008885-3418522649-1831038044-1853292631-2271478464"
+
+	fileDeleteChild = 0x40
+
+	// writeAccessMask is the access rights that permit a change of a file or directory, or of its security.
+	writeAccessMask = windows.GENERIC_ALL | windows.GENERIC_WRITE | windows.WRITE_DAC | windows.WRITE_OWNER | windows.DELETE |
+		windows.FILE_WRITE_DATA | windows.FILE_APPEND_DATA | windows.FILE_WRITE_EA | windows.FILE_WRITE_ATTRIBUTES |
+		fileDeleteChild
+
+	// replaceAccessMask is the access rights that permit the replacement of a directory or of its entries. The right to
+	// add entries to the directory is harmless for the directories above the one of the binary, e.g. everyone may
+	// create directories in the root of the system drive.
+	replaceAccessMask = windows.GENERIC_ALL | windows.WRITE_DAC | windows.WRITE_OWNER | windows.DELETE | fileDeleteChild
+
+	accessAllowedACEType = 0
+	inheritOnlyACEFlag   = 0x08
+)
+
+// serviceBinaryFiles are the files that are installed along with the service binary. The wintun.dll must be next to
+// the binary, because the TUN device is created using it.
+var serviceBinaryFiles = []string{"wintun.dll"}
+
+// serviceBinaryDir returns the directory that the binary of the daemon service of the user with the given SID is
+// installed in.
+func serviceBinaryDir(sid string) (string, error) {
+	pf, err := windows.KnownFolderPath(windows.FOLDERID_ProgramFiles, 0)
+	if err != nil {
+		return "", fmt.Errorf("unable to find the Program Files directory: %w", err)
+	}
+	return filepath.Join(pf, "Telepresence", "service", sid), nil
+}
+
+// installServiceBinary copies the given binary, and the serviceBinaryFiles next to it, to the given directory, and
+// makes them only modifiable by SYSTEM and the Administrators. It returns the path of the installed binary.
+func installServiceBinary(exe, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	if err := setProtectedSecurity(dir, serviceDirSecurity); err != nil {
+		return "", err
+	}
+	files := []string{exe}
+	for _, f := range serviceBinaryFiles {
+		src := filepath.Join(filepath.Dir(exe), f)
+		if _, err := os.Stat(src); err == nil {
+			files = append(files, src)
+		}
+	}
+	for _, src := range files {
+		dst := filepath.Join(dir, filepath.Base(src))
+		if err := copyFile(src, dst); err != nil {
+			return "", err
+		}
+		if err := setProtectedSecurity(dst, serviceFileSecurity); err != nil {
+			return "", err
+		}
+	}
+	installed := filepath.Join(dir, filepath.Base(exe))
+	if err := checkServiceBinary(installed); err != nil {
+		return "", err
+	}
+	return installed, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func setProtectedSecurity(path, sddl string) error {
+	sd, err := windows.SecurityDescriptorFromString(sddl)
+	if err != nil {
+		return err
+	}
+	owner, _, err := sd.Owner()
+	if err != nil {
+		return err
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil {
+		return err
+	}
+	err = windows.SetNamedSecurityInfo(path, windows.SE_FILE_OBJECT,
+		windows.OWNER_SECURITY_INFORMATION|windows.DACL_SECURITY_INFORMATION|windows.PROTECTED_DACL_SECURITY_INFORMATION,
+		owner, nil, dacl, nil)
+	if err != nil {
+		return fmt.Errorf("unable to set the security of %s: %w", path, err)
+	}
+	return nil
+}
+
+// checkServiceBinary returns an error unless the given binary and its directory can only be modified by SYSTEM, the
+// Administrators, and TrustedInstaller, and the directories above it can only be replaced by them.
+func checkServiceBinary(exe string) error {
+	dir := filepath.Dir(exe)
+	for _, path := range []string{exe, dir} {
+		if err := checkProtected(path, writeAccessMask); err != nil {
+			return err
+		}
+	}
+	for path := filepath.Dir(dir); ; {
+		if err := checkProtected(path, replaceAccessMask); err != nil {
+			return err
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return nil
+		}
+		path = parent
+	}
+}
+
+func checkProtected(path string, mask uint32) error {
+	sd, err := windows.GetNamedSecurityInfo(path, windows.SE_FILE_OBJECT,
+		windows.OWNER_SECURITY_INFORMATION|windows.DACL_SECURITY_INFORMATION)
+	if err != nil {
+		return fmt.Errorf("unable to get the security of %s: %w", path, err)
+	}
+	if err = checkProtectedSecurity(sd, mask); err != nil {
+		return fmt.Errorf("%s can't be used by the daemon service: %w", path, err)
+	}
+	return nil
+}
+
+// checkProtectedSecurity returns an error unless the given security descriptor only grants SYSTEM, the
+// Administrators, and TrustedInstaller the rights of the given mask, and one of them owns the object.
+func checkProtectedSecurity(sd *windows.SECURITY_DESCRIPTOR, mask uint32) error {
+	owner, _, err := sd.Owner()
+	if err != nil {
+		return err
+	}
+	if owner == nil || !isTrustedSID(owner) {
+		return fmt.Errorf("it's owned by %s", owner)
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil {
+		return err
+	}
+	if dacl == nil {
+		return errors.New("it has no DACL, which grants everyone full access")
+	}
+	return forEachAllowedACE(dacl, func(flags byte, aceMask uint32, sid *windows.SID) error {
+		if flags&inheritOnlyACEFlag == 0 && aceMask&mask != 0 && !isTrustedSID(sid) {
+			return fmt.Errorf("%s may modify it", sid)
+		}
+		return nil
+	})
+}
+
+var trustedSIDs = []windows.WELL_KNOWN_SID_TYPE{windows.WinLocalSystemSid, windows.WinBuiltinAdministratorsSid}
+
+func isTrustedSID(sid *windows.SID) bool {
+	for _, wk := range trustedSIDs {
+		if sid.IsWellKnown(wk) {
+			return true
+		}
+	}
+	return sid.String() == trustedInstallerSID
+}
+
+// aceHeader is the ACE_HEADER that all ACEs start with.
+type aceHeader struct {
+	aceType  byte
+	aceFlags byte
+	aceSize  uint16
+}
+
+// aclHeader is the layout of the windows.ACL, which doesn't export its fields.
+type aclHeader struct {
+	aclRevision byte
+	sbz1        byte
+	aclSize     uint16
+	aceCount    uint16
+	sbz2        uint16
+}
+
+// forEachAllowedACE calls the given function with the flags, the access mask, and the SID of each
+// ACCESS_ALLOWED_ACE of the given ACL. Other ACEs only deny access, or are about auditing, so they can't grant
+// anyone the right to modify the object.
+func forEachAllowedACE(acl *windows.ACL, f func(flags byte, mask uint32, sid *windows.SID) error) error {
+	hdr := (*aclHeader)(unsafe.Pointer(acl))
+	size := uintptr(hdr.aclSize)
+	offset := unsafe.Sizeof(*hdr)
+	for i := uint16(0); i < hdr.aceCount; i++ {
+		if offset+unsafe.Sizeof(aceHeader{}) > size {
+			return errors.New("the DACL is truncated")
+		}
+		p := unsafe.Add(unsafe.Pointer(acl), offset)
+		ace := (*aceHeader)(p)
+		if ace.aceSize < uint16(unsafe.Sizeof(aceHeader{})) || offset+uintptr(ace.aceSize) > size {
+			return errors.New("the DACL is truncated")
+		}
+		if ace.aceType == accessAllowedACEType {
+			// ACCESS_ALLOWED_ACE is the header, followed by the mask and then the SID.
+			mask := *(*uint32)(unsafe.Add(p, unsafe.Sizeof(*ace)))
+			sid := (*windows.SID)(unsafe.Add(p, unsafe.Sizeof(*ace)+unsafe.Sizeof(mask)))
+			if err := f(ace.aceFlags, mask, sid); err != nil {
+				return err
+			}
+		}
+		offset += uintptr(ace.aceSize)
+	}
+	return nil
+}
+
+// serviceBinaryPath returns the path of the binary in the given command line of a service.
+func serviceBinaryPath(cmdLine string) string {
+	if strings.HasPrefix(cmdLine, `"`) {
+		if end := strings.IndexByte(cmdLine[1:], '"'); end >= 0 {
+			return cmdLine[1 : end+1]
+		}
+	}
+	if sp := strings.IndexByte(cmdLine, ' '); sp >= 0 {
+		return cmdLine[:sp]
+	}
+	return cmdLine
+}
@@ -0,0 +1,49 @@
+package cliutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/windows"
+)
+
+func Test_checkProtectedSecurity(t *testing.T) {
+	tests := []struct {
+		name    string
+		sddl    string
+		mask    uint32
+		protect bool
+	}{
+		{"service file", serviceFileSecurity, writeAccessMask, true},
+		{"service dir", serviceDirSecurity, writeAccessMask, true},
+		{"trusted installer", "O:S-1-5-80-956008885-3418522649-1831038044-1853292631-2271478464D:P(A;;FA;;;SY)(A;;0x1200a9;;;BU)", writeAccessMask, true},
+		{"owned by users", "O:BUD:P(A;;FA;;;SY)(A;;0x1200a9;;;BU)", writeAccessMask, false},
+		{"writable by users", "O:BAD:P(A;;FA;;;SY)(A;;FA;;;BU)", writeAccessMask, false},
+		{"appendable by authenticated users", "O:BAD:P(A;;FA;;;SY)(A;;0x4;;;AU)", writeAccessMask, false},
+		{"subdirs by authenticated users", "O:BAD:P(A;;FA;;;SY)(A;;0x4;;;AU)", replaceAccessMask, true},
+		{"deletable by everyone", "O:BAD:P(A;;FA;;;SY)(A;;SD;;;WD)", replaceAccessMask, false},
+		{"inherit only", "O:BAD:P(A;;FA;;;SY)(A;OICIIO;FA;;;CO)", writeAccessMask, true},
+		{"denied", "O:BAD:P(A;;FA;;;SY)(D;;FA;;;BU)", writeAccessMask, true},
+		{"null DACL", "O:BAD:NO_ACCESS_CONTROL", writeAccessMask, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sd, err := windows.SecurityDescriptorFromString(tt.sddl)
+			require.NoError(t, err)
+			err = checkProtectedSecurity(sd, tt.mask)
+			if tt.protect {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func Test_serviceBinaryPath(t *testing.T) {
+	assert.Equal(t, `C:\Program Files\Telepresence\telepresence.exe`,
+		serviceBinaryPath(`"C:\Program Files\Telepresence\telepresence.exe" daemon-service C:\logs C:\config`))
+	assert.Equal(t, `C:\telepresence\telepresence.exe`, serviceBinaryPath(`C:\telepresence\telepresence.exe daemon-service`))
+	assert.Equal(t, `C:\telepresence\telepresence.exe`, serviceBinaryPath(`C:\telepresence\telepresence.exe`))
+}
@@ -15,7 +15,6 @@ import (
 	grpcCodes "google.golang.org/grpc/codes"
 	grpcStatus "google.golang.org/grpc/status"
 	empty "google.golang.org/protobuf/types/known/emptypb"
-	"gopkg.in/yaml.v2"
 
 	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/rpc/v2/connector"
@@ -33,6 +32,9 @@ import (
 // login.  If the `apikey` argument is empty an interactive login is performed; if it is non-empty
 // the key is used instead of performing an interactive login.
 func EnsureLoggedIn(ctx context.Context, apikey string) (connector.LoginResult_Code, error) {
+	if err := client.CheckOnline(ctx, "login to Ambassador Cloud"); err != nil {
+		return connector.LoginResult_UNSPECIFIED, err
+	}
 	err := GetTelepresencePro(ctx)
 	if err != nil {
 		return connector.LoginResult_UNSPECIFIED, err
@@ -48,6 +50,9 @@ func EnsureLoggedIn(ctx context.Context, apikey string) (connector.LoginResult_C
 
 // ClientEnsureLoggedIn is like EnsureLoggedIn but uses an already acquired ConnectorClient.
 func ClientEnsureLoggedIn(ctx context.Context, apikey string, connectorClient connector.ConnectorClient) (connector.LoginResult_Code, error) {
+	if err := client.CheckOnline(ctx, "login to Ambassador Cloud"); err != nil {
+		return connector.LoginResult_UNSPECIFIED, err
+	}
 	resp, err := connectorClient.Login(ctx, &connector.LoginRequest{
 		ApiKey: apikey,
 	})
@@ -99,6 +104,11 @@ func HasLoggedIn(ctx context.Context) bool {
 }
 
 func GetCloudUserInfo(ctx context.Context, autoLogin bool, refresh bool) (*connector.UserInfo, error) {
+	if autoLogin || refresh {
+		if err := client.CheckOnline(ctx, "Ambassador Cloud"); err != nil {
+			return nil, err
+		}
+	}
 	var userInfo *connector.UserInfo
 	err := WithConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
 		var err error
@@ -115,6 +125,9 @@ func GetCloudUserInfo(ctx context.Context, autoLogin bool, refresh bool) (*conne
 }
 
 func GetCloudAPIKey(ctx context.Context, description string, autoLogin bool) (string, error) {
+	if err := client.CheckOnline(ctx, "Ambassador Cloud"); err != nil {
+		return "", err
+	}
 	var keyData *connector.KeyData
 	err := WithConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
 		var err error
@@ -134,6 +147,9 @@ func GetCloudAPIKey(ctx context.Context, description string, autoLogin bool) (st
 // license, puts it in a kubernetes secret, and then writes that secret to the
 // output file for the user to apply to their cluster
 func GetCloudLicense(ctx context.Context, outputFile, id string) (string, string, error) {
+	if err := client.CheckOnline(ctx, "Ambassador Cloud"); err != nil {
+		return "", "", err
+	}
 	var licenseData *connector.LicenseData
 	err := WithConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
 		var err error
@@ -226,6 +242,9 @@ func installTelepresencePro(ctx context.Context, telProLocation string) error {
 	// We install the correct version of telepresence-pro based on
 	// the OSS version that is associated with this client since
 	// daemon versions need to match
+	if err := client.CheckOnline(ctx, "installation of the enhanced free client"); err != nil {
+		return err
+	}
 	clientVersion := strings.Trim(client.Version(), "v")
 	systemAHost := client.GetConfig(ctx).Cloud.SystemaHost
 	downloadURL := fmt.Sprintf("https://%s/download/tel-pro/%s/%s/%s/latest/%s",
@@ -327,22 +346,12 @@ func updateConfig(ctx context.Context, telProLocation string) error {
 		dlog.Infof(ctx, "Updating %s, changing Daemons.UserDaemonBinary from %s to %s", cfgFile, cfg.Daemons.UserDaemonBinary, telProLocation)
 	}
 
-	cfg.Daemons.UserDaemonBinary = telProLocation
-	b, err := yaml.Marshal(cfg)
-	if err != nil {
-		return errcat.NoDaemonLogs.Newf("error marshaling updating config: %w", err)
-	}
-	if s, err := os.Stat(cfgFile); err == nil && s.Size() > 0 {
-		_ = os.Rename(cfgFile, cfgFile+".bak")
-	}
-
-	f, err := os.OpenFile(cfgFile, os.O_CREATE|os.O_WRONLY, 0644)
+	err := client.UpdateConfig(ctx, func(fileCfg *client.Config) error {
+		fileCfg.Daemons.UserDaemonBinary = telProLocation
+		return nil
+	})
 	if err != nil {
-		return errcat.NoDaemonLogs.Newf("error opening config file: %w", err)
-	}
-	defer f.Close()
-	if _, err = f.Write(b); err != nil {
-		return errcat.NoDaemonLogs.Newf("error writing config file: %w", err)
+		return errcat.NoDaemonLogs.Newf("error updating config file: %w", err)
 	}
 	return nil
 }
@@ -100,6 +100,9 @@ func (cmc *cloudMessageCache) getMessageFromCache(ctx context.Context, cmdUsed s
 // for raising the message for the command used.
 func raiseCloudMessage(cmd *cobra.Command, _ []string) error {
 	ctx := cmd.Context()
+	if client.IsOffline(ctx) {
+		return nil
+	}
 	// Currently, we only have messages that should be served when a user
 	// isn't logged in, so we check that here
 	if cliutil.HasLoggedIn(cmd.Context()) {
@@ -10,6 +10,7 @@ import (
 	"github.com/spf13/pflag"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 )
@@ -118,10 +119,11 @@ func Command(ctx context.Context) *cobra.Command {
 
 	rootCmd.InitDefaultHelpCmd()
 	static := cliutil.CommandGroups{
-		"Session Commands": []*cobra.Command{connectCommand(), LoginCommand(), LogoutCommand(), LicenseCommand(), statusCommand(), quitCommand()},
-		"Traffic Commands": []*cobra.Command{listCommand(), interceptCommand(ctx), leaveCommand(), previewCommand()},
-		"Debug Commands":   []*cobra.Command{loglevelCommand(), gatherLogsCommand()},
-		"Other Commands":   []*cobra.Command{versionCommand(), uninstallCommand(), dashboardCommand(), ClusterIdCommand(), genYAMLCommand(), vpnDiagCommand()},
+		"Session Commands": []*cobra.Command{connectCommand(), proxyCommand(), LoginCommand(), LogoutCommand(), LicenseCommand(), statusCommand(), sessionCommand(), quitCommand()},
+		"Traffic Commands": []*cobra.Command{listCommand(), interceptCommand(ctx), replaceCommand(ctx), leaveCommand(), handoffCommand(), previewCommand(), composeCommand(ctx)},
+		"Debug Commands":   []*cobra.Command{loglevelCommand(), gatherLogsCommand(), benchmarkCommand(), describeCommand()},
+		"Other Commands": append([]*cobra.Command{versionCommand(), uninstallCommand(), upgradeCommand(), dashboardCommand(), ClusterIdCommand(), genYAMLCommand(), rbacCommand(), vpnDiagCommand()},
+			platformCommands()...),
 	}
 	for name, cmds := range static {
 		if _, ok := groups[name]; !ok {
@@ -131,6 +133,10 @@ func Command(ctx context.Context) *cobra.Command {
 	}
 
 	AddCommandGroups(rootCmd, groups)
+
+	// Hidden commands are added outside the groups, because the groups are listed by the help regardless
+	rootCmd.AddCommand(integrationTestCommand())
+	rootCmd.AddCommand(daemonRelayCommand())
 	initGlobalFlagGroups()
 	for _, commands := range groups {
 		for _, command := range commands {
@@ -144,9 +150,23 @@ func Command(ctx context.Context) *cobra.Command {
 	for _, group := range globalFlagGroups {
 		rootCmd.PersistentFlags().AddFlagSet(group.Flags)
 	}
+	rootCmd.PersistentPreRun = applyOfflineFlag
 	return rootCmd
 }
 
+// applyOfflineFlag puts the config of the command's context in offline mode when the global --offline flag is set.
+// The daemons that the command starts are then started in offline mode too.
+func applyOfflineFlag(cmd *cobra.Command, _ []string) {
+	if offline, _ := cmd.Flags().GetBool("offline"); offline {
+		ctx := cmd.Context()
+		if cfg := client.GetConfig(ctx); cfg != nil && !cfg.Offline {
+			oc := *cfg
+			oc.Offline = true
+			client.ReplaceConfig(ctx, &oc)
+		}
+	}
+}
+
 // argsCheck wraps an PositionalArgs checker in a function that wraps a potential error
 // using errcat.User
 func argsCheck(f cobra.PositionalArgs) cobra.PositionalArgs {
@@ -200,6 +220,10 @@ func initGlobalFlagGroups() {
 				"no-report", false,
 				"turn off anonymous crash reports and log submission on failure",
 			)
+			flags.Bool(
+				"offline", false,
+				"make no network calls other than those to the cluster, e.g. to Ambassador Cloud or for telemetry",
+			)
 			flags.String(
 				"output", "default",
 				"set the output format, supported values are 'json' and 'default'",
@@ -0,0 +1,107 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/output"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+type benchmarkInfo struct {
+	size       int64
+	roundTrips int32
+}
+
+func benchmarkCommand() *cobra.Command {
+	bi := &benchmarkInfo{}
+	cmd := &cobra.Command{
+		Use:  "benchmark",
+		Args: cobra.NoArgs,
+
+		Short: "Measure latency and throughput to the cluster",
+		Long: `Measure round-trip time and throughput between this machine and the traffic-manager.
+
+The measurement is done twice: once through the TUN-device and the traffic-manager tunnel, which is the path
+used when accessing cluster resources, and once through a direct Kubernetes port-forward for comparison.`,
+		RunE: bi.run,
+	}
+	flags := cmd.Flags()
+	flags.Int64Var(&bi.size, "size", 10*1024*1024, "Number of bytes to upload and download")
+	flags.Int32VarP(&bi.roundTrips, "round-trips", "r", 20, "Number of round-trips used when measuring latency")
+	return cmd
+}
+
+func (bi *benchmarkInfo) run(cmd *cobra.Command, _ []string) error {
+	if bi.size <= 0 {
+		return errcat.User.New("--size must be greater than zero")
+	}
+	if bi.roundTrips <= 0 {
+		return errcat.User.New("--round-trips must be greater than zero")
+	}
+	return withConnector(cmd, true, nil, func(ctx context.Context, cs *connectorState) error {
+		rsp, err := cs.userD.Benchmark(ctx, &connector.BenchmarkRequest{Size: bi.size, RoundTrips: bi.roundTrips})
+		if err != nil {
+			return err
+		}
+		stdout := cmd.OutOrStdout()
+		if output.WantsJSONOutput(cmd.Flags()) {
+			if streamerOut, ok := stdout.(output.StructuredStreamer); ok {
+				streamerOut.StructuredStream(rsp.Results, nil)
+				return nil
+			}
+		}
+		printBenchmarkResults(stdout, rsp.Results)
+		return nil
+	})
+}
+
+func printBenchmarkResults(out io.Writer, results []*connector.BenchmarkResult) {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PATH\tMIN RTT\tAVG RTT\tMAX RTT\tUPLOAD\tDOWNLOAD")
+	for _, r := range results {
+		if r.Error != "" {
+			fmt.Fprintf(tw, "%s\terror: %s\n", r.Name, r.Error)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Name,
+			roundDuration(r.MinRtt.AsDuration()),
+			roundDuration(r.AvgRtt.AsDuration()),
+			roundDuration(r.MaxRtt.AsDuration()),
+			formatThroughput(r.UploadBps),
+			formatThroughput(r.DownloadBps))
+	}
+	_ = tw.Flush()
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	switch {
+	case d > time.Second:
+		return d.Round(time.Millisecond)
+	case d > time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	default:
+		return d.Round(time.Microsecond)
+	}
+}
+
+// formatThroughput formats the given bytes per second as bits per second using SI prefixes.
+func formatThroughput(bps float64) string {
+	bits := bps * 8
+	switch {
+	case bits >= 1e9:
+		return fmt.Sprintf("%.2f Gbit/s", bits/1e9)
+	case bits >= 1e6:
+		return fmt.Sprintf("%.2f Mbit/s", bits/1e6)
+	case bits >= 1e3:
+		return fmt.Sprintf("%.2f kbit/s", bits/1e3)
+	default:
+		return fmt.Sprintf("%.0f bit/s", bits)
+	}
+}
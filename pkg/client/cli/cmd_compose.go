@@ -0,0 +1,346 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/datawire/dlib/dcontext"
+	"github.com/datawire/dlib/dexec"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/extensions"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+)
+
+type composeArgs struct {
+	file       string   // --file
+	intercepts []string // --intercept
+	namespace  string   // --namespace
+
+	extState *extensions.ExtensionsState // extension flags
+
+	cmdline []string // Args
+}
+
+// composeIntercept maps a workload in the cluster to the service in the compose file that replaces it.
+type composeIntercept struct {
+	composeService string
+	workload       string
+	svcPortId      string
+}
+
+// composeFile is the subset of a Docker Compose file that is needed to bridge its services to the cluster.
+type composeFile struct {
+	Services map[string]*composeService `yaml:"services"`
+}
+
+type composeService struct {
+	Ports []composePort `yaml:"ports"`
+}
+
+// composePort is a port of a compose service, declared using either the short or the long syntax.
+type composePort struct {
+	Target    uint16 `yaml:"target"`
+	Published string `yaml:"published"`
+	Protocol  string `yaml:"protocol"`
+}
+
+func composeCommand(ctx context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:  "compose [flags] [-- <docker compose arguments...>]",
+		Args: cobra.ArbitraryArgs,
+
+		Short: "Run a Docker Compose project with some of its services intercepting the cluster",
+		Long: `Run a Docker Compose project where the given compose services intercept workloads in the cluster.
+
+Each intercept routes the traffic of the workload to the first published port of the compose service,
+and the service gets the environment of the intercepted container. All services of the project resolve
+cluster names when the Docker containers can reach the cluster (see "telepresence connect --allow-docker").
+The arguments after -- are passed to "docker compose". They default to "up".`,
+		PreRunE:  updateCheckIfDue,
+		PostRunE: raiseCloudMessage,
+	}
+	args := composeArgs{}
+	flags := cmd.Flags()
+
+	flags.StringVarP(&args.file, "file", "f", "docker-compose.yml", "The Docker Compose file")
+	flags.StringSliceVarP(&args.intercepts, "intercept", "i", nil, ``+
+		`A <compose service>=<workload> mapping that makes the compose service intercept the workload. `+
+		`Use <compose service>=<workload>:<svcPortIdentifier> if the workload is exposed by a service with multiple ports. `+
+		`Can be repeated.`)
+	flags.StringVarP(&args.namespace, "namespace", "n", "", "If present, the namespace of the intercepted workloads")
+
+	var extErr error
+	args.extState, extErr = extensions.LoadExtensions(ctx, flags)
+
+	cmd.RunE = func(cmd *cobra.Command, positional []string) error {
+		if extErr != nil {
+			return extErr
+		}
+		args.cmdline = positional
+		if len(args.cmdline) == 0 {
+			args.cmdline = []string{"up"}
+		}
+		return compose(cmd, args)
+	}
+	return cmd
+}
+
+func compose(cmd *cobra.Command, args composeArgs) error {
+	cf, err := readComposeFile(args.file)
+	if err != nil {
+		return err
+	}
+	cis := make([]*composeIntercept, len(args.intercepts))
+	for i, s := range args.intercepts {
+		if cis[i], err = parseComposeIntercept(s); err != nil {
+			return err
+		}
+	}
+	requiresLogin, err := args.extState.RequiresAPIKeyOrLicense()
+	if err != nil {
+		return err
+	}
+
+	var request *connector.ConnectRequest
+	if runtime.GOOS == "linux" {
+		// Let the compose services reach the cluster
+		request = &connector.ConnectRequest{AllowDocker: true}
+	}
+	return withConnector(cmd, false, request, func(ctx context.Context, cs *connectorState) error {
+		return cliutil.WithManager(ctx, func(ctx context.Context, managerClient manager.ManagerClient) error {
+			iss := make([]*interceptState, len(cis))
+			for i, ci := range cis {
+				ia, err := cf.interceptArgs(ci, args.namespace)
+				if err != nil {
+					return err
+				}
+				ia.extState = args.extState
+				ia.extRequiresLogin = requiresLogin
+				iss[i] = newInterceptState(ctx, safeCobraCommandImpl{cmd}, ia, cs, managerClient)
+				defer iss[i].scout.Close()
+			}
+			return withInterceptStates(ctx, iss, func() error {
+				dnsIP := net.IP(cs.ConnectInfo.DockerDnsIp)
+				if len(dnsIP) == 0 {
+					fmt.Fprintln(cmd.ErrOrStderr(),
+						"Warning: the compose services cannot resolve cluster names unless telepresence is connected with --allow-docker")
+				}
+				envs := make(map[string]map[string]string, len(iss))
+				for i, is := range iss {
+					envs[cis[i].composeService] = is.env
+				}
+				return runCompose(ctx, cs.userD, args.file, composeOverride(cf, envs, dnsIP), iss, args.cmdline)
+			})
+		})
+	})
+}
+
+// withInterceptStates ensures the state of all the given intercepts while calling f.
+func withInterceptStates(ctx context.Context, iss []*interceptState, f func() error) error {
+	if len(iss) == 0 {
+		return f()
+	}
+	return client.WithEnsuredState(ctx, iss[0], false, func() error {
+		return withInterceptStates(ctx, iss[1:], f)
+	})
+}
+
+func runCompose(
+	ctx context.Context,
+	cc connector.ConnectorClient,
+	file string,
+	override map[string]any,
+	iss []*interceptState,
+	args []string,
+) error {
+	data, err := yaml.Marshal(override)
+	if err != nil {
+		return err
+	}
+	of, err := os.CreateTemp("", "tel-compose-*.yml")
+	if err != nil {
+		return errcat.NoDaemonLogs.Newf("failed to create temporary compose file. %w", err)
+	}
+	defer os.Remove(of.Name())
+	_, err = of.Write(data)
+	if cerr := of.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return errcat.NoDaemonLogs.Newf("failed to write temporary compose file. %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(dcontext.WithSoftness(ctx))
+	defer cancel()
+	var cmd *dexec.Cmd
+	if cmd, err = proc.Start(ctx, nil, "docker", append([]string{"compose", "-f", file, "-f", of.Name()}, args...)...); err != nil {
+		return errcat.NoDaemonLogs.New(err)
+	}
+
+	// Send info about the pid and intercept ids to the traffic-manager so that it kills
+	// the process if it receives a leave of quit call.
+	for _, is := range iss {
+		ior := &connector.Interceptor{
+			InterceptId: is.env["TELEPRESENCE_INTERCEPT_ID"],
+			Pid:         int32(os.Getpid()),
+		}
+		if _, err = cc.AddInterceptor(ctx, ior); err != nil {
+			_ = cmd.Process.Kill()
+			return err
+		}
+		defer func() {
+			if _, err := cc.RemoveInterceptor(ctx, ior); err != nil {
+				dlog.Error(ctx, err)
+			}
+		}()
+	}
+	if err = proc.Wait(ctx, cancel, cmd); err != nil {
+		// The external command will not output anything to the logs. An error here
+		// is likely caused by the user hitting <ctrl>-C to terminate the process.
+		err = errcat.NoDaemonLogs.New(err)
+	}
+	return err
+}
+
+func parseComposeIntercept(s string) (*composeIntercept, error) {
+	svc, wl, ok := strings.Cut(s, "=")
+	if !ok || svc == "" || wl == "" {
+		return nil, errcat.User.Newf("invalid intercept %q, use <compose service>=<workload>[:<svcPortIdentifier>]", s)
+	}
+	ci := &composeIntercept{composeService: svc, workload: wl}
+	if wl, id, ok := strings.Cut(wl, ":"); ok {
+		if wl == "" || id == "" {
+			return nil, errcat.User.Newf("invalid intercept %q, use <compose service>=<workload>[:<svcPortIdentifier>]", s)
+		}
+		ci.workload = wl
+		ci.svcPortId = id
+	}
+	return ci, nil
+}
+
+func readComposeFile(file string) (*composeFile, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errcat.User.New(err)
+	}
+	var cf composeFile
+	if err = yaml.Unmarshal(data, &cf); err != nil {
+		return nil, errcat.User.Newf("unable to parse compose file %s: %w", file, err)
+	}
+	return &cf, nil
+}
+
+// interceptArgs returns the arguments of an intercept of the workload that routes its traffic to the first
+// published TCP port of the compose service.
+func (cf *composeFile) interceptArgs(ci *composeIntercept, namespace string) (interceptArgs, error) {
+	svc, ok := cf.Services[ci.composeService]
+	if !ok {
+		return interceptArgs{}, errcat.User.Newf("compose file has no service %q", ci.composeService)
+	}
+	var localPort uint16
+	for _, p := range svc.Ports {
+		if lp, err := p.publishedPort(); err == nil && lp != 0 && (p.Protocol == "" || p.Protocol == "tcp") {
+			localPort = lp
+			break
+		}
+	}
+	if localPort == 0 {
+		return interceptArgs{}, errcat.User.Newf("compose service %q doesn't publish a TCP port", ci.composeService)
+	}
+	port := strconv.Itoa(int(localPort))
+	if ci.svcPortId != "" {
+		port += ":" + ci.svcPortId
+	}
+	name := ci.workload
+	if namespace != "" {
+		name += "-" + namespace
+	}
+	return interceptArgs{
+		name:      name,
+		agentName: ci.workload,
+		namespace: namespace,
+		port:      port,
+		mount:     "false",
+		mountSet:  true,
+	}, nil
+}
+
+// composeOverride returns a compose file that, when merged with the given one, gives the intercepting services
+// the environment of the containers that they intercept and makes all services use the given DNS server.
+func composeOverride(cf *composeFile, envs map[string]map[string]string, dnsIP net.IP) map[string]any {
+	svcs := make(map[string]any, len(cf.Services))
+	for name := range cf.Services {
+		svc := make(map[string]any)
+		if len(dnsIP) > 0 {
+			svc["dns"] = []string{dnsIP.String()}
+			svc["dns_search"] = []string{"tel2-search"}
+		}
+		if env, ok := envs[name]; ok {
+			// Compose interpolates variables in the values, so a literal $ must be escaped.
+			ce := make(map[string]string, len(env))
+			for k, v := range env {
+				ce[k] = strings.ReplaceAll(v, "$", "$$")
+			}
+			svc["environment"] = ce
+		}
+		if len(svc) > 0 {
+			svcs[name] = svc
+		}
+	}
+	return map[string]any{"services": svcs}
+}
+
+func (p *composePort) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		type plain composePort
+		return node.Decode((*plain)(p))
+	}
+
+	// Short syntax: [[HOST_IP:]PUBLISHED:]TARGET[/PROTOCOL]
+	s := node.Value
+	if i := strings.LastIndexByte(s, '/'); i >= 0 {
+		p.Protocol = s[i+1:]
+		s = s[:i]
+	}
+	target := s
+	if i := strings.LastIndexByte(s, ':'); i >= 0 {
+		target = s[i+1:]
+		s = s[:i]
+		if i = strings.LastIndexByte(s, ':'); i >= 0 {
+			s = s[i+1:]
+		}
+		p.Published = s
+	}
+	if strings.ContainsRune(target, '-') {
+		// A port range. It's not possible to intercept to those.
+		return nil
+	}
+	t, err := strconv.ParseUint(target, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port %q: %w", node.Value, err)
+	}
+	p.Target = uint16(t)
+	return nil
+}
+
+// publishedPort returns the port that the port is published on localhost, or zero when it's not published or
+// published using a port range.
+func (p *composePort) publishedPort() (uint16, error) {
+	if p.Published == "" || strings.ContainsRune(p.Published, '-') {
+		return 0, nil
+	}
+	return parseNumericPort(p.Published)
+}
@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+const testComposeFile = `
+services:
+  web:
+    image: web
+    ports:
+      - "127.0.0.1:8080:80"
+      - 9090
+  api:
+    image: api
+    ports:
+      - target: 3000
+        published: 3001
+        protocol: tcp
+  db:
+    image: postgres
+    ports:
+      - "5432-5434:5432-5434/tcp"
+`
+
+func Test_composeFile(t *testing.T) {
+	var cf composeFile
+	require.NoError(t, yaml.Unmarshal([]byte(testComposeFile), &cf))
+	assert.Equal(t, []composePort{{Target: 80, Published: "8080"}, {Target: 9090}}, cf.Services["web"].Ports)
+	assert.Equal(t, []composePort{{Target: 3000, Published: "3001", Protocol: "tcp"}}, cf.Services["api"].Ports)
+
+	ia, err := cf.interceptArgs(&composeIntercept{composeService: "web", workload: "frontend"}, "")
+	require.NoError(t, err)
+	assert.Equal(t, "frontend", ia.name)
+	assert.Equal(t, "8080", ia.port)
+	assert.Equal(t, "false", ia.mount)
+
+	ia, err = cf.interceptArgs(&composeIntercept{composeService: "api", workload: "backend", svcPortId: "http"}, "dev")
+	require.NoError(t, err)
+	assert.Equal(t, "backend-dev", ia.name)
+	assert.Equal(t, "backend", ia.agentName)
+	assert.Equal(t, "3001:http", ia.port)
+
+	_, err = cf.interceptArgs(&composeIntercept{composeService: "db", workload: "db"}, "")
+	assert.Error(t, err)
+	_, err = cf.interceptArgs(&composeIntercept{composeService: "cache", workload: "cache"}, "")
+	assert.Error(t, err)
+}
+
+func Test_parseComposeIntercept(t *testing.T) {
+	ci, err := parseComposeIntercept("web=frontend")
+	require.NoError(t, err)
+	assert.Equal(t, &composeIntercept{composeService: "web", workload: "frontend"}, ci)
+
+	ci, err = parseComposeIntercept("web=frontend:http")
+	require.NoError(t, err)
+	assert.Equal(t, &composeIntercept{composeService: "web", workload: "frontend", svcPortId: "http"}, ci)
+
+	for _, s := range []string{"web", "=frontend", "web=", "web=:http", "web=frontend:"} {
+		_, err = parseComposeIntercept(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func Test_composeOverride(t *testing.T) {
+	var cf composeFile
+	require.NoError(t, yaml.Unmarshal([]byte(testComposeFile), &cf))
+	envs := map[string]map[string]string{"web": {"PRICE": "$5"}}
+
+	assert.Equal(t, map[string]any{
+		"services": map[string]any{
+			"web": map[string]any{"environment": map[string]string{"PRICE": "$$5"}},
+		},
+	}, composeOverride(&cf, envs, nil))
+
+	ov := composeOverride(&cf, envs, net.IP{172, 17, 0, 1})
+	svcs := ov["services"].(map[string]any)
+	assert.Len(t, svcs, 3)
+	assert.Equal(t, map[string]any{
+		"dns":        []string{"172.17.0.1"},
+		"dns_search": []string{"tel2-search"},
+	}, svcs["db"])
+}
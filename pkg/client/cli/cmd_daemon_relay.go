@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/output"
+)
+
+func daemonRelayCommand() *cobra.Command {
+	var start bool
+	cmd := &cobra.Command{
+		Use:    "daemon-relay",
+		Args:   cobra.NoArgs,
+		Hidden: true,
+
+		Short: "Relay stdin and stdout to the user daemon",
+		Long: `Relay stdin and stdout to the socket of the user daemon, and start the daemons first unless they are running
+or --start=false is given.
+
+The command is started by a telepresence CLI that runs in a WSL2 distribution and is configured to use the daemons
+of the Windows host, because the CLI can start Windows executables but can't open the named pipes of Windows.`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return runDaemonRelay(cmd, start)
+		},
+	}
+	cmd.Flags().BoolVar(&start, "start", true, "Start the daemons unless they are running")
+	return cmd
+}
+
+func runDaemonRelay(cmd *cobra.Command, start bool) error {
+	// Stdout carries the relayed traffic, so everything that would normally be printed there goes to stderr.
+	cmd.SetOut(cmd.ErrOrStderr())
+	ctx := output.WithStructure(cmd.Context(), cmd)
+	if start {
+		err := cliutil.WithNetwork(ctx, func(ctx context.Context, _ daemon.DaemonClient) error {
+			return cliutil.WithConnector(ctx, func(context.Context, connector.ConnectorClient) error {
+				return nil
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	conn, err := client.DialSocketConn(ctx, client.ConnectorSocketName(ctx))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err = os.Stdout.Write([]byte{client.RelayReady}); err != nil {
+		return err
+	}
+
+	go func() {
+		_, _ = io.Copy(conn, os.Stdin)
+		_ = conn.Close()
+	}()
+	if _, err = io.Copy(os.Stdout, conn); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+//go:build !windows
+// +build !windows
+
+package cli
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// TestDaemonRelayHelperProcess isn't a real test. It's the daemon-relay process that Test_runDaemonRelay starts.
+func TestDaemonRelayHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	cmd := daemonRelayCommand()
+	cmd.SetArgs([]string{"--start=false"})
+	if err := cmd.ExecuteContext(context.Background()); err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func Test_runDaemonRelay(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	t.Setenv("GO_WANT_HELPER_PROCESS", "1")
+	dialRelay := func() (*grpc.ClientConn, error) {
+		return client.DialRelay(ctx, os.Args[0], []string{"-test.run=TestDaemonRelayHelperProcess"})
+	}
+
+	// The relay fails when the user daemon isn't running, and it isn't started
+	_, err := dialRelay()
+	assert.ErrorContains(t, err, "exited before it reached the daemon")
+
+	listener, err := client.ListenSocket(ctx, "connector", client.ConnectorSocketName(ctx))
+	require.NoError(t, err)
+	defer func() { _ = client.RemoveSocket(listener) }()
+	srv := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(srv, health.NewServer())
+	go func() { _ = srv.Serve(listener) }()
+	defer srv.Stop()
+
+	conn, err := dialRelay()
+	require.NoError(t, err)
+	defer conn.Close()
+	rsp, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, rsp.Status)
+}
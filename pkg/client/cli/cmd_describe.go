@@ -0,0 +1,136 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/output"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+func describeCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:  "describe",
+		Args: OnlySubcommands,
+
+		Short: "Show details that help finding out why something failed",
+		RunE:  RunSubcommands,
+	}
+	cmd.AddCommand(describeInterceptCommand())
+	return cmd
+}
+
+func describeInterceptCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:  "intercept <intercept_name>",
+		Args: cobra.ExactArgs(1),
+
+		Short: "Show the disposition history of an intercept, and the traffic-agent injection status and events of its pods",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withConnector(cmd, true, nil, func(ctx context.Context, cs *connectorState) error {
+				d, err := cs.userD.DescribeIntercept(ctx, &connector.DescribeInterceptRequest{Name: args[0]})
+				if err != nil {
+					if st := status.Convert(err); st.Code() == codes.NotFound {
+						err = errcat.User.New(st.Message())
+					}
+					return err
+				}
+				stdout := cmd.OutOrStdout()
+				if output.WantsJSONOutput(cmd.Flags()) {
+					streamerOut, _ := stdout.(output.StructuredStreamer)
+					if streamerOut == nil {
+						panic("writer not output.StructuredStreamer")
+					}
+					streamerOut.StructuredStream(d, nil)
+					return nil
+				}
+				printInterceptDescription(stdout, d)
+				return nil
+			})
+		},
+	}
+}
+
+func printInterceptDescription(out io.Writer, d *connector.InterceptDescription) {
+	sb := strings.Builder{}
+	sb.WriteString("Intercept")
+	describeIntercept(d.Intercept, nil, true, &sb)
+	fmt.Fprintln(out, sb.String())
+
+	fmt.Fprintln(out, "\nDisposition history:")
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	switch {
+	case d.HistoryUnavailable:
+		fmt.Fprintln(tw, "    The traffic-manager is too old to keep a disposition history")
+	case len(d.History) == 0:
+		fmt.Fprintln(tw, "    None")
+	}
+	for _, dc := range d.History {
+		var by string
+		switch {
+		case dc.AgentPodIp == "":
+			by = "traffic-manager"
+		case dc.Ignored:
+			by = "agent " + dc.AgentPodIp + " (ignored)"
+		default:
+			by = "agent " + dc.AgentPodIp
+		}
+		fmt.Fprintf(tw, "    %s\t%s\t%s\t%s\n", formatTime(dc.Time), dc.Disposition, by, dc.Message)
+	}
+	_ = tw.Flush()
+
+	ns := d.Intercept.Spec.Namespace
+	fmt.Fprintf(out, "\nPods of %s %s.%s:\n", d.Intercept.Spec.WorkloadKind, d.Intercept.Spec.Agent, ns)
+	if len(d.Pods) == 0 {
+		fmt.Fprintln(out, "    None")
+	}
+	for _, pod := range d.Pods {
+		fmt.Fprintf(out, "    %s: %s, %s\n", pod.Name, pod.Phase, podInjectionStatus(pod))
+		if len(pod.Events) > 0 {
+			tw = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+			for _, ev := range pod.Events {
+				count := ""
+				if ev.Count > 1 {
+					count = fmt.Sprintf(" (x%d)", ev.Count)
+				}
+				fmt.Fprintf(tw, "        %s\t%s\t%s\t%s%s\n", formatTime(ev.Time), ev.Type, ev.Reason, ev.Message, count)
+			}
+			_ = tw.Flush()
+		}
+	}
+	if d.EventsError != "" {
+		fmt.Fprintf(out, "\nUnable to list the events in namespace %s: %s\n", ns, d.EventsError)
+	}
+}
+
+// podInjectionStatus describes whether the agent-injector injected a traffic-agent into the given pod, and if
+// so, whether that traffic-agent is ready.
+func podInjectionStatus(pod *connector.InterceptedPod) string {
+	switch {
+	case !pod.AgentInjected && (pod.InjectAnnotation == "false" || pod.InjectAnnotation == "disabled"):
+		return fmt.Sprintf("traffic-agent injection disabled by annotation (%s)", pod.InjectAnnotation)
+	case !pod.AgentInjected:
+		return "no traffic-agent, the pod was not mutated by the agent-injector webhook"
+	case pod.Ready:
+		return "traffic-agent injected and ready"
+	default:
+		return "traffic-agent injected, " + pod.AgentStatus
+	}
+}
+
+func formatTime(t *timestamppb.Timestamp) string {
+	if t == nil {
+		return ""
+	}
+	return t.AsTime().Local().Format(time.RFC3339)
+}
@@ -6,10 +6,12 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -39,7 +41,10 @@ func gatherLogsCommand() *cobra.Command {
 		Short: "Gather logs from traffic-manager, traffic-agent, user and root daemons, and export them into a zip file.",
 		Long: `Gather logs from traffic-manager, traffic-agent, user and root daemons,
 and export them into a zip file. Useful if you are opening a Github issue or asking
-someone to help you debug Telepresence.`,
+someone to help you debug Telepresence.
+
+Crash reports written by the daemons are always included, and so are goroutine and
+heap profiles from daemons that have a profiling port configured in config.yml.`,
 		Example: `Here are a few examples of how you can use this command:
 # Get all logs and export to a given file
 telepresence gather-logs -o /tmp/telepresence_logs.zip
@@ -158,6 +163,10 @@ func (gl *gatherLogsArgs) gatherLogs(ctx context.Context, cmd *cobra.Command) er
 		gl.gatherClusterLogs(ctx, cmd, exportDir, az)
 	}
 
+	// Crash reports are named after the daemon that crashed, so they are included by the
+	// copy below. Profiles must be obtained from the running daemons.
+	gatherDaemonProfiles(ctx, cmd, exportDir, daemonLogs)
+
 	// Get all logs from the logDir that match the daemons the user cares about.
 	logFiles, err := os.ReadDir(logDir)
 	if err != nil {
@@ -257,6 +266,66 @@ func (gl *gatherLogsArgs) gatherClusterLogs(ctx context.Context, cmd *cobra.Comm
 	}
 }
 
+// gatherDaemonProfiles fetches goroutine and heap profiles from the daemons that have a profiling port
+// configured and stores them in the export directory as "<daemon>-<profile>.txt"
+func gatherDaemonProfiles(ctx context.Context, cmd *cobra.Command, exportDir string, daemonLogs []string) {
+	cfg := client.GetConfig(ctx)
+	if cfg == nil {
+		return
+	}
+	for _, logType := range daemonLogs {
+		var port uint16
+		switch logType {
+		case "daemon":
+			port = cfg.Daemons.RootDaemonProfilingPort
+		case "connector":
+			port = cfg.Daemons.UserDaemonProfilingPort
+		}
+		if port == 0 {
+			continue
+		}
+		for _, profile := range []string{"goroutine", "heap"} {
+			dstFile := filepath.Join(exportDir, fmt.Sprintf("%s-%s.txt", logType, profile))
+			if err := downloadProfile(ctx, port, profile, dstFile); err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "failed to get %s profile from %s: %s\n", profile, logType, err)
+			}
+		}
+	}
+}
+
+// downloadProfile retrieves a profile in text format from the pprof server at the given localhost port.
+func downloadProfile(ctx context.Context, port uint16, profile, dstFile string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	// debug=2 on the goroutine profile gives the same output as an unrecovered panic. Other
+	// profiles only recognize debug=1 as the text format.
+	debug := 1
+	if profile == "goroutine" {
+		debug = 2
+	}
+	url := fmt.Sprintf("http://localhost:%d/debug/pprof/%s?debug=%d", port, profile, debug)
+	rq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	rsp, err := http.DefaultClient.Do(rq)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, rsp.Status)
+	}
+	dstWriter, err := os.Create(dstFile)
+	if err != nil {
+		return err
+	}
+	defer dstWriter.Close()
+	_, err = io.Copy(dstWriter, rsp.Body)
+	return err
+}
+
 func isEmpty(file string) (bool, error) {
 	s, err := os.Stat(file)
 	if err != nil {
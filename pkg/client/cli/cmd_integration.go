@@ -0,0 +1,609 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/datawire/dlib/dcontext"
+	"github.com/datawire/dlib/dexec"
+	"github.com/datawire/dlib/dhttp"
+	"github.com/datawire/dlib/dtime"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/shellquote"
+)
+
+type integrationTestInfo struct {
+	clusterName      string
+	createCluster    bool
+	keepCluster      bool
+	anyContext       bool
+	nodeImage        string
+	loadImages       []string
+	namespace        string
+	managerNamespace string
+	scenarios        []string
+	timeout          time.Duration
+}
+
+func integrationTestCommand() *cobra.Command {
+	it := &integrationTestInfo{}
+	cmd := &cobra.Command{
+		Use:    "integration-test",
+		Args:   cobra.NoArgs,
+		Hidden: true,
+
+		Short: "Validate this build end-to-end against a kind cluster",
+		Long: `Validate this build end-to-end by running a scripted matrix of connect, intercept, leave, and uninstall
+scenarios against a Kubernetes cluster.
+
+A kind cluster is created for the run and deleted when it completes, unless --create-cluster=false is given,
+in which case the current Kubernetes context is used. An existing kind cluster with the given name is reused
+and kept. The uninstall scenario removes the traffic-manager and all traffic-agents from the cluster, so a current
+context other than the one of the kind cluster is refused unless --allow-any-context is given. The traffic-manager
+is installed by the first connect, so the Telepresence images of this build must be available to the cluster, e.g.
+by using --load-image.
+
+The scenarios start their own daemons, so the command refuses to run while the Telepresence daemons are running.
+It requires the kind and kubectl executables, and fails when any of the scenarios fail.`,
+		RunE: it.run,
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&it.clusterName, "cluster-name", "telepresence-itest", "Name of the kind cluster")
+	flags.BoolVar(&it.createCluster, "create-cluster", true, "Create a kind cluster for the run")
+	flags.BoolVar(&it.keepCluster, "keep-cluster", false, "Don't delete the kind cluster when the run completes")
+	flags.BoolVar(&it.anyContext, "allow-any-context", false,
+		"Run against the current Kubernetes context when --create-cluster=false, even if it isn't the kind cluster")
+	flags.StringVar(&it.nodeImage, "node-image", "", "Node image used when creating the kind cluster")
+	flags.StringSliceVar(&it.loadImages, "load-image", nil, "Docker image to load into the kind cluster, e.g. the traffic-manager image of this build")
+	flags.StringVar(&it.namespace, "namespace", "telepresence-itest", "Namespace of the workloads used by the scenarios")
+	flags.StringVar(&it.managerNamespace, "manager-namespace", "ambassador", "Namespace of the traffic-manager")
+	flags.StringSliceVar(&it.scenarios, "scenario", nil, "Only run the scenarios with the given names or name prefixes")
+	flags.DurationVar(&it.timeout, "timeout", 2*time.Minute, "Timeout for each step of a scenario")
+	return cmd
+}
+
+// itestScenario is a named sequence of steps. A scenario fails at the first step that fails. The cleanup, if any,
+// runs when the scenario completes, regardless of its outcome, so that a failed scenario doesn't affect the next one.
+type itestScenario struct {
+	name    string
+	steps   []itestStep
+	cleanup func(context.Context, *itestRunner)
+}
+
+type itestStep struct {
+	name string
+	run  func(context.Context, *itestRunner) error
+}
+
+type itestResult struct {
+	scenario string
+	step     string
+	duration time.Duration
+	err      error
+}
+
+// itestRunner runs the commands of the scenarios.
+type itestRunner struct {
+	exe string
+	env []string
+	out io.Writer
+}
+
+func (it *integrationTestInfo) run(cmd *cobra.Command, _ []string) error {
+	ctx := cmd.Context()
+	scenarios := selectScenarios(integrationScenarios(it.namespace, it.managerNamespace), it.scenarios)
+	if len(scenarios) == 0 {
+		return errcat.User.Newf("no scenarios match %s", strings.Join(it.scenarios, ","))
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	r := &itestRunner{
+		exe: exe,
+		env: append(os.Environ(), "TELEPRESENCE_MANAGER_NAMESPACE="+it.managerNamespace),
+		out: cmd.OutOrStdout(),
+	}
+
+	// The daemons must be started by the scenarios, so that they use the same environment, and the daemons of
+	// the user must not be quit by the run.
+	out, err := r.telepresence(ctx, "status", "--json")
+	if err != nil {
+		return err
+	}
+	if running, err := daemonsRunning(out); err != nil || running {
+		if err == nil {
+			err = errcat.User.New("the Telepresence daemons are running. Quit them using \"telepresence quit -s\" before running the integration test")
+		}
+		return err
+	}
+
+	if it.createCluster {
+		tmpDir, err := os.MkdirTemp("", "telepresence-itest")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmpDir)
+		created, err := it.ensureCluster(ctx, r, filepath.Join(tmpDir, "kubeconfig"))
+		if created && !it.keepCluster {
+			defer func() {
+				if _, err := r.command(context.Background(), "", "kind", "delete", "cluster", "--name", it.clusterName); err != nil {
+					fmt.Fprintf(r.out, "Unable to delete kind cluster %s: %v\n", it.clusterName, err)
+				}
+			}()
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		current, err := r.kubectl(ctx, "", "config", "current-context")
+		if err != nil {
+			return err
+		}
+		if err = it.checkContext(strings.TrimSpace(current)); err != nil {
+			return err
+		}
+	}
+
+	nsManifest := fmt.Sprintf("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: %s\n", it.namespace)
+	if _, err = r.kubectl(ctx, nsManifest, "apply", "-f", "-"); err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = r.telepresence(context.Background(), "quit", "-s")
+		_, _ = r.kubectl(context.Background(), "", "delete", "namespace", it.namespace, "--wait=false")
+	}()
+
+	var results []itestResult
+	failed := 0
+	for _, sc := range scenarios {
+		fmt.Fprintf(r.out, "Scenario %s\n", sc.name)
+		for _, step := range sc.steps {
+			start := time.Now()
+			sCtx, cancel := context.WithTimeout(ctx, it.timeout)
+			err := step.run(sCtx, r)
+			cancel()
+			res := itestResult{scenario: sc.name, step: step.name, duration: time.Since(start).Round(time.Millisecond), err: err}
+			results = append(results, res)
+			if err != nil {
+				fmt.Fprintf(r.out, "  FAIL %s: %v\n", step.name, err)
+				failed++
+				break
+			}
+			fmt.Fprintf(r.out, "  ok   %s (%s)\n", step.name, res.duration)
+		}
+		if sc.cleanup != nil {
+			cCtx, cancel := context.WithTimeout(context.Background(), it.timeout)
+			sc.cleanup(cCtx, r)
+			cancel()
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	fmt.Fprintln(r.out)
+	printIntegrationResults(r.out, results)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d scenarios failed", failed, len(scenarios))
+	}
+	return nil
+}
+
+// daemonsRunning returns true if the output of "telepresence status --json" reports that a daemon is running.
+func daemonsRunning(statusJSON string) (bool, error) {
+	var so statusOutput
+	if err := json.Unmarshal([]byte(statusJSON), &so); err != nil {
+		return false, fmt.Errorf("unable to parse the output of telepresence status: %w", err)
+	}
+	return so.DaemonStatus.Running || so.UserDaemon.Running, nil
+}
+
+// checkContext returns an error unless the given Kubernetes context is the one of the kind cluster of the run, or
+// the run is explicitly allowed to use any context.
+func (it *integrationTestInfo) checkContext(current string) error {
+	if it.anyContext || current == "kind-"+it.clusterName {
+		return nil
+	}
+	return errcat.User.Newf("the current context %q isn't the context of kind cluster %s. The integration test uninstalls "+
+		"Telepresence from the cluster, so use --allow-any-context to run it against this context anyway", current, it.clusterName)
+}
+
+// ensureCluster creates the kind cluster, unless it already exists, loads the images into it, and makes the
+// runner use the kubeconfig of the cluster. It returns true if the cluster was created.
+func (it *integrationTestInfo) ensureCluster(ctx context.Context, r *itestRunner, kubeconfig string) (bool, error) {
+	clusters, err := r.command(ctx, "", "kind", "get", "clusters")
+	if err != nil {
+		return false, err
+	}
+	exists := false
+	for _, c := range strings.Fields(clusters) {
+		if c == it.clusterName {
+			exists = true
+			break
+		}
+	}
+	if exists {
+		fmt.Fprintf(r.out, "Using existing kind cluster %s\n", it.clusterName)
+	} else {
+		fmt.Fprintf(r.out, "Creating kind cluster %s\n", it.clusterName)
+		args := []string{"create", "cluster", "--name", it.clusterName, "--wait", "5m"}
+		if it.nodeImage != "" {
+			args = append(args, "--image", it.nodeImage)
+		}
+		if _, err = r.command(ctx, "", "kind", args...); err != nil {
+			return false, err
+		}
+	}
+	if _, err = r.command(ctx, "", "kind", "export", "kubeconfig", "--name", it.clusterName, "--kubeconfig", kubeconfig); err != nil {
+		return !exists, err
+	}
+	r.env = append(r.env, "KUBECONFIG="+kubeconfig)
+	for _, image := range it.loadImages {
+		fmt.Fprintf(r.out, "Loading image %s\n", image)
+		if _, err = r.command(ctx, "", "kind", "load", "docker-image", "--name", it.clusterName, image); err != nil {
+			return !exists, err
+		}
+	}
+	return !exists, nil
+}
+
+// command runs the given executable with the environment of the runner, and returns its output. The error contains
+// the output when the command fails.
+func (r *itestRunner) command(ctx context.Context, stdin, exe string, args ...string) (string, error) {
+	cmd := dexec.CommandContext(ctx, exe, args...)
+	cmd.DisableLogging = true
+	cmd.Env = r.env
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("%s: %w\n%s", shellquote.ShellString(exe, args), err, bytes.TrimSpace(out))
+	}
+	return string(out), nil
+}
+
+func (r *itestRunner) telepresence(ctx context.Context, args ...string) (string, error) {
+	return r.command(ctx, "", r.exe, args...)
+}
+
+func (r *itestRunner) kubectl(ctx context.Context, stdin string, args ...string) (string, error) {
+	return r.command(ctx, stdin, "kubectl", args...)
+}
+
+// expectOutput runs telepresence with the given args and checks that its output contains the expected string.
+func (r *itestRunner) expectOutput(ctx context.Context, expected string, args ...string) error {
+	out, err := r.telepresence(ctx, args...)
+	if err != nil {
+		return err
+	}
+	return expectContains(out, expected)
+}
+
+// eventually calls f until it returns nil, or until the context is done, in which case the last error is returned.
+func eventually(ctx context.Context, interval time.Duration, f func() error) error {
+	for {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		dtime.SleepWithContext(ctx, interval)
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+func expectContains(out, expected string) error {
+	if !strings.Contains(out, expected) {
+		return fmt.Errorf("expected output to contain %q, got:\n%s", expected, strings.TrimSpace(out))
+	}
+	return nil
+}
+
+func expectNotContains(out, unexpected string) error {
+	if strings.Contains(out, unexpected) {
+		return fmt.Errorf("expected output to not contain %q, got:\n%s", unexpected, strings.TrimSpace(out))
+	}
+	return nil
+}
+
+// selectScenarios returns the scenarios whose names match one of the given names or name prefixes, or all scenarios
+// when no names are given.
+func selectScenarios(scenarios []itestScenario, names []string) []itestScenario {
+	if len(names) == 0 {
+		return scenarios
+	}
+	var selected []itestScenario
+	for _, sc := range scenarios {
+		for _, name := range names {
+			if strings.HasPrefix(sc.name, name) {
+				selected = append(selected, sc)
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// itestWorkloadKinds are the kinds of workloads that the intercept and leave scenarios run for.
+var itestWorkloadKinds = []string{"Deployment", "ReplicaSet", "StatefulSet"}
+
+const itestEchoPort = 8080
+
+// itestManifest returns the manifest of a service and a workload of the given kind that runs an echo server.
+func itestManifest(kind, name string) string {
+	serviceName := ""
+	if kind == "StatefulSet" {
+		serviceName = "\n  serviceName: " + name
+	}
+	return fmt.Sprintf(`---
+apiVersion: v1
+kind: Service
+metadata:
+  name: %[2]s
+spec:
+  selector:
+    app: %[2]s
+  ports:
+    - name: http
+      port: 80
+      targetPort: %[4]d
+---
+apiVersion: apps/v1
+kind: %[1]s
+metadata:
+  name: %[2]s
+spec:%[3]s
+  replicas: 1
+  selector:
+    matchLabels:
+      app: %[2]s
+  template:
+    metadata:
+      labels:
+        app: %[2]s
+    spec:
+      containers:
+        - name: echo
+          image: jmalloc/echo-server:0.1.0
+          ports:
+            - containerPort: %[4]d
+`, kind, name, serviceName, itestEchoPort)
+}
+
+// integrationScenarios returns the scenarios in the order that they run. The connect scenario installs the
+// traffic-manager, and the uninstall scenario removes it, so the intercept scenarios must run between them.
+func integrationScenarios(ns, managerNS string) []itestScenario {
+	quit := itestStep{name: "quit", run: func(ctx context.Context, r *itestRunner) error {
+		_, err := r.telepresence(ctx, "quit")
+		return err
+	}}
+	connect := itestStep{name: "connect", run: func(ctx context.Context, r *itestRunner) error {
+		return r.expectOutput(ctx, "Connected to context", "connect")
+	}}
+
+	const echoName = "itest-echo"
+	scenarios := []itestScenario{{
+		name: "connect",
+		steps: []itestStep{
+			connect,
+			{name: "traffic-manager is ready", run: func(ctx context.Context, r *itestRunner) error {
+				_, err := r.kubectl(ctx, "", "--namespace", managerNS, "rollout", "status", "--watch", "deploy/traffic-manager")
+				return err
+			}},
+			{name: "status", run: func(ctx context.Context, r *itestRunner) error {
+				return r.expectOutput(ctx, "Connected", "status")
+			}},
+			{name: "apply workload", run: func(ctx context.Context, r *itestRunner) error {
+				if _, err := r.kubectl(ctx, itestManifest("Deployment", echoName), "--namespace", ns, "apply", "-f", "-"); err != nil {
+					return err
+				}
+				_, err := r.kubectl(ctx, "", "--namespace", ns, "rollout", "status", "--watch", "deploy/"+echoName)
+				return err
+			}},
+			{name: "service is reachable", run: func(ctx context.Context, r *itestRunner) error {
+				url := fmt.Sprintf("http://%s.%s", echoName, ns)
+				return eventually(ctx, 2*time.Second, func() error {
+					return httpGet(ctx, url)
+				})
+			}},
+			quit,
+		},
+		cleanup: func(ctx context.Context, r *itestRunner) {
+			_, _ = r.telepresence(ctx, "quit")
+			_, _ = r.kubectl(ctx, "", "--namespace", ns, "delete", "--ignore-not-found", "svc,deploy", echoName)
+		},
+	}}
+
+	for _, kind := range itestWorkloadKinds {
+		kind := kind
+		name := "itest-" + strings.ToLower(kind)
+		interceptName := name + "-" + ns
+		url := fmt.Sprintf("http://%s.%s", name, ns)
+		var local *itestLocalServer
+		scenarios = append(scenarios, itestScenario{
+			name: "intercept-" + strings.ToLower(kind),
+			steps: []itestStep{
+				{name: "start local server", run: func(ctx context.Context, r *itestRunner) (err error) {
+					local, err = startLocalServer(ctx, "intercepted "+name)
+					return err
+				}},
+				{name: "apply workload", run: func(ctx context.Context, r *itestRunner) error {
+					if _, err := r.kubectl(ctx, itestManifest(kind, name), "--namespace", ns, "apply", "-f", "-"); err != nil {
+						return err
+					}
+					_, err := r.kubectl(ctx, "", "--namespace", ns, "wait", "pod", "--selector", "app="+name, "--for", "condition=ready")
+					return err
+				}},
+				connect,
+				{name: "list", run: func(ctx context.Context, r *itestRunner) error {
+					return eventually(ctx, 2*time.Second, func() error {
+						return r.expectOutput(ctx, name, "list", "--namespace", ns)
+					})
+				}},
+				{name: "intercept", run: func(ctx context.Context, r *itestRunner) error {
+					return r.expectOutput(ctx, "Using "+kind+" "+name,
+						"intercept", "--namespace", ns, "--mount", "false", "--port", strconv.Itoa(local.port), name)
+				}},
+				{name: "list intercepted", run: func(ctx context.Context, r *itestRunner) error {
+					return r.expectOutput(ctx, name+": intercepted", "list", "--namespace", ns, "--intercepts")
+				}},
+				{name: "traffic reaches local server", run: func(ctx context.Context, r *itestRunner) error {
+					return eventually(ctx, 2*time.Second, func() error {
+						body, err := httpGetBody(ctx, url)
+						if err != nil {
+							return err
+						}
+						return expectContains(body, local.body)
+					})
+				}},
+				{name: "leave", run: func(ctx context.Context, r *itestRunner) error {
+					if _, err := r.telepresence(ctx, "leave", interceptName); err != nil {
+						return err
+					}
+					out, err := r.telepresence(ctx, "list", "--namespace", ns, "--intercepts")
+					if err != nil {
+						return err
+					}
+					return expectNotContains(out, name+": intercepted")
+				}},
+				{name: "traffic reaches workload", run: func(ctx context.Context, r *itestRunner) error {
+					return eventually(ctx, 2*time.Second, func() error {
+						body, err := httpGetBody(ctx, url)
+						if err != nil {
+							return err
+						}
+						return expectNotContains(body, local.body)
+					})
+				}},
+				{name: "uninstall agent", run: func(ctx context.Context, r *itestRunner) error {
+					if _, err := r.telepresence(ctx, "uninstall", "--namespace", ns, "--agent", name); err != nil {
+						return err
+					}
+					return eventually(ctx, 3*time.Second, func() error {
+						out, err := r.telepresence(ctx, "list", "--namespace", ns, "--agents")
+						if err != nil {
+							return err
+						}
+						return expectNotContains(out, name)
+					})
+				}},
+				quit,
+			},
+			cleanup: func(ctx context.Context, r *itestRunner) {
+				_, _ = r.telepresence(ctx, "quit")
+				_, _ = r.kubectl(ctx, "", "--namespace", ns, "delete", "--ignore-not-found", "svc,"+strings.ToLower(kind), name)
+				if local != nil {
+					local.close()
+					local = nil
+				}
+			},
+		})
+	}
+
+	scenarios = append(scenarios, itestScenario{
+		name: "uninstall",
+		steps: []itestStep{
+			connect,
+			{name: "uninstall everything", run: func(ctx context.Context, r *itestRunner) error {
+				_, err := r.telepresence(ctx, "uninstall", "--everything")
+				return err
+			}},
+			{name: "traffic-manager is removed", run: func(ctx context.Context, r *itestRunner) error {
+				return eventually(ctx, 2*time.Second, func() error {
+					out, err := r.kubectl(ctx, "", "--namespace", managerNS, "get", "deploy", "--ignore-not-found", "traffic-manager")
+					if err != nil {
+						return err
+					}
+					return expectNotContains(out, "traffic-manager")
+				})
+			}},
+			quit,
+		},
+		cleanup: func(ctx context.Context, r *itestRunner) {
+			_, _ = r.telepresence(ctx, "quit")
+		},
+	})
+	return scenarios
+}
+
+func httpGet(ctx context.Context, url string) error {
+	_, err := httpGetBody(ctx, url)
+	return err
+}
+
+func httpGetBody(ctx context.Context, url string) (string, error) {
+	rq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	rs, err := http.DefaultClient.Do(rq)
+	if err != nil {
+		return "", err
+	}
+	defer rs.Body.Close()
+	body, err := io.ReadAll(rs.Body)
+	if err != nil {
+		return "", err
+	}
+	if rs.StatusCode != http.StatusOK {
+		return "", errors.New(rs.Status)
+	}
+	return string(body), nil
+}
+
+// itestLocalServer is an HTTP server on the local machine that an intercept routes the traffic of a workload to.
+// It responds with its body, so that a response from it can be told apart from a response from the workload.
+// The server outlives the step that starts it, and runs until it's closed.
+type itestLocalServer struct {
+	cancel context.CancelFunc
+	port   int
+	body   string
+}
+
+func startLocalServer(ctx context.Context, body string) (*itestLocalServer, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(dcontext.WithoutCancel(ctx))
+	sc := &dhttp.ServerConfig{Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, body)
+	})}
+	go func() {
+		_ = sc.Serve(ctx, l)
+	}()
+	return &itestLocalServer{cancel: cancel, port: l.Addr().(*net.TCPAddr).Port, body: body}, nil
+}
+
+func (s *itestLocalServer) close() {
+	s.cancel()
+}
+
+func printIntegrationResults(out io.Writer, results []itestResult) {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SCENARIO\tSTEP\tRESULT\tDURATION")
+	for _, r := range results {
+		result := "ok"
+		if r.err != nil {
+			result = "FAIL"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.scenario, r.step, result, r.duration)
+	}
+	_ = tw.Flush()
+}
@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/yaml"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+func Test_selectScenarios(t *testing.T) {
+	scenarios := integrationScenarios("itest", "ambassador")
+	names := func(scs []itestScenario) []string {
+		ns := make([]string, len(scs))
+		for i, sc := range scs {
+			ns[i] = sc.name
+		}
+		return ns
+	}
+	assert.Equal(t, []string{"connect", "intercept-deployment", "intercept-replicaset", "intercept-statefulset", "uninstall"}, names(scenarios))
+	assert.Equal(t, names(scenarios), names(selectScenarios(scenarios, nil)))
+	assert.Equal(t, []string{"intercept-deployment", "intercept-replicaset", "intercept-statefulset"}, names(selectScenarios(scenarios, []string{"intercept"})))
+	assert.Equal(t, []string{"connect", "intercept-statefulset"}, names(selectScenarios(scenarios, []string{"connect", "intercept-s"})))
+	assert.Empty(t, selectScenarios(scenarios, []string{"nope"}))
+}
+
+func Test_itestManifest(t *testing.T) {
+	for _, kind := range itestWorkloadKinds {
+		t.Run(kind, func(t *testing.T) {
+			docs := strings.Split(itestManifest(kind, "echo"), "---\n")
+			require.Len(t, docs, 3)
+			var svc, wl struct {
+				Kind string `json:"kind"`
+				Spec struct {
+					ServiceName string `json:"serviceName"`
+					Selector    any    `json:"selector"`
+				} `json:"spec"`
+			}
+			require.NoError(t, yaml.Unmarshal([]byte(docs[1]), &svc))
+			require.NoError(t, yaml.Unmarshal([]byte(docs[2]), &wl))
+			assert.Equal(t, "Service", svc.Kind)
+			assert.Equal(t, kind, wl.Kind)
+			assert.NotNil(t, wl.Spec.Selector)
+			if kind == "StatefulSet" {
+				assert.Equal(t, "echo", wl.Spec.ServiceName)
+			} else {
+				assert.Empty(t, wl.Spec.ServiceName)
+			}
+		})
+	}
+}
+
+func Test_printIntegrationResults(t *testing.T) {
+	buf := &bytes.Buffer{}
+	printIntegrationResults(buf, []itestResult{
+		{scenario: "connect", step: "connect"},
+		{scenario: "connect", step: "status", err: errors.New("boom")},
+	})
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Regexp(t, `^connect\s+connect\s+ok\s`, lines[1])
+	assert.Regexp(t, `^connect\s+status\s+FAIL\s`, lines[2])
+}
+
+func Test_daemonsRunning(t *testing.T) {
+	running, err := daemonsRunning(`{"root_daemon":{},"user_daemon":{"ambassador_cloud":{}}}`)
+	require.NoError(t, err)
+	assert.False(t, running)
+
+	running, err = daemonsRunning(`{"root_daemon":{"running":true},"user_daemon":{"ambassador_cloud":{}}}`)
+	require.NoError(t, err)
+	assert.True(t, running)
+
+	running, err = daemonsRunning(`{"root_daemon":{},"user_daemon":{"running":true}}`)
+	require.NoError(t, err)
+	assert.True(t, running)
+
+	_, err = daemonsRunning("Root Daemon: Running")
+	assert.Error(t, err)
+}
+
+func Test_checkContext(t *testing.T) {
+	it := &integrationTestInfo{clusterName: "telepresence-itest"}
+	assert.NoError(t, it.checkContext("kind-telepresence-itest"))
+	assert.Error(t, it.checkContext("production"))
+	assert.Error(t, it.checkContext("kind-other"))
+
+	it.anyContext = true
+	assert.NoError(t, it.checkContext("production"))
+}
+
+func Test_integrationScenarios_interceptTraffic(t *testing.T) {
+	// The intercept scenarios verify that the traffic reaches the local server while intercepted, and the workload
+	// after the intercept is left.
+	for _, sc := range selectScenarios(integrationScenarios("itest", "ambassador"), []string{"intercept"}) {
+		var steps []string
+		for _, step := range sc.steps {
+			steps = append(steps, step.name)
+		}
+		assert.Subset(t, steps, []string{"start local server", "traffic reaches local server", "traffic reaches workload"}, sc.name)
+	}
+}
+
+func Test_localServer(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	s, err := startLocalServer(ctx, "intercepted echo")
+	require.NoError(t, err)
+	defer s.close()
+	body, err := httpGetBody(ctx, "http://127.0.0.1:"+strconv.Itoa(s.port))
+	require.NoError(t, err)
+	assert.Equal(t, "intercepted echo", body)
+}
@@ -2,6 +2,7 @@ package cli
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -9,11 +10,14 @@ import (
 
 	"github.com/spf13/cobra"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/telepresenceio/telepresence/rpc/v2/connector"
 	"github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/output"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 )
 
 type listInfo struct {
@@ -22,6 +26,7 @@ type listInfo struct {
 	onlyInterceptable bool
 	debug             bool
 	namespace         string
+	cluster           string
 	watch             bool
 }
 
@@ -40,12 +45,16 @@ func listCommand() *cobra.Command {
 	flags.BoolVarP(&s.onlyInterceptable, "only-interceptable", "o", true, "interceptable workloads only")
 	flags.BoolVar(&s.debug, "debug", false, "include debugging information")
 	flags.StringVarP(&s.namespace, "namespace", "n", "", "If present, the namespace scope for this CLI request")
-	flags.BoolVarP(&s.watch, "watch", "w", false, "watch a namespace. --agents and --intercepts are disabled if this flag is set")
+	flags.BoolVarP(&s.watch, "watch", "w", false, "watch a namespace and print the workloads that are added, modified, or deleted. --agents and --intercepts are disabled if this flag is set")
+	flags.StringVar(&s.cluster, "cluster", "", "list the workloads of this peered cluster instead of the connected cluster")
 	return cmd
 }
 
 // list requests a list current intercepts from the daemon
 func (s *listInfo) list(cmd *cobra.Command, _ []string) error {
+	if s.watch && s.cluster != "" {
+		return errcat.User.New("--watch cannot be used with --cluster")
+	}
 	stdout := cmd.OutOrStdout()
 	return withConnector(cmd, true, nil, func(ctx context.Context, cs *connectorState) error {
 		var filter connector.ListRequest_Filter
@@ -72,7 +81,7 @@ func (s *listInfo) list(cmd *cobra.Command, _ []string) error {
 
 		jsonOut := output.WantsJSONOutput(cmd.Flags())
 		if !s.watch {
-			r, err := cs.userD.List(ctx, &connector.ListRequest{Filter: filter, Namespace: s.namespace}, grpc.MaxCallRecvMsgSize(int(maxRecSize)))
+			r, err := cs.userD.List(ctx, &connector.ListRequest{Filter: filter, Namespace: s.namespace, Cluster: s.cluster}, grpc.MaxCallRecvMsgSize(int(maxRecSize)))
 			if err != nil {
 				return err
 			}
@@ -80,33 +89,92 @@ func (s *listInfo) list(cmd *cobra.Command, _ []string) error {
 			return nil
 		}
 
-		stream, err := cs.userD.WatchWorkloads(ctx, &connector.WatchWorkloadsRequest{Namespaces: []string{s.namespace}}, grpc.MaxCallRecvMsgSize(int(maxRecSize)))
+		stream, err := cs.userD.WatchWorkloadEvents(ctx, &connector.WatchWorkloadsRequest{Namespaces: []string{s.namespace}}, grpc.MaxCallRecvMsgSize(int(maxRecSize)))
 		if err != nil {
 			return err
 		}
-
-		ch := make(chan *connector.WorkloadInfoSnapshot)
-		go func() {
-			for {
-				r, err := stream.Recv()
-				if err != nil {
-					break
+		for {
+			delta, err := stream.Recv()
+			if err != nil {
+				if status.Code(err) == codes.Unimplemented {
+					// The user daemon predates workload events
+					return s.watchSnapshots(ctx, cs, stdout, jsonOut, maxRecSize)
 				}
-				ch <- r
+				if ctx.Err() != nil || errors.Is(err, io.EOF) {
+					return nil
+				}
+				return err
 			}
-		}()
+			s.printEvents(delta.Events, stdout, jsonOut)
+		}
+	})
+}
+
+// watchSnapshots prints the complete list of workloads each time that it changes.
+func (s *listInfo) watchSnapshots(ctx context.Context, cs *connectorState, stdout io.Writer, jsonOut bool, maxRecSize int64) error {
+	stream, err := cs.userD.WatchWorkloads(ctx, &connector.WatchWorkloadsRequest{Namespaces: []string{s.namespace}}, grpc.MaxCallRecvMsgSize(int(maxRecSize)))
+	if err != nil {
+		return err
+	}
 
-	looper:
+	ch := make(chan *connector.WorkloadInfoSnapshot)
+	go func() {
 		for {
-			select {
-			case r := <-ch:
-				s.printList(r.Workloads, stdout, jsonOut)
-			case <-ctx.Done():
-				break looper
+			r, err := stream.Recv()
+			if err != nil {
+				break
 			}
+			ch <- r
 		}
-		return nil
-	})
+	}()
+
+looper:
+	for {
+		select {
+		case r := <-ch:
+			s.printList(r.Workloads, stdout, jsonOut)
+		case <-ctx.Done():
+			break looper
+		}
+	}
+	return nil
+}
+
+// workloadEvent is the JSON representation of a connector.WorkloadEvent.
+type workloadEvent struct {
+	Type     string                  `json:"type"`
+	Workload *connector.WorkloadInfo `json:"workload"`
+}
+
+// printEvents prints one line for each workload that was added, modified, or deleted, or one JSON object
+// with all the events.
+func (s *listInfo) printEvents(events []*connector.WorkloadEvent, stdout io.Writer, jsonOut bool) {
+	if jsonOut {
+		streamerOut, _ := stdout.(output.StructuredStreamer)
+		if streamerOut == nil {
+			panic("writer not output.StructuredStreamer")
+		}
+		wes := make([]workloadEvent, len(events))
+		for i, e := range events {
+			wes[i] = workloadEvent{Type: strings.ToLower(e.Type.String()), Workload: e.Workload}
+		}
+		streamerOut.StructuredStream(wes, nil)
+		return
+	}
+
+	if len(events) == 0 {
+		fmt.Fprintln(stdout, "No Workloads (Deployments, StatefulSets, or ReplicaSets)")
+		return
+	}
+	for _, e := range events {
+		wl := e.Workload
+		switch e.Type {
+		case connector.WorkloadEvent_DELETED:
+			fmt.Fprintf(stdout, "%-8s %s\n", e.Type, wl.Name)
+		default:
+			fmt.Fprintf(stdout, "%-8s %s: %s\n", e.Type, wl.Name, s.workloadState(wl))
+		}
+	}
 }
 
 func (s *listInfo) printList(workloads []*connector.WorkloadInfo, stdout io.Writer, jsonOut bool) {
@@ -128,21 +196,6 @@ func (s *listInfo) printList(workloads []*connector.WorkloadInfo, stdout io.Writ
 		return
 	}
 
-	state := func(workload *connector.WorkloadInfo) string {
-		if iis := workload.InterceptInfos; len(iis) > 0 {
-			return DescribeIntercepts(iis, nil, s.debug)
-		}
-		ai := workload.AgentInfo
-		if ai != nil {
-			return "ready to intercept (traffic-agent already installed)"
-		}
-		if workload.NotInterceptableReason != "" {
-			return "not interceptable (traffic-agent not installed): " + workload.NotInterceptableReason
-		} else {
-			return "ready to intercept (traffic-agent not yet installed)"
-		}
-	}
-
 	if jsonOut {
 		streamerOut.StructuredStream(workloads, nil)
 	} else {
@@ -188,12 +241,27 @@ func (s *listInfo) printList(workloads []*connector.WorkloadInfo, stdout io.Writ
 				if includeNs {
 					n += "." + workload.Namespace
 				}
-				fmt.Fprintf(stdout, "%-*s: %s\n", nameLen, n, state(workload))
+				fmt.Fprintf(stdout, "%-*s: %s\n", nameLen, n, s.workloadState(workload))
 			}
 		}
 	}
 }
 
+func (s *listInfo) workloadState(workload *connector.WorkloadInfo) string {
+	if iis := workload.InterceptInfos; len(iis) > 0 {
+		return DescribeIntercepts(iis, nil, s.debug)
+	}
+	ai := workload.AgentInfo
+	if ai != nil {
+		return "ready to intercept (traffic-agent already installed)"
+	}
+	if workload.NotInterceptableReason != "" {
+		return "not interceptable (traffic-agent not installed): " + workload.NotInterceptableReason
+	} else {
+		return "ready to intercept (traffic-agent not yet installed)"
+	}
+}
+
 func DescribeIntercepts(iis []*manager.InterceptInfo, volumeMountsPrevented error, debug bool) string {
 	sb := strings.Builder{}
 	sb.WriteString("intercepted")
@@ -261,13 +329,7 @@ func describeIntercept(ii *manager.InterceptInfo, volumeMountsPrevented error, d
 	}()})
 
 	if ii.PreviewDomain != "" {
-		previewURL := ii.PreviewDomain
-		// Right now SystemA gives back domains with the leading "https://", but
-		// let's not rely on that.
-		if !strings.HasPrefix(previewURL, "https://") && !strings.HasPrefix(previewURL, "http://") {
-			previewURL = "https://" + previewURL
-		}
-		fields = append(fields, kv{"Preview URL", previewURL})
+		fields = append(fields, kv{"Preview URL", previewURL(ii.PreviewDomain)})
 	}
 	if l5Hostname := ii.GetPreviewSpec().GetIngress().GetL5Host(); l5Hostname != "" {
 		fields = append(fields, kv{"Layer 5 Hostname", l5Hostname})
@@ -288,3 +350,13 @@ func describeIntercept(ii *manager.InterceptInfo, volumeMountsPrevented error, d
 		}
 	}
 }
+
+// previewURL returns the URL of the given preview domain.
+func previewURL(previewDomain string) string {
+	// Right now SystemA gives back domains with the leading "https://", but
+	// let's not rely on that.
+	if !strings.HasPrefix(previewDomain, "https://") && !strings.HasPrefix(previewDomain, "http://") {
+		previewDomain = "https://" + previewDomain
+	}
+	return previewDomain
+}
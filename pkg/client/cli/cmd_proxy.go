@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/datawire/dlib/dcontext"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/proxy"
+	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+)
+
+func proxyCommand() *cobra.Command {
+	kubeFlags := pflag.NewFlagSet("Kubernetes flags", 0)
+	cmd := &cobra.Command{
+		Use:   "proxy [flags] <command> [args...]",
+		Args:  cobra.MinimumNArgs(1),
+		Short: "Run a command that reaches the cluster through a proxy",
+		Long: `Run a command that reaches the cluster through a proxy.
+
+The command is started with the HTTP_PROXY, HTTPS_PROXY, and ALL_PROXY environment variables pointing to a local
+SOCKS5 and HTTP proxy, which resolves names and dispatches connections using the traffic-manager. No root daemon is
+started and no routes are added, so this works on machines where the TUN device of "telepresence connect" can't
+be used. Only the command, and the commands that it starts, reach the cluster, and only when they honor the proxy
+environment variables.
+
+When not connected, a connect-only session that lasts until the command ends is created. The Kubernetes flags are
+used for that session.`,
+		Example: `  telepresence proxy curl http://echo.default
+  telepresence proxy -- wget -qO- http://echo.default`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			request := &connector.ConnectRequest{
+				KubeFlags:   kubeFlagMap(kubeFlags),
+				ConnectOnly: true,
+				NoNetwork:   true,
+			}
+			return cliutil.WithConnector(cmd.Context(), func(ctx context.Context, connectorClient connector.ConnectorClient) error {
+				ci, err := connectorClient.Status(ctx, &empty.Empty{})
+				if err != nil {
+					return err
+				}
+				if ci.Error == connector.ConnectInfo_DISCONNECTED {
+					var didConnect bool
+					if didConnect, ci, err = connect(ctx, connectorClient, cmd.OutOrStdout(), request); err != nil {
+						return err
+					}
+					if didConnect {
+						defer func() {
+							_ = cliutil.UserDaemonDisconnect(dcontext.WithoutCancel(ctx), false)
+						}()
+					}
+				}
+				return runWithProxy(ctx, ci.SessionInfo, args)
+			})
+		},
+	}
+	// Everything after the command is passed to the command, including its flags
+	cmd.Flags().SetInterspersed(false)
+
+	kubeConfig := genericclioptions.NewConfigFlags(false)
+	kubeConfig.Namespace = nil // "proxy", like "connect", doesn't take --namespace
+	kubeConfig.AddFlags(kubeFlags)
+	cmd.Flags().AddFlagSet(kubeFlags)
+	return cmd
+}
+
+// runWithProxy runs the given command with a proxy that uses the manager proxy of the user daemon.
+func runWithProxy(ctx context.Context, session *manager.SessionInfo, args []string) error {
+	conn, err := cliutil.DialConnector(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	srv := proxy.NewServer(proxy.NewManagerTunnel(manager.NewManagerClient(conn), session, client.GetConfig(ctx).Tunnel.Compression))
+	go func() {
+		if err := srv.Serve(ctx, l); err != nil {
+			dlog.Errorf(ctx, "proxy failed: %v", err)
+		}
+	}()
+
+	addr := l.Addr().String()
+	env := make(map[string]string, 6)
+	for k, v := range map[string]string{
+		"HTTP_PROXY":  "http://" + addr,
+		"HTTPS_PROXY": "http://" + addr,
+		"ALL_PROXY":   "socks5h://" + addr,
+	} {
+		env[k] = v
+		env[strings.ToLower(k)] = v
+	}
+	return proc.Run(ctx, env, args[0], args[1:]...)
+}
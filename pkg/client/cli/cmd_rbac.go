@@ -0,0 +1,316 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+	rbac "k8s.io/api/rbac/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+// The client features that the rbac command can generate roles for. Each feature includes the ones before it.
+const (
+	rbacConnect   = "connect"
+	rbacIntercept = "intercept"
+	rbacInstall   = "install"
+)
+
+type rbacInfo struct {
+	client           bool
+	manager          bool
+	feature          string
+	namespaces       []string
+	managerNamespace string
+	ephemeralAgents  bool
+	injectionMode    string
+	clientIdentity   string
+}
+
+func rbacCommand() *cobra.Command {
+	ri := rbacInfo{}
+	cmd := &cobra.Command{
+		Use:  "rbac",
+		Args: cobra.NoArgs,
+
+		Short: "Generate the minimal RBAC that the client or the traffic-manager needs",
+		Long: `Generate the Roles and ClusterRoles that grant the minimal set of permissions that a client needs to use
+the given feature, or that the traffic-manager needs. The roles of a client must be bound to the users or groups
+that use Telepresence. The roles of the traffic-manager come with bindings to its service account.
+
+The features are cumulative:
+  connect    connect to the cluster and list namespaces and services
+  intercept  also list workloads, and create intercepts and gather logs
+  install    also install, upgrade, and uninstall the traffic-manager`,
+		RunE: ri.run,
+	}
+	flags := cmd.Flags()
+	flags.BoolVar(&ri.client, "client", false, "Generate the roles of a client")
+	flags.BoolVar(&ri.manager, "manager", false, "Generate the roles of the traffic-manager")
+	flags.StringVar(&ri.feature, "for", rbacIntercept,
+		fmt.Sprintf("The client feature to generate roles for, one of %q, %q, or %q", rbacConnect, rbacIntercept, rbacInstall))
+	flags.StringSliceVar(&ri.namespaces, "namespaces", nil,
+		"Generate Roles that are limited to the given namespaces instead of ClusterRoles")
+	flags.StringVar(&ri.managerNamespace, "manager-namespace", "ambassador", "The namespace of the traffic-manager")
+	flags.BoolVar(&ri.ephemeralAgents, "ephemeral-agents", false,
+		"Permit the traffic-manager to add traffic-agents as ephemeral containers, see the ephemeralAgents.enabled chart value")
+	flags.StringVar(&ri.injectionMode, "injection-mode", string(agentconfig.SidecarInjectionMode),
+		"How the traffic-manager adds traffic-agents to pods, see the agentInjector.injectionMode chart value")
+	flags.StringVar(&ri.clientIdentity, "client-identity", "",
+		`How the traffic-manager verifies the identities of clients, "kubernetes" or "oidc", see the clientIdentity.method chart value`)
+	return cmd
+}
+
+func (ri *rbacInfo) run(cmd *cobra.Command, _ []string) error {
+	if ri.client == ri.manager {
+		return errcat.User.New("exactly one of --client and --manager must be given")
+	}
+	if ri.manager && cmd.Flag("for").Changed {
+		return errcat.User.New("--for can only be used together with --client")
+	}
+	if ri.client {
+		for _, f := range []string{"ephemeral-agents", "injection-mode", "client-identity"} {
+			if cmd.Flag(f).Changed {
+				return errcat.User.Newf("--%s can only be used together with --manager", f)
+			}
+		}
+	}
+	if _, err := agentconfig.NewInjectionMode(ri.injectionMode); err != nil {
+		return errcat.User.New(err)
+	}
+	switch ri.clientIdentity {
+	case "", "kubernetes", "oidc":
+	default:
+		return errcat.User.Newf("invalid value %q for --client-identity, must be %q or %q", ri.clientIdentity, "kubernetes", "oidc")
+	}
+	if ri.manager && len(ri.namespaces) > 0 {
+		found := false
+		for _, ns := range ri.namespaces {
+			if ns == ri.managerNamespace {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errcat.User.Newf("--namespaces must include the namespace of the traffic-manager, %q", ri.managerNamespace)
+		}
+	}
+	var objs []any
+	if ri.client {
+		switch ri.feature {
+		case rbacConnect, rbacIntercept, rbacInstall:
+		default:
+			return errcat.User.Newf("invalid value %q for --for, must be one of %q, %q, or %q", ri.feature, rbacConnect, rbacIntercept, rbacInstall)
+		}
+		objs = ri.clientRBAC()
+	} else {
+		objs = ri.managerRBAC()
+	}
+	return writeYAMLDocs(cmd.OutOrStdout(), objs)
+}
+
+func (ri *rbacInfo) clientRBAC() []any {
+	const name = "telepresence-client"
+	clusterRules := []rbac.PolicyRule{
+		policyRule("", []string{"namespaces", "services"}, "get", "list", "watch"),
+	}
+	nsRules := []rbac.PolicyRule{
+		// For gather-logs
+		policyRule("", []string{"pods/log"}, "get"),
+		policyRule("", []string{"pods"}, "list"),
+		// For describe intercept
+		policyRule("", []string{"events"}, "list"),
+		// Needed in order to maintain a list of workloads
+		policyRule("apps", []string{"deployments", "replicasets", "statefulsets"}, "get", "list", "watch"),
+		// Needed in order to wait for the pods of an intercepted Job or CronJob
+		policyRule("batch", []string{"jobs", "cronjobs"}, "get"),
+	}
+
+	var objs []any
+	if ri.feature != rbacConnect && len(ri.namespaces) == 0 {
+		clusterRules = append(clusterRules, nsRules...)
+	}
+	objs = append(objs, clusterRole(name, clusterRules))
+	if ri.feature != rbacConnect {
+		for _, ns := range ri.namespaces {
+			objs = append(objs, role(name, ns, nsRules))
+		}
+	}
+
+	// A port-forward to the traffic-manager is needed in order to connect.
+	objs = append(objs, role("traffic-manager-connect", ri.managerNamespace, []rbac.PolicyRule{
+		policyRule("", []string{"pods"}, "get", "list", "watch"),
+		policyRule("", []string{"pods/portforward"}, "create"),
+	}))
+
+	if ri.feature == rbacInstall {
+		// Installing the traffic-manager creates roles that grant permissions that the installer must
+		// either have or be permitted to escalate.
+		const installName = "telepresence-install"
+		manage := []string{"get", "list", "watch", "create", "update", "patch", "delete"}
+		grant := append([]string{"escalate", "bind"}, manage...)
+		objs = append(objs, clusterRole(installName, []rbac.PolicyRule{
+			policyRule("", []string{"namespaces"}, "get", "create"),
+			policyRule("rbac.authorization.k8s.io", []string{"clusterroles", "clusterrolebindings"}, grant...),
+			policyRule("admissionregistration.k8s.io", []string{"mutatingwebhookconfigurations"}, manage...),
+		}))
+		objs = append(objs, role(installName, ri.managerNamespace, []rbac.PolicyRule{
+			// The helm release is stored in a secret
+			policyRule("", []string{"configmaps", "pods", "secrets", "serviceaccounts", "services"}, manage...),
+			policyRule("apps", []string{"deployments"}, manage...),
+			policyRule("batch", []string{"jobs"}, manage...),
+			policyRule("rbac.authorization.k8s.io", []string{"roles", "rolebindings"}, grant...),
+		}))
+		for _, ns := range ri.namespaces {
+			if ns != ri.managerNamespace {
+				objs = append(objs, role(installName, ns, []rbac.PolicyRule{
+					policyRule("rbac.authorization.k8s.io", []string{"roles", "rolebindings"}, grant...),
+				}))
+			}
+		}
+	}
+	return objs
+}
+
+// managerRBAC returns the roles and bindings that the traffic-manager chart creates when it's installed with
+// the given settings. Test_managerRBAC verifies that they grant the same permissions as the chart.
+func (ri *rbacInfo) managerRBAC() []any {
+	nsRules := []rbac.PolicyRule{
+		// Needed for upgrade of older versions and for init-container-free redirection of target ports
+		policyRule("", []string{"services"}, "update"),
+		policyRule("", []string{"pods", "services"}, "list", "get", "watch"),
+		policyRule("", []string{"configmaps"}, "create"),
+		{
+			APIGroups:     []string{""},
+			Resources:     []string{"configmaps"},
+			ResourceNames: []string{agentconfig.ConfigMap, agentconfig.NodeAgentConfigMap, agentconfig.EphemeralAgentConfigMap},
+			Verbs:         []string{"list", "get", "watch", "update", "delete"},
+		},
+		// Needed to record events on the workloads that have agents or intercepts
+		policyRule("", []string{"events"}, "create", "patch"),
+		policyRule("apps", []string{"deployments", "replicasets", "statefulsets"}, "get", "list", "patch", "update"),
+		// The pods of an intercepted Job are restarted by evicting or deleting them
+		policyRule("batch", []string{"jobs", "cronjobs"}, "get", "list"),
+		policyRule("", []string{"pods"}, "delete"),
+		// The pods of ReplicaSets and Jobs are evicted, which respects their PodDisruptionBudgets
+		policyRule("", []string{"pods/eviction"}, "create"),
+		// Argo Rollouts and Knative Services are accessed using the dynamic client
+		policyRule("argoproj.io", []string{"rollouts"}, "get", "list", "patch", "update"),
+		policyRule("serving.knative.dev", []string{"services"}, "get", "list", "patch", "update"),
+		// Needed to pause the autoscalers of intercepted workloads
+		policyRule("autoscaling", []string{"horizontalpodautoscalers"}, "get", "list", "patch"),
+		policyRule("autoscaling.k8s.io", []string{"verticalpodautoscalers"}, "get", "list", "patch"),
+	}
+	if ri.ephemeralAgents {
+		// Needed to add traffic-agents to running pods as ephemeral containers
+		nsRules = append(nsRules, policyRule("", []string{"pods/ephemeralcontainers"}, "update", "patch"))
+	}
+	if agentconfig.InjectionMode(ri.injectionMode) == agentconfig.NodeInjectionMode {
+		// Needed to assign the traffic-agents that the traffic-node-agents run to the pods
+		nsRules = append(nsRules, policyRule("", []string{"pods"}, "patch"))
+	}
+	sa := rbac.Subject{Kind: rbac.ServiceAccountKind, Name: install.ManagerAppName, Namespace: ri.managerNamespace}
+
+	var objs []any
+	if ri.clientIdentity != "" {
+		// TokenReviews and SubjectAccessReviews are cluster-scoped, also when the other roles are namespaced
+		var rules []rbac.PolicyRule
+		if ri.clientIdentity == "kubernetes" {
+			rules = append(rules, policyRule("authentication.k8s.io", []string{"tokenreviews"}, "create"))
+		}
+		rules = append(rules, policyRule("authorization.k8s.io", []string{"subjectaccessreviews"}, "create"))
+		name := install.ManagerAppName + "-tokenreview-" + ri.managerNamespace
+		objs = append(objs, clusterRole(name, rules), clusterRoleBinding(name, sa))
+	}
+	if len(ri.namespaces) == 0 {
+		name := install.ManagerAppName + "-" + ri.managerNamespace
+		rules := append([]rbac.PolicyRule{
+			policyRule("", []string{"nodes"}, "list", "get", "watch"),
+			// Needed to be able to find the cluster DNS resolver
+			policyRule("", []string{"namespaces"}, "get", "list"),
+		}, nsRules...)
+		objs = append(objs, clusterRole(name, rules), clusterRoleBinding(name, sa))
+	}
+	namespaces := ri.namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{ri.managerNamespace}
+	}
+	for _, ns := range namespaces {
+		var rules []rbac.PolicyRule
+		if len(ri.namespaces) > 0 {
+			rules = append(rules, nsRules...)
+		}
+		if ns == ri.managerNamespace {
+			// Must be able to make an unsuccessful attempt to create a dummy service in order to receive
+			// the error message containing correct service CIDR
+			rules = append(rules, policyRule("", []string{"services"}, "create"))
+			if len(ri.namespaces) > 0 {
+				// Must be able to get the manager namespace in order to get the cluster-id
+				rules = append(rules, rbac.PolicyRule{
+					APIGroups:     []string{""},
+					Resources:     []string{"namespaces"},
+					ResourceNames: []string{ns},
+					Verbs:         []string{"get"},
+				})
+			}
+		}
+		if len(rules) == 0 {
+			continue
+		}
+		objs = append(objs, role(install.ManagerAppName, ns, rules), &rbac.RoleBinding{
+			TypeMeta:   meta.TypeMeta{APIVersion: rbac.SchemeGroupVersion.String(), Kind: "RoleBinding"},
+			ObjectMeta: meta.ObjectMeta{Name: install.ManagerAppName, Namespace: ns},
+			RoleRef:    rbac.RoleRef{APIGroup: rbac.GroupName, Kind: "Role", Name: install.ManagerAppName},
+			Subjects:   []rbac.Subject{sa},
+		})
+	}
+	return objs
+}
+
+func policyRule(group string, resources []string, verbs ...string) rbac.PolicyRule {
+	return rbac.PolicyRule{APIGroups: []string{group}, Resources: resources, Verbs: verbs}
+}
+
+func clusterRole(name string, rules []rbac.PolicyRule) *rbac.ClusterRole {
+	return &rbac.ClusterRole{
+		TypeMeta:   meta.TypeMeta{APIVersion: rbac.SchemeGroupVersion.String(), Kind: "ClusterRole"},
+		ObjectMeta: meta.ObjectMeta{Name: name},
+		Rules:      rules,
+	}
+}
+
+func clusterRoleBinding(name string, sa rbac.Subject) *rbac.ClusterRoleBinding {
+	return &rbac.ClusterRoleBinding{
+		TypeMeta:   meta.TypeMeta{APIVersion: rbac.SchemeGroupVersion.String(), Kind: "ClusterRoleBinding"},
+		ObjectMeta: meta.ObjectMeta{Name: name},
+		RoleRef:    rbac.RoleRef{APIGroup: rbac.GroupName, Kind: "ClusterRole", Name: name},
+		Subjects:   []rbac.Subject{sa},
+	}
+}
+
+func role(name, namespace string, rules []rbac.PolicyRule) *rbac.Role {
+	return &rbac.Role{
+		TypeMeta:   meta.TypeMeta{APIVersion: rbac.SchemeGroupVersion.String(), Kind: "Role"},
+		ObjectMeta: meta.ObjectMeta{Name: name, Namespace: namespace},
+		Rules:      rules,
+	}
+}
+
+// writeYAMLDocs writes the given objects as a stream of YAML documents.
+func writeYAMLDocs(out io.Writer, objs []any) error {
+	for _, obj := range objs {
+		doc, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		if _, err = fmt.Fprintf(out, "---\n%s", doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	rbac "k8s.io/api/rbac/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+func grants(rules []rbac.PolicyRule, resource, verb string) bool {
+	for _, r := range rules {
+		for _, res := range r.Resources {
+			if res != resource {
+				continue
+			}
+			for _, v := range r.Verbs {
+				if v == verb {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func Test_clientRBAC(t *testing.T) {
+	rolesByName := func(objs []any) (map[string]*rbac.ClusterRole, map[string]*rbac.Role) {
+		crs := make(map[string]*rbac.ClusterRole)
+		rs := make(map[string]*rbac.Role)
+		for _, obj := range objs {
+			switch o := obj.(type) {
+			case *rbac.ClusterRole:
+				crs[o.Name] = o
+			case *rbac.Role:
+				rs[o.Namespace+"/"+o.Name] = o
+			}
+		}
+		return crs, rs
+	}
+
+	t.Run("connect", func(t *testing.T) {
+		ri := rbacInfo{client: true, feature: rbacConnect, managerNamespace: "ambassador"}
+		crs, rs := rolesByName(ri.clientRBAC())
+		require.Contains(t, crs, "telepresence-client")
+		assert.True(t, grants(crs["telepresence-client"].Rules, "namespaces", "list"))
+		assert.False(t, grants(crs["telepresence-client"].Rules, "deployments", "list"))
+		require.Contains(t, rs, "ambassador/traffic-manager-connect")
+		assert.True(t, grants(rs["ambassador/traffic-manager-connect"].Rules, "pods/portforward", "create"))
+		assert.NotContains(t, crs, "telepresence-install")
+	})
+
+	t.Run("intercept namespaced", func(t *testing.T) {
+		ri := rbacInfo{client: true, feature: rbacIntercept, namespaces: []string{"dev", "test"}, managerNamespace: "ambassador"}
+		crs, rs := rolesByName(ri.clientRBAC())
+		assert.False(t, grants(crs["telepresence-client"].Rules, "deployments", "list"))
+		for _, ns := range ri.namespaces {
+			require.Contains(t, rs, ns+"/telepresence-client")
+			assert.True(t, grants(rs[ns+"/telepresence-client"].Rules, "deployments", "watch"))
+			assert.True(t, grants(rs[ns+"/telepresence-client"].Rules, "pods/log", "get"))
+		}
+	})
+
+	t.Run("install", func(t *testing.T) {
+		ri := rbacInfo{client: true, feature: rbacInstall, managerNamespace: "ambassador"}
+		crs, rs := rolesByName(ri.clientRBAC())
+		assert.True(t, grants(crs["telepresence-client"].Rules, "deployments", "list"))
+		require.Contains(t, crs, "telepresence-install")
+		assert.True(t, grants(crs["telepresence-install"].Rules, "clusterroles", "escalate"))
+		require.Contains(t, rs, "ambassador/telepresence-install")
+		assert.True(t, grants(rs["ambassador/telepresence-install"].Rules, "secrets", "create"))
+	})
+}
+
+func Test_rbacCommand(t *testing.T) {
+	run := func(args ...string) (string, error) {
+		cmd := rbacCommand()
+		out := &bytes.Buffer{}
+		cmd.SetOut(out)
+		cmd.SetErr(out)
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		return out.String(), err
+	}
+
+	_, err := run()
+	assert.Error(t, err)
+	_, err = run("--client", "--manager")
+	assert.Error(t, err)
+	_, err = run("--client", "--for", "everything")
+	assert.Error(t, err)
+	_, err = run("--manager", "--namespaces", "dev")
+	assert.Error(t, err)
+	_, err = run("--client", "--ephemeral-agents")
+	assert.Error(t, err)
+	_, err = run("--manager", "--injection-mode", "pod")
+	assert.Error(t, err)
+	_, err = run("--manager", "--client-identity", "ldap")
+	assert.Error(t, err)
+
+	out, err := run("--manager")
+	require.NoError(t, err)
+	assert.Contains(t, out, "kind: ClusterRoleBinding\n")
+	assert.Contains(t, out, "name: traffic-manager-ambassador\n")
+
+	out, err = run("--manager", "--manager-namespace", "tp", "--namespaces", "tp,dev")
+	require.NoError(t, err)
+	assert.NotContains(t, out, "kind: ClusterRole")
+	assert.Contains(t, out, "namespace: dev\n")
+}
+
+// renderManagerRBAC renders the roles and bindings of the traffic-manager chart using the given values.
+func renderManagerRBAC(t *testing.T, namespace string, values map[string]any) []any {
+	chrt, err := loader.LoadDir(filepath.Join("..", "..", "..", "charts", "telepresence"))
+	require.NoError(t, err)
+	vals, err := chartutil.ToRenderValues(chrt, values, chartutil.ReleaseOptions{
+		Name:      install.ManagerAppName,
+		Namespace: namespace,
+		IsInstall: true,
+	}, chartutil.DefaultCapabilities)
+	require.NoError(t, err)
+	files, err := engine.Render(chrt, vals)
+	require.NoError(t, err)
+
+	var objs []any
+	for name, content := range files {
+		if !strings.HasPrefix(name, "telepresence/templates/trafficManagerRbac/") {
+			continue
+		}
+		for _, doc := range strings.Split(content, "\n---\n") {
+			var tm meta.TypeMeta
+			require.NoError(t, yaml.Unmarshal([]byte(doc), &tm))
+			var obj any
+			switch tm.Kind {
+			case "ClusterRole":
+				obj = &rbac.ClusterRole{}
+			case "ClusterRoleBinding":
+				obj = &rbac.ClusterRoleBinding{}
+			case "Role":
+				obj = &rbac.Role{}
+			case "RoleBinding":
+				obj = &rbac.RoleBinding{}
+			default:
+				continue
+			}
+			require.NoError(t, yaml.Unmarshal([]byte(doc), obj))
+			objs = append(objs, obj)
+		}
+	}
+	return objs
+}
+
+// rbacGrants returns the roles and bindings of the given objects keyed by kind, namespace, and name. Roles are
+// represented by the sorted set of the permissions that they grant, so that the same permissions compare equal
+// regardless of how they are grouped into rules.
+func rbacGrants(objs []any) map[string][]string {
+	flatten := func(rules []rbac.PolicyRule) []string {
+		var gs []string
+		for _, r := range rules {
+			names := r.ResourceNames
+			if len(names) == 0 {
+				names = []string{""}
+			}
+			for _, g := range r.APIGroups {
+				for _, res := range r.Resources {
+					for _, n := range names {
+						for _, v := range r.Verbs {
+							gs = append(gs, fmt.Sprintf("%s:%s:%s:%s", g, res, n, v))
+						}
+					}
+				}
+			}
+		}
+		sort.Strings(gs)
+		return gs
+	}
+	binding := func(ref rbac.RoleRef, subjects []rbac.Subject) []string {
+		bs := []string{ref.Kind + "/" + ref.Name}
+		for _, s := range subjects {
+			bs = append(bs, s.Kind+"/"+s.Name+"."+s.Namespace)
+		}
+		return bs
+	}
+	grants := make(map[string][]string)
+	for _, obj := range objs {
+		switch o := obj.(type) {
+		case *rbac.ClusterRole:
+			grants["ClusterRole/"+o.Name] = flatten(o.Rules)
+		case *rbac.Role:
+			grants["Role/"+o.Name+"."+o.Namespace] = flatten(o.Rules)
+		case *rbac.ClusterRoleBinding:
+			grants["ClusterRoleBinding/"+o.Name] = binding(o.RoleRef, o.Subjects)
+		case *rbac.RoleBinding:
+			grants["RoleBinding/"+o.Name+"."+o.Namespace] = binding(o.RoleRef, o.Subjects)
+		}
+	}
+	return grants
+}
+
+func Test_managerRBAC(t *testing.T) {
+	tests := []struct {
+		name   string
+		ri     rbacInfo
+		values map[string]any
+	}{
+		{
+			name:   "cluster",
+			ri:     rbacInfo{managerNamespace: "ambassador", injectionMode: "sidecar"},
+			values: map[string]any{},
+		},
+		{
+			name: "namespaced",
+			ri:   rbacInfo{managerNamespace: "tp", namespaces: []string{"tp", "dev"}, injectionMode: "sidecar"},
+			values: map[string]any{
+				"managerRbac": map[string]any{"namespaced": true, "namespaces": []any{"tp", "dev"}},
+			},
+		},
+		{
+			name: "ephemeral agents and node injection",
+			ri:   rbacInfo{managerNamespace: "ambassador", ephemeralAgents: true, injectionMode: "node"},
+			values: map[string]any{
+				"ephemeralAgents": map[string]any{"enabled": true},
+				"agentInjector":   map[string]any{"injectionMode": "node"},
+			},
+		},
+		{
+			name: "namespaced with kubernetes client identity",
+			ri:   rbacInfo{managerNamespace: "tp", namespaces: []string{"tp"}, injectionMode: "node", clientIdentity: "kubernetes"},
+			values: map[string]any{
+				"managerRbac":    map[string]any{"namespaced": true, "namespaces": []any{"tp"}},
+				"agentInjector":  map[string]any{"injectionMode": "node"},
+				"clientIdentity": map[string]any{"method": "kubernetes"},
+			},
+		},
+		{
+			name: "oidc client identity",
+			ri:   rbacInfo{managerNamespace: "ambassador", injectionMode: "sidecar", clientIdentity: "oidc"},
+			values: map[string]any{
+				"clientIdentity": map[string]any{"method": "oidc", "oidc": map[string]any{"issuerURL": "https://idp.example.com", "clientID": "telepresence"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, rbacGrants(renderManagerRBAC(t, tt.ri.managerNamespace, tt.values)), rbacGrants(tt.ri.managerRBAC()))
+		})
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package cli
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// platformCommands returns nil. Only Windows has platform specific commands.
+func platformCommands() []*cobra.Command {
+	return nil
+}
@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// platformCommands returns the commands that are specific to Windows.
+func platformCommands() []*cobra.Command {
+	return []*cobra.Command{serviceCommand()}
+}
+
+func serviceCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:  "service",
+		Args: cobra.NoArgs,
+
+		Short: "Install or uninstall the Windows service that runs the root daemon",
+		Long: `Install or uninstall the Windows service that runs the root daemon.
+
+Without the service, Telepresence shows a UAC prompt each time it starts the root daemon. Once the service is
+installed, Telepresence starts the service instead. The service must be installed by the user who will use it,
+from a prompt that runs as Administrator.`,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return errcat.User.New("please run service as \"service install\" or \"service uninstall\"")
+		},
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "install",
+		Args:  cobra.NoArgs,
+		Short: "Install the Windows service that runs the root daemon",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if err := cliutil.InstallDaemonService(cmd.Context()); err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "Telepresence Root Daemon service installed")
+			return nil
+		},
+	}, &cobra.Command{
+		Use:   "uninstall",
+		Args:  cobra.NoArgs,
+		Short: "Stop and uninstall the Windows service that runs the root daemon",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if err := cliutil.UninstallDaemonService(cmd.Context()); err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "Telepresence Root Daemon service uninstalled")
+			return nil
+		},
+	})
+	return cmd
+}
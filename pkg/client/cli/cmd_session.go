@@ -0,0 +1,494 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/moby/term"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+	"gopkg.in/yaml.v3"
+
+	"github.com/datawire/dlib/dgroup"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/extensions"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// sessionFile is the definition of a session that "session export" writes and "session import" re-creates.
+type sessionFile struct {
+	// Context is the kubeconfig context of the session.
+	Context string `yaml:"context,omitempty"`
+
+	// Namespace is the connected namespace. It's the namespace of intercepts that don't declare one.
+	Namespace string `yaml:"namespace,omitempty"`
+
+	MappedNamespaces []string `yaml:"mappedNamespaces,omitempty"`
+	AllowDocker      bool     `yaml:"allowDocker,omitempty"`
+	NamespaceSandbox bool     `yaml:"namespaceSandbox,omitempty"`
+
+	Intercepts []*cache.InterceptRecord `yaml:"intercepts,omitempty"`
+}
+
+func sessionCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:  "session",
+		Args: OnlySubcommands,
+
+		Short: "Export the current session to a file, or re-create a session from such a file",
+		RunE:  RunSubcommands,
+	}
+	cmd.AddCommand(sessionExportCommand(), sessionImportCommand())
+	return cmd
+}
+
+func sessionExportCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:  "export",
+		Args: cobra.NoArgs,
+
+		Short: "Write the definition of the current session to stdout",
+		Long: `Write the definition of the current session to stdout, as YAML.
+
+The definition contains the kubeconfig context, the connected and mapped namespaces, and the intercepts of the
+session together with their handlers and the files that their environments are written to. Use
+"telepresence session import" to re-create the session from the definition, e.g. on the machine of a teammate.`,
+		Example: `  telepresence session export > team.yaml`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return exportSession(cmd)
+		},
+	}
+}
+
+func sessionImportCommand() *cobra.Command {
+	var switchContext, yes bool
+	cmd := &cobra.Command{
+		Use:  "import [flags] <file>",
+		Args: cobra.ExactArgs(1),
+
+		Short: "Connect and create the intercepts of the session definition in a file",
+		Long: `Connect and create the intercepts of the session definition in a file, as written by "telepresence session export".
+Use - as the file to read the definition from stdin.
+
+Intercepts without a handler are retained, like the ones of "telepresence intercept" without a command. When
+intercepts have handlers, the handlers are started and the command waits for them to exit, and removes their
+intercepts when they do.
+
+The handlers that will run, the files that will be written, and the mount points that will be used, are printed
+before anything is done, and must be confirmed unless --yes is given. The files that the environments are written
+to must be relative paths within the current directory.`,
+		Example:  `  telepresence session import team.yaml`,
+		PreRunE:  updateCheckIfDue,
+		PostRunE: raiseCloudMessage,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return importSession(cmd, args[0], switchContext, yes)
+		},
+	}
+	flags := cmd.Flags()
+	flags.BoolVar(&switchContext, "switch-context", false, ``+
+		`Switch to the context of the file when already connected using another one, without restarting the daemons. `+
+		`Intercepts of the current session are removed`)
+	flags.BoolVarP(&yes, "yes", "y", false, "Run the handlers and write the files of the definition without asking")
+	return cmd
+}
+
+func exportSession(cmd *cobra.Command) error {
+	return cliutil.WithStartedConnector(cmd.Context(), false, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
+		ci, err := connectorClient.Status(ctx, &empty.Empty{})
+		if err != nil {
+			return err
+		}
+		if ci.Error != connector.ConnectInfo_ALREADY_CONNECTED {
+			return cliutil.ErrNoTrafficManager
+		}
+		records, err := cache.LoadInterceptRecordsFromUserCache(ctx)
+		if err != nil {
+			return err
+		}
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		sf := newSessionFile(ci, records)
+		for _, rec := range sf.Intercepts {
+			if msg := makePortable(rec, cwd); msg != "" {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Intercept %s: %s\n", rec.Name, msg)
+			}
+		}
+		data, err := yaml.Marshal(sf)
+		if err != nil {
+			return err
+		}
+		_, err = cmd.OutOrStdout().Write(data)
+		return err
+	})
+}
+
+// newSessionFile returns the definition of the session with the given info. The intercepts are described by the
+// given records when they exist, and by what the traffic-manager knows about them otherwise, in which case their
+// handlers and environment files are unknown.
+func newSessionFile(ci *connector.ConnectInfo, records map[string]*cache.InterceptRecord) *sessionFile {
+	sf := &sessionFile{
+		Context:          ci.ClusterContext,
+		Namespace:        ci.Namespace,
+		MappedNamespaces: ci.MappedNamespaces,
+		AllowDocker:      len(ci.DockerDnsIp) > 0,
+		NamespaceSandbox: ci.NetNamespace != "",
+	}
+	for _, ii := range ci.GetIntercepts().GetIntercepts() {
+		rec, ok := records[ii.Spec.Name]
+		if ok {
+			// The record is owned by the cache and must not be modified by the export.
+			cp := *rec
+			rec = &cp
+		} else {
+			rec = interceptRecordFromInfo(ii)
+		}
+		sf.Intercepts = append(sf.Intercepts, rec)
+	}
+	sort.Slice(sf.Intercepts, func(i, j int) bool {
+		return sf.Intercepts[i].Name < sf.Intercepts[j].Name
+	})
+	return sf
+}
+
+// makePortable removes the parts of the given record that only make sense on this machine. A mount point is
+// replaced with "true", and the paths of the environment files are made relative to the given directory. Paths
+// outside the directory are dropped. The returned string describes what was dropped, if anything.
+func makePortable(rec *cache.InterceptRecord, cwd string) string {
+	if rec.Mount != "" {
+		if _, err := strconv.ParseBool(rec.Mount); err != nil {
+			rec.Mount = "true"
+		}
+	}
+	e := rec.Env
+	if e == nil {
+		return ""
+	}
+	cp := *e
+	rec.Env = &cp
+	var dropped []string
+	portable := func(path string) string {
+		if path == "" {
+			return ""
+		}
+		if filepath.IsAbs(path) {
+			rel, err := filepath.Rel(cwd, path)
+			if err != nil || !isLocalPath(rel) {
+				dropped = append(dropped, path)
+				return ""
+			}
+			return rel
+		}
+		return path
+	}
+	cp.File = portable(cp.File)
+	cp.JSON = portable(cp.JSON)
+	if cp.File == "" && cp.JSON == "" {
+		rec.Env = nil
+	}
+	if len(dropped) > 0 {
+		return "environment files outside the current directory are not exported: " + strings.Join(dropped, ", ")
+	}
+	return ""
+}
+
+// isLocalPath returns true if the given path is relative and doesn't leave the directory that it's relative to.
+func isLocalPath(path string) bool {
+	if filepath.IsAbs(path) || filepath.VolumeName(path) != "" {
+		return false
+	}
+	path = filepath.Clean(path)
+	return path != ".." && !strings.HasPrefix(path, ".."+string(filepath.Separator))
+}
+
+func interceptRecordFromInfo(ii *manager.InterceptInfo) *cache.InterceptRecord {
+	spec := ii.Spec
+	rec := &cache.InterceptRecord{
+		Name:      spec.Name,
+		Workload:  spec.Agent,
+		Namespace: spec.Namespace,
+	}
+	if spec.Agent == "" {
+		rec.LocalOnly = true
+		return rec
+	}
+	rec.Replace = spec.Replace
+	rec.Port = strconv.Itoa(int(spec.TargetPort))
+	if spec.ServicePortIdentifier != "" {
+		rec.Port += ":" + spec.ServicePortIdentifier
+	}
+	rec.Service = spec.ServiceName
+	rec.Mechanism = spec.Mechanism
+	rec.MechanismArgs = spec.MechanismArgs
+	rec.Mount = strconv.FormatBool(ii.ClientMountPoint != "")
+	for _, p := range spec.ExtraPorts {
+		rec.ToPod = append(rec.ToPod, strconv.Itoa(int(p)))
+	}
+	rec.PreviewURL = ii.PreviewDomain != ""
+	rec.PinScale = spec.PinScale
+	rec.Ephemeral = spec.Ephemeral
+	rec.Cluster = spec.Cluster
+	return rec
+}
+
+func importSession(cmd *cobra.Command, file string, switchContext, yes bool) error {
+	sf, err := readSessionFile(cmd.InOrStdin(), file)
+	if err != nil {
+		return err
+	}
+	if !yes {
+		if err = confirmSessionFile(cmd, file, sf); err != nil {
+			return err
+		}
+	}
+	request := &connector.ConnectRequest{
+		KubeFlags:        map[string]string{},
+		MappedNamespaces: sf.MappedNamespaces,
+		AllowDocker:      sf.AllowDocker,
+		SwitchContext:    switchContext,
+		NamespaceSandbox: sf.NamespaceSandbox,
+	}
+	if sf.Context != "" {
+		request.KubeFlags["context"] = sf.Context
+	}
+	return withConnector(cmd, true, request, func(ctx context.Context, cs *connectorState) error {
+		return cliutil.WithManager(ctx, func(ctx context.Context, managerClient manager.ManagerClient) error {
+			var handled []*interceptState
+			for _, rec := range sf.Intercepts {
+				ia, err := sf.interceptArgs(ctx, rec)
+				if err != nil {
+					return err
+				}
+				is := newInterceptState(ctx, safeCobraCommandImpl{cmd}, ia, cs, managerClient)
+				defer is.scout.Close()
+				if rec.Handler != nil {
+					handled = append(handled, is)
+					continue
+				}
+				if err = client.WithEnsuredState(ctx, is, true, func() error { return nil }); err != nil {
+					return err
+				}
+			}
+			if len(handled) == 0 {
+				return nil
+			}
+			return withInterceptStates(ctx, handled, func() error {
+				grp := dgroup.NewGroup(ctx, dgroup.GroupConfig{DisableLogging: true})
+				for _, is := range handled {
+					grp.Go(is.args.name, is.runCommand)
+				}
+				return grp.Wait()
+			})
+		})
+	})
+}
+
+// confirmSessionFile prints the handlers, environment files, and mount points of the given session file, and
+// asks the user to confirm them. Files that have none of them are imported without asking.
+func confirmSessionFile(cmd *cobra.Command, file string, sf *sessionFile) error {
+	var sb strings.Builder
+	for _, rec := range sf.Intercepts {
+		var lines []string
+		if h := rec.Handler; h != nil {
+			if h.DockerRun {
+				lines = append(lines, "runs: docker run "+strings.Join(h.Command, " "))
+				if h.DockerMount != "" {
+					lines = append(lines, "mounts in container: "+h.DockerMount)
+				}
+			} else {
+				lines = append(lines, "runs: "+strings.Join(h.Command, " "))
+			}
+		}
+		if e := rec.Env; e != nil {
+			if e.File != "" {
+				lines = append(lines, "writes: "+e.File)
+			}
+			if e.JSON != "" {
+				lines = append(lines, "writes: "+e.JSON)
+			}
+		}
+		if rec.Mount != "" {
+			if _, err := strconv.ParseBool(rec.Mount); err != nil {
+				lines = append(lines, "mounts at: "+rec.Mount)
+			}
+		}
+		if len(lines) > 0 {
+			fmt.Fprintf(&sb, "Intercept %s\n", rec.Name)
+			for _, l := range lines {
+				fmt.Fprintf(&sb, "  %s\n", l)
+			}
+		}
+	}
+	if sb.Len() == 0 {
+		return nil
+	}
+	out := cmd.OutOrStdout()
+	fmt.Fprint(out, sb.String())
+	if file == "-" || !term.IsTerminal(os.Stdin.Fd()) {
+		return errcat.User.New("use --yes to import a session file with handlers, environment files, or mount points non-interactively")
+	}
+	fmt.Fprint(out, "Import the session? [y/N]: ")
+	reply, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(strings.TrimSpace(reply)) {
+	case "y", "yes":
+		return nil
+	default:
+		return errcat.User.New("session import aborted")
+	}
+}
+
+func readSessionFile(stdin io.Reader, file string) (*sessionFile, error) {
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = io.ReadAll(stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return nil, errcat.User.New(err)
+	}
+	var sf sessionFile
+	if err = yaml.Unmarshal(data, &sf); err != nil {
+		return nil, errcat.User.Newf("unable to parse session file %s: %w", file, err)
+	}
+	names := make(map[string]struct{}, len(sf.Intercepts))
+	for _, rec := range sf.Intercepts {
+		switch {
+		case rec.Name == "":
+			return nil, errcat.User.Newf("session file %s has an intercept without a name", file)
+		case !rec.LocalOnly && rec.Workload == "":
+			return nil, errcat.User.Newf("intercept %s of session file %s has no workload", rec.Name, file)
+		case rec.Handler != nil && len(rec.Handler.Command) == 0 && !rec.Handler.DockerRun:
+			return nil, errcat.User.Newf("the handler of intercept %s of session file %s has no command", rec.Name, file)
+		}
+		if e := rec.Env; e != nil {
+			for _, path := range []string{e.File, e.JSON} {
+				if path != "" && !isLocalPath(path) {
+					return nil, errcat.User.Newf(
+						"the environment file %s of intercept %s of session file %s must be a relative path within the current directory",
+						path, rec.Name, file)
+				}
+			}
+		}
+		if _, ok := names[rec.Name]; ok {
+			return nil, errcat.User.Newf("session file %s has more than one intercept named %s", file, rec.Name)
+		}
+		names[rec.Name] = struct{}{}
+	}
+	return &sf, nil
+}
+
+// interceptArgs returns the arguments of the intercept that the given record describes. The mechanism of the
+// intercept is applied to the flags of the intercept extensions, just like when the flags are given to the
+// intercept command.
+func (sf *sessionFile) interceptArgs(ctx context.Context, rec *cache.InterceptRecord) (interceptArgs, error) {
+	flags := pflag.NewFlagSet(rec.Name, pflag.ContinueOnError)
+	extState, err := extensions.LoadExtensions(ctx, flags)
+	if err != nil {
+		return interceptArgs{}, err
+	}
+	if err = flags.Parse(mechanismFlags(rec.Mechanism, rec.MechanismArgs)); err != nil {
+		return interceptArgs{}, errcat.User.Newf("invalid mechanism of intercept %s: %w", rec.Name, err)
+	}
+	requiresLogin, err := extState.RequiresAPIKeyOrLicense()
+	if err != nil {
+		return interceptArgs{}, errcat.User.Newf("invalid mechanism of intercept %s: %w", rec.Name, err)
+	}
+
+	ia := interceptArgs{
+		name:             rec.Name,
+		agentName:        rec.Workload,
+		namespace:        rec.Namespace,
+		localOnly:        rec.LocalOnly,
+		extState:         extState,
+		extRequiresLogin: requiresLogin,
+		previewSpec:      &manager.PreviewSpec{},
+	}
+	if ia.namespace == "" {
+		ia.namespace = sf.Namespace
+	}
+	if rec.LocalOnly {
+		ia.agentName = ""
+		return ia, nil
+	}
+	ia.replace = rec.Replace
+	ia.port = rec.Port
+	if ia.port == "" {
+		ia.port = strconv.Itoa(client.GetConfig(ctx).Intercept.DefaultPort)
+	} else {
+		ia.portSet = true
+	}
+	ia.serviceName = rec.Service
+	ia.mount = rec.Mount
+	if ia.mount == "" {
+		ia.mount = "true"
+	} else {
+		ia.mountSet = true
+	}
+	ia.mountReadOnly = rec.MountReadOnly
+	ia.mountROSet = rec.MountReadOnly
+	ia.mountInclude = rec.MountInclude
+	ia.mountExclude = rec.MountExclude
+	ia.toPod = rec.ToPod
+	ia.previewEnabled = rec.PreviewURL
+	ia.pinScale = rec.PinScale
+	ia.ephemeral = rec.Ephemeral
+	ia.cluster = rec.Cluster
+	if ia.cluster != "" {
+		ia.mount = "false"
+	}
+	if h := rec.Handler; h != nil {
+		ia.cmdline = h.Command
+		ia.dockerRun = h.DockerRun
+		ia.dockerMount = h.DockerMount
+		if ia.dockerRun {
+			if err = validateDockerArgs(ia.cmdline); err != nil {
+				return interceptArgs{}, err
+			}
+		}
+	}
+	if e := rec.Env; e != nil {
+		ia.envFile = e.File
+		ia.envJSON = e.JSON
+		if e.Syntax != "" {
+			if err = ia.envSyntax.Set(e.Syntax); err != nil {
+				return interceptArgs{}, errcat.User.Newf("invalid env syntax of intercept %s: %w", rec.Name, err)
+			}
+		}
+	}
+	return ia, nil
+}
+
+// mechanismFlags returns the intercept flags that select the given mechanism with the given arguments. The
+// arguments are the ones of an InterceptSpec, i.e. the flags of the mechanism without the mechanism prefix.
+func mechanismFlags(mechanism string, args []string) []string {
+	if mechanism == "" {
+		return nil
+	}
+	flags := make([]string, 0, len(args)+1)
+	flags = append(flags, "--mechanism="+mechanism)
+	for _, arg := range args {
+		if name := strings.TrimPrefix(arg, "--"); name != arg {
+			arg = "--" + mechanism + "-" + name
+		}
+		flags = append(flags, arg)
+	}
+	return flags
+}
@@ -0,0 +1,149 @@
+package cli
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
+)
+
+func Test_newSessionFile(t *testing.T) {
+	ci := &connector.ConnectInfo{
+		ClusterContext:   "dev-cluster",
+		Namespace:        "default",
+		MappedNamespaces: []string{"dev"},
+		Intercepts: &manager.InterceptInfoSnapshot{Intercepts: []*manager.InterceptInfo{
+			{
+				Spec: &manager.InterceptSpec{
+					Name:                  "web-dev",
+					Agent:                 "web",
+					Namespace:             "dev",
+					Mechanism:             "http",
+					MechanismArgs:         []string{"--header=x-dev=alice"},
+					TargetPort:            8080,
+					ServicePortIdentifier: "http",
+					ExtraPorts:            []int32{9000},
+				},
+				ClientMountPoint: "/tmp/telfs-123",
+			},
+			{
+				Spec: &manager.InterceptSpec{Name: "api", Agent: "api", Namespace: "default", Mechanism: "tcp", TargetPort: 3000},
+			},
+		}},
+	}
+	records := map[string]*cache.InterceptRecord{
+		"api": {
+			Name:      "api",
+			Workload:  "api",
+			Namespace: "default",
+			Port:      "3000",
+			Mechanism: "tcp",
+			Mount:     "false",
+			Handler:   &cache.InterceptHandler{Command: []string{"npm", "start"}},
+			Env:       &cache.InterceptEnv{File: "api.env", Syntax: "docker"},
+		},
+	}
+	sf := newSessionFile(ci, records)
+	assert.Equal(t, "dev-cluster", sf.Context)
+	assert.Equal(t, "default", sf.Namespace)
+	assert.Equal(t, []string{"dev"}, sf.MappedNamespaces)
+	require.Len(t, sf.Intercepts, 2)
+	assert.Equal(t, records["api"], sf.Intercepts[0])
+	assert.NotSame(t, records["api"], sf.Intercepts[0])
+
+	web := sf.Intercepts[1]
+	assert.Equal(t, "web-dev", web.Name)
+	assert.Equal(t, "web", web.Workload)
+	assert.Equal(t, "8080:http", web.Port)
+	assert.Equal(t, "true", web.Mount)
+	assert.Equal(t, []string{"9000"}, web.ToPod)
+	assert.Nil(t, web.Handler)
+
+	data, err := yaml.Marshal(sf)
+	require.NoError(t, err)
+	rsf, err := readSessionFile(strings.NewReader(string(data)), "-")
+	require.NoError(t, err)
+	assert.Equal(t, sf, rsf)
+}
+
+func Test_readSessionFile(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"no name", "intercepts:\n- workload: web\n", "without a name"},
+		{"no workload", "intercepts:\n- name: web\n", "has no workload"},
+		{"no handler command", "intercepts:\n- name: web\n  workload: web\n  handler:\n    dockerMount: /tmp\n", "has no command"},
+		{"duplicate", "intercepts:\n- name: web\n  workload: web\n- name: web\n  workload: web\n", "more than one"},
+		{"local-only", "intercepts:\n- name: dev\n  localOnly: true\n", ""},
+		{"absolute env file", "intercepts:\n- name: web\n  workload: web\n  env:\n    file: /etc/profile\n", "relative path"},
+		{"env file outside", "intercepts:\n- name: web\n  workload: web\n  env:\n    json: ../web.json\n", "relative path"},
+		{"env file inside", "intercepts:\n- name: web\n  workload: web\n  env:\n    file: env/../web.env\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readSessionFile(strings.NewReader(tt.data), "-")
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func Test_mechanismFlags(t *testing.T) {
+	assert.Nil(t, mechanismFlags("", nil))
+	assert.Equal(t, []string{"--mechanism=tcp"}, mechanismFlags("tcp", nil))
+	assert.Equal(t,
+		[]string{"--mechanism=http", "--http-header=x-dev=alice", "--http-path-prefix=/api"},
+		mechanismFlags("http", []string{"--header=x-dev=alice", "--path-prefix=/api"}))
+}
+
+func Test_makePortable(t *testing.T) {
+	cwd := filepath.Join(t.TempDir(), "project")
+	env := &cache.InterceptEnv{File: filepath.Join(cwd, "env", "web.env"), JSON: filepath.Join(cwd, "..", "web.json")}
+	rec := &cache.InterceptRecord{Name: "web", Mount: "/home/alice/web", Env: env}
+	msg := makePortable(rec, cwd)
+	assert.Contains(t, msg, "web.json")
+	assert.Equal(t, "true", rec.Mount)
+	require.NotNil(t, rec.Env)
+	assert.Equal(t, filepath.Join("env", "web.env"), rec.Env.File)
+	assert.Empty(t, rec.Env.JSON)
+	assert.Equal(t, filepath.Join(cwd, "env", "web.env"), env.File, "the original env must not be modified")
+
+	rec = &cache.InterceptRecord{Name: "api", Mount: "false", Env: &cache.InterceptEnv{File: "api.env"}}
+	assert.Empty(t, makePortable(rec, cwd))
+	assert.Equal(t, "false", rec.Mount)
+	assert.Equal(t, "api.env", rec.Env.File)
+}
+
+func Test_confirmSessionFile(t *testing.T) {
+	cmd := &cobra.Command{}
+	out := &bytes.Buffer{}
+	cmd.SetOut(out)
+
+	sf := &sessionFile{Intercepts: []*cache.InterceptRecord{{Name: "web", Workload: "web", Mount: "false"}}}
+	assert.NoError(t, confirmSessionFile(cmd, "-", sf))
+	assert.Empty(t, out.String())
+
+	sf.Intercepts = append(sf.Intercepts, &cache.InterceptRecord{
+		Name:     "api",
+		Workload: "api",
+		Mount:    "/tmp/api",
+		Handler:  &cache.InterceptHandler{Command: []string{"npm", "start"}},
+		Env:      &cache.InterceptEnv{File: "api.env"},
+	})
+	assert.ErrorContains(t, confirmSessionFile(cmd, "-", sf), "--yes")
+	assert.Equal(t, "Intercept api\n  runs: npm start\n  writes: api.env\n  mounts at: /tmp/api\n", out.String())
+}
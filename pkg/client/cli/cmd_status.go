@@ -9,33 +9,76 @@ import (
 	"net"
 	"time"
 
+	"github.com/blang/semver"
 	"github.com/spf13/cobra"
 	empty "google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/telepresenceio/telepresence/rpc/v2/connector"
 	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/scout"
 	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
 )
 
 type statusInfo struct {
-	json bool
-	out  io.Writer
+	json    bool
+	dns     bool
+	network bool
+	out     io.Writer
 }
 
 type statusOutput struct {
-	DaemonStatus daemonStatus    `json:"root_daemon"`
-	UserDaemon   connectorStatus `json:"user_daemon"`
+	DaemonStatus    daemonStatus    `json:"root_daemon"`
+	UserDaemon      connectorStatus `json:"user_daemon"`
+	UpdateAvailable string          `json:"update_available,omitempty"`
 }
 
 type daemonStatus struct {
-	Running           bool             `json:"running,omitempty"`
-	Version           string           `json:"version,omitempty"`
-	APIVersion        int32            `json:"api_version,omitempty"`
-	DNS               *daemonStatusDNS `json:"dns,omitempty"`
-	AlsoProxySubnets  []string         `json:"also_proxy_subnets,omitempty"`
-	NeverProxySubnets []string         `json:"never_proxy_subnets,omitempty"`
+	Running                 bool             `json:"running,omitempty"`
+	Version                 string           `json:"version,omitempty"`
+	APIVersion              int32            `json:"api_version,omitempty"`
+	DNS                     *daemonStatusDNS `json:"dns,omitempty"`
+	AlsoProxySubnets        []string         `json:"also_proxy_subnets,omitempty"`
+	NeverProxySubnets       []string         `json:"never_proxy_subnets,omitempty"`
+	AllowConflictingSubnets []string         `json:"allow_conflicting_subnets,omitempty"`
+	DNSStats                *daemonDNSStats  `json:"dns_stats,omitempty"`
+	ConnTrack               *daemonConnTrack `json:"conn_track,omitempty"`
+	Network                 *daemonNetwork   `json:"network,omitempty"`
+}
+
+type daemonNetwork struct {
+	DeviceName       string               `json:"device_name,omitempty"`
+	DeviceAddresses  []string             `json:"device_addresses,omitempty"`
+	Routes           []daemonNetworkRoute `json:"routes,omitempty"`
+	DNSLocalAddress  string               `json:"dns_local_address,omitempty"`
+	DNSRemoteIP      net.IP               `json:"dns_remote_ip,omitempty"`
+	PacketsReceived  int64                `json:"packets_received"`
+	PacketsForwarded int64                `json:"packets_forwarded"`
+	PacketsDropped   int64                `json:"packets_dropped"`
+	PacketsSent      int64                `json:"packets_sent"`
+}
+
+type daemonNetworkRoute struct {
+	Subnet string `json:"subnet"`
+	Origin string `json:"origin"`
+}
+
+type daemonDNSStats struct {
+	Requests              int64 `json:"requests"`
+	ClusterLookups        int64 `json:"cluster_lookups"`
+	ClusterLookupFailures int64 `json:"cluster_lookup_failures"`
+}
+
+type daemonConnTrack struct {
+	Size           int32         `json:"size"`
+	MaxSize        int32         `json:"max_size"`
+	TCPIdleTimeout time.Duration `json:"tcp_idle_timeout_in_nanos"`
+	UDPIdleTimeout time.Duration `json:"udp_idle_timeout_in_nanos"`
+	Created        int64         `json:"created"`
+	Rejected       int64         `json:"rejected"`
+	TCPIdleEvicted int64         `json:"tcp_idle_evicted"`
+	UDPIdleEvicted int64         `json:"udp_idle_evicted"`
 }
 
 type daemonStatusDNS struct {
@@ -43,6 +86,7 @@ type daemonStatusDNS struct {
 	RemoteIP        net.IP        `json:"remote_ip,omitempty"`
 	ExcludeSuffixes []string      `json:"exclude_suffixes,omitempty"`
 	IncludeSuffixes []string      `json:"include_suffixes,omitempty"`
+	SearchPath      []string      `json:"search_path,omitempty"`
 	LookupTimeout   time.Duration `json:"lookup_timeout_in_nanos,omitempty"`
 }
 
@@ -57,6 +101,8 @@ type connectorStatus struct {
 	Error             string                         `json:"error,omitempty"`
 	KubernetesServer  string                         `json:"kubernetes_server,omitempty"`
 	KubernetesContext string                         `json:"kubernetes_context,omitempty"`
+	ConnectOnly       bool                           `json:"connect_only,omitempty"`
+	DNSName           string                         `json:"dns_name,omitempty"`
 	Intercepts        []connectStatusIntercept       `json:"intercepts,omitempty"`
 }
 
@@ -83,6 +129,9 @@ func statusCommand() *cobra.Command {
 	}
 	flags := cmd.Flags()
 	flags.BoolVarP(&s.json, "json", "j", false, "output as json object")
+	flags.BoolVar(&s.dns, "dns", false, "include the counters of the local DNS resolver")
+	flags.BoolVar(&s.network, "network", false,
+		"include the TUN-device, its routes, DNS bindings, packet counters, and the connection-tracking table")
 	return cmd
 }
 
@@ -101,16 +150,17 @@ func (s *statusInfo) status(cmd *cobra.Command, _ []string) error {
 		return err
 	}
 
+	update := availableUpdate(ctx)
 	if s.json {
-		return s.printJSON(ds, cs)
+		return s.printJSON(ds, cs, update)
 	}
-	s.printText(ds, cs)
+	s.printText(ds, cs, update)
 	return nil
 }
 
 func (s *statusInfo) daemonStatus(ctx context.Context) (*daemonStatus, error) {
 	ds := &daemonStatus{}
-	err := cliutil.WithStartedNetwork(ctx, func(ctx context.Context, daemonClient daemon.DaemonClient) error {
+	err := cliutil.WithStartedNetwork(cliutil.WithUserDaemonSession(ctx), func(ctx context.Context, daemonClient daemon.DaemonClient) error {
 		ds.Running = true
 		var err error
 		status, err := daemonClient.Status(ctx, &empty.Empty{})
@@ -135,6 +185,7 @@ func (s *statusInfo) daemonStatus(ctx context.Context) (*daemonStatus, error) {
 			ds.DNS.RemoteIP = dns.RemoteIp
 			ds.DNS.ExcludeSuffixes = dns.ExcludeSuffixes
 			ds.DNS.IncludeSuffixes = dns.IncludeSuffixes
+			ds.DNS.SearchPath = dns.SearchPath
 			ds.DNS.LookupTimeout = dns.LookupTimeout.AsDuration()
 			for _, subnet := range obc.AlsoProxySubnets {
 				ds.AlsoProxySubnets = append(ds.AlsoProxySubnets, iputil.IPNetFromRPC(subnet).String())
@@ -142,6 +193,46 @@ func (s *statusInfo) daemonStatus(ctx context.Context) (*daemonStatus, error) {
 			for _, subnet := range obc.NeverProxySubnets {
 				ds.NeverProxySubnets = append(ds.NeverProxySubnets, iputil.IPNetFromRPC(subnet).String())
 			}
+			for _, subnet := range obc.AllowConflictingSubnets {
+				ds.AllowConflictingSubnets = append(ds.AllowConflictingSubnets, iputil.IPNetFromRPC(subnet).String())
+			}
+		}
+		if st := status.DnsStats; s.dns && st != nil {
+			ds.DNSStats = &daemonDNSStats{
+				Requests:              st.Requests,
+				ClusterLookups:        st.ClusterLookups,
+				ClusterLookupFailures: st.ClusterLookupFailures,
+			}
+		}
+		if st := status.NetworkStats; s.network && st != nil {
+			dn := &daemonNetwork{
+				DeviceName:       st.DeviceName,
+				DNSLocalAddress:  st.DnsLocalAddress,
+				DNSRemoteIP:      st.DnsRemoteIp,
+				PacketsReceived:  st.PacketsReceived,
+				PacketsForwarded: st.PacketsForwarded,
+				PacketsDropped:   st.PacketsDropped,
+				PacketsSent:      st.PacketsSent,
+			}
+			for _, addr := range st.DeviceAddresses {
+				dn.DeviceAddresses = append(dn.DeviceAddresses, iputil.IPNetFromRPC(addr).String())
+			}
+			for _, r := range st.Routes {
+				dn.Routes = append(dn.Routes, daemonNetworkRoute{Subnet: iputil.IPNetFromRPC(r.Subnet).String(), Origin: r.Origin})
+			}
+			ds.Network = dn
+		}
+		if st := status.ConnTrackStats; s.network && st != nil {
+			ds.ConnTrack = &daemonConnTrack{
+				Size:           st.Size,
+				MaxSize:        st.MaxSize,
+				TCPIdleTimeout: st.TcpIdleTimeout.AsDuration(),
+				UDPIdleTimeout: st.UdpIdleTimeout.AsDuration(),
+				Created:        st.Created,
+				Rejected:       st.Rejected,
+				TCPIdleEvicted: st.TcpIdleEvicted,
+				UDPIdleEvicted: st.UdpIdleEvicted,
+			}
 		}
 		return nil
 	})
@@ -206,6 +297,8 @@ func (s *statusInfo) connectorStatus(ctx context.Context) (*connectorStatus, err
 		}
 		cs.KubernetesServer = status.ClusterServer
 		cs.KubernetesContext = status.ClusterContext
+		cs.ConnectOnly = status.GetSessionInfo().GetConnectOnly()
+		cs.DNSName = status.GetSessionInfo().GetDnsName()
 		for _, icept := range status.GetIntercepts().GetIntercepts() {
 			cs.Intercepts = append(cs.Intercepts, connectStatusIntercept{
 				Name:   icept.Spec.Name,
@@ -224,11 +317,15 @@ func (s *statusInfo) connectorStatus(ctx context.Context) (*connectorStatus, err
 	return cs, nil
 }
 
-func (s *statusInfo) printJSON(ds *daemonStatus, cs *connectorStatus) error {
-	output, err := json.Marshal(statusOutput{
+func (s *statusInfo) printJSON(ds *daemonStatus, cs *connectorStatus, update *semver.Version) error {
+	so := statusOutput{
 		DaemonStatus: *ds,
 		UserDaemon:   *cs,
-	})
+	}
+	if update != nil {
+		so.UpdateAvailable = update.String()
+	}
+	output, err := json.Marshal(so)
 	if err != nil {
 		return err
 	}
@@ -236,9 +333,13 @@ func (s *statusInfo) printJSON(ds *daemonStatus, cs *connectorStatus) error {
 	return nil
 }
 
-func (s *statusInfo) printText(ds *daemonStatus, cs *connectorStatus) {
+func (s *statusInfo) printText(ds *daemonStatus, cs *connectorStatus, update *semver.Version) {
 	s.printDaemonText(ds)
 	s.printConnectorText(cs)
+	if update != nil {
+		ourVersion := client.Semver()
+		s.println(updateMessage(&ourVersion, update))
+	}
 }
 
 func (s *statusInfo) printDaemonText(ds *daemonStatus) {
@@ -253,6 +354,7 @@ func (s *statusInfo) printDaemonText(ds *daemonStatus) {
 			s.printf("    Remote IP       : %v\n", ds.DNS.RemoteIP)
 			s.printf("    Exclude suffixes: %v\n", ds.DNS.ExcludeSuffixes)
 			s.printf("    Include suffixes: %v\n", ds.DNS.IncludeSuffixes)
+			s.printf("    Search path     : %v\n", ds.DNS.SearchPath)
 			s.printf("    Timeout         : %v\n", ds.DNS.LookupTimeout)
 			s.printf("  Also Proxy : (%d subnets)\n", len(ds.AlsoProxySubnets))
 			for _, subnet := range ds.AlsoProxySubnets {
@@ -262,6 +364,53 @@ func (s *statusInfo) printDaemonText(ds *daemonStatus) {
 			for _, subnet := range ds.NeverProxySubnets {
 				s.printf("    - %s\n", subnet)
 			}
+			if len(ds.AllowConflictingSubnets) > 0 {
+				s.printf("  Allow Conflicting: (%d subnets)\n", len(ds.AllowConflictingSubnets))
+				for _, subnet := range ds.AllowConflictingSubnets {
+					s.printf("    - %s\n", subnet)
+				}
+			}
+		}
+		if st := ds.DNSStats; st != nil {
+			s.printf("  DNS Stats  :\n")
+			s.printf("    Requests         : %d\n", st.Requests)
+			s.printf("    Cluster lookups  : %d\n", st.ClusterLookups)
+			s.printf("    Lookup failures  : %d\n", st.ClusterLookupFailures)
+		}
+		if dn := ds.Network; dn != nil {
+			s.printf("  Network    :\n")
+			s.printf("    TUN device       : %s\n", dn.DeviceName)
+			s.printf("    Addresses        : %v\n", dn.DeviceAddresses)
+			s.printf("    DNS local address: %s\n", dn.DNSLocalAddress)
+			if len(dn.DNSRemoteIP) > 0 {
+				s.printf("    DNS remote IP    : %v\n", dn.DNSRemoteIP)
+			}
+			s.printf("    Routes           : (%d routes)\n", len(dn.Routes))
+			for _, r := range dn.Routes {
+				s.printf("      - %-18s %s\n", r.Subnet, r.Origin)
+			}
+			s.printf("    Packets received : %d\n", dn.PacketsReceived)
+			s.printf("    Packets forwarded: %d\n", dn.PacketsForwarded)
+			s.printf("    Packets dropped  : %d\n", dn.PacketsDropped)
+			s.printf("    Packets sent     : %d\n", dn.PacketsSent)
+		}
+		if ct := ds.ConnTrack; ct != nil {
+			s.printf("  Connection table:\n")
+			if ct.MaxSize > 0 {
+				s.printf("    Size             : %d of %d\n", ct.Size, ct.MaxSize)
+			} else {
+				s.printf("    Size             : %d (no limit)\n", ct.Size)
+			}
+			if ct.TCPIdleTimeout > 0 {
+				s.printf("    TCP idle timeout : %v\n", ct.TCPIdleTimeout)
+			} else {
+				s.printf("    TCP idle timeout : none\n")
+			}
+			s.printf("    UDP idle timeout : %v\n", ct.UDPIdleTimeout)
+			s.printf("    Created          : %d\n", ct.Created)
+			s.printf("    Rejected (full)  : %d\n", ct.Rejected)
+			s.printf("    TCP idle evicted : %d\n", ct.TCPIdleEvicted)
+			s.printf("    UDP idle evicted : %d\n", ct.UDPIdleEvicted)
 		}
 	} else {
 		s.println("Root Daemon: Not running")
@@ -285,7 +434,14 @@ func (s *statusInfo) printConnectorText(cs *connectorStatus) {
 		}
 		s.printf("  Kubernetes server : %s\n", cs.KubernetesServer)
 		s.printf("  Kubernetes context: %s\n", cs.KubernetesContext)
-		s.printf("  Intercepts        : %d total\n", len(cs.Intercepts))
+		if cs.DNSName != "" {
+			s.printf("  Cluster DNS name  : %s\n", cs.DNSName)
+		}
+		if cs.ConnectOnly {
+			s.printf("  Intercepts        : not permitted, the session is connect-only\n")
+		} else {
+			s.printf("  Intercepts        : %d total\n", len(cs.Intercepts))
+		}
 		for _, intercept := range cs.Intercepts {
 			s.printf("    %s: %s\n", intercept.Name, intercept.Client)
 		}
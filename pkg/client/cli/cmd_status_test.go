@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusNetworkFlag(t *testing.T) {
+	cmd := statusCommand()
+	require.NoError(t, cmd.ParseFlags([]string{"--network"}))
+	v, err := cmd.Flags().GetBool("network")
+	require.NoError(t, err)
+	assert.True(t, v)
+	assert.Error(t, statusCommand().ParseFlags([]string{"--net"}))
+}
+
+func TestPrintDaemonTextNetwork(t *testing.T) {
+	out := &bytes.Buffer{}
+	s := &statusInfo{out: out}
+	s.printDaemonText(&daemonStatus{
+		Running: true,
+		Version: "v2.7.0",
+		Network: &daemonNetwork{
+			DeviceName:      "tel0",
+			DeviceAddresses: []string{"10.96.0.0/12"},
+			Routes: []daemonNetworkRoute{
+				{Subnet: "10.96.0.0/12", Origin: "service-subnet"},
+				{Subnet: "10.244.0.0/16", Origin: "pod-subnet"},
+			},
+			DNSLocalAddress: "127.0.0.1:53",
+			PacketsReceived: 12,
+			PacketsDropped:  2,
+		},
+	})
+	txt := out.String()
+	assert.Contains(t, txt, "TUN device       : tel0")
+	assert.Contains(t, txt, "Routes           : (2 routes)")
+	assert.Contains(t, txt, "10.244.0.0/16      pod-subnet")
+	assert.Contains(t, txt, "Packets dropped  : 2")
+	assert.NotContains(t, txt, "DNS remote IP")
+}
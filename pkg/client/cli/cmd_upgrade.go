@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+type upgradeAgentsInfo struct {
+	namespace string
+	dryRun    bool
+}
+
+func upgradeCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:  "upgrade",
+		Args: cobra.NoArgs,
+
+		Short: "Upgrade telepresence components",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return errcat.User.New("please run upgrade as \"upgrade agents\" or \"upgrade self\"")
+		},
+	}
+	cmd.AddCommand(upgradeAgentsCommand(), upgradeSelfCommand())
+	return cmd
+}
+
+func upgradeAgentsCommand() *cobra.Command {
+	ui := &upgradeAgentsInfo{}
+	cmd := &cobra.Command{
+		Use:  "agents [flags] [<workload>...]",
+		Args: cobra.ArbitraryArgs,
+
+		Short: "Upgrade the traffic-agents that have a version that differs from the traffic-manager",
+		Long: `Upgrade the traffic-agents that have a version that differs from the traffic-manager.
+
+The workloads of the outdated agents are rolled out one at a time, so that they get a traffic-agent
+of the same version as the traffic-manager. All outdated agents in the namespace are upgraded unless
+workloads are given. Intercepts of the upgraded workloads are interrupted while their pods restart.`,
+		RunE: ui.run,
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&ui.namespace, "namespace", "n", "", "If present, the namespace scope for this CLI request")
+	flags.BoolVar(&ui.dryRun, "dry-run", false, "List the outdated agents without upgrading them")
+	return cmd
+}
+
+func (ui *upgradeAgentsInfo) run(cmd *cobra.Command, args []string) error {
+	return withConnector(cmd, true, nil, func(ctx context.Context, cs *connectorState) error {
+		stream, err := cs.userD.UpgradeAgents(ctx, &connector.UpgradeAgentsRequest{
+			Agents:    args,
+			Namespace: ui.namespace,
+			DryRun:    ui.dryRun,
+		})
+		if err != nil {
+			return err
+		}
+		outdated, failed := 0, 0
+		stdout := cmd.OutOrStdout()
+		for {
+			p, err := stream.Recv()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				return err
+			}
+			switch p.Phase {
+			case connector.UpgradeAgentProgress_OUTDATED:
+				outdated++
+			case connector.UpgradeAgentProgress_FAILED:
+				failed++
+			}
+			printUpgradeProgress(stdout, p)
+		}
+		switch {
+		case failed > 0:
+			return errcat.User.Newf("failed to upgrade %d agent(s)", failed)
+		case outdated == 0:
+			fmt.Fprintln(stdout, "All agents have the same version as the traffic-manager")
+		}
+		return nil
+	})
+}
+
+func printUpgradeProgress(out io.Writer, p *connector.UpgradeAgentProgress) {
+	switch p.Phase {
+	case connector.UpgradeAgentProgress_OUTDATED:
+		fmt.Fprintf(out, "Agent of %s.%s has version %s, traffic-manager has version %s\n", p.Name, p.Namespace, p.Version, p.ManagerVersion)
+	case connector.UpgradeAgentProgress_UPGRADING:
+		fmt.Fprintf(out, "Upgrading agent of %s.%s...\n", p.Name, p.Namespace)
+	case connector.UpgradeAgentProgress_UPGRADED:
+		fmt.Fprintf(out, "Upgraded agent of %s.%s to version %s\n", p.Name, p.Namespace, p.Version)
+	case connector.UpgradeAgentProgress_FAILED:
+		fmt.Fprintf(out, "Failed to upgrade agent of %s.%s: %s\n", p.Name, p.Namespace, p.ErrorText)
+	}
+}
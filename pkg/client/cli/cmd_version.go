@@ -2,61 +2,173 @@ package cli
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	empty "google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/telepresenceio/telepresence/rpc/v2/common"
 	"github.com/telepresenceio/telepresence/rpc/v2/connector"
 	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/output"
 )
 
+const (
+	versionOK           = "ok"
+	versionMismatch     = "mismatch"
+	versionNotRunning   = "not running"
+	versionNotConnected = "not connected"
+	versionError        = "error"
+)
+
+// componentVersion is the version of a Telepresence component and whether it matches the version of the component
+// that it's used with.
+type componentVersion struct {
+	Name       string   `json:"name"`
+	Status     string   `json:"status"`
+	Version    string   `json:"version,omitempty"`
+	APIVersion int32    `json:"api_version,omitempty"`
+	Expected   string   `json:"expected_version,omitempty"`
+	Error      string   `json:"error,omitempty"`
+	Workloads  []string `json:"workloads,omitempty"`
+}
+
 func versionCommand() *cobra.Command {
 	return &cobra.Command{
 		Use:  "version",
 		Args: cobra.NoArgs,
 
-		Short:   "Show version",
+		Short: "Show version",
+		Long: `Show the versions of the client, the daemons, the traffic-manager, and the traffic-agents of the
+workloads in the connected namespace, and whether they match the versions that they are used with.`,
 		PreRunE: forcedUpdateCheck,
 		RunE:    printVersion,
 	}
 }
 
-// printVersion requests version info from the daemon and prints both client and daemon version.
+// printVersion requests version info from the daemons and the traffic-manager and prints the versions of all
+// components.
 func printVersion(cmd *cobra.Command, _ []string) error {
-	fmt.Fprintf(cmd.OutOrStdout(), "Client: %s\n",
-		client.DisplayVersion())
+	ctx := cmd.Context()
+	cvs, err := componentVersions(ctx)
+	stdout := cmd.OutOrStdout()
+	if output.WantsJSONOutput(cmd.Flags()) {
+		if streamerOut, ok := stdout.(output.StructuredStreamer); ok {
+			streamerOut.StructuredStream(cvs, err)
+			return nil
+		}
+	}
+	for _, cv := range cvs {
+		fmt.Fprintln(stdout, cv)
+	}
+	return err
+}
 
+// componentVersions returns the versions of all the components that can be reached without starting or
+// connecting anything, along with the first error that was encountered when querying a daemon.
+func componentVersions(ctx context.Context) ([]*componentVersion, error) {
 	var retErr error
+	clientVersion := client.Version()
+	cvs := []*componentVersion{{Name: "Client", Status: versionOK, Version: clientVersion, APIVersion: client.APIVersion}}
 
-	version, err := daemonVersion(cmd.Context())
-	switch {
-	case err == nil:
-		fmt.Fprintf(cmd.OutOrStdout(), "Root Daemon: %s (api v%d)\n",
-			version.Version, version.ApiVersion)
-	case err == cliutil.ErrNoNetwork:
-		fmt.Fprintf(cmd.OutOrStdout(), "Root Daemon: not running\n")
-	default:
-		fmt.Fprintf(cmd.OutOrStdout(), "Root Daemon: error: %v\n", err)
+	version, err := daemonVersion(ctx)
+	cv := newComponentVersion("Root Daemon", version, clientVersion, err, cliutil.ErrNoNetwork)
+	if cv.Status == versionError {
+		retErr = err
+	}
+	cvs = append(cvs, cv)
+
+	version, err = connectorVersion(ctx)
+	cv = newComponentVersion("User Daemon", version, clientVersion, err, cliutil.ErrNoUserDaemon)
+	if cv.Status == versionError && retErr == nil {
 		retErr = err
 	}
+	cvs = append(cvs, cv)
+	if cv.Status == versionNotRunning {
+		return cvs, retErr
+	}
 
-	version, err = connectorVersion(cmd.Context())
+	managerVersion, err := trafficManagerVersion(ctx)
+	cv = newComponentVersion("Traffic Manager", managerVersion, clientVersion, err, nil)
+	cvs = append(cvs, cv)
+	if cv.Status == versionNotConnected || cv.Status == versionError {
+		return cvs, retErr
+	}
+
+	agents, err := agentVersions(ctx, managerVersion.Version)
+	if err != nil {
+		cvs = append(cvs, &componentVersion{Name: "Traffic Agent", Status: versionError, Error: err.Error()})
+	} else {
+		cvs = append(cvs, agents...)
+	}
+	return cvs, retErr
+}
+
+// newComponentVersion creates the componentVersion of a component from the result of a version query. The
+// notRunning error is returned by the query when the component isn't running.
+func newComponentVersion(name string, version *common.VersionInfo, expected string, err, notRunning error) *componentVersion {
+	cv := &componentVersion{Name: name}
 	switch {
 	case err == nil:
-		fmt.Fprintf(cmd.OutOrStdout(), "User Daemon: %s (api v%d)\n",
-			version.Version, version.ApiVersion)
-	case err == cliutil.ErrNoUserDaemon:
-		fmt.Fprintf(cmd.OutOrStdout(), "User Daemon: not running\n")
+		cv.Version = version.Version
+		cv.APIVersion = version.ApiVersion
+		cv.Status = versionStatus(version.Version, expected)
+		if cv.Status == versionMismatch {
+			cv.Expected = expected
+		}
+	case notRunning != nil && errors.Is(err, notRunning):
+		cv.Status = versionNotRunning
+	case status.Code(err) == codes.Unavailable:
+		cv.Status = versionNotConnected
 	default:
-		fmt.Fprintf(cmd.OutOrStdout(), "User Daemon: error: %v\n", err)
-		retErr = err
+		cv.Status = versionError
+		cv.Error = err.Error()
 	}
+	return cv
+}
 
-	return retErr
+func versionStatus(version, expected string) string {
+	if strings.TrimPrefix(version, "v") == strings.TrimPrefix(expected, "v") {
+		return versionOK
+	}
+	return versionMismatch
+}
+
+func (cv *componentVersion) String() string {
+	switch cv.Status {
+	case versionNotRunning, versionNotConnected:
+		return fmt.Sprintf("%s: %s", cv.Name, cv.Status)
+	case versionError:
+		return fmt.Sprintf("%s: error: %s", cv.Name, cv.Error)
+	}
+	sb := strings.Builder{}
+	sb.WriteString(cv.Name)
+	sb.WriteString(": ")
+	sb.WriteString(cv.Version)
+	if cv.APIVersion > 0 {
+		fmt.Fprintf(&sb, " (api v%d)", cv.APIVersion)
+	}
+	if cv.Status == versionMismatch {
+		fmt.Fprintf(&sb, " (expected %s)", cv.Expected)
+	}
+	if n := len(cv.Workloads); n > 0 {
+		const maxShown = 3
+		sb.WriteString(", used by ")
+		if n > maxShown {
+			fmt.Fprintf(&sb, "%s and %d more", strings.Join(cv.Workloads[:maxShown], ", "), n-maxShown)
+		} else {
+			sb.WriteString(strings.Join(cv.Workloads, ", "))
+		}
+	}
+	return sb.String()
 }
 
 func daemonVersion(ctx context.Context) (*common.VersionInfo, error) {
@@ -90,3 +202,64 @@ func connectorVersion(ctx context.Context) (*common.VersionInfo, error) {
 	}
 	return version, nil
 }
+
+func trafficManagerVersion(ctx context.Context) (*common.VersionInfo, error) {
+	var version *common.VersionInfo
+	err := cliutil.WithStartedManager(ctx, func(ctx context.Context, managerClient manager.ManagerClient) error {
+		vi, err := managerClient.Version(ctx, &empty.Empty{})
+		if err != nil {
+			return err
+		}
+		version = &common.VersionInfo{Version: vi.Version}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+// agentVersions returns one componentVersion for each version of the traffic-agents installed in the
+// workloads of the connected namespace.
+func agentVersions(ctx context.Context, managerVersion string) ([]*componentVersion, error) {
+	var workloads []*connector.WorkloadInfo
+	err := cliutil.WithStartedConnector(ctx, false, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
+		rsp, err := connectorClient.List(ctx, &connector.ListRequest{Filter: connector.ListRequest_INSTALLED_AGENTS})
+		if err != nil {
+			return err
+		}
+		workloads = rsp.Workloads
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return groupAgentVersions(workloads, managerVersion), nil
+}
+
+// groupAgentVersions groups the workloads by the version of their traffic-agent.
+func groupAgentVersions(workloads []*connector.WorkloadInfo, managerVersion string) []*componentVersion {
+	byVersion := make(map[string]*componentVersion)
+	for _, wl := range workloads {
+		ai := wl.AgentInfo
+		if ai == nil {
+			continue
+		}
+		cv, ok := byVersion[ai.Version]
+		if !ok {
+			cv = &componentVersion{Name: "Traffic Agent", Version: ai.Version, Status: versionStatus(ai.Version, managerVersion)}
+			if cv.Status == versionMismatch {
+				cv.Expected = managerVersion
+			}
+			byVersion[ai.Version] = cv
+		}
+		cv.Workloads = append(cv.Workloads, wl.Name)
+	}
+	cvs := make([]*componentVersion, 0, len(byVersion))
+	for _, cv := range byVersion {
+		sort.Strings(cv.Workloads)
+		cvs = append(cvs, cv)
+	}
+	sort.Slice(cvs, func(i, j int) bool { return cvs[i].Version < cvs[j].Version })
+	return cvs
+}
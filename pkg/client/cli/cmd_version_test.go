@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+func TestGroupAgentVersions(t *testing.T) {
+	wl := func(name, version string) *connector.WorkloadInfo {
+		return &connector.WorkloadInfo{Name: name, AgentInfo: &manager.AgentInfo{Name: name, Version: version}}
+	}
+	cvs := groupAgentVersions([]*connector.WorkloadInfo{
+		wl("echo", "v2.7.0"),
+		wl("web", "v2.6.8"),
+		{Name: "no-agent"},
+		wl("api", "v2.6.8"),
+	}, "v2.7.0")
+	assert.Len(t, cvs, 2)
+	assert.Equal(t, &componentVersion{
+		Name:      "Traffic Agent",
+		Status:    versionMismatch,
+		Version:   "v2.6.8",
+		Expected:  "v2.7.0",
+		Workloads: []string{"api", "web"},
+	}, cvs[0])
+	assert.Equal(t, "Traffic Agent: v2.6.8 (expected v2.7.0), used by api, web", cvs[0].String())
+	assert.Equal(t, "Traffic Agent: v2.7.0, used by echo", cvs[1].String())
+}
+
+func TestComponentVersionString(t *testing.T) {
+	cv := &componentVersion{Name: "User Daemon", Status: versionOK, Version: "v2.7.0", APIVersion: 3}
+	assert.Equal(t, "User Daemon: v2.7.0 (api v3)", cv.String())
+
+	cv = &componentVersion{Name: "Traffic Manager", Status: versionNotConnected}
+	assert.Equal(t, "Traffic Manager: not connected", cv.String())
+
+	cv = &componentVersion{Name: "Traffic Agent", Status: versionOK, Version: "v2.7.0", Workloads: []string{"a", "b", "c", "d", "e"}}
+	assert.Equal(t, "Traffic Agent: v2.7.0, used by a, b, c and 2 more", cv.String())
+}
@@ -2,22 +2,26 @@ package cli
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
+	"path"
 	"reflect"
 	"regexp"
 	"runtime"
-	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/blang/semver"
 	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 	empty "google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/datawire/dlib/dcontext"
@@ -34,26 +38,44 @@ import (
 	"github.com/telepresenceio/telepresence/v2/pkg/proc"
 )
 
+// waitForRollout is the value of the --wait-for flag that waits for the rollout of the intercepted workload.
+const waitForRollout = "rollout"
+
 type interceptArgs struct {
 	name        string // Args[0] || `${Args[0]}-${--namespace}` // which depends on a combinationof --workload and --namespace
 	agentName   string // --workload || Args[0] // only valid if !localOnly
 	namespace   string // --namespace
 	port        string // --port // only valid if !localOnly
+	portSet     bool   // whether --port was passed
 	serviceName string // --service // only valid if !localOnly
 	localOnly   bool   // --local-only
+	replace     bool   // true when the intercept replaces the container, i.e. the replace command
+	pinScale    bool   // --pin-scale
+	ephemeral   bool   // --ephemeral
+	cluster     string // --cluster
 
 	previewEnabled bool                 // --preview-url // only valid if !localOnly
 	previewSpec    *manager.PreviewSpec // --preview-url-* // only valid if !localOnly
 
-	envFile  string   // --env-file
-	envJSON  string   // --env-json
-	mount    string   // --mount // "true", "false", or desired mount point // only valid if !localOnly
-	mountSet bool     // whether --mount was passed
-	toPod    []string // --to-pod
+	envFile   string    // --env-file
+	envSyntax envSyntax // --env-syntax
+	envJSON   string    // --env-json
+	mount     string    // --mount // "true", "false", or desired mount point // only valid if !localOnly
+	mountSet  bool      // whether --mount was passed
+	toPod     []string  // --to-pod
+
+	mountReadOnly bool     // --mount-ro
+	mountROSet    bool     // whether --mount-ro was passed
+	mountInclude  []string // --mount-include
+	mountExclude  []string // --mount-exclude
 
 	dockerRun   bool   // --docker-run
 	dockerMount string // --docker-mount // where to mount in a docker container. Defaults to mount unless mount is "true" or "false".
 
+	waitFor       string        // --wait-for
+	waitTimeout   time.Duration // --wait-timeout
+	noRolloutWait bool          // --no-rollout-wait
+
 	extState         *extensions.ExtensionsState // extension flags
 	extRequiresLogin bool                        // pre-extracted from extState
 
@@ -97,6 +119,7 @@ type interceptState struct {
 
 	// set later ///////////////////////////////////////////////////////////
 
+	defaults   *connector.InterceptDefaults // declared by the annotations of the intercepted workload
 	env        map[string]string
 	mountPoint string // if non-empty, this the final mount point of a successful mount
 	localPort  uint16 // the parsed <local port>
@@ -113,7 +136,30 @@ func interceptCommand(ctx context.Context) *cobra.Command {
 		PreRunE:  updateCheckIfDue,
 		PostRunE: raiseCloudMessage,
 	}
-	args := interceptArgs{}
+	return addInterceptFlags(ctx, cmd, false)
+}
+
+func replaceCommand(ctx context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:  "replace [flags] <workload> [-- <command with arguments...>]",
+		Args: cobra.MinimumNArgs(1),
+
+		Short: "Replace a container with a local process",
+		Long: `Replace a container of a workload with a local process.
+
+The container is removed from the pods of the workload, and the traffic to all of its ports, including the ones
+that no service exposes, is routed to the local machine. The port given with --port receives the traffic of the
+intercepted service port, and the traffic to the other container ports is routed to the same ports on localhost.
+The container is restored when the replacement ends.`,
+		PreRunE:  updateCheckIfDue,
+		PostRunE: raiseCloudMessage,
+	}
+	return addInterceptFlags(ctx, cmd, true)
+}
+
+// addInterceptFlags adds the flags and the run function that are common to the intercept and replace commands.
+func addInterceptFlags(ctx context.Context, cmd *cobra.Command, replace bool) *cobra.Command {
+	args := interceptArgs{replace: replace}
 	flags := cmd.Flags()
 
 	flags.StringVarP(&args.agentName, "workload", "w", "", "Name of workload (Deployment, ReplicaSet) to intercept, if different from <name>")
@@ -125,8 +171,14 @@ func interceptCommand(ctx context.Context) *cobra.Command {
 
 	flags.StringVar(&args.serviceName, "service", "", "Name of service to intercept. If not provided, we will try to auto-detect one")
 
-	flags.BoolVarP(&args.localOnly, "local-only", "l", false, ``+
-		`Declare a local-only intercept for the purpose of getting direct outbound access to the intercept's namespace`)
+	if !replace {
+		flags.BoolVarP(&args.localOnly, "local-only", "l", false, ``+
+			`Declare a local-only intercept for the purpose of getting direct outbound access to the intercept's namespace`)
+
+		flags.BoolVar(&args.ephemeral, "ephemeral", false, ``+
+			`Experimental. Add the traffic-agent to the running pods as an ephemeral container instead of rolling out `+
+			`the workload, so that the pods aren't restarted. The agent remains until a pod restarts`)
+	}
 
 	flags.BoolVarP(&args.previewEnabled, "preview-url", "u", cliutil.HasLoggedIn(ctx), ``+
 		`Generate an edgestack.me preview domain for this intercept. `+
@@ -136,8 +188,11 @@ func interceptCommand(ctx context.Context) *cobra.Command {
 	addPreviewFlags("preview-url-", flags, args.previewSpec)
 
 	flags.StringVarP(&args.envFile, "env-file", "e", "", ``+
-		`Also emit the remote environment to an env file in Docker Compose format. `+
-		`See https://docs.docker.com/compose/env-file/ for more information on the limitations of this format.`)
+		`Also emit the remote environment to an env file. The syntax used depends on the --env-syntax flag`)
+
+	flags.Var(&args.envSyntax, "env-syntax", ``+
+		`Syntax used for the env file, one of `+strings.Join(envSyntaxNames, "|")+`. The docker syntax is `+
+		`understood by docker run --env-file, but cannot represent values that contain newlines`)
 
 	flags.StringVarP(&args.envJSON, "env-json", "j", "", `Also emit the remote environment to a file as a JSON blob.`)
 
@@ -145,6 +200,15 @@ func interceptCommand(ctx context.Context) *cobra.Command {
 		`The absolute path for the root directory where volumes will be mounted, $TELEPRESENCE_ROOT. Use "true" to `+
 		`have Telepresence pick a random mount point (default). Use "false" to disable filesystem mounting entirely.`)
 
+	flags.BoolVar(&args.mountReadOnly, "mount-ro", false, `Mount the remote volumes read-only`)
+
+	flags.StringSliceVar(&args.mountInclude, "mount-include", nil, ``+
+		`An absolute path in the intercepted container to mount instead of all of its volumes, e.g. the mount path `+
+		`of a ConfigMap volume. Can be repeated.`)
+
+	flags.StringSliceVar(&args.mountExclude, "mount-exclude", nil, ``+
+		`An absolute path in the intercepted container whose volumes will not be mounted. Can be repeated.`)
+
 	flags.StringSliceVar(&args.toPod, "to-pod", []string{}, ``+
 		`An additional port to forward from the intercepted pod, will be made available at localhost:PORT `+
 		`Use this to, for example, access proxy/helper sidecars in the intercepted pod.`)
@@ -158,6 +222,24 @@ func interceptCommand(ctx context.Context) *cobra.Command {
 
 	flags.StringVarP(&args.namespace, "namespace", "n", "", "If present, the namespace scope for this CLI request")
 
+	flags.StringVar(&args.cluster, "cluster", "", ``+
+		`Name of a peered cluster to intercept in instead of the connected cluster. The traffic-manager forwards the `+
+		`intercept to the traffic-manager of that cluster. Remote volumes are not mounted`)
+
+	flags.BoolVar(&args.pinScale, "pin-scale", false, ``+
+		`Keep at least one pod of a workload that scales to zero, like a Knative Service, running until the intercept ends`)
+
+	flags.StringVar(&args.waitFor, "wait-for", "", ``+
+		`Use "rollout" to block until all pods of the intercepted workload have a traffic-agent and are ready, `+
+		`so that all traffic to the workload is intercepted`)
+
+	flags.DurationVar(&args.waitTimeout, "wait-timeout", 0, ``+
+		`The maximum time to wait for the rollout. Defaults to the agentInstall timeout of the config`)
+
+	flags.BoolVar(&args.noRolloutWait, "no-rollout-wait", false, ``+
+		`Return as soon as the intercept is created, without waiting for the rollout of the workload to bring a pod `+
+		`with a traffic-agent. The intercept becomes active when the agent arrives`)
+
 	flags.StringVar(&args.ingressHost, "ingress-host", "", "If this flag is set, the ingress dialogue will be skipped,"+
 		" and this value will be used as the ingress hostname.")
 	flags.Int32Var(&args.ingressPort, "ingress-port", 0, "If this flag is set, the ingress dialogue will be skipped,"+
@@ -194,7 +276,7 @@ func interceptCommand(ctx context.Context) *cobra.Command {
 			if cmd.Flag("port").Changed {
 				return errcat.User.New("a local-only intercept cannot have a port")
 			}
-			if cmd.Flag("mount").Changed {
+			if cmd.Flag("mount").Changed || args.mountReadOnly || len(args.mountInclude) > 0 || len(args.mountExclude) > 0 {
 				return errcat.User.New("a local-only intercept cannot have mounts")
 			}
 			if cmd.Flag("preview-url").Changed && args.previewEnabled {
@@ -209,7 +291,52 @@ func interceptCommand(ctx context.Context) *cobra.Command {
 				}
 			}
 		}
+		switch args.waitFor {
+		case "":
+			if cmd.Flag("wait-timeout").Changed {
+				return errcat.User.New("--wait-timeout requires --wait-for")
+			}
+		case waitForRollout:
+			if args.localOnly {
+				return errcat.User.New("a local-only intercept has no rollout to wait for")
+			}
+		default:
+			return errcat.User.Newf("invalid value %q for --wait-for, the only supported value is %q", args.waitFor, waitForRollout)
+		}
+		if args.ephemeral {
+			switch {
+			case args.localOnly:
+				return errcat.User.New("--ephemeral cannot be combined with --local-only")
+			case args.waitFor != "" || args.noRolloutWait:
+				return errcat.User.New("an ephemeral intercept has no rollout to wait for")
+			}
+		}
+		if args.cluster != "" {
+			switch {
+			case args.localOnly:
+				return errcat.User.New("--cluster cannot be combined with --local-only")
+			case cmd.Flag("mount").Changed && args.mount != "false":
+				return errcat.User.New("the volumes of a peered cluster cannot be mounted. Use --mount=false")
+			case len(args.toPod) > 0:
+				return errcat.User.New("--to-pod cannot be combined with --cluster")
+			case args.waitFor != "":
+				return errcat.User.New("the rollout in a peered cluster cannot be waited for")
+			}
+			args.mount = "false"
+		}
+		if args.noRolloutWait {
+			switch {
+			case args.localOnly:
+				return errcat.User.New("a local-only intercept has no rollout to wait for")
+			case args.waitFor != "":
+				return errcat.User.New("--no-rollout-wait cannot be combined with --wait-for")
+			case len(args.cmdline) > 0 || args.dockerRun:
+				return errcat.User.New("--no-rollout-wait cannot be used when running a command, because the command needs an active intercept")
+			}
+		}
+		args.portSet = cmd.Flag("port").Changed
 		args.mountSet = cmd.Flag("mount").Changed
+		args.mountROSet = cmd.Flag("mount-ro").Changed
 		if args.dockerRun {
 			if err := validateDockerArgs(args.cmdline); err != nil {
 				return err
@@ -234,6 +361,56 @@ func leaveCommand() *cobra.Command {
 	}
 }
 
+func handoffCommand() *cobra.Command {
+	accept := false
+	cmd := &cobra.Command{
+		Use:  "handoff [flags] <intercept_name> <recipient>",
+		Args: cobra.RangeArgs(1, 2),
+
+		Short: "Hand over an existing intercept to a teammate",
+		Long: `Hand over an existing intercept to a teammate.
+
+The intercept is offered to the recipient, which is the name of a connected client, i.e. user@host, or just the user
+when that user has one session. The recipient accepts it using "telepresence handoff --accept <intercept_name>", and
+the traffic-manager then moves the intercept in one step, so no-one else can intercept the workload in between. The
+recipient must have a verified identity that may get the intercepted workload, and must serve the intercepted
+traffic on the same local port.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var recipient string
+			if len(args) == 2 {
+				recipient = strings.TrimSpace(args[1])
+			} else if !accept {
+				return errcat.User.New("the recipient of the intercept must be given")
+			}
+			return handoffIntercept(cmd, strings.TrimSpace(args[0]), recipient, accept)
+		},
+	}
+	cmd.Flags().BoolVar(&accept, "accept", false,
+		"Accept an intercept that has been offered to you. The optional second argument is then the client that offered it")
+	return cmd
+}
+
+func handoffIntercept(cmd *cobra.Command, name, recipient string, accept bool) error {
+	return cliutil.WithStartedConnector(cmd.Context(), true, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
+		r, err := connectorClient.HandoffIntercept(ctx, &manager.HandoffInterceptRequest{Name: name, Recipient: recipient, Accept: accept})
+		if err != nil {
+			if status.Code(err) == codes.Unimplemented {
+				return errcat.User.New("the user daemon is too old to hand off intercepts. Please run \"telepresence quit --stop-daemons\" and try again")
+			}
+			return err
+		}
+		if r.Error != connector.InterceptError_UNSPECIFIED {
+			return interceptMessage(r)
+		}
+		if accept {
+			fmt.Fprintf(cmd.OutOrStdout(), "Intercept %s accepted\n", name)
+		} else {
+			fmt.Fprintf(cmd.OutOrStdout(), "Intercept %s offered, waiting for %s to accept it\n", name, recipient)
+		}
+		return nil
+	})
+}
+
 func intercept(cmd *cobra.Command, args interceptArgs) error {
 	if len(args.cmdline) == 0 && !args.dockerRun {
 		// start and retain the intercept
@@ -251,58 +428,64 @@ func intercept(cmd *cobra.Command, args interceptArgs) error {
 		return cliutil.WithManager(ctx, func(ctx context.Context, managerClient manager.ManagerClient) error {
 			is := newInterceptState(ctx, safeCobraCommandImpl{cmd}, args, cs, managerClient)
 			defer is.scout.Close()
-			return client.WithEnsuredState(ctx, is, false, func() (err error) {
-				ctx, cancel := context.WithCancel(dcontext.WithSoftness(ctx))
-				defer cancel()
-				var cmd *dexec.Cmd
-				if args.dockerRun {
-					envFile := is.args.envFile
-					if envFile == "" {
-						file, err := os.CreateTemp("", "tel-*.env")
-						if err != nil {
-							return errcat.NoDaemonLogs.Newf("failed to create temporary environment file. %w", err)
-						}
-						defer os.Remove(file.Name())
-
-						if err = is.writeEnvToFileAndClose(file); err != nil {
-							return err
-						}
-						envFile = file.Name()
-					}
-					cmd, err = is.startInDocker(ctx, envFile, args.cmdline)
-				} else {
-					cmd, err = proc.Start(ctx, is.env, args.cmdline[0], args.cmdline[1:]...)
-				}
-				if err == nil {
-					// Send info about the pid and intercept id to the traffic-manager so that it kills
-					// the process if it receives a leave of quit call.
-					cc := is.connectorClient
-					ior := &connector.Interceptor{
-						InterceptId: is.env["TELEPRESENCE_INTERCEPT_ID"],
-						Pid:         int32(os.Getpid()),
-					}
-					if _, err = cc.AddInterceptor(ctx, ior); err != nil {
-						_ = cmd.Process.Kill()
-						return err
-					}
-					defer func() {
-						if _, err := cc.RemoveInterceptor(ctx, ior); err != nil {
-							dlog.Error(ctx, err)
-						}
-					}()
-					err = proc.Wait(ctx, cancel, cmd)
-				}
-				// The external command will not output anything to the logs. An error here
-				// is likely caused by the user hitting <ctrl>-C to terminate the process.
-				if err != nil {
-					err = errcat.NoDaemonLogs.New(err)
-				}
-				return err
+			return client.WithEnsuredState(ctx, is, false, func() error {
+				return is.runCommand(ctx)
 			})
 		})
 	})
 }
 
+// runCommand runs the command that handles the intercepted traffic, either locally or using "docker run", and
+// waits for it to exit.
+func (is *interceptState) runCommand(ctx context.Context) (err error) {
+	ctx, cancel := context.WithCancel(dcontext.WithSoftness(ctx))
+	defer cancel()
+	var cmd *dexec.Cmd
+	if is.args.dockerRun {
+		envFile := is.args.envFile
+		if envFile == "" || is.args.envSyntax != envSyntaxDocker {
+			file, err := os.CreateTemp("", "tel-*.env")
+			if err != nil {
+				return errcat.NoDaemonLogs.Newf("failed to create temporary environment file. %w", err)
+			}
+			defer os.Remove(file.Name())
+
+			if err = is.writeEnvToFileAndClose(file, envSyntaxDocker); err != nil {
+				return err
+			}
+			envFile = file.Name()
+		}
+		cmd, err = is.startInDocker(ctx, envFile, is.args.cmdline)
+	} else {
+		cmd, err = proc.Start(ctx, is.env, is.args.cmdline[0], is.args.cmdline[1:]...)
+	}
+	if err == nil {
+		// Send info about the pid and intercept id to the traffic-manager so that it kills
+		// the process if it receives a leave of quit call.
+		cc := is.connectorClient
+		ior := &connector.Interceptor{
+			InterceptId: is.env["TELEPRESENCE_INTERCEPT_ID"],
+			Pid:         int32(os.Getpid()),
+		}
+		if _, err = cc.AddInterceptor(ctx, ior); err != nil {
+			_ = cmd.Process.Kill()
+			return err
+		}
+		defer func() {
+			if _, err := cc.RemoveInterceptor(ctx, ior); err != nil {
+				dlog.Error(ctx, err)
+			}
+		}()
+		err = proc.Wait(ctx, cancel, cmd)
+	}
+	// The external command will not output anything to the logs. An error here
+	// is likely caused by the user hitting <ctrl>-C to terminate the process.
+	if err != nil {
+		err = errcat.NoDaemonLogs.New(err)
+	}
+	return err
+}
+
 func newInterceptState(
 	ctx context.Context,
 	cmd safeCobraCommand,
@@ -385,33 +568,6 @@ func interceptMessage(r *connector.InterceptResult) error {
 	return errCat.Newf(msg)
 }
 
-func checkMountCapability(ctx context.Context) error {
-	// Use CombinedOutput to include stderr which has information about whether they
-	// need to upgrade to a newer version of macFUSE or not
-	var cmd *dexec.Cmd
-	if runtime.GOOS == "windows" {
-		cmd = proc.CommandContext(ctx, "sshfs-win", "cmd", "-V")
-	} else {
-		cmd = proc.CommandContext(ctx, "sshfs", "-V")
-	}
-	cmd.DisableLogging = true
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		dlog.Errorf(ctx, "sshfs not installed: %v", err)
-		return errors.New("sshfs is not installed on your local machine")
-	}
-
-	// OSXFUSE changed to macFUSE, and we've noticed that older versions of OSXFUSE
-	// can cause browsers to hang + kernel crashes, so we add an error to prevent
-	// our users from running into this problem.
-	// OSXFUSE isn't included in the output of sshfs -V in versions of 4.0.0 so
-	// we check for that as a proxy for if they have the right version or not.
-	if bytes.Contains(out, []byte("OSXFUSE")) {
-		return errors.New(`macFUSE 4.0.5 or higher is required on your local machine`)
-	}
-	return nil
-}
-
 func parseNumericPort(portStr string) (uint16, error) {
 	port, err := strconv.ParseUint(portStr, 10, 16)
 	if err != nil {
@@ -473,6 +629,10 @@ func (is *interceptState) createRequest(ctx context.Context) (*connector.CreateI
 		return ir, nil
 	}
 
+	spec.PinScale = is.args.pinScale
+	spec.NoRolloutWait = is.args.noRolloutWait
+	spec.Ephemeral = is.args.ephemeral
+	spec.Cluster = is.args.cluster
 	if is.args.serviceName != "" {
 		spec.ServiceName = is.args.serviceName
 	}
@@ -498,10 +658,14 @@ func (is *interceptState) createRequest(ctx context.Context) (*connector.CreateI
 		doMount, boolErr = strconv.ParseBool(is.args.mount)
 		if boolErr != nil || doMount {
 			// not --mount=false, so refuse.
-			return nil, errcat.User.Newf("remote volume mounts are disabled: %w", err)
+			return nil, errcat.User.Newf("remote volume mounts are disabled: %w. Use --mount=false to intercept without them", err)
 		}
 	}
 
+	if doMount && !is.args.mountROSet && is.defaults.GetMount() == "read-only" {
+		is.args.mountReadOnly = true
+	}
+
 	for _, toPod := range is.args.toPod {
 		var port uint16
 		if port, err = parseNumericPort(toPod); err != nil {
@@ -510,6 +674,18 @@ func (is *interceptState) createRequest(ctx context.Context) (*connector.CreateI
 		spec.ExtraPorts = append(spec.ExtraPorts, int32(port))
 	}
 
+	if is.args.mountReadOnly || len(is.args.mountInclude) > 0 || len(is.args.mountExclude) > 0 {
+		if !doMount {
+			return nil, errcat.User.New("--mount-ro, --mount-include, and --mount-exclude cannot be used with --mount=false")
+		}
+		if err = validateMountPaths(is.args.mountInclude, is.args.mountExclude); err != nil {
+			return nil, err
+		}
+		ir.MountReadOnly = is.args.mountReadOnly
+		ir.MountInclude = is.args.mountInclude
+		ir.MountExclude = is.args.mountExclude
+	}
+
 	if is.args.dockerMount != "" {
 		if !is.args.dockerRun {
 			return nil, errcat.User.New("--docker-mount must be used together with --docker-run")
@@ -525,9 +701,80 @@ func (is *interceptState) createRequest(ctx context.Context) (*connector.CreateI
 	if spec.MechanismArgs, err = is.args.extState.MechanismArgs(); err != nil {
 		return nil, err
 	}
+	if header := is.defaults.GetRequiredHeader(); header != "" {
+		if err = checkRequiredHeader(spec, header); err != nil {
+			return nil, err
+		}
+	}
+	if is.args.replace {
+		if spec.Mechanism != "tcp" {
+			return nil, errcat.User.Newf("a replaced container receives all of its traffic, so the %s mechanism cannot be used", spec.Mechanism)
+		}
+		spec.Replace = true
+	}
 	return ir, nil
 }
 
+// checkRequiredHeader returns an error unless the intercept matches on the given header, which the intercepted
+// workload requires using its telepresence.getambassador.io/intercept-header annotation.
+func checkRequiredHeader(spec *manager.InterceptSpec, header string) error {
+	if spec.Mechanism == "http" {
+		for _, arg := range spec.MechanismArgs {
+			for _, prefix := range []string{"--header=", "--match="} {
+				if kv := strings.TrimPrefix(arg, prefix); kv != arg {
+					if k, _, ok := strings.Cut(kv, "="); ok && strings.EqualFold(k, header) {
+						return nil
+					}
+				}
+			}
+		}
+	}
+	return errcat.User.Newf("%s only permits intercepts that match on the %q header. Use --http-header=%s=<value>",
+		spec.Agent, header, header)
+}
+
+// applyInterceptDefaults applies the defaults that the intercepted workload declares using annotations to the
+// flags that weren't given.
+func (is *interceptState) applyInterceptDefaults(ctx context.Context) error {
+	d, err := is.connectorClient.GetInterceptDefaults(ctx, &connector.GetInterceptDefaultsRequest{
+		Name:      is.args.agentName,
+		Namespace: is.args.namespace,
+	})
+	if err != nil {
+		switch st := status.Convert(err); st.Code() {
+		case codes.Unimplemented:
+			return nil
+		case codes.InvalidArgument:
+			return errcat.User.New(st.Message())
+		}
+		return err
+	}
+	is.defaults = d
+	if d.Port != "" && !is.args.portSet {
+		is.args.port = d.Port
+	}
+	if d.Mount == "false" && !is.args.mountSet {
+		is.args.mount = "false"
+	}
+	return nil
+}
+
+// validateMountPaths checks that the paths given with --mount-include and --mount-exclude are absolute paths in
+// the intercepted container.
+func validateMountPaths(include, exclude []string) error {
+	if runtime.GOOS == "windows" && (len(include) > 0 || len(exclude) > 0) {
+		return errcat.User.New("--mount-include and --mount-exclude are not supported on Windows")
+	}
+	for _, ps := range [][]string{include, exclude} {
+		for _, p := range ps {
+			if !path.IsAbs(p) {
+				return errcat.User.Newf("mount path %q is not an absolute path", p)
+			}
+		}
+	}
+	return nil
+}
+
 func (is *interceptState) getMountPoint() (string, bool, error) {
 	mountPoint := ""
 	doMount, err := strconv.ParseBool(is.args.mount)
@@ -603,6 +850,11 @@ func (is *interceptState) canInterceptAndLogIn(ctx context.Context, ir *connecto
 }
 
 func (is *interceptState) createAndValidateRequest(ctx context.Context) (*connector.CreateInterceptRequest, error) {
+	if is.args.agentName != "" {
+		if err := is.applyInterceptDefaults(ctx); err != nil {
+			return nil, err
+		}
+	}
 	ir, err := is.createRequest(ctx)
 	if err != nil {
 		return nil, err
@@ -682,7 +934,12 @@ func (is *interceptState) EnsureState(ctx context.Context) (acquired bool, err e
 	}()
 
 	// Submit the request
+	stopProgress := func() {}
+	if args.agentName != "" && !args.noRolloutWait && !args.ephemeral && args.cluster == "" {
+		stopProgress = is.reportRolloutProgress(ctx, ir.Spec)
+	}
 	r, err := is.connectorClient.CreateIntercept(ctx, ir)
+	stopProgress()
 	if err != nil {
 		return false, fmt.Errorf("connector.CreateIntercept: %w", err)
 	}
@@ -694,12 +951,36 @@ func (is *interceptState) EnsureState(ctx context.Context) (acquired bool, err e
 		}
 		return false, interceptMessage(r)
 	}
+	if r.Updated {
+		fmt.Fprintf(is.cmd.OutOrStdout(), "Intercept %s updated\n", args.name)
+	}
+	if err := cache.SaveInterceptRecordToUserCache(ctx, is.record(ir, r.GetInterceptInfo())); err != nil {
+		dlog.Warnf(ctx, "unable to record intercept %s: %v", args.name, err)
+	}
+	for _, w := range r.Warnings {
+		fmt.Fprintf(is.cmd.ErrOrStderr(), "Warning: %s\n", w)
+	}
 
 	if args.agentName == "" {
 		// local-only
+		if ii := r.InterceptInfo; ii != nil {
+			is.env = map[string]string{
+				"TELEPRESENCE_INTERCEPT_NAME": ii.Spec.Name,
+				"TELEPRESENCE_NAMESPACE":      ii.Spec.Namespace,
+			}
+		}
 		return true, nil
 	}
 	fmt.Fprintf(is.cmd.OutOrStdout(), "Using %s %s\n", r.WorkloadKind, args.agentName)
+	if args.noRolloutWait && r.GetInterceptInfo().GetDisposition() == manager.InterceptDispositionType_WAITING {
+		fmt.Fprintf(is.cmd.OutOrStdout(), "Intercept %s is waiting for the rollout of %s to bring a traffic-agent. "+
+			"Use \"telepresence list\" to see when it's active\n", args.name, args.agentName)
+	}
+	if args.waitFor == waitForRollout {
+		if err = is.waitForRollout(ctx); err != nil {
+			return true, err
+		}
+	}
 	var intercept *manager.InterceptInfo
 
 	// Add metadata to scout from InterceptResult
@@ -752,8 +1033,28 @@ func (is *interceptState) EnsureState(ctx context.Context) (acquired bool, err e
 	is.scout.SetMetadatum(ctx, "intercept_id", intercept.Id)
 
 	is.env = intercept.Environment
+	if is.env == nil {
+		is.env = make(map[string]string)
+	}
+	for _, k := range is.defaults.GetEnvExclude() {
+		delete(is.env, k)
+	}
 	is.env["TELEPRESENCE_INTERCEPT_ID"] = intercept.Id
+	is.env["TELEPRESENCE_INTERCEPT_NAME"] = intercept.Spec.Name
+	is.env["TELEPRESENCE_WORKLOAD"] = intercept.Spec.Agent
+	is.env["TELEPRESENCE_NAMESPACE"] = intercept.Spec.Namespace
+	is.env["TELEPRESENCE_LOCAL_PORT"] = strconv.Itoa(int(intercept.Spec.TargetPort))
 	is.env["TELEPRESENCE_ROOT"] = intercept.ClientMountPoint
+	if intercept.PreviewDomain != "" {
+		is.env["TELEPRESENCE_PREVIEW_URL"] = previewURL(intercept.PreviewDomain)
+	}
+	if intercept.PodName != "" {
+		is.env["TELEPRESENCE_POD_NAME"] = intercept.PodName
+	}
+	is.env["TELEPRESENCE_SESSION_ID"] = is.connInfo.GetSessionInfo().GetSessionId()
+	if headers := interceptHeaders(intercept.Headers); headers != "" {
+		is.env["TELEPRESENCE_INTERCEPT_HEADERS"] = headers
+	}
 	if args.envFile != "" {
 		if err = is.writeEnvFile(); err != nil {
 			return true, err
@@ -774,6 +1075,128 @@ func (is *interceptState) EnsureState(ctx context.Context) (acquired bool, err e
 	return true, nil
 }
 
+// rolloutProgressDelay is how long an intercept waits for its traffic-agent before the progress of the rollout
+// is printed.
+const rolloutProgressDelay = 3 * time.Second
+
+// reportRolloutProgress prints the progress of the rollout of the intercepted workload while the intercept is
+// created, so that a slow rollout doesn't look like a hang. Nothing is printed unless the creation takes longer
+// than the rolloutProgressDelay. The returned function stops the reporting.
+func (is *interceptState) reportRolloutProgress(ctx context.Context, spec *manager.InterceptSpec) func() {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(rolloutProgressDelay):
+		}
+		stream, err := is.connectorClient.WatchRollout(ctx, &connector.WatchRolloutRequest{
+			WorkloadName: spec.Agent,
+			Namespace:    spec.Namespace,
+			WorkloadKind: spec.WorkloadKind,
+		})
+		if err != nil {
+			return
+		}
+		out := is.cmd.OutOrStdout()
+		for {
+			// An older user daemon that doesn't implement WatchRollout ends the stream with an error.
+			p, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			if p.Phase == connector.InterceptWaitProgress_ROLLING_OUT {
+				fmt.Fprintf(out, "Waiting for rollout, %d of %d pods ready: %s\n", p.ReadyPods, p.TotalPods, p.Message)
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// waitForRollout waits until all pods of the intercepted workload have a traffic-agent and are ready, and prints
+// the reasons for the wait each time they change.
+func (is *interceptState) waitForRollout(ctx context.Context) error {
+	wr := &connector.WaitForInterceptRequest{Name: is.args.name}
+	if is.args.waitTimeout > 0 {
+		wr.Timeout = durationpb.New(is.args.waitTimeout)
+	}
+	stream, err := is.connectorClient.WaitForIntercept(ctx, wr)
+	if err != nil {
+		return err
+	}
+	out := is.cmd.OutOrStdout()
+	for {
+		p, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = errcat.User.Newf("wait for intercept %s ended prematurely", is.args.name)
+			}
+			return err
+		}
+		switch p.Phase {
+		case connector.InterceptWaitProgress_WAITING:
+			fmt.Fprintf(out, "Waiting for intercept %s: %s\n", is.args.name, p.Message)
+		case connector.InterceptWaitProgress_ROLLING_OUT:
+			fmt.Fprintf(out, "Waiting for rollout, %d of %d pods ready: %s\n", p.ReadyPods, p.TotalPods, p.Message)
+		case connector.InterceptWaitProgress_READY:
+			fmt.Fprintf(out, "Rollout complete, %d of %d pods ready\n", p.ReadyPods, p.TotalPods)
+			return nil
+		case connector.InterceptWaitProgress_FAILED:
+			return errcat.Category(p.ErrorCategory).New(p.ErrorText)
+		}
+	}
+}
+
+// record returns the InterceptRecord that describes how the intercept was created from the given request.
+func (is *interceptState) record(ir *connector.CreateInterceptRequest, ii *manager.InterceptInfo) *cache.InterceptRecord {
+	args := &is.args
+	rec := &cache.InterceptRecord{
+		Name:      args.name,
+		Workload:  args.agentName,
+		Namespace: args.namespace,
+		LocalOnly: args.localOnly,
+	}
+	if ns := ii.GetSpec().GetNamespace(); ns != "" {
+		rec.Namespace = ns
+	}
+	if args.localOnly {
+		return rec
+	}
+	rec.Replace = args.replace
+	rec.Port = args.port
+	rec.Service = args.serviceName
+	rec.Mechanism = ir.Spec.Mechanism
+	rec.MechanismArgs = ir.Spec.MechanismArgs
+	rec.Mount = args.mount
+	rec.MountReadOnly = ir.MountReadOnly
+	rec.MountInclude = ir.MountInclude
+	rec.MountExclude = ir.MountExclude
+	rec.ToPod = args.toPod
+	rec.PreviewURL = args.previewEnabled
+	rec.PinScale = args.pinScale
+	rec.Ephemeral = args.ephemeral
+	rec.Cluster = args.cluster
+	if len(args.cmdline) > 0 || args.dockerRun {
+		rec.Handler = &cache.InterceptHandler{
+			Command:     args.cmdline,
+			DockerRun:   args.dockerRun,
+			DockerMount: args.dockerMount,
+		}
+	}
+	if args.envFile != "" || args.envJSON != "" {
+		rec.Env = &cache.InterceptEnv{File: args.envFile, JSON: args.envJSON}
+		if args.envFile != "" {
+			rec.Env.Syntax = args.envSyntax.String()
+		}
+	}
+	return rec
+}
+
 func (is *interceptState) DeactivateState(ctx context.Context) error {
 	return removeIntercept(ctx, strings.TrimSpace(is.args.name))
 }
@@ -789,6 +1212,9 @@ func removeIntercept(ctx context.Context, name string) error {
 		if r.Error != connector.InterceptError_UNSPECIFIED {
 			return interceptMessage(r)
 		}
+		if err = cache.DeleteInterceptRecordFromUserCache(ctx, name); err != nil {
+			dlog.Warnf(ctx, "unable to remove the record of intercept %s: %v", name, err)
+		}
 		return nil
 	})
 }
@@ -824,6 +1250,11 @@ func (is *interceptState) startInDocker(ctx context.Context, envFile string, arg
 		ourArgs = append(ourArgs, "-p", fmt.Sprintf("%d:%d", is.localPort, is.dockerPort))
 	}
 
+	if dnsIP := is.connInfo.DockerDnsIp; len(dnsIP) > 0 && !hasArg("--dns") {
+		// Connected with --allow-docker, so the container can resolve cluster names using the root daemon.
+		ourArgs = append(ourArgs, "--dns", net.IP(dnsIP).String())
+	}
+
 	dockerMount := ""
 	if is.mountPoint != "" { // do we have a mount point at all?
 		if dockerMount = is.args.dockerMount; dockerMount == "" {
@@ -831,55 +1262,62 @@ func (is *interceptState) startInDocker(ctx context.Context, envFile string, arg
 		}
 	}
 	if dockerMount != "" {
-		ourArgs = append(ourArgs, "-v", fmt.Sprintf("%s:%s", is.mountPoint, dockerMount))
+		volume := fmt.Sprintf("%s:%s", is.mountPoint, dockerMount)
+		if is.args.mountReadOnly {
+			volume += ":ro"
+		}
+		ourArgs = append(ourArgs, "-v", volume)
 	}
 	return proc.Start(ctx, nil, "docker", append(ourArgs, args...)...)
 }
 
+// interceptHeaders returns the header matchers of an intercept as a JSON object, or an empty string when the
+// intercept doesn't match on headers. The path matchers, which use keys like ":path-prefix:", are excluded.
+func interceptHeaders(matchers map[string]string) string {
+	headers := make(map[string]string, len(matchers))
+	for k, v := range matchers {
+		if !strings.HasPrefix(k, ":") {
+			headers[k] = v
+		}
+	}
+	if len(headers) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(headers)
+	if err != nil {
+		// Creating JSON from a map[string]string should never fail
+		panic(err)
+	}
+	return string(data)
+}
+
 func (is *interceptState) writeEnvFile() error {
 	file, err := os.Create(is.args.envFile)
 	if err != nil {
 		return errcat.NoDaemonLogs.Newf("failed to create environment file %q: %w", is.args.envFile, err)
 	}
-	return is.writeEnvToFileAndClose(file)
+	return is.writeEnvToFileAndClose(file, is.args.envSyntax)
 }
 
-func (is *interceptState) writeEnvToFileAndClose(file *os.File) (err error) {
+func (is *interceptState) writeEnvToFileAndClose(file *os.File, syntax envSyntax) error {
 	defer file.Close()
-	w := bufio.NewWriter(file)
-
-	keys := make([]string, len(is.env))
-	i := 0
-	for k := range is.env {
-		keys[i] = k
-		i++
+	skipped, err := syntax.write(file, is.env)
+	if err != nil {
+		return err
 	}
-	sort.Strings(keys)
-
-	for _, k := range keys {
-		if _, err = w.WriteString(k); err != nil {
-			return err
-		}
-		if err = w.WriteByte('='); err != nil {
-			return err
-		}
-		if _, err = w.WriteString(is.env[k]); err != nil {
-			return err
-		}
-		if err = w.WriteByte('\n'); err != nil {
-			return err
-		}
+	if len(skipped) > 0 {
+		fmt.Fprintf(is.cmd.ErrOrStderr(), "Warning: the %s syntax cannot represent the environment variables %s. They were omitted from %s\n",
+			syntax, strings.Join(skipped, ", "), file.Name())
 	}
-	return w.Flush()
+	return nil
 }
 
 func (is *interceptState) writeEnvJSON() error {
-	data, err := json.MarshalIndent(is.env, "", "  ")
+	file, err := os.Create(is.args.envJSON)
 	if err != nil {
-		// Creating JSON from a map[string]string should never fail
-		panic(err)
+		return errcat.NoDaemonLogs.Newf("failed to create environment file %q: %w", is.args.envJSON, err)
 	}
-	return os.WriteFile(is.args.envJSON, data, 0644)
+	return is.writeEnvToFileAndClose(file, envSyntaxJSON)
 }
 
 var hostRx = regexp.MustCompile(`^[a-zA-Z0-9](?:[a-zA-Z0-9\-]*[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9\-]*[a-zA-Z0-9])?)*$`)
@@ -2,20 +2,35 @@ package cli
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"strings"
+	"syscall"
 
 	"github.com/pkg/browser"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/kubernetes"
 
 	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
 	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+	"github.com/telepresenceio/telepresence/v2/pkg/shellquote"
 )
 
 // ClusterIdCommand is a simple command that makes it easier for users to
@@ -55,6 +70,11 @@ func ClusterIdCommand() *cobra.Command {
 func connectCommand() *cobra.Command {
 	var dnsIP string
 	var mappedNamespaces []string
+	var allowDocker bool
+	var switchContext bool
+	var connectOnly bool
+	var exposed []string
+	var namespaceSandbox bool
 
 	kubeFlags := pflag.NewFlagSet("Kubernetes flags", 0)
 	cmd := &cobra.Command{
@@ -62,9 +82,18 @@ func connectCommand() *cobra.Command {
 		Args:  cobra.ArbitraryArgs,
 		Short: "Connect to a cluster",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			exposedPorts, err := parseExposedPorts(exposed)
+			if err != nil {
+				return err
+			}
 			request := &connector.ConnectRequest{
 				KubeFlags:        kubeFlagMap(kubeFlags),
 				MappedNamespaces: mappedNamespaces,
+				AllowDocker:      allowDocker,
+				SwitchContext:    switchContext,
+				ConnectOnly:      connectOnly,
+				ExposedPorts:     exposedPorts,
+				NamespaceSandbox: namespaceSandbox,
 			}
 
 			if len(args) == 0 {
@@ -73,13 +102,25 @@ func connectCommand() *cobra.Command {
 				})
 			}
 
-			return withConnector(cmd, false, request, func(ctx context.Context, _ *connectorState) error {
+			return withConnector(cmd, false, request, func(ctx context.Context, cs *connectorState) error {
+				if cs.NetNamespace != "" {
+					return runInNetNamespace(ctx, cs.rootD, cs.GetSessionInfo().GetSessionId(), args)
+				}
 				return proc.Run(ctx, nil, args[0], args[1:]...)
 			})
 		},
 	}
 
 	flags := cmd.Flags()
+	flags.BoolVar(&switchContext, "switch-context", false, ``+
+		`Switch to the given context, kubeconfig, or flags when already connected using other ones, without restarting `+
+		`the daemons. Intercepts of the current session are removed`)
+	flags.BoolVar(&connectOnly, "connect-only", false, ``+
+		`Only provide outbound connectivity and DNS. The session can't intercept. The traffic-manager may also make `+
+		`the session connect-only, when the user isn't permitted to intercept`)
+	flags.StringSliceVar(&exposed, "expose", nil, ``+
+		`Comma separated list of local ports, as <local-port>[:<cluster-port>], that the pods in the cluster can `+
+		`connect to using the DNS name of the session. The cluster port defaults to the local port`)
 
 	nwFlags := pflag.NewFlagSet("Telepresence networking flags", 0)
 	// TODO: Those flags aren't applicable on a Linux with systemd-resolved configured either but
@@ -94,6 +135,15 @@ func connectCommand() *cobra.Command {
 		"mapped-namespaces", nil, ``+
 			`Comma separated list of namespaces considered by DNS resolver and NAT for outbound connections. `+
 			`Defaults to all namespaces`)
+	nwFlags.BoolVar(&allowDocker,
+		"allow-docker", false, ``+
+			`Make the cluster reachable from local Docker containers, including their DNS lookups. `+
+			`Only supported on Linux`)
+	nwFlags.BoolVar(&namespaceSandbox,
+		"namespace-sandbox", false, ``+
+			`Establish routing and DNS in a dedicated network namespace and leave the host's network untouched. `+
+			`Only the commands that are run by "telepresence connect -- <command>" can reach the cluster. `+
+			`Only supported on Linux`)
 	flags.AddFlagSet(nwFlags)
 
 	kubeConfig := genericclioptions.NewConfigFlags(false)
@@ -103,13 +153,151 @@ func connectCommand() *cobra.Command {
 	return cmd
 }
 
+// runInNetNamespace runs the given command in the network namespace of the given session. Only root can enter the
+// namespace, so the command is started by the root daemon, but with the identity of the current user. Its stdio,
+// the signals that it's sent, and its exit code are relayed by this process.
+func runInNetNamespace(ctx context.Context, daemonClient daemon.DaemonClient, sessionID string, args []string) error {
+	if daemonClient == nil {
+		return errcat.User.New("commands can only be run in the network namespace of a root daemon that runs on this host")
+	}
+	// The command is found using the PATH of the current user, not the one of the root daemon.
+	exe, err := exec.LookPath(args[0])
+	if err != nil {
+		return errcat.User.New(err)
+	}
+	if exe, err = filepath.Abs(exe); err != nil {
+		return err
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(client.WithRootDaemonSession(ctx, sessionID))
+	defer cancel()
+	stream, err := daemonClient.RunInNetNamespace(ctx)
+	if err != nil {
+		return err
+	}
+	if err = stream.Send(&daemon.NetNamespaceInput{Args: append([]string{exe}, args[1:]...), Env: os.Environ(), Dir: dir}); err != nil {
+		return err
+	}
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	exitCode, err := relayNetNamespaceCommand(stream, os.Stdin, os.Stdout, os.Stderr, sigCh)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("%s: exited with %d", shellquote.ShellString(exe, args[1:]), exitCode)
+	}
+	return nil
+}
+
+// relayNetNamespaceCommand relays the given stdin and signals to a command that RunInNetNamespace runs, and the output
+// of the command to the given stdout and stderr, until the command exits. The exit code of the command is returned.
+func relayNetNamespaceCommand(
+	stream daemon.Daemon_RunInNetNamespaceClient,
+	stdin io.Reader,
+	stdout, stderr io.Writer,
+	sigCh <-chan os.Signal,
+) (int, error) {
+	ctx := stream.Context()
+	inputs := make(chan *daemon.NetNamespaceInput)
+	go func() {
+		buf := make([]byte, 0x8000)
+		for {
+			n, err := stdin.Read(buf)
+			in := &daemon.NetNamespaceInput{Stdin: append([]byte(nil), buf[:n]...), CloseStdin: err != nil}
+			select {
+			case <-ctx.Done():
+				return
+			case inputs <- in:
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		// The stream isn't safe for concurrent sends, so all inputs are sent from here.
+		for {
+			var in *daemon.NetNamespaceInput
+			select {
+			case <-ctx.Done():
+				return
+			case in = <-inputs:
+			case sig := <-sigCh:
+				s, ok := sig.(syscall.Signal)
+				if !ok {
+					continue
+				}
+				in = &daemon.NetNamespaceInput{Signal: int32(s)}
+			}
+			if err := stream.Send(in); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		out, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = errors.New("the root daemon didn't report the exit code of the command")
+			}
+			return 0, err
+		}
+		if len(out.Stdout) > 0 {
+			_, _ = stdout.Write(out.Stdout)
+		}
+		if len(out.Stderr) > 0 {
+			_, _ = stderr.Write(out.Stderr)
+		}
+		if out.Exited {
+			return int(out.ExitCode), nil
+		}
+	}
+}
+
+// parseExposedPorts parses the given --expose values.
+func parseExposedPorts(specs []string) ([]*manager.ExposedPort, error) {
+	ports := make([]*manager.ExposedPort, 0, len(specs))
+	for _, spec := range specs {
+		portError := errcat.User.Newf("expose must be of the format --expose <local-port>[:<cluster-port>], you gave: %q", spec)
+		local, cluster, hasCluster := strings.Cut(spec, ":")
+		lp, err := parseNumericPort(local)
+		if err != nil || lp == 0 {
+			return nil, portError
+		}
+		cp := lp
+		if hasCluster {
+			if cp, err = parseNumericPort(cluster); err != nil || cp == 0 {
+				return nil, portError
+			}
+		}
+		ports = append(ports, &manager.ExposedPort{Port: int32(cp), LocalPort: int32(lp)})
+	}
+	return ports, nil
+}
+
 func dashboardCommand() *cobra.Command {
-	return &cobra.Command{
+	local := false
+	cmd := &cobra.Command{
 		Use:  "dashboard",
 		Args: cobra.NoArgs,
 
 		Short: "Open the dashboard in a web page",
+		Long: `Open the Ambassador Cloud dashboard in a web page.
+
+Use --local to instead open the local dashboard that the user daemon serves on localhost. It shows the
+connection health, routed subnets, DNS statistics, and the active intercepts with their traffic, and
+it can be used to leave intercepts.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if local {
+				return openLocalDashboard(cmd)
+			}
 			cloudCfg := client.GetConfig(cmd.Context()).Cloud
 
 			// Ensure we're logged in
@@ -128,23 +316,74 @@ func dashboardCommand() *cobra.Command {
 			}
 
 			return nil
-		}}
+		},
+	}
+	cmd.Flags().BoolVar(&local, "local", false, "Open the local dashboard that is served by the user daemon")
+	return cmd
 }
 
+func openLocalDashboard(cmd *cobra.Command) error {
+	return cliutil.WithStartedConnector(cmd.Context(), false, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
+		di, err := connectorClient.Dashboard(ctx, &empty.Empty{})
+		if err != nil {
+			if status.Code(err) == codes.Unimplemented {
+				return errcat.User.New("the user daemon is too old to serve the local dashboard. Please run \"telepresence quit --stop-daemons\" and try again")
+			}
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "The local dashboard is served at %s\n", di.Url)
+		if err := browser.OpenURL(di.Url); err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), "Unable to open a web browser: %v\n", err)
+		}
+		return nil
+	})
+}
+
+// quitDisconnect and quitForceCleanup are the functions that the quit command calls. Tests replace them.
+var (
+	quitDisconnect   = cliutil.Disconnect
+	quitForceCleanup = cliutil.ForceCleanup
+)
+
 func quitCommand() *cobra.Command {
 	quitRootDaemon := false
 	quitUserDaemon := false
+	stopDaemons := false
+	onlyDisconnect := false
+	forceCleanup := false
 	cmd := &cobra.Command{
 		Use:  "quit",
 		Args: cobra.NoArgs,
 
 		Short: "Tell telepresence daemon to quit",
+		Long: `Tell telepresence daemon to quit.
+
+The session is disconnected and the daemons are left running by default, so that the next connect is fast
+and doesn't need to elevate privileges to start the root daemon. Use --stop-daemons to also stop both the
+user and the root daemon.
+
+Use --force-cleanup to also restore the routes and the DNS configuration that a root daemon which didn't get
+a chance to clean up, e.g. because it crashed, left behind. The daemons are stopped first.`,
 		RunE: func(cmd *cobra.Command, _ []string) error {
-			return cliutil.Disconnect(cmd.Context(), quitUserDaemon, quitRootDaemon)
+			if onlyDisconnect && (stopDaemons || quitRootDaemon || quitUserDaemon || forceCleanup) {
+				return errcat.User.New("--only-disconnect cannot be combined with --stop-daemons, --root-daemon, --user-daemon, or --force-cleanup")
+			}
+			if stopDaemons || forceCleanup {
+				quitRootDaemon = true
+				quitUserDaemon = true
+			}
+			if err := quitDisconnect(cmd.Context(), quitUserDaemon, quitRootDaemon); err != nil || !forceCleanup {
+				return err
+			}
+			return quitForceCleanup(cmd.Context())
 		},
 	}
 	flags := cmd.Flags()
+	flags.BoolVar(&stopDaemons, "stop-daemons", false, "stop both the user and the root daemon")
+	flags.BoolVar(&onlyDisconnect, "only-disconnect", false, "disconnect the session and leave the daemons running (default)")
 	flags.BoolVarP(&quitRootDaemon, "root-daemon", "r", false, "stop root daemon")
 	flags.BoolVarP(&quitUserDaemon, "user-daemon", "u", false, "stop user daemon")
+	flags.BoolVar(&forceCleanup, "force-cleanup", false,
+		"stop both daemons and restore the network configuration that a crashed root daemon left behind")
 	return cmd
 }
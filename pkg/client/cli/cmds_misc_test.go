@@ -0,0 +1,215 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// netnsStream is a RunInNetNamespace stream where the inputs that are sent are delivered on a channel and the outputs
+// are taken from another.
+type netnsStream struct {
+	grpc.ClientStream
+	ctx     context.Context
+	inputs  chan *daemon.NetNamespaceInput
+	outputs chan *daemon.NetNamespaceOutput
+}
+
+func newNetnsStream(ctx context.Context) *netnsStream {
+	return &netnsStream{
+		ctx:     ctx,
+		inputs:  make(chan *daemon.NetNamespaceInput, 10),
+		outputs: make(chan *daemon.NetNamespaceOutput, 10),
+	}
+}
+
+func (s *netnsStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *netnsStream) Send(in *daemon.NetNamespaceInput) error {
+	s.inputs <- in
+	return nil
+}
+
+func (s *netnsStream) Recv() (*daemon.NetNamespaceOutput, error) {
+	out, ok := <-s.outputs
+	if !ok {
+		return nil, io.EOF
+	}
+	return out, nil
+}
+
+func (s *netnsStream) nextInput(t *testing.T) *daemon.NetNamespaceInput {
+	select {
+	case in := <-s.inputs:
+		return in
+	case <-time.After(5 * time.Second):
+		t.Fatal("no input was sent")
+		return nil
+	}
+}
+
+func Test_relayNetNamespaceCommand(t *testing.T) {
+	stream := newNetnsStream(dlog.NewTestContext(t, false))
+	sigCh := make(chan os.Signal, 1)
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	type result struct {
+		exitCode int
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		exitCode, err := relayNetNamespaceCommand(stream, strings.NewReader("input"), stdout, stderr, sigCh)
+		done <- result{exitCode, err}
+	}()
+
+	// The stdin is sent and then closed
+	var stdin []byte
+	var closed bool
+	for !closed {
+		in := stream.nextInput(t)
+		stdin = append(stdin, in.Stdin...)
+		closed = in.CloseStdin
+	}
+	assert.Equal(t, "input", string(stdin))
+
+	// Signals are passed on
+	sigCh <- syscall.SIGTERM
+	assert.Equal(t, int32(syscall.SIGTERM), stream.nextInput(t).Signal)
+
+	stream.outputs <- &daemon.NetNamespaceOutput{Stdout: []byte("out")}
+	stream.outputs <- &daemon.NetNamespaceOutput{Stderr: []byte("err")}
+	stream.outputs <- &daemon.NetNamespaceOutput{Exited: true, ExitCode: 3}
+	r := <-done
+	require.NoError(t, r.err)
+	assert.Equal(t, 3, r.exitCode)
+	assert.Equal(t, "out", stdout.String())
+	assert.Equal(t, "err", stderr.String())
+}
+
+func Test_relayNetNamespaceCommand_noExitCode(t *testing.T) {
+	stream := newNetnsStream(dlog.NewTestContext(t, false))
+	close(stream.outputs)
+	_, err := relayNetNamespaceCommand(stream, strings.NewReader(""), io.Discard, io.Discard, nil)
+	assert.Error(t, err)
+}
+
+// netnsDaemon is a daemon.DaemonClient that only implements RunInNetNamespace.
+type netnsDaemon struct {
+	daemon.DaemonClient
+	stream *netnsStream
+	md     metadata.MD
+}
+
+func (d *netnsDaemon) RunInNetNamespace(ctx context.Context, _ ...grpc.CallOption) (daemon.Daemon_RunInNetNamespaceClient, error) {
+	d.md, _ = metadata.FromOutgoingContext(ctx)
+	d.stream.ctx = ctx
+	return d.stream, nil
+}
+
+func Test_runInNetNamespace(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	d := &netnsDaemon{stream: newNetnsStream(ctx)}
+	d.stream.outputs <- &daemon.NetNamespaceOutput{Exited: true, ExitCode: 2}
+	err := runInNetNamespace(ctx, d, "session-1", []string{"sh", "-c", "exit 2"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exited with 2")
+
+	// The root daemon is told which session's namespace to use, and the command is found using the PATH of the user
+	assert.Equal(t, []string{"session-1"}, d.md.Get("x-telepresence-session-id"))
+	first := d.stream.nextInput(t)
+	require.Len(t, first.Args, 3)
+	assert.True(t, filepath.IsAbs(first.Args[0]))
+	assert.Equal(t, "sh", filepath.Base(first.Args[0]))
+	assert.Equal(t, []string{"-c", "exit 2"}, first.Args[1:])
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	assert.Equal(t, wd, first.Dir)
+	assert.Contains(t, first.Env, "PATH="+os.Getenv("PATH"))
+
+	d.stream = newNetnsStream(ctx)
+	d.stream.outputs <- &daemon.NetNamespaceOutput{Exited: true}
+	assert.NoError(t, runInNetNamespace(ctx, d, "session-1", []string{"sh"}))
+
+	err = runInNetNamespace(ctx, d, "session-1", []string{"no-such-command-for-telepresence"})
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+
+	err = runInNetNamespace(ctx, nil, "session-1", []string{"sh"})
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+}
+
+func Test_quitCommand(t *testing.T) {
+	type call struct {
+		quitUserDaemon bool
+		quitRootDaemon bool
+		forceCleanup   bool
+	}
+	savedDisconnect, savedForceCleanup := quitDisconnect, quitForceCleanup
+	defer func() {
+		quitDisconnect, quitForceCleanup = savedDisconnect, savedForceCleanup
+	}()
+	var calls []call
+	quitDisconnect = func(_ context.Context, quitUserDaemon, quitRootDaemon bool) error {
+		calls = append(calls, call{quitUserDaemon: quitUserDaemon, quitRootDaemon: quitRootDaemon})
+		return nil
+	}
+	quitForceCleanup = func(context.Context) error {
+		calls[len(calls)-1].forceCleanup = true
+		return nil
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    call
+		wantErr bool
+	}{
+		{name: "default", want: call{}},
+		{name: "only-disconnect", args: []string{"--only-disconnect"}, want: call{}},
+		{name: "stop-daemons", args: []string{"--stop-daemons"}, want: call{quitUserDaemon: true, quitRootDaemon: true}},
+		{name: "user-daemon", args: []string{"--user-daemon"}, want: call{quitUserDaemon: true}},
+		{name: "user-daemon shorthand", args: []string{"-u"}, want: call{quitUserDaemon: true}},
+		{name: "root-daemon", args: []string{"--root-daemon"}, want: call{quitRootDaemon: true}},
+		{name: "root-daemon shorthand", args: []string{"-r"}, want: call{quitRootDaemon: true}},
+		{name: "force-cleanup", args: []string{"--force-cleanup"}, want: call{quitUserDaemon: true, quitRootDaemon: true, forceCleanup: true}},
+		{name: "only-disconnect and stop-daemons", args: []string{"--only-disconnect", "--stop-daemons"}, wantErr: true},
+		{name: "only-disconnect and user-daemon", args: []string{"--only-disconnect", "-u"}, wantErr: true},
+		{name: "only-disconnect and root-daemon", args: []string{"--only-disconnect", "-r"}, wantErr: true},
+		{name: "only-disconnect and force-cleanup", args: []string{"--only-disconnect", "--force-cleanup"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			calls = nil
+			cmd := quitCommand()
+			out := &bytes.Buffer{}
+			cmd.SetOut(out)
+			cmd.SetErr(out)
+			cmd.SetArgs(tt.args)
+			err := cmd.ExecuteContext(dlog.NewTestContext(t, false))
+			if tt.wantErr {
+				assert.Equal(t, errcat.User, errcat.GetCategory(err))
+				assert.Empty(t, calls)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, []call{tt.want}, calls)
+		})
+	}
+}
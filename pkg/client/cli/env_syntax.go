@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// envSyntax is the syntax used when writing the environment of an intercept to a file. It implements pflag.Value so
+// that it can be used as the value of the --env-syntax flag.
+type envSyntax int
+
+const (
+	envSyntaxDocker envSyntax = iota
+	envSyntaxCompose
+	envSyntaxSh
+	envSyntaxCsh
+	envSyntaxFish
+	envSyntaxPS1
+	envSyntaxJSON
+)
+
+var envSyntaxNames = []string{"docker", "compose", "sh", "csh", "fish", "ps1", "json"}
+
+func (e envSyntax) String() string {
+	return envSyntaxNames[e]
+}
+
+func (e *envSyntax) Set(s string) error {
+	for i, n := range envSyntaxNames {
+		if s == n {
+			*e = envSyntax(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid syntax %q, must be one of %s", s, strings.Join(envSyntaxNames, "|"))
+}
+
+func (e *envSyntax) Type() string {
+	return "string"
+}
+
+// identifierRx matches the variable names that a shell can assign. Kubernetes also permits '-' and '.' in the name
+// of an environment variable.
+var identifierRx = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// entry returns the line that assigns the given value to the given variable, or an empty string when the syntax
+// cannot represent the assignment.
+func (e envSyntax) entry(k, v string) string {
+	switch e {
+	case envSyntaxDocker:
+		// docker run --env-file has no quoting at all. Everything after the first '=' is the value.
+		if strings.ContainsAny(v, "\r\n") {
+			return ""
+		}
+		return k + "=" + v
+	case envSyntaxCompose:
+		// Double quotes, so that escaped newlines are expanded, and with '$' doubled to prevent interpolation.
+		return k + `="` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "$", "$$").Replace(v) + `"`
+	case envSyntaxSh:
+		if !identifierRx.MatchString(k) {
+			return ""
+		}
+		return "export " + k + "=" + shQuote(v)
+	case envSyntaxCsh:
+		if !identifierRx.MatchString(k) {
+			return ""
+		}
+		// csh performs history substitution on '!' and requires an escaped newline even within single quotes.
+		return "setenv " + k + " '" + strings.NewReplacer("'", `'\''`, "!", `'\!'`, "\n", "\\\n").Replace(v) + "'"
+	case envSyntaxFish:
+		if !identifierRx.MatchString(k) {
+			return ""
+		}
+		return "set -gx " + k + " '" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(v) + "'"
+	case envSyntaxPS1:
+		name := "$Env:" + k
+		if !identifierRx.MatchString(k) {
+			name = "${Env:" + strings.NewReplacer("`", "``", "}", "`}").Replace(k) + "}"
+		}
+		return name + " = '" + strings.ReplaceAll(v, "'", "''") + "'"
+	default:
+		return ""
+	}
+}
+
+// shQuote quotes the given string using POSIX shell single quotes.
+func shQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// write writes the given environment to out using this syntax. Variables that cannot be represented in this
+// syntax are written as comments, and their names are returned so that the caller can warn about them.
+func (e envSyntax) write(out io.Writer, env map[string]string) (skipped []string, err error) {
+	if e == envSyntaxJSON {
+		data, err := json.MarshalIndent(env, "", "  ")
+		if err != nil {
+			// Creating JSON from a map[string]string should never fail
+			panic(err)
+		}
+		_, err = out.Write(data)
+		return nil, err
+	}
+
+	keys := make([]string, len(env))
+	i := 0
+	for k := range env {
+		keys[i] = k
+		i++
+	}
+	sort.Strings(keys)
+
+	w := bufio.NewWriter(out)
+	for _, k := range keys {
+		line := e.entry(k, env[k])
+		if line == "" {
+			skipped = append(skipped, k)
+			line = fmt.Sprintf("# %s cannot be represented in %s syntax", strings.NewReplacer("\n", " ", "\r", " ").Replace(k), e)
+		}
+		if _, err = w.WriteString(line); err != nil {
+			return nil, err
+		}
+		if err = w.WriteByte('\n'); err != nil {
+			return nil, err
+		}
+	}
+	return skipped, w.Flush()
+}
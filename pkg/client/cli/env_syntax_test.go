@@ -0,0 +1,128 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testEnv = map[string]string{
+	"PLAIN":     "hello",
+	"QUOTED":    `it's "quoted" $HOME \n`,
+	"MULTILINE": "line 1\nline 2",
+	"dotted.id": "x",
+}
+
+func TestEnvSyntax_write(t *testing.T) {
+	tests := []struct {
+		syntax  string
+		want    string
+		skipped []string
+	}{
+		{
+			"docker",
+			"# MULTILINE cannot be represented in docker syntax\n" +
+				"PLAIN=hello\n" +
+				`QUOTED=it's "quoted" $HOME \n` + "\n" +
+				"dotted.id=x\n",
+			[]string{"MULTILINE"},
+		},
+		{
+			"compose",
+			`MULTILINE="line 1\nline 2"` + "\n" +
+				`PLAIN="hello"` + "\n" +
+				`QUOTED="it's \"quoted\" $$HOME \\n"` + "\n" +
+				`dotted.id="x"` + "\n",
+			nil,
+		},
+		{
+			"sh",
+			"export MULTILINE='line 1\nline 2'\n" +
+				"export PLAIN='hello'\n" +
+				`export QUOTED='it'\''s "quoted" $HOME \n'` + "\n" +
+				"# dotted.id cannot be represented in sh syntax\n",
+			[]string{"dotted.id"},
+		},
+		{
+			"csh",
+			"setenv MULTILINE 'line 1\\\nline 2'\n" +
+				"setenv PLAIN 'hello'\n" +
+				`setenv QUOTED 'it'\''s "quoted" $HOME \n'` + "\n" +
+				"# dotted.id cannot be represented in csh syntax\n",
+			[]string{"dotted.id"},
+		},
+		{
+			"fish",
+			"set -gx MULTILINE 'line 1\nline 2'\n" +
+				"set -gx PLAIN 'hello'\n" +
+				`set -gx QUOTED 'it\'s "quoted" $HOME \\n'` + "\n" +
+				"# dotted.id cannot be represented in fish syntax\n",
+			[]string{"dotted.id"},
+		},
+		{
+			"ps1",
+			"$Env:MULTILINE = 'line 1\nline 2'\n" +
+				"$Env:PLAIN = 'hello'\n" +
+				`$Env:QUOTED = 'it''s "quoted" $HOME \n'` + "\n" +
+				"${Env:dotted.id} = 'x'\n",
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.syntax, func(t *testing.T) {
+			var es envSyntax
+			require.NoError(t, es.Set(tt.syntax))
+			assert.Equal(t, tt.syntax, es.String())
+			buf := bytes.Buffer{}
+			skipped, err := es.write(&buf, testEnv)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, buf.String())
+			assert.Equal(t, tt.skipped, skipped)
+		})
+	}
+
+	t.Run("json", func(t *testing.T) {
+		buf := bytes.Buffer{}
+		skipped, err := envSyntaxJSON.write(&buf, testEnv)
+		require.NoError(t, err)
+		assert.Empty(t, skipped)
+		var env map[string]string
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &env))
+		assert.Equal(t, testEnv, env)
+	})
+
+	var es envSyntax
+	assert.Error(t, es.Set("bash"))
+}
+
+func TestEnvSyntax_sourceSh(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("requires a POSIX shell")
+	}
+	envFile := filepath.Join(t.TempDir(), "env.sh")
+	f, err := os.Create(envFile)
+	require.NoError(t, err)
+	_, err = envSyntaxSh.write(f, testEnv)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	for k, v := range testEnv {
+		if !identifierRx.MatchString(k) {
+			continue
+		}
+		out, err := exec.Command(sh, "-c", `. "$1" && printf '%s' "$(printenv `+k+`)"`, "sh", envFile).Output()
+		require.NoError(t, err)
+		assert.Equal(t, v, string(out))
+	}
+}
@@ -326,10 +326,14 @@ func (es *ExtensionsState) AgentImage(ctx context.Context) (string, error) {
 		return "", err
 	}
 	image := os.Expand(es.exts[es.mech2ext[mechname]].Image, client.GetEnv(ctx).Get)
-	if cfg.Cloud.SkipLogin {
+	if cfg.Cloud.SkipLogin || client.IsOffline(ctx) {
+		setting := "cloud.skipLogin"
+		if !cfg.Cloud.SkipLogin {
+			setting = "offline mode"
+		}
 		msg := fmt.Sprintf(
-			`images.agentImage must be set with cloud.skipLogin in
-%s for intercepts of mechanism: %s`, client.GetConfigFile(ctx), mechname)
+			`images.agentImage must be set with %s in
+%s for intercepts of mechanism: %s`, setting, client.GetConfigFile(ctx), mechname)
 		err := errcat.Config.New(msg)
 		return "", err
 	}
@@ -19,13 +19,14 @@ import (
 
 type legacyCommand struct {
 	swapDeployment string
-	newDeployment  bool
+	swapContainer  string
+	newDeployment  string
 	method         bool
-	expose         string
+	expose         []string
 	run            bool
 	dockerRun      bool
 	runShell       bool
-	processCmd     string
+	processCmd     []string
 	mount          string
 	dockerMount    string
 	envFile        string
@@ -69,13 +70,16 @@ Parsing:
 	for i, v := range args {
 		switch {
 		case v == "--swap-deployment" || v == "-s":
-			lc.swapDeployment = getArg(i + 1)
+			// Legacy Telepresence used <deployment>[:<container>]
+			lc.swapDeployment, lc.swapContainer, _ = strings.Cut(getArg(i+1), ":")
 		case v == "--new-deployment" || v == "-n":
-			lc.newDeployment = true
+			lc.newDeployment = getArg(i + 1)
 		case v == "--method" || v == "-m":
 			lc.method = true
 		case v == "--expose":
-			lc.expose = getArg(i + 1)
+			if ex := getArg(i + 1); ex != "" {
+				lc.expose = append(lc.expose, ex)
+			}
 		case v == "--mount":
 			lc.mount = getArg(i + 1)
 		case v == "--docker-mount":
@@ -94,15 +98,11 @@ Parsing:
 		// correct *incorrect* tp1 commands here, but it could be improved
 		case v == "--run":
 			lc.run = true
-			if nxtArg := getArg(i + 1); nxtArg != "" {
-				lc.processCmd = strings.Join(args[i+1:], " ")
-			}
+			lc.processCmd = args[i+1:]
 			break Parsing
 		case v == "--docker-run":
 			lc.dockerRun = true
-			if nxtArg := getArg(i + 1); nxtArg != "" {
-				lc.processCmd = strings.Join(args[i+1:], " ")
-			}
+			lc.processCmd = args[i+1:]
 			break Parsing
 		case v == "--run-shell":
 			lc.runShell = true
@@ -122,17 +122,18 @@ Parsing:
 	return lc
 }
 
-// genTPCommand constructs a Telepresence command based on
+// genTPCommand constructs the arguments of a Telepresence command based on
 // the values that are set in the legacyCommand struct
-func (lc *legacyCommand) genTPCommand() (string, error) {
+func (lc *legacyCommand) genTPCommand() ([]string, error) {
 	var cmdSlice []string
 	switch {
 	// if swapDeployment isn't empty, then our translation is
 	// an intercept subcommand
 	case lc.swapDeployment != "":
 		cmdSlice = append(cmdSlice, "intercept", lc.swapDeployment)
-		if lc.expose != "" {
-			cmdSlice = append(cmdSlice, "--port", lc.expose)
+		if len(lc.expose) > 0 {
+			// An intercept has one port. The message from translateLegacyCmd explains what to do with the others.
+			cmdSlice = append(cmdSlice, "--port", lc.expose[0])
 		}
 
 		if lc.envFile != "" {
@@ -154,7 +155,7 @@ func (lc *legacyCommand) genTPCommand() (string, error) {
 		// This should be impossible based on how we currently parse commands.
 		// Just putting it here just in case the impossible happens.
 		if lc.run && lc.dockerRun {
-			return "", errcat.User.New("--run and --docker-run are mutually exclusive")
+			return nil, errcat.User.New("--run and --docker-run are mutually exclusive")
 		}
 
 		if lc.run {
@@ -171,38 +172,52 @@ func (lc *legacyCommand) genTPCommand() (string, error) {
 		}
 		cmdSlice = append(cmdSlice, lc.globalFlags...)
 
-		if lc.processCmd != "" {
-			cmdSlice = append(cmdSlice, "--", lc.processCmd)
+		if len(lc.processCmd) > 0 {
+			cmdSlice = append(cmdSlice, "--")
+			cmdSlice = append(cmdSlice, lc.processCmd...)
 		}
 
 		if lc.runShell {
 			cmdSlice = append(cmdSlice, "--", "bash")
 		}
 	// If we have a run of some kind without a swapDeployment, then
-	// we translate to a connect
+	// we translate to a connect. Legacy Telepresence then created a new
+	// deployment that forwarded the exposed ports to the local machine,
+	// which is what the exposed ports of the connect do.
 	case lc.runShell:
 		cmdSlice = append(cmdSlice, "connect")
+		cmdSlice = append(cmdSlice, lc.connectExpose()...)
 		cmdSlice = append(cmdSlice, lc.globalFlags...)
 		cmdSlice = append(cmdSlice, "--", "bash")
 	case lc.run:
 		cmdSlice = append(cmdSlice, "connect")
+		cmdSlice = append(cmdSlice, lc.connectExpose()...)
 		cmdSlice = append(cmdSlice, lc.globalFlags...)
-		cmdSlice = append(cmdSlice, "--", lc.processCmd)
+		cmdSlice = append(cmdSlice, "--")
+		cmdSlice = append(cmdSlice, lc.processCmd...)
 	// Either not a legacyCommand or we don't know how to translate it to Telepresence
 	default:
-		return "", nil
+		return nil, nil
 	}
+	return cmdSlice, nil
+}
 
-	return strings.Join(cmdSlice, " "), nil
+// connectExpose returns the --expose flag of a connect that makes the exposed ports reachable from the cluster.
+// Legacy Telepresence and the connect command both use <local port>[:<remote port>].
+func (lc *legacyCommand) connectExpose() []string {
+	if len(lc.expose) == 0 {
+		return nil
+	}
+	return []string{"--expose", strings.Join(lc.expose, ",")}
 }
 
 // translateLegacyCmd tries to detect if a legacy Telepresence command was used
 // and constructs a Telepresence command from that.
-func translateLegacyCmd(args []string) (string, string, *legacyCommand, error) {
+func translateLegacyCmd(args []string) ([]string, string, *legacyCommand, error) {
 	lc := parseLegacyCommand(args)
 	tpCmd, err := lc.genTPCommand()
 	if err != nil {
-		return "", "", lc, err
+		return nil, "", lc, err
 	}
 
 	// There are certain elements of the telepresence 1 cli that we either
@@ -216,9 +231,29 @@ func translateLegacyCmd(args []string) (string, string, *legacyCommand, error) {
 	if lc.method {
 		msg += "Telepresence doesn't have proxying methods. You can use --docker-run for container, otherwise it works similarly to vpn-tcp\n"
 	}
-
-	if lc.newDeployment {
-		msg += "This flag is ignored since Telepresence uses one traffic-manager deployed in the ambassador namespace.\n"
+	if lc.swapContainer != "" {
+		msg += fmt.Sprintf("The container %s is ignored since Telepresence finds the container to intercept using the --port.\n", lc.swapContainer)
+	}
+	switch {
+	case lc.swapDeployment != "":
+		if lc.newDeployment != "" {
+			msg += "--new-deployment is ignored, because --swap-deployment intercepts an existing workload.\n"
+		}
+	case len(lc.expose) > 0:
+		// A new deployment is what legacy Telepresence created by default when no deployment was swapped.
+		name := "the new deployment"
+		if lc.newDeployment != "" && !strings.HasPrefix(lc.newDeployment, "-") {
+			name = lc.newDeployment
+		}
+		msg += fmt.Sprintf("Telepresence doesn't create a deployment. The exposed ports are instead reachable from the cluster "+
+			"using the DNS name of the session, <user>.telepresence, rather than using %s. This requires a traffic-manager that "+
+			"is installed with the Helm value clientDNS.enabled=true.\n", name)
+	case lc.newDeployment != "":
+		msg += "--new-deployment is ignored, because Telepresence doesn't need a deployment to reach the cluster.\n"
+	}
+	if lc.swapDeployment != "" && len(lc.expose) > 1 {
+		msg += fmt.Sprintf("Telepresence intercepts one port at a time, so only --expose %s is used. For the other ports, use "+
+			"\"telepresence intercept <name> --workload %s --port <port>\" once for each port.\n", lc.expose[0], lc.swapDeployment)
 	}
 	return tpCmd, msg, lc, nil
 }
@@ -260,23 +295,22 @@ func checkLegacyCmd(cmd *cobra.Command, args []string) error {
 
 	// Generate output to user letting them know legacy Telepresence was used,
 	// what the Telepresence command is, and runs it.
-	if tpCmd != "" {
+	if len(tpCmd) > 0 {
 		fmt.Fprintf(cmd.OutOrStderr(), "Legacy Telepresence command used\n")
 
 		if msg != "" {
 			fmt.Fprintln(cmd.OutOrStderr(), msg)
 		}
 
-		fmt.Fprintf(cmd.OutOrStderr(), "Command roughly translates to the following in Telepresence:\ntelepresence %s\n", tpCmd)
+		fmt.Fprintf(cmd.OutOrStderr(), "Command roughly translates to the following in Telepresence:\ntelepresence %s\n", strings.Join(tpCmd, " "))
 		ctx := cmd.Context()
 		fmt.Fprintln(cmd.OutOrStderr(), "running...")
 		newCmd := Command(ctx)
-		newCmd.SetArgs(strings.Split(tpCmd, " "))
+		newCmd.SetArgs(tpCmd)
 		newCmd.SetOut(cmd.OutOrStderr())
 		newCmd.SetErr(cmd.OutOrStderr())
-		if err := newCmd.ExecuteContext(ctx); err != nil {
-			fmt.Fprintln(cmd.ErrOrStderr(), err)
-		}
+		// Scripts that use the legacy syntax rely on the exit status, so the error of the translated command is returned.
+		return newCmd.ExecuteContext(ctx)
 	}
 	return nil
 }
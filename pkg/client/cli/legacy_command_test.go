@@ -74,12 +74,42 @@ func Test_legacyCommands(t *testing.T) {
 			name:               "runShellNewDeployment",
 			inputLegacyCommand: "telepresence --new-deployment myserver --run-shell",
 			outputTP2Command:   "connect -- bash",
-			msg:                "This flag is ignored since Telepresence uses one traffic-manager deployed in the ambassador namespace.\n",
+			msg:                "--new-deployment is ignored, because Telepresence doesn't need a deployment to reach the cluster.\n",
 		},
 		{
-			name:               "runShellIgnoreExtraArgs",
+			name:               "newDeploymentExpose",
+			inputLegacyCommand: "telepresence --new-deployment myserver --expose 8080 --expose 9090:80 --run python3 -m http.server 8080",
+			outputTP2Command:   "connect --expose 8080,9090:80 -- python3 -m http.server 8080",
+			msg: "Telepresence doesn't create a deployment. The exposed ports are instead reachable from the cluster using the DNS " +
+				"name of the session, <user>.telepresence, rather than using myserver. This requires a traffic-manager that is " +
+				"installed with the Helm value clientDNS.enabled=true.\n",
+		},
+		{
+			name:               "runShellExpose",
 			inputLegacyCommand: "telepresence --expose 8080 --run-shell",
-			outputTP2Command:   "connect -- bash",
+			outputTP2Command:   "connect --expose 8080 -- bash",
+			msg: "Telepresence doesn't create a deployment. The exposed ports are instead reachable from the cluster using the DNS " +
+				"name of the session, <user>.telepresence, rather than using the new deployment. This requires a traffic-manager " +
+				"that is installed with the Helm value clientDNS.enabled=true.\n",
+		},
+		{
+			name:               "swapDeploymentNewDeployment",
+			inputLegacyCommand: "telepresence --swap-deployment myserver --new-deployment other --run-shell",
+			outputTP2Command:   "intercept myserver -- bash",
+			msg:                "--new-deployment is ignored, because --swap-deployment intercepts an existing workload.\n",
+		},
+		{
+			name:               "swapDeploymentContainer",
+			inputLegacyCommand: "telepresence --swap-deployment myserver:app --expose 9090 --run python3 -m http.server 9090",
+			outputTP2Command:   "intercept myserver --port 9090 -- python3 -m http.server 9090",
+			msg:                "The container app is ignored since Telepresence finds the container to intercept using the --port.\n",
+		},
+		{
+			name:               "swapDeploymentMultipleExpose",
+			inputLegacyCommand: "telepresence --swap-deployment myserver --expose 9090 --expose 9091:81 --run python3 -m http.server 9090",
+			outputTP2Command:   "intercept myserver --port 9090 -- python3 -m http.server 9090",
+			msg: "Telepresence intercepts one port at a time, so only --expose 9090 is used. For the other ports, use " +
+				"\"telepresence intercept <name> --workload myserver --port <port>\" once for each port.\n",
 		},
 	}
 
@@ -94,7 +124,7 @@ func Test_legacyCommands(t *testing.T) {
 				t.Fatal(err)
 			}
 			assert.Equal(t, tc.msg, msg)
-			assert.Equal(t, tc.outputTP2Command, genTPCmd)
+			assert.Equal(t, tc.outputTP2Command, strings.Join(genTPCmd, " "))
 		})
 	}
 }
@@ -0,0 +1,35 @@
+//go:build !windows
+// +build !windows
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+)
+
+func checkMountCapability(ctx context.Context) error {
+	// Use CombinedOutput to include stderr which has information about whether they
+	// need to upgrade to a newer version of macFUSE or not
+	cmd := proc.CommandContext(ctx, "sshfs", "-V")
+	cmd.DisableLogging = true
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		dlog.Errorf(ctx, "sshfs not installed: %v", err)
+		return errors.New("sshfs is not installed on your local machine")
+	}
+
+	// OSXFUSE changed to macFUSE, and we've noticed that older versions of OSXFUSE
+	// can cause browsers to hang + kernel crashes, so we add an error to prevent
+	// our users from running into this problem.
+	// OSXFUSE isn't included in the output of sshfs -V in versions of 4.0.0 so
+	// we check for that as a proxy for if they have the right version or not.
+	if bytes.Contains(out, []byte("OSXFUSE")) {
+		return errors.New(`macFUSE 4.0.5 or higher is required on your local machine`)
+	}
+	return nil
+}
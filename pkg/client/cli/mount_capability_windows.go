@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/windows/registry"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+)
+
+// winFspKey is the registry key where the WinFsp installer records its installation directory. WinFsp is a 32-bit
+// installer, so on a 64-bit Windows, the key is found below WOW6432Node.
+const winFspKey = `SOFTWARE\WOW6432Node\WinFsp`
+
+func checkMountCapability(ctx context.Context) error {
+	if err := checkWinFsp(); err != nil {
+		dlog.Errorf(ctx, "WinFsp not installed: %v", err)
+		return errors.New("the WinFsp file system driver is not installed on your local machine (see https://winfsp.dev)")
+	}
+	cmd := proc.CommandContext(ctx, "sshfs-win", "cmd", "-V")
+	cmd.DisableLogging = true
+	if _, err := cmd.CombinedOutput(); err != nil {
+		dlog.Errorf(ctx, "sshfs-win not installed: %v", err)
+		return errors.New("sshfs-win is not installed on your local machine")
+	}
+	return nil
+}
+
+// checkWinFsp returns an error unless the WinFsp driver is installed.
+func checkWinFsp() error {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, winFspKey, registry.QUERY_VALUE)
+	if err != nil {
+		k, err = registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\WinFsp`, registry.QUERY_VALUE)
+		if err != nil {
+			return err
+		}
+	}
+	defer k.Close()
+	dir, _, err := k.GetStringValue("InstallDir")
+	if err != nil {
+		return err
+	}
+	_, err = os.Stat(filepath.Join(dir, "bin"))
+	return err
+}
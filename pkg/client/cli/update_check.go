@@ -13,6 +13,7 @@ import (
 	"github.com/blang/semver"
 	"github.com/spf13/cobra"
 
+	"github.com/datawire/dlib/dlog"
 	"github.com/datawire/dlib/dtime"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
@@ -24,7 +25,10 @@ const cacheFilename = "update-checks.json"
 
 type UpdateChecker struct {
 	NextCheck map[string]time.Time `json:"next_check"`
-	url       string
+
+	// LatestVersion is the newer version that the background check found, keyed by URL.
+	LatestVersion map[string]string `json:"latest_version,omitempty"`
+	url           string
 }
 
 // newUpdateChecker returns a new update checker, possibly initialized from the users cache.
@@ -42,6 +46,11 @@ func NewUpdateChecker(ctx context.Context, url string) (*UpdateChecker, error) {
 	return ts, nil
 }
 
+// releaseURL returns the URL of the file that declares the latest version of the given release channel.
+func releaseURL(ctx context.Context, channel string) string {
+	return fmt.Sprintf("https://%s/download/tel2/%s/%s/%s.txt", client.GetConfig(ctx).Cloud.SystemaHost, runtime.GOOS, runtime.GOARCH, channel)
+}
+
 func updateCheckIfDue(cmd *cobra.Command, _ []string) error {
 	return updateCheck(cmd, false)
 }
@@ -57,8 +66,16 @@ func forcedUpdateCheck(cmd *cobra.Command, _ []string) error {
 //   cmd:         the command that provides Context and stout/stderr
 //   forcedCheck: if true, perform check regardless of if it's due or not
 func updateCheck(cmd *cobra.Command, forceCheck bool) error {
-	cloudCfg := client.GetConfig(cmd.Context()).Cloud
-	uc, err := NewUpdateChecker(cmd.Context(), fmt.Sprintf("https://%s/download/tel2/%s/%s/stable.txt", cloudCfg.SystemaHost, runtime.GOOS, runtime.GOARCH))
+	ctx := cmd.Context()
+	if client.IsOffline(ctx) {
+		return nil
+	}
+	cfg := client.GetConfig(ctx).Updates
+	if cfg.BackgroundCheck && !forceCheck {
+		// The user daemon checks for updates, and the status and connect commands show what it finds.
+		return nil
+	}
+	uc, err := NewUpdateChecker(ctx, releaseURL(ctx, cfg.UpdateChannel()))
 	if err != nil || !(forceCheck || uc.timeToCheck()) {
 		return err
 	}
@@ -70,13 +87,89 @@ func updateCheck(cmd *cobra.Command, forceCheck bool) error {
 		return uc.StoreNextCheck(cmd.Context(), time.Hour)
 	}
 	if update != nil {
-		fmt.Fprintf(cmd.OutOrStdout(),
-			"An update of %s from version %s to %s is available. Please visit https://www.getambassador.io/docs/telepresence/latest/install/upgrade/ for more info.\n",
-			binaryName, &ourVersion, update)
+		fmt.Fprintln(cmd.OutOrStdout(), updateMessage(&ourVersion, update))
 	}
 	return uc.StoreNextCheck(cmd.Context(), checkDuration)
 }
 
+func updateMessage(ourVersion, update *semver.Version) string {
+	return fmt.Sprintf("An update of %s from version %s to %s is available. "+
+		"Run \"telepresence upgrade self\" to install it, or visit https://www.getambassador.io/docs/telepresence/latest/install/upgrade/ for more info.",
+		binaryName, ourVersion, update)
+}
+
+// BackgroundUpdateCheck checks for updates when a check is due and the background check is enabled, until the
+// given context is cancelled. The latest version is stored in the user cache, where the status and connect
+// commands find it.
+func BackgroundUpdateCheck(ctx context.Context) error {
+	for {
+		if cfg := client.GetConfig(ctx).Updates; cfg.BackgroundCheck && !client.IsOffline(ctx) {
+			if err := backgroundUpdateCheck(ctx, releaseURL(ctx, cfg.UpdateChannel())); err != nil {
+				dlog.Errorf(ctx, "update check failed: %v", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Hour):
+		}
+	}
+}
+
+func backgroundUpdateCheck(ctx context.Context, url string) error {
+	uc, err := NewUpdateChecker(ctx, url)
+	if err != nil || !uc.timeToCheck() {
+		return err
+	}
+	ourVersion := client.Semver()
+	errOut := &strings.Builder{}
+	update, ok := uc.UpdateAvailable(&ourVersion, errOut)
+	if !ok {
+		if errOut.Len() > 0 {
+			dlog.Error(ctx, strings.TrimSpace(errOut.String()))
+		}
+		// Failed to read from remote server. Next attempt is due in an hour
+		return uc.StoreNextCheck(ctx, time.Hour)
+	}
+	if update != nil {
+		dlog.Infof(ctx, "An update of %s from version %s to %s is available", binaryName, &ourVersion, update)
+		if uc.LatestVersion == nil {
+			uc.LatestVersion = make(map[string]string)
+		}
+		uc.LatestVersion[uc.url] = update.String()
+	} else {
+		delete(uc.LatestVersion, uc.url)
+	}
+	return uc.StoreNextCheck(ctx, checkDuration)
+}
+
+// availableUpdate returns the update that the background check found, or nil if the background check isn't
+// enabled or didn't find an update.
+func availableUpdate(ctx context.Context) *semver.Version {
+	cfg := client.GetConfig(ctx).Updates
+	if !cfg.BackgroundCheck {
+		return nil
+	}
+	return cachedUpdate(ctx, releaseURL(ctx, cfg.UpdateChannel()))
+}
+
+// cachedUpdate returns the update that the background check found using the given URL, or nil if it didn't find one.
+func cachedUpdate(ctx context.Context, url string) *semver.Version {
+	uc, err := NewUpdateChecker(ctx, url)
+	if err != nil {
+		return nil
+	}
+	update, err := semver.Parse(uc.LatestVersion[uc.url])
+	if err != nil {
+		return nil
+	}
+	if ourVersion := client.Semver(); !ourVersion.LT(update) {
+		// We've been updated since the check
+		return nil
+	}
+	return &update
+}
+
 func (uc *UpdateChecker) StoreNextCheck(ctx context.Context, d time.Duration) error {
 	uc.NextCheck[uc.url] = dtime.Now().Add(d)
 	return cache.SaveToUserCache(ctx, uc, cacheFilename)
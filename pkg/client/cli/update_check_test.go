@@ -15,6 +15,7 @@ import (
 	"github.com/datawire/dlib/dhttp"
 	"github.com/datawire/dlib/dlog"
 	"github.com/datawire/dlib/dtime"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
 )
 
@@ -131,3 +132,66 @@ func Test_NewUpdateChecker(t *testing.T) {
 		t.Fatal(fmt.Sprintf("Expected updateAvailable() to return %s", lastestVer))
 	}
 }
+
+func Test_backgroundUpdateCheck(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+
+	// the server that delivers the latest version
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	latestVer := semver.MustParse("999.0.0")
+	httpSrvCfg := &dhttp.ServerConfig{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(latestVer.String()))
+		}),
+	}
+	httpSrvCh := make(chan error)
+	httpSrvCtx, httpSrvCancel := context.WithCancel(dcontext.WithSoftness(ctx))
+	go func() {
+		httpSrvCh <- httpSrvCfg.Serve(httpSrvCtx, l)
+		close(httpSrvCh)
+	}()
+	defer func() {
+		httpSrvCancel()
+		if err := <-httpSrvCh; err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// a fake user cache directory
+	ctx = filelocation.WithUserHomeDir(ctx, t.TempDir())
+
+	ft := dtime.NewFakeTime()
+	dtime.SetNow(ft.Now)
+
+	url := fmt.Sprintf("http://%s", l.Addr())
+	if v := cachedUpdate(ctx, url); v != nil {
+		t.Fatalf("Expected cachedUpdate() to return nil before the first check, got %s", v)
+	}
+	if err = backgroundUpdateCheck(ctx, url); err != nil {
+		t.Fatal(err)
+	}
+	if v := cachedUpdate(ctx, url); v == nil || !latestVer.EQ(*v) {
+		t.Fatalf("Expected cachedUpdate() to return %s", latestVer)
+	}
+
+	// The check isn't due, so the cached version remains
+	latestVer = client.Semver()
+	if err = backgroundUpdateCheck(ctx, url); err != nil {
+		t.Fatal(err)
+	}
+	if v := cachedUpdate(ctx, url); v == nil {
+		t.Fatal("Expected cachedUpdate() to return the cached version")
+	}
+
+	// A day later, the check finds no update and clears the cached version
+	ft.Step(checkDuration + 1)
+	if err = backgroundUpdateCheck(ctx, url); err != nil {
+		t.Fatal(err)
+	}
+	if v := cachedUpdate(ctx, url); v != nil {
+		t.Fatalf("Expected cachedUpdate() to return nil, got %s", v)
+	}
+}
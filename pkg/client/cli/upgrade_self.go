@@ -0,0 +1,316 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/spf13/cobra"
+
+	"github.com/datawire/dlib/dexec"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/shellquote"
+	"github.com/telepresenceio/telepresence/v2/pkg/version"
+)
+
+type upgradeSelfInfo struct {
+	channel string
+	version string
+}
+
+func upgradeSelfCommand() *cobra.Command {
+	ui := &upgradeSelfInfo{}
+	cmd := &cobra.Command{
+		Use:  "self [flags]",
+		Args: cobra.NoArgs,
+
+		Short: "Replace the telepresence binary with the latest release",
+		Long: `Replace the telepresence binary with the latest release of the update channel.
+
+The new binary is downloaded and verified before the daemons are quit and the binary is swapped. The
+verification checks the SHA-256 checksum of the binary against a manifest that is signed with the
+release signing key that this binary was built with. The manifest also declares the version, OS, and
+architecture of the binary, which must be the ones that were requested. The swap is done using the elevation command (sudo by default) when the binary isn't writable by the
+current user, e.g. because it's installed in /usr/local/bin. The daemons use the new binary the next
+time that they're started.`,
+		RunE: ui.run,
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&ui.channel, "channel", "",
+		fmt.Sprintf("The update channel, %q or %q. Defaults to the updates.channel of the config", client.UpdateChannelStable, client.UpdateChannelLatest))
+	flags.StringVar(&ui.version, "version", "", "Install this version instead of the latest release of the update channel")
+	return cmd
+}
+
+func (ui *upgradeSelfInfo) run(cmd *cobra.Command, _ []string) error {
+	if runtime.GOOS == "windows" {
+		return errcat.User.New("telepresence upgrade self is not supported on Windows, please use the installer")
+	}
+	ctx := cmd.Context()
+	if err := client.CheckOnline(ctx, "telepresence upgrade self"); err != nil {
+		return err
+	}
+	key, err := releaseSigningKey()
+	if err != nil {
+		return err
+	}
+	stdout := cmd.OutOrStdout()
+	version, err := ui.targetVersion(ctx, cmd.ErrOrStderr())
+	if err != nil || version == nil {
+		if err == nil {
+			fmt.Fprintf(stdout, "%s %s is up to date\n", binaryName, client.Version())
+		}
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to determine the location of the telepresence binary: %w", err)
+	}
+
+	fmt.Fprintf(stdout, "Downloading %s v%s...\n", binaryName, version)
+	tmp, elevate, err := downloadBinary(ctx, version, filepath.Dir(exe), key)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	// Quit the daemons so that none of them keep running the old binary. The root daemon is
+	// started from the same binary, so swapping it upgrades them all.
+	if err = cliutil.Disconnect(ctx, true, true); err != nil {
+		return err
+	}
+	if err = replaceBinary(ctx, cmd, tmp, exe, elevate); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%s was upgraded from %s to v%s\n", exe, client.Version(), version)
+	return nil
+}
+
+// targetVersion returns the version to upgrade to, or nil when the binary is up-to-date.
+func (ui *upgradeSelfInfo) targetVersion(ctx context.Context, errOut io.Writer) (*semver.Version, error) {
+	if ui.version != "" {
+		v, err := semver.Parse(strings.TrimPrefix(ui.version, "v"))
+		if err != nil {
+			return nil, errcat.User.Newf("invalid version %q: %w", ui.version, err)
+		}
+		return &v, nil
+	}
+	channel := ui.channel
+	if channel == "" {
+		channel = client.GetConfig(ctx).Updates.UpdateChannel()
+	} else if err := client.ValidateUpdateChannel(channel); err != nil {
+		return nil, errcat.User.New(err)
+	}
+	uc, err := NewUpdateChecker(ctx, releaseURL(ctx, channel))
+	if err != nil {
+		return nil, err
+	}
+	ourVersion := client.Semver()
+	update, ok := uc.UpdateAvailable(&ourVersion, errOut)
+	if !ok {
+		return nil, fmt.Errorf("unable to determine the latest version from %s", uc.url)
+	}
+	return update, nil
+}
+
+// releaseSigningKey returns the public key of the version.ReleaseSigningKey.
+func releaseSigningKey() (ed25519.PublicKey, error) {
+	if version.ReleaseSigningKey == "" {
+		return nil, errcat.User.New("this build of telepresence has no release signing key and can't verify a new release, please upgrade it " +
+			"the way that it was installed")
+	}
+	key, err := base64.StdEncoding.DecodeString(version.ReleaseSigningKey)
+	if err == nil && len(key) != ed25519.PublicKeySize {
+		err = fmt.Errorf("expected %d bytes, got %d", ed25519.PublicKeySize, len(key))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid release signing key: %w", err)
+	}
+	return key, nil
+}
+
+// downloadBinary downloads the given version of the binary, verifies it against its signed manifest, and verifies
+// that it runs. The binary is stored in the given directory, so that it can replace the current binary with a rename,
+// unless that directory isn't writable. The temporary directory is used instead in that case, and the replacement must
+// be done with elevated privileges.
+func downloadBinary(ctx context.Context, version *semver.Version, dir string, key ed25519.PublicKey) (tmp string, elevate bool, err error) {
+	f, err := os.CreateTemp(dir, "."+binaryName+"-*")
+	if errors.Is(err, fs.ErrPermission) {
+		elevate = true
+		f, err = os.CreateTemp("", binaryName+"-*")
+	}
+	if err != nil {
+		return "", false, err
+	}
+	tmp = f.Name()
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp)
+		}
+	}()
+
+	url := fmt.Sprintf("https://%s/download/tel2/%s/%s/%s/%s",
+		client.GetConfig(ctx).Cloud.SystemaHost, runtime.GOOS, runtime.GOARCH, version, binaryName)
+	sum, err := downloadSignedChecksum(ctx, url, key, version, runtime.GOOS, runtime.GOARCH)
+	if err != nil {
+		return "", false, err
+	}
+	h := sha256.New()
+	err = download(ctx, url, io.MultiWriter(f, h))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", false, err
+	}
+	if !bytes.Equal(h.Sum(nil), sum) {
+		return "", false, fmt.Errorf("the checksum of %s doesn't match its signed manifest", url)
+	}
+	if err = os.Chmod(tmp, 0o755); err != nil {
+		return "", false, err
+	}
+
+	// Don't swap in a binary that is broken or built for another platform
+	check := dexec.CommandContext(ctx, tmp, "help")
+	check.DisableLogging = true
+	if out, err := check.CombinedOutput(); err != nil {
+		return "", false, fmt.Errorf("the downloaded binary failed to run: %w\n%s", err, out)
+	}
+	return tmp, elevate, nil
+}
+
+// downloadSignedChecksum downloads the manifest of the given URL, and the signature of that manifest, and returns
+// the SHA-256 checksum that the manifest declares when the signature is made with the given key, and the manifest is
+// for the given version, OS, and architecture. The manifest is the "<url>.manifest" file, see verifyManifest, and the
+// signature is the base64 encoded ed25519 signature of that file in "<url>.manifest.sig".
+func downloadSignedChecksum(ctx context.Context, url string, key ed25519.PublicKey, v *semver.Version, goos, goarch string) ([]byte, error) {
+	var manifest, sigFile bytes.Buffer
+	if err := download(ctx, url+".manifest", &manifest); err != nil {
+		return nil, err
+	}
+	if err := download(ctx, url+".manifest.sig", &sigFile); err != nil {
+		return nil, err
+	}
+	return verifyManifest(key, manifest.Bytes(), sigFile.Bytes(), v, goos, goarch)
+}
+
+// verifyManifest verifies that sig is a signature of the manifest made with the given key, and that the manifest is
+// for the given version, OS, and architecture, and returns the checksum that it declares. The signature covers all
+// of them, so that a signed binary can't be passed off as another version, or as a binary for another platform. The
+// manifest has one "<key>: <value>" line for each of the keys version, os, arch, and sha256, e.g.
+//
+//	version: 2.14.0
+//	os: linux
+//	arch: amd64
+//	sha256: <hex encoded checksum>
+func verifyManifest(key ed25519.PublicKey, manifest, sig []byte, v *semver.Version, goos, goarch string) ([]byte, error) {
+	rawSig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest signature: %w", err)
+	}
+	if !ed25519.Verify(key, manifest, rawSig) {
+		return nil, errors.New("the manifest isn't signed with the release signing key")
+	}
+	fields := make(map[string]string)
+	for _, line := range strings.Split(string(manifest), "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		k, val, ok := strings.Cut(line, ":")
+		k = strings.TrimSpace(k)
+		if _, dup := fields[k]; !ok || dup {
+			return nil, fmt.Errorf("invalid manifest line %q", line)
+		}
+		fields[k] = strings.TrimSpace(val)
+	}
+	for _, k := range []string{"version", "os", "arch", "sha256"} {
+		if _, ok := fields[k]; !ok {
+			return nil, fmt.Errorf("the manifest has no %s", k)
+		}
+	}
+	mv, err := semver.Parse(strings.TrimPrefix(fields["version"], "v"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest version: %w", err)
+	}
+	if !mv.Equals(*v) {
+		return nil, fmt.Errorf("the manifest is for version %s, not %s", mv, v)
+	}
+	if fields["os"] != goos || fields["arch"] != goarch {
+		return nil, fmt.Errorf("the manifest is for %s/%s, not %s/%s", fields["os"], fields["arch"], goos, goarch)
+	}
+	sum, err := hex.DecodeString(fields["sha256"])
+	if err == nil && len(sum) != sha256.Size {
+		err = fmt.Errorf("expected %d bytes, got %d", sha256.Size, len(sum))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid checksum: %w", err)
+	}
+	return sum, nil
+}
+
+func download(ctx context.Context, url string, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to download %s: %s", url, resp.Status)
+	}
+	if _, err = io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("unable to download %s: %w", url, err)
+	}
+	return nil
+}
+
+// replaceBinary replaces the exe with the tmp binary. The rename makes the swap atomic, so a failure leaves the old
+// binary intact.
+func replaceBinary(ctx context.Context, cmd *cobra.Command, tmp, exe string, elevate bool) error {
+	if !elevate {
+		return os.Rename(tmp, exe)
+	}
+	ec := client.GetConfig(ctx).Daemons.ElevationCommand
+	if len(ec) == 0 {
+		ec = []string{"sudo"}
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Need root privileges to replace %s\n", exe)
+	staged := exe + ".upgrade"
+	for _, args := range [][]string{
+		{"install", "-m", "0755", tmp, staged},
+		{"mv", "-f", staged, exe},
+	} {
+		args = append(append([]string{}, ec...), args...)
+		ic := dexec.CommandContext(ctx, args[0], args[1:]...)
+		ic.DisableLogging = true
+		ic.Stdin = cmd.InOrStdin()
+		ic.Stdout = cmd.OutOrStdout()
+		ic.Stderr = cmd.ErrOrStderr()
+		if err := ic.Run(); err != nil {
+			return fmt.Errorf("%s: %w", shellquote.ShellString(args[0], args[1:]), err)
+		}
+	}
+	return nil
+}
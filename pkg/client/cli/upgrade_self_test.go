@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/version"
+)
+
+func Test_verifyManifest(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	sum := sha256.Sum256([]byte("binary"))
+	hexSum := hex.EncodeToString(sum[:])
+	manifest := func(lines ...string) []byte {
+		return []byte(strings.Join(lines, "\n") + "\n")
+	}
+	sign := func(data []byte) []byte {
+		return []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, data)) + "\n")
+	}
+	v := semver.MustParse("2.14.0")
+	good := manifest("version: 2.14.0", "os: linux", "arch: amd64", "sha256: "+hexSum)
+
+	got, err := verifyManifest(pub, good, sign(good), &v, "linux", "amd64")
+	require.NoError(t, err)
+	assert.Equal(t, sum[:], got)
+
+	_, err = verifyManifest(otherPub, good, sign(good), &v, "linux", "amd64")
+	assert.ErrorContains(t, err, "isn't signed")
+
+	tampered := manifest("version: 2.14.0", "os: linux", "arch: amd64", "sha256: "+hex.EncodeToString(make([]byte, sha256.Size)))
+	_, err = verifyManifest(pub, tampered, sign(good), &v, "linux", "amd64")
+	assert.ErrorContains(t, err, "isn't signed")
+
+	_, err = verifyManifest(pub, good, []byte("not base64!"), &v, "linux", "amd64")
+	assert.ErrorContains(t, err, "invalid manifest signature")
+
+	// A signed manifest of another version or platform is rejected
+	older := semver.MustParse("2.13.0")
+	_, err = verifyManifest(pub, good, sign(good), &older, "linux", "amd64")
+	assert.ErrorContains(t, err, "is for version 2.14.0, not 2.13.0")
+	_, err = verifyManifest(pub, good, sign(good), &v, "darwin", "amd64")
+	assert.ErrorContains(t, err, "is for linux/amd64, not darwin/amd64")
+	_, err = verifyManifest(pub, good, sign(good), &v, "linux", "arm64")
+	assert.ErrorContains(t, err, "is for linux/amd64, not linux/arm64")
+
+	tests := []struct {
+		name     string
+		manifest []byte
+		err      string
+	}{
+		{"no version", manifest("os: linux", "arch: amd64", "sha256: "+hexSum), "has no version"},
+		{"no os", manifest("version: 2.14.0", "arch: amd64", "sha256: "+hexSum), "has no os"},
+		{"no arch", manifest("version: 2.14.0", "os: linux", "sha256: "+hexSum), "has no arch"},
+		{"no checksum", manifest("version: 2.14.0", "os: linux", "arch: amd64"), "has no sha256"},
+		{"duplicate", manifest("version: 2.14.0", "version: 2.13.0", "os: linux", "arch: amd64", "sha256: "+hexSum), "invalid manifest line"},
+		{"sha256sum format", []byte(hexSum + "  telepresence\n"), "invalid manifest line"},
+		{"bad version", manifest("version: two", "os: linux", "arch: amd64", "sha256: "+hexSum), "invalid manifest version"},
+		{"short checksum", manifest("version: 2.14.0", "os: linux", "arch: amd64", "sha256: abcd"), "invalid checksum"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifyManifest(pub, tt.manifest, sign(tt.manifest), &v, "linux", "amd64")
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func Test_releaseSigningKey(t *testing.T) {
+	defer func(k string) { version.ReleaseSigningKey = k }(version.ReleaseSigningKey)
+
+	version.ReleaseSigningKey = ""
+	_, err := releaseSigningKey()
+	assert.ErrorContains(t, err, "no release signing key")
+
+	version.ReleaseSigningKey = base64.StdEncoding.EncodeToString([]byte("short"))
+	_, err = releaseSigningKey()
+	assert.ErrorContains(t, err, "invalid release signing key")
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	version.ReleaseSigningKey = base64.StdEncoding.EncodeToString(pub)
+	key, err := releaseSigningKey()
+	require.NoError(t, err)
+	assert.Equal(t, pub, key)
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"os"
+	"net"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -13,6 +13,7 @@ import (
 	"github.com/datawire/dlib/dcontext"
 	"github.com/telepresenceio/telepresence/rpc/v2/connector"
 	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 )
@@ -61,19 +62,6 @@ func withConnector(cmd *cobra.Command, retain bool, request *connector.ConnectRe
 	})
 }
 
-func addKubeconfigEnv(cr *connector.ConnectRequest) {
-	// Certain options' default are bound to the connector daemon process; this is notably true of the kubeconfig file(s) to use,
-	// and since those files can be specified, both as a --kubeconfig flag and in the KUBECONFIG setting, and since the flag won't
-	// accept multiple path entries, we need to pass the environment setting to the connector daemon so that it can set it every
-	// time it receives a new config.
-	if cfg, ok := os.LookupEnv("KUBECONFIG"); ok {
-		if cr.KubeFlags == nil {
-			cr.KubeFlags = make(map[string]string)
-		}
-		cr.KubeFlags["KUBECONFIG"] = cfg
-	}
-}
-
 func connect(ctx context.Context, connectorClient connector.ConnectorClient, stdout io.Writer, request *connector.ConnectRequest) (bool, *connector.ConnectInfo, error) {
 	var ci *connector.ConnectInfo
 	var err error
@@ -81,7 +69,7 @@ func connect(ctx context.Context, connectorClient connector.ConnectorClient, std
 		// implicit calls use the current Status instead of passing flags and mapped namespaces.
 		ci, err = connectorClient.Status(ctx, &empty.Empty{})
 	} else {
-		addKubeconfigEnv(request)
+		cliutil.AddKubeconfigEnv(request)
 		ci, err = connectorClient.Connect(ctx, request)
 	}
 	if err != nil {
@@ -93,6 +81,22 @@ func connect(ctx context.Context, connectorClient connector.ConnectorClient, std
 	switch ci.Error {
 	case connector.ConnectInfo_UNSPECIFIED:
 		fmt.Fprintf(stdout, "Connected to context %s (%s)\n", ci.ClusterContext, ci.ClusterServer)
+		if len(ci.DockerDnsIp) > 0 {
+			fmt.Fprintf(stdout, "Docker containers can reach the cluster, use --dns %s to resolve its names\n", net.IP(ci.DockerDnsIp))
+		}
+		if ci.NetNamespace != "" {
+			fmt.Fprintf(stdout, "Only network namespace %s can reach the cluster, use \"telepresence connect -- <command>\" to run commands in it\n", ci.NetNamespace)
+		}
+		if dnsName := ci.GetSessionInfo().GetDnsName(); dnsName != "" {
+			fmt.Fprintf(stdout, "The exposed ports are reachable from the cluster as %s\n", dnsName)
+		}
+		for _, w := range ci.ManagerWarnings {
+			fmt.Fprintf(stdout, "Warning: %s\n", w)
+		}
+		if update := availableUpdate(ctx); update != nil {
+			ourVersion := client.Semver()
+			fmt.Fprintln(stdout, updateMessage(&ourVersion, update))
+		}
 		return true, ci, nil
 	case connector.ConnectInfo_ALREADY_CONNECTED:
 		return false, ci, nil
@@ -104,7 +108,7 @@ func connect(ctx context.Context, connectorClient connector.ConnectorClient, std
 		// prior call to connect. So we make it explicit here without flags
 		return connect(ctx, connectorClient, stdout, &connector.ConnectRequest{})
 	case connector.ConnectInfo_MUST_RESTART:
-		msg = "Cluster configuration changed, please quit telepresence and reconnect"
+		msg = "Cluster configuration changed, please use \"telepresence connect --switch-context\" or quit telepresence and reconnect"
 	case connector.ConnectInfo_TRAFFIC_MANAGER_FAILED, connector.ConnectInfo_CLUSTER_FAILED, connector.ConnectInfo_DAEMON_FAILED:
 		msg = ci.ErrorText
 		if ci.ErrorCategory != 0 {
@@ -5,22 +5,29 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 	"unsafe"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc"
 	"gopkg.in/yaml.v3"
 	"k8s.io/apimachinery/pkg/api/resource"
 
 	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/dnet"
 	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
 	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
 )
 
 const configFile = "config.yml"
@@ -35,6 +42,14 @@ type Config struct {
 	TelepresenceAPI TelepresenceAPI `json:"telepresenceAPI,omitempty" yaml:"telepresenceAPI,omitempty"`
 	Daemons         Daemons         `json:"daemons,omitempty" yaml:"daemons,omitempty"`
 	Intercept       Intercept       `json:"intercept,omitempty" yaml:"intercept,omitempty"`
+	Tunnel          Tunnel          `json:"tunnel,omitempty" yaml:"tunnel,omitempty"`
+	DNS             DNS             `json:"dns,omitempty" yaml:"dns,omitempty"`
+	Updates         Updates         `json:"updates,omitempty" yaml:"updates,omitempty"`
+	Telemetry       Telemetry       `json:"telemetry,omitempty" yaml:"telemetry,omitempty"`
+	KubeAPI         KubeAPI         `json:"kubeAPI,omitempty" yaml:"kubeAPI,omitempty"`
+
+	// Offline prevents all network calls other than those to the cluster and the traffic-manager.
+	Offline bool `json:"offline,omitempty" yaml:"offline,omitempty"`
 }
 
 // Merge merges this instance with the non-zero values of the given argument. The argument values take priority.
@@ -47,6 +62,14 @@ func (c *Config) Merge(o *Config) {
 	c.TelepresenceAPI.merge(&o.TelepresenceAPI)
 	c.Daemons.merge(&o.Daemons)
 	c.Intercept.merge(&o.Intercept)
+	c.Tunnel.merge(&o.Tunnel)
+	c.DNS.merge(&o.DNS)
+	c.Updates.merge(&o.Updates)
+	c.Telemetry.merge(&o.Telemetry)
+	c.KubeAPI.merge(&o.KubeAPI)
+	if o.Offline {
+		c.Offline = o.Offline
+	}
 }
 
 // Watch uses a file system watcher that receives events when the configuration changes
@@ -129,6 +152,18 @@ func (c *Config) UnmarshalYAML(node *yaml.Node) error {
 			err = ms[i+1].Decode(&c.Daemons)
 		case kv == "intercept":
 			err = ms[i+1].Decode(&c.Intercept)
+		case kv == "tunnel":
+			err = ms[i+1].Decode(&c.Tunnel)
+		case kv == "dns":
+			err = ms[i+1].Decode(&c.DNS)
+		case kv == "updates":
+			err = ms[i+1].Decode(&c.Updates)
+		case kv == "telemetry":
+			err = ms[i+1].Decode(&c.Telemetry)
+		case kv == "kubeAPI":
+			err = ms[i+1].Decode(&c.KubeAPI)
+		case kv == "offline":
+			err = ms[i+1].Decode(&c.Offline)
 		case parseContext != nil:
 			dlog.Warn(parseContext, withLoc(fmt.Sprintf("unknown key %q", kv), ms[i]))
 		}
@@ -523,6 +558,13 @@ type Images struct {
 	PrivateRegistry        string `json:"registry,omitempty" yaml:"registry,omitempty"`
 	PrivateAgentImage      string `json:"agentImage,omitempty" yaml:"agentImage,omitempty"`
 	PrivateWebhookRegistry string `json:"webhookRegistry,omitempty" yaml:"webhookRegistry,omitempty"`
+
+	// PrivateManagerImage is the name of the traffic-manager image in the Registry, optionally followed by a
+	// tag or a digest, e.g. "tel2@sha256:...".
+	PrivateManagerImage string `json:"managerImage,omitempty" yaml:"managerImage,omitempty"`
+
+	// PullSecrets are the names of the secrets used when pulling the traffic-manager and traffic-agent images.
+	PullSecrets []string `json:"pullSecrets,omitempty" yaml:"pullSecrets,omitempty"`
 }
 
 // UnmarshalYAML parses the images YAML
@@ -546,6 +588,12 @@ func (img *Images) UnmarshalYAML(node *yaml.Node) (err error) {
 			img.PrivateAgentImage = v.Value
 		case "webhookRegistry":
 			img.PrivateWebhookRegistry = v.Value
+		case "managerImage":
+			img.PrivateManagerImage = v.Value
+		case "pullSecrets":
+			if err := v.Decode(&img.PullSecrets); err != nil {
+				return errors.New(withLoc("pullSecrets must be a list of secret names", v))
+			}
 		case "webhookAgentImage":
 			dlog.Warn(parseContext, withLoc(fmt.Sprintf(`deprecated key %q, please use "agentImage" instead`, kv), ms[i]))
 			img.PrivateAgentImage = v.Value
@@ -568,6 +616,12 @@ func (img *Images) merge(o *Images) {
 	if o.PrivateWebhookRegistry != "" {
 		img.PrivateWebhookRegistry = o.PrivateWebhookRegistry
 	}
+	if o.PrivateManagerImage != "" {
+		img.PrivateManagerImage = o.PrivateManagerImage
+	}
+	if len(o.PullSecrets) > 0 {
+		img.PullSecrets = o.PullSecrets
+	}
 }
 
 func (img *Images) Registry(c context.Context) string {
@@ -693,12 +747,85 @@ type Grpc struct {
 	// MaxReceiveSize is the maximum message size in bytes the client can receive in a gRPC call or stream message.
 	// Overrides the gRPC default of 4MB.
 	MaxReceiveSize resource.Quantity `json:"maxReceiveSize,omitempty" yaml:"maxReceiveSize,omitempty"`
+
+	// InitialWindowSize is the initial HTTP/2 flow-control window of each gRPC stream. Each connection that is
+	// tunneled to or from the cluster uses its own stream. Setting it disables gRPC's dynamic window estimation.
+	InitialWindowSize resource.Quantity `json:"initialWindowSize,omitempty" yaml:"initialWindowSize,omitempty"`
+
+	// InitialConnWindowSize is the initial HTTP/2 flow-control window of each gRPC connection. All tunneled streams
+	// share this window, so it must be large when many connections are active at the same time.
+	InitialConnWindowSize resource.Quantity `json:"initialConnWindowSize,omitempty" yaml:"initialConnWindowSize,omitempty"`
+
+	// KeepAliveInterval is the time of inactivity after which the client pings the traffic-manager connection.
+	// The pings prevent middleboxes from dropping long-lived connections that they consider idle. Zero means
+	// no pings.
+	KeepAliveInterval time.Duration `json:"keepAliveInterval,omitempty" yaml:"keepAliveInterval,omitempty"`
+
+	// KeepAliveTimeout is the time that the client waits for a ping to be acknowledged before it considers the
+	// connection dead. Zero means that the KeepAliveInterval is used.
+	KeepAliveTimeout time.Duration `json:"keepAliveTimeout,omitempty" yaml:"keepAliveTimeout,omitempty"`
+
+	// TCPUserTimeout is the maximum time that data sent to the traffic-manager may remain unacknowledged before
+	// the connection is closed. Only used on Linux, and only when the traffic-manager is dialed directly.
+	TCPUserTimeout time.Duration `json:"tcpUserTimeout,omitempty" yaml:"tcpUserTimeout,omitempty"`
 }
 
 func (g *Grpc) merge(o *Grpc) {
 	if !o.MaxReceiveSize.IsZero() {
 		g.MaxReceiveSize = o.MaxReceiveSize
 	}
+	if !o.InitialWindowSize.IsZero() {
+		g.InitialWindowSize = o.InitialWindowSize
+	}
+	if !o.InitialConnWindowSize.IsZero() {
+		g.InitialConnWindowSize = o.InitialConnWindowSize
+	}
+	if o.KeepAliveInterval != 0 {
+		g.KeepAliveInterval = o.KeepAliveInterval
+	}
+	if o.KeepAliveTimeout != 0 {
+		g.KeepAliveTimeout = o.KeepAliveTimeout
+	}
+	if o.TCPUserTimeout != 0 {
+		g.TCPUserTimeout = o.TCPUserTimeout
+	}
+}
+
+// windowSize returns the given quantity as an int32 suitable for the gRPC window size options, or zero
+// when the quantity is zero or out of range.
+func windowSize(q *resource.Quantity) int32 {
+	if v, ok := q.AsInt64(); ok && v > 0 && v <= math.MaxInt32 {
+		return int32(v)
+	}
+	return 0
+}
+
+// KeepAliveDialOptions returns the dial options that apply the configured keep-alive interval and timeout.
+func (g *Grpc) KeepAliveDialOptions() []grpc.DialOption {
+	return dnet.KeepAliveDialOptions(g.KeepAliveInterval, g.KeepAliveTimeout)
+}
+
+// WindowDialOptions returns the dial options that apply the configured flow-control window sizes.
+func (g *Grpc) WindowDialOptions() []grpc.DialOption {
+	var opts []grpc.DialOption
+	if ws := windowSize(&g.InitialWindowSize); ws > 0 {
+		opts = append(opts, grpc.WithInitialWindowSize(ws))
+	}
+	if ws := windowSize(&g.InitialConnWindowSize); ws > 0 {
+		opts = append(opts, grpc.WithInitialConnWindowSize(ws))
+	}
+	return opts
+}
+
+// HTTP2Server returns the HTTP/2 server configuration that applies the configured flow-control window sizes
+// to a gRPC server that is served by a dhttp.ServerConfig, or nil when no window sizes are configured.
+func (g *Grpc) HTTP2Server() *http2.Server {
+	ws := windowSize(&g.InitialWindowSize)
+	cws := windowSize(&g.InitialConnWindowSize)
+	if ws == 0 && cws == 0 {
+		return nil
+	}
+	return &http2.Server{MaxUploadBufferPerStream: ws, MaxUploadBufferPerConnection: cws}
 }
 
 // UnmarshalYAML parses the images YAML
@@ -716,12 +843,33 @@ func (g *Grpc) UnmarshalYAML(node *yaml.Node) (err error) {
 		}
 		v := ms[i+1]
 		switch kv {
-		case "maxReceiveSize":
+		case "maxReceiveSize", "initialWindowSize", "initialConnWindowSize":
 			val, err := resource.ParseQuantity(v.Value)
 			if err != nil {
 				dlog.Warningf(parseContext, "unable to parse quantity %q: %v", v.Value, withLoc(err.Error(), ms[i]))
-			} else {
+				continue
+			}
+			switch kv {
+			case "maxReceiveSize":
 				g.MaxReceiveSize = val
+			case "initialWindowSize":
+				g.InitialWindowSize = val
+			default:
+				g.InitialConnWindowSize = val
+			}
+		case "keepAliveInterval", "keepAliveTimeout", "tcpUserTimeout":
+			val, err := time.ParseDuration(v.Value)
+			if err != nil {
+				dlog.Warningf(parseContext, "unable to parse duration %q: %v", v.Value, withLoc(err.Error(), ms[i]))
+				continue
+			}
+			switch kv {
+			case "keepAliveInterval":
+				g.KeepAliveInterval = val
+			case "keepAliveTimeout":
+				g.KeepAliveTimeout = val
+			default:
+				g.TCPUserTimeout = val
 			}
 		default:
 			if parseContext != nil {
@@ -738,6 +886,21 @@ func (g Grpc) MarshalYAML() (any, error) {
 	if !g.MaxReceiveSize.IsZero() {
 		cm["maxReceiveSize"] = g.MaxReceiveSize.String()
 	}
+	if !g.InitialWindowSize.IsZero() {
+		cm["initialWindowSize"] = g.InitialWindowSize.String()
+	}
+	if !g.InitialConnWindowSize.IsZero() {
+		cm["initialConnWindowSize"] = g.InitialConnWindowSize.String()
+	}
+	if g.KeepAliveInterval != 0 {
+		cm["keepAliveInterval"] = g.KeepAliveInterval.String()
+	}
+	if g.KeepAliveTimeout != 0 {
+		cm["keepAliveTimeout"] = g.KeepAliveTimeout.String()
+	}
+	if g.TCPUserTimeout != 0 {
+		cm["tcpUserTimeout"] = g.TCPUserTimeout.String()
+	}
 	return cm, nil
 }
 
@@ -753,12 +916,71 @@ func (g *TelepresenceAPI) merge(o *TelepresenceAPI) {
 
 type Daemons struct {
 	UserDaemonBinary string `json:"userDaemonBinary,omitempty" yaml:"userDaemonBinary,omitempty"`
+
+	// RootDaemonProfilingPort is the localhost port where the root daemon serves pprof endpoints. Zero means disabled.
+	RootDaemonProfilingPort uint16 `json:"rootDaemonProfilingPort,omitempty" yaml:"rootDaemonProfilingPort,omitempty"`
+
+	// UserDaemonProfilingPort is the localhost port where the user daemon serves pprof endpoints. Zero means disabled.
+	UserDaemonProfilingPort uint16 `json:"userDaemonProfilingPort,omitempty" yaml:"userDaemonProfilingPort,omitempty"`
+
+	// UserDaemonTCPAddress is a TCP address that the user daemon listens to in addition to its unix socket
+	// or named pipe. Clients that connect using TCP must present the token that the daemon generates when it
+	// starts. Empty means disabled.
+	UserDaemonTCPAddress string `json:"userDaemonTCPAddress,omitempty" yaml:"userDaemonTCPAddress,omitempty"`
+
+	// UserDaemonTLSCert and UserDaemonTLSKey are the PEM files of the certificate and key that the user daemon
+	// uses for TLS on its UserDaemonTCPAddress.
+	UserDaemonTLSCert string `json:"userDaemonTLSCert,omitempty" yaml:"userDaemonTLSCert,omitempty"`
+	UserDaemonTLSKey  string `json:"userDaemonTLSKey,omitempty" yaml:"userDaemonTLSKey,omitempty"`
+
+	// UserDaemonTLSClientCA is a PEM file with the CAs used to verify the certificates of clients that connect
+	// to the UserDaemonTCPAddress. Clients with a verified certificate don't need to present the token.
+	UserDaemonTLSClientCA string `json:"userDaemonTLSClientCA,omitempty" yaml:"userDaemonTLSClientCA,omitempty"`
+
+	// ElevationCommand is the command, with arguments, that is prepended to the root daemon command line in order
+	// to run it with elevated privileges. It replaces the default use of sudo (or UAC on Windows) when set.
+	ElevationCommand []string `json:"elevationCommand,omitempty" yaml:"elevationCommand,omitempty"`
+
+	// ElevationPrompt is the message that is printed before the user is asked for permission to elevate privileges.
+	ElevationPrompt string `json:"elevationPrompt,omitempty" yaml:"elevationPrompt,omitempty"`
+
+	// WSLWindowsBinary is the Windows executable, e.g. telepresence.exe, whose daemons are used by a CLI that runs in
+	// a WSL2 distribution. The CLI then relays its calls to the daemons of the Windows host instead of starting its
+	// own. Empty means disabled. It's ignored outside of WSL2.
+	WSLWindowsBinary string `json:"wslWindowsBinary,omitempty" yaml:"wslWindowsBinary,omitempty"`
 }
 
 func (d *Daemons) merge(o *Daemons) {
 	if o.UserDaemonBinary != "" {
 		d.UserDaemonBinary = o.UserDaemonBinary
 	}
+	if o.RootDaemonProfilingPort != 0 {
+		d.RootDaemonProfilingPort = o.RootDaemonProfilingPort
+	}
+	if o.UserDaemonProfilingPort != 0 {
+		d.UserDaemonProfilingPort = o.UserDaemonProfilingPort
+	}
+	if o.UserDaemonTCPAddress != "" {
+		d.UserDaemonTCPAddress = o.UserDaemonTCPAddress
+	}
+	if o.UserDaemonTLSCert != "" {
+		d.UserDaemonTLSCert = o.UserDaemonTLSCert
+	}
+	if o.UserDaemonTLSKey != "" {
+		d.UserDaemonTLSKey = o.UserDaemonTLSKey
+	}
+	if o.UserDaemonTLSClientCA != "" {
+		d.UserDaemonTLSClientCA = o.UserDaemonTLSClientCA
+	}
+	if len(o.ElevationCommand) > 0 {
+		d.ElevationCommand = o.ElevationCommand
+	}
+	if o.ElevationPrompt != "" {
+		d.ElevationPrompt = o.ElevationPrompt
+	}
+	if o.WSLWindowsBinary != "" {
+		d.WSLWindowsBinary = o.WSLWindowsBinary
+	}
 }
 
 const defaultInterceptDefaultPort = 8080
@@ -798,6 +1020,367 @@ func (ic Intercept) MarshalYAML() (any, error) {
 	return im, nil
 }
 
+type Tunnel struct {
+	// Compression is the compression that is requested for traffic that is tunneled to and from the cluster.
+	// Streams that carry TLS or otherwise incompressible traffic will stop compressing automatically.
+	Compression tunnel.Compression `json:"compression,omitempty" yaml:"compression,omitempty"`
+
+	// MTU is the MTU of the TUN device. A zero value means that the MTU is lowered from the default of 1500
+	// when the network interface that the cluster's API server is routed through has a smaller MTU.
+	MTU int `json:"mtu,omitempty" yaml:"mtu,omitempty"`
+
+	// PoolSize is the number of tunnel streams to the traffic-manager that are opened in advance, so that new
+	// connections don't have to wait for them. A negative value disables the pool.
+	PoolSize int `json:"poolSize,omitempty" yaml:"poolSize,omitempty"`
+
+	// MaxConnections is the maximum number of TCP connections that the root daemon tunnels concurrently. New
+	// connections are held back until the number drops below the limit. A negative value means no limit.
+	MaxConnections int `json:"maxConnections,omitempty" yaml:"maxConnections,omitempty"`
+
+	// TableSize is the maximum number of entries, TCP connections and UDP flows, in the connection-tracking table
+	// of the root daemon. Packets that would need a new entry are dropped when it's full. A negative value means
+	// no limit.
+	TableSize int `json:"tableSize,omitempty" yaml:"tableSize,omitempty"`
+
+	// TCPIdleTimeout is the time after which a TCP connection without traffic is closed. Zero means never.
+	TCPIdleTimeout time.Duration `json:"tcpIdleTimeout,omitempty" yaml:"tcpIdleTimeout,omitempty"`
+
+	// UDPIdleTimeout is the time after which a UDP flow without outbound traffic is evicted from the table.
+	UDPIdleTimeout time.Duration `json:"udpIdleTimeout,omitempty" yaml:"udpIdleTimeout,omitempty"`
+
+	// MuxStreams is the number of gRPC streams that the tunneled connections are multiplexed over. Zero means that
+	// each connection uses a gRPC stream of its own.
+	MuxStreams int `json:"muxStreams,omitempty" yaml:"muxStreams,omitempty"`
+
+	// MuxWindowSize is the number of bytes that each multiplexed connection may have in flight in each direction.
+	// Zero means the default of 256KiB.
+	MuxWindowSize int `json:"muxWindowSize,omitempty" yaml:"muxWindowSize,omitempty"`
+}
+
+const (
+	defaultTunnelPoolSize       = 2
+	defaultTunnelMaxConnections = 2048
+	defaultTunnelTableSize      = 4096
+	defaultTunnelUDPIdleTimeout = 5 * time.Second
+)
+
+var defaultTunnel = Tunnel{
+	PoolSize:       defaultTunnelPoolSize,
+	MaxConnections: defaultTunnelMaxConnections,
+	TableSize:      defaultTunnelTableSize,
+	UDPIdleTimeout: defaultTunnelUDPIdleTimeout,
+}
+
+// IsZero controls whether this element will be included in marshalled output
+func (t Tunnel) IsZero() bool {
+	return t == defaultTunnel
+}
+
+// MarshalYAML is not using pointer receiver here, because Tunnel is not pointer in the Config struct
+func (t Tunnel) MarshalYAML() (any, error) {
+	tm := make(map[string]any)
+	if t.Compression != tunnel.NoCompression {
+		tm["compression"] = t.Compression
+	}
+	if t.MTU != 0 {
+		tm["mtu"] = t.MTU
+	}
+	if t.PoolSize != 0 && t.PoolSize != defaultTunnelPoolSize {
+		tm["poolSize"] = t.PoolSize
+	}
+	if t.MaxConnections != 0 && t.MaxConnections != defaultTunnelMaxConnections {
+		tm["maxConnections"] = t.MaxConnections
+	}
+	if t.TableSize != 0 && t.TableSize != defaultTunnelTableSize {
+		tm["tableSize"] = t.TableSize
+	}
+	if t.TCPIdleTimeout != 0 {
+		tm["tcpIdleTimeout"] = t.TCPIdleTimeout.String()
+	}
+	if t.UDPIdleTimeout != 0 && t.UDPIdleTimeout != defaultTunnelUDPIdleTimeout {
+		tm["udpIdleTimeout"] = t.UDPIdleTimeout.String()
+	}
+	if t.MuxStreams != 0 {
+		tm["muxStreams"] = t.MuxStreams
+	}
+	if t.MuxWindowSize != 0 {
+		tm["muxWindowSize"] = t.MuxWindowSize
+	}
+	return tm, nil
+}
+
+// minTunnelMTU is the smallest MTU that all IPv4 hosts must accept, see RFC 791.
+const minTunnelMTU = 576
+
+func (t *Tunnel) merge(o *Tunnel) {
+	if o.Compression != tunnel.NoCompression {
+		t.Compression = o.Compression
+	}
+	if o.MTU != 0 {
+		t.MTU = o.MTU
+	}
+	if o.PoolSize != 0 {
+		t.PoolSize = o.PoolSize
+	}
+	if o.MaxConnections != 0 {
+		t.MaxConnections = o.MaxConnections
+	}
+	if o.TableSize != 0 {
+		t.TableSize = o.TableSize
+	}
+	if o.TCPIdleTimeout != 0 {
+		t.TCPIdleTimeout = o.TCPIdleTimeout
+	}
+	if o.UDPIdleTimeout != 0 {
+		t.UDPIdleTimeout = o.UDPIdleTimeout
+	}
+	if o.MuxStreams != 0 {
+		t.MuxStreams = o.MuxStreams
+	}
+	if o.MuxWindowSize != 0 {
+		t.MuxWindowSize = o.MuxWindowSize
+	}
+}
+
+func (t *Tunnel) UnmarshalYAML(node *yaml.Node) error {
+	type plain Tunnel
+	if err := node.Decode((*plain)(t)); err != nil {
+		return err
+	}
+	if t.MTU != 0 && (t.MTU < minTunnelMTU || t.MTU > 0xffff) {
+		return errors.New(withLoc(fmt.Sprintf("tunnel mtu must be between %d and %d", minTunnelMTU, 0xffff), node))
+	}
+	if t.TCPIdleTimeout < 0 || t.UDPIdleTimeout < 0 {
+		return errors.New(withLoc("tunnel idle timeouts cannot be negative", node))
+	}
+	if t.MuxStreams < 0 || t.MuxWindowSize < 0 {
+		return errors.New(withLoc("tunnel muxStreams and muxWindowSize cannot be negative", node))
+	}
+	return nil
+}
+
+type DNS struct {
+	// LocalPort is the port that the local DNS server of the root daemon listens to. A zero value
+	// means that a random port is used.
+	LocalPort uint16 `json:"localPort,omitempty" yaml:"localPort,omitempty"`
+
+	// Resolver controls how the local DNS server is integrated with the DNS resolver of the host.
+	Resolver DNSResolver `json:"resolver,omitempty" yaml:"resolver,omitempty"`
+
+	// Overrides maps names to the IP addresses that the local DNS server resolves them to without asking the
+	// cluster. A name that starts with "*." is a wildcard that matches all names in that domain.
+	Overrides map[string]string `json:"overrides,omitempty" yaml:"overrides,omitempty"`
+}
+
+func (d *DNS) merge(o *DNS) {
+	if o.LocalPort != 0 {
+		d.LocalPort = o.LocalPort
+	}
+	if o.Resolver != DNSResolverAuto {
+		d.Resolver = o.Resolver
+	}
+	if len(o.Overrides) > 0 {
+		d.Overrides = o.Overrides
+	}
+}
+
+// UnmarshalYAML parses the dns YAML and validates the overrides
+func (d *DNS) UnmarshalYAML(node *yaml.Node) error {
+	type plain DNS
+	if err := node.Decode((*plain)(d)); err != nil {
+		return err
+	}
+	for name, ip := range d.Overrides {
+		if n := strings.TrimPrefix(name, "*."); n == "" || strings.ContainsAny(n, "* ") {
+			return errors.New(withLoc(fmt.Sprintf("invalid dns override name %q", name), node))
+		}
+		if net.ParseIP(ip) == nil {
+			return errors.New(withLoc(fmt.Sprintf("invalid IP address %q for dns override %q", ip, name), node))
+		}
+	}
+	return nil
+}
+
+// UpdateChannelStable and UpdateChannelLatest are the release channels that updates can be checked against. The
+// stable channel is used by default.
+const (
+	UpdateChannelStable = "stable"
+	UpdateChannelLatest = "latest"
+)
+
+type Updates struct {
+	// Channel is the release channel that updates are checked against and installed from.
+	Channel string `json:"channel,omitempty" yaml:"channel,omitempty"`
+
+	// BackgroundCheck makes the user daemon check for updates periodically, and the status and connect
+	// commands show the update that it found, instead of having other commands check when a check is due.
+	BackgroundCheck bool `json:"backgroundCheck,omitempty" yaml:"backgroundCheck,omitempty"`
+}
+
+func (u *Updates) merge(o *Updates) {
+	if o.Channel != "" {
+		u.Channel = o.Channel
+	}
+	if o.BackgroundCheck {
+		u.BackgroundCheck = o.BackgroundCheck
+	}
+}
+
+// UpdateChannel returns the configured release channel, or the stable channel when none is configured.
+func (u *Updates) UpdateChannel() string {
+	if u.Channel == "" {
+		return UpdateChannelStable
+	}
+	return u.Channel
+}
+
+// UnmarshalYAML parses the updates YAML and validates the channel
+func (u *Updates) UnmarshalYAML(node *yaml.Node) error {
+	type plain Updates
+	if err := node.Decode((*plain)(u)); err != nil {
+		return err
+	}
+	if err := ValidateUpdateChannel(u.Channel); err != nil {
+		return errors.New(withLoc(err.Error(), node))
+	}
+	return nil
+}
+
+// ValidateUpdateChannel returns an error unless the given channel is empty or a known release channel.
+func ValidateUpdateChannel(channel string) error {
+	switch channel {
+	case "", UpdateChannelStable, UpdateChannelLatest:
+		return nil
+	default:
+		return fmt.Errorf("invalid update channel %q, must be %q or %q", channel, UpdateChannelStable, UpdateChannelLatest)
+	}
+}
+
+type Telemetry struct {
+	// Disabled turns off the reporting of anonymous usage data.
+	Disabled bool `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+
+	// ReportEndpoint is the URL that usage data is reported to. The Metriton default is used when it's empty.
+	ReportEndpoint string `json:"reportEndpoint,omitempty" yaml:"reportEndpoint,omitempty"`
+
+	// Proxy is the URL of the HTTP proxy that usage data is reported through. The proxy declared by the
+	// HTTPS_PROXY environment variable is used when it's empty.
+	Proxy string `json:"proxy,omitempty" yaml:"proxy,omitempty"`
+}
+
+func (t *Telemetry) merge(o *Telemetry) {
+	if o.Disabled {
+		t.Disabled = o.Disabled
+	}
+	if o.ReportEndpoint != "" {
+		t.ReportEndpoint = o.ReportEndpoint
+	}
+	if o.Proxy != "" {
+		t.Proxy = o.Proxy
+	}
+}
+
+// UnmarshalYAML parses the telemetry YAML. The scalars "disabled" and "enabled" are accepted as a shorthand for
+// the disabled flag.
+func (t *Telemetry) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		switch node.Value {
+		case "disabled":
+			t.Disabled = true
+		case "enabled":
+			t.Disabled = false
+		default:
+			return errors.New(withLoc(fmt.Sprintf("invalid telemetry %q, must be \"enabled\", \"disabled\", or an object", node.Value), node))
+		}
+		return nil
+	}
+	type plain Telemetry
+	if err := node.Decode((*plain)(t)); err != nil {
+		return err
+	}
+	for _, u := range []string{t.ReportEndpoint, t.Proxy} {
+		if u == "" {
+			continue
+		}
+		if _, err := url.Parse(u); err != nil {
+			return errors.New(withLoc(fmt.Sprintf("invalid telemetry URL %q: %v", u, err), node))
+		}
+	}
+	return nil
+}
+
+// KubeAPI controls the rate of the requests that the user daemon makes to the Kubernetes API server.
+type KubeAPI struct {
+	// QPS is the maximum number of requests per second. The client-go default is used when it's zero.
+	QPS float32 `json:"qps,omitempty" yaml:"qps,omitempty"`
+
+	// Burst is the maximum number of requests that can exceed the QPS momentarily. The client-go default is
+	// used when it's zero.
+	Burst int `json:"burst,omitempty" yaml:"burst,omitempty"`
+
+	// Adaptive makes the user daemon lower its rate when the API server responds with 429 Too Many Requests,
+	// and gradually raise it again to the QPS when the API server accepts the requests.
+	Adaptive bool `json:"adaptive,omitempty" yaml:"adaptive,omitempty"`
+}
+
+func (k *KubeAPI) merge(o *KubeAPI) {
+	if o.QPS != 0 {
+		k.QPS = o.QPS
+	}
+	if o.Burst != 0 {
+		k.Burst = o.Burst
+	}
+	if o.Adaptive {
+		k.Adaptive = o.Adaptive
+	}
+}
+
+// UnmarshalYAML parses the kubeAPI YAML and validates the rates
+func (k *KubeAPI) UnmarshalYAML(node *yaml.Node) error {
+	type plain KubeAPI
+	if err := node.Decode((*plain)(k)); err != nil {
+		return err
+	}
+	if k.QPS < 0 || k.Burst < 0 {
+		return errors.New(withLoc("kubeAPI qps and burst cannot be negative", node))
+	}
+	return nil
+}
+
+type offlineKey struct{}
+
+// WithOffline returns a context that is in offline mode regardless of the offline setting of its config.
+func WithOffline(ctx context.Context) context.Context {
+	return context.WithValue(ctx, offlineKey{}, true)
+}
+
+// IsOffline returns true when no network calls other than those to the cluster and the traffic-manager may be
+// made, i.e. no calls to Ambassador Cloud, the release servers, or the telemetry endpoint.
+func IsOffline(ctx context.Context) bool {
+	if offline, ok := ctx.Value(offlineKey{}).(bool); ok && offline {
+		return true
+	}
+	cfg := GetConfig(ctx)
+	return cfg != nil && cfg.Offline
+}
+
+// CheckOnline returns an error describing what isn't available when the given context is in offline mode.
+func CheckOnline(ctx context.Context, what string) error {
+	if IsOffline(ctx) {
+		return errcat.User.Newf("%s is not available in offline mode", what)
+	}
+	return nil
+}
+
+// TelemetryEnabled returns true when usage data may be reported.
+func TelemetryEnabled(ctx context.Context) bool {
+	if IsOffline(ctx) {
+		return false
+	}
+	cfg := GetConfig(ctx)
+	return cfg == nil || !cfg.Telemetry.Disabled
+}
+
 var parseContext context.Context
 
 type parsedFile struct{}
@@ -869,6 +1452,12 @@ func GetDefaultConfig() Config {
 		Intercept: Intercept{
 			DefaultPort: defaultInterceptDefaultPort,
 		},
+		Tunnel: Tunnel{
+			PoolSize:       defaultTunnelPoolSize,
+			MaxConnections: defaultTunnelMaxConnections,
+			TableSize:      defaultTunnelTableSize,
+			UDPIdleTimeout: defaultTunnelUDPIdleTimeout,
+		},
 	}
 }
 
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"reflect"
+	"strings"
+)
+
+// ConfigChanges describes the differences between two configurations in terms of the top-level keys of the
+// config file.
+type ConfigChanges struct {
+	// Changed are the keys of all changed sections.
+	Changed []string
+
+	// RequireReconnect are the keys of the changed sections that are only read when a session is established.
+	RequireReconnect []string
+
+	// RequireRestart are the keys of the changed sections that are only read when the daemons start.
+	RequireRestart []string
+}
+
+// configSectionsRequiringReconnect are the sections that configure the gRPC connections and the tunnel of a
+// session. All other sections, except those in configSectionsRequiringRestart, are read each time they are used,
+// or applied by the daemons when they reload the config, so they take effect immediately.
+var configSectionsRequiringReconnect = map[string]bool{
+	"grpc":   true,
+	"tunnel": true,
+}
+
+// configKeysRequiringReconnect are the keys of the sections that are otherwise reloadable, which are only read when
+// a session is established. The DNS overrides are applied to the DNS server of a running session, but the DNS server
+// can't move to another port or resolver integration.
+var configKeysRequiringReconnect = map[string]bool{
+	"dns.localPort": true,
+	"dns.resolver":  true,
+}
+
+// configSectionsRequiringRestart are the sections that configure how the daemons are started and what they
+// listen to.
+var configSectionsRequiringRestart = map[string]bool{
+	"daemons": true,
+	"offline": true,
+}
+
+// DiffConfig returns the changes between the old and the new configuration.
+func DiffConfig(oldCfg, newCfg *Config) ConfigChanges {
+	var cc ConfigChanges
+	ov := reflect.ValueOf(oldCfg).Elem()
+	nv := reflect.ValueOf(newCfg).Elem()
+	changedFields(ov, nv, func(key string, of, nf reflect.Value) {
+		cc.Changed = append(cc.Changed, key)
+		switch {
+		case configSectionsRequiringReconnect[key]:
+			cc.RequireReconnect = append(cc.RequireReconnect, key)
+		case configSectionsRequiringRestart[key]:
+			cc.RequireRestart = append(cc.RequireRestart, key)
+		case of.Kind() == reflect.Struct:
+			changedFields(of, nf, func(subKey string, _, _ reflect.Value) {
+				if subKey = key + "." + subKey; configKeysRequiringReconnect[subKey] {
+					cc.RequireReconnect = append(cc.RequireReconnect, subKey)
+				}
+			})
+		}
+	})
+	return cc
+}
+
+// changedFields calls changed with the yaml key and the values of each field that differs between the given structs.
+func changedFields(ov, nv reflect.Value, changed func(key string, of, nf reflect.Value)) {
+	ct := ov.Type()
+	for i := 0; i < ct.NumField(); i++ {
+		if reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+		key := ct.Field(i).Tag.Get("yaml")
+		if ix := strings.IndexByte(key, ','); ix >= 0 {
+			key = key[:ix]
+		}
+		changed(key, ov.Field(i), nv.Field(i))
+	}
+}
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -15,6 +16,7 @@ import (
 	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
 	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
 )
 
 func TestGetConfig(t *testing.T) {
@@ -31,6 +33,7 @@ timeouts:
   apply: 33s
 logLevels:
   userDaemon: debug
+telemetry: disabled
 `,
 		/* user */ `
 timeouts:
@@ -41,11 +44,41 @@ logLevels:
 images:
   registry: testregistry.io
   agentImage: ambassador-telepresence-agent-image:0.0.2
+  managerImage: tel2@sha256:0123456789abcdef
+  pullSecrets: [regcred]
 telepresenceAPI:
   port: 1234
 intercept:
   appProtocolStrategy: portName
   defaultPort: 9080
+daemons:
+  rootDaemonProfilingPort: 6060
+  elevationCommand: [doas, -n]
+  userDaemonTCPAddress: localhost:9985
+  wslWindowsBinary: telepresence.exe
+grpc:
+  initialWindowSize: 1Mi
+tunnel:
+  compression: zstd
+  mtu: 1380
+  udpIdleTimeout: 30s
+dns:
+  localPort: 5353
+  resolver: overriding
+  overrides:
+    "*.test": 127.0.0.1
+    legacy-db: 10.1.2.3
+updates:
+  channel: latest
+  backgroundCheck: true
+telemetry:
+  reportEndpoint: https://metrics.example.com/scout
+  proxy: http://proxy.example.com:3128
+offline: true
+kubeAPI:
+  qps: 20
+  burst: 40
+  adaptive: true
 `,
 	}
 
@@ -81,9 +114,33 @@ intercept:
 
 	assert.Equal(t, "testregistry.io", cfg.Images.PrivateRegistry)                             // from user
 	assert.Equal(t, "ambassador-telepresence-agent-image:0.0.2", cfg.Images.PrivateAgentImage) // from user
+	assert.Equal(t, "tel2@sha256:0123456789abcdef", cfg.Images.PrivateManagerImage)            // from user
+	assert.Equal(t, []string{"regcred"}, cfg.Images.PullSecrets)                               // from user
 	assert.Equal(t, 1234, cfg.TelepresenceAPI.Port)                                            // from user
 	assert.Equal(t, k8sapi.PortName, cfg.Intercept.AppProtocolStrategy)                        // from user
 	assert.Equal(t, 9080, cfg.Intercept.DefaultPort)                                           // from user
+	assert.Equal(t, uint16(6060), cfg.Daemons.RootDaemonProfilingPort)                         // from user
+	assert.Equal(t, []string{"doas", "-n"}, cfg.Daemons.ElevationCommand)                      // from user
+	assert.Equal(t, "localhost:9985", cfg.Daemons.UserDaemonTCPAddress)                        // from user
+	assert.Equal(t, "telepresence.exe", cfg.Daemons.WSLWindowsBinary)                          // from user
+	assert.Equal(t, resource.MustParse("1Mi"), cfg.Grpc.InitialWindowSize)                     // from user
+	assert.Equal(t, tunnel.ZstdCompression, cfg.Tunnel.Compression)                            // from user
+	assert.Equal(t, 1380, cfg.Tunnel.MTU)                                                      // from user
+	assert.Equal(t, 30*time.Second, cfg.Tunnel.UDPIdleTimeout)                                 // from user
+	assert.Equal(t, 4096, cfg.Tunnel.TableSize)                                                // default
+	assert.Equal(t, uint16(5353), cfg.DNS.LocalPort)                                           // from user
+	assert.Equal(t, DNSResolverOverriding, cfg.DNS.Resolver)                                   // from user
+	assert.Equal(t, "127.0.0.1", cfg.DNS.Overrides["*.test"])                                  // from user
+	assert.Equal(t, "10.1.2.3", cfg.DNS.Overrides["legacy-db"])                                // from user
+	assert.Equal(t, UpdateChannelLatest, cfg.Updates.UpdateChannel())                          // from user
+	assert.True(t, cfg.Updates.BackgroundCheck)                                                // from user
+	assert.True(t, cfg.Telemetry.Disabled)                                                     // from sys2
+	assert.Equal(t, "https://metrics.example.com/scout", cfg.Telemetry.ReportEndpoint)         // from user
+	assert.Equal(t, "http://proxy.example.com:3128", cfg.Telemetry.Proxy)                      // from user
+	assert.True(t, cfg.Offline)                                                                // from user
+	assert.Equal(t, KubeAPI{QPS: 20, Burst: 40, Adaptive: true}, cfg.KubeAPI)                  // from user
+	assert.True(t, IsOffline(c))
+	assert.False(t, TelemetryEnabled(c))
 }
 
 func Test_ConfigMarshalYAML(t *testing.T) {
@@ -93,13 +150,33 @@ func Test_ConfigMarshalYAML(t *testing.T) {
 	ctx = WithEnv(ctx, env)
 	cfg := GetDefaultConfig()
 	cfg.Images.PrivateAgentImage = "something:else"
+	cfg.Images.PullSecrets = []string{"regcred"}
 	cfg.Timeouts.PrivateTrafficManagerAPI = defaultTimeoutsTrafficManagerAPI + 20*time.Second
 	cfg.Cloud.RefreshMessages += 10 * time.Minute
 	cfg.LogLevels.UserDaemon = logrus.TraceLevel
 	cfg.Grpc.MaxReceiveSize, _ = resource.ParseQuantity("20Mi")
+	cfg.Grpc.InitialConnWindowSize, _ = resource.ParseQuantity("16Mi")
+	cfg.Grpc.KeepAliveInterval = 30 * time.Second
+	cfg.Grpc.TCPUserTimeout = 20 * time.Second
 	cfg.TelepresenceAPI.Port = 4567
 	cfg.Intercept.AppProtocolStrategy = k8sapi.PortName
 	cfg.Intercept.DefaultPort = 9080
+	cfg.Daemons.UserDaemonProfilingPort = 6061
+	cfg.Daemons.ElevationPrompt = "Telepresence needs to configure the network"
+	cfg.Tunnel.Compression = tunnel.S2Compression
+	cfg.Tunnel.MTU = 1400
+	cfg.Tunnel.TableSize = 10000
+	cfg.Tunnel.TCPIdleTimeout = 2 * time.Hour
+	cfg.Tunnel.MuxStreams = 4
+	cfg.DNS.LocalPort = 5353
+	cfg.DNS.Resolver = DNSResolverNRPT
+	cfg.DNS.Overrides = map[string]string{"*.test": "127.0.0.1"}
+	cfg.Updates.Channel = UpdateChannelLatest
+	cfg.Updates.BackgroundCheck = true
+	cfg.Telemetry.Disabled = true
+	cfg.Telemetry.ReportEndpoint = "https://metrics.example.com/scout"
+	cfg.Offline = true
+	cfg.KubeAPI = KubeAPI{QPS: 12.5, Burst: 30, Adaptive: true}
 	cfgBytes, err := yaml.Marshal(cfg)
 	require.NoError(t, err)
 
@@ -119,3 +196,88 @@ func Test_ConfigMarshalYAMLDefaults(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "{}\n", string(cfgBytes))
 }
+
+func TestGrpc_HTTP2Server(t *testing.T) {
+	g := Grpc{}
+	assert.Nil(t, g.HTTP2Server())
+	assert.Empty(t, g.WindowDialOptions())
+
+	g.InitialConnWindowSize = resource.MustParse("16Mi")
+	s := g.HTTP2Server()
+	require.NotNil(t, s)
+	assert.Equal(t, int32(0), s.MaxUploadBufferPerStream)
+	assert.Equal(t, int32(16<<20), s.MaxUploadBufferPerConnection)
+	assert.Len(t, g.WindowDialOptions(), 1)
+
+	// A window that doesn't fit in an int32 leaves the default in place
+	g.InitialWindowSize = resource.MustParse("4Gi")
+	assert.Equal(t, int32(0), g.HTTP2Server().MaxUploadBufferPerStream)
+	assert.Len(t, g.WindowDialOptions(), 1)
+}
+
+func TestUpdateConfig(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	tmp := t.TempDir()
+	sys := t.TempDir()
+	ctx = filelocation.WithAppUserConfigDir(ctx, tmp)
+	ctx = filelocation.WithAppSystemConfigDirs(ctx, []string{sys})
+	orig := []byte("timeouts:\n  clusterConnect: 25s\n")
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, configFile), orig, 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(sys, configFile), []byte("logLevels:\n  userDaemon: trace\n"), 0600))
+
+	require.NoError(t, UpdateConfig(ctx, func(cfg *Config) error {
+		assert.Equal(t, 25*time.Second, cfg.Timeouts.PrivateClusterConnect)
+		assert.NotEqual(t, logrus.TraceLevel, cfg.LogLevels.UserDaemon, "the system config is included")
+		cfg.Daemons.UserDaemonBinary = "/usr/local/bin/telepresence-pro"
+		return nil
+	}))
+
+	cfg, err := LoadConfig(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 25*time.Second, cfg.Timeouts.PrivateClusterConnect)
+	assert.Equal(t, "/usr/local/bin/telepresence-pro", cfg.Daemons.UserDaemonBinary)
+	assert.Equal(t, logrus.TraceLevel, cfg.LogLevels.UserDaemon)
+
+	// Only the user's config is written to the file
+	bs, err := os.ReadFile(filepath.Join(tmp, configFile))
+	require.NoError(t, err)
+	assert.Equal(t, "timeouts:\n    clusterConnect: 25s\ndaemons:\n    userDaemonBinary: /usr/local/bin/telepresence-pro\n", string(bs))
+
+	bak, err := os.ReadFile(filepath.Join(tmp, configFile+".bak"))
+	require.NoError(t, err)
+	assert.Equal(t, orig, bak)
+
+	// A failing update leaves the file untouched
+	require.Error(t, UpdateConfig(ctx, func(cfg *Config) error {
+		cfg.Daemons.UserDaemonBinary = ""
+		return errors.New("boom")
+	}))
+	cfg, err = LoadConfig(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "/usr/local/bin/telepresence-pro", cfg.Daemons.UserDaemonBinary)
+
+	// No temporary files are left behind
+	tmps, err := filepath.Glob(filepath.Join(tmp, "*.tmp"))
+	require.NoError(t, err)
+	assert.Empty(t, tmps)
+}
+
+func TestDiffConfig(t *testing.T) {
+	oldCfg := GetDefaultConfig()
+	newCfg := GetDefaultConfig()
+	assert.Empty(t, DiffConfig(&oldCfg, &newCfg).Changed)
+
+	newCfg.LogLevels.UserDaemon = logrus.DebugLevel
+	newCfg.Timeouts.PrivateHelm = time.Minute
+	newCfg.Tunnel.MTU = 1380
+	newCfg.DNS.Overrides = map[string]string{"*.test": "127.0.0.1"}
+	newCfg.Daemons.RootDaemonProfilingPort = 6060
+	cc := DiffConfig(&oldCfg, &newCfg)
+	assert.Equal(t, []string{"timeouts", "logLevels", "daemons", "tunnel", "dns"}, cc.Changed)
+	assert.Equal(t, []string{"tunnel"}, cc.RequireReconnect, "the DNS overrides are applied to a running session")
+	assert.Equal(t, []string{"daemons"}, cc.RequireRestart)
+
+	newCfg.DNS.LocalPort = 5353
+	cc = DiffConfig(&oldCfg, &newCfg)
+	assert.Equal(t, []string{"tunnel", "dns.localPort"}, cc.RequireReconnect)
+}
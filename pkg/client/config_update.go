@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// UpdateConfig performs a read-modify-write of the user's config file. The given function is called with the
+// configuration that the file declares, without the defaults and the system wide configs, and only that is written
+// back. Values that are equal to their defaults are omitted from the written file.
+//
+// The update holds an advisory lock on a lock file next to the config file so that concurrent updates by the
+// CLI and the daemons don't overwrite each other's changes, and the new content is written to a temporary file
+// that is then renamed, so that a daemon that reloads the config never sees a partially written file. The daemons
+// watch the directory of the config file, so they pick up the change without a restart.
+//
+// The previous content of the file is saved with a ".bak" suffix.
+func UpdateConfig(c context.Context, update func(*Config) error) error {
+	cfgFile := GetConfigFile(c)
+	dir := filepath.Dir(cfgFile)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	unlock, err := lockFile(cfgFile + ".lock")
+	if err != nil {
+		return fmt.Errorf("unable to lock %s: %w", cfgFile, err)
+	}
+	defer unlock()
+
+	cfg := Config{}
+	bs, err := os.ReadFile(cfgFile)
+	switch {
+	case err == nil:
+		parseContext = context.WithValue(c, parsedFile{}, cfgFile)
+		err = yaml.Unmarshal(bs, &cfg)
+		parseContext = nil
+		if err != nil {
+			return fmt.Errorf("unable to parse %s: %w", cfgFile, err)
+		}
+	case os.IsNotExist(err):
+		bs = nil
+	default:
+		return err
+	}
+
+	if err = update(&cfg); err != nil {
+		return err
+	}
+	nbs, err := marshalUserConfig(&cfg)
+	if err != nil {
+		return fmt.Errorf("unable to marshal the updated config: %w", err)
+	}
+	if len(bs) > 0 {
+		if err = os.WriteFile(cfgFile+".bak", bs, 0o600); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(cfgFile, nbs, 0o644)
+}
+
+// marshalUserConfig marshals the given config, omitting the sections that it doesn't declare. The sections of a
+// config that wasn't merged with the defaults are only omitted by the marshaller when they're equal to the defaults,
+// so the empty mappings of the other sections are removed here.
+func marshalUserConfig(cfg *Config) ([]byte, error) {
+	var doc yaml.Node
+	if err := doc.Encode(cfg); err != nil {
+		return nil, err
+	}
+	if doc.Kind == yaml.MappingNode {
+		var content []*yaml.Node
+		for i := 0; i+1 < len(doc.Content); i += 2 {
+			if v := doc.Content[i+1]; v.Kind == yaml.MappingNode && len(v.Content) == 0 {
+				continue
+			}
+			content = append(content, doc.Content[i], doc.Content[i+1])
+		}
+		doc.Content = content
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	return yaml.Marshal(&doc)
+}
+
+// writeFileAtomic writes the given data to a temporary file in the directory of the named file, and then renames
+// the temporary file to the named file.
+func writeFileAtomic(name string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := f.Name()
+	defer func() {
+		// No-op unless the rename failed or was never attempted
+		_ = os.Remove(tmpName)
+	}()
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmpName, perm)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpName, name)
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DNSResolver specifies how the local DNS server of the root daemon is integrated with the DNS
+// resolver of the host.
+type DNSResolver int
+
+var dnsResolverNames = [...]string{"auto", "systemd-resolved", "overriding", "resolver-files", "nrpt", "interface"}
+
+const (
+	// DNSResolverAuto means use the best integration that is available on the platform (this is the default behavior)
+	DNSResolverAuto DNSResolver = iota
+
+	// DNSResolverSystemdResolved means configure per-link DNS using systemd-resolved (Linux only)
+	DNSResolverSystemdResolved
+
+	// DNSResolverOverriding means take over all DNS requests using a local server that forwards the requests that
+	// it can't resolve to the original DNS server (Linux only)
+	DNSResolverOverriding
+
+	// DNSResolverResolverFiles means create scoped files under /etc/resolver (macOS only)
+	DNSResolverResolverFiles
+
+	// DNSResolverNRPT means add Name Resolution Policy Table rules for the cluster domains (Windows only)
+	DNSResolverNRPT
+
+	// DNSResolverInterface means set the DNS server and search list of the TUN-device interface (Windows only)
+	DNSResolverInterface
+)
+
+func (r DNSResolver) String() string {
+	if r < 0 || int(r) >= len(dnsResolverNames) {
+		return fmt.Sprintf("** unknown DNS resolver: %d **", int(r))
+	}
+	return dnsResolverNames[r]
+}
+
+func NewDNSResolver(s string) (DNSResolver, error) {
+	if s == "" {
+		return DNSResolverAuto, nil
+	}
+	for i, n := range dnsResolverNames {
+		if s == n {
+			return DNSResolver(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid DNS resolver: %q", s)
+}
+
+func (r DNSResolver) MarshalYAML() (any, error) {
+	return r.String(), nil
+}
+
+func (r *DNSResolver) UnmarshalYAML(node *yaml.Node) error {
+	var s string
+	if err := node.Decode(&s); err != nil {
+		return err
+	}
+	v, err := NewDNSResolver(s)
+	if err != nil {
+		return err
+	}
+	*r = v
+	return nil
+}
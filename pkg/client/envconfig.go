@@ -26,6 +26,27 @@ type Env struct {
 	// This environment variable becomes the default for the images.agentImage and images.webhookAgentImage
 	AgentImage string `env:"TELEPRESENCE_AGENT_IMAGE,default="`
 
+	// UserDaemonAddress is the TCP address of a user daemon that the CLI connects to instead of using
+	// the user daemon's unix socket or named pipe. The CLI will not start a user daemon when it's set.
+	UserDaemonAddress string `env:"TELEPRESENCE_USER_DAEMON_ADDRESS,default="`
+
+	// UserDaemonToken is the token presented to the user daemon at UserDaemonAddress. The token that the
+	// user daemon stored in the user cache is used when it's empty.
+	UserDaemonToken string `env:"TELEPRESENCE_USER_DAEMON_TOKEN,default="`
+
+	// UserDaemonCA is a PEM file with the CAs used to verify the certificate of the user daemon at
+	// UserDaemonAddress. TLS is used when this, or UserDaemonCert, is set.
+	UserDaemonCA string `env:"TELEPRESENCE_USER_DAEMON_CA,default="`
+
+	// UserDaemonCert and UserDaemonKey are the PEM files of the client certificate and key presented to the
+	// user daemon at UserDaemonAddress.
+	UserDaemonCert string `env:"TELEPRESENCE_USER_DAEMON_CERT,default="`
+	UserDaemonKey  string `env:"TELEPRESENCE_USER_DAEMON_KEY,default="`
+
+	// IdentityToken is the token that the user daemon presents to a traffic-manager that verifies the identities of
+	// its clients, e.g. an OIDC ID token. The bearer token of the kubeconfig is presented when it's empty.
+	IdentityToken string `env:"TELEPRESENCE_IDENTITY_TOKEN,default="`
+
 	lookuper envconfig.Lookuper
 }
 
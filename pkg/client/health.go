@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// HealthServer is a grpc_health_v1.HealthServer that makes it possible for external supervisors, like launchd,
+// systemd, or an IDE plugin, to detect a daemon that is wedged and restart it. The daemon is reported as serving
+// until its context is cancelled, or until a call to the probe function has been blocked for longer than the time
+// that it takes to connect to the cluster and the traffic-manager.
+//
+// A Check never blocks. It starts a new call to the probe unless one is already in progress.
+type HealthServer struct {
+	grpc_health_v1.UnimplementedHealthServer
+	ctx      context.Context
+	probe    func()
+	services []string
+	timeout  time.Duration
+
+	// probeStart is the start time in unix nanoseconds of the probe that is in progress, or zero.
+	probeStart int64
+}
+
+// healthWatchInterval is the interval between the checks that a Watch performs.
+const healthWatchInterval = 5 * time.Second
+
+// NewHealthServer returns a HealthServer that calls the given probe. The probe should acquire and release the locks
+// that the daemon needs to serve requests. Besides the "" that represents the overall health of the daemon, the
+// health of each of the given grpc services can be checked.
+func NewHealthServer(ctx context.Context, probe func(), services ...string) *HealthServer {
+	to := &GetConfig(ctx).Timeouts
+	return &HealthServer{
+		ctx:      ctx,
+		probe:    probe,
+		services: services,
+		timeout:  to.Get(TimeoutClusterConnect) + to.Get(TimeoutTrafficManagerConnect),
+	}
+}
+
+func (h *HealthServer) Check(_ context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	if !h.knownService(req.Service) {
+		return nil, status.Errorf(codes.NotFound, "unknown service %q", req.Service)
+	}
+	return &grpc_health_v1.HealthCheckResponse{Status: h.status()}, nil
+}
+
+func (h *HealthServer) Watch(req *grpc_health_v1.HealthCheckRequest, stream grpc_health_v1.Health_WatchServer) error {
+	ticker := time.NewTicker(healthWatchInterval)
+	defer ticker.Stop()
+	ctx := stream.Context()
+	st := h.serviceStatus(req.Service)
+	for {
+		if err := stream.Send(&grpc_health_v1.HealthCheckResponse{Status: st}); err != nil {
+			return err
+		}
+		next := st
+		for next == st {
+			select {
+			case <-ctx.Done():
+				return status.FromContextError(ctx.Err()).Err()
+			case <-ticker.C:
+			}
+			next = h.serviceStatus(req.Service)
+		}
+		st = next
+	}
+}
+
+func (h *HealthServer) serviceStatus(service string) grpc_health_v1.HealthCheckResponse_ServingStatus {
+	if !h.knownService(service) {
+		return grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN
+	}
+	return h.status()
+}
+
+func (h *HealthServer) knownService(service string) bool {
+	if service == "" {
+		return true
+	}
+	for _, s := range h.services {
+		if s == service {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *HealthServer) status() grpc_health_v1.HealthCheckResponse_ServingStatus {
+	if h.ctx.Err() != nil {
+		return grpc_health_v1.HealthCheckResponse_NOT_SERVING
+	}
+	now := time.Now()
+	start := atomic.LoadInt64(&h.probeStart)
+	if start == 0 {
+		if atomic.CompareAndSwapInt64(&h.probeStart, 0, now.UnixNano()) {
+			go func() {
+				h.probe()
+				atomic.StoreInt64(&h.probeStart, 0)
+			}()
+		}
+		return grpc_health_v1.HealthCheckResponse_SERVING
+	}
+	if now.Sub(time.Unix(0, start)) > h.timeout {
+		return grpc_health_v1.HealthCheckResponse_NOT_SERVING
+	}
+	return grpc_health_v1.HealthCheckResponse_SERVING
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+func TestHealthServer_Check(t *testing.T) {
+	cfg := GetDefaultConfig()
+	ctx, cancel := context.WithCancel(WithConfig(dlog.NewTestContext(t, false), &cfg))
+	defer cancel()
+
+	block := make(chan struct{})
+	probed := make(chan struct{}, 1)
+	h := NewHealthServer(ctx, func() {
+		select {
+		case probed <- struct{}{}:
+		default:
+		}
+		<-block
+	}, "test.Service")
+	h.timeout = 100 * time.Millisecond
+
+	check := func(service string) grpc_health_v1.HealthCheckResponse_ServingStatus {
+		rsp, err := h.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		return rsp.Status
+	}
+
+	_, err := h.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: "other.Service"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// The first check starts a probe that blocks
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, check(""))
+	<-probed
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, check("test.Service"))
+
+	// The probe has been blocked for too long
+	time.Sleep(2 * h.timeout)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, check(""))
+
+	// The probe is unblocked
+	close(block)
+	assert.Eventually(t, func() bool {
+		return check("") == grpc_health_v1.HealthCheckResponse_SERVING
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, check(""))
+}
@@ -0,0 +1,112 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/datawire/dlib/derror"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+// crashSuffix is the infix used in the name of crash report files, e.g. "connector-crash-20220601T153010.txt".
+const crashSuffix = "-crash-"
+
+// WriteCrashReport writes a crash report for the given process into the log directory. The report contains the
+// given error, with stack trace when available, followed by the stacks of all goroutines that are currently running.
+// The name of the created file is returned.
+func WriteCrashReport(ctx context.Context, procName string, perr error) (string, error) {
+	dir, err := filelocation.AppUserLogDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+
+	// Dump all goroutine stacks. Grow the buffer until the dump fits.
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	sb := strings.Builder{}
+	fmt.Fprintf(&sb, "Telepresence %s %s crashed at %s\n", procName, client.DisplayVersion(), time.Now().Format(time.RFC3339))
+	fmt.Fprintf(&sb, "PID: %d, OS: %s, ARCH: %s, Go: %s\n\n", os.Getpid(), runtime.GOOS, runtime.GOARCH, runtime.Version())
+	fmt.Fprintf(&sb, "%+v\n\nGoroutines:\n\n", perr)
+	sb.Write(buf)
+
+	fileName := filepath.Join(dir, procName+crashSuffix+time.Now().Format("20060102T150405")+".txt")
+	if err = os.WriteFile(fileName, []byte(sb.String()), 0o600); err != nil {
+		return "", err
+	}
+
+	// The root daemon writes into the user's log directory, so ensure that the user owns the report.
+	if df, err := os.Open(dir); err == nil {
+		if di, err := FStat(df); err == nil {
+			_ = di.SetOwnerAndGroup(fileName)
+		}
+		_ = df.Close()
+	}
+	return fileName, nil
+}
+
+// ReportCrash writes a crash report and logs where it can be found. Errors are logged and otherwise ignored since
+// this is called when things are already going south.
+func ReportCrash(ctx context.Context, procName string, perr error) {
+	if fileName, err := WriteCrashReport(ctx, procName, perr); err != nil {
+		dlog.Errorf(ctx, "unable to write crash report: %v", err)
+	} else {
+		dlog.Errorf(ctx, "crash report written to %s", fileName)
+	}
+}
+
+// WithCrashReport wraps the given function so that a panic in it results in a crash report in the log directory.
+// The panic is converted to an error and returned, in the same way as dgroup does with panics in its goroutines.
+func WithCrashReport(procName string, f func(context.Context) error) func(context.Context) error {
+	return func(ctx context.Context) (err error) {
+		defer func() {
+			if perr := derror.PanicToError(recover()); perr != nil {
+				ReportCrash(ctx, procName, perr)
+				err = perr
+			}
+		}()
+		return f(ctx)
+	}
+}
+
+// CrashReportServerOptions returns gRPC server options that will write a crash report when a gRPC handler panics.
+// The panic is propagated after the report has been written, so the process behaves the same way as it would
+// without the report.
+func CrashReportServerOptions(ctx context.Context, procName string) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(c context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			defer crashGuard(ctx, procName)
+			return handler(c, req)
+		}),
+		grpc.ChainStreamInterceptor(func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			defer crashGuard(ctx, procName)
+			return handler(srv, ss)
+		}),
+	}
+}
+
+func crashGuard(ctx context.Context, procName string) {
+	if r := recover(); r != nil {
+		ReportCrash(ctx, procName, derror.PanicToError(r))
+		panic(r)
+	}
+}
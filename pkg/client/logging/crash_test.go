@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+func TestWithCrashReport(t *testing.T) {
+	logDir := t.TempDir()
+	ctx := filelocation.WithAppUserLogDir(dlog.NewTestContext(t, false), logDir)
+
+	t.Run("no panic", func(t *testing.T) {
+		theErr := errors.New("not a panic")
+		err := WithCrashReport("testing", func(context.Context) error { return theErr })(ctx)
+		require.Equal(t, theErr, err)
+		files, err := os.ReadDir(logDir)
+		require.NoError(t, err)
+		require.Empty(t, files)
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		err := WithCrashReport("testing", func(context.Context) error { panic("boom") })(ctx)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "boom")
+
+		files, err := os.ReadDir(logDir)
+		require.NoError(t, err)
+		require.Len(t, files, 1)
+		name := files[0].Name()
+		require.True(t, strings.HasPrefix(name, "testing-crash-"), name)
+
+		bs, err := os.ReadFile(filepath.Join(logDir, name))
+		require.NoError(t, err)
+		report := string(bs)
+		require.Contains(t, report, "PANIC: boom")
+		require.Contains(t, report, "Goroutines:")
+		require.Contains(t, report, "TestWithCrashReport")
+	})
+}
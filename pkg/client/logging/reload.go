@@ -2,6 +2,7 @@ package logging
 
 import (
 	"context"
+	"strings"
 
 	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
@@ -10,12 +11,17 @@ import (
 
 // ReloadDaemonConfig replaces the current config with one loaded from disk and
 // calls SetLevel with the log level defined for the rootDaemon or userDaemon
-// depending on the root flag
+// depending on the root flag. Changes that don't take effect until the session
+// is reconnected, or the daemons are restarted, are reported as warnings.
 func ReloadDaemonConfig(c context.Context, root bool) error {
 	newCfg, err := client.LoadConfig(c)
 	if err != nil {
 		return err
 	}
+	var changes client.ConfigChanges
+	if oldCfg := client.GetConfig(c); oldCfg != nil {
+		changes = client.DiffConfig(oldCfg, newCfg)
+	}
 	client.ReplaceConfig(c, newCfg)
 	var level string
 	if root {
@@ -24,6 +30,17 @@ func ReloadDaemonConfig(c context.Context, root bool) error {
 		level = newCfg.LogLevels.UserDaemon.String()
 	}
 	log.SetLevel(c, level)
-	dlog.Info(c, "Configuration reloaded")
+	if len(changes.Changed) == 0 {
+		dlog.Info(c, "Configuration reloaded")
+		return nil
+	}
+	dlog.Infof(c, "Configuration reloaded, changed: %s", strings.Join(changes.Changed, ", "))
+	if len(changes.RequireReconnect) > 0 {
+		dlog.Warnf(c, "Changes to %s take effect when the session is reconnected", strings.Join(changes.RequireReconnect, ", "))
+	}
+	if len(changes.RequireRestart) > 0 {
+		dlog.Warnf(c, "Changes to %s take effect when the daemons are restarted using telepresence quit -s",
+			strings.Join(changes.RequireRestart, ", "))
+	}
 	return nil
 }
@@ -0,0 +1,19 @@
+package client
+
+import (
+	"google.golang.org/grpc/metadata"
+)
+
+// managerWarningsMetadataKey is the gRPC header that the traffic-manager uses to push warnings, such as the
+// deprecation of the client's version, to the client.
+const managerWarningsMetadataKey = "x-telepresence-manager-warnings"
+
+// ManagerWarningsHeader returns the gRPC header that carries the given warnings.
+func ManagerWarningsHeader(warnings ...string) metadata.MD {
+	return metadata.MD{managerWarningsMetadataKey: warnings}
+}
+
+// ManagerWarnings returns the warnings that the traffic-manager pushed in the given gRPC header.
+func ManagerWarnings(header metadata.MD) []string {
+	return header.Get(managerWarningsMetadataKey)
+}
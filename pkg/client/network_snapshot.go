@@ -0,0 +1,31 @@
+package client
+
+import (
+	"context"
+	"path/filepath"
+)
+
+// NetworkSnapshotDir returns the directory where the root daemon keeps a snapshot of the network configuration of
+// the machine for each of its sessions. A snapshot is removed when its session has restored the configuration, so
+// the snapshots that remain after the root daemon has quit were left by a daemon that didn't get a chance to.
+//
+// The directory is owned by the administrator, because the snapshots are restored with elevated privileges, and
+// must never contain anything that an unprivileged user has written.
+func NetworkSnapshotDir(_ context.Context) (string, error) {
+	return networkSnapshotDir()
+}
+
+// NetworkSnapshots returns the files of the snapshots in the NetworkSnapshotDir.
+func NetworkSnapshots(ctx context.Context) ([]string, error) {
+	dir, err := NetworkSnapshotDir(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return filepath.Glob(filepath.Join(dir, "*.json"))
+}
+
+// CheckNetworkSnapshot returns an error unless the given snapshot file, and the directory that it's in, can only
+// have been written by the administrator.
+func CheckNetworkSnapshot(file string) error {
+	return checkNetworkSnapshot(file)
+}
@@ -0,0 +1,34 @@
+//go:build !windows
+// +build !windows
+
+package client
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+func networkSnapshotDir() (string, error) {
+	return "/var/run/telepresence/network-snapshots", nil
+}
+
+func checkNetworkSnapshot(file string) error {
+	for _, name := range []string{filepath.Dir(file), file} {
+		st, err := os.Lstat(name)
+		if err != nil {
+			return err
+		}
+		if st.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%s is a symbolic link", name)
+		}
+		if sys, ok := st.Sys().(*syscall.Stat_t); !ok || sys.Uid != 0 {
+			return fmt.Errorf("%s isn't owned by root", name)
+		}
+		if st.Mode().Perm()&0o022 != 0 {
+			return fmt.Errorf("%s is writable by others than root", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+//go:build !windows
+// +build !windows
+
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckNetworkSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Chmod(dir, 0o755))
+	file := filepath.Join(dir, "session.json")
+	require.NoError(t, os.WriteFile(file, []byte("{}"), 0o600))
+	link := filepath.Join(dir, "link.json")
+	require.NoError(t, os.Symlink(file, link))
+
+	assert.Error(t, CheckNetworkSnapshot(link), "a symbolic link was accepted")
+	if os.Getuid() != 0 {
+		assert.Error(t, CheckNetworkSnapshot(file), "a file that isn't owned by root was accepted")
+		return
+	}
+	assert.NoError(t, CheckNetworkSnapshot(file))
+	require.NoError(t, os.Chmod(file, 0o666))
+	assert.Error(t, CheckNetworkSnapshot(file), "a file that others may write was accepted")
+	require.NoError(t, os.Chmod(file, 0o600))
+	require.NoError(t, os.Chmod(dir, 0o777))
+	assert.Error(t, CheckNetworkSnapshot(file), "a directory that others may write was accepted")
+}
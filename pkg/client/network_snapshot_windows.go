@@ -0,0 +1,28 @@
+package client
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+func networkSnapshotDir() (string, error) {
+	pd := os.Getenv("ProgramData")
+	if pd == "" {
+		return "", errors.New("the ProgramData directory is unknown")
+	}
+	return filepath.Join(pd, "telepresence", "network-snapshots"), nil
+}
+
+// checkNetworkSnapshot only checks that the file isn't a symbolic link. The ACL of the directory decides who may
+// write to it, and the restore only applies changes that a session could have made, regardless of the contents.
+func checkNetworkSnapshot(file string) error {
+	st, err := os.Lstat(file)
+	if err != nil {
+		return err
+	}
+	if st.Mode()&os.ModeSymlink != 0 {
+		return errors.New(file + " is a symbolic link")
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+// Package proxy contains a SOCKS5 and HTTP proxy that dispatches the connections of a single process to the
+// cluster, without the global routes of the root daemon's TUN device.
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// Tunnel connects the connections of the proxy to the cluster.
+type Tunnel interface {
+	// LookupHost returns the IP that the given host resolves to in the cluster.
+	LookupHost(ctx context.Context, host string) (net.IP, error)
+
+	// Connect dispatches the traffic of the given connection to the given address in the cluster. It returns when
+	// the connection has ended.
+	Connect(ctx context.Context, conn net.Conn, ip net.IP, port uint16) error
+}
+
+// Server accepts SOCKS5 and HTTP proxy connections on the same listener. SOCKS5 connections are recognized by
+// their first byte, which is the version 5.
+type Server struct {
+	tunnel Tunnel
+}
+
+func NewServer(tunnel Tunnel) *Server {
+	return &Server{tunnel: tunnel}
+}
+
+// Serve accepts connections on the given listener until the context is cancelled.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		_ = l.Close()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go s.handle(ctx, conn)
+	}
+}
+
+// bufferedConn is a connection that is read through the reader that was used during the proxy handshake, so that
+// no data that the client sent after the handshake is lost.
+type bufferedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (s *Server) handle(ctx context.Context, conn net.Conn) {
+	br := bufio.NewReader(conn)
+	first, err := br.Peek(1)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	if first[0] == socksVersion {
+		err = s.handleSOCKS(ctx, conn, br)
+	} else {
+		err = s.handleHTTP(ctx, conn, br)
+	}
+	if err != nil {
+		dlog.Errorf(ctx, "proxy connection from %s failed: %v", conn.RemoteAddr(), err)
+	}
+	_ = conn.Close()
+}
+
+// connect resolves the given host in the cluster and dispatches the connection to it. The reply function is
+// called with the result of the lookup before any traffic is dispatched.
+func (s *Server) connect(ctx context.Context, conn net.Conn, host string, port uint16, reply func(error) error) error {
+	ip, err := s.tunnel.LookupHost(ctx, host)
+	if err != nil {
+		_ = reply(err)
+		return err
+	}
+	if err = reply(nil); err != nil {
+		return err
+	}
+	return s.tunnel.Connect(ctx, conn, ip, port)
+}
+
+const (
+	socksVersion          = 5
+	socksNoAuth           = 0
+	socksNoAcceptable     = 0xff
+	socksCmdConnect       = 1
+	socksAtypIPv4         = 1
+	socksAtypDomain       = 3
+	socksAtypIPv6         = 4
+	socksSucceeded        = 0
+	socksHostUnreachable  = 4
+	socksCmdNotSupported  = 7
+	socksAtypNotSupported = 8
+)
+
+// handleSOCKS implements the CONNECT command of SOCKS5 (RFC 1928) without authentication. Host names are resolved
+// in the cluster.
+func (s *Server) handleSOCKS(ctx context.Context, conn net.Conn, br *bufio.Reader) error {
+	// Greeting: VER NMETHODS METHODS...
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return err
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(br, methods); err != nil {
+		return err
+	}
+	method := byte(socksNoAcceptable)
+	for _, m := range methods {
+		if m == socksNoAuth {
+			method = socksNoAuth
+			break
+		}
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return err
+	}
+	if method == socksNoAcceptable {
+		return errors.New("the SOCKS client requires authentication")
+	}
+
+	// Request: VER CMD RSV ATYP DST.ADDR DST.PORT
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(br, req); err != nil {
+		return err
+	}
+	reply := func(rep byte) error {
+		_, err := conn.Write([]byte{socksVersion, rep, 0, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+		return err
+	}
+	if req[1] != socksCmdConnect {
+		_ = reply(socksCmdNotSupported)
+		return fmt.Errorf("unsupported SOCKS command %d", req[1])
+	}
+	var host string
+	switch req[3] {
+	case socksAtypIPv4, socksAtypIPv6:
+		ip := make(net.IP, 4)
+		if req[3] == socksAtypIPv6 {
+			ip = make(net.IP, 16)
+		}
+		if _, err := io.ReadFull(br, ip); err != nil {
+			return err
+		}
+		host = ip.String()
+	case socksAtypDomain:
+		n, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		name := make([]byte, n)
+		if _, err = io.ReadFull(br, name); err != nil {
+			return err
+		}
+		host = string(name)
+	default:
+		_ = reply(socksAtypNotSupported)
+		return fmt.Errorf("unsupported SOCKS address type %d", req[3])
+	}
+	pb := make([]byte, 2)
+	if _, err := io.ReadFull(br, pb); err != nil {
+		return err
+	}
+	port := binary.BigEndian.Uint16(pb)
+
+	return s.connect(ctx, &bufferedConn{Conn: conn, r: br}, host, port, func(err error) error {
+		if err != nil {
+			return reply(socksHostUnreachable)
+		}
+		return reply(socksSucceeded)
+	})
+}
+
+// handleHTTP implements an HTTP proxy. A CONNECT request is answered and then tunneled as is. Other requests must
+// use an absolute http URL. They are forwarded with "Connection: close", so that the connection isn't reused for
+// another host.
+func (s *Server) handleHTTP(ctx context.Context, conn net.Conn, br *bufio.Reader) error {
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return err
+	}
+	badRequest := func(format string, args ...interface{}) error {
+		_, _ = io.WriteString(conn, "HTTP/1.1 400 Bad Request\r\nConnection: close\r\n\r\n")
+		return fmt.Errorf(format, args...)
+	}
+	if req.Method == http.MethodConnect {
+		host, port, err := splitHostPort(req.Host, "")
+		if err != nil {
+			return badRequest("invalid CONNECT address %q: %v", req.Host, err)
+		}
+		return s.connect(ctx, &bufferedConn{Conn: conn, r: br}, host, port, func(err error) error {
+			if err != nil {
+				_, err = io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\nConnection: close\r\n\r\n")
+				return err
+			}
+			_, err = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+			return err
+		})
+	}
+
+	if req.URL.Scheme != "http" || req.URL.Host == "" {
+		return badRequest("the proxy only forwards absolute http URLs, got %q", req.RequestURI)
+	}
+	host, port, err := splitHostPort(req.URL.Host, "80")
+	if err != nil {
+		return badRequest("invalid host %q: %v", req.URL.Host, err)
+	}
+	req.Header.Del("Proxy-Connection")
+	req.Header.Del("Proxy-Authorization")
+	req.Close = true
+
+	// The request is written in origin form, followed by whatever the client sends after it.
+	pr, pw := io.Pipe()
+	go func() {
+		_ = pw.CloseWithError(req.Write(pw))
+	}()
+	defer pr.Close()
+	return s.connect(ctx, &bufferedConn{Conn: conn, r: io.MultiReader(pr, br)}, host, port, func(err error) error {
+		if err != nil {
+			_, err = io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\nConnection: close\r\n\r\n")
+		}
+		return err
+	})
+}
+
+func splitHostPort(hostPort, defaultPort string) (string, uint16, error) {
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		if defaultPort == "" {
+			return "", 0, err
+		}
+		host, port = strings.TrimSuffix(strings.TrimPrefix(hostPort, "["), "]"), defaultPort
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return "", 0, err
+	}
+	return host, uint16(p), nil
+}
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// fakeTunnel knows one host, echo.default, which is an HTTP server on localhost.
+type fakeTunnel struct{}
+
+func (fakeTunnel) LookupHost(_ context.Context, host string) (net.IP, error) {
+	if host == "echo.default" || host == "127.0.0.1" {
+		return net.IPv4(127, 0, 0, 1), nil
+	}
+	return nil, fmt.Errorf("no such host %s", host)
+}
+
+func (fakeTunnel) Connect(ctx context.Context, conn net.Conn, ip net.IP, port uint16) error {
+	var d net.Dialer
+	dc, err := d.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), fmt.Sprint(port)))
+	if err != nil {
+		return err
+	}
+	defer dc.Close()
+	go func() {
+		_, _ = io.Copy(dc, conn)
+	}()
+	_, _ = io.Copy(conn, dc)
+	return nil
+}
+
+func startProxy(t *testing.T) (string, string) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	t.Cleanup(cancel)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = NewServer(fakeTunnel{}).Serve(ctx, l) }()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "hello from %s", r.URL.Path)
+	}))
+	t.Cleanup(srv.Close)
+	su, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	return l.Addr().String(), su.Port()
+}
+
+func get(t *testing.T, proxyURL, target string) (*http.Response, string) {
+	pu, err := url.Parse(proxyURL)
+	require.NoError(t, err)
+	hc := http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(pu)}}
+	rs, err := hc.Get(target)
+	require.NoError(t, err)
+	defer rs.Body.Close()
+	body, err := io.ReadAll(rs.Body)
+	require.NoError(t, err)
+	return rs, string(body)
+}
+
+func TestServer_SOCKS(t *testing.T) {
+	addr, port := startProxy(t)
+	rs, body := get(t, "socks5://"+addr, "http://echo.default:"+port+"/socks")
+	assert.Equal(t, http.StatusOK, rs.StatusCode)
+	assert.Equal(t, "hello from /socks", body)
+
+	hc := http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(&url.URL{Scheme: "socks5", Host: addr})}}
+	_, err := hc.Get("http://unknown.default:" + port + "/")
+	assert.Error(t, err)
+}
+
+func TestServer_HTTP(t *testing.T) {
+	addr, port := startProxy(t)
+	rs, body := get(t, "http://"+addr, "http://echo.default:"+port+"/http")
+	assert.Equal(t, http.StatusOK, rs.StatusCode)
+	assert.Equal(t, "hello from /http", body)
+
+	rs, _ = get(t, "http://"+addr, "http://unknown.default:"+port+"/")
+	assert.Equal(t, http.StatusBadGateway, rs.StatusCode)
+}
+
+func TestServer_CONNECT(t *testing.T) {
+	addr, port := startProxy(t)
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = fmt.Fprintf(conn, "CONNECT echo.default:%s HTTP/1.1\r\nHost: echo.default:%s\r\n\r\n", port, port)
+	require.NoError(t, err)
+	br := bufio.NewReader(conn)
+	rs, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rs.StatusCode)
+
+	// The connection is now tunneled to the server
+	_, err = fmt.Fprintf(conn, "GET /connect HTTP/1.1\r\nHost: echo.default\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+	rs, err = http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	defer rs.Body.Close()
+	body, err := io.ReadAll(rs.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello from /connect", string(body))
+}
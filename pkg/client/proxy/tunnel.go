@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+type managerTunnel struct {
+	managerClient manager.ManagerClient
+	session       *manager.SessionInfo
+	compression   tunnel.Compression
+}
+
+// NewManagerTunnel returns a Tunnel that resolves names, and dispatches connections, using the traffic-manager
+// of the given session. The given compression is requested for each connection.
+func NewManagerTunnel(managerClient manager.ManagerClient, session *manager.SessionInfo, compression tunnel.Compression) Tunnel {
+	return &managerTunnel{managerClient: managerClient, session: session, compression: compression}
+}
+
+func (t *managerTunnel) LookupHost(ctx context.Context, host string) (net.IP, error) {
+	if ip := iputil.Parse(host); ip != nil {
+		return ip, nil
+	}
+	r, err := t.managerClient.LookupHost(ctx, &manager.LookupHostRequest{Session: t.session, Host: host})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up %s: %w", host, err)
+	}
+	ips := iputil.IPsFromBytesSlice(r.Ips)
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no such host %s", host)
+	}
+	// Prefer IPv4, because the cluster might not route IPv6
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip, nil
+		}
+	}
+	return ips[0], nil
+}
+
+func (t *managerTunnel) Connect(ctx context.Context, conn net.Conn, ip net.IP, port uint16) error {
+	srcIP, srcPort, err := iputil.SplitToIPPort(conn.RemoteAddr())
+	if err != nil {
+		return fmt.Errorf("failed to parse the source address %s: %w", conn.RemoteAddr(), err)
+	}
+	id := tunnel.NewConnID(ipproto.TCP, srcIP, ip, srcPort, port)
+	ms, err := t.managerClient.Tunnel(ctx)
+	if err != nil {
+		return fmt.Errorf("call to manager.Tunnel() failed. Id %s: %w", id, err)
+	}
+	tc := client.GetConfig(ctx).Timeouts
+	s, err := tunnel.NewClientStream(ctx, ms, id, t.session.SessionId, tc.Get(client.TimeoutRoundtripLatency), tc.Get(client.TimeoutEndpointDial), t.compression)
+	if err != nil {
+		return err
+	}
+	ep := tunnel.NewConnEndpoint(s, conn)
+	ep.Start(ctx)
+	<-ep.Done()
+	return nil
+}
@@ -0,0 +1,109 @@
+package dns
+
+import (
+	"net"
+	"sort"
+	"strings"
+)
+
+// overrides are static mappings of names, and of wildcard names like "*.test", to IP addresses. They are
+// resolved by the local DNS server without asking the cluster.
+type overrides struct {
+	names map[string]net.IP
+
+	// wildcards are sorted with the longest domain first, so that the most specific wildcard wins.
+	wildcards []wildcardOverride
+}
+
+type wildcardOverride struct {
+	domain string
+	ip     net.IP
+}
+
+// newOverrides returns the overrides for the given map of names to IP addresses. Names and addresses
+// are validated when the client config is loaded, so invalid entries are silently ignored here.
+func newOverrides(m map[string]string) *overrides {
+	if len(m) == 0 {
+		return nil
+	}
+	o := &overrides{names: make(map[string]net.IP, len(m))}
+	for name, s := range m {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			continue
+		}
+		name = strings.TrimSuffix(strings.ToLower(name), ".")
+		if domain := strings.TrimPrefix(name, "*."); domain != name {
+			o.wildcards = append(o.wildcards, wildcardOverride{domain: domain, ip: ip})
+		} else {
+			o.names[name] = ip
+		}
+	}
+	sort.Slice(o.wildcards, func(i, j int) bool {
+		return len(o.wildcards[i].domain) > len(o.wildcards[j].domain)
+	})
+	return o
+}
+
+// lookup returns the IP address of the given name, or nil if the name isn't overridden. The name is also
+// looked up with each of the given search paths stripped, because a single-label name might arrive with a
+// search path that the host's resolver appended to it.
+func (o *overrides) lookup(name string, search []string) net.IP {
+	if o == nil {
+		return nil
+	}
+	name = strings.ToLower(name)
+	name = strings.TrimSuffix(name, tel2SubDomainDot)
+	name = strings.TrimSuffix(name, ".")
+	if ip := o.lookupName(name); ip != nil {
+		return ip
+	}
+	for _, sp := range search {
+		sp = strings.TrimSuffix(sp, ".")
+		if sp == "" {
+			continue
+		}
+		if n := strings.TrimSuffix(name, "."+sp); n != name {
+			if ip := o.lookupName(n); ip != nil {
+				return ip
+			}
+		}
+	}
+	return nil
+}
+
+func (o *overrides) lookupName(name string) net.IP {
+	if ip, ok := o.names[name]; ok {
+		return ip
+	}
+	for _, wc := range o.wildcards {
+		if strings.HasSuffix(name, "."+wc.domain) {
+			return wc.ip
+		}
+	}
+	return nil
+}
+
+// domains returns the domains that the overrides resolve names in. The host's resolver must route queries
+// for those domains to the local DNS server.
+func (o *overrides) domains() []string {
+	if o == nil || len(o.names)+len(o.wildcards) == 0 {
+		return nil
+	}
+	domains := make([]string, 0, len(o.names)+len(o.wildcards))
+	for name := range o.names {
+		domains = append(domains, name)
+	}
+	for _, wc := range o.wildcards {
+		domains = append(domains, wc.domain)
+	}
+	sort.Strings(domains)
+	last := 0
+	for i := 1; i < len(domains); i++ {
+		if domains[i] != domains[last] {
+			last++
+			domains[last] = domains[i]
+		}
+	}
+	return domains[:last+1]
+}
@@ -0,0 +1,46 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_overrides(t *testing.T) {
+	o := newOverrides(map[string]string{
+		"*.test":       "127.0.0.1",
+		"*.api.test":   "127.0.0.2",
+		"legacy-db":    "10.1.2.3",
+		"Mixed.Case.":  "::1",
+		"not-an-ip.io": "localhost",
+	})
+	search := []string{"", "default.svc.cluster.local."}
+
+	tests := []struct {
+		name string
+		want net.IP
+	}{
+		{"legacy-db.", net.IP{10, 1, 2, 3}},
+		{"LEGACY-DB.", net.IP{10, 1, 2, 3}},
+		{"legacy-db.tel2-search.", net.IP{10, 1, 2, 3}},
+		{"legacy-db.default.svc.cluster.local.", net.IP{10, 1, 2, 3}},
+		{"legacy-db.other.svc.cluster.local.", nil},
+		{"a.test.", net.IP{127, 0, 0, 1}},
+		{"a.b.test.", net.IP{127, 0, 0, 1}},
+		{"a.api.test.", net.IP{127, 0, 0, 2}},
+		{"test.", nil},
+		{"mixed.case.", net.ParseIP("::1")},
+		{"not-an-ip.io.", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.True(t, tt.want.Equal(o.lookup(tt.name, search)), "got %v, want %v", o.lookup(tt.name, search), tt.want)
+		})
+	}
+	assert.Equal(t, []string{"api.test", "legacy-db", "mixed.case", "test"}, o.domains())
+
+	var no *overrides
+	assert.Nil(t, no.lookup("legacy-db.", nil))
+	assert.Empty(t, no.domains())
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"reflect"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -67,13 +69,22 @@ type Server struct {
 	// searchPathCh receives requests to change the search path.
 	searchPathCh chan []string
 
+	// overridesCh receives a notification when the domains of the overrides have changed.
+	overridesCh chan struct{}
+
 	config *rpc.DNSConfig
 
+	// overrides are resolved before the cluster is asked. They are locked by the domainsLock.
+	overrides *overrides
+
 	// clusterDomain reported by the traffic-manager
 	clusterDomain string
 
 	// Function that sends a lookup requrest to the traffic-manager
 	clusterLookup func(context.Context, string) ([][]byte, error)
+
+	// netns is the network namespace that the server serves DNS to instead of to the host
+	netns string
 }
 
 type cacheEntry struct {
@@ -114,6 +125,7 @@ func NewServer(config *rpc.DNSConfig, clusterLookup func(context.Context, string
 		domains:       make(map[string]struct{}),
 		search:        []string{""},
 		searchPathCh:  make(chan []string, 5),
+		overridesCh:   make(chan struct{}, 1),
 		clusterDomain: defaultClusterDomain,
 		clusterLookup: clusterLookup,
 	}
@@ -203,6 +215,14 @@ func (s *Server) GetConfig() *rpc.DNSConfig {
 		dnsConfig.IncludeSuffixes = s.config.IncludeSuffixes
 		dnsConfig.LookupTimeout = s.config.LookupTimeout
 	}
+	s.domainsLock.RLock()
+	if len(s.search) > 0 {
+		dnsConfig.SearchPath = make([]string, len(s.search))
+		for i, sp := range s.search {
+			dnsConfig.SearchPath[i] = strings.TrimSuffix(sp, ".")
+		}
+	}
+	s.domainsLock.RUnlock()
 	return dnsConfig
 }
 
@@ -216,6 +236,47 @@ func (s *Server) SetClusterDomainAndDNS(domain string, dnsIP net.IP) {
 	}
 }
 
+// SetOverrides sets the static mappings of names, and of wildcard names like "*.test", to IP addresses that
+// are resolved without asking the cluster. When the server is running, the routes of the host's resolver are
+// updated to the domains of the new overrides.
+func (s *Server) SetOverrides(names map[string]string) {
+	o := newOverrides(names)
+	s.domainsLock.Lock()
+	old := s.overrides
+	s.overrides = o
+	s.domainsLock.Unlock()
+	if reflect.DeepEqual(old, o) {
+		return
+	}
+	s.flushDNS()
+	if !reflect.DeepEqual(old.domains(), o.domains()) {
+		select {
+		case s.overridesCh <- struct{}{}:
+		default:
+			// A notification is already pending
+		}
+	}
+}
+
+// SetNetNamespace makes the server serve DNS to the processes in the given network namespace instead of
+// configuring the host's resolver. It must be called before the server is started.
+func (s *Server) SetNetNamespace(name string) {
+	s.netns = name
+}
+
+// routedSuffixes returns the suffixes, besides the cluster domain and the namespaces, that the host's resolver
+// must route to this server.
+func (s *Server) routedSuffixes() []string {
+	sfxs := s.config.IncludeSuffixes
+	s.domainsLock.RLock()
+	ods := s.overrides.domains()
+	s.domainsLock.RUnlock()
+	if len(ods) > 0 {
+		sfxs = append(append(make([]string, 0, len(sfxs)+len(ods)), sfxs...), ods...)
+	}
+	return sfxs
+}
+
 // SetSearchPath updates the DNS search path used by the resolver
 func (s *Server) SetSearchPath(ctx context.Context, paths, namespaces []string) {
 	// Provide direct access to intercepted namespaces
@@ -228,12 +289,22 @@ func (s *Server) SetSearchPath(ctx context.Context, paths, namespaces []string)
 	}
 }
 
+// newLocalUDPListener creates a listener on the loopback interface. The port is taken from the client
+// configuration, and a random port is used unless it's configured.
 func newLocalUDPListener(c context.Context) (net.PacketConn, error) {
 	lc := &net.ListenConfig{}
-	return lc.ListenPacket(c, "udp", "127.0.0.1:0")
+	return lc.ListenPacket(c, "udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(configuredDNS(c).LocalPort))))
 }
 
-func (s *Server) processSearchPaths(g *dgroup.Group, processor func(context.Context, []string, *vif.Device) error, dev *vif.Device) {
+// configuredDNS returns the DNS section of the client configuration.
+func configuredDNS(c context.Context) client.DNS {
+	if cfg := client.GetConfig(c); cfg != nil {
+		return cfg.DNS
+	}
+	return client.DNS{}
+}
+
+func (s *Server) processSearchPaths(g *dgroup.Group, processor func(context.Context, []string, vif.Interface) error, dev vif.Interface) {
 	g.Go("SearchPaths", func(c context.Context) error {
 		var prevPaths []string
 		unchanged := func(paths []string) bool {
@@ -261,6 +332,11 @@ func (s *Server) processSearchPaths(g *dgroup.Group, processor func(context.Cont
 					dlog.Debugf(c, "%v -> %v", prevPaths, paths)
 					prevPaths = make([]string, len(paths))
 					copy(prevPaths, paths)
+					select {
+					case <-s.overridesCh:
+						// The processor uses the current overrides
+					default:
+					}
 					if err := processor(c, paths, dev); err != nil {
 						return err
 					}
@@ -275,6 +351,18 @@ func (s *Server) processSearchPaths(g *dgroup.Group, processor func(context.Cont
 						}
 					}
 				}
+			case <-s.overridesCh:
+				if prevPaths == nil {
+					// The routes are set when the first search path arrives
+					continue
+				}
+				// The routes to the domains of the overrides must be updated. The processor may modify the paths.
+				dlog.Debug(c, "DNS overrides changed")
+				paths := make([]string, len(prevPaths))
+				copy(paths, prevPaths)
+				if err := processor(c, paths, dev); err != nil {
+					return err
+				}
 			}
 		}
 	})
@@ -452,7 +540,7 @@ func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 
 	defer func() {
 		dlog.Debugf(c, "%s%-6s %s -> %s %s", pfx, qts, q.Name, rct, txt)
-		_ = w.WriteMsg(msg)
+		_ = writeReply(w, r, msg)
 	}()
 
 	if err == nil && answer != nil {
@@ -491,6 +579,15 @@ func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 	pfx = func() string { return fmt.Sprintf("(%s) ", s.fallbackPool.RemoteAddr()) }
 	dc := &dns.Client{Net: "udp", Timeout: s.config.LookupTimeout.AsDuration()}
 	msg, _, err = s.fallbackPool.Exchange(c, dc, r)
+	if err == nil && msg.Truncated {
+		// The reply didn't fit in a UDP datagram, so ask again using TCP.
+		tc := &dns.Client{Net: "tcp", Timeout: s.config.LookupTimeout.AsDuration()}
+		if tMsg, _, tErr := tc.ExchangeContext(c, r, net.JoinHostPort(s.fallbackPool.RemoteAddr(), "53")); tErr == nil {
+			msg = tMsg
+		} else {
+			dlog.Debugf(c, "TCP retry of truncated reply for %s failed: %v", q.Name, tErr)
+		}
+	}
 	if err != nil {
 		msg = new(dns.Msg)
 		rc = dns.RcodeServerFailure
@@ -511,6 +608,29 @@ func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 	}
 }
 
+// maxUDPSize is the UDP payload size that the server announces in the EDNS0 OPT records of its replies. It's the
+// size recommended by DNS flag day 2020, which avoids IP fragmentation on most networks.
+const maxUDPSize = 1232
+
+// writeReply writes the reply to the given request. The reply gets an EDNS0 OPT record if the request has one.
+// A reply that is sent over UDP is truncated to the payload size that the client announces, or to 512 bytes when
+// the client doesn't use EDNS0. The truncated reply has its TC bit set, so that the client retries using TCP.
+func writeReply(w dns.ResponseWriter, r, msg *dns.Msg) error {
+	size := dns.MinMsgSize
+	if opt := r.IsEdns0(); opt != nil {
+		if msg.IsEdns0() == nil {
+			msg.SetEdns0(maxUDPSize, opt.Do())
+		}
+		if us := int(opt.UDPSize()); us > size {
+			size = us
+		}
+	}
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		msg.Truncate(size)
+	}
+	return w.WriteMsg(msg)
+}
+
 // dnsTTL is the number of seconds that a found DNS record should be allowed to live in the callers cache. We
 // keep this low to avoid such caching.
 const dnsTTL = 4
@@ -526,7 +646,7 @@ func (s *Server) resolveQuery(q *dns.Question, dv *cacheEntry) ([]dns.RR, error)
 	switch q.Qtype {
 	case dns.TypeA, dns.TypeAAAA:
 		var ips []net.IP
-		if ips, err = s.resolve(s.ctx, q.Name); err != nil || len(ips) == 0 {
+		if ips, err = s.resolveName(q.Name); err != nil || len(ips) == 0 {
 			break
 		}
 		answer := make([]dns.RR, 0, len(ips))
@@ -548,7 +668,7 @@ func (s *Server) resolveQuery(q *dns.Question, dv *cacheEntry) ([]dns.RR, error)
 		dv.answer = answer
 	default:
 		var ips []net.IP
-		if ips, err = s.resolve(s.ctx, q.Name); err != nil {
+		if ips, err = s.resolveName(q.Name); err != nil {
 			break
 		}
 		if len(ips) > 0 {
@@ -566,6 +686,17 @@ func (s *Server) resolveQuery(q *dns.Question, dv *cacheEntry) ([]dns.RR, error)
 	return copyRRs(dv.answer, q.Qtype), err
 }
 
+// resolveName resolves the given name using the overrides, and then using the resolver of the server.
+func (s *Server) resolveName(name string) ([]net.IP, error) {
+	s.domainsLock.RLock()
+	ip := s.overrides.lookup(name, s.search)
+	s.domainsLock.RUnlock()
+	if ip != nil {
+		return []net.IP{ip}, nil
+	}
+	return s.resolve(s.ctx, name)
+}
+
 // Run starts the DNS server(s) and waits for them to end
 func (s *Server) Run(c context.Context, initDone chan<- struct{}, listeners []net.PacketConn, fallbackPool FallbackPool, resolve Resolver) error {
 	s.ctx = c
@@ -573,9 +704,8 @@ func (s *Server) Run(c context.Context, initDone chan<- struct{}, listeners []ne
 	s.resolve = resolve
 
 	g := dgroup.NewGroup(c, dgroup.GroupConfig{})
-	for _, listener := range listeners {
-		srv := &dns.Server{PacketConn: listener, Handler: s, ReadTimeout: time.Second}
-		g.Go(listener.LocalAddr().String(), func(c context.Context) error {
+	serve := func(name string, srv *dns.Server) {
+		g.Go(name, func(c context.Context) error {
 			go func() {
 				<-c.Done()
 				dlog.Debugf(c, "Shutting down DNS server")
@@ -584,6 +714,18 @@ func (s *Server) Run(c context.Context, initDone chan<- struct{}, listeners []ne
 			return srv.ActivateAndServe()
 		})
 	}
+	for _, listener := range listeners {
+		addr := listener.LocalAddr().String()
+		serve(addr, &dns.Server{PacketConn: listener, Handler: s, ReadTimeout: time.Second})
+
+		// Clients retry over TCP when a reply is truncated, so DNS is served over TCP on the same address.
+		tl, err := net.Listen("tcp", addr)
+		if err != nil {
+			dlog.Warnf(c, "Unable to serve DNS over TCP on %s: %v", addr, err)
+			continue
+		}
+		serve("tcp-"+addr, &dns.Server{Listener: tl, Handler: s, ReadTimeout: time.Second})
+	}
 	close(initDone)
 	return g.Wait()
 }
@@ -13,9 +13,12 @@ import (
 
 	"github.com/datawire/dlib/dgroup"
 	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/vif"
 )
 
+var resolverDirName = filepath.Join("/etc", "resolver")
+
 type resolveFile struct {
 	port        int
 	domain      string
@@ -129,8 +132,18 @@ func (r *resolveFile) setSearchPaths(paths ...string) {
 //   man 5 resolver
 //
 // or, if not on a Mac, follow this link: https://www.manpagez.com/man/5/resolver/
-func (s *Server) Worker(c context.Context, dev *vif.Device, configureDNS func(net.IP, *net.UDPAddr)) error {
-	resolverDirName := filepath.Join("/etc", "resolver")
+// SystemWide returns true, because the resolver files apply to the whole system, and the files of two sessions
+// would compete for the cluster domain and the namespaces.
+func SystemWide(context.Context, string) bool {
+	return true
+}
+
+func (s *Server) Worker(c context.Context, dev vif.Interface, configureDNS func(net.IP, *net.UDPAddr)) error {
+	switch resolver := configuredDNS(c).Resolver; resolver {
+	case client.DNSResolverAuto, client.DNSResolverResolverFiles:
+	default:
+		dlog.Warningf(c, "DNS resolver %q is not supported on darwin, using %q", resolver, client.DNSResolverResolverFiles)
+	}
 	resolverFileName := filepath.Join(resolverDirName, "telepresence.local")
 
 	listener, err := newLocalUDPListener(c)
@@ -176,7 +189,7 @@ func (s *Server) Worker(c context.Context, dev *vif.Device, configureDNS func(ne
 	g := dgroup.NewGroup(c, dgroup.GroupConfig{})
 	g.Go("Server", func(c context.Context) error {
 		// Server will close the listener, so no need to close it here.
-		s.processSearchPaths(g, func(c context.Context, paths []string, device *vif.Device) error {
+		s.processSearchPaths(g, func(c context.Context, paths []string, device vif.Interface) error {
 			return s.updateResolverFiles(c, resolverDirName, resolverFileName, dnsAddr, paths)
 		}, dev)
 		return s.Run(c, make(chan struct{}), []net.PacketConn{listener}, nil, s.resolveInCluster)
@@ -208,7 +221,7 @@ func (s *Server) updateResolverFiles(c context.Context, resolverDirName, resolve
 	for ns, v := range namespaces {
 		domains[ns] = v
 	}
-	for _, sfx := range s.config.IncludeSuffixes {
+	for _, sfx := range s.routedSuffixes() {
 		domains[strings.TrimPrefix(sfx, ".")] = struct{}{}
 	}
 
@@ -270,3 +283,46 @@ func (s *Server) updateResolverFiles(c context.Context, resolverDirName, resolve
 func domainResolverFile(resolverDirName, domain string) string {
 	return filepath.Join(resolverDirName, "telepresence."+domain+".local")
 }
+
+// telepresenceResolverFiles returns the resolver files that are managed by Telepresence.
+func telepresenceResolverFiles() ([]string, error) {
+	return filepath.Glob(filepath.Join(resolverDirName, "telepresence.*"))
+}
+
+func takeSnapshot(_ context.Context) (*Snapshot, error) {
+	files, err := telepresenceResolverFiles()
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{Files: make(map[string]string, len(files))}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		s.Files[file] = string(data)
+	}
+	return s, nil
+}
+
+func (s *Snapshot) restore(ctx context.Context) error {
+	files, err := telepresenceResolverFiles()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if _, ok := s.Files[file]; !ok {
+			dlog.Infof(ctx, "Removing %s", file)
+			if err := os.Remove(file); err != nil {
+				dlog.Error(ctx, err)
+			}
+		}
+	}
+	for file, data := range s.filesIn(ctx, resolverDirName, "telepresence.") {
+		dlog.Infof(ctx, "Restoring %s", file)
+		if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+			dlog.Error(ctx, err)
+		}
+	}
+	return nil
+}
@@ -15,12 +15,41 @@ import (
 	"github.com/datawire/dlib/dgroup"
 	"github.com/datawire/dlib/dlog"
 	"github.com/datawire/dlib/dtime"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/rootd/dbus"
 	"github.com/telepresenceio/telepresence/v2/pkg/vif"
 )
 
 var errResolveDNotConfigured = errors.New("resolved not configured")
 
-func (s *Server) Worker(c context.Context, dev *vif.Device, configureDNS func(net.IP, *net.UDPAddr)) error {
+// routeInNetNamespace routes the given subnet to the given device in the given network namespace, and
+// writeNetNamespaceResolvConf writes the resolv.conf of that namespace. They're variables so that tests can replace
+// them, because only root can change a network namespace.
+var (
+	routeInNetNamespace = func(c context.Context, netns string, subnet *net.IPNet, dev string) error {
+		return dexec.CommandContext(c, "ip", "-n", netns, "route", "replace", subnet.String(), "dev", dev).Run()
+	}
+	writeNetNamespaceResolvConf = vif.WriteNetNamespaceResolvConf
+)
+
+func (s *Server) Worker(c context.Context, dev vif.Interface, configureDNS func(net.IP, *net.UDPAddr)) error {
+	if s.netns != "" {
+		return s.runSandboxServer(dgroup.WithGoroutineName(c, "/sandbox"), dev, configureDNS)
+	}
+	switch resolver := configuredDNS(c).Resolver; resolver {
+	case client.DNSResolverSystemdResolved:
+		err := s.tryResolveD(dgroup.WithGoroutineName(c, "/resolved"), dev, configureDNS)
+		if err == errResolveDNotConfigured {
+			err = errors.New("the configured DNS resolver systemd-resolved is not available")
+		}
+		return err
+	case client.DNSResolverOverriding:
+		return s.runOverridingServer(dgroup.WithGoroutineName(c, "/legacy"), dev)
+	case client.DNSResolverAuto:
+	default:
+		dlog.Warningf(c, "DNS resolver %q is not supported on linux, using %q", resolver, client.DNSResolverAuto)
+	}
+
 	if runningInDocker() {
 		// Don't bother with systemd-resolved when running in a docker container
 		return s.runOverridingServer(dgroup.WithGoroutineName(c, "/docker"), dev)
@@ -94,7 +123,67 @@ func (s *Server) resolveInSearch(c context.Context, query string) ([]net.IP, err
 	return s.resolveInCluster(c, query)
 }
 
-func (s *Server) runOverridingServer(c context.Context, dev *vif.Device) error {
+// runSandboxServer serves DNS to the processes that run in the network namespace of the session. The resolv.conf of
+// the namespace names the remote IP, which is routed to the TUN device, where the queries are passed on to this
+// server. The host's resolver is left untouched.
+func (s *Server) runSandboxServer(c context.Context, dev vif.Interface, configureDNS func(net.IP, *net.UDPAddr)) error {
+	dnsIP := net.IP(s.config.RemoteIp)
+	if dnsIP == nil {
+		return errors.New("the network namespace has no DNS IP")
+	}
+	// The queries reach the server through the TUN device, so it listens on the loopback interface of the host only.
+	listener, err := newLocalUDPListener(c)
+	if err != nil {
+		return err
+	}
+	dnsResolverAddr, err := splitToUDPAddr(listener.LocalAddr())
+	if err != nil {
+		listener.Close()
+		return err
+	}
+
+	// The DNS IP isn't necessarily within a subnet of the TUN device, and the namespace has no other route.
+	ipNet := net.IPNet{IP: dnsIP, Mask: net.CIDRMask(32, 32)}
+	if dnsIP.To4() == nil {
+		ipNet.Mask = net.CIDRMask(128, 128)
+	}
+	if err = routeInNetNamespace(c, s.netns, &ipNet, dev.Name()); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to route DNS IP %s in network namespace %s: %w", dnsIP, s.netns, err)
+	}
+	if err = writeNetNamespaceResolvConf(s.netns, dnsIP, nil); err != nil {
+		listener.Close()
+		return err
+	}
+	configureDNS(dnsIP, dnsResolverAddr)
+	defer configureDNS(nil, nil)
+
+	g := dgroup.NewGroup(c, dgroup.GroupConfig{})
+	g.Go("Server", func(c context.Context) error {
+		// Server will close the listener, so no need to close it here.
+		s.processSearchPaths(g, func(c context.Context, paths []string, _ vif.Interface) error {
+			namespaces := make(map[string]struct{})
+			search := make([]string, 0)
+			for _, path := range paths {
+				if strings.ContainsRune(path, '.') {
+					search = append(search, path)
+				} else if path != "" {
+					namespaces[path] = struct{}{}
+				}
+			}
+			s.domainsLock.Lock()
+			s.namespaces = namespaces
+			s.search = search
+			s.domainsLock.Unlock()
+			s.flushDNS()
+			return writeNetNamespaceResolvConf(s.netns, dnsIP, search)
+		}, dev)
+		return s.Run(c, make(chan struct{}), []net.PacketConn{listener}, nil, s.resolveInSearch)
+	})
+	return g.Wait()
+}
+
+func (s *Server) runOverridingServer(c context.Context, dev vif.Interface) error {
 	if s.config.LocalIp == nil {
 		dat, err := os.ReadFile("/etc/resolv.conf")
 		if err != nil {
@@ -152,7 +241,7 @@ func (s *Server) runOverridingServer(c context.Context, dev *vif.Device) error {
 	g.Go("Server", func(c context.Context) error {
 		defer close(serverDone)
 		// Server will close the listener, so no need to close it here.
-		s.processSearchPaths(g, func(c context.Context, paths []string, _ *vif.Device) error {
+		s.processSearchPaths(g, func(c context.Context, paths []string, _ vif.Interface) error {
 			namespaces := make(map[string]struct{})
 			search := make([]string, 0)
 			for _, path := range paths {
@@ -258,6 +347,11 @@ func (s *Server) dnsListeners(c context.Context) ([]net.PacketConn, error) {
 			dlog.Infof(c, "listening to docker bridge at %s", dockerGatewayIP)
 			return append(listeners, ls), nil
 		}
+		if configuredDNS(c).LocalPort != 0 {
+			// The port is fixed by configuration so there's no point in trying other ports.
+			dlog.Infof(c, "not listening on docker bridge: %v", err)
+			return listeners, nil
+		}
 
 		// the extraAddr was busy, try next available port
 		for localAddr.Port++; localAddr.Port <= math.MaxUint16; localAddr.Port++ {
@@ -277,6 +371,22 @@ func (s *Server) dnsListeners(c context.Context) ([]net.PacketConn, error) {
 	}
 }
 
+// SystemWide returns true if the DNS configuration of a session that uses the given network namespace applies to
+// the whole system, i.e. when the overriding resolver is used. A sandbox has a resolv.conf of its own, and
+// systemd-resolved scopes the configuration to the TUN device.
+func SystemWide(c context.Context, netns string) bool {
+	if netns != "" {
+		return false
+	}
+	switch configuredDNS(c).Resolver {
+	case client.DNSResolverSystemdResolved:
+		return false
+	case client.DNSResolverOverriding:
+		return true
+	}
+	return runningInDocker() || !dbus.IsResolveDRunning(c)
+}
+
 func runningInDocker() bool {
 	_, err := os.Stat("/.dockerenv")
 	return err == nil
@@ -339,3 +449,14 @@ func unrouteDNS(c context.Context) {
 	_ = runNatTableCmd(c, "-F", tpDNSChain)
 	_ = runNatTableCmd(c, "-X", tpDNSChain)
 }
+
+func takeSnapshot(_ context.Context) (*Snapshot, error) {
+	// The DNS configuration of systemd-resolved is bound to the TUN device and vanishes with it, so the only
+	// modification that must be restored is the iptables chain of the overriding resolver.
+	return &Snapshot{}, nil
+}
+
+func (s *Snapshot) restore(ctx context.Context) error {
+	unrouteDNS(ctx)
+	return nil
+}
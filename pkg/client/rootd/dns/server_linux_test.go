@@ -0,0 +1,134 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/fake"
+)
+
+// sandboxRecorder records the changes that the sandbox server makes to its network namespace.
+type sandboxRecorder struct {
+	sync.Mutex
+	routes  []string
+	search  [][]string
+	written chan struct{}
+}
+
+func recordSandbox(t *testing.T) *sandboxRecorder {
+	r := &sandboxRecorder{written: make(chan struct{}, 10)}
+	origRoute, origWrite := routeInNetNamespace, writeNetNamespaceResolvConf
+	t.Cleanup(func() {
+		routeInNetNamespace, writeNetNamespaceResolvConf = origRoute, origWrite
+	})
+	routeInNetNamespace = func(_ context.Context, netns string, subnet *net.IPNet, dev string) error {
+		r.Lock()
+		r.routes = append(r.routes, netns+" "+subnet.String()+" "+dev)
+		r.Unlock()
+		return nil
+	}
+	writeNetNamespaceResolvConf = func(netns string, server net.IP, search []string) error {
+		assert.Equal(t, "tel", netns)
+		assert.Equal(t, "10.0.0.53", server.String())
+		r.Lock()
+		r.search = append(r.search, search)
+		r.Unlock()
+		r.written <- struct{}{}
+		return nil
+	}
+	return r
+}
+
+func TestServer_runSandboxServer(t *testing.T) {
+	r := recordSandbox(t)
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	s := NewServer(&rpc.DNSConfig{RemoteIp: net.IP{10, 0, 0, 53}}, func(_ context.Context, name string) ([][]byte, error) {
+		if name == "echo.default.svc.cluster.local" {
+			return [][]byte{{10, 96, 0, 10}}, nil
+		}
+		return nil, nil
+	})
+	s.SetNetNamespace("tel")
+	dev := fake.NewDevice("tel0", 1)
+
+	type dnsConfig struct {
+		ip   net.IP
+		addr *net.UDPAddr
+	}
+	configured := make(chan dnsConfig, 2)
+	workerDone := make(chan error, 1)
+	go func() {
+		workerDone <- s.Worker(ctx, dev, func(ip net.IP, addr *net.UDPAddr) {
+			configured <- dnsConfig{ip, addr}
+		})
+	}()
+
+	var cfg dnsConfig
+	select {
+	case cfg = <-configured:
+	case err := <-workerDone:
+		t.Fatalf("worker ended with %v", err)
+	}
+	<-r.written
+	assert.Equal(t, "10.0.0.53", cfg.ip.String())
+	assert.True(t, cfg.addr.IP.IsLoopback())
+	r.Lock()
+	assert.Equal(t, []string{"tel 10.0.0.53/32 tel0"}, r.routes)
+	assert.Equal(t, [][]string{nil}, r.search)
+	r.Unlock()
+
+	// The TUN device passes the queries for the DNS IP on to the server's address
+	q := new(dns.Msg)
+	q.SetQuestion("echo.default.svc.cluster.local.", dns.TypeA)
+	var reply *dns.Msg
+	require.Eventually(t, func() bool {
+		var err error
+		reply, err = dns.Exchange(q, cfg.addr.String())
+		return err == nil
+	}, 5*time.Second, 50*time.Millisecond)
+	require.Len(t, reply.Answer, 1)
+	assert.Equal(t, "10.96.0.10", reply.Answer[0].(*dns.A).A.String())
+
+	// The search path of the namespace follows the one of the session
+	s.SetSearchPath(ctx, nil, []string{"default"})
+	select {
+	case <-r.written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the search path wasn't written")
+	}
+	r.Lock()
+	assert.Equal(t, []string{"default.svc.cluster.local."}, r.search[1])
+	r.Unlock()
+
+	cancel()
+	select {
+	case err := <-workerDone:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the worker didn't end")
+	}
+	cfg = <-configured
+	assert.Nil(t, cfg.ip, "the DNS configuration wasn't reset")
+}
+
+func TestServer_runSandboxServer_noDNSIP(t *testing.T) {
+	r := recordSandbox(t)
+	s := NewServer(&rpc.DNSConfig{}, nil)
+	s.SetNetNamespace("tel")
+	err := s.Worker(dlog.NewTestContext(t, false), fake.NewDevice("tel0", 1), func(net.IP, *net.UDPAddr) {
+		t.Error("DNS configured without a DNS IP")
+	})
+	assert.Error(t, err)
+	assert.Empty(t, r.routes)
+}
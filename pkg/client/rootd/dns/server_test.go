@@ -0,0 +1,141 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dgroup"
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif"
+)
+
+type captureWriter struct {
+	dns.ResponseWriter
+	remote net.Addr
+	msg    *dns.Msg
+}
+
+func (w *captureWriter) RemoteAddr() net.Addr {
+	return w.remote
+}
+
+func (w *captureWriter) WriteMsg(msg *dns.Msg) error {
+	w.msg = msg
+	return nil
+}
+
+func Test_writeReply(t *testing.T) {
+	bigReply := func(r *dns.Msg) *dns.Msg {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		for i := 0; i < 60; i++ {
+			msg.Answer = append(msg.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: dnsTTL},
+				Txt: []string{fmt.Sprintf("v=DKIM1; k=rsa; p=%064d", i)},
+			})
+		}
+		return msg
+	}
+	udp := &net.UDPAddr{IP: net.IP{127, 0, 0, 1}, Port: 5353}
+	tcp := &net.TCPAddr{IP: net.IP{127, 0, 0, 1}, Port: 5353}
+
+	t.Run("truncated over UDP", func(t *testing.T) {
+		r := new(dns.Msg)
+		r.SetQuestion("selector._domainkey.example.org.", dns.TypeTXT)
+		w := &captureWriter{remote: udp}
+		require.NoError(t, writeReply(w, r, bigReply(r)))
+		assert.True(t, w.msg.Truncated)
+		assert.LessOrEqual(t, w.msg.Len(), dns.MinMsgSize)
+		assert.Nil(t, w.msg.IsEdns0())
+	})
+
+	t.Run("EDNS0 over UDP", func(t *testing.T) {
+		r := new(dns.Msg)
+		r.SetQuestion("selector._domainkey.example.org.", dns.TypeTXT)
+		r.SetEdns0(4096, true)
+		w := &captureWriter{remote: udp}
+		require.NoError(t, writeReply(w, r, bigReply(r)))
+		assert.True(t, w.msg.Truncated)
+		assert.LessOrEqual(t, w.msg.Len(), 4096)
+		opt := w.msg.IsEdns0()
+		require.NotNil(t, opt)
+		assert.Equal(t, uint16(maxUDPSize), opt.UDPSize())
+		assert.True(t, opt.Do())
+	})
+
+	t.Run("not truncated over TCP", func(t *testing.T) {
+		r := new(dns.Msg)
+		r.SetQuestion("selector._domainkey.example.org.", dns.TypeTXT)
+		w := &captureWriter{remote: tcp}
+		require.NoError(t, writeReply(w, r, bigReply(r)))
+		assert.False(t, w.msg.Truncated)
+		assert.Len(t, w.msg.Answer, 60)
+	})
+}
+
+func TestServer_GetConfig(t *testing.T) {
+	s := &Server{}
+	assert.Empty(t, s.GetConfig().SearchPath)
+
+	s.search = []string{"intercepted.svc.cluster.local.", "default.svc.cluster.local."}
+	assert.Equal(t, []string{"intercepted.svc.cluster.local", "default.svc.cluster.local"}, s.GetConfig().SearchPath)
+}
+
+func TestServer_SetOverrides(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	s := NewServer(&rpc.DNSConfig{IncludeSuffixes: []string{".corp"}}, nil)
+	s.SetOverrides(map[string]string{"db.test": "10.0.0.1"})
+
+	type update struct {
+		paths    []string
+		suffixes []string
+	}
+	updates := make(chan update, 5)
+	g := dgroup.NewGroup(ctx, dgroup.GroupConfig{})
+	s.processSearchPaths(g, func(_ context.Context, paths []string, _ vif.Interface) error {
+		updates <- update{paths: paths, suffixes: s.routedSuffixes()}
+		return nil
+	}, nil)
+	next := func() update {
+		select {
+		case u := <-updates:
+			return u
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "the search paths weren't processed")
+			return update{}
+		}
+	}
+
+	s.SetSearchPath(ctx, []string{"default"}, nil)
+	assert.Equal(t, update{paths: []string{"default"}, suffixes: []string{".corp", "db.test"}}, next())
+
+	// The same overrides don't update the routes, but their answers are flushed from the cache when they change
+	s.SetOverrides(map[string]string{"db.test": "10.0.0.1"})
+	s.cache.Store("db.test.", &cacheEntry{})
+	s.SetOverrides(map[string]string{"db.test": "10.0.0.2"})
+	_, cached := s.cache.Load("db.test.")
+	assert.False(t, cached)
+	ips, err := s.resolveName("db.test.")
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("10.0.0.2")}, ips)
+	assert.Empty(t, updates)
+
+	// Routes are updated for the domains of new overrides, using the current search paths
+	s.SetOverrides(map[string]string{"*.example": "10.0.0.3"})
+	assert.Equal(t, update{paths: []string{"default"}, suffixes: []string{".corp", "example"}}, next())
+	ips, err = s.resolveName("web.example.")
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("10.0.0.3")}, ips)
+
+	cancel()
+	assert.NoError(t, g.Wait())
+}
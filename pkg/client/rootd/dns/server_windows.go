@@ -5,12 +5,36 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"time"
 
+	"github.com/datawire/dlib/dcontext"
 	"github.com/datawire/dlib/dgroup"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/proc"
 	"github.com/telepresenceio/telepresence/v2/pkg/vif"
 )
 
-func (s *Server) Worker(c context.Context, dev *vif.Device, configureDNS func(net.IP, *net.UDPAddr)) error {
+// nrptComment is used as the comment of all NRPT rules that are added by Telepresence, so that
+// they can be found and removed.
+const nrptComment = "telepresence"
+
+// SystemWide returns true if the NRPT resolver is used, because the NRPT rules apply to the whole system. The
+// interface resolver scopes the configuration to the TUN device.
+func SystemWide(c context.Context, _ string) bool {
+	return configuredDNS(c).Resolver == client.DNSResolverNRPT
+}
+
+func (s *Server) Worker(c context.Context, dev vif.Interface, configureDNS func(net.IP, *net.UDPAddr)) error {
+	useNRPT := false
+	switch resolver := configuredDNS(c).Resolver; resolver {
+	case client.DNSResolverAuto, client.DNSResolverInterface:
+	case client.DNSResolverNRPT:
+		useNRPT = true
+	default:
+		dlog.Warningf(c, "DNS resolver %q is not supported on windows, using %q", resolver, client.DNSResolverInterface)
+	}
+
 	listener, err := newLocalUDPListener(c)
 	if err != nil {
 		return err
@@ -21,17 +45,34 @@ func (s *Server) Worker(c context.Context, dev *vif.Device, configureDNS func(ne
 	}
 	configureDNS(s.config.RemoteIp, dnsAddr)
 
+	updateDNS := s.updateRouterDNS
+	if useNRPT {
+		updateDNS = func(c context.Context, paths []string, dev vif.Interface) error {
+			if err := s.updateRouterDNS(c, paths, dev); err != nil {
+				return err
+			}
+			return s.updateNRPTRules(c)
+		}
+		defer func() {
+			c, cancel := context.WithTimeout(dcontext.WithoutCancel(c), 10*time.Second)
+			defer cancel()
+			if err := removeNRPTRules(c); err != nil {
+				dlog.Error(c, err)
+			}
+		}()
+	}
+
 	// Start local DNS server
 	g := dgroup.NewGroup(c, dgroup.GroupConfig{})
 	g.Go("Server", func(c context.Context) error {
 		// No need to close listener. It's closed by the dns server.
-		s.processSearchPaths(g, s.updateRouterDNS, dev)
+		s.processSearchPaths(g, updateDNS, dev)
 		return s.Run(c, make(chan struct{}), []net.PacketConn{listener}, nil, s.resolveInCluster)
 	})
 	return g.Wait()
 }
 
-func (s *Server) updateRouterDNS(c context.Context, paths []string, dev *vif.Device) error {
+func (s *Server) updateRouterDNS(c context.Context, paths []string, dev vif.Interface) error {
 	namespaces := make(map[string]struct{})
 	search := make([]string, 0)
 	for _, path := range paths {
@@ -53,3 +94,62 @@ func (s *Server) updateRouterDNS(c context.Context, paths []string, dev *vif.Dev
 	}
 	return nil
 }
+
+// updateNRPTRules replaces the Name Resolution Policy Table rules added by Telepresence with rules that
+// direct queries for the cluster domain, the mapped namespaces, and the included suffixes to the DNS
+// server of the TUN-device.
+func (s *Server) updateNRPTRules(c context.Context) error {
+	s.domainsLock.RLock()
+	nss := make([]string, 0, len(s.namespaces)+len(s.config.IncludeSuffixes)+1)
+	nss = append(nss, psQuote("."+strings.TrimSuffix(s.clusterDomain, ".")))
+	for ns := range s.namespaces {
+		nss = append(nss, psQuote("."+ns))
+	}
+	s.domainsLock.RUnlock()
+	for _, sfx := range s.routedSuffixes() {
+		nss = append(nss, psQuote("."+strings.TrimPrefix(sfx, ".")))
+	}
+	pshScript := fmt.Sprintf(`
+Get-DnsClientNrptRule | Where-Object Comment -eq %s | Remove-DnsClientNrptRule -Force
+Add-DnsClientNrptRule -Namespace %s -NameServers %s -Comment %s
+`, psQuote(nrptComment), strings.Join(nss, ","), psQuote(net.IP(s.config.RemoteIp).String()), psQuote(nrptComment))
+	dlog.Debugf(c, "Setting NRPT rules for %s", strings.Join(nss, ","))
+	if err := runPowershell(c, pshScript); err != nil {
+		return fmt.Errorf("failed to set NRPT rules: %w", err)
+	}
+	s.flushDNS()
+	return nil
+}
+
+// removeNRPTRules removes all Name Resolution Policy Table rules that were added by Telepresence.
+func removeNRPTRules(c context.Context) error {
+	pshScript := fmt.Sprintf(`
+Get-DnsClientNrptRule | Where-Object Comment -eq %s | Remove-DnsClientNrptRule -Force
+`, psQuote(nrptComment))
+	dlog.Debug(c, "Removing NRPT rules")
+	if err := runPowershell(c, pshScript); err != nil {
+		return fmt.Errorf("failed to remove NRPT rules: %w", err)
+	}
+	return nil
+}
+
+func runPowershell(c context.Context, pshScript string) error {
+	cmd := proc.CommandContext(c, "powershell.exe", "-NoProfile", "-NonInteractive", pshScript)
+	cmd.DisableLogging = true // disable chatty logging
+	return cmd.Run()
+}
+
+// psQuote quotes the given string as a single-quoted powershell string to prevent powershell injection.
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func takeSnapshot(_ context.Context) (*Snapshot, error) {
+	// The DNS configuration of the interface vanishes with the TUN device, so the only modifications that must be
+	// restored are the NRPT rules.
+	return &Snapshot{}, nil
+}
+
+func (s *Snapshot) restore(ctx context.Context) error {
+	return removeNRPTRules(ctx)
+}
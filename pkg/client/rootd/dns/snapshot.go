@@ -0,0 +1,41 @@
+package dns
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// Snapshot is the part of the DNS configuration of the machine that the DNS server modifies, as it was before the
+// modification.
+type Snapshot struct {
+	// Files are the contents of configuration files, keyed by file name.
+	Files map[string]string `json:"files,omitempty"`
+}
+
+// TakeSnapshot returns a Snapshot of the current DNS configuration of the machine.
+func TakeSnapshot(ctx context.Context) (*Snapshot, error) {
+	return takeSnapshot(ctx)
+}
+
+// Restore restores the DNS configuration of the machine to the state of the snapshot, removing the modifications
+// that a DNS server that didn't get a chance to clean up made.
+func (s *Snapshot) Restore(ctx context.Context) error {
+	return s.restore(ctx)
+}
+
+// filesIn returns the files of the snapshot that are in the given directory and have names with the given prefix.
+// The other files are never restored, however they ended up in the snapshot.
+func (s *Snapshot) filesIn(ctx context.Context, dir, prefix string) map[string]string {
+	files := make(map[string]string, len(s.Files))
+	for file, data := range s.Files {
+		if filepath.Clean(file) != file || filepath.Dir(file) != dir || !strings.HasPrefix(filepath.Base(file), prefix) {
+			dlog.Errorf(ctx, "Not restoring %s, because it isn't a %s file in %s", file, prefix+"*", dir)
+			continue
+		}
+		files[file] = data
+	}
+	return files
+}
@@ -0,0 +1,28 @@
+package dns
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+func TestSnapshot_filesIn(t *testing.T) {
+	dir := filepath.Join("/etc", "resolver")
+	s := &Snapshot{Files: map[string]string{
+		filepath.Join(dir, "telepresence.local"):               "nameserver 127.0.0.1",
+		filepath.Join(dir, "telepresence.cluster.local"):       "nameserver 127.0.0.1",
+		filepath.Join(dir, "other.local"):                      "nameserver 10.0.0.1",
+		filepath.Join("/etc", "sudoers"):                       "ALL ALL=(ALL) NOPASSWD: ALL",
+		dir + "/../sudoers.d/telepresence.x":                   "ALL ALL=(ALL) NOPASSWD: ALL",
+		filepath.Join(dir, "sub", "telepresence.local"):        "nameserver 127.0.0.1",
+		filepath.Join("/etc", "resolver-evil", "telepresence"): "nameserver 127.0.0.1",
+	}}
+	files := s.filesIn(dlog.NewTestContext(t, false), dir, "telepresence.")
+	assert.Equal(t, map[string]string{
+		filepath.Join(dir, "telepresence.local"):         "nameserver 127.0.0.1",
+		filepath.Join(dir, "telepresence.cluster.local"): "nameserver 127.0.0.1",
+	}, files)
+}
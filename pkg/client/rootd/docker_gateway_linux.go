@@ -0,0 +1,137 @@
+package rootd
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/datawire/dlib/dcontext"
+	"github.com/datawire/dlib/dexec"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// listenDockerDNS opens the listener of the DNS forwarder for local Docker containers on port 53 of the gateway IP
+// of the default Docker bridge network. Containers on any bridge network can reach that IP.
+func listenDockerDNS(c context.Context) (net.PacketConn, error) {
+	ip, err := dockerBridgeIP(c)
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.ListenPacket("udp", net.JoinHostPort(ip.String(), "53"))
+	if err != nil {
+		return nil, errcat.User.Newf("unable to serve DNS to Docker containers on %s: %v", ip, err)
+	}
+	return l, nil
+}
+
+func dockerBridgeIP(c context.Context) (net.IP, error) {
+	output, err := dexec.CommandContext(c, "docker", "inspect", "bridge",
+		"-f", "{{(index .IPAM.Config 0).Gateway}}").Output()
+	if err != nil {
+		return nil, errcat.User.Newf("unable to find the Docker bridge network: %v", err)
+	}
+	ifAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	return dockerGatewayIP(string(output), ifAddrs)
+}
+
+// dockerGatewayIP parses the gateway that "docker inspect" printed and verifies that it's the address of one of
+// the given interface addresses.
+func dockerGatewayIP(output string, ifAddrs []net.Addr) (net.IP, error) {
+	ip := net.ParseIP(strings.TrimSpace(output))
+	if ip == nil {
+		return nil, errcat.User.Newf("unable to parse the Docker bridge gateway %q", strings.TrimSpace(output))
+	}
+
+	// When running WSL2 on a Windows box, or when Docker runs in a VM, the gateway is never visible to the
+	// host and the containers' traffic will not pass through it.
+	for _, ifAddr := range ifAddrs {
+		if ifIP, _, err := net.ParseCIDR(ifAddr.String()); err == nil && ifIP.Equal(ip) {
+			return ip, nil
+		}
+	}
+	return nil, errcat.User.Newf("the Docker bridge gateway %s is not a network interface on this host", ip)
+}
+
+// dockerGatewayRules are the iptables rules that let local Docker containers reach the cluster through the
+// TUN-device. Docker drops forwarded packets that it doesn't know about, so the DOCKER-USER chain must accept
+// them, and they must be masqueraded so that the replies find their way back.
+func dockerGatewayRules(tunName string) [][]string {
+	return [][]string{
+		{"DOCKER-USER", "-o", tunName, "-j", "ACCEPT"},
+		{"DOCKER-USER", "-i", tunName, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
+		{"-t", "nat", "POSTROUTING", "-o", tunName, "-j", "MASQUERADE"},
+	}
+}
+
+func runIPTablesCmd(c context.Context, op string, rule []string) error {
+	return dexec.CommandContext(c, "iptables", iptablesArgs(op, rule)...).Run()
+}
+
+// iptablesArgs returns the arguments of the iptables command that performs the given operation on the given
+// rule. A table selection that starts the rule must precede the operation.
+func iptablesArgs(op string, rule []string) []string {
+	args := make([]string, 0, len(rule)+1)
+	if rule[0] == "-t" {
+		args = append(args, rule[:2]...)
+		rule = rule[2:]
+	}
+	args = append(args, op)
+	return append(args, rule...)
+}
+
+// dockerGatewayWorker installs the iptables rules that bridge the local Docker containers to the cluster and
+// serves DNS to the containers on the Docker bridge until the context is cancelled. The rules are removed
+// when it returns.
+func (s *session) dockerGatewayWorker(c context.Context) error {
+	// We specifically don't want to use the cancellation of 'c' when removing the rules, because we
+	// don't ever want to leave things in a half-cleaned-up state.
+	hc := dcontext.WithoutCancel(c)
+	rules := dockerGatewayRules(s.dev.Name())
+	defer func() {
+		for _, rule := range rules {
+			if err := runIPTablesCmd(hc, "-D", rule); err != nil {
+				dlog.Errorf(c, "failed to remove iptables rule %v: %v", rule, err)
+			}
+		}
+	}()
+	for i, rule := range rules {
+		if err := runIPTablesCmd(hc, "-I", rule); err != nil {
+			rules = rules[:i]
+			return errcat.User.Newf("failed to bridge Docker containers to the cluster: %v", err)
+		}
+	}
+	dlog.Infof(c, "Docker containers are bridged to the cluster, serving DNS on %s", s.dockerDNS.LocalAddr())
+
+	upstream := "127.0.0.53:53"
+	if cfg, err := dns.ClientConfigFromFile("/etc/resolv.conf"); err == nil && len(cfg.Servers) > 0 {
+		upstream = net.JoinHostPort(cfg.Servers[0], cfg.Port)
+	}
+	dc := &dns.Client{Net: "udp", Timeout: 4 * time.Second}
+	srv := &dns.Server{
+		PacketConn: s.dockerDNS,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, q *dns.Msg) {
+			// The host's resolver knows about the cluster domains, so the containers get the same answers as
+			// processes on the host.
+			r, _, err := dc.ExchangeContext(c, q, upstream)
+			if err != nil {
+				dlog.Debugf(c, "docker DNS forward of %v failed: %v", q.Question, err)
+				r = new(dns.Msg)
+				r.SetRcode(q, dns.RcodeServerFailure)
+			}
+			_ = w.WriteMsg(r)
+		}),
+		ReadTimeout: time.Second,
+	}
+	go func() {
+		<-c.Done()
+		_ = srv.ShutdownContext(hc)
+	}()
+	return srv.ActivateAndServe()
+}
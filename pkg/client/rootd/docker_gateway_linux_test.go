@@ -0,0 +1,62 @@
+package rootd
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_dockerGatewayIP(t *testing.T) {
+	ifAddrs := func(cidrs ...string) []net.Addr {
+		addrs := make([]net.Addr, len(cidrs))
+		for i, cidr := range cidrs {
+			ip, ipNet, err := net.ParseCIDR(cidr)
+			require.NoError(t, err)
+			ipNet.IP = ip
+			addrs[i] = ipNet
+		}
+		return addrs
+	}
+	tests := []struct {
+		name    string
+		output  string
+		ifAddrs []net.Addr
+		want    string
+		wantErr string
+	}{
+		{"bridge interface", "172.17.0.1\n", ifAddrs("127.0.0.1/8", "172.17.0.1/16"), "172.17.0.1", ""},
+		{"custom bridge subnet", "  10.200.0.1  \n", ifAddrs("10.200.0.1/24"), "10.200.0.1", ""},
+		{"IPv6 gateway", "fd00:dead::1\n", ifAddrs("fd00:dead::1/64"), "fd00:dead::1", ""},
+		{"no gateway", "\n", ifAddrs("172.17.0.1/16"), "", "unable to parse"},
+		{"template error", "<no value>\n", ifAddrs("172.17.0.1/16"), "", "unable to parse"},
+		{"docker in a VM", "172.17.0.1\n", ifAddrs("127.0.0.1/8", "192.168.65.3/24"), "", "is not a network interface"},
+		{"within an interface subnet", "172.17.0.1\n", ifAddrs("172.17.0.2/16"), "", "is not a network interface"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip, err := dockerGatewayIP(tt.output, tt.ifAddrs)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, ip.String())
+		})
+	}
+}
+
+func Test_iptablesArgs(t *testing.T) {
+	rules := dockerGatewayRules("tel0")
+	require.Len(t, rules, 3)
+	assert.Equal(t,
+		[]string{"-I", "DOCKER-USER", "-o", "tel0", "-j", "ACCEPT"},
+		iptablesArgs("-I", rules[0]))
+	assert.Equal(t,
+		[]string{"-D", "DOCKER-USER", "-i", "tel0", "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
+		iptablesArgs("-D", rules[1]))
+	assert.Equal(t,
+		[]string{"-t", "nat", "-I", "POSTROUTING", "-o", "tel0", "-j", "MASQUERADE"},
+		iptablesArgs("-I", rules[2]))
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package rootd
+
+import (
+	"context"
+	"net"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+func listenDockerDNS(context.Context) (net.PacketConn, error) {
+	return nil, errcat.User.New("--allow-docker is only supported on Linux, where Docker containers share the host's network stack")
+}
+
+func (s *session) dockerGatewayWorker(context.Context) error {
+	return nil
+}
@@ -2,9 +2,11 @@ package rootd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"sync/atomic"
+	"time"
 
 	"golang.org/x/net/ipv4"
 
@@ -44,6 +46,7 @@ func (s *session) routerWorker(c context.Context) error {
 			return fmt.Errorf("read packet error: %w", err)
 		}
 		if n > 0 {
+			atomic.AddInt64(&s.packets.received, 1)
 			bufCh <- buffer.DataPool.Copy(buf, n)
 		}
 	}
@@ -67,12 +70,15 @@ func (s *session) handlePacket(c context.Context, data *buffer.Data) {
 		_, err := s.dev.WritePacket(pkt.Data(), 0)
 		if err != nil {
 			dlog.Errorf(c, "TUN write failed: %v", err)
+		} else {
+			atomic.AddInt64(&s.packets.sent, 1)
 		}
 	}
 
 	ipHdr, err := ip.ParseHeader(data.Buf())
 	if err != nil {
 		dlog.Error(c, "Unable to parse packet header")
+		s.packets.drop()
 		return
 	}
 
@@ -96,34 +102,64 @@ func (s *session) handlePacket(c context.Context, data *buffer.Data) {
 		dst := ipHdr.Destination()
 		if !dst.IsGlobalUnicast() {
 			// Just ignore at this point.
+			s.packets.drop()
 			return
 		}
 		if ip4 := dst.To4(); ip4 != nil && ip4[2] == 0 && ip4[3] == 0 {
 			// Write to the subnet's zero address. Not sure why this is happening but
 			// there's no point in passing them on.
+			s.packets.drop()
 			reply(icmp.DestinationUnreachablePacket(ipHdr, icmp.HostUnreachable))
 			return
 		}
 		dg := udp.DatagramFromData(ipHdr, data)
 		if blockedUDPPorts[dg.Header().SourcePort()] || blockedUDPPorts[dg.Header().DestinationPort()] {
+			s.packets.drop()
 			reply(icmp.DestinationUnreachablePacket(ipHdr, icmp.PortUnreachable))
 			return
 		}
 		data = nil
 		s.udp(c, dg)
 	case ipproto.ICMP:
+		s.packets.drop()
 	case ipproto.ICMPV6:
+		s.packets.drop()
 		pkt := icmp.PacketFromData(ipHdr, data)
 		dlog.Tracef(c, "<- TUN %s", pkt)
 	default:
 		// An L4 protocol that we don't handle.
+		s.packets.drop()
 		dlog.Tracef(c, "Unhandled protocol %d", ipHdr.L4Protocol())
 		reply(icmp.DestinationUnreachablePacket(ipHdr, icmp.ProtocolUnreachable))
 	}
 }
 
+// packetCounters are the counters of the packets that pass through the TUN device.
+type packetCounters struct {
+	// received is the number of packets read from the TUN device
+	received int64
+
+	// forwarded is the number of received packets that were passed on to a connection handler
+	forwarded int64
+
+	// dropped is the number of received packets that were discarded
+	dropped int64
+
+	// sent is the number of packets written to the TUN device
+	sent int64
+}
+
+func (p *packetCounters) forward() {
+	atomic.AddInt64(&p.forwarded, 1)
+}
+
+func (p *packetCounters) drop() {
+	atomic.AddInt64(&p.dropped, 1)
+}
+
 type vifWriter struct {
-	*vif.Device
+	vif.Interface
+	packets *packetCounters
 }
 
 func (w vifWriter) Write(ctx context.Context, pkt ip.Packet) (err error) {
@@ -138,6 +174,7 @@ func (w vifWriter) Write(ctx context.Context, pkt ip.Packet) (err error) {
 		}
 		l -= n
 		if l == 0 {
+			atomic.AddInt64(&w.packets.sent, 1)
 			return nil
 		}
 		o += n
@@ -157,8 +194,10 @@ func (s *session) tcp(c context.Context, pkt tcp.Packet) {
 		// Only a SYN packet can create a new connection. For all other packets, the connection must already exist
 		wf := s.handlers.Get(connID)
 		if wf == nil {
+			s.packets.drop()
 			pkt.Release()
 		} else {
+			s.packets.forward()
 			wf.(tcp.PacketHandler).HandlePacket(c, pkt)
 		}
 		return
@@ -167,21 +206,68 @@ func (s *session) tcp(c context.Context, pkt tcp.Packet) {
 	if s.isForDNS(ipHdr.Destination(), tcpHdr.DestinationPort()) {
 		// Ignore TCP packets intended for the DNS resolver for now
 		// TODO: Add support to DNS over TCP. The github.com/miekg/dns can do that.
+		s.packets.drop()
+		pkt.Release()
+		return
+	}
+
+	if s.handlers.Get(connID) == nil && s.tcpLimitReached(c) {
+		// Drop the SYN. The peer will retransmit it with an increasing delay, which holds new connections back
+		// until existing ones have been closed.
+		s.packets.drop()
 		pkt.Release()
 		return
 	}
 
 	wf, _, err := s.handlers.GetOrCreate(c, connID, func(c context.Context, remove func()) (tunnel.Handler, error) {
-		return tcp.NewHandler(s.streamCreator(connID), &s.closing, vifWriter{s.dev}, connID, remove, s.rndSource), nil
+		atomic.AddInt32(&s.tcpConnections, 1)
+		release := func() {
+			atomic.AddInt32(&s.tcpConnections, -1)
+			remove()
+		}
+		return tcp.NewHandler(s.streamCreator(connID), &s.closing, vifWriter{Interface: s.dev, packets: &s.packets}, connID, s.mtu, release, s.rndSource), nil
 	})
 	if err != nil {
-		dlog.Error(c, err)
+		if errors.Is(err, tunnel.ErrPoolFull) {
+			s.logLimit(c, "the connection-tracking table is full, new connections and UDP flows are dropped")
+		} else {
+			dlog.Error(c, err)
+		}
+		s.packets.drop()
 		pkt.Release()
 		return
 	}
 	// if wf is nil, the packet should simply be ignored
 	if wf != nil {
+		s.packets.forward()
 		wf.(tcp.PacketHandler).HandlePacket(c, pkt)
+	} else {
+		s.packets.drop()
+	}
+}
+
+// tcpLimitLogInterval is the minimum time between two log messages about the limits of the connection-tracking table.
+const tcpLimitLogInterval = 10 * time.Second
+
+// tcpLimitReached returns true when the number of active TCP connections has reached the configured limit.
+func (s *session) tcpLimitReached(c context.Context) bool {
+	limit := client.GetConfig(c).Tunnel.MaxConnections
+	if limit <= 0 {
+		return false
+	}
+	count := atomic.LoadInt32(&s.tcpConnections)
+	if int(count) < limit {
+		return false
+	}
+	s.logLimit(c, fmt.Sprintf("the number of concurrent TCP connections has reached the limit of %d, new connections are held back", limit))
+	return true
+}
+
+// logLimit logs the given message as a warning, unless a limit was logged less than tcpLimitLogInterval ago.
+func (s *session) logLimit(c context.Context, msg string) {
+	if now := time.Now(); now.Sub(s.limitLogged) > tcpLimitLogInterval {
+		s.limitLogged = now
+		dlog.Warn(c, msg)
 	}
 }
 
@@ -190,7 +276,7 @@ func (s *session) udp(c context.Context, dg udp.Datagram) {
 	udpHdr := dg.Header()
 	connID := tunnel.NewConnID(ipproto.UDP, ipHdr.Source(), ipHdr.Destination(), udpHdr.SourcePort(), udpHdr.DestinationPort())
 	uh, _, err := s.handlers.GetOrCreate(c, connID, func(c context.Context, remove func()) (tunnel.Handler, error) {
-		w := vifWriter{s.dev}
+		w := vifWriter{Interface: s.dev, packets: &s.packets}
 		if s.isForDNS(ipHdr.Destination(), udpHdr.DestinationPort()) {
 			return udp.NewDnsInterceptor(w, connID, remove, s.dnsLocalAddr)
 		}
@@ -201,20 +287,27 @@ func (s *session) udp(c context.Context, dg udp.Datagram) {
 		return udp.NewHandler(stream, w, connID, remove), nil
 	})
 	if err != nil {
-		dlog.Error(c, err)
+		if errors.Is(err, tunnel.ErrPoolFull) {
+			s.logLimit(c, "the connection-tracking table is full, new connections and UDP flows are dropped")
+		} else {
+			dlog.Error(c, err)
+		}
+		s.packets.drop()
+		dg.Release()
 		return
 	}
+	s.packets.forward()
 	uh.(udp.DatagramHandler).HandleDatagram(c, dg)
 }
 
 func (s *session) streamCreator(id tunnel.ConnID) tcp.StreamCreator {
 	return func(c context.Context) (tunnel.Stream, error) {
 		dlog.Debugf(c, "Opening tunnel for id %s", id)
-		ct, err := s.managerClient.Tunnel(c)
+		ct, err := s.tunnelClient.Tunnel(c)
 		if err != nil {
 			return nil, err
 		}
 		tc := client.GetConfig(c).Timeouts
-		return tunnel.NewClientStream(c, ct, id, s.session.SessionId, tc.Get(client.TimeoutRoundtripLatency), tc.Get(client.TimeoutEndpointDial))
+		return tunnel.NewClientStream(c, ct, id, s.session.SessionId, tc.Get(client.TimeoutRoundtripLatency), tc.Get(client.TimeoutEndpointDial), s.compression)
 	}
 }
@@ -0,0 +1,272 @@
+package rootd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/ipv4"
+	"google.golang.org/grpc"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/buffer"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/fake"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/icmp"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/ip"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/tcp"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/udp"
+)
+
+var (
+	localIP   = net.IP{10, 0, 0, 1}
+	clusterIP = net.IP{10, 96, 0, 10}
+)
+
+func testContext(t *testing.T, cfgFunc func(*client.Config)) context.Context {
+	cfg := client.GetDefaultConfig()
+	if cfgFunc != nil {
+		cfgFunc(&cfg)
+	}
+	return client.WithConfig(dlog.NewTestContext(t, false), &cfg)
+}
+
+func udpDatagram(src, dst net.IP, srcPort, dstPort uint16, payload []byte) *buffer.Data {
+	dg := udp.NewDatagram(udp.HeaderLen+len(payload), src, dst)
+	ipHdr := dg.IPHeader()
+	udpHdr := dg.Header()
+	udpHdr.SetSourcePort(srcPort)
+	udpHdr.SetDestinationPort(dstPort)
+	udpHdr.SetPayloadLen(uint16(len(payload)))
+	copy(udpHdr.Payload(), payload)
+	udpHdr.SetChecksum(ipHdr)
+	ipHdr.SetChecksum()
+	return dg.Data()
+}
+
+func tcpSYN(src, dst net.IP, srcPort, dstPort uint16) *buffer.Data {
+	pkt := tcp.NewPacket(tcp.HeaderLen, src, dst, false)
+	ipHdr := pkt.IPHeader()
+	ipHdr.SetL4Protocol(ipproto.TCP)
+	tcpHdr := pkt.Header()
+	tcpHdr.SetSourcePort(srcPort)
+	tcpHdr.SetDestinationPort(dstPort)
+	tcpHdr.SetDataOffset(tcp.HeaderLen / 4)
+	tcpHdr.SetSYN(true)
+	tcpHdr.SetWindowSize(0xffff)
+	tcpHdr.SetChecksum(ipHdr)
+	ipHdr.SetChecksum()
+	return pkt.Data()
+}
+
+func nextWritten(t *testing.T, dev *fake.Device) ip.Header {
+	select {
+	case pkt := <-dev.Written():
+		ipHdr, err := ip.ParseHeader(pkt)
+		require.NoError(t, err)
+		return ipHdr
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "no packet was written to the device")
+		return nil
+	}
+}
+
+func assertNothingWritten(t *testing.T, dev *fake.Device) {
+	select {
+	case <-dev.Written():
+		assert.Fail(t, "unexpected packet written to the device")
+	default:
+	}
+}
+
+func Test_handlePacket_blockedUDPPort(t *testing.T) {
+	ctx := testContext(t, nil)
+	dev := fake.NewDevice("tel0", 1)
+	s := testSession(dev)
+
+	s.handlePacket(ctx, udpDatagram(localIP, clusterIP, 54321, 137, []byte("netbios")))
+	ipHdr := nextWritten(t, dev)
+	require.Equal(t, ipproto.ICMP, ipHdr.L4Protocol())
+	icmpHdr := icmp.Header(ipHdr.Payload())
+	assert.Equal(t, int(ipv4.ICMPTypeDestinationUnreachable), icmpHdr.MessageType())
+	assert.Equal(t, int(icmp.PortUnreachable), icmpHdr.Code())
+	assert.Equal(t, int64(1), atomic.LoadInt64(&s.packets.dropped))
+	assert.Equal(t, int64(1), atomic.LoadInt64(&s.packets.sent))
+	assert.Equal(t, int64(0), s.handlers.Stats().Created)
+}
+
+func Test_handlePacket_unhandledProtocol(t *testing.T) {
+	ctx := testContext(t, nil)
+	dev := fake.NewDevice("tel0", 1)
+	s := testSession(dev)
+
+	pkt := icmp.NewPacket(icmp.HeaderLen, localIP, clusterIP)
+	pkt.IPHeader().SetL4Protocol(47) // GRE
+	pkt.IPHeader().SetChecksum()
+	s.handlePacket(ctx, pkt.Data())
+	ipHdr := nextWritten(t, dev)
+	require.Equal(t, ipproto.ICMP, ipHdr.L4Protocol())
+	assert.Equal(t, int(icmp.ProtocolUnreachable), icmp.Header(ipHdr.Payload()).Code())
+	assert.Equal(t, int64(1), atomic.LoadInt64(&s.packets.dropped))
+}
+
+func Test_handlePacket_tcpWithoutConnection(t *testing.T) {
+	ctx := testContext(t, nil)
+	dev := fake.NewDevice("tel0", 1)
+	s := testSession(dev)
+
+	data := tcpSYN(localIP, clusterIP, 54321, 80)
+	tcp.Header(ip.V4Header(data.Buf()).Payload()).SetSYN(false)
+	s.handlePacket(ctx, data)
+	assertNothingWritten(t, dev)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&s.packets.dropped))
+	assert.Equal(t, int64(0), s.handlers.Stats().Created)
+}
+
+func Test_handlePacket_tcpToDNS(t *testing.T) {
+	ctx := testContext(t, nil)
+	dev := fake.NewDevice("tel0", 1)
+	s := testSession(dev)
+	s.configureDNS(clusterIP, &net.UDPAddr{IP: net.IP{127, 0, 0, 1}, Port: 53})
+
+	s.handlePacket(ctx, tcpSYN(localIP, clusterIP, 54321, 53))
+	assertNothingWritten(t, dev)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&s.packets.dropped))
+	assert.Equal(t, int64(0), s.handlers.Stats().Created)
+}
+
+func Test_handlePacket_tcpLimit(t *testing.T) {
+	ctx := testContext(t, func(cfg *client.Config) {
+		cfg.Tunnel.MaxConnections = 1
+	})
+	dev := fake.NewDevice("tel0", 1)
+	s := testSession(dev)
+	s.tcpConnections = 1
+
+	// The SYN is dropped without creating a handler
+	s.handlePacket(ctx, tcpSYN(localIP, clusterIP, 54321, 80))
+	assertNothingWritten(t, dev)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&s.packets.dropped))
+	assert.Equal(t, int64(0), s.handlers.Stats().Created)
+}
+
+// failingTunnelClient is a manager.ManagerClient that can't open tunnels.
+type failingTunnelClient struct {
+	manager.ManagerClient
+}
+
+func (failingTunnelClient) Tunnel(context.Context, ...grpc.CallOption) (manager.Manager_TunnelClient, error) {
+	return nil, errors.New("no tunnel")
+}
+
+func Test_handlePacket_tcpLimitReleased(t *testing.T) {
+	ctx, cancel := context.WithCancel(testContext(t, func(cfg *client.Config) {
+		cfg.Tunnel.MaxConnections = 1
+	}))
+	defer cancel()
+	dev := fake.NewDevice("tel0", 1)
+	s := testSession(dev)
+	s.tunnelClient = failingTunnelClient{}
+
+	// The SYN creates a handler that replies with RST when the tunnel can't be opened
+	s.handlePacket(ctx, tcpSYN(localIP, clusterIP, 54321, 80))
+	assert.Equal(t, int64(1), s.handlers.Stats().Created)
+	assert.True(t, tcp.Header(nextWritten(t, dev).Payload()).RST())
+
+	// The connection no longer counts towards the limit when its handler is gone, so the next SYN gets a handler
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&s.tcpConnections) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	s.handlePacket(ctx, tcpSYN(localIP, clusterIP, 54322, 80))
+	assert.Equal(t, int64(2), s.handlers.Stats().Created)
+	assert.Equal(t, int64(0), atomic.LoadInt64(&s.packets.dropped))
+}
+
+func Test_tcpLimitReached(t *testing.T) {
+	s := testSession(fake.NewDevice("tel0", 1))
+	s.tcpConnections = 5
+	tests := []struct {
+		limit   int
+		reached bool
+	}{
+		{-1, false},
+		{0, false},
+		{6, false},
+		{5, true},
+		{1, true},
+	}
+	for _, tt := range tests {
+		ctx := testContext(t, func(cfg *client.Config) {
+			cfg.Tunnel.MaxConnections = tt.limit
+		})
+		assert.Equal(t, tt.reached, s.tcpLimitReached(ctx), "limit %d", tt.limit)
+	}
+}
+
+func Test_handlePacket_dns(t *testing.T) {
+	ctx, cancel := context.WithCancel(testContext(t, nil))
+	defer cancel()
+	dev := fake.NewDevice("tel0", 1)
+	s := testSession(dev)
+
+	// A local DNS service that echoes the queries that it receives
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IP{127, 0, 0, 1}})
+	require.NoError(t, err)
+	defer conn.Close()
+	go func() {
+		b := make([]byte, 0x400)
+		for {
+			n, addr, err := conn.ReadFromUDP(b)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteToUDP(b[:n], addr)
+		}
+	}()
+	s.configureDNS(clusterIP, conn.LocalAddr().(*net.UDPAddr))
+
+	s.handlePacket(ctx, udpDatagram(localIP, clusterIP, 54321, 53, []byte("query")))
+	ipHdr := nextWritten(t, dev)
+	require.Equal(t, ipproto.UDP, ipHdr.L4Protocol())
+	assert.True(t, clusterIP.Equal(ipHdr.Source()))
+	assert.True(t, localIP.Equal(ipHdr.Destination()))
+	udpHdr := udp.Header(ipHdr.Payload())
+	assert.Equal(t, uint16(53), udpHdr.SourcePort())
+	assert.Equal(t, uint16(54321), udpHdr.DestinationPort())
+	assert.Equal(t, "query", string(udpHdr.Payload()))
+	assert.Equal(t, int64(1), atomic.LoadInt64(&s.packets.forwarded))
+	assert.Equal(t, int64(1), atomic.LoadInt64(&s.packets.sent))
+}
+
+func Test_routerWorker(t *testing.T) {
+	ctx := testContext(t, nil)
+	dev := fake.NewDevice("tel0", 1)
+	s := testSession(dev)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.routerWorker(ctx)
+	}()
+	dev.Inject(udpDatagram(localIP, clusterIP, 54321, 138, nil).Buf())
+	ipHdr := nextWritten(t, dev)
+	assert.Equal(t, ipproto.ICMP, ipHdr.L4Protocol())
+
+	// The worker returns without an error when the device is closed during shutdown
+	atomic.StoreInt32(&s.closing, 2)
+	require.NoError(t, dev.Close())
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "router worker didn't return")
+	}
+	assert.Equal(t, int64(1), atomic.LoadInt64(&s.packets.received))
+	assert.Equal(t, int64(1), atomic.LoadInt64(&s.packets.dropped))
+}
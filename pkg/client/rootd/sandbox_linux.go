@@ -0,0 +1,202 @@
+package rootd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif"
+)
+
+// openSandboxTun creates the network namespace of a sandboxed session and opens the TUN device in it. The namespace
+// lasts until the device is closed by closeSandbox. It's a variable so that tests can replace it.
+var openSandboxTun = func(c context.Context, name string) (vif.Interface, error) {
+	if err := vif.CreateNetNamespace(c, name); err != nil {
+		return nil, err
+	}
+	dev, err := vif.OpenTunInNetNamespace(c, name)
+	if err != nil {
+		_ = vif.DeleteNetNamespace(c, name)
+		return nil, err
+	}
+	return dev, nil
+}
+
+// closeSandbox deletes the network namespace of a sandboxed session, which stops the processes that still run in it
+// from reaching the cluster. It's a variable so that tests can replace it.
+var closeSandbox = vif.DeleteNetNamespace
+
+// startInNetNamespace starts a command in a network namespace. It's a variable so that tests can replace it.
+var startInNetNamespace = vif.StartInNetNamespace
+
+type peerCredentialsKey struct{}
+
+// withPeerCredentials returns a context that holds the credentials of the process at the other end of the given
+// connection. It's used as the ConnContext of the gRPC server, so that the calls that are made over the connection
+// can be attributed to the user that made them.
+func withPeerCredentials(ctx context.Context, conn net.Conn) context.Context {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return ctx
+	}
+	var cred *unix.Ucred
+	if cerr := raw.Control(func(fd uintptr) {
+		cred, err = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); cerr != nil || err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, peerCredentialsKey{}, cred)
+}
+
+// RunInNetNamespace runs a command in the network namespace of the session that the call concerns. Only root can
+// enter the namespace, so the daemon starts the command, but with the identity of the caller. The identity is taken
+// from the credentials of the caller's connection, so no caller can run a command as another user.
+func (d *service) RunInNetNamespace(stream rpc.Daemon_RunInNetNamespaceServer) error {
+	ctx := stream.Context()
+	cred, ok := ctx.Value(peerCredentialsKey{}).(*unix.Ucred)
+	if !ok {
+		return status.Error(codes.PermissionDenied, "unable to determine the identity of the caller")
+	}
+	var netns string
+	if err := d.withSession(ctx, func(_ context.Context, s *session) error {
+		netns = s.netNamespace
+		return nil
+	}); err != nil {
+		return err
+	}
+	if netns == "" {
+		return status.Error(codes.FailedPrecondition, "the session has no network namespace")
+	}
+	in, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if len(in.Args) == 0 {
+		return status.Error(codes.InvalidArgument, "no command to run")
+	}
+
+	cmd := exec.Command(in.Args[0], in.Args[1:]...)
+	cmd.Env = in.Env
+	cmd.Dir = in.Dir
+	if int(cred.Uid) != os.Geteuid() {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{
+			Uid:    cred.Uid,
+			Gid:    cred.Gid,
+			Groups: groupsOf(cred.Uid, cred.Gid),
+		}}
+	}
+	sendLock := &sync.Mutex{}
+	cmd.Stdout = &outputWriter{stream: stream, lock: sendLock}
+	cmd.Stderr = &outputWriter{stream: stream, lock: sendLock, stderr: true}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	dlog.Debugf(ctx, "Running %s in network namespace %s as uid %d", in.Args[0], netns, cred.Uid)
+	if err = startInNetNamespace(netns, cmd); err != nil {
+		return status.Errorf(codes.FailedPrecondition, "failed to start %s in network namespace %s: %v", in.Args[0], netns, err)
+	}
+
+	go func() {
+		defer stdin.Close()
+		for {
+			in, err := stream.Recv()
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					// The caller is gone, and so is the one who uses the command.
+					_ = cmd.Process.Kill()
+				}
+				return
+			}
+			if len(in.Stdin) > 0 {
+				_, _ = stdin.Write(in.Stdin)
+			}
+			if in.CloseStdin {
+				_ = stdin.Close()
+			}
+			if in.Signal != 0 {
+				_ = cmd.Process.Signal(syscall.Signal(in.Signal))
+			}
+		}
+	}()
+
+	exitCode := 0
+	if err = cmd.Wait(); err != nil {
+		var ee *exec.ExitError
+		if !errors.As(err, &ee) {
+			return err
+		}
+		exitCode = exitCodeOf(ee.ProcessState)
+	}
+	sendLock.Lock()
+	defer sendLock.Unlock()
+	return stream.Send(&rpc.NetNamespaceOutput{Exited: true, ExitCode: int32(exitCode)})
+}
+
+// outputWriter sends what's written to it as the stdout or stderr of a NetNamespaceOutput.
+type outputWriter struct {
+	stream rpc.Daemon_RunInNetNamespaceServer
+	lock   *sync.Mutex
+	stderr bool
+}
+
+func (w *outputWriter) Write(data []byte) (int, error) {
+	out := &rpc.NetNamespaceOutput{}
+	if w.stderr {
+		out.Stderr = data
+	} else {
+		out.Stdout = data
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if err := w.stream.Send(out); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// exitCodeOf returns the exit code of the given process, or 128 plus the number of the signal that terminated it,
+// like a shell does.
+func exitCodeOf(ps *os.ProcessState) int {
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return ps.ExitCode()
+}
+
+// groupsOf returns the given gid and the supplementary groups of the user with the given uid. Only the gid is
+// returned when the groups of the user can't be looked up.
+func groupsOf(uid, gid uint32) []uint32 {
+	groups := []uint32{gid}
+	u, err := user.LookupId(strconv.Itoa(int(uid)))
+	if err != nil {
+		return groups
+	}
+	ids, err := u.GroupIds()
+	if err != nil {
+		return groups
+	}
+	for _, id := range ids {
+		if g, err := strconv.ParseUint(id, 10, 32); err == nil && uint32(g) != gid {
+			groups = append(groups, uint32(g))
+		}
+	}
+	return groups
+}
@@ -0,0 +1,223 @@
+package rootd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"github.com/datawire/dlib/dhttp"
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/scout"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/fake"
+)
+
+func Test_openTun_sandbox(t *testing.T) {
+	defer func(f func(context.Context, string) (vif.Interface, error)) { openSandboxTun = f }(openSandboxTun)
+	dev := fake.NewDevice("tel0", 1)
+	var netns string
+	openSandboxTun = func(_ context.Context, name string) (vif.Interface, error) {
+		netns = name
+		return dev, nil
+	}
+	got, err := openTun(dlog.NewTestContext(t, false), "telepresence")
+	require.NoError(t, err)
+	assert.Same(t, dev, got)
+	assert.Equal(t, "telepresence", netns)
+
+	openSandboxTun = func(context.Context, string) (vif.Interface, error) {
+		return nil, errors.New("no network namespaces")
+	}
+	got, err = openTun(dlog.NewTestContext(t, false), "telepresence")
+	assert.Error(t, err)
+	assert.Nil(t, got)
+}
+
+func Test_stop_sandbox(t *testing.T) {
+	defer func(f func(context.Context, string) error) { closeSandbox = f }(closeSandbox)
+	var closed []string
+	closeSandbox = func(_ context.Context, name string) error {
+		closed = append(closed, name)
+		return nil
+	}
+	ctx := dlog.NewTestContext(t, false)
+	conn, err := grpc.DialContext(ctx, "passthrough:///connector",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return nil, errors.New("the connector is gone")
+		}))
+	require.NoError(t, err)
+
+	dev := fake.NewDevice("tel0", 1)
+	s := testSession(dev)
+	s.scout = &scout.Reporter{}
+	s.clientConn = conn
+	s.netNamespace = "telepresence"
+	s.stop(ctx)
+	assert.Equal(t, []string{"telepresence"}, closed)
+	_, err = dev.ReadPacket(nil)
+	assert.ErrorIs(t, err, fake.ErrClosed)
+
+	// The host's network has no namespace to delete
+	closed = nil
+	s = testSession(fake.NewDevice("tel0", 1))
+	s.scout = &scout.Reporter{}
+	s.clientConn = conn
+	s.stop(ctx)
+	assert.Empty(t, closed)
+}
+
+// sandboxClient returns a client of a daemon that serves the given service on a unix socket, so that the calls
+// have peer credentials. Commands are started in the current network namespace.
+func sandboxClient(t *testing.T, d *service) (rpc.DaemonClient, chan *exec.Cmd) {
+	started := make(chan *exec.Cmd, 1)
+	defer func(f func(string, *exec.Cmd) error) { t.Cleanup(func() { startInNetNamespace = f }) }(startInNetNamespace)
+	startInNetNamespace = func(name string, cmd *exec.Cmd) error {
+		assert.Equal(t, "telepresence", name)
+		started <- cmd
+		return cmd.Start()
+	}
+
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	socket := filepath.Join(t.TempDir(), "daemon.socket")
+	l, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	svc := grpc.NewServer()
+	rpc.RegisterDaemonServer(svc, d)
+	sc := &dhttp.ServerConfig{Handler: svc, ConnContext: withPeerCredentials}
+	serveDone := make(chan struct{})
+	go func() {
+		_ = sc.Serve(ctx, l)
+		close(serveDone)
+	}()
+	conn, err := grpc.DialContext(ctx, "unix:"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+		cancel()
+		<-serveDone
+	})
+	return rpc.NewDaemonClient(conn), started
+}
+
+// runCommand runs the given command using RunInNetNamespace, and returns its stdout, stderr, and exit code. The
+// given function is called with the stream once the first output has been received.
+func runCommand(t *testing.T, dc rpc.DaemonClient, args []string, stdin string, afterFirst func(rpc.Daemon_RunInNetNamespaceClient)) (string, string, int32, error) {
+	ctx, cancel := context.WithTimeout(dlog.NewTestContext(t, false), 10*time.Second)
+	defer cancel()
+	stream, err := dc.RunInNetNamespace(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&rpc.NetNamespaceInput{Args: args, Env: []string{"GREETING=hello"}, Dir: "/"}))
+	if stdin != "" {
+		require.NoError(t, stream.Send(&rpc.NetNamespaceInput{Stdin: []byte(stdin)}))
+		require.NoError(t, stream.Send(&rpc.NetNamespaceInput{CloseStdin: true}))
+	}
+	var stdout, stderr strings.Builder
+	for first := true; ; first = false {
+		out, err := stream.Recv()
+		if err != nil {
+			return stdout.String(), stderr.String(), 0, err
+		}
+		stdout.Write(out.Stdout)
+		stderr.Write(out.Stderr)
+		if out.Exited {
+			_, err = stream.Recv()
+			assert.ErrorIs(t, err, io.EOF, "output after the exit code")
+			return stdout.String(), stderr.String(), out.ExitCode, nil
+		}
+		if first && afterFirst != nil {
+			afterFirst(stream)
+		}
+	}
+}
+
+func TestService_RunInNetNamespace(t *testing.T) {
+	d, _ := testService("a")
+	d.sessions["a"].netNamespace = "telepresence"
+	dc, started := sandboxClient(t, d)
+
+	stdout, stderr, exitCode, err := runCommand(t, dc, []string{"/bin/sh", "-c", `cat; echo "$GREETING from $PWD" >&2; exit 3`}, "input", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "input", stdout)
+	assert.Equal(t, "hello from /\n", stderr)
+	assert.Equal(t, int32(3), exitCode)
+
+	// The caller runs as the same user as the daemon in this test, so the identity isn't changed
+	cmd := <-started
+	assert.Nil(t, cmd.SysProcAttr)
+
+	// Signals are passed on to the command
+	stdout, _, exitCode, err = runCommand(t, dc, []string{"/bin/sh", "-c", `trap "exit 7" TERM; echo ready; while :; do sleep 0.1; done`}, "",
+		func(stream rpc.Daemon_RunInNetNamespaceClient) {
+			assert.NoError(t, stream.Send(&rpc.NetNamespaceInput{Signal: int32(syscall.SIGTERM)}))
+		})
+	require.NoError(t, err)
+	<-started
+	assert.Equal(t, "ready\n", stdout)
+	assert.Equal(t, int32(7), exitCode)
+
+	// A command that is terminated by a signal exits like it does in a shell
+	_, _, exitCode, err = runCommand(t, dc, []string{"/bin/sh", "-c", `echo ready; kill -KILL $$`}, "", nil)
+	require.NoError(t, err)
+	<-started
+	assert.Equal(t, int32(128+9), exitCode)
+
+	_, _, _, err = runCommand(t, dc, []string{"/no/such/command"}, "", nil)
+	<-started
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, _, _, err = runCommand(t, dc, nil, "", nil)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestService_RunInNetNamespace_noNamespace(t *testing.T) {
+	d, _ := testService("a")
+	dc, started := sandboxClient(t, d)
+	_, _, _, err := runCommand(t, dc, []string{"/bin/true"}, "", nil)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	delete(d.sessions, "a")
+	_, _, _, err = runCommand(t, dc, []string{"/bin/true"}, "", nil)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Empty(t, started)
+}
+
+// noPeerStream is a stream of a call that wasn't made over a unix socket.
+type noPeerStream struct {
+	rpc.Daemon_RunInNetNamespaceServer
+	ctx context.Context
+}
+
+func (s *noPeerStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestService_RunInNetNamespace_noPeerCredentials(t *testing.T) {
+	d, _ := testService("a")
+	d.sessions["a"].netNamespace = "telepresence"
+	err := d.RunInNetNamespace(&noPeerStream{ctx: dlog.NewTestContext(t, false)})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func Test_groupsOf(t *testing.T) {
+	// The groups of an unknown user are just its gid
+	assert.Equal(t, []uint32{4711}, groupsOf(4711, 4711))
+	groups := groupsOf(0, 0)
+	require.NotEmpty(t, groups)
+	assert.Equal(t, uint32(0), groups[0])
+}
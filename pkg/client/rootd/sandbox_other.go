@@ -0,0 +1,32 @@
+//go:build !linux
+// +build !linux
+
+package rootd
+
+import (
+	"context"
+	"net"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif"
+)
+
+func openSandboxTun(context.Context, string) (vif.Interface, error) {
+	return nil, errcat.User.New("--namespace-sandbox is only supported on Linux, which has network namespaces")
+}
+
+func closeSandbox(context.Context, string) error {
+	return nil
+}
+
+func withPeerCredentials(ctx context.Context, _ net.Conn) context.Context {
+	return ctx
+}
+
+func (d *service) RunInNetNamespace(rpc.Daemon_RunInNetNamespaceServer) error {
+	return status.Error(codes.Unimplemented, "network namespaces are only supported on Linux")
+}
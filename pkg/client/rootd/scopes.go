@@ -0,0 +1,83 @@
+package rootd
+
+import (
+	"net"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/subnet"
+)
+
+// sessionScopes keeps the network configurations of the sessions that the root daemon hosts apart. A subnet that
+// overlaps a subnet that another session routes is never routed, and only one session at a time may use a DNS
+// configuration that applies to the whole system. A nil sessionScopes imposes no restrictions.
+type sessionScopes struct {
+	sync.Mutex
+
+	// subnets are the subnets that each session routes, keyed by session ID.
+	subnets map[string][]*net.IPNet
+
+	// systemDNS is the ID of the session that uses a DNS configuration that applies to the whole system.
+	systemDNS string
+}
+
+func newSessionScopes() *sessionScopes {
+	return &sessionScopes{subnets: make(map[string][]*net.IPNet)}
+}
+
+// claimSubnets makes the given subnets the subnets that the session with the given ID routes, except for those that
+// overlap the subnets of other sessions, which are returned as conflicting.
+func (sc *sessionScopes) claimSubnets(id string, subnets []*net.IPNet) (claimed, conflicting []*net.IPNet) {
+	if sc == nil {
+		return subnets, nil
+	}
+	sc.Lock()
+	defer sc.Unlock()
+	claimed, conflicting = subnet.Partition(subnets, func(_ int, sn *net.IPNet) bool {
+		for oid, osns := range sc.subnets {
+			if oid == id {
+				continue
+			}
+			for _, osn := range osns {
+				if subnet.Covers(osn, sn) || subnet.Covers(sn, osn) {
+					return false
+				}
+			}
+		}
+		return true
+	})
+	sc.subnets[id] = claimed
+	return claimed, conflicting
+}
+
+// claimSystemDNS makes the session with the given ID the session that uses a DNS configuration that applies to the
+// whole system. It returns a FailedPrecondition error when another session uses such a configuration.
+func (sc *sessionScopes) claimSystemDNS(id string) error {
+	if sc == nil {
+		return nil
+	}
+	sc.Lock()
+	defer sc.Unlock()
+	if sc.systemDNS != "" && sc.systemDNS != id {
+		return status.Errorf(codes.FailedPrecondition,
+			"the DNS configuration of session %s applies to the whole system, so no other session can be hosted until it ends. "+
+				"Use a DNS resolver that is scoped to the TUN device, or disconnect the other session", sc.systemDNS)
+	}
+	sc.systemDNS = id
+	return nil
+}
+
+// release releases the subnets and the DNS configuration of the session with the given ID.
+func (sc *sessionScopes) release(id string) {
+	if sc == nil {
+		return
+	}
+	sc.Lock()
+	defer sc.Unlock()
+	delete(sc.subnets, id)
+	if sc.systemDNS == id {
+		sc.systemDNS = ""
+	}
+}
@@ -0,0 +1,64 @@
+package rootd
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSessionScopes_claimSubnets(t *testing.T) {
+	sc := newSessionScopes()
+	claimed, conflicting := sc.claimSubnets("a", []*net.IPNet{mustParseCIDR(t, "10.96.0.0/12"), mustParseCIDR(t, "10.244.0.0/16")})
+	assert.Equal(t, []string{"10.96.0.0/12", "10.244.0.0/16"}, cidrs(claimed))
+	assert.Empty(t, conflicting)
+
+	// Subnets that overlap the subnets of another session, in either direction, are conflicting
+	claimed, conflicting = sc.claimSubnets("b", []*net.IPNet{
+		mustParseCIDR(t, "10.100.0.0/16"),
+		mustParseCIDR(t, "10.0.0.0/8"),
+		mustParseCIDR(t, "172.20.0.0/16"),
+	})
+	assert.Equal(t, []string{"172.20.0.0/16"}, cidrs(claimed))
+	assert.Equal(t, []string{"10.100.0.0/16", "10.0.0.0/8"}, cidrs(conflicting))
+
+	// A session doesn't conflict with itself, and its claim is replaced
+	claimed, conflicting = sc.claimSubnets("a", []*net.IPNet{mustParseCIDR(t, "10.96.0.0/12")})
+	assert.Equal(t, []string{"10.96.0.0/12"}, cidrs(claimed))
+	assert.Empty(t, conflicting)
+	claimed, _ = sc.claimSubnets("b", []*net.IPNet{mustParseCIDR(t, "10.244.0.0/16")})
+	assert.Equal(t, []string{"10.244.0.0/16"}, cidrs(claimed))
+
+	// The subnets of a released session can be claimed by others
+	sc.release("a")
+	claimed, conflicting = sc.claimSubnets("b", []*net.IPNet{mustParseCIDR(t, "10.0.0.0/8")})
+	assert.Equal(t, []string{"10.0.0.0/8"}, cidrs(claimed))
+	assert.Empty(t, conflicting)
+}
+
+func TestSessionScopes_claimSystemDNS(t *testing.T) {
+	sc := newSessionScopes()
+	require.NoError(t, sc.claimSystemDNS("a"))
+	require.NoError(t, sc.claimSystemDNS("a"))
+	err := sc.claimSystemDNS("b")
+	require.Error(t, err)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	sc.release("b")
+	assert.Error(t, sc.claimSystemDNS("b"), "the release of another session released the DNS")
+	sc.release("a")
+	assert.NoError(t, sc.claimSystemDNS("b"))
+}
+
+func TestSessionScopes_nil(t *testing.T) {
+	var sc *sessionScopes
+	sns := []*net.IPNet{mustParseCIDR(t, "10.0.0.0/8")}
+	claimed, conflicting := sc.claimSubnets("a", sns)
+	assert.Equal(t, sns, claimed)
+	assert.Empty(t, conflicting)
+	assert.NoError(t, sc.claimSystemDNS("a"))
+	sc.release("a")
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/spf13/cobra"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/status"
 	empty "google.golang.org/protobuf/types/known/emptypb"
 
@@ -30,6 +31,7 @@ import (
 	"github.com/telepresenceio/telepresence/v2/pkg/client/scout"
 	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
 	"github.com/telepresenceio/telepresence/v2/pkg/log"
+	"github.com/telepresenceio/telepresence/v2/pkg/pprof"
 	"github.com/telepresenceio/telepresence/v2/pkg/proc"
 )
 
@@ -52,33 +54,48 @@ type sessionReply struct {
 	err    error
 }
 
-// service represents the state of the Telepresence Daemon
+// service represents the state of the Telepresence Daemon. The daemon can host the sessions of several
+// user daemons simultaneously. Each session has its own TUN device and DNS server, and the scopes keep
+// their routes and DNS configurations apart.
 type service struct {
 	rpc.UnsafeDaemonServer
 	quit           context.CancelFunc
 	connectCh      chan *rpc.OutboundInfo
 	connectReplyCh chan sessionReply
 	sessionLock    sync.RWMutex
-	sessionContext context.Context
-	session        *session
-	cancelCh       chan struct{}
-	timedLogLevel  log.TimedLevel
+
+	// sessions are the active sessions, keyed by session ID
+	sessions map[string]*session
+
+	// stopping are the done channels of sessions that have been cancelled but not yet terminated
+	stopping []chan struct{}
+
+	scopes *sessionScopes
+
+	timedLogLevel log.TimedLevel
 
 	scout *scout.Reporter
 }
 
 // Command returns the telepresence sub-command "daemon-foreground"
 func Command() *cobra.Command {
-	return &cobra.Command{
+	var offline bool
+	cmd := &cobra.Command{
 		Use:    ProcessName + "-foreground <logging dir> <config dir>",
 		Short:  "Launch Telepresence " + titleName + " in the foreground (debug)",
 		Args:   cobra.ExactArgs(2),
 		Hidden: true,
 		Long:   help,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return run(cmd.Context(), args[0], args[1])
+			ctx := cmd.Context()
+			if offline {
+				ctx = client.WithOffline(ctx)
+			}
+			return run(ctx, args[0], args[1])
 		},
 	}
+	cmd.Flags().BoolVar(&offline, "offline", false, "make no network calls other than those to the cluster")
+	return cmd
 }
 
 func (d *service) Version(_ context.Context, _ *empty.Empty) (*common.VersionInfo, error) {
@@ -88,14 +105,16 @@ func (d *service) Version(_ context.Context, _ *empty.Empty) (*common.VersionInf
 	}, nil
 }
 
-func (d *service) Status(_ context.Context, _ *empty.Empty) (*rpc.DaemonStatus, error) {
+// Status returns the status of the session that the call concerns. The status has no session when the call
+// concerns no session, or when it doesn't identify its session and several sessions are active.
+func (d *service) Status(ctx context.Context, _ *empty.Empty) (*rpc.DaemonStatus, error) {
 	d.sessionLock.RLock()
 	defer d.sessionLock.RUnlock()
-	r := &rpc.DaemonStatus{}
-	if d.session != nil {
-		r.OutboundConfig = d.session.getInfo()
+	s, err := d.sessionLocked(ctx)
+	if err != nil || s == nil {
+		return &rpc.DaemonStatus{}, nil
 	}
-	return r, nil
+	return s.getStatus(), nil
 }
 
 func (d *service) Quit(ctx context.Context, _ *empty.Empty) (*empty.Empty, error) {
@@ -103,7 +122,7 @@ func (d *service) Quit(ctx context.Context, _ *empty.Empty) (*empty.Empty, error
 	d.quit()
 	d.sessionLock.Lock()
 	defer d.sessionLock.Unlock()
-	d.session = nil
+	d.sessions = make(map[string]*session)
 	return &empty.Empty{}, nil
 }
 
@@ -132,31 +151,83 @@ func (d *service) Connect(ctx context.Context, info *rpc.OutboundInfo) (*rpc.Dae
 
 func (d *service) Disconnect(ctx context.Context, _ *empty.Empty) (*empty.Empty, error) {
 	dlog.Debug(ctx, "Received gRPC Disconnect")
-	d.cancelSession()
+	d.sessionLock.Lock()
+	defer d.sessionLock.Unlock()
+	s, err := d.sessionLocked(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if s != nil {
+		d.cancelSessionLocked(s)
+	}
 	return &empty.Empty{}, nil
 }
 
-func (d *service) cancelSession() {
-	d.sessionLock.Lock()
-	defer d.sessionLock.Unlock()
-	if d.cancelCh != nil || d.session == nil {
+// sessionLocked returns the session that the call of the given context concerns, or nil if there's no such
+// session. A call that doesn't identify a session concerns the only active session, and a FailedPrecondition
+// error is returned when several sessions are active. The sessionLock must be held when calling this method.
+func (d *service) sessionLocked(c context.Context) (*session, error) {
+	if id := client.RootDaemonSession(c); id != "" {
+		return d.sessions[id], nil
+	}
+	if len(d.sessions) > 1 {
+		return nil, status.Error(codes.FailedPrecondition, "several sessions are active, the call must identify its session")
+	}
+	for _, s := range d.sessions {
+		return s, nil
+	}
+	return nil, nil
+}
+
+// cancelSessionLocked removes the given session from the active sessions and cancels it. The
+// sessionLock must be held when calling this method.
+func (d *service) cancelSessionLocked(s *session) {
+	id := s.session.SessionId
+	if d.sessions[id] != s {
 		// avoid repeated cancellations
 		return
 	}
-	defer func() {
-		d.session = nil
-	}()
-	d.cancelCh = make(chan struct{})
-	d.session.cancel()
+	delete(d.sessions, id)
+	d.stopping = append(d.stopping, s.done)
+	s.cancel()
 }
 
+func (d *service) cancelSession(s *session) {
+	d.sessionLock.Lock()
+	d.cancelSessionLocked(s)
+	d.sessionLock.Unlock()
+}
+
+// withSession calls the given function with the session that the call concerns. A call that doesn't
+// identify a session concerns the only active session.
 func (d *service) withSession(c context.Context, f func(context.Context, *session) error) error {
 	d.sessionLock.RLock()
 	defer d.sessionLock.RUnlock()
-	if d.session == nil {
+	s, err := d.sessionLocked(c)
+	if err != nil {
+		return err
+	}
+	if s == nil {
 		return status.Error(codes.Unavailable, "no active session")
 	}
-	return f(d.sessionContext, d.session)
+	return f(s.ctx, s)
+}
+
+// awaitStopping waits until all cancelled sessions have terminated. This ensures that a new
+// session doesn't compete with the routes and DNS configuration of a session that is shutting down.
+func (d *service) awaitStopping(c context.Context) bool {
+	d.sessionLock.Lock()
+	stopping := d.stopping
+	d.stopping = nil
+	d.sessionLock.Unlock()
+	for _, done := range stopping {
+		select {
+		case <-c.Done():
+			return false
+		case <-done:
+		}
+	}
+	return true
 }
 
 func (d *service) GetClusterSubnets(ctx context.Context, _ *empty.Empty) (*rpc.ClusterSubnets, error) {
@@ -201,13 +272,22 @@ func (d *service) SetLogLevel(ctx context.Context, request *manager.LogLevelRequ
 
 func (d *service) configReload(c context.Context) error {
 	return client.Watch(c, func(c context.Context) error {
-		return logging.ReloadDaemonConfig(c, true)
+		if err := logging.ReloadDaemonConfig(c, true); err != nil {
+			return err
+		}
+		overrides := client.GetConfig(c).DNS.Overrides
+		d.sessionLock.RLock()
+		defer d.sessionLock.RUnlock()
+		for _, s := range d.sessions {
+			s.dnsServer.SetOverrides(overrides)
+		}
+		return nil
 	})
 }
 
 // manageSessions is the counterpart to the Connect method. It reads the connectCh, creates
-// a session and writes a reply to the connectErrCh. The session is then started if it was
-// successfully created.
+// a session unless one with the same ID is already active, and writes a reply to the connectReplyCh.
+// A new session is then started if it was successfully created.
 func (d *service) manageSessions(c context.Context) error {
 	// The d.quit is called when we receive a Quit. Since it
 	// terminates this function, it terminates the whole process.
@@ -223,42 +303,37 @@ nextSession:
 		case oi = <-d.connectCh:
 		}
 
-		var session *session
+		// Respond with the status of the session when it's already active. Otherwise, create
+		// the session and respond with its status or the error (if creation failed). The
+		// s variable is only set when a new session was created.
+		var s *session
 		reply := sessionReply{}
-
-		d.sessionLock.Lock()
-		// If a cancelCh exists, we must wait for it to close and
-		// then check for it again. This ensures that the session
-		// cannot be accessed during shutdown and that a new connect
-		// must wait for an old to complete.
-		for {
-			if cancelCh := d.cancelCh; cancelCh != nil {
-				d.sessionLock.Unlock()
-				select {
-				case <-c.Done():
+		if oi.Session == nil {
+			reply.err = status.Error(codes.InvalidArgument, "connect request has no session")
+		} else {
+			d.sessionLock.RLock()
+			if as, ok := d.sessions[oi.Session.SessionId]; ok {
+				reply.status = &rpc.DaemonStatus{OutboundConfig: as.getInfo()}
+			}
+			d.sessionLock.RUnlock()
+			if reply.status == nil {
+				if !d.awaitStopping(c) {
 					break nextSession
-				case <-cancelCh:
+				}
+				var ns *session
+				if ns, reply.err = newSession(c, d.scout, oi, d.scopes); reply.err != nil {
+					d.scopes.release(oi.Session.SessionId)
+				} else {
+					s = ns
+					s.ctx, s.cancel = context.WithCancel(c)
 					d.sessionLock.Lock()
+					d.sessions[oi.Session.SessionId] = s
+					d.sessionLock.Unlock()
+					reply.status = &rpc.DaemonStatus{OutboundConfig: s.getInfo()}
 				}
-			} else {
-				break
 			}
 		}
 
-		// Respond by setting the session and returning the error (or nil
-		// if everything is ok)
-		if d.session != nil {
-			reply.status = &rpc.DaemonStatus{OutboundConfig: d.session.getInfo()}
-		} else {
-			session, reply.err = newSession(c, d.scout, oi)
-			if reply.err == nil {
-				d.session = session
-				d.sessionContext, session.cancel = context.WithCancel(c)
-				reply.status = &rpc.DaemonStatus{OutboundConfig: d.session.getInfo()}
-			}
-		}
-		d.sessionLock.Unlock()
-
 		select {
 		case <-c.Done():
 			break nextSession
@@ -266,30 +341,29 @@ nextSession:
 		default:
 			// Nobody left to read the response? That's fine really. Just means that
 			// whoever wanted to start the session terminated early.
-			d.cancelSession()
+			if s != nil {
+				d.cancelSession(s)
+			}
 			continue
 		}
-		if reply.err != nil {
+		if s == nil {
 			continue
 		}
 
 		// Run the session asynchronously. We must be able to respond to connect (with getInfo) while
-		// the session is running. The d.session.cancel is called from Disconnect
+		// the session is running. The s.cancel is called from Disconnect
 		wg.Add(1)
-		go func() {
+		go func(s *session) {
 			defer func() {
-				d.sessionLock.Lock()
-				if d.cancelCh != nil {
-					close(d.cancelCh)
-					d.cancelCh = nil
-				}
-				d.sessionLock.Unlock()
+				d.cancelSession(s)
+				d.scopes.release(s.session.SessionId)
+				close(s.done)
 				wg.Done()
 			}()
-			if err := d.session.run(d.sessionContext); err != nil {
+			if err := s.run(s.ctx); err != nil {
 				dlog.Error(c, err)
 			}
-		}()
+		}(s)
 	}
 	wg.Wait()
 	return nil
@@ -300,10 +374,11 @@ func (d *service) serveGrpc(c context.Context, l net.Listener) error {
 		// Error recovery.
 		if perr := derror.PanicToError(recover()); perr != nil {
 			dlog.Errorf(c, "%+v", perr)
+			logging.ReportCrash(c, ProcessName, perr)
 		}
 	}()
 
-	var opts []grpc.ServerOption
+	opts := logging.CrashReportServerOptions(c, ProcessName)
 	cfg := client.GetConfig(c)
 	if !cfg.Grpc.MaxReceiveSize.IsZero() {
 		if mz, ok := cfg.Grpc.MaxReceiveSize.AsInt64(); ok {
@@ -312,9 +387,14 @@ func (d *service) serveGrpc(c context.Context, l net.Listener) error {
 	}
 	svc := grpc.NewServer(opts...)
 	rpc.RegisterDaemonServer(svc, d)
+	grpc_health_v1.RegisterHealthServer(svc, client.NewHealthServer(c, func() {
+		d.sessionLock.RLock()
+		d.sessionLock.RUnlock() //nolint:staticcheck // empty critical section, the lock is only probed
+	}, rpc.Daemon_ServiceDesc.ServiceName))
 
 	sc := &dhttp.ServerConfig{
-		Handler: svc,
+		Handler:     svc,
+		ConnContext: withPeerCredentials,
 	}
 	dlog.Info(c, "gRPC server started")
 	err := sc.Serve(c, l)
@@ -328,8 +408,8 @@ func (d *service) serveGrpc(c context.Context, l net.Listener) error {
 
 // run is the main function when executing as the daemon
 func run(c context.Context, loggingDir, configDir string) error {
-	if !proc.IsAdmin() {
-		return fmt.Errorf("telepresence %s must run with elevated privileges", ProcessName)
+	if !(proc.IsAdmin() || proc.HasNetAdmin()) {
+		return fmt.Errorf("telepresence %s must run with elevated privileges or with the CAP_NET_ADMIN capability", ProcessName)
 	}
 
 	// seed random generator (used when shuffling IPs)
@@ -340,6 +420,12 @@ func run(c context.Context, loggingDir, configDir string) error {
 	c = filelocation.WithAppUserLogDir(c, loggingDir)
 	c = filelocation.WithAppUserConfigDir(c, configDir)
 
+	// The sockets are those of the user that started the daemon, i.e. the owner of the config directory.
+	c, err := client.WithSocketUserOfDir(c, configDir)
+	if err != nil {
+		return err
+	}
+
 	cfg, err := client.LoadConfig(c)
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
@@ -360,7 +446,7 @@ func run(c context.Context, loggingDir, configDir string) error {
 	// Listen on domain unix domain socket or windows named pipe. The listener must be opened
 	// before other tasks because the CLI client will only wait for a short period of time for
 	// the socket/pipe to appear before it gives up.
-	grpcListener, err := client.ListenSocket(c, ProcessName, client.DaemonSocketName)
+	grpcListener, err := client.ListenSocket(c, ProcessName, client.DaemonSocketName(c))
 	if err != nil {
 		return err
 	}
@@ -374,6 +460,8 @@ func run(c context.Context, loggingDir, configDir string) error {
 		timedLogLevel:  log.NewTimedLevel(cfg.LogLevels.RootDaemon.String(), log.SetLevel),
 		connectCh:      make(chan *rpc.OutboundInfo),
 		connectReplyCh: make(chan sessionReply),
+		sessions:       make(map[string]*session),
+		scopes:         newSessionScopes(),
 	}
 	if err = logging.LoadTimedLevelFromCache(c, d.timedLogLevel, ProcessName); err != nil {
 		return err
@@ -387,9 +475,12 @@ func run(c context.Context, loggingDir, configDir string) error {
 
 	// Add a reload function that triggers on create and write of the config.yml file.
 	g.Go("config-reload", d.configReload)
-	g.Go("session", d.manageSessions)
+	g.Go("session", logging.WithCrashReport(ProcessName, d.manageSessions))
 	g.Go("server-grpc", func(c context.Context) error { return d.serveGrpc(c, grpcListener) })
 	g.Go("metriton", d.scout.Run)
+	if port := cfg.Daemons.RootDaemonProfilingPort; port != 0 {
+		g.Go("pprof", func(c context.Context) error { return pprof.Serve(c, port) })
+	}
 	err = g.Wait()
 	if err != nil {
 		dlog.Error(c, err)
@@ -0,0 +1,101 @@
+package rootd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// incomingSession returns a context of an incoming call that concerns the session with the given ID.
+func incomingSession(ctx context.Context, id string) context.Context {
+	md, _ := metadata.FromOutgoingContext(client.WithRootDaemonSession(ctx, id))
+	return metadata.NewIncomingContext(ctx, md)
+}
+
+func testService(ids ...string) (*service, map[string]bool) {
+	d := &service{sessions: make(map[string]*session), scopes: newSessionScopes()}
+	cancelled := make(map[string]bool)
+	for _, id := range ids {
+		id := id
+		d.sessions[id] = &session{
+			session: &manager.SessionInfo{SessionId: id},
+			cancel:  func() { cancelled[id] = true },
+			done:    make(chan struct{}),
+		}
+	}
+	return d, cancelled
+}
+
+func TestService_Disconnect(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	d, cancelled := testService("a", "b")
+
+	// A call that doesn't identify its session disconnects nothing when several sessions are active
+	_, err := d.Disconnect(ctx, &empty.Empty{})
+	require.Error(t, err)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Empty(t, cancelled)
+
+	// Only the identified session is disconnected
+	_, err = d.Disconnect(incomingSession(ctx, "a"), &empty.Empty{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"a": true}, cancelled)
+	assert.Contains(t, d.sessions, "b")
+
+	// An unknown session is a no-op
+	_, err = d.Disconnect(incomingSession(ctx, "c"), &empty.Empty{})
+	require.NoError(t, err)
+	assert.Contains(t, d.sessions, "b")
+
+	// The only active session is disconnected by a call that doesn't identify it
+	_, err = d.Disconnect(ctx, &empty.Empty{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"a": true, "b": true}, cancelled)
+	assert.Empty(t, d.sessions)
+	assert.Len(t, d.stopping, 2)
+}
+
+func TestService_Status(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	d, _ := testService("a", "b")
+
+	// Several sessions are active, so a call that doesn't identify its session gets no session
+	st, err := d.Status(ctx, &empty.Empty{})
+	require.NoError(t, err)
+	assert.Nil(t, st.OutboundConfig)
+
+	st, err = d.Status(incomingSession(ctx, "c"), &empty.Empty{})
+	require.NoError(t, err)
+	assert.Nil(t, st.OutboundConfig)
+}
+
+func TestService_withSession(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	d, _ := testService("a", "b")
+	var got string
+	f := func(_ context.Context, s *session) error {
+		got = s.session.SessionId
+		return nil
+	}
+	require.NoError(t, d.withSession(incomingSession(ctx, "b"), f))
+	assert.Equal(t, "b", got)
+
+	err := d.withSession(ctx, f)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	err = d.withSession(incomingSession(ctx, "c"), f)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	delete(d.sessions, "b")
+	require.NoError(t, d.withSession(ctx, f))
+	assert.Equal(t, "a", got)
+}
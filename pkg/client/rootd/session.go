@@ -17,6 +17,7 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 	empty "google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/datawire/dlib/dcontext"
@@ -30,6 +31,7 @@ import (
 	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/rootd/dns"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/scout"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
 	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
 	"github.com/telepresenceio/telepresence/v2/pkg/subnet"
 	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
@@ -69,10 +71,19 @@ import (
 type session struct {
 	cancel context.CancelFunc
 
+	// ctx is the context that the session runs in. It's cancelled by cancel.
+	ctx context.Context
+
+	// done is closed when the session has terminated.
+	done chan struct{}
+
 	scout *scout.Reporter
 
 	// dev is the TUN device that gets configured with the subnets found in the cluster
-	dev *vif.Device
+	dev vif.Interface
+
+	// mtu is the MTU of the TUN device
+	mtu int
 
 	// clientConn is the connection that uses the connector's socket
 	clientConn *grpc.ClientConn
@@ -80,6 +91,12 @@ type session struct {
 	// managerClient provides the gRPC tunnel to the traffic-manager
 	managerClient manager.ManagerClient
 
+	// tunnelClient provides prewarmed tunnel streams to the traffic-manager. It's set when the session runs.
+	tunnelClient manager.ManagerClient
+
+	// compression is requested for each tunnel stream. It's set when the session runs.
+	compression tunnel.Compression
+
 	// connPool contains handlers that represent active connections. Those handlers
 	// are obtained using a connpool.ConnID.
 	handlers *tunnel.Pool
@@ -102,15 +119,28 @@ type session struct {
 	// Cluster subnets reported by the traffic-manager
 	clusterSubnets []*net.IPNet
 
+	// serviceSubnets are the service subnets among the clusterSubnets.
+	serviceSubnets []*net.IPNet
+
 	// Subnets configured by the user
 	alsoProxySubnets []*net.IPNet
 
 	// Subnets configured not to be proxied
 	neverProxySubnets []routing.Route
+
+	// Subnets configured to be routed to the TUN device even when they conflict with routes of other interfaces
+	allowConflictingSubnets []*net.IPNet
+
 	// Subnets that the router is currently configured with. Managed, and only used in
-	// the refreshSubnets() method.
-	curSubnets      []*net.IPNet
-	curStaticRoutes []routing.Route
+	// the refreshSubnets() method. The curSubnetsLock protects reads from other goroutines.
+	curSubnets        []*net.IPNet
+	curSubnetsLock    sync.RWMutex
+	curStaticRoutes   []routing.Route
+	curOverrideRoutes []*net.IPNet
+
+	// snapshotFile is the file of the snapshot of the network configuration that was taken before the session
+	// modified it. It's removed when the session has restored the configuration.
+	snapshotFile string
 
 	// closing is set during shutdown and can have the values:
 	//   0 = running
@@ -124,16 +154,43 @@ type session struct {
 	// rndSource is the source for the random number generator in the TCP handlers
 	rndSource rand.Source
 
+	// tcpConnections is the number of TCP handlers that are currently active
+	tcpConnections int32
+
+	// packets counts the packets that pass through the TUN device
+	packets packetCounters
+
+	// limitLogged is when a limit of the connection-tracking table was last logged. It's only used by the
+	// packet handler.
+	limitLogged time.Time
+
 	// Telemetry counters for DNS lookups
-	dnsLookups  int
-	dnsFailures int
+	dnsLookups  int64
+	dnsFailures int64
 
 	// Whether pods and services should be proxied by the TUN-device
 	proxyCluster bool
+
+	// Whether the subnets that cover the cluster IPs of discovered services are routed instead of the
+	// whole service subnet
+	minimizeSubnets bool
+
+	// dockerDNS is the listener of the DNS forwarder for local Docker containers. It's only set when the
+	// session was created with allow_docker.
+	dockerDNS net.PacketConn
+
+	// netNamespace is the network namespace that the TUN device was created in. It's only set when the session
+	// was created with a net_namespace, and the host's network is then left untouched.
+	netNamespace string
+
+	// scopes keep the routes and the DNS configuration of the session apart from those of the other sessions
+	// of the root daemon.
+	scopes *sessionScopes
 }
 
-// connectToManager connects to the traffic-manager and asserts that its version is compatible
-func connectToManager(c context.Context) (*grpc.ClientConn, manager.ManagerClient, error) {
+// connectToManager connects to the traffic-manager through the connector that listens to the given
+// socket and asserts that its version is compatible
+func connectToManager(c context.Context, connectorSocket string) (*grpc.ClientConn, manager.ManagerClient, error) {
 	// First check. Establish connection
 	clientConfig := client.GetConfig(c)
 	tos := &clientConfig.Timeouts
@@ -141,7 +198,7 @@ func connectToManager(c context.Context) (*grpc.ClientConn, manager.ManagerClien
 	defer cancel()
 
 	var conn *grpc.ClientConn
-	conn, err := client.DialSocket(tc, client.ConnectorSocketName)
+	conn, err := client.DialSocket(tc, connectorSocket, clientConfig.Grpc.WindowDialOptions()...)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			// The connector called us, and then it died which means we will die too. This is
@@ -201,23 +258,73 @@ func convertNeverProxySubnets(c context.Context, ms []*manager.IPNet) []routing.
 	return rs
 }
 
+// openTun opens the TUN device of a session. The device is created in a new network namespace with the given name,
+// or in the host's namespace when the name is empty.
+func openTun(c context.Context, netns string) (vif.Interface, error) {
+	if netns != "" {
+		return openSandboxTun(c, netns)
+	}
+	dev, err := vif.OpenTun(c)
+	if err != nil {
+		return nil, err
+	}
+	return dev, nil
+}
+
 // newSession returns a new properly initialized session object.
-func newSession(c context.Context, scout *scout.Reporter, mi *rpc.OutboundInfo) (*session, error) {
-	dlog.Info(c, "-- Starting new session")
-	conn, mc, err := connectToManager(c)
+func newSession(c context.Context, scout *scout.Reporter, mi *rpc.OutboundInfo, scopes *sessionScopes) (*session, error) {
+	dlog.Infof(c, "-- Starting new session %s", mi.Session.SessionId)
+	if mi.AllowDocker && mi.NetNamespace != "" {
+		return nil, errcat.User.New("--allow-docker can't be combined with --namespace-sandbox, because Docker containers don't run in the sandbox")
+	}
+	connectorSocket := mi.ConnectorSocket
+	if connectorSocket == "" {
+		connectorSocket = client.ConnectorSocketName(c)
+	} else if err := client.ValidateConnectorSocket(c, connectorSocket); err != nil {
+		return nil, status.Errorf(codes.PermissionDenied, "unable to use the connector socket of the session: %v", err)
+	}
+	if dns.SystemWide(c, mi.NetNamespace) {
+		if err := scopes.claimSystemDNS(mi.Session.SessionId); err != nil {
+			return nil, err
+		}
+	}
+	conn, mc, err := connectToManager(c, connectorSocket)
 	if mc == nil || err != nil {
 		return nil, err
 	}
 
-	dev, err := vif.OpenTun(c)
+	var dockerDNS net.PacketConn
+	if mi.AllowDocker {
+		if dockerDNS, err = listenDockerDNS(c); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	dev, err := openTun(c, mi.NetNamespace)
 	if err != nil {
+		if dockerDNS != nil {
+			dockerDNS.Close()
+		}
 		return nil, err
 	}
+	mtu := buffer.DataPool.MTU
+	if mi.Mtu > 0 {
+		mtu = int(mi.Mtu)
+		// The MSS of the tunneled connections is clamped to the MTU even if the device refuses it.
+		if err = dev.SetMTU(mtu); err != nil {
+			dlog.Warn(c, err)
+		} else {
+			dlog.Infof(c, "MTU of the TUN device set to %d", mtu)
+		}
+	}
 
 	s := &session{
 		cancel:            func() {},
+		done:              make(chan struct{}),
 		scout:             scout,
 		dev:               dev,
+		mtu:               mtu,
 		handlers:          tunnel.NewPool(),
 		fragmentMap:       make(map[uint16][]*buffer.Data),
 		rndSource:         rand.NewSource(time.Now().UnixNano()),
@@ -227,21 +334,43 @@ func newSession(c context.Context, scout *scout.Reporter, mi *rpc.OutboundInfo)
 		alsoProxySubnets:  convertAlsoProxySubnets(c, mi.AlsoProxySubnets),
 		neverProxySubnets: convertNeverProxySubnets(c, mi.NeverProxySubnets),
 		proxyCluster:      true,
+		minimizeSubnets:   mi.MinimizeSubnets,
+		netNamespace:      mi.NetNamespace,
+		scopes:            scopes,
+	}
+	s.allowConflictingSubnets = make([]*net.IPNet, len(mi.AllowConflictingSubnets))
+	for i, ac := range mi.AllowConflictingSubnets {
+		n := iputil.IPNetFromRPC(ac)
+		dlog.Infof(c, "Adding allow-conflicting subnet %s", n)
+		s.allowConflictingSubnets[i] = n
 	}
+	if s.netNamespace == "" {
+		if s.snapshotFile, err = snapshotNetwork(c, mi.Session.SessionId, s.neverProxySubnets, s.allowConflictingSubnets); err != nil {
+			// The snapshot is a safety net, so failing to take it doesn't prevent the session from starting.
+			dlog.Errorf(c, "unable to take a snapshot of the network configuration: %v", err)
+		}
+	}
+	tc := client.GetConfig(c).Tunnel
+	s.handlers.SetMaxSize(tc.TableSize)
+	s.handlers.SetIdleTimeout(ipproto.TCP, tc.TCPIdleTimeout)
+	s.handlers.SetIdleTimeout(ipproto.UDP, tc.UDPIdleTimeout)
 	s.dnsServer = dns.NewServer(mi.Dns, s.clusterLookup)
+	s.dnsServer.SetOverrides(client.GetConfig(c).DNS.Overrides)
+	s.dnsServer.SetNetNamespace(s.netNamespace)
+	s.dockerDNS = dockerDNS
 	return s, nil
 }
 
 // clusterLookup sends a LookupHost request to the traffic-manager and returns the result
 func (s *session) clusterLookup(ctx context.Context, key string) ([][]byte, error) {
 	dlog.Debugf(ctx, "LookupHost %q", key)
-	s.dnsLookups++
+	atomic.AddInt64(&s.dnsLookups, 1)
 	r, err := s.managerClient.LookupHost(ctx, &manager.LookupHostRequest{
 		Session: s.session,
 		Host:    key,
 	})
 	if err != nil || len(r.Ips) == 0 {
-		s.dnsFailures++
+		atomic.AddInt64(&s.dnsFailures, 1)
 	}
 	if err != nil {
 		return nil, err
@@ -251,12 +380,17 @@ func (s *session) clusterLookup(ctx context.Context, key string) ([][]byte, erro
 
 func (s *session) getInfo() *rpc.OutboundInfo {
 	info := rpc.OutboundInfo{
-		Session: s.session,
-		Dns:     s.dnsServer.GetConfig(),
+		Session:      s.session,
+		Dns:          s.dnsServer.GetConfig(),
+		NetNamespace: s.netNamespace,
 	}
 	if s.dnsLocalAddr != nil {
 		info.Dns.RemoteIp = s.dnsLocalAddr.IP
 	}
+	if s.dockerDNS != nil {
+		info.AllowDocker = true
+		info.DockerDnsIp = s.dockerDNS.LocalAddr().(*net.UDPAddr).IP
+	}
 	if len(s.alsoProxySubnets) > 0 {
 		info.AlsoProxySubnets = make([]*manager.IPNet, len(s.alsoProxySubnets))
 		for i, ap := range s.alsoProxySubnets {
@@ -271,9 +405,94 @@ func (s *session) getInfo() *rpc.OutboundInfo {
 		}
 	}
 
+	if len(s.allowConflictingSubnets) > 0 {
+		info.AllowConflictingSubnets = make([]*manager.IPNet, len(s.allowConflictingSubnets))
+		for i, ac := range s.allowConflictingSubnets {
+			info.AllowConflictingSubnets[i] = iputil.IPNetToRPC(ac)
+		}
+	}
+
 	return &info
 }
 
+// getStatus returns the status of this session.
+func (s *session) getStatus() *rpc.DaemonStatus {
+	r := &rpc.DaemonStatus{
+		OutboundConfig: s.getInfo(),
+		DnsStats: &rpc.DNSStats{
+			Requests:              int64(s.dnsServer.RequestCount()),
+			ClusterLookups:        atomic.LoadInt64(&s.dnsLookups),
+			ClusterLookupFailures: atomic.LoadInt64(&s.dnsFailures),
+		},
+	}
+	ps := s.handlers.Stats()
+	r.ConnTrackStats = &rpc.ConnTrackStats{
+		Size:           int32(ps.Size),
+		MaxSize:        int32(ps.MaxSize),
+		TcpIdleTimeout: durationpb.New(ps.TCPIdleTimeout),
+		UdpIdleTimeout: durationpb.New(ps.UDPIdleTimeout),
+		Created:        ps.Created,
+		Rejected:       ps.Rejected,
+		TcpIdleEvicted: ps.TCPIdleEvicted,
+		UdpIdleEvicted: ps.UDPIdleEvicted,
+	}
+	s.curSubnetsLock.RLock()
+	r.RoutedSubnets = make([]*manager.IPNet, len(s.curSubnets))
+	for i, sn := range s.curSubnets {
+		r.RoutedSubnets[i] = iputil.IPNetToRPC(sn)
+	}
+	s.curSubnetsLock.RUnlock()
+	r.NetworkStats = s.getNetworkStats()
+	return r
+}
+
+// Origins of the routes in the NetworkStats.
+const (
+	routeOriginServiceSubnet = "service-subnet"
+	routeOriginPodSubnet     = "pod-subnet"
+	routeOriginAlsoProxy     = "also-proxy"
+	routeOriginNeverProxy    = "never-proxy"
+	routeOriginOverride      = "allow-conflicting"
+)
+
+// getNetworkStats returns the TUN-device, the routes that it has installed together with their origin, the DNS
+// bindings, and the packet counters.
+func (s *session) getNetworkStats() *rpc.NetworkStats {
+	ns := &rpc.NetworkStats{
+		DeviceName:       s.dev.Name(),
+		DnsRemoteIp:      s.remoteDnsIP,
+		PacketsReceived:  atomic.LoadInt64(&s.packets.received),
+		PacketsForwarded: atomic.LoadInt64(&s.packets.forwarded),
+		PacketsDropped:   atomic.LoadInt64(&s.packets.dropped),
+		PacketsSent:      atomic.LoadInt64(&s.packets.sent),
+	}
+	if s.dnsLocalAddr != nil {
+		ns.DnsLocalAddress = s.dnsLocalAddr.String()
+	}
+	if ifc, err := net.InterfaceByName(ns.DeviceName); err == nil {
+		if addrs, err := ifc.Addrs(); err == nil {
+			for _, addr := range addrs {
+				if ipn, ok := addr.(*net.IPNet); ok {
+					ns.DeviceAddresses = append(ns.DeviceAddresses, iputil.IPNetToRPC(ipn))
+				}
+			}
+		}
+	}
+
+	s.curSubnetsLock.RLock()
+	for _, sn := range s.curSubnets {
+		ns.Routes = append(ns.Routes, &rpc.NetworkStats_Route{Subnet: iputil.IPNetToRPC(sn), Origin: s.routeOrigin(sn)})
+	}
+	for _, sr := range s.curStaticRoutes {
+		ns.Routes = append(ns.Routes, &rpc.NetworkStats_Route{Subnet: iputil.IPNetToRPC(sr.RoutedNet), Origin: routeOriginNeverProxy})
+	}
+	for _, sn := range s.curOverrideRoutes {
+		ns.Routes = append(ns.Routes, &rpc.NetworkStats_Route{Subnet: iputil.IPNetToRPC(sn), Origin: routeOriginOverride})
+	}
+	s.curSubnetsLock.RUnlock()
+	return ns
+}
+
 func (s *session) configureDNS(dnsIP net.IP, dnsLocalAddr *net.UDPAddr) {
 	s.remoteDnsIP = dnsIP
 	s.dnsLocalAddr = dnsLocalAddr
@@ -316,20 +535,101 @@ removing:
 			dlog.Errorf(ctx, "failed to remove static route %s: %v", c, err)
 		}
 	}
+	s.curSubnetsLock.Lock()
 	s.curStaticRoutes = desired
+	s.curSubnetsLock.Unlock()
 
 	return nil
 }
 
+// overrideSubnets returns the parts of the current subnets that are also allow-conflicting subnets.
+func (s *session) overrideSubnets() []*net.IPNet {
+	var desired []*net.IPNet
+	for _, ac := range s.allowConflictingSubnets {
+		acOnes, acBits := ac.Mask.Size()
+		for _, cs := range s.curSubnets {
+			csOnes, csBits := cs.Mask.Size()
+			switch {
+			case acBits != csBits:
+			case acOnes >= csOnes && cs.Contains(ac.IP):
+				desired = append(desired, ac)
+			case csOnes > acOnes && ac.Contains(cs.IP):
+				desired = append(desired, cs)
+			}
+		}
+	}
+	return subnet.Unique(desired)
+}
+
+// overrideContext returns a context that makes the device journal the routes that the override routes remove in
+// the network snapshot of the session.
+func (s *session) overrideContext(ctx context.Context) context.Context {
+	if s.snapshotFile == "" {
+		return ctx
+	}
+	return vif.WithOverriddenRoutesJournal(ctx, journalOverriddenRoutes(s.snapshotFile))
+}
+
+// overrideExceptions returns the subnets that must keep their routes even when they are within an override route.
+// They are the never-proxy subnets, which include the IPs of the API server.
+func (s *session) overrideExceptions() []*net.IPNet {
+	exceptions := make([]*net.IPNet, len(s.neverProxySubnets))
+	for i, np := range s.neverProxySubnets {
+		exceptions[i] = np.RoutedNet
+	}
+	return exceptions
+}
+
+func (s *session) reconcileOverrideRoutes(ctx context.Context) {
+	desired := s.overrideSubnets()
+	exceptions := s.overrideExceptions()
+	var current []*net.IPNet
+	ctx = s.overrideContext(ctx)
+
+removing:
+	for _, c := range s.curOverrideRoutes {
+		for _, d := range desired {
+			if subnet.Equal(c, d) {
+				current = append(current, c)
+				continue removing
+			}
+		}
+		if err := s.dev.RemoveOverrideRoute(ctx, c); err != nil {
+			dlog.Errorf(ctx, "failed to remove override route %s: %v", c, err)
+		}
+	}
+
+adding:
+	for _, d := range desired {
+		for _, c := range s.curOverrideRoutes {
+			if subnet.Equal(c, d) {
+				continue adding
+			}
+		}
+		if err := s.dev.AddOverrideRoute(ctx, d, exceptions); err != nil {
+			dlog.Errorf(ctx, "failed to add override route %s: %v", d, err)
+			continue
+		}
+		current = append(current, d)
+	}
+	s.curSubnetsLock.Lock()
+	s.curOverrideRoutes = current
+	s.curSubnetsLock.Unlock()
+}
+
 func (s *session) refreshSubnets(ctx context.Context) error {
-	// Create a unique slice of all desired subnets.
+	// Create a unique slice of all desired subnets. The subnets that other sessions route are never routed.
 	desired := make([]*net.IPNet, len(s.clusterSubnets)+len(s.alsoProxySubnets))
 	copy(desired, s.clusterSubnets)
 	copy(desired[len(s.clusterSubnets):], s.alsoProxySubnets)
-	desired = subnet.Unique(desired)
+	desired, conflicting := s.scopes.claimSubnets(s.session.GetSessionId(), subnet.Unique(desired))
+	for _, sn := range conflicting {
+		dlog.Errorf(ctx, "subnet %s is not routed, because it overlaps a subnet that another session routes", sn)
+	}
 
 	// Remove all no longer desired subnets from the t.curSubnets
 	var removed []*net.IPNet
+	s.curSubnetsLock.Lock()
 	s.curSubnets, removed = subnet.Partition(s.curSubnets, func(_ int, sn *net.IPNet) bool {
 		for _, d := range desired {
 			if subnet.Equal(sn, d) {
@@ -351,6 +651,7 @@ func (s *session) refreshSubnets(ctx context.Context) error {
 
 	// Add desiredSubnets to the currently routed subnets
 	s.curSubnets = append(s.curSubnets, added...)
+	s.curSubnetsLock.Unlock()
 
 	for _, sn := range removed {
 		if err := s.dev.RemoveSubnet(ctx, sn); err != nil {
@@ -364,7 +665,11 @@ func (s *session) refreshSubnets(ctx context.Context) error {
 		}
 	}
 
-	return s.reconcileStaticRoutes(ctx)
+	if err := s.reconcileStaticRoutes(ctx); err != nil {
+		return err
+	}
+	s.reconcileOverrideRoutes(ctx)
+	return nil
 }
 
 func (s *session) watchClusterInfo(ctx context.Context, cfgComplete chan<- struct{}) {
@@ -418,16 +723,47 @@ func (s *session) watchClusterInfo(ctx context.Context, cfgComplete chan<- struc
 	}
 }
 
+// routeOrigin returns the origins of the source subnets that the given routed subnet covers, separated by commas. A
+// route has more than one origin when sources of different origins are equal, or when the route covers sources that
+// were dropped because they're redundant. The curSubnetsLock must be held.
+func (s *session) routeOrigin(route *net.IPNet) string {
+	var origins []string
+	add := func(origin string, sources []*net.IPNet) {
+		for _, sn := range sources {
+			if subnet.Covers(route, sn) {
+				origins = append(origins, origin)
+				return
+			}
+		}
+	}
+	add(routeOriginServiceSubnet, s.serviceSubnets)
+	// The service subnets are first among the cluster subnets, so the rest are the pod subnets
+	add(routeOriginPodSubnet, s.clusterSubnets[len(s.serviceSubnets):])
+	add(routeOriginAlsoProxy, s.alsoProxySubnets)
+	return strings.Join(origins, ",")
+}
+
 func (s *session) onClusterInfo(ctx context.Context, mgrInfo *manager.ClusterInfo) {
 	dlog.Debugf(ctx, "WatchClusterInfo update")
 
 	subnets := make([]*net.IPNet, 0, 1+len(mgrInfo.PodSubnets))
+	var svcSubnets []*net.IPNet
 	if s.proxyCluster {
-		if mgrInfo.ServiceSubnet != nil {
+		if s.minimizeSubnets && len(mgrInfo.ServiceIpSubnets) > 0 {
+			for _, sn := range mgrInfo.ServiceIpSubnets {
+				cidr := iputil.IPNetFromRPC(sn)
+				dlog.Infof(ctx, "Adding subnet %s that covers service IPs", cidr)
+				svcSubnets = append(svcSubnets, cidr)
+			}
+		} else if mgrInfo.ServiceSubnet != nil {
+			if s.minimizeSubnets {
+				dlog.Info(ctx, "The traffic-manager reports no subnets that cover service IPs, so the whole service subnet is routed")
+			}
 			cidr := iputil.IPNetFromRPC(mgrInfo.ServiceSubnet)
 			dlog.Infof(ctx, "Adding service subnet %s", cidr)
-			subnets = append(subnets, cidr)
+			svcSubnets = append(svcSubnets, cidr)
 		}
+		subnets = append(subnets, svcSubnets...)
 
 		for _, sn := range mgrInfo.PodSubnets {
 			cidr := iputil.IPNetFromRPC(sn)
@@ -436,7 +772,10 @@ func (s *session) onClusterInfo(ctx context.Context, mgrInfo *manager.ClusterInf
 		}
 	}
 
+	s.curSubnetsLock.Lock()
 	s.clusterSubnets = subnets
+	s.serviceSubnets = svcSubnets
+	s.curSubnetsLock.Unlock()
 	if err := s.refreshSubnets(ctx); err != nil {
 		dlog.Error(ctx, err)
 	}
@@ -462,6 +801,14 @@ func (s *session) checkConnectivity(ctx context.Context, info *manager.ClusterIn
 func (s *session) run(c context.Context) error {
 	defer dlog.Info(c, "-- Session ended")
 
+	tc := client.GetConfig(c).Tunnel
+	s.compression = tc.Compression
+	if tc.MuxStreams > 0 {
+		// The multiplexed streams are long-lived, so they don't need to be prewarmed.
+		s.tunnelClient = tunnel.NewMuxingClient(c, s.managerClient, tc.MuxStreams, tc.MuxWindowSize)
+	} else {
+		s.tunnelClient = tunnel.NewPrewarmingClient(c, s.managerClient, tc.PoolSize)
+	}
 	g := dgroup.NewGroup(c, dgroup.GroupConfig{})
 
 	cancelDNSLock := sync.Mutex{}
@@ -497,6 +844,9 @@ func (s *session) run(c context.Context) error {
 		return s.dnsServer.Worker(ctx, s.dev, s.configureDNS)
 	})
 	g.Go("router", s.routerWorker)
+	if s.dockerDNS != nil {
+		g.Go("docker-gateway", s.dockerGatewayWorker)
+	}
 	return g.Wait()
 }
 
@@ -508,8 +858,8 @@ func (s *session) stop(c context.Context) {
 	dlog.Debug(c, "Brining down TUN-device")
 
 	s.scout.Report(c, "incluster_dns_queries",
-		scout.Entry{Key: "total", Value: s.dnsLookups},
-		scout.Entry{Key: "failures", Value: s.dnsFailures})
+		scout.Entry{Key: "total", Value: atomic.LoadInt64(&s.dnsLookups)},
+		scout.Entry{Key: "failures", Value: atomic.LoadInt64(&s.dnsFailures)})
 
 	cc, cancel := context.WithTimeout(c, time.Second)
 	defer cancel()
@@ -521,6 +871,11 @@ func (s *session) stop(c context.Context) {
 	atomic.StoreInt32(&s.closing, 2)
 
 	cc = dcontext.WithoutCancel(c)
+	for _, sn := range s.curOverrideRoutes {
+		if err := s.dev.RemoveOverrideRoute(s.overrideContext(cc), sn); err != nil {
+			dlog.Warnf(c, "error removing override route %s: %v", sn, err)
+		}
+	}
 	for _, np := range s.curStaticRoutes {
 		err := s.dev.RemoveStaticRoute(cc, np)
 		if err != nil {
@@ -530,6 +885,16 @@ func (s *session) stop(c context.Context) {
 	if err := s.dev.Close(); err != nil {
 		dlog.Errorf(c, "unable to close %s: %v", s.dev.Name(), err)
 	}
+	if s.netNamespace != "" {
+		if err := closeSandbox(cc, s.netNamespace); err != nil {
+			dlog.Errorf(c, "unable to delete network namespace %s: %v", s.netNamespace, err)
+		}
+	}
+	if s.snapshotFile != "" {
+		if err := os.Remove(s.snapshotFile); err != nil {
+			dlog.Warnf(c, "unable to remove network snapshot %s: %v", s.snapshotFile, err)
+		}
+	}
 
 	dlog.Debug(c, "Sending disconnect message to connector")
 	_, _ = connector.NewConnectorClient(s.clientConn).Disconnect(c, &empty.Empty{})
@@ -0,0 +1,226 @@
+package rootd
+
+import (
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/buffer"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/fake"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/routing"
+)
+
+func testSession(dev *fake.Device) *session {
+	return &session{
+		dev:          dev,
+		mtu:          1500,
+		handlers:     tunnel.NewPool(),
+		fragmentMap:  make(map[uint16][]*buffer.Data),
+		rndSource:    rand.NewSource(1),
+		proxyCluster: true,
+	}
+}
+
+func mustParseCIDR(t *testing.T, cidr string) *net.IPNet {
+	_, sn, err := net.ParseCIDR(cidr)
+	require.NoError(t, err)
+	return sn
+}
+
+func cidrs(sns []*net.IPNet) []string {
+	ss := make([]string, len(sns))
+	for i, sn := range sns {
+		ss[i] = sn.String()
+	}
+	return ss
+}
+
+func Test_onClusterInfo(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	dev := fake.NewDevice("tel0", 1)
+	s := testSession(dev)
+	s.alsoProxySubnets = []*net.IPNet{mustParseCIDR(t, "192.168.200.0/24")}
+
+	s.onClusterInfo(ctx, &manager.ClusterInfo{
+		ServiceSubnet: iputil.IPNetToRPC(mustParseCIDR(t, "10.96.0.0/12")),
+		PodSubnets: []*manager.IPNet{
+			iputil.IPNetToRPC(mustParseCIDR(t, "10.244.0.0/24")),
+			iputil.IPNetToRPC(mustParseCIDR(t, "10.244.1.0/24")),
+		},
+	})
+	assert.ElementsMatch(t, []string{"10.96.0.0/12", "10.244.0.0/24", "10.244.1.0/24", "192.168.200.0/24"}, cidrs(dev.Subnets()))
+	assert.Equal(t, []string{"10.96.0.0/12"}, cidrs(s.serviceSubnets))
+
+	// Subnets that are no longer reported are removed from the device
+	s.onClusterInfo(ctx, &manager.ClusterInfo{
+		ServiceSubnet: iputil.IPNetToRPC(mustParseCIDR(t, "10.96.0.0/12")),
+		PodSubnets:    []*manager.IPNet{iputil.IPNetToRPC(mustParseCIDR(t, "10.244.1.0/24"))},
+	})
+	assert.ElementsMatch(t, []string{"10.96.0.0/12", "10.244.1.0/24", "192.168.200.0/24"}, cidrs(dev.Subnets()))
+}
+
+func Test_onClusterInfo_minimizeSubnets(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	dev := fake.NewDevice("tel0", 1)
+	s := testSession(dev)
+	s.minimizeSubnets = true
+
+	// The service subnet is used until the traffic-manager reports subnets that cover the service IPs
+	info := &manager.ClusterInfo{ServiceSubnet: iputil.IPNetToRPC(mustParseCIDR(t, "10.96.0.0/12"))}
+	s.onClusterInfo(ctx, info)
+	assert.Equal(t, []string{"10.96.0.0/12"}, cidrs(dev.Subnets()))
+
+	info.ServiceIpSubnets = []*manager.IPNet{
+		iputil.IPNetToRPC(mustParseCIDR(t, "10.96.0.0/24")),
+		iputil.IPNetToRPC(mustParseCIDR(t, "10.100.12.0/24")),
+	}
+	s.onClusterInfo(ctx, info)
+	assert.ElementsMatch(t, []string{"10.96.0.0/24", "10.100.12.0/24"}, cidrs(dev.Subnets()))
+	assert.ElementsMatch(t, []string{"10.96.0.0/24", "10.100.12.0/24"}, cidrs(s.serviceSubnets))
+}
+
+func Test_onClusterInfo_noProxyCluster(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	dev := fake.NewDevice("tel0", 1)
+	s := testSession(dev)
+	s.proxyCluster = false
+	s.alsoProxySubnets = []*net.IPNet{mustParseCIDR(t, "192.168.200.0/24")}
+
+	s.onClusterInfo(ctx, &manager.ClusterInfo{
+		ServiceSubnet: iputil.IPNetToRPC(mustParseCIDR(t, "10.96.0.0/12")),
+		PodSubnets:    []*manager.IPNet{iputil.IPNetToRPC(mustParseCIDR(t, "10.244.0.0/16"))},
+	})
+	assert.Equal(t, []string{"192.168.200.0/24"}, cidrs(dev.Subnets()))
+}
+
+func Test_reconcileStaticRoutes(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	dev := fake.NewDevice("tel0", 1)
+	s := testSession(dev)
+	eth0 := &net.Interface{Name: "eth0", Index: 2}
+	s.neverProxySubnets = []routing.Route{
+		{RoutedNet: mustParseCIDR(t, "10.244.3.0/24"), Interface: eth0, Gateway: net.IP{192, 168, 1, 1}},
+		{RoutedNet: mustParseCIDR(t, "172.16.0.0/16"), Interface: eth0, Gateway: net.IP{192, 168, 1, 1}},
+	}
+
+	info := &manager.ClusterInfo{PodSubnets: []*manager.IPNet{iputil.IPNetToRPC(mustParseCIDR(t, "10.244.0.0/16"))}}
+	s.onClusterInfo(ctx, info)
+
+	// Only the never-proxy subnet that overlaps with a routed subnet needs a static route
+	routes := dev.StaticRoutes()
+	require.Len(t, routes, 1)
+	assert.Equal(t, "10.244.3.0/24", routes[0].RoutedNet.String())
+
+	// The static route is removed when the subnet that it overlaps with is no longer routed
+	info.PodSubnets = []*manager.IPNet{iputil.IPNetToRPC(mustParseCIDR(t, "10.245.0.0/16"))}
+	s.onClusterInfo(ctx, info)
+	assert.Empty(t, dev.StaticRoutes())
+}
+
+func Test_reconcileOverrideRoutes(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	dev := fake.NewDevice("tel0", 1)
+	s := testSession(dev)
+	s.allowConflictingSubnets = []*net.IPNet{
+		mustParseCIDR(t, "10.0.0.0/8"),
+		mustParseCIDR(t, "10.96.12.0/24"),
+		mustParseCIDR(t, "192.168.0.0/16"),
+	}
+
+	info := &manager.ClusterInfo{
+		ServiceSubnet: iputil.IPNetToRPC(mustParseCIDR(t, "10.96.0.0/16")),
+		PodSubnets:    []*manager.IPNet{iputil.IPNetToRPC(mustParseCIDR(t, "172.17.0.0/16"))},
+	}
+	s.onClusterInfo(ctx, info)
+
+	// An override route is added for the intersection of an allow-conflicting subnet and a routed subnet. Intersections
+	// that are covered by other intersections are dropped.
+	assert.Equal(t, []string{"10.96.0.0/16"}, cidrs(dev.OverrideRoutes()))
+
+	info.ServiceSubnet = nil
+	s.onClusterInfo(ctx, info)
+	assert.Empty(t, dev.OverrideRoutes())
+}
+
+func Test_reconcileOverrideRoutes_neverProxy(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	dev := fake.NewDevice("tel0", 1)
+	s := testSession(dev)
+	s.allowConflictingSubnets = []*net.IPNet{mustParseCIDR(t, "10.96.0.0/16")}
+
+	// The never-proxy subnets, such as the IP of the API server, are exceptions of the override routes.
+	apiServer := mustParseCIDR(t, "10.96.0.1/32")
+	s.neverProxySubnets = []routing.Route{{RoutedNet: apiServer, Interface: &net.Interface{Name: "en0"}}}
+	s.onClusterInfo(ctx, &manager.ClusterInfo{ServiceSubnet: iputil.IPNetToRPC(mustParseCIDR(t, "10.96.0.0/16"))})
+
+	overrides := dev.OverrideRoutes()
+	require.Len(t, overrides, 1)
+	assert.Equal(t, []string{"10.96.0.1/32"}, cidrs(dev.OverrideExceptions(overrides[0])))
+	routes := dev.StaticRoutes()
+	require.Len(t, routes, 1)
+	assert.Equal(t, apiServer, routes[0].RoutedNet)
+}
+
+func Test_refreshSubnets_scopes(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	scopes := newSessionScopes()
+	newScoped := func(id string, dev *fake.Device) *session {
+		s := testSession(dev)
+		s.session = &manager.SessionInfo{SessionId: id}
+		s.scopes = scopes
+		return s
+	}
+	devA := fake.NewDevice("tel0", 1)
+	a := newScoped("a", devA)
+	a.alsoProxySubnets = []*net.IPNet{mustParseCIDR(t, "10.96.0.0/12")}
+	require.NoError(t, a.refreshSubnets(ctx))
+	assert.Equal(t, []string{"10.96.0.0/12"}, cidrs(devA.Subnets()))
+
+	// The subnets of the other session aren't routed again
+	devB := fake.NewDevice("tel1", 2)
+	b := newScoped("b", devB)
+	b.alsoProxySubnets = []*net.IPNet{mustParseCIDR(t, "10.100.0.0/16"), mustParseCIDR(t, "172.20.0.0/16")}
+	require.NoError(t, b.refreshSubnets(ctx))
+	assert.Equal(t, []string{"172.20.0.0/16"}, cidrs(devB.Subnets()))
+
+	// They're routed once the other session has ended
+	scopes.release("a")
+	require.NoError(t, b.refreshSubnets(ctx))
+	assert.ElementsMatch(t, []string{"10.100.0.0/16", "172.20.0.0/16"}, cidrs(devB.Subnets()))
+}
+
+func Test_getNetworkStats_routeOrigins(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	dev := fake.NewDevice("tel0", 1)
+	s := testSession(dev)
+	s.alsoProxySubnets = []*net.IPNet{
+		mustParseCIDR(t, "10.244.0.0/16"),
+		mustParseCIDR(t, "192.168.200.0/24"),
+		mustParseCIDR(t, "172.20.0.0/16"),
+	}
+	s.onClusterInfo(ctx, &manager.ClusterInfo{
+		ServiceSubnet: iputil.IPNetToRPC(mustParseCIDR(t, "172.20.0.0/16")),
+		PodSubnets: []*manager.IPNet{
+			iputil.IPNetToRPC(mustParseCIDR(t, "10.244.0.0/24")),
+			iputil.IPNetToRPC(mustParseCIDR(t, "10.245.0.0/24")),
+		},
+	})
+
+	origins := make(map[string]string)
+	for _, r := range s.getNetworkStats().Routes {
+		origins[iputil.IPNetFromRPC(r.Subnet).String()] = r.Origin
+	}
+	assert.Equal(t, map[string]string{
+		"172.20.0.0/16":    "service-subnet,also-proxy",
+		"10.244.0.0/16":    "pod-subnet,also-proxy", // covers the 10.244.0.0/24 pod subnet
+		"10.245.0.0/24":    "pod-subnet",
+		"192.168.200.0/24": "also-proxy",
+	}, origins)
+}
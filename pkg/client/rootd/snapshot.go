@@ -0,0 +1,337 @@
+package rootd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/logging"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/rootd/dns"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+	"github.com/telepresenceio/telepresence/v2/pkg/subnet"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/routing"
+)
+
+// networkSnapshot is the part of the network configuration of the machine that a session modifies, as it was before
+// the session modified it. It is persisted while the session is active, so that "telepresence quit --force-cleanup"
+// can restore it when the root daemon didn't get a chance to do so, e.g. because it crashed.
+//
+// Routes through the TUN device vanish with the device, so only the routes of other interfaces are of interest.
+type networkSnapshot struct {
+	// Routes is the routing table of the machine.
+	Routes []snapshotRoute `json:"routes,omitempty"`
+
+	// NeverProxySubnets are the subnets that the session adds routes for through other interfaces.
+	NeverProxySubnets []string `json:"neverProxySubnets,omitempty"`
+
+	// AllowConflictingSubnets are the subnets that the session may remove the routes of other interfaces for.
+	AllowConflictingSubnets []string `json:"allowConflictingSubnets,omitempty"`
+
+	// OverriddenRoutes are the routes of other interfaces that the session has removed because they conflicted with
+	// an allow-conflicting subnet. Unlike the Routes, they are updated while the session is active, so that routes
+	// that were added after the snapshot was taken, e.g. by a VPN, aren't lost.
+	OverriddenRoutes []snapshotRoute `json:"overriddenRoutes,omitempty"`
+
+	// DNS is the DNS configuration of the machine.
+	DNS *dns.Snapshot `json:"dns,omitempty"`
+}
+
+type snapshotRoute struct {
+	Subnet    string `json:"subnet"`
+	Gateway   string `json:"gateway"`
+	Interface string `json:"interface"`
+}
+
+func toSnapshotRoute(r *routing.Route) snapshotRoute {
+	return snapshotRoute{Subnet: r.RoutedNet.String(), Gateway: r.Gateway.String(), Interface: r.Interface.Name}
+}
+
+// toRoute returns the routing.Route of this snapshotRoute, or an error if the route can no longer be added, e.g.
+// because its interface is gone.
+func (sr *snapshotRoute) toRoute() (routing.Route, error) {
+	_, sn, err := net.ParseCIDR(sr.Subnet)
+	if err != nil {
+		return routing.Route{}, err
+	}
+	gw := iputil.Parse(sr.Gateway)
+	if gw == nil {
+		return routing.Route{}, fmt.Errorf("unable to parse gateway %q", sr.Gateway)
+	}
+	iface, err := net.InterfaceByName(sr.Interface)
+	if err != nil {
+		return routing.Route{}, err
+	}
+	return routing.Route{RoutedNet: sn, Gateway: gw, Interface: iface}, nil
+}
+
+// snapshotNetwork persists a networkSnapshot for the session with the given ID and returns the name of its file.
+func snapshotNetwork(ctx context.Context, sessionID string, neverProxy []routing.Route, allowConflicting []*net.IPNet) (string, error) {
+	snap := networkSnapshot{}
+	rt, err := routing.GetRoutingTable(ctx)
+	if err != nil {
+		return "", err
+	}
+	for i := range rt {
+		snap.Routes = append(snap.Routes, toSnapshotRoute(&rt[i]))
+	}
+	for _, np := range neverProxy {
+		snap.NeverProxySubnets = append(snap.NeverProxySubnets, np.RoutedNet.String())
+	}
+	for _, ac := range allowConflicting {
+		snap.AllowConflictingSubnets = append(snap.AllowConflictingSubnets, ac.String())
+	}
+	if snap.DNS, err = dns.TakeSnapshot(ctx); err != nil {
+		return "", err
+	}
+
+	dir, err := client.NetworkSnapshotDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	if err = client.CheckNetworkSnapshot(dir); err != nil {
+		return "", err
+	}
+	file := filepath.Join(dir, filepath.Base(sessionID)+".json")
+	if err = writeNetworkSnapshot(file, &snap); err != nil {
+		return "", err
+	}
+	return file, nil
+}
+
+// writeNetworkSnapshot writes the given snapshot to the given file. The snapshot is written to a temporary file that
+// then replaces the file, so that a crash never leaves a partial snapshot behind.
+func writeNetworkSnapshot(file string, snap *networkSnapshot) error {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, file); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// journalOverriddenRoutes returns a vif.OverriddenRoutesJournal that records the routes that the override routes
+// have removed in the OverriddenRoutes of the snapshot in the given file.
+func journalOverriddenRoutes(file string) vif.OverriddenRoutesJournal {
+	return func(_ context.Context, removed []routing.Route) error {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		snap, err := parseNetworkSnapshot(data)
+		if err != nil {
+			return err
+		}
+		snap.OverriddenRoutes = make([]snapshotRoute, len(removed))
+		for i := range removed {
+			snap.OverriddenRoutes[i] = toSnapshotRoute(&removed[i])
+		}
+		return writeNetworkSnapshot(file, snap)
+	}
+}
+
+// validate checks that the snapshot only describes changes that a session could have made. A snapshot that doesn't
+// is rejected as a whole, rather than restored in part.
+func (snap *networkSnapshot) validate() error {
+	for _, sr := range append(snap.Routes[:len(snap.Routes):len(snap.Routes)], snap.OverriddenRoutes...) {
+		_, sn, err := net.ParseCIDR(sr.Subnet)
+		if err != nil {
+			return err
+		}
+		gw := iputil.Parse(sr.Gateway)
+		if gw == nil {
+			return fmt.Errorf("unable to parse gateway %q of route %s", sr.Gateway, sr.Subnet)
+		}
+		if (gw.To4() == nil) != (sn.IP.To4() == nil) {
+			return fmt.Errorf("gateway %s of route %s belongs to another IP family", sr.Gateway, sr.Subnet)
+		}
+		if sr.Interface == "" {
+			return fmt.Errorf("route %s has no interface", sr.Subnet)
+		}
+	}
+	// Routes are only added and removed for these subnets, so they must never cover the whole address space, or the
+	// restore would touch the default route.
+	for _, cidrs := range [][]string{snap.NeverProxySubnets, snap.AllowConflictingSubnets} {
+		for _, cidr := range cidrs {
+			_, sn, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return err
+			}
+			if ones, _ := sn.Mask.Size(); ones == 0 {
+				return fmt.Errorf("subnet %s covers all addresses", cidr)
+			}
+		}
+	}
+	return nil
+}
+
+// restore restores the routes and the DNS configuration of the snapshot. Routes of other interfaces that were
+// added for a never-proxy subnet are removed, and routes that were removed because they conflicted with an
+// allow-conflicting subnet are added back.
+func (snap *networkSnapshot) restore(ctx context.Context) {
+	if snap.DNS != nil {
+		if err := snap.DNS.Restore(ctx); err != nil {
+			dlog.Errorf(ctx, "unable to restore DNS configuration: %v", err)
+		}
+	}
+	vif.CleanupOverrideRoutes(ctx)
+
+	rt, err := routing.GetRoutingTable(ctx)
+	if err != nil {
+		dlog.Errorf(ctx, "unable to restore routes: %v", err)
+		return
+	}
+	current := make(map[snapshotRoute]struct{}, len(rt))
+	for i := range rt {
+		current[toSnapshotRoute(&rt[i])] = struct{}{}
+	}
+	before := make(map[snapshotRoute]struct{}, len(snap.Routes))
+	for _, sr := range snap.Routes {
+		before[sr] = struct{}{}
+	}
+
+	parse := func(cidrs []string) []*net.IPNet {
+		sns := make([]*net.IPNet, 0, len(cidrs))
+		for _, cidr := range cidrs {
+			if _, sn, err := net.ParseCIDR(cidr); err == nil {
+				sns = append(sns, sn)
+			}
+		}
+		return sns
+	}
+	neverProxy := parse(snap.NeverProxySubnets)
+	for i := range rt {
+		r := &rt[i]
+		if _, ok := before[toSnapshotRoute(r)]; ok {
+			continue
+		}
+		for _, np := range neverProxy {
+			if subnet.Equal(r.RoutedNet, np) {
+				dlog.Infof(ctx, "Removing route %s", r)
+				if err := r.RemoveStatic(ctx); err != nil {
+					dlog.Errorf(ctx, "unable to remove route %s: %v", r, err)
+				}
+				break
+			}
+		}
+	}
+
+	allowConflicting := parse(snap.AllowConflictingSubnets)
+	restored := make(map[snapshotRoute]struct{}, len(snap.OverriddenRoutes))
+	for _, sr := range append(snap.Routes[:len(snap.Routes):len(snap.Routes)], snap.OverriddenRoutes...) {
+		if _, ok := current[sr]; ok {
+			continue
+		}
+		if _, ok := restored[sr]; ok {
+			continue
+		}
+		restored[sr] = struct{}{}
+		if _, sn, _ := net.ParseCIDR(sr.Subnet); sn != nil {
+			if ones, _ := sn.Mask.Size(); ones == 0 {
+				// A missing default route wasn't removed by a session.
+				continue
+			}
+		}
+		r, err := sr.toRoute()
+		if err != nil {
+			dlog.Debugf(ctx, "not restoring route %s via %s dev %s: %v", sr.Subnet, sr.Gateway, sr.Interface, err)
+			continue
+		}
+		for _, ac := range allowConflicting {
+			if subnet.Covers(ac, r.RoutedNet) {
+				dlog.Infof(ctx, "Restoring route %s", r)
+				if err := r.AddStatic(ctx); err != nil {
+					dlog.Errorf(ctx, "unable to restore route %s: %v", r, err)
+				}
+				break
+			}
+		}
+	}
+}
+
+// restoreNetworkSnapshots restores, and then removes, all snapshots in the client.NetworkSnapshotDir.
+func restoreNetworkSnapshots(ctx context.Context) error {
+	files, err := client.NetworkSnapshots(ctx)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		dlog.Info(ctx, "No network snapshots to restore")
+		return nil
+	}
+	for _, file := range files {
+		dlog.Infof(ctx, "Restoring network snapshot %s", file)
+		if err = client.CheckNetworkSnapshot(file); err != nil {
+			// Not removed either, because it wasn't written by the root daemon.
+			dlog.Errorf(ctx, "refusing to restore network snapshot %s: %v", file, err)
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if snap, err := parseNetworkSnapshot(data); err != nil {
+			dlog.Errorf(ctx, "unable to restore network snapshot %s: %v", file, err)
+		} else {
+			snap.restore(ctx)
+		}
+		if err = os.Remove(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseNetworkSnapshot returns the validated networkSnapshot of the given data.
+func parseNetworkSnapshot(data []byte) (*networkSnapshot, error) {
+	var snap networkSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, err
+	}
+	if err := snap.validate(); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// CleanupCommand returns the telepresence sub-command "daemon-cleanup" that restores the network snapshots that were
+// left behind by a root daemon that didn't get a chance to restore them.
+func CleanupCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:    ProcessName + "-cleanup <logging dir> <config dir>",
+		Short:  "Restore the network configuration that a terminated Telepresence " + titleName + " left behind",
+		Args:   cobra.ExactArgs(2),
+		Hidden: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !(proc.IsAdmin() || proc.HasNetAdmin()) {
+				return fmt.Errorf("telepresence %s-cleanup must run with elevated privileges or with the CAP_NET_ADMIN capability", ProcessName)
+			}
+			ctx := filelocation.WithAppUserLogDir(cmd.Context(), args[0])
+			ctx = filelocation.WithAppUserConfigDir(ctx, args[1])
+			ctx, err := logging.InitContext(ctx, ProcessName, logging.RotateDaily, false)
+			if err != nil {
+				return err
+			}
+			return restoreNetworkSnapshots(ctx)
+		},
+	}
+}
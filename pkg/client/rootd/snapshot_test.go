@@ -0,0 +1,79 @@
+package rootd
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/routing"
+)
+
+func TestParseNetworkSnapshot(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		ok   bool
+	}{
+		{
+			"valid",
+			`{"routes":[{"subnet":"10.0.0.0/16","gateway":"192.168.1.1","interface":"en0"}],` +
+				`"neverProxySubnets":["10.0.5.0/24"],"allowConflictingSubnets":["10.0.0.0/16"],"dns":{}}`,
+			true,
+		},
+		{"empty", `{}`, true},
+		{"not json", `routes`, false},
+		{"bad subnet", `{"routes":[{"subnet":"10.0.0.0","gateway":"192.168.1.1","interface":"en0"}]}`, false},
+		{"bad gateway", `{"routes":[{"subnet":"10.0.0.0/16","gateway":"gw","interface":"en0"}]}`, false},
+		{"mixed families", `{"routes":[{"subnet":"10.0.0.0/16","gateway":"fe80::1","interface":"en0"}]}`, false},
+		{"no interface", `{"routes":[{"subnet":"10.0.0.0/16","gateway":"192.168.1.1","interface":""}]}`, false},
+		{"default never-proxy", `{"neverProxySubnets":["0.0.0.0/0"]}`, false},
+		{"default allow-conflicting", `{"allowConflictingSubnets":["::/0"]}`, false},
+		{"bad allow-conflicting", `{"allowConflictingSubnets":["10.0.0.1"]}`, false},
+		{"overridden", `{"overriddenRoutes":[{"subnet":"10.0.1.0/24","gateway":"10.8.0.1","interface":"utun3"}]}`, true},
+		{"bad overridden", `{"overriddenRoutes":[{"subnet":"10.0.1.0/24","gateway":"gw","interface":"utun3"}]}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snap, err := parseNetworkSnapshot([]byte(tt.data))
+			if tt.ok {
+				require.NoError(t, err)
+				assert.NotNil(t, snap)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestJournalOverriddenRoutes(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "session.json")
+	snap := &networkSnapshot{AllowConflictingSubnets: []string{"10.0.0.0/16"}}
+	require.NoError(t, writeNetworkSnapshot(file, snap))
+
+	_, sn, err := net.ParseCIDR("10.0.1.0/24")
+	require.NoError(t, err)
+	removed := []routing.Route{{RoutedNet: sn, Gateway: net.IP{10, 8, 0, 1}, Interface: &net.Interface{Name: "utun3"}}}
+	journal := journalOverriddenRoutes(file)
+	require.NoError(t, journal(context.Background(), removed))
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	got, err := parseNetworkSnapshot(data)
+	require.NoError(t, err)
+	assert.Equal(t, snap.AllowConflictingSubnets, got.AllowConflictingSubnets)
+	assert.Equal(t, []snapshotRoute{{Subnet: "10.0.1.0/24", Gateway: "10.8.0.1", Interface: "utun3"}}, got.OverriddenRoutes)
+
+	// The routes are replaced, not appended, when the set of removed routes changes.
+	require.NoError(t, journal(context.Background(), nil))
+	data, err = os.ReadFile(file)
+	require.NoError(t, err)
+	got, err = parseNetworkSnapshot(data)
+	require.NoError(t, err)
+	assert.Empty(t, got.OverriddenRoutes)
+	assert.NoFileExists(t, file+".tmp")
+}
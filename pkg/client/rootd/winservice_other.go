@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package rootd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// ServiceCommand returns nil. The daemon only runs as a service on Windows.
+func ServiceCommand() *cobra.Command {
+	return nil
+}
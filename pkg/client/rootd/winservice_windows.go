@@ -0,0 +1,88 @@
+package rootd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/windows/svc"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// ServiceCommand returns the telepresence sub-command "daemon-service" that the Windows service control manager
+// (SCM) uses to run the daemon as a service. The service is installed with "telepresence service install". Once
+// it's installed, the CLI starts the daemon by starting the service, which doesn't require a UAC prompt.
+func ServiceCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:    ProcessName + "-service <logging dir> <config dir>",
+		Short:  "Launch Telepresence " + titleName + " as a Windows service",
+		Args:   cobra.ExactArgs(2),
+		Hidden: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			isService, err := svc.IsWindowsService()
+			if err != nil {
+				return err
+			}
+			if !isService {
+				return fmt.Errorf("%s can only be started by the Windows service control manager", cmd.Name())
+			}
+			ctx, err = client.WithSocketUserOfDir(ctx, args[1])
+			if err != nil {
+				return err
+			}
+			name, err := client.DaemonServiceName(ctx)
+			if err != nil {
+				return err
+			}
+			return svc.Run(name, &serviceHandler{ctx: ctx, logDir: args[0], configDir: args[1]})
+		},
+	}
+}
+
+type serviceHandler struct {
+	ctx       context.Context
+	logDir    string
+	configDir string
+}
+
+// Execute runs the daemon until it quits, or until the SCM tells the service to stop. The arguments that the
+// service is started with are the name of the service followed by the flags of the "daemon-foreground" command.
+func (h *serviceHandler) Execute(args []string, r <-chan svc.ChangeRequest, s chan<- svc.Status) (bool, uint32) {
+	s <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(h.ctx)
+	defer cancel()
+	for _, arg := range args[1:] {
+		if arg == "--offline" {
+			ctx = client.WithOffline(ctx)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- run(ctx, h.logDir, h.configDir)
+	}()
+	s <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case err := <-done:
+			s <- svc.Status{State: svc.StopPending}
+			if err != nil {
+				dlog.Errorf(ctx, "%s ended with: %v", ProcessName, err)
+				return false, 1
+			}
+			return false, 0
+		case cr := <-r:
+			switch cr.Cmd {
+			case svc.Interrogate:
+				s <- cr.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				s <- svc.Status{State: svc.StopPending}
+				cancel()
+			}
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package client
+
+import (
+	"context"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// sessionIDMetadataKey is the gRPC metadata key that identifies the session that a call to the root
+// daemon concerns. A root daemon can host the sessions of several user daemons simultaneously.
+const sessionIDMetadataKey = "x-telepresence-session-id"
+
+// WithRootDaemonSession returns a context that makes calls to the root daemon concern the session with
+// the given ID.
+func WithRootDaemonSession(ctx context.Context, sessionID string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, sessionIDMetadataKey, sessionID)
+}
+
+// RootDaemonSession returns the ID of the session that an incoming call to the root daemon concerns, or
+// an empty string if the call doesn't identify a session.
+func RootDaemonSession(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(sessionIDMetadataKey); len(ids) > 0 {
+			return ids[0]
+		}
+	}
+	return ""
+}
@@ -2,6 +2,8 @@ package scout
 
 import (
 	"context"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -32,6 +34,7 @@ type Reporter struct {
 	buffer   chan bufEntry
 	done     chan struct{}
 	reporter *metriton.Reporter
+	proxy    string
 }
 
 // Entry is a key/value association used when reporting
@@ -304,6 +307,10 @@ func (r *Reporter) Report(ctx context.Context, action string, entries ...Entry)
 }
 
 func (r *Reporter) doReport(ctx context.Context, be *bufEntry) {
+	if !client.TelemetryEnabled(ctx) {
+		return
+	}
+	r.applyTelemetryConfig(ctx)
 	r.index++
 	metadata := make(map[string]any, 4+len(be.entries))
 	metadata["action"] = be.action
@@ -323,6 +330,32 @@ func (r *Reporter) doReport(ctx context.Context, be *bufEntry) {
 	}
 }
 
+// applyTelemetryConfig makes the metriton reporter use the report endpoint and the proxy of the telemetry config.
+// It's called before each report, so that changes to the config take effect while the reporter runs.
+func (r *Reporter) applyTelemetryConfig(ctx context.Context) {
+	cfg := client.GetConfig(ctx)
+	if cfg == nil {
+		return
+	}
+	tc := &cfg.Telemetry
+	if tc.ReportEndpoint != "" {
+		r.reporter.Endpoint = tc.ReportEndpoint
+	}
+	if tc.Proxy == r.proxy {
+		return
+	}
+	r.proxy = tc.Proxy
+	r.reporter.Client = nil
+	if tc.Proxy != "" {
+		pu, err := url.Parse(tc.Proxy)
+		if err != nil {
+			dlog.Errorf(ctx, "invalid telemetry proxy %q: %v", tc.Proxy, err)
+			return
+		}
+		r.reporter.Client = &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(pu)}}
+	}
+}
+
 // Returns a metadata map containing all the additional environment variables to be reported
 func getDefaultEnvironmentMetadata() map[string]string {
 	metadata := map[string]string{}
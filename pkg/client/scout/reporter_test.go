@@ -20,6 +20,7 @@ import (
 	"github.com/datawire/dlib/dcontext"
 	"github.com/datawire/dlib/dlog"
 	"github.com/datawire/metriton-go-client/metriton"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
 	"github.com/telepresenceio/telepresence/v2/pkg/version"
 )
@@ -495,3 +496,81 @@ func TestReport(t *testing.T) {
 		})
 	}
 }
+
+func TestReportTelemetryConfig(t *testing.T) {
+	type testcase struct {
+		Telemetry     client.Telemetry
+		Offline       bool
+		UseEndpoint   bool
+		ExpectReports int
+	}
+	testcases := map[string]testcase{
+		"enabled": {
+			ExpectReports: 1,
+		},
+		"disabled": {
+			Telemetry: client.Telemetry{Disabled: true},
+		},
+		"offline": {
+			Offline: true,
+		},
+		"report-endpoint": {
+			UseEndpoint:   true,
+			ExpectReports: 1,
+		},
+	}
+	for tcName, tcData := range testcases {
+		tcData := tcData
+		t.Run(tcName, func(t *testing.T) {
+			ctx := dlog.NewTestContext(t, true)
+
+			// Mock server counting reports
+			var mu sync.Mutex
+			reports := 0
+			testServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				mu.Lock()
+				reports++
+				mu.Unlock()
+			}))
+			defer testServer.Close()
+
+			cfg := client.GetDefaultConfig()
+			cfg.Telemetry = tcData.Telemetry
+			cfg.Offline = tcData.Offline
+			endpoint := testServer.URL
+			if tcData.UseEndpoint {
+				cfg.Telemetry.ReportEndpoint = testServer.URL
+				endpoint = "http://unused.invalid"
+			}
+			ctx = client.WithConfig(ctx, &cfg)
+
+			scout := &Reporter{
+				buffer: make(chan bufEntry, 40),
+				reporter: &metriton.Reporter{
+					Application: "telepresence2",
+					Version:     "v2.4.5-test",
+					GetInstallID: func(r *metriton.Reporter) (string, error) {
+						return "00000000-1111-2222-3333-444444444444", nil
+					},
+					Endpoint: endpoint,
+				},
+			}
+			scout.initialize(ctx, "test-mode", "linux", "amd64")
+
+			sc, cancel := context.WithCancel(dcontext.WithSoftness(ctx))
+			wg := &sync.WaitGroup{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, scout.Run(sc))
+			}()
+			scout.Report(ctx, "test-action")
+			cancel()
+			wg.Wait()
+
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, tcData.ExpectReports, reports)
+		})
+	}
+}
@@ -0,0 +1,15 @@
+package client
+
+import (
+	"context"
+)
+
+// DaemonServiceName returns the name of the Windows service that runs the root daemon of the socket user. Each
+// user has its own service, because the root daemon serves the named pipe of the user that installed it.
+func DaemonServiceName(ctx context.Context) (string, error) {
+	sid, err := SocketUserSID(ctx)
+	if err != nil {
+		return "", err
+	}
+	return "TelepresenceDaemon-" + sid, nil
+}
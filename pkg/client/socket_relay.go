@@ -0,0 +1,129 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/datawire/dlib/dcontext"
+	"github.com/datawire/dlib/dexec"
+	"github.com/telepresenceio/telepresence/v2/pkg/shellquote"
+)
+
+// relayDialTimeout is how long DialRelay waits for the daemon to respond. It's generous, because the relay may have
+// to start the daemons before it can relay anything.
+const relayDialTimeout = 30 * time.Second
+
+// RelayReady is written by a relay process when it has reached the socket of the daemon, before it starts to relay.
+const RelayReady byte = 0x06
+
+// DialRelay dials a daemon through a relay process that is started with the given executable and arguments, and
+// returns the resulting connection. The relay must copy everything between its stdin/stdout and the socket of the
+// daemon, and must write RelayReady before it starts to do so. It's used where the socket can't be reached but the
+// relay can be started, like in a WSL2 distribution, which can run Windows executables but can't open the named
+// pipes of Windows.
+func DialRelay(ctx context.Context, exe string, args []string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	ctx, cancel := context.WithTimeout(ctx, relayDialTimeout)
+	defer cancel()
+	return grpc.DialContext(ctx, "passthrough:///"+exe, append([]grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithNoProxy(),
+		grpc.WithBlock(),
+		grpc.FailOnNonTempDialError(true),
+		grpc.WithContextDialer(func(c context.Context, _ string) (net.Conn, error) {
+			conn, err := startRelay(c, exe, args)
+			if err != nil {
+				// Starting the relay again won't help, so the dial must fail rather than be retried
+				err = relayError{err}
+			}
+			return conn, err
+		}),
+	}, opts...)...)
+}
+
+// startRelay starts the relay process and waits for it to become ready. The process must outlive the dial, so it's
+// terminated when the returned connection is closed rather than when the given context is cancelled. The process
+// writes its own messages and errors to the stderr of this process.
+func startRelay(c context.Context, exe string, args []string) (net.Conn, error) {
+	cmd := dexec.CommandContext(dcontext.WithoutCancel(c), exe, args...)
+	cmd.DisableLogging = true
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stderr = os.Stderr
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	rc := &relayConn{Reader: stdout, WriteCloser: stdin, cmd: cmd}
+	ready := make([]byte, 1)
+	if _, err = io.ReadFull(stdout, ready); err != nil || ready[0] != RelayReady {
+		_ = rc.Close()
+		return nil, fmt.Errorf("%s exited before it reached the daemon", shellquote.ShellString(exe, args))
+	}
+	return rc, nil
+}
+
+// relayError is an error that gRPC doesn't consider temporary, so that FailOnNonTempDialError applies to it.
+type relayError struct {
+	error
+}
+
+func (relayError) Temporary() bool {
+	return false
+}
+
+// relayConn is a net.Conn that reads from the stdout, and writes to the stdin, of a relay process. It doesn't
+// support deadlines.
+type relayConn struct {
+	io.Reader
+	io.WriteCloser
+	cmd *dexec.Cmd
+}
+
+type relayAddr string
+
+func (a relayAddr) Network() string {
+	return "relay"
+}
+
+func (a relayAddr) String() string {
+	return string(a)
+}
+
+func (c *relayConn) Close() error {
+	_ = c.WriteCloser.Close()
+	_ = c.cmd.Process.Kill()
+	_ = c.cmd.Wait()
+	return nil
+}
+
+func (c *relayConn) LocalAddr() net.Addr {
+	return relayAddr("stdio")
+}
+
+func (c *relayConn) RemoteAddr() net.Addr {
+	return relayAddr(c.cmd.Path)
+}
+
+func (c *relayConn) SetDeadline(time.Time) error {
+	return nil
+}
+
+func (c *relayConn) SetReadDeadline(time.Time) error {
+	return nil
+}
+
+func (c *relayConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
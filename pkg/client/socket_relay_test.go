@@ -0,0 +1,103 @@
+//go:build !windows
+// +build !windows
+
+package client_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// The modes of the relay helper process.
+const (
+	relayModeRelay = "relay" // relays to the socket
+	relayModeExit  = "exit"  // exits without writing client.RelayReady
+	relayModeBad   = "bad"   // writes something other than client.RelayReady
+)
+
+// TestRelayHelperProcess isn't a real test. It's the relay process that the DialRelay tests start.
+func TestRelayHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	mode, socket := args[1], args[2]
+	switch mode {
+	case relayModeExit:
+		os.Exit(1)
+	case relayModeBad:
+		_, _ = os.Stdout.Write([]byte("x"))
+		_, _ = io.Copy(io.Discard, os.Stdin)
+		os.Exit(0)
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		os.Exit(1)
+	}
+	if _, err = os.Stdout.Write([]byte{client.RelayReady}); err != nil {
+		os.Exit(1)
+	}
+	go func() {
+		_, _ = io.Copy(conn, os.Stdin)
+		_ = conn.Close()
+	}()
+	_, _ = io.Copy(os.Stdout, conn)
+	os.Exit(0)
+}
+
+// dialRelayHelper dials through a relay helper process that uses the given mode.
+func dialRelayHelper(ctx context.Context, t *testing.T, mode, socket string) (*grpc.ClientConn, error) {
+	t.Setenv("GO_WANT_HELPER_PROCESS", "1")
+	return client.DialRelay(ctx, os.Args[0], []string{"-test.run=TestRelayHelperProcess", "--", mode, socket})
+}
+
+func TestDialRelay(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	socket := filepath.Join(t.TempDir(), "test.socket")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(srv, health.NewServer())
+	go func() { _ = srv.Serve(listener) }()
+	defer srv.Stop()
+
+	conn, err := dialRelayHelper(ctx, t, relayModeRelay, socket)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		rsp, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, rsp.Status)
+	}
+	assert.NoError(t, conn.Close())
+}
+
+func TestDialRelay_notReady(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	socket := filepath.Join(t.TempDir(), "test.socket")
+	for _, mode := range []string{relayModeExit, relayModeBad, relayModeRelay} {
+		t.Run(mode, func(t *testing.T) {
+			// There's nothing to relay to, so the relay fails also in the relay mode
+			start := time.Now()
+			_, err := dialRelayHelper(ctx, t, mode, socket)
+			assert.ErrorContains(t, err, "exited before it reached the daemon")
+			assert.Less(t, time.Since(start), 10*time.Second, "the dial doesn't fail fast")
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// tokenMetadataKey is the gRPC metadata key that carries the authentication token of a daemon that
+// listens to a TCP port.
+const tokenMetadataKey = "x-telepresence-token"
+
+// NewSocketToken returns a new random token that a client must present when it connects to a daemon
+// using TCP.
+func NewSocketToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ListenTCP returns a listener for the given TCP address. The host defaults to localhost when
+// the address only contains a port. A host that isn't a loopback address is refused unless tlsConfig
+// is non-nil, because the token, and all other traffic, would then reach the network in clear text.
+func ListenTCP(ctx context.Context, processName, address string, tlsConfig *tls.Config) (net.Listener, error) {
+	if strings.HasPrefix(address, ":") {
+		address = "localhost" + address
+	}
+	if tlsConfig == nil {
+		if err := checkLoopback(ctx, address); err != nil {
+			return nil, errcat.Config.Newf("%s refuses to listen to %s without TLS: %v", processName, address, err)
+		}
+	}
+	lc := net.ListenConfig{}
+	listener, err := lc.Listen(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("%s unable to listen to %s: %w", processName, address, err)
+	}
+	return listener, nil
+}
+
+// checkLoopback returns an error unless all addresses of the host of the given address are loopback addresses.
+func checkLoopback(ctx context.Context, address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else if host != "" {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return err
+		}
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return fmt.Errorf("%q is not a loopback address", host)
+	}
+	for _, ip := range ips {
+		if !ip.IsLoopback() {
+			return fmt.Errorf("%s is not a loopback address", ip)
+		}
+	}
+	return nil
+}
+
+// TokenHandler returns a http.Handler that ensures that all gRPC calls present the given token before
+// they are passed on to the given handler. It's intended for the server of a TCP listener, since unlike a
+// unix socket or a named pipe, such a listener can be reached by other users. Calls from clients that
+// have presented a verified TLS client certificate don't need the token.
+func TokenHandler(token string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verified := r.TLS != nil && len(r.TLS.VerifiedChains) > 0
+		if !verified && subtle.ConstantTimeCompare([]byte(r.Header.Get(tokenMetadataKey)), []byte(token)) != 1 {
+			// Respond with a trailers-only gRPC response
+			h := w.Header()
+			h.Set("Content-Type", "application/grpc")
+			h.Set("Grpc-Status", strconv.Itoa(int(codes.Unauthenticated)))
+			h.Set("Grpc-Message", "invalid or missing token")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// ServerTLSConfig returns the TLS configuration of a daemon that uses the given certificate and key. Clients
+// must present a certificate signed by a CA in the given clientCAFile unless it's empty.
+func ServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile != "" {
+		if cfg.ClientCAs, err = loadCertPool(clientCAFile); err != nil {
+			return nil, err
+		}
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// ClientTLSConfig returns the TLS configuration used when dialing a daemon. The daemon's certificate is verified
+// using the CAs in the given caFile, or using the host's root CAs when it's empty. The client presents the given
+// certificate unless certFile is empty.
+func ClientTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	var err error
+	if caFile != "" {
+		if cfg.RootCAs, err = loadCertPool(caFile); err != nil {
+			return nil, err
+		}
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return pool, nil
+}
+
+// DialTCP dials a daemon that listens to the given TCP address and returns the resulting connection.
+// The given token, if any, is presented in all calls. TLS is used unless tlsConfig is nil.
+func DialTCP(ctx context.Context, address, token string, tlsConfig *tls.Config, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	dos := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithNoProxy(),
+		grpc.WithBlock(),
+		grpc.FailOnNonTempDialError(true),
+	}
+	if token != "" {
+		dos = append(dos, grpc.WithPerRPCCredentials(tokenCredentials(token)))
+	}
+	return grpc.DialContext(ctx, address, append(dos, opts...)...)
+}
+
+// tokenCredentials is a credentials.PerRPCCredentials that presents a token.
+type tokenCredentials string
+
+func (t tokenCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{tokenMetadataKey: string(t)}, nil
+}
+
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
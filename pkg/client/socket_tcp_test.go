@@ -0,0 +1,187 @@
+package client_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/datawire/dlib/dgroup"
+	"github.com/datawire/dlib/dhttp"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+func TestDialTCP(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	token, err := client.NewSocketToken()
+	require.NoError(t, err)
+	listener, err := client.ListenTCP(ctx, "test", ":0", nil)
+	require.NoError(t, err)
+
+	grp := dgroup.NewGroup(ctx, dgroup.GroupConfig{
+		EnableWithSoftness: true,
+		ShutdownOnNonError: true,
+		DisableLogging:     true,
+	})
+
+	grp.Go("server", func(ctx context.Context) error {
+		srv := grpc.NewServer()
+		grpc_health_v1.RegisterHealthServer(srv, health.NewServer())
+		sc := &dhttp.ServerConfig{Handler: client.TokenHandler(token, srv)}
+		return sc.Serve(ctx, listener)
+	})
+
+	grp.Go("client", func(ctx context.Context) error {
+		check := func(token string) error {
+			conn, err := client.DialTCP(ctx, listener.Addr().String(), token, nil)
+			require.NoError(t, err)
+			defer conn.Close()
+			_, err = grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+			return err
+		}
+		assert.NoError(t, check(token))
+		assert.Equal(t, codes.Unauthenticated, status.Code(check("bogus")))
+		return nil
+	})
+
+	assert.NoError(t, grp.Wait())
+}
+
+func TestDialTCP_mTLS(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	dir := t.TempDir()
+	caCert, caKey := newTestCert(t, dir, "ca", nil, nil)
+	newTestCert(t, dir, "server", caCert, caKey)
+	newTestCert(t, dir, "client", caCert, caKey)
+	file := func(name string) string { return filepath.Join(dir, name) }
+
+	serverTLS, err := client.ServerTLSConfig(file("server.crt"), file("server.key"), file("ca.crt"))
+	require.NoError(t, err)
+	clientTLS, err := client.ClientTLSConfig(file("ca.crt"), file("client.crt"), file("client.key"))
+	require.NoError(t, err)
+	noCertTLS, err := client.ClientTLSConfig(file("ca.crt"), "", "")
+	require.NoError(t, err)
+
+	listener, err := client.ListenTCP(ctx, "test", "localhost:0", serverTLS)
+	require.NoError(t, err)
+
+	grp := dgroup.NewGroup(ctx, dgroup.GroupConfig{
+		EnableWithSoftness: true,
+		ShutdownOnNonError: true,
+		DisableLogging:     true,
+	})
+
+	grp.Go("server", func(ctx context.Context) error {
+		srv := grpc.NewServer()
+		grpc_health_v1.RegisterHealthServer(srv, health.NewServer())
+		sc := &dhttp.ServerConfig{Handler: client.TokenHandler("secret", srv), TLSConfig: serverTLS}
+		return sc.ServeTLS(ctx, listener, "", "")
+	})
+
+	grp.Go("client", func(ctx context.Context) error {
+		// A verified client certificate replaces the token
+		conn, err := client.DialTCP(ctx, listener.Addr().String(), "", clientTLS)
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+		assert.NoError(t, err)
+
+		// A client without a certificate is rejected during the handshake
+		dc, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		_, err = client.DialTCP(dc, listener.Addr().String(), "secret", noCertTLS)
+		assert.Error(t, err)
+		return nil
+	})
+
+	assert.NoError(t, grp.Wait())
+}
+
+func TestListenTCP_loopback(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	dir := t.TempDir()
+	newTestCert(t, dir, "server", nil, nil)
+	serverTLS, err := client.ServerTLSConfig(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), "")
+	require.NoError(t, err)
+
+	tests := []struct {
+		address string
+		tls     bool
+		wantErr bool
+	}{
+		{address: ":0"},
+		{address: "localhost:0"},
+		{address: "127.0.0.1:0"},
+		{address: "0.0.0.0:0", wantErr: true},
+		{address: "[::]:0", wantErr: true},
+		{address: "0.0.0.0:0", tls: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(fmt.Sprintf("%s tls=%t", tt.address, tt.tls), func(t *testing.T) {
+			var tlsConfig *tls.Config
+			if tt.tls {
+				tlsConfig = serverTLS
+			}
+			listener, err := client.ListenTCP(ctx, "test", tt.address, tlsConfig)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			_ = listener.Close()
+		})
+	}
+}
+
+// newTestCert creates a certificate and key named name.crt and name.key in the given directory. The
+// certificate is a self-signed CA when parent is nil.
+func newTestCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
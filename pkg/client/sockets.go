@@ -4,16 +4,38 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"os"
 	"time"
 
 	"google.golang.org/grpc"
 )
 
+type socketUserKey struct{}
+
+// WithSocketUser returns a context where the names of the daemon sockets are those of the user with the
+// given uid. The current user is used by default.
+func WithSocketUser(ctx context.Context, uid int) context.Context {
+	return context.WithValue(ctx, socketUserKey{}, uid)
+}
+
+func socketUser(ctx context.Context) int {
+	if uid, ok := ctx.Value(socketUserKey{}).(int); ok {
+		return uid
+	}
+	return os.Getuid()
+}
+
 // DialSocket dials the given socket and returns the resulting connection
 func DialSocket(ctx context.Context, socketName string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
 	return dialSocket(ctx, socketName, opts...)
 }
 
+// DialSocketConn dials the given socket and returns the resulting net.Conn. It's used by processes that relay
+// the traffic of a socket that can't be reached by the client.
+func DialSocketConn(ctx context.Context, socketName string) (net.Conn, error) {
+	return dialSocketConn(ctx, socketName)
+}
+
 // ListenSocket returns a listener for the given socket and returns the resulting connection
 func ListenSocket(ctx context.Context, processName, socketName string) (net.Listener, error) {
 	return listenSocket(ctx, processName, socketName)
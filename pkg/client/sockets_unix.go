@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
 	"time"
 
 	"golang.org/x/sys/unix"
@@ -19,13 +20,107 @@ import (
 )
 
 const (
-	// ConnectorSocketName is the path used when communicating to the connector process
-	ConnectorSocketName = "/tmp/telepresence-connector.socket"
+	// connectorSocketName is the name of the socket used when communicating to the connector process. It's
+	// created in the runtime directory of the user.
+	connectorSocketName = "telepresence-connector.socket"
 
-	// DaemonSocketName is the path used when communicating to the daemon process
-	DaemonSocketName = "/var/run/telepresence-daemon.socket"
+	// rootDaemonSocketFormat is the path, formatted with the uid of the user, used when communicating to a
+	// daemon process that runs as root.
+	rootDaemonSocketFormat = "/var/run/telepresence-daemon-%d.socket"
+
+	// capDaemonSocketName is the name of the socket used when communicating to a daemon process that runs
+	// as the current user with the capabilities needed to configure the network. It's created in the runtime
+	// directory of the user.
+	capDaemonSocketName = "telepresence-daemon.socket"
 )
 
+// ConnectorSocketName returns the path used when communicating to the connector process of the socket user.
+func ConnectorSocketName(ctx context.Context) string {
+	return filepath.Join(userRuntimeDir(socketUser(ctx)), connectorSocketName)
+}
+
+// ValidateConnectorSocket returns an error unless the given path is the connector socket of the socket user, in a
+// directory that no other user can write to. The root daemon dials the connector socket that a session names, so
+// the socket must not be one that another user can provide.
+func ValidateConnectorSocket(ctx context.Context, path string) error {
+	if !filepath.IsAbs(path) || filepath.Clean(path) != path || filepath.Base(path) != connectorSocketName {
+		return fmt.Errorf("%q is not a connector socket", path)
+	}
+	uid := socketUser(ctx)
+	var st unix.Stat_t
+	if err := unix.Lstat(path, &st); err != nil {
+		return fmt.Errorf("unable to stat %s: %w", path, err)
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFSOCK || int(st.Uid) != uid {
+		return fmt.Errorf("%q is not a socket of user %d", path, uid)
+	}
+	dir := filepath.Dir(path)
+	if err := unix.Stat(dir, &st); err != nil {
+		return fmt.Errorf("unable to stat %s: %w", dir, err)
+	}
+	if int(st.Uid) != uid && st.Uid != 0 || st.Mode&0o022 != 0 {
+		return fmt.Errorf("the directory of %q can be written by other users", path)
+	}
+	return nil
+}
+
+// DaemonSocketName returns the path used when communicating to the daemon process of the socket user. A
+// daemon that doesn't run as root isn't permitted to create its socket in /var/run, so it uses the runtime
+// directory of the user instead.
+func DaemonSocketName(ctx context.Context) string {
+	uid := socketUser(ctx)
+	if proc.IsAdmin() || !(proc.CanNetAdmin() || proc.HasNetAdmin()) {
+		return fmt.Sprintf(rootDaemonSocketFormat, uid)
+	}
+	return filepath.Join(userRuntimeDir(uid), capDaemonSocketName)
+}
+
+// WithSocketUserOfDir returns a context where the socket user is the owner of the given directory. The root
+// daemon uses this to find the sockets of the user that started it.
+func WithSocketUserOfDir(ctx context.Context, dir string) (context.Context, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(dir, &st); err != nil {
+		return ctx, err
+	}
+	return WithSocketUser(ctx, int(st.Uid)), nil
+}
+
+// userRuntimeDir returns the directory where the sockets of the user with the given uid are created. It's
+// $XDG_RUNTIME_DIR for the current user, /run/user/<uid> if it exists, and /tmp/telepresence-<uid> otherwise.
+func userRuntimeDir(uid int) string {
+	if uid == os.Getuid() {
+		if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+			return dir
+		}
+	}
+	dir := fmt.Sprintf("/run/user/%d", uid)
+	if s, err := os.Stat(dir); err == nil && s.IsDir() {
+		return dir
+	}
+	return fmt.Sprintf("/tmp/telepresence-%d", uid)
+}
+
+// ensureSocketDir ensures that the given directory exists and that it's owned by the current user or root, so
+// that other users can't replace the sockets in it.
+func ensureSocketDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	var st unix.Stat_t
+	if err := unix.Stat(dir, &st); err != nil {
+		return err
+	}
+	if int(st.Uid) != os.Geteuid() && st.Uid != 0 {
+		return fmt.Errorf("socket directory %q is owned by another user", dir)
+	}
+	return nil
+}
+
+func dialSocketConn(ctx context.Context, socketName string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, "unix", socketName)
+}
+
 func dialSocket(ctx context.Context, socketName string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second) // FIXME(lukeshu): Make this configurable
 	defer cancel()
@@ -82,6 +177,9 @@ func listenSocket(_ context.Context, processName, socketName string) (net.Listen
 		origUmask := unix.Umask(0)
 		defer unix.Umask(origUmask)
 	}
+	if err := ensureSocketDir(filepath.Dir(socketName)); err != nil {
+		return nil, err
+	}
 	listener, err := net.Listen("unix", socketName)
 	if err != nil {
 		if errors.Is(err, unix.EADDRINUSE) {
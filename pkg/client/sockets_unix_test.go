@@ -101,3 +101,58 @@ func TestDialSocket(t *testing.T) {
 		assert.Contains(t, err.Error(), "this usually means that the process is not running")
 	})
 }
+
+func TestSocketNames(t *testing.T) {
+	tmpdir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", tmpdir)
+	ctx := dlog.NewTestContext(t, false)
+
+	assert.Equal(t, filepath.Join(tmpdir, "telepresence-connector.socket"), client.ConnectorSocketName(ctx))
+
+	// The runtime dir of another user is never taken from the environment
+	other := os.Getuid() + 1
+	octx := client.WithSocketUser(ctx, other)
+	assert.NotContains(t, client.ConnectorSocketName(octx), tmpdir)
+	assert.NotEqual(t, client.DaemonSocketName(ctx), client.DaemonSocketName(octx))
+
+	// The socket user is the owner of the directory
+	octx, err := client.WithSocketUserOfDir(octx, tmpdir)
+	assert.NoError(t, err)
+	assert.Equal(t, client.ConnectorSocketName(ctx), client.ConnectorSocketName(octx))
+}
+
+func TestValidateConnectorSocket(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	tmpdir := t.TempDir()
+	assert.NoError(t, os.Chmod(tmpdir, 0o700))
+	sockname := filepath.Join(tmpdir, "telepresence-connector.socket")
+	l, err := net.Listen("unix", sockname)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+	assert.NoError(t, client.ValidateConnectorSocket(ctx, sockname))
+
+	// Only the connector socket of the socket user is valid
+	assert.Error(t, client.ValidateConnectorSocket(client.WithSocketUser(ctx, os.Getuid()+1), sockname))
+	assert.Error(t, client.ValidateConnectorSocket(ctx, tmpdir+"/../"+filepath.Base(tmpdir)+"/telepresence-connector.socket"))
+	assert.Error(t, client.ValidateConnectorSocket(ctx, "telepresence-connector.socket"))
+
+	other := filepath.Join(tmpdir, "other.socket")
+	ol, err := net.Listen("unix", other)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ol.Close()
+	assert.Error(t, client.ValidateConnectorSocket(ctx, other))
+
+	// A file that isn't a socket is invalid
+	fdir := filepath.Join(tmpdir, "file")
+	assert.NoError(t, os.Mkdir(fdir, 0o700))
+	assert.NoError(t, os.WriteFile(filepath.Join(fdir, "telepresence-connector.socket"), nil, 0o600))
+	assert.Error(t, client.ValidateConnectorSocket(ctx, filepath.Join(fdir, "telepresence-connector.socket")))
+
+	// The socket must not be in a directory that others can write to
+	assert.NoError(t, os.Chmod(tmpdir, 0o777))
+	assert.Error(t, client.ValidateConnectorSocket(ctx, sockname))
+}
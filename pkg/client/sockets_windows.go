@@ -4,27 +4,98 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"strings"
 
 	"github.com/Microsoft/go-winio"
 	"golang.org/x/sys/windows"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
-
-	"github.com/telepresenceio/telepresence/v2/pkg/proc"
 )
 
 // The Windows IPC between the CLI and the user and root daemons is based on named pipes rather than
-// unix sockets.
+// unix sockets. The names of the pipes contain the SID of the user, so that the daemons of different users
+// that are logged in on the same host don't collide.
 // See https://docs.microsoft.com/en-us/windows/win32/ipc/pipe-names for more info
 // about pipe names.
 const (
-	// ConnectorSocketName is the name used when communicating to the connector process
-	ConnectorSocketName = `\\.\pipe\telepresence-connector`
+	// connectorSocketFormat is the name, formatted with the SID of the user, used when communicating to the
+	// connector process
+	connectorSocketFormat = `\\.\pipe\telepresence-connector-%s`
 
-	// DaemonSocketName is the name used when communicating to the daemon process
-	DaemonSocketName = `\\.\pipe\telepresence-daemon`
+	// daemonSocketFormat is the name, formatted with the SID of the user, used when communicating to the
+	// daemon process
+	daemonSocketFormat = `\\.\pipe\telepresence-daemon-%s`
 )
 
+// unknownSocketUser is used in the names of the pipes when the SID of the socket user can't be determined. No
+// pipe is ever created with such a name, because listenSocket refuses to create a pipe for an unknown user.
+const unknownSocketUser = "unknown-user"
+
+// ConnectorSocketName returns the name used when communicating to the connector process of the socket user
+func ConnectorSocketName(ctx context.Context) string {
+	return fmt.Sprintf(connectorSocketFormat, socketUserSIDOrUnknown(ctx))
+}
+
+// ValidateConnectorSocket returns an error unless the given name is the connector pipe of the socket user. The root
+// daemon dials the connector pipe that a session names, so the pipe must not be one that another user can provide.
+func ValidateConnectorSocket(ctx context.Context, name string) error {
+	sid, err := SocketUserSID(ctx)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(name, fmt.Sprintf(connectorSocketFormat, sid)) {
+		return fmt.Errorf("%q is not the connector pipe of user %s", name, sid)
+	}
+	return nil
+}
+
+// DaemonSocketName returns the name used when communicating to the daemon process of the socket user
+func DaemonSocketName(ctx context.Context) string {
+	return fmt.Sprintf(daemonSocketFormat, socketUserSIDOrUnknown(ctx))
+}
+
+func socketUserSIDOrUnknown(ctx context.Context) string {
+	sid, err := SocketUserSID(ctx)
+	if err != nil {
+		return unknownSocketUser
+	}
+	return sid
+}
+
+type socketUserSIDKey struct{}
+
+// WithSocketUserOfDir returns a context where the socket user is the owner of the given directory. The root
+// daemon uses this to find the named pipes of the user that started it, and to grant that user access to its
+// own named pipe.
+func WithSocketUserOfDir(ctx context.Context, dir string) (context.Context, error) {
+	sd, err := windows.GetNamedSecurityInfo(dir, windows.SE_FILE_OBJECT, windows.OWNER_SECURITY_INFORMATION)
+	if err != nil {
+		return ctx, fmt.Errorf("unable to get the owner of %s: %w", dir, err)
+	}
+	owner, _, err := sd.Owner()
+	if err != nil {
+		return ctx, fmt.Errorf("unable to get the owner of %s: %w", dir, err)
+	}
+	return context.WithValue(ctx, socketUserSIDKey{}, owner.String()), nil
+}
+
+// SocketUserSID returns the SID of the socket user. The user of the current process is used by default.
+func SocketUserSID(ctx context.Context) (string, error) {
+	if sid, ok := ctx.Value(socketUserSIDKey{}).(string); ok {
+		return sid, nil
+	}
+	tu, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return "", fmt.Errorf("unable to get the user of the current process: %w", err)
+	}
+	return tu.User.Sid.String(), nil
+}
+
+// dialSocketConn dials the given named pipe and returns the resulting net.Conn
+func dialSocketConn(c context.Context, socketName string) (net.Conn, error) {
+	return winio.DialPipeContext(c, socketName)
+}
+
 // dialSocket dials the given named pipe and returns the resulting connection
 func dialSocket(c context.Context, socketName string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
 	conn, err := grpc.DialContext(c, socketName, append([]grpc.DialOption{
@@ -46,18 +117,23 @@ func dialSocket(c context.Context, socketName string, opts ...grpc.DialOption) (
 	return conn, err
 }
 
-// allowEveryone is a security descriptor that allows everyone to perform the action.
-// For more info about the syntax, sse:
+// socketSecurityFormat is a security descriptor, formatted with the SID of the socket user, that grants full
+// access to the named pipe to SYSTEM and the Administrators, and read/write access to the socket user. The
+// mandatory label allows processes with medium integrity, i.e. processes that aren't elevated, to connect to a
+// pipe that is created by an elevated process. Everyone else is denied access.
+// For more info about the syntax, see:
 // https://docs.microsoft.com/en-us/windows/win32/secauthz/security-descriptor-string-format
-const allowEveryone = "S:(ML;;NW;;;LW)D:(A;;0x12019f;;;WD)"
+const socketSecurityFormat = "S:(ML;;NW;;;ME)D:P(A;;GA;;;SY)(A;;GA;;;BA)(A;;0x12019f;;;%s)"
 
 // listenSocket returns a listener for the given named pipe and returns the resulting connection
-func listenSocket(_ context.Context, processName, socketName string) (net.Listener, error) {
-	var config *winio.PipeConfig
-	if proc.IsAdmin() {
-		config = &winio.PipeConfig{SecurityDescriptor: allowEveryone}
+func listenSocket(ctx context.Context, processName, socketName string) (net.Listener, error) {
+	sid, err := SocketUserSID(ctx)
+	if err != nil {
+		return nil, err
 	}
-	return winio.ListenPipe(socketName, config)
+	return winio.ListenPipe(socketName, &winio.PipeConfig{
+		SecurityDescriptor: fmt.Sprintf(socketSecurityFormat, sid),
+	})
 }
 
 // removeSocket does nothing because a named pipe has no representation in the file system that
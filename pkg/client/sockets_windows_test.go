@@ -0,0 +1,26 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSocketUserSID(t *testing.T) {
+	ctx := context.Background()
+	sid, err := SocketUserSID(ctx)
+	require.NoError(t, err)
+	assert.NotEmpty(t, sid)
+	assert.Equal(t, `\\.\pipe\telepresence-connector-`+sid, ConnectorSocketName(ctx))
+
+	ctx = context.WithValue(ctx, socketUserSIDKey{}, "S-1-5-21-1-2-3-1001")
+	sid, err = SocketUserSID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "S-1-5-21-1-2-3-1001", sid)
+	assert.Equal(t, `\\.\pipe\telepresence-daemon-S-1-5-21-1-2-3-1001`, DaemonSocketName(ctx))
+	name, err := DaemonServiceName(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "TelepresenceDaemon-S-1-5-21-1-2-3-1001", name)
+}
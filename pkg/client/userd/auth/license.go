@@ -70,6 +70,9 @@ func getLicenseJWT(ctx context.Context, accessToken, licenseID string) (string,
 // GetLicense is added as part of the loginExecutor so it can utilize the
 // access token to talk to systemA in getLicenseJWT
 func (l *loginExecutor) GetLicense(ctx context.Context, id string) (string, string, error) {
+	if err := client.CheckOnline(ctx, "Ambassador Cloud"); err != nil {
+		return "", "", err
+	}
 	l.loginMu.Lock()
 	defer l.loginMu.Unlock()
 	if l.tokenSource == nil {
@@ -248,6 +248,10 @@ func (l *loginExecutor) Worker(ctx context.Context) error {
 		for {
 			select {
 			case <-l.refreshTimer.C:
+				if client.IsOffline(ctx) {
+					// The token is refreshed on demand when the offline mode ends
+					continue
+				}
 				dlog.Infoln(ctx, "refreshing access token...")
 				if token, err := l.getToken(ctx); err != nil {
 					dlog.Infof(ctx, "could not refresh access token: %v", err)
@@ -295,6 +299,9 @@ func (l *loginExecutor) reportLoginResult(ctx context.Context, err error, method
 // succeeds, this function will then try invoking the authorization server's userinfo endpoint
 // and persist the userinfo using l.SaveUserInfoFunc (which would usually write to user cache).
 func (l *loginExecutor) Login(ctx context.Context) (err error) {
+	if err = client.CheckOnline(ctx, "login to Ambassador Cloud"); err != nil {
+		return err
+	}
 	// We'll be making use of l.auth2config
 	l.oauth2ConfigMu.RLock()
 	defer l.oauth2ConfigMu.RUnlock()
@@ -369,6 +376,9 @@ func (l *loginExecutor) Login(ctx context.Context) (err error) {
 }
 
 func (l *loginExecutor) LoginAPIKey(ctx context.Context, apikey string) (newLogin bool, err error) {
+	if err = client.CheckOnline(ctx, "login to Ambassador Cloud"); err != nil {
+		return false, err
+	}
 	l.loginMu.Lock()
 	defer l.loginMu.Unlock()
 	defer l.reportLoginResult(ctx, err, "apikey")
@@ -471,6 +481,9 @@ func (l *loginExecutor) getToken(ctx context.Context) (string, error) {
 
 // Must hold l.loginMu to call this.
 func (l *loginExecutor) lockedGetCreds(ctx context.Context) (map[string]string, error) {
+	if err := client.CheckOnline(ctx, "Ambassador Cloud"); err != nil {
+		return nil, err
+	}
 	rootKey, rootKeyOK := l.apikeys[client.GetEnv(ctx).LoginDomain][a8rcloud.KeyDescRoot]
 	switch {
 	case rootKeyOK:
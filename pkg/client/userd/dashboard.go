@@ -0,0 +1,114 @@
+package userd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/userd/dashboard"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/userd/trafficmgr"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+)
+
+// dashboardBackend is the dashboard.Backend of the user daemon.
+type dashboardBackend struct {
+	*service
+}
+
+func (s dashboardBackend) Snapshot(ctx context.Context) (*dashboard.Snapshot, error) {
+	ci, err := s.Status(ctx, &empty.Empty{})
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &dashboard.Snapshot{Connection: dashboard.Connection{
+		ClusterContext:  ci.ClusterContext,
+		ClusterServer:   ci.ClusterServer,
+		ClusterID:       ci.ClusterId,
+		SessionID:       ci.GetSessionInfo().GetSessionId(),
+		ManagerWarnings: ci.ManagerWarnings,
+	}}
+	switch ci.Error {
+	case rpc.ConnectInfo_UNSPECIFIED, rpc.ConnectInfo_ALREADY_CONNECTED:
+		snapshot.Connection.Status = "connected"
+	case rpc.ConnectInfo_DISCONNECTED:
+		snapshot.Connection.Status = "disconnected"
+		return snapshot, nil
+	default:
+		snapshot.Connection.Status = strings.ReplaceAll(strings.ToLower(ci.Error.String()), "_", " ")
+		snapshot.Connection.Error = ci.ErrorText
+		return snapshot, nil
+	}
+
+	if err := s.addRootDaemonStatus(client.WithRootDaemonSession(ctx, snapshot.Connection.SessionID), snapshot); err != nil {
+		snapshot.Connection.RootDaemonError = err.Error()
+	}
+	_ = s.withSession(ctx, "DashboardSnapshot", func(_ context.Context, session trafficmgr.Session) error {
+		for _, ii := range ci.GetIntercepts().GetIntercepts() {
+			spec := ii.Spec
+			snapshot.Intercepts = append(snapshot.Intercepts, dashboard.Intercept{
+				Name:        spec.Name,
+				Namespace:   spec.Namespace,
+				Workload:    spec.Agent,
+				Disposition: ii.Disposition.String(),
+				Message:     ii.Message,
+				Target:      net.JoinHostPort(spec.TargetHost, strconv.Itoa(int(spec.TargetPort))),
+				Traffic:     session.InterceptTraffic(spec),
+			})
+		}
+		return nil
+	})
+	return snapshot, nil
+}
+
+func (s dashboardBackend) addRootDaemonStatus(ctx context.Context, snapshot *dashboard.Snapshot) error {
+	rd, err := s.RootDaemonClient(ctx)
+	if err != nil {
+		return err
+	}
+	ds, err := rd.Status(ctx, &empty.Empty{})
+	if err != nil {
+		return err
+	}
+	subnets := func(ips []*manager.IPNet) []string {
+		ss := make([]string, len(ips))
+		for i, ip := range ips {
+			ss[i] = iputil.IPNetFromRPC(ip).String()
+		}
+		return ss
+	}
+	snapshot.RoutedSubnets = subnets(ds.RoutedSubnets)
+	if oc := ds.OutboundConfig; oc != nil {
+		snapshot.AlsoProxySubnets = subnets(oc.AlsoProxySubnets)
+		snapshot.NeverProxySubnets = subnets(oc.NeverProxySubnets)
+	}
+	if st := ds.DnsStats; st != nil {
+		snapshot.DNS = &dashboard.DNSStats{
+			Requests:              st.Requests,
+			ClusterLookups:        st.ClusterLookups,
+			ClusterLookupFailures: st.ClusterLookupFailures,
+		}
+	}
+	return nil
+}
+
+func (s dashboardBackend) LeaveIntercept(ctx context.Context, name string) error {
+	r, err := s.RemoveIntercept(ctx, &manager.RemoveInterceptRequest2{Name: name})
+	if err != nil {
+		return err
+	}
+	switch r.Error {
+	case rpc.InterceptError_UNSPECIFIED:
+		return nil
+	case rpc.InterceptError_NOT_FOUND:
+		return errors.New("no such intercept")
+	default:
+		return errors.New(r.ErrorText)
+	}
+}
@@ -0,0 +1,204 @@
+// Package dashboard serves a small web UI on localhost that shows the state of the user daemon's connection and
+// intercepts, for developers who prefer a visual view over polling the CLI.
+package dashboard
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed" // embed needs to be imported for the go:embed directive to work
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/datawire/dlib/dhttp"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/userd/trafficmgr"
+)
+
+//go:embed index.html
+var indexHTML []byte
+
+// Snapshot is the state that the dashboard shows.
+type Snapshot struct {
+	Connection        Connection  `json:"connection"`
+	RoutedSubnets     []string    `json:"routedSubnets"`
+	AlsoProxySubnets  []string    `json:"alsoProxySubnets"`
+	NeverProxySubnets []string    `json:"neverProxySubnets"`
+	DNS               *DNSStats   `json:"dns,omitempty"`
+	Intercepts        []Intercept `json:"intercepts"`
+}
+
+// Connection describes the health of the connection to the cluster.
+type Connection struct {
+	// Status is "connected", "disconnected", or a description of the reason why the connection failed.
+	Status          string   `json:"status"`
+	Error           string   `json:"error,omitempty"`
+	ClusterContext  string   `json:"clusterContext,omitempty"`
+	ClusterServer   string   `json:"clusterServer,omitempty"`
+	ClusterID       string   `json:"clusterId,omitempty"`
+	SessionID       string   `json:"sessionId,omitempty"`
+	ManagerWarnings []string `json:"managerWarnings,omitempty"`
+
+	// RootDaemonError is set when the status of the root daemon couldn't be obtained.
+	RootDaemonError string `json:"rootDaemonError,omitempty"`
+}
+
+// DNSStats are the counters of the local DNS resolver.
+type DNSStats struct {
+	Requests              int64 `json:"requests"`
+	ClusterLookups        int64 `json:"clusterLookups"`
+	ClusterLookupFailures int64 `json:"clusterLookupFailures"`
+}
+
+// Intercept is an active intercept and the traffic that it has received.
+type Intercept struct {
+	Name        string                  `json:"name"`
+	Namespace   string                  `json:"namespace"`
+	Workload    string                  `json:"workload"`
+	Disposition string                  `json:"disposition"`
+	Message     string                  `json:"message,omitempty"`
+	Target      string                  `json:"target"`
+	Traffic     trafficmgr.TrafficStats `json:"traffic"`
+}
+
+// Backend provides the state of the dashboard and performs its actions.
+type Backend interface {
+	Snapshot(context.Context) (*Snapshot, error)
+	LeaveIntercept(ctx context.Context, name string) error
+}
+
+// Server serves the dashboard. The listener isn't opened until the first call to URL.
+type Server struct {
+	backend Backend
+	ready   chan struct{}
+
+	// ctx is the context of the Worker. The HTTP server ends when it's cancelled.
+	ctx context.Context
+
+	lock sync.Mutex
+	url  string
+}
+
+// NewServer returns a new Server that uses the given Backend.
+func NewServer(backend Backend) *Server {
+	return &Server{backend: backend, ready: make(chan struct{})}
+}
+
+// Worker enables the server and blocks until the given context is cancelled.
+func (s *Server) Worker(ctx context.Context) error {
+	s.ctx = ctx
+	close(s.ready)
+	<-ctx.Done()
+	return nil
+}
+
+// URL starts the HTTP server, unless it's already started, and returns the URL of the dashboard. The URL contains
+// a random token that must be present in all requests, which prevents other sites that the user visits from using
+// the dashboard.
+func (s *Server) URL(ctx context.Context) (string, error) {
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-s.ready:
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.url != "" {
+		return s.url, nil
+	}
+	if err := s.ctx.Err(); err != nil {
+		return "", err
+	}
+
+	tb := make([]byte, 16)
+	if _, err := rand.Read(tb); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(tb)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("unable to listen for dashboard requests: %w", err)
+	}
+	host := l.Addr().String()
+	sc := &dhttp.ServerConfig{Handler: s.handler(host, token)}
+	go func() {
+		if err := sc.Serve(s.ctx, l); err != nil && s.ctx.Err() == nil {
+			dlog.Errorf(s.ctx, "dashboard server ended with: %v", err)
+		}
+	}()
+	s.url = "http://" + host + "/" + token + "/"
+	dlog.Infof(ctx, "Dashboard listening to %s", host)
+	return s.url, nil
+}
+
+func (s *Server) handler(host, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write(indexHTML)
+	})
+	mux.HandleFunc("/api/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		snapshot, err := s.backend.Snapshot(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(snapshot)
+	})
+	mux.HandleFunc("/api/leave", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var rq struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&rq); err != nil || rq.Name == "" {
+			http.Error(w, "the request must be a JSON object with a name", http.StatusBadRequest)
+			return
+		}
+		if err := s.backend.LeaveIntercept(r.Context(), rq.Name); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	prefix := "/" + token
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A Host other than the listener's address means that the request was sent using a name that resolves
+		// to the loopback address, which is how DNS rebinding attacks work.
+		if r.Host != host {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		path := r.URL.Path
+		if len(path) < len(prefix) || subtle.ConstantTimeCompare([]byte(path[:len(prefix)]), []byte(prefix)) != 1 {
+			http.NotFound(w, r)
+			return
+		}
+		path = path[len(prefix):]
+		if path == "" {
+			http.Redirect(w, r, prefix+"/", http.StatusMovedPermanently)
+			return
+		}
+		if !strings.HasPrefix(path, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = path
+		mux.ServeHTTP(w, r2)
+	})
+}
@@ -0,0 +1,108 @@
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/userd/trafficmgr"
+)
+
+type testBackend struct {
+	left []string
+}
+
+func (b *testBackend) Snapshot(context.Context) (*Snapshot, error) {
+	return &Snapshot{
+		Connection:    Connection{Status: "connected", ClusterContext: "default"},
+		RoutedSubnets: []string{"10.96.0.0/12"},
+		Intercepts: []Intercept{{
+			Name:        "echo",
+			Namespace:   "default",
+			Workload:    "echo",
+			Disposition: "ACTIVE",
+			Target:      "127.0.0.1:8080",
+			Traffic:     trafficmgr.TrafficStats{Connections: 2, BytesIn: 100},
+		}},
+	}, nil
+}
+
+func (b *testBackend) LeaveIntercept(_ context.Context, name string) error {
+	if name != "echo" {
+		return errors.New("no such intercept")
+	}
+	b.left = append(b.left, name)
+	return nil
+}
+
+func TestServer(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	backend := &testBackend{}
+	s := NewServer(backend)
+	go func() { _ = s.Worker(ctx) }()
+
+	du, err := s.URL(ctx)
+	require.NoError(t, err)
+	again, err := s.URL(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, du, again, "the server must only be started once")
+	u, err := url.Parse(du)
+	require.NoError(t, err)
+
+	get := func(path string) *http.Response {
+		rs, err := http.Get(du + path)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = rs.Body.Close() })
+		return rs
+	}
+
+	rs := get("")
+	assert.Equal(t, http.StatusOK, rs.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", rs.Header.Get("Content-Type"))
+
+	rs = get("api/snapshot")
+	require.Equal(t, http.StatusOK, rs.StatusCode)
+	var snapshot Snapshot
+	require.NoError(t, json.NewDecoder(rs.Body).Decode(&snapshot))
+	assert.Equal(t, "connected", snapshot.Connection.Status)
+	require.Len(t, snapshot.Intercepts, 1)
+	assert.Equal(t, int64(2), snapshot.Intercepts[0].Traffic.Connections)
+
+	leave := func(body string) int {
+		rs, err := http.Post(du+"api/leave", "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		_ = rs.Body.Close()
+		return rs.StatusCode
+	}
+	assert.Equal(t, http.StatusNoContent, leave(`{"name":"echo"}`))
+	assert.Equal(t, http.StatusInternalServerError, leave(`{"name":"other"}`))
+	assert.Equal(t, http.StatusBadRequest, leave(`{}`))
+	assert.Equal(t, []string{"echo"}, backend.left)
+
+	rs = get("api/leave")
+	assert.Equal(t, http.StatusMethodNotAllowed, rs.StatusCode)
+
+	// Requests without the token are rejected
+	rs, err = http.Get("http://" + u.Host + "/api/snapshot")
+	require.NoError(t, err)
+	_ = rs.Body.Close()
+	assert.Equal(t, http.StatusNotFound, rs.StatusCode)
+
+	// Requests using another host name are rejected
+	rq, err := http.NewRequest(http.MethodGet, du+"api/snapshot", nil)
+	require.NoError(t, err)
+	rq.Host = "rebound.example.com:" + u.Port()
+	rs, err = http.DefaultClient.Do(rq)
+	require.NoError(t, err)
+	_ = rs.Body.Close()
+	assert.Equal(t, http.StatusForbidden, rs.StatusCode)
+}
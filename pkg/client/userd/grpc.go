@@ -245,6 +245,9 @@ func (s *service) CreateIntercept(c context.Context, ir *rpc.CreateInterceptRequ
 	}()
 	err = s.withSession(c, "CreateIntercept", func(c context.Context, session trafficmgr.Session) error {
 		result, err = session.AddIntercept(c, ir)
+		if err == nil && result.Error == rpc.InterceptError_UNSPECIFIED {
+			s.recordIntercept(c, ir)
+		}
 		return err
 	})
 	return
@@ -269,6 +272,7 @@ func (s *service) RemoveIntercept(c context.Context, rr *manager.RemoveIntercept
 			result.ServiceUid = spec.ServiceUid
 			result.WorkloadKind = spec.WorkloadKind
 		}
+		s.forgetIntercept(c, rr.Name)
 		if err := session.RemoveIntercept(c, rr.Name); err != nil {
 			if grpcStatus.Code(err) == grpcCodes.NotFound {
 				result.Error = rpc.InterceptError_NOT_FOUND
@@ -285,6 +289,35 @@ func (s *service) RemoveIntercept(c context.Context, rr *manager.RemoveIntercept
 	return result, err
 }
 
+func (s *service) HandoffIntercept(c context.Context, hr *manager.HandoffInterceptRequest) (result *rpc.InterceptResult, err error) {
+	err = s.withSession(c, "HandoffIntercept", func(c context.Context, session trafficmgr.Session) error {
+		result = &rpc.InterceptResult{}
+		ii, err := session.HandoffIntercept(c, hr.Name, hr.Recipient, hr.Accept)
+		if err != nil {
+			st := grpcStatus.Convert(err)
+			result.Error = rpc.InterceptError_TRAFFIC_MANAGER_ERROR
+			result.ErrorText = st.Message()
+			switch st.Code() {
+			case grpcCodes.NotFound, grpcCodes.InvalidArgument, grpcCodes.FailedPrecondition, grpcCodes.AlreadyExists, grpcCodes.ResourceExhausted,
+				grpcCodes.PermissionDenied:
+				result.ErrorCategory = int32(errcat.User)
+			case grpcCodes.Unimplemented:
+				result.ErrorText = "the traffic-manager doesn't support intercept handoffs, it must be upgraded"
+				result.ErrorCategory = int32(errcat.User)
+			default:
+				result.ErrorCategory = int32(errcat.Unknown)
+			}
+			return nil
+		}
+		if !hr.Accept {
+			s.forgetIntercept(c, hr.Name)
+		}
+		result.InterceptInfo = ii
+		return nil
+	})
+	return result, err
+}
+
 func (s *service) AddInterceptor(ctx context.Context, interceptor *rpc.Interceptor) (*empty.Empty, error) {
 	return &empty.Empty{}, s.withSession(ctx, "AddInterceptor", func(_ context.Context, session trafficmgr.Session) error {
 		return session.AddInterceptor(interceptor.InterceptId, int(interceptor.Pid))
@@ -299,7 +332,11 @@ func (s *service) RemoveInterceptor(ctx context.Context, interceptor *rpc.Interc
 
 func (s *service) List(c context.Context, lr *rpc.ListRequest) (result *rpc.WorkloadInfoSnapshot, err error) {
 	err = s.withSession(c, "List", func(c context.Context, session trafficmgr.Session) error {
-		result, err = session.WorkloadInfoSnapshot(c, []string{lr.Namespace}, lr.Filter, true)
+		if lr.Cluster != "" {
+			result, err = session.PeerWorkloadInfoSnapshot(c, lr.Cluster, lr.Namespace, lr.Filter)
+		} else {
+			result, err = session.WorkloadInfoSnapshot(c, []string{lr.Namespace}, lr.Filter, true)
+		}
 		return err
 	})
 	return
@@ -311,6 +348,12 @@ func (s *service) WatchWorkloads(wr *rpc.WatchWorkloadsRequest, server rpc.Conne
 	})
 }
 
+func (s *service) WatchWorkloadEvents(wr *rpc.WatchWorkloadsRequest, server rpc.Connector_WatchWorkloadEventsServer) error {
+	return s.withSession(server.Context(), "WatchWorkloadEvents", func(c context.Context, session trafficmgr.Session) error {
+		return session.WatchWorkloadEvents(c, wr, server)
+	})
+}
+
 func (s *service) Uninstall(c context.Context, ur *rpc.UninstallRequest) (result *rpc.UninstallResult, err error) {
 	err = s.withSession(c, "Uninstall", func(c context.Context, session trafficmgr.Session) error {
 		result, err = session.Uninstall(c, ur)
@@ -440,6 +483,58 @@ func (s *service) GatherLogs(ctx context.Context, request *rpc.LogsRequest) (res
 	return
 }
 
+func (s *service) Benchmark(ctx context.Context, request *rpc.BenchmarkRequest) (result *rpc.BenchmarkResponse, err error) {
+	err = s.withSession(ctx, "Benchmark", func(c context.Context, session trafficmgr.Session) error {
+		result, err = session.Benchmark(c, request)
+		return err
+	})
+	return
+}
+
+func (s *service) UpgradeAgents(ur *rpc.UpgradeAgentsRequest, server rpc.Connector_UpgradeAgentsServer) error {
+	return s.withSession(server.Context(), "UpgradeAgents", func(c context.Context, session trafficmgr.Session) error {
+		return session.UpgradeAgents(c, ur, server)
+	})
+}
+
+func (s *service) DescribeIntercept(c context.Context, dr *rpc.DescribeInterceptRequest) (result *rpc.InterceptDescription, err error) {
+	err = s.withSession(c, "DescribeIntercept", func(c context.Context, session trafficmgr.Session) error {
+		result, err = session.DescribeIntercept(c, dr)
+		return err
+	})
+	return
+}
+
+func (s *service) GetInterceptDefaults(c context.Context, dr *rpc.GetInterceptDefaultsRequest) (result *rpc.InterceptDefaults, err error) {
+	err = s.withSession(c, "GetInterceptDefaults", func(c context.Context, session trafficmgr.Session) error {
+		result, err = session.GetInterceptDefaults(c, dr)
+		return err
+	})
+	return
+}
+
+func (s *service) Dashboard(c context.Context, _ *empty.Empty) (result *rpc.DashboardInfo, err error) {
+	s.logCall(c, "Dashboard", func(c context.Context) {
+		var url string
+		if url, err = s.dashboard.URL(c); err == nil {
+			result = &rpc.DashboardInfo{Url: url}
+		}
+	})
+	return
+}
+
+func (s *service) WaitForIntercept(wr *rpc.WaitForInterceptRequest, server rpc.Connector_WaitForInterceptServer) error {
+	return s.withSession(server.Context(), "WaitForIntercept", func(c context.Context, session trafficmgr.Session) error {
+		return session.WaitForIntercept(c, wr, server)
+	})
+}
+
+func (s *service) WatchRollout(wr *rpc.WatchRolloutRequest, server rpc.Connector_WatchRolloutServer) error {
+	return s.withSession(server.Context(), "WatchRollout", func(c context.Context, session trafficmgr.Session) error {
+		return session.WatchRollout(c, wr, server)
+	})
+}
+
 func (s *service) SetLogLevel(ctx context.Context, request *manager.LogLevelRequest) (result *empty.Empty, err error) {
 	s.logCall(ctx, "SetLogLevel", func(c context.Context) {
 		duration := time.Duration(0)
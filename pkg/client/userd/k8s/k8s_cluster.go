@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/blang/semver"
+	"gopkg.in/yaml.v3"
 	"k8s.io/apimachinery/pkg/version"
 	"k8s.io/client-go/kubernetes"
 
@@ -30,12 +31,15 @@ type Cluster struct {
 	// Main
 	ki kubernetes.Interface
 
+	// throttler limits the rate of the requests made using ki
+	throttler *Throttler
+
 	// Current Namespace snapshot, get set by namespace Watcher.
 	// The boolean value indicates if this client is allowed to
 	// watch services and retrieve workloads in the namespace
 	nsWatcher *k8sapi.Watcher
 
-	// nsLock protects currentMappedNamespaces and namespaceListeners
+	// nsLock protects the Namespace of the Config, currentMappedNamespaces, and namespaceListeners
 	nsLock sync.Mutex
 
 	// Current Namespace snapshot, filtered by mappedNamespaces
@@ -47,7 +51,7 @@ type Cluster struct {
 
 func (kc *Cluster) ActualNamespace(namespace string) string {
 	if namespace == "" {
-		namespace = kc.Namespace
+		namespace = kc.ConnectedNamespace()
 	}
 	if !kc.namespaceAccessible(namespace) {
 		namespace = ""
@@ -55,6 +59,25 @@ func (kc *Cluster) ActualNamespace(namespace string) string {
 	return namespace
 }
 
+// ConnectedNamespace returns the namespace of the kubeconfig context, i.e. the namespace that is used when no
+// namespace is given explicitly.
+func (kc *Cluster) ConnectedNamespace() string {
+	kc.nsLock.Lock()
+	defer kc.nsLock.Unlock()
+	return kc.Namespace
+}
+
+// SetConnectedNamespace changes the namespace of the kubeconfig context. It returns true if the namespace changed.
+func (kc *Cluster) SetConnectedNamespace(namespace string) bool {
+	kc.nsLock.Lock()
+	defer kc.nsLock.Unlock()
+	if kc.Namespace == namespace {
+		return false
+	}
+	kc.Namespace = namespace
+	return true
+}
+
 // check uses a non-caching DiscoveryClientConfig to retrieve the server version
 func (kc *Cluster) check(c context.Context) error {
 	// The discover client is using context.TODO() so the timeout specified in our
@@ -118,10 +141,18 @@ func (kc *Cluster) namespaceAccessible(namespace string) (exists bool) {
 }
 
 func NewCluster(c context.Context, kubeFlags *Config, namespaces []string) (*Cluster, error) {
+	if kubeFlags.kubeconfigExtension.SSH != nil {
+		if err := kubeFlags.startSSHTunnel(c); err != nil {
+			return nil, err
+		}
+	}
 	rs, err := kubeFlags.ConfigFlags.ToRESTConfig()
 	if err != nil {
 		return nil, err
 	}
+	throttler := NewThrottler(c, &client.GetConfig(c).KubeAPI)
+	rs.RateLimiter = throttler
+	rs.Wrap(throttler.WrapTransport)
 	cs, err := kubernetes.NewForConfig(rs)
 	if err != nil {
 		return nil, err
@@ -138,6 +169,7 @@ func NewCluster(c context.Context, kubeFlags *Config, namespaces []string) (*Clu
 		Config:           kubeFlags,
 		mappedNamespaces: namespaces,
 		ki:               cs,
+		throttler:        throttler,
 	}
 
 	timedC, cancel := client.GetConfig(c).Timeouts.TimeoutContext(c, client.TimeoutClusterConnect)
@@ -148,6 +180,9 @@ func NewCluster(c context.Context, kubeFlags *Config, namespaces []string) (*Clu
 
 	dlog.Infof(c, "Context: %s", ret.Context)
 	dlog.Infof(c, "Server: %s", ret.Server)
+	if im := rs.Impersonate; im.UserName != "" || len(im.Groups) > 0 {
+		dlog.Infof(c, "Impersonating user %q, groups %q", im.UserName, im.Groups)
+	}
 
 	ret.startNamespaceWatcher(c)
 	return ret, nil
@@ -176,6 +211,20 @@ func (kc *Cluster) GetCurrentNamespaces(forClientAccess bool) []string {
 	return nss
 }
 
+// ApplyServedConfig applies the client config that the traffic-manager serves. The settings of the user's own
+// config take priority. It's also called when the user's own config is reloaded, possibly with empty data.
+func (kc *Cluster) ApplyServedConfig(c context.Context, data []byte) error {
+	var cfg client.Config
+	if len(data) > 0 {
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return fmt.Errorf("unable to parse the client config served by the traffic-manager: %w", err)
+		}
+	}
+	cfg.Merge(client.GetConfig(c))
+	kc.throttler.Configure(&cfg.KubeAPI)
+	return nil
+}
+
 func (kc *Cluster) GetClusterId(ctx context.Context) string {
 	clusterID, _ := k8sapi.GetClusterID(ctx)
 	return clusterID
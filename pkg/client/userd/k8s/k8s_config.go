@@ -2,8 +2,17 @@ package k8s
 
 import (
 	"context"
+	"encoding/csv"
 	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
 
 	"github.com/spf13/pflag"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -12,8 +21,10 @@ import (
 	_ "k8s.io/client-go/plugin/pkg/client/auth" // Important for various cloud provider auth
 	"k8s.io/client-go/rest"
 
+	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/dnet"
 	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
 )
 
@@ -45,6 +56,39 @@ type dnsConfig struct {
 type managerConfig struct {
 	// Namespace is the name of the namespace where the traffic manager is to be found
 	Namespace string `json:"namespace,omitempty"`
+
+	// Address is the host:port of a traffic manager endpoint that is reachable from outside the cluster, such
+	// as a LoadBalancer service or an ingress. When set, the traffic manager is dialed directly instead of
+	// through a port-forward.
+	Address string `json:"address,omitempty"`
+
+	// TLS enables TLS when dialing the Address. This is typically required when the Address is an ingress.
+	TLS bool `json:"tls,omitempty"`
+
+	// WebsocketURL is a ws:// or wss:// URL, typically of an ingress, where the traffic manager's websocket
+	// tunnel endpoint can be reached. It's used when the traffic manager cannot be dialed using gRPC.
+	WebsocketURL string `json:"websocket-url,omitempty"`
+
+	// ProxyURL is a socks5://, http://, or https:// URL of a proxy, optionally with credentials, that is used
+	// when dialing the Address or the WebsocketURL.
+	ProxyURL string `json:"proxy-url,omitempty"`
+}
+
+// The sshConfig is part of the kubeconfigExtension struct. It configures an SSH jump host through which the API
+// server and the traffic manager are reached.
+type sshConfig struct {
+	// Host is the host[:port] of the jump host. The port defaults to 22.
+	Host string `json:"host,omitempty"`
+
+	// User is the SSH user. Defaults to the name of the current user.
+	User string `json:"user,omitempty"`
+
+	// IdentityFile is a file containing an unencrypted private key. The keys of the ssh-agent are used when
+	// it's not set.
+	IdentityFile string `json:"identity-file,omitempty"`
+
+	// KnownHostsFile is used when verifying the key of the jump host. Defaults to ~/.ssh/known_hosts.
+	KnownHostsFile string `json:"known-hosts-file,omitempty"`
 }
 
 // kubeconfigExtension is an extension read from the selected kubeconfig Cluster.
@@ -53,6 +97,15 @@ type kubeconfigExtension struct {
 	AlsoProxy  []*iputil.Subnet `json:"also-proxy,omitempty"`
 	NeverProxy []*iputil.Subnet `json:"never-proxy,omitempty"`
 	Manager    *managerConfig   `json:"manager,omitempty"`
+	SSH        *sshConfig       `json:"ssh,omitempty"`
+
+	// MinimizeSubnets makes the root daemon route the subnets that cover the cluster IPs of the services that
+	// the traffic-manager has discovered, instead of the whole service subnet.
+	MinimizeSubnets bool `json:"minimize-subnets,omitempty"`
+
+	// AllowConflictingSubnets are subnets that are routed through the TUN device even when they conflict with
+	// routes of other network interfaces.
+	AllowConflictingSubnets []*iputil.Subnet `json:"allow-conflicting-subnets,omitempty"`
 }
 
 type Config struct {
@@ -61,8 +114,13 @@ type Config struct {
 	Context     string
 	Server      string
 	flagMap     map[string]string
-	ConfigFlags *genericclioptions.ConfigFlags
-	RestConfig  *rest.Config
+	kubeconfigs []string // the KUBECONFIG path list, empty when KUBECONFIG is unset
+	sshDialer   *dnet.SSHDialer
+
+	// managerDialer dials the traffic manager through a proxy. Nil unless a manager proxy-url is configured.
+	managerDialer func(context.Context, string) (net.Conn, error)
+	ConfigFlags   *genericclioptions.ConfigFlags
+	RestConfig    *rest.Config
 }
 
 const configExtension = "telepresence.io"
@@ -75,23 +133,24 @@ func NewConfig(c context.Context, flagMap map[string]string) (*Config, error) {
 	// The KUBECONFIG entry is a copy of the KUBECONFIG environment variable sent to us from the CLI to give
 	// this long-running daemon a chance to update it. Using the --kubeconfig flag to send the info isn't
 	// sufficient because that flag doesn't allow for multiple path entries like the KUBECONFIG does.
+	var kubeconfigs []string
 	if kcEnv, ok := flagMap["KUBECONFIG"]; ok {
 		delete(flagMap, "KUBECONFIG")
-		if err := os.Setenv("KUBECONFIG", kcEnv); err != nil {
-			return nil, err
-		}
-	} else {
-		// If user unsets the KUBECONFIG, we need to do that too
-		if err := os.Unsetenv("KUBECONFIG"); err != nil {
-			return nil, err
+		for _, kc := range filepath.SplitList(kcEnv) {
+			if kc != "" {
+				kubeconfigs = append(kubeconfigs, kc)
+			}
 		}
 	}
+	if err := setKubeconfigEnv(kubeconfigs); err != nil {
+		return nil, err
+	}
 
 	configFlags := genericclioptions.NewConfigFlags(false)
 	flags := pflag.NewFlagSet("", 0)
 	configFlags.AddFlags(flags)
 	for k, v := range flagMap {
-		if err := flags.Set(k, v); err != nil {
+		if err := setFlag(flags, k, v); err != nil {
 			return nil, errcat.User.Newf("error processing kubectl flag --%s=%s: %w", k, v, err)
 		}
 	}
@@ -136,6 +195,7 @@ func NewConfig(c context.Context, flagMap map[string]string) (*Config, error) {
 		Server:      cluster.Server,
 		Namespace:   namespace,
 		flagMap:     flagMap,
+		kubeconfigs: kubeconfigs,
 		ConfigFlags: configFlags,
 		RestConfig:  restConfig,
 	}
@@ -146,10 +206,27 @@ func NewConfig(c context.Context, flagMap map[string]string) (*Config, error) {
 		}
 	}
 
+	if sc := k.kubeconfigExtension.SSH; sc != nil && sc.Host == "" {
+		return nil, errcat.Config.Newf("the ssh entry of extension %s in kubeconfig has no host", configExtension)
+	}
+
 	if k.kubeconfigExtension.Manager == nil {
 		k.kubeconfigExtension.Manager = &managerConfig{}
 	}
 
+	if pu := k.kubeconfigExtension.Manager.ProxyURL; pu != "" {
+		if k.kubeconfigExtension.SSH != nil {
+			return nil, errcat.Config.Newf("the manager proxy-url and the ssh entry of extension %s in kubeconfig are mutually exclusive", configExtension)
+		}
+		u, err := url.Parse(pu)
+		if err == nil {
+			k.managerDialer, err = dnet.NewProxyDialer(u)
+		}
+		if err != nil {
+			return nil, errcat.Config.Newf("invalid manager proxy-url in extension %s in kubeconfig: %w", configExtension, err)
+		}
+	}
+
 	if k.kubeconfigExtension.Manager.Namespace == "" {
 		k.kubeconfigExtension.Manager.Namespace = client.GetEnv(c).ManagerNamespace
 	}
@@ -157,12 +234,55 @@ func NewConfig(c context.Context, flagMap map[string]string) (*Config, error) {
 	return k, nil
 }
 
+// setFlag sets the named flag to the given value. The value of a slice flag, such as --as-group, is the string
+// representation of the whole slice, so it replaces the current slice rather than being appended to it.
+func setFlag(flags *pflag.FlagSet, name, value string) error {
+	flag := flags.Lookup(name)
+	if flag == nil {
+		return fmt.Errorf("unknown flag --%s", name)
+	}
+	sv, ok := flag.Value.(pflag.SliceValue)
+	if !ok {
+		return flags.Set(name, value)
+	}
+	var elems []string
+	if value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]"); value != "" {
+		var err error
+		if elems, err = csv.NewReader(strings.NewReader(value)).Read(); err != nil {
+			return err
+		}
+	}
+	if err := sv.Replace(elems); err != nil {
+		return err
+	}
+	flag.Changed = true
+	return nil
+}
+
+// setKubeconfigEnv sets the KUBECONFIG environment variable to the given path list, or unsets it when the list is
+// empty. The environment must reflect the kubeconfig files of the current session, because client-go reads it
+// every time a config is loaded, and it does so using the same merge semantics as kubectl.
+func setKubeconfigEnv(kubeconfigs []string) error {
+	if len(kubeconfigs) == 0 {
+		return os.Unsetenv("KUBECONFIG")
+	}
+	return os.Setenv("KUBECONFIG", strings.Join(kubeconfigs, string(os.PathListSeparator)))
+}
+
+// RestoreKubeconfigEnv restores the KUBECONFIG environment variable that this instance was created with. It must
+// be called when a Config that was created in order to compare it with this one is discarded.
+func (kf *Config) RestoreKubeconfigEnv() error {
+	return setKubeconfigEnv(kf.kubeconfigs)
+}
+
 // ContextServiceAndFlagsEqual determines if this instance is equal to the given instance with respect to context,
-// server, and flag arguments.
+// server, impersonation, kubeconfig files, and flag arguments.
 func (kf *Config) ContextServiceAndFlagsEqual(okf *Config) bool {
 	return kf != nil && okf != nil &&
 		kf.Context == okf.Context &&
 		kf.Server == okf.Server &&
+		reflect.DeepEqual(kf.RestConfig.Impersonate, okf.RestConfig.Impersonate) &&
+		sortedStringSlicesEqual(kf.kubeconfigs, okf.kubeconfigs) &&
 		mapEqual(kf.flagMap, okf.flagMap)
 }
 
@@ -170,6 +290,132 @@ func (kf *Config) GetManagerNamespace() string {
 	return kf.kubeconfigExtension.Manager.Namespace
 }
 
+// GetManagerAddress returns the address of an externally reachable traffic manager endpoint, and whether that
+// endpoint uses TLS. An empty address means that the traffic manager must be reached using a port-forward.
+func (kf *Config) GetManagerAddress() (string, bool) {
+	return kf.kubeconfigExtension.Manager.Address, kf.kubeconfigExtension.Manager.TLS
+}
+
+// GetManagerWebsocketURL returns the URL of the traffic manager's websocket tunnel endpoint, or an empty string
+// when no such URL has been configured.
+func (kf *Config) GetManagerWebsocketURL() string {
+	return kf.kubeconfigExtension.Manager.WebsocketURL
+}
+
+// startSSHTunnel makes the API server reachable through the configured SSH jump host by forwarding a loopback
+// port to it, and then redirects all REST configs, including those that are derived from the ConfigFlags, to that
+// port. The TLS server name remains the one of the API server so that its certificate can be verified.
+func (kf *Config) startSSHTunnel(c context.Context) error {
+	sc := kf.kubeconfigExtension.SSH
+	sd, err := dnet.NewSSHDialer(&dnet.SSHConfig{
+		Address:        sc.Host,
+		User:           sc.User,
+		IdentityFile:   sc.IdentityFile,
+		KnownHostsFile: sc.KnownHostsFile,
+	})
+	if err != nil {
+		return errcat.Config.New(err)
+	}
+	u, err := url.Parse(kf.RestConfig.Host)
+	if err != nil {
+		return err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		port := "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	localAddr, err := sd.Forward(c, addr)
+	if err != nil {
+		return err
+	}
+	dlog.Infof(c, "Reaching the API server %s through SSH jump host %s", addr, sc.Host)
+	host := *u
+	host.Host = localAddr
+	serverName := u.Hostname()
+	redirect := func(rc *rest.Config) *rest.Config {
+		rc.Host = host.String()
+		if rc.TLSClientConfig.ServerName == "" {
+			rc.TLSClientConfig.ServerName = serverName
+		}
+		return rc
+	}
+	redirect(kf.RestConfig)
+	wrap := kf.ConfigFlags.WrapConfigFn
+	kf.ConfigFlags.WrapConfigFn = func(rc *rest.Config) *rest.Config {
+		if wrap != nil {
+			rc = wrap(rc)
+		}
+		return redirect(rc)
+	}
+	kf.sshDialer = sd
+	return nil
+}
+
+// ManagerDialer returns the function that dials a traffic manager Address. It dials through the SSH jump host or
+// the manager proxy when one is configured.
+func (kf *Config) ManagerDialer(tcpUserTimeout time.Duration) func(context.Context, string) (net.Conn, error) {
+	if d := kf.egressDialer(); d != nil {
+		return d
+	}
+	return dnet.NewTCPDialer(tcpUserTimeout)
+}
+
+// DialManagerWebsocket dials the traffic manager's WebsocketURL, through the SSH jump host or the manager proxy when
+// one is configured. The traffic manager only accepts the websocket when the given identity token verifies.
+func (kf *Config) DialManagerWebsocket(ctx context.Context, token string) (net.Conn, error) {
+	return dnet.DialWebsocketUsing(ctx, kf.GetManagerWebsocketURL(), token, kf.egressDialer())
+}
+
+// egressDialer returns the dialer of the SSH jump host or the manager proxy, or nil when none is configured.
+func (kf *Config) egressDialer() func(context.Context, string) (net.Conn, error) {
+	if sd := kf.sshDialer; sd != nil {
+		return func(ctx context.Context, addr string) (net.Conn, error) {
+			return sd.DialContext(ctx, "tcp", addr)
+		}
+	}
+	return kf.managerDialer
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(rq *http.Request) (*http.Response, error) {
+	return f(rq)
+}
+
+// BearerToken returns the bearer token that the kubeconfig uses to authenticate to the API server. The token is
+// obtained using the credential plugin or the auth provider of the kubeconfig when it has one. An error is
+// returned when the kubeconfig doesn't use a bearer token, e.g. when it uses a client certificate.
+func (kf *Config) BearerToken(ctx context.Context) (string, error) {
+	token := ""
+	rt, err := rest.HTTPWrappersForConfig(kf.RestConfig, roundTripperFunc(func(rq *http.Request) (*http.Response, error) {
+		// Capture the authorization that the wrappers add, without sending the request.
+		if auth := rq.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			token = strings.TrimPrefix(auth, "Bearer ")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: rq}, nil
+	}))
+	if err != nil {
+		return "", err
+	}
+	rq, err := http.NewRequestWithContext(ctx, http.MethodGet, kf.RestConfig.Host, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := rt.RoundTrip(rq)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if token == "" {
+		return "", errcat.User.Newf("the kubeconfig context %q doesn't authenticate using a bearer token", kf.Context)
+	}
+	return token, nil
+}
+
 func mapEqual(a, b map[string]string) bool {
 	if len(a) != len(b) {
 		return false
@@ -0,0 +1,128 @@
+package k8s
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+const impersonatingKubeconfig = `apiVersion: v1
+kind: Config
+current-context: test
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+clusters:
+- name: test
+  cluster:
+    server: https://127.0.0.1:6443
+    extensions:
+    - name: telepresence.io
+      extension:
+        manager:
+          namespace: ambassador
+users:
+- name: test
+  user:
+    token: abc
+    as: alice
+    as-groups:
+    - team
+`
+
+func TestNewConfig_impersonation(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, os.WriteFile(kubeconfig, []byte(impersonatingKubeconfig), 0o600))
+	t.Setenv("KUBECONFIG", kubeconfig)
+
+	fromKubeconfig, err := NewConfig(ctx, map[string]string{"KUBECONFIG": kubeconfig})
+	require.NoError(t, err)
+	assert.Equal(t, "alice", fromKubeconfig.RestConfig.Impersonate.UserName)
+	assert.Equal(t, []string{"team"}, fromKubeconfig.RestConfig.Impersonate.Groups)
+
+	// The CLI sends slice flags using their string representation
+	fromFlags, err := NewConfig(ctx, map[string]string{"KUBECONFIG": kubeconfig, "as": "bob", "as-group": "[dev,ops]"})
+	require.NoError(t, err)
+	assert.Equal(t, "bob", fromFlags.RestConfig.Impersonate.UserName)
+	assert.Equal(t, []string{"dev", "ops"}, fromFlags.RestConfig.Impersonate.Groups)
+	assert.False(t, fromKubeconfig.ContextServiceAndFlagsEqual(fromFlags))
+}
+
+const otherKubeconfig = `apiVersion: v1
+kind: Config
+current-context: other
+contexts:
+- name: other
+  context:
+    cluster: other
+    user: other
+clusters:
+- name: other
+  cluster:
+    server: https://127.0.0.2:6443
+    extensions:
+    - name: telepresence.io
+      extension:
+        manager:
+          namespace: ambassador
+users:
+- name: other
+  user:
+    token: def
+`
+
+func TestNewConfig_kubeconfigList(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first")
+	second := filepath.Join(dir, "second")
+	require.NoError(t, os.WriteFile(first, []byte(impersonatingKubeconfig), 0o600))
+	require.NoError(t, os.WriteFile(second, []byte(otherKubeconfig), 0o600))
+	t.Setenv("KUBECONFIG", first)
+
+	// The current context is the one of the first file, while the "other" context is only found in the second.
+	list := first + string(os.PathListSeparator) + second
+	merged, err := NewConfig(ctx, map[string]string{"KUBECONFIG": list})
+	require.NoError(t, err)
+	assert.Equal(t, "test", merged.Context)
+	assert.Equal(t, "https://127.0.0.1:6443", merged.Server)
+	assert.Equal(t, list, os.Getenv("KUBECONFIG"))
+
+	other, err := NewConfig(ctx, map[string]string{"KUBECONFIG": list, "context": "other"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://127.0.0.2:6443", other.Server)
+
+	single, err := NewConfig(ctx, map[string]string{"KUBECONFIG": first})
+	require.NoError(t, err)
+	assert.Equal(t, merged.Context, single.Context)
+	assert.Equal(t, merged.Server, single.Server)
+	assert.False(t, merged.ContextServiceAndFlagsEqual(single), "a different set of kubeconfig files is a different config")
+
+	require.NoError(t, merged.RestoreKubeconfigEnv())
+	assert.Equal(t, list, os.Getenv("KUBECONFIG"))
+}
+
+func TestConfig_BearerToken(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, os.WriteFile(kubeconfig, []byte(impersonatingKubeconfig), 0o600))
+	t.Setenv("KUBECONFIG", kubeconfig)
+
+	cfg, err := NewConfig(ctx, map[string]string{"KUBECONFIG": kubeconfig})
+	require.NoError(t, err)
+	token, err := cfg.BearerToken(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", token)
+
+	cfg.RestConfig.BearerToken = ""
+	_, err = cfg.BearerToken(ctx)
+	assert.Error(t, err)
+}
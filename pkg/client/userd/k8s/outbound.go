@@ -117,6 +117,13 @@ func (kc *Cluster) SetMappedNamespaces(c context.Context, namespaces []string) b
 	return !equal
 }
 
+// MappedNamespaces returns the namespaces that the client asked to map, or nil when all namespaces are mapped.
+func (kc *Cluster) MappedNamespaces() []string {
+	kc.nsLock.Lock()
+	defer kc.nsLock.Unlock()
+	return append([]string(nil), kc.mappedNamespaces...)
+}
+
 func (kc *Cluster) AddNamespaceListener(nsListener NamespaceListener) {
 	kc.nsLock.Lock()
 	kc.namespaceListeners = append(kc.namespaceListeners, nsListener)
@@ -0,0 +1,129 @@
+package k8s
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/rest"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// minAdaptiveQPS is the lowest rate that an adaptive Throttler lowers its rate to.
+const minAdaptiveQPS = 1
+
+// Throttler is a flowcontrol.RateLimiter for the requests that the user daemon makes to the Kubernetes API server.
+// Its rate can be changed while it's in use, so that a client config served by the traffic-manager takes effect
+// after the connect. An adaptive Throttler halves its rate each time the API server responds with 429 Too Many
+// Requests, and raises it again in small steps while the API server accepts the requests.
+type Throttler struct {
+	ctx      context.Context // used for logging
+	mu       sync.Mutex
+	limiter  *rate.Limiter
+	qps      rate.Limit
+	adaptive bool
+}
+
+// NewThrottler returns a Throttler configured using the given settings.
+func NewThrottler(ctx context.Context, cfg *client.KubeAPI) *Throttler {
+	t := &Throttler{ctx: ctx, limiter: rate.NewLimiter(rate.Limit(rest.DefaultQPS), rest.DefaultBurst)}
+	t.Configure(cfg)
+	return t
+}
+
+// Configure changes the QPS, the burst, and the adaptiveness of the Throttler. The client-go defaults are used
+// for a QPS or burst of zero.
+func (t *Throttler) Configure(cfg *client.KubeAPI) {
+	qps, burst := cfg.QPS, cfg.Burst
+	if qps == 0 {
+		qps = rest.DefaultQPS
+	}
+	if burst == 0 {
+		burst = rest.DefaultBurst
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.qps = rate.Limit(qps)
+	t.adaptive = cfg.Adaptive
+	t.limiter.SetLimit(t.qps)
+	t.limiter.SetBurst(burst)
+	dlog.Debugf(t.ctx, "Kubernetes API requests are limited to %g per second with a burst of %d, adaptive=%t", qps, burst, cfg.Adaptive)
+}
+
+func (t *Throttler) TryAccept() bool {
+	return t.limiter.Allow()
+}
+
+func (t *Throttler) Accept() {
+	_ = t.limiter.Wait(context.Background())
+}
+
+func (t *Throttler) Wait(ctx context.Context) error {
+	return t.limiter.Wait(ctx)
+}
+
+func (t *Throttler) Stop() {
+}
+
+func (t *Throttler) QPS() float32 {
+	return float32(t.limiter.Limit())
+}
+
+// WrapTransport returns a transport that adapts the rate of the Throttler to the responses of the API server.
+func (t *Throttler) WrapTransport(rt http.RoundTripper) http.RoundTripper {
+	return &throttledTransport{throttler: t, next: rt}
+}
+
+// throttled halves the current rate when the Throttler is adaptive.
+func (t *Throttler) throttled() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.adaptive {
+		return
+	}
+	limit := t.limiter.Limit() / 2
+	if limit < minAdaptiveQPS {
+		limit = minAdaptiveQPS
+	}
+	if limit > t.qps {
+		limit = t.qps
+	}
+	if limit < t.limiter.Limit() {
+		dlog.Infof(t.ctx, "Kubernetes API server is throttling requests, lowering the rate to %g per second", float64(limit))
+		t.limiter.SetLimit(limit)
+	}
+}
+
+// accepted raises a lowered rate by a twentieth of the QPS.
+func (t *Throttler) accepted() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if limit := t.limiter.Limit(); limit < t.qps {
+		limit += t.qps / 20
+		if limit > t.qps {
+			limit = t.qps
+		}
+		t.limiter.SetLimit(limit)
+	}
+}
+
+type throttledTransport struct {
+	throttler *Throttler
+	next      http.RoundTripper
+}
+
+func (tt *throttledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := tt.next.RoundTrip(req)
+	if err == nil {
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests:
+			tt.throttler.throttled()
+		case resp.StatusCode < http.StatusBadRequest:
+			tt.throttler.accepted()
+		}
+	}
+	return resp, err
+}
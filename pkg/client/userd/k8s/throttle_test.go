@@ -0,0 +1,70 @@
+package k8s
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/rest"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+func TestThrottler(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+
+	th := NewThrottler(ctx, &client.KubeAPI{})
+	assert.Equal(t, float32(rest.DefaultQPS), th.QPS())
+	assert.Equal(t, rest.DefaultBurst, th.limiter.Burst())
+
+	th.Configure(&client.KubeAPI{QPS: 40, Burst: 80})
+	assert.Equal(t, float32(40), th.QPS())
+	assert.Equal(t, 80, th.limiter.Burst())
+
+	// Not adaptive, so 429 responses don't change the rate
+	th.throttled()
+	assert.Equal(t, float32(40), th.QPS())
+
+	th.Configure(&client.KubeAPI{QPS: 40, Burst: 80, Adaptive: true})
+	th.throttled()
+	assert.Equal(t, float32(20), th.QPS())
+	for i := 0; i < 10; i++ {
+		th.throttled()
+	}
+	assert.Equal(t, float32(minAdaptiveQPS), th.QPS())
+
+	// Accepted requests restore the rate in steps of a twentieth of the QPS
+	th.accepted()
+	assert.Equal(t, float32(minAdaptiveQPS+2), th.QPS())
+	for i := 0; i < 20; i++ {
+		th.accepted()
+	}
+	assert.Equal(t, float32(40), th.QPS())
+}
+
+func TestThrottler_WrapTransport(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	status := http.StatusTooManyRequests
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	th := NewThrottler(ctx, &client.KubeAPI{QPS: 10, Adaptive: true})
+	hc := &http.Client{Transport: th.WrapTransport(http.DefaultTransport)}
+	get := func() {
+		resp, err := hc.Get(srv.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	get()
+	assert.Equal(t, float32(5), th.QPS())
+
+	status = http.StatusOK
+	get()
+	assert.Equal(t, float32(5.5), th.QPS())
+}
@@ -2,8 +2,10 @@ package userd
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"sync"
@@ -11,6 +13,7 @@ import (
 
 	"github.com/spf13/cobra"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
 
 	"github.com/datawire/dlib/dgroup"
 	"github.com/datawire/dlib/dhttp"
@@ -20,14 +23,18 @@ import (
 	"github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/v2/pkg/a8rcloud"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/logging"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/scout"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/userd/auth"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/userd/dashboard"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/userd/internal/broadcastqueue"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/userd/trafficmgr"
 	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
 	"github.com/telepresenceio/telepresence/v2/pkg/log"
+	"github.com/telepresenceio/telepresence/v2/pkg/pprof"
 )
 
 const ProcessName = "connector"
@@ -79,12 +86,19 @@ type service struct {
 	sessionContext context.Context
 	sessionLock    sync.RWMutex
 
+	// sessionState is the state of the session that is restored if the user daemon quits unexpectedly
+	sessionState *cache.SessionState
+	stateLock    sync.Mutex
+
 	// These are used to communicate between the various goroutines.
 	connectRequest  chan *rpc.ConnectRequest // server-grpc.connect() -> connectWorker
 	connectResponse chan *rpc.ConnectInfo    // connectWorker -> server-grpc.connect()
 
 	// This is used for the service to know which CLI commands it supports
 	getCommands CommandFactory
+
+	// dashboard serves the local web dashboard
+	dashboard *dashboard.Server
 }
 
 func (s *service) SetManagerClient(managerClient manager.ManagerClient, callOptions ...grpc.CallOption) {
@@ -97,7 +111,7 @@ func (s *service) RootDaemonClient(c context.Context) (daemon.DaemonClient, erro
 	}
 	// establish a connection to the root daemon gRPC grpcService
 	dlog.Info(c, "Connecting to root daemon...")
-	conn, err := client.DialSocket(c, client.DaemonSocketName)
+	conn, err := client.DialSocket(c, client.DaemonSocketName(c))
 	if err != nil {
 		dlog.Errorf(c, "unable to connect to root daemon: %+v", err)
 		return nil, err
@@ -112,6 +126,7 @@ func (s *service) LoginExecutor() auth.LoginExecutor {
 
 // Command returns the CLI sub-command for "connector-foreground"
 func Command(getCommands CommandFactory, daemonServices []DaemonService, sessionServices []trafficmgr.SessionService) *cobra.Command {
+	var offline bool
 	c := &cobra.Command{
 		Use:    ProcessName + "-foreground",
 		Short:  "Launch Telepresence " + titleName + " in the foreground (debug)",
@@ -119,15 +134,28 @@ func Command(getCommands CommandFactory, daemonServices []DaemonService, session
 		Hidden: true,
 		Long:   help,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return run(cmd.Context(), getCommands, daemonServices, sessionServices)
+			ctx := cmd.Context()
+			if offline {
+				ctx = client.WithOffline(ctx)
+			}
+			return run(ctx, getCommands, daemonServices, sessionServices)
 		},
 	}
+	c.Flags().BoolVar(&offline, "offline", false, "make no network calls other than those to the cluster")
 	return c
 }
 
 func (s *service) configReload(c context.Context) error {
 	return client.Watch(c, func(c context.Context) error {
-		return logging.ReloadDaemonConfig(c, false)
+		if err := logging.ReloadDaemonConfig(c, false); err != nil {
+			return err
+		}
+		s.sessionLock.RLock()
+		defer s.sessionLock.RUnlock()
+		if s.session == nil {
+			return nil
+		}
+		return s.session.ApplyConfig(c)
 	})
 }
 
@@ -139,14 +167,22 @@ func (s *service) manageSessions(c context.Context, sessionServices []trafficmgr
 	// terminates this function, it terminates the whole process.
 	wg := sync.WaitGroup{}
 	c, s.quit = context.WithCancel(c)
+	lost := s.lostSession(c)
 nextSession:
 	for {
-		// Wait for a connection request
+		// Wait for a connection request, unless a lost session is restored
 		var cr *rpc.ConnectRequest
-		select {
-		case <-c.Done():
-			break nextSession
-		case cr = <-s.connectRequest:
+		var restored []*rpc.CreateInterceptRequest
+		restoring := lost != nil
+		if restoring {
+			cr, restored = lost.Request, lost.Intercepts
+			lost = nil
+		} else {
+			select {
+			case <-c.Done():
+				break nextSession
+			case cr = <-s.connectRequest:
+			}
 		}
 
 		var session trafficmgr.Session
@@ -157,7 +193,17 @@ nextSession:
 			if s.session != nil {
 				// UpdateStatus sets rpc.ConnectInfo_ALREADY_CONNECTED if successful
 				rsp = s.session.UpdateStatus(s.sessionContext, cr)
-			} else {
+				if rsp.Error == rpc.ConnectInfo_MUST_RESTART && cr.SwitchContext {
+					// Replace the session. The daemons keep running, and the root daemon replaces its
+					// network session when the new session connects to it.
+					dlog.Infof(c, "Switching from context %s to a new session", rsp.ClusterContext)
+					s.sessionLock.Unlock()
+					s.cancelSession()
+					s.sessionLock.Lock()
+					rsp = nil
+				}
+			}
+			if rsp == nil {
 				sCtx, sCancel := context.WithCancel(c)
 				session, rsp = trafficmgr.NewSession(sCtx, s.scout, cr, s, sessionServices)
 				sCtx = a8rcloud.WithSystemAPool[*SessionClient](sCtx, a8rcloud.UserdConnName, &SessionClientProvider{session})
@@ -172,19 +218,32 @@ nextSession:
 		}
 		s.sessionLock.Unlock()
 
-		select {
-		case <-c.Done():
-			break nextSession
-		case s.connectResponse <- rsp:
-		default:
-			// Nobody there to read the response? That's fine. The user may have got
-			// impatient.
-			s.cancelSession()
-			continue
+		if restoring {
+			if c.Err() != nil {
+				break nextSession
+			}
+		} else {
+			select {
+			case <-c.Done():
+				break nextSession
+			case s.connectResponse <- rsp:
+			default:
+				// Nobody there to read the response? That's fine. The user may have got
+				// impatient.
+				s.cancelSession()
+				continue
+			}
 		}
 		if rsp.Error != rpc.ConnectInfo_UNSPECIFIED {
+			if restoring {
+				dlog.Errorf(c, "unable to restore the lost session: %s", rsp.ErrorText)
+				s.forgetSession(c)
+			}
 			continue
 		}
+		if session != nil {
+			s.recordSession(c, cr, rsp.GetSessionInfo().GetSessionId())
+		}
 
 		// Run the session asynchronously. We must be able to respond to connect (with UpdateStatus) while
 		// the session is running. The s.sessionCancel is called from Disconnect
@@ -206,13 +265,20 @@ nextSession:
 				dlog.Error(c, err)
 			}
 		}(cr)
+		if len(restored) > 0 {
+			go s.restoreIntercepts(s.sessionContext, s.session, restored)
+		}
 	}
 	wg.Wait()
+
+	// Not deferred, because the state must survive a panic
+	s.forgetSession(c)
 	return nil
 }
 
 func (s *service) cancelSessionReadLocked() {
 	if s.sessionCancel != nil {
+		s.forgetSession(s.sessionContext)
 		if err := s.session.ClearIntercepts(s.sessionContext); err != nil {
 			dlog.Errorf(s.sessionContext, "failed to clear intercepts: %v", err)
 		}
@@ -249,7 +315,7 @@ func run(c context.Context, getCommands CommandFactory, daemonServices []DaemonS
 	// Listen on domain unix domain socket or windows named pipe. The listener must be opened
 	// before other tasks because the CLI client will only wait for a short period of time for
 	// the socket/pipe to appear before it gives up.
-	grpcListener, err := client.ListenSocket(c, ProcessName, client.ConnectorSocketName)
+	grpcListener, err := client.ListenSocket(c, ProcessName, client.ConnectorSocketName(c))
 	if err != nil {
 		return err
 	}
@@ -258,6 +324,33 @@ func run(c context.Context, getCommands CommandFactory, daemonServices []DaemonS
 	}()
 	dlog.Debug(c, "Listener opened")
 
+	// Optionally also listen on TCP, for clients that can't reach the socket/pipe.
+	var tcpListener net.Listener
+	var tcpToken string
+	var tcpTLS *tls.Config
+	if addr := cfg.Daemons.UserDaemonTCPAddress; addr != "" {
+		if tcpToken, err = client.NewSocketToken(); err != nil {
+			return err
+		}
+		if dc := &cfg.Daemons; dc.UserDaemonTLSCert != "" {
+			if tcpTLS, err = client.ServerTLSConfig(dc.UserDaemonTLSCert, dc.UserDaemonTLSKey, dc.UserDaemonTLSClientCA); err != nil {
+				return fmt.Errorf("unable to load the TLS configuration of the user daemon: %w", err)
+			}
+		}
+		if tcpListener, err = client.ListenTCP(c, ProcessName, addr, tcpTLS); err != nil {
+			return err
+		}
+		defer func() {
+			_ = tcpListener.Close()
+			_ = cache.DeleteUserDaemonTCPFromUserCache(c)
+		}()
+		udt := &cache.UserDaemonTCP{Address: tcpListener.Addr().String(), Token: tcpToken}
+		if err = cache.SaveUserDaemonTCPToUserCache(c, udt); err != nil {
+			return err
+		}
+		dlog.Infof(c, "Listening to %s", udt.Address)
+	}
+
 	dlog.Info(c, "---")
 	dlog.Infof(c, "Telepresence %s %s starting...", titleName, client.DisplayVersion())
 	dlog.Infof(c, "PID is %d", os.Getpid())
@@ -279,6 +372,7 @@ func run(c context.Context, getCommands CommandFactory, daemonServices []DaemonS
 		timedLogLevel:     log.NewTimedLevel(cfg.LogLevels.UserDaemon.String(), log.SetLevel),
 		getCommands:       getCommands,
 	}
+	s.dashboard = dashboard.NewServer(dashboardBackend{s})
 	if err := logging.LoadTimedLevelFromCache(c, s.timedLogLevel, s.procName); err != nil {
 		return err
 	}
@@ -290,7 +384,7 @@ func run(c context.Context, getCommands CommandFactory, daemonServices []DaemonS
 	})
 
 	g.Go("server-grpc", func(c context.Context) (err error) {
-		opts := []grpc.ServerOption{}
+		opts := logging.CrashReportServerOptions(c, ProcessName)
 		cfg := client.GetConfig(c)
 		if !cfg.Grpc.MaxReceiveSize.IsZero() {
 			if mz, ok := cfg.Grpc.MaxReceiveSize.AsInt64(); ok {
@@ -300,6 +394,10 @@ func run(c context.Context, getCommands CommandFactory, daemonServices []DaemonS
 		s.svc = grpc.NewServer(opts...)
 		rpc.RegisterConnectorServer(s.svc, s)
 		manager.RegisterManagerServer(s.svc, s.managerProxy)
+		grpc_health_v1.RegisterHealthServer(s.svc, client.NewHealthServer(c, func() {
+			s.sessionLock.RLock()
+			s.sessionLock.RUnlock() //nolint:staticcheck // empty critical section, the lock is only probed
+		}, rpc.Connector_ServiceDesc.ServiceName, manager.Manager_ServiceDesc.ServiceName))
 		for _, ds := range daemonServices {
 			dlog.Infof(c, "Starting additional daemon service %s", ds.Name())
 			if err := ds.Start(c, sr, s.svc, s.withSession); err != nil {
@@ -307,8 +405,24 @@ func run(c context.Context, getCommands CommandFactory, daemonServices []DaemonS
 			}
 		}
 
-		sc := &dhttp.ServerConfig{Handler: s.svc}
+		sc := &dhttp.ServerConfig{Handler: s.svc, HTTP2Config: cfg.Grpc.HTTP2Server()}
 		dlog.Info(c, "gRPC server started")
+		if tcpListener != nil {
+			g.Go("server-grpc-tcp", func(c context.Context) error {
+				sc := &dhttp.ServerConfig{Handler: client.TokenHandler(tcpToken, s.svc), HTTP2Config: cfg.Grpc.HTTP2Server()}
+				var err error
+				if tcpTLS != nil {
+					sc.TLSConfig = tcpTLS
+					err = sc.ServeTLS(c, tcpListener, "", "")
+				} else {
+					err = sc.Serve(c, tcpListener)
+				}
+				if err != nil && c.Err() == nil {
+					return err
+				}
+				return nil
+			})
+		}
 		if err = sc.Serve(c, grpcListener); err != nil && c.Err() != nil {
 			err = nil // Normal shutdown
 		}
@@ -321,20 +435,29 @@ func run(c context.Context, getCommands CommandFactory, daemonServices []DaemonS
 	})
 
 	g.Go("config-reload", s.configReload)
-	g.Go("session", func(c context.Context) error {
+	g.Go("session", logging.WithCrashReport(ProcessName, func(c context.Context) error {
 		err := s.manageSessions(c, sessionServices)
 		cliio.Close()
 		return err
-	})
+	}))
+	if port := cfg.Daemons.UserDaemonProfilingPort; port != 0 {
+		g.Go("pprof", func(c context.Context) error { return pprof.Serve(c, port) })
+	}
 
 	// background-systema runs a localhost HTTP server for handling callbacks from the
 	// Ambassador Cloud login flow.
 	g.Go("background-systema", s.loginExecutor.Worker)
 
+	// dashboard runs a localhost HTTP server for the web dashboard once it's requested.
+	g.Go("dashboard", s.dashboard.Worker)
+
 	// background-metriton is the goroutine that handles all telemetry reports, so that calls to
 	// metriton don't block the functional goroutines.
 	g.Go("background-metriton", s.scout.Run)
 
+	// background-update-check checks for updates of telepresence when the background check is enabled.
+	g.Go("background-update-check", cli.BackgroundUpdateCheck)
+
 	err = g.Wait()
 	if err != nil {
 		dlog.Error(c, err)
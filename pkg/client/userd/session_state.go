@@ -0,0 +1,118 @@
+package userd
+
+import (
+	"context"
+	"os"
+
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/userd/trafficmgr"
+)
+
+// recordSession saves the state of a newly established session, so that the session can be restored if the
+// user daemon quits unexpectedly.
+func (s *service) recordSession(c context.Context, cr *rpc.ConnectRequest, sessionID string) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	s.sessionState = &cache.SessionState{SessionID: sessionID, Request: cr}
+	s.saveSessionStateLocked(c)
+}
+
+// recordIntercept adds the given intercept to the state of the session.
+func (s *service) recordIntercept(c context.Context, ir *rpc.CreateInterceptRequest) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	if s.sessionState == nil {
+		return
+	}
+	ics := s.sessionState.Intercepts[:0]
+	for _, ic := range s.sessionState.Intercepts {
+		if ic.Spec.Name != ir.Spec.Name {
+			ics = append(ics, ic)
+		}
+	}
+	s.sessionState.Intercepts = append(ics, ir)
+	s.saveSessionStateLocked(c)
+}
+
+// forgetIntercept removes the intercept with the given name from the state of the session.
+func (s *service) forgetIntercept(c context.Context, name string) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	if s.sessionState == nil {
+		return
+	}
+	ics := s.sessionState.Intercepts[:0]
+	for _, ic := range s.sessionState.Intercepts {
+		if ic.Spec.Name != name {
+			ics = append(ics, ic)
+		}
+	}
+	s.sessionState.Intercepts = ics
+	s.saveSessionStateLocked(c)
+}
+
+// forgetSession removes the state of the session. It's called when the session ends normally.
+func (s *service) forgetSession(c context.Context) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	s.sessionState = nil
+	if err := cache.DeleteSessionStateFromUserCache(c); err != nil {
+		dlog.Errorf(c, "failed to delete session state: %v", err)
+	}
+}
+
+func (s *service) saveSessionStateLocked(c context.Context) {
+	if err := cache.SaveSessionStateToUserCache(c, s.sessionState); err != nil {
+		dlog.Errorf(c, "failed to save session state: %v", err)
+	}
+}
+
+// lostSession returns the state of a session that was active when a previous user daemon quit unexpectedly, or
+// nil if there is no such session. The root daemon session of the lost session is disconnected, so that its routes
+// don't compete with the ones of the restored session.
+func (s *service) lostSession(c context.Context) *cache.SessionState {
+	ss, err := cache.LoadSessionStateFromUserCache(c)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			dlog.Errorf(c, "failed to load session state: %v", err)
+		}
+		return nil
+	}
+	if ss.Request == nil {
+		s.forgetSession(c)
+		return nil
+	}
+	dlog.Infof(c, "Restoring the session of a user daemon that quit unexpectedly")
+	if ss.SessionID != "" {
+		if rd, err := s.RootDaemonClient(c); err == nil {
+			if _, err = rd.Disconnect(client.WithRootDaemonSession(c, ss.SessionID), &empty.Empty{}); err != nil {
+				dlog.Warnf(c, "failed to disconnect the root daemon session of the lost session: %v", err)
+			}
+		}
+	}
+	return ss
+}
+
+// restoreIntercepts re-creates the intercepts of a lost session in the given session.
+func (s *service) restoreIntercepts(c context.Context, session trafficmgr.Session, irs []*rpc.CreateInterceptRequest) {
+	for _, ir := range irs {
+		if ir.GetSpec() == nil {
+			continue
+		}
+		result, err := session.AddIntercept(c, ir)
+		switch {
+		case err != nil:
+			dlog.Errorf(c, "failed to restore intercept %s: %v", ir.Spec.Name, err)
+		case result.Error != rpc.InterceptError_UNSPECIFIED:
+			dlog.Errorf(c, "failed to restore intercept %s: %s %s", ir.Spec.Name, result.Error, result.ErrorText)
+		default:
+			dlog.Infof(c, "Restored intercept %s", ir.Spec.Name)
+			s.recordIntercept(c, ir)
+		}
+	}
+}
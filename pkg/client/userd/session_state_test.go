@@ -0,0 +1,162 @@
+package userd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/userd/trafficmgr"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+func testCacheContext(t *testing.T) context.Context {
+	ctx := dlog.NewTestContext(t, false)
+	ctx = filelocation.WithGOOS(ctx, "linux")
+	return filelocation.WithUserHomeDir(ctx, t.TempDir())
+}
+
+func interceptRequest(name string) *rpc.CreateInterceptRequest {
+	return &rpc.CreateInterceptRequest{Spec: &manager.InterceptSpec{Name: name, Agent: name, Namespace: "default"}}
+}
+
+func interceptNames(ss *cache.SessionState) []string {
+	names := make([]string, len(ss.Intercepts))
+	for i, ic := range ss.Intercepts {
+		names[i] = ic.Spec.Name
+	}
+	return names
+}
+
+func Test_recordSession(t *testing.T) {
+	ctx := testCacheContext(t)
+	s := &service{}
+
+	// Intercepts are only recorded for a session
+	s.recordIntercept(ctx, interceptRequest("echo"))
+	_, err := cache.LoadSessionStateFromUserCache(ctx)
+	assert.True(t, os.IsNotExist(err))
+
+	cr := &rpc.ConnectRequest{KubeFlags: map[string]string{"namespace": "ambassador"}, MappedNamespaces: []string{"default"}}
+	s.recordSession(ctx, cr, "session-1")
+	s.recordIntercept(ctx, interceptRequest("echo"))
+	s.recordIntercept(ctx, interceptRequest("web"))
+	s.recordIntercept(ctx, interceptRequest("echo"))
+	ss, err := cache.LoadSessionStateFromUserCache(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "session-1", ss.SessionID)
+	assert.True(t, proto.Equal(cr, ss.Request))
+	assert.Equal(t, []string{"web", "echo"}, interceptNames(ss), "a re-created intercept isn't recorded twice")
+
+	s.forgetIntercept(ctx, "web")
+	s.forgetIntercept(ctx, "other")
+	ss, err = cache.LoadSessionStateFromUserCache(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"echo"}, interceptNames(ss))
+
+	s.forgetSession(ctx)
+	_, err = cache.LoadSessionStateFromUserCache(ctx)
+	assert.True(t, os.IsNotExist(err))
+}
+
+// disconnectRecorder is a daemon.DaemonClient that records the root daemon sessions that are disconnected.
+type disconnectRecorder struct {
+	daemon.DaemonClient
+	sessions []string
+	err      error
+}
+
+func (d *disconnectRecorder) Disconnect(ctx context.Context, _ *empty.Empty, _ ...grpc.CallOption) (*empty.Empty, error) {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	d.sessions = append(d.sessions, md.Get("x-telepresence-session-id")...)
+	return &empty.Empty{}, d.err
+}
+
+func Test_lostSession(t *testing.T) {
+	ctx := testCacheContext(t)
+	rd := &disconnectRecorder{}
+	s := &service{daemonClient: rd}
+
+	// No session was lost
+	assert.Nil(t, s.lostSession(ctx))
+
+	// A state without a request can't be restored, and is removed
+	require.NoError(t, cache.SaveSessionStateToUserCache(ctx, &cache.SessionState{SessionID: "session-1"}))
+	assert.Nil(t, s.lostSession(ctx))
+	_, err := cache.LoadSessionStateFromUserCache(ctx)
+	assert.True(t, os.IsNotExist(err))
+	assert.Empty(t, rd.sessions)
+
+	// A state that can't be read isn't restored
+	dir, err := filelocation.AppUserCacheDir(ctx)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(dir+"/session.json", []byte("{"), 0o600))
+	assert.Nil(t, s.lostSession(ctx))
+
+	// The lost session is returned, and its root daemon session is disconnected, also when that fails
+	cr := &rpc.ConnectRequest{MappedNamespaces: []string{"default"}}
+	require.NoError(t, cache.SaveSessionStateToUserCache(ctx, &cache.SessionState{
+		SessionID:  "session-1",
+		Request:    cr,
+		Intercepts: []*rpc.CreateInterceptRequest{interceptRequest("echo")},
+	}))
+	rd.err = errors.New("root daemon session not found")
+	ss := s.lostSession(ctx)
+	require.NotNil(t, ss)
+	assert.True(t, proto.Equal(cr, ss.Request))
+	assert.Equal(t, []string{"echo"}, interceptNames(ss))
+	assert.Equal(t, []string{"session-1"}, rd.sessions)
+}
+
+// interceptSession is a trafficmgr.Session that only implements AddIntercept, using the given results.
+type interceptSession struct {
+	trafficmgr.Session
+	results map[string]*rpc.InterceptResult
+	added   []string
+}
+
+func (is *interceptSession) AddIntercept(_ context.Context, ir *rpc.CreateInterceptRequest) (*rpc.InterceptResult, error) {
+	is.added = append(is.added, ir.Spec.Name)
+	r, ok := is.results[ir.Spec.Name]
+	if !ok {
+		return nil, errors.New("traffic-manager unavailable")
+	}
+	return r, nil
+}
+
+func Test_restoreIntercepts(t *testing.T) {
+	ctx := testCacheContext(t)
+	s := &service{}
+	s.recordSession(ctx, &rpc.ConnectRequest{}, "session-2")
+	session := &interceptSession{results: map[string]*rpc.InterceptResult{
+		"echo":  {},
+		"web":   {Error: rpc.InterceptError_NOT_FOUND, ErrorText: "workload web not found"},
+		"other": {},
+	}}
+	s.restoreIntercepts(ctx, session, []*rpc.CreateInterceptRequest{
+		interceptRequest("echo"),
+		{}, // A request without a spec is skipped
+		interceptRequest("web"),
+		interceptRequest("failing"),
+		interceptRequest("other"),
+	})
+
+	// All intercepts are attempted, but only the ones that were re-created are recorded
+	assert.Equal(t, []string{"echo", "web", "failing", "other"}, session.added)
+	ss, err := cache.LoadSessionStateFromUserCache(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "session-2", ss.SessionID)
+	assert.Equal(t, []string{"echo", "other"}, interceptNames(ss))
+}
@@ -74,6 +74,7 @@ func (tm *TrafficManager) setCurrentAgents(ctx context.Context, agents []*manage
 	tm.currentAgents = agents
 	dlog.Debugf(ctx, "setCurrentAgents %s", agentsStringer(agents))
 	tm.currentAgentsLock.Unlock()
+	tm.wlWatcher.notify()
 }
 
 func (tm *TrafficManager) notifyAgentWatchers(ctx context.Context, agents []*manager.AgentInfo) {
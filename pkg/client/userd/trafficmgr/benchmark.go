@@ -0,0 +1,113 @@
+package trafficmgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/benchmark"
+	"github.com/telepresenceio/telepresence/v2/pkg/dnet"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+const (
+	defaultBenchmarkSize       = 10 * 1024 * 1024
+	defaultBenchmarkRoundTrips = 20
+)
+
+// Benchmark measures latency and throughput between this client and the traffic-manager using two different
+// paths. The "tunnel" path dials the traffic-manager pod IP, which means that the traffic is routed through the
+// root daemon's TUN-device and the traffic-manager's tunnel. The "port-forward" path uses a direct Kubernetes
+// port-forward for comparison.
+func (tm *TrafficManager) Benchmark(ctx context.Context, rq *connector.BenchmarkRequest) (*connector.BenchmarkResponse, error) {
+	size := rq.Size
+	if size == 0 {
+		size = defaultBenchmarkSize
+	}
+	roundTrips := int(rq.RoundTrips)
+	if roundTrips == 0 {
+		roundTrips = defaultBenchmarkRoundTrips
+	}
+
+	port := fmt.Sprint(install.ManagerPortHTTP)
+	rsp := &connector.BenchmarkResponse{}
+
+	podIP, err := tm.managerPodIP(ctx)
+	if err == nil {
+		dialer := &net.Dialer{}
+		rsp.Results = append(rsp.Results, runBenchmark(ctx, "tunnel", dialer.DialContext, net.JoinHostPort(podIP.String(), port), size, roundTrips))
+	} else {
+		rsp.Results = append(rsp.Results, &connector.BenchmarkResult{Name: "tunnel", Error: err.Error()})
+	}
+
+	pfDialer, err := dnet.NewK8sPortForwardDialer(ctx, tm.Config.RestConfig, k8sapi.GetK8sInterface(ctx))
+	if err == nil {
+		dial, addr := portForwardTarget(pfDialer, tm.GetManagerNamespace(), port)
+		rsp.Results = append(rsp.Results, runBenchmark(ctx, "port-forward", dial, addr, size, roundTrips))
+	} else {
+		rsp.Results = append(rsp.Results, &connector.BenchmarkResult{Name: "port-forward", Error: err.Error()})
+	}
+	return rsp, nil
+}
+
+// portForwardTarget returns a dialer that dials the traffic-manager service in the given namespace using the given
+// port-forward dialer, and the address to benchmark with it. The address is used in the benchmark URLs, so it can't
+// contain the "svc/" prefix that the port-forward dialer needs; the dialer adds it to the address that it's asked to
+// dial instead.
+func portForwardTarget(
+	pfDialer func(context.Context, string) (net.Conn, error),
+	namespace, port string,
+) (func(context.Context, string, string) (net.Conn, error), string) {
+	dial := func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return pfDialer(ctx, "svc/"+addr)
+	}
+	return dial, net.JoinHostPort("traffic-manager."+namespace, port)
+}
+
+// managerPodIP returns the IP of the traffic-manager pod, as reported by the traffic-manager.
+func (tm *TrafficManager) managerPodIP(ctx context.Context) (net.IP, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := tm.managerClient.WatchClusterInfo(ctx, tm.session())
+	if err != nil {
+		return nil, err
+	}
+	info, err := stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	if len(info.ManagerPodIp) == 0 {
+		return nil, errors.New("traffic-manager did not report its pod IP")
+	}
+	return info.ManagerPodIp, nil
+}
+
+func runBenchmark(
+	ctx context.Context,
+	name string,
+	dial func(context.Context, string, string) (net.Conn, error),
+	addr string,
+	size int64,
+	roundTrips int,
+) *connector.BenchmarkResult {
+	dlog.Debugf(ctx, "running %s benchmark against %s", name, addr)
+	br := &connector.BenchmarkResult{Name: name}
+	r, err := benchmark.Measure(ctx, dial, addr, size, roundTrips)
+	if err != nil {
+		dlog.Errorf(ctx, "%s benchmark failed: %v", name, err)
+		br.Error = err.Error()
+		return br
+	}
+	br.MinRtt = durationpb.New(r.MinRTT)
+	br.AvgRtt = durationpb.New(r.AvgRTT)
+	br.MaxRtt = durationpb.New(r.MaxRTT)
+	br.UploadBps = r.UploadBPS
+	br.DownloadBps = r.DownloadBPS
+	return br
+}
@@ -0,0 +1,40 @@
+package trafficmgr
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/benchmark"
+)
+
+func Test_portForwardTarget(t *testing.T) {
+	srv := httptest.NewServer(benchmark.Handler(http.NotFoundHandler()))
+	defer srv.Close()
+
+	// A port-forward dialer that dials the benchmark server when it's asked to dial the traffic-manager service
+	var dialed []string
+	pfDialer := func(ctx context.Context, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", strings.TrimPrefix(srv.URL, "http://"))
+	}
+	dial, addr := portForwardTarget(pfDialer, "ambassador", "8081")
+	assert.Equal(t, "traffic-manager.ambassador:8081", addr)
+
+	br := runBenchmark(dlog.NewTestContext(t, false), "port-forward", dial, addr, 1024, 2)
+	require.Empty(t, br.Error)
+	assert.NotZero(t, br.AvgRtt.AsDuration())
+	assert.NotZero(t, br.UploadBps)
+	require.NotEmpty(t, dialed)
+	for _, d := range dialed {
+		assert.Equal(t, "svc/traffic-manager.ambassador:8081", d)
+	}
+}
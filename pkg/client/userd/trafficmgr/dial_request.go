@@ -2,15 +2,36 @@ package trafficmgr
 
 import (
 	"context"
+	"time"
 
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
 )
 
 func (tm *TrafficManager) dialRequestWatcher(ctx context.Context) error {
-	// Deal with dial requests from the manager
-	dialerStream, err := tm.managerClient.WatchDial(ctx, tm.sessionInfo)
-	if err != nil {
-		return err
+	compression := client.GetConfig(ctx).Tunnel.Compression
+	ctx = tunnel.WithConnObserver(ctx, tm.traffic.observe)
+
+	// Deal with dial requests from the manager. The watch ends when the session expires, so it's restarted
+	// in case the session is resumed.
+	backoff := 100 * time.Millisecond
+	for ctx.Err() == nil {
+		dialerStream, err := tm.managerClient.WatchDial(ctx, tm.sessionInfo)
+		if err == nil {
+			backoff = 100 * time.Millisecond
+			err = tunnel.DialWaitLoop(ctx, tm.managerClient, dialerStream, tm.sessionInfo.SessionId, compression)
+		}
+		if err != nil && ctx.Err() == nil {
+			dlog.Debugf(ctx, "dial request watcher: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+			if backoff < 3*time.Second {
+				backoff *= 2
+			}
+		}
 	}
-	return tunnel.DialWaitLoop(ctx, tm.managerClient, dialerStream, tm.sessionInfo.SessionId)
+	return nil
 }
@@ -783,5 +783,8 @@ func addAgentToWorkload(
 }
 
 func (ki *installer) EnsureManager(c context.Context) error {
-	return helm.EnsureTrafficManager(c, ki.ConfigFlags, ki.GetManagerNamespace())
+	if err := helm.EnsureTrafficManager(c, ki.ConfigFlags, ki.GetManagerNamespace()); err != nil {
+		return err
+	}
+	return helm.ValidateImages(c, ki.GetManagerNamespace())
 }
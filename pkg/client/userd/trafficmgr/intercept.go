@@ -8,6 +8,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"runtime"
 	"sort"
 	"strconv"
@@ -31,6 +32,7 @@ import (
 	"github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/rpc/v2/userdaemon"
 	"github.com/telepresenceio/telepresence/v2/pkg/a8rcloud"
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/userd/auth"
@@ -52,6 +54,9 @@ type mountForward struct {
 	forwardKey
 	SftpPort         int32
 	RemoteMountPoint string
+
+	// VolumeMounts are the mount paths of the intercepted container's volumes
+	VolumeMounts []string
 }
 
 type portForward struct {
@@ -92,7 +97,7 @@ func (lpf livePortForwards) start(ctx context.Context, tm *TrafficManager, ii *m
 		if _, isLive := lpf.live[fk]; !isLive {
 			pfCtx, pfCancel := context.WithCancel(ctx)
 			livePortForward := &livePortForward{cancel: pfCancel}
-			tm.startForwards(pfCtx, &livePortForward.wg, fk, ii.SftpPort, ii.MountPoint, ii.Spec.ExtraPorts)
+			tm.startForwards(pfCtx, &livePortForward.wg, fk, ii.SftpPort, ii.MountPoint, volumeMounts(ii), ii.Spec.ExtraPorts)
 			dlog.Debugf(ctx, "Started forward for %+v", fk)
 			lpf.live[fk] = livePortForward
 		}
@@ -120,22 +125,23 @@ func (lpf livePortForwards) cancelUnwanted(ctx context.Context) {
 func (tm *TrafficManager) reconcileMountPoints(ctx context.Context, existingIntercepts map[string]struct{}) {
 	var mountsToDelete []any
 	tm.mountPoints.Range(func(key, value any) bool {
-		if _, ok := existingIntercepts[value.(string)]; !ok {
+		if _, ok := existingIntercepts[value.(*interceptMount).name]; !ok {
 			mountsToDelete = append(mountsToDelete, key)
 		}
 		return true
 	})
 
 	for _, key := range mountsToDelete {
-		if _, loaded := tm.mountPoints.LoadAndDelete(key); loaded {
+		if value, loaded := tm.mountPoints.LoadAndDelete(key); loaded {
 			// Execute the removal in a separate go-routine so that we don't hang the daemon in case
 			// the removal hangs on a "resource busy".
-			go func(mountPoint string) {
+			go func(mountPoint string, im *interceptMount) {
 				if runtime.GOOS == "darwin" {
 					//  macFUSE will sometimes not unmount in a timely manner so we do this to avoid "resource busy" and
 					//  "Device not configured" errors.
 					_ = proc.CommandContext(ctx, "umount", mountPoint).Run()
 				}
+				removeMountDirs(mountPoint, im.getMountedPaths())
 				err := os.Remove(mountPoint)
 				switch {
 				case err == nil:
@@ -145,7 +151,7 @@ func (tm *TrafficManager) reconcileMountPoints(ctx context.Context, existingInte
 				default:
 					dlog.Errorf(ctx, "Failed to remove mount point %q: %v", mountPoint, err)
 				}
-			}(key.(string))
+			}(key.(string), value.(*interceptMount))
 		}
 	}
 }
@@ -193,11 +199,26 @@ func (tm *TrafficManager) workerPortForwardIntercepts(ctx context.Context) error
 				allNames[intercept.Spec.Name] = struct{}{}
 
 				var iceptError error
+				pending := false
 				switch intercept.Disposition {
 				case manager.InterceptDispositionType_ACTIVE:
 					// do nothing
-				case manager.InterceptDispositionType_WAITING:
-					continue
+				case manager.InterceptDispositionType_WAITING, manager.InterceptDispositionType_NO_AGENT:
+					if k8sapi.IsBatchKind(intercept.Spec.WorkloadKind) {
+						// The pods of a Job come and go, so the intercept is pending until a pod with an agent arrives,
+						// and again when it's gone.
+						pending = true
+						break
+					}
+					if intercept.Disposition == manager.InterceptDispositionType_WAITING {
+						if intercept.Spec.NoRolloutWait {
+							// The intercept was created without waiting for the rollout to bring an agent
+							pending = true
+							break
+						}
+						continue
+					}
+					fallthrough
 				default:
 					iceptError = fmt.Errorf("intercept in error state %v: %v", intercept.Disposition, intercept.Message)
 				}
@@ -219,7 +240,9 @@ func (tm *TrafficManager) workerPortForwardIntercepts(ctx context.Context) error
 				}
 				if iceptError == nil {
 					namespaces[intercept.Spec.Namespace] = struct{}{}
-					portForwards.start(ctx, tm, intercept)
+					if !pending {
+						portForwards.start(ctx, tm, intercept)
+					}
 				}
 			}
 			portForwards.cancelUnwanted(ctx)
@@ -263,7 +286,25 @@ func (tm *TrafficManager) setCurrentIntercepts(ctx context.Context, intercepts [
 	tm.currentInterceptsLock.Lock()
 	tm.currentIntercepts = intercepts
 	tm.reconcileAPIServers(ctx)
+	var accepted []*manager.InterceptInfo
+	for id, ii := range tm.offeredIntercepts {
+		found := false
+		for _, cept := range intercepts {
+			if cept.Id == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			delete(tm.offeredIntercepts, id)
+			accepted = append(accepted, ii)
+		}
+	}
 	tm.currentInterceptsLock.Unlock()
+	for _, ii := range accepted {
+		tm.stopInterceptor(ctx, ii)
+	}
+	tm.wlWatcher.notify()
 }
 
 func interceptError(tp rpc.InterceptError, err error) *rpc.InterceptResult {
@@ -321,6 +362,83 @@ func (s *serviceProps) interceptResult() *rpc.InterceptResult {
 	}
 }
 
+// isSameInterceptTarget returns true if the given specs intercept the same workload in the same namespace.
+func isSameInterceptTarget(a, b *manager.InterceptSpec) bool {
+	return a.Agent == b.Agent && a.Namespace == b.Namespace && a.Cluster == b.Cluster
+}
+
+// ownedIntercept returns the intercept with the same name and target as the given spec, or nil if this client
+// doesn't own such an intercept.
+func (tm *TrafficManager) ownedIntercept(spec *manager.InterceptSpec) *manager.InterceptInfo {
+	for _, iCept := range tm.getCurrentIntercepts() {
+		if iCept.Spec.Name == spec.Name && isSameInterceptTarget(iCept.Spec, spec) {
+			return iCept
+		}
+	}
+	return nil
+}
+
+// replaceIntercept removes the given intercept and waits until it, and its mount point, are gone, so that a new
+// intercept with the same name can be created. The traffic-manager identifies an intercept by its name, so the new
+// intercept can't be created before the old one is removed. The returned request recreates the old intercept, and
+// must be passed to restoreIntercept if the new intercept can't be created.
+func (tm *TrafficManager) replaceIntercept(c context.Context, ii *manager.InterceptInfo, agentImage string) (*rpc.CreateInterceptRequest, error) {
+	name := ii.Spec.Name
+	restore := &rpc.CreateInterceptRequest{
+		Spec:       proto.Clone(ii.Spec).(*manager.InterceptSpec),
+		AgentImage: agentImage,
+	}
+	if mountPoint, im := tm.mountForIntercept(name); im != nil {
+		restore.MountPoint = mountPoint
+		restore.MountReadOnly = im.readOnly
+		restore.MountInclude = im.include
+		restore.MountExclude = im.exclude
+	}
+	dlog.Debugf(c, "replacing intercept %s", name)
+	if err := tm.removeIntercept(c, ii); err != nil && grpcStatus.Code(err) != grpcCodes.NotFound {
+		return nil, err
+	}
+	if err := tm.waitForInterceptRemoval(c, name); err != nil {
+		return restore, err
+	}
+	return restore, nil
+}
+
+// restoreIntercept recreates an intercept that was removed by replaceIntercept, after the intercept that was meant
+// to replace it failed. The failed intercept is removed by then, or about to be, so it's waited for first.
+func (tm *TrafficManager) restoreIntercept(c context.Context, ir *rpc.CreateInterceptRequest) {
+	name := ir.Spec.Name
+	c, cancel := client.GetConfig(c).Timeouts.TimeoutContext(dcontext.WithoutCancel(c), client.TimeoutIntercept)
+	defer cancel()
+	err := tm.waitForInterceptRemoval(c, name)
+	if err == nil {
+		dlog.Infof(c, "restoring intercept %s", name)
+		var result *rpc.InterceptResult
+		if result, err = tm.AddIntercept(c, ir); err == nil && result.Error != rpc.InterceptError_UNSPECIFIED {
+			err = errors.New(result.ErrorText)
+		}
+	}
+	if err != nil {
+		dlog.Errorf(c, "unable to restore intercept %s: %v", name, err)
+	}
+}
+
+// waitForInterceptRemoval waits until the intercept with the given name, and its mount point, are gone.
+func (tm *TrafficManager) waitForInterceptRemoval(c context.Context, name string) error {
+	for tm.GetInterceptSpec(name) != nil || tm.mountPointForIntercept(name) != "" {
+		dtime.SleepWithContext(c, 100*time.Millisecond)
+		if err := c.Err(); err != nil {
+			return client.CheckTimeout(c, err)
+		}
+	}
+	return nil
+}
+
+// replacementFailed returns true if the result of AddIntercept means that no intercept was created.
+func replacementFailed(result *rpc.InterceptResult, err error) bool {
+	return err != nil || result == nil || result.Error != rpc.InterceptError_UNSPECIFIED
+}
+
 func imageVersion(image string) *semver.Version {
 	if cp := strings.LastIndexByte(image, ':'); cp > 0 {
 		if v, err := semver.Parse(image[cp+1:]); err == nil {
@@ -336,18 +454,35 @@ func imageVersion(image string) *semver.Version {
 func (tm *TrafficManager) CanIntercept(c context.Context, ir *rpc.CreateInterceptRequest) (*serviceProps, *rpc.InterceptResult) {
 	tm.waitForSync(c)
 	spec := ir.Spec
-	spec.Namespace = tm.ActualNamespace(spec.Namespace)
-	if spec.Namespace == "" {
+	if spec.Cluster != "" {
+		// The namespaces of a peered cluster aren't mapped
+		if spec.Agent == "" {
+			return nil, interceptError(rpc.InterceptError_TRAFFIC_MANAGER_ERROR, errcat.User.New(
+				"a local-only intercept can't be created in a peered cluster"))
+		}
+		if tm.managerVersion.LT(firstFederationVersion) {
+			return nil, interceptError(rpc.InterceptError_TRAFFIC_MANAGER_ERROR,
+				errcat.User.Newf("traffic-manager version %s cannot intercept in peered clusters, version %s or later is required",
+					tm.managerVersion, firstFederationVersion))
+		}
+		if spec.Namespace == "" {
+			spec.Namespace = tm.ConnectedNamespace()
+		}
+	} else if spec.Namespace = tm.ActualNamespace(spec.Namespace); spec.Namespace == "" {
 		// namespace is not currently mapped
 		return nil, interceptError(rpc.InterceptError_NO_ACCEPTABLE_WORKLOAD, errcat.User.Newf(ir.Spec.Agent))
 	}
 
-	if _, inUse := tm.localIntercepts[spec.Name]; inUse {
+	if ns, inUse := tm.localIntercepts[spec.Name]; inUse && !(spec.Agent == "" && ns == spec.Namespace) {
 		return nil, interceptError(rpc.InterceptError_ALREADY_EXISTS, errcat.User.Newf(spec.Name))
 	}
 
 	for _, iCept := range tm.getCurrentIntercepts() {
 		if iCept.Spec.Name == spec.Name {
+			if isSameInterceptTarget(iCept.Spec, spec) {
+				// Intercepts that this client owns are updated when they are created again.
+				continue
+			}
 			return nil, interceptError(rpc.InterceptError_ALREADY_EXISTS, errcat.User.Newf(spec.Name))
 		}
 		if iCept.Spec.TargetPort == spec.TargetPort && iCept.Spec.TargetHost == spec.TargetHost {
@@ -362,6 +497,11 @@ func (tm *TrafficManager) CanIntercept(c context.Context, ir *rpc.CreateIntercep
 	if spec.Agent == "" {
 		return nil, nil
 	}
+	if tm.session().ConnectOnly {
+		return nil, interceptError(rpc.InterceptError_TRAFFIC_MANAGER_ERROR, errcat.User.New(
+			"this session is connect-only, so it can't intercept. Reconnect without --connect-only, or ask the "+
+				"cluster administrator for intercept rights"))
+	}
 
 	apiKey, err := tm.getCloudAPIKey(c, a8rcloud.KeyDescAgent(spec), false)
 	if err != nil {
@@ -370,6 +510,18 @@ func (tm *TrafficManager) CanIntercept(c context.Context, ir *rpc.CreateIntercep
 		}
 	}
 
+	if spec.Cluster == "" {
+		// The permissions of the user in a peered cluster are unknown to this client
+		if err := checkInterceptAccess(c, spec, tm.managerVersion.LT(firstAgentConfigMapVersion)); err != nil {
+			return nil, interceptError(rpc.InterceptError_FAILED_TO_ESTABLISH, err)
+		}
+	}
+
+	if spec.Replace && tm.managerVersion.LT(firstReplaceVersion) {
+		return nil, interceptError(rpc.InterceptError_TRAFFIC_MANAGER_ERROR,
+			errcat.User.Newf("traffic-manager version %s cannot replace containers, version %s or later is required",
+				tm.managerVersion, firstReplaceVersion))
+	}
 	if tm.managerVersion.LT(firstAgentConfigMapVersion) {
 		// fall back traffic-manager behaviour prior to 2.6
 		return tm.legacyCanInterceptEpilog(c, ir, apiKey)
@@ -398,6 +550,40 @@ func (tm *TrafficManager) CanIntercept(c context.Context, ir *rpc.CreateIntercep
 	return svcProps, svcProps.interceptResult()
 }
 
+// checkInterceptAccess verifies that the current user has the permissions needed to intercept the workload of
+// the given spec, so that missing permissions are reported together and up front instead of as a 403 halfway
+// through the intercept. A legacy traffic-manager requires that the client modifies the workload.
+func checkInterceptAccess(c context.Context, spec *manager.InterceptSpec, legacy bool) error {
+	group, resource := "apps", "deployments"
+	switch spec.WorkloadKind {
+	case "", "Deployment":
+	case "ReplicaSet":
+		resource = "replicasets"
+	case "StatefulSet":
+		resource = "statefulsets"
+	case "Job":
+		group, resource = "batch", "jobs"
+	case "CronJob":
+		group, resource = "batch", "cronjobs"
+	default:
+		// A custom workload kind, such as an Argo Rollout, is only accessed by the traffic-manager
+		if !legacy {
+			return nil
+		}
+	}
+	accesses := k8sapi.Accesses(group, resource, spec.Namespace, "get")
+	if legacy {
+		accesses = append(accesses, k8sapi.Accesses(group, resource, spec.Namespace, "update")...)
+		accesses = append(accesses, k8sapi.Accesses("", "services", spec.Namespace, "list", "update")...)
+		accesses = append(accesses, k8sapi.Accesses("", "pods", spec.Namespace, "list")...)
+	}
+	if err := k8sapi.CheckAccess(c, accesses); err != nil {
+		return errcat.User.Newf("insufficient permissions to intercept %s.%s:\n%v\n"+
+			"Use \"telepresence rbac --client --for intercept\" to generate the required roles", spec.Agent, spec.Namespace, err)
+	}
+	return nil
+}
+
 // legacyImage ensures that the installer never modifies a workload to
 // install a version that is more recent than the traffic-manager currently
 // in use (it's legacy too, or we wouldn't end up here)
@@ -545,7 +731,11 @@ func (tm *TrafficManager) AddIntercept(c context.Context, ir *rpc.CreateIntercep
 
 	spec := ir.Spec
 	if svcProps == nil {
-		return tm.AddLocalOnlyIntercept(c, spec)
+		_, updated := tm.localIntercepts[spec.Name]
+		if result, err = tm.AddLocalOnlyIntercept(c, spec); result != nil {
+			result.Updated = updated
+		}
+		return result, err
 	}
 
 	spec.Client = tm.userAndHost
@@ -580,16 +770,43 @@ func (tm *TrafficManager) AddIntercept(c context.Context, ir *rpc.CreateIntercep
 		// Make spec port identifier unambiguous.
 		spec.ServiceName = svcProps.preparedIntercept.ServiceName
 		spec.ServicePortIdentifier = svcProps.preparedIntercept.ServicePortName
+		if spec.ServicePortIdentifier == "" {
+			spec.ServicePortIdentifier = strconv.Itoa(int(svcProps.preparedIntercept.ServicePort))
+		}
 	}
 
 	spec.ServiceUid = result.ServiceUid
 	spec.WorkloadKind = result.WorkloadKind
 
+	// The traffic-manager cannot change the spec of an existing intercept, so an intercept that this
+	// client already owns is updated by replacing it. The old intercept is restored if the new one fails.
+	if owned := tm.ownedIntercept(spec); owned != nil {
+		var restore *rpc.CreateInterceptRequest
+		restore, err = tm.replaceIntercept(c, owned, ir.AgentImage)
+		if restore != nil {
+			defer func() {
+				if replacementFailed(result, err) {
+					tm.restoreIntercept(c, restore)
+				}
+			}()
+		}
+		if err != nil {
+			return nil, err
+		}
+		result.Updated = true
+	}
+
 	deleteMount := false
 	if ir.MountPoint != "" {
 		// Ensure that the mount-point is free to use
-		if prev, loaded := tm.mountPoints.LoadOrStore(ir.MountPoint, spec.Name); loaded {
-			return interceptError(rpc.InterceptError_MOUNT_POINT_BUSY, errcat.User.Newf(prev.(string))), nil
+		im := &interceptMount{
+			name:     spec.Name,
+			readOnly: ir.MountReadOnly,
+			include:  ir.MountInclude,
+			exclude:  ir.MountExclude,
+		}
+		if prev, loaded := tm.mountPoints.LoadOrStore(ir.MountPoint, im); loaded {
+			return interceptError(rpc.InterceptError_MOUNT_POINT_BUSY, errcat.User.Newf(prev.(*interceptMount).name)), nil
 		}
 
 		// Assume that the mount-point should to be removed from the busy map. Only a happy path
@@ -626,6 +843,10 @@ func (tm *TrafficManager) AddIntercept(c context.Context, ir *rpc.CreateIntercep
 	})
 	if err != nil {
 		dlog.Debugf(c, "manager responded to CreateIntercept with error %v", err)
+		if st := grpcStatus.Convert(err); st.Code() == grpcCodes.ResourceExhausted || st.Code() == grpcCodes.PermissionDenied {
+			// The traffic-manager's limits on the number of intercepts are exceeded, or the client may not intercept
+			return interceptError(rpc.InterceptError_TRAFFIC_MANAGER_ERROR, errcat.User.New(st.Message())), nil
+		}
 		err = client.CheckTimeout(c, err)
 		code := grpcCodes.Internal
 		if errors.Is(err, context.DeadlineExceeded) {
@@ -654,7 +875,9 @@ func (tm *TrafficManager) AddIntercept(c context.Context, ir *rpc.CreateIntercep
 	}()
 
 	// Wait for the intercept to transition from WAITING or NO_AGENT to ACTIVE. This
-	// might result in more than one event.
+	// might result in more than one event. The intercept of a Job or CronJob is established
+	// without waiting, because there might not be any pods until the next time that it runs,
+	// and so is an intercept that is created without waiting for the rollout.
 	for {
 		select {
 		case <-c.Done():
@@ -670,16 +893,19 @@ func (tm *TrafficManager) AddIntercept(c context.Context, ir *rpc.CreateIntercep
 				return interceptError(rpc.InterceptError_FAILED_TO_ESTABLISH, errcat.User.New(wr.err)), nil
 			}
 			ii = wr.intercept
-			if ii.Disposition != manager.InterceptDispositionType_ACTIVE {
+			pending := k8sapi.IsBatchKind(ii.Spec.WorkloadKind) || ii.Spec.NoRolloutWait
+			if ii.Disposition != manager.InterceptDispositionType_ACTIVE && !pending {
 				continue
 			}
 			// Older traffic-managers pass env in the agent info
 			if agentEnv != nil {
 				ii.Environment = agentEnv
 			}
+			result.Warnings = resolveRedactedEnvironment(c, ii)
 			result.InterceptInfo = ii
 			mountPoint := tm.mountPointForIntercept(ii.Spec.Name)
-			if mountPoint != "" && ii.SftpPort > 0 {
+			if mountPoint != "" && (ii.SftpPort > 0 || pending) {
+				// The mount of a pending intercept is made when a pod with an agent arrives
 				deleteMount = false // Mount-point is busy until intercept ends
 				ii.ClientMountPoint = mountPoint
 			}
@@ -696,12 +922,20 @@ func (tm *TrafficManager) shouldForward(ii *manager.InterceptInfo) bool {
 
 // startForwards starts port forwards and mounts for the given forwardKey.
 // It assumes that the user has called shouldForward and is sure that something will be started.
-func (tm *TrafficManager) startForwards(ctx context.Context, wg *sync.WaitGroup, fk forwardKey, sftpPort int32, remoteMountPoint string, extraPorts []int32) {
+func (tm *TrafficManager) startForwards(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	fk forwardKey,
+	sftpPort int32,
+	remoteMountPoint string,
+	volumeMounts []string,
+	extraPorts []int32,
+) {
 	if sftpPort > 0 {
 		// There's nothing to mount if the SftpPort is zero
 		mntCtx := dgroup.WithGoroutineName(ctx, fmt.Sprintf("/%s:%d", fk.PodIP, sftpPort))
 		wg.Add(1)
-		go tm.workerMountForwardIntercept(mntCtx, mountForward{fk, sftpPort, remoteMountPoint}, wg)
+		go tm.workerMountForwardIntercept(mntCtx, mountForward{fk, sftpPort, remoteMountPoint, volumeMounts}, wg)
 	}
 	for _, port := range extraPorts {
 		pfCtx := dgroup.WithGoroutineName(ctx, fmt.Sprintf("/%s:%d", fk.PodIP, port))
@@ -729,9 +963,15 @@ func (tm *TrafficManager) workerPortForwardIntercept(ctx context.Context, pf por
 }
 
 func (tm *TrafficManager) mountPointForIntercept(name string) (mountPoint string) {
+	mountPoint, _ = tm.mountForIntercept(name)
+	return
+}
+
+func (tm *TrafficManager) mountForIntercept(name string) (mountPoint string, im *interceptMount) {
 	tm.mountPoints.Range(func(key, value any) bool {
-		if name == value.(string) {
+		if vm := value.(*interceptMount); name == vm.name {
 			mountPoint = key.(string)
+			im = vm
 			return false
 		}
 		return true
@@ -739,10 +979,18 @@ func (tm *TrafficManager) mountPointForIntercept(name string) (mountPoint string
 	return
 }
 
+// volumeMounts returns the mount paths of the intercepted container's volumes.
+func volumeMounts(ii *manager.InterceptInfo) []string {
+	if ms := ii.Environment[agentconfig.EnvInterceptMounts]; ms != "" {
+		return strings.Split(ms, ":")
+	}
+	return nil
+}
+
 func (tm *TrafficManager) workerMountForwardIntercept(ctx context.Context, mf mountForward, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	mountPoint := tm.mountPointForIntercept(mf.Name)
+	mountPoint, im := tm.mountForIntercept(mf.Name)
 	if mountPoint == "" {
 		// User has explicitly specified that no mount should take place
 		dlog.Infof(ctx, "No mount point found for intercept %q", mf.Name)
@@ -769,7 +1017,37 @@ func (tm *TrafficManager) workerMountForwardIntercept(ctx context.Context, mf mo
 		mountMutex.Unlock()
 	}()
 
-	// Retry mount in case it gets disconnected
+	if !im.isSubset() {
+		sshfsMount(ctx, mf, mf.RemoteMountPoint, mountPoint, im.readOnly)
+		return
+	}
+
+	// Mount each path separately, so that the rest of the container's file system isn't exposed
+	paths := im.mountPaths(mf.VolumeMounts)
+	if len(paths) == 0 {
+		dlog.Infof(ctx, "No paths to mount for intercept %q", mf.Name)
+		return
+	}
+	im.addMountedPaths(paths)
+	var pathWg sync.WaitGroup
+	for _, p := range paths {
+		local := filepath.Join(mountPoint, filepath.FromSlash(p))
+		if err := os.MkdirAll(local, 0o700); err != nil {
+			dlog.Errorf(ctx, "unable to create mount point %q: %v", local, err)
+			continue
+		}
+		pathWg.Add(1)
+		go func(remote, local string) {
+			defer pathWg.Done()
+			sshfsMount(ctx, mf, remote, local, im.readOnly)
+		}(mf.RemoteMountPoint+p, local)
+	}
+	pathWg.Wait()
+}
+
+// sshfsMount mounts the given remote directory of the agent's sftp server at the given local directory, and
+// remounts it when it gets disconnected, until the context is cancelled.
+func sshfsMount(ctx context.Context, mf mountForward, remote, local string, readOnly bool) {
 	err := client.Retry(ctx, "sshfs", func(ctx context.Context) error {
 		dl := &net.Dialer{Timeout: 3 * time.Second}
 		conn, err := dl.DialContext(ctx, "tcp", fmt.Sprintf("%s:%d", mf.PodIP, mf.SftpPort))
@@ -791,9 +1069,19 @@ func (tm *TrafficManager) workerMountForwardIntercept(ctx context.Context, mf mo
 			// mount directives
 			"-o", "follow_symlinks",
 			"-o", "allow_root", // needed to make --docker-run work as docker runs as root
-			"localhost:" + mf.RemoteMountPoint, // what to mount
-			mountPoint,                         // where to mount it
+
+			// Secrets and projected service account tokens are rotated by the kubelet by swapping symlinks, and
+			// a cached size or a cached link target will then yield truncated or missing files.
+			"-o", "cache_stat_timeout=1",
+			"-o", "cache_link_timeout=1",
 		}
+		if readOnly {
+			sshfsArgs = append(sshfsArgs, "-o", "ro")
+		}
+		sshfsArgs = append(sshfsArgs,
+			"localhost:"+remote, // what to mount
+			local,               // where to mount it
+		)
 		exe := "sshfs"
 		if runtime.GOOS == "windows" {
 			// Use sshfs-win to launch the sshfs
@@ -806,7 +1094,7 @@ func (tm *TrafficManager) workerMountForwardIntercept(ctx context.Context, mf mo
 		// sshfs sometimes leave the mount point in a bad state. This will clean it up
 		ctx, cancel := context.WithTimeout(dcontext.WithoutCancel(ctx), time.Second)
 		defer cancel()
-		_ = proc.CommandContext(ctx, "fusermount", "-uz", mountPoint).Run()
+		_ = proc.CommandContext(ctx, "fusermount", "-uz", local).Run()
 		return err
 	}, 3*time.Second, 6*time.Second)
 
@@ -839,6 +1127,56 @@ func (tm *TrafficManager) RemoveIntercept(c context.Context, name string) error
 }
 
 func (tm *TrafficManager) removeIntercept(c context.Context, ii *manager.InterceptInfo) error {
+	tm.stopInterceptor(c, ii)
+	dlog.Debugf(c, "telling manager to remove intercept %s", ii.Spec.Name)
+	_, err := tm.managerClient.RemoveIntercept(c, &manager.RemoveInterceptRequest2{
+		Session: tm.session(),
+		Name:    ii.Spec.Name,
+	})
+	return err
+}
+
+// HandoffIntercept offers the intercept with the given name to the given recipient. The process that was
+// associated with the intercept using AddInterceptor is terminated once the recipient has accepted the intercept.
+// When accept is true, the intercept with the given name that the recipient has offered to this client is
+// accepted instead, and the recipient may be empty.
+func (tm *TrafficManager) HandoffIntercept(c context.Context, name, recipient string, accept bool) (*manager.InterceptInfo, error) {
+	if accept {
+		dlog.Debugf(c, "telling manager to accept intercept %s", name)
+		return tm.managerClient.HandoffIntercept(c, &manager.HandoffInterceptRequest{
+			Session:   tm.session(),
+			Name:      name,
+			Recipient: recipient,
+			Accept:    true,
+		})
+	}
+	var ii *manager.InterceptInfo
+	for _, cept := range tm.getCurrentIntercepts() {
+		if cept.Spec.Name == name {
+			ii = cept
+			break
+		}
+	}
+	if ii == nil {
+		return nil, grpcStatus.Errorf(grpcCodes.NotFound, "Intercept named %q not found", name)
+	}
+	dlog.Debugf(c, "telling manager to offer intercept %s to %s", name, recipient)
+	offered, err := tm.managerClient.HandoffIntercept(c, &manager.HandoffInterceptRequest{
+		Session:   tm.session(),
+		Name:      name,
+		Recipient: recipient,
+	})
+	if err != nil {
+		return nil, err
+	}
+	tm.currentInterceptsLock.Lock()
+	tm.offeredIntercepts[ii.Id] = ii
+	tm.currentInterceptsLock.Unlock()
+	return offered, nil
+}
+
+// stopInterceptor terminates the process that was associated with the given intercept using AddInterceptor.
+func (tm *TrafficManager) stopInterceptor(c context.Context, ii *manager.InterceptInfo) {
 	tm.currentInterceptsLock.Lock()
 	pid, ok := tm.currentInterceptors[ii.Id]
 	if ok {
@@ -859,13 +1197,6 @@ func (tm *TrafficManager) removeIntercept(c context.Context, ii *manager.Interce
 			}
 		}
 	}
-
-	dlog.Debugf(c, "telling manager to remove intercept %s", name)
-	_, err := tm.managerClient.RemoveIntercept(c, &manager.RemoveInterceptRequest2{
-		Session: tm.session(),
-		Name:    name,
-	})
-	return err
 }
 
 // AddInterceptor associates the given interceptId with a pid of a running process. This ensures that
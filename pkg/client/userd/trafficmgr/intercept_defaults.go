@@ -0,0 +1,55 @@
+package trafficmgr
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	errors2 "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+// GetInterceptDefaults returns the intercept defaults declared by the annotations of the given workload. Empty
+// defaults are returned when the workload cannot be found or read, because CanIntercept will report that problem
+// in a more meaningful way.
+func (tm *TrafficManager) GetInterceptDefaults(ctx context.Context, dr *rpc.GetInterceptDefaultsRequest) (*rpc.InterceptDefaults, error) {
+	namespace := tm.ActualNamespace(dr.Namespace)
+	if namespace == "" {
+		return &rpc.InterceptDefaults{}, nil
+	}
+	wl, err := k8sapi.GetWorkload(ctx, dr.Name, namespace, "")
+	if err != nil {
+		if !errors2.IsNotFound(err) {
+			dlog.Debugf(ctx, "unable to get intercept defaults of %s.%s: %v", dr.Name, namespace, err)
+		}
+		return &rpc.InterceptDefaults{}, nil
+	}
+	return interceptDefaults(wl)
+}
+
+func interceptDefaults(wl k8sapi.Workload) (*rpc.InterceptDefaults, error) {
+	an := wl.GetAnnotations()
+	d := &rpc.InterceptDefaults{
+		Port:           strings.TrimSpace(an[install.InterceptPortAnnotation]),
+		RequiredHeader: strings.TrimSpace(an[install.InterceptHeaderAnnotation]),
+	}
+	for _, e := range strings.Split(an[install.InterceptEnvExcludeAnnotation], ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			d.EnvExclude = append(d.EnvExclude, e)
+		}
+	}
+	switch mount := strings.TrimSpace(an[install.InterceptMountAnnotation]); mount {
+	case "", "true", "false", "read-only":
+		d.Mount = mount
+	default:
+		return nil, status.Errorf(codes.InvalidArgument,
+			`the %s annotation of %s %s.%s has the invalid value %q. It must be "true", "false", or "read-only"`,
+			install.InterceptMountAnnotation, strings.ToLower(wl.GetKind()), wl.GetName(), wl.GetNamespace(), mount)
+	}
+	return d, nil
+}
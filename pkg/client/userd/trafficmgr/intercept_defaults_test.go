@@ -0,0 +1,48 @@
+package trafficmgr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	apps "k8s.io/api/apps/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+func Test_interceptDefaults(t *testing.T) {
+	deployment := func(annotations map[string]string) k8sapi.Workload {
+		return k8sapi.Deployment(&apps.Deployment{
+			TypeMeta:   meta.TypeMeta{Kind: "Deployment"},
+			ObjectMeta: meta.ObjectMeta{Name: "echo", Namespace: "default", Annotations: annotations},
+		})
+	}
+
+	d, err := interceptDefaults(deployment(nil))
+	require.NoError(t, err)
+	assert.Empty(t, d.Port)
+	assert.Empty(t, d.RequiredHeader)
+	assert.Empty(t, d.EnvExclude)
+	assert.Empty(t, d.Mount)
+
+	d, err = interceptDefaults(deployment(map[string]string{
+		install.InterceptPortAnnotation:       "8080:http",
+		install.InterceptHeaderAnnotation:     " x-dev-user ",
+		install.InterceptEnvExcludeAnnotation: "DATABASE_URL, API_TOKEN,,",
+		install.InterceptMountAnnotation:      "read-only",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, "8080:http", d.Port)
+	assert.Equal(t, "x-dev-user", d.RequiredHeader)
+	assert.Equal(t, []string{"DATABASE_URL", "API_TOKEN"}, d.EnvExclude)
+	assert.Equal(t, "read-only", d.Mount)
+
+	_, err = interceptDefaults(deployment(map[string]string{install.InterceptMountAnnotation: "ro"}))
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Contains(t, err.Error(), "deployment echo.default")
+}
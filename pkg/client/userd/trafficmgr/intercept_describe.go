@@ -0,0 +1,114 @@
+package trafficmgr
+
+import (
+	"context"
+	"sort"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	typed "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+// DescribeIntercept returns the disposition history of the named intercept, and the traffic-agent injection
+// status and the events of the pods of its workload.
+func (tm *TrafficManager) DescribeIntercept(ctx context.Context, dr *rpc.DescribeInterceptRequest) (*rpc.InterceptDescription, error) {
+	d := &rpc.InterceptDescription{}
+	diag, err := tm.managerClient.GetInterceptDiagnostics(ctx, &manager.GetInterceptRequest{Session: tm.session(), Name: dr.Name})
+	switch status.Code(err) {
+	case codes.OK:
+		d.Intercept = diag.Intercept
+		d.History = diag.History
+	case codes.Unimplemented:
+		// The traffic-manager is too old to keep a history, so only the current disposition is known.
+		for _, ii := range tm.getCurrentIntercepts() {
+			if ii.Spec.Name == dr.Name {
+				d.Intercept = ii
+				break
+			}
+		}
+		if d.Intercept == nil {
+			return nil, status.Errorf(codes.NotFound, "intercept %s not found", dr.Name)
+		}
+		d.HistoryUnavailable = true
+	default:
+		return nil, err
+	}
+
+	spec := d.Intercept.Spec
+	_, pods, err := workloadPods(ctx, spec.Agent, spec.Namespace, spec.WorkloadKind)
+	if err != nil {
+		return nil, err
+	}
+	events := k8sapi.GetK8sInterface(ctx).CoreV1().Events(spec.Namespace)
+	for i := range pods {
+		pod := &pods[i]
+		ip := describePod(pod)
+		if d.EventsError == "" {
+			if ip.Events, err = podEvents(ctx, events, pod.Name); err != nil {
+				d.EventsError = err.Error()
+			}
+		}
+		d.Pods = append(d.Pods, ip)
+	}
+	return d, nil
+}
+
+func describePod(pod *core.Pod) *rpc.InterceptedPod {
+	ip := &rpc.InterceptedPod{
+		Name:             pod.Name,
+		Phase:            string(pod.Status.Phase),
+		InjectAnnotation: pod.Annotations[agentconfig.InjectAnnotation],
+	}
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name == agentconfig.ContainerName {
+			ip.AgentInjected = true
+			break
+		}
+	}
+	if !ip.AgentInjected {
+		ip.AgentInjected = agentconfig.HasEphemeralAgent(pod) || agentconfig.HasNodeAgent(pod)
+	}
+	ip.Ready, ip.AgentStatus = podAgentReady(pod)
+	return ip
+}
+
+// podEvents returns the events of the named pod, oldest first.
+func podEvents(ctx context.Context, events typed.EventInterface, podName string) ([]*rpc.PodEvent, error) {
+	sel := fields.Set{"involvedObject.kind": "Pod", "involvedObject.name": podName}.AsSelector()
+	el, err := events.List(ctx, meta.ListOptions{FieldSelector: sel.String()})
+	if err != nil {
+		return nil, err
+	}
+	pes := make([]*rpc.PodEvent, len(el.Items))
+	for i := range el.Items {
+		ev := &el.Items[i]
+		t := ev.LastTimestamp.Time
+		switch {
+		case !t.IsZero():
+		case !ev.EventTime.IsZero():
+			t = ev.EventTime.Time
+		default:
+			t = ev.FirstTimestamp.Time
+		}
+		pes[i] = &rpc.PodEvent{
+			Time:    timestamppb.New(t),
+			Type:    ev.Type,
+			Reason:  ev.Reason,
+			Message: ev.Message,
+			Count:   ev.Count,
+		}
+	}
+	sort.SliceStable(pes, func(i, j int) bool {
+		return pes[i].Time.AsTime().Before(pes[j].Time.AsTime())
+	})
+	return pes, nil
+}
@@ -1,11 +1,25 @@
 package trafficmgr
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/blang/semver"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
 )
 
 func Test_makeFlagsCompatible(t *testing.T) {
@@ -101,3 +115,73 @@ func Test_makeFlagsCompatible(t *testing.T) {
 		})
 	}
 }
+
+func TestTrafficManager_replaceIntercept(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cfg := client.GetDefaultConfig()
+	ctx = client.WithConfig(ctx, &cfg)
+	tm := &TrafficManager{managerClient: &removingManagerClient{}}
+	ii := &manager.InterceptInfo{Spec: &manager.InterceptSpec{Name: "echo", Agent: "echo", Namespace: "default", TargetPort: 8080}}
+	tm.mountPoints.Store("/tmp/echo", &interceptMount{name: "echo", readOnly: true, include: []string{"/var/data"}})
+	tm.currentIntercepts = []*manager.InterceptInfo{ii}
+
+	// The intercept isn't gone until its mount point is released
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		tm.currentInterceptsLock.Lock()
+		tm.currentIntercepts = nil
+		tm.currentInterceptsLock.Unlock()
+		tm.mountPoints.Delete("/tmp/echo")
+	}()
+	restore, err := tm.replaceIntercept(ctx, ii, "tel2:2.7.0")
+	require.NoError(t, err)
+	assert.Nil(t, tm.GetInterceptSpec("echo"))
+
+	// The request that restores the intercept has its spec and its mount
+	require.NotNil(t, restore)
+	assert.True(t, proto.Equal(ii.Spec, restore.Spec))
+	assert.NotSame(t, ii.Spec, restore.Spec)
+	assert.Equal(t, "tel2:2.7.0", restore.AgentImage)
+	assert.Equal(t, "/tmp/echo", restore.MountPoint)
+	assert.True(t, restore.MountReadOnly)
+	assert.Equal(t, []string{"/var/data"}, restore.MountInclude)
+}
+
+func TestTrafficManager_replaceIntercept_timeout(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cfg := client.GetDefaultConfig()
+	ctx = client.WithConfig(ctx, &cfg)
+	tm := &TrafficManager{managerClient: &removingManagerClient{}}
+	ii := &manager.InterceptInfo{Spec: &manager.InterceptSpec{Name: "echo", Agent: "echo", Namespace: "default"}}
+	tm.currentIntercepts = []*manager.InterceptInfo{ii}
+
+	// The intercept may be gone even if the wait fails, so a request that restores it is returned
+	ctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	restore, err := tm.replaceIntercept(ctx, ii, "")
+	assert.Error(t, err)
+	assert.NotNil(t, restore)
+
+	// Nothing is removed when the manager refuses to remove the intercept
+	tm.managerClient = &removingManagerClient{err: status.Error(codes.Unavailable, "unavailable")}
+	restore, err = tm.replaceIntercept(context.Background(), ii, "")
+	assert.Error(t, err)
+	assert.Nil(t, restore)
+}
+
+func Test_replacementFailed(t *testing.T) {
+	assert.True(t, replacementFailed(nil, errors.New("boom")))
+	assert.True(t, replacementFailed(nil, nil))
+	assert.True(t, replacementFailed(&rpc.InterceptResult{Error: rpc.InterceptError_FAILED_TO_ESTABLISH}, nil))
+	assert.False(t, replacementFailed(&rpc.InterceptResult{}, nil))
+}
+
+// removingManagerClient is a ManagerClient that only implements RemoveIntercept.
+type removingManagerClient struct {
+	manager.ManagerClient
+	err error
+}
+
+func (m *removingManagerClient) RemoveIntercept(context.Context, *manager.RemoveInterceptRequest2, ...grpc.CallOption) (*empty.Empty, error) {
+	return &empty.Empty{}, m.err
+}
@@ -0,0 +1,248 @@
+package trafficmgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/datawire/dlib/dtime"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+// waitPollInterval is the interval at which the progress of an intercept or a rollout is checked.
+var waitPollInterval = time.Second
+
+type InterceptWaitStream interface {
+	Send(*rpc.InterceptWaitProgress) error
+}
+
+// WaitForIntercept waits until the named intercept is ACTIVE and all pods of its workload have a traffic-agent
+// and are ready. Traffic to the workload's service may still reach pods without an agent until then. The progress
+// is sent to the stream each time it changes, so that the reasons for a long wait are visible.
+func (tm *TrafficManager) WaitForIntercept(ctx context.Context, wr *rpc.WaitForInterceptRequest, stream InterceptWaitStream) error {
+	var cancel context.CancelFunc
+	timeout := wr.Timeout.AsDuration()
+	if timeout <= 0 {
+		timeout = client.GetConfig(ctx).Timeouts.Get(client.TimeoutAgentInstall)
+	}
+	ctx, cancel = context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var last *rpc.InterceptWaitProgress
+	for {
+		p, err := tm.interceptWaitProgress(ctx, wr.Name)
+		if err != nil {
+			return err
+		}
+		if !proto.Equal(p, last) {
+			if err = stream.Send(p); err != nil {
+				return err
+			}
+			last = p
+		}
+		if p.Phase == rpc.InterceptWaitProgress_READY || p.Phase == rpc.InterceptWaitProgress_FAILED {
+			return nil
+		}
+		dtime.SleepWithContext(ctx, waitPollInterval)
+		if ctx.Err() != nil {
+			err = errcat.User.Newf("timed out after %s waiting for intercept %s: %s", timeout, wr.Name, p.Message)
+			return stream.Send(&rpc.InterceptWaitProgress{
+				Phase:         rpc.InterceptWaitProgress_FAILED,
+				Disposition:   p.Disposition,
+				Message:       p.Message,
+				ReadyPods:     p.ReadyPods,
+				TotalPods:     p.TotalPods,
+				ErrorText:     err.Error(),
+				ErrorCategory: int32(errcat.GetCategory(err)),
+			})
+		}
+	}
+}
+
+// interceptWaitProgress returns the current progress of the named intercept.
+func (tm *TrafficManager) interceptWaitProgress(ctx context.Context, name string) (*rpc.InterceptWaitProgress, error) {
+	var ii *manager.InterceptInfo
+	for _, ic := range tm.getCurrentIntercepts() {
+		if ic.Spec.Name == name {
+			ii = ic
+			break
+		}
+	}
+	if ii == nil {
+		return nil, errcat.User.Newf("intercept %s not found", name)
+	}
+	p := &rpc.InterceptWaitProgress{Disposition: ii.Disposition, Message: ii.Message}
+	switch ii.Disposition {
+	case manager.InterceptDispositionType_ACTIVE:
+	case manager.InterceptDispositionType_WAITING, manager.InterceptDispositionType_NO_AGENT:
+		if k8sapi.IsBatchKind(ii.Spec.WorkloadKind) {
+			// The intercept of a Job or CronJob is pending while it has no pods, so only its pods are checked.
+			break
+		}
+		if ii.Disposition == manager.InterceptDispositionType_WAITING {
+			p.Phase = rpc.InterceptWaitProgress_WAITING
+			if p.Message == "" {
+				p.Message = "waiting for the traffic-agent to arrive"
+			}
+			return p, nil
+		}
+		fallthrough
+	default:
+		err := errcat.User.Newf("intercept in error state %v: %v", ii.Disposition, ii.Message)
+		p.Phase = rpc.InterceptWaitProgress_FAILED
+		p.ErrorText = err.Error()
+		p.ErrorCategory = int32(errcat.GetCategory(err))
+		return p, nil
+	}
+
+	if err := rolloutProgress(ctx, ii.Spec.Agent, ii.Spec.Namespace, ii.Spec.WorkloadKind, p); err != nil {
+		var uwkErr k8sapi.UnsupportedWorkloadKindError
+		if errors.As(err, &uwkErr) && ii.Disposition == manager.InterceptDispositionType_ACTIVE {
+			// A custom workload kind that only the traffic-manager knows about, so the ACTIVE intercept is trusted
+			p.Phase = rpc.InterceptWaitProgress_READY
+			return p, nil
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+// WatchRollout sends the progress of the rollout of the given workload to the stream each time it changes, until
+// all pods of the workload have a traffic-agent and are ready. A workload of a custom kind that only the
+// traffic-manager knows about has no progress that the client can see.
+func (tm *TrafficManager) WatchRollout(ctx context.Context, wr *rpc.WatchRolloutRequest, stream InterceptWaitStream) error {
+	ns := tm.ActualNamespace(wr.Namespace)
+	var last *rpc.InterceptWaitProgress
+	for {
+		p := &rpc.InterceptWaitProgress{}
+		if err := rolloutProgress(ctx, wr.WorkloadName, ns, wr.WorkloadKind, p); err != nil {
+			var uwkErr k8sapi.UnsupportedWorkloadKindError
+			if errors.As(err, &uwkErr) || ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if !proto.Equal(p, last) {
+			if err := stream.Send(p); err != nil {
+				return err
+			}
+			last = p
+		}
+		if p.Phase == rpc.InterceptWaitProgress_READY {
+			return nil
+		}
+		dtime.SleepWithContext(ctx, waitPollInterval)
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// rolloutProgress sets the phase, the number of pods, and the message of the given progress according to the pods
+// of the given workload.
+func rolloutProgress(ctx context.Context, name, namespace, kind string, p *rpc.InterceptWaitProgress) error {
+	wl, pods, err := workloadPods(ctx, name, namespace, kind)
+	if err != nil {
+		return err
+	}
+	var reason string
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Status.Phase == core.PodSucceeded || pod.Status.Phase == core.PodFailed {
+			// Pods that have run to completion, like the pods of a Job, will not get an agent
+			continue
+		}
+		p.TotalPods++
+		ready, why := podAgentReady(pod)
+		if ready {
+			p.ReadyPods++
+		} else if reason == "" {
+			reason = fmt.Sprintf("pod %s: %s", pod.Name, why)
+		}
+	}
+	if p.ReadyPods == p.TotalPods && int(p.TotalPods) >= wl.Replicas() {
+		p.Phase = rpc.InterceptWaitProgress_READY
+		p.Message = ""
+		return nil
+	}
+	p.Phase = rpc.InterceptWaitProgress_ROLLING_OUT
+	if reason == "" {
+		reason = fmt.Sprintf("%d of %d replicas are running", p.TotalPods, wl.Replicas())
+	}
+	p.Message = reason
+	return nil
+}
+
+// workloadPods returns the given workload and the pods that it selects.
+func workloadPods(ctx context.Context, name, namespace, kind string) (k8sapi.Workload, []core.Pod, error) {
+	wl, err := k8sapi.GetWorkload(ctx, name, namespace, kind)
+	if err != nil {
+		return nil, nil, err
+	}
+	sel, err := wl.Selector()
+	if err != nil {
+		return nil, nil, err
+	}
+	pods, err := k8sapi.GetK8sInterface(ctx).CoreV1().Pods(namespace).List(ctx, meta.ListOptions{LabelSelector: sel.String()})
+	if err != nil {
+		return nil, nil, err
+	}
+	return wl, pods.Items, nil
+}
+
+// podAgentReady returns true if the given pod has a traffic-agent and is ready. The reason why it isn't ready is
+// returned otherwise.
+func podAgentReady(pod *core.Pod) (bool, string) {
+	if pod.DeletionTimestamp != nil {
+		return false, "terminating"
+	}
+	hasAgent := false
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name == agentconfig.ContainerName {
+			hasAgent = true
+			break
+		}
+	}
+	switch {
+	case hasAgent:
+	case agentconfig.HasEphemeralAgent(pod):
+		// An ephemeral agent isn't part of the readiness of the pod.
+		if cs := agentconfig.EphemeralAgentStatus(pod); cs == nil || cs.State.Running == nil {
+			return false, "ephemeral traffic-agent is not running"
+		}
+		if _, ok := pod.Annotations[agentconfig.EphemeralRedirectAnnotation]; !ok {
+			return false, "ports are not redirected to the ephemeral traffic-agent"
+		}
+	case agentconfig.HasNodeAgent(pod):
+		if !agentconfig.NodeAgentRedirected(pod) {
+			return false, "the traffic-node-agent has not started the traffic-agent"
+		}
+	default:
+		return false, "no traffic-agent"
+	}
+	for i := range pod.Status.ContainerStatuses {
+		cs := &pod.Status.ContainerStatuses[i]
+		if w := cs.State.Waiting; w != nil && w.Reason != "" {
+			return false, fmt.Sprintf("container %s is waiting: %s", cs.Name, w.Reason)
+		}
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == core.PodReady {
+			if c.Status == core.ConditionTrue {
+				return true, ""
+			}
+			break
+		}
+	}
+	return false, "not ready"
+}
@@ -0,0 +1,258 @@
+package trafficmgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/durationpb"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+var echoLabels = map[string]string{"app": "echo"}
+
+func echoDeployment() *apps.Deployment {
+	replicas := int32(2)
+	return &apps.Deployment{
+		ObjectMeta: meta.ObjectMeta{Name: "echo", Namespace: "default"},
+		Spec: apps.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &meta.LabelSelector{MatchLabels: echoLabels},
+		},
+	}
+}
+
+// echoPod returns a ready pod of the echo deployment, with a traffic-agent sidecar if agent is true.
+func echoPod(name string, agent bool) *core.Pod {
+	p := &core.Pod{
+		ObjectMeta: meta.ObjectMeta{Name: name, Namespace: "default", Labels: echoLabels},
+		Spec:       core.PodSpec{Containers: []core.Container{{Name: "echo"}}},
+		Status: core.PodStatus{
+			Phase:      core.PodRunning,
+			Conditions: []core.PodCondition{{Type: core.PodReady, Status: core.ConditionTrue}},
+		},
+	}
+	if agent {
+		p.Spec.Containers = append(p.Spec.Containers, core.Container{Name: agentconfig.ContainerName})
+	}
+	return p
+}
+
+func TestRolloutProgress(t *testing.T) {
+	dep := echoDeployment()
+	ctx := k8sapi.WithK8sInterface(context.Background(), fake.NewSimpleClientset(dep, echoPod("echo-1", true), echoPod("echo-2", false)))
+	p := &rpc.InterceptWaitProgress{}
+	require.NoError(t, rolloutProgress(ctx, "echo", "default", "Deployment", p))
+	assert.Equal(t, rpc.InterceptWaitProgress_ROLLING_OUT, p.Phase)
+	assert.Equal(t, int32(1), p.ReadyPods)
+	assert.Equal(t, int32(2), p.TotalPods)
+	assert.Equal(t, "pod echo-2: no traffic-agent", p.Message)
+
+	ctx = k8sapi.WithK8sInterface(context.Background(), fake.NewSimpleClientset(dep, echoPod("echo-1", true), echoPod("echo-3", true)))
+	p = &rpc.InterceptWaitProgress{}
+	require.NoError(t, rolloutProgress(ctx, "echo", "default", "", p))
+	assert.Equal(t, rpc.InterceptWaitProgress_READY, p.Phase)
+	assert.Equal(t, int32(2), p.ReadyPods)
+}
+
+func TestPodAgentReady_ephemeral(t *testing.T) {
+	pod := &core.Pod{
+		ObjectMeta: meta.ObjectMeta{Name: "echo-1", Namespace: "default"},
+		Spec: core.PodSpec{
+			Containers:          []core.Container{{Name: "echo"}},
+			EphemeralContainers: []core.EphemeralContainer{{EphemeralContainerCommon: core.EphemeralContainerCommon{Name: agentconfig.ContainerName}}},
+		},
+		Status: core.PodStatus{
+			Phase:      core.PodRunning,
+			Conditions: []core.PodCondition{{Type: core.PodReady, Status: core.ConditionTrue}},
+		},
+	}
+	_, reason := podAgentReady(pod)
+	assert.Equal(t, "ephemeral traffic-agent is not running", reason)
+
+	pod.Status.EphemeralContainerStatuses = []core.ContainerStatus{{
+		Name:        agentconfig.ContainerName,
+		ContainerID: "containerd://3f4e1c2b9a",
+		State:       core.ContainerState{Running: &core.ContainerStateRunning{}},
+	}}
+	_, reason = podAgentReady(pod)
+	assert.Equal(t, "ports are not redirected to the ephemeral traffic-agent", reason)
+
+	pod.Annotations = map[string]string{agentconfig.EphemeralRedirectAnnotation: "containerd://3f4e1c2b9a"}
+	ready, _ := podAgentReady(pod)
+	assert.True(t, ready)
+}
+
+func TestPodAgentReady_node(t *testing.T) {
+	const config = "agentName: echo\n"
+	pod := &core.Pod{
+		ObjectMeta: meta.ObjectMeta{
+			Name:        "echo-1",
+			Namespace:   "default",
+			Annotations: map[string]string{agentconfig.NodeAgentConfigAnnotation: agentconfig.NodeAgentConfigHash(config)},
+		},
+		Spec: core.PodSpec{Containers: []core.Container{{Name: "echo"}}},
+		Status: core.PodStatus{
+			Phase:      core.PodRunning,
+			Conditions: []core.PodCondition{{Type: core.PodReady, Status: core.ConditionTrue}},
+		},
+	}
+	_, reason := podAgentReady(pod)
+	assert.Equal(t, "the traffic-node-agent has not started the traffic-agent", reason)
+
+	// The agent runs with a previous config
+	pod.Annotations[agentconfig.NodeAgentRedirectAnnotation] = agentconfig.NodeAgentConfigHash("agentName: other\n")
+	_, reason = podAgentReady(pod)
+	assert.Equal(t, "the traffic-node-agent has not started the traffic-agent", reason)
+
+	pod.Annotations[agentconfig.NodeAgentRedirectAnnotation] = agentconfig.NodeAgentConfigHash(config)
+	ready, _ := podAgentReady(pod)
+	assert.True(t, ready)
+	assert.True(t, describePod(pod).AgentInjected)
+}
+
+func TestPodAgentReady(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*core.Pod)
+		ready  bool
+		reason string
+	}{
+		{"ready", func(*core.Pod) {}, true, ""},
+		{"terminating", func(p *core.Pod) { p.DeletionTimestamp = &meta.Time{} }, false, "terminating"},
+		{"no agent", func(p *core.Pod) { p.Spec.Containers = p.Spec.Containers[:1] }, false, "no traffic-agent"},
+		{"waiting container", func(p *core.Pod) {
+			p.Status.ContainerStatuses = []core.ContainerStatus{
+				{Name: "echo", State: core.ContainerState{Running: &core.ContainerStateRunning{}}},
+				{Name: agentconfig.ContainerName, State: core.ContainerState{Waiting: &core.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}},
+			}
+		}, false, "container traffic-agent is waiting: CrashLoopBackOff"},
+		{"not ready", func(p *core.Pod) { p.Status.Conditions[0].Status = core.ConditionFalse }, false, "not ready"},
+		{"no ready condition", func(p *core.Pod) { p.Status.Conditions = nil }, false, "not ready"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := echoPod("echo-1", true)
+			tt.modify(pod)
+			ready, reason := podAgentReady(pod)
+			assert.Equal(t, tt.ready, ready)
+			assert.Equal(t, tt.reason, reason)
+		})
+	}
+}
+
+// interceptTM returns a TrafficManager with an intercept of the echo deployment that has the given disposition.
+func interceptTM(disposition manager.InterceptDispositionType, message string) *TrafficManager {
+	return &TrafficManager{currentIntercepts: []*manager.InterceptInfo{{
+		Spec: &manager.InterceptSpec{
+			Name:         "echo",
+			Agent:        "echo",
+			Namespace:    "default",
+			WorkloadKind: "Deployment",
+		},
+		Disposition: disposition,
+		Message:     message,
+	}}}
+}
+
+func TestInterceptWaitProgress(t *testing.T) {
+	ctx := k8sapi.WithK8sInterface(context.Background(), fake.NewSimpleClientset(echoDeployment(), echoPod("echo-1", true), echoPod("echo-2", false)))
+	tests := []struct {
+		name        string
+		disposition manager.InterceptDispositionType
+		message     string
+		phase       rpc.InterceptWaitProgress_Phase
+		wantMessage string
+		wantError   string
+	}{
+		{"waiting", manager.InterceptDispositionType_WAITING, "", rpc.InterceptWaitProgress_WAITING, "waiting for the traffic-agent to arrive", ""},
+		{"waiting with reason", manager.InterceptDispositionType_WAITING, "agent is being installed", rpc.InterceptWaitProgress_WAITING, "agent is being installed", ""},
+		{"agent error", manager.InterceptDispositionType_AGENT_ERROR, "crashed", rpc.InterceptWaitProgress_FAILED, "crashed", "intercept in error state AGENT_ERROR: crashed"},
+		{"no agent", manager.InterceptDispositionType_NO_AGENT, "", rpc.InterceptWaitProgress_FAILED, "", "intercept in error state NO_AGENT: "},
+		{"active", manager.InterceptDispositionType_ACTIVE, "", rpc.InterceptWaitProgress_ROLLING_OUT, "pod echo-2: no traffic-agent", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := interceptTM(tt.disposition, tt.message).interceptWaitProgress(ctx, "echo")
+			require.NoError(t, err)
+			assert.Equal(t, tt.phase, p.Phase)
+			assert.Equal(t, tt.disposition, p.Disposition)
+			assert.Equal(t, tt.wantMessage, p.Message)
+			assert.Equal(t, tt.wantError, p.ErrorText)
+		})
+	}
+
+	_, err := interceptTM(manager.InterceptDispositionType_ACTIVE, "").interceptWaitProgress(ctx, "other")
+	assert.ErrorContains(t, err, "intercept other not found")
+}
+
+// progressRecorder is an InterceptWaitStream that records the progress that is sent.
+type progressRecorder []*rpc.InterceptWaitProgress
+
+func (r *progressRecorder) Send(p *rpc.InterceptWaitProgress) error {
+	*r = append(*r, p)
+	return nil
+}
+
+func phases(r progressRecorder) []rpc.InterceptWaitProgress_Phase {
+	ps := make([]rpc.InterceptWaitProgress_Phase, len(r))
+	for i, p := range r {
+		ps[i] = p.Phase
+	}
+	return ps
+}
+
+func TestWaitForIntercept(t *testing.T) {
+	defer func(d time.Duration) { waitPollInterval = d }(waitPollInterval)
+	waitPollInterval = 10 * time.Millisecond
+	wr := &rpc.WaitForInterceptRequest{Name: "echo", Timeout: durationpb.New(5 * time.Second)}
+
+	t.Run("ready", func(t *testing.T) {
+		// The second pod gets its traffic-agent after a couple of polls
+		cs := fake.NewSimpleClientset(echoDeployment(), echoPod("echo-1", true), echoPod("echo-2", false))
+		lists := 0
+		cs.PrependReactor("list", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+			if lists++; lists < 3 {
+				return false, nil, nil
+			}
+			return true, &core.PodList{Items: []core.Pod{*echoPod("echo-1", true), *echoPod("echo-2", true)}}, nil
+		})
+		ctx := k8sapi.WithK8sInterface(dlog.NewTestContext(t, false), cs)
+		var r progressRecorder
+		require.NoError(t, interceptTM(manager.InterceptDispositionType_ACTIVE, "").WaitForIntercept(ctx, wr, &r))
+		assert.Equal(t, []rpc.InterceptWaitProgress_Phase{rpc.InterceptWaitProgress_ROLLING_OUT, rpc.InterceptWaitProgress_READY}, phases(r),
+			"unchanged progress was sent again")
+		assert.Equal(t, int32(2), r[1].ReadyPods)
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		ctx := dlog.NewTestContext(t, false)
+		var r progressRecorder
+		require.NoError(t, interceptTM(manager.InterceptDispositionType_AGENT_ERROR, "crashed").WaitForIntercept(ctx, wr, &r))
+		assert.Equal(t, []rpc.InterceptWaitProgress_Phase{rpc.InterceptWaitProgress_FAILED}, phases(r))
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		ctx := dlog.NewTestContext(t, false)
+		var r progressRecorder
+		wr := &rpc.WaitForInterceptRequest{Name: "echo", Timeout: durationpb.New(50 * time.Millisecond)}
+		require.NoError(t, interceptTM(manager.InterceptDispositionType_WAITING, "").WaitForIntercept(ctx, wr, &r))
+		require.Equal(t, []rpc.InterceptWaitProgress_Phase{rpc.InterceptWaitProgress_WAITING, rpc.InterceptWaitProgress_FAILED}, phases(r))
+		assert.Equal(t, "timed out after 50ms waiting for intercept echo: waiting for the traffic-agent to arrive", r[1].ErrorText)
+		assert.Equal(t, int32(errcat.User), r[1].ErrorCategory)
+	})
+}
@@ -0,0 +1,92 @@
+package trafficmgr
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// interceptMount describes the desired mount of an intercept.
+type interceptMount struct {
+	// name of the intercept
+	name string
+
+	// readOnly is true when the remote volumes are mounted read-only
+	readOnly bool
+
+	// include and exclude are the paths in the intercepted container given with --mount-include and --mount-exclude
+	include []string
+	exclude []string
+
+	// mountedPaths are the paths that have been mounted, so that their directories can be removed
+	sync.Mutex
+	mountedPaths []string
+}
+
+func (im *interceptMount) addMountedPaths(ps []string) {
+	im.Lock()
+	im.mountedPaths = append(im.mountedPaths, ps...)
+	im.Unlock()
+}
+
+func (im *interceptMount) getMountedPaths() []string {
+	im.Lock()
+	defer im.Unlock()
+	return im.mountedPaths
+}
+
+// isSubset returns true when only some of the paths in the intercepted container are mounted.
+func (im *interceptMount) isSubset() bool {
+	return len(im.include) > 0 || len(im.exclude) > 0
+}
+
+// mountPaths returns the paths in the intercepted container that are mounted, given the container's volume mounts.
+// The included paths, or all volume mounts when nothing is included, are retained unless they're equal to, or
+// beneath, an excluded path, and paths beneath other retained paths are dropped.
+func (im *interceptMount) mountPaths(volumeMounts []string) []string {
+	candidates := im.include
+	if len(candidates) == 0 {
+		candidates = volumeMounts
+	}
+	var ps []string
+	for _, p := range candidates {
+		p = path.Clean(p)
+		if !pathIsWithinAny(p, im.exclude) {
+			ps = append(ps, p)
+		}
+	}
+	sort.Strings(ps)
+	var result []string
+	for _, p := range ps {
+		if !pathIsWithinAny(p, result) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+func pathIsWithinAny(p string, dirs []string) bool {
+	for _, dir := range dirs {
+		dir = path.Clean(dir)
+		if p == dir || dir == "/" || strings.HasPrefix(p, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// removeMountDirs removes the directories beneath the given mount point that were created for the given paths, and
+// their parents. Directories that aren't empty are retained, so nothing is ever removed from a volume that for some
+// reason remains mounted.
+func removeMountDirs(mountPoint string, paths []string) {
+	for _, p := range paths {
+		for ; p != "/" && p != "."; p = path.Dir(p) {
+			if err := os.Remove(filepath.Join(mountPoint, filepath.FromSlash(p))); err != nil && !os.IsNotExist(err) {
+				break
+			}
+		}
+	}
+}
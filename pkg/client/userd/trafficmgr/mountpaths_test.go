@@ -0,0 +1,54 @@
+package trafficmgr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_interceptMount_mountPaths(t *testing.T) {
+	volumeMounts := []string{"/etc/config", "/var/run/secrets/kubernetes.io", "/data", "/data/cache"}
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		want    []string
+	}{
+		{
+			name: "all",
+			want: []string{"/data", "/etc/config", "/var/run/secrets/kubernetes.io"},
+		},
+		{
+			name:    "include",
+			include: []string{"/etc/config/"},
+			want:    []string{"/etc/config"},
+		},
+		{
+			name:    "include nested",
+			include: []string{"/etc/config/app.d", "/etc", "/var/run/secrets"},
+			want:    []string{"/etc", "/var/run/secrets"},
+		},
+		{
+			name:    "exclude",
+			exclude: []string{"/data", "/var/run/secrets"},
+			want:    []string{"/etc/config"},
+		},
+		{
+			name:    "include and exclude",
+			include: []string{"/etc/config", "/data/cache"},
+			exclude: []string{"/data"},
+			want:    []string{"/etc/config"},
+		},
+		{
+			name:    "exclude does not match prefix of name",
+			exclude: []string{"/dat"},
+			want:    []string{"/data", "/etc/config", "/var/run/secrets/kubernetes.io"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			im := &interceptMount{include: tt.include, exclude: tt.exclude}
+			assert.Equal(t, tt.want, im.mountPaths(volumeMounts))
+		})
+	}
+}
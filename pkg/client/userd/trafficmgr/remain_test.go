@@ -0,0 +1,101 @@
+package trafficmgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+// expiringManager is a manager.ManagerClient that has expired the session, and that may resume it.
+type expiringManager struct {
+	manager.ManagerClient
+	resumeErr error
+	resumes   chan *manager.ResumeSessionRequest
+	departed  chan struct{}
+}
+
+func (m *expiringManager) Remain(context.Context, *manager.RemainRequest, ...grpc.CallOption) (*empty.Empty, error) {
+	return nil, status.Error(codes.NotFound, "session not found")
+}
+
+func (m *expiringManager) ResumeSession(_ context.Context, rq *manager.ResumeSessionRequest, _ ...grpc.CallOption) (*manager.SessionInfo, error) {
+	m.resumes <- rq
+	if m.resumeErr != nil {
+		return nil, m.resumeErr
+	}
+	return rq.Session, nil
+}
+
+func (m *expiringManager) Depart(context.Context, *manager.SessionInfo, ...grpc.CallOption) (*empty.Empty, error) {
+	close(m.departed)
+	return &empty.Empty{}, nil
+}
+
+func newExpiringSession(t *testing.T, resumeErr error) (*TrafficManager, *expiringManager) {
+	conn, err := grpc.Dial("127.0.0.1:1", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	mc := &expiringManager{
+		resumeErr: resumeErr,
+		resumes:   make(chan *manager.ResumeSessionRequest, 10),
+		departed:  make(chan struct{}),
+	}
+	return &TrafficManager{
+		managerClient:  mc,
+		managerConn:    conn,
+		sessionInfo:    &manager.SessionInfo{SessionId: "session-1"},
+		resumeToken:    "secret",
+		getCloudAPIKey: func(context.Context, string, bool) (string, error) { return "", nil },
+	}, mc
+}
+
+func TestRemain_resumeSession(t *testing.T) {
+	defer func(d time.Duration) { remainInterval = d }(remainInterval)
+	remainInterval = 10 * time.Millisecond
+
+	t.Run("resumed", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+		tm, mc := newExpiringSession(t, nil)
+		errs := make(chan error, 1)
+		go func() { errs <- tm.remain(ctx) }()
+
+		// The session is resumed each time it has expired, using its resume token
+		for i := 0; i < 2; i++ {
+			select {
+			case rq := <-mc.resumes:
+				assert.Equal(t, "session-1", rq.Session.SessionId)
+				assert.Equal(t, "secret", rq.ResumeToken)
+			case <-time.After(5 * time.Second):
+				t.Fatal("session wasn't resumed")
+			}
+		}
+		cancel()
+		assert.NoError(t, <-errs)
+		<-mc.departed
+	})
+
+	t.Run("resume token expired", func(t *testing.T) {
+		ctx := dlog.NewTestContext(t, false)
+		tm, mc := newExpiringSession(t, status.Error(codes.NotFound, "resume token has expired"))
+		errs := make(chan error, 1)
+		go func() { errs <- tm.remain(ctx) }()
+		select {
+		case err := <-errs:
+			assert.ErrorIs(t, err, SessionExpiredErr)
+		case <-time.After(5 * time.Second):
+			t.Fatal("session didn't expire")
+		}
+		assert.Len(t, mc.resumes, 1)
+		<-mc.departed
+	})
+}
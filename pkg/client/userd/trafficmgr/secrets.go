@@ -0,0 +1,62 @@
+package trafficmgr
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	core "k8s.io/api/core/v1"
+	errors2 "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+// resolveRedactedEnvironment fills in the environment values that the traffic-manager redacted because they're
+// obtained from secrets, by reading the secrets using the user's own credentials. Values from secrets that the user
+// isn't permitted to read retain their placeholders and a warning is returned for each such secret.
+func resolveRedactedEnvironment(ctx context.Context, ii *manager.InterceptInfo) []string {
+	if len(ii.RedactedEnvironment) == 0 {
+		return nil
+	}
+	if cluster := ii.Spec.Cluster; cluster != "" {
+		return []string{fmt.Sprintf("the environment obtained from secrets is redacted, because the secrets are in cluster %s", cluster)}
+	}
+	keys := make([]string, 0, len(ii.RedactedEnvironment))
+	for k := range ii.RedactedEnvironment {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	api := k8sapi.GetK8sInterface(ctx).CoreV1().Secrets(ii.Spec.Namespace)
+	secrets := make(map[string]*core.Secret)
+	failed := make(map[string]error)
+	var warnings []string
+	for _, k := range keys {
+		ref := ii.RedactedEnvironment[k]
+		secret, ok := secrets[ref.Name]
+		if !ok {
+			if _, ok = failed[ref.Name]; ok {
+				continue
+			}
+			var err error
+			if secret, err = api.Get(ctx, ref.Name, meta.GetOptions{}); err != nil {
+				dlog.Debugf(ctx, "unable to get secret %s.%s: %v", ref.Name, ii.Spec.Namespace, err)
+				failed[ref.Name] = err
+				if errors2.IsForbidden(err) {
+					warnings = append(warnings, fmt.Sprintf("the environment obtained from secret %s is redacted because you're not permitted to read it", ref.Name))
+				} else {
+					warnings = append(warnings, fmt.Sprintf("the environment obtained from secret %s is redacted: %v", ref.Name, err))
+				}
+				continue
+			}
+			secrets[ref.Name] = secret
+		}
+		if v, ok := secret.Data[ref.Key]; ok {
+			ii.Environment[k] = string(v)
+		}
+	}
+	return warnings
+}
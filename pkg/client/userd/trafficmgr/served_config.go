@@ -0,0 +1,66 @@
+package trafficmgr
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// servedConfigPollInterval is the interval between the checks for changes in the client config that the
+// traffic-manager serves.
+const servedConfigPollInterval = time.Minute
+
+// ApplyConfig applies the reloadable settings of the current config to the session. It's called when the user's
+// config has been reloaded. The client config that the traffic-manager serves is fetched again, because the
+// user's config is merged with it.
+func (tm *TrafficManager) ApplyConfig(c context.Context) error {
+	return tm.refreshServedConfig(c, true)
+}
+
+// servedConfigWatcher periodically fetches the client config that the traffic-manager serves and applies it
+// when it has changed.
+func (tm *TrafficManager) servedConfigWatcher(c context.Context) error {
+	ticker := time.NewTicker(servedConfigPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.Done():
+			return nil
+		case <-ticker.C:
+			if err := tm.refreshServedConfig(c, false); err != nil {
+				dlog.Warn(c, err)
+			}
+		}
+	}
+}
+
+// refreshServedConfig fetches the client config that the traffic-manager serves and applies it if it has changed,
+// or if force is true.
+func (tm *TrafficManager) refreshServedConfig(c context.Context, force bool) error {
+	tm.servedConfigLock.Lock()
+	defer tm.servedConfigLock.Unlock()
+	data := tm.servedConfig
+	cc, err := tm.managerClient.GetClientConfig(c, &empty.Empty{})
+	switch {
+	case err == nil:
+		data = cc.ConfigYaml
+	case status.Code(err) == codes.Unimplemented || c.Err() != nil:
+	default:
+		dlog.Debugf(c, "unable to get the client config served by the traffic-manager: %v", err)
+	}
+	changed := !bytes.Equal(data, tm.servedConfig)
+	if !(changed || force) {
+		return nil
+	}
+	if changed {
+		dlog.Info(c, "The client config served by the traffic-manager has changed")
+		tm.servedConfig = data
+	}
+	return tm.ApplyServedConfig(c, data)
+}
@@ -61,6 +61,22 @@ func (p *mgrProxy) GetIntercept(ctx context.Context, arg *managerrpc.GetIntercep
 	return client.GetIntercept(ctx, arg, callOptions...)
 }
 
+func (p *mgrProxy) GetInterceptDiagnostics(ctx context.Context, arg *managerrpc.GetInterceptRequest) (*managerrpc.InterceptDiagnostics, error) {
+	client, callOptions, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+	return client.GetInterceptDiagnostics(ctx, arg, callOptions...)
+}
+
+func (p *mgrProxy) ListWorkloads(ctx context.Context, arg *managerrpc.ListWorkloadsRequest) (*managerrpc.WorkloadInfoList, error) {
+	client, callOptions, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+	return client.ListWorkloads(ctx, arg, callOptions...)
+}
+
 func (p *mgrProxy) Version(ctx context.Context, arg *empty.Empty) (*managerrpc.VersionInfo2, error) {
 	client, callOptions, err := p.get()
 	if err != nil {
@@ -101,6 +117,14 @@ func (p *mgrProxy) GetCloudConfig(ctx context.Context, arg *empty.Empty) (*manag
 	return client.GetCloudConfig(ctx, arg, callOptions...)
 }
 
+func (p *mgrProxy) GetClientConfig(ctx context.Context, arg *empty.Empty) (*managerrpc.CLIConfig, error) {
+	client, callOptions, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+	return client.GetClientConfig(ctx, arg, callOptions...)
+}
+
 func (p *mgrProxy) ArriveAsClient(ctx context.Context, arg *managerrpc.ClientInfo) (*managerrpc.SessionInfo, error) {
 	client, callOptions, err := p.get()
 	if err != nil {
@@ -129,6 +153,13 @@ func (p *mgrProxy) Depart(ctx context.Context, arg *managerrpc.SessionInfo) (*em
 	}
 	return client.Depart(ctx, arg, callOptions...)
 }
+func (p *mgrProxy) ResumeSession(ctx context.Context, arg *managerrpc.ResumeSessionRequest) (*managerrpc.SessionInfo, error) {
+	client, callOptions, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+	return client.ResumeSession(ctx, arg, callOptions...)
+}
 
 func (p *mgrProxy) WatchAgents(arg *managerrpc.SessionInfo, srv managerrpc.Manager_WatchAgentsServer) error {
 	return status.Error(codes.Unimplemented, "WatchAgents was deprecated in 2.5.5")
@@ -189,6 +220,9 @@ func (p *mgrProxy) CreateIntercept(_ context.Context, _ *managerrpc.CreateInterc
 func (p *mgrProxy) RemoveIntercept(context.Context, *managerrpc.RemoveInterceptRequest2) (*empty.Empty, error) {
 	return nil, status.Error(codes.Unimplemented, "must call connector.RemoveIntercept instead of manager.RemoveIntercept")
 }
+func (p *mgrProxy) HandoffIntercept(context.Context, *managerrpc.HandoffInterceptRequest) (*managerrpc.InterceptInfo, error) {
+	return nil, status.Error(codes.Unimplemented, "must call connector.HandoffIntercept instead of manager.HandoffIntercept")
+}
 func (p *mgrProxy) UpdateIntercept(ctx context.Context, arg *managerrpc.UpdateInterceptRequest) (*managerrpc.InterceptInfo, error) {
 	client, callOptions, err := p.get()
 	if err != nil {
@@ -0,0 +1,102 @@
+package trafficmgr
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+// TrafficStats are the counters of the intercepted connections that have been dialed to a local address.
+type TrafficStats struct {
+	// Connections is the total number of connections.
+	Connections int64 `json:"connections"`
+
+	// ActiveConnections is the number of connections that are currently open.
+	ActiveConnections int64 `json:"activeConnections"`
+
+	// BytesIn is the number of bytes that were sent from the cluster to the local address.
+	BytesIn int64 `json:"bytesIn"`
+
+	// BytesOut is the number of bytes that were sent from the local address to the cluster.
+	BytesOut int64 `json:"bytesOut"`
+}
+
+// trafficCounters keeps the TrafficStats of each dialed address. The zero value is ready to use.
+type trafficCounters struct {
+	sync.Mutex
+	stats map[string]*TrafficStats
+}
+
+// observe is a tunnel.ConnObserver that counts the traffic of the given connection.
+func (tc *trafficCounters) observe(id tunnel.ConnID, conn net.Conn) net.Conn {
+	key := id.DestinationAddr().String()
+	tc.Lock()
+	if tc.stats == nil {
+		tc.stats = make(map[string]*TrafficStats)
+	}
+	ts, ok := tc.stats[key]
+	if !ok {
+		ts = &TrafficStats{}
+		tc.stats[key] = ts
+	}
+	tc.Unlock()
+	atomic.AddInt64(&ts.Connections, 1)
+	atomic.AddInt64(&ts.ActiveConnections, 1)
+	return &countingConn{Conn: conn, stats: ts}
+}
+
+// get returns a copy of the stats of the given address.
+func (tc *trafficCounters) get(addr string) TrafficStats {
+	tc.Lock()
+	ts, ok := tc.stats[addr]
+	tc.Unlock()
+	if !ok {
+		return TrafficStats{}
+	}
+	return TrafficStats{
+		Connections:       atomic.LoadInt64(&ts.Connections),
+		ActiveConnections: atomic.LoadInt64(&ts.ActiveConnections),
+		BytesIn:           atomic.LoadInt64(&ts.BytesIn),
+		BytesOut:          atomic.LoadInt64(&ts.BytesOut),
+	}
+}
+
+type countingConn struct {
+	net.Conn
+	stats  *TrafficStats
+	closed int32
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.stats.BytesOut, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.stats.BytesIn, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Close() error {
+	if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		atomic.AddInt64(&c.stats.ActiveConnections, -1)
+	}
+	return c.Conn.Close()
+}
+
+// InterceptTraffic returns the counters of the connections that have been dialed to the target of the given
+// intercept during this session.
+func (tm *TrafficManager) InterceptTraffic(spec *manager.InterceptSpec) TrafficStats {
+	host := spec.TargetHost
+	if ip := iputil.Parse(host); ip != nil {
+		host = ip.String()
+	}
+	return tm.traffic.get(net.JoinHostPort(host, strconv.Itoa(int(spec.TargetPort))))
+}
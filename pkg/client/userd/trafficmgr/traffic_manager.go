@@ -2,6 +2,9 @@ package trafficmgr
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
@@ -17,8 +20,11 @@ import (
 	stacktrace "github.com/pkg/errors"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/durationpb"
 	empty "google.golang.org/protobuf/types/known/emptypb"
 	core "k8s.io/api/core/v1"
@@ -47,6 +53,7 @@ import (
 	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
 	"github.com/telepresenceio/telepresence/v2/pkg/matcher"
 	"github.com/telepresenceio/telepresence/v2/pkg/restapi"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/buffer"
 )
 
 // A SessionService represents a service that should be started together with each daemon session.
@@ -61,6 +68,10 @@ type WatchWorkloadsStream interface {
 	Send(*rpc.WorkloadInfoSnapshot) error
 }
 
+type WatchWorkloadEventsStream interface {
+	Send(*rpc.WorkloadEventsDelta) error
+}
+
 type Session interface {
 	restapi.AgentState
 	AddIntercept(context.Context, *rpc.CreateInterceptRequest) (*rpc.InterceptResult, error)
@@ -73,18 +84,29 @@ type Session interface {
 	IngressInfos(c context.Context) ([]*manager.IngressInfo, error)
 	ClearIntercepts(context.Context) error
 	RemoveIntercept(context.Context, string) error
+	HandoffIntercept(context.Context, string, string, bool) (*manager.InterceptInfo, error)
 	Run(context.Context) error
+	ApplyConfig(context.Context) error
 	Uninstall(context.Context, *rpc.UninstallRequest) (*rpc.UninstallResult, error)
 	UpdateStatus(context.Context, *rpc.ConnectRequest) *rpc.ConnectInfo
 	WatchWorkloads(context.Context, *rpc.WatchWorkloadsRequest, WatchWorkloadsStream) error
+	WatchWorkloadEvents(context.Context, *rpc.WatchWorkloadsRequest, WatchWorkloadEventsStream) error
 	WithK8sInterface(context.Context) context.Context
 	WorkloadInfoSnapshot(context.Context, []string, rpc.ListRequest_Filter, bool) (*rpc.WorkloadInfoSnapshot, error)
+	PeerWorkloadInfoSnapshot(context.Context, string, string, rpc.ListRequest_Filter) (*rpc.WorkloadInfoSnapshot, error)
 	ManagerClient() manager.ManagerClient
 	GetCurrentNamespaces(forClientAccess bool) []string
 	ActualNamespace(string) string
 	RemainWithToken(context.Context) error
 	AddNamespaceListener(k8s.NamespaceListener)
 	GatherLogs(context.Context, *connector.LogsRequest) (*connector.LogsResponse, error)
+	Benchmark(context.Context, *connector.BenchmarkRequest) (*connector.BenchmarkResponse, error)
+	UpgradeAgents(context.Context, *rpc.UpgradeAgentsRequest, UpgradeAgentsStream) error
+	WaitForIntercept(context.Context, *rpc.WaitForInterceptRequest, InterceptWaitStream) error
+	WatchRollout(context.Context, *rpc.WatchRolloutRequest, InterceptWaitStream) error
+	DescribeIntercept(context.Context, *rpc.DescribeInterceptRequest) (*rpc.InterceptDescription, error)
+	GetInterceptDefaults(context.Context, *rpc.GetInterceptDefaultsRequest) (*rpc.InterceptDefaults, error)
+	InterceptTraffic(*manager.InterceptSpec) TrafficStats
 }
 
 type Service interface {
@@ -103,6 +125,10 @@ type apiMatcher struct {
 	metadata       map[string]string
 }
 
+// sandboxNetNamespace is the name of the network namespace that the root daemon establishes the network in when
+// connected with --namespace-sandbox.
+const sandboxNetNamespace = "telepresence"
+
 type TrafficManager struct {
 	*installer // installer is also a k8sCluster
 
@@ -123,11 +149,29 @@ type TrafficManager struct {
 	// version reported by the manager
 	managerVersion semver.Version
 
+	// warnings that the manager pushed when this client arrived
+	managerWarnings []string
+
+	// servedConfig is the client config that the manager serves, as last fetched
+	servedConfig     []byte
+	servedConfigLock sync.Mutex
+
 	// search paths are propagated to the rootDaemon
 	rootDaemon daemon.DaemonClient
 
+	// dockerDNSIP is the IP where the root daemon serves DNS to local Docker containers. It's only
+	// set when connected with --allow-docker.
+	dockerDNSIP net.IP
+
+	// netNamespace is the network namespace that the root daemon established the network in. It's only
+	// set when connected with --namespace-sandbox.
+	netNamespace string
+
 	sessionInfo *manager.SessionInfo // sessionInfo returned by the traffic-manager
 
+	// resumeToken is the secret that enables this client to resume its session after it has expired
+	resumeToken string
+
 	// Map of desired mount points for intercepts
 	mountPoints sync.Map
 
@@ -137,6 +181,9 @@ type TrafficManager struct {
 
 	wlWatcher *workloadsAndServicesWatcher
 
+	// wlCache is the persisted snapshot of the workloads that the wlWatcher has discovered
+	wlCache workloadCache
+
 	insLock sync.Mutex
 
 	// Currently intercepted namespaces by remote intercepts
@@ -158,6 +205,13 @@ type TrafficManager struct {
 	// the pid of that new command.
 	currentInterceptors map[string]int
 
+	// offeredIntercepts are the intercepts that have been offered to another client, keyed by intercept ID. Their
+	// interceptors are stopped when the recipient has accepted them.
+	offeredIntercepts map[string]*manager.InterceptInfo
+
+	// traffic counts the intercepted connections that are dialed by the dial request watcher
+	traffic trafficCounters
+
 	// currentAgents is the latest snapshot returned by the agent watcher
 	currentAgents     []*manager.AgentInfo
 	currentAgentsLock sync.Mutex
@@ -186,13 +240,24 @@ type interceptResult struct {
 // TODO: Change to released version
 var firstAgentConfigMapVersion = semver.MustParse("2.6.0-alpha.64")
 
+// firstReplaceVersion first version of traffic-manager that can replace a container with the intercepting process
+var firstReplaceVersion = semver.MustParse("2.7.0-0")
+
+// firstFederationVersion first version of traffic-manager that can intercept in, and list, peered clusters
+var firstFederationVersion = semver.MustParse("2.7.0-0")
+
 func NewSession(c context.Context, sr *scout.Reporter, cr *rpc.ConnectRequest, svc Service, extraServices []SessionService) (Session, *connector.ConnectInfo) {
 	dlog.Info(c, "-- Starting new session")
 	sr.Report(c, "connect")
 
-	rootDaemon, err := svc.RootDaemonClient(c)
-	if err != nil {
-		return nil, connectError(rpc.ConnectInfo_DAEMON_FAILED, err)
+	// A session without network has no root daemon. The cluster is then only reachable through the proxy of
+	// "telepresence proxy".
+	var rootDaemon daemon.DaemonClient
+	if !cr.NoNetwork {
+		var err error
+		if rootDaemon, err = svc.RootDaemonClient(c); err != nil {
+			return nil, connectError(rpc.ConnectInfo_DAEMON_FAILED, err)
+		}
 	}
 
 	dlog.Info(c, "Connecting to k8s cluster...")
@@ -214,7 +279,7 @@ func NewSession(c context.Context, sr *scout.Reporter, cr *rpc.ConnectRequest, s
 	connectStart := time.Now()
 
 	dlog.Info(c, "Connecting to traffic manager...")
-	tmgr, err := connectMgr(c, cluster, sr.InstallID(), svc, rootDaemon)
+	tmgr, err := connectMgr(c, cluster, sr.InstallID(), svc, rootDaemon, cr)
 
 	if err != nil {
 		dlog.Errorf(c, "Unable to connect to TrafficManager: %s", err)
@@ -235,20 +300,55 @@ func NewSession(c context.Context, sr *scout.Reporter, cr *rpc.ConnectRequest, s
 	}
 	svc.SetManagerClient(tmgr.managerClient, opts...)
 
-	// Tell daemon what it needs to know in order to establish outbound traffic to the cluster
-	oi := tmgr.getOutboundInfo(c)
+	if rootDaemon != nil {
+		if err = tmgr.connectRootDaemon(c, cr); err != nil {
+			return nil, connectError(rpc.ConnectInfo_DAEMON_FAILED, err)
+		}
+	}
+	tmgr.AddNamespaceListener(tmgr.updateDaemonNamespaces)
+
+	// Collect data on how long connection time took
+	dlog.Debug(c, "Finished connecting to traffic manager")
+	sr.Report(c, "finished_connecting_traffic_manager", scout.Entry{
+		Key: "connect_duration", Value: time.Since(connectStart).Seconds()})
+
+	ret := &rpc.ConnectInfo{
+		Error:            rpc.ConnectInfo_UNSPECIFIED,
+		ClusterContext:   cluster.Config.Context,
+		ClusterServer:    cluster.Config.Server,
+		ClusterId:        cluster.GetClusterId(c),
+		SessionInfo:      tmgr.session(),
+		Intercepts:       &manager.InterceptInfoSnapshot{Intercepts: tmgr.getCurrentIntercepts()},
+		DockerDnsIp:      tmgr.dockerDNSIP,
+		ManagerWarnings:  tmgr.managerWarnings,
+		NetNamespace:     tmgr.netNamespace,
+		Namespace:        cluster.ConnectedNamespace(),
+		MappedNamespaces: cluster.MappedNamespaces(),
+	}
+	return tmgr, ret
+}
+
+// connectRootDaemon tells the root daemon what it needs to know in order to establish outbound traffic to the
+// cluster.
+func (tm *TrafficManager) connectRootDaemon(c context.Context, cr *rpc.ConnectRequest) error {
+	oi := tm.getOutboundInfo(c)
+	oi.AllowDocker = cr.AllowDocker
+	if cr.NamespaceSandbox {
+		oi.NetNamespace = sandboxNetNamespace
+	}
 
 	dlog.Debug(c, "Connecting to root daemon")
 	var rootStatus *daemon.DaemonStatus
 	for attempt := 1; ; attempt++ {
-		if rootStatus, err = rootDaemon.Connect(c, oi); err != nil {
+		var err error
+		if rootStatus, err = tm.rootDaemon.Connect(c, oi); err != nil {
 			dlog.Errorf(c, "failed to connect to root daemon: %v", err)
-			return nil, connectError(rpc.ConnectInfo_DAEMON_FAILED, err)
+			return err
 		}
 		oc := rootStatus.OutboundConfig
 		if oc == nil || oc.Session == nil {
 			// This is an internal error. Something is wrong with the root daemon.
-			return nil, connectError(rpc.ConnectInfo_DAEMON_FAILED, errors.New("root daemon's OutboundConfig has no Session"))
+			return errors.New("root daemon's OutboundConfig has no Session")
 		}
 		if oc.Session.SessionId == oi.Session.SessionId {
 			break
@@ -258,29 +358,16 @@ func NewSession(c context.Context, sr *scout.Reporter, cr *rpc.ConnectRequest, s
 		// crashed without disconnecting. So let's do that now, and then reconnect...
 		if attempt == 2 {
 			// ...or not, since we've already done it.
-			return nil, connectError(rpc.ConnectInfo_DAEMON_FAILED, errors.New("unable to reconnect"))
+			return errors.New("unable to reconnect")
 		}
-		if _, err = rootDaemon.Disconnect(c, &empty.Empty{}); err != nil {
-			return nil, connectError(rpc.ConnectInfo_DAEMON_FAILED, fmt.Errorf("failed to disconnect from the root daemon: %w", err))
+		if _, err = tm.rootDaemon.Disconnect(client.WithRootDaemonSession(c, oc.Session.SessionId), &empty.Empty{}); err != nil {
+			return fmt.Errorf("failed to disconnect from the root daemon: %w", err)
 		}
 	}
 	dlog.Debug(c, "Connected to root daemon")
-	tmgr.AddNamespaceListener(tmgr.updateDaemonNamespaces)
-
-	// Collect data on how long connection time took
-	dlog.Debug(c, "Finished connecting to traffic manager")
-	sr.Report(c, "finished_connecting_traffic_manager", scout.Entry{
-		Key: "connect_duration", Value: time.Since(connectStart).Seconds()})
-
-	ret := &rpc.ConnectInfo{
-		Error:          rpc.ConnectInfo_UNSPECIFIED,
-		ClusterContext: cluster.Config.Context,
-		ClusterServer:  cluster.Config.Server,
-		ClusterId:      cluster.GetClusterId(c),
-		SessionInfo:    tmgr.session(),
-		Intercepts:     &manager.InterceptInfoSnapshot{Intercepts: tmgr.getCurrentIntercepts()},
-	}
-	return tmgr, ret
+	tm.dockerDNSIP = rootStatus.OutboundConfig.DockerDnsIp
+	tm.netNamespace = rootStatus.OutboundConfig.NetNamespace
+	return nil
 }
 
 func (tm *TrafficManager) RemainWithToken(ctx context.Context) error {
@@ -324,7 +411,7 @@ func connectCluster(c context.Context, cr *rpc.ConnectRequest) (*k8s.Cluster, er
 }
 
 // connectMgr returns a session for the given cluster that is connected to the traffic-manager.
-func connectMgr(c context.Context, cluster *k8s.Cluster, installID string, svc Service, rootDaemon daemon.DaemonClient) (*TrafficManager, error) {
+func connectMgr(c context.Context, cluster *k8s.Cluster, installID string, svc Service, rootDaemon daemon.DaemonClient, cr *rpc.ConnectRequest) (*TrafficManager, error) {
 	clientConfig := client.GetConfig(c)
 	tos := &clientConfig.Timeouts
 
@@ -357,28 +444,70 @@ func connectMgr(c context.Context, cluster *k8s.Cluster, installID string, svc S
 		return nil, fmt.Errorf("failed to ensure traffic manager: %w", err)
 	}
 
-	dlog.Debug(c, "traffic-manager started, creating port-forward")
-	grpcDialer, err := dnet.NewK8sPortForwardDialer(c, cluster.Config.RestConfig, k8sapi.GetK8sInterface(c))
-	if err != nil {
-		return nil, err
+	opts := []grpc.DialOption{
+		grpc.WithNoProxy(),
+		grpc.WithBlock(),
+		grpc.WithReturnConnectionError()}
+
+	grpcAddr, useTLS := cluster.GetManagerAddress()
+	if grpcAddr != "" {
+		dlog.Debugf(c, "traffic-manager started, dialing %s", grpcAddr)
+		opts = append(opts, grpc.WithContextDialer(cluster.ManagerDialer(clientConfig.Grpc.TCPUserTimeout)))
+		if useTLS {
+			host, _, err := net.SplitHostPort(grpcAddr)
+			if err != nil {
+				return nil, errcat.Config.Newf("invalid traffic-manager address %q: %w", grpcAddr, err)
+			}
+			opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{ServerName: host})))
+		} else {
+			opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		}
+	} else {
+		dlog.Debug(c, "traffic-manager started, creating port-forward")
+		grpcDialer, err := dnet.NewK8sPortForwardDialer(c, cluster.Config.RestConfig, k8sapi.GetK8sInterface(c))
+		if err != nil {
+			return nil, err
+		}
+		grpcAddr = net.JoinHostPort(
+			"svc/traffic-manager."+cluster.GetManagerNamespace(),
+			fmt.Sprint(install.ManagerPortHTTP))
+		opts = append(opts,
+			grpc.WithContextDialer(grpcDialer),
+			grpc.WithTransportCredentials(insecure.NewCredentials()))
 	}
-	grpcAddr := net.JoinHostPort(
-		"svc/traffic-manager."+cluster.GetManagerNamespace(),
-		fmt.Sprint(install.ManagerPortHTTP))
 
 	// First check. Establish connection
 	tc, tCancel := tos.TimeoutContext(c, client.TimeoutTrafficManagerAPI)
-	defer tCancel()
+	defer func() { tCancel() }()
 
-	opts := []grpc.DialOption{grpc.WithContextDialer(grpcDialer),
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithNoProxy(),
-		grpc.WithBlock(),
-		grpc.WithReturnConnectionError()}
+	opts = append(opts, clientConfig.Grpc.WindowDialOptions()...)
+	opts = append(opts, clientConfig.Grpc.KeepAliveDialOptions()...)
 
 	var conn *grpc.ClientConn
 	if conn, err = grpc.DialContext(tc, grpcAddr, opts...); err != nil {
-		return nil, client.CheckTimeout(tc, fmt.Errorf("dial manager: %w", err))
+		wsURL := cluster.GetManagerWebsocketURL()
+		if wsURL == "" {
+			return nil, client.CheckTimeout(tc, fmt.Errorf("dial manager: %w", err))
+		}
+
+		// Fall back to tunneling the gRPC connection through a websocket. This works in environments where
+		// only HTTPS egress through a proxy is permitted.
+		dlog.Warningf(c, "unable to dial traffic-manager at %s, falling back to websocket %s: %v", grpcAddr, wsURL, err)
+		// The traffic-manager only accepts websockets from clients with verified identities.
+		var token string
+		if token, err = identityToken(c, cluster); err != nil {
+			return nil, fmt.Errorf("the websocket tunnel requires an identity: %w", err)
+		}
+		tCancel()
+		tc, tCancel = tos.TimeoutContext(c, client.TimeoutTrafficManagerAPI)
+		opts = append(opts,
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return cluster.DialManagerWebsocket(ctx, token)
+			}),
+			grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if conn, err = grpc.DialContext(tc, grpcAddr, opts...); err != nil {
+			return nil, client.CheckTimeout(tc, fmt.Errorf("dial manager using websocket %s: %w", wsURL, err))
+		}
 	}
 	defer func() {
 		if err != nil {
@@ -398,17 +527,63 @@ func connectMgr(c context.Context, cluster *k8s.Cluster, installID string, svc S
 		return nil, client.CheckTimeout(tc, fmt.Errorf("unable to parse manager.Version: %w", err))
 	}
 
-	dlog.Debugf(c, "traffic-manager port-forward established, making client known to the traffic-manager as %q", userAndHost)
-	si, err := mClient.ArriveAsClient(tc, &manager.ClientInfo{
-		Name:      userAndHost,
-		InstallId: installID,
-		Product:   "telepresence",
-		Version:   client.Version(),
-		ApiKey:    apiKey,
-	})
+	resumeToken, err := newResumeToken()
 	if err != nil {
+		return nil, err
+	}
+
+	dlog.Debugf(c, "traffic-manager connection established, making client known to the traffic-manager as %q", userAndHost)
+	var header metadata.MD
+	ci := &manager.ClientInfo{
+		Name:         userAndHost,
+		InstallId:    installID,
+		Product:      "telepresence",
+		Version:      client.Version(),
+		ApiKey:       apiKey,
+		ResumeToken:  resumeToken,
+		ConnectOnly:  cr.ConnectOnly,
+		ExposedPorts: cr.ExposedPorts,
+	}
+	si, err := mClient.ArriveAsClient(tc, ci, grpc.Header(&header))
+	if status.Code(err) == codes.Unauthenticated {
+		// The traffic-manager verifies the identities of its clients. The token is only sent when it's required,
+		// because it grants access to the cluster.
+		dlog.Debugf(c, "traffic-manager requires an identity: %v", err)
+		if ci.IdentityToken, err = identityToken(c, cluster); err != nil {
+			return nil, fmt.Errorf("the traffic-manager requires an identity: %w", err)
+		}
+		si, err = mClient.ArriveAsClient(tc, ci, grpc.Header(&header))
+	}
+	if err != nil {
+		if st, ok := status.FromError(err); ok && st.Code() == codes.Unauthenticated {
+			return nil, errcat.User.New(st.Message())
+		}
 		return nil, client.CheckTimeout(tc, fmt.Errorf("manager.ArriveAsClient: %w", err))
 	}
+	if cr.ConnectOnly {
+		// Traffic-managers that predate connect-only sessions don't know that the client asked for it.
+		si.ConnectOnly = true
+	}
+	managerWarnings := client.ManagerWarnings(header)
+	if len(cr.ExposedPorts) > 0 && si.DnsName == "" && len(managerWarnings) == 0 {
+		// Traffic-managers that predate exposed ports ignore them.
+		managerWarnings = append(managerWarnings, "the traffic-manager doesn't expose the ports of clients, it must be upgraded")
+	}
+	for _, w := range managerWarnings {
+		dlog.Warnf(c, "traffic-manager: %s", w)
+	}
+
+	var servedConfig []byte
+	if cc, err := mClient.GetClientConfig(tc, &empty.Empty{}); err != nil {
+		if status.Code(err) != codes.Unimplemented {
+			dlog.Warnf(c, "unable to get the client config served by the traffic-manager: %v", err)
+		}
+	} else {
+		servedConfig = cc.ConfigYaml
+		if err = cluster.ApplyServedConfig(c, servedConfig); err != nil {
+			dlog.Warn(c, err)
+		}
+	}
 
 	return &TrafficManager{
 		installer:           ti.(*installer),
@@ -419,13 +594,30 @@ func connectMgr(c context.Context, cluster *k8s.Cluster, installID string, svc S
 		managerConn:         conn,
 		managerVersion:      managerVersion,
 		sessionInfo:         si,
+		resumeToken:         resumeToken,
 		rootDaemon:          rootDaemon,
 		localIntercepts:     map[string]string{},
 		currentInterceptors: map[string]int{},
+		offeredIntercepts:   map[string]*manager.InterceptInfo{},
 		wlWatcher:           newWASWatcher(),
+		managerWarnings:     managerWarnings,
+		servedConfig:        servedConfig,
 	}, nil
 }
 
+// identityToken returns the token that identifies the user to a traffic-manager that verifies the identities of its
+// clients; the TELEPRESENCE_IDENTITY_TOKEN, or the bearer token of the kubeconfig.
+func identityToken(c context.Context, cluster *k8s.Cluster) (string, error) {
+	if env := client.GetEnv(c); env != nil && env.IdentityToken != "" {
+		return env.IdentityToken, nil
+	}
+	token, err := cluster.BearerToken(c)
+	if err != nil {
+		return "", fmt.Errorf("%w. Set TELEPRESENCE_IDENTITY_TOKEN to a token that identifies you", err)
+	}
+	return token, nil
+}
+
 func connectError(t rpc.ConnectInfo_ErrType, err error) *rpc.ConnectInfo {
 	return &rpc.ConnectInfo{
 		Error:         t,
@@ -459,17 +651,38 @@ func (tm *TrafficManager) updateDaemonNamespaces(c context.Context) {
 	// Avoid being locked for the remainder of this function.
 	tm.insLock.Unlock()
 	sort.Strings(namespaces)
+	namespaces = tm.appendConnectedNamespace(namespaces)
+	if tm.rootDaemon == nil {
+		return
+	}
 
 	// Pass current mapped namespaces as plain names (no ending dot). The DNS-resolver will
 	// create special mapping for those, allowing names like myservice.mynamespace to be resolved
 	paths := tm.GetCurrentNamespaces(false)
 	dlog.Debugf(c, "posting search paths %v and namespaces %v", paths, namespaces)
-	if _, err := tm.rootDaemon.SetDnsSearchPath(c, &daemon.Paths{Paths: paths, Namespaces: namespaces}); err != nil {
+	rc := client.WithRootDaemonSession(c, tm.sessionInfo.SessionId)
+	if _, err := tm.rootDaemon.SetDnsSearchPath(rc, &daemon.Paths{Paths: paths, Namespaces: namespaces}); err != nil {
 		dlog.Errorf(c, "error posting search paths %v and namespaces %v to root daemon: %v", paths, namespaces, err)
 	}
 	dlog.Debug(c, "search paths posted successfully")
 }
 
+// appendConnectedNamespace appends the connected namespace to the given namespaces, so that single-label names
+// are resolved in the intercepted namespaces first, and then in the connected namespace. The connected namespace
+// is only appended when it's mapped and accessible.
+func (tm *TrafficManager) appendConnectedNamespace(namespaces []string) []string {
+	cns := tm.ActualNamespace("")
+	if cns == "" {
+		return namespaces
+	}
+	for _, ns := range namespaces {
+		if ns == cns {
+			return namespaces
+		}
+	}
+	return append(namespaces, cns)
+}
+
 // Run (1) starts up with ensuring that the manager is installed and running,
 // but then for most of its life
 //  - (2) calls manager.ArriveAsClient and then periodically calls manager.Remain
@@ -485,6 +698,7 @@ func (tm *TrafficManager) Run(c context.Context) error {
 	g.Go("intercept-port-forward", tm.workerPortForwardIntercepts)
 	g.Go("agent-watcher", tm.agentInfoWatcher)
 	g.Go("dial-request-watcher", tm.dialRequestWatcher)
+	g.Go("served-config-watcher", tm.servedConfigWatcher)
 	for _, svc := range tm.sessionServices {
 		func(svc SessionService) {
 			dlog.Infof(c, "Starting additional session service %s", svc.Name())
@@ -501,6 +715,7 @@ func (tm *TrafficManager) session() *manager.SessionInfo {
 }
 
 // getInfosForWorkloads returns a list of workloads found in the given namespace that fulfils the given filter criteria.
+// The workloads are taken from the user cache when the workload watchers of the namespaces haven't synced yet.
 func (tm *TrafficManager) getInfosForWorkloads(
 	ctx context.Context,
 	namespaces []string,
@@ -508,7 +723,52 @@ func (tm *TrafficManager) getInfosForWorkloads(
 	aMap map[string]*manager.AgentInfo,
 	filter rpc.ListRequest_Filter,
 ) ([]*rpc.WorkloadInfo, error) {
-	wiMap := make(map[types.UID]*rpc.WorkloadInfo)
+	clusterID := tm.GetClusterId(ctx)
+	var wlsByNs map[string][]*rpc.WorkloadInfo
+	if !tm.wlWatcher.hasSynced(namespaces) {
+		var ok bool
+		if wlsByNs, ok = tm.wlCache.get(ctx, clusterID, namespaces); ok {
+			dlog.Debugf(ctx, "Using cached workloads for namespaces %v while the workload watchers sync", namespaces)
+		} else {
+			tm.wlWatcher.waitForSync(ctx)
+		}
+	}
+	if wlsByNs == nil {
+		var err error
+		if wlsByNs, err = tm.discoverWorkloads(ctx, namespaces); err != nil {
+			return nil, err
+		}
+		if tm.wlWatcher.hasSynced(namespaces) {
+			tm.wlCache.update(ctx, clusterID, wlsByNs)
+		}
+	}
+
+	var wiz []*rpc.WorkloadInfo
+	for _, ns := range namespaces {
+		for _, wl := range wlsByNs[ns] {
+			wlInfo := proto.Clone(wl).(*rpc.WorkloadInfo)
+			var ok bool
+			if wlInfo.InterceptInfos, ok = iMap[wl.Name]; !ok && filter <= rpc.ListRequest_INTERCEPTS {
+				continue
+			}
+			if wlInfo.AgentInfo, ok = aMap[wl.Name]; !ok && filter <= rpc.ListRequest_INSTALLED_AGENTS {
+				continue
+			}
+			wiz = append(wiz, wlInfo)
+		}
+	}
+	sort.Slice(wiz, func(i, j int) bool { return wiz[i].Name < wiz[j].Name })
+	return wiz, nil
+}
+
+// discoverWorkloads returns the workloads that the workload watchers have found in each of the given namespaces.
+// The returned workloads have no intercept or agent info.
+func (tm *TrafficManager) discoverWorkloads(ctx context.Context, namespaces []string) (map[string][]*rpc.WorkloadInfo, error) {
+	wlsByNs := make(map[string][]*rpc.WorkloadInfo, len(namespaces))
+	for _, ns := range namespaces {
+		wlsByNs[ns] = []*rpc.WorkloadInfo{}
+	}
+	found := make(map[types.UID]struct{})
 	var err error
 	tm.wlWatcher.eachService(ctx, namespaces, func(svc *core.Service) {
 		var wls []k8sapi.Workload
@@ -516,7 +776,7 @@ func (tm *TrafficManager) getInfosForWorkloads(
 			return
 		}
 		for _, workload := range wls {
-			if _, ok := wiMap[workload.GetUID()]; ok {
+			if _, ok := found[workload.GetUID()]; ok {
 				continue
 			}
 			name := workload.GetName()
@@ -528,9 +788,11 @@ func (tm *TrafficManager) getInfosForWorkloads(
 					Port: p.Port,
 				})
 			}
-			wlInfo := &rpc.WorkloadInfo{
+			found[workload.GetUID()] = struct{}{}
+			ns := workload.GetNamespace()
+			wlsByNs[ns] = append(wlsByNs[ns], &rpc.WorkloadInfo{
 				Name:                 name,
-				Namespace:            workload.GetNamespace(),
+				Namespace:            ns,
 				WorkloadResourceType: workload.GetKind(),
 				Uid:                  string(workload.GetUID()),
 				Service: &rpc.WorkloadInfo_ServiceReference{
@@ -539,25 +801,16 @@ func (tm *TrafficManager) getInfosForWorkloads(
 					Uid:       string(svc.UID),
 					Ports:     ports,
 				},
-			}
-			var ok bool
-			if wlInfo.InterceptInfos, ok = iMap[name]; !ok && filter <= rpc.ListRequest_INTERCEPTS {
-				continue
-			}
-			if wlInfo.AgentInfo, ok = aMap[name]; !ok && filter <= rpc.ListRequest_INSTALLED_AGENTS {
-				continue
-			}
-			wiMap[workload.GetUID()] = wlInfo
+			})
 		}
 	})
-	wiz := make([]*rpc.WorkloadInfo, len(wiMap))
-	i := 0
-	for _, wi := range wiMap {
-		wiz[i] = wi
-		i++
+	if err != nil {
+		return nil, err
 	}
-	sort.Slice(wiz, func(i, j int) bool { return wiz[i].Name < wiz[j].Name })
-	return wiz, nil
+	for _, wls := range wlsByNs {
+		sort.Slice(wls, func(i, j int) bool { return wls[i].Name < wls[j].Name })
+	}
+	return wlsByNs, nil
 }
 
 func (tm *TrafficManager) waitForSync(ctx context.Context) {
@@ -606,10 +859,60 @@ func (tm *TrafficManager) WorkloadInfoSnapshot(
 	filter rpc.ListRequest_Filter,
 	includeLocalIntercepts bool,
 ) (*rpc.WorkloadInfoSnapshot, error) {
-	tm.waitForSync(ctx)
+	// The workload watchers aren't waited for here. That's done by getInfosForWorkloads unless it finds the
+	// workloads in the user cache.
+	tm.WaitForNSSync(ctx)
+	tm.wlWatcher.setNamespacesToWatch(ctx, tm.GetCurrentNamespaces(true))
 	return tm.workloadInfoSnapshot(ctx, namespaces, filter, includeLocalIntercepts)
 }
 
+// PeerWorkloadInfoSnapshot returns the workloads of the given namespace in the given peered cluster, as listed by
+// the traffic-manager of that cluster, together with the intercepts that this client has in them. The namespaces of
+// a peered cluster aren't mapped, so an empty namespace means the connected namespace.
+func (tm *TrafficManager) PeerWorkloadInfoSnapshot(
+	ctx context.Context,
+	cluster, namespace string,
+	filter rpc.ListRequest_Filter,
+) (*rpc.WorkloadInfoSnapshot, error) {
+	if tm.managerVersion.LT(firstFederationVersion) {
+		return nil, errcat.User.Newf("traffic-manager version %s cannot list peered clusters, version %s or later is required",
+			tm.managerVersion, firstFederationVersion)
+	}
+	if namespace == "" {
+		namespace = tm.ConnectedNamespace()
+	}
+	wls, err := tm.managerClient.ListWorkloads(ctx, &manager.ListWorkloadsRequest{Session: tm.session(), Namespace: namespace, Cluster: cluster})
+	if err != nil {
+		if st := status.Convert(err); st.Code() == codes.InvalidArgument {
+			return nil, errcat.User.New(st.Message())
+		}
+		return nil, err
+	}
+	is := tm.getCurrentIntercepts()
+	infos := make([]*rpc.WorkloadInfo, 0, len(wls.Workloads))
+	for _, wl := range wls.Workloads {
+		info := &rpc.WorkloadInfo{
+			Name:                 wl.Name,
+			Namespace:            wl.Namespace,
+			WorkloadResourceType: wl.Kind,
+			AgentInfo:            wl.AgentInfo,
+		}
+		for _, ii := range is {
+			if ii.Spec.Cluster == cluster && ii.Spec.Namespace == wl.Namespace && ii.Spec.Agent == wl.Name {
+				info.InterceptInfos = append(info.InterceptInfos, ii)
+			}
+		}
+		if len(info.InterceptInfos) == 0 && filter <= rpc.ListRequest_INTERCEPTS {
+			continue
+		}
+		if info.AgentInfo == nil && filter <= rpc.ListRequest_INSTALLED_AGENTS {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return &rpc.WorkloadInfoSnapshot{Workloads: infos}, nil
+}
+
 func (tm *TrafficManager) ensureWatchers(ctx context.Context,
 	namespaces []string) {
 	// If a watcher is started, we better wait for the next snapshot from WatchAgentsNS
@@ -625,7 +928,7 @@ func (tm *TrafficManager) ensureWatchers(ctx context.Context,
 		if ns == "" {
 			// Don't use tm.ActualNamespace here because the accessibility of the namespace
 			// is actually determined once the watcher starts
-			ns = tm.Namespace
+			ns = tm.ConnectedNamespace()
 		}
 		tm.wlWatcher.ensureStarted(ctx, ns, func(started bool) {
 			if started {
@@ -660,7 +963,9 @@ func (tm *TrafficManager) workloadInfoSnapshot(
 		// intercepts.
 		nsMap := make(map[string]struct{})
 		for _, i := range is {
-			nsMap[i.Spec.Namespace] = struct{}{}
+			if i.Spec.Cluster == "" {
+				nsMap[i.Spec.Namespace] = struct{}{}
+			}
 		}
 		for _, ns := range tm.localIntercepts {
 			nsMap[ns] = struct{}{}
@@ -689,6 +994,10 @@ func (tm *TrafficManager) workloadInfoSnapshot(
 	iMap := make(map[string][]*manager.InterceptInfo, len(is))
 nextIs:
 	for _, i := range is {
+		if i.Spec.Cluster != "" {
+			// Listed by PeerWorkloadInfoSnapshot
+			continue
+		}
 		for _, ns := range nss {
 			if i.Spec.Namespace == ns {
 				iMap[i.Spec.Agent] = append(iMap[i.Spec.Agent], i)
@@ -727,8 +1036,11 @@ nextIs:
 
 var SessionExpiredErr = errors.New("session expired")
 
+// remainInterval is the interval at which the session tells the traffic-manager that it remains.
+var remainInterval = 5 * time.Second
+
 func (tm *TrafficManager) remain(c context.Context) error {
-	ticker := time.NewTicker(5 * time.Second)
+	ticker := time.NewTicker(remainInterval)
 	defer func() {
 		ticker.Stop()
 		c = dcontext.WithoutCancel(c)
@@ -757,7 +1069,11 @@ func (tm *TrafficManager) remain(c context.Context) error {
 			if err != nil && c.Err() == nil {
 				dlog.Error(c, err)
 				if gErr, ok := status.FromError(err); ok && gErr.Code() == codes.NotFound {
-					// Session has expired. We need to cancel the owner session and reconnect
+					// Session has expired, typically because this machine has been asleep. Try to resume it, and
+					// if that fails, cancel the owner session and reconnect
+					if tm.resumeSession(c) {
+						continue
+					}
 					return SessionExpiredErr
 				}
 			}
@@ -765,12 +1081,39 @@ func (tm *TrafficManager) remain(c context.Context) error {
 	}
 }
 
+// resumeSession asks the traffic-manager to resume the session after it has expired. The traffic-manager
+// recreates the intercepts that the session had, so they are picked up again by the intercept watcher.
+func (tm *TrafficManager) resumeSession(c context.Context) bool {
+	if _, err := tm.managerClient.ResumeSession(c, &manager.ResumeSessionRequest{
+		Session:     tm.session(),
+		ResumeToken: tm.resumeToken,
+	}); err != nil {
+		dlog.Errorf(c, "unable to resume session: %v", err)
+		return false
+	}
+	dlog.Infof(c, "Session %s resumed", tm.session().SessionId)
+	return true
+}
+
+// newResumeToken returns a random secret that is used as the resume token of a session.
+func newResumeToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
 func (tm *TrafficManager) UpdateStatus(c context.Context, cr *rpc.ConnectRequest) *rpc.ConnectInfo {
 	config, err := k8s.NewConfig(c, cr.KubeFlags)
 	if err != nil {
 		return connectError(rpc.ConnectInfo_CLUSTER_FAILED, err)
 	}
 	if !tm.Config.ContextServiceAndFlagsEqual(config) {
+		// Creating the new config changed the KUBECONFIG of this session.
+		if err := tm.Config.RestoreKubeconfigEnv(); err != nil {
+			return connectError(rpc.ConnectInfo_CLUSTER_FAILED, err)
+		}
 		return &rpc.ConnectInfo{
 			Error:          rpc.ConnectInfo_MUST_RESTART,
 			ClusterContext: tm.Config.Context,
@@ -784,18 +1127,26 @@ func (tm *TrafficManager) UpdateStatus(c context.Context, cr *rpc.ConnectRequest
 		tm.ingressInfo = nil
 		tm.insLock.Unlock()
 	}
+	if tm.SetConnectedNamespace(config.Namespace) {
+		dlog.Infof(c, "Connected namespace changed to %q", config.Namespace)
+		tm.updateDaemonNamespaces(c)
+	}
 	return tm.Status(c)
 }
 
 func (tm *TrafficManager) Status(c context.Context) *rpc.ConnectInfo {
 	cfg := tm.Config
 	ret := &rpc.ConnectInfo{
-		Error:          rpc.ConnectInfo_ALREADY_CONNECTED,
-		ClusterContext: cfg.Context,
-		ClusterServer:  cfg.Server,
-		ClusterId:      tm.GetClusterId(c),
-		SessionInfo:    tm.session(),
-		Intercepts:     &manager.InterceptInfoSnapshot{Intercepts: tm.getCurrentIntercepts()},
+		Error:            rpc.ConnectInfo_ALREADY_CONNECTED,
+		ClusterContext:   cfg.Context,
+		ClusterServer:    cfg.Server,
+		ClusterId:        tm.GetClusterId(c),
+		SessionInfo:      tm.session(),
+		Intercepts:       &manager.InterceptInfoSnapshot{Intercepts: tm.getCurrentIntercepts()},
+		DockerDnsIp:      tm.dockerDNSIP,
+		NetNamespace:     tm.netNamespace,
+		Namespace:        tm.ConnectedNamespace(),
+		MappedNamespaces: tm.MappedNamespaces(),
 	}
 	return ret
 }
@@ -929,7 +1280,7 @@ func (tm *TrafficManager) Uninstall(ctx context.Context, ur *rpc.UninstallReques
 		// must have a valid namespace in order to uninstall named agents
 		tm.waitForSync(ctx)
 		if ur.Namespace == "" {
-			ur.Namespace = tm.Namespace
+			ur.Namespace = tm.ConnectedNamespace()
 		}
 		tm.wlWatcher.ensureStarted(ctx, ur.Namespace, nil)
 		namespace := tm.ActualNamespace(ur.Namespace)
@@ -983,7 +1334,7 @@ func (tm *TrafficManager) Uninstall(ctx context.Context, ur *rpc.UninstallReques
 	if ur.Namespace != "" {
 		tm.waitForSync(ctx)
 		if ur.Namespace == "" {
-			ur.Namespace = tm.Namespace
+			ur.Namespace = tm.ConnectedNamespace()
 		}
 		tm.wlWatcher.ensureStarted(ctx, ur.Namespace, nil)
 		namespace := tm.ActualNamespace(ur.Namespace)
@@ -1004,6 +1355,31 @@ func (tm *TrafficManager) Uninstall(ctx context.Context, ur *rpc.UninstallReques
 	return result(nil)
 }
 
+// pathMTU is iputil.PathMTU. It's a variable so that tests can replace it.
+var pathMTU = iputil.PathMTU
+
+// tunMTU returns the MTU that the root daemon should use for the TUN device, or zero if it should use its default.
+// The MTU is lowered when the path to the API server, and hence to the traffic-manager, has a smaller MTU than the
+// default, e.g. because it goes through a VPN. The root daemon clamps the MSS of the tunneled connections to the MTU.
+func tunMTU(ctx context.Context, serverIPs []net.IP) int32 {
+	if mtu := client.GetConfig(ctx).Tunnel.MTU; mtu > 0 {
+		return int32(mtu)
+	}
+	for _, ip := range serverIPs {
+		mtu, err := pathMTU(ip)
+		if err != nil {
+			dlog.Debugf(ctx, "Unable to determine the MTU of the path to %s: %v", ip, err)
+			continue
+		}
+		if mtu < buffer.DataPool.MTU {
+			dlog.Infof(ctx, "Using MTU %d for the TUN device, because that's the MTU of the path to the API server at %s", mtu, ip)
+			return int32(mtu)
+		}
+		break
+	}
+	return 0
+}
+
 // getClusterCIDRs finds the service CIDR and the pod CIDRs of all nodes in the cluster
 func (tm *TrafficManager) getOutboundInfo(ctx context.Context) *daemon.OutboundInfo {
 	// We'll figure out the IP address of the API server(s) so that we can tell the daemon never to proxy them.
@@ -1012,6 +1388,7 @@ func (tm *TrafficManager) getOutboundInfo(ctx context.Context) *daemon.OutboundI
 	// the cluster, since an open tunnel to the traffic-manager (via the API server) is itself required
 	// to communicate with the cluster.
 	neverProxy := []*manager.IPNet{}
+	var serverIPs []net.IP
 	url, err := url.Parse(tm.Server)
 	if err != nil {
 		// This really shouldn't happen as we are connected to the server
@@ -1028,6 +1405,7 @@ func (tm *TrafficManager) getOutboundInfo(ctx context.Context) *daemon.OutboundI
 				ips = []net.IP{}
 			}
 		}
+		serverIPs = ips
 		for _, ip := range ips {
 			mask := net.CIDRMask(128, 128)
 			if ipv4 := ip.To4(); ipv4 != nil {
@@ -1044,6 +1422,9 @@ func (tm *TrafficManager) getOutboundInfo(ctx context.Context) *daemon.OutboundI
 	info := &daemon.OutboundInfo{
 		Session:           tm.sessionInfo,
 		NeverProxySubnets: neverProxy,
+		ConnectorSocket:   client.ConnectorSocketName(ctx),
+		Mtu:               tunMTU(ctx, serverIPs),
+		MinimizeSubnets:   tm.MinimizeSubnets,
 	}
 
 	if tm.DNS != nil {
@@ -1066,5 +1447,12 @@ func (tm *TrafficManager) getOutboundInfo(ctx context.Context) *daemon.OutboundI
 			info.AlsoProxySubnets[i] = iputil.IPNetToRPC((*net.IPNet)(ap))
 		}
 	}
+
+	if len(tm.AllowConflictingSubnets) > 0 {
+		info.AllowConflictingSubnets = make([]*manager.IPNet, len(tm.AllowConflictingSubnets))
+		for i, ac := range tm.AllowConflictingSubnets {
+			info.AllowConflictingSubnets[i] = iputil.IPNetToRPC((*net.IPNet)(ac))
+		}
+	}
 	return info
 }
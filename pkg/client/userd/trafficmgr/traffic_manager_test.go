@@ -0,0 +1,52 @@
+package trafficmgr
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+func TestTunMTU(t *testing.T) {
+	saved := pathMTU
+	defer func() {
+		pathMTU = saved
+	}()
+	pathMTUs := map[string]int{"10.0.0.1": 1500, "10.0.0.2": 1380}
+	pathMTU = func(ip net.IP) (int, error) {
+		if mtu, ok := pathMTUs[ip.String()]; ok {
+			return mtu, nil
+		}
+		return 0, errors.New("no route")
+	}
+
+	ctx := dlog.NewTestContext(t, false)
+	cfg := client.GetDefaultConfig()
+	ctx = client.WithConfig(ctx, &cfg)
+	tests := []struct {
+		name string
+		ips  []string
+		want int32
+	}{
+		{"default MTU", []string{"10.0.0.1"}, 0},
+		{"smaller path MTU", []string{"10.0.0.2"}, 1380},
+		{"first path that is found", []string{"10.0.0.3", "10.0.0.2", "10.0.0.1"}, 1380},
+		{"no path", []string{"10.0.0.3"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ips := make([]net.IP, len(tt.ips))
+			for i, ip := range tt.ips {
+				ips[i] = net.ParseIP(ip)
+			}
+			assert.Equal(t, tt.want, tunMTU(ctx, ips))
+		})
+	}
+
+	cfg.Tunnel.MTU = 1200
+	assert.Equal(t, int32(1200), tunMTU(ctx, []net.IP{net.ParseIP("10.0.0.2")}), "the configured MTU takes precedence")
+}
@@ -0,0 +1,43 @@
+package trafficmgr
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+func TestInterceptTraffic(t *testing.T) {
+	tm := &TrafficManager{}
+	spec := &manager.InterceptSpec{TargetHost: "127.0.0.1", TargetPort: 8080}
+	assert.Equal(t, TrafficStats{}, tm.InterceptTraffic(spec))
+
+	id := tunnel.NewConnID(ipproto.TCP, net.IP{10, 0, 0, 1}, net.IP{127, 0, 0, 1}, 34567, 8080)
+	local, remote := net.Pipe()
+	defer remote.Close()
+	conn := tm.traffic.observe(id, local)
+	go func() {
+		buf := make([]byte, 5)
+		_, _ = io.ReadFull(remote, buf)
+		_, _ = remote.Write([]byte("hi"))
+	}()
+	_, err := conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+
+	assert.Equal(t, TrafficStats{Connections: 1, ActiveConnections: 1, BytesIn: 5, BytesOut: 2}, tm.InterceptTraffic(spec))
+	require.NoError(t, conn.Close())
+	_ = conn.Close()
+	assert.Equal(t, TrafficStats{Connections: 1, BytesIn: 5, BytesOut: 2}, tm.InterceptTraffic(spec))
+
+	// Other targets are counted separately
+	assert.Equal(t, TrafficStats{}, tm.InterceptTraffic(&manager.InterceptSpec{TargetHost: "127.0.0.1", TargetPort: 8081}))
+}
@@ -0,0 +1,200 @@
+package trafficmgr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blang/semver"
+	"gopkg.in/yaml.v3"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/strings/slices"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/datawire/dlib/dtime"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/agentconfig"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+type UpgradeAgentsStream interface {
+	Send(*rpc.UpgradeAgentProgress) error
+}
+
+// UpgradeAgents rolls out the workloads whose agents have a version that differs from the traffic-manager's version.
+// The workloads are rolled out one at a time, and the next one isn't started until all agents of the previous one
+// have been replaced.
+//
+// Just like the removal of agents, this is deliberately done in the client instead of the traffic-manager so that
+// RBAC can be configured to prevent the clients from doing it.
+func (tm *TrafficManager) UpgradeAgents(ctx context.Context, ur *rpc.UpgradeAgentsRequest, stream UpgradeAgentsStream) error {
+	if tm.managerVersion.LT(firstAgentConfigMapVersion) {
+		return errcat.User.Newf("the agents of traffic-manager version %s cannot be upgraded, uninstall them instead", tm.managerVersion)
+	}
+	tm.waitForSync(ctx)
+	if ur.Namespace == "" {
+		ur.Namespace = tm.ConnectedNamespace()
+	}
+	tm.wlWatcher.ensureStarted(ctx, ur.Namespace, nil)
+	namespace := tm.ActualNamespace(ur.Namespace)
+	if namespace == "" {
+		return errcat.User.Newf("namespace %s is not mapped", ur.Namespace)
+	}
+
+	mv := tm.managerVersion.String()
+	progress := func(ai *manager.AgentInfo, phase rpc.UpgradeAgentProgress_Phase, err error) error {
+		p := &rpc.UpgradeAgentProgress{
+			Name:           ai.Name,
+			Namespace:      ai.Namespace,
+			Version:        strings.TrimPrefix(ai.Version, "v"),
+			ManagerVersion: mv,
+			Phase:          phase,
+		}
+		if err != nil {
+			p.ErrorText = err.Error()
+			p.ErrorCategory = int32(errcat.GetCategory(err))
+		}
+		return stream.Send(p)
+	}
+
+	agents := outdatedAgents(tm.getCurrentAgents(), namespace, ur.Agents, tm.managerVersion)
+	for _, name := range ur.Agents {
+		if !hasAgent(tm.getCurrentAgents(), name, namespace) {
+			ai := &manager.AgentInfo{Name: name, Namespace: namespace}
+			if err := progress(ai, rpc.UpgradeAgentProgress_FAILED, errcat.User.Newf("no agent found for %s.%s", name, namespace)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, ai := range agents {
+		if err := progress(ai, rpc.UpgradeAgentProgress_OUTDATED, nil); err != nil {
+			return err
+		}
+	}
+	if ur.DryRun {
+		return nil
+	}
+
+	for _, ai := range agents {
+		if err := progress(ai, rpc.UpgradeAgentProgress_UPGRADING, nil); err != nil {
+			return err
+		}
+		nai, err := tm.upgradeAgent(ctx, ai)
+		if err != nil {
+			dlog.Errorf(ctx, "unable to upgrade agent %s.%s: %v", ai.Name, ai.Namespace, err)
+			err = progress(ai, rpc.UpgradeAgentProgress_FAILED, err)
+		} else {
+			err = progress(nai, rpc.UpgradeAgentProgress_UPGRADED, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upgradeAgent makes the traffic-manager regenerate the agent config of the given agent's workload, which
+// rolls out the workload, and then waits until all agents of the workload have been replaced. The returned
+// info is one of the new agents.
+func (tm *TrafficManager) upgradeAgent(ctx context.Context, ai *manager.AgentInfo) (*manager.AgentInfo, error) {
+	cmAPI := k8sapi.GetK8sInterface(ctx).CoreV1().ConfigMaps(ai.Namespace)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := cmAPI.Get(ctx, agentconfig.ConfigMap, meta.GetOptions{})
+		if err != nil {
+			if k8serrors.IsNotFound(err) {
+				err = errcat.User.Newf("the %s ConfigMap in namespace %s has no entry for %s", agentconfig.ConfigMap, ai.Namespace, ai.Name)
+			}
+			return err
+		}
+		y, ok := cm.Data[ai.Name]
+		if !ok {
+			return errcat.User.Newf("the %s ConfigMap in namespace %s has no entry for %s", agentconfig.ConfigMap, ai.Namespace, ai.Name)
+		}
+		var ac agentconfig.Sidecar
+		if err = yaml.Unmarshal([]byte(y), &ac); err != nil {
+			return fmt.Errorf("failed to parse entry for %s in ConfigMap %s.%s: %w", ai.Name, agentconfig.ConfigMap, ai.Namespace, err)
+		}
+		if ac.Manual {
+			return errcat.User.Newf("the agent of %s.%s is manually injected, so its image must be updated in the workload", ai.Name, ai.Namespace)
+		}
+
+		// An entry that is marked for creation is regenerated by the traffic-manager, and the workload is
+		// rolled out when that's done.
+		ac.Create = true
+		bf := bytes.Buffer{}
+		if err = yaml.NewEncoder(&bf).Encode(&ac); err != nil {
+			return err
+		}
+		cm.Data[ai.Name] = bf.String()
+		_, err = cmAPI.Update(ctx, cm, meta.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := client.GetConfig(ctx).Timeouts.TimeoutContext(ctx, client.TimeoutAgentInstall)
+	defer cancel()
+	for {
+		var nai *manager.AgentInfo
+		replaced := true
+		for _, a := range tm.getCurrentAgents() {
+			if a.Name == ai.Name && a.Namespace == ai.Namespace {
+				if a.Version == ai.Version {
+					replaced = false
+					break
+				}
+				nai = a
+			}
+		}
+		if replaced && nai != nil {
+			return nai, nil
+		}
+		dtime.SleepWithContext(ctx, time.Second)
+		if ctx.Err() != nil {
+			return nil, client.CheckTimeout(ctx, fmt.Errorf("waiting for the agents of %s.%s to be replaced", ai.Name, ai.Namespace))
+		}
+	}
+}
+
+// outdatedAgents returns one agent for each workload in the given namespace that has an agent with a version that
+// differs from the given version, sorted by name. Only the named workloads are considered unless names is empty.
+func outdatedAgents(agents []*manager.AgentInfo, namespace string, names []string, version semver.Version) []*manager.AgentInfo {
+	var outdated []*manager.AgentInfo
+	found := make(map[string]struct{})
+	for _, ai := range agents {
+		if ai.Namespace != namespace {
+			continue
+		}
+		if _, ok := found[ai.Name]; ok {
+			continue
+		}
+		if len(names) > 0 && !slices.Contains(names, ai.Name) {
+			continue
+		}
+		if av, err := semver.Parse(strings.TrimPrefix(ai.Version, "v")); err == nil && av.EQ(version) {
+			continue
+		}
+		found[ai.Name] = struct{}{}
+		outdated = append(outdated, ai)
+	}
+	sort.Slice(outdated, func(i, j int) bool { return outdated[i].Name < outdated[j].Name })
+	return outdated
+}
+
+func hasAgent(agents []*manager.AgentInfo, name, namespace string) bool {
+	for _, ai := range agents {
+		if ai.Name == name && ai.Namespace == namespace {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package trafficmgr
+
+import (
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+func Test_outdatedAgents(t *testing.T) {
+	agents := []*manager.AgentInfo{
+		{Name: "echo", Namespace: "default", Version: "v2.6.8"},
+		{Name: "echo", Namespace: "default", Version: "v2.6.8"},
+		{Name: "web", Namespace: "default", Version: "v2.7.0"},
+		{Name: "api", Namespace: "default", Version: "v2.6.5"},
+		{Name: "api", Namespace: "other", Version: "v2.6.5"},
+		{Name: "dev", Namespace: "default", Version: "v2.7.0-alpha.1"},
+	}
+	names := func(ais []*manager.AgentInfo) []string {
+		ns := make([]string, len(ais))
+		for i, ai := range ais {
+			ns[i] = ai.Name
+		}
+		return ns
+	}
+	v := semver.MustParse("2.7.0")
+	assert.Equal(t, []string{"api", "dev", "echo"}, names(outdatedAgents(agents, "default", nil, v)))
+	assert.Equal(t, []string{"echo"}, names(outdatedAgents(agents, "default", []string{"echo", "web"}, v)))
+	assert.Equal(t, []string{"api"}, names(outdatedAgents(agents, "other", nil, v)))
+	assert.Empty(t, outdatedAgents(agents, "default", []string{"web"}, v))
+}
@@ -0,0 +1,88 @@
+package trafficmgr
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
+)
+
+// workloadCache is the set of workloads that was discovered in each namespace of a cluster. It's persisted in the
+// user cache, so that a new session can list the workloads before its watchers have synced, which may take a long
+// time on clusters with thousands of objects. The cached workloads have no intercept or agent info.
+type workloadCache struct {
+	sync.Mutex
+	loaded    bool
+	workloads map[string][]*rpc.WorkloadInfo
+}
+
+// load reads the cache of the given cluster unless it has been loaded already. Must be called with the lock held.
+func (wc *workloadCache) load(ctx context.Context, clusterID string) {
+	if wc.loaded {
+		return
+	}
+	wc.loaded = true
+	var err error
+	if wc.workloads, err = cache.LoadWorkloadsFromUserCache(ctx, clusterID); err != nil {
+		dlog.Warnf(ctx, "unable to load the cached workloads: %v", err)
+		wc.workloads = make(map[string][]*rpc.WorkloadInfo)
+	}
+}
+
+// get returns the cached workloads of the given namespaces, and false if any of those namespaces isn't cached.
+func (wc *workloadCache) get(ctx context.Context, clusterID string, namespaces []string) (map[string][]*rpc.WorkloadInfo, bool) {
+	if clusterID == "" {
+		return nil, false
+	}
+	wc.Lock()
+	defer wc.Unlock()
+	wc.load(ctx, clusterID)
+	wls := make(map[string][]*rpc.WorkloadInfo, len(namespaces))
+	for _, ns := range namespaces {
+		nwls, ok := wc.workloads[ns]
+		if !ok {
+			return nil, false
+		}
+		wls[ns] = nwls
+	}
+	return wls, true
+}
+
+// update replaces the cached workloads of the namespaces of the given map, and persists the cache if that
+// changed it.
+func (wc *workloadCache) update(ctx context.Context, clusterID string, wls map[string][]*rpc.WorkloadInfo) {
+	if clusterID == "" {
+		return
+	}
+	wc.Lock()
+	defer wc.Unlock()
+	wc.load(ctx, clusterID)
+	changed := false
+	for ns, nwls := range wls {
+		if !workloadInfosEqual(wc.workloads[ns], nwls) {
+			wc.workloads[ns] = nwls
+			changed = true
+		}
+	}
+	if changed {
+		if err := cache.SaveWorkloadsToUserCache(ctx, clusterID, wc.workloads); err != nil {
+			dlog.Warnf(ctx, "unable to save the workloads to the user cache: %v", err)
+		}
+	}
+}
+
+func workloadInfosEqual(a, b []*rpc.WorkloadInfo) bool {
+	if a == nil || len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !proto.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package trafficmgr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+func TestWorkloadCache(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	// Create a fake user cache directory
+	ctx = filelocation.WithUserHomeDir(ctx, t.TempDir())
+
+	const clusterID = "5f2fca2b-8a1d-4d3c-9a0e-37b0b99a5a0d"
+	echo := &rpc.WorkloadInfo{
+		Name:                 "echo",
+		Namespace:            "default",
+		WorkloadResourceType: "Deployment",
+		Uid:                  "1234",
+		Service: &rpc.WorkloadInfo_ServiceReference{
+			Name:      "echo",
+			Namespace: "default",
+			Uid:       "5678",
+			Ports:     []*rpc.WorkloadInfo_ServiceReference_Port{{Name: "http", Port: 80}},
+		},
+	}
+
+	wc := &workloadCache{}
+	_, ok := wc.get(ctx, clusterID, []string{"default"})
+	assert.False(t, ok)
+
+	wc.update(ctx, clusterID, map[string][]*rpc.WorkloadInfo{
+		"default": {echo},
+		"empty":   {},
+	})
+
+	// A new cache, i.e. a new session, loads the workloads from the user cache
+	wc = &workloadCache{}
+	wls, ok := wc.get(ctx, clusterID, []string{"default", "empty"})
+	require.True(t, ok)
+	require.Len(t, wls["default"], 1)
+	assert.True(t, proto.Equal(echo, wls["default"][0]))
+	assert.Empty(t, wls["empty"])
+
+	_, ok = wc.get(ctx, clusterID, []string{"default", "other"})
+	assert.False(t, ok, "namespaces that aren't cached must be discovered")
+
+	// The cache is kept per cluster
+	wc = &workloadCache{}
+	_, ok = wc.get(ctx, "another-cluster", []string{"default"})
+	assert.False(t, ok)
+	_, ok = wc.get(ctx, "", []string{"default"})
+	assert.False(t, ok)
+}
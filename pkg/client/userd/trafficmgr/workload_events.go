@@ -0,0 +1,100 @@
+package trafficmgr
+
+import (
+	"context"
+	"sort"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+// WatchWorkloadEvents streams the interceptable workloads that are added, modified, or deleted in the given
+// namespaces. The first delta adds all the workloads that exist when the watch starts.
+func (tm *TrafficManager) WatchWorkloadEvents(c context.Context, wr *rpc.WatchWorkloadsRequest, stream WatchWorkloadEventsStream) error {
+	tm.waitForSync(c)
+	tm.ensureWatchers(c, wr.Namespaces)
+	sCtx, sCancel := context.WithCancel(c)
+	// The subscription must end when we leave this method, or the goroutine that writes to the channel will leak.
+	defer sCancel()
+	snapshotAvailable := tm.wlWatcher.subscribe(sCtx)
+
+	var current map[string]*rpc.WorkloadInfo
+	sendDelta := func() error {
+		snapshot, err := tm.workloadInfoSnapshot(c, wr.GetNamespaces(), rpc.ListRequest_INTERCEPTABLE, false)
+		if err != nil {
+			return status.Errorf(codes.Unavailable, "failed to create WorkloadInfoSnapshot: %v", err)
+		}
+		next := workloadMap(snapshot.Workloads)
+		events := workloadEvents(current, next)
+		if current != nil && len(events) == 0 {
+			return nil
+		}
+		current = next
+		if err := stream.Send(&rpc.WorkloadEventsDelta{Events: events}); err != nil {
+			dlog.Errorf(c, "WatchWorkloadEvents.Send() failed: %v", err)
+			return err
+		}
+		return nil
+	}
+
+	if err := sendDelta(); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-c.Done():
+			return nil
+		case <-snapshotAvailable:
+			if err := sendDelta(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func workloadMap(wls []*rpc.WorkloadInfo) map[string]*rpc.WorkloadInfo {
+	m := make(map[string]*rpc.WorkloadInfo, len(wls))
+	for _, wl := range wls {
+		m[wl.Namespace+"/"+wl.Name] = wl
+	}
+	return m
+}
+
+// workloadEvents returns the events that turn the workloads of the prev map into those of the next map. The events
+// are ordered like the workloads of the snapshots, i.e. by name, with the deleted workloads last.
+func workloadEvents(prev, next map[string]*rpc.WorkloadInfo) []*rpc.WorkloadEvent {
+	var events []*rpc.WorkloadEvent
+	for _, wl := range sortedWorkloads(next) {
+		pw, ok := prev[wl.Namespace+"/"+wl.Name]
+		switch {
+		case !ok:
+			events = append(events, &rpc.WorkloadEvent{Type: rpc.WorkloadEvent_ADDED, Workload: wl})
+		case !proto.Equal(pw, wl):
+			events = append(events, &rpc.WorkloadEvent{Type: rpc.WorkloadEvent_MODIFIED, Workload: wl})
+		}
+	}
+	for _, wl := range sortedWorkloads(prev) {
+		if _, ok := next[wl.Namespace+"/"+wl.Name]; !ok {
+			events = append(events, &rpc.WorkloadEvent{Type: rpc.WorkloadEvent_DELETED, Workload: wl})
+		}
+	}
+	return events
+}
+
+func sortedWorkloads(m map[string]*rpc.WorkloadInfo) []*rpc.WorkloadInfo {
+	wls := make([]*rpc.WorkloadInfo, 0, len(m))
+	for _, wl := range m {
+		wls = append(wls, wl)
+	}
+	sort.Slice(wls, func(i, j int) bool {
+		if wls[i].Name == wls[j].Name {
+			return wls[i].Namespace < wls[j].Namespace
+		}
+		return wls[i].Name < wls[j].Name
+	})
+	return wls
+}
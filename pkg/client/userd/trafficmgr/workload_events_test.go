@@ -0,0 +1,62 @@
+package trafficmgr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+func Test_workloadEvents(t *testing.T) {
+	wl := func(name string, agent bool) *rpc.WorkloadInfo {
+		wi := &rpc.WorkloadInfo{Name: name, Namespace: "default", WorkloadResourceType: "Deployment"}
+		if agent {
+			wi.AgentInfo = &manager.AgentInfo{Name: name, Namespace: "default"}
+		}
+		return wi
+	}
+	type event struct {
+		tp   rpc.WorkloadEvent_Type
+		name string
+	}
+	tests := []struct {
+		name string
+		prev []*rpc.WorkloadInfo
+		next []*rpc.WorkloadInfo
+		want []event
+	}{
+		{
+			name: "initial",
+			next: []*rpc.WorkloadInfo{wl("b", false), wl("a", false)},
+			want: []event{{rpc.WorkloadEvent_ADDED, "a"}, {rpc.WorkloadEvent_ADDED, "b"}},
+		},
+		{
+			name: "unchanged",
+			prev: []*rpc.WorkloadInfo{wl("a", true)},
+			next: []*rpc.WorkloadInfo{wl("a", true)},
+		},
+		{
+			name: "agent installed",
+			prev: []*rpc.WorkloadInfo{wl("a", false), wl("b", false)},
+			next: []*rpc.WorkloadInfo{wl("a", false), wl("b", true)},
+			want: []event{{rpc.WorkloadEvent_MODIFIED, "b"}},
+		},
+		{
+			name: "added and deleted",
+			prev: []*rpc.WorkloadInfo{wl("a", false), wl("c", false)},
+			next: []*rpc.WorkloadInfo{wl("b", false), wl("c", false)},
+			want: []event{{rpc.WorkloadEvent_ADDED, "b"}, {rpc.WorkloadEvent_DELETED, "a"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []event
+			for _, e := range workloadEvents(workloadMap(tt.prev), workloadMap(tt.next)) {
+				got = append(got, event{e.Type, e.Workload.Name})
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -199,6 +199,18 @@ func (w *workloadsAndServicesWatcher) eachService(c context.Context, namespaces
 	}
 }
 
+// hasSynced returns true when the watchers of all the given namespaces have synced.
+func (w *workloadsAndServicesWatcher) hasSynced(namespaces []string) bool {
+	w.Lock()
+	defer w.Unlock()
+	for _, ns := range namespaces {
+		if nw, ok := w.nsWatchers[ns]; !ok || !nw.hasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
 func (w *workloadsAndServicesWatcher) waitForSync(c context.Context) {
 	hss := make([]cache.InformerSynced, len(w.nsWatchers))
 	w.Lock()
@@ -224,6 +236,12 @@ func (w *workloadsAndServicesWatcher) waitForSync(c context.Context) {
 	}
 }
 
+// notify makes all subscribers produce a new snapshot. It's used when the agents or intercepts of the
+// workloads change.
+func (w *workloadsAndServicesWatcher) notify() {
+	w.cond.Broadcast()
+}
+
 // subscribe writes to the given channel whenever relevant information has changed
 // in the current snapshot
 func (w *workloadsAndServicesWatcher) subscribe(c context.Context) <-chan struct{} {
@@ -0,0 +1,18 @@
+package client
+
+import "context"
+
+// IsWSL2 returns true when running in a WSL2 distribution of a Windows host.
+func IsWSL2() bool {
+	return isWSL2()
+}
+
+// WSLWindowsBinary returns the Windows executable whose daemons the CLI uses instead of its own, or an empty string
+// when the CLI uses its own daemons. It's only set when running in a WSL2 distribution that is configured to use
+// the daemons of the Windows host.
+func WSLWindowsBinary(ctx context.Context) string {
+	if bin := GetConfig(ctx).Daemons.WSLWindowsBinary; bin != "" && IsWSL2() {
+		return bin
+	}
+	return ""
+}
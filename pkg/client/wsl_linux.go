@@ -0,0 +1,17 @@
+package client
+
+import (
+	"os"
+	"strings"
+)
+
+func isWSL2() bool {
+	// The Microsoft kernel of WSL2 has a release like "5.15.90.1-microsoft-standard-WSL2". A custom kernel may lack
+	// the "WSL2" suffix, but only WSL2 provides the interop socket.
+	release, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return false
+	}
+	r := strings.ToLower(string(release))
+	return strings.Contains(r, "microsoft") && (strings.Contains(r, "wsl2") || os.Getenv("WSL_INTEROP") != "")
+}
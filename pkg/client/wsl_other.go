@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package client
+
+func isWSL2() bool {
+	return false
+}
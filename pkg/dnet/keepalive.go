@@ -0,0 +1,51 @@
+package dnet
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+// KeepAliveDialOptions returns the gRPC dial options that make the client ping the server after the given interval
+// of inactivity, and close the connection when a ping isn't acknowledged within the given timeout. A timeout of
+// zero means that the interval is used as the timeout. No options are returned when the interval is zero.
+func KeepAliveDialOptions(interval, timeout time.Duration) []grpc.DialOption {
+	if interval <= 0 {
+		return nil
+	}
+	if timeout <= 0 {
+		timeout = interval
+	}
+	return []grpc.DialOption{grpc.WithKeepaliveParams(keepalive.ClientParameters{
+		Time:                interval,
+		Timeout:             timeout,
+		PermitWithoutStream: true,
+	})}
+}
+
+// NewTCPDialer returns a dialer, suitable for use with grpc.WithContextDialer, that dials TCP connections using the
+// given user timeout. The user timeout is the maximum time that transmitted data may remain unacknowledged before
+// the connection is closed. It detects half-open connections, such as those caused by a dead NAT mapping, within
+// seconds instead of after the minutes that the default retransmission timeout takes. The user timeout is only
+// supported on Linux and is ignored on other platforms.
+func NewTCPDialer(userTimeout time.Duration) func(context.Context, string) (net.Conn, error) {
+	d := &net.Dialer{}
+	if userTimeout > 0 {
+		d.Control = func(_, _ string, c syscall.RawConn) error {
+			var serr error
+			if err := c.Control(func(fd uintptr) {
+				serr = setTCPUserTimeout(fd, userTimeout)
+			}); err != nil {
+				return err
+			}
+			return serr
+		}
+	}
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		return d.DialContext(ctx, "tcp", addr)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package dnet
+
+import (
+	"time"
+)
+
+func setTCPUserTimeout(uintptr, time.Duration) error {
+	return nil
+}
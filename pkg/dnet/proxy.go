@@ -0,0 +1,46 @@
+package dnet
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+
+	"golang.org/x/net/proxy"
+)
+
+// NewProxyDialer returns a dialer, suitable for use with grpc.WithContextDialer, that dials TCP connections through
+// the proxy of the given URL. The socks5 scheme, and the http and https schemes, which use the CONNECT method, are
+// supported. Credentials are taken from the user info of the URL.
+func NewProxyDialer(proxyURL *url.URL) (func(context.Context, string) (net.Conn, error), error) {
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if pu := proxyURL.User; pu != nil {
+			pw, _ := pu.Password()
+			auth = &proxy.Auth{User: pu.Username(), Password: pw}
+		}
+		proxyAddr := proxyURL.Host
+		if proxyURL.Port() == "" {
+			proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "1080")
+		}
+		d, err := proxy.SOCKS5("tcp", proxyAddr, auth, &net.Dialer{})
+		if err != nil {
+			return nil, err
+		}
+		cd := d.(proxy.ContextDialer)
+		return func(ctx context.Context, addr string) (net.Conn, error) {
+			conn, err := cd.DialContext(ctx, "tcp", addr)
+			if err != nil {
+				return nil, fmt.Errorf("unable to dial %s through proxy %s: %w", addr, proxyURL.Redacted(), err)
+			}
+			return conn, nil
+		}, nil
+	case "http", "https":
+		return func(ctx context.Context, addr string) (net.Conn, error) {
+			return dialProxyTunnel(ctx, proxyURL, addr)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy URL scheme %q", proxyURL.Scheme)
+	}
+}
@@ -0,0 +1,212 @@
+package dnet_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/dnet"
+)
+
+func echoServer(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func pipe(a, b net.Conn) {
+	defer a.Close()
+	defer b.Close()
+	go func() { _, _ = io.Copy(a, b) }()
+	_, _ = io.Copy(b, a)
+}
+
+// connectProxy is an HTTP proxy that only permits the CONNECT method and requires basic authentication.
+func connectProxy(t *testing.T, user, password string) string {
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Header.Get("Proxy-Authorization") != auth {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		rc, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			rc.Close()
+			return
+		}
+		_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go pipe(conn, rc)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// socks5Proxy is a SOCKS5 proxy that only permits username/password authentication and the CONNECT command.
+func socks5Proxy(t *testing.T, user, password string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	readString := func(conn net.Conn) string {
+		n := make([]byte, 1)
+		_, _ = io.ReadFull(conn, n)
+		s := make([]byte, n[0])
+		_, _ = io.ReadFull(conn, s)
+		return string(s)
+	}
+	serve := func(conn net.Conn) {
+		hdr := make([]byte, 2)
+		if _, err := io.ReadFull(conn, hdr); err != nil {
+			conn.Close()
+			return
+		}
+		methods := make([]byte, hdr[1])
+		_, _ = io.ReadFull(conn, methods)
+		_, _ = conn.Write([]byte{5, 2}) // username/password
+		_, _ = io.ReadFull(conn, hdr[:1])
+		if readString(conn) != user || readString(conn) != password {
+			_, _ = conn.Write([]byte{1, 1})
+			conn.Close()
+			return
+		}
+		_, _ = conn.Write([]byte{1, 0})
+
+		rq := make([]byte, 4)
+		_, _ = io.ReadFull(conn, rq)
+		var host string
+		switch rq[3] {
+		case 1:
+			ip := make([]byte, 4)
+			_, _ = io.ReadFull(conn, ip)
+			host = net.IP(ip).String()
+		case 3:
+			host = readString(conn)
+		}
+		port := make([]byte, 2)
+		_, _ = io.ReadFull(conn, port)
+		rc, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+		if err != nil {
+			_, _ = conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+			conn.Close()
+			return
+		}
+		_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		pipe(conn, rc)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return "socks5://" + l.Addr().String()
+}
+
+func TestNewProxyDialer(t *testing.T) {
+	echo := echoServer(t)
+	tests := []struct {
+		name     string
+		proxyURL string
+	}{
+		{"http", connectProxy(t, "jane", "secret")},
+		{"socks5", socks5Proxy(t, "jane", "secret")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			u, err := url.Parse(tt.proxyURL)
+			require.NoError(t, err)
+
+			// Without credentials
+			dial, err := dnet.NewProxyDialer(u)
+			require.NoError(t, err)
+			_, err = dial(ctx, echo)
+			assert.Error(t, err)
+
+			u.User = url.UserPassword("jane", "secret")
+			dial, err = dnet.NewProxyDialer(u)
+			require.NoError(t, err)
+			conn, err := dial(ctx, echo)
+			require.NoError(t, err)
+			defer conn.Close()
+			_, err = conn.Write([]byte("hello"))
+			require.NoError(t, err)
+			buf := make([]byte, 5)
+			_, err = io.ReadFull(conn, buf)
+			require.NoError(t, err)
+			assert.Equal(t, "hello", string(buf))
+		})
+	}
+
+	_, err := dnet.NewProxyDialer(&url.URL{Scheme: "ftp", Host: "example.com"})
+	assert.Error(t, err)
+}
+
+func TestNewProxyDialer_dataWithResponse(t *testing.T) {
+	// A proxy that sends the first data from the remote end in the same segment as its response
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rq, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil || rq.Method != http.MethodConnect {
+			return
+		}
+		_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\nSSH-2.0-greeting\r\n"))
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	dial, err := dnet.NewProxyDialer(&url.URL{Scheme: "http", Host: l.Addr().String()})
+	require.NoError(t, err)
+	conn, err := dial(ctx, "example.com:22")
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	greeting, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "SSH-2.0-greeting\r\n", greeting)
+}
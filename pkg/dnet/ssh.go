@@ -0,0 +1,205 @@
+package dnet
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// SSHConfig configures the connection to an SSH jump host.
+type SSHConfig struct {
+	// Address is the host:port of the jump host.
+	Address string
+
+	// User is the name of the SSH user. Defaults to the name of the current user.
+	User string
+
+	// IdentityFile is a file containing an unencrypted private key. The keys of the ssh-agent that SSH_AUTH_SOCK
+	// refers to are used when it's empty.
+	IdentityFile string
+
+	// KnownHostsFile is the file used to verify the key of the jump host. Defaults to ~/.ssh/known_hosts.
+	KnownHostsFile string
+}
+
+// SSHDialer dials connections through an SSH jump host. The SSH connection is established on first use, and
+// reestablished when it's lost.
+type SSHDialer struct {
+	address string
+	config  *ssh.ClientConfig
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+// NewSSHDialer returns a dialer that uses the jump host of the given config.
+func NewSSHDialer(sc *SSHConfig) (*SSHDialer, error) {
+	address := sc.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "22")
+	}
+
+	userName := sc.User
+	if userName == "" {
+		u, err := user.Current()
+		if err != nil {
+			return nil, fmt.Errorf("unable to determine the SSH user: %w", err)
+		}
+		userName = u.Username
+		if i := strings.LastIndexByte(userName, '\\'); i >= 0 {
+			// Windows user names are prefixed with the domain
+			userName = userName[i+1:]
+		}
+	}
+
+	var auth ssh.AuthMethod
+	if sc.IdentityFile != "" {
+		data, err := os.ReadFile(expandHome(sc.IdentityFile))
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse SSH identity file %s (use an ssh-agent for encrypted keys): %w", sc.IdentityFile, err)
+		}
+		auth = ssh.PublicKeys(signer)
+	} else {
+		sock := os.Getenv("SSH_AUTH_SOCK")
+		if sock == "" {
+			return nil, fmt.Errorf("no SSH identity file is configured and SSH_AUTH_SOCK is not set")
+		}
+		auth = ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			conn, err := net.Dial("unix", sock)
+			if err != nil {
+				return nil, fmt.Errorf("unable to reach the ssh-agent: %w", err)
+			}
+			defer conn.Close()
+			return agent.NewClient(conn).Signers()
+		})
+	}
+
+	knownHostsFile := sc.KnownHostsFile
+	if knownHostsFile == "" {
+		knownHostsFile = filepath.Join("~", ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(expandHome(knownHostsFile))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the SSH known hosts: %w", err)
+	}
+
+	return &SSHDialer{
+		address: address,
+		config: &ssh.ClientConfig{
+			User:            userName,
+			Auth:            []ssh.AuthMethod{auth},
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         30 * time.Second,
+		},
+	}, nil
+}
+
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, `~\`) {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
+
+func (d *SSHDialer) sshClient(ctx context.Context) (*ssh.Client, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.client != nil {
+		return d.client, nil
+	}
+	conn, err := (&net.Dialer{Timeout: d.config.Timeout}).DialContext(ctx, "tcp", d.address)
+	if err != nil {
+		return nil, fmt.Errorf("unable to dial SSH jump host %s: %w", d.address, err)
+	}
+	cc, chans, reqs, err := ssh.NewClientConn(conn, d.address, d.config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to connect to SSH jump host %s: %w", d.address, err)
+	}
+	client := ssh.NewClient(cc, chans, reqs)
+	d.client = client
+	go func() {
+		_ = client.Wait()
+		d.mu.Lock()
+		if d.client == client {
+			d.client = nil
+		}
+		d.mu.Unlock()
+	}()
+	return client, nil
+}
+
+// DialContext dials the given address from the jump host. Only "tcp" networks are supported.
+func (d *SSHDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	client, err := d.sshClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.Dial(network, addr)
+}
+
+// Forward listens on a loopback port, and forwards each connection that it accepts to the given address through
+// the jump host, until the given context is cancelled. It returns the address of the loopback port.
+func (d *SSHDialer) Forward(ctx context.Context, addr string) (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	go func() {
+		<-ctx.Done()
+		_ = l.Close()
+		d.Close()
+	}()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				rc, err := d.DialContext(ctx, "tcp", addr)
+				if err != nil {
+					dlog.Errorf(ctx, "unable to forward %s through SSH jump host %s: %v", addr, d.address, err)
+					return
+				}
+				defer rc.Close()
+				done := make(chan struct{}, 2)
+				go func() { _, _ = io.Copy(rc, conn); done <- struct{}{} }()
+				go func() { _, _ = io.Copy(conn, rc); done <- struct{}{} }()
+				<-done
+			}()
+		}
+	}()
+	return l.Addr().String(), nil
+}
+
+// Close closes the SSH connection, if any.
+func (d *SSHDialer) Close() {
+	d.mu.Lock()
+	client := d.client
+	d.client = nil
+	d.mu.Unlock()
+	if client != nil {
+		_ = client.Close()
+	}
+}
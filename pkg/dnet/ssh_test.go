@@ -0,0 +1,155 @@
+package dnet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+func newSigner(t *testing.T) (ssh.Signer, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	return signer, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+// startJumpHost starts an SSH server that only permits the given client key, and that serves direct-tcpip
+// channels. It returns the address of the server.
+func startJumpHost(t *testing.T, hostKey ssh.Signer, clientKey ssh.PublicKey) string {
+	cfg := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(clientKey.Marshal()) {
+				return nil, assert.AnError
+			}
+			return nil, nil
+		},
+	}
+	cfg.AddHostKey(hostKey)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for nc := range chans {
+					var target struct {
+						Host     string
+						Port     uint32
+						OrigHost string
+						OrigPort uint32
+					}
+					if nc.ChannelType() != "direct-tcpip" || ssh.Unmarshal(nc.ExtraData(), &target) != nil {
+						_ = nc.Reject(ssh.UnknownChannelType, "unsupported")
+						continue
+					}
+					rc, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+					if err != nil {
+						_ = nc.Reject(ssh.ConnectionFailed, err.Error())
+						continue
+					}
+					ch, creqs, err := nc.Accept()
+					if err != nil {
+						rc.Close()
+						continue
+					}
+					go ssh.DiscardRequests(creqs)
+					go func() {
+						defer ch.Close()
+						defer rc.Close()
+						go func() { _, _ = io.Copy(rc, ch) }()
+						_, _ = io.Copy(ch, rc)
+					}()
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func startEchoServer(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestSSHDialer(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	hostKey, _ := newSigner(t)
+	clientKey, clientPEM := newSigner(t)
+	jumpHost := startJumpHost(t, hostKey, clientKey.PublicKey())
+	echo := startEchoServer(t)
+
+	dir := t.TempDir()
+	identityFile := filepath.Join(dir, "id_ecdsa")
+	require.NoError(t, os.WriteFile(identityFile, clientPEM, 0o600))
+	knownHostsFile := filepath.Join(dir, "known_hosts")
+	require.NoError(t, os.WriteFile(knownHostsFile, []byte(knownhosts.Line([]string{jumpHost}, hostKey.PublicKey())+"\n"), 0o600))
+
+	t.Run("forward", func(t *testing.T) {
+		sd, err := NewSSHDialer(&SSHConfig{Address: jumpHost, User: "jane", IdentityFile: identityFile, KnownHostsFile: knownHostsFile})
+		require.NoError(t, err)
+		localAddr, err := sd.Forward(ctx, echo)
+		require.NoError(t, err)
+
+		conn, err := net.Dial("tcp", localAddr)
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("hello"))
+		require.NoError(t, err)
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(buf))
+	})
+
+	t.Run("unknown host key", func(t *testing.T) {
+		otherKey, _ := newSigner(t)
+		otherKnownHosts := filepath.Join(dir, "other_known_hosts")
+		require.NoError(t, os.WriteFile(otherKnownHosts, []byte(knownhosts.Line([]string{jumpHost}, otherKey.PublicKey())+"\n"), 0o600))
+		sd, err := NewSSHDialer(&SSHConfig{Address: jumpHost, User: "jane", IdentityFile: identityFile, KnownHostsFile: otherKnownHosts})
+		require.NoError(t, err)
+		_, err = sd.DialContext(ctx, "tcp", echo)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,235 @@
+package dnet
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/websocket"
+)
+
+// WebsocketPath is the path where the traffic-manager accepts websocket connections that tunnel its gRPC API.
+const WebsocketPath = "/tunnel/ws"
+
+// WebsocketHandler returns a http.Handler that upgrades requests to websockets and then serves HTTP/2 over each
+// websocket connection using the given server and handler. This makes it possible to reach a gRPC server through
+// proxies and ingresses that only permit HTTP/1.1 and websockets.
+//
+// The given authorize function is called with each upgrade request, and the upgrade is rejected with a 403
+// Forbidden when it returns an error. All upgrades are rejected when it's nil.
+func WebsocketHandler(ctx context.Context, h2 *http2.Server, handler http.Handler, authorize func(*http.Request) error) http.Handler {
+	if h2 == nil {
+		h2 = &http2.Server{}
+	}
+	return websocket.Server{
+		Handshake: func(_ *websocket.Config, rq *http.Request) error {
+			if authorize == nil {
+				return errors.New("websocket tunnels aren't authorized")
+			}
+			return authorize(rq)
+		},
+		Handler: func(ws *websocket.Conn) {
+			ws.PayloadType = websocket.BinaryFrame
+			rq := ws.Request()
+			conn := &serverWsConn{Conn: ws}
+			conn.laddr, _ = rq.Context().Value(http.LocalAddrContextKey).(net.Addr)
+			conn.raddr, _ = net.ResolveTCPAddr("tcp", rq.RemoteAddr)
+			h2.ServeConn(conn, &http2.ServeConnOpts{Context: ctx, Handler: handler})
+		},
+	}
+}
+
+// serverWsConn is a server side websocket connection that reports the addresses of the underlying connection. The
+// websocket.Conn reports addresses derived from the handshake, which are undefined when the origin isn't checked.
+type serverWsConn struct {
+	*websocket.Conn
+	laddr net.Addr
+	raddr net.Addr
+}
+
+func (c *serverWsConn) LocalAddr() net.Addr {
+	if c.laddr == nil {
+		return &net.TCPAddr{}
+	}
+	return c.laddr
+}
+
+func (c *serverWsConn) RemoteAddr() net.Addr {
+	if c.raddr == nil {
+		return &net.TCPAddr{}
+	}
+	return c.raddr
+}
+
+// BearerToken returns the bearer token of the Authorization header of the given request, or an empty string when
+// it has none.
+func BearerToken(rq *http.Request) string {
+	if scheme, token, ok := strings.Cut(rq.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// DialWebsocket dials the given ws:// or wss:// URL and returns the resulting websocket connection. Data written
+// to the connection is sent in binary frames. A proxy that is configured in the environment using HTTPS_PROXY or
+// HTTP_PROXY is used when dialing. The token, unless it's empty, is sent as the bearer token of the upgrade request.
+func DialWebsocket(ctx context.Context, wsURL, token string) (net.Conn, error) {
+	return DialWebsocketUsing(ctx, wsURL, token, nil)
+}
+
+// DialWebsocketUsing is like DialWebsocket, but uses the given dialer, unless it's nil, to establish the
+// connection to the host of the URL instead of a proxy configured in the environment.
+func DialWebsocketUsing(ctx context.Context, wsURL, token string, dial func(context.Context, string) (net.Conn, error)) (net.Conn, error) {
+	u, err := url.Parse(wsURL)
+	if err != nil {
+		return nil, err
+	}
+	var scheme, port string
+	switch u.Scheme {
+	case "ws":
+		scheme, port = "http", "80"
+	case "wss":
+		scheme, port = "https", "443"
+	default:
+		return nil, fmt.Errorf("unsupported websocket URL scheme %q", u.Scheme)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	var conn net.Conn
+	if dial != nil {
+		conn, err = dial(ctx, addr)
+	} else {
+		var proxyURL *url.URL
+		proxyURL, err = http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: scheme, Host: addr}})
+		if err != nil {
+			return nil, err
+		}
+		if proxyURL != nil {
+			conn, err = dialProxyTunnel(ctx, proxyURL, addr)
+		} else {
+			var d net.Dialer
+			conn, err = d.DialContext(ctx, "tcp", addr)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ok := false
+	defer func() {
+		if !ok {
+			_ = conn.Close()
+		}
+	}()
+	if dl, hasDl := ctx.Deadline(); hasDl {
+		_ = conn.SetDeadline(dl)
+	}
+	if scheme == "https" {
+		tc := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err = tc.HandshakeContext(ctx); err != nil {
+			return nil, err
+		}
+		conn = tc
+	}
+
+	cfg, err := websocket.NewConfig(wsURL, scheme+"://"+u.Host)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		cfg.Header.Set("Authorization", "Bearer "+token)
+	}
+	ws, err := websocket.NewClient(cfg, conn)
+	if err != nil {
+		return nil, fmt.Errorf("websocket handshake with %s failed: %w", wsURL, err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	ws.PayloadType = websocket.BinaryFrame
+	ok = true
+	return ws, nil
+}
+
+// dialProxyTunnel establishes a connection to addr using the CONNECT method of the given HTTP proxy. The connection
+// to the proxy itself uses TLS when the scheme of the proxy URL is https.
+func dialProxyTunnel(ctx context.Context, proxyURL *url.URL, addr string) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	}
+	if proxyURL.Scheme == "https" {
+		tc := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err = tc.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		conn = tc
+	}
+
+	rq := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if pu := proxyURL.User; pu != nil {
+		pw, _ := pu.Password()
+		rq.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(pu.Username()+":"+pw)))
+	}
+	if err = rq.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	rs, err := http.ReadResponse(br, rq)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = rs.Body.Close()
+	if rs.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, fmt.Errorf("proxy %s refused to connect to %s: %s", proxyURL.Host, addr, rs.Status)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	if br.Buffered() > 0 {
+		// The proxy may send data from the remote end along with its response, and the reader has consumed it.
+		conn = &readerConn{Conn: conn, r: br}
+	}
+	return conn, nil
+}
+
+// readerConn is a connection that is read through the reader that was used to read the response of the proxy, so
+// that data that the reader has buffered isn't lost.
+type readerConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *readerConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
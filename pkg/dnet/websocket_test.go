@@ -0,0 +1,96 @@
+package dnet_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/dnet"
+)
+
+func TestWebsocket(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.Proto, r.URL.Path)
+	})
+	authorize := func(rq *http.Request) error {
+		if dnet.BearerToken(rq) != "secret" {
+			return errors.New("bad token")
+		}
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.Handle(dnet.WebsocketPath, dnet.WebsocketHandler(ctx, nil, handler, authorize))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	wsURL := "ws://" + strings.TrimPrefix(srv.URL, "http://") + dnet.WebsocketPath
+
+	dc, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	for _, token := range []string{"", "wrong"} {
+		_, err := dnet.DialWebsocket(dc, wsURL, token)
+		assert.Error(t, err, "token %q", token)
+	}
+	conn, err := dnet.DialWebsocket(dc, wsURL, "secret")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	cc, err := (&http2.Transport{}).NewClientConn(conn)
+	require.NoError(t, err)
+	for _, path := range []string{"/a", "/b"} {
+		rq, err := http.NewRequestWithContext(dc, http.MethodGet, "http://example.com"+path, nil)
+		require.NoError(t, err)
+		rs, err := cc.RoundTrip(rq)
+		require.NoError(t, err)
+		body, err := io.ReadAll(rs.Body)
+		rs.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "HTTP/2.0 "+path, string(body))
+	}
+}
+
+func TestWebsocketHandler_NoAuthorizer(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	mux := http.NewServeMux()
+	mux.Handle(dnet.WebsocketPath, dnet.WebsocketHandler(ctx, nil, http.NotFoundHandler(), nil))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	dc, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	_, err := dnet.DialWebsocket(dc, "ws://"+strings.TrimPrefix(srv.URL, "http://")+dnet.WebsocketPath, "secret")
+	assert.Error(t, err)
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := map[string]string{
+		"":               "",
+		"Bearer abc":     "abc",
+		"bearer abc":     "abc",
+		"Basic YWJjOmQ=": "",
+		"Bearer":         "",
+	}
+	for header, want := range tests {
+		rq := httptest.NewRequest(http.MethodGet, "/", nil)
+		if header != "" {
+			rq.Header.Set("Authorization", header)
+		}
+		assert.Equal(t, want, dnet.BearerToken(rq), header)
+	}
+}
+
+func TestDialWebsocket_BadScheme(t *testing.T) {
+	_, err := dnet.DialWebsocket(context.Background(), "http://example.com/", "")
+	assert.Error(t, err)
+}
@@ -2,6 +2,7 @@ package forwarder
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
@@ -17,6 +18,9 @@ import (
 	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
 )
 
+// tlsHandshakeTimeout is the maximum time allowed for a TLS handshake with the client or the target.
+const tlsHandshakeTimeout = 10 * time.Second
+
 type Forwarder struct {
 	mu sync.Mutex
 
@@ -29,6 +33,14 @@ type Forwarder struct {
 	targetHost string
 	targetPort uint16
 
+	// terminatingTLS is used when terminating TLS for incoming connections and originatingTLS when
+	// originating TLS for connections that are forwarded to the target.
+	terminatingTLS *tls.Config
+	originatingTLS *tls.Config
+
+	// reusePort makes the listener use SO_REUSEPORT, so that it can share its port with other sockets.
+	reusePort bool
+
 	manager     manager.ManagerClient
 	sessionInfo *manager.SessionInfo
 
@@ -52,6 +64,24 @@ func (f *Forwarder) SetManager(sessionInfo *manager.SessionInfo, manager manager
 	f.mgrVersion = version
 }
 
+// SetTLS makes the forwarder terminate TLS for incoming connections using the given terminating config. Intercepted
+// connections are then sent as plaintext to the client, and other connections are forwarded to the target using a
+// TLS connection created from the given originating config, which must not be nil when terminating isn't nil.
+func (f *Forwarder) SetTLS(terminating, originating *tls.Config) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.terminatingTLS = terminating
+	f.originatingTLS = originating
+}
+
+// SetReusePort makes the forwarder listen using the SO_REUSEPORT socket option, so that it can coexist with an app
+// that binds the same port on all interfaces. It has no effect on platforms that lack the option.
+func (f *Forwarder) SetReusePort(reusePort bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reusePort = reusePort
+}
+
 func (f *Forwarder) Serve(ctx context.Context) error {
 	listener, err := f.Listen(ctx)
 	if err != nil {
@@ -104,9 +134,18 @@ func (f *Forwarder) Listen(ctx context.Context) (*net.TCPListener, error) {
 	// Set up target lifetime
 	f.tCtx, f.tCancel = context.WithCancel(f.lCtx)
 	listenAddr := f.listenAddr
+	reusePort := f.reusePort
 
 	f.mu.Unlock()
-	return net.ListenTCP("tcp", listenAddr)
+	if !reusePort {
+		return net.ListenTCP("tcp", listenAddr)
+	}
+	lc := net.ListenConfig{Control: reusePortControl}
+	l, err := lc.Listen(ctx, "tcp", listenAddr.String())
+	if err != nil {
+		return nil, err
+	}
+	return l.(*net.TCPListener), nil
 }
 
 func (f *Forwarder) Close() error {
@@ -173,16 +212,43 @@ func (f *Forwarder) SetIntercepting(intercept *manager.InterceptInfo) {
 	f.intercept = intercept
 }
 
-func (f *Forwarder) forwardConn(clientConn *net.TCPConn) error {
+// closeWriter is implemented by both *net.TCPConn and *tls.Conn
+type closeWriter interface {
+	net.Conn
+	CloseWrite() error
+}
+
+func (f *Forwarder) forwardConn(tcpConn *net.TCPConn) error {
 	f.mu.Lock()
 	ctx := f.tCtx
 	targetHost := f.targetHost
 	targetPort := f.targetPort
 	intercept := f.intercept
+	terminatingTLS := f.terminatingTLS
+	originatingTLS := f.originatingTLS
 	f.mu.Unlock()
+
+	var clientConn closeWriter = tcpConn
+	var alpn string
+	if terminatingTLS != nil {
+		tc := tls.Server(tcpConn, terminatingTLS)
+		hc, cancel := context.WithTimeout(ctx, tlsHandshakeTimeout)
+		err := tc.HandshakeContext(hc)
+		cancel()
+		if err != nil {
+			_ = tcpConn.Close()
+			return fmt.Errorf("TLS handshake with %s failed: %w", tcpConn.RemoteAddr(), err)
+		}
+		alpn = tc.ConnectionState().NegotiatedProtocol
+		clientConn = tc
+	}
 	if intercept != nil {
 		return f.interceptConn(ctx, clientConn, intercept)
 	}
+	if targetPort == 0 {
+		_ = clientConn.Close()
+		return fmt.Errorf("connection from %s refused, the target container has been replaced", clientConn.RemoteAddr())
+	}
 
 	targetAddr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:%d", targetHost, targetPort))
 	if err != nil {
@@ -197,10 +263,27 @@ func (f *Forwarder) forwardConn(clientConn *net.TCPConn) error {
 
 	defer clientConn.Close()
 
-	targetConn, err := net.DialTCP("tcp", nil, targetAddr)
+	tcpTargetConn, err := net.DialTCP("tcp", nil, targetAddr)
 	if err != nil {
 		return fmt.Errorf("error on dial: %w", err)
 	}
+	var targetConn closeWriter = tcpTargetConn
+	if terminatingTLS != nil {
+		// Re-originate TLS using the application protocol that was negotiated with the client.
+		oc := originatingTLS.Clone()
+		if alpn != "" {
+			oc.NextProtos = []string{alpn}
+		}
+		tc := tls.Client(tcpTargetConn, oc)
+		hc, cancel := context.WithTimeout(ctx, tlsHandshakeTimeout)
+		err = tc.HandshakeContext(hc)
+		cancel()
+		if err != nil {
+			_ = tcpTargetConn.Close()
+			return fmt.Errorf("TLS handshake with %s failed: %w", targetAddr, err)
+		}
+		targetConn = tc
+	}
 	defer targetConn.Close()
 
 	done := make(chan struct{})
@@ -249,7 +332,7 @@ func (f *Forwarder) interceptConn(ctx context.Context, conn net.Conn, iCept *man
 		return fmt.Errorf("call to manager.Tunnel() failed. Id %s: %v", id, err)
 	}
 
-	s, err := tunnel.NewClientStream(ctx, ms, id, f.sessionInfo.SessionId, time.Duration(spec.RoundtripLatency), time.Duration(spec.DialTimeout))
+	s, err := tunnel.NewClientStream(ctx, ms, id, f.sessionInfo.SessionId, time.Duration(spec.RoundtripLatency), time.Duration(spec.DialTimeout), tunnel.NoCompression)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,88 @@
+package forwarder_test
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/forwarder"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+)
+
+func TestForwarder_TLS(t *testing.T) {
+	// The forwarder logs when the forwarded connections end, which may happen after the test has ended, so the
+	// test logger cannot be used.
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ctx, cancel := context.WithCancel(dlog.WithLogger(context.Background(), dlog.WrapLogrus(logger)))
+	defer cancel()
+
+	app := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "tls=%t %s", r.TLS != nil, r.URL.Path)
+	}))
+	app.EnableHTTP2 = true
+	app.StartTLS()
+	defer app.Close()
+	_, appPort, err := iputil.SplitToIPPort(app.Listener.Addr())
+	require.NoError(t, err)
+
+	fwd := forwarder.NewForwarder(&net.TCPAddr{IP: net.IP{127, 0, 0, 1}}, "127.0.0.1", appPort)
+	fwd.SetTLS(
+		&tls.Config{Certificates: app.TLS.Certificates, NextProtos: []string{"h2", "http/1.1"}},
+		&tls.Config{InsecureSkipVerify: true})
+	l, err := fwd.Listen(ctx)
+	require.NoError(t, err)
+	go func() {
+		_ = fwd.ServeListener(ctx, l)
+	}()
+
+	for _, h2 := range []bool{false, true} {
+		t.Run(fmt.Sprintf("h2=%t", h2), func(t *testing.T) {
+			tr := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, ForceAttemptHTTP2: h2}
+			defer tr.CloseIdleConnections()
+			rs, err := (&http.Client{Transport: tr}).Get(fmt.Sprintf("https://%s/hello", l.Addr()))
+			require.NoError(t, err)
+			body, err := io.ReadAll(rs.Body)
+			rs.Body.Close()
+			require.NoError(t, err)
+			assert.Equal(t, "tls=true /hello", string(body))
+			assert.Equal(t, h2, rs.ProtoMajor == 2)
+		})
+	}
+}
+
+func TestForwarder_ReusePort(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SO_REUSEPORT isn't supported on Windows")
+	}
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	// An app that binds the port on all interfaces, using SO_REUSEPORT
+	first := forwarder.NewForwarder(&net.TCPAddr{}, "127.0.0.1", 0)
+	first.SetReusePort(true)
+	l1, err := first.Listen(ctx)
+	require.NoError(t, err)
+	defer l1.Close()
+	port := l1.Addr().(*net.TCPAddr).Port
+
+	fwd := forwarder.NewForwarder(&net.TCPAddr{IP: net.IP{127, 0, 0, 1}, Port: port}, "127.0.0.1", 0)
+	_, err = fwd.Listen(ctx)
+	assert.Error(t, err, "the port is in use")
+
+	fwd.SetReusePort(true)
+	l2, err := fwd.Listen(ctx)
+	require.NoError(t, err)
+	l2.Close()
+}
@@ -0,0 +1,10 @@
+package forwarder
+
+import (
+	"syscall"
+)
+
+// reusePortControl does nothing, because Windows has no SO_REUSEPORT.
+func reusePortControl(_, _ string, _ syscall.RawConn) error {
+	return nil
+}
@@ -14,4 +14,10 @@ const (
 	MutatorWebhookPortHTTPS   = 8443
 	MutatorWebhookTLSName     = "mutator-webhook-tls"
 	TelAppMountPoint          = "/tel_app_mounts"
+
+	// Workload annotations that declare defaults for the intercepts of the workload.
+	InterceptPortAnnotation       = DomainPrefix + "intercept-port"
+	InterceptHeaderAnnotation     = DomainPrefix + "intercept-header"
+	InterceptEnvExcludeAnnotation = DomainPrefix + "intercept-env-exclude"
+	InterceptMountAnnotation      = DomainPrefix + "intercept-mount"
 )
@@ -16,7 +16,8 @@ import (
 )
 
 // FilterServicePorts iterates through a list of ports in a service and
-// only returns the ports that match the given nameOrNumber. All ports will
+// only returns the ports that match the given nameOrNumber. A number matches
+// the port of the service port, or its numeric target port. All ports will
 // be returned if nameOrNumber is equal to the empty string
 func FilterServicePorts(svc *core.Service, nameOrNumber string) ([]core.ServicePort, error) {
 	ports := svc.Spec.Ports
@@ -36,14 +37,8 @@ func FilterServicePorts(svc *core.Service, nameOrNumber string) ([]core.ServiceP
 		}
 	} else {
 		for _, port := range ports {
-			pn := int32(0)
-			if port.TargetPort.Type == intstr.Int {
-				pn = port.TargetPort.IntVal
-			}
-			if pn == 0 {
-				pn = port.Port
-			}
-			if pn == int32(number) {
+			pn := int32(number)
+			if port.Port == pn || port.TargetPort.Type == intstr.Int && port.TargetPort.IntVal == pn {
 				svcPorts = append(svcPorts, port)
 			}
 		}
@@ -0,0 +1,83 @@
+package helm
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	core "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestExternalAccess(t *testing.T) {
+	chrt, err := loader.LoadDir(filepath.Join("..", "..", "..", "charts", "telepresence"))
+	require.NoError(t, err)
+	render := func(values map[string]any) (map[string]string, error) {
+		vals, err := chartutil.ToRenderValues(chrt, values, chartutil.ReleaseOptions{
+			Name:      releaseName,
+			Namespace: "ambassador",
+			IsInstall: true,
+		}, chartutil.DefaultCapabilities)
+		require.NoError(t, err)
+		return engine.Render(chrt, vals)
+	}
+
+	tests := []struct {
+		name    string
+		values  map[string]any
+		ports   []string
+		wantErr string
+	}{
+		{
+			name:    "unauthenticated",
+			values:  map[string]any{"externalAccess": map[string]any{"enabled": true}},
+			wantErr: "requires that clientIdentity.method or federation.tls.secretName is set",
+		},
+		{
+			name: "client identity",
+			values: map[string]any{
+				"externalAccess": map[string]any{"enabled": true},
+				"clientIdentity": map[string]any{"method": "kubernetes"},
+			},
+			ports: []string{"api"},
+		},
+		{
+			name: "federation",
+			values: map[string]any{
+				"externalAccess": map[string]any{"enabled": true},
+				"federation":     map[string]any{"tls": map[string]any{"secretName": "federation-tls"}},
+			},
+			ports: []string{"federation"},
+		},
+		{
+			name: "ingress without client identity",
+			values: map[string]any{
+				"externalAccess": map[string]any{"enabled": true, "ingress": map[string]any{"enabled": true, "host": "tm.example.com"}},
+				"federation":     map[string]any{"tls": map[string]any{"secretName": "federation-tls"}},
+			},
+			wantErr: "externalAccess.ingress exposes the API",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := render(tt.values)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			var svc core.Service
+			require.NoError(t, yaml.Unmarshal([]byte(files["telepresence/templates/external-access.yaml"]), &svc))
+			var ports []string
+			for _, p := range svc.Spec.Ports {
+				ports = append(ports, p.Name)
+			}
+			assert.Equal(t, tt.ports, ports)
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package helm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+// splitImage splits the given image name into its name, tag, and digest.
+func splitImage(img string) (name, tag, digest string) {
+	if at := strings.IndexByte(img, '@'); at >= 0 {
+		return img[:at], "", img[at+1:]
+	}
+	// A colon that is followed by a slash is the port of a registry host, not a tag
+	if colon := strings.LastIndexByte(img, ':'); colon >= 0 && !strings.ContainsRune(img[colon:], '/') {
+		return img[:colon], img[colon+1:], ""
+	}
+	return img, "", ""
+}
+
+// imageValues returns the values of the registry, name, tag, and digest of the given image that are set.
+func imageValues(img, registry string) map[string]any {
+	values := make(map[string]any)
+	if img != "" {
+		name, tag, digest := splitImage(img)
+		values["name"] = name
+		if tag != "" {
+			values["tag"] = tag
+		}
+		if digest != "" {
+			values["digest"] = digest
+		}
+	}
+	if registry != "" {
+		values["registry"] = registry
+	}
+	return values
+}
+
+func pullSecretValues(secrets []string) []any {
+	refs := make([]any, len(secrets))
+	for i, s := range secrets {
+		refs[i] = map[string]any{"name": s}
+	}
+	return refs
+}
+
+// ValidateImages verifies that the images used by the traffic-manager in the given namespace, and by the
+// traffic-agents that it injects, are the ones that the client is configured to use. Nothing is validated
+// unless a private registry, or an explicit traffic-manager or agent image digest, is configured.
+func ValidateImages(ctx context.Context, namespace string) error {
+	imgConfig := client.GetConfig(ctx).Images
+	_, _, managerDigest := splitImage(imgConfig.PrivateManagerImage)
+	_, _, agentDigest := splitImage(imgConfig.PrivateAgentImage)
+	if imgConfig.PrivateRegistry == "" && managerDigest == "" && agentDigest == "" {
+		return nil
+	}
+
+	dep, err := k8sapi.GetK8sInterface(ctx).AppsV1().Deployments(namespace).Get(ctx, releaseName, meta.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) || errors.IsForbidden(err) {
+			// Some other error, e.g. a missing traffic-manager, will be reported when connecting to it
+			return nil
+		}
+		return err
+	}
+	var cn *core.Container
+	cns := dep.Spec.Template.Spec.Containers
+	for i := range cns {
+		if cns[i].Name == releaseName {
+			cn = &cns[i]
+			break
+		}
+	}
+	if cn == nil {
+		return nil
+	}
+	env := make(map[string]string, len(cn.Env))
+	for _, e := range cn.Env {
+		env[e.Name] = e.Value
+	}
+
+	var msgs []string
+	if r := imgConfig.PrivateRegistry; r != "" && !strings.HasPrefix(cn.Image, r+"/") {
+		msgs = append(msgs, fmt.Sprintf("uses image %q, which isn't in the configured registry %q", cn.Image, r))
+	}
+	if managerDigest != "" && !strings.HasSuffix(cn.Image, "@"+managerDigest) {
+		msgs = append(msgs, fmt.Sprintf("uses image %q, which doesn't have the configured digest %q", cn.Image, managerDigest))
+	}
+	if r := imgConfig.WebhookRegistry(ctx); imgConfig.PrivateRegistry != "" && env["TELEPRESENCE_REGISTRY"] != r {
+		msgs = append(msgs, fmt.Sprintf("injects agents from registry %q, but the configured registry is %q", env["TELEPRESENCE_REGISTRY"], r))
+	}
+	if agentDigest != "" && !strings.HasSuffix(env["TELEPRESENCE_AGENT_IMAGE"], "@"+agentDigest) {
+		msgs = append(msgs, fmt.Sprintf("injects agent image %q, which doesn't have the configured digest %q", env["TELEPRESENCE_AGENT_IMAGE"], agentDigest))
+	}
+	if len(msgs) > 0 {
+		return errcat.Config.Newf("the traffic-manager in namespace %s %s. Please upgrade it using values that match the images config",
+			namespace, strings.Join(msgs, ", and "))
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package helm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
+)
+
+func Test_splitImage(t *testing.T) {
+	tests := []struct {
+		img    string
+		name   string
+		tag    string
+		digest string
+	}{
+		{"tel2", "tel2", "", ""},
+		{"tel2:2.7.0", "tel2", "2.7.0", ""},
+		{"tel2@sha256:0123", "tel2", "", "sha256:0123"},
+		{"registry.local:5000/tel2", "registry.local:5000/tel2", "", ""},
+		{"registry.local:5000/tel2:2.7.0", "registry.local:5000/tel2", "2.7.0", ""},
+	}
+	for _, tt := range tests {
+		name, tag, digest := splitImage(tt.img)
+		assert.Equal(t, tt.name, name, tt.img)
+		assert.Equal(t, tt.tag, tag, tt.img)
+		assert.Equal(t, tt.digest, digest, tt.img)
+	}
+}
+
+func TestValidateImages(t *testing.T) {
+	managerDeployment := func(image string, env ...core.EnvVar) *apps.Deployment {
+		return &apps.Deployment{
+			ObjectMeta: meta.ObjectMeta{Name: releaseName, Namespace: "ambassador"},
+			Spec: apps.DeploymentSpec{
+				Template: core.PodTemplateSpec{
+					Spec: core.PodSpec{
+						Containers: []core.Container{{Name: releaseName, Image: image, Env: env}},
+					},
+				},
+			},
+		}
+	}
+	testCtx := func(t *testing.T, images client.Images, dep *apps.Deployment) context.Context {
+		ctx := dlog.NewTestContext(t, false)
+		ctx = client.WithEnv(ctx, &client.Env{Registry: "docker.io/datawire"})
+		cfg := client.GetDefaultConfig()
+		cfg.Images = images
+		ctx = client.WithConfig(ctx, &cfg)
+		return k8sapi.WithK8sInterface(ctx, fake.NewSimpleClientset(dep))
+	}
+
+	t.Run("nothing configured", func(t *testing.T) {
+		ctx := testCtx(t, client.Images{}, managerDeployment("docker.io/datawire/tel2:2.7.0"))
+		require.NoError(t, ValidateImages(ctx, "ambassador"))
+	})
+
+	t.Run("matching registry and digests", func(t *testing.T) {
+		ctx := testCtx(t, client.Images{
+			PrivateRegistry:     "registry.local",
+			PrivateManagerImage: "tel2@sha256:0123",
+			PrivateAgentImage:   "tel2@sha256:4567",
+		}, managerDeployment("registry.local/tel2@sha256:0123",
+			core.EnvVar{Name: "TELEPRESENCE_REGISTRY", Value: "registry.local"},
+			core.EnvVar{Name: "TELEPRESENCE_AGENT_IMAGE", Value: "tel2@sha256:4567"}))
+		require.NoError(t, ValidateImages(ctx, "ambassador"))
+	})
+
+	t.Run("public registry", func(t *testing.T) {
+		ctx := testCtx(t, client.Images{
+			PrivateRegistry: "registry.local",
+		}, managerDeployment("docker.io/datawire/tel2:2.7.0",
+			core.EnvVar{Name: "TELEPRESENCE_REGISTRY", Value: "docker.io/datawire"}))
+		err := ValidateImages(ctx, "ambassador")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `uses image "docker.io/datawire/tel2:2.7.0", which isn't in the configured registry "registry.local"`)
+		assert.Contains(t, err.Error(), `injects agents from registry "docker.io/datawire"`)
+	})
+
+	t.Run("wrong digest", func(t *testing.T) {
+		ctx := testCtx(t, client.Images{
+			PrivateManagerImage: "tel2@sha256:0123",
+		}, managerDeployment("docker.io/datawire/tel2@sha256:89ab"))
+		err := ValidateImages(ctx, "ambassador")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `doesn't have the configured digest "sha256:0123"`)
+	})
+}
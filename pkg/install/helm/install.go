@@ -12,6 +12,7 @@ import (
 
 	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
 )
 
@@ -37,42 +38,52 @@ func getValues(ctx context.Context) map[string]any {
 	imageRegistry := imgConfig.Registry(ctx)
 	cloudConfig := clientConfig.Cloud
 	imageTag := strings.TrimPrefix(client.Version(), "v")
+	image := imageValues(imgConfig.PrivateManagerImage, imageRegistry)
+	if _, ok := image["tag"]; !ok {
+		image["tag"] = imageTag
+	}
+	if len(imgConfig.PullSecrets) > 0 {
+		image["imagePullSecrets"] = pullSecretValues(imgConfig.PullSecrets)
+	}
 	values := map[string]any{
-		"image": map[string]any{
-			"registry": imageRegistry,
-			"tag":      imageTag,
-		},
+		"image":       image,
 		"systemaHost": cloudConfig.SystemaHost,
 		"systemaPort": cloudConfig.SystemaPort,
 		"createdBy":   releaseOwner,
 	}
+	grpcValues := make(map[string]any)
 	if !clientConfig.Grpc.MaxReceiveSize.IsZero() {
-		values["grpc"] = map[string]any{
-			"maxReceiveSize": clientConfig.Grpc.MaxReceiveSize.String(),
-		}
+		grpcValues["maxReceiveSize"] = clientConfig.Grpc.MaxReceiveSize.String()
+	}
+	if !clientConfig.Grpc.InitialWindowSize.IsZero() {
+		grpcValues["initialWindowSize"] = clientConfig.Grpc.InitialWindowSize.String()
+	}
+	if !clientConfig.Grpc.InitialConnWindowSize.IsZero() {
+		grpcValues["initialConnWindowSize"] = clientConfig.Grpc.InitialConnWindowSize.String()
+	}
+	if clientConfig.Grpc.KeepAliveInterval != 0 {
+		grpcValues["keepAliveInterval"] = clientConfig.Grpc.KeepAliveInterval.String()
+	}
+	if clientConfig.Grpc.KeepAliveTimeout != 0 {
+		grpcValues["keepAliveTimeout"] = clientConfig.Grpc.KeepAliveTimeout.String()
+	}
+	if clientConfig.Grpc.TCPUserTimeout != 0 {
+		grpcValues["tcpUserTimeout"] = clientConfig.Grpc.TCPUserTimeout.String()
+	}
+	if len(grpcValues) > 0 {
+		values["grpc"] = grpcValues
 	}
 	apc := clientConfig.Intercept.AppProtocolStrategy
-	if wai, wr := imgConfig.AgentImage(ctx), imgConfig.WebhookRegistry(ctx); wai != "" || wr != "" || apc != k8sapi.Http2Probe {
-		agentImage := make(map[string]any)
-		if wai != "" {
-			parts := strings.Split(wai, ":")
-			image := wai
-			tag := ""
-			if len(parts) > 1 {
-				image = parts[0]
-				tag = parts[1]
-			}
-			agentImage["name"] = image
-			agentImage["tag"] = tag
-		}
-		if wr != "" {
-			agentImage["registry"] = wr
-		}
-		agentInjector := map[string]any{"agentImage": agentImage}
+	pss := imgConfig.PullSecrets
+	if wai, wr := imgConfig.AgentImage(ctx), imgConfig.WebhookRegistry(ctx); wai != "" || wr != "" || apc != k8sapi.Http2Probe || len(pss) > 0 {
+		agentInjector := map[string]any{"agentImage": imageValues(wai, wr)}
 		values["agentInjector"] = agentInjector
 		if apc != k8sapi.Http2Probe {
 			agentInjector["appProtocolStrategy"] = apc.String()
 		}
+		if len(pss) > 0 {
+			agentInjector["agentImagePullSecrets"] = pullSecretValues(pss)
+		}
 	}
 	if clientConfig.TelepresenceAPI.Port != 0 {
 		values["telepresenceAPI"] = map[string]any{
@@ -103,8 +114,38 @@ func timedRun(ctx context.Context, run func(time.Duration) error) error {
 	}
 }
 
+// checkInstallAccess verifies that the current user is allowed to perform the given verb on all the resources that
+// the chart creates, so that a lack of permissions is reported up front instead of as a 403 halfway through the
+// helm action.
+func checkInstallAccess(ctx context.Context, namespace, verb string) error {
+	const rbacGroup = "rbac.authorization.k8s.io"
+	accesses := []k8sapi.Access{
+		{Verb: verb, Group: rbacGroup, Resource: "clusterroles"},
+		{Verb: verb, Group: rbacGroup, Resource: "clusterrolebindings"},
+		{Verb: verb, Group: "admissionregistration.k8s.io", Resource: "mutatingwebhookconfigurations"},
+		{Verb: verb, Resource: "serviceaccounts", Namespace: namespace},
+		{Verb: verb, Resource: "services", Namespace: namespace},
+		{Verb: verb, Resource: "secrets", Namespace: namespace},
+		{Verb: verb, Resource: "configmaps", Namespace: namespace},
+		{Verb: verb, Group: "apps", Resource: "deployments", Namespace: namespace},
+		{Verb: verb, Group: rbacGroup, Resource: "roles", Namespace: namespace},
+		{Verb: verb, Group: rbacGroup, Resource: "rolebindings", Namespace: namespace},
+	}
+	if verb == "create" {
+		accesses = append(accesses, k8sapi.Access{Verb: verb, Resource: "namespaces"})
+	}
+	if err := k8sapi.CheckAccess(ctx, accesses); err != nil {
+		return errcat.User.Newf("insufficient permissions to install or upgrade the traffic-manager in namespace %s:\n%v\n"+
+			"Use \"telepresence rbac --client --for install\" to generate the required roles", namespace, err)
+	}
+	return nil
+}
+
 func installNew(ctx context.Context, chrt *chart.Chart, helmConfig *action.Configuration, namespace string) error {
 	dlog.Infof(ctx, "No existing Traffic Manager found in namespace %s, installing %s...", namespace, client.Version())
+	if err := checkInstallAccess(ctx, namespace, "create"); err != nil {
+		return err
+	}
 	install := action.NewInstall(helmConfig)
 	install.ReleaseName = releaseName
 	install.Namespace = namespace
@@ -119,6 +160,9 @@ func installNew(ctx context.Context, chrt *chart.Chart, helmConfig *action.Confi
 
 func upgradeExisting(ctx context.Context, existingVer string, chrt *chart.Chart, helmConfig *action.Configuration, namespace string) error {
 	dlog.Infof(ctx, "Existing Traffic Manager %s found in namespace %s, upgrading to %s...", existingVer, namespace, client.Version())
+	if err := checkInstallAccess(ctx, namespace, "patch"); err != nil {
+		return err
+	}
 	upgrade := action.NewUpgrade(helmConfig)
 	upgrade.Atomic = true
 	upgrade.Namespace = namespace
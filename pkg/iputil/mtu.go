@@ -0,0 +1,50 @@
+package iputil
+
+import (
+	"fmt"
+	"net"
+)
+
+// mtuProbePort is the port that the UDP sockets used to find the route to an IP are connected to.
+const mtuProbePort = 443
+
+// InterfaceMTU returns the MTU of the network interface that traffic to the given IP is routed through. No
+// traffic is sent. The route is found by the kernel when a UDP socket is connected to the IP. Links beyond the
+// interface may have a smaller MTU, see PathMTU.
+func InterfaceMTU(ip net.IP) (int, error) {
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: ip, Port: mtuProbePort})
+	if err != nil {
+		return 0, err
+	}
+	localIP := conn.LocalAddr().(*net.UDPAddr).IP
+	conn.Close()
+
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return 0, err
+	}
+	for _, ifc := range ifs {
+		addrs, err := ifc.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipn, ok := addr.(*net.IPNet); ok && ipn.IP.Equal(localIP) {
+				return ifc.MTU, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("found no network interface with address %s", localIP)
+}
+
+// PathMTU returns the MTU of the path to the given IP, which is never larger than its InterfaceMTU. Where the
+// platform supports it, the path is probed by sending datagrams that must not be fragmented to the IP, so that a
+// router with a smaller MTU along the path makes the kernel lower the MTU that it has cached for the route. The
+// InterfaceMTU is returned on other platforms.
+func PathMTU(ip net.IP) (int, error) {
+	mtu, err := InterfaceMTU(ip)
+	if err != nil {
+		return 0, err
+	}
+	return probePathMTU(ip, mtu)
+}
@@ -0,0 +1,91 @@
+package iputil
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// maxPathMTUProbes is the maximum number of probes sent, i.e. the number of routers along the path that can
+	// lower the MTU.
+	maxPathMTUProbes = 4
+
+	// pathMTUProbeTimeout is the time to wait for a router to report that a probe is too large.
+	pathMTUProbeTimeout = 200 * time.Millisecond
+)
+
+// probePathMTU sends probes of the given MTU to the given IP from a UDP socket that sets the don't-fragment bit,
+// and returns the MTU that the kernel then has for the path. A probe that is too large for a link along the path
+// makes its router reply with an ICMP message that lowers the path MTU, which is reported to the socket as
+// EMSGSIZE, and another probe of the lowered MTU is then sent.
+func probePathMTU(ip net.IP, mtu int) (int, error) {
+	level, discover, do, mtuOpt, hdrLen := unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_DO, unix.IP_MTU, 20+8
+	if ip.To4() == nil {
+		level, discover, do, mtuOpt, hdrLen = unix.IPPROTO_IPV6, unix.IPV6_MTU_DISCOVER, unix.IPV6_PMTUDISC_DO, unix.IPV6_MTU, 40+8
+	}
+	d := net.Dialer{Control: func(_, _ string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			err = unix.SetsockoptInt(int(fd), level, discover, do)
+		}); cerr != nil {
+			return cerr
+		}
+		return err
+	}}
+	c, err := d.Dial("udp", (&net.UDPAddr{IP: ip, Port: mtuProbePort}).String())
+	if err != nil {
+		return 0, err
+	}
+	conn := c.(*net.UDPConn)
+	defer conn.Close()
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	pathMTU := func() (int, error) {
+		var pm int
+		var err error
+		if cerr := rc.Control(func(fd uintptr) {
+			pm, err = unix.GetsockoptInt(int(fd), level, mtuOpt)
+		}); cerr != nil {
+			return 0, cerr
+		}
+		return pm, err
+	}
+
+	buf := make([]byte, mtu)
+	for i := 0; i < maxPathMTUProbes; i++ {
+		n := mtu - hdrLen
+		if n > 0xffff-hdrLen {
+			n = 0xffff - hdrLen
+		}
+		if _, err = conn.Write(buf[:n]); err == nil {
+			_ = conn.SetReadDeadline(time.Now().Add(pathMTUProbeTimeout))
+			_, err = conn.Read(buf)
+		}
+		if !errors.Is(err, unix.EMSGSIZE) {
+			// The probe fit, or no router reported that it didn't.
+			break
+		}
+		pm, err := pathMTU()
+		if err != nil {
+			return 0, err
+		}
+		if pm >= mtu {
+			break
+		}
+		mtu = pm
+	}
+	pm, err := pathMTU()
+	if err != nil {
+		return 0, err
+	}
+	if pm < mtu {
+		mtu = pm
+	}
+	return mtu, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package iputil
+
+import (
+	"net"
+)
+
+// probePathMTU returns the given MTU, because the platform doesn't report the MTU that it has for a path.
+func probePathMTU(_ net.IP, mtu int) (int, error) {
+	return mtu, nil
+}
@@ -0,0 +1,106 @@
+package k8sapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	auth "k8s.io/api/authorization/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// Access describes a verb on a resource. An empty Namespace means that the resource is cluster-scoped, or that
+// the access is for all namespaces.
+type Access struct {
+	Verb      string
+	Group     string
+	Resource  string
+	Namespace string
+}
+
+// Accesses returns one Access for each of the given verbs on the given resource.
+func Accesses(group, resource, namespace string, verbs ...string) []Access {
+	as := make([]Access, len(verbs))
+	for i, verb := range verbs {
+		as[i] = Access{Verb: verb, Group: group, Resource: resource, Namespace: namespace}
+	}
+	return as
+}
+
+func (a *Access) qualifiedResource() string {
+	if a.Group == "" {
+		return a.Resource
+	}
+	return a.Resource + "." + a.Group
+}
+
+// CheckAccess performs a SelfSubjectAccessReview for each of the given accesses and returns an error that lists
+// every denied access, or nil when all of them are allowed. A review that can't be performed is logged and treated
+// as allowed, so that the operation itself gets to report what went wrong.
+func CheckAccess(ctx context.Context, accesses []Access) error {
+	reviews := GetK8sInterface(ctx).AuthorizationV1().SelfSubjectAccessReviews()
+	denied := make([]bool, len(accesses))
+	wg := sync.WaitGroup{}
+	wg.Add(len(accesses))
+	for i := range accesses {
+		go func(i int) {
+			defer wg.Done()
+			a := &accesses[i]
+			ar, err := reviews.Create(ctx, &auth.SelfSubjectAccessReview{
+				Spec: auth.SelfSubjectAccessReviewSpec{ResourceAttributes: &auth.ResourceAttributes{
+					Namespace: a.Namespace,
+					Verb:      a.Verb,
+					Group:     a.Group,
+					Resource:  a.Resource,
+				}},
+			}, meta.CreateOptions{})
+			if err != nil {
+				if ctx.Err() == nil {
+					dlog.Errorf(ctx, `unable to do "can-i" check verb %q, kind %q, in namespace %q: %v`, a.Verb, a.qualifiedResource(), a.Namespace, err)
+				}
+				return
+			}
+			denied[i] = !ar.Status.Allowed
+		}(i)
+	}
+	wg.Wait()
+
+	// Group the denied verbs by resource and namespace, retaining the order of the given accesses
+	type target struct {
+		resource  string
+		namespace string
+	}
+	var targets []target
+	verbs := make(map[target][]string)
+	for i := range accesses {
+		if !denied[i] {
+			continue
+		}
+		a := &accesses[i]
+		t := target{resource: a.qualifiedResource(), namespace: a.Namespace}
+		vs, ok := verbs[t]
+		if !ok {
+			targets = append(targets, t)
+		}
+		verbs[t] = append(vs, fmt.Sprintf("%q", a.Verb))
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(targets))
+	for i, t := range targets {
+		vs := verbs[t]
+		sort.Strings(vs)
+		if t.namespace == "" {
+			msgs[i] = fmt.Sprintf("you lack %s on %s", strings.Join(vs, ", "), t.resource)
+		} else {
+			msgs[i] = fmt.Sprintf("you lack %s on %s in namespace %q", strings.Join(vs, ", "), t.resource, t.namespace)
+		}
+	}
+	return errors.New(strings.Join(msgs, "\n"))
+}
@@ -0,0 +1,36 @@
+package k8sapi
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	auth "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCheckAccess(t *testing.T) {
+	ki := fake.NewSimpleClientset()
+	ki.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		ar := action.(k8stesting.CreateAction).GetObject().(*auth.SelfSubjectAccessReview)
+		ra := ar.Spec.ResourceAttributes
+		ar.Status.Allowed = ra.Verb == "get" || ra.Namespace == "open"
+		return true, ar, nil
+	})
+	ctx := WithK8sInterface(context.Background(), ki)
+
+	require.NoError(t, CheckAccess(ctx, Accesses("apps", "deployments", "dev", "get")))
+	require.NoError(t, CheckAccess(ctx, Accesses("apps", "deployments", "open", "get", "patch")))
+
+	accesses := Accesses("apps", "deployments", "dev", "get", "update", "patch")
+	accesses = append(accesses, Accesses("", "services", "dev", "update")...)
+	accesses = append(accesses, Accesses("", "namespaces", "", "create")...)
+	err := CheckAccess(ctx, accesses)
+	require.Error(t, err)
+	assert.Equal(t, `you lack "patch", "update" on deployments.apps in namespace "dev"
+you lack "update" on services in namespace "dev"
+you lack "create" on namespaces`, err.Error())
+}
@@ -7,7 +7,9 @@ import (
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
 	typedCore "k8s.io/client-go/kubernetes/typed/core/v1"
 )
 
@@ -23,6 +25,35 @@ type Object interface {
 	Patch(context.Context, types.PatchType, []byte, ...string) error
 }
 
+// GetGroupVersionKind returns the GroupVersionKind of the given object. The objects returned by the typed clients
+// have an empty TypeMeta, so their GroupVersionKind is looked up in the client-go scheme instead.
+func GetGroupVersionKind(o Object) schema.GroupVersionKind {
+	var obj runtime.Object = o
+	switch o := o.(type) {
+	case *service:
+		obj = o.Service
+	case *pod:
+		obj = o.Pod
+	case *deployment:
+		obj = o.Deployment
+	case *replicaSet:
+		obj = o.ReplicaSet
+	case *statefulSet:
+		obj = o.StatefulSet
+	case *job:
+		obj = o.Job
+	case *cronJob:
+		obj = o.CronJob
+	}
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	if gvk.Empty() {
+		if gvks, _, err := scheme.Scheme.ObjectKinds(obj); err == nil {
+			gvk = gvks[0]
+		}
+	}
+	return gvk
+}
+
 func GetService(c context.Context, name, namespace string) (Object, error) {
 	d, err := services(c, namespace).Get(c, name, meta.GetOptions{})
 	if err != nil {
@@ -8,6 +8,7 @@ import (
 	core "k8s.io/api/core/v1"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 
 	"github.com/datawire/dlib/dlog"
@@ -27,6 +28,20 @@ func GetK8sInterface(ctx context.Context) kubernetes.Interface {
 
 type kiKey struct{}
 
+func WithDynamicInterface(ctx context.Context, di dynamic.Interface) context.Context {
+	return context.WithValue(ctx, diKey{}, di)
+}
+
+func GetDynamicInterface(ctx context.Context) dynamic.Interface {
+	di, ok := ctx.Value(diKey{}).(dynamic.Interface)
+	if !ok {
+		return nil
+	}
+	return di
+}
+
+type diKey struct{}
+
 // GetPort finds a port with the given name and returns it.
 func GetPort(cn *core.Container, portName string) (*core.ContainerPort, error) {
 	ports := cn.Ports
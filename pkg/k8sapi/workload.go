@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	apps "k8s.io/api/apps/v1"
+	batch "k8s.io/api/batch/v1"
 	core "k8s.io/api/core/v1"
 	errors2 "k8s.io/apimachinery/pkg/api/errors"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -12,6 +13,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	typedApps "k8s.io/client-go/kubernetes/typed/apps/v1"
+	typedBatch "k8s.io/client-go/kubernetes/typed/batch/v1"
 )
 
 type Workload interface {
@@ -33,8 +35,13 @@ func (u UnsupportedWorkloadKindError) Error() string {
 //   1. Deployments
 //   2. ReplicaSets
 //   3. StatefulSets
+//   4. Jobs
+//   5. CronJobs
+//   6. The registered custom workload kinds, in the order of registration
 //
-// The first match is returned.
+// The first match is returned. Jobs and CronJobs that the caller isn't permitted to get are
+// skipped in the search, because access to the "batch" API group is often more restricted.
+// The same is true for custom workloads, whose kinds might not even be installed.
 func GetWorkload(c context.Context, name, namespace, workloadKind string) (obj Workload, err error) {
 	switch workloadKind {
 	case "Deployment":
@@ -43,18 +50,29 @@ func GetWorkload(c context.Context, name, namespace, workloadKind string) (obj W
 		obj, err = GetReplicaSet(c, name, namespace)
 	case "StatefulSet":
 		obj, err = GetStatefulSet(c, name, namespace)
+	case "Job":
+		obj, err = GetJob(c, name, namespace)
+	case "CronJob":
+		obj, err = GetCronJob(c, name, namespace)
 	case "":
-		for _, wk := range []string{"Deployment", "ReplicaSet", "StatefulSet"} {
+		wks := []string{"Deployment", "ReplicaSet", "StatefulSet", "Job", "CronJob"}
+		builtIn := len(wks)
+		if GetDynamicInterface(c) != nil {
+			for _, ck := range GetWorkloadKinds(c) {
+				wks = append(wks, ck.Kind)
+			}
+		}
+		for i, wk := range wks {
 			if obj, err = GetWorkload(c, name, namespace, wk); err == nil {
 				return obj, nil
 			}
-			if !errors2.IsNotFound(err) {
+			if !(errors2.IsNotFound(err) || errors2.IsForbidden(err) && (IsBatchKind(wk) || i >= builtIn)) {
 				return nil, err
 			}
 		}
 		err = errors2.NewNotFound(core.Resource("workload"), name+"."+namespace)
 	default:
-		return nil, UnsupportedWorkloadKindError(workloadKind)
+		obj, err = GetCustomWorkload(c, name, namespace, workloadKind)
 	}
 	return obj, err
 }
@@ -67,6 +85,10 @@ func WrapWorkload(workload runtime.Object) (Workload, error) {
 		return ReplicaSet(workload), nil
 	case *apps.StatefulSet:
 		return StatefulSet(workload), nil
+	case *batch.Job:
+		return Job(workload), nil
+	case *batch.CronJob:
+		return CronJob(workload), nil
 	default:
 		return nil, fmt.Errorf("unsupported workload type %T", workload)
 	}
@@ -177,6 +199,82 @@ func StatefulSetImpl(o Object) (*apps.StatefulSet, bool) {
 	return nil, false
 }
 
+// IsBatchKind returns true if the given workload kind is a Job or a CronJob. The pods of such workloads
+// run to completion, so they are short-lived and there might not be any pods at all.
+func IsBatchKind(workloadKind string) bool {
+	return workloadKind == "Job" || workloadKind == "CronJob"
+}
+
+func GetJob(c context.Context, name, namespace string) (Workload, error) {
+	d, err := jobs(c, namespace).Get(c, name, meta.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &job{d}, nil
+}
+
+// Jobs returns all jobs found in the given Namespace
+func Jobs(c context.Context, namespace string, labelSelector labels.Set) ([]Workload, error) {
+	ls, err := jobs(c, namespace).List(c, listOptions(labelSelector))
+	if err != nil {
+		return nil, err
+	}
+	is := ls.Items
+	os := make([]Workload, len(is))
+	for i := range is {
+		os[i] = Job(&is[i])
+	}
+	return os, nil
+}
+
+func Job(d *batch.Job) Workload {
+	return &job{d}
+}
+
+// JobImpl casts the given Object as an *batch.Job and returns
+// it together with a status flag indicating whether the cast was possible
+func JobImpl(o Object) (*batch.Job, bool) {
+	if s, ok := o.(*job); ok {
+		return s.Job, true
+	}
+	return nil, false
+}
+
+func GetCronJob(c context.Context, name, namespace string) (Workload, error) {
+	d, err := cronJobs(c, namespace).Get(c, name, meta.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &cronJob{d}, nil
+}
+
+// CronJobs returns all cron jobs found in the given Namespace
+func CronJobs(c context.Context, namespace string, labelSelector labels.Set) ([]Workload, error) {
+	ls, err := cronJobs(c, namespace).List(c, listOptions(labelSelector))
+	if err != nil {
+		return nil, err
+	}
+	is := ls.Items
+	os := make([]Workload, len(is))
+	for i := range is {
+		os[i] = CronJob(&is[i])
+	}
+	return os, nil
+}
+
+func CronJob(d *batch.CronJob) Workload {
+	return &cronJob{d}
+}
+
+// CronJobImpl casts the given Object as an *batch.CronJob and returns
+// it together with a status flag indicating whether the cast was possible
+func CronJobImpl(o Object) (*batch.CronJob, bool) {
+	if s, ok := o.(*cronJob); ok {
+		return s.CronJob, true
+	}
+	return nil, false
+}
+
 type deployment struct {
 	*apps.Deployment
 }
@@ -371,3 +469,137 @@ func (o *statefulSet) Updated(origGeneration int64) bool {
 		o.Status.CurrentReplicas == o.Status.Replicas
 	return applied
 }
+
+type job struct {
+	*batch.Job
+}
+
+func jobs(c context.Context, namespace string) typedBatch.JobInterface {
+	return GetK8sInterface(c).BatchV1().Jobs(namespace)
+}
+
+func (o *job) ki(c context.Context) typedBatch.JobInterface {
+	return jobs(c, o.Namespace)
+}
+
+func (o *job) GetKind() string {
+	return "Job"
+}
+
+func (o *job) Delete(c context.Context) error {
+	// The default propagation policy for jobs orphans their pods
+	bg := meta.DeletePropagationBackground
+	return o.ki(c).Delete(c, o.Name, meta.DeleteOptions{PropagationPolicy: &bg})
+}
+
+func (o *job) GetPodTemplate() *core.PodTemplateSpec {
+	return &o.Spec.Template
+}
+
+func (o *job) Patch(c context.Context, pt types.PatchType, data []byte, subresources ...string) error {
+	d, err := o.ki(c).Patch(c, o.Name, pt, data, meta.PatchOptions{}, subresources...)
+	if err == nil {
+		o.Job = d
+	}
+	return err
+}
+
+func (o *job) Refresh(c context.Context) error {
+	d, err := o.ki(c).Get(c, o.Name, meta.GetOptions{})
+	if err == nil {
+		o.Job = d
+	}
+	return err
+}
+
+func (o *job) Replicas() int {
+	return int(o.Status.Active)
+}
+
+func (o *job) Selector() (labels.Selector, error) {
+	return meta.LabelSelectorAsSelector(o.Spec.Selector)
+}
+
+func (o *job) Update(c context.Context) error {
+	d, err := o.ki(c).Update(c, o.Job, meta.UpdateOptions{})
+	if err == nil {
+		o.Job = d
+	}
+	return err
+}
+
+func (o *job) Updated(origGeneration int64) bool {
+	// The pod template of a job is immutable, and a job has no observed generation
+	return o.ObjectMeta.Generation >= origGeneration
+}
+
+type cronJob struct {
+	*batch.CronJob
+}
+
+func cronJobs(c context.Context, namespace string) typedBatch.CronJobInterface {
+	return GetK8sInterface(c).BatchV1().CronJobs(namespace)
+}
+
+func (o *cronJob) ki(c context.Context) typedBatch.CronJobInterface {
+	return cronJobs(c, o.Namespace)
+}
+
+func (o *cronJob) GetKind() string {
+	return "CronJob"
+}
+
+func (o *cronJob) Delete(c context.Context) error {
+	bg := meta.DeletePropagationBackground
+	return o.ki(c).Delete(c, o.Name, meta.DeleteOptions{PropagationPolicy: &bg})
+}
+
+func (o *cronJob) GetPodTemplate() *core.PodTemplateSpec {
+	return &o.Spec.JobTemplate.Spec.Template
+}
+
+func (o *cronJob) Patch(c context.Context, pt types.PatchType, data []byte, subresources ...string) error {
+	d, err := o.ki(c).Patch(c, o.Name, pt, data, meta.PatchOptions{}, subresources...)
+	if err == nil {
+		o.CronJob = d
+	}
+	return err
+}
+
+func (o *cronJob) Refresh(c context.Context) error {
+	d, err := o.ki(c).Get(c, o.Name, meta.GetOptions{})
+	if err == nil {
+		o.CronJob = d
+	}
+	return err
+}
+
+func (o *cronJob) Replicas() int {
+	return len(o.Status.Active)
+}
+
+// Selector returns a selector for the pods of all jobs of the cron job. The selector of a job is normally
+// generated when the job is created, so the labels of the job template are used unless a selector is declared.
+func (o *cronJob) Selector() (labels.Selector, error) {
+	js := &o.Spec.JobTemplate.Spec
+	if js.Selector != nil {
+		return meta.LabelSelectorAsSelector(js.Selector)
+	}
+	if lbs := js.Template.Labels; len(lbs) > 0 {
+		return labels.SelectorFromSet(lbs), nil
+	}
+	return labels.Nothing(), nil
+}
+
+func (o *cronJob) Update(c context.Context) error {
+	d, err := o.ki(c).Update(c, o.CronJob, meta.UpdateOptions{})
+	if err == nil {
+		o.CronJob = d
+	}
+	return err
+}
+
+func (o *cronJob) Updated(origGeneration int64) bool {
+	// A cron job has no observed generation. Changes are applied to the jobs that it creates next
+	return o.ObjectMeta.Generation >= origGeneration
+}
@@ -0,0 +1,393 @@
+package k8sapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	core "k8s.io/api/core/v1"
+	errors2 "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// WorkloadKind describes a custom resource, such as an Argo Rollout, that manages pods using a pod template.
+// Workloads of the registered kinds are accessed using the dynamic client.
+type WorkloadKind struct {
+	// Kind is the kind of the resource, e.g. "Rollout"
+	Kind string `json:"kind"`
+
+	// APIVersion is the group and version of the resource, e.g. "argoproj.io/v1alpha1"
+	APIVersion string `json:"apiVersion,omitempty"`
+
+	// Resource is the plural name of the resource, e.g. "rollouts"
+	Resource string `json:"resource,omitempty"`
+
+	// TemplatePath is the dot separated path to the pod template. Default is "spec.template"
+	TemplatePath string `json:"templatePath,omitempty"`
+
+	// SelectorPath is the dot separated path to the label selector of the pods. Default is "spec.selector"
+	SelectorPath string `json:"selectorPath,omitempty"`
+
+	// ReplicasPath is the dot separated path to the current number of pods. Default is "status.replicas"
+	ReplicasPath string `json:"replicasPath,omitempty"`
+
+	// PodLabel is a label that the controller of the resource adds to its pods, with the name of the resource
+	// as the value. It's used to find the workload of a pod that isn't owned by it, such as the pod of a
+	// Knative Service, and as the selector of the pods when the resource has no selector.
+	PodLabel string `json:"podLabel,omitempty"`
+
+	// MinScaleAnnotation is the pod template annotation that declares the minimum number of pods of a resource
+	// that scales to zero, e.g. "autoscaling.knative.dev/min-scale"
+	MinScaleAnnotation string `json:"minScaleAnnotation,omitempty"`
+}
+
+// WorkloadKinds is a list of custom workload kinds. It's decoded from its JSON representation in the environment.
+type WorkloadKinds []WorkloadKind
+
+// wellKnownWorkloadKinds are always registered. A configured kind with the same name takes precedence, and
+// its empty fields are set from the well-known kind.
+var wellKnownWorkloadKinds = WorkloadKinds{
+	{
+		Kind:       "Rollout",
+		APIVersion: "argoproj.io/v1alpha1",
+		Resource:   "rollouts",
+	},
+	{
+		Kind:       "Service",
+		APIVersion: "serving.knative.dev/v1",
+		Resource:   "services",
+		PodLabel:   "serving.knative.dev/service",
+
+		MinScaleAnnotation: "autoscaling.knative.dev/min-scale",
+	},
+}
+
+func (ks *WorkloadKinds) EnvDecode(val string) error {
+	*ks = nil
+	if val == "" {
+		return nil
+	}
+	var wks WorkloadKinds
+	if err := json.Unmarshal([]byte(val), &wks); err != nil {
+		return fmt.Errorf("invalid WorkloadKinds: %w", err)
+	}
+	for i := range wks {
+		if err := wks[i].complete(); err != nil {
+			return err
+		}
+	}
+	*ks = wks
+	return nil
+}
+
+// complete sets the empty fields from the well-known kind of the same name and validates the result.
+func (k *WorkloadKind) complete() error {
+	switch k.Kind {
+	case "":
+		return fmt.Errorf("invalid WorkloadKinds: missing kind")
+	case "Deployment", "ReplicaSet", "StatefulSet", "Job", "CronJob":
+		return fmt.Errorf("invalid WorkloadKinds: %s is a built-in workload kind", k.Kind)
+	}
+	for i := range wellKnownWorkloadKinds {
+		if wk := &wellKnownWorkloadKinds[i]; wk.Kind == k.Kind {
+			if k.APIVersion == "" {
+				k.APIVersion = wk.APIVersion
+			}
+			if k.Resource == "" {
+				k.Resource = wk.Resource
+			}
+			if k.PodLabel == "" {
+				k.PodLabel = wk.PodLabel
+			}
+			if k.MinScaleAnnotation == "" {
+				k.MinScaleAnnotation = wk.MinScaleAnnotation
+			}
+			break
+		}
+	}
+	if !strings.Contains(k.APIVersion, "/") {
+		return fmt.Errorf("invalid WorkloadKinds: %s must have an apiVersion of the form <group>/<version>", k.Kind)
+	}
+	if k.Resource == "" {
+		return fmt.Errorf("invalid WorkloadKinds: %s must have a resource", k.Kind)
+	}
+	return nil
+}
+
+func (k *WorkloadKind) groupVersionResource() schema.GroupVersionResource {
+	gv, _ := schema.ParseGroupVersion(k.APIVersion)
+	return gv.WithResource(k.Resource)
+}
+
+func fieldPath(path, defaultPath string) []string {
+	if path == "" {
+		path = defaultPath
+	}
+	return strings.Split(path, ".")
+}
+
+// MergeWorkloadKinds returns the well-known workload kinds merged with the given configured kinds.
+func MergeWorkloadKinds(configured WorkloadKinds) WorkloadKinds {
+	merged := make(WorkloadKinds, 0, len(wellKnownWorkloadKinds)+len(configured))
+	for _, wk := range wellKnownWorkloadKinds {
+		found := false
+		for _, ck := range configured {
+			if ck.Kind == wk.Kind {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, wk)
+		}
+	}
+	return append(merged, configured...)
+}
+
+// WithWorkloadKinds returns a context that registers the given custom workload kinds. The workloads of the
+// custom kinds are accessed using the dynamic Interface of the context.
+func WithWorkloadKinds(ctx context.Context, kinds WorkloadKinds) context.Context {
+	return context.WithValue(ctx, wkKey{}, kinds)
+}
+
+// GetWorkloadKinds returns the custom workload kinds that are registered in the given context.
+func GetWorkloadKinds(ctx context.Context) WorkloadKinds {
+	wks, _ := ctx.Value(wkKey{}).(WorkloadKinds)
+	return wks
+}
+
+type wkKey struct{}
+
+func findWorkloadKind(c context.Context, kind string) *WorkloadKind {
+	wks := GetWorkloadKinds(c)
+	for i := range wks {
+		if wks[i].Kind == kind {
+			return &wks[i]
+		}
+	}
+	return nil
+}
+
+// LabeledWorkloadRef returns the kind and name of the custom workload that the given labels refer to using the
+// PodLabel of a registered kind. Empty strings are returned when there is no such label.
+func LabeledWorkloadRef(c context.Context, lbs map[string]string) (kind, name string) {
+	if len(lbs) == 0 {
+		return "", ""
+	}
+	for _, wk := range GetWorkloadKinds(c) {
+		if wk.PodLabel != "" {
+			if name = lbs[wk.PodLabel]; name != "" {
+				return wk.Kind, name
+			}
+		}
+	}
+	return "", ""
+}
+
+func GetCustomWorkload(c context.Context, name, namespace, workloadKind string) (Workload, error) {
+	wk := findWorkloadKind(c, workloadKind)
+	if wk == nil || GetDynamicInterface(c) == nil {
+		return nil, UnsupportedWorkloadKindError(workloadKind)
+	}
+	u, err := customWorkloads(c, wk, namespace).Get(c, name, meta.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	wl, err := newCustomWorkload(u, wk)
+	if err != nil {
+		return nil, err
+	}
+	return wl, nil
+}
+
+// CustomWorkloads returns the workloads of all registered custom kinds found in the given Namespace. Kinds that
+// aren't installed in the cluster, or that can't be listed, are skipped.
+func CustomWorkloads(c context.Context, namespace string, labelSelector labels.Set) ([]Workload, error) {
+	if GetDynamicInterface(c) == nil {
+		return nil, nil
+	}
+	var os []Workload
+	wks := GetWorkloadKinds(c)
+	for i := range wks {
+		wk := &wks[i]
+		ls, err := customWorkloads(c, wk, namespace).List(c, listOptions(labelSelector))
+		if err != nil {
+			if errors2.IsNotFound(err) || errors2.IsForbidden(err) {
+				continue
+			}
+			return nil, err
+		}
+		for j := range ls.Items {
+			wl, err := newCustomWorkload(&ls.Items[j], wk)
+			if err != nil {
+				return nil, err
+			}
+			os = append(os, wl)
+		}
+	}
+	return os, nil
+}
+
+// CustomWorkloadImpl casts the given Object as an *unstructured.Unstructured and returns it together
+// with a status flag indicating whether the cast was possible
+func CustomWorkloadImpl(o Object) (*unstructured.Unstructured, bool) {
+	if s, ok := o.(*customWorkload); ok {
+		return s.Unstructured, true
+	}
+	return nil, false
+}
+
+// MinScaleAnnotation returns the pod template annotation that declares the minimum number of pods of the given
+// workload, or an empty string when the workload doesn't scale to zero.
+func MinScaleAnnotation(wl Workload) string {
+	if cw, ok := wl.(*customWorkload); ok {
+		return cw.kind.MinScaleAnnotation
+	}
+	return ""
+}
+
+// PatchPodTemplateMetadata merges the given JSON object into the metadata of the pod template of the given
+// workload, e.g. `{"annotations": {"x": "y"}}`.
+func PatchPodTemplateMetadata(c context.Context, wl Workload, metadata string) error {
+	if cw, ok := wl.(*customWorkload); ok {
+		// Custom resources don't support strategic merge patches
+		patch := `{"metadata": ` + metadata + `}`
+		path := fieldPath(cw.kind.TemplatePath, "spec.template")
+		for i := len(path) - 1; i >= 0; i-- {
+			patch = fmt.Sprintf(`{%q: %s}`, path[i], patch)
+		}
+		return wl.Patch(c, types.MergePatchType, []byte(patch))
+	}
+	patch := `{"spec": {"template": {"metadata": ` + metadata + `}}}`
+	if _, ok := wl.(*cronJob); ok {
+		patch = `{"spec": {"jobTemplate": ` + patch + `}}`
+	}
+	return wl.Patch(c, types.StrategicMergePatchType, []byte(patch))
+}
+
+type customWorkload struct {
+	*unstructured.Unstructured
+	kind     *WorkloadKind
+	template *core.PodTemplateSpec
+}
+
+func customWorkloads(c context.Context, wk *WorkloadKind, namespace string) dynamic.ResourceInterface {
+	return GetDynamicInterface(c).Resource(wk.groupVersionResource()).Namespace(namespace)
+}
+
+func newCustomWorkload(u *unstructured.Unstructured, wk *WorkloadKind) (*customWorkload, error) {
+	o := &customWorkload{kind: wk}
+	if err := o.set(u); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// set assigns the given resource and converts its pod template.
+func (o *customWorkload) set(u *unstructured.Unstructured) error {
+	path := fieldPath(o.kind.TemplatePath, "spec.template")
+	m, ok, err := unstructured.NestedMap(u.Object, path...)
+	if err == nil && !ok {
+		err = fmt.Errorf("no pod template found at %s", strings.Join(path, "."))
+	}
+	if err == nil {
+		tpl := &core.PodTemplateSpec{}
+		if err = runtime.DefaultUnstructuredConverter.FromUnstructured(m, tpl); err == nil {
+			o.Unstructured = u
+			o.template = tpl
+			return nil
+		}
+	}
+	return fmt.Errorf("%s %s.%s: %w", o.kind.Kind, u.GetName(), u.GetNamespace(), err)
+}
+
+func (o *customWorkload) ki(c context.Context) dynamic.ResourceInterface {
+	return customWorkloads(c, o.kind, o.GetNamespace())
+}
+
+func (o *customWorkload) Delete(c context.Context) error {
+	return o.ki(c).Delete(c, o.GetName(), meta.DeleteOptions{})
+}
+
+// GetPodTemplate returns the pod template of the resource. Changes made to it are written back to the
+// resource by Update.
+func (o *customWorkload) GetPodTemplate() *core.PodTemplateSpec {
+	return o.template
+}
+
+func (o *customWorkload) Patch(c context.Context, pt types.PatchType, data []byte, subresources ...string) error {
+	u, err := o.ki(c).Patch(c, o.GetName(), pt, data, meta.PatchOptions{}, subresources...)
+	if err == nil {
+		err = o.set(u)
+	}
+	return err
+}
+
+func (o *customWorkload) Refresh(c context.Context) error {
+	u, err := o.ki(c).Get(c, o.GetName(), meta.GetOptions{})
+	if err == nil {
+		err = o.set(u)
+	}
+	return err
+}
+
+func (o *customWorkload) Replicas() int {
+	n, _, _ := unstructured.NestedInt64(o.Object, fieldPath(o.kind.ReplicasPath, "status.replicas")...)
+	return int(n)
+}
+
+// Selector returns the selector found at the SelectorPath, or a selector that uses the PodLabel, or lastly,
+// a selector for the labels of the pod template.
+func (o *customWorkload) Selector() (labels.Selector, error) {
+	m, ok, err := unstructured.NestedMap(o.Object, fieldPath(o.kind.SelectorPath, "spec.selector")...)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		ls := &meta.LabelSelector{}
+		if err = runtime.DefaultUnstructuredConverter.FromUnstructured(m, ls); err != nil {
+			return nil, err
+		}
+		return meta.LabelSelectorAsSelector(ls)
+	}
+	if o.kind.PodLabel != "" {
+		return labels.SelectorFromSet(labels.Set{o.kind.PodLabel: o.GetName()}), nil
+	}
+	if lbs := o.template.Labels; len(lbs) > 0 {
+		return labels.SelectorFromSet(lbs), nil
+	}
+	return labels.Nothing(), nil
+}
+
+func (o *customWorkload) Update(c context.Context) error {
+	m, err := runtime.DefaultUnstructuredConverter.ToUnstructured(o.template)
+	if err != nil {
+		return err
+	}
+	if err = unstructured.SetNestedMap(o.Object, m, fieldPath(o.kind.TemplatePath, "spec.template")...); err != nil {
+		return err
+	}
+	u, err := o.ki(c).Update(c, o.Unstructured, meta.UpdateOptions{})
+	if err == nil {
+		err = o.set(u)
+	}
+	return err
+}
+
+// Updated returns true when the generation has been observed. The observed generation is a string in some
+// resources, e.g. in an Argo Rollout, and it's absent in others.
+func (o *customWorkload) Updated(origGeneration int64) bool {
+	gen := o.GetGeneration()
+	if gen < origGeneration {
+		return false
+	}
+	og, ok, _ := unstructured.NestedFieldNoCopy(o.Object, "status", "observedGeneration")
+	return !ok || fmt.Sprint(og) == fmt.Sprint(gen)
+}
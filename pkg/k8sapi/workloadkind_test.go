@@ -0,0 +1,169 @@
+package k8sapi
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apps "k8s.io/api/apps/v1"
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	errors2 "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicFake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWorkloadKinds_EnvDecode(t *testing.T) {
+	var wks WorkloadKinds
+	require.NoError(t, wks.EnvDecode(""))
+	assert.Nil(t, wks)
+
+	require.NoError(t, wks.EnvDecode(`[{"kind": "Rollout"}, {"kind": "CloneSet", "apiVersion": "apps.kruise.io/v1alpha1", "resource": "clonesets"}]`))
+	assert.Equal(t, WorkloadKinds{
+		{Kind: "Rollout", APIVersion: "argoproj.io/v1alpha1", Resource: "rollouts"},
+		{Kind: "CloneSet", APIVersion: "apps.kruise.io/v1alpha1", Resource: "clonesets"},
+	}, wks)
+
+	assert.Error(t, wks.EnvDecode(`[{"kind": "Deployment"}]`))
+	assert.Error(t, wks.EnvDecode(`[{"kind": "CloneSet", "resource": "clonesets"}]`))
+	assert.Error(t, wks.EnvDecode(`[{"kind": "CloneSet", "apiVersion": "apps.kruise.io/v1alpha1"}]`))
+	assert.Error(t, wks.EnvDecode(`{"kind": "Rollout"}`))
+}
+
+func TestMergeWorkloadKinds(t *testing.T) {
+	merged := MergeWorkloadKinds(WorkloadKinds{{Kind: "Rollout", APIVersion: "argoproj.io/v1beta1", Resource: "rollouts"}})
+	require.Len(t, merged, 2)
+	assert.Equal(t, "Service", merged[0].Kind)
+	assert.Equal(t, "argoproj.io/v1beta1", merged[1].APIVersion)
+}
+
+var (
+	rolloutsGVR = schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "rollouts"}
+	knativeGVR  = schema.GroupVersionResource{Group: "serving.knative.dev", Version: "v1", Resource: "services"}
+)
+
+func customObject(apiVersion, kind, name string, spec, status map[string]any) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"metadata":   map[string]any{"name": name, "namespace": "default", "generation": int64(2)},
+		"spec":       spec,
+		"status":     status,
+	}}
+}
+
+func podTemplate(lbs map[string]any) map[string]any {
+	return map[string]any{
+		"metadata": map[string]any{"labels": lbs},
+		"spec": map[string]any{
+			"containers": []any{map[string]any{"name": "echo", "image": "echo:1.0"}},
+		},
+	}
+}
+
+func customWorkloadContext(objs ...runtime.Object) context.Context {
+	di := dynamicFake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		rolloutsGVR: "RolloutList",
+		knativeGVR:  "ServiceList",
+	}, objs...)
+	ctx := WithK8sInterface(context.Background(), fake.NewSimpleClientset())
+	ctx = WithDynamicInterface(ctx, di)
+	return WithWorkloadKinds(ctx, MergeWorkloadKinds(nil))
+}
+
+func TestGetWorkload_custom(t *testing.T) {
+	ctx := customWorkloadContext(
+		customObject("argoproj.io/v1alpha1", "Rollout", "echo", map[string]any{
+			"selector": map[string]any{"matchLabels": map[string]any{"app": "echo"}},
+			"template": podTemplate(map[string]any{"app": "echo"}),
+		}, map[string]any{"replicas": int64(3), "observedGeneration": "2"}),
+		customObject("serving.knative.dev/v1", "Service", "hello", map[string]any{
+			"template": podTemplate(nil),
+		}, map[string]any{"observedGeneration": int64(1)}),
+	)
+
+	wl, err := GetWorkload(ctx, "echo", "default", "")
+	require.NoError(t, err)
+	assert.Equal(t, "Rollout", wl.GetKind())
+	assert.Equal(t, "echo:1.0", wl.GetPodTemplate().Spec.Containers[0].Image)
+	assert.Equal(t, 3, wl.Replicas())
+	assert.True(t, wl.Updated(2))
+	assert.False(t, wl.Updated(3))
+	sel, err := wl.Selector()
+	require.NoError(t, err)
+	assert.True(t, sel.Matches(labels.Set{"app": "echo"}))
+
+	wl, err = GetWorkload(ctx, "hello", "default", "Service")
+	require.NoError(t, err)
+	assert.Equal(t, 0, wl.Replicas())
+	assert.False(t, wl.Updated(2), "generation 2 isn't observed")
+	sel, err = wl.Selector()
+	require.NoError(t, err)
+	assert.True(t, sel.Matches(labels.Set{"serving.knative.dev/service": "hello"}))
+
+	kind, name := LabeledWorkloadRef(ctx, map[string]string{"serving.knative.dev/service": "hello"})
+	assert.Equal(t, "Service", kind)
+	assert.Equal(t, "hello", name)
+
+	_, err = GetWorkload(ctx, "nope", "default", "")
+	assert.True(t, errors2.IsNotFound(err))
+	_, err = GetWorkload(ctx, "echo", "default", "CloneSet")
+	assert.ErrorIs(t, err, UnsupportedWorkloadKindError("CloneSet"))
+
+	wls, err := CustomWorkloads(ctx, "default", labels.Set{})
+	require.NoError(t, err)
+	assert.Len(t, wls, 2)
+}
+
+func TestGetGroupVersionKind(t *testing.T) {
+	ctx := customWorkloadContext(customObject("argoproj.io/v1alpha1", "Rollout", "echo", map[string]any{
+		"template": podTemplate(nil),
+	}, nil))
+	rollout, err := GetWorkload(ctx, "echo", "default", "Rollout")
+	require.NoError(t, err)
+
+	tests := []struct {
+		obj  Object
+		want string
+	}{
+		{Deployment(&apps.Deployment{}), "apps/v1, Kind=Deployment"},
+		{StatefulSet(&apps.StatefulSet{}), "apps/v1, Kind=StatefulSet"},
+		{Job(&batch.Job{}), "batch/v1, Kind=Job"},
+		{CronJob(&batch.CronJob{}), "batch/v1, Kind=CronJob"},
+		{Pod(&core.Pod{}), "/v1, Kind=Pod"},
+		{rollout, "argoproj.io/v1alpha1, Kind=Rollout"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, GetGroupVersionKind(tt.obj).String())
+	}
+}
+
+func TestCustomWorkload_modify(t *testing.T) {
+	ctx := customWorkloadContext(customObject("argoproj.io/v1alpha1", "Rollout", "echo", map[string]any{
+		"template": podTemplate(map[string]any{"app": "echo"}),
+	}, nil))
+
+	wl, err := GetWorkload(ctx, "echo", "default", "Rollout")
+	require.NoError(t, err)
+	require.NoError(t, PatchPodTemplateMetadata(ctx, wl, `{"annotations": {"x": "y"}}`))
+	assert.Equal(t, "y", wl.GetPodTemplate().Annotations["x"])
+
+	tpl := wl.GetPodTemplate()
+	tpl.Spec.Containers = append(tpl.Spec.Containers, core.Container{Name: "traffic-agent", Image: "tel2:2.7.0"})
+	require.NoError(t, wl.Update(ctx))
+
+	u, err := GetDynamicInterface(ctx).Resource(rolloutsGVR).Namespace("default").Get(ctx, "echo", meta.GetOptions{})
+	require.NoError(t, err)
+	cns, _, err := unstructured.NestedSlice(u.Object, "spec", "template", "spec", "containers")
+	require.NoError(t, err)
+	assert.Len(t, cns, 2)
+	ans, _, err := unstructured.NestedStringMap(u.Object, "spec", "template", "metadata", "annotations")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"x": "y"}, ans)
+}
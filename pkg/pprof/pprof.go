@@ -0,0 +1,32 @@
+// Package pprof serves the net/http/pprof endpoints of a long-running process on a localhost port.
+package pprof
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/datawire/dlib/dhttp"
+	"github.com/datawire/dlib/dlog"
+)
+
+// Serve starts an HTTP server on localhost:<port> that serves the standard /debug/pprof endpoints. The server
+// terminates when the given context is cancelled.
+func Serve(ctx context.Context, port uint16) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	addr := fmt.Sprintf("localhost:%d", port)
+	sc := &dhttp.ServerConfig{Handler: mux}
+	dlog.Infof(ctx, "pprof server started on %s", addr)
+	err := sc.ListenAndServe(ctx, addr)
+	if err != nil && ctx.Err() != nil {
+		err = nil // Normal shutdown
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+package proc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// vfsCapFlagsEffective is set in the magic_etc of a vfs_cap_data when the permitted file
+	// capabilities are raised in the effective set on execve.
+	vfsCapFlagsEffective = 0x000001
+
+	// xattrNameCaps is the extended attribute where setcap(8) stores the file capabilities.
+	xattrNameCaps = "security.capability"
+)
+
+// hasNetAdmin returns true if the current process has the CAP_NET_ADMIN capability in its effective set.
+func hasNetAdmin() bool {
+	return processCapSet("CapEff")&(1<<unix.CAP_NET_ADMIN) != 0
+}
+
+// canNetAdmin returns true if a process that is started from the given executable will get the CAP_NET_ADMIN
+// capability without the use of sudo. That's the case when the executable has been given the capability
+// using setcap(8), or when the current process has the capability in its ambient set, e.g. because it was
+// started by systemd with AmbientCapabilities=CAP_NET_ADMIN.
+func canNetAdmin(exe string) bool {
+	if isAdmin() {
+		return false
+	}
+	return processCapSet("CapAmb")&(1<<unix.CAP_NET_ADMIN) != 0 || fileHasNetAdmin(exe)
+}
+
+// processCapSet returns the given capability set of the current process as reported by /proc/self/status.
+func processCapSet(name string) uint64 {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	prefix := name + ":"
+	for sc.Scan() {
+		if line := sc.Text(); strings.HasPrefix(line, prefix) {
+			caps, err := strconv.ParseUint(strings.TrimSpace(line[len(prefix):]), 16, 64)
+			if err != nil {
+				return 0
+			}
+			return caps
+		}
+	}
+	return 0
+}
+
+// fileHasNetAdmin returns true if the given file has CAP_NET_ADMIN among its permitted file capabilities
+// and the capabilities are raised in the effective set when the file is executed.
+func fileHasNetAdmin(exe string) bool {
+	// The vfs_cap_data is a little endian magic_etc followed by pairs of permitted and inheritable
+	// capability masks. CAP_NET_ADMIN is in the first permitted mask.
+	buf := make([]byte, 24)
+	n, err := unix.Getxattr(exe, xattrNameCaps, buf)
+	if err != nil || n < 8 {
+		return false
+	}
+	magicEtc := binary.LittleEndian.Uint32(buf)
+	permitted := binary.LittleEndian.Uint32(buf[4:])
+	return magicEtc&vfsCapFlagsEffective != 0 && permitted&(1<<unix.CAP_NET_ADMIN) != 0
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package proc
+
+func hasNetAdmin() bool {
+	return false
+}
+
+func canNetAdmin(_ string) bool {
+	return false
+}
@@ -82,10 +82,43 @@ func StartInBackground(args ...string) error {
 	return startInBackground(args...)
 }
 
-func StartInBackgroundAsRoot(ctx context.Context, args ...string) error {
-	return startInBackgroundAsRoot(ctx, args...)
+// Elevation controls how StartInBackgroundAsRoot elevates the privileges of the process that it starts.
+type Elevation struct {
+	// Command is the command, with arguments, that is prepended to the command line of the process. The
+	// platform default (sudo or UAC on Windows) is used when it's empty.
+	Command []string
+
+	// Prompt is printed before the user is asked for permission to elevate privileges. A default message
+	// is used when it's empty.
+	Prompt string
+}
+
+func (e *Elevation) prompt(args []string) string {
+	if e.Prompt != "" {
+		return e.Prompt
+	}
+	return fmt.Sprintf("Need root privileges to run: %s", shellquote.ShellString(args[0], args[1:]))
+}
+
+func StartInBackgroundAsRoot(ctx context.Context, elevation Elevation, args ...string) error {
+	return startInBackgroundAsRoot(ctx, elevation, args...)
 }
 
 func IsAdmin() bool {
 	return isAdmin()
 }
+
+// HasNetAdmin returns true if the current process has the capability to configure the network without
+// being an administrator. This is only supported on Linux, where it means that the process has the
+// CAP_NET_ADMIN capability.
+func HasNetAdmin() bool {
+	return hasNetAdmin()
+}
+
+// CanNetAdmin returns true if the current process isn't an administrator, but a process started from the
+// current executable will get the capability to configure the network. A root daemon can then be started
+// without the use of sudo.
+func CanNetAdmin() bool {
+	exe, err := os.Executable()
+	return err == nil && canNetAdmin(exe)
+}
@@ -45,36 +45,55 @@ func startInBackground(args ...string) error {
 	return nil
 }
 
-func startInBackgroundAsRoot(ctx context.Context, args ...string) error {
-	if !isAdmin() {
-		// If we're going to be prompting for the `sudo` password, we want to first provide
-		// the user with some info about exactly what we're prompting for.  We don't want to
-		// use `sudo`'s `--prompt` flag for this because (1) we don't want it to be
-		// re-displayed if they typo their password, and (2) it might be ignored anyway
-		// depending on `passprompt_override` in `/etc/sudoers`.  So we'll do a pre-flight
-		// `sudo --non-interactive true` to decide whether to display it.
-		//
-		// Note: Using `sudo --non-interactive --validate` does not work well in situations
-		// where the user has configured `myuser ALL=(ALL:ALL) NOPASSWD: ALL` in the sudoers
-		// file. Hence the use of `sudo --non-interactive true`. A plausible cause can be
-		// found in the first comment here:
-		// https://unix.stackexchange.com/questions/50584/why-sudo-timestamp-is-not-updated-when-nopasswd-is-set
-		needPwCmd := dexec.CommandContext(ctx, "sudo", "--non-interactive", "true")
-		needPwCmd.DisableLogging = true
-		if err := needPwCmd.Run(); err != nil {
-			fmt.Printf("Need root privileges to run: %s\n", shellquote.ShellString(args[0], args[1:]))
-			// `sudo` won't be able to read the password from the terminal when we run
-			// it with Setpgid=true, so do a pre-flight `sudo true` to read the
-			// password, and then enforce that being re-used by passing
-			// `--non-interactive`.
-			pwCmd := dexec.CommandContext(ctx, "sudo", "true")
-			pwCmd.DisableLogging = true
-			if err := pwCmd.Run(); err != nil {
-				return err
-			}
+func startInBackgroundAsRoot(ctx context.Context, elevation Elevation, args ...string) error {
+	if isAdmin() || canNetAdmin(args[0]) {
+		// The process will have the privileges that it needs, so there's no need for sudo
+		return startInBackground(args...)
+	}
+
+	if ec := elevation.Command; len(ec) > 0 {
+		// Do a pre-flight `<command> true` in the foreground so that the elevation command can
+		// authenticate the user using the terminal. The command is then expected to reuse that
+		// authentication when starting the background process, or to authenticate without a
+		// terminal (e.g. `sudo --askpass`).
+		fmt.Println(elevation.prompt(args))
+		pfArgs := append(append([]string{}, ec[1:]...), "true")
+		pfCmd := dexec.CommandContext(ctx, ec[0], pfArgs...)
+		pfCmd.DisableLogging = true
+		pfCmd.Stdin = os.Stdin
+		pfCmd.Stdout = os.Stdout
+		pfCmd.Stderr = os.Stderr
+		if err := pfCmd.Run(); err != nil {
+			return fmt.Errorf("%s: %w", shellquote.ShellString(ec[0], pfArgs), err)
 		}
-		args = append([]string{"sudo", "--non-interactive"}, args...)
+		return startInBackground(append(append([]string{}, ec...), args...)...)
 	}
 
-	return startInBackground(args...)
+	// If we're going to be prompting for the `sudo` password, we want to first provide
+	// the user with some info about exactly what we're prompting for.  We don't want to
+	// use `sudo`'s `--prompt` flag for this because (1) we don't want it to be
+	// re-displayed if they typo their password, and (2) it might be ignored anyway
+	// depending on `passprompt_override` in `/etc/sudoers`.  So we'll do a pre-flight
+	// `sudo --non-interactive true` to decide whether to display it.
+	//
+	// Note: Using `sudo --non-interactive --validate` does not work well in situations
+	// where the user has configured `myuser ALL=(ALL:ALL) NOPASSWD: ALL` in the sudoers
+	// file. Hence the use of `sudo --non-interactive true`. A plausible cause can be
+	// found in the first comment here:
+	// https://unix.stackexchange.com/questions/50584/why-sudo-timestamp-is-not-updated-when-nopasswd-is-set
+	needPwCmd := dexec.CommandContext(ctx, "sudo", "--non-interactive", "true")
+	needPwCmd.DisableLogging = true
+	if err := needPwCmd.Run(); err != nil {
+		fmt.Println(elevation.prompt(args))
+		// `sudo` won't be able to read the password from the terminal when we run
+		// it with Setpgid=true, so do a pre-flight `sudo true` to read the
+		// password, and then enforce that being re-used by passing
+		// `--non-interactive`.
+		pwCmd := dexec.CommandContext(ctx, "sudo", "true")
+		pwCmd.DisableLogging = true
+		if err := pwCmd.Run(); err != nil {
+			return err
+		}
+	}
+	return startInBackground(append([]string{"sudo", "--non-interactive"}, args...)...)
 }
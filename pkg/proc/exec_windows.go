@@ -2,6 +2,7 @@ package proc
 
 import (
 	"context"
+	"fmt"
 	"os"
 
 	"golang.org/x/sys/windows"
@@ -22,12 +23,18 @@ func startInBackground(args ...string) error {
 	return shellExec("open", args[0], args[1:]...)
 }
 
-func startInBackgroundAsRoot(_ context.Context, args ...string) error {
-	verb := "runas"
+func startInBackgroundAsRoot(_ context.Context, elevation Elevation, args ...string) error {
 	if isAdmin() {
-		verb = "open"
+		return shellExec("open", args[0], args[1:]...)
 	}
-	return shellExec(verb, args[0], args[1:]...)
+	if elevation.Prompt != "" {
+		// The text of the UAC dialog can't be changed, so the prompt is printed before it's shown.
+		fmt.Println(elevation.prompt(args))
+	}
+	if ec := elevation.Command; len(ec) > 0 {
+		return shellExec("open", ec[0], append(append([]string{}, ec[1:]...), args...)...)
+	}
+	return shellExec("runas", args[0], args[1:]...)
 }
 
 func shellExec(verb, exe string, args ...string) error {
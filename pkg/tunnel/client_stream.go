@@ -12,14 +12,25 @@ type GRPClientCStream interface {
 	CloseSend() error
 }
 
-func NewClientStream(ctx context.Context, grpcStream GRPClientCStream, id ConnID, sessionID string, callDelay, dialTimeout time.Duration) (Stream, error) {
+// NewClientStream sends a StreamInfo message on the given gRPC stream and returns a Stream once the server has
+// responded with StreamOK. The given compression is requested in the StreamInfo message, and is used for the
+// stream in both directions when the server accepts it. The stream isn't compressed when the server is of a
+// version that doesn't know about compression.
+func NewClientStream(
+	ctx context.Context,
+	grpcStream GRPClientCStream,
+	id ConnID,
+	sessionID string,
+	callDelay, dialTimeout time.Duration,
+	compression Compression,
+) (Stream, error) {
 	s := &clientStream{stream: newStream("CLI", grpcStream)}
 	s.id = id
 	s.roundtripLatency = callDelay
 	s.dialTimeout = dialTimeout
 	s.sessionID = sessionID
 
-	if err := s.Send(ctx, StreamInfoMessage(id, sessionID, callDelay, dialTimeout)); err != nil {
+	if err := s.Send(ctx, StreamInfoMessage(id, sessionID, callDelay, dialTimeout, compression)); err != nil {
 		_ = s.CloseSend(ctx)
 		return nil, err
 	}
@@ -33,6 +44,9 @@ func NewClientStream(ctx context.Context, grpcStream GRPClientCStream, id ConnID
 		return nil, errors.New("initial message was not StreamOK")
 	}
 	s.peerVersion = getVersion(m)
+	if compression != NoCompression && getAcceptedCompression(m) == compression {
+		s.compressor.algorithm = compression
+	}
 	return s, nil
 }
 
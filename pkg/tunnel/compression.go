@@ -0,0 +1,211 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"gopkg.in/yaml.v3"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// Compression identifies the algorithm used when compressing the payload of Normal messages that are sent
+// over a Stream. The algorithm is requested by the client side of the Stream and must be accepted by the
+// server side before it's used.
+type Compression byte
+
+const (
+	NoCompression = Compression(iota)
+	S2Compression
+	ZstdCompression
+)
+
+const (
+	// minCompressSize is the smallest payload that is considered for compression.
+	minCompressSize = 256
+
+	// maxCompressMisses is the number of consecutive messages that didn't compress well that will make a
+	// stream stop compressing.
+	maxCompressMisses = 4
+)
+
+// The zstd encoder and decoder are safe for concurrent use by all streams. They are created on first use
+// since they start goroutines of their own.
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+func zstdCodecs() (*zstd.Encoder, *zstd.Decoder) {
+	zstdOnce.Do(func() {
+		var err error
+		if zstdEncoder, err = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest)); err != nil {
+			return
+		}
+		if zstdDecoder, err = zstd.NewReader(nil); err != nil {
+			zstdEncoder = nil
+		}
+	})
+	return zstdEncoder, zstdDecoder
+}
+
+func (c Compression) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case S2Compression:
+		return "s2"
+	case ZstdCompression:
+		return "zstd"
+	default:
+		return fmt.Sprintf("** unknown compression: %d **", c)
+	}
+}
+
+// NewCompression returns the Compression that corresponds to the given name.
+func NewCompression(name string) (Compression, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return NoCompression, nil
+	case "s2":
+		return S2Compression, nil
+	case "zstd":
+		return ZstdCompression, nil
+	default:
+		return NoCompression, fmt.Errorf("invalid compression %q, must be one of none, s2, or zstd", name)
+	}
+}
+
+func (c Compression) MarshalYAML() (any, error) {
+	return c.String(), nil
+}
+
+func (c *Compression) UnmarshalYAML(node *yaml.Node) error {
+	var s string
+	if err := node.Decode(&s); err != nil {
+		return err
+	}
+	v, err := NewCompression(s)
+	if err != nil {
+		return err
+	}
+	*c = v
+	return nil
+}
+
+// supported returns true if this process can compress and decompress using the given algorithm.
+func (c Compression) supported() bool {
+	switch c {
+	case NoCompression, S2Compression:
+		return true
+	case ZstdCompression:
+		enc, _ := zstdCodecs()
+		return enc != nil
+	default:
+		return false
+	}
+}
+
+// compressor compresses the payload of Normal messages sent on a stream. Compression is automatically
+// turned off for the stream when the payload is TLS or when it repeatedly fails to shrink, which is what
+// happens when the payload is already compressed.
+type compressor struct {
+	algorithm Compression
+	disabled  int32
+	misses    int
+	seen      bool
+}
+
+func (c *compressor) active() bool {
+	return c.algorithm != NoCompression && atomic.LoadInt32(&c.disabled) == 0
+}
+
+func (c *compressor) disable(ctx context.Context, tag string, id ConnID, reason string) {
+	if atomic.CompareAndSwapInt32(&c.disabled, 0, 1) {
+		dlog.Debugf(ctx, "   %s %s, compression disabled because %s", tag, id, reason)
+	}
+}
+
+// compress returns a compressed version of the given Normal message, or the message itself when it's
+// not worth compressing.
+func (c *compressor) compress(ctx context.Context, tag string, id ConnID, m Message) Message {
+	pl := m.Payload()
+	if !c.seen {
+		c.seen = true
+		if isTLSRecord(pl) {
+			c.disable(ctx, tag, id, "the traffic is TLS")
+			return m
+		}
+	}
+	if len(pl) < minCompressSize {
+		return m
+	}
+
+	// The payload of a compressed message is the algorithm followed by the compressed data.
+	var cm msg
+	switch c.algorithm {
+	case S2Compression:
+		buf := make(msg, 2+s2.MaxEncodedLen(len(pl)))
+		cm = buf[:2+len(s2.Encode(buf[2:], pl))]
+	case ZstdCompression:
+		enc, _ := zstdCodecs()
+		cm = enc.EncodeAll(pl, make(msg, 2, 2+len(pl)))
+	default:
+		return m
+	}
+	cm[0] = byte(compressed)
+	cm[1] = byte(c.algorithm)
+
+	// Require a gain of at least 1/8 to consider the payload compressible.
+	if len(cm) > len(pl)-len(pl)/8 {
+		c.misses++
+		if c.misses >= maxCompressMisses {
+			c.disable(ctx, tag, id, "the traffic is not compressible")
+		}
+		return m
+	}
+	c.misses = 0
+	return cm
+}
+
+// decompress returns the Normal message that corresponds to the given compressed message.
+func decompress(m Message) (Message, error) {
+	pl := m.Payload()
+	if len(pl) < 1 {
+		return nil, errors.New("malformed compressed message")
+	}
+	var err error
+	var dm []byte
+	switch Compression(pl[0]) {
+	case S2Compression:
+		var n int
+		if n, err = s2.DecodedLen(pl[1:]); err == nil {
+			dm = makeMessage(Normal, n)
+			_, err = s2.Decode(dm[1:], pl[1:])
+		}
+	case ZstdCompression:
+		_, dec := zstdCodecs()
+		if dec == nil {
+			return nil, errors.New("zstd decompression is not supported")
+		}
+		dm, err = dec.DecodeAll(pl[1:], makeMessage(Normal, 0))
+	default:
+		return nil, fmt.Errorf("unsupported compression %s", Compression(pl[0]))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s message: %w", Compression(pl[0]), err)
+	}
+	return msg(dm), nil
+}
+
+// isTLSRecord returns true if the given data starts with a TLS handshake record.
+func isTLSRecord(data []byte) bool {
+	return len(data) >= 3 && data[0] == 0x16 && data[1] == 0x03 && data[2] <= 0x04
+}
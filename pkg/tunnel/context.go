@@ -1,9 +1,18 @@
 package tunnel
 
-import "context"
+import (
+	"context"
+	"net"
+)
 
 type poolKey struct{}
 
+type connObserverKey struct{}
+
+// A ConnObserver is called with each connection that a dialer establishes. It returns the connection that the
+// dialer will use, which is typically a wrapper of the given connection that counts its traffic.
+type ConnObserver func(id ConnID, conn net.Conn) net.Conn
+
 // WithPool returns a context with the given Pool
 func WithPool(ctx context.Context, pool *Pool) context.Context {
 	return context.WithValue(ctx, poolKey{}, pool)
@@ -16,3 +25,17 @@ func GetPool(ctx context.Context) *Pool {
 	}
 	return pool
 }
+
+// WithConnObserver returns a context that makes the dialers that are started with it call the given observer with
+// each connection that they establish.
+func WithConnObserver(ctx context.Context, observer ConnObserver) context.Context {
+	return context.WithValue(ctx, connObserverKey{}, observer)
+}
+
+// GetConnObserver returns the observer stored using WithConnObserver, or nil.
+func GetConnObserver(ctx context.Context) ConnObserver {
+	if o, ok := ctx.Value(connObserverKey{}).(ConnObserver); ok {
+		return o
+	}
+	return nil
+}
@@ -93,6 +93,9 @@ func (h *dialer) Start(ctx context.Context) {
 				return
 			}
 			dlog.Debugf(ctx, "   CONN %s, dial answered", id)
+			if observe := GetConnObserver(ctx); observe != nil {
+				conn = observe(id, conn)
+			}
 			h.conn = conn
 
 		case connecting:
@@ -282,8 +285,14 @@ func (h *dialer) resetIdle() bool {
 
 // DialWaitLoop reads from the given dialStream. A new goroutine that creates a Tunnel to the manager and then
 // attaches a dialer Endpoint to that tunnel is spawned for each request that arrives. The method blocks until
-// the dialStream is closed.
-func DialWaitLoop(ctx context.Context, manager rpc.ManagerClient, dialStream rpc.Manager_WatchDialClient, sessionID string) error {
+// the dialStream is closed. The given compression is requested for each tunnel.
+func DialWaitLoop(
+	ctx context.Context,
+	manager rpc.ManagerClient,
+	dialStream rpc.Manager_WatchDialClient,
+	sessionID string,
+	compression Compression,
+) error {
 	for ctx.Err() == nil {
 		dr, err := dialStream.Recv()
 		if err != nil {
@@ -292,19 +301,19 @@ func DialWaitLoop(ctx context.Context, manager rpc.ManagerClient, dialStream rpc
 			}
 			return nil
 		}
-		go dialRespond(ctx, manager, dr, sessionID)
+		go dialRespond(ctx, manager, dr, sessionID, compression)
 	}
 	return nil
 }
 
-func dialRespond(ctx context.Context, manager rpc.ManagerClient, dr *rpc.DialRequest, sessionID string) {
+func dialRespond(ctx context.Context, manager rpc.ManagerClient, dr *rpc.DialRequest, sessionID string, compression Compression) {
 	id := ConnID(dr.ConnId)
 	mt, err := manager.Tunnel(ctx)
 	if err != nil {
 		dlog.Errorf(ctx, "!! CONN %s, call to manager Tunnel failed: %v", id, err)
 		return
 	}
-	s, err := NewClientStream(ctx, mt, id, sessionID, time.Duration(dr.RoundtripLatency), time.Duration(dr.DialTimeout))
+	s, err := NewClientStream(ctx, mt, id, sessionID, time.Duration(dr.RoundtripLatency), time.Duration(dr.DialTimeout), compression)
 	if err != nil {
 		dlog.Error(ctx, err)
 		return
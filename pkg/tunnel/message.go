@@ -22,6 +22,13 @@ const (
 	Disconnect
 	KeepAlive
 	Session
+	compressed
+	muxInfo
+	muxOK
+	muxData
+	muxWindow
+	muxClose
+	muxReset
 )
 
 func (c MessageCode) String() string {
@@ -42,6 +49,20 @@ func (c MessageCode) String() string {
 		return "KEEP_ALIVE"
 	case Session:
 		return "SESSION"
+	case compressed:
+		return "COMPRESSED"
+	case muxInfo:
+		return "MUX_INFO"
+	case muxOK:
+		return "MUX_OK"
+	case muxData:
+		return "MUX_DATA"
+	case muxWindow:
+		return "MUX_WINDOW"
+	case muxClose:
+		return "MUX_CLOSE"
+	case muxReset:
+		return "MUX_RESET"
 	default:
 		return fmt.Sprintf("** unknown control code: %d **", c)
 	}
@@ -84,7 +105,7 @@ func NewMessage(code MessageCode, payload []byte) Message {
 	return msg{byte(code)}
 }
 
-func StreamInfoMessage(id ConnID, sessionID string, callDelay, dialTimeout time.Duration) Message {
+func StreamInfoMessage(id ConnID, sessionID string, callDelay, dialTimeout time.Duration, compression Compression) Message {
 	b := bytes.Buffer{}
 	b.WriteByte(byte(streamInfo))
 
@@ -107,13 +128,19 @@ func StreamInfoMessage(id ConnID, sessionID string, callDelay, dialTimeout time.
 	n = binary.PutUvarint(buf, uint64(len(sb)))
 	b.Write(buf[:n])
 	b.Write(sb)
+
+	// Added in version 3. Older peers ignore it.
+	b.WriteByte(byte(compression))
 	return msg(b.Bytes())
 }
 
-func StreamOKMessage() Message {
-	m := makeMessage(streamOK, 4)
+// StreamOKMessage creates the response to a StreamInfo message. The compression is the compression that the
+// server accepted, which is NoCompression unless the client requested a compression that the server supports.
+func StreamOKMessage(compression Compression) Message {
+	m := makeMessage(streamOK, 5)
 	n := binary.PutUvarint(m.Payload(), uint64(Version))
-	return m[:n+1]
+	m[n+1] = byte(compression)
+	return m[:n+2]
 }
 
 func SessionMessage(sessionID string) Message {
@@ -136,6 +163,16 @@ func getVersion(m Message) uint16 {
 	return uint16(v)
 }
 
+// getAcceptedCompression returns the compression accepted in a StreamOK message. Peers older than
+// version 3 don't send it, which means that they don't compress.
+func getAcceptedCompression(m Message) Compression {
+	pl := m.Payload()
+	if _, n := binary.Uvarint(pl); n > 0 && len(pl) > n {
+		return Compression(pl[n])
+	}
+	return NoCompression
+}
+
 var errMalformedConnect = errors.New("malformed Connect message")
 
 // connectInfo returns the connectInfo that this Message represents
@@ -177,5 +214,10 @@ func setConnectInfo(m Message, s *stream) error {
 	}
 	pl = pl[n:]
 	s.sessionID = string(pl[:v])
+	pl = pl[v:]
+
+	if len(pl) > 0 {
+		s.compressor.algorithm = Compression(pl[0])
+	}
 	return nil
 }
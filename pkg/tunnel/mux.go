@@ -0,0 +1,527 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+// DefaultMuxWindowSize is the number of bytes that a multiplexed stream may have in flight when no other window size
+// is negotiated.
+const DefaultMuxWindowSize = 256 * 1024
+
+var errMalformedMux = errors.New("malformed multiplexed message")
+
+// A mux carries many streams over one gRPC stream. Each stream is identified by a number that is chosen by the
+// client, and has a flow control of its own, so that a stream that isn't read doesn't hold up the other streams
+// of the mux. The streams are presented as GRPCStreams, so the usual StreamInfo handshake is done for each of them.
+//
+// The client starts a mux with a muxInfo message, which carries its version and the window size. The server
+// replies with a muxOK message. From then on, all messages are one of:
+//
+//	muxData <stream> <message>  a message of the stream. The first message of a stream opens it.
+//	muxWindow <stream> <bytes>  the receiver has consumed the given number of bytes of the stream.
+//	muxClose <stream>           the client will send no more messages on the stream, or the server is done with it.
+//	muxReset <stream>           the stream is cancelled.
+//
+// A server that doesn't know about muxes fails the gRPC stream when it receives the muxInfo message, so the
+// client knows that it must use one gRPC stream for each connection.
+type mux struct {
+	tag        string
+	grpcStream GRPCStream
+	window     int
+	server     bool
+
+	// sendLock serializes the calls to grpcStream.Send.
+	sendLock sync.Mutex
+
+	lock    sync.Mutex
+	streams map[uint64]*muxStream
+	lastID  uint64
+	done    chan struct{}
+	err     error
+}
+
+func newMux(tag string, grpcStream GRPCStream, window int, server bool) *mux {
+	return &mux{
+		tag:        tag,
+		grpcStream: grpcStream,
+		window:     window,
+		server:     server,
+		streams:    make(map[uint64]*muxStream),
+		done:       make(chan struct{}),
+	}
+}
+
+func muxInfoMessage(code MessageCode, window int) Message {
+	m := makeMessage(code, 2*binary.MaxVarintLen64)
+	n := binary.PutUvarint(m.Payload(), uint64(Version))
+	n += binary.PutUvarint(m.Payload()[n:], uint64(window))
+	return m[:n+1]
+}
+
+func getMuxWindow(m Message) (int, error) {
+	pl := m.Payload()
+	if _, n := binary.Uvarint(pl); n > 0 {
+		if w, k := binary.Uvarint(pl[n:]); k > 0 && w > 0 {
+			return int(w), nil
+		}
+	}
+	return 0, errMalformedMux
+}
+
+// muxFrame creates a message with the given code and stream number, and room for extra bytes.
+func muxFrame(code MessageCode, id uint64, extra int) (msg, int) {
+	m := makeMessage(code, binary.MaxVarintLen64+extra)
+	n := binary.PutUvarint(m.Payload(), id)
+	return m, n + 1
+}
+
+func (m *mux) sendFrame(f msg) error {
+	m.sendLock.Lock()
+	defer m.sendLock.Unlock()
+	return m.grpcStream.Send(&rpc.TunnelMessage{Payload: f})
+}
+
+func (m *mux) sendData(id uint64, payload []byte) error {
+	f, n := muxFrame(muxData, id, len(payload))
+	n += copy(f[n:], payload)
+	return m.sendFrame(f[:n])
+}
+
+func (m *mux) sendControl(code MessageCode, id uint64) error {
+	f, n := muxFrame(code, id, 0)
+	return m.sendFrame(f[:n])
+}
+
+func (m *mux) sendWindow(id uint64, consumed int) error {
+	f, n := muxFrame(muxWindow, id, binary.MaxVarintLen64)
+	n += binary.PutUvarint(f[n:], uint64(consumed))
+	return m.sendFrame(f[:n])
+}
+
+// close ends the mux and all its streams with the given error.
+func (m *mux) close(err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	select {
+	case <-m.done:
+		return
+	default:
+	}
+	m.err = err
+	close(m.done)
+	for _, s := range m.streams {
+		s.cancel()
+	}
+	m.streams = nil
+}
+
+func (m *mux) isDone() bool {
+	select {
+	case <-m.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// size returns the number of streams of the mux.
+func (m *mux) size() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return len(m.streams)
+}
+
+func (m *mux) remove(s *muxStream) {
+	m.lock.Lock()
+	if m.streams != nil && m.streams[s.id] == s {
+		delete(m.streams, s.id)
+	}
+	m.lock.Unlock()
+}
+
+func (m *mux) newStream(ctx context.Context, id uint64) *muxStream {
+	ctx, cancel := context.WithCancel(ctx)
+	return &muxStream{
+		mux:    m,
+		id:     id,
+		ctx:    ctx,
+		cancel: cancel,
+		credit: m.window,
+		ready:  make(chan struct{}, 1),
+	}
+}
+
+// open creates a new stream on a client mux, or returns nil if the mux is done.
+func (m *mux) open(ctx context.Context) *muxStream {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.streams == nil {
+		return nil
+	}
+	m.lastID++
+	s := m.newStream(ctx, m.lastID)
+	m.streams[s.id] = s
+	return s
+}
+
+// stream returns the stream with the given number. A server mux creates the stream when it's new, and then calls
+// accept with it.
+func (m *mux) stream(ctx context.Context, id uint64, accept func(*muxStream)) *muxStream {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if s, ok := m.streams[id]; ok || m.streams == nil || accept == nil || id <= m.lastID {
+		// Messages for a stream that has been removed are ignored.
+		return s
+	}
+	m.lastID = id
+	s := m.newStream(ctx, id)
+	m.streams[id] = s
+	accept(s)
+	return s
+}
+
+// readLoop dispatches the messages that are received on the gRPC stream to the streams of the mux, until the
+// gRPC stream fails.
+func (m *mux) readLoop(ctx context.Context, accept func(*muxStream)) {
+	for {
+		tm, err := m.grpcStream.Recv()
+		if err != nil {
+			if ctx.Err() == nil && !(errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed)) {
+				dlog.Errorf(ctx, "!! %s MUX, read from grpc stream failed: %v", m.tag, err)
+			}
+			m.close(err)
+			return
+		}
+		p := tm.Payload
+		if len(p) == 0 {
+			continue
+		}
+		id, n := binary.Uvarint(p[1:])
+		if n <= 0 {
+			m.close(errMalformedMux)
+			return
+		}
+		rest := p[1+n:]
+		switch code := MessageCode(p[0]); code {
+		case muxData:
+			if s := m.stream(ctx, id, accept); s != nil {
+				s.deliver(msg(rest))
+			}
+		case muxWindow:
+			consumed, k := binary.Uvarint(rest)
+			if k <= 0 {
+				m.close(errMalformedMux)
+				return
+			}
+			if s := m.stream(ctx, id, nil); s != nil {
+				s.addCredit(int(consumed))
+			}
+		case muxClose:
+			if s := m.stream(ctx, id, nil); s != nil {
+				s.closedByPeer()
+			}
+		case muxReset:
+			if s := m.stream(ctx, id, nil); s != nil {
+				s.resetByPeer()
+			}
+		default:
+			dlog.Errorf(ctx, "!! %s MUX, unexpected %s", m.tag, code)
+		}
+	}
+}
+
+// muxStream is a stream of a mux. It implements the rpc.Manager_TunnelClient, so that it can be used wherever a
+// gRPC tunnel stream is used.
+type muxStream struct {
+	mux    *mux
+	id     uint64
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// ready is signalled when a message is queued, when credit is added, and when the peer closes the stream.
+	ready chan struct{}
+
+	lock       sync.Mutex
+	queue      []msg
+	credit     int  // the number of bytes that may be sent before the peer consumes them
+	consumed   int  // the number of received bytes that haven't been reported to the peer
+	peerClosed bool // the peer closed its end
+	closed     bool // this end is closed
+	reset      bool // the stream is cancelled
+}
+
+func (s *muxStream) signal() {
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (s *muxStream) deliver(m msg) {
+	s.lock.Lock()
+	s.queue = append(s.queue, m)
+	s.lock.Unlock()
+	s.signal()
+}
+
+func (s *muxStream) addCredit(n int) {
+	s.lock.Lock()
+	s.credit += n
+	s.lock.Unlock()
+	s.signal()
+}
+
+func (s *muxStream) closedByPeer() {
+	s.lock.Lock()
+	s.peerClosed = true
+	s.lock.Unlock()
+	if !s.mux.server {
+		// The server is done with the stream, so it will neither send nor receive anything more.
+		s.mux.remove(s)
+	}
+	s.signal()
+}
+
+func (s *muxStream) resetByPeer() {
+	s.lock.Lock()
+	s.reset = true
+	s.lock.Unlock()
+	s.mux.remove(s)
+	s.cancel()
+}
+
+// wait waits until the stream is signalled, and returns an error when the stream or its mux is cancelled.
+func (s *muxStream) wait() error {
+	select {
+	case <-s.ready:
+		return nil
+	case <-s.mux.done:
+		return s.mux.err
+	case <-s.ctx.Done():
+		s.abort()
+		return s.ctx.Err()
+	}
+}
+
+// abort tells the peer that the stream is cancelled, unless it's done already.
+func (s *muxStream) abort() {
+	s.lock.Lock()
+	send := !(s.reset || s.closed && s.peerClosed)
+	s.reset = true
+	s.lock.Unlock()
+	s.mux.remove(s)
+	if send && !s.mux.isDone() {
+		_ = s.mux.sendControl(muxReset, s.id)
+	}
+}
+
+func (s *muxStream) Recv() (*rpc.TunnelMessage, error) {
+	for {
+		s.lock.Lock()
+		if len(s.queue) > 0 {
+			m := s.queue[0]
+			s.queue[0] = nil
+			s.queue = s.queue[1:]
+			ack := 0
+			if s.consumed += len(m); s.consumed >= s.mux.window/2 {
+				ack = s.consumed
+				s.consumed = 0
+			}
+			s.lock.Unlock()
+			if ack > 0 {
+				// A failure will surface on the next Recv or Send.
+				_ = s.mux.sendWindow(s.id, ack)
+			}
+			return &rpc.TunnelMessage{Payload: m}, nil
+		}
+		peerClosed := s.peerClosed
+		s.lock.Unlock()
+		if peerClosed {
+			return nil, io.EOF
+		}
+		if err := s.wait(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (s *muxStream) Send(tm *rpc.TunnelMessage) error {
+	for {
+		s.lock.Lock()
+		switch {
+		case s.closed:
+			s.lock.Unlock()
+			return net.ErrClosed
+		case s.peerClosed && !s.mux.server:
+			// Sending on a gRPC stream that the server has ended yields io.EOF.
+			s.lock.Unlock()
+			return io.EOF
+		case s.credit > 0:
+			s.credit -= len(tm.Payload)
+			s.lock.Unlock()
+			return s.mux.sendData(s.id, tm.Payload)
+		}
+		s.lock.Unlock()
+		if err := s.wait(); err != nil {
+			return err
+		}
+	}
+}
+
+// CloseSend closes the sending end of a client stream. The server's Recv will return io.EOF.
+func (s *muxStream) CloseSend() error {
+	return s.closeSend()
+}
+
+func (s *muxStream) closeSend() error {
+	s.lock.Lock()
+	if s.closed || s.reset {
+		s.lock.Unlock()
+		return nil
+	}
+	s.closed = true
+	done := s.peerClosed
+	s.lock.Unlock()
+	if done || s.mux.server {
+		s.mux.remove(s)
+	}
+	if s.mux.isDone() {
+		return nil
+	}
+	return s.mux.sendControl(muxClose, s.id)
+}
+
+func (s *muxStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *muxStream) Header() (metadata.MD, error) {
+	return nil, nil
+}
+
+func (s *muxStream) Trailer() metadata.MD {
+	return nil
+}
+
+func (s *muxStream) SendMsg(m any) error {
+	tm, ok := m.(*rpc.TunnelMessage)
+	if !ok {
+		return fmt.Errorf("unable to send a %T on a multiplexed stream", m)
+	}
+	return s.Send(tm)
+}
+
+func (s *muxStream) RecvMsg(m any) error {
+	tm, ok := m.(*rpc.TunnelMessage)
+	if !ok {
+		return fmt.Errorf("unable to receive a %T on a multiplexed stream", m)
+	}
+	r, err := s.Recv()
+	if err != nil {
+		return err
+	}
+	tm.Payload = r.Payload
+	return nil
+}
+
+// dialMux starts a client mux on the given gRPC stream. It returns an error with codes.Unimplemented when the
+// server doesn't support muxes.
+func dialMux(ctx context.Context, grpcStream GRPClientCStream, window int) (*mux, error) {
+	if err := grpcStream.Send(muxInfoMessage(muxInfo, window).TunnelMessage()); err != nil {
+		return nil, err
+	}
+	tm, err := grpcStream.Recv()
+	if err != nil {
+		if status.Code(err) == codes.FailedPrecondition {
+			// The server expected a StreamInfo message
+			return nil, status.Error(codes.Unimplemented, "the traffic-manager doesn't support multiplexed tunnels")
+		}
+		return nil, fmt.Errorf("failed to read initial MuxOK message: %w", err)
+	}
+	m := msg(tm.Payload)
+	if len(m) == 0 || m.Code() != muxOK {
+		_ = grpcStream.CloseSend()
+		return nil, status.Error(codes.Unimplemented, "initial message was not MuxOK")
+	}
+	if window, err = getMuxWindow(m); err != nil {
+		_ = grpcStream.CloseSend()
+		return nil, err
+	}
+	mx := newMux("CLI", grpcStream, window, false)
+	go mx.readLoop(ctx, nil)
+	return mx, nil
+}
+
+// AcceptServerStream reads the first message of a gRPC tunnel stream. It returns a Stream when the client opens a
+// single stream, and a Mux when the client multiplexes many streams over the gRPC stream.
+func AcceptServerStream(ctx context.Context, grpcStream GRPCStream) (Stream, *Mux, error) {
+	tm, err := grpcStream.Recv()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read initial StreamInfo message: %w", err)
+	}
+	m := msg(tm.Payload)
+	if len(m) > 0 && m.Code() == muxInfo {
+		window, err := getMuxWindow(m)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err = grpcStream.Send(muxInfoMessage(muxOK, window).TunnelMessage()); err != nil {
+			return nil, nil, err
+		}
+		return nil, &Mux{mux: newMux("SRV", grpcStream, window, true)}, nil
+	}
+	s, err := newServerStream(ctx, grpcStream, m)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, nil, nil
+}
+
+// Mux is the server end of a gRPC stream that multiplexes many streams.
+type Mux struct {
+	mux *mux
+}
+
+// Serve calls handle in a goroutine of its own for each stream that the client opens, until the gRPC stream ends.
+// The stream ends when handle returns.
+func (m *Mux) Serve(ctx context.Context, handle func(context.Context, Stream) error) error {
+	mx := m.mux
+	var wg sync.WaitGroup
+	accept := func(ms *muxStream) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				_ = ms.closeSend()
+				ms.cancel()
+			}()
+			s, err := NewServerStream(ms.ctx, ms)
+			if err != nil {
+				dlog.Errorf(ctx, "!! %s MUX %d, failed to connect stream: %v", mx.tag, ms.id, err)
+				return
+			}
+			if err = handle(ms.ctx, s); err != nil {
+				dlog.Errorf(ctx, "!! %s MUX %d, %s: %v", mx.tag, ms.id, s.ID(), err)
+			}
+		}()
+	}
+	mx.readLoop(ctx, accept)
+	wg.Wait()
+	if errors.Is(mx.err, io.EOF) || ctx.Err() != nil {
+		return nil
+	}
+	return mx.err
+}
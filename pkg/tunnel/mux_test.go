@@ -0,0 +1,246 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+)
+
+// pipeTunnel is the client end of a pipe that behaves like a gRPC tunnel stream. Its Recv returns the error of the
+// server once the server is done.
+type pipeTunnel struct {
+	grpc.ClientStream
+	client GRPClientCStream
+	ctx    context.Context
+
+	lock *sync.Mutex
+	err  *error
+}
+
+func (p *pipeTunnel) Context() context.Context {
+	return p.ctx
+}
+
+func (p *pipeTunnel) Recv() (*manager.TunnelMessage, error) {
+	m, err := p.client.Recv()
+	if err != nil {
+		p.lock.Lock()
+		if *p.err != nil {
+			err = *p.err
+		} else {
+			err = io.EOF
+		}
+		p.lock.Unlock()
+	}
+	return m, err
+}
+
+func (p *pipeTunnel) Send(m *manager.TunnelMessage) error {
+	return p.client.Send(m)
+}
+
+func (p *pipeTunnel) CloseSend() error {
+	return p.client.CloseSend()
+}
+
+// pipeTunnelClient is a manager.ManagerClient that serves each Tunnel call in-process, using the given function.
+type pipeTunnelClient struct {
+	manager.ManagerClient
+	serve  func(context.Context, GRPCStream) error
+	opened int32
+}
+
+func (c *pipeTunnelClient) Tunnel(ctx context.Context, _ ...grpc.CallOption) (manager.Manager_TunnelClient, error) {
+	atomic.AddInt32(&c.opened, 1)
+	done := make(chan struct{})
+	cs, ss := NewPipe(done)
+	pt := &pipeTunnel{client: cs, ctx: ctx, lock: &sync.Mutex{}, err: new(error)}
+	go func() {
+		err := c.serve(ctx, ss)
+		pt.lock.Lock()
+		*pt.err = err
+		pt.lock.Unlock()
+		close(done)
+	}()
+	return pt, nil
+}
+
+// serveTunnel serves a tunnel the way the traffic-manager does.
+func serveTunnel(handle func(context.Context, Stream) error) func(context.Context, GRPCStream) error {
+	return func(ctx context.Context, gs GRPCStream) error {
+		s, mux, err := AcceptServerStream(ctx, gs)
+		if err != nil {
+			return status.Errorf(codes.FailedPrecondition, "failed to connect stream: %v", err)
+		}
+		if mux != nil {
+			return mux.Serve(ctx, handle)
+		}
+		return handle(ctx, s)
+	}
+}
+
+// echo sends all normal messages back to the client.
+func echo(ctx context.Context, s Stream) error {
+	for {
+		m, err := s.Receive(ctx)
+		if err != nil {
+			return nil
+		}
+		if m.Code() == Normal {
+			if err = s.Send(ctx, m); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func openStream(ctx context.Context, t *testing.T, mc manager.ManagerClient, port uint16) Stream {
+	t.Helper()
+	gs, err := mc.Tunnel(ctx)
+	require.NoError(t, err)
+	id := NewConnID(ipproto.TCP, iputil.Parse("127.0.0.1"), iputil.Parse("192.168.0.1"), port, 8080)
+	s, err := NewClientStream(ctx, gs, id, "session", time.Second, time.Second, NoCompression)
+	require.NoError(t, err)
+	return s
+}
+
+func requireEcho(ctx context.Context, t *testing.T, s Stream, payload string) {
+	t.Helper()
+	require.NoError(t, s.Send(ctx, NewMessage(Normal, []byte(payload))))
+	m, err := s.Receive(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, payload, string(m.Payload()))
+}
+
+func TestMuxingClient(t *testing.T) {
+	ctx, cancel := testContext(t, 30*time.Second)
+	defer cancel()
+
+	pc := &pipeTunnelClient{serve: serveTunnel(echo)}
+	assert.Same(t, pc, NewMuxingClient(ctx, pc, 0, 0))
+
+	mc := NewMuxingClient(ctx, pc, 2, 0)
+	streams := make([]Stream, 10)
+	for i := range streams {
+		streams[i] = openStream(ctx, t, mc, uint16(1000+i))
+	}
+	for i, s := range streams {
+		requireEcho(ctx, t, s, fmt.Sprintf("hello %d", i))
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&pc.opened), "streams are multiplexed over two gRPC streams")
+
+	// The server sees the end of a stream when the client closes it, and the client sees the end when the server
+	// is done with it.
+	for _, s := range streams {
+		require.NoError(t, s.CloseSend(ctx))
+		_, err := s.Receive(ctx)
+		assert.ErrorIs(t, err, io.EOF)
+	}
+	for _, m := range mc.(*muxingClient).muxes {
+		require.Eventually(t, func() bool { return m.size() == 0 }, 5*time.Second, time.Millisecond)
+	}
+}
+
+func TestMuxingClient_flowControl(t *testing.T) {
+	ctx, cancel := testContext(t, 30*time.Second)
+	defer cancel()
+
+	const window = 0x10000
+	stalled := make(chan struct{})
+	defer close(stalled)
+	handle := func(ctx context.Context, s Stream) error {
+		if s.ID().SourcePort() == 1000 {
+			// Never read from this stream
+			select {
+			case <-ctx.Done():
+			case <-stalled:
+			}
+			return nil
+		}
+		return echo(ctx, s)
+	}
+	pc := &pipeTunnelClient{serve: serveTunnel(handle)}
+	mc := NewMuxingClient(ctx, pc, 1, window)
+
+	stalledStream := openStream(ctx, t, mc, 1000)
+	var sent int64
+	go func() {
+		payload := make([]byte, 0x1000)
+		for stalledStream.Send(ctx, NewMessage(Normal, payload)) == nil {
+			atomic.AddInt64(&sent, int64(len(payload)))
+		}
+	}()
+
+	// The sender of the stream that isn't read is held back by the window of the stream
+	var last int64
+	require.Eventually(t, func() bool {
+		prev := last
+		last = atomic.LoadInt64(&sent)
+		return last > 0 && last == prev
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.LessOrEqual(t, last, int64(window))
+
+	// Other streams of the same gRPC stream are unaffected
+	requireEcho(ctx, t, openStream(ctx, t, mc, 1001), "hello")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&pc.opened))
+}
+
+func TestMuxingClient_fallback(t *testing.T) {
+	ctx, cancel := testContext(t, 30*time.Second)
+	defer cancel()
+
+	// A traffic-manager that doesn't know about muxes
+	pc := &pipeTunnelClient{serve: func(ctx context.Context, gs GRPCStream) error {
+		s, err := NewServerStream(ctx, gs)
+		if err != nil {
+			return status.Errorf(codes.FailedPrecondition, "failed to connect stream: %v", err)
+		}
+		return echo(ctx, s)
+	}}
+	mc := NewMuxingClient(ctx, pc, 2, 0)
+	requireEcho(ctx, t, openStream(ctx, t, mc, 1000), "hello")
+	requireEcho(ctx, t, openStream(ctx, t, mc, 1001), "world")
+	assert.True(t, mc.(*muxingClient).unsupported)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&pc.opened), "one failed mux, and one gRPC stream for each connection")
+}
+
+func TestMuxStream_cancel(t *testing.T) {
+	ctx, cancel := testContext(t, 30*time.Second)
+	defer cancel()
+
+	cancelled := make(chan struct{})
+	handle := func(ctx context.Context, s Stream) error {
+		<-ctx.Done()
+		close(cancelled)
+		return nil
+	}
+	pc := &pipeTunnelClient{serve: serveTunnel(handle)}
+	mc := NewMuxingClient(ctx, pc, 1, 0)
+
+	sCtx, sCancel := context.WithCancel(ctx)
+	s := openStream(sCtx, t, mc, 1000)
+	sCancel()
+
+	// The cancellation is noticed by the client's Receive and passed on to the server
+	_, err := s.Receive(sCtx)
+	assert.ErrorIs(t, err, context.Canceled)
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server's stream was not cancelled")
+	}
+}
@@ -0,0 +1,117 @@
+package tunnel
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+type muxingClient struct {
+	rpc.ManagerClient
+	ctx    context.Context
+	size   int
+	window int
+
+	lock        sync.Mutex
+	muxes       []*mux
+	unsupported bool
+}
+
+// NewMuxingClient returns a ManagerClient whose Tunnel method returns streams that are multiplexed over at most
+// size gRPC streams, each stream with a flow-control window of the given number of bytes. The gRPC streams are
+// opened when they're first needed, and kept open until the given context is cancelled. The Tunnel method falls back
+// to the given client when the traffic-manager doesn't support multiplexed streams. The given client is returned as
+// is when size isn't positive.
+func NewMuxingClient(ctx context.Context, mc rpc.ManagerClient, size, window int) rpc.ManagerClient {
+	if size <= 0 {
+		return mc
+	}
+	if window <= 0 {
+		window = DefaultMuxWindowSize
+	}
+	return &muxingClient{ManagerClient: mc, ctx: ctx, size: size, window: window}
+}
+
+// Tunnel returns a stream of a mux, or a new gRPC stream when muxes are unavailable. The stream is cancelled when
+// the given context is cancelled.
+func (mc *muxingClient) Tunnel(ctx context.Context, opts ...grpc.CallOption) (rpc.Manager_TunnelClient, error) {
+	if len(opts) == 0 {
+		if m := mc.getMux(); m != nil {
+			if s := m.open(ctx); s != nil {
+				return s, nil
+			}
+		}
+	}
+	return mc.ManagerClient.Tunnel(ctx, opts...)
+}
+
+// getMux returns the mux with the fewest streams. A new mux is opened as long as there are fewer than size muxes.
+func (mc *muxingClient) getMux() *mux {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	if mc.unsupported {
+		return nil
+	}
+	active := mc.muxes[:0]
+	for _, m := range mc.muxes {
+		if !m.isDone() {
+			active = append(active, m)
+		}
+	}
+	for i := len(active); i < len(mc.muxes); i++ {
+		mc.muxes[i] = nil
+	}
+	mc.muxes = active
+
+	if len(mc.muxes) < mc.size {
+		m, err := mc.dial()
+		if err == nil {
+			mc.muxes = append(mc.muxes, m)
+			return m
+		}
+		if status.Code(err) == codes.Unimplemented {
+			dlog.Infof(mc.ctx, "tunnel streams will not be multiplexed: %v", err)
+			mc.unsupported = true
+			return nil
+		}
+		if mc.ctx.Err() == nil {
+			dlog.Errorf(mc.ctx, "unable to open a multiplexed tunnel: %v", err)
+		}
+	}
+
+	var best *mux
+	bestSize := 0
+	for _, m := range mc.muxes {
+		if sz := m.size(); best == nil || sz < bestSize {
+			best = m
+			bestSize = sz
+		}
+	}
+	return best
+}
+
+func (mc *muxingClient) dial() (*mux, error) {
+	ctx, cancel := context.WithCancel(mc.ctx)
+	gs, err := mc.ManagerClient.Tunnel(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	m, err := dialMux(ctx, gs, mc.window)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go func() {
+		// Release the gRPC stream when the mux is done.
+		<-m.done
+		cancel()
+	}()
+	return m, nil
+}
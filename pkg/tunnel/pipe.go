@@ -0,0 +1,93 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+// NewPipe returns the two ends of an in-process tunnel, for use with NewClientStream and NewServerStream. It lets a
+// process connect a local connection to a Stream of a peer without a call to Tunnel. Both ends fail with
+// context.Canceled once the given done channel is closed.
+func NewPipe(done <-chan struct{}) (GRPClientCStream, GRPCStream) {
+	cToS := newPipeDir(done)
+	sToC := newPipeDir(done)
+	return &pipeClient{recv: sToC, send: cToS}, &pipeServer{recv: cToS, send: sToC}
+}
+
+// pipeDir is one direction of a pipe. It's closed by closing the closed channel rather than the message channel,
+// so that a Send after CloseSend fails like it does on a gRPC stream.
+type pipeDir struct {
+	closeOnce sync.Once
+	ch        chan *manager.TunnelMessage
+	closed    chan struct{}
+	done      <-chan struct{}
+}
+
+func newPipeDir(done <-chan struct{}) *pipeDir {
+	return &pipeDir{ch: make(chan *manager.TunnelMessage, 8), closed: make(chan struct{}), done: done}
+}
+
+func (d *pipeDir) Recv() (*manager.TunnelMessage, error) {
+	select {
+	case <-d.done:
+		return nil, context.Canceled
+	case m := <-d.ch:
+		return m, nil
+	case <-d.closed:
+		// Messages sent before the close are still delivered
+		select {
+		case m := <-d.ch:
+			return m, nil
+		default:
+			return nil, net.ErrClosed
+		}
+	}
+}
+
+func (d *pipeDir) Send(m *manager.TunnelMessage) error {
+	select {
+	case <-d.done:
+		return context.Canceled
+	case <-d.closed:
+		return net.ErrClosed
+	case d.ch <- m:
+		return nil
+	}
+}
+
+func (d *pipeDir) close() {
+	d.closeOnce.Do(func() { close(d.closed) })
+}
+
+type pipeClient struct {
+	recv, send *pipeDir
+}
+
+func (p *pipeClient) Recv() (*manager.TunnelMessage, error) {
+	return p.recv.Recv()
+}
+
+func (p *pipeClient) Send(m *manager.TunnelMessage) error {
+	return p.send.Send(m)
+}
+
+// CloseSend closes the direction towards the server end, which then receives net.ErrClosed.
+func (p *pipeClient) CloseSend() error {
+	p.send.close()
+	return nil
+}
+
+type pipeServer struct {
+	recv, send *pipeDir
+}
+
+func (p *pipeServer) Recv() (*manager.TunnelMessage, error) {
+	return p.recv.Recv()
+}
+
+func (p *pipeServer) Send(m *manager.TunnelMessage) error {
+	return p.send.Send(m)
+}
@@ -4,13 +4,50 @@ import (
 	"context"
 	"errors"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
 )
 
+// ErrPoolFull is returned by GetOrCreate when a new handler is needed, and the pool already has its maximum
+// number of handlers.
+var ErrPoolFull = errors.New("the connection table is full")
+
 type Pool struct {
 	handlers map[ConnID]Handler
 	lock     sync.RWMutex
+
+	// maxSize is the maximum number of handlers. Zero means no limit.
+	maxSize int
+
+	// tcpIdleTimeout and udpIdleTimeout are the times after which handlers without activity are evicted.
+	tcpIdleTimeout time.Duration
+	udpIdleTimeout time.Duration
+
+	created        int64
+	rejected       int64
+	tcpIdleEvicted int64
+	udpIdleEvicted int64
+}
+
+// PoolStats is a snapshot of the size, limits, and counters of a Pool.
+type PoolStats struct {
+	Size           int
+	MaxSize        int
+	TCPIdleTimeout time.Duration
+	UDPIdleTimeout time.Duration
+
+	// Created is the number of handlers that have been added to the pool.
+	Created int64
+
+	// Rejected is the number of handlers that couldn't be created because the pool was full.
+	Rejected int64
+
+	// TCPIdleEvicted and UDPIdleEvicted are the number of handlers that were evicted because they were idle.
+	TCPIdleEvicted int64
+	UDPIdleEvicted int64
 }
 
 type Handler interface {
@@ -24,6 +61,73 @@ func NewPool() *Pool {
 	return &Pool{handlers: make(map[ConnID]Handler)}
 }
 
+// SetMaxSize sets the maximum number of handlers in the pool. Zero or a negative value means no limit.
+func (p *Pool) SetMaxSize(maxSize int) {
+	if maxSize < 0 {
+		maxSize = 0
+	}
+	p.lock.Lock()
+	p.maxSize = maxSize
+	p.lock.Unlock()
+}
+
+// SetIdleTimeout sets the time after which a handler for the given protocol is evicted when it has no
+// activity. Zero means that the handler decides.
+func (p *Pool) SetIdleTimeout(proto int, timeout time.Duration) {
+	p.lock.Lock()
+	switch proto {
+	case ipproto.TCP:
+		p.tcpIdleTimeout = timeout
+	case ipproto.UDP:
+		p.udpIdleTimeout = timeout
+	}
+	p.lock.Unlock()
+}
+
+// IdleTimeout returns the idle timeout for the given protocol, or the given fallback when no timeout has been set.
+func (p *Pool) IdleTimeout(proto int, fallback time.Duration) time.Duration {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	var timeout time.Duration
+	switch proto {
+	case ipproto.TCP:
+		timeout = p.tcpIdleTimeout
+	case ipproto.UDP:
+		timeout = p.udpIdleTimeout
+	}
+	if timeout == 0 {
+		timeout = fallback
+	}
+	return timeout
+}
+
+// IdleEvicted is called by a handler that removes itself because it was idle for too long.
+func (p *Pool) IdleEvicted(id ConnID) {
+	switch id.Protocol() {
+	case ipproto.TCP:
+		atomic.AddInt64(&p.tcpIdleEvicted, 1)
+	case ipproto.UDP:
+		atomic.AddInt64(&p.udpIdleEvicted, 1)
+	}
+}
+
+// Stats returns a snapshot of the size, limits, and counters of the pool.
+func (p *Pool) Stats() PoolStats {
+	p.lock.RLock()
+	s := PoolStats{
+		Size:           len(p.handlers),
+		MaxSize:        p.maxSize,
+		TCPIdleTimeout: p.tcpIdleTimeout,
+		UDPIdleTimeout: p.udpIdleTimeout,
+	}
+	p.lock.RUnlock()
+	s.Created = atomic.LoadInt64(&p.created)
+	s.Rejected = atomic.LoadInt64(&p.rejected)
+	s.TCPIdleEvicted = atomic.LoadInt64(&p.tcpIdleEvicted)
+	s.UDPIdleEvicted = atomic.LoadInt64(&p.udpIdleEvicted)
+	return s
+}
+
 func (p *Pool) release(ctx context.Context, id ConnID) {
 	p.lock.Lock()
 	delete(p.handlers, id)
@@ -45,17 +149,24 @@ func (p *Pool) Get(id ConnID) Handler {
 
 // GetOrCreate finds a handler for the given id from the pool, or creates a new handler using the given createHandler func
 // when no handler was found. The handler is returned together with a boolean flag which is set to true if
-// the handler was found or false if it was created.
+// the handler was found or false if it was created. ErrPoolFull is returned when a handler must be created
+// and the pool already has its maximum number of handlers. The context passed to createHandler, and to the
+// Start method of the handler, carries the pool.
 func (p *Pool) GetOrCreate(ctx context.Context, id ConnID, createHandler HandlerCreator) (Handler, bool, error) {
 	p.lock.RLock()
 	handler, ok := p.handlers[id]
+	full := p.maxSize > 0 && len(p.handlers) >= p.maxSize
 	p.lock.RUnlock()
 
 	if ok {
 		return handler, true, nil
 	}
+	if full {
+		atomic.AddInt64(&p.rejected, 1)
+		return nil, false, ErrPoolFull
+	}
 
-	handlerCtx, cancel := context.WithCancel(ctx)
+	handlerCtx, cancel := context.WithCancel(WithPool(ctx, p))
 	release := func() {
 		p.release(ctx, id)
 		cancel()
@@ -81,6 +192,7 @@ func (p *Pool) GetOrCreate(ctx context.Context, id ConnID, createHandler Handler
 		// Toss newly created handler. It's not started anyway.
 		return old, true, nil
 	}
+	atomic.AddInt64(&p.created, 1)
 	handler.Start(handlerCtx)
 	dlog.Debugf(ctx, "++ POOL %s, count now is %d", id, count)
 	return handler, false, nil
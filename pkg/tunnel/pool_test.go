@@ -0,0 +1,75 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+)
+
+type poolTestHandler struct {
+	ctx context.Context
+}
+
+func (h *poolTestHandler) Close(context.Context) {}
+
+func (h *poolTestHandler) Start(ctx context.Context) {
+	h.ctx = ctx
+}
+
+func TestPool_limitsAndStats(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	p := NewPool()
+	p.SetMaxSize(2)
+	p.SetIdleTimeout(ipproto.UDP, 30*time.Second)
+	assert.Equal(t, 30*time.Second, p.IdleTimeout(ipproto.UDP, 5*time.Second))
+	assert.Equal(t, time.Minute, p.IdleTimeout(ipproto.TCP, time.Minute))
+
+	src := net.ParseIP("192.168.1.2")
+	dst := net.ParseIP("10.0.0.1")
+	newID := func(port uint16) ConnID {
+		return NewConnID(ipproto.UDP, src, dst, port, 53)
+	}
+	releases := make(map[ConnID]func())
+	create := func(id ConnID) (Handler, bool, error) {
+		return p.GetOrCreate(ctx, id, func(ctx context.Context, release func()) (Handler, error) {
+			releases[id] = release
+			return &poolTestHandler{}, nil
+		})
+	}
+
+	h, found, err := create(newID(1000))
+	require.NoError(t, err)
+	assert.False(t, found)
+	assert.Same(t, p, GetPool(h.(*poolTestHandler).ctx), "the handler's context must carry the pool")
+
+	_, found, err = create(newID(1000))
+	require.NoError(t, err)
+	assert.True(t, found)
+
+	_, _, err = create(newID(1001))
+	require.NoError(t, err)
+	_, _, err = create(newID(1002))
+	assert.ErrorIs(t, err, ErrPoolFull)
+
+	// Releasing a handler makes room for a new one
+	p.IdleEvicted(newID(1001))
+	releases[newID(1001)]()
+	_, _, err = create(newID(1002))
+	require.NoError(t, err)
+
+	s := p.Stats()
+	assert.Equal(t, 2, s.Size)
+	assert.Equal(t, 2, s.MaxSize)
+	assert.Equal(t, int64(3), s.Created)
+	assert.Equal(t, int64(1), s.Rejected)
+	assert.Equal(t, int64(1), s.UDPIdleEvicted)
+	assert.Equal(t, int64(0), s.TCPIdleEvicted)
+	assert.Equal(t, 30*time.Second, s.UDPIdleTimeout)
+}
@@ -0,0 +1,165 @@
+package tunnel
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+const (
+	// prewarmedMaxAge is the age at which a prewarmed stream that hasn't been used is replaced, so that streams
+	// on a connection that has gone bad are discarded before they're needed.
+	prewarmedMaxAge = time.Minute
+
+	// prewarmRetryDelay is the time that the prewarmer waits after failing to open a stream.
+	prewarmRetryDelay = 3 * time.Second
+)
+
+// prewarmedStream is a tunnel stream that was opened before it was needed.
+type prewarmedStream struct {
+	rpc.Manager_TunnelClient
+	cancel context.CancelFunc
+	opened time.Time
+}
+
+// Recv cancels the stream when it ends, so that its context is released.
+func (s *prewarmedStream) Recv() (*rpc.TunnelMessage, error) {
+	m, err := s.Manager_TunnelClient.Recv()
+	if err != nil {
+		s.cancel()
+	}
+	return m, err
+}
+
+func (s *prewarmedStream) usable() bool {
+	return s.Context().Err() == nil && time.Since(s.opened) < prewarmedMaxAge
+}
+
+type prewarmingClient struct {
+	rpc.ManagerClient
+	streams chan *prewarmedStream
+	taken   chan struct{}
+}
+
+// NewPrewarmingClient returns a ManagerClient whose Tunnel method returns streams that were opened in advance, so
+// that a new connection doesn't have to wait for the gRPC connection to become ready and for a stream to be
+// created on it. Up to size streams are kept open until the given context is cancelled. The given client is
+// returned as is when size isn't positive.
+func NewPrewarmingClient(ctx context.Context, mc rpc.ManagerClient, size int) rpc.ManagerClient {
+	if size <= 0 {
+		return mc
+	}
+	pc := &prewarmingClient{
+		ManagerClient: mc,
+		streams:       make(chan *prewarmedStream, size),
+		taken:         make(chan struct{}, 1),
+	}
+	go pc.prewarm(ctx)
+	return pc
+}
+
+// Tunnel returns a prewarmed stream, or a new stream when no prewarmed stream is available. The prewarmed stream is
+// cancelled when the given context is cancelled.
+func (pc *prewarmingClient) Tunnel(ctx context.Context, opts ...grpc.CallOption) (rpc.Manager_TunnelClient, error) {
+	if len(opts) == 0 {
+		for {
+			var s *prewarmedStream
+			select {
+			case s = <-pc.streams:
+			default:
+			}
+			if s == nil {
+				break
+			}
+			select {
+			case pc.taken <- struct{}{}:
+			default:
+			}
+			if !s.usable() {
+				s.cancel()
+				continue
+			}
+			go func() {
+				select {
+				case <-ctx.Done():
+					s.cancel()
+				case <-s.Context().Done():
+				}
+			}()
+			return s, nil
+		}
+	}
+	return pc.ManagerClient.Tunnel(ctx, opts...)
+}
+
+// prewarm keeps the pool of streams filled, and replaces the streams that are no longer usable.
+func (pc *prewarmingClient) prewarm(ctx context.Context) {
+	ticker := time.NewTicker(prewarmedMaxAge / 4)
+	defer ticker.Stop()
+	defer func() {
+		for {
+			select {
+			case s := <-pc.streams:
+				s.cancel()
+			default:
+				return
+			}
+		}
+	}()
+
+	for {
+		// Only this goroutine adds streams, so the channel can't become full while it's filled.
+		for len(pc.streams) < cap(pc.streams) {
+			s, err := pc.open(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				dlog.Debugf(ctx, "unable to prewarm a tunnel stream: %v", err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(prewarmRetryDelay):
+				}
+				continue
+			}
+			pc.streams <- s
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-pc.taken:
+		case <-ticker.C:
+			pc.discardUnusable()
+		}
+	}
+}
+
+func (pc *prewarmingClient) open(ctx context.Context) (*prewarmedStream, error) {
+	sCtx, cancel := context.WithCancel(ctx)
+	s, err := pc.ManagerClient.Tunnel(sCtx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &prewarmedStream{Manager_TunnelClient: s, cancel: cancel, opened: time.Now()}, nil
+}
+
+func (pc *prewarmingClient) discardUnusable() {
+	for i := len(pc.streams); i > 0; i-- {
+		select {
+		case s := <-pc.streams:
+			if s.usable() {
+				pc.streams <- s
+			} else {
+				s.cancel()
+			}
+		default:
+			return
+		}
+	}
+}
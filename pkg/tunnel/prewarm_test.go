@@ -0,0 +1,74 @@
+package tunnel
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+type countingStream struct {
+	grpc.ClientStream
+	ctx context.Context
+}
+
+func (s *countingStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *countingStream) Recv() (*manager.TunnelMessage, error) {
+	<-s.ctx.Done()
+	return nil, s.ctx.Err()
+}
+
+func (s *countingStream) Send(*manager.TunnelMessage) error {
+	return s.ctx.Err()
+}
+
+type countingClient struct {
+	manager.ManagerClient
+	opened int32
+}
+
+func (c *countingClient) Tunnel(ctx context.Context, _ ...grpc.CallOption) (manager.Manager_TunnelClient, error) {
+	atomic.AddInt32(&c.opened, 1)
+	return &countingStream{ctx: ctx}, nil
+}
+
+func TestPrewarmingClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	cc := &countingClient{}
+	assert.Same(t, cc, NewPrewarmingClient(ctx, cc, 0))
+
+	mc := NewPrewarmingClient(ctx, cc, 2)
+	opened := func() int32 { return atomic.LoadInt32(&cc.opened) }
+	require.Eventually(t, func() bool { return opened() == 2 }, 5*time.Second, time.Millisecond)
+
+	// A prewarmed stream is returned, and the pool is refilled
+	sCtx, sCancel := context.WithCancel(ctx)
+	s, err := mc.Tunnel(sCtx)
+	require.NoError(t, err)
+	_, ok := s.(*prewarmedStream)
+	assert.True(t, ok)
+	require.Eventually(t, func() bool { return opened() == 3 }, 5*time.Second, time.Millisecond)
+
+	// The stream is cancelled together with the context that it was returned for
+	require.NoError(t, s.Context().Err())
+	sCancel()
+	require.Eventually(t, func() bool { return s.Context().Err() != nil }, 5*time.Second, time.Millisecond)
+
+	// Streams are opened directly when call options are given
+	s, err = mc.Tunnel(ctx, grpc.WaitForReady(true))
+	require.NoError(t, err)
+	_, ok = s.(*prewarmedStream)
+	assert.False(t, ok)
+}
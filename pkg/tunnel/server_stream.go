@@ -4,21 +4,32 @@ import (
 	"context"
 	"errors"
 	"fmt"
+
+	"github.com/datawire/dlib/dlog"
 )
 
 func NewServerStream(ctx context.Context, grpcStream GRPCStream) (Stream, error) {
-	s := &stream{tag: "SRV", grpcStream: grpcStream, syncRatio: 8, ackWindow: 1}
-	m, err := s.Receive(ctx)
+	tm, err := grpcStream.Recv()
 	if err != nil {
 		return nil, fmt.Errorf("failed to read initial StreamInfo message: %w", err)
 	}
-	if m.Code() != streamInfo {
+	return newServerStream(ctx, grpcStream, msg(tm.Payload))
+}
+
+// newServerStream creates a server stream from the initial message that was read from the gRPC stream.
+func newServerStream(ctx context.Context, grpcStream GRPCStream, m msg) (Stream, error) {
+	s := &stream{tag: "SRV", grpcStream: grpcStream, syncRatio: 8, ackWindow: 1}
+	if len(m) == 0 || m.Code() != streamInfo {
 		return nil, errors.New("initial message was not StreamInfo")
 	}
-	if err = setConnectInfo(m, s); err != nil {
+	dlog.Tracef(ctx, "<- %s, %s", s.tag, m)
+	if err := setConnectInfo(m, s); err != nil {
 		return nil, fmt.Errorf("failed to parse StreamInfo message: %w", err)
 	}
-	if err = s.Send(ctx, StreamOKMessage()); err != nil {
+	if !s.compressor.algorithm.supported() {
+		s.compressor.algorithm = NoCompression
+	}
+	if err := s.Send(ctx, StreamOKMessage(s.compressor.algorithm)); err != nil {
 		return nil, err
 	}
 	return s, nil
@@ -15,7 +15,8 @@ import (
 // Version
 //   0 which didn't report versions and didn't do synchronization
 //   1 used MuxTunnel instead of one tunnel per connection.
-const Version = uint16(2)
+//   2 used one tunnel per connection without compression.
+const Version = uint16(3)
 
 // Endpoint is an endpoint for a Stream such as a Dialer or a bidirectional pipe.
 type Endpoint interface {
@@ -138,6 +139,7 @@ type stream struct {
 	syncRatio        uint32 // send and check sync after each syncRatio message
 	ackWindow        uint32 // maximum permitted difference between sent and received ack
 	peerVersion      uint16
+	compressor       compressor
 }
 
 func newStream(tag string, grpcStream GRPCStream) stream {
@@ -173,8 +175,13 @@ func (s *stream) Receive(ctx context.Context) (Message, error) {
 	if err != nil {
 		return nil, err
 	}
-	m := msg(cm.Payload)
+	var m Message = msg(cm.Payload)
 	switch m.Code() {
+	case compressed:
+		if m, err = decompress(m); err != nil {
+			return nil, err
+		}
+		dlog.Tracef(ctx, "<- %s %s, %s (compressed len %d)", s.tag, s.id, m, len(cm.Payload))
 	case closeSend:
 		dlog.Tracef(ctx, "<- %s %s, close send", s.tag, s.id)
 		return nil, net.ErrClosed
@@ -187,6 +194,9 @@ func (s *stream) Receive(ctx context.Context) (Message, error) {
 }
 
 func (s *stream) Send(ctx context.Context, m Message) error {
+	if m.Code() == Normal && s.compressor.active() {
+		m = s.compressor.compress(ctx, s.tag, s.id, m)
+	}
 	if err := s.grpcStream.Send(m.TunnelMessage()); err != nil {
 		if ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
 			dlog.Errorf(ctx, "!! %s %s, Send failed: %v", s.tag, s.id, err)
@@ -3,6 +3,7 @@ package tunnel
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"net"
@@ -119,7 +120,7 @@ func TestStream_Connect(t *testing.T) {
 	wg.Add(2)
 	go func() {
 		defer wg.Done()
-		client, err := NewClientStream(ctx, tunnel.clientSide(), id, si, 0, 0)
+		client, err := NewClientStream(ctx, tunnel.clientSide(), id, si, 0, 0, NoCompression)
 		require.NoError(t, err)
 		assert.Equal(t, Version, client.PeerVersion())
 		assert.NoError(t, client.CloseSend(ctx))
@@ -220,7 +221,7 @@ func TestStream_Xfer(t *testing.T) {
 		wg.Add(2)
 		go func() {
 			defer wg.Done()
-			if client, err := NewClientStream(ctx, tunnel.clientSide(), id, si, 0, 0); err != nil {
+			if client, err := NewClientStream(ctx, tunnel.clientSide(), id, si, 0, 0, NoCompression); err != nil {
 				errs <- err
 			} else {
 				produce(ctx, client, large, errs)
@@ -252,7 +253,7 @@ func TestStream_Xfer(t *testing.T) {
 		}()
 		go func() {
 			defer wg.Done()
-			if client, err := NewClientStream(ctx, tunnel.clientSide(), id, si, 0, 0); err != nil {
+			if client, err := NewClientStream(ctx, tunnel.clientSide(), id, si, 0, 0, NoCompression); err != nil {
 				errs <- err
 			} else {
 				consume(ctx, client, b, errs)
@@ -290,7 +291,7 @@ func TestStream_Xfer(t *testing.T) {
 		}()
 		go func() {
 			defer wg.Done()
-			if server, err := NewClientStream(ctx, ta.clientSide(), id, si, 0, 0); err != nil {
+			if server, err := NewClientStream(ctx, ta.clientSide(), id, si, 0, 0, NoCompression); err != nil {
 				errs <- err
 			} else {
 				produce(ctx, server, large, errs)
@@ -298,7 +299,7 @@ func TestStream_Xfer(t *testing.T) {
 		}()
 		go func() {
 			defer wg.Done()
-			if client, err := NewClientStream(ctx, tb.clientSide(), id, si, 0, 0); err != nil {
+			if client, err := NewClientStream(ctx, tb.clientSide(), id, si, 0, 0, NoCompression); err != nil {
 				errs <- err
 			} else {
 				consume(ctx, client, b, errs)
@@ -328,3 +329,152 @@ func TestStream_Xfer(t *testing.T) {
 		errs = requireNoErrs(t, errs)
 	})
 }
+
+func TestStream_Compression(t *testing.T) {
+	ctx, cancel := testContext(t, 30*time.Second)
+	defer cancel()
+
+	id := NewConnID(ipproto.TCP, iputil.Parse("127.0.0.1"), iputil.Parse("192.168.0.1"), 1001, 8080)
+	si := uuid.New().String()
+
+	// Repetitive and hence compressible payload
+	compressible := bytes.Repeat([]byte("GET /index.html HTTP/1.1\r\nHost: example.com\r\n\r\n"), 200)
+
+	// Payload that looks like the start of a TLS handshake
+	tlsPayload := make([]byte, len(compressible))
+	copy(tlsPayload, compressible)
+	copy(tlsPayload, []byte{0x16, 0x03, 0x01})
+
+	tests := []struct {
+		name        string
+		compression Compression
+		payload     []byte
+		compressed  bool
+	}{
+		{"none", NoCompression, compressible, false},
+		{"s2", S2Compression, compressible, true},
+		{"zstd", ZstdCompression, compressible, true},
+		{"tls", S2Compression, tlsPayload, false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tunnel := newBidi(10, ctx.Done())
+			wg := sync.WaitGroup{}
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				client, err := NewClientStream(ctx, tunnel.clientSide(), id, si, 0, 0, tt.compression)
+				require.NoError(t, err)
+				require.NoError(t, client.Send(ctx, NewMessage(Normal, tt.payload)))
+				m, err := client.Receive(ctx)
+				require.NoError(t, err)
+				assert.Equal(t, Normal, m.Code())
+				assert.Equal(t, tt.payload, m.Payload())
+				assert.NoError(t, client.CloseSend(ctx))
+			}()
+			go func() {
+				defer wg.Done()
+				server, err := NewServerStream(ctx, tunnel.serverSide())
+				require.NoError(t, err)
+
+				// Peek at the raw message to verify that it was compressed.
+				cm, err := server.(*stream).grpcStream.Recv()
+				require.NoError(t, err)
+				assert.Equal(t, tt.compressed, msg(cm.Payload).Code() == compressed)
+				if tt.compressed {
+					assert.Less(t, len(cm.Payload), len(tt.payload))
+				}
+				var m Message = msg(cm.Payload)
+				if tt.compressed {
+					m, err = decompress(m)
+					require.NoError(t, err)
+				}
+				assert.Equal(t, tt.payload, m.Payload())
+				require.NoError(t, server.Send(ctx, m))
+			}()
+			wg.Wait()
+		})
+	}
+}
+
+func TestCompressor_Incompressible(t *testing.T) {
+	ctx, cancel := testContext(t, time.Second)
+	defer cancel()
+
+	id := NewConnID(ipproto.TCP, iputil.Parse("127.0.0.1"), iputil.Parse("192.168.0.1"), 1001, 8080)
+	random := make([]byte, 0x1000)
+	_, err := rand.Read(random)
+	require.NoError(t, err)
+
+	c := compressor{algorithm: S2Compression}
+	m := NewMessage(Normal, random)
+	for i := 0; i < maxCompressMisses; i++ {
+		require.True(t, c.active())
+		assert.Equal(t, m, c.compress(ctx, "TST", id, m))
+	}
+	assert.False(t, c.active())
+}
+
+func TestStream_Compression_olderPeer(t *testing.T) {
+	ctx, cancel := testContext(t, 30*time.Second)
+	defer cancel()
+
+	id := NewConnID(ipproto.TCP, iputil.Parse("127.0.0.1"), iputil.Parse("192.168.0.1"), 1001, 8080)
+	si := uuid.New().String()
+	compressible := bytes.Repeat([]byte("GET /index.html HTTP/1.1\r\nHost: example.com\r\n\r\n"), 200)
+
+	// versionTwo returns the given message without the compression that version 3 appended to it.
+	versionTwo := func(m Message) *manager.TunnelMessage {
+		pl := m.TunnelMessage().Payload
+		return &manager.TunnelMessage{Payload: pl[:len(pl)-1]}
+	}
+
+	t.Run("server", func(t *testing.T) {
+		tunnel := newBidi(10, ctx.Done())
+		wg := sync.WaitGroup{}
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			client, err := NewClientStream(ctx, tunnel.clientSide(), id, si, 0, 0, S2Compression)
+			require.NoError(t, err)
+			require.NoError(t, client.Send(ctx, NewMessage(Normal, compressible)))
+			assert.NoError(t, client.CloseSend(ctx))
+		}()
+		go func() {
+			defer wg.Done()
+			ss := tunnel.serverSide()
+			_, err := ss.Recv()
+			require.NoError(t, err)
+			require.NoError(t, ss.Send(versionTwo(StreamOKMessage(NoCompression))))
+			cm, err := ss.Recv()
+			require.NoError(t, err)
+			assert.Equal(t, Normal, msg(cm.Payload).Code(), "a server that doesn't know about compression gets uncompressed messages")
+		}()
+		wg.Wait()
+	})
+
+	t.Run("client", func(t *testing.T) {
+		tunnel := newBidi(10, ctx.Done())
+		wg := sync.WaitGroup{}
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			cs := tunnel.clientSide()
+			require.NoError(t, cs.Send(versionTwo(StreamInfoMessage(id, si, 0, 0, NoCompression))))
+			m, err := cs.Recv()
+			require.NoError(t, err)
+			assert.Equal(t, NoCompression, getAcceptedCompression(msg(m.Payload)))
+			cm, err := cs.Recv()
+			require.NoError(t, err)
+			assert.Equal(t, Normal, msg(cm.Payload).Code(), "a client that doesn't know about compression gets uncompressed messages")
+		}()
+		go func() {
+			defer wg.Done()
+			server, err := NewServerStream(ctx, tunnel.serverSide())
+			require.NoError(t, err)
+			require.NoError(t, server.Send(ctx, NewMessage(Normal, compressible)))
+		}()
+		wg.Wait()
+	})
+}
@@ -13,6 +13,11 @@ import (
 // init()-time by inspecting the binary's own debug info.
 var Version string
 
+// ReleaseSigningKey is the base64 encoded ed25519 public key that the checksums of the released binaries are
+// signed with. It's populated at build-time using `--ldflags -X`, and binaries that are built without it can't
+// verify a release, and hence not upgrade themselves.
+var ReleaseSigningKey string
+
 func init() {
 	// Prefer version number inserted at build using --ldflags, but if it's not set...
 	if Version == "" {
@@ -9,6 +9,26 @@ import (
 	"github.com/telepresenceio/telepresence/v2/pkg/vif/routing"
 )
 
+// Interface is what the root daemon uses to configure a TUN device and to exchange packets with it. It's
+// implemented by the Device, and by the in-memory fake.Device that tests use to run without privileges.
+type Interface interface {
+	AddSubnet(ctx context.Context, subnet *net.IPNet) error
+	RemoveSubnet(ctx context.Context, subnet *net.IPNet) error
+	AddStaticRoute(ctx context.Context, route routing.Route) error
+	RemoveStaticRoute(ctx context.Context, route routing.Route) error
+	AddOverrideRoute(ctx context.Context, subnet *net.IPNet, exceptions []*net.IPNet) error
+	RemoveOverrideRoute(ctx context.Context, subnet *net.IPNet) error
+	Name() string
+	Index() int32
+	ReadPacket(into *buffer.Data) (int, error)
+	WritePacket(from *buffer.Data, offset int) (int, error)
+	SetDNS(ctx context.Context, server net.IP, domains []string) error
+	SetMTU(mtu int) error
+	Close() error
+}
+
+var _ Interface = (*Device)(nil)
+
 // OpenTun creates a new TUN device and ensures that it is up and running.
 func OpenTun(ctx context.Context) (*Device, error) {
 	return openTun(ctx)
@@ -39,6 +59,37 @@ func (t *Device) RemoveStaticRoute(ctx context.Context, route routing.Route) err
 	return t.removeStaticRoute(ctx, route)
 }
 
+// AddOverrideRoute adds a route for the given subnet through this device that takes precedence over the routes of
+// other interfaces for addresses in that subnet, even when those routes are more specific. Addresses in the given
+// exceptions, e.g. the never-proxy subnets, keep their routes. The routing of the subnet is restored by
+// RemoveOverrideRoute.
+//
+// Routes of other interfaces that must be removed to make the route take precedence are passed to the
+// OverriddenRoutesJournal of the context before they are removed.
+func (t *Device) AddOverrideRoute(ctx context.Context, subnet *net.IPNet, exceptions []*net.IPNet) error {
+	dlog.Debugf(ctx, "Adding override route %s", subnet)
+	return t.addOverrideRoute(ctx, subnet, exceptions)
+}
+
+// RemoveOverrideRoute removes a route added via AddOverrideRoute.
+func (t *Device) RemoveOverrideRoute(ctx context.Context, subnet *net.IPNet) error {
+	dlog.Debugf(ctx, "Dropping override route %s", subnet)
+	return t.removeOverrideRoute(ctx, subnet)
+}
+
+// OverriddenRoutesJournal is called with all routes that the override routes of a device have removed, whenever
+// that set changes. It's called before routes are removed, so that they can be restored by another process if
+// this one doesn't get a chance to do so. An error prevents the removal.
+type OverriddenRoutesJournal func(ctx context.Context, removed []routing.Route) error
+
+type overriddenRoutesJournalKey struct{}
+
+// WithOverriddenRoutesJournal returns a context that makes AddOverrideRoute and RemoveOverrideRoute report the
+// routes that they remove to the given journal.
+func WithOverriddenRoutesJournal(ctx context.Context, journal OverriddenRoutesJournal) context.Context {
+	return context.WithValue(ctx, overriddenRoutesJournalKey{}, journal)
+}
+
 // Name returns the name of this device, e.g. "tun0"
 func (t *Device) Name() string {
 	return t.name
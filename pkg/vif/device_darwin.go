@@ -13,6 +13,7 @@ import (
 	"golang.org/x/net/ipv6"
 	"golang.org/x/sys/unix"
 
+	"github.com/datawire/dlib/dexec"
 	"github.com/telepresenceio/telepresence/v2/pkg/vif/buffer"
 	"github.com/telepresenceio/telepresence/v2/pkg/vif/routing"
 )
@@ -23,7 +24,11 @@ const uTunControlName = "com.apple.net.utun_control"
 
 type Device struct {
 	*os.File
-	name string
+	name  string
+	index int32
+
+	// overridden are the routes that were removed by addOverrideRoute, keyed by the overriding subnet.
+	overridden map[string][]routing.Route
 }
 
 func openTun(_ context.Context) (*Device, error) {
@@ -56,9 +61,14 @@ func openTun(_ context.Context) (*Device, error) {
 	if err != nil {
 		return nil, err
 	}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
 	return &Device{
-		File: os.NewFile(uintptr(fd), ""),
-		name: name,
+		File:  os.NewFile(uintptr(fd), ""),
+		name:  name,
+		index: int32(iface.Index),
 	}, nil
 }
 
@@ -98,6 +108,26 @@ func (t *Device) removeStaticRoute(ctx context.Context, route routing.Route) err
 	})
 }
 
+func (t *Device) addInterfaceRoute(ctx context.Context, subnet *net.IPNet) error {
+	return dexec.CommandContext(ctx, "route", "-n", "add", routeFamilyFlag(subnet), "-net", subnet.String(), "-interface", t.name).Run()
+}
+
+func (t *Device) removeInterfaceRoute(ctx context.Context, subnet *net.IPNet) error {
+	return dexec.CommandContext(ctx, "route", "-n", "delete", routeFamilyFlag(subnet), "-net", subnet.String(), "-interface", t.name).Run()
+}
+
+func routeFamilyFlag(subnet *net.IPNet) string {
+	if subnet.IP.To4() != nil {
+		return "-inet"
+	}
+	return "-inet6"
+}
+
+// Index returns the index of this device
+func (t *Device) Index() int32 {
+	return t.index
+}
+
 func (t *Device) setMTU(mtu int) error {
 	return withSocket(unix.AF_INET, func(fd int) error {
 		var ifr unix.IfreqMTU
@@ -21,6 +21,13 @@ type Device struct {
 	*os.File
 	name  string
 	index int32
+
+	// netns is the network namespace that the device was created in. It's empty when the device is in the
+	// namespace of the host.
+	netns string
+
+	// overrideExceptions are the exceptions that addOverrideRoute added rules for, keyed by the overriding subnet.
+	overrideExceptions map[string][]*net.IPNet
 }
 
 func openTun(_ context.Context) (*Device, error) {
@@ -92,20 +99,35 @@ func openTun(_ context.Context) (*Device, error) {
 	return &Device{File: os.NewFile(uintptr(fd), devicePath), name: name, index: index}, nil
 }
 
+// ipCommand returns an "ip" command that runs in the network namespace of the device.
+func (t *Device) ipCommand(ctx context.Context, args ...string) *dexec.Cmd {
+	if t.netns != "" {
+		args = append([]string{"-n", t.netns}, args...)
+	}
+	return dexec.CommandContext(ctx, "ip", args...)
+}
+
 func (t *Device) addSubnet(ctx context.Context, subnet *net.IPNet) error {
-	return dexec.CommandContext(ctx, "ip", "a", "add", subnet.String(), "dev", t.name).Run()
+	return t.ipCommand(ctx, "a", "add", subnet.String(), "dev", t.name).Run()
 }
 
 func (t *Device) removeSubnet(ctx context.Context, subnet *net.IPNet) error {
-	return dexec.CommandContext(ctx, "ip", "a", "del", subnet.String(), "dev", t.name).Run()
+	return t.ipCommand(ctx, "a", "del", subnet.String(), "dev", t.name).Run()
 }
 
 func (t *Device) addStaticRoute(ctx context.Context, route routing.Route) error {
-	return dexec.CommandContext(ctx, "ip", "route", "add", route.RoutedNet.String(), "via", route.Gateway.String(), "dev", route.Interface.Name).Run()
+	if t.netns != "" {
+		// The namespace has no other device that the route could use.
+		return nil
+	}
+	return route.AddStatic(ctx)
 }
 
 func (t *Device) removeStaticRoute(ctx context.Context, route routing.Route) error {
-	return dexec.CommandContext(ctx, "ip", "route", "del", route.RoutedNet.String(), "via", route.Gateway.String(), "dev", route.Interface.Name).Run()
+	if t.netns != "" {
+		return nil
+	}
+	return route.RemoveStatic(ctx)
 }
 
 // Index returns the index of this device
@@ -114,6 +136,15 @@ func (t *Device) Index() int32 {
 }
 
 func (t *Device) setMTU(mtu int) error {
+	if t.netns != "" {
+		return withNetNamespace(t.netns, func() error {
+			return t.setMTUInCurrentNamespace(mtu)
+		})
+	}
+	return t.setMTUInCurrentNamespace(mtu)
+}
+
+func (t *Device) setMTUInCurrentNamespace(mtu int) error {
 	return withSocket(unix.AF_INET, func(fd int) error {
 		var mtuRequest struct {
 			name [unix.IFNAMSIZ]byte
@@ -27,6 +27,9 @@ type Device struct {
 	name           string
 	dns            net.IP
 	interfaceIndex uint32
+
+	// overridden are the routes that were removed by addOverrideRoute, keyed by the overriding subnet.
+	overridden map[string][]routing.Route
 }
 
 func openTun(ctx context.Context) (td *Device, err error) {
@@ -169,45 +172,32 @@ $job | Receive-Job
 	return nil
 }
 
-func maskToIP(mask net.IPMask) (ip net.IP) {
-	ip = make(net.IP, len(mask))
-	copy(ip[:], mask)
-	return ip
-}
-
 func (t *Device) addStaticRoute(ctx context.Context, route routing.Route) error {
-	mask := maskToIP(route.RoutedNet.Mask)
-	cmd := proc.CommandContext(ctx,
-		"route",
-		"ADD",
-		route.RoutedNet.IP.String(),
-		"MASK",
-		mask.String(),
-		route.Gateway.String(),
-	)
-	cmd.DisableLogging = true
-	out, err := cmd.Output()
-	if err != nil {
-		return fmt.Errorf("failed to create route %s: %w", route, err)
-	}
-	if !strings.Contains(string(out), "OK!") {
-		return fmt.Errorf("failed to create route %s: %s", route, strings.TrimSpace(string(out)))
-	}
-	return nil
+	return route.AddStatic(ctx)
 }
 
 func (t *Device) removeStaticRoute(ctx context.Context, route routing.Route) error {
-	cmd := proc.CommandContext(ctx,
-		"route",
-		"DELETE",
-		route.RoutedNet.IP.String(),
-	)
-	cmd.DisableLogging = true
-	err := cmd.Run()
-	if err != nil {
-		return fmt.Errorf("failed to delete route %s: %w", route, err)
+	return route.RemoveStatic(ctx)
+}
+
+func onLinkNextHop(subnet *net.IPNet) netip.Addr {
+	if subnet.IP.To4() != nil {
+		return netip.IPv4Unspecified()
 	}
-	return nil
+	return netip.IPv6Unspecified()
+}
+
+func (t *Device) addInterfaceRoute(_ context.Context, subnet *net.IPNet) error {
+	return t.getLUID().AddRoute(prefixFromIPNet(subnet), onLinkNextHop(subnet), 0)
+}
+
+func (t *Device) removeInterfaceRoute(_ context.Context, subnet *net.IPNet) error {
+	return t.getLUID().DeleteRoute(prefixFromIPNet(subnet), onLinkNextHop(subnet))
+}
+
+// Index returns the index of this device
+func (t *Device) Index() int32 {
+	return int32(t.interfaceIndex)
 }
 
 func (t *Device) setMTU(mtu int) error {
@@ -0,0 +1,247 @@
+// Package fake contains an in-memory implementation of the vif.Interface, so that the logic that configures and uses a
+// TUN device can be tested without privileges and without touching the network configuration of the machine.
+package fake
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/subnet"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/buffer"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/routing"
+)
+
+// ErrClosed is returned by the methods of a Device that has been closed.
+var ErrClosed = errors.New("device is closed")
+
+// Device is an in-memory vif.Interface. It keeps track of the subnets, routes, and DNS configuration that are added
+// to it. Packets that are injected using Inject are returned by ReadPacket, and packets that are written using
+// WritePacket are delivered on the channel returned by Written.
+type Device struct {
+	name  string
+	index int32
+
+	mu             sync.Mutex
+	subnets        []*net.IPNet
+	staticRoutes   []routing.Route
+	overrideRoutes []*net.IPNet
+	exceptions     map[string][]*net.IPNet
+	dnsServer      net.IP
+	dnsDomains     []string
+	mtu            int
+
+	incoming  chan []byte
+	written   chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+var _ vif.Interface = (*Device)(nil)
+
+// NewDevice returns a new Device with the given name and index.
+func NewDevice(name string, index int32) *Device {
+	return &Device{
+		name:     name,
+		index:    index,
+		incoming: make(chan []byte, 100),
+		written:  make(chan []byte, 100),
+		closed:   make(chan struct{}),
+	}
+}
+
+func (d *Device) AddSubnet(_ context.Context, sn *net.IPNet) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, s := range d.subnets {
+		if subnet.Equal(s, sn) {
+			return fmt.Errorf("subnet %s already exists", sn)
+		}
+	}
+	d.subnets = append(d.subnets, sn)
+	return nil
+}
+
+func (d *Device) RemoveSubnet(_ context.Context, sn *net.IPNet) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, s := range d.subnets {
+		if subnet.Equal(s, sn) {
+			d.subnets = append(d.subnets[:i], d.subnets[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("subnet %s does not exist", sn)
+}
+
+func (d *Device) AddStaticRoute(_ context.Context, route routing.Route) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, r := range d.staticRoutes {
+		if subnet.Equal(r.RoutedNet, route.RoutedNet) {
+			return fmt.Errorf("static route %s already exists", route.RoutedNet)
+		}
+	}
+	d.staticRoutes = append(d.staticRoutes, route)
+	return nil
+}
+
+func (d *Device) RemoveStaticRoute(_ context.Context, route routing.Route) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, r := range d.staticRoutes {
+		if subnet.Equal(r.RoutedNet, route.RoutedNet) {
+			d.staticRoutes = append(d.staticRoutes[:i], d.staticRoutes[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("static route %s does not exist", route.RoutedNet)
+}
+
+func (d *Device) AddOverrideRoute(_ context.Context, sn *net.IPNet, exceptions []*net.IPNet) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, s := range d.overrideRoutes {
+		if subnet.Equal(s, sn) {
+			return fmt.Errorf("override route %s already exists", sn)
+		}
+	}
+	d.overrideRoutes = append(d.overrideRoutes, sn)
+	if d.exceptions == nil {
+		d.exceptions = make(map[string][]*net.IPNet)
+	}
+	d.exceptions[sn.String()] = exceptions
+	return nil
+}
+
+func (d *Device) RemoveOverrideRoute(_ context.Context, sn *net.IPNet) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, s := range d.overrideRoutes {
+		if subnet.Equal(s, sn) {
+			d.overrideRoutes = append(d.overrideRoutes[:i], d.overrideRoutes[i+1:]...)
+			delete(d.exceptions, sn.String())
+			return nil
+		}
+	}
+	return fmt.Errorf("override route %s does not exist", sn)
+}
+
+func (d *Device) Name() string {
+	return d.name
+}
+
+func (d *Device) Index() int32 {
+	return d.index
+}
+
+// ReadPacket blocks until a packet is injected or the device is closed.
+func (d *Device) ReadPacket(into *buffer.Data) (int, error) {
+	select {
+	case <-d.closed:
+		return 0, ErrClosed
+	case pkt := <-d.incoming:
+		return copy(into.Buf(), pkt), nil
+	}
+}
+
+// WritePacket delivers a copy of the packet on the channel returned by Written. The packet is discarded when that
+// channel's buffer is full.
+func (d *Device) WritePacket(from *buffer.Data, offset int) (int, error) {
+	select {
+	case <-d.closed:
+		return 0, ErrClosed
+	default:
+	}
+	b := from.Buf()[offset:]
+	pkt := make([]byte, len(b))
+	copy(pkt, b)
+	select {
+	case d.written <- pkt:
+	default:
+	}
+	return len(b), nil
+}
+
+func (d *Device) SetDNS(_ context.Context, server net.IP, domains []string) error {
+	d.mu.Lock()
+	d.dnsServer = server
+	d.dnsDomains = domains
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *Device) SetMTU(mtu int) error {
+	d.mu.Lock()
+	d.mtu = mtu
+	d.mu.Unlock()
+	return nil
+}
+
+// Close closes the device. The subnets and routes of the device are removed, just like they are when a real TUN
+// device is closed, except for the static routes, which belong to other interfaces.
+func (d *Device) Close() error {
+	d.closeOnce.Do(func() {
+		close(d.closed)
+		d.mu.Lock()
+		d.subnets = nil
+		d.overrideRoutes = nil
+		d.mu.Unlock()
+	})
+	return nil
+}
+
+// Inject makes the given packet available to ReadPacket, as if it had been sent to the device by the host.
+func (d *Device) Inject(pkt []byte) {
+	d.incoming <- pkt
+}
+
+// Written returns the channel on which the packets that are written to the device are delivered.
+func (d *Device) Written() <-chan []byte {
+	return d.written
+}
+
+// Subnets returns the subnets that have been added to the device.
+func (d *Device) Subnets() []*net.IPNet {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]*net.IPNet(nil), d.subnets...)
+}
+
+// StaticRoutes returns the static routes that have been added.
+func (d *Device) StaticRoutes() []routing.Route {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]routing.Route(nil), d.staticRoutes...)
+}
+
+// OverrideRoutes returns the override routes that have been added.
+func (d *Device) OverrideRoutes() []*net.IPNet {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]*net.IPNet(nil), d.overrideRoutes...)
+}
+
+// OverrideExceptions returns the exceptions that the override route for the given subnet was added with.
+func (d *Device) OverrideExceptions(sn *net.IPNet) []*net.IPNet {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]*net.IPNet(nil), d.exceptions[sn.String()]...)
+}
+
+// DNS returns the DNS server and the domains that were set using SetDNS.
+func (d *Device) DNS() (net.IP, []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dnsServer, d.dnsDomains
+}
+
+// MTU returns the MTU that was set using SetMTU, or zero if it wasn't set.
+func (d *Device) MTU() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.mtu
+}
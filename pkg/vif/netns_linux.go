@@ -0,0 +1,146 @@
+package vif
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/datawire/dlib/dexec"
+)
+
+// netnsConfDir is where "ip netns exec" finds the files that it bind-mounts over the ones in /etc for
+// the processes that it runs in a namespace.
+var netnsConfDir = "/etc/netns"
+
+// CreateNetNamespace creates a network namespace with the given name and brings up its loopback device. The
+// namespace is empty otherwise, so nothing that runs in it can reach the network until a device is added.
+func CreateNetNamespace(ctx context.Context, name string) error {
+	// A namespace that is left behind by a daemon that didn't get a chance to delete it is replaced.
+	_ = DeleteNetNamespace(ctx, name)
+	if err := dexec.CommandContext(ctx, "ip", "netns", "add", name).Run(); err != nil {
+		return fmt.Errorf("failed to create network namespace %s: %w", name, err)
+	}
+	if err := dexec.CommandContext(ctx, "ip", "-n", name, "link", "set", "lo", "up").Run(); err != nil {
+		_ = DeleteNetNamespace(ctx, name)
+		return fmt.Errorf("failed to bring up the loopback device of network namespace %s: %w", name, err)
+	}
+	return nil
+}
+
+// DeleteNetNamespace deletes the given network namespace together with the files that configure it.
+func DeleteNetNamespace(ctx context.Context, name string) error {
+	_ = os.RemoveAll(filepath.Join(netnsConfDir, name))
+	return dexec.CommandContext(ctx, "ip", "netns", "del", name).Run()
+}
+
+// WriteNetNamespaceResolvConf writes the resolv.conf that the processes that run in the given network namespace
+// use, so that their names are resolved by the given server using the given search domains.
+func WriteNetNamespaceResolvConf(name string, server net.IP, search []string) error {
+	dir := filepath.Join(netnsConfDir, name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	sb := strings.Builder{}
+	fmt.Fprintf(&sb, "# Generated by telepresence for network namespace %s\n", name)
+	fmt.Fprintf(&sb, "nameserver %s\n", server)
+	if len(search) > 0 {
+		sb.WriteString("search")
+		for _, s := range search {
+			sb.WriteByte(' ')
+			sb.WriteString(strings.TrimSuffix(s, "."))
+		}
+		sb.WriteByte('\n')
+	}
+	// The file is rewritten in place, because it's bind-mounted over /etc/resolv.conf in the processes that already
+	// run in the namespace.
+	return os.WriteFile(filepath.Join(dir, "resolv.conf"), []byte(sb.String()), 0o644)
+}
+
+// OpenTunInNetNamespace creates a new TUN device in the given network namespace and ensures that it is up and
+// running. The subnets of the device are routed in that namespace only.
+func OpenTunInNetNamespace(ctx context.Context, name string) (dev *Device, err error) {
+	err = withNetNamespace(name, func() error {
+		dev, err = openTun(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	dev.netns = name
+	return dev, nil
+}
+
+// StartInNetNamespace starts the given command in the given network namespace, like "ip netns exec" does. The files
+// in /etc/netns/<name> are bind-mounted over the ones in /etc for the command, and its /sys shows the devices of the
+// namespace. The mounts are made in a mount namespace of the command's own, so the host never sees them.
+func StartInNetNamespace(name string, cmd *exec.Cmd) error {
+	return withNetNamespace(name, func() error {
+		if err := mountNetNamespaceFiles(name); err != nil {
+			return err
+		}
+		// The command inherits the namespaces of the thread that starts it.
+		return cmd.Start()
+	})
+}
+
+// mountNetNamespaceFiles moves the current thread to a new mount namespace and makes the mounts that "ip netns exec"
+// makes for the given network namespace. It must be called on a thread that has entered that namespace.
+func mountNetNamespaceFiles(name string) error {
+	if err := unix.Unshare(unix.CLONE_NEWNS); err != nil {
+		return fmt.Errorf("failed to create a mount namespace: %w", err)
+	}
+	// Mounts that propagate to the host would replace its /etc/resolv.conf.
+	if err := unix.Mount("", "/", "none", unix.MS_SLAVE|unix.MS_REC, ""); err != nil {
+		return fmt.Errorf("failed to make the mounts of the mount namespace private: %w", err)
+	}
+	if err := unix.Unmount("/sys", unix.MNT_DETACH); err == nil {
+		if err = unix.Mount(name, "/sys", "sysfs", 0, ""); err != nil {
+			return fmt.Errorf("failed to mount /sys of network namespace %s: %w", name, err)
+		}
+	}
+	dir := filepath.Join(netnsConfDir, name)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, e := range entries {
+		src, dst := filepath.Join(dir, e.Name()), filepath.Join("/etc", e.Name())
+		if err = unix.Mount(src, dst, "none", unix.MS_BIND, ""); err != nil {
+			return fmt.Errorf("failed to bind %s to %s: %w", src, dst, err)
+		}
+	}
+	return nil
+}
+
+// withNetNamespace calls the given function on a thread that has entered the given network namespace. Sockets and
+// devices that the function creates belong to that namespace. The thread is never returned to the runtime, so it
+// terminates together with the goroutine that the function runs in.
+func withNetNamespace(name string, f func() error) error {
+	errCh := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		ns, err := unix.Open(filepath.Join("/run/netns", name), unix.O_RDONLY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			errCh <- fmt.Errorf("failed to open network namespace %s: %w", name, err)
+			return
+		}
+		err = unix.Setns(ns, unix.CLONE_NEWNET)
+		_ = unix.Close(ns)
+		if err != nil {
+			errCh <- fmt.Errorf("failed to enter network namespace %s: %w", name, err)
+			return
+		}
+		errCh <- f()
+	}()
+	return <-errCh
+}
@@ -0,0 +1,106 @@
+package vif
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dexec"
+	"github.com/datawire/dlib/dlog"
+)
+
+func TestWriteNetNamespaceResolvConf(t *testing.T) {
+	defer func(d string) { netnsConfDir = d }(netnsConfDir)
+	netnsConfDir = t.TempDir()
+	file := filepath.Join(netnsConfDir, "tel", "resolv.conf")
+
+	require.NoError(t, WriteNetNamespaceResolvConf("tel", net.IP{10, 0, 0, 53}, nil))
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "# Generated by telepresence for network namespace tel\nnameserver 10.0.0.53\n", string(data))
+	st, err := os.Stat(file)
+	require.NoError(t, err)
+
+	// The file is bind-mounted by the processes in the namespace, so it must be rewritten in place
+	require.NoError(t, WriteNetNamespaceResolvConf("tel", net.IP{10, 0, 0, 53}, []string{"default.svc.cluster.local.", "svc.cluster.local"}))
+	data, err = os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "# Generated by telepresence for network namespace tel\nnameserver 10.0.0.53\nsearch default.svc.cluster.local svc.cluster.local\n", string(data))
+	st2, err := os.Stat(file)
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(st, st2))
+}
+
+// testNetNamespace creates a network namespace that is deleted when the test ends. The test is skipped unless it
+// runs as root.
+func testNetNamespace(t *testing.T) string {
+	if os.Geteuid() != 0 {
+		t.Skip("network namespaces can only be created by root")
+	}
+	ctx := dlog.NewTestContext(t, false)
+	name := "tel-test-" + strconv.Itoa(os.Getpid())
+	require.NoError(t, CreateNetNamespace(ctx, name))
+	t.Cleanup(func() {
+		assert.NoError(t, DeleteNetNamespace(ctx, name))
+		assert.NoDirExists(t, filepath.Join(netnsConfDir, name))
+	})
+	return name
+}
+
+func TestOpenTunInNetNamespace(t *testing.T) {
+	name := testNetNamespace(t)
+	ctx := dlog.NewTestContext(t, false)
+	dev, err := OpenTunInNetNamespace(ctx, name)
+	require.NoError(t, err)
+	defer dev.Close()
+
+	require.NoError(t, dev.AddSubnet(ctx, mustParseCIDR(t, "10.128.0.0/16")))
+	require.NoError(t, dev.SetMTU(1400))
+	out, err := dexec.CommandContext(ctx, "ip", "-n", name, "addr", "show", "dev", dev.Name()).Output()
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "mtu 1400")
+	assert.Contains(t, string(out), "10.128.0.0/16")
+
+	// The device isn't visible in the host's namespace
+	assert.Error(t, dexec.CommandContext(ctx, "ip", "link", "show", "dev", dev.Name()).Run())
+}
+
+func TestStartInNetNamespace(t *testing.T) {
+	name := testNetNamespace(t)
+	require.NoError(t, WriteNetNamespaceResolvConf(name, net.IP{10, 0, 0, 53}, []string{"default.svc.cluster.local."}))
+	hostResolvConf, err := os.ReadFile("/etc/resolv.conf")
+	require.NoError(t, err)
+
+	stdout := bytes.Buffer{}
+	cmd := exec.Command("sh", "-c", "cat /etc/resolv.conf; ls /sys/class/net; id -u")
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: 65534, Gid: 65534}}
+	require.NoError(t, StartInNetNamespace(name, cmd))
+	require.NoError(t, cmd.Wait())
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	assert.Equal(t, []string{
+		"# Generated by telepresence for network namespace " + name,
+		"nameserver 10.0.0.53",
+		"search default.svc.cluster.local",
+		"lo",
+		"65534",
+	}, lines)
+
+	// The host keeps its own resolv.conf
+	data, err := os.ReadFile("/etc/resolv.conf")
+	require.NoError(t, err)
+	assert.Equal(t, hostResolvConf, data)
+
+	assert.Error(t, StartInNetNamespace("tel-test-missing", exec.Command("true")))
+}
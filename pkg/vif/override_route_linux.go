@@ -0,0 +1,131 @@
+package vif
+
+import (
+	"context"
+	"net"
+
+	"github.com/datawire/dlib/dexec"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/subnet"
+)
+
+// The override routes are kept in a routing table of their own, which is consulted before the main routing table
+// by means of a rule with a higher priority for each overridden subnet. The exceptions of an override route get
+// rules with an even higher priority that make them use the main routing table, so that they keep their routes.
+//
+// No routes of other interfaces are removed, so the OverriddenRoutesJournal is never called on this platform.
+const (
+	overrideRouteTable        = "7301"
+	overrideRulePriority      = "7301"
+	overrideExceptionTable    = "main"
+	overrideExceptionPriority = "7300"
+)
+
+func ipFamilyFlag(subnet *net.IPNet) string {
+	if subnet.IP.To4() != nil {
+		return "-4"
+	}
+	return "-6"
+}
+
+// overrideExceptions returns the exceptions that are within the given subnet and of its IP family. Other exceptions
+// aren't affected by the override route.
+func overrideExceptions(sn *net.IPNet, exceptions []*net.IPNet) []*net.IPNet {
+	_, bits := sn.Mask.Size()
+	var within []*net.IPNet
+	for _, e := range exceptions {
+		if _, eBits := e.Mask.Size(); eBits == bits && (sn.Contains(e.IP) || e.Contains(sn.IP)) {
+			within = append(within, e)
+		}
+	}
+	return within
+}
+
+func (t *Device) addOverrideRoute(ctx context.Context, subnet *net.IPNet, exceptions []*net.IPNet) (err error) {
+	family := ipFamilyFlag(subnet)
+	exceptions = overrideExceptions(subnet, exceptions)
+	var added []*net.IPNet
+	defer func() {
+		if err != nil {
+			t.removeExceptionRules(ctx, family, added)
+		}
+	}()
+
+	// The exceptions are added first so that their addresses never use the override route. An exception can be shared
+	// by several override routes, but the kernel refuses duplicate rules, so its rule is only added once.
+	for _, e := range exceptions {
+		if t.hasExceptionRule(e) {
+			added = append(added, e)
+			continue
+		}
+		if err = t.ipCommand(ctx, family, "rule", "add", "to", e.String(), "lookup", overrideExceptionTable, "priority", overrideExceptionPriority).Run(); err != nil {
+			return err
+		}
+		added = append(added, e)
+	}
+	if err = t.ipCommand(ctx, family, "route", "add", subnet.String(), "dev", t.name, "table", overrideRouteTable).Run(); err != nil {
+		return err
+	}
+	err = t.ipCommand(ctx, family, "rule", "add", "to", subnet.String(), "lookup", overrideRouteTable, "priority", overrideRulePriority).Run()
+	if err != nil {
+		_ = t.ipCommand(ctx, family, "route", "del", subnet.String(), "dev", t.name, "table", overrideRouteTable).Run()
+		return err
+	}
+	if t.overrideExceptions == nil {
+		t.overrideExceptions = make(map[string][]*net.IPNet)
+	}
+	t.overrideExceptions[subnet.String()] = added
+	return nil
+}
+
+func (t *Device) removeOverrideRoute(ctx context.Context, subnet *net.IPNet) error {
+	family := ipFamilyFlag(subnet)
+	err := t.ipCommand(ctx, family, "rule", "del", "to", subnet.String(), "lookup", overrideRouteTable, "priority", overrideRulePriority).Run()
+
+	// The route is removed together with the device, so failing to remove it is not an error.
+	_ = t.ipCommand(ctx, family, "route", "del", subnet.String(), "dev", t.name, "table", overrideRouteTable).Run()
+
+	key := subnet.String()
+	exceptions := t.overrideExceptions[key]
+	delete(t.overrideExceptions, key)
+	t.removeExceptionRules(ctx, family, exceptions)
+	return err
+}
+
+func (t *Device) hasExceptionRule(e *net.IPNet) bool {
+	for _, es := range t.overrideExceptions {
+		for _, o := range es {
+			if subnet.Equal(e, o) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// removeExceptionRules removes the rules of the given exceptions, unless another override route still uses them.
+func (t *Device) removeExceptionRules(ctx context.Context, family string, exceptions []*net.IPNet) {
+	for _, e := range exceptions {
+		if t.hasExceptionRule(e) {
+			continue
+		}
+		err := t.ipCommand(ctx, family, "rule", "del", "to", e.String(), "lookup", overrideExceptionTable, "priority", overrideExceptionPriority).Run()
+		if err != nil {
+			dlog.Errorf(ctx, "unable to remove the rule that excepts %s from the override routes: %v", e, err)
+		}
+	}
+}
+
+// CleanupOverrideRoutes removes the rules and routes that make override routes take precedence, along with the rules
+// of their exceptions, regardless of what device they were added for. It's used when cleaning up after a process that
+// didn't get a chance to remove them.
+func CleanupOverrideRoutes(ctx context.Context) {
+	for _, family := range []string{"-4", "-6"} {
+		// A rule is deleted per call, so the calls are repeated until they fail because no rule remains.
+		for _, priority := range []string{overrideRulePriority, overrideExceptionPriority} {
+			for dexec.CommandContext(ctx, "ip", family, "rule", "del", "priority", priority).Run() == nil {
+			}
+		}
+		_ = dexec.CommandContext(ctx, "ip", family, "route", "flush", "table", overrideRouteTable).Run()
+	}
+}
@@ -0,0 +1,37 @@
+package vif
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustParseCIDR(t *testing.T, cidr string) *net.IPNet {
+	_, sn, err := net.ParseCIDR(cidr)
+	require.NoError(t, err)
+	return sn
+}
+
+func Test_overrideExceptions(t *testing.T) {
+	exceptions := []*net.IPNet{
+		mustParseCIDR(t, "10.96.0.1/32"),
+		mustParseCIDR(t, "10.0.0.0/8"),
+		mustParseCIDR(t, "10.97.0.1/32"),
+		mustParseCIDR(t, "fd00::1/128"),
+	}
+	got := overrideExceptions(mustParseCIDR(t, "10.96.0.0/16"), exceptions)
+
+	// Exceptions within the subnet, and the ones that cover it, are retained. Others, including the ones with another
+	// IP family, don't affect the override route.
+	assert.Equal(t, exceptions[:2], got)
+	assert.Empty(t, overrideExceptions(mustParseCIDR(t, "fd01::/64"), exceptions))
+}
+
+func Test_hasExceptionRule(t *testing.T) {
+	e := mustParseCIDR(t, "10.96.0.1/32")
+	d := &Device{overrideExceptions: map[string][]*net.IPNet{"10.96.0.0/16": {e}}}
+	assert.True(t, d.hasExceptionRule(mustParseCIDR(t, "10.96.0.1/32")))
+	assert.False(t, d.hasExceptionRule(mustParseCIDR(t, "10.96.0.2/32")))
+}
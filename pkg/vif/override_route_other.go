@@ -0,0 +1,134 @@
+//go:build !linux
+// +build !linux
+
+package vif
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/subnet"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/routing"
+)
+
+// addOverrideRoute removes the routes of other interfaces that would take precedence over a route for the given subnet
+// through this device, i.e. the routes for the subnet itself and for the subnets within it, and then adds the route.
+// Routes that are covered by one of the given exceptions are retained. The removed routes are journaled before they
+// are removed, and restored by removeOverrideRoute.
+func (t *Device) addOverrideRoute(ctx context.Context, subnet *net.IPNet, exceptions []*net.IPNet) error {
+	shadowing, err := t.shadowingRoutes(ctx, subnet, exceptions)
+	if err != nil {
+		return err
+	}
+	key := subnet.String()
+	if t.overridden == nil {
+		t.overridden = make(map[string][]routing.Route)
+	}
+	t.overridden[key] = shadowing
+	if err = journalOverriddenRoutes(ctx, t.allOverridden()); err != nil {
+		delete(t.overridden, key)
+		return fmt.Errorf("unable to journal the routes that conflict with %s: %w", subnet, err)
+	}
+
+	removed := make([]routing.Route, 0, len(shadowing))
+	for _, r := range shadowing {
+		dlog.Infof(ctx, "Removing route %s while %s is routed to %s", r, subnet, t.name)
+		if err = t.removeStaticRoute(ctx, r); err != nil {
+			err = fmt.Errorf("unable to remove route %s that conflicts with %s: %w", r, subnet, err)
+			break
+		}
+		removed = append(removed, r)
+	}
+	if err == nil {
+		err = t.addInterfaceRoute(ctx, subnet)
+	}
+	if err != nil {
+		t.restoreRoutes(ctx, removed)
+		delete(t.overridden, key)
+		t.journal(ctx)
+		return err
+	}
+	return nil
+}
+
+func (t *Device) removeOverrideRoute(ctx context.Context, subnet *net.IPNet) error {
+	key := subnet.String()
+	err := t.removeInterfaceRoute(ctx, subnet)
+	t.restoreRoutes(ctx, t.overridden[key])
+	delete(t.overridden, key)
+	t.journal(ctx)
+	return err
+}
+
+// allOverridden returns the routes that are removed by all override routes of this device.
+func (t *Device) allOverridden() []routing.Route {
+	var all []routing.Route
+	for _, rs := range t.overridden {
+		all = append(all, rs...)
+	}
+	return all
+}
+
+// journal passes the routes that are currently removed to the OverriddenRoutesJournal of the context. Failing to do
+// so after routes have been restored only means that a cleanup would restore routes that already exist.
+func (t *Device) journal(ctx context.Context) {
+	if err := journalOverriddenRoutes(ctx, t.allOverridden()); err != nil {
+		dlog.Errorf(ctx, "unable to journal the overridden routes: %v", err)
+	}
+}
+
+// journalOverriddenRoutes passes the given routes to the OverriddenRoutesJournal of the context, if any.
+func journalOverriddenRoutes(ctx context.Context, removed []routing.Route) error {
+	if journal, ok := ctx.Value(overriddenRoutesJournalKey{}).(OverriddenRoutesJournal); ok {
+		return journal(ctx, removed)
+	}
+	return nil
+}
+
+func (t *Device) restoreRoutes(ctx context.Context, routes []routing.Route) {
+	for _, r := range routes {
+		dlog.Infof(ctx, "Restoring route %s", r)
+		if err := t.addStaticRoute(ctx, r); err != nil {
+			dlog.Errorf(ctx, "unable to restore route %s: %v", r, err)
+		}
+	}
+}
+
+func (t *Device) shadowingRoutes(ctx context.Context, subnet *net.IPNet, exceptions []*net.IPNet) ([]routing.Route, error) {
+	rt, err := routing.GetRoutingTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return shadowingRoutes(rt, t.name, subnet, exceptions), nil
+}
+
+// shadowingRoutes returns the routes of the given routing table that are for the given subnet, or for subnets
+// within it, and that use another interface than the given one. Routes that are covered by an exception, e.g. the
+// static routes of the never-proxy subnets, aren't shadowing.
+func shadowingRoutes(rt []routing.Route, name string, sn *net.IPNet, exceptions []*net.IPNet) []routing.Route {
+	ones, bits := sn.Mask.Size()
+	var shadowing []routing.Route
+nextRoute:
+	for _, r := range rt {
+		if r.Interface.Name == name {
+			continue
+		}
+		rOnes, rBits := r.RoutedNet.Mask.Size()
+		if rOnes > 0 && rBits == bits && rOnes >= ones && sn.Contains(r.RoutedNet.IP) {
+			for _, e := range exceptions {
+				if subnet.Covers(e, r.RoutedNet) {
+					continue nextRoute
+				}
+			}
+			shadowing = append(shadowing, r)
+		}
+	}
+	return shadowing
+}
+
+// CleanupOverrideRoutes is a no-op on this platform, because the override routes vanish with the device, and the
+// routes that they replaced are restored from the routes that were passed to the OverriddenRoutesJournal.
+func CleanupOverrideRoutes(_ context.Context) {
+}
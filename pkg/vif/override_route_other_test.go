@@ -0,0 +1,55 @@
+//go:build !linux
+// +build !linux
+
+package vif
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/routing"
+)
+
+func mustParseCIDR(t *testing.T, cidr string) *net.IPNet {
+	_, sn, err := net.ParseCIDR(cidr)
+	require.NoError(t, err)
+	return sn
+}
+
+func Test_shadowingRoutes(t *testing.T) {
+	vpn := &net.Interface{Name: "utun3"}
+	tel := &net.Interface{Name: "utun4"}
+	route := func(cidr string, iface *net.Interface) routing.Route {
+		return routing.Route{RoutedNet: mustParseCIDR(t, cidr), Gateway: net.IP{10, 8, 0, 1}, Interface: iface}
+	}
+	rt := []routing.Route{
+		route("0.0.0.0/0", vpn),
+		route("10.0.0.0/8", vpn),
+		route("10.96.0.0/16", vpn),
+		route("10.96.3.0/24", vpn),
+		route("10.96.0.1/32", vpn),
+		route("10.96.0.0/16", tel),
+		route("fd00::/8", vpn),
+	}
+	got := shadowingRoutes(rt, tel.Name, mustParseCIDR(t, "10.96.0.0/16"), []*net.IPNet{mustParseCIDR(t, "10.96.0.1/32")})
+
+	// Less specific routes don't shadow the subnet, and routes of the device itself and routes covered by an
+	// exception are retained.
+	assert.Equal(t, []routing.Route{rt[2], rt[3]}, got)
+}
+
+func Test_journalOverriddenRoutes(t *testing.T) {
+	removed := []routing.Route{{RoutedNet: mustParseCIDR(t, "10.96.3.0/24"), Interface: &net.Interface{Name: "utun3"}}}
+	var journaled []routing.Route
+	ctx := WithOverriddenRoutesJournal(context.Background(), func(_ context.Context, rs []routing.Route) error {
+		journaled = rs
+		return nil
+	})
+	require.NoError(t, journalOverriddenRoutes(ctx, removed))
+	assert.Equal(t, removed, journaled)
+	assert.NoError(t, journalOverriddenRoutes(context.Background(), removed))
+}
@@ -144,3 +144,20 @@ func GetRoute(ctx context.Context, routedNet *net.IPNet) (Route, error) {
 		Gateway:   gatewayIp,
 	}, nil
 }
+
+func routeFamilyFlag(ip net.IP) string {
+	if ip.To4() != nil {
+		return "-inet"
+	}
+	return "-inet6"
+}
+
+// AddStatic adds this route to the routing table of the machine.
+func (r *Route) AddStatic(ctx context.Context) error {
+	return dexec.CommandContext(ctx, "route", "-n", "add", routeFamilyFlag(r.RoutedNet.IP), "-net", r.RoutedNet.String(), r.Gateway.String()).Run()
+}
+
+// RemoveStatic removes this route from the routing table of the machine.
+func (r *Route) RemoveStatic(ctx context.Context) error {
+	return dexec.CommandContext(ctx, "route", "-n", "delete", routeFamilyFlag(r.RoutedNet.IP), "-net", r.RoutedNet.String(), r.Gateway.String()).Run()
+}
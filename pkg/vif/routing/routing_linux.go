@@ -163,3 +163,13 @@ func GetRoute(ctx context.Context, routedNet *net.IPNet) (Route, error) {
 		LocalIP:   localIP,
 	}, nil
 }
+
+// AddStatic adds this route to the routing table of the machine.
+func (r *Route) AddStatic(ctx context.Context) error {
+	return dexec.CommandContext(ctx, "ip", "route", "add", r.RoutedNet.String(), "via", r.Gateway.String(), "dev", r.Interface.Name).Run()
+}
+
+// RemoveStatic removes this route from the routing table of the machine.
+func (r *Route) RemoveStatic(ctx context.Context) error {
+	return dexec.CommandContext(ctx, "ip", "route", "del", r.RoutedNet.String(), "via", r.Gateway.String(), "dev", r.Interface.Name).Run()
+}
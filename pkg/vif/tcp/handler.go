@@ -53,8 +53,14 @@ func (s state) String() (txt string) {
 const myWindowScale = 8
 const maxReceiveWindow = 4096 << myWindowScale // 1MB
 
-var maxSegmentSize = buffer.DataPool.MTU - (20 + HeaderLen) // Ethernet MTU of 1500 - 20 byte IP header and 20 byte TCP header
-var ioChannelSize = maxReceiveWindow / maxSegmentSize
+var defaultMaxSegmentSize = buffer.DataPool.MTU - (20 + HeaderLen) // Ethernet MTU of 1500 - 20 byte IP header and 20 byte TCP header
+var ioChannelSize = maxReceiveWindow / defaultMaxSegmentSize
+
+// The maximum segment sizes to assume when the peer doesn't send the MSS option, see RFC 879 and RFC 8200
+const (
+	defaultPeerMaxSegmentSizeIPv4 = 536
+	defaultPeerMaxSegmentSizeIPv6 = 1220
+)
 
 type queueElement struct {
 	sequence uint32
@@ -97,10 +103,32 @@ type handler struct {
 	// remove is the function that removes this instance from the pool
 	remove func()
 
-	// TUN I/O
+	// TUN I/O. The packets in fromTun are processed by theWorkers.
 	toTun   ip.Writer
 	fromTun chan Packet
 
+	// ctx and cancel are set when the handler starts. The ctx is used by the worker that processes the packets in
+	// fromTun.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// started is set to 1 when the handler has started, and the workers may process it.
+	started int32
+
+	// scheduled is 1 while the handler is on the run queue of theWorkers or being processed by a worker.
+	scheduled int32
+
+	// finalDeadline is the time, in unix nanoseconds, when the processing of the final packets of a closing
+	// connection ends. Zero means that the connection isn't closing.
+	finalDeadline int64
+
+	// dialed is closed when the attempt to create the stream to the traffic-manager has ended. It's nil until the
+	// SYN has been received.
+	dialed chan struct{}
+
+	// writerDone is closed when the writeToMgrLoop has ended. It's nil unless the writeToMgrLoop was started.
+	writerDone chan struct{}
+
 	// the dispatcher signals its intent to close in dispatcherClosing. 0 == running, 1 == closing, 2 == closed
 	dispatcherClosing *int32
 
@@ -110,11 +138,10 @@ type handler struct {
 	// Channel to use when sending packets to the traffic-manager
 	toMgrCh chan Packet
 
-	// Channel to use when sending messages to the traffic-manager
+	// Channel to use when sending control messages to the traffic-manager
 	toMgrMsgCh chan tunnel.Message
 
-	// Waitgroup that the processPackets (reader of TUN packets) and readFromMgrLoop (reader of packets from
-	// the traffic manager) will signal when they are tunDone.
+	// Waitgroup that the readFromMgrLoop (reader of packets from the traffic manager) will signal when it's done.
 	wg sync.WaitGroup
 
 	// queue where unacked elements are placed until they are acked
@@ -168,12 +195,26 @@ type handler struct {
 	// peerMaxSegmentSize is the maximum size of a segment sent to the peer (not counting IP-header)
 	peerMaxSegmentSize uint16
 
+	// maxSegmentSize is the maximum size of a segment that fits the MTU of the TUN device. It's announced
+	// to the peer, and the peerMaxSegmentSize is clamped to it.
+	maxSegmentSize uint16
+
 	// sendLock and sendCondition are used when throttling writes to the TUN device
 	sendLock      sync.Mutex
 	sendCondition *sync.Cond
 
 	// random generator for initial sequence number
 	rnd *rand.Rand
+
+	// idleTimeout is the time after which a connection without activity is closed. Zero means never.
+	idleTimeout time.Duration
+
+	// lastActivity is the time, in unix nanoseconds, when a packet was last received from TUN or from the
+	// traffic-manager.
+	lastActivity int64
+
+	// idleEvicted is set to 1 when the connection has been closed because it was idle.
+	idleEvicted int32
 }
 
 func NewHandler(
@@ -181,9 +222,14 @@ func NewHandler(
 	dispatcherClosing *int32,
 	toTun ip.Writer,
 	id tunnel.ConnID,
+	mtu int,
 	remove func(),
 	rndSource rand.Source,
 ) PacketHandler {
+	ipHeaderLen := 20
+	if !id.IsIPv4() {
+		ipHeaderLen = 40
+	}
 	h := &handler{
 		streamCreator:     streamCreator,
 		id:                id,
@@ -192,8 +238,9 @@ func NewHandler(
 		dispatcherClosing: dispatcherClosing,
 		fromTun:           make(chan Packet, ioChannelSize),
 		toMgrCh:           make(chan Packet, ioChannelSize),
-		toMgrMsgCh:        make(chan tunnel.Message),
+		toMgrMsgCh:        make(chan tunnel.Message, 1),
 		myWindow:          maxReceiveWindow,
+		maxSegmentSize:    uint16(mtu - (ipHeaderLen + HeaderLen)),
 		wfState:           stateIdle,
 		rnd:               rand.New(rndSource),
 		tunDone:           make(chan struct{}),
@@ -206,16 +253,37 @@ func (h *handler) RandomSequence() int32 {
 	return h.rnd.Int31()
 }
 
+// HandlePacket queues the packet for processing by theWorkers. It never blocks, because that would hold up the
+// packets of all other connections.
 func (h *handler) HandlePacket(ctx context.Context, pkt Packet) {
-	select {
-	case <-ctx.Done():
-		dlog.Debugf(ctx, "!! TUN %s discarded because context is cancelled", pkt)
-	case <-h.tunDone:
+	h.touch()
+	if h.isTunDone() {
 		dlog.Debugf(ctx, "!! TUN %s discarded because TCP handler's input processing was cancelled", pkt)
+		pkt.Release()
+		return
+	}
+	select {
 	case h.fromTun <- pkt:
+		h.wake()
+	default:
+		// The peer sends more than the receive window permits. The packet is lost, and will be resent by the peer.
+		dlog.Debugf(ctx, "!! TUN %s discarded because TCP handler's input queue is full", pkt)
+		pkt.Release()
 	}
 }
 
+// wake puts the handler on the run queue of theWorkers, unless it hasn't started yet.
+func (h *handler) wake() {
+	if atomic.LoadInt32(&h.started) == 1 {
+		theWorkers.schedule(h)
+	}
+}
+
+// hasWork returns true when the worker has packets to process, or when the handler must finish.
+func (h *handler) hasWork() bool {
+	return len(h.fromTun) > 0 || h.ctx.Err() != nil || h.finalDeadlineExpired()
+}
+
 func (h *handler) Close(ctx context.Context) {
 	if h.state() == stateEstablished || h.state() == stateSynReceived {
 		h.setState(ctx, stateFinWait1)
@@ -230,30 +298,19 @@ func (h *handler) Reset(ctx context.Context, initialPacket ip.Packet) error {
 	return h.toTun.Write(ctx, initialPacket.(Packet).Reset())
 }
 
+// Start makes the handler available to theWorkers and theResender. The handler has no goroutine of its own for the
+// packets from the TUN device.
 func (h *handler) Start(ctx context.Context) {
-	ctx, cancel := context.WithCancel(ctx)
-	go h.processResends(ctx)
-	go func() {
-		defer cancel()
-		defer func() {
-			h.remove()
-			// Drain any incoming to unblock
-			for {
-				select {
-				case <-h.fromTun:
-				default:
-					return
-				}
-			}
-		}()
-		defer func() {
-			if h.stream != nil {
-				_ = h.stream.CloseSend(ctx)
-			}
-		}()
-		h.processPackets(ctx)
-		h.wg.Wait()
-	}()
+	h.ctx, h.cancel = context.WithCancel(ctx)
+	if pool := tunnel.GetPool(ctx); pool != nil {
+		h.idleTimeout = pool.IdleTimeout(ipproto.TCP, 0)
+	}
+	h.touch()
+	theResender.add(h.ctx, h)
+	atomic.StoreInt32(&h.started, 1)
+	if len(h.fromTun) > 0 {
+		h.wake()
+	}
 }
 
 func (h *handler) sendToTun(ctx context.Context, pkt Packet, seqAdd uint32, forceAck bool) {
@@ -352,7 +409,7 @@ func (h *handler) sendSyn(ctx context.Context) {
 	opts := tcpHdr.OptionBytes()
 	opts[0] = byte(maximumSegmentSize)
 	opts[1] = 4
-	binary.BigEndian.PutUint16(opts[2:], uint16(maxSegmentSize))
+	binary.BigEndian.PutUint16(opts[2:], h.maxSegmentSize)
 
 	opts[4] = byte(windowScale)
 	opts[5] = 3
@@ -453,23 +510,44 @@ func (h *handler) idle(ctx context.Context, syn Packet) quitReason {
 			dlog.Tracef(ctx, "   CON %s option %d with len %d", h.id, synOpt.kind(), synOpt.len())
 		}
 	}
+	if h.peerMaxSegmentSize == 0 {
+		if h.id.IsIPv4() {
+			h.peerMaxSegmentSize = defaultPeerMaxSegmentSizeIPv4
+		} else {
+			h.peerMaxSegmentSize = defaultPeerMaxSegmentSizeIPv6
+		}
+	}
+	if h.peerMaxSegmentSize > h.maxSegmentSize {
+		// Never send segments that don't fit the MTU of the TUN device
+		dlog.Tracef(ctx, "   CON %s maximum segment size clamped to %d", h.id, h.maxSegmentSize)
+		h.peerMaxSegmentSize = h.maxSegmentSize
+	}
 
 	h.setSequence(uint32(h.RandomSequence()))
 	h.setState(ctx, stateSynReceived)
-	// Reply to the SYN, then establish a connection. We send a reset if that fails.
-	h.sendSynReply(ctx, syn)
+	h.dialed = make(chan struct{})
+	go h.dial(ctx, syn)
+	return pleaseContinue
+}
+
+// dial establishes a connection to the traffic-manager and then replies to the SYN. We send a reset if that fails.
+// It runs in a goroutine of its own so that a slow traffic-manager doesn't hold up the workers.
+func (h *handler) dial(ctx context.Context, syn Packet) {
 	defer syn.Release()
-	if h.stream, err = h.streamCreator(ctx); err == nil {
-		go h.readFromMgrLoop(ctx)
-	}
+	defer close(h.dialed)
+	stream, err := h.streamCreator(ctx)
 	if err != nil {
 		dlog.Error(ctx, err)
 		if err := h.toTun.Write(ctx, syn.Reset()); err != nil {
 			dlog.Errorf(ctx, "!! CON %s, send of RST failed: %v", h.id, err)
 		}
-		return quitByUs
+		h.finishNow()
+		return
 	}
-	return pleaseContinue
+	h.stream = stream
+	h.wg.Add(1)
+	go h.readFromMgrLoop(ctx)
+	h.sendSynReply(ctx, syn)
 }
 
 func (h *handler) synReceived(ctx context.Context, pkt Packet) quitReason {
@@ -487,9 +565,20 @@ func (h *handler) synReceived(ctx context.Context, pkt Packet) quitReason {
 	if !tcpHdr.ACK() {
 		return pleaseContinue
 	}
+	select {
+	case <-h.dialed:
+		if h.stream == nil {
+			// The dial failed and the handler is finishing.
+			return pleaseContinue
+		}
+	default:
+		// We haven't replied to the SYN yet, so this ACK is bogus.
+		return pleaseContinue
+	}
 
 	h.onAckReceived(ctx, tcpHdr.AckNumber())
 	h.setState(ctx, stateEstablished)
+	h.writerDone = make(chan struct{})
 	go h.writeToMgrLoop(ctx)
 
 	pl := len(tcpHdr.Payload())
@@ -563,7 +652,7 @@ func (h *handler) handleReceived(ctx context.Context, pkt Packet) quitReason {
 	case sq == lastAck-1 && payloadLen == 0:
 		// keep alive, force is needed because the ackNbr is unchanged
 		h.forceSendAck(ctx)
-		go h.sendStreamControl(ctx, tunnel.KeepAlive)
+		h.sendStreamControl(tunnel.KeepAlive)
 		return pleaseContinue
 	default:
 		// resend of already acknowledged packet. Just ignore
@@ -611,59 +700,130 @@ func (h *handler) handleReceived(ctx context.Context, pkt Packet) quitReason {
 	return pleaseContinue
 }
 
-func (h *handler) processPackets(ctx context.Context) {
-	h.wg.Add(1)
-	defer h.wg.Done()
-	defer func() {
-		close(h.tunDone)
-		h.setState(ctx, stateIdle)
-		h.sendLock.Lock()
-		h.ackWaitQueue = nil
-		h.oooQueue = nil
-		h.sendLock.Unlock()
-		if h.stream != nil {
-			go func() {
-				if err := h.stream.CloseSend(ctx); err != nil {
-					dlog.Errorf(ctx, "!! CON %s CloseSend() failed %v", h.id, err)
-				}
-			}()
+// processQueued processes the packets that are queued for the handler. It's called by a worker, and never by more
+// than one worker at a time. It returns true when the handler is done.
+func (h *handler) processQueued() bool {
+	ctx := h.ctx
+	for i := 0; i < workerBatchSize; i++ {
+		if ctx.Err() != nil || h.finalDeadlineExpired() {
+			h.finish()
+			return true
 		}
-	}()
-
-	process := func(ctx context.Context, pkt Packet) bool {
-		h.peerWindowFromHeader(ctx, pkt.Header())
-		var end quitReason
-		switch h.state() {
-		case stateIdle:
-			end = h.idle(ctx, pkt)
-		case stateSynReceived:
-			end = h.synReceived(ctx, pkt)
+		var pkt Packet
+		select {
+		case pkt = <-h.fromTun:
 		default:
-			end = h.handleReceived(ctx, pkt)
+			return false
+		}
+		if !h.process(ctx, pkt) {
+			h.finish()
+			return true
 		}
-		switch end {
-		case quitByReset, quitByContext:
+	}
+	return false
+}
+
+// process processes a packet from the TUN device, and then the out-of-order packets that it made processable. It
+// returns false when the handler is done.
+func (h *handler) process(ctx context.Context, pkt Packet) bool {
+	if !h.processPacket(ctx, pkt) {
+		return false
+	}
+	for {
+		if ctx.Err() != nil {
 			return false
-		case quitByUs, quitByPeer, quitByBoth:
-			h.processFinalPackets(ctx)
+		}
+		continueProcessing, next := h.processNextOutOfOrderPacket(ctx, h.processPacket)
+		if !continueProcessing {
 			return false
-		default:
+		}
+		if !next {
 			return true
 		}
 	}
-	h.processPacketsWithProcessor(ctx, process)
 }
 
-func (h *handler) processFinalPackets(ctx context.Context) {
-	ctx, cancel := context.WithTimeout(ctx, time.Second)
-	defer cancel()
-	defer h.setState(ctx, stateIdle)
+// finalPacketsTimeout is how long the final packets of a closing connection are processed.
+const finalPacketsTimeout = time.Second
 
-	h.processPacketsWithProcessor(ctx, func(ctx context.Context, pkt Packet) bool {
-		h.peerWindowFromHeader(ctx, pkt.Header())
-		end := h.handleReceived(ctx, pkt)
-		return end == pleaseContinue
-	})
+func (h *handler) processPacket(ctx context.Context, pkt Packet) bool {
+	h.peerWindowFromHeader(ctx, pkt.Header())
+	if atomic.LoadInt64(&h.finalDeadline) != 0 {
+		// The connection is closing. Process its final packets.
+		return h.handleReceived(ctx, pkt) == pleaseContinue
+	}
+	var end quitReason
+	switch h.state() {
+	case stateIdle:
+		end = h.idle(ctx, pkt)
+	case stateSynReceived:
+		end = h.synReceived(ctx, pkt)
+	default:
+		end = h.handleReceived(ctx, pkt)
+	}
+	switch end {
+	case quitByReset, quitByContext:
+		return false
+	case quitByUs, quitByPeer, quitByBoth:
+		atomic.StoreInt64(&h.finalDeadline, time.Now().Add(finalPacketsTimeout).UnixNano())
+		return true
+	default:
+		return true
+	}
+}
+
+func (h *handler) finalDeadlineExpired() bool {
+	dl := atomic.LoadInt64(&h.finalDeadline)
+	return dl != 0 && time.Now().UnixNano() >= dl
+}
+
+// finishNow makes the worker finish the handler without processing any more packets.
+func (h *handler) finishNow() {
+	atomic.StoreInt64(&h.finalDeadline, 1)
+	h.wake()
+}
+
+// finish ends the processing of the handler. It's called once, by the worker that processes the handler. The
+// stream to the traffic-manager is closed, and the handler removed from the pool, in a goroutine, because that
+// waits for the stream.
+func (h *handler) finish() {
+	ctx := h.ctx
+	close(h.tunDone)
+	h.setState(ctx, stateIdle)
+	h.sendLock.Lock()
+	h.ackWaitQueue = nil
+	h.oooQueue = nil
+	h.sendLock.Unlock()
+	// Wake up if waiting for larger window size (ends processPayload)
+	h.sendCondition.Broadcast()
+	theResender.remove(h)
+	for len(h.fromTun) > 0 {
+		(<-h.fromTun).Release()
+	}
+
+	dialed := h.dialed
+	writerDone := h.writerDone
+	go func() {
+		if dialed != nil {
+			<-dialed
+		}
+		if writerDone != nil {
+			// The writeToMgrLoop closes the stream when it ends.
+			<-writerDone
+		} else if h.stream != nil {
+			if err := h.stream.CloseSend(ctx); err != nil {
+				dlog.Errorf(ctx, "!! CON %s CloseSend() failed %v", h.id, err)
+			}
+		}
+		h.cancel()
+		h.wg.Wait()
+		for len(h.toMgrCh) > 0 {
+			pkt := <-h.toMgrCh
+			releaseBuffer(len(pkt.Header().Payload()))
+			pkt.Release()
+		}
+		h.remove()
+	}()
 }
 
 const initialResendDelay = 2
@@ -675,33 +835,6 @@ type resend struct {
 	next   *resend
 }
 
-func (h *handler) processPacketsWithProcessor(ctx context.Context, process func(ctx context.Context, pkt Packet) bool) {
-	for {
-		select {
-		case pkt := <-h.fromTun:
-			if !process(ctx, pkt) {
-				return
-			}
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				default:
-				}
-				continueProcessing, next := h.processNextOutOfOrderPacket(ctx, process)
-				if !continueProcessing {
-					return
-				}
-				if !next {
-					break
-				}
-			}
-		case <-ctx.Done():
-			return
-		}
-	}
-}
-
 func (h *handler) copyPacket(orig Packet) Packet {
 	origHdr := orig.Header()
 	ipLen := HeaderLen + orig.PayloadLen()
@@ -715,52 +848,70 @@ func (h *handler) copyPacket(orig Packet) Packet {
 	return pkt
 }
 
-func (h *handler) processResends(ctx context.Context) {
-	ticker := time.NewTicker(100 * time.Millisecond)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-h.tunDone:
-			return
-		case <-ticker.C:
+func (h *handler) isTunDone() bool {
+	select {
+	case <-h.tunDone:
+		return true
+	default:
+		return false
+	}
+}
+
+func (h *handler) touch() {
+	atomic.StoreInt64(&h.lastActivity, time.Now().UnixNano())
+}
+
+// checkIdle closes the connection when it has been idle for longer than the idle timeout. It's called
+// periodically by the resender.
+func (h *handler) checkIdle(ctx context.Context) {
+	if h.idleTimeout <= 0 || time.Since(time.Unix(0, atomic.LoadInt64(&h.lastActivity))) < h.idleTimeout {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&h.idleEvicted, 0, 1) {
+		dlog.Debugf(ctx, "   CON %s closed after being idle for %s", h.id, h.idleTimeout)
+		if pool := tunnel.GetPool(ctx); pool != nil {
+			pool.IdleEvicted(h.id)
 		}
-		now := time.Now()
-		var resends *resend
-		h.sendLock.Lock()
-		var prev *queueElement
-		for el := h.ackWaitQueue; el != nil; {
-			secs := initialResendDelay << el.retries // 2, 4, 8, 16, ...
-			deadLine := el.cTime.Add(time.Duration(secs) * time.Second)
-			if deadLine.Before(now) {
-				el.retries++
-				if el.retries > maxResends {
-					el.packet.Release()
-					dlog.Errorf(ctx, "   CON %s, packet resent %d times, giving up", h.id, maxResends)
-					// Drop from queue and point to next
-					el = el.next
-					if prev == nil {
-						h.ackWaitQueue = el
-					} else {
-						prev.next = el
-					}
-					continue
-				}
+		h.Close(ctx)
+	}
+}
 
-				// reverse (i.e. put in right order since ackWaitQueue is in fact reversed)
-				resends = &resend{packet: el.packet, secs: secs, next: resends}
+// processResends resends the packets that haven't been acked in time. It's called periodically by the resender.
+func (h *handler) processResends(ctx context.Context) {
+	now := time.Now()
+	var resends *resend
+	h.sendLock.Lock()
+	var prev *queueElement
+	for el := h.ackWaitQueue; el != nil; {
+		secs := initialResendDelay << el.retries // 2, 4, 8, 16, ...
+		deadLine := el.cTime.Add(time.Duration(secs) * time.Second)
+		if deadLine.Before(now) {
+			el.retries++
+			if el.retries > maxResends {
+				el.packet.Release()
+				dlog.Errorf(ctx, "   CON %s, packet resent %d times, giving up", h.id, maxResends)
+				// Drop from queue and point to next
+				el = el.next
+				if prev == nil {
+					h.ackWaitQueue = el
+				} else {
+					prev.next = el
+				}
+				continue
 			}
-			prev = el
-			el = el.next
-		}
-		h.sendLock.Unlock()
-		for resends != nil {
-			pkt := h.copyPacket(resends.packet)
-			dlog.Debugf(ctx, "   CON %s resent after %d seconds", pkt, resends.secs)
-			h.sendToTun(ctx, pkt, uint32(len(pkt.Header().Payload())), false)
-			resends = resends.next
+
+			// reverse (i.e. put in right order since ackWaitQueue is in fact reversed)
+			resends = &resend{packet: el.packet, secs: secs, next: resends}
 		}
+		prev = el
+		el = el.next
+	}
+	h.sendLock.Unlock()
+	for resends != nil {
+		pkt := h.copyPacket(resends.packet)
+		dlog.Debugf(ctx, "   CON %s resent after %d seconds", pkt, resends.secs)
+		h.sendToTun(ctx, pkt, uint32(len(pkt.Header().Payload())), false)
+		resends = resends.next
 	}
 }
 
@@ -0,0 +1,97 @@
+package tcp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+// synPacket creates a SYN packet of the connection with the given ID. It announces the given maximum segment size,
+// unless it's zero.
+func synPacket(id tunnel.ConnID, mss uint16) Packet {
+	hl := HeaderLen
+	if mss > 0 {
+		hl += 4
+	}
+	pkt := NewPacket(hl, id.Source(), id.Destination(), false)
+	ipHdr := pkt.IPHeader()
+	ipHdr.SetL4Protocol(ipproto.TCP)
+	ipHdr.SetChecksum()
+
+	tcpHdr := pkt.Header()
+	tcpHdr.SetDataOffset(hl / 4)
+	tcpHdr.SetSourcePort(id.SourcePort())
+	tcpHdr.SetDestinationPort(id.DestinationPort())
+	tcpHdr.SetSequence(1000)
+	tcpHdr.SetSYN(true)
+	if mss > 0 {
+		opts := tcpHdr.OptionBytes()
+		opts[0] = byte(maximumSegmentSize)
+		opts[1] = 4
+		binary.BigEndian.PutUint16(opts[2:], mss)
+	}
+	tcpHdr.SetChecksum(ipHdr)
+	return pkt
+}
+
+func TestHandler_maxSegmentSize(t *testing.T) {
+	v4ID := tunnel.NewConnID(ipproto.TCP, srcIP, dstIP, 54321, 80)
+	v6ID := tunnel.NewConnID(ipproto.TCP, net.ParseIP("fd00::1"), net.ParseIP("fd00:96::10"), 54321, 80)
+	tests := []struct {
+		name    string
+		id      tunnel.ConnID
+		mtu     int
+		peerMSS uint16
+		wantMSS uint16
+		wantPMS uint16
+	}{
+		{"default MTU", v4ID, 1500, 1460, 1460, 1460},
+		{"peer with smaller MSS", v4ID, 1500, 1200, 1460, 1200},
+		{"VPN MTU", v4ID, 1400, 1460, 1360, 1360},
+		{"peer without MSS", v4ID, 1400, 0, 1360, defaultPeerMaxSegmentSizeIPv4},
+		{"IPv6 default MTU", v6ID, 1500, 1440, 1440, 1440},
+		{"IPv6 VPN MTU", v6ID, 1280, 1440, 1220, 1220},
+		{"IPv6 peer without MSS", v6ID, 1500, 0, 1440, defaultPeerMaxSegmentSizeIPv6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := dlog.NewTestContext(t, false)
+			w := make(chanWriter, 10)
+			noStream := func(context.Context) (tunnel.Stream, error) {
+				return nil, errors.New("no traffic-manager")
+			}
+			h := NewHandler(noStream, new(int32), w, tt.id, tt.mtu, func() {}, rand.NewSource(1)).(*handler)
+			assert.Equal(t, pleaseContinue, h.idle(ctx, synPacket(tt.id, tt.peerMSS)))
+			assert.Equal(t, tt.wantPMS, h.peerMaxSegmentSize, "segments sent to the peer")
+
+			// The SYN is reset because the stream cannot be created
+			select {
+			case pkt := <-w:
+				assert.True(t, pkt.(Packet).Header().RST())
+			case <-time.After(5 * time.Second):
+				t.Fatal("expected an RST")
+			}
+
+			h.sendSyn(ctx)
+			require.Len(t, w, 1, "expected a SYN-ACK")
+			synAck := (<-w).(Packet).Header()
+			require.True(t, synAck.SYN() && synAck.ACK())
+			opts, err := options(synAck)
+			require.NoError(t, err)
+			require.NotEmpty(t, opts)
+			require.Equal(t, maximumSegmentSize, opts[0].kind())
+			assert.Equal(t, tt.wantMSS, binary.BigEndian.Uint16(opts[0].data()), "the announced maximum segment size")
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package tcp
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const resendInterval = 100 * time.Millisecond
+
+// resender is a single goroutine that resends the unacknowledged packets of all TCP handlers, closes the ones
+// that have been idle for too long, and wakes the ones that must finish, so that a handler doesn't need a goroutine
+// and a ticker of its own. The goroutine is only running while there are handlers.
+type resender struct {
+	sync.Mutex
+	handlers map[*handler]context.Context
+	running  bool
+}
+
+var theResender = resender{handlers: make(map[*handler]context.Context)}
+
+func (r *resender) add(ctx context.Context, h *handler) {
+	r.Lock()
+	r.handlers[h] = ctx
+	start := !r.running
+	r.running = true
+	r.Unlock()
+	if start {
+		go r.run()
+	}
+}
+
+func (r *resender) remove(h *handler) {
+	r.Lock()
+	delete(r.handlers, h)
+	r.Unlock()
+}
+
+func (r *resender) run() {
+	ticker := time.NewTicker(resendInterval)
+	defer ticker.Stop()
+	type active struct {
+		ctx context.Context
+		h   *handler
+	}
+	var hs []active
+	for range ticker.C {
+		r.Lock()
+		if len(r.handlers) == 0 {
+			r.running = false
+			r.Unlock()
+			return
+		}
+		hs = hs[:0]
+		for h, ctx := range r.handlers {
+			hs = append(hs, active{ctx: ctx, h: h})
+		}
+		r.Unlock()
+		for _, a := range hs {
+			if a.ctx.Err() != nil || a.h.isTunDone() {
+				// Let a worker finish the handler, unless that already happened.
+				a.h.wake()
+				r.remove(a.h)
+				continue
+			}
+			if a.h.finalDeadlineExpired() {
+				a.h.wake()
+				continue
+			}
+			a.h.processResends(a.ctx)
+			a.h.checkIdle(a.ctx)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package tcp
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/ip"
+)
+
+// chanWriter is an ip.Writer that passes the packets that are written on a channel.
+type chanWriter chan ip.Packet
+
+func (w chanWriter) Write(_ context.Context, pkt ip.Packet) error {
+	w <- pkt
+	return nil
+}
+
+var (
+	srcIP = net.IP{10, 0, 0, 1}
+	dstIP = net.IP{10, 96, 0, 10}
+)
+
+func testHandler(w chanWriter) *handler {
+	id := tunnel.NewConnID(ipproto.TCP, srcIP, dstIP, 54321, 80)
+	return NewHandler(nil, new(int32), w, id, 1500, func() {}, rand.NewSource(1)).(*handler)
+}
+
+// awaitAck puts a packet with the given payload in the ack-wait queue of the handler, as if it was sent at the given
+// time and resent the given number of times.
+func awaitAck(h *handler, payload string, sent time.Time, retries int32) {
+	pkt := h.newResponse(HeaderLen+len(payload), true)
+	copy(pkt.Header().Payload(), payload)
+	h.ackWaitQueue = &queueElement{
+		sequence: h.addSequence(uint32(len(payload))),
+		retries:  retries,
+		cTime:    sent,
+		packet:   pkt,
+		next:     h.ackWaitQueue,
+	}
+}
+
+func newTestResender() *resender {
+	return &resender{handlers: make(map[*handler]context.Context)}
+}
+
+func (r *resender) isRunning() bool {
+	r.Lock()
+	defer r.Unlock()
+	return r.running
+}
+
+func (r *resender) count() int {
+	r.Lock()
+	defer r.Unlock()
+	return len(r.handlers)
+}
+
+func TestResender_resends(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	w := make(chanWriter, 10)
+	h := testHandler(w)
+	h.sendLock.Lock()
+	awaitAck(h, "old", time.Now().Add(-3*time.Second), 0)
+	awaitAck(h, "new", time.Now(), 0)
+	h.sendLock.Unlock()
+
+	r := newTestResender()
+	r.add(ctx, h)
+	select {
+	case pkt := <-w:
+		assert.Equal(t, "old", string(pkt.(Packet).Header().Payload()))
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the packet wasn't resent")
+	}
+	select {
+	case pkt := <-w:
+		assert.Fail(t, "unexpected resend", "payload %q", pkt.(Packet).Header().Payload())
+	case <-time.After(3 * resendInterval):
+	}
+	r.remove(h)
+	require.Eventually(t, func() bool { return !r.isRunning() }, 5*time.Second, resendInterval)
+}
+
+func TestHandler_processResends_givesUp(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	w := make(chanWriter, 10)
+	h := testHandler(w)
+	awaitAck(h, "lost", time.Now().Add(-time.Hour), maxResends)
+	h.processResends(ctx)
+	assert.Nil(t, h.ackWaitQueue)
+	assert.Empty(t, w)
+}
+
+func TestResender_removesDone(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	r := newTestResender()
+
+	// A handler is removed when its context is cancelled or when its TUN processing is done, and the goroutine stops
+	// when no handlers remain.
+	h1 := testHandler(make(chanWriter, 10))
+	h2 := testHandler(make(chanWriter, 10))
+	close(h2.tunDone)
+	r.add(cancelledCtx, h1)
+	r.add(ctx, h2)
+	require.Eventually(t, func() bool { return !r.isRunning() }, 5*time.Second, resendInterval)
+	assert.Equal(t, 0, r.count())
+
+	// The goroutine is started again when a handler is added
+	h3 := testHandler(make(chanWriter, 10))
+	r.add(ctx, h3)
+	assert.True(t, r.isRunning())
+	r.remove(h3)
+	require.Eventually(t, func() bool { return !r.isRunning() }, 5*time.Second, resendInterval)
+}
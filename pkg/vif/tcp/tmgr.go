@@ -3,6 +3,10 @@ package tcp
 import (
 	"bytes"
 	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
 	"time"
 
 	"github.com/datawire/dlib/dlog"
@@ -19,25 +23,29 @@ func (h *handler) handleStreamControl(ctx context.Context, ctrl tunnel.Message)
 }
 
 func (h *handler) sendToMgr(ctx context.Context, pkt Packet) bool {
-	select {
-	case h.toMgrCh <- pkt:
-		h.adjustReceiveWindow()
-		if h.packetLostTimer != nil {
-			h.packetLostTimer.Stop()
-			h.packetLostTimer = nil
-		}
-		return true
-	default:
-		// Manager doesn't keep up. Packet loss!
-		dlog.Debugf(ctx, "-> MGR %s packet lost!", pkt)
-		pkt.Release()
-		if h.packetLostTimer == nil {
-			h.packetLostTimer = time.AfterFunc(5*time.Second, func() {
-				h.Close(ctx)
-			})
+	pl := len(pkt.Header().Payload())
+	if reserveBuffer(pl) {
+		select {
+		case h.toMgrCh <- pkt:
+			h.adjustReceiveWindow()
+			if h.packetLostTimer != nil {
+				h.packetLostTimer.Stop()
+				h.packetLostTimer = nil
+			}
+			return true
+		default:
+			releaseBuffer(pl)
 		}
-		return false
 	}
+	// Manager doesn't keep up. Packet loss!
+	dlog.Debugf(ctx, "-> MGR %s packet lost!", pkt)
+	pkt.Release()
+	if h.packetLostTimer == nil {
+		h.packetLostTimer = time.AfterFunc(5*time.Second, func() {
+			h.Close(ctx)
+		})
+	}
+	return false
 }
 
 func (h *handler) adjustReceiveWindow() {
@@ -46,7 +54,12 @@ func (h *handler) adjustReceiveWindow() {
 	inBuffer := float64(len(h.toMgrCh) + len(h.fromTun))
 	bufSize := float64(2 * ioChannelSize)
 	ratio := inBuffer / bufSize // 0.0 means empty, 1.0 is completely full
-	ratio = 0.5 - ratio         // 0.5 means empty, below zero means more than half full
+
+	// The window also shrinks when the buffer that all handlers share fills up
+	if shared := float64(atomic.LoadInt64(&bufferedBytes)) / float64(maxBufferedBytes); shared > ratio {
+		ratio = shared
+	}
+	ratio = 0.5 - ratio // 0.5 means empty, below zero means more than half full
 
 	windowSize := 0
 
@@ -62,45 +75,36 @@ func (h *handler) adjustReceiveWindow() {
 	h.setReceiveWindow(windowSize)
 }
 
-// readFromMgrLoop sends the packets read from the fromMgr channel to the TUN device
+// readFromMgrLoop sends the packets read from the stream to the TUN device. It reads the stream directly, so
+// that a connection uses one goroutine for it.
 func (h *handler) readFromMgrLoop(ctx context.Context) {
-	h.wg.Add(1)
 	defer func() {
 		h.Close(ctx)
 		h.wg.Done()
 	}()
-	fromMgrCh, fromMgrErrs := tunnel.ReadLoop(ctx, h.stream)
 	for {
-		select {
-		case <-ctx.Done():
+		m, err := h.stream.Receive(ctx)
+		if err != nil {
+			if ctx.Err() == nil && !h.isTunDone() && !(errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed)) {
+				dlog.Errorf(ctx, "!! CON %s, read from stream failed: %v", h.id, err)
+			}
 			return
-		case <-h.tunDone:
+		}
+		if ctx.Err() != nil || h.isTunDone() {
 			return
-		case err := <-fromMgrErrs:
-			dlog.Error(ctx, err)
-		case m := <-fromMgrCh:
-			if m == nil {
-				return
-			}
-
-			select {
-			case <-ctx.Done():
-				return
-			case <-h.tunDone:
-				return
-			default:
-			}
-
-			if m.Code() != tunnel.Normal {
-				h.handleStreamControl(ctx, m)
-				continue
-			}
-			h.processPayload(ctx, m.Payload())
 		}
+		h.touch()
+		if m.Code() != tunnel.Normal {
+			h.handleStreamControl(ctx, m)
+			continue
+		}
+		h.processPayload(ctx, m.Payload())
 	}
 }
 
-// writeToMgrLoop sends the packets read from the toMgrCh channel to the traffic-manager device
+// writeToMgrLoop sends the packets read from the toMgrCh channel, and the control messages read from the
+// toMgrMsgCh channel, to the traffic-manager. It writes to the stream directly, so that a connection uses one
+// goroutine for it, and closes the stream when it ends.
 func (h *handler) writeToMgrLoop(ctx context.Context) {
 	// the time to wait until we flush in spite of not getting a PSH
 	const flushDelay = 2 * time.Millisecond
@@ -108,16 +112,16 @@ func (h *handler) writeToMgrLoop(ctx context.Context) {
 	// Threshold when we flush in spite of not getting a PSH
 	const maxBufSize = 0x10000
 
-	var mgrWrite func(payload []byte) bool
-	defer close(h.toMgrMsgCh)
-	tunnel.WriteLoop(ctx, h.stream, h.toMgrMsgCh)
-	mgrWrite = func(payload []byte) bool {
-		select {
-		case <-ctx.Done():
-			return true
-		case h.toMgrMsgCh <- tunnel.NewMessage(tunnel.Normal, payload):
-			return false
+	defer func() {
+		if err := h.stream.CloseSend(ctx); err != nil {
+			dlog.Errorf(ctx, "!! CON %s CloseSend() failed %v", h.id, err)
 		}
+		close(h.writerDone)
+	}()
+
+	// mgrWrite returns true when the stream is broken
+	mgrWrite := func(m tunnel.Message) bool {
+		return ctx.Err() != nil || h.stream.Send(ctx, m) != nil
 	}
 
 	flushTimer := time.NewTimer(flushDelay)
@@ -125,11 +129,12 @@ func (h *handler) writeToMgrLoop(ctx context.Context) {
 
 	buf := bytes.Buffer{}
 
-	sendBuf := func() {
-		if mgrWrite(buf.Bytes()) {
-			return
+	sendBuf := func() bool {
+		if mgrWrite(tunnel.NewMessage(tunnel.Normal, buf.Bytes())) {
+			return true
 		}
 		buf.Reset()
+		return false
 	}
 
 	for {
@@ -137,27 +142,31 @@ func (h *handler) writeToMgrLoop(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-flushTimer.C:
-			if buf.Len() > 0 {
-				sendBuf()
+			if buf.Len() > 0 && sendBuf() {
+				return
 			}
 		case <-h.tunDone:
 			return
+		case m := <-h.toMgrMsgCh:
+			if mgrWrite(m) {
+				return
+			}
 		case pkt := <-h.toMgrCh:
 			if pkt == nil {
 				return
 			}
-			h.adjustReceiveWindow()
 			tcpHdr := pkt.Header()
 			payload := tcpHdr.Payload()
+			releaseBuffer(len(payload))
+			h.adjustReceiveWindow()
+			broken := false
 			if tcpHdr.PSH() || buf.Len()+len(payload) >= maxBufSize {
 				if buf.Len() == 0 {
-					if mgrWrite(payload) { // save extra copying by bypassing buf.
-						return
-					}
+					broken = mgrWrite(tunnel.NewMessage(tunnel.Normal, payload)) // save extra copying by bypassing buf.
 				} else {
 					flushTimer.Stop() // It doesn't matter if the flushTime.C isn't empty. It will fire on a zero buffer
 					buf.Write(payload)
-					sendBuf()
+					broken = sendBuf()
 				}
 			} else {
 				if buf.Len() == 0 {
@@ -166,13 +175,18 @@ func (h *handler) writeToMgrLoop(ctx context.Context) {
 				buf.Write(payload)
 			}
 			pkt.Release()
+			if broken {
+				return
+			}
 		}
 	}
 }
 
-func (h *handler) sendStreamControl(ctx context.Context, code tunnel.MessageCode) {
+// sendStreamControl queues a control message for the writeToMgrLoop. The message is dropped when another one is
+// queued already, so that the worker never blocks on it.
+func (h *handler) sendStreamControl(code tunnel.MessageCode) {
 	select {
-	case <-ctx.Done():
 	case h.toMgrMsgCh <- tunnel.NewMessage(code, nil):
+	default:
 	}
 }
@@ -0,0 +1,94 @@
+package tcp
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// workerBatchSize is the maximum number of packets that a worker processes for a handler before it moves on to the
+// next handler in the run queue, so that one busy connection cannot starve the others.
+const workerBatchSize = 64
+
+// workers is a pool of goroutines that process the packets that the TUN device delivers to all TCP handlers. A handler
+// is put on the run queue when a packet is queued for it, and a worker then processes what the handler has queued,
+// so that a connection doesn't need a goroutine of its own for its TUN input. At most max workers are running, and
+// only while there are handlers on the run queue.
+type workers struct {
+	sync.Mutex
+	queue   []*handler
+	running int
+	max     int
+}
+
+var theWorkers = workers{max: 2 * runtime.GOMAXPROCS(0)}
+
+// schedule puts the handler on the run queue unless it's already there, or is being processed by a worker.
+func (w *workers) schedule(h *handler) {
+	if !atomic.CompareAndSwapInt32(&h.scheduled, 0, 1) {
+		return
+	}
+	w.Lock()
+	w.queue = append(w.queue, h)
+	start := w.running < w.max
+	if start {
+		w.running++
+	}
+	w.Unlock()
+	if start {
+		go w.run()
+	}
+}
+
+func (w *workers) next() *handler {
+	w.Lock()
+	defer w.Unlock()
+	if len(w.queue) == 0 {
+		w.queue = nil
+		w.running--
+		return nil
+	}
+	h := w.queue[0]
+	w.queue[0] = nil
+	w.queue = w.queue[1:]
+	return h
+}
+
+func (w *workers) run() {
+	for h := w.next(); h != nil; h = w.next() {
+		if h.processQueued() {
+			// The handler is done, so it stays scheduled and is never put on the run queue again.
+			continue
+		}
+		atomic.StoreInt32(&h.scheduled, 0)
+		if h.hasWork() {
+			w.schedule(h)
+		}
+	}
+}
+
+// bufferedBytes is the number of payload bytes that all TCP handlers have received from the TUN device, but not yet
+// sent to the traffic-manager.
+var bufferedBytes int64
+
+// maxBufferedBytes is the limit for bufferedBytes. The handlers shrink the receive windows that they advertise as the
+// limit is approached, and drop the packets that would exceed it, so that the peers back off when the
+// traffic-manager doesn't keep up, rather than making the daemon buffer the receive windows of all connections.
+var maxBufferedBytes = int64(64 * maxReceiveWindow)
+
+// reserveBuffer adds n to the bufferedBytes, unless that would exceed the maxBufferedBytes.
+func reserveBuffer(n int) bool {
+	for {
+		b := atomic.LoadInt64(&bufferedBytes)
+		if b+int64(n) > maxBufferedBytes {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&bufferedBytes, b, b+int64(n)) {
+			return true
+		}
+	}
+}
+
+func releaseBuffer(n int) {
+	atomic.AddInt64(&bufferedBytes, -int64(n))
+}
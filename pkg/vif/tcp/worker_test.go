@@ -0,0 +1,196 @@
+package tcp
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/ip"
+)
+
+// fakeStream is a tunnel.Stream that passes the messages that are sent on a channel, and that doesn't receive
+// anything until it's closed.
+type fakeStream struct {
+	id     tunnel.ConnID
+	sent   chan tunnel.Message
+	closed chan struct{}
+}
+
+func newFakeStream(id tunnel.ConnID) *fakeStream {
+	return &fakeStream{id: id, sent: make(chan tunnel.Message, 10), closed: make(chan struct{})}
+}
+
+func (s *fakeStream) Tag() string                     { return "FAKE" }
+func (s *fakeStream) ID() tunnel.ConnID               { return s.id }
+func (s *fakeStream) PeerVersion() uint16             { return 2 }
+func (s *fakeStream) SessionID() string               { return "session" }
+func (s *fakeStream) DialTimeout() time.Duration      { return time.Second }
+func (s *fakeStream) RoundtripLatency() time.Duration { return time.Second }
+
+func (s *fakeStream) Receive(ctx context.Context) (tunnel.Message, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (s *fakeStream) Send(_ context.Context, m tunnel.Message) error {
+	s.sent <- m
+	return nil
+}
+
+func (s *fakeStream) CloseSend(context.Context) error {
+	close(s.closed)
+	return nil
+}
+
+// ackPacket creates a packet of the connection with the given ID that acknowledges the given sequence and carries
+// the given payload.
+func ackPacket(id tunnel.ConnID, seq, ack uint32, payload string) Packet {
+	pkt := NewPacket(HeaderLen+len(payload), id.Source(), id.Destination(), false)
+	ipHdr := pkt.IPHeader()
+	ipHdr.SetL4Protocol(ipproto.TCP)
+	ipHdr.SetChecksum()
+
+	tcpHdr := pkt.Header()
+	tcpHdr.SetDataOffset(HeaderLen / 4)
+	tcpHdr.SetSourcePort(id.SourcePort())
+	tcpHdr.SetDestinationPort(id.DestinationPort())
+	tcpHdr.SetSequence(seq)
+	tcpHdr.SetAckNumber(ack)
+	tcpHdr.SetACK(true)
+	tcpHdr.SetPSH(payload != "")
+	tcpHdr.SetWindowSize(0xffff)
+	copy(tcpHdr.Payload(), payload)
+	tcpHdr.SetChecksum(ipHdr)
+	return pkt
+}
+
+// headerWriter is an ip.Writer that passes copies of the TCP headers of the packets that are written on a channel.
+// The packets themselves cannot be passed, because the handler releases them once they have been written.
+type headerWriter chan Header
+
+func (w headerWriter) Write(_ context.Context, pkt ip.Packet) error {
+	w <- append(Header(nil), pkt.(Packet).Header()...)
+	return nil
+}
+
+func awaitPacket(t *testing.T, w headerWriter) Header {
+	t.Helper()
+	select {
+	case hdr := <-w:
+		return hdr
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for a packet")
+		return nil
+	}
+}
+
+func TestHandler_workers(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	const connections = 200
+	goroutinesBefore := runtime.NumGoroutine()
+	var removed int32
+	type conn struct {
+		id     tunnel.ConnID
+		w      headerWriter
+		stream *fakeStream
+	}
+	conns := make([]conn, connections)
+	for i := range conns {
+		c := &conns[i]
+		c.id = tunnel.NewConnID(ipproto.TCP, srcIP, dstIP, uint16(10000+i), 80)
+		c.w = make(headerWriter, 10)
+		c.stream = newFakeStream(c.id)
+		create := func(context.Context) (tunnel.Stream, error) {
+			return c.stream, nil
+		}
+		h := NewHandler(create, new(int32), c.w, c.id, 1500, func() { atomic.AddInt32(&removed, 1) }, rand.NewSource(1))
+		h.Start(ctx)
+		h.(PacketHandler).HandlePacket(ctx, synPacket(c.id, 1460))
+		synAck := awaitPacket(t, c.w)
+		require.True(t, synAck.SYN() && synAck.ACK())
+		h.(PacketHandler).HandlePacket(ctx, ackPacket(c.id, 1001, synAck.Sequence()+1, "hello"))
+	}
+
+	for _, c := range conns {
+		select {
+		case m := <-c.stream.sent:
+			assert.Equal(t, "hello", string(m.Payload()))
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for the payload to reach the traffic-manager")
+		}
+		assert.Equal(t, uint32(1006), awaitPacket(t, c.w).AckNumber())
+	}
+
+	// An established connection uses one goroutine to read from its stream, and one to write to it. The packets
+	// from the TUN device are processed by the workers.
+	assert.LessOrEqual(t, runtime.NumGoroutine()-goroutinesBefore, 2*connections+theWorkers.max+10)
+
+	cancel()
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&removed) == connections }, 10*time.Second, 10*time.Millisecond)
+	assert.Zero(t, atomic.LoadInt64(&bufferedBytes))
+}
+
+func TestHandler_HandlePacket_neverBlocks(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	h := testHandler(make(chanWriter, 10))
+	for i := 0; i < ioChannelSize; i++ {
+		h.HandlePacket(ctx, synPacket(h.id, 1460))
+	}
+	done := make(chan struct{})
+	go func() {
+		// The packet is dropped because the handler's input queue is full.
+		h.HandlePacket(ctx, synPacket(h.id, 1460))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("HandlePacket blocked")
+	}
+	assert.Len(t, h.fromTun, ioChannelSize)
+}
+
+func TestHandler_sendToMgr_sharedBuffer(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	saved := maxBufferedBytes
+	maxBufferedBytes = 20
+	defer func() {
+		maxBufferedBytes = saved
+	}()
+
+	h := testHandler(make(chanWriter, 10))
+	id := h.id
+	assert.True(t, h.sendToMgr(ctx, ackPacket(id, 1001, 1, "hello")))
+	assert.Equal(t, (maxReceiveWindow/2)&^0xff, h.receiveWindow(), "three quarters of the shared buffer are free")
+
+	assert.True(t, h.sendToMgr(ctx, ackPacket(id, 1006, 1, "world")))
+	assert.Zero(t, h.receiveWindow(), "half of the shared buffer is used")
+
+	// The packet is lost, because it would exceed the shared buffer.
+	assert.False(t, h.sendToMgr(ctx, ackPacket(id, 1011, 1, "hello world")))
+	assert.Len(t, h.toMgrCh, 2)
+	h.packetLostTimer.Stop()
+
+	for len(h.toMgrCh) > 0 {
+		pkt := <-h.toMgrCh
+		releaseBuffer(len(pkt.Header().Payload()))
+		pkt.Release()
+	}
+	assert.Zero(t, atomic.LoadInt64(&bufferedBytes))
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"net"
 	"sync"
-	"time"
 
 	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
@@ -51,7 +50,7 @@ func (h *dnsInterceptor) HandleDatagram(ctx context.Context, dg Datagram) {
 }
 
 func (h *dnsInterceptor) Start(ctx context.Context) {
-	h.idleTimer = time.NewTimer(idleDuration)
+	h.startIdle(ctx)
 	go func() {
 		defer h.Close(ctx)
 		wg := sync.WaitGroup{}
@@ -91,6 +90,7 @@ func (h *dnsInterceptor) tunToConn(ctx context.Context, wg *sync.WaitGroup) {
 		case <-ctx.Done():
 			return
 		case <-h.idleTimer.C:
+			h.idleExpired(ctx)
 			return
 		case dg := <-h.fromTun:
 			payload := dg.Header().Payload()
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
 	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
 	"github.com/telepresenceio/telepresence/v2/pkg/vif/ip"
 )
@@ -16,17 +17,35 @@ type DatagramHandler interface {
 }
 
 type timedHandler struct {
-	id        tunnel.ConnID
-	idleTimer *time.Timer
-	idleLock  sync.Mutex
-	remove    func()
+	id          tunnel.ConnID
+	idleTimer   *time.Timer
+	idleTimeout time.Duration
+	idleLock    sync.Mutex
+	remove      func()
+}
+
+// startIdle starts the idle timer, using the idle timeout of the pool that the handler belongs to, if any.
+func (h *timedHandler) startIdle(ctx context.Context) {
+	h.idleTimeout = idleDuration
+	if pool := tunnel.GetPool(ctx); pool != nil {
+		h.idleTimeout = pool.IdleTimeout(ipproto.UDP, idleDuration)
+	}
+	h.idleTimer = time.NewTimer(h.idleTimeout)
+}
+
+// idleExpired is called when the idle timer fires and the handler is about to be evicted.
+func (h *timedHandler) idleExpired(ctx context.Context) {
+	dlog.Tracef(ctx, "   CON %s evicted after being idle for %s", h.id, h.idleTimeout)
+	if pool := tunnel.GetPool(ctx); pool != nil {
+		pool.IdleEvicted(h.id)
+	}
 }
 
 func (h *timedHandler) resetIdle() bool {
 	h.idleLock.Lock()
 	stopped := h.idleTimer.Stop()
 	if stopped {
-		h.idleTimer.Reset(idleDuration)
+		h.idleTimer.Reset(h.idleTimeout)
 	}
 	h.idleLock.Unlock()
 	return stopped
@@ -96,7 +115,7 @@ func sendUDPToTun(ctx context.Context, id tunnel.ConnID, payload []byte, toTun i
 }
 
 func (h *handler) Start(ctx context.Context) {
-	h.idleTimer = time.NewTimer(idleDuration)
+	h.startIdle(ctx)
 	go h.readLoop(ctx)
 	go h.writeLoop(ctx)
 }
@@ -125,6 +144,7 @@ func (h *handler) writeLoop(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-h.idleTimer.C:
+			h.idleExpired(ctx)
 			return
 		case dg := <-h.fromTun:
 			if !h.resetIdle() {
@@ -48,6 +48,9 @@ type ConnectorClient interface {
 	// Deactivates and removes an existent workload intercept.
 	// Requires having already called Connect.
 	RemoveIntercept(ctx context.Context, in *manager.RemoveInterceptRequest2, opts ...grpc.CallOption) (*InterceptResult, error)
+	// Hands an intercept over to the session of another client. The session of
+	// the request is ignored. Requires having already called Connect.
+	HandoffIntercept(ctx context.Context, in *manager.HandoffInterceptRequest, opts ...grpc.CallOption) (*InterceptResult, error)
 	// Uninstalls traffic-agents and traffic-manager from the cluster.
 	// Requires having already called Connect.
 	Uninstall(ctx context.Context, in *UninstallRequest, opts ...grpc.CallOption) (*UninstallResult, error)
@@ -56,6 +59,11 @@ type ConnectorClient interface {
 	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*WorkloadInfoSnapshot, error)
 	// Watch all workloads in the mapped namespaces
 	WatchWorkloads(ctx context.Context, in *WatchWorkloadsRequest, opts ...grpc.CallOption) (Connector_WatchWorkloadsClient, error)
+	// Watch the interceptable workloads in the given namespaces, and stream the
+	// workloads that are added, modified, or deleted. The first event delta
+	// contains all workloads. Changes of intercepts and traffic-agents count as
+	// modifications of their workloads.
+	WatchWorkloadEvents(ctx context.Context, in *WatchWorkloadsRequest, opts ...grpc.CallOption) (Connector_WatchWorkloadEventsClient, error)
 	// Returns a stream of messages to display to the user.  Does NOT
 	// require having called anything else first.
 	UserNotifications(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (Connector_UserNotificationsClient, error)
@@ -85,6 +93,30 @@ type ConnectorClient interface {
 	AddInterceptor(ctx context.Context, in *Interceptor, opts ...grpc.CallOption) (*emptypb.Empty, error)
 	// RemoveInterceptor removes a previously added interceptor
 	RemoveInterceptor(ctx context.Context, in *Interceptor, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// Benchmark measures round-trip time and throughput to the traffic-manager, both
+	// through the TUN-device and through a direct port-forward.
+	Benchmark(ctx context.Context, in *BenchmarkRequest, opts ...grpc.CallOption) (*BenchmarkResponse, error)
+	// UpgradeAgents rolls out the workloads whose traffic-agents have a version that differs
+	// from the traffic-manager's version, one workload at a time, and streams the progress.
+	UpgradeAgents(ctx context.Context, in *UpgradeAgentsRequest, opts ...grpc.CallOption) (Connector_UpgradeAgentsClient, error)
+	// WaitForIntercept waits until the given intercept is ACTIVE and the rollout of its workload
+	// is complete, i.e. all pods of the workload have a traffic-agent and are ready, and streams
+	// the reasons for the wait.
+	WaitForIntercept(ctx context.Context, in *WaitForInterceptRequest, opts ...grpc.CallOption) (Connector_WaitForInterceptClient, error)
+	// WatchRollout streams the progress of the rollout of a workload, i.e. how
+	// many of its pods have a traffic-agent and are ready, until all of them are.
+	// It's used to report the progress while an intercept waits for an agent.
+	WatchRollout(ctx context.Context, in *WatchRolloutRequest, opts ...grpc.CallOption) (Connector_WatchRolloutClient, error)
+	// DescribeIntercept returns the disposition history of the given intercept,
+	// and the traffic-agent injection status and events of the pods of its
+	// workload.
+	DescribeIntercept(ctx context.Context, in *DescribeInterceptRequest, opts ...grpc.CallOption) (*InterceptDescription, error)
+	// GetInterceptDefaults returns the intercept defaults that a workload
+	// declares using annotations.
+	GetInterceptDefaults(ctx context.Context, in *GetInterceptDefaultsRequest, opts ...grpc.CallOption) (*InterceptDefaults, error)
+	// Dashboard starts the local web dashboard, unless it's already started,
+	// and returns its URL.
+	Dashboard(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*DashboardInfo, error)
 }
 
 type connectorClient struct {
@@ -158,6 +190,15 @@ func (c *connectorClient) RemoveIntercept(ctx context.Context, in *manager.Remov
 	return out, nil
 }
 
+func (c *connectorClient) HandoffIntercept(ctx context.Context, in *manager.HandoffInterceptRequest, opts ...grpc.CallOption) (*InterceptResult, error) {
+	out := new(InterceptResult)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/HandoffIntercept", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *connectorClient) Uninstall(ctx context.Context, in *UninstallRequest, opts ...grpc.CallOption) (*UninstallResult, error) {
 	out := new(UninstallResult)
 	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/Uninstall", in, out, opts...)
@@ -208,8 +249,40 @@ func (x *connectorWatchWorkloadsClient) Recv() (*WorkloadInfoSnapshot, error) {
 	return m, nil
 }
 
+func (c *connectorClient) WatchWorkloadEvents(ctx context.Context, in *WatchWorkloadsRequest, opts ...grpc.CallOption) (Connector_WatchWorkloadEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Connector_ServiceDesc.Streams[1], "/telepresence.connector.Connector/WatchWorkloadEvents", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &connectorWatchWorkloadEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Connector_WatchWorkloadEventsClient interface {
+	Recv() (*WorkloadEventsDelta, error)
+	grpc.ClientStream
+}
+
+type connectorWatchWorkloadEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *connectorWatchWorkloadEventsClient) Recv() (*WorkloadEventsDelta, error) {
+	m := new(WorkloadEventsDelta)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *connectorClient) UserNotifications(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (Connector_UserNotificationsClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Connector_ServiceDesc.Streams[1], "/telepresence.connector.Connector/UserNotifications", opts...)
+	stream, err := c.cc.NewStream(ctx, &Connector_ServiceDesc.Streams[2], "/telepresence.connector.Connector/UserNotifications", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -366,6 +439,138 @@ func (c *connectorClient) RemoveInterceptor(ctx context.Context, in *Interceptor
 	return out, nil
 }
 
+func (c *connectorClient) Benchmark(ctx context.Context, in *BenchmarkRequest, opts ...grpc.CallOption) (*BenchmarkResponse, error) {
+	out := new(BenchmarkResponse)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/Benchmark", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *connectorClient) UpgradeAgents(ctx context.Context, in *UpgradeAgentsRequest, opts ...grpc.CallOption) (Connector_UpgradeAgentsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Connector_ServiceDesc.Streams[3], "/telepresence.connector.Connector/UpgradeAgents", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &connectorUpgradeAgentsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Connector_UpgradeAgentsClient interface {
+	Recv() (*UpgradeAgentProgress, error)
+	grpc.ClientStream
+}
+
+type connectorUpgradeAgentsClient struct {
+	grpc.ClientStream
+}
+
+func (x *connectorUpgradeAgentsClient) Recv() (*UpgradeAgentProgress, error) {
+	m := new(UpgradeAgentProgress)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *connectorClient) WaitForIntercept(ctx context.Context, in *WaitForInterceptRequest, opts ...grpc.CallOption) (Connector_WaitForInterceptClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Connector_ServiceDesc.Streams[4], "/telepresence.connector.Connector/WaitForIntercept", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &connectorWaitForInterceptClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Connector_WaitForInterceptClient interface {
+	Recv() (*InterceptWaitProgress, error)
+	grpc.ClientStream
+}
+
+type connectorWaitForInterceptClient struct {
+	grpc.ClientStream
+}
+
+func (x *connectorWaitForInterceptClient) Recv() (*InterceptWaitProgress, error) {
+	m := new(InterceptWaitProgress)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *connectorClient) WatchRollout(ctx context.Context, in *WatchRolloutRequest, opts ...grpc.CallOption) (Connector_WatchRolloutClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Connector_ServiceDesc.Streams[5], "/telepresence.connector.Connector/WatchRollout", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &connectorWatchRolloutClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Connector_WatchRolloutClient interface {
+	Recv() (*InterceptWaitProgress, error)
+	grpc.ClientStream
+}
+
+type connectorWatchRolloutClient struct {
+	grpc.ClientStream
+}
+
+func (x *connectorWatchRolloutClient) Recv() (*InterceptWaitProgress, error) {
+	m := new(InterceptWaitProgress)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *connectorClient) DescribeIntercept(ctx context.Context, in *DescribeInterceptRequest, opts ...grpc.CallOption) (*InterceptDescription, error) {
+	out := new(InterceptDescription)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/DescribeIntercept", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *connectorClient) GetInterceptDefaults(ctx context.Context, in *GetInterceptDefaultsRequest, opts ...grpc.CallOption) (*InterceptDefaults, error) {
+	out := new(InterceptDefaults)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/GetInterceptDefaults", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *connectorClient) Dashboard(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*DashboardInfo, error) {
+	out := new(DashboardInfo)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/Dashboard", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ConnectorServer is the server API for Connector service.
 // All implementations must embed UnimplementedConnectorServer
 // for forward compatibility
@@ -392,6 +597,9 @@ type ConnectorServer interface {
 	// Deactivates and removes an existent workload intercept.
 	// Requires having already called Connect.
 	RemoveIntercept(context.Context, *manager.RemoveInterceptRequest2) (*InterceptResult, error)
+	// Hands an intercept over to the session of another client. The session of
+	// the request is ignored. Requires having already called Connect.
+	HandoffIntercept(context.Context, *manager.HandoffInterceptRequest) (*InterceptResult, error)
 	// Uninstalls traffic-agents and traffic-manager from the cluster.
 	// Requires having already called Connect.
 	Uninstall(context.Context, *UninstallRequest) (*UninstallResult, error)
@@ -400,6 +608,11 @@ type ConnectorServer interface {
 	List(context.Context, *ListRequest) (*WorkloadInfoSnapshot, error)
 	// Watch all workloads in the mapped namespaces
 	WatchWorkloads(*WatchWorkloadsRequest, Connector_WatchWorkloadsServer) error
+	// Watch the interceptable workloads in the given namespaces, and stream the
+	// workloads that are added, modified, or deleted. The first event delta
+	// contains all workloads. Changes of intercepts and traffic-agents count as
+	// modifications of their workloads.
+	WatchWorkloadEvents(*WatchWorkloadsRequest, Connector_WatchWorkloadEventsServer) error
 	// Returns a stream of messages to display to the user.  Does NOT
 	// require having called anything else first.
 	UserNotifications(*emptypb.Empty, Connector_UserNotificationsServer) error
@@ -429,6 +642,30 @@ type ConnectorServer interface {
 	AddInterceptor(context.Context, *Interceptor) (*emptypb.Empty, error)
 	// RemoveInterceptor removes a previously added interceptor
 	RemoveInterceptor(context.Context, *Interceptor) (*emptypb.Empty, error)
+	// Benchmark measures round-trip time and throughput to the traffic-manager, both
+	// through the TUN-device and through a direct port-forward.
+	Benchmark(context.Context, *BenchmarkRequest) (*BenchmarkResponse, error)
+	// UpgradeAgents rolls out the workloads whose traffic-agents have a version that differs
+	// from the traffic-manager's version, one workload at a time, and streams the progress.
+	UpgradeAgents(*UpgradeAgentsRequest, Connector_UpgradeAgentsServer) error
+	// WaitForIntercept waits until the given intercept is ACTIVE and the rollout of its workload
+	// is complete, i.e. all pods of the workload have a traffic-agent and are ready, and streams
+	// the reasons for the wait.
+	WaitForIntercept(*WaitForInterceptRequest, Connector_WaitForInterceptServer) error
+	// WatchRollout streams the progress of the rollout of a workload, i.e. how
+	// many of its pods have a traffic-agent and are ready, until all of them are.
+	// It's used to report the progress while an intercept waits for an agent.
+	WatchRollout(*WatchRolloutRequest, Connector_WatchRolloutServer) error
+	// DescribeIntercept returns the disposition history of the given intercept,
+	// and the traffic-agent injection status and events of the pods of its
+	// workload.
+	DescribeIntercept(context.Context, *DescribeInterceptRequest) (*InterceptDescription, error)
+	// GetInterceptDefaults returns the intercept defaults that a workload
+	// declares using annotations.
+	GetInterceptDefaults(context.Context, *GetInterceptDefaultsRequest) (*InterceptDefaults, error)
+	// Dashboard starts the local web dashboard, unless it's already started,
+	// and returns its URL.
+	Dashboard(context.Context, *emptypb.Empty) (*DashboardInfo, error)
 	mustEmbedUnimplementedConnectorServer()
 }
 
@@ -457,6 +694,9 @@ func (UnimplementedConnectorServer) CreateIntercept(context.Context, *CreateInte
 func (UnimplementedConnectorServer) RemoveIntercept(context.Context, *manager.RemoveInterceptRequest2) (*InterceptResult, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method RemoveIntercept not implemented")
 }
+func (UnimplementedConnectorServer) HandoffIntercept(context.Context, *manager.HandoffInterceptRequest) (*InterceptResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method HandoffIntercept not implemented")
+}
 func (UnimplementedConnectorServer) Uninstall(context.Context, *UninstallRequest) (*UninstallResult, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Uninstall not implemented")
 }
@@ -466,6 +706,9 @@ func (UnimplementedConnectorServer) List(context.Context, *ListRequest) (*Worklo
 func (UnimplementedConnectorServer) WatchWorkloads(*WatchWorkloadsRequest, Connector_WatchWorkloadsServer) error {
 	return status.Errorf(codes.Unimplemented, "method WatchWorkloads not implemented")
 }
+func (UnimplementedConnectorServer) WatchWorkloadEvents(*WatchWorkloadsRequest, Connector_WatchWorkloadEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchWorkloadEvents not implemented")
+}
 func (UnimplementedConnectorServer) UserNotifications(*emptypb.Empty, Connector_UserNotificationsServer) error {
 	return status.Errorf(codes.Unimplemented, "method UserNotifications not implemented")
 }
@@ -511,6 +754,27 @@ func (UnimplementedConnectorServer) AddInterceptor(context.Context, *Interceptor
 func (UnimplementedConnectorServer) RemoveInterceptor(context.Context, *Interceptor) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method RemoveInterceptor not implemented")
 }
+func (UnimplementedConnectorServer) Benchmark(context.Context, *BenchmarkRequest) (*BenchmarkResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Benchmark not implemented")
+}
+func (UnimplementedConnectorServer) UpgradeAgents(*UpgradeAgentsRequest, Connector_UpgradeAgentsServer) error {
+	return status.Errorf(codes.Unimplemented, "method UpgradeAgents not implemented")
+}
+func (UnimplementedConnectorServer) WaitForIntercept(*WaitForInterceptRequest, Connector_WaitForInterceptServer) error {
+	return status.Errorf(codes.Unimplemented, "method WaitForIntercept not implemented")
+}
+func (UnimplementedConnectorServer) WatchRollout(*WatchRolloutRequest, Connector_WatchRolloutServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchRollout not implemented")
+}
+func (UnimplementedConnectorServer) DescribeIntercept(context.Context, *DescribeInterceptRequest) (*InterceptDescription, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DescribeIntercept not implemented")
+}
+func (UnimplementedConnectorServer) GetInterceptDefaults(context.Context, *GetInterceptDefaultsRequest) (*InterceptDefaults, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetInterceptDefaults not implemented")
+}
+func (UnimplementedConnectorServer) Dashboard(context.Context, *emptypb.Empty) (*DashboardInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Dashboard not implemented")
+}
 func (UnimplementedConnectorServer) mustEmbedUnimplementedConnectorServer() {}
 
 // UnsafeConnectorServer may be embedded to opt out of forward compatibility for this service.
@@ -650,6 +914,24 @@ func _Connector_RemoveIntercept_Handler(srv interface{}, ctx context.Context, de
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Connector_HandoffIntercept_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(manager.HandoffInterceptRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConnectorServer).HandoffIntercept(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.Connector/HandoffIntercept",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConnectorServer).HandoffIntercept(ctx, req.(*manager.HandoffInterceptRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Connector_Uninstall_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(UninstallRequest)
 	if err := dec(in); err != nil {
@@ -707,6 +989,27 @@ func (x *connectorWatchWorkloadsServer) Send(m *WorkloadInfoSnapshot) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _Connector_WatchWorkloadEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchWorkloadsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ConnectorServer).WatchWorkloadEvents(m, &connectorWatchWorkloadEventsServer{stream})
+}
+
+type Connector_WatchWorkloadEventsServer interface {
+	Send(*WorkloadEventsDelta) error
+	grpc.ServerStream
+}
+
+type connectorWatchWorkloadEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *connectorWatchWorkloadEventsServer) Send(m *WorkloadEventsDelta) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 func _Connector_UserNotifications_Handler(srv interface{}, stream grpc.ServerStream) error {
 	m := new(emptypb.Empty)
 	if err := stream.RecvMsg(m); err != nil {
@@ -980,6 +1283,141 @@ func _Connector_RemoveInterceptor_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Connector_Benchmark_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BenchmarkRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConnectorServer).Benchmark(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.Connector/Benchmark",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConnectorServer).Benchmark(ctx, req.(*BenchmarkRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Connector_UpgradeAgents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(UpgradeAgentsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ConnectorServer).UpgradeAgents(m, &connectorUpgradeAgentsServer{stream})
+}
+
+type Connector_UpgradeAgentsServer interface {
+	Send(*UpgradeAgentProgress) error
+	grpc.ServerStream
+}
+
+type connectorUpgradeAgentsServer struct {
+	grpc.ServerStream
+}
+
+func (x *connectorUpgradeAgentsServer) Send(m *UpgradeAgentProgress) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Connector_WaitForIntercept_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WaitForInterceptRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ConnectorServer).WaitForIntercept(m, &connectorWaitForInterceptServer{stream})
+}
+
+type Connector_WaitForInterceptServer interface {
+	Send(*InterceptWaitProgress) error
+	grpc.ServerStream
+}
+
+type connectorWaitForInterceptServer struct {
+	grpc.ServerStream
+}
+
+func (x *connectorWaitForInterceptServer) Send(m *InterceptWaitProgress) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Connector_WatchRollout_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRolloutRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ConnectorServer).WatchRollout(m, &connectorWatchRolloutServer{stream})
+}
+
+type Connector_WatchRolloutServer interface {
+	Send(*InterceptWaitProgress) error
+	grpc.ServerStream
+}
+
+type connectorWatchRolloutServer struct {
+	grpc.ServerStream
+}
+
+func (x *connectorWatchRolloutServer) Send(m *InterceptWaitProgress) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Connector_DescribeIntercept_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DescribeInterceptRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConnectorServer).DescribeIntercept(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.Connector/DescribeIntercept",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConnectorServer).DescribeIntercept(ctx, req.(*DescribeInterceptRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Connector_GetInterceptDefaults_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetInterceptDefaultsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConnectorServer).GetInterceptDefaults(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.Connector/GetInterceptDefaults",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConnectorServer).GetInterceptDefaults(ctx, req.(*GetInterceptDefaultsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Connector_Dashboard_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConnectorServer).Dashboard(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.Connector/Dashboard",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConnectorServer).Dashboard(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Connector_ServiceDesc is the grpc.ServiceDesc for Connector service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -1015,6 +1453,10 @@ var Connector_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "RemoveIntercept",
 			Handler:    _Connector_RemoveIntercept_Handler,
 		},
+		{
+			MethodName: "HandoffIntercept",
+			Handler:    _Connector_HandoffIntercept_Handler,
+		},
 		{
 			MethodName: "Uninstall",
 			Handler:    _Connector_Uninstall_Handler,
@@ -1079,6 +1521,22 @@ var Connector_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "RemoveInterceptor",
 			Handler:    _Connector_RemoveInterceptor_Handler,
 		},
+		{
+			MethodName: "Benchmark",
+			Handler:    _Connector_Benchmark_Handler,
+		},
+		{
+			MethodName: "DescribeIntercept",
+			Handler:    _Connector_DescribeIntercept_Handler,
+		},
+		{
+			MethodName: "GetInterceptDefaults",
+			Handler:    _Connector_GetInterceptDefaults_Handler,
+		},
+		{
+			MethodName: "Dashboard",
+			Handler:    _Connector_Dashboard_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -1086,11 +1544,31 @@ var Connector_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _Connector_WatchWorkloads_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "WatchWorkloadEvents",
+			Handler:       _Connector_WatchWorkloadEvents_Handler,
+			ServerStreams: true,
+		},
 		{
 			StreamName:    "UserNotifications",
 			Handler:       _Connector_UserNotifications_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "UpgradeAgents",
+			Handler:       _Connector_UpgradeAgents_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WaitForIntercept",
+			Handler:       _Connector_WaitForIntercept_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchRollout",
+			Handler:       _Connector_WatchRollout_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "rpc/connector/connector.proto",
 }
@@ -33,7 +33,8 @@ type DaemonClient interface {
 	Quit(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*emptypb.Empty, error)
 	// Connect creates a new session that provides outbound connectivity to the cluster
 	Connect(ctx context.Context, in *OutboundInfo, opts ...grpc.CallOption) (*DaemonStatus, error)
-	// Disconnect disconnects the current session.
+	// Disconnect disconnects the session identified by the x-telepresence-session-id
+	// metadata of the call, or all sessions when no session is identified.
 	Disconnect(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*emptypb.Empty, error)
 	// GetClusterSubnets gets the outbound info that has been set on daemon
 	GetClusterSubnets(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ClusterSubnets, error)
@@ -41,6 +42,11 @@ type DaemonClient interface {
 	SetDnsSearchPath(ctx context.Context, in *Paths, opts ...grpc.CallOption) (*emptypb.Empty, error)
 	// SetLogLevel will temporarily set the log-level for the daemon for a duration that is determined b the request.
 	SetLogLevel(ctx context.Context, in *manager.LogLevelRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// RunInNetNamespace runs a command in the network namespace of the session,
+	// with the identity of the caller. The first input names the command and the
+	// following ones carry its stdin and the signals that it's sent. The outputs
+	// carry its stdout and stderr, and the last one its exit code.
+	RunInNetNamespace(ctx context.Context, opts ...grpc.CallOption) (Daemon_RunInNetNamespaceClient, error)
 }
 
 type daemonClient struct {
@@ -123,6 +129,37 @@ func (c *daemonClient) SetLogLevel(ctx context.Context, in *manager.LogLevelRequ
 	return out, nil
 }
 
+func (c *daemonClient) RunInNetNamespace(ctx context.Context, opts ...grpc.CallOption) (Daemon_RunInNetNamespaceClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Daemon_ServiceDesc.Streams[0], "/telepresence.daemon.Daemon/RunInNetNamespace", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &daemonRunInNetNamespaceClient{stream}
+	return x, nil
+}
+
+type Daemon_RunInNetNamespaceClient interface {
+	Send(*NetNamespaceInput) error
+	Recv() (*NetNamespaceOutput, error)
+	grpc.ClientStream
+}
+
+type daemonRunInNetNamespaceClient struct {
+	grpc.ClientStream
+}
+
+func (x *daemonRunInNetNamespaceClient) Send(m *NetNamespaceInput) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *daemonRunInNetNamespaceClient) Recv() (*NetNamespaceOutput, error) {
+	m := new(NetNamespaceOutput)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // DaemonServer is the server API for Daemon service.
 // All implementations must embed UnimplementedDaemonServer
 // for forward compatibility
@@ -135,7 +172,8 @@ type DaemonServer interface {
 	Quit(context.Context, *emptypb.Empty) (*emptypb.Empty, error)
 	// Connect creates a new session that provides outbound connectivity to the cluster
 	Connect(context.Context, *OutboundInfo) (*DaemonStatus, error)
-	// Disconnect disconnects the current session.
+	// Disconnect disconnects the session identified by the x-telepresence-session-id
+	// metadata of the call, or all sessions when no session is identified.
 	Disconnect(context.Context, *emptypb.Empty) (*emptypb.Empty, error)
 	// GetClusterSubnets gets the outbound info that has been set on daemon
 	GetClusterSubnets(context.Context, *emptypb.Empty) (*ClusterSubnets, error)
@@ -143,6 +181,11 @@ type DaemonServer interface {
 	SetDnsSearchPath(context.Context, *Paths) (*emptypb.Empty, error)
 	// SetLogLevel will temporarily set the log-level for the daemon for a duration that is determined b the request.
 	SetLogLevel(context.Context, *manager.LogLevelRequest) (*emptypb.Empty, error)
+	// RunInNetNamespace runs a command in the network namespace of the session,
+	// with the identity of the caller. The first input names the command and the
+	// following ones carry its stdin and the signals that it's sent. The outputs
+	// carry its stdout and stderr, and the last one its exit code.
+	RunInNetNamespace(Daemon_RunInNetNamespaceServer) error
 	mustEmbedUnimplementedDaemonServer()
 }
 
@@ -174,6 +217,9 @@ func (UnimplementedDaemonServer) SetDnsSearchPath(context.Context, *Paths) (*emp
 func (UnimplementedDaemonServer) SetLogLevel(context.Context, *manager.LogLevelRequest) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SetLogLevel not implemented")
 }
+func (UnimplementedDaemonServer) RunInNetNamespace(Daemon_RunInNetNamespaceServer) error {
+	return status.Errorf(codes.Unimplemented, "method RunInNetNamespace not implemented")
+}
 func (UnimplementedDaemonServer) mustEmbedUnimplementedDaemonServer() {}
 
 // UnsafeDaemonServer may be embedded to opt out of forward compatibility for this service.
@@ -331,6 +377,32 @@ func _Daemon_SetLogLevel_Handler(srv interface{}, ctx context.Context, dec func(
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Daemon_RunInNetNamespace_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(DaemonServer).RunInNetNamespace(&daemonRunInNetNamespaceServer{stream})
+}
+
+type Daemon_RunInNetNamespaceServer interface {
+	Send(*NetNamespaceOutput) error
+	Recv() (*NetNamespaceInput, error)
+	grpc.ServerStream
+}
+
+type daemonRunInNetNamespaceServer struct {
+	grpc.ServerStream
+}
+
+func (x *daemonRunInNetNamespaceServer) Send(m *NetNamespaceOutput) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *daemonRunInNetNamespaceServer) Recv() (*NetNamespaceInput, error) {
+	m := new(NetNamespaceInput)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // Daemon_ServiceDesc is the grpc.ServiceDesc for Daemon service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -371,6 +443,13 @@ var Daemon_ServiceDesc = grpc.ServiceDesc{
 			Handler:    _Daemon_SetLogLevel_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "RunInNetNamespace",
+			Handler:       _Daemon_RunInNetNamespace_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
 	Metadata: "rpc/daemon/daemon.proto",
 }